@@ -1,9 +1,11 @@
 package auth
 
 import (
+	"context"
 	"fmt"
 	"kiro2api/logger"
 	"kiro2api/types"
+	"time"
 )
 
 // AuthService 认证服务（推荐使用依赖注入方式）
@@ -60,6 +62,46 @@ func (as *AuthService) GetTokenWithUsage() (*types.TokenWithUsage, error) {
 	return as.tokenManager.GetBestTokenWithUsage()
 }
 
+// GetTokenFrom 只在指定的 TokenID 子集中获取可用token（客户端密钥绑定的token池）
+func (as *AuthService) GetTokenFrom(tokenIDs []string) (types.TokenInfo, error) {
+	if as.tokenManager == nil {
+		return types.TokenInfo{}, fmt.Errorf("token管理器未初始化")
+	}
+	return as.tokenManager.getBestTokenFrom(tokenIDs)
+}
+
+// GetTokenWithUsageFrom 只在指定的 TokenID 子集中获取可用token（包含使用信息）
+func (as *AuthService) GetTokenWithUsageFrom(tokenIDs []string) (*types.TokenWithUsage, error) {
+	if as.tokenManager == nil {
+		return nil, fmt.Errorf("token管理器未初始化")
+	}
+	return as.tokenManager.GetBestTokenWithUsageFrom(tokenIDs)
+}
+
+// GetTokenContext 同 GetTokenFrom，tokenIDs 为空时在全部token中选择；ctx 结束时放弃排队等待
+func (as *AuthService) GetTokenContext(ctx context.Context, tokenIDs []string) (types.TokenInfo, error) {
+	if as.tokenManager == nil {
+		return types.TokenInfo{}, fmt.Errorf("token管理器未初始化")
+	}
+	return as.tokenManager.getBestTokenContext(ctx, tokenIDs)
+}
+
+// GetTokenWithUsageContext 同 GetTokenWithUsageFrom，tokenIDs 为空时在全部token中选择；ctx 结束时放弃排队等待
+func (as *AuthService) GetTokenWithUsageContext(ctx context.Context, tokenIDs []string) (*types.TokenWithUsage, error) {
+	if as.tokenManager == nil {
+		return nil, fmt.Errorf("token管理器未初始化")
+	}
+	return as.tokenManager.GetBestTokenWithUsageContext(ctx, tokenIDs)
+}
+
+// MarkTokenRetryAfter 记录上游对该token返回的Retry-After，冷却期内不再选择该token
+func (as *AuthService) MarkTokenRetryAfter(token types.TokenInfo, d time.Duration) {
+	if as.tokenManager == nil {
+		return
+	}
+	as.tokenManager.MarkTokenRetryAfter(token.AccessToken, d)
+}
+
 // GetTokenManager 获取底层的TokenManager（用于高级操作）
 func (as *AuthService) GetTokenManager() *TokenManager {
 	return as.tokenManager
@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"kiro2api/config"
+	"kiro2api/logger"
+)
+
+// clockSkewDetector 根据刷新响应的 Date 头估计上游时钟与本机时钟的偏差
+// token 过期时间以上游时钟表示，判断是否过期时用本机时间加上偏差估计上游当前时间
+type clockSkewDetector struct {
+	mutex  sync.RWMutex
+	offset time.Duration // 上游时间 - 本机时间
+	now    func() time.Time
+}
+
+// tokenClock 所有token共用的时钟偏差估计
+var tokenClock = &clockSkewDetector{now: time.Now}
+
+// observe 记录一次刷新响应的 Date 头，返回响应对应的上游时间
+// Date 头缺失或无法解析时沿用已有偏差估计上游时间
+func (d *clockSkewDetector) observe(resp *http.Response) time.Time {
+	if resp == nil {
+		return d.Now()
+	}
+	serverTime, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return d.Now()
+	}
+
+	offset := serverTime.Sub(d.now())
+	d.mutex.Lock()
+	d.offset = offset
+	d.mutex.Unlock()
+
+	if threshold := config.ClockSkewWarningThreshold(); offset > threshold || offset < -threshold {
+		logger.Warn("检测到与上游的时钟偏差，token过期判断已按偏差校正",
+			logger.Duration("offset", offset),
+			logger.Duration("threshold", threshold),
+			logger.String("server_time", serverTime.UTC().Format(time.RFC3339)))
+	}
+	return serverTime
+}
+
+// Offset 当前估计的时钟偏差（上游时间 - 本机时间）
+func (d *clockSkewDetector) Offset() time.Duration {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	return d.offset
+}
+
+// Now 按偏差校正后的上游当前时间
+func (d *clockSkewDetector) Now() time.Time {
+	return d.now().Add(d.Offset())
+}
+
+// ClockSkewOffset 返回最近一次刷新测得的上游时钟偏差，未测得时为0
+func ClockSkewOffset() time.Duration {
+	return tokenClock.Offset()
+}
+
+// expiresAt 按上游时间计算token过期时间
+func expiresAt(serverNow time.Time, expiresIn int) time.Time {
+	return serverNow.Add(time.Duration(expiresIn) * time.Second)
+}
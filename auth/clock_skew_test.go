@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"kiro2api/types"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// useFixedTokenClock 把本机时间固定为 local，测试结束后恢复
+func useFixedTokenClock(t *testing.T, local time.Time) {
+	t.Helper()
+	orig := tokenClock
+	tokenClock = &clockSkewDetector{now: func() time.Time { return local }}
+	t.Cleanup(func() { tokenClock = orig })
+}
+
+// newSkewedRefreshServer 刷新接口的 Date 头比本机时间快 skew
+func newSkewedRefreshServer(t *testing.T, local time.Time, skew time.Duration, expiresIn int) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", local.Add(skew).UTC().Format(http.TimeFormat))
+		_ = json.NewEncoder(w).Encode(types.RefreshResponse{AccessToken: "access", ExpiresIn: expiresIn})
+	}))
+	t.Cleanup(server.Close)
+
+	orig := refreshTokenURL
+	refreshTokenURL = server.URL
+	t.Cleanup(func() { refreshTokenURL = orig })
+}
+
+func TestClockSkew_TokenUsabilityFollowsServerClock(t *testing.T) {
+	t.Setenv("TOKEN_EXPIRY_MARGIN", "2m")
+	local := time.Now().Truncate(time.Second)
+
+	tests := []struct {
+		name      string
+		skew      time.Duration
+		expiresAt time.Time // 以上游时钟表示的过期时间
+		usable    bool      // 按偏差校正后的判断
+	}{
+		// 上游快5分钟：上游看来只剩1分钟，不足安全余量；不校正时会误判为还剩6分钟
+		{"上游时钟快5分钟", 5 * time.Minute, local.Add(6 * time.Minute), false},
+		// 上游慢5分钟：上游看来还剩3分钟；不校正时会误判为已过期
+		{"上游时钟慢5分钟", -5 * time.Minute, local.Add(-2 * time.Minute), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFixedTokenClock(t, local)
+			newSkewedRefreshServer(t, local, tt.skew, 3600)
+
+			token, err := refreshSocialToken("refresh", "")
+			require.NoError(t, err)
+			assert.Equal(t, tt.skew, ClockSkewOffset())
+			assert.WithinDuration(t, local.Add(tt.skew).Add(time.Hour), token.ExpiresAt, 0, "过期时间按上游时间计算")
+
+			cached := &CachedToken{Token: types.TokenInfo{ExpiresAt: tt.expiresAt}, Available: 10}
+			assert.Equal(t, tt.usable, cached.IsUsable())
+
+			// 不应用偏差时判断相反
+			tokenClock.offset = 0
+			assert.Equal(t, !tt.usable, cached.IsUsable())
+		})
+	}
+}
+
+func TestClockSkew_ExpiryMargin(t *testing.T) {
+	local := time.Now()
+	useFixedTokenClock(t, local)
+	cached := &CachedToken{Token: types.TokenInfo{ExpiresAt: local.Add(90 * time.Second)}, Available: 10}
+
+	t.Setenv("TOKEN_EXPIRY_MARGIN", "")
+	assert.False(t, cached.IsUsable(), "默认预留2分钟")
+
+	t.Setenv("TOKEN_EXPIRY_MARGIN", "1m")
+	assert.True(t, cached.IsUsable())
+
+	t.Setenv("TOKEN_EXPIRY_MARGIN", "0s")
+	assert.True(t, cached.IsUsable())
+}
+
+func TestClockSkew_MissingDateKeepsOffset(t *testing.T) {
+	local := time.Now()
+	useFixedTokenClock(t, local)
+	tokenClock.offset = time.Minute
+
+	serverNow := tokenClock.observe(&http.Response{Header: http.Header{}})
+	assert.Equal(t, local.Add(time.Minute), serverNow)
+	assert.Equal(t, time.Minute, ClockSkewOffset())
+}
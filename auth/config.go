@@ -4,17 +4,38 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
+	"time"
+	"unicode"
 
+	"kiro2api/config"
 	"kiro2api/logger"
 )
 
 // AuthConfig 简化的认证配置
 type AuthConfig struct {
+	// TokenID 首次添加时生成的稳定标识，按时间排序；持久化顺序和缓存key都以它为准
+	TokenID      string `json:"tokenId,omitempty"`
 	AuthType     string `json:"auth"`
 	RefreshToken string `json:"refreshToken"`
 	ClientID     string `json:"clientId,omitempty"`
 	ClientSecret string `json:"clientSecret,omitempty"`
 	Disabled     bool   `json:"disabled,omitempty"`
+	// Region 刷新token和查询使用限制所用的AWS区域，为空时使用 us-east-1
+	Region string `json:"region,omitempty"`
+	// ProfileArn 多 profile 账号默认使用的 CodeWhisperer AI profile，优先于刷新响应中的 profileArn
+	ProfileArn string `json:"profileArn,omitempty"`
+	// Profiles 该账号的 profile 名称到 ARN 的映射，KIRO_PROFILES 按模型或 X-Kiro-Profile 选择名称；ARN 只对所属账号有效
+	Profiles map[string]string `json:"profiles,omitempty"`
+	// DeletedAt 软删除时间，非空时不参与选择和刷新；旧版本文件没有该字段，按未删除处理
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+	// FromEnv 配置来自 KIRO_AUTH_TOKEN；启动合并时只有这类配置会因从环境变量中移除而被删除
+	FromEnv bool `json:"fromEnv,omitempty"`
+}
+
+// IsDeleted 配置是否已被软删除
+func (c AuthConfig) IsDeleted() bool {
+	return c.DeletedAt != nil
 }
 
 // 认证方法常量
@@ -23,20 +44,129 @@ const (
 	AuthMethodIdC    = "IdC"
 )
 
+// ValidateAuthConfig 在刷新token前校验认证配置的字段格式
+// - refreshToken 不能为空且不能包含空白字符
+// - auth 必须是已知的认证方式
+// - IdC 认证必须提供 clientId 和 clientSecret
+// - region 非空时必须是AWS区域格式
+// - profileArn 以及 profiles 中的 ARN 非空时必须以 arn: 开头，profiles 的名称不能为空
+func ValidateAuthConfig(cfg AuthConfig) error {
+	if cfg.RefreshToken == "" {
+		return fmt.Errorf("refreshToken 不能为空")
+	}
+	if strings.IndexFunc(cfg.RefreshToken, unicode.IsSpace) >= 0 {
+		return fmt.Errorf("refreshToken 不能包含空白字符")
+	}
+
+	switch cfg.AuthType {
+	case AuthMethodSocial:
+	case AuthMethodIdC:
+		if strings.TrimSpace(cfg.ClientID) == "" || strings.TrimSpace(cfg.ClientSecret) == "" {
+			return fmt.Errorf("IdC 认证需要 clientId 和 clientSecret")
+		}
+	default:
+		return fmt.Errorf("auth 必须是 '%s' 或 '%s'", AuthMethodSocial, AuthMethodIdC)
+	}
+
+	if cfg.Region != "" && !config.IsValidRegion(cfg.Region) {
+		return fmt.Errorf("region 格式无效: %s", cfg.Region)
+	}
+	if cfg.ProfileArn != "" && !strings.HasPrefix(cfg.ProfileArn, "arn:") {
+		return fmt.Errorf("profileArn 格式无效: %s", cfg.ProfileArn)
+	}
+	for name, arn := range cfg.Profiles {
+		if strings.TrimSpace(name) == "" {
+			return fmt.Errorf("profiles 的名称不能为空")
+		}
+		if !strings.HasPrefix(arn, "arn:") {
+			return fmt.Errorf("profiles[%s] 格式无效: %s", name, arn)
+		}
+	}
+
+	return nil
+}
+
 // loadConfigs 从环境变量或持久化文件加载配置
 func loadConfigs() ([]AuthConfig, error) {
 	// 🔥 优先从持久化文件加载（容器重启后配置不丢失）
 	storage := NewConfigStorage()
-	persistedConfigs, err := storage.Load()
+	persistedConfigs, assigned, err := storage.load()
 	if err == nil && len(persistedConfigs) > 0 {
 		logger.Info("从持久化文件加载配置",
 			logger.Int("count", len(persistedConfigs)))
-		return processConfigs(persistedConfigs), nil
+		// 旧版本文件没有TokenID，首次加载时写回，避免每次重启重新生成
+		if assigned > 0 {
+			if err := storage.Save(persistedConfigs); err != nil {
+				logger.Warn("保存补齐的TokenID失败（不影响运行）", logger.Err(err))
+			} else {
+				logger.Info("已为旧版本配置补齐TokenID", logger.Int("count", assigned))
+			}
+		}
+		return processConfigs(mergeEnvConfigs(storage, persistedConfigs)), nil
 	}
 
 	// 如果持久化文件不存在或为空，从环境变量加载
 	logger.Info("持久化文件不存在，从环境变量加载配置")
+	validConfigs, err := envConfigs()
+	if err != nil || len(validConfigs) == 0 {
+		return validConfigs, err
+	}
+	for i := range validConfigs {
+		validConfigs[i].FromEnv = true
+	}
 
+	// 🔥 首次从环境变量加载后，保存到持久化文件（下次重启直接用）
+	if err := storage.Save(validConfigs); err != nil {
+		logger.Warn("保存初始配置到持久化文件失败（不影响运行）",
+			logger.Err(err))
+	}
+
+	return validConfigs, nil
+}
+
+// ReadConfigs 按启动时的顺序读取认证配置（持久化文件优先，其次 KIRO_AUTH_TOKEN），但不写入持久化文件
+// 与启动流程不同，持久化文件读取或解析失败时返回错误而不是回退到环境变量，供启动自检报告
+func ReadConfigs() ([]AuthConfig, error) {
+	persistedConfigs, err := NewConfigStorage().Load()
+	if err != nil {
+		return nil, fmt.Errorf("读取持久化配置失败: %w", err)
+	}
+	if len(persistedConfigs) > 0 {
+		return processConfigs(persistedConfigs), nil
+	}
+	logger.Info("持久化文件不存在，从环境变量加载配置")
+	return envConfigs()
+}
+
+// mergeEnvConfigs 设置了 KIRO_AUTH_TOKEN 时把其中的配置合并到持久化配置，有变化时写回持久化文件
+// 未设置或解析失败时原样使用持久化配置
+func mergeEnvConfigs(storage *ConfigStorage, persisted []AuthConfig) []AuthConfig {
+	if os.Getenv("KIRO_AUTH_TOKEN") == "" {
+		return persisted
+	}
+	fromEnv, err := envConfigs()
+	if err != nil {
+		logger.Warn("解析KIRO_AUTH_TOKEN失败，仅使用持久化配置", logger.Err(err))
+		return persisted
+	}
+
+	merged, added, removed, collisions := mergeConfigs(persisted, fromEnv)
+	if added == 0 && removed == 0 {
+		return merged
+	}
+	logger.Info("已合并环境变量中的认证配置",
+		logger.Int("added", added),
+		logger.Int("removed", removed),
+		logger.Int("fingerprint_collisions", collisions),
+		logger.Int("count", len(merged)))
+	if err := storage.Save(merged); err != nil {
+		logger.Warn("保存合并后的配置到持久化文件失败（不影响运行）", logger.Err(err))
+	}
+	return merged
+}
+
+// envConfigs 解析环境变量 KIRO_AUTH_TOKEN（JSON字符串或文件路径）中的认证配置
+func envConfigs() ([]AuthConfig, error) {
 	// 检测并警告弃用的环境变量
 	deprecatedVars := []string{
 		"REFRESH_TOKEN",
@@ -107,12 +237,6 @@ func loadConfigs() ([]AuthConfig, error) {
 		logger.Int("总配置数", len(configs)),
 		logger.Int("有效配置数", len(validConfigs)))
 
-	// 🔥 首次从环境变量加载后，保存到持久化文件（下次重启直接用）
-	if err := storage.Save(validConfigs); err != nil {
-		logger.Warn("保存初始配置到持久化文件失败（不影响运行）",
-			logger.Err(err))
-	}
-
 	return validConfigs, nil
 }
 
@@ -160,8 +284,8 @@ func processConfigs(configs []AuthConfig) []AuthConfig {
 			}
 		}
 
-		// 跳过禁用的配置
-		if config.Disabled {
+		// 跳过禁用的配置；软删除的配置需要保留到被清除，以便重启后仍可恢复
+		if config.Disabled && !config.IsDeleted() {
 			continue
 		}
 
@@ -169,5 +293,6 @@ func processConfigs(configs []AuthConfig) []AuthConfig {
 		_ = i // 避免未使用变量警告
 	}
 
+	ensureTokenIDs(validConfigs, nil)
 	return validConfigs
 }
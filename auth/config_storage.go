@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"kiro2api/logger"
 )
@@ -30,17 +31,17 @@ func NewConfigStorage() *ConfigStorage {
 	if configDir == "" {
 		configDir = DefaultConfigDir
 	}
-	
+
 	// 创建目录（如果不存在）
 	if err := os.MkdirAll(configDir, 0755); err != nil {
-		logger.Warn("创建配置目录失败，使用当前目录", 
+		logger.Warn("创建配置目录失败，使用当前目录",
 			logger.String("dir", configDir),
 			logger.Err(err))
 		configDir = "."
 	}
-	
+
 	filePath := filepath.Join(configDir, ConfigFileName)
-	
+
 	return &ConfigStorage{
 		filePath: filePath,
 	}
@@ -48,58 +49,200 @@ func NewConfigStorage() *ConfigStorage {
 
 // Load 从文件加载配置
 func (cs *ConfigStorage) Load() ([]AuthConfig, error) {
+	configs, _, err := cs.load()
+	return configs, err
+}
+
+// load 从文件加载配置，并返回为旧版本文件补齐的TokenID数量（由调用方决定是否写回）
+func (cs *ConfigStorage) load() ([]AuthConfig, int, error) {
 	cs.mutex.RLock()
 	defer cs.mutex.RUnlock()
-	
+
 	// 检查文件是否存在
 	if _, err := os.Stat(cs.filePath); os.IsNotExist(err) {
 		logger.Info("持久化配置文件不存在，将从环境变量加载",
 			logger.String("file", cs.filePath))
-		return nil, nil // 返回nil表示需要从环境变量加载
+		return nil, 0, nil // 返回nil表示需要从环境变量加载
 	}
-	
+
 	// 读取文件
 	data, err := os.ReadFile(cs.filePath)
 	if err != nil {
 		logger.Warn("读取持久化配置失败",
 			logger.String("file", cs.filePath),
 			logger.Err(err))
-		return nil, err
+		return nil, 0, err
 	}
-	
+
 	// 解析JSON
 	var configs []AuthConfig
 	if err := json.Unmarshal(data, &configs); err != nil {
-		return nil, fmt.Errorf("解析配置文件失败: %w", err)
+		return nil, 0, fmt.Errorf("解析配置文件失败: %w", err)
 	}
-	
+
+	// 旧版本文件没有TokenID，按文件中的顺序补齐后再排序，顺序保持不变
+	assigned := ensureTokenIDs(configs, nil)
+	sortConfigsByTokenID(configs)
+
 	logger.Info("从持久化文件加载配置成功",
 		logger.String("file", cs.filePath),
 		logger.Int("count", len(configs)))
-	
-	return configs, nil
+
+	return configs, assigned, nil
 }
 
-// Save 保存配置到文件
+// Save 保存配置到文件，按TokenID排序写入，与内存中的数组顺序无关
 func (cs *ConfigStorage) Save(configs []AuthConfig) error {
 	cs.mutex.Lock()
 	defer cs.mutex.Unlock()
-	
+
+	sorted := make([]AuthConfig, len(configs))
+	copy(sorted, configs)
+	sortConfigsByTokenID(sorted)
+
 	// 序列化为格式化的JSON
-	data, err := json.MarshalIndent(configs, "", "  ")
+	data, err := json.MarshalIndent(sorted, "", "  ")
 	if err != nil {
 		return fmt.Errorf("序列化配置失败: %w", err)
 	}
-	
+
 	// 写入文件
 	if err := os.WriteFile(cs.filePath, data, 0600); err != nil {
 		return fmt.Errorf("写入配置文件失败: %w", err)
 	}
-	
+
 	logger.Info("配置已保存到持久化文件",
 		logger.String("file", cs.filePath),
 		logger.Int("count", len(configs)))
-	
+
+	return nil
+}
+
+// LoadJSON 读取配置目录下名为 name 的JSON文件到 v，文件不存在时返回 false
+// 用于与token配置放在同一目录（同一个volume）的其他持久化状态，如客户端配额用量
+func (cs *ConfigStorage) LoadJSON(name string, v any) (bool, error) {
+	cs.mutex.RLock()
+	defer cs.mutex.RUnlock()
+
+	data, err := os.ReadFile(filepath.Join(filepath.Dir(cs.filePath), name))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("读取 %s 失败: %w", name, err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, fmt.Errorf("解析 %s 失败: %w", name, err)
+	}
+	return true, nil
+}
+
+// SaveJSON 把 v 写入配置目录下名为 name 的JSON文件，先写临时文件再重命名，写入中途退出不会损坏原文件
+func (cs *ConfigStorage) SaveJSON(name string, v any) error {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化 %s 失败: %w", name, err)
+	}
+	path := filepath.Join(filepath.Dir(cs.filePath), name)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("写入 %s 失败: %w", name, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("写入 %s 失败: %w", name, err)
+	}
 	return nil
 }
 
+// configFingerprintLength 配置指纹取 refreshToken 的前缀长度
+const configFingerprintLength = 16
+
+// configFingerprint refreshToken 的前16个字符
+// Kiro的refreshToken有很长的固定前缀，不同token的指纹可能相同，因此只用于发现冲突，不作为合并依据
+func configFingerprint(cfg AuthConfig) string {
+	if len(cfg.RefreshToken) <= configFingerprintLength {
+		return cfg.RefreshToken
+	}
+	return cfg.RefreshToken[:configFingerprintLength]
+}
+
+// MergeConfigs 按refreshToken合并持久化配置与环境变量中的配置，返回合并结果以及新增、移除的数量
+// - 两边都有的token沿用持久化的配置（保留TokenID、禁用和删除状态），标记为来自环境变量
+// - 只在环境变量中的token追加到末尾，环境变量内重复的只保留第一个
+// - 曾来自环境变量但已不在其中的token软删除，可通过恢复接口找回；通过Dashboard或导入添加的token不受影响
+func MergeConfigs(persisted, fromEnv []AuthConfig) (merged []AuthConfig, added, removed int) {
+	merged, added, removed, _ = mergeConfigs(persisted, fromEnv)
+	return merged, added, removed
+}
+
+// mergeConfigs 同 MergeConfigs，另外返回与已有token指纹相同但refreshToken不同的新增token数量
+// 这类token仍按不同的token新增，并记录警告日志
+func mergeConfigs(persisted, fromEnv []AuthConfig) (merged []AuthConfig, added, removed, collisions int) {
+	envTokens := make(map[string]bool, len(fromEnv))
+	for _, cfg := range fromEnv {
+		envTokens[cfg.RefreshToken] = true
+	}
+
+	merged = make([]AuthConfig, 0, len(persisted)+len(fromEnv))
+	seen := make(map[string]bool, len(persisted)+len(fromEnv))
+	fingerprints := make(map[string]int, len(persisted)+len(fromEnv)) // 指纹 -> 首个使用该指纹的配置在 merged 中的位置
+	now := time.Now()
+	for _, cfg := range persisted {
+		seen[cfg.RefreshToken] = true
+		if _, ok := fingerprints[configFingerprint(cfg)]; !ok {
+			fingerprints[configFingerprint(cfg)] = len(merged)
+		}
+		switch {
+		case envTokens[cfg.RefreshToken]:
+			cfg.FromEnv = true
+		case cfg.FromEnv && !cfg.IsDeleted():
+			deletedAt := now
+			cfg.DeletedAt = &deletedAt
+			removed++
+			logger.Info("环境变量中已移除的token已删除",
+				logger.String("token_id", cfg.TokenID),
+				logger.String("auth_type", cfg.AuthType))
+		}
+		merged = append(merged, cfg)
+	}
+
+	existingIDs := make(map[string]bool, len(merged))
+	for _, cfg := range merged {
+		existingIDs[cfg.TokenID] = true
+	}
+	collidedWith := make(map[int]int) // 指纹冲突的新增配置位置 -> 已有配置位置
+	for _, cfg := range fromEnv {
+		if seen[cfg.RefreshToken] {
+			continue
+		}
+		seen[cfg.RefreshToken] = true
+		fingerprint := configFingerprint(cfg)
+		if other, ok := fingerprints[fingerprint]; ok {
+			collidedWith[len(merged)] = other
+		} else {
+			fingerprints[fingerprint] = len(merged)
+		}
+		cfg.FromEnv = true
+		cfg.TokenID = ""
+		merged = append(merged, cfg)
+		added++
+	}
+	ensureTokenIDs(merged[len(merged)-added:], existingIDs)
+	for i := len(merged) - added; i < len(merged); i++ {
+		logger.Info("从环境变量新增token",
+			logger.String("token_id", merged[i].TokenID),
+			logger.String("auth_type", merged[i].AuthType))
+		if other, ok := collidedWith[i]; ok {
+			collisions++
+			logger.Warn("新增token与已有token的指纹相同但refreshToken不同，已按不同的token新增",
+				logger.String("token_id", merged[i].TokenID),
+				logger.String("collides_with", merged[other].TokenID))
+		}
+	}
+
+	return merged, added, removed, collisions
+}
@@ -0,0 +1,456 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"kiro2api/types"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestStorage(t *testing.T) *ConfigStorage {
+	t.Helper()
+	t.Setenv("CONFIG_DIR", t.TempDir())
+	return NewConfigStorage()
+}
+
+func refreshTokens(configs []AuthConfig) []string {
+	tokens := make([]string, len(configs))
+	for i, cfg := range configs {
+		tokens[i] = cfg.RefreshToken
+	}
+	return tokens
+}
+
+func TestNewTokenID_SortsInCreationOrder(t *testing.T) {
+	ids := make([]string, 5000)
+	for i := range ids {
+		ids[i] = newTokenID()
+	}
+	for i := 1; i < len(ids); i++ {
+		require.Less(t, ids[i-1], ids[i], "第 %d 个ID应大于前一个", i)
+	}
+	assert.Len(t, ids[0], 36)
+	assert.Equal(t, byte('7'), ids[0][14], "版本号为7")
+}
+
+func TestConfigStorage_RoundTripSortedByTokenID(t *testing.T) {
+	storage := newTestStorage(t)
+
+	configs := []AuthConfig{
+		{AuthType: AuthMethodSocial, RefreshToken: "first"},
+		{AuthType: AuthMethodSocial, RefreshToken: "second"},
+		{AuthType: AuthMethodSocial, RefreshToken: "third"},
+	}
+	ensureTokenIDs(configs, nil)
+
+	// 内存中的顺序被打乱后保存，文件仍按TokenID排序
+	shuffled := []AuthConfig{configs[2], configs[0], configs[1]}
+	require.NoError(t, storage.Save(shuffled))
+	assert.Equal(t, "third", shuffled[0].RefreshToken, "Save不应修改调用方的切片")
+
+	loaded, err := storage.Load()
+	require.NoError(t, err)
+	assert.Equal(t, configs, loaded)
+
+	// 再次保存/加载结果不变
+	require.NoError(t, storage.Save(loaded))
+	reloaded, err := storage.Load()
+	require.NoError(t, err)
+	assert.Equal(t, loaded, reloaded)
+}
+
+func TestConfigStorage_LoadLegacyFileAssignsIDsInFileOrder(t *testing.T) {
+	storage := newTestStorage(t)
+
+	legacy := `[{"auth":"Social","refreshToken":"a"},{"auth":"Social","refreshToken":"b"},{"auth":"Social","refreshToken":"c"}]`
+	require.NoError(t, os.WriteFile(storage.filePath, []byte(legacy), 0600))
+
+	loaded, err := storage.Load()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, refreshTokens(loaded))
+	for _, cfg := range loaded {
+		assert.NotEmpty(t, cfg.TokenID)
+	}
+}
+
+func TestLoadConfigs_PersistsTokenIDsForLegacyFile(t *testing.T) {
+	storage := newTestStorage(t)
+	t.Setenv("KIRO_AUTH_TOKEN", "")
+
+	legacy := `[{"auth":"Social","refreshToken":"legacy-token-0001"},{"auth":"Social","refreshToken":"legacy-token-0002"}]`
+	require.NoError(t, os.WriteFile(storage.filePath, []byte(legacy), 0600))
+
+	first, err := loadConfigs()
+	require.NoError(t, err)
+	require.Len(t, first, 2)
+
+	// 补齐的TokenID已写回文件，重启后保持不变
+	saved, err := os.ReadFile(storage.filePath)
+	require.NoError(t, err)
+	assert.Contains(t, string(saved), first[0].TokenID)
+	assert.Contains(t, string(saved), first[1].TokenID)
+
+	second, err := loadConfigs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{first[0].TokenID, first[1].TokenID}, []string{second[0].TokenID, second[1].TokenID})
+}
+
+func TestLoadConfigs_PersistsTokenIDsForEnvConfigs(t *testing.T) {
+	newTestStorage(t)
+	t.Setenv("KIRO_AUTH_TOKEN", `[{"auth":"Social","refreshToken":"env-token-0001"},{"auth":"Social","refreshToken":"env-token-0002"}]`)
+
+	first, err := loadConfigs()
+	require.NoError(t, err)
+	require.Len(t, first, 2)
+
+	second, err := loadConfigs()
+	require.NoError(t, err)
+	require.Len(t, second, 2)
+	for i := range first {
+		assert.NotEmpty(t, first[i].TokenID)
+		assert.Equal(t, first[i].TokenID, second[i].TokenID, "环境变量中的token重启后沿用首次生成的TokenID")
+	}
+}
+
+func TestEnsureTokenIDs_ReplacesDuplicates(t *testing.T) {
+	configs := []AuthConfig{
+		{TokenID: "dup", RefreshToken: "a"},
+		{TokenID: "dup", RefreshToken: "b"},
+		{TokenID: "taken", RefreshToken: "c"},
+	}
+	ensureTokenIDs(configs, map[string]bool{"taken": true})
+
+	assert.Equal(t, "dup", configs[0].TokenID)
+	assert.NotEqual(t, "dup", configs[1].TokenID)
+	assert.NotEqual(t, "taken", configs[2].TokenID)
+}
+
+func TestTokenManager_RemoveTokenSoftDeletesAndPersists(t *testing.T) {
+	storage := newTestStorage(t)
+
+	tm := NewTokenManager([]AuthConfig{
+		{AuthType: AuthMethodSocial, RefreshToken: "a"},
+		{AuthType: AuthMethodSocial, RefreshToken: "b"},
+		{AuthType: AuthMethodSocial, RefreshToken: "c"},
+	})
+	tm.storage = storage
+
+	// 预填充缓存，删除后其余token的缓存应保留
+	tm.mutex.Lock()
+	for _, cfg := range tm.configs {
+		tm.cache.tokens[cfg.TokenID] = &CachedToken{CachedAt: time.Now(), Available: 1}
+	}
+	deletedID := tm.configs[0].TokenID
+	keptID := tm.configs[2].TokenID
+	tm.lastRefresh = time.Now()
+	tm.currentIndex = 2
+	tm.mutex.Unlock()
+
+	tokenID, err := tm.RemoveToken(0)
+	require.NoError(t, err)
+	assert.Equal(t, deletedID, tokenID)
+
+	// 配置仍保留在原索引，只是被标记删除并移出选择顺序
+	configs := tm.GetCurrentConfigs()
+	assert.Equal(t, []string{"a", "b", "c"}, refreshTokens(configs))
+	assert.True(t, configs[0].IsDeleted())
+	assert.NotContains(t, tm.configOrder, deletedID)
+	assert.NotContains(t, tm.cache.tokens, deletedID)
+	assert.Contains(t, tm.cache.tokens, keptID)
+	assert.Equal(t, keptID, tm.configOrder[tm.currentIndex], "当前位置应继续指向同一个token")
+
+	_, err = tm.RemoveToken(0)
+	assert.Error(t, err, "重复删除应返回错误")
+	_, err = tm.ToggleTokenStatus(0)
+	assert.Error(t, err, "已删除的token不能切换状态")
+
+	// 模拟重启：删除标记随文件保存，重新加载后仍不参与选择
+	loaded, err := storage.Load()
+	require.NoError(t, err)
+	require.Len(t, loaded, 3)
+	assert.True(t, loaded[0].IsDeleted())
+	assert.Len(t, processConfigs(loaded), 3, "软删除的配置在加载时保留，以便恢复")
+
+	restarted := NewTokenManager(loaded)
+	assert.Equal(t, tm.configOrder, restarted.configOrder)
+}
+
+func TestTokenManager_DeleteRestoreRoundTrip(t *testing.T) {
+	storage := newTestStorage(t)
+	newMockAuthServer(t, http.StatusOK, 10)
+
+	tm := NewTokenManager([]AuthConfig{
+		{AuthType: AuthMethodSocial, RefreshToken: "a"},
+		{AuthType: AuthMethodSocial, RefreshToken: "b"},
+	})
+	tm.storage = storage
+	before := tm.GetCurrentConfigs()
+	orderBefore := append([]string(nil), tm.configOrder...)
+
+	tokenID, err := tm.RemoveToken(1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{before[0].TokenID}, tm.configOrder)
+
+	require.NoError(t, tm.RestoreToken(tokenID))
+	assert.Equal(t, before, tm.GetCurrentConfigs())
+	assert.Equal(t, orderBefore, tm.configOrder)
+	assert.Contains(t, tm.cache.tokens, tokenID, "恢复后重新刷新并写入缓存")
+
+	// 恢复结果已持久化
+	loaded, err := storage.Load()
+	require.NoError(t, err)
+	assert.Equal(t, before, loaded)
+
+	assert.Error(t, tm.RestoreToken(tokenID), "未删除的token不能恢复")
+	assert.Error(t, tm.RestoreToken("missing"))
+}
+
+func TestTokenManager_PurgeDeletedTokens(t *testing.T) {
+	storage := newTestStorage(t)
+
+	tm := NewTokenManager([]AuthConfig{
+		{AuthType: AuthMethodSocial, RefreshToken: "old"},
+		{AuthType: AuthMethodSocial, RefreshToken: "recent"},
+		{AuthType: AuthMethodSocial, RefreshToken: "active"},
+	})
+	tm.storage = storage
+
+	oldDeletedAt := time.Now().Add(-40 * 24 * time.Hour)
+	recentDeletedAt := time.Now().Add(-time.Hour)
+	tm.configs[0].DeletedAt = &oldDeletedAt
+	tm.configs[1].DeletedAt = &recentDeletedAt
+	oldID := tm.configs[0].TokenID
+
+	purged, err := tm.PurgeDeletedTokens(30 * 24 * time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, []string{oldID}, purged)
+	assert.Equal(t, []string{"recent", "active"}, refreshTokens(tm.GetCurrentConfigs()))
+
+	loaded, err := storage.Load()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"recent", "active"}, refreshTokens(loaded))
+
+	purged, err = tm.PurgeDeletedTokens(0)
+	require.NoError(t, err)
+	assert.Len(t, purged, 1)
+	assert.Equal(t, []string{"active"}, refreshTokens(tm.GetCurrentConfigs()))
+}
+
+func TestTokenManager_CleanupInvalidTokensSoftDeletes(t *testing.T) {
+	newTestStorage(t)
+	newMockAuthServer(t, http.StatusOK, 10)
+
+	tm := NewTokenManager([]AuthConfig{
+		{AuthType: AuthMethodSocial, RefreshToken: "exhausted"},
+		{AuthType: AuthMethodSocial, RefreshToken: "healthy"},
+	})
+	exhaustedID := tm.configs[0].TokenID
+	tm.cache.tokens[exhaustedID] = &CachedToken{
+		Token:     types.TokenInfo{ExpiresAt: time.Now().Add(time.Hour)},
+		CachedAt:  time.Now(),
+		Available: 0,
+	}
+
+	removed, err := tm.CleanupInvalidTokens()
+	require.NoError(t, err)
+	assert.Equal(t, []string{exhaustedID}, removed)
+
+	configs := tm.GetCurrentConfigs()
+	require.Len(t, configs, 2, "清理只做软删除")
+	assert.True(t, configs[0].IsDeleted())
+	assert.Equal(t, []string{configs[1].TokenID}, tm.configOrder)
+
+	require.NoError(t, tm.RestoreToken(exhaustedID))
+	assert.Len(t, tm.configOrder, 2)
+}
+
+func TestConfigStorage_LoadLegacyFileWithoutDeletedAt(t *testing.T) {
+	storage := newTestStorage(t)
+
+	legacy := `[{"tokenId":"0190b5a0-0000-7000-8000-000000000001","auth":"Social","refreshToken":"a","disabled":true}]`
+	require.NoError(t, os.WriteFile(storage.filePath, []byte(legacy), 0600))
+
+	loaded, err := storage.Load()
+	require.NoError(t, err)
+	require.Len(t, loaded, 1)
+	assert.False(t, loaded[0].IsDeleted())
+
+	// 未删除的配置序列化时不写入 deletedAt 字段，旧版本仍能读取
+	require.NoError(t, storage.Save(loaded))
+	data, err := os.ReadFile(storage.filePath)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "deletedAt")
+}
+
+func TestTokenManager_ReloadConfigsAppendsAfterExisting(t *testing.T) {
+	storage := newTestStorage(t)
+	newMockAuthServer(t, http.StatusOK, 10)
+
+	tm := NewTokenManager([]AuthConfig{{AuthType: AuthMethodSocial, RefreshToken: "existing"}})
+	tm.storage = storage
+	existingID := tm.configs[0].TokenID
+
+	// 导入数据携带的TokenID（例如从其他实例导出）不会被沿用
+	imported := []AuthConfig{{TokenID: "00000000-0000-7000-8000-000000000000", AuthType: AuthMethodSocial, RefreshToken: "imported"}}
+	_, err := tm.ReloadConfigs(imported)
+	require.NoError(t, err)
+	assert.Equal(t, "00000000-0000-7000-8000-000000000000", imported[0].TokenID, "不应修改调用方的切片")
+
+	configs := tm.GetCurrentConfigs()
+	require.Len(t, configs, 2)
+	assert.Equal(t, existingID, configs[0].TokenID)
+	assert.Greater(t, configs[1].TokenID, existingID)
+	assert.Contains(t, tm.cache.tokens, configs[1].TokenID, "新token以TokenID为key写入缓存")
+
+	data, err := os.ReadFile(filepath.Join(os.Getenv("CONFIG_DIR"), ConfigFileName))
+	require.NoError(t, err)
+	var persisted []AuthConfig
+	require.NoError(t, json.Unmarshal(data, &persisted))
+	assert.Equal(t, configs, persisted)
+}
+
+func TestMergeConfigs(t *testing.T) {
+	deletedAt := time.Now().Add(-time.Hour)
+	persisted := []AuthConfig{
+		{TokenID: newTokenID(), AuthType: AuthMethodSocial, RefreshToken: "aaaaaaaaaaaaaaaa-old-suffix", FromEnv: true, Region: "eu-west-1"},
+		{TokenID: newTokenID(), AuthType: AuthMethodSocial, RefreshToken: "dashboard-token-1"},
+		{TokenID: newTokenID(), AuthType: AuthMethodSocial, RefreshToken: "removed-from-env", FromEnv: true},
+		{TokenID: newTokenID(), AuthType: AuthMethodSocial, RefreshToken: "deleted-in-dashboard", FromEnv: true, DeletedAt: &deletedAt},
+	}
+
+	t.Run("指纹相同但refreshToken不同按不同的token处理", func(t *testing.T) {
+		// Kiro的refreshToken共享很长的固定前缀，前16个字符相同的不一定是同一个token
+		merged, added, removed, collisions := mergeConfigs(persisted[:1], []AuthConfig{
+			{AuthType: AuthMethodSocial, RefreshToken: "aaaaaaaaaaaaaaaa-new-suffix"},
+		})
+		assert.Equal(t, 1, added)
+		assert.Equal(t, 1, removed)
+		assert.Equal(t, 1, collisions)
+		assert.Equal(t, []string{"aaaaaaaaaaaaaaaa-old-suffix", "aaaaaaaaaaaaaaaa-new-suffix"}, refreshTokens(merged))
+		assert.True(t, merged[0].IsDeleted())
+		assert.True(t, merged[1].FromEnv)
+		assert.NotEqual(t, merged[0].TokenID, merged[1].TokenID)
+	})
+
+	t.Run("相同refreshToken沿用持久化配置", func(t *testing.T) {
+		merged, added, removed, collisions := mergeConfigs(persisted[:1], []AuthConfig{
+			{AuthType: AuthMethodSocial, RefreshToken: "aaaaaaaaaaaaaaaa-old-suffix"},
+		})
+		assert.Equal(t, 0, added)
+		assert.Equal(t, 0, removed)
+		assert.Equal(t, 0, collisions)
+		assert.Equal(t, persisted[:1], merged)
+	})
+
+	t.Run("新增与移除", func(t *testing.T) {
+		merged, added, removed := MergeConfigs(persisted, []AuthConfig{
+			{TokenID: "env-id", AuthType: AuthMethodSocial, RefreshToken: "aaaaaaaaaaaaaaaa-old-suffix"},
+			{TokenID: "env-id", AuthType: AuthMethodIdC, RefreshToken: "brand-new-token", ClientID: "id", ClientSecret: "secret"},
+			{AuthType: AuthMethodSocial, RefreshToken: "deleted-in-dashboard"},
+		})
+		assert.Equal(t, 1, added)
+		assert.Equal(t, 1, removed)
+		require.Len(t, merged, 5)
+		assert.Equal(t, []string{"aaaaaaaaaaaaaaaa-old-suffix", "dashboard-token-1", "removed-from-env", "deleted-in-dashboard", "brand-new-token"}, refreshTokens(merged))
+
+		// 持久化配置的状态保留；通过Dashboard添加的token不受影响；已删除的token不会因仍在环境变量中而恢复
+		assert.Equal(t, "eu-west-1", merged[0].Region)
+		assert.False(t, merged[1].IsDeleted())
+		assert.True(t, merged[2].IsDeleted())
+		assert.Equal(t, &deletedAt, merged[3].DeletedAt)
+
+		// 新增的token标记来源，TokenID不与已有配置冲突且排在最后
+		assert.True(t, merged[4].FromEnv)
+		assert.Equal(t, AuthMethodIdC, merged[4].AuthType)
+		assert.NotEqual(t, "env-id", merged[4].TokenID)
+		assert.Greater(t, merged[4].TokenID, merged[3].TokenID)
+		assert.Nil(t, persisted[2].DeletedAt, "不应修改调用方的切片")
+	})
+
+	t.Run("环境变量内重复只保留第一个", func(t *testing.T) {
+		merged, added, removed, collisions := mergeConfigs(nil, []AuthConfig{
+			{AuthType: AuthMethodSocial, RefreshToken: "bbbbbbbbbbbbbbbb-1"},
+			{AuthType: AuthMethodSocial, RefreshToken: "bbbbbbbbbbbbbbbb-1"},
+			{AuthType: AuthMethodSocial, RefreshToken: "bbbbbbbbbbbbbbbb-2"},
+			{AuthType: AuthMethodSocial, RefreshToken: "short"},
+		})
+		assert.Equal(t, 3, added)
+		assert.Equal(t, 0, removed)
+		assert.Equal(t, 1, collisions, "环境变量内指纹相同的两个token都保留")
+		assert.Equal(t, []string{"bbbbbbbbbbbbbbbb-1", "bbbbbbbbbbbbbbbb-2", "short"}, refreshTokens(merged))
+	})
+
+	t.Run("来自环境变量的token已全部移除", func(t *testing.T) {
+		merged, added, removed := MergeConfigs(persisted, nil)
+		assert.Equal(t, 0, added)
+		assert.Equal(t, 2, removed)
+		assert.True(t, merged[0].IsDeleted())
+		assert.False(t, merged[1].IsDeleted())
+	})
+}
+
+func TestLoadConfigs_MergesEnvIntoPersisted(t *testing.T) {
+	storage := newTestStorage(t)
+	t.Setenv("KIRO_AUTH_TOKEN", `[{"auth":"Social","refreshToken":"env-token-one-0001"}]`)
+
+	// 首次启动从环境变量加载并保存
+	configs, err := loadConfigs()
+	require.NoError(t, err)
+	require.Len(t, configs, 1)
+	assert.True(t, configs[0].FromEnv)
+
+	// 在Dashboard中添加一个token后，环境变量改为另一个token
+	persisted, err := storage.Load()
+	require.NoError(t, err)
+	dashboard := []AuthConfig{{AuthType: AuthMethodSocial, RefreshToken: "dashboard-token-0002"}}
+	ensureTokenIDs(dashboard, nil)
+	require.NoError(t, storage.Save(append(persisted, dashboard...)))
+	t.Setenv("KIRO_AUTH_TOKEN", `[{"auth":"Social","refreshToken":"env-token-two-0003"}]`)
+
+	configs, err = loadConfigs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"env-token-one-0001", "dashboard-token-0002", "env-token-two-0003"}, refreshTokens(configs))
+	assert.True(t, configs[0].IsDeleted())
+	assert.False(t, configs[1].IsDeleted())
+	assert.False(t, configs[2].IsDeleted())
+
+	// 合并结果已写回持久化文件
+	saved, err := storage.Load()
+	require.NoError(t, err)
+	assert.Equal(t, refreshTokens(configs), refreshTokens(saved))
+	assert.True(t, saved[0].IsDeleted())
+
+	// 未设置 KIRO_AUTH_TOKEN 时原样使用持久化配置
+	t.Setenv("KIRO_AUTH_TOKEN", "")
+	configs, err = loadConfigs()
+	require.NoError(t, err)
+	assert.Equal(t, refreshTokens(saved), refreshTokens(configs))
+	assert.True(t, configs[0].IsDeleted())
+}
+
+func TestConfigStorage_JSONRoundTrip(t *testing.T) {
+	storage := newTestStorage(t)
+
+	var state map[string]int
+	found, err := storage.LoadJSON("state.json", &state)
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	require.NoError(t, storage.SaveJSON("state.json", map[string]int{"requests": 3}))
+	found, err = storage.LoadJSON("state.json", &state)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, map[string]int{"requests": 3}, state)
+	assert.NoFileExists(t, filepath.Join(os.Getenv("CONFIG_DIR"), "state.json.tmp"))
+
+	require.NoError(t, os.WriteFile(filepath.Join(os.Getenv("CONFIG_DIR"), "state.json"), []byte("{broken"), 0600))
+	_, err = storage.LoadJSON("state.json", &state)
+	assert.Error(t, err)
+}
@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateAuthConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     AuthConfig
+		wantErr string
+	}{
+		{
+			name: "有效的Social配置",
+			cfg:  AuthConfig{AuthType: AuthMethodSocial, RefreshToken: "aorAAAAAGj.token-value_1"},
+		},
+		{
+			name: "有效的IdC配置",
+			cfg:  AuthConfig{AuthType: AuthMethodIdC, RefreshToken: "aorAAAAAGj", ClientID: "client", ClientSecret: "secret"},
+		},
+		{
+			name:    "refreshToken为空",
+			cfg:     AuthConfig{AuthType: AuthMethodSocial},
+			wantErr: "refreshToken 不能为空",
+		},
+		{
+			name:    "refreshToken包含空格",
+			cfg:     AuthConfig{AuthType: AuthMethodSocial, RefreshToken: "token with space"},
+			wantErr: "refreshToken 不能包含空白字符",
+		},
+		{
+			name:    "refreshToken包含换行",
+			cfg:     AuthConfig{AuthType: AuthMethodSocial, RefreshToken: "token\n"},
+			wantErr: "refreshToken 不能包含空白字符",
+		},
+		{
+			name:    "未知认证类型",
+			cfg:     AuthConfig{AuthType: "OAuth", RefreshToken: "token"},
+			wantErr: "auth 必须是 'Social' 或 'IdC'",
+		},
+		{
+			name:    "认证类型为空",
+			cfg:     AuthConfig{RefreshToken: "token"},
+			wantErr: "auth 必须是 'Social' 或 'IdC'",
+		},
+		{
+			name:    "IdC缺少clientSecret",
+			cfg:     AuthConfig{AuthType: AuthMethodIdC, RefreshToken: "token", ClientID: "client"},
+			wantErr: "IdC 认证需要 clientId 和 clientSecret",
+		},
+		{
+			name:    "IdC的clientId只有空白",
+			cfg:     AuthConfig{AuthType: AuthMethodIdC, RefreshToken: "token", ClientID: "  ", ClientSecret: "secret"},
+			wantErr: "IdC 认证需要 clientId 和 clientSecret",
+		},
+		{
+			name: "指定区域",
+			cfg:  AuthConfig{AuthType: AuthMethodSocial, RefreshToken: "token", Region: "eu-central-1"},
+		},
+		{
+			name:    "区域格式无效",
+			cfg:     AuthConfig{AuthType: AuthMethodSocial, RefreshToken: "token", Region: "europe"},
+			wantErr: "region 格式无效",
+		},
+		{
+			name: "指定profileArn",
+			cfg:  AuthConfig{AuthType: AuthMethodSocial, RefreshToken: "token", ProfileArn: "arn:aws:codewhisperer:us-east-1:123456789012:profile/ABC"},
+		},
+		{
+			name:    "profileArn格式无效",
+			cfg:     AuthConfig{AuthType: AuthMethodSocial, RefreshToken: "token", ProfileArn: "profile/ABC"},
+			wantErr: "profileArn 格式无效",
+		},
+		{
+			name: "按名称配置profiles",
+			cfg: AuthConfig{AuthType: AuthMethodSocial, RefreshToken: "token",
+				Profiles: map[string]string{"opus": "arn:aws:codewhisperer:us-east-1:123456789012:profile/OPUS"}},
+		},
+		{
+			name:    "profiles的ARN格式无效",
+			cfg:     AuthConfig{AuthType: AuthMethodSocial, RefreshToken: "token", Profiles: map[string]string{"opus": "profile/OPUS"}},
+			wantErr: "profiles[opus] 格式无效",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateAuthConfig(tt.cfg)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestTokenManager_ReloadConfigsRejectsInvalidConfig(t *testing.T) {
+	tm := NewTokenManager([]AuthConfig{{AuthType: AuthMethodSocial, RefreshToken: "existing"}})
+	tm.storage = nil
+
+	_, err := tm.ReloadConfigs([]AuthConfig{
+		{AuthType: AuthMethodSocial, RefreshToken: "valid"},
+		{AuthType: AuthMethodIdC, RefreshToken: "missing-client"},
+	})
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "配置 #1 无效")
+	assert.Len(t, tm.configs, 1, "任一配置无效时不应写入任何配置")
+}
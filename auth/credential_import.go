@@ -0,0 +1,262 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// 支持导入的凭证文件格式
+const (
+	CredentialFormatAWSCLI = "awscli"
+	CredentialFormatSAML   = "saml"
+	CredentialFormatOIDC   = "oidc"
+)
+
+// ErrNoCredentials 凭证数据中没有可以构造认证配置的条目
+var ErrNoCredentials = errors.New("凭证数据中未找到 refresh token")
+
+// CredentialParser 把某种凭证文件格式解析为认证配置
+type CredentialParser interface {
+	// Format 格式名，与导入接口路径中的 :format 对应
+	Format() string
+	// Parse 解析凭证数据，返回的配置尚未经过 ValidateAuthConfig 校验
+	Parse(data []byte) ([]AuthConfig, error)
+}
+
+var credentialParsers = map[string]CredentialParser{
+	CredentialFormatAWSCLI: awsCLIParser{},
+	CredentialFormatSAML:   samlParser{},
+	CredentialFormatOIDC:   oidcParser{},
+}
+
+// GetCredentialParser 按格式名查找解析器
+func GetCredentialParser(format string) (CredentialParser, bool) {
+	parser, ok := credentialParsers[strings.ToLower(format)]
+	return parser, ok
+}
+
+// SupportedCredentialFormats 返回支持的格式名（按字母排序）
+func SupportedCredentialFormats() []string {
+	formats := make([]string, 0, len(credentialParsers))
+	for format := range credentialParsers {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// credentialFields 各格式解析出的公共字段
+type credentialFields struct {
+	authType     string
+	refreshToken string
+	clientID     string
+	clientSecret string
+	region       string
+}
+
+// set 按规范化后的字段名赋值，返回是否为已知字段
+func (f *credentialFields) set(key, value string) bool {
+	value = strings.TrimSpace(value)
+	switch normalizeCredentialKey(key) {
+	case "auth", "authtype":
+		f.authType = value
+	case "refreshtoken", "kirorefreshtoken":
+		f.refreshToken = value
+	case "clientid":
+		f.clientID = value
+	case "clientsecret":
+		f.clientSecret = value
+	case "region", "ssoregion":
+		if f.region == "" || normalizeCredentialKey(key) == "region" {
+			f.region = value
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// authConfig 构造认证配置：未显式指定 auth 时，同时有 clientId 和 clientSecret 视为 IdC，否则为 Social
+func (f credentialFields) authConfig() AuthConfig {
+	authType := f.authType
+	if authType == "" {
+		authType = AuthMethodSocial
+		if f.clientID != "" && f.clientSecret != "" {
+			authType = AuthMethodIdC
+		}
+	}
+	return AuthConfig{
+		AuthType:     authType,
+		RefreshToken: f.refreshToken,
+		ClientID:     f.clientID,
+		ClientSecret: f.clientSecret,
+		Region:       f.region,
+	}
+}
+
+// normalizeCredentialKey 去掉命名空间前缀、下划线和连字符并转小写，使 refresh_token、refreshToken 和
+// https://kiro.dev/claims/refresh-token 等写法指向同一字段
+func normalizeCredentialKey(key string) string {
+	if i := strings.LastIndexAny(key, "/:"); i >= 0 {
+		key = key[i+1:]
+	}
+	key = strings.NewReplacer("_", "", "-", "").Replace(key)
+	return strings.ToLower(strings.TrimSpace(key))
+}
+
+// awsCLIParser 解析 ~/.aws/credentials 风格的INI文件，每个含 refresh_token 的 profile 生成一个配置
+// 只有 aws_access_key_id 等长期密钥的 profile 无法用于刷新token，直接跳过
+type awsCLIParser struct{}
+
+func (awsCLIParser) Format() string { return CredentialFormatAWSCLI }
+
+func (awsCLIParser) Parse(data []byte) ([]AuthConfig, error) {
+	var (
+		configs []AuthConfig
+		current *credentialFields
+	)
+	flush := func() {
+		if current != nil && current.refreshToken != "" {
+			configs = append(configs, current.authConfig())
+		}
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("第 %d 行: profile 声明缺少 ]", lineNo)
+			}
+			flush()
+			current = &credentialFields{}
+			continue
+		}
+
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			return nil, fmt.Errorf("第 %d 行: 缺少 =", lineNo)
+		}
+		if current == nil {
+			return nil, fmt.Errorf("第 %d 行: 键值对出现在 profile 声明之前", lineNo)
+		}
+		current.set(key, value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取INI失败: %w", err)
+	}
+	flush()
+
+	if len(configs) == 0 {
+		return nil, ErrNoCredentials
+	}
+	return configs, nil
+}
+
+// samlParser 解析SAML断言，从 AttributeStatement 的属性中读取凭证字段
+// 输入可以是单个 Assertion，也可以是包含多个 Assertion 的 Response，每个断言生成一个配置
+type samlParser struct{}
+
+type samlAssertion struct {
+	Attributes []struct {
+		Name   string   `xml:"Name,attr"`
+		Values []string `xml:"AttributeValue"`
+	} `xml:"AttributeStatement>Attribute"`
+}
+
+func (samlParser) Format() string { return CredentialFormatSAML }
+
+func (samlParser) Parse(data []byte) ([]AuthConfig, error) {
+	var configs []AuthConfig
+	assertions := 0
+
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("XML格式无效: %w", err)
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "Assertion" {
+			continue
+		}
+
+		var assertion samlAssertion
+		if err := decoder.DecodeElement(&assertion, &start); err != nil {
+			return nil, fmt.Errorf("解析SAML断言失败: %w", err)
+		}
+		assertions++
+
+		var fields credentialFields
+		for _, attr := range assertion.Attributes {
+			if len(attr.Values) > 0 {
+				fields.set(attr.Name, attr.Values[0])
+			}
+		}
+		if fields.refreshToken != "" {
+			configs = append(configs, fields.authConfig())
+		}
+	}
+
+	if assertions == 0 {
+		return nil, fmt.Errorf("未找到SAML Assertion元素")
+	}
+	if len(configs) == 0 {
+		return nil, ErrNoCredentials
+	}
+	return configs, nil
+}
+
+// oidcParser 解析OIDC token端点的JSON响应，支持单个对象或对象数组
+// 字段名同时接受 refresh_token 和 AWS SSO OIDC 的 refreshToken 写法
+type oidcParser struct{}
+
+func (oidcParser) Format() string { return CredentialFormatOIDC }
+
+func (oidcParser) Parse(data []byte) ([]AuthConfig, error) {
+	var responses []map[string]any
+	if err := json.Unmarshal(data, &responses); err != nil {
+		var single map[string]any
+		if err := json.Unmarshal(data, &single); err != nil {
+			return nil, fmt.Errorf("JSON格式无效: %w", err)
+		}
+		responses = []map[string]any{single}
+	}
+
+	var configs []AuthConfig
+	for i, resp := range responses {
+		// token端点的错误响应（如 invalid_grant）不含可用凭证
+		if code, ok := resp["error"].(string); ok && code != "" {
+			description, _ := resp["error_description"].(string)
+			return nil, fmt.Errorf("响应 #%d 是OIDC错误: %s %s", i, code, description)
+		}
+
+		var fields credentialFields
+		for key, value := range resp {
+			if s, ok := value.(string); ok {
+				fields.set(key, s)
+			}
+		}
+		if fields.refreshToken != "" {
+			configs = append(configs, fields.authConfig())
+		}
+	}
+
+	if len(configs) == 0 {
+		return nil, ErrNoCredentials
+	}
+	return configs, nil
+}
@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func parseCredentialFile(t *testing.T, format, name string) ([]AuthConfig, error) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	require.NoError(t, err)
+	parser, ok := GetCredentialParser(format)
+	require.True(t, ok)
+	return parser.Parse(data)
+}
+
+func TestAWSCLIParser(t *testing.T) {
+	configs, err := parseCredentialFile(t, CredentialFormatAWSCLI, "credentials.ini")
+	require.NoError(t, err)
+
+	// 只有 access key 的 default profile 被跳过
+	assert.Equal(t, []AuthConfig{
+		{AuthType: AuthMethodSocial, RefreshToken: "aorAAAAAGsocialToken", Region: "us-east-1"},
+		{AuthType: AuthMethodIdC, RefreshToken: "aorAAAAAGidcToken", ClientID: "idc-client-id", ClientSecret: "idc-client-secret", Region: "eu-west-1"},
+	}, configs)
+	for _, cfg := range configs {
+		assert.NoError(t, ValidateAuthConfig(cfg))
+	}
+
+	_, err = awsCLIParser{}.Parse([]byte("[default]\naws_access_key_id = AKIA\n"))
+	assert.ErrorIs(t, err, ErrNoCredentials)
+	_, err = awsCLIParser{}.Parse([]byte("refresh_token = x\n"))
+	assert.ErrorContains(t, err, "第 1 行")
+}
+
+func TestSAMLParser(t *testing.T) {
+	configs, err := parseCredentialFile(t, CredentialFormatSAML, "saml_assertion.xml")
+	require.NoError(t, err)
+
+	assert.Equal(t, []AuthConfig{
+		{AuthType: AuthMethodIdC, RefreshToken: "aorAAAAAGsamlToken", ClientID: "saml-client-id", ClientSecret: "saml-client-secret", Region: "us-west-2"},
+	}, configs)
+
+	_, err = samlParser{}.Parse([]byte(`<Response><Issuer>x</Issuer></Response>`))
+	assert.ErrorContains(t, err, "Assertion")
+	_, err = samlParser{}.Parse([]byte(`<Assertion><AttributeStatement></AttributeStatement></Assertion>`))
+	assert.ErrorIs(t, err, ErrNoCredentials)
+}
+
+func TestOIDCParser(t *testing.T) {
+	configs, err := parseCredentialFile(t, CredentialFormatOIDC, "oidc_token.json")
+	require.NoError(t, err)
+	assert.Equal(t, []AuthConfig{{AuthType: AuthMethodSocial, RefreshToken: "aorAAAAAGoidcToken"}}, configs)
+
+	// AWS SSO OIDC 的驼峰字段和数组形式
+	configs, err = oidcParser{}.Parse([]byte(`[{"accessToken":"a","refreshToken":"r1","clientId":"c","clientSecret":"s"},{"refresh_token":"r2"}]`))
+	require.NoError(t, err)
+	require.Len(t, configs, 2)
+	assert.Equal(t, AuthMethodIdC, configs[0].AuthType)
+	assert.Equal(t, "r2", configs[1].RefreshToken)
+
+	_, err = oidcParser{}.Parse([]byte(`{"error":"invalid_grant","error_description":"expired"}`))
+	assert.ErrorContains(t, err, "invalid_grant")
+	_, err = oidcParser{}.Parse([]byte(`{"access_token":"a"}`))
+	assert.ErrorIs(t, err, ErrNoCredentials)
+}
+
+func TestGetCredentialParser(t *testing.T) {
+	assert.Equal(t, []string{"awscli", "oidc", "saml"}, SupportedCredentialFormats())
+	parser, ok := GetCredentialParser("OIDC")
+	require.True(t, ok)
+	assert.Equal(t, CredentialFormatOIDC, parser.Format())
+	_, ok = GetCredentialParser("pem")
+	assert.False(t, ok)
+}
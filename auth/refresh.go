@@ -8,23 +8,57 @@ import (
 	"kiro2api/types"
 	"kiro2api/utils"
 	"net/http"
-	"time"
+)
+
+// 上游刷新端点，测试中可替换为本地模拟服务
+var (
+	refreshTokenURL    = config.RefreshTokenURL
+	idcRefreshTokenURL = config.IdcRefreshTokenURL
 )
 
 // refreshSingleToken 刷新单个token
 func (tm *TokenManager) refreshSingleToken(authConfig AuthConfig) (types.TokenInfo, error) {
+	return RefreshToken(authConfig)
+}
+
+// RefreshToken 按认证方式刷新单个配置的token，配置格式无效时不发起请求
+func RefreshToken(authConfig AuthConfig) (types.TokenInfo, error) {
+	// 配置格式无效时不发起刷新请求
+	if err := ValidateAuthConfig(authConfig); err != nil {
+		return types.TokenInfo{}, fmt.Errorf("认证配置无效: %w", err)
+	}
+
+	var token types.TokenInfo
+	var err error
 	switch authConfig.AuthType {
 	case AuthMethodSocial:
-		return refreshSocialToken(authConfig.RefreshToken)
+		token, err = refreshSocialToken(authConfig.RefreshToken, authConfig.Region)
 	case AuthMethodIdC:
-		return refreshIdCToken(authConfig)
+		token, err = refreshIdCToken(authConfig)
 	default:
 		return types.TokenInfo{}, fmt.Errorf("不支持的认证类型: %s", authConfig.AuthType)
 	}
+	if err == nil {
+		token.ProfileArn = defaultProfileArn(authConfig, token.ProfileArn)
+		token.Profiles = authConfig.Profiles
+	}
+	return token, err
+}
+
+// defaultProfileArn 账号默认发送的 profileArn：账号配置的 profileArn 优先；
+// 刷新响应中的 profileArn 只在 SEND_REFRESH_PROFILE_ARN 开启时使用，默认不改变发往上游的请求
+func defaultProfileArn(authConfig AuthConfig, refreshed string) string {
+	if authConfig.ProfileArn != "" {
+		return authConfig.ProfileArn
+	}
+	if config.IsRefreshProfileArnEnabled() {
+		return refreshed
+	}
+	return ""
 }
 
-// refreshSocialToken 刷新Social认证token
-func refreshSocialToken(refreshToken string) (types.TokenInfo, error) {
+// refreshSocialToken 刷新Social认证token，region 为空时使用默认区域
+func refreshSocialToken(refreshToken, region string) (types.TokenInfo, error) {
 	refreshReq := types.RefreshRequest{
 		RefreshToken: refreshToken,
 	}
@@ -34,7 +68,7 @@ func refreshSocialToken(refreshToken string) (types.TokenInfo, error) {
 		return types.TokenInfo{}, fmt.Errorf("序列化请求失败: %v", err)
 	}
 
-	req, err := http.NewRequest("POST", config.RefreshTokenURL, bytes.NewBuffer(reqBody))
+	req, err := http.NewRequest("POST", regionalURL(refreshTokenURL, region), bytes.NewBuffer(reqBody))
 	if err != nil {
 		return types.TokenInfo{}, fmt.Errorf("创建请求失败: %v", err)
 	}
@@ -65,6 +99,7 @@ func refreshSocialToken(refreshToken string) (types.TokenInfo, error) {
 
 	var token types.Token
 	token.FromRefreshResponse(refreshResp, refreshToken)
+	token.ExpiresAt = expiresAt(tokenClock.observe(resp), refreshResp.ExpiresIn)
 
 	return token, nil
 }
@@ -83,14 +118,14 @@ func refreshIdCToken(authConfig AuthConfig) (types.TokenInfo, error) {
 		return types.TokenInfo{}, fmt.Errorf("序列化IdC请求失败: %v", err)
 	}
 
-	req, err := http.NewRequest("POST", config.IdcRefreshTokenURL, bytes.NewBuffer(reqBody))
+	req, err := http.NewRequest("POST", regionalURL(idcRefreshTokenURL, authConfig.Region), bytes.NewBuffer(reqBody))
 	if err != nil {
 		return types.TokenInfo{}, fmt.Errorf("创建IdC请求失败: %v", err)
 	}
 
 	// 设置IdC特殊headers（与真实 KiroIDE 完全一致）
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Host", "oidc.us-east-1.amazonaws.com")
+	req.Header.Set("Host", req.URL.Host)
 	req.Header.Set("Connection", "keep-alive")
 	req.Header.Set("x-amz-user-agent", "aws-sdk-js/3.738.0 ua/2.1 os/other lang/js md/browser#unknown_unknown api/sso-oidc#3.738.0 m/E KiroIDE")
 	req.Header.Set("amz-sdk-invocation-id", generateInvocationID())
@@ -127,14 +162,14 @@ func refreshIdCToken(authConfig AuthConfig) (types.TokenInfo, error) {
 	token.AccessToken = refreshResp.AccessToken
 	token.RefreshToken = authConfig.RefreshToken
 	token.ExpiresIn = refreshResp.ExpiresIn
-	token.ExpiresAt = time.Now().Add(time.Duration(refreshResp.ExpiresIn) * time.Second)
+	token.ExpiresAt = expiresAt(tokenClock.observe(resp), refreshResp.ExpiresIn)
 
 	return token, nil
 }
 
 // RefreshSocialToken 公开的Social token刷新函数
-func RefreshSocialToken(refreshToken string) (types.TokenInfo, error) {
-	return refreshSocialToken(refreshToken)
+func RefreshSocialToken(refreshToken, region string) (types.TokenInfo, error) {
+	return refreshSocialToken(refreshToken, region)
 }
 
 // RefreshIdCToken 公开的IdC token刷新函数
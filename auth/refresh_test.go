@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"kiro2api/types"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefreshToken_ProfileArn(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(types.RefreshResponse{
+			AccessToken: "access", ExpiresIn: 3600,
+			ProfileArn: "arn:aws:codewhisperer:us-east-1:123456789012:profile/DEFAULT",
+		})
+	}))
+	t.Cleanup(server.Close)
+	orig := refreshTokenURL
+	refreshTokenURL = server.URL
+	t.Cleanup(func() { refreshTokenURL = orig })
+
+	token, err := RefreshToken(AuthConfig{AuthType: AuthMethodSocial, RefreshToken: "refresh"})
+	require.NoError(t, err)
+	assert.Empty(t, token.ProfileArn, "默认不发送刷新响应中的profileArn")
+
+	t.Setenv("SEND_REFRESH_PROFILE_ARN", "true")
+	token, err = RefreshToken(AuthConfig{AuthType: AuthMethodSocial, RefreshToken: "refresh"})
+	require.NoError(t, err)
+	assert.Equal(t, "arn:aws:codewhisperer:us-east-1:123456789012:profile/DEFAULT", token.ProfileArn, "开启后沿用刷新响应中的profileArn")
+
+	profiles := map[string]string{"opus": "arn:aws:codewhisperer:us-east-1:123456789012:profile/OPUS"}
+	token, err = RefreshToken(AuthConfig{AuthType: AuthMethodSocial, RefreshToken: "refresh",
+		ProfileArn: "arn:aws:codewhisperer:us-east-1:123456789012:profile/CUSTOM", Profiles: profiles})
+	require.NoError(t, err)
+	assert.Equal(t, "arn:aws:codewhisperer:us-east-1:123456789012:profile/CUSTOM", token.ProfileArn, "配置的profileArn优先")
+	assert.Equal(t, profiles, token.Profiles, "账号的profiles随token传递")
+}
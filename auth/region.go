@@ -0,0 +1,22 @@
+package auth
+
+import (
+	"net/url"
+	"strings"
+
+	"kiro2api/config"
+)
+
+// regionalURL 将上游地址主机名中的默认区域替换为指定区域
+// region 为空、等于默认区域，或地址中不含默认区域（如测试用的本地模拟服务）时原样返回
+func regionalURL(rawURL, region string) string {
+	if region == "" || region == config.DefaultRegion {
+		return rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || !strings.Contains(u.Host, config.DefaultRegion) {
+		return rawURL
+	}
+	u.Host = strings.Replace(u.Host, config.DefaultRegion, region, 1)
+	return u.String()
+}
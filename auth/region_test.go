@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"kiro2api/config"
+	"kiro2api/types"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegionalURL(t *testing.T) {
+	assert.Equal(t, "https://prod.eu-central-1.auth.desktop.kiro.dev/refreshToken",
+		regionalURL(config.RefreshTokenURL, "eu-central-1"))
+	assert.Equal(t, "https://oidc.eu-central-1.amazonaws.com/token",
+		regionalURL(config.IdcRefreshTokenURL, "eu-central-1"))
+	assert.Equal(t, config.IdcRefreshTokenURL, regionalURL(config.IdcRefreshTokenURL, ""))
+	assert.Equal(t, config.IdcRefreshTokenURL, regionalURL(config.IdcRefreshTokenURL, config.DefaultRegion))
+	assert.Equal(t, "http://127.0.0.1:8080/token", regionalURL("http://127.0.0.1:8080/token", "eu-central-1"))
+}
+
+func TestUsageLimitsChecker_UsesRegionalHost(t *testing.T) {
+	var gotHost string
+	checker := NewUsageLimitsChecker("eu-central-1")
+	checker.httpClient = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		gotHost = req.URL.Host
+		rec := httptest.NewRecorder()
+		rec.WriteString(`{"usageBreakdownList":[]}`)
+		return rec.Result(), nil
+	})}
+
+	_, err := checker.CheckUsageLimits(types.TokenInfo{AccessToken: "mock-access-token-0123456789"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "q.eu-central-1.amazonaws.com", gotHost)
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// tokenIDClock 保证同一进程内生成的TokenID严格递增
+// 同一毫秒内用12位序号区分，序号用尽时借用下一毫秒
+var tokenIDClock struct {
+	sync.Mutex
+	lastMs int64
+	seq    uint16
+}
+
+// newTokenID 生成按时间排序的UUID（版本7布局：48位毫秒时间戳 + 12位序号 + 随机数）
+// 字典序与生成顺序一致，按TokenID排序即可还原添加顺序
+func newTokenID() string {
+	tokenIDClock.Lock()
+	ms := time.Now().UnixMilli()
+	if ms <= tokenIDClock.lastMs {
+		ms = tokenIDClock.lastMs
+		tokenIDClock.seq++
+		if tokenIDClock.seq > 0x0fff {
+			ms++
+			tokenIDClock.seq = 0
+		}
+	} else {
+		tokenIDClock.seq = 0
+	}
+	tokenIDClock.lastMs = ms
+	seq := tokenIDClock.seq
+	tokenIDClock.Unlock()
+
+	var b [16]byte
+	_, _ = rand.Read(b[8:])
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(ms))
+	copy(b[0:6], ts[2:8])
+	b[6] = 0x70 | byte(seq>>8) // Version 7
+	b[7] = byte(seq)
+	b[8] = (b[8] & 0x3f) | 0x80 // Variant bits
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// ensureTokenIDs 为缺少TokenID或与之前配置重复的项分配新ID（按数组顺序生成，保持原有相对顺序）
+// existing 为已占用的ID集合，可为nil；返回新分配的数量
+func ensureTokenIDs(configs []AuthConfig, existing map[string]bool) int {
+	seen := make(map[string]bool, len(existing)+len(configs))
+	for id := range existing {
+		seen[id] = true
+	}
+	assigned := 0
+	for i := range configs {
+		if configs[i].TokenID == "" || seen[configs[i].TokenID] {
+			configs[i].TokenID = newTokenID()
+			assigned++
+		}
+		seen[configs[i].TokenID] = true
+	}
+	return assigned
+}
+
+// sortConfigsByTokenID 按TokenID稳定排序
+func sortConfigsByTokenID(configs []AuthConfig) {
+	sort.SliceStable(configs, func(i, j int) bool {
+		return configs[i].TokenID < configs[j].TokenID
+	})
+}
@@ -0,0 +1,229 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"kiro2api/logger"
+)
+
+// ErrNoConfigRollback 没有可回滚的配置（未执行过替换导入或已回滚过）
+var ErrNoConfigRollback = errors.New("没有可回滚的token配置")
+
+// TokenImportOptions 导入token配置的选项
+type TokenImportOptions struct {
+	DryRun  bool // 只校验并返回报告，不修改配置、不刷新token
+	Replace bool // 用导入的配置整体替换现有配置，旧配置保留一份供 RollbackConfigs 恢复
+}
+
+// TokenImportIssue 导入时被跳过的配置
+type TokenImportIssue struct {
+	Index   int    `json:"index"` // 在导入数据中的位置
+	Reason  string `json:"reason"`
+	TokenID string `json:"token_id,omitempty"` // 与已有配置重复时为已有配置的TokenID
+}
+
+// TokenImportReport 导入报告
+type TokenImportReport struct {
+	DryRun     bool               `json:"dry_run"`
+	Replace    bool               `json:"replace"`
+	WouldAdd   int                `json:"would_add"` // 去重后将写入的配置数
+	Removed    int                `json:"removed"`   // 替换模式下被移除的已有配置数
+	Duplicates []TokenImportIssue `json:"duplicates"`
+	Invalid    []TokenImportIssue `json:"invalid"`
+	AddedIDs   []string           `json:"token_ids"` // 实际新增的TokenID，dry_run时为空
+}
+
+// tokenConfigKey 导入去重的key：refreshToken的哈希，IdC再加上clientId
+func tokenConfigKey(cfg AuthConfig) string {
+	sum := sha256.Sum256([]byte(cfg.RefreshToken))
+	key := hex.EncodeToString(sum[:])
+	if cfg.AuthType == AuthMethodIdC {
+		key += ":" + cfg.ClientID
+	}
+	return key
+}
+
+// planImportUnlocked 校验并去重导入的配置，返回报告和待写入的配置，不修改任何状态
+// 替换模式下与已有配置相同的项沿用原TokenID，缓存的token继续有效
+// 内部方法：调用者必须持有 tm.mutex
+func (tm *TokenManager) planImportUnlocked(newConfigs []AuthConfig, opts TokenImportOptions) (TokenImportReport, []AuthConfig) {
+	report := TokenImportReport{
+		DryRun:     opts.DryRun,
+		Replace:    opts.Replace,
+		Duplicates: []TokenImportIssue{},
+		Invalid:    []TokenImportIssue{},
+		AddedIDs:   []string{},
+	}
+
+	existing := make(map[string]string, len(tm.configs))
+	for _, cfg := range tm.configs {
+		existing[tokenConfigKey(cfg)] = cfg.TokenID
+	}
+
+	seen := make(map[string]int, len(newConfigs))
+	accepted := make([]AuthConfig, 0, len(newConfigs))
+	for i, cfg := range newConfigs {
+		if err := ValidateAuthConfig(cfg); err != nil {
+			report.Invalid = append(report.Invalid, TokenImportIssue{Index: i, Reason: err.Error()})
+			continue
+		}
+
+		key := tokenConfigKey(cfg)
+		if first, ok := seen[key]; ok {
+			report.Duplicates = append(report.Duplicates, TokenImportIssue{Index: i, Reason: fmt.Sprintf("与导入数据中的配置 #%d 重复", first)})
+			continue
+		}
+		seen[key] = i
+
+		cfg.TokenID = ""
+		if existingID, ok := existing[key]; ok {
+			if !opts.Replace {
+				report.Duplicates = append(report.Duplicates, TokenImportIssue{Index: i, Reason: "与已有配置重复", TokenID: existingID})
+				continue
+			}
+			cfg.TokenID = existingID
+		}
+		accepted = append(accepted, cfg)
+	}
+
+	report.WouldAdd = len(accepted)
+	if opts.Replace {
+		report.Removed = len(tm.configs)
+		for _, cfg := range accepted {
+			if cfg.TokenID != "" {
+				report.Removed--
+			}
+		}
+	}
+	return report, accepted
+}
+
+// ImportConfigs 导入token配置：按refreshToken去重，已有的配置跳过并写入报告
+// 任一配置无效时整体拒绝；DryRun 只返回报告；Replace 整体替换现有配置
+func (tm *TokenManager) ImportConfigs(newConfigs []AuthConfig, opts TokenImportOptions) (TokenImportReport, error) {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	report, accepted := tm.planImportUnlocked(newConfigs, opts)
+	if opts.DryRun {
+		return report, nil
+	}
+	if len(report.Invalid) > 0 {
+		first := report.Invalid[0]
+		return report, fmt.Errorf("配置 #%d 无效: %s", first.Index, first.Reason)
+	}
+	if opts.Replace && len(accepted) == 0 {
+		return report, fmt.Errorf("替换后的配置不能为空")
+	}
+
+	// 新添加的配置总是生成新的TokenID（忽略导入数据中携带的ID），保证排在已有配置之后
+	for i := range accepted {
+		if accepted[i].TokenID == "" {
+			accepted[i].TokenID = newTokenID()
+			report.AddedIDs = append(report.AddedIDs, accepted[i].TokenID)
+		}
+	}
+
+	oldCount := len(tm.configs)
+	if opts.Replace {
+		tm.previousConfigs = tm.configs
+		sortConfigsByTokenID(accepted)
+		tm.swapConfigsUnlocked(accepted)
+	} else {
+		tm.configs = append(tm.configs, accepted...)
+		// 不重置 currentIndex 和 exhausted，保持原有使用状态
+		tm.configOrder = generateConfigOrder(tm.configs)
+	}
+
+	logger.Info("token配置已导入",
+		logger.Int("old_count", oldCount),
+		logger.Int("total_count", len(tm.configs)),
+		logger.Int("added", len(report.AddedIDs)),
+		logger.Int("duplicates", len(report.Duplicates)),
+		logger.Bool("replace", opts.Replace))
+
+	// 🔥 持久化保存配置到文件
+	tm.persistUnlocked()
+
+	tm.refreshUncachedUnlocked(report.AddedIDs)
+	tm.notifyTokenWaitersUnlocked()
+	return report, nil
+}
+
+// RollbackConfigs 恢复最近一次替换导入之前的配置，只能回滚一次，返回恢复后的配置数
+func (tm *TokenManager) RollbackConfigs() (int, error) {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	if tm.previousConfigs == nil {
+		return 0, ErrNoConfigRollback
+	}
+
+	current := make(map[string]bool, len(tm.configs))
+	for _, cfg := range tm.configs {
+		current[cfg.TokenID] = true
+	}
+	var restoredIDs []string
+	for _, cfg := range tm.previousConfigs {
+		if !current[cfg.TokenID] {
+			restoredIDs = append(restoredIDs, cfg.TokenID)
+		}
+	}
+
+	tm.swapConfigsUnlocked(tm.previousConfigs)
+	tm.previousConfigs = nil
+	tm.persistUnlocked()
+	tm.refreshUncachedUnlocked(restoredIDs)
+	tm.notifyTokenWaitersUnlocked()
+
+	logger.Info("token配置已回滚", logger.Int("config_count", len(tm.configs)))
+	return len(tm.configs), nil
+}
+
+// swapConfigsUnlocked 整体替换配置，清除不再存在或已停用的token的缓存和选择状态
+// 内部方法：调用者必须持有 tm.mutex
+func (tm *TokenManager) swapConfigsUnlocked(configs []AuthConfig) {
+	kept := make(map[string]bool, len(configs))
+	for _, cfg := range configs {
+		kept[cfg.TokenID] = !cfg.Disabled && !cfg.IsDeleted()
+	}
+	for _, cfg := range tm.configs {
+		if kept[cfg.TokenID] {
+			continue
+		}
+		delete(tm.cache.tokens, cfg.TokenID)
+		delete(tm.exhausted, cfg.TokenID)
+		delete(tm.retryAfter, cfg.TokenID)
+	}
+
+	tm.configs = configs
+	tm.rebuildConfigOrderUnlocked()
+}
+
+// refreshUncachedUnlocked 刷新指定的尚未缓存的token（新导入或回滚恢复的配置），失败只记录日志
+// 内部方法：调用者必须持有 tm.mutex
+func (tm *TokenManager) refreshUncachedUnlocked(tokenIDs []string) {
+	for _, tokenID := range tokenIDs {
+		i := tm.indexOfUnlocked(tokenID)
+		if i < 0 {
+			continue
+		}
+		cfg := tm.configs[i]
+		if cfg.Disabled || cfg.IsDeleted() {
+			logger.Info("跳过禁用或已删除的token", logger.Int("index", i))
+			continue
+		}
+		if _, cached := tm.cache.tokens[cfg.TokenID]; cached {
+			continue
+		}
+		if err := tm.refreshCachedTokenUnlocked(cfg); err != nil {
+			logger.Warn("刷新新添加的token失败（但配置已保存）",
+				logger.Int("config_index", i),
+				logger.String("auth_type", cfg.AuthType),
+				logger.Err(err))
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readPersistedConfigs(t *testing.T, storage *ConfigStorage) []AuthConfig {
+	t.Helper()
+	data, err := os.ReadFile(storage.filePath)
+	require.NoError(t, err)
+	var persisted []AuthConfig
+	require.NoError(t, json.Unmarshal(data, &persisted))
+	return persisted
+}
+
+func TestTokenManager_ImportConfigsSkipsDuplicates(t *testing.T) {
+	storage := newTestStorage(t)
+	newMockAuthServer(t, http.StatusOK, 10)
+
+	tm := NewTokenManager([]AuthConfig{
+		{AuthType: AuthMethodSocial, RefreshToken: "existing"},
+		{AuthType: AuthMethodIdC, RefreshToken: "idc-token", ClientID: "client-a", ClientSecret: "secret"},
+	})
+	tm.storage = storage
+	existingIDs := []string{tm.configs[0].TokenID, tm.configs[1].TokenID}
+
+	report, err := tm.ImportConfigs([]AuthConfig{
+		{AuthType: AuthMethodSocial, RefreshToken: "existing"},
+		{AuthType: AuthMethodSocial, RefreshToken: "new-token"},
+		{AuthType: AuthMethodSocial, RefreshToken: "new-token", Region: "eu-west-1"},
+		{AuthType: AuthMethodIdC, RefreshToken: "idc-token", ClientID: "client-a", ClientSecret: "other-secret"},
+		// 同一refreshToken但clientId不同的IdC配置视为不同账号
+		{AuthType: AuthMethodIdC, RefreshToken: "idc-token", ClientID: "client-b", ClientSecret: "secret"},
+	}, TokenImportOptions{})
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, report.WouldAdd)
+	require.Len(t, report.AddedIDs, 2)
+	assert.Equal(t, []TokenImportIssue{
+		{Index: 0, Reason: "与已有配置重复", TokenID: existingIDs[0]},
+		{Index: 2, Reason: "与导入数据中的配置 #1 重复"},
+		{Index: 3, Reason: "与已有配置重复", TokenID: existingIDs[1]},
+	}, report.Duplicates)
+	assert.Empty(t, report.Invalid)
+
+	configs := tm.GetCurrentConfigs()
+	assert.Equal(t, []string{"existing", "idc-token", "new-token", "idc-token"}, refreshTokens(configs))
+	assert.Equal(t, "client-b", configs[3].ClientID)
+	assert.Equal(t, configs, readPersistedConfigs(t, storage))
+
+	// 再次导入同一份数据不会重复添加
+	report, err = tm.ImportConfigs(configs, TokenImportOptions{})
+	require.NoError(t, err)
+	assert.Zero(t, report.WouldAdd)
+	assert.Len(t, report.Duplicates, 4)
+	assert.Len(t, tm.GetCurrentConfigs(), 4)
+}
+
+func TestTokenManager_ImportConfigsDryRun(t *testing.T) {
+	storage := newTestStorage(t)
+
+	tm := NewTokenManager([]AuthConfig{{AuthType: AuthMethodSocial, RefreshToken: "existing", Disabled: true}})
+	tm.storage = storage
+	before := tm.GetCurrentConfigs()
+
+	imported := []AuthConfig{
+		{AuthType: AuthMethodSocial, RefreshToken: "existing"},
+		{AuthType: AuthMethodSocial, RefreshToken: "new-token"},
+		{AuthType: AuthMethodIdC, RefreshToken: "missing-client"},
+		{AuthType: AuthMethodSocial, RefreshToken: "has space"},
+	}
+	report, err := tm.ImportConfigs(imported, TokenImportOptions{DryRun: true})
+	require.NoError(t, err, "dry_run 只返回报告，无效配置不报错")
+
+	assert.True(t, report.DryRun)
+	assert.Equal(t, 1, report.WouldAdd)
+	assert.Empty(t, report.AddedIDs)
+	assert.Equal(t, []TokenImportIssue{{Index: 0, Reason: "与已有配置重复", TokenID: before[0].TokenID}}, report.Duplicates)
+	require.Len(t, report.Invalid, 2)
+	assert.Equal(t, 2, report.Invalid[0].Index)
+	assert.Contains(t, report.Invalid[0].Reason, "clientId")
+	assert.Equal(t, 3, report.Invalid[1].Index)
+	assert.Contains(t, report.Invalid[1].Reason, "空白字符")
+
+	// 不修改配置、不刷新、不写文件
+	assert.Equal(t, before, tm.GetCurrentConfigs())
+	assert.Empty(t, tm.cache.tokens)
+	assert.NoFileExists(t, storage.filePath)
+
+	// 实际导入时任一配置无效则整体拒绝
+	_, err = tm.ImportConfigs(imported, TokenImportOptions{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "配置 #2 无效")
+	assert.Equal(t, before, tm.GetCurrentConfigs())
+}
+
+func TestTokenManager_ImportConfigsReplaceAndRollback(t *testing.T) {
+	storage := newTestStorage(t)
+	newMockAuthServer(t, http.StatusOK, 10)
+
+	tm := NewTokenManager([]AuthConfig{
+		{AuthType: AuthMethodSocial, RefreshToken: "kept"},
+		{AuthType: AuthMethodSocial, RefreshToken: "removed"},
+	})
+	tm.storage = storage
+	_, err := tm.RefreshAllTokens()
+	require.NoError(t, err)
+	original := tm.GetCurrentConfigs()
+	keptID, removedID := original[0].TokenID, original[1].TokenID
+
+	_, err = tm.RollbackConfigs()
+	assert.ErrorIs(t, err, ErrNoConfigRollback, "未替换过时没有可回滚的配置")
+
+	// 替换为空集合被拒绝
+	_, err = tm.ImportConfigs([]AuthConfig{}, TokenImportOptions{Replace: true})
+	require.Error(t, err)
+	assert.Equal(t, original, tm.GetCurrentConfigs())
+
+	// dry_run 报告替换后的变化
+	report, err := tm.ImportConfigs([]AuthConfig{
+		{AuthType: AuthMethodSocial, RefreshToken: "kept"},
+		{AuthType: AuthMethodSocial, RefreshToken: "added"},
+	}, TokenImportOptions{Replace: true, DryRun: true})
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.WouldAdd)
+	assert.Equal(t, 1, report.Removed)
+	assert.Equal(t, original, tm.GetCurrentConfigs())
+
+	report, err = tm.ImportConfigs([]AuthConfig{
+		{AuthType: AuthMethodSocial, RefreshToken: "kept"},
+		{AuthType: AuthMethodSocial, RefreshToken: "added"},
+	}, TokenImportOptions{Replace: true})
+	require.NoError(t, err)
+	require.Len(t, report.AddedIDs, 1)
+	assert.Empty(t, report.Duplicates)
+
+	replaced := tm.GetCurrentConfigs()
+	assert.Equal(t, []string{"kept", "added"}, refreshTokens(replaced))
+	assert.Equal(t, keptID, replaced[0].TokenID, "相同的配置沿用原TokenID")
+	assert.Equal(t, []string{keptID, report.AddedIDs[0]}, tm.configOrder)
+	assert.NotContains(t, tm.cache.tokens, removedID)
+	assert.Contains(t, tm.cache.tokens, report.AddedIDs[0])
+	assert.Equal(t, replaced, readPersistedConfigs(t, storage))
+
+	// 回滚恢复替换前的token池和持久化文件
+	count, err := tm.RollbackConfigs()
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, original, tm.GetCurrentConfigs())
+	assert.Equal(t, []string{keptID, removedID}, tm.configOrder)
+	assert.Contains(t, tm.cache.tokens, removedID, "恢复的token重新刷新")
+	assert.NotContains(t, tm.cache.tokens, report.AddedIDs[0])
+	assert.Equal(t, original, readPersistedConfigs(t, storage))
+
+	// 只能回滚一次
+	_, err = tm.RollbackConfigs()
+	assert.ErrorIs(t, err, ErrNoConfigRollback)
+}
@@ -1,11 +1,13 @@
 package auth
 
 import (
+	"context"
 	"fmt"
 	"kiro2api/config"
 	"kiro2api/logger"
 	"kiro2api/types"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -15,26 +17,34 @@ type TokenManager struct {
 	configs      []AuthConfig
 	mutex        sync.RWMutex
 	lastRefresh  time.Time
-	configOrder  []string        // 配置顺序
-	currentIndex int             // 当前使用的token索引
-	exhausted    map[string]bool // 已耗尽的token记录
-	storage      *ConfigStorage  // 配置持久化存储
+	configOrder  []string             // 配置顺序
+	currentIndex int                  // 当前使用的token索引
+	exhausted    map[string]bool      // 已耗尽的token记录
+	retryAfter   map[string]time.Time // 上游要求退避的token（value为冷却结束时间）
+	storage      *ConfigStorage       // 配置持久化存储
+	wait         *tokenWaitQueue      // 没有可用token时的等待队列
+
+	previousConfigs []AuthConfig // 替换导入前的配置，供一次性回滚
 }
 
 // SimpleTokenCache 简化的token缓存（纯数据结构，无锁）
-// 所有并发访问由 TokenManager.mutex 统一管理
+// 并发访问由 TokenManager.mutex 统一管理：选择token只需读锁，刷新和管理操作持独占锁
 type SimpleTokenCache struct {
 	tokens map[string]*CachedToken
 	ttl    time.Duration
 }
 
 // CachedToken 缓存的token信息
+// Available 为刷新时的可用次数，只在持有 TokenManager.mutex 独占锁时写入；
+// 每次使用的扣减和最后使用时间通过原子计数记录，读锁下即可更新
 type CachedToken struct {
 	Token     types.TokenInfo
 	UsageInfo *types.UsageLimits
 	CachedAt  time.Time
-	LastUsed  time.Time
 	Available float64
+
+	used     atomic.Int64 // 刷新以来已扣减的次数
+	lastUsed atomic.Int64 // 最后使用时间（UnixNano）
 }
 
 // NewSimpleTokenCache 创建简单的token缓存
@@ -47,6 +57,10 @@ func NewSimpleTokenCache(ttl time.Duration) *SimpleTokenCache {
 
 // NewTokenManager 创建新的token管理器
 func NewTokenManager(configs []AuthConfig) *TokenManager {
+	// 补齐TokenID并按其排序，保证与持久化文件的顺序一致
+	ensureTokenIDs(configs, nil)
+	sortConfigsByTokenID(configs)
+
 	// 生成配置顺序
 	configOrder := generateConfigOrder(configs)
 
@@ -54,84 +68,173 @@ func NewTokenManager(configs []AuthConfig) *TokenManager {
 		logger.Int("config_count", len(configs)),
 		logger.Int("config_order_count", len(configOrder)))
 
-	return &TokenManager{
-		cache:        NewSimpleTokenCache(config.TokenCacheTTL),
+	tm := &TokenManager{
+		cache:        NewSimpleTokenCache(config.Get().TokenCacheTTL),
 		configs:      configs,
 		configOrder:  configOrder,
 		currentIndex: 0,
 		exhausted:    make(map[string]bool),
+		retryAfter:   make(map[string]time.Time),
 		storage:      NewConfigStorage(), // 初始化配置存储
 	}
+	tm.wait = newTokenWaitQueue()
+	return tm
 }
 
 // getBestToken 获取最优可用token
-// 统一锁管理：所有操作在单一锁保护下完成，避免多次加锁/解锁
 func (tm *TokenManager) getBestToken() (types.TokenInfo, error) {
-	tm.mutex.Lock()
-	defer tm.mutex.Unlock()
+	return tm.getBestTokenFrom(nil)
+}
 
-	// 检查是否需要刷新缓存（在锁内）
-	if time.Since(tm.lastRefresh) > config.TokenCacheTTL {
-		if err := tm.refreshCacheUnlocked(); err != nil {
-			logger.Warn("刷新token缓存失败", logger.Err(err))
+// getBestTokenFrom 获取最优可用token，tokenIDs 非空时只在该 TokenID 子集中选择
+func (tm *TokenManager) getBestTokenFrom(tokenIDs []string) (types.TokenInfo, error) {
+	return tm.getBestTokenContext(context.Background(), tokenIDs)
+}
+
+// getBestTokenContext 同 getBestTokenFrom，ctx 结束时放弃排队等待
+func (tm *TokenManager) getBestTokenContext(ctx context.Context, tokenIDs []string) (types.TokenInfo, error) {
+	tokenWithUsage, err := tm.GetBestTokenWithUsageContext(ctx, tokenIDs)
+	if err != nil {
+		return types.TokenInfo{}, err
+	}
+	return tokenWithUsage.TokenInfo, nil
+}
+
+// GetBestTokenWithUsage 获取最优可用token（包含使用信息）
+func (tm *TokenManager) GetBestTokenWithUsage() (*types.TokenWithUsage, error) {
+	return tm.GetBestTokenWithUsageFrom(nil)
+}
+
+// GetBestTokenWithUsageFrom 获取最优可用token（包含使用信息），tokenIDs 非空时只在该 TokenID 子集中选择
+func (tm *TokenManager) GetBestTokenWithUsageFrom(tokenIDs []string) (*types.TokenWithUsage, error) {
+	return tm.GetBestTokenWithUsageContext(context.Background(), tokenIDs)
+}
+
+// GetBestTokenWithUsageContext 同 GetBestTokenWithUsageFrom，ctx 结束（如客户端断开）时放弃排队等待
+// 读多写少：当前token可用时只持读锁并原子扣减次数；需要刷新缓存、切换token或排队时才取独占锁
+func (tm *TokenManager) GetBestTokenWithUsageContext(ctx context.Context, tokenIDs []string) (*types.TokenWithUsage, error) {
+	tokenWithUsage := tm.acquireFast(tokenIDs)
+	if tokenWithUsage == nil {
+		var err error
+		if tokenWithUsage, err = tm.acquireSlow(ctx, tokenIDs); err != nil {
+			return nil, err
 		}
 	}
 
-	// 选择最优token（内部方法，不加锁）
-	bestToken := tm.selectBestTokenUnlocked()
-	if bestToken == nil {
-		return types.TokenInfo{}, fmt.Errorf("没有可用的token")
+	logger.Debug("返回TokenWithUsage",
+		logger.Float64("available_count", tokenWithUsage.AvailableCount),
+		logger.Bool("is_exceeded", tokenWithUsage.IsUsageExceeded))
+
+	return tokenWithUsage, nil
+}
+
+// acquireFast 快速路径：持读锁检查当前token，可用时原子扣减
+// 不修改选择状态（索引、耗尽标记、冷却记录），无法直接选中时返回nil由慢路径处理
+func (tm *TokenManager) acquireFast(tokenIDs []string) *types.TokenWithUsage {
+	tm.mutex.RLock()
+	defer tm.mutex.RUnlock()
+
+	// 需要刷新缓存或已有请求排队时交给慢路径，保持排队顺序
+	if time.Since(tm.lastRefresh) > config.Get().TokenCacheTTL || len(tm.wait.waiters) > 0 {
+		return nil
 	}
 
-	// 更新最后使用时间（在锁内，安全）
-	bestToken.LastUsed = time.Now()
-	if bestToken.Available > 0 {
-		bestToken.Available--
+	if len(tokenIDs) == 0 {
+		if len(tm.configOrder) == 0 {
+			return nil
+		}
+		return tm.takeIfReadyRLocked(tm.configOrder[tm.currentIndex])
 	}
 
-	return bestToken.Token, nil
+	allowed := make(map[string]bool, len(tokenIDs))
+	for _, id := range tokenIDs {
+		allowed[id] = true
+	}
+	for offset := 0; offset < len(tm.configOrder); offset++ {
+		key := tm.configOrder[(tm.currentIndex+offset)%len(tm.configOrder)]
+		if !allowed[key] {
+			continue
+		}
+		if tokenWithUsage := tm.takeIfReadyRLocked(key); tokenWithUsage != nil {
+			return tokenWithUsage
+		}
+	}
+	return nil
 }
 
-// GetBestTokenWithUsage 获取最优可用token（包含使用信息）
-// 统一锁管理：所有操作在单一锁保护下完成
-func (tm *TokenManager) GetBestTokenWithUsage() (*types.TokenWithUsage, error) {
+// takeIfReadyRLocked token存在、未过期、不在冷却期且扣减成功时返回其使用信息
+// 内部方法：调用者至少持有 tm.mutex 读锁
+func (tm *TokenManager) takeIfReadyRLocked(key string) *types.TokenWithUsage {
+	cached, exists := tm.cache.tokens[key]
+	if !exists || time.Since(cached.CachedAt) > tm.cache.ttl || tm.isCoolingDownRLocked(key) || cached.isExpired() {
+		return nil
+	}
+	available, ok := cached.take()
+	if !ok {
+		return nil
+	}
+	return cached.withUsage(available)
+}
+
+// acquireSlow 慢路径：持独占锁刷新缓存、按顺序切换token或排队等待
+func (tm *TokenManager) acquireSlow(ctx context.Context, tokenIDs []string) (*types.TokenWithUsage, error) {
 	tm.mutex.Lock()
 	defer tm.mutex.Unlock()
 
 	// 检查是否需要刷新缓存（在锁内）
-	if time.Since(tm.lastRefresh) > config.TokenCacheTTL {
+	if time.Since(tm.lastRefresh) > config.Get().TokenCacheTTL {
 		if err := tm.refreshCacheUnlocked(); err != nil {
 			logger.Warn("刷新token缓存失败", logger.Err(err))
 		}
 	}
 
-	// 选择最优token（内部方法，不加锁）
-	bestToken := tm.selectBestTokenUnlocked()
-	if bestToken == nil {
-		return nil, fmt.Errorf("没有可用的token")
+	// 选择最优token（内部方法，不加锁；没有可用token时可能排队等待）
+	bestToken, err := tm.acquireTokenUnlocked(ctx, tokenIDs)
+	if err != nil {
+		return nil, err
 	}
 
-	// 更新最后使用时间（在锁内，安全）
-	bestToken.LastUsed = time.Now()
-	available := bestToken.Available
-	if bestToken.Available > 0 {
-		bestToken.Available--
+	// 独占锁下没有并发扣减，选中的token一定可以扣减成功
+	available, _ := bestToken.take()
+	return bestToken.withUsage(available), nil
+}
+
+// selectTokenUnlocked tokenIDs 为空时使用全局顺序选择，否则只在子集中选择
+// 内部方法：调用者必须持有 tm.mutex
+func (tm *TokenManager) selectTokenUnlocked(tokenIDs []string) *CachedToken {
+	if len(tokenIDs) == 0 {
+		return tm.selectBestTokenUnlocked()
 	}
+	return tm.selectSubsetTokenUnlocked(tokenIDs)
+}
 
-	// 构造 TokenWithUsage
-	tokenWithUsage := &types.TokenWithUsage{
-		TokenInfo:       bestToken.Token,
-		UsageLimits:     bestToken.UsageInfo,
-		AvailableCount:  available, // 使用精确计算的可用次数
-		LastUsageCheck:  bestToken.LastUsed,
-		IsUsageExceeded: available <= 0,
+// selectSubsetTokenUnlocked 从当前索引开始按配置顺序查找子集中第一个可用token
+// 子集外的token不受影响：不移动当前索引，也不标记耗尽，避免干扰其他客户端密钥的粘性选择
+// 内部方法：调用者必须持有 tm.mutex
+func (tm *TokenManager) selectSubsetTokenUnlocked(tokenIDs []string) *CachedToken {
+	allowed := make(map[string]bool, len(tokenIDs))
+	for _, id := range tokenIDs {
+		allowed[id] = true
 	}
 
-	logger.Debug("返回TokenWithUsage",
-		logger.Float64("available_count", available),
-		logger.Bool("is_exceeded", tokenWithUsage.IsUsageExceeded))
+	for offset := 0; offset < len(tm.configOrder); offset++ {
+		key := tm.configOrder[(tm.currentIndex+offset)%len(tm.configOrder)]
+		if !allowed[key] {
+			continue
+		}
+		cached, exists := tm.cache.tokens[key]
+		if !exists || time.Since(cached.CachedAt) > tm.cache.ttl || tm.isCoolingDownUnlocked(key) || !cached.IsUsable() {
+			continue
+		}
+		logger.Debug("子集策略选择token",
+			logger.String("selected_key", key),
+			logger.Int("subset_size", len(tokenIDs)),
+			logger.Float64("available_count", cached.Remaining()))
+		return cached
+	}
 
-	return tokenWithUsage, nil
+	logger.Warn("token子集中没有可用token", logger.Any("token_ids", tokenIDs))
+	return nil
 }
 
 // selectBestTokenUnlocked 按配置顺序选择下一个可用token
@@ -143,10 +246,10 @@ func (tm *TokenManager) selectBestTokenUnlocked() *CachedToken {
 	// 如果没有配置顺序，降级到按map遍历顺序
 	if len(tm.configOrder) == 0 {
 		for key, cached := range tm.cache.tokens {
-			if time.Since(cached.CachedAt) <= tm.cache.ttl && cached.IsUsable() {
+			if time.Since(cached.CachedAt) <= tm.cache.ttl && cached.IsUsable() && !tm.isCoolingDownUnlocked(key) {
 				logger.Debug("顺序策略选择token（无顺序配置）",
 					logger.String("selected_key", key),
-					logger.Float64("available_count", cached.Available))
+					logger.Float64("available_count", cached.Remaining()))
 				return cached
 			}
 		}
@@ -166,12 +269,21 @@ func (tm *TokenManager) selectBestTokenUnlocked() *CachedToken {
 				continue
 			}
 
+			// 处于Retry-After冷却期的token暂时跳过（不标记为耗尽）
+			if tm.isCoolingDownUnlocked(currentKey) {
+				logger.Debug("token处于Retry-After冷却期，跳过",
+					logger.String("cooling_key", currentKey),
+					logger.String("until", tm.retryAfter[currentKey].Format(time.RFC3339)))
+				tm.currentIndex = (tm.currentIndex + 1) % len(tm.configOrder)
+				continue
+			}
+
 			// 检查token是否可用
 			if cached.IsUsable() {
 				logger.Debug("顺序策略选择token",
 					logger.String("selected_key", currentKey),
 					logger.Int("index", tm.currentIndex),
-					logger.Float64("available_count", cached.Available))
+					logger.Float64("available_count", cached.Remaining()))
 				return cached
 			}
 		}
@@ -193,13 +305,61 @@ func (tm *TokenManager) selectBestTokenUnlocked() *CachedToken {
 	return nil
 }
 
+// MarkTokenRetryAfter 记录上游返回的Retry-After
+// 冷却期内selectBestTokenUnlocked会跳过该token，直接尝试池中的下一个token
+func (tm *TokenManager) MarkTokenRetryAfter(accessToken string, d time.Duration) {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	for key, cached := range tm.cache.tokens {
+		if cached.Token.AccessToken != accessToken {
+			continue
+		}
+
+		tm.retryAfter[key] = time.Now().Add(d)
+		if len(tm.configOrder) > 0 && tm.configOrder[tm.currentIndex] == key {
+			tm.currentIndex = (tm.currentIndex + 1) % len(tm.configOrder)
+		}
+
+		if d > 0 {
+			tm.notifyTokenWaitersAfter(d)
+		}
+
+		logger.Info("token进入Retry-After冷却期",
+			logger.String("cache_key", key),
+			logger.String("retry_after", d.String()))
+		return
+	}
+}
+
+// isCoolingDownRLocked 检查token是否处于Retry-After冷却期，不清理过期记录
+// 内部方法：调用者至少持有 tm.mutex 读锁
+func (tm *TokenManager) isCoolingDownRLocked(key string) bool {
+	until, exists := tm.retryAfter[key]
+	return exists && time.Now().Before(until)
+}
+
+// isCoolingDownUnlocked 检查token是否处于Retry-After冷却期，过期记录顺带清理
+// 内部方法：调用者必须持有 tm.mutex
+func (tm *TokenManager) isCoolingDownUnlocked(key string) bool {
+	until, exists := tm.retryAfter[key]
+	if !exists {
+		return false
+	}
+	if time.Now().Before(until) {
+		return true
+	}
+	delete(tm.retryAfter, key)
+	return false
+}
+
 // refreshCacheUnlocked 刷新token缓存
 // 内部方法：调用者必须持有 tm.mutex
 func (tm *TokenManager) refreshCacheUnlocked() error {
 	logger.Debug("开始刷新token缓存")
 
 	for i, cfg := range tm.configs {
-		if cfg.Disabled {
+		if cfg.Disabled || cfg.IsDeleted() {
 			continue
 		}
 
@@ -217,7 +377,7 @@ func (tm *TokenManager) refreshCacheUnlocked() error {
 		var usageInfo *types.UsageLimits
 		var available float64
 
-		checker := NewUsageLimitsChecker()
+		checker := NewUsageLimitsChecker(cfg.Region)
 		if usage, checkErr := checker.CheckUsageLimits(token); checkErr == nil {
 			usageInfo = usage
 			available = CalculateAvailableCount(usage)
@@ -226,7 +386,7 @@ func (tm *TokenManager) refreshCacheUnlocked() error {
 		}
 
 		// 更新缓存（直接访问，已在tm.mutex保护下）
-		cacheKey := fmt.Sprintf(config.TokenCacheKeyFormat, i)
+		cacheKey := cfg.TokenID
 		tm.cache.tokens[cacheKey] = &CachedToken{
 			Token:     token,
 			UsageInfo: usageInfo,
@@ -240,24 +400,60 @@ func (tm *TokenManager) refreshCacheUnlocked() error {
 	}
 
 	tm.lastRefresh = time.Now()
+	tm.notifyTokenWaitersUnlocked()
 	return nil
 }
 
 // IsUsable 检查缓存的token是否可用
+// 过期时间以上游时钟表示，按测得的时钟偏差校正本机时间，并预留 TOKEN_EXPIRY_MARGIN 的安全余量
 func (ct *CachedToken) IsUsable() bool {
-	// 检查token是否过期
-	if time.Now().After(ct.Token.ExpiresAt) {
-		return false
+	return !ct.isExpired() && ct.Remaining() > 0
+}
+
+// isExpired 按上游时钟判断token是否已过期（含安全余量）
+func (ct *CachedToken) isExpired() bool {
+	return !tokenClock.Now().Add(config.TokenExpiryMargin()).Before(ct.Token.ExpiresAt)
+}
+
+// Remaining 返回当前剩余的可用次数
+func (ct *CachedToken) Remaining() float64 {
+	return ct.Available - float64(ct.used.Load())
+}
+
+// LastUsed 返回最后使用时间，从未使用时为零值
+func (ct *CachedToken) LastUsed() time.Time {
+	nanos := ct.lastUsed.Load()
+	if nanos == 0 {
+		return time.Time{}
 	}
+	return time.Unix(0, nanos)
+}
 
-	// 检查可用次数
-	return ct.Available > 0
+// take 剩余次数大于0时原子扣减一次并记录使用时间，返回扣减前的剩余次数
+func (ct *CachedToken) take() (float64, bool) {
+	for {
+		used := ct.used.Load()
+		remaining := ct.Available - float64(used)
+		if remaining <= 0 {
+			return remaining, false
+		}
+		if ct.used.CompareAndSwap(used, used+1) {
+			ct.lastUsed.Store(time.Now().UnixNano())
+			return remaining, true
+		}
+	}
 }
 
-// *** 已删除 set 和 updateLastUsed 方法 ***
-// SimpleTokenCache 现在是纯数据结构，所有访问由 TokenManager.mutex 保护
-// set 操作：直接通过 tm.cache.tokens[key] = value 完成
-// updateLastUsed 操作：已合并到 getBestToken 方法中
+// withUsage 构造返回给调用方的token使用信息，available 为本次扣减前的剩余次数
+func (ct *CachedToken) withUsage(available float64) *types.TokenWithUsage {
+	return &types.TokenWithUsage{
+		TokenInfo:       ct.Token,
+		UsageLimits:     ct.UsageInfo,
+		AvailableCount:  available, // 使用精确计算的可用次数
+		LastUsageCheck:  ct.LastUsed(),
+		IsUsageExceeded: available <= 0,
+	}
+}
 
 // CalculateAvailableCount 计算可用次数 (基于CREDIT资源类型，返回浮点精度)
 func CalculateAvailableCount(usage *types.UsageLimits) float64 {
@@ -284,85 +480,14 @@ func CalculateAvailableCount(usage *types.UsageLimits) float64 {
 	return 0.0
 }
 
-// ReloadConfigs 添加新的token配置（不需要重启服务）
-// 注意：这是添加配置，不是替换！原有配置会保留
-func (tm *TokenManager) ReloadConfigs(newConfigs []AuthConfig) error {
-	tm.mutex.Lock()
-	defer tm.mutex.Unlock()
-
-	oldCount := len(tm.configs)
-	
-	logger.Info("开始添加token配置",
-		logger.Int("old_count", oldCount),
-		logger.Int("add_count", len(newConfigs)))
-
-	tm.configs = append(tm.configs, newConfigs...)
-	
-	// 重新生成配置顺序
-	tm.configOrder = generateConfigOrder(tm.configs)
-	
-	// 不重置 currentIndex 和 exhausted，保持原有使用状态
-
-	logger.Info("token配置已添加到列表",
-		logger.Int("old_count", oldCount),
-		logger.Int("total_count", len(tm.configs)))
-
-	// 🔥 持久化保存配置到文件
-	if tm.storage != nil {
-		if err := tm.storage.Save(tm.configs); err != nil {
-			logger.Warn("保存配置到持久化文件失败（但内存配置已更新）",
-				logger.Err(err))
-		}
-	}
-
-	// 刷新新添加的token（只刷新新添加的部分）
-	for i := oldCount; i < len(tm.configs); i++ {
-		cfg := tm.configs[i]
-		if cfg.Disabled {
-			logger.Info("跳过禁用的token", logger.Int("index", i))
-			continue
-		}
-
-		token, err := tm.refreshSingleToken(cfg)
-		if err != nil {
-			logger.Warn("刷新新添加的token失败（但配置已保存）",
-				logger.Int("config_index", i),
-				logger.String("auth_type", cfg.AuthType),
-				logger.Err(err))
-			continue
-		}
-
-		// 检查使用限制
-		var usageInfo *types.UsageLimits
-		var available float64
-
-		checker := NewUsageLimitsChecker()
-		if usage, checkErr := checker.CheckUsageLimits(token); checkErr == nil {
-			usageInfo = usage
-			available = CalculateAvailableCount(usage)
-		} else {
-			logger.Warn("检查使用限制失败", logger.Err(checkErr))
-		}
-
-		// 添加到缓存
-		cacheKey := fmt.Sprintf(config.TokenCacheKeyFormat, i)
-		tm.cache.tokens[cacheKey] = &CachedToken{
-			Token:     token,
-			UsageInfo: usageInfo,
-			CachedAt:  time.Now(),
-			Available: available,
-		}
-
-		logger.Info("新token已添加到缓存",
-			logger.String("cache_key", cacheKey),
-			logger.Float64("available", available))
+// ReloadConfigs 追加token配置，与已有配置重复的项跳过，返回新增配置的TokenID
+// 任一配置无效则整体拒绝，避免部分写入
+func (tm *TokenManager) ReloadConfigs(newConfigs []AuthConfig) ([]string, error) {
+	report, err := tm.ImportConfigs(newConfigs, TokenImportOptions{})
+	if err != nil {
+		return nil, err
 	}
-
-	logger.Info("token配置添加完成",
-		logger.Int("total_configs", len(tm.configs)),
-		logger.Int("cached_tokens", len(tm.cache.tokens)))
-
-	return nil
+	return report.AddedIDs, nil
 }
 
 // GetCurrentConfigs 获取当前配置（用于查看）
@@ -376,13 +501,16 @@ func (tm *TokenManager) GetCurrentConfigs() []AuthConfig {
 	return configs
 }
 
-// ToggleTokenStatus 切换token的启用/停用状态
-func (tm *TokenManager) ToggleTokenStatus(index int) error {
+// ToggleTokenStatus 切换token的启用/停用状态，返回该token的TokenID
+func (tm *TokenManager) ToggleTokenStatus(index int) (string, error) {
 	tm.mutex.Lock()
 	defer tm.mutex.Unlock()
 
 	if index < 0 || index >= len(tm.configs) {
-		return fmt.Errorf("索引越界: %d", index)
+		return "", fmt.Errorf("索引越界: %d", index)
+	}
+	if tm.configs[index].IsDeleted() {
+		return "", fmt.Errorf("token已删除，请先恢复: %d", index)
 	}
 
 	// 切换状态
@@ -391,32 +519,14 @@ func (tm *TokenManager) ToggleTokenStatus(index int) error {
 	if tm.configs[index].Disabled {
 		newStatus = "已停用"
 		// 从缓存中移除
-		cacheKey := fmt.Sprintf(config.TokenCacheKeyFormat, index)
+		cacheKey := tm.configs[index].TokenID
 		delete(tm.cache.tokens, cacheKey)
 		delete(tm.exhausted, cacheKey)
+		delete(tm.retryAfter, cacheKey)
 	} else {
 		// 重新刷新这个token
-		cfg := tm.configs[index]
-		token, err := tm.refreshSingleToken(cfg)
-		if err != nil {
+		if err := tm.refreshCachedTokenUnlocked(tm.configs[index]); err != nil {
 			logger.Warn("启用token后刷新失败", logger.Err(err))
-		} else {
-			// 添加到缓存
-			var usageInfo *types.UsageLimits
-			var available float64
-			checker := NewUsageLimitsChecker()
-			if usage, checkErr := checker.CheckUsageLimits(token); checkErr == nil {
-				usageInfo = usage
-				available = CalculateAvailableCount(usage)
-			}
-			
-			cacheKey := fmt.Sprintf(config.TokenCacheKeyFormat, index)
-			tm.cache.tokens[cacheKey] = &CachedToken{
-				Token:     token,
-				UsageInfo: usageInfo,
-				CachedAt:  time.Now(),
-				Available: available,
-			}
 		}
 	}
 
@@ -424,53 +534,179 @@ func (tm *TokenManager) ToggleTokenStatus(index int) error {
 		logger.Int("index", index),
 		logger.String("status", newStatus))
 
+	return tm.configs[index].TokenID, nil
+}
+
+// refreshCachedTokenUnlocked 刷新单个token并写入缓存，用于重新启用或恢复token
+// 内部方法：调用者必须持有 tm.mutex
+func (tm *TokenManager) refreshCachedTokenUnlocked(cfg AuthConfig) error {
+	token, err := tm.refreshSingleToken(cfg)
+	if err != nil {
+		return err
+	}
+
+	var usageInfo *types.UsageLimits
+	var available float64
+	checker := NewUsageLimitsChecker(cfg.Region)
+	if usage, checkErr := checker.CheckUsageLimits(token); checkErr == nil {
+		usageInfo = usage
+		available = CalculateAvailableCount(usage)
+	}
+
+	tm.cache.tokens[cfg.TokenID] = &CachedToken{
+		Token:     token,
+		UsageInfo: usageInfo,
+		CachedAt:  time.Now(),
+		Available: available,
+	}
+	tm.notifyTokenWaitersUnlocked()
 	return nil
 }
 
-// RemoveToken 删除指定索引的token
-func (tm *TokenManager) RemoveToken(index int) error {
+// RemoveToken 软删除指定索引的token，返回其TokenID
+// 配置保留在数组中（索引不变），只记录删除时间并移出选择顺序；可通过 RestoreToken 撤销
+func (tm *TokenManager) RemoveToken(index int) (string, error) {
 	tm.mutex.Lock()
 	defer tm.mutex.Unlock()
 
 	if index < 0 || index >= len(tm.configs) {
-		return fmt.Errorf("索引越界: %d", index)
+		return "", fmt.Errorf("索引越界: %d", index)
+	}
+	if tm.configs[index].IsDeleted() {
+		return "", fmt.Errorf("token已删除: %d", index)
 	}
 
-	logger.Info("删除token配置",
+	tokenID := tm.configs[index].TokenID
+	tm.softDeleteUnlocked(index, time.Now())
+	tm.rebuildConfigOrderUnlocked()
+
+	// 持久化删除标记，避免重启后被删除的token重新参与选择
+	tm.persistUnlocked()
+
+	logger.Info("token已软删除",
 		logger.Int("index", index),
-		logger.Int("total_before", len(tm.configs)))
+		logger.String("token_id", tokenID),
+		logger.Int("cached_tokens", len(tm.cache.tokens)))
 
-	// 删除配置
-	tm.configs = append(tm.configs[:index], tm.configs[index+1:]...)
-	
-	// 重新生成配置顺序
-	tm.configOrder = generateConfigOrder(tm.configs)
-	
-	// 清空缓存，重新刷新（因为索引变了）
-	tm.cache.tokens = make(map[string]*CachedToken)
-	tm.exhausted = make(map[string]bool)
-	tm.currentIndex = 0
-	
-	// 重新刷新所有token
-	if err := tm.refreshCacheUnlocked(); err != nil {
-		logger.Warn("删除token后重新刷新失败", logger.Err(err))
+	return tokenID, nil
+}
+
+// RestoreToken 撤销指定TokenID的软删除，恢复后重新参与选择
+func (tm *TokenManager) RestoreToken(tokenID string) error {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	index := tm.indexOfUnlocked(tokenID)
+	if index < 0 {
+		return fmt.Errorf("token不存在: %s", tokenID)
+	}
+	if !tm.configs[index].IsDeleted() {
+		return fmt.Errorf("token未被删除: %s", tokenID)
 	}
 
-	logger.Info("token已删除",
-		logger.Int("total_after", len(tm.configs)),
-		logger.Int("cached_tokens", len(tm.cache.tokens)))
+	tm.configs[index].DeletedAt = nil
+	tm.rebuildConfigOrderUnlocked()
+	tm.persistUnlocked()
+
+	if !tm.configs[index].Disabled {
+		if err := tm.refreshCachedTokenUnlocked(tm.configs[index]); err != nil {
+			logger.Warn("恢复token后刷新失败（但配置已恢复）", logger.Err(err))
+		}
+	}
+
+	logger.Info("token已恢复",
+		logger.Int("index", index),
+		logger.String("token_id", tokenID))
 
 	return nil
 }
 
-// generateConfigOrder 生成token配置的顺序
+// PurgeDeletedTokens 永久移除软删除时间早于 olderThan 之前的配置，返回被移除的TokenID
+func (tm *TokenManager) PurgeDeletedTokens(olderThan time.Duration) ([]string, error) {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	cutoff := time.Now().Add(-olderThan)
+	var kept []AuthConfig
+	var purged []string
+	for _, cfg := range tm.configs {
+		if cfg.IsDeleted() && !cfg.DeletedAt.After(cutoff) {
+			purged = append(purged, cfg.TokenID)
+			continue
+		}
+		kept = append(kept, cfg)
+	}
+
+	if len(purged) == 0 {
+		return nil, nil
+	}
+
+	// 软删除的token不在选择顺序中，清除后顺序不变
+	tm.configs = kept
+	tm.persistUnlocked()
+
+	logger.Info("已清除软删除的token",
+		logger.Int("purged", len(purged)),
+		logger.Int("remaining", len(tm.configs)))
+
+	return purged, nil
+}
+
+// softDeleteUnlocked 标记配置为已删除并移除其缓存和选择状态
+// 内部方法：调用者必须持有 tm.mutex
+func (tm *TokenManager) softDeleteUnlocked(index int, now time.Time) {
+	deletedAt := now
+	tm.configs[index].DeletedAt = &deletedAt
+
+	tokenID := tm.configs[index].TokenID
+	delete(tm.cache.tokens, tokenID)
+	delete(tm.exhausted, tokenID)
+	delete(tm.retryAfter, tokenID)
+}
+
+// rebuildConfigOrderUnlocked 重新生成配置顺序，currentIndex 尽量继续指向同一个token
+// 当前token已被移出顺序时停在原位置，即顺延到下一个token
+// 内部方法：调用者必须持有 tm.mutex
+func (tm *TokenManager) rebuildConfigOrderUnlocked() {
+	var currentID string
+	if tm.currentIndex < len(tm.configOrder) {
+		currentID = tm.configOrder[tm.currentIndex]
+	}
+
+	tm.configOrder = generateConfigOrder(tm.configs)
+
+	for i, id := range tm.configOrder {
+		if id == currentID {
+			tm.currentIndex = i
+			return
+		}
+	}
+	if tm.currentIndex >= len(tm.configOrder) {
+		tm.currentIndex = 0
+	}
+}
+
+// indexOfUnlocked 返回TokenID对应的配置索引，不存在时返回-1
+// 内部方法：调用者必须持有 tm.mutex
+func (tm *TokenManager) indexOfUnlocked(tokenID string) int {
+	for i, cfg := range tm.configs {
+		if cfg.TokenID == tokenID {
+			return i
+		}
+	}
+	return -1
+}
+
+// generateConfigOrder 生成token配置的顺序，跳过已软删除的配置
+// 以TokenID作为cache key，与refreshCache中的逻辑保持一致；删除或重排配置不会让key指向别的token
 func generateConfigOrder(configs []AuthConfig) []string {
 	var order []string
 
-	for i := range configs {
-		// 使用索引生成cache key，与refreshCache中的逻辑保持一致
-		cacheKey := fmt.Sprintf(config.TokenCacheKeyFormat, i)
-		order = append(order, cacheKey)
+	for _, cfg := range configs {
+		if cfg.IsDeleted() {
+			continue
+		}
+		order = append(order, cfg.TokenID)
 	}
 
 	logger.Debug("生成配置顺序",
@@ -480,6 +716,18 @@ func generateConfigOrder(configs []AuthConfig) []string {
 	return order
 }
 
+// persistUnlocked 保存当前配置到持久化文件，失败只记录日志
+// 内部方法：调用者必须持有 tm.mutex
+func (tm *TokenManager) persistUnlocked() {
+	if tm.storage == nil {
+		return
+	}
+	if err := tm.storage.Save(tm.configs); err != nil {
+		logger.Warn("保存配置到持久化文件失败（但内存配置已更新）",
+			logger.Err(err))
+	}
+}
+
 // RefreshAllTokens 刷新所有token的用量信息
 func (tm *TokenManager) RefreshAllTokens() (int, error) {
 	tm.mutex.Lock()
@@ -501,33 +749,36 @@ func (tm *TokenManager) RefreshAllTokens() (int, error) {
 	return refreshedCount, nil
 }
 
-// CleanupInvalidTokens 清理失效token（过期或已耗尽）
-func (tm *TokenManager) CleanupInvalidTokens() (int, error) {
+// CleanupInvalidTokens 软删除失效token（过期或已耗尽），返回被删除的TokenID
+// 暂时被限流的token也可能被判定为耗尽，因此只做软删除，可通过 RestoreToken 撤销
+func (tm *TokenManager) CleanupInvalidTokens() ([]string, error) {
 	tm.mutex.Lock()
 	defer tm.mutex.Unlock()
 
 	logger.Info("开始清理失效token", logger.Int("total", len(tm.configs)))
 
 	now := time.Now()
-	var validConfigs []AuthConfig
-	removedCount := 0
+	var removedIDs []string
 
 	for i, cfg := range tm.configs {
-		cacheKey := fmt.Sprintf(config.TokenCacheKeyFormat, i)
-		cached, exists := tm.cache.tokens[cacheKey]
+		if cfg.IsDeleted() {
+			continue
+		}
+
+		cached, exists := tm.cache.tokens[cfg.TokenID]
 
 		shouldRemove := false
 		reason := ""
 
 		if exists && cached != nil {
-			// 检查是否过期
-			if cached.Token.ExpiresAt.Before(now) {
+			// 检查是否过期（过期时间以上游时钟表示）
+			if cached.Token.ExpiresAt.Before(tokenClock.Now()) {
 				shouldRemove = true
 				reason = "已过期"
 			}
 
 			// 检查是否已耗尽
-			if cached.Available <= 0 {
+			if cached.Remaining() <= 0 {
 				shouldRemove = true
 				reason = "已耗尽"
 			}
@@ -536,32 +787,35 @@ func (tm *TokenManager) CleanupInvalidTokens() (int, error) {
 		if shouldRemove {
 			logger.Info("清理失效token",
 				logger.Int("index", i),
+				logger.String("token_id", cfg.TokenID),
 				logger.String("reason", reason))
-			removedCount++
-		} else {
-			validConfigs = append(validConfigs, cfg)
+			tm.softDeleteUnlocked(i, now)
+			removedIDs = append(removedIDs, cfg.TokenID)
 		}
 	}
 
-	// 更新配置
-	tm.configs = validConfigs
+	// 更新配置顺序
 	tm.configOrder = generateConfigOrder(tm.configs)
+	if len(removedIDs) > 0 {
+		tm.persistUnlocked()
+	}
 
 	// 清空缓存，重新刷新
 	tm.cache.tokens = make(map[string]*CachedToken)
 	tm.exhausted = make(map[string]bool)
+	tm.retryAfter = make(map[string]time.Time)
 	tm.currentIndex = 0
 
 	// 重新刷新所有token
-	if len(tm.configs) > 0 {
+	if len(tm.configOrder) > 0 {
 		if err := tm.refreshCacheUnlocked(); err != nil {
 			logger.Warn("清理后重新刷新失败", logger.Err(err))
 		}
 	}
 
 	logger.Info("token清理完成",
-		logger.Int("removed", removedCount),
-		logger.Int("remaining", len(tm.configs)))
+		logger.Int("removed", len(removedIDs)),
+		logger.Int("remaining", len(tm.configOrder)))
 
-	return removedCount, nil
+	return removedIDs, nil
 }
@@ -2,11 +2,14 @@ package auth
 
 import (
 	"fmt"
-	"kiro2api/config"
 	"kiro2api/types"
+	"math"
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // TestTokenManager_ConcurrentAccess 测试TokenManager的并发访问安全性
@@ -33,7 +36,7 @@ func TestTokenManager_ConcurrentAccess(t *testing.T) {
 	// 预填充缓存（模拟已刷新的token）
 	tm.mutex.Lock()
 	for i := range configs {
-		cacheKey := fmt.Sprintf(config.TokenCacheKeyFormat, i)
+		cacheKey := tm.configs[i].TokenID
 		tm.cache.tokens[cacheKey] = &CachedToken{
 			Token: types.TokenInfo{
 				AccessToken: fmt.Sprintf("access_token_%d", i),
@@ -109,7 +112,7 @@ func TestTokenManager_ConcurrentRefresh(t *testing.T) {
 
 	// 预填充缓存
 	tm.mutex.Lock()
-	tm.cache.tokens["token_0"] = &CachedToken{
+	tm.cache.tokens[tm.configs[0].TokenID] = &CachedToken{
 		Token: types.TokenInfo{
 			AccessToken: "access_token_0",
 			ExpiresAt:   time.Now().Add(1 * time.Hour),
@@ -157,7 +160,7 @@ func TestTokenManager_RaceCondition(t *testing.T) {
 	// 预填充缓存
 	tm.mutex.Lock()
 	for i := range configs {
-		tm.cache.tokens[fmt.Sprintf(config.TokenCacheKeyFormat, i)] = &CachedToken{
+		tm.cache.tokens[tm.configs[i].TokenID] = &CachedToken{
 			Token: types.TokenInfo{
 				AccessToken: fmt.Sprintf("access_%d", i),
 				ExpiresAt:   time.Now().Add(1 * time.Hour),
@@ -201,7 +204,7 @@ func TestTokenManager_SequentialSelection(t *testing.T) {
 	// 预填充缓存 - 每个token只有少量可用次数
 	tm.mutex.Lock()
 	for i := range configs {
-		tm.cache.tokens[fmt.Sprintf(config.TokenCacheKeyFormat, i)] = &CachedToken{
+		tm.cache.tokens[tm.configs[i].TokenID] = &CachedToken{
 			Token: types.TokenInfo{
 				AccessToken: fmt.Sprintf("access_%d", i),
 				ExpiresAt:   time.Now().Add(1 * time.Hour),
@@ -243,3 +246,209 @@ func TestTokenManager_SequentialSelection(t *testing.T) {
 
 	t.Logf("✅ 顺序选择策略验证通过：粘性策略正确工作")
 }
+
+// TestTokenManager_RetryAfterCooldown 测试被Retry-After标记的token在冷却期内被跳过
+func TestTokenManager_RetryAfterCooldown(t *testing.T) {
+	configs := []AuthConfig{
+		{AuthType: AuthMethodSocial, RefreshToken: "token1"},
+		{AuthType: AuthMethodSocial, RefreshToken: "token2"},
+	}
+
+	tm := NewTokenManager(configs)
+
+	tm.mutex.Lock()
+	for i := range configs {
+		tm.cache.tokens[tm.configs[i].TokenID] = &CachedToken{
+			Token: types.TokenInfo{
+				AccessToken: fmt.Sprintf("access_%d", i),
+				ExpiresAt:   time.Now().Add(1 * time.Hour),
+			},
+			CachedAt:  time.Now(),
+			Available: 100.0,
+		}
+	}
+	tm.lastRefresh = time.Now()
+	tm.mutex.Unlock()
+
+	token, err := tm.getBestToken()
+	if err != nil || token.AccessToken != "access_0" {
+		t.Fatalf("期望首先选择access_0，实际: %s, err: %v", token.AccessToken, err)
+	}
+
+	// 长冷却期：冷却期内应直接使用下一个token
+	tm.MarkTokenRetryAfter("access_0", time.Hour)
+	for i := 0; i < 3; i++ {
+		token, err = tm.getBestToken()
+		if err != nil || token.AccessToken != "access_1" {
+			t.Fatalf("冷却期内期望选择access_1，实际: %s, err: %v", token.AccessToken, err)
+		}
+	}
+
+	// 所有token都在冷却期时应返回错误
+	tm.MarkTokenRetryAfter("access_1", time.Hour)
+	if _, err := tm.getBestToken(); err == nil {
+		t.Fatalf("所有token冷却时期望返回错误")
+	}
+
+	// 冷却期结束后token恢复可用
+	tm.MarkTokenRetryAfter("access_0", -time.Second)
+	token, err = tm.getBestToken()
+	if err != nil || token.AccessToken != "access_0" {
+		t.Fatalf("冷却结束后期望恢复access_0，实际: %s, err: %v", token.AccessToken, err)
+	}
+}
+
+// TestTokenManager_SubsetSelection 测试客户端密钥绑定的token子集只在子集内选择，且不影响全局顺序
+func TestTokenManager_SubsetSelection(t *testing.T) {
+	configs := []AuthConfig{
+		{AuthType: AuthMethodSocial, RefreshToken: "token1"},
+		{AuthType: AuthMethodSocial, RefreshToken: "token2"},
+		{AuthType: AuthMethodSocial, RefreshToken: "token3"},
+	}
+
+	tm := NewTokenManager(configs)
+
+	tm.mutex.Lock()
+	for i := range configs {
+		tm.cache.tokens[tm.configs[i].TokenID] = &CachedToken{
+			Token: types.TokenInfo{
+				AccessToken: fmt.Sprintf("access_%d", i),
+				ExpiresAt:   time.Now().Add(1 * time.Hour),
+			},
+			CachedAt:  time.Now(),
+			Available: 2.0,
+		}
+	}
+	tm.lastRefresh = time.Now()
+	tm.mutex.Unlock()
+
+	subset := []string{tm.configs[1].TokenID, tm.configs[2].TokenID}
+	var selected []string
+	for i := 0; i < 4; i++ {
+		token, err := tm.getBestTokenFrom(subset)
+		if err != nil {
+			t.Fatalf("第%d次子集选择失败: %v", i+1, err)
+		}
+		selected = append(selected, token.AccessToken)
+	}
+	if fmt.Sprint(selected) != "[access_1 access_1 access_2 access_2]" {
+		t.Errorf("子集选择顺序不符合预期: %v", selected)
+	}
+
+	// 子集耗尽后返回错误
+	if _, err := tm.getBestTokenFrom(subset); err == nil {
+		t.Fatalf("子集耗尽时期望返回错误")
+	}
+
+	// 全局选择仍从子集外的token开始
+	withUsage, err := tm.GetBestTokenWithUsageFrom(nil)
+	if err != nil || withUsage.AccessToken != "access_0" {
+		t.Fatalf("期望全局选择access_0，实际: %+v, err: %v", withUsage, err)
+	}
+
+	// 未知的TokenID没有可用token
+	if _, err := tm.getBestTokenFrom([]string{"missing"}); err == nil {
+		t.Fatalf("未知TokenID期望返回错误")
+	}
+}
+
+// seedTokenCache 按配置顺序预填充缓存，available[i] 为第i个token的可用次数
+func seedTokenCache(tm *TokenManager, available ...float64) {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+	for i, count := range available {
+		tm.cache.tokens[tm.configs[i].TokenID] = &CachedToken{
+			Token: types.TokenInfo{
+				AccessToken: fmt.Sprintf("access_%d", i),
+				ExpiresAt:   time.Now().Add(1 * time.Hour),
+			},
+			CachedAt:  time.Now(),
+			Available: count,
+		}
+	}
+	tm.lastRefresh = time.Now()
+}
+
+// TestTokenManager_DeterministicSequence 读锁快速路径不改变顺序选择与耗尽标记
+func TestTokenManager_DeterministicSequence(t *testing.T) {
+	tm := NewTokenManager([]AuthConfig{
+		{AuthType: AuthMethodSocial, RefreshToken: "token1"},
+		{AuthType: AuthMethodSocial, RefreshToken: "token2"},
+		{AuthType: AuthMethodSocial, RefreshToken: "token3"},
+	})
+	seedTokenCache(tm, 2, 1, 2.5)
+
+	var selected []string
+	var available []float64
+	for i := 0; i < 6; i++ {
+		withUsage, err := tm.GetBestTokenWithUsage()
+		require.NoError(t, err, "第%d次选择", i+1)
+		selected = append(selected, withUsage.AccessToken)
+		available = append(available, withUsage.AvailableCount)
+		assert.False(t, withUsage.LastUsageCheck.IsZero())
+	}
+
+	assert.Equal(t, []string{"access_0", "access_0", "access_1", "access_2", "access_2", "access_2"}, selected)
+	assert.Equal(t, []float64{2, 1, 1, 2.5, 1.5, 0.5}, available)
+
+	_, err := tm.GetBestTokenWithUsage()
+	require.Error(t, err, "所有token耗尽后期望返回错误")
+
+	tm.mutex.RLock()
+	defer tm.mutex.RUnlock()
+	assert.Len(t, tm.exhausted, 3)
+	for _, cfg := range tm.configs {
+		assert.True(t, tm.exhausted[cfg.TokenID])
+		assert.LessOrEqual(t, tm.cache.tokens[cfg.TokenID].Remaining(), 0.0)
+	}
+}
+
+// TestTokenManager_ParallelConsumptionExact 并发获取token时扣减次数不多不少
+func TestTokenManager_ParallelConsumptionExact(t *testing.T) {
+	tm := NewTokenManager([]AuthConfig{
+		{AuthType: AuthMethodSocial, RefreshToken: "token1"},
+		{AuthType: AuthMethodSocial, RefreshToken: "token2"},
+	})
+	seedTokenCache(tm, 100, 50)
+
+	var mu sync.Mutex
+	counts := make(map[string]int)
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				token, err := tm.getBestToken()
+				if err != nil {
+					continue
+				}
+				mu.Lock()
+				counts[token.AccessToken]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, map[string]int{"access_0": 100, "access_1": 50}, counts)
+}
+
+// BenchmarkTokenManager_GetBestTokenParallel 高并发下获取token的开销（主要衡量锁竞争）
+func BenchmarkTokenManager_GetBestTokenParallel(b *testing.B) {
+	tm := NewTokenManager([]AuthConfig{
+		{AuthType: AuthMethodSocial, RefreshToken: "token1"},
+		{AuthType: AuthMethodSocial, RefreshToken: "token2"},
+	})
+	seedTokenCache(tm, math.MaxInt32, math.MaxInt32)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := tm.GetBestTokenWithUsage(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
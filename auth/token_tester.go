@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"fmt"
+	"time"
+)
+
+// TokenTestReport 单个token的即时检测结果
+type TokenTestReport struct {
+	Index            int     `json:"index"`
+	AuthType         string  `json:"auth_type"`
+	Disabled         bool    `json:"disabled"`
+	Valid            bool    `json:"valid"`
+	AvailableCredits float64 `json:"available_credits"`
+	ExpiresAt        string  `json:"expires_at,omitempty"`
+	Error            string  `json:"error,omitempty"`
+}
+
+// TestToken 立即刷新指定索引的token并检查使用限制，用于排查单个token是否可用
+// 检测结果不写入token池缓存，也不影响耗尽/冷却状态；只有索引越界时返回错误
+func (tm *TokenManager) TestToken(index int) (*TokenTestReport, error) {
+	tm.mutex.RLock()
+	if index < 0 || index >= len(tm.configs) {
+		tm.mutex.RUnlock()
+		return nil, fmt.Errorf("索引越界: %d", index)
+	}
+	cfg := tm.configs[index]
+	tm.mutex.RUnlock()
+
+	report := &TokenTestReport{
+		Index:    index,
+		AuthType: cfg.AuthType,
+		Disabled: cfg.Disabled,
+	}
+
+	// 网络请求在锁外执行，避免阻塞正常的token选择
+	token, err := tm.refreshSingleToken(cfg)
+	if err != nil {
+		report.Error = err.Error()
+		return report, nil
+	}
+	report.ExpiresAt = token.ExpiresAt.Format(time.RFC3339)
+
+	usage, err := NewUsageLimitsChecker(cfg.Region).CheckUsageLimits(token)
+	if err != nil {
+		report.Error = err.Error()
+		return report, nil
+	}
+
+	report.Valid = true
+	report.AvailableCredits = CalculateAvailableCount(usage)
+	return report, nil
+}
@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"kiro2api/types"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newMockAuthServer 模拟刷新和使用限制接口，并替换对应端点
+func newMockAuthServer(t *testing.T, refreshStatus int, available float64) {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/refreshToken", func(w http.ResponseWriter, r *http.Request) {
+		if refreshStatus != http.StatusOK {
+			w.WriteHeader(refreshStatus)
+			_, _ = w.Write([]byte(`{"message":"invalid refresh token"}`))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(types.RefreshResponse{
+			AccessToken: "mock-access-token-0123456789",
+			ExpiresIn:   3600,
+		})
+	})
+	mux.HandleFunc("/getUsageLimits", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer mock-access-token-0123456789", r.Header.Get("Authorization"))
+		_ = json.NewEncoder(w).Encode(types.UsageLimits{
+			UsageBreakdownList: []types.UsageBreakdown{{
+				ResourceType:            "CREDIT",
+				UsageLimitWithPrecision: available,
+			}},
+		})
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	origRefresh, origUsage := refreshTokenURL, usageLimitsURL
+	refreshTokenURL = server.URL + "/refreshToken"
+	usageLimitsURL = server.URL + "/getUsageLimits"
+	t.Cleanup(func() {
+		refreshTokenURL, usageLimitsURL = origRefresh, origUsage
+	})
+}
+
+func TestTokenManager_TestToken(t *testing.T) {
+	t.Run("token有效", func(t *testing.T) {
+		newMockAuthServer(t, http.StatusOK, 42.5)
+		tm := NewTokenManager([]AuthConfig{{AuthType: AuthMethodSocial, RefreshToken: "refresh-ok"}})
+
+		report, err := tm.TestToken(0)
+		require.NoError(t, err)
+		assert.True(t, report.Valid)
+		assert.Equal(t, 42.5, report.AvailableCredits)
+		assert.NotEmpty(t, report.ExpiresAt)
+		assert.Empty(t, report.Error)
+
+		// 检测结果不写入token池缓存
+		assert.Empty(t, tm.cache.tokens)
+	})
+
+	t.Run("刷新失败", func(t *testing.T) {
+		newMockAuthServer(t, http.StatusUnauthorized, 0)
+		tm := NewTokenManager([]AuthConfig{{AuthType: AuthMethodSocial, RefreshToken: "refresh-bad"}})
+
+		report, err := tm.TestToken(0)
+		require.NoError(t, err)
+		assert.False(t, report.Valid)
+		assert.Contains(t, report.Error, "状态码 401")
+		assert.Empty(t, report.ExpiresAt)
+	})
+
+	t.Run("索引越界", func(t *testing.T) {
+		tm := NewTokenManager([]AuthConfig{{AuthType: AuthMethodSocial, RefreshToken: "refresh-ok"}})
+
+		_, err := tm.TestToken(3)
+		assert.Error(t, err)
+	})
+}
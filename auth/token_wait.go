@@ -0,0 +1,226 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"kiro2api/config"
+	"kiro2api/logger"
+)
+
+// ErrTokenWaitQueueFull 没有可用token且等待队列已满，调用方应返回429
+var ErrTokenWaitQueueFull = errors.New("没有可用的token，等待队列已满")
+
+// TokenWaitStats 没有可用token时请求排队等待的统计
+type TokenWaitStats struct {
+	TimeoutMs     int64   `json:"timeout_ms"`      // 当前配置的最长等待时间，0表示不等待
+	QueueCapacity int     `json:"queue_capacity"`  // 队列容量
+	QueueDepth    int     `json:"queue_depth"`     // 当前排队的请求数
+	MaxQueueDepth int     `json:"max_queue_depth"` // 启动以来的最大排队数
+	Served        int64   `json:"served"`          // 等待后获得token的请求数
+	TimedOut      int64   `json:"timed_out"`       // 等待超时的请求数
+	Canceled      int64   `json:"canceled"`        // 等待期间客户端断开或请求被取消的数量
+	Rejected      int64   `json:"rejected"`        // 队列已满被拒绝的请求数
+	P50Ms         float64 `json:"p50_ms"`          // 最近等待时间分位数（含超时）
+	P95Ms         float64 `json:"p95_ms"`
+	P99Ms         float64 `json:"p99_ms"`
+}
+
+// tokenWaitQueue 等待可用token的FIFO队列
+// 所有字段由 TokenManager.mutex 保护；每个等待者有自己的唤醒通道，等待时不持有锁
+type tokenWaitQueue struct {
+	waiters  []*tokenWaiter // 按到达顺序排列
+	maxDepth int
+
+	served   int64
+	timedOut int64
+	canceled int64
+	rejected int64
+
+	samples    []time.Duration // 最近的等待时间（环形缓冲）
+	sampleNext int
+}
+
+// tokenWaiter 排队中的请求，ready 容量为1，重复唤醒不会阻塞
+type tokenWaiter struct {
+	ready chan struct{}
+}
+
+func newTokenWaitQueue() *tokenWaitQueue {
+	return &tokenWaitQueue{}
+}
+
+func (q *tokenWaitQueue) enqueue() *tokenWaiter {
+	w := &tokenWaiter{ready: make(chan struct{}, 1)}
+	q.waiters = append(q.waiters, w)
+	if len(q.waiters) > q.maxDepth {
+		q.maxDepth = len(q.waiters)
+	}
+	return w
+}
+
+func (q *tokenWaitQueue) remove(w *tokenWaiter) {
+	for i, waiter := range q.waiters {
+		if waiter == w {
+			q.waiters = append(q.waiters[:i], q.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+func (q *tokenWaitQueue) isHead(w *tokenWaiter) bool {
+	return len(q.waiters) > 0 && q.waiters[0] == w
+}
+
+func (q *tokenWaitQueue) recordWait(d time.Duration) {
+	if len(q.samples) < config.TokenWaitSampleSize {
+		q.samples = append(q.samples, d)
+		return
+	}
+	q.samples[q.sampleNext] = d
+	q.sampleNext = (q.sampleNext + 1) % len(q.samples)
+}
+
+// notifyTokenWaitersUnlocked token可用性可能发生变化（缓存刷新、配置变更、冷却结束）时唤醒队首请求
+// 只有队首可以取得token，其余请求在队首离开时依次被唤醒
+// 内部方法：调用者必须持有 tm.mutex
+func (tm *TokenManager) notifyTokenWaitersUnlocked() {
+	if len(tm.wait.waiters) == 0 {
+		return
+	}
+	select {
+	case tm.wait.waiters[0].ready <- struct{}{}:
+	default:
+	}
+}
+
+// notifyTokenWaitersAfter 在 d 之后唤醒排队的请求（用于Retry-After冷却结束）
+func (tm *TokenManager) notifyTokenWaitersAfter(d time.Duration) {
+	time.AfterFunc(d, func() {
+		tm.mutex.Lock()
+		defer tm.mutex.Unlock()
+		tm.notifyTokenWaitersUnlocked()
+	})
+}
+
+// acquireTokenUnlocked 选择可用token
+// 没有可用token且配置了 TOKEN_WAIT_TIMEOUT 时排队等待，按到达顺序服务；已有请求排队时新请求不插队
+// 队首请求在缓存过期（TokenCacheTTL）时自行刷新缓存，不依赖其他请求触发刷新；ctx 结束时立即放弃等待
+// 内部方法：调用者必须持有 tm.mutex，等待期间会释放锁
+func (tm *TokenManager) acquireTokenUnlocked(ctx context.Context, tokenIDs []string) (*CachedToken, error) {
+	if len(tm.wait.waiters) == 0 {
+		if cached := tm.selectTokenUnlocked(tokenIDs); cached != nil {
+			return cached, nil
+		}
+	}
+
+	timeout := config.TokenWaitTimeout()
+	if timeout <= 0 {
+		return nil, fmt.Errorf("没有可用的token")
+	}
+	if capacity := config.TokenWaitQueueSize(); len(tm.wait.waiters) >= capacity {
+		tm.wait.rejected++
+		logger.Warn("等待可用token的请求过多，拒绝请求", logger.Int("queue_capacity", capacity))
+		return nil, ErrTokenWaitQueueFull
+	}
+
+	waiter := tm.wait.enqueue()
+	start := time.Now()
+	deadline := start.Add(timeout)
+	defer func() {
+		tm.wait.remove(waiter)
+		tm.wait.recordWait(time.Since(start))
+		// 队首变化，让下一个等待者重新检查
+		tm.notifyTokenWaitersUnlocked()
+	}()
+
+	logger.Debug("没有可用token，排队等待",
+		logger.Int("queue_depth", len(tm.wait.waiters)),
+		logger.Duration("timeout", timeout))
+
+	for {
+		head := tm.wait.isHead(waiter)
+		if head {
+			if time.Since(tm.lastRefresh) > config.Get().TokenCacheTTL {
+				if err := tm.refreshCacheUnlocked(); err != nil {
+					logger.Warn("排队期间刷新token缓存失败", logger.Err(err))
+				}
+			}
+			if cached := tm.selectTokenUnlocked(tokenIDs); cached != nil {
+				tm.wait.served++
+				logger.Debug("排队等待后获得token", logger.Duration("waited", time.Since(start)))
+				return cached, nil
+			}
+		}
+
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			tm.wait.timedOut++
+			logger.Warn("等待可用token超时", logger.Duration("timeout", timeout))
+			return nil, fmt.Errorf("没有可用的token（等待 %s 后超时）", timeout)
+		}
+		if head {
+			if untilRefresh := time.Until(tm.lastRefresh.Add(config.Get().TokenCacheTTL)); untilRefresh < wait {
+				wait = max(untilRefresh, config.TokenWaitMinRefreshInterval)
+			}
+		}
+
+		tm.mutex.Unlock()
+		timer := time.NewTimer(wait)
+		select {
+		case <-waiter.ready:
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+		timer.Stop()
+		tm.mutex.Lock()
+
+		if err := ctx.Err(); err != nil {
+			tm.wait.canceled++
+			return nil, fmt.Errorf("等待可用token时请求已取消: %w", err)
+		}
+	}
+}
+
+// WaitStats 返回排队等待token的统计
+func (tm *TokenManager) WaitStats() TokenWaitStats {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	stats := TokenWaitStats{
+		TimeoutMs:     config.TokenWaitTimeout().Milliseconds(),
+		QueueCapacity: config.TokenWaitQueueSize(),
+		QueueDepth:    len(tm.wait.waiters),
+		MaxQueueDepth: tm.wait.maxDepth,
+		Served:        tm.wait.served,
+		TimedOut:      tm.wait.timedOut,
+		Canceled:      tm.wait.canceled,
+		Rejected:      tm.wait.rejected,
+	}
+
+	sorted := append([]time.Duration(nil), tm.wait.samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	stats.P50Ms = waitPercentileMs(sorted, 0.50)
+	stats.P95Ms = waitPercentileMs(sorted, 0.95)
+	stats.P99Ms = waitPercentileMs(sorted, 0.99)
+	return stats
+}
+
+// waitPercentileMs 按最近秩法计算已排序样本的分位数（毫秒）
+func waitPercentileMs(sorted []time.Duration, q float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	index := int(math.Ceil(q*float64(len(sorted)))) - 1
+	if index < 0 {
+		index = 0
+	}
+	if index >= len(sorted) {
+		index = len(sorted) - 1
+	}
+	return float64(sorted[index]) / float64(time.Millisecond)
+}
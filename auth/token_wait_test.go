@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"kiro2api/config"
+	"kiro2api/types"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newExhaustedTokenManager 创建只有一个已耗尽token的管理器，缓存视为刚刚刷新
+func newExhaustedTokenManager(t *testing.T) *TokenManager {
+	t.Helper()
+	tm := NewTokenManager([]AuthConfig{{AuthType: AuthMethodSocial, RefreshToken: "refresh-ok"}})
+
+	tm.mutex.Lock()
+	tm.cache.tokens[tm.configs[0].TokenID] = &CachedToken{
+		Token:     types.TokenInfo{AccessToken: "exhausted", ExpiresAt: time.Now().Add(time.Hour)},
+		CachedAt:  time.Now(),
+		Available: 0,
+	}
+	tm.lastRefresh = time.Now()
+	tm.mutex.Unlock()
+	return tm
+}
+
+func TestTokenManager_NoWaitByDefault(t *testing.T) {
+	t.Setenv("TOKEN_WAIT_TIMEOUT", "")
+	tm := newExhaustedTokenManager(t)
+
+	start := time.Now()
+	_, err := tm.getBestToken()
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrTokenWaitQueueFull)
+	assert.Less(t, time.Since(start), 100*time.Millisecond, "未配置等待时立即失败")
+	assert.Zero(t, tm.WaitStats().MaxQueueDepth)
+}
+
+func TestTokenManager_QueuedRequestsServedAfterRefresh(t *testing.T) {
+	newMockAuthServer(t, http.StatusOK, 10)
+	t.Setenv("TOKEN_WAIT_TIMEOUT", "5s")
+	t.Setenv("TOKEN_WAIT_QUEUE_SIZE", "3")
+	tm := newExhaustedTokenManager(t)
+	// 缓存在200ms后过期，由排队的队首请求自行刷新
+	tm.mutex.Lock()
+	tm.lastRefresh = time.Now().Add(-config.Get().TokenCacheTTL + 200*time.Millisecond)
+	tm.mutex.Unlock()
+
+	var wg sync.WaitGroup
+	errs := make([]error, 3)
+	tokens := make([]string, 3)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			token, err := tm.getBestToken()
+			tokens[i], errs[i] = token.AccessToken, err
+		}(i)
+		require.Eventually(t, func() bool { return tm.WaitStats().QueueDepth == i+1 }, time.Second, time.Millisecond)
+	}
+
+	// 超出队列容量立即返回
+	_, err := tm.GetBestTokenWithUsage()
+	assert.ErrorIs(t, err, ErrTokenWaitQueueFull)
+
+	wg.Wait()
+
+	for i := range errs {
+		require.NoError(t, errs[i], "排队请求 #%d", i)
+		assert.Equal(t, "mock-access-token-0123456789", tokens[i])
+	}
+
+	stats := tm.WaitStats()
+	assert.Zero(t, stats.QueueDepth)
+	assert.Equal(t, 3, stats.MaxQueueDepth)
+	assert.Equal(t, int64(3), stats.Served)
+	assert.Equal(t, int64(1), stats.Rejected)
+	assert.Zero(t, stats.TimedOut)
+	assert.GreaterOrEqual(t, stats.P50Ms, 150.0)
+	assert.Less(t, stats.P99Ms, 5000.0)
+}
+
+func TestTokenManager_WaitTimesOut(t *testing.T) {
+	t.Setenv("TOKEN_WAIT_TIMEOUT", "100ms")
+	tm := newExhaustedTokenManager(t)
+
+	start := time.Now()
+	_, err := tm.getBestToken()
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrTokenWaitQueueFull)
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+
+	stats := tm.WaitStats()
+	assert.Equal(t, int64(1), stats.TimedOut)
+	assert.Zero(t, stats.QueueDepth)
+	assert.Equal(t, int64(100), stats.TimeoutMs)
+}
+
+func TestTokenManager_RetryAfterExpiryWakesWaiters(t *testing.T) {
+	t.Setenv("TOKEN_WAIT_TIMEOUT", "5s")
+	tm := newExhaustedTokenManager(t)
+
+	tm.mutex.Lock()
+	tm.cache.tokens[tm.configs[0].TokenID].Available = 5
+	tm.mutex.Unlock()
+	tm.MarkTokenRetryAfter("exhausted", 150*time.Millisecond)
+
+	start := time.Now()
+	token, err := tm.getBestToken()
+	require.NoError(t, err)
+	assert.Equal(t, "exhausted", token.AccessToken)
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+	assert.Less(t, time.Since(start), 2*time.Second, "冷却结束后应被唤醒，而不是等到超时")
+}
+
+func TestTokenManager_WaitCanceledWithContext(t *testing.T) {
+	t.Setenv("TOKEN_WAIT_TIMEOUT", "5s")
+	tm := newExhaustedTokenManager(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := tm.GetBestTokenWithUsageContext(ctx, nil)
+	require.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, time.Since(start), time.Second, "请求取消后立即放弃等待")
+
+	stats := tm.WaitStats()
+	assert.Equal(t, int64(1), stats.Canceled)
+	assert.Zero(t, stats.TimedOut)
+	assert.Zero(t, stats.QueueDepth)
+}
@@ -28,22 +28,27 @@ var kiroVersion = kiroVersionInfo{
 	nodeVer: "22.21.1",
 }
 
+// usageLimitsURL 使用限制查询端点，测试中可替换为本地模拟服务
+var usageLimitsURL = "https://q.us-east-1.amazonaws.com/getUsageLimits"
+
 // UsageLimitsChecker 使用限制检查器 (遵循SRP原则)
 type UsageLimitsChecker struct {
 	httpClient *http.Client
+	region     string
 }
 
-// NewUsageLimitsChecker 创建使用限制检查器
-func NewUsageLimitsChecker() *UsageLimitsChecker {
+// NewUsageLimitsChecker 创建使用限制检查器，region 为空时使用默认区域
+func NewUsageLimitsChecker(region string) *UsageLimitsChecker {
 	return &UsageLimitsChecker{
 		httpClient: utils.SharedHTTPClient,
+		region:     region,
 	}
 }
 
 // CheckUsageLimits 检���token的使用限制 (基于token.md API规范)
 func (c *UsageLimitsChecker) CheckUsageLimits(token types.TokenInfo) (*types.UsageLimits, error) {
 	// 构建请求URL (与真实 KiroIDE 0.8.0 完全一致)
-	baseURL := "https://q.us-east-1.amazonaws.com/getUsageLimits"
+	baseURL := regionalURL(usageLimitsURL, c.region)
 	params := url.Values{}
 	params.Add("isEmailRequired", "true")
 	params.Add("origin", "AI_EDITOR")
@@ -64,7 +69,7 @@ func (c *UsageLimitsChecker) CheckUsageLimits(token types.TokenInfo) (*types.Usa
 	// 设置请求头 (与真实 KiroIDE 0.8.0 完全一致)
 	req.Header.Set("x-amz-user-agent", fmt.Sprintf("aws-sdk-js/1.0.27 %s", kiroIdentifier))
 	req.Header.Set("user-agent", fmt.Sprintf("aws-sdk-js/1.0.27 ua/2.1 os/win32#%s lang/js md/nodejs#%s api/codewhispererstreaming#1.0.27 m/E %s", ver.osVer, ver.nodeVer, kiroIdentifier))
-	req.Header.Set("host", "q.us-east-1.amazonaws.com")
+	req.Header.Set("host", req.URL.Host)
 	req.Header.Set("amz-sdk-invocation-id", generateInvocationID())
 	req.Header.Set("amz-sdk-request", "attempt=1; max=1")
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.AccessToken))
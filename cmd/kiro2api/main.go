@@ -2,13 +2,16 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
 
+	appconfig "kiro2api/config"
 	"kiro2api/internal/config"
 	"kiro2api/internal/runtime"
 	"kiro2api/logger"
+	"kiro2api/utils"
 
 	"github.com/joho/godotenv"
 )
@@ -21,32 +24,57 @@ func main() {
 		logger.Info("已从 .env 文件加载配置")
 	}
 
-	// 加载系统配置（优先使用持久化配置）
-	config.LoadSystemConfig()
+	// 加载系统配置（优先使用持久化配置），配置项无效时列出全部错误后退出
+	if _, err := config.LoadSystemConfig(); err != nil {
+		logger.Error("致命错误: 配置无效", logger.Err(err))
+		os.Exit(1)
+	}
 
 	logger.Reinitialize()
 
 	logger.Debug("日志系统初始化完成",
-		logger.String("config_level", os.Getenv("LOG_LEVEL")),
+		logger.String("config_level", appconfig.Get().LogLevel),
 		logger.String("config_file", os.Getenv("LOG_FILE")))
 
 	options := runtime.Options{}
 
-	if len(os.Args) > 1 {
-		options.Port = os.Args[1]
+	// --check 只执行启动自检后退出，--probe 额外向上游发送一次探测请求；其余第一个参数为端口
+	checkOnly := appconfig.IsCheckOnlyEnabled()
+	probe := false
+	for _, arg := range os.Args[1:] {
+		switch arg {
+		case "--check":
+			checkOnly = true
+		case "--probe":
+			probe = true
+		default:
+			if options.Port == "" {
+				options.Port = arg
+			}
+		}
+	}
+
+	if checkOnly {
+		os.Exit(runCheck(probe))
 	}
 
-	if envPort := os.Getenv("PORT"); envPort != "" {
+	if envPort := appconfig.Get().Port; envPort != "" {
 		options.Port = envPort
 	}
 
-	options.ClientToken = os.Getenv("KIRO_CLIENT_TOKEN")
-	if options.ClientToken == "" {
-		logger.Error("致命错误: 未设置 KIRO_CLIENT_TOKEN 环境变量")
-		logger.Error("请在 .env 文件中设置强密码，例如: KIRO_CLIENT_TOKEN=your-secure-random-password")
+	clientKeys, err := appconfig.ClientKeys()
+	if err != nil {
+		logger.Error("致命错误: KIRO_CLIENT_TOKENS 格式错误", logger.Err(err))
+		os.Exit(1)
+	}
+	if len(clientKeys) == 0 {
+		logger.Error("致命错误: 未设置 KIRO_CLIENT_TOKENS 或 KIRO_CLIENT_TOKEN 环境变量")
+		logger.Error("请在 .env 文件中设置强密码，例如: KIRO_CLIENT_TOKENS=your-secure-random-password")
 		logger.Error("安全提示: 请使用至少32字符的随机字符串")
 		os.Exit(1)
 	}
+	options.ClientToken = clientKeys[0].Key
+	logger.Info("客户端密钥已加载", logger.Int("key_count", len(clientKeys)))
 
 	application, err := runtime.New(options)
 	if err != nil {
@@ -62,3 +90,22 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// runCheck 执行启动自检，输出可读报告和JSON摘要，返回进程退出码
+func runCheck(probe bool) int {
+	runtime.ApplyTuning()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	report := runtime.RunCheck(ctx, runtime.DefaultCheckOptions(os.Getenv("KIRO_CLIENT_TOKEN"), probe))
+	fmt.Print(report.Text())
+	if summary, err := utils.SafeMarshal(report); err == nil {
+		fmt.Println(string(summary))
+	}
+
+	if !report.OK {
+		return 1
+	}
+	return 0
+}
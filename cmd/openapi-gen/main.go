@@ -0,0 +1,44 @@
+// openapi-gen 根据已注册的Gin路由生成 openapi.yaml
+//
+// 用法: go generate ./internal/adapter/httpapi/handlers 或 make openapi
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"kiro2api/internal/adapter/httpapi/handlers"
+	"kiro2api/internal/adapter/httpapi/openapi"
+
+	"github.com/gin-gonic/gin"
+)
+
+func main() {
+	output := flag.String("o", "openapi.yaml", "输出文件路径")
+	flag.Parse()
+
+	// 避免注册路由时输出调试日志
+	gin.SetMode(gin.ReleaseMode)
+
+	doc, err := handlers.BuildOpenAPISpec()
+	if err != nil {
+		fail("生成OpenAPI文档失败: %v", err)
+	}
+	if err := openapi.Validate(doc); err != nil {
+		fail("OpenAPI文档校验失败: %v", err)
+	}
+
+	data, err := openapi.Marshal(doc)
+	if err != nil {
+		fail("序列化OpenAPI文档失败: %v", err)
+	}
+	if err := os.WriteFile(*output, data, 0644); err != nil {
+		fail("写入 %s 失败: %v", *output, err)
+	}
+}
+
+func fail(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
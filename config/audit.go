@@ -0,0 +1,47 @@
+package config
+
+import (
+	"os"
+	"strings"
+	"time"
+)
+
+// 会话审计级别
+const (
+	// AuditLevelOff 不记录会话
+	AuditLevelOff = "off"
+	// AuditLevelMetadata 只记录时间、模型、消息数、token用量、stop_reason等元数据
+	AuditLevelMetadata = "metadata"
+	// AuditLevelFull 额外保留规范化后的请求体（图片替换为大小占位）
+	AuditLevelFull = "full"
+)
+
+// AuditLevel 会话审计级别
+// 通过环境变量 AUDIT_LEVEL 配置（off/metadata/full），默认 metadata；无法识别的值按 metadata 处理
+func AuditLevel() string {
+	switch level := strings.ToLower(strings.TrimSpace(os.Getenv("AUDIT_LEVEL"))); level {
+	case AuditLevelOff, AuditLevelFull:
+		return level
+	default:
+		return AuditLevelMetadata
+	}
+}
+
+// AuditRetention 会话审计记录的保留时长
+// 可通过环境变量 AUDIT_RETENTION_MINUTES 配置，默认60分钟
+func AuditRetention() time.Duration {
+	minutes := positiveIntEnv("AUDIT_RETENTION_MINUTES", int(DefaultAuditRetention/time.Minute))
+	return time.Duration(minutes) * time.Minute
+}
+
+// AuditMaxBytes 所有会话审计记录合计占用的上限
+// 可通过环境变量 AUDIT_MAX_BYTES 配置，默认16MB
+func AuditMaxBytes() int64 {
+	return int64(positiveIntEnv("AUDIT_MAX_BYTES", DefaultAuditMaxBytes))
+}
+
+// AuditMaxTurns 单个会话最多保留的请求记录数
+// 可通过环境变量 AUDIT_MAX_TURNS 配置，默认100
+func AuditMaxTurns() int {
+	return positiveIntEnv("AUDIT_MAX_TURNS", DefaultAuditMaxTurns)
+}
@@ -0,0 +1,37 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditLevel(t *testing.T) {
+	for value, want := range map[string]string{
+		"":         AuditLevelMetadata,
+		"off":      AuditLevelOff,
+		"FULL":     AuditLevelFull,
+		"metadata": AuditLevelMetadata,
+		"verbose":  AuditLevelMetadata,
+	} {
+		t.Setenv("AUDIT_LEVEL", value)
+		assert.Equal(t, want, AuditLevel(), "AUDIT_LEVEL=%q", value)
+	}
+}
+
+func TestAuditLimits(t *testing.T) {
+	t.Setenv("AUDIT_RETENTION_MINUTES", "")
+	t.Setenv("AUDIT_MAX_BYTES", "")
+	t.Setenv("AUDIT_MAX_TURNS", "")
+	assert.Equal(t, DefaultAuditRetention, AuditRetention())
+	assert.Equal(t, int64(DefaultAuditMaxBytes), AuditMaxBytes())
+	assert.Equal(t, DefaultAuditMaxTurns, AuditMaxTurns())
+
+	t.Setenv("AUDIT_RETENTION_MINUTES", "5")
+	t.Setenv("AUDIT_MAX_BYTES", "1024")
+	t.Setenv("AUDIT_MAX_TURNS", "7")
+	assert.Equal(t, 5*time.Minute, AuditRetention())
+	assert.Equal(t, int64(1024), AuditMaxBytes())
+	assert.Equal(t, 7, AuditMaxTurns())
+}
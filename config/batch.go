@@ -0,0 +1,7 @@
+package config
+
+// BatchParallelism 消息批处理中同时执行的请求数
+// 可通过环境变量 BATCH_PARALLELISM 配置，默认4，最大64
+func BatchParallelism() int {
+	return min(positiveIntEnv("BATCH_PARALLELISM", DefaultBatchParallelism), MaxBatchParallelism)
+}
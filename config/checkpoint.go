@@ -0,0 +1,48 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// CheckpointIntervalBytes 流式响应每累积该字节数写一次检查点文件
+// 可通过环境变量 CHECKPOINT_INTERVAL_BYTES 配置，默认0（不写检查点，不支持 Last-Event-ID 续传）
+func CheckpointIntervalBytes() int {
+	return positiveIntEnv("CHECKPOINT_INTERVAL_BYTES", 0)
+}
+
+// IsCheckpointEnabled 是否启用流式响应检查点
+func IsCheckpointEnabled() bool {
+	return CheckpointIntervalBytes() > 0
+}
+
+// CheckpointTTL 流结束（未发出 message_stop）后检查点文件的保留时长
+// 可通过环境变量 CHECKPOINT_TTL（秒）配置，默认300秒
+func CheckpointTTL() time.Duration {
+	seconds := positiveIntEnv("CHECKPOINT_TTL", int(DefaultCheckpointTTL/time.Second))
+	return time.Duration(seconds) * time.Second
+}
+
+// CheckpointBufferBytes 启用检查点时每条流在内存环形缓冲区中保留的最近SSE字节数
+// 可通过环境变量 CHECKPOINT_BUFFER_BYTES 配置，默认262144（256KB）
+func CheckpointBufferBytes() int {
+	return positiveIntEnv("CHECKPOINT_BUFFER_BYTES", DefaultCheckpointBufferBytes)
+}
+
+// CheckpointUpstreamTimeout 启用检查点时上游流式请求的最长时间，客户端断开后上游读取继续到流结束或超时
+// 可通过环境变量 CHECKPOINT_UPSTREAM_TIMEOUT（秒）配置，默认600秒
+func CheckpointUpstreamTimeout() time.Duration {
+	seconds := positiveIntEnv("CHECKPOINT_UPSTREAM_TIMEOUT", int(DefaultCheckpointUpstreamTimeout/time.Second))
+	return time.Duration(seconds) * time.Second
+}
+
+// CheckpointDir 检查点文件所在目录
+// 可通过环境变量 CHECKPOINT_DIR 配置，默认为系统临时目录下的 kiro2api-checkpoints
+func CheckpointDir() string {
+	if dir := strings.TrimSpace(os.Getenv("CHECKPOINT_DIR")); dir != "" {
+		return dir
+	}
+	return filepath.Join(os.TempDir(), CheckpointDirName)
+}
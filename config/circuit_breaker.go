@@ -0,0 +1,54 @@
+package config
+
+import (
+	"os"
+	"strings"
+	"time"
+)
+
+// IsCircuitBreakerEnabled 是否启用上游熔断，默认启用；CIRCUIT_BREAKER=false 时关闭
+func IsCircuitBreakerEnabled() bool {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("CIRCUIT_BREAKER"))) {
+	case "0", "false", "no", "off":
+		return false
+	default:
+		return true
+	}
+}
+
+// CircuitBreakerThreshold 打开熔断所需的连续失败次数（网络错误或5xx），同时也是按失败率判定的最少样本数
+// 可通过环境变量 CIRCUIT_BREAKER_THRESHOLD 配置，默认5
+func CircuitBreakerThreshold() int {
+	return positiveIntEnv("CIRCUIT_BREAKER_THRESHOLD", DefaultCircuitBreakerThreshold)
+}
+
+// CircuitBreakerErrorPercent 窗口内失败率超过该百分比时打开熔断
+// 可通过环境变量 CIRCUIT_BREAKER_ERROR_PERCENT 配置（1-100），默认0表示只按连续失败次数判定
+func CircuitBreakerErrorPercent() int {
+	return min(positiveIntEnv("CIRCUIT_BREAKER_ERROR_PERCENT", 0), 100)
+}
+
+// CircuitBreakerWindow 计算失败率的滑动窗口
+// 可通过环境变量 CIRCUIT_BREAKER_WINDOW（秒）配置，默认60秒
+func CircuitBreakerWindow() time.Duration {
+	seconds := positiveIntEnv("CIRCUIT_BREAKER_WINDOW", int(DefaultCircuitBreakerWindow/time.Second))
+	return time.Duration(seconds) * time.Second
+}
+
+// CircuitBreakerCooldown 熔断打开后拒绝请求的时长，到期后进入半开状态放行探测请求
+// 可通过环境变量 CIRCUIT_BREAKER_COOLDOWN（秒）配置，默认30秒
+func CircuitBreakerCooldown() time.Duration {
+	seconds := positiveIntEnv("CIRCUIT_BREAKER_COOLDOWN", int(DefaultCircuitBreakerCooldown/time.Second))
+	return time.Duration(seconds) * time.Second
+}
+
+// CircuitBreakerHalfOpenProbes 半开状态允许同时进行的探测请求数
+// 可通过环境变量 CIRCUIT_BREAKER_HALF_OPEN_PROBES 配置，默认1
+func CircuitBreakerHalfOpenProbes() int {
+	return positiveIntEnv("CIRCUIT_BREAKER_HALF_OPEN_PROBES", DefaultCircuitBreakerHalfOpenProbes)
+}
+
+// IsCircuitBreakerPerToken 是否按 端点+token 分别熔断（CIRCUIT_BREAKER_PER_TOKEN=true），默认只按端点
+func IsCircuitBreakerPerToken() bool {
+	return boolEnv("CIRCUIT_BREAKER_PER_TOKEN")
+}
@@ -0,0 +1,127 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// DefaultClientKeyName 通过 KIRO_CLIENT_TOKEN 配置的客户端密钥名称
+const DefaultClientKeyName = "default"
+
+// ClientKey 客户端 API 密钥
+// Tier 对应 CLIENT_RATE_LIMIT_TIERS 中的限流档位，为空表示不限流；Tokens 为允许使用的 TokenID 子集，为空表示整个token池
+type ClientKey struct {
+	Key    string   `json:"key"`
+	Name   string   `json:"name,omitempty"`
+	Tier   string   `json:"tier,omitempty"`
+	Tokens []string `json:"tokens,omitempty"`
+}
+
+// ClientKeys 返回所有客户端密钥
+// KIRO_CLIENT_TOKEN（已废弃，保留兼容）作为第一个密钥，与 KIRO_CLIENT_TOKENS 中的密钥重复时以后者的配置为准
+// KIRO_CLIENT_TOKENS 格式错误时仍返回 KIRO_CLIENT_TOKEN 对应的密钥和错误
+func ClientKeys() ([]ClientKey, error) {
+	legacy := strings.TrimSpace(os.Getenv("KIRO_CLIENT_TOKEN"))
+	parsed, err := ParseClientKeys(os.Getenv("KIRO_CLIENT_TOKENS"))
+	if err != nil {
+		parsed = nil
+	}
+
+	keys := make([]ClientKey, 0, len(parsed)+1)
+	if legacy != "" && !containsClientKey(parsed, legacy) {
+		keys = append(keys, ClientKey{Key: legacy, Name: DefaultClientKeyName})
+	}
+	return append(keys, parsed...), err
+}
+
+func containsClientKey(keys []ClientKey, key string) bool {
+	for _, k := range keys {
+		if k.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseClientKeys 解析 KIRO_CLIENT_TOKENS，支持两种格式：
+//   - 逗号分隔：每项为 "key" 或 "key:tier"
+//   - JSON 数组：元素为密钥字符串或 {"key","name","tier","tokens"} 对象
+//
+// 未指定名称的密钥按位置命名为 key-1、key-2……
+func ParseClientKeys(raw string) ([]ClientKey, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, nil
+	}
+
+	var keys []ClientKey
+	if strings.HasPrefix(raw, "[") {
+		var items []json.RawMessage
+		if err := json.Unmarshal([]byte(raw), &items); err != nil {
+			return nil, fmt.Errorf("解析 KIRO_CLIENT_TOKENS 失败: %v", err)
+		}
+		for i, item := range items {
+			var key ClientKey
+			var plain string
+			if err := json.Unmarshal(item, &plain); err == nil {
+				key.Key = plain
+			} else if err := json.Unmarshal(item, &key); err != nil {
+				return nil, fmt.Errorf("解析 KIRO_CLIENT_TOKENS 第 %d 项失败: %v", i+1, err)
+			}
+			keys = append(keys, key)
+		}
+	} else {
+		for _, part := range strings.Split(raw, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			key, tier, _ := strings.Cut(part, ":")
+			keys = append(keys, ClientKey{Key: key, Tier: tier})
+		}
+	}
+
+	seen := make(map[string]bool, len(keys))
+	names := make(map[string]bool, len(keys))
+	for i := range keys {
+		keys[i].Key = strings.TrimSpace(keys[i].Key)
+		keys[i].Tier = strings.TrimSpace(keys[i].Tier)
+		if keys[i].Key == "" {
+			return nil, fmt.Errorf("KIRO_CLIENT_TOKENS 第 %d 项密钥为空", i+1)
+		}
+		if seen[keys[i].Key] {
+			return nil, fmt.Errorf("KIRO_CLIENT_TOKENS 第 %d 项密钥重复", i+1)
+		}
+		seen[keys[i].Key] = true
+
+		if keys[i].Name == "" {
+			keys[i].Name = fmt.Sprintf("key-%d", i+1)
+		}
+		if names[keys[i].Name] {
+			return nil, fmt.Errorf("KIRO_CLIENT_TOKENS 密钥名称重复: %s", keys[i].Name)
+		}
+		names[keys[i].Name] = true
+	}
+	return keys, nil
+}
+
+// ClientRateLimitTiers 读取 CLIENT_RATE_LIMIT_TIERS（JSON 对象，档位名 -> 每分钟请求数），如 {"free":10,"pro":600}
+// 未配置的档位不限流
+func ClientRateLimitTiers() (map[string]int, error) {
+	raw := strings.TrimSpace(os.Getenv("CLIENT_RATE_LIMIT_TIERS"))
+	if raw == "" {
+		return nil, nil
+	}
+	var tiers map[string]int
+	if err := json.Unmarshal([]byte(raw), &tiers); err != nil {
+		return nil, fmt.Errorf("解析 CLIENT_RATE_LIMIT_TIERS 失败: %v", err)
+	}
+	for tier, rpm := range tiers {
+		if rpm <= 0 {
+			return nil, fmt.Errorf("限流档位 %s 的每分钟请求数必须大于0", tier)
+		}
+	}
+	return tiers, nil
+}
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseClientKeys_CommaList(t *testing.T) {
+	keys, err := ParseClientKeys(" alpha-key , beta-key:pro ,")
+	require.NoError(t, err)
+	assert.Equal(t, []ClientKey{
+		{Key: "alpha-key", Name: "key-1"},
+		{Key: "beta-key", Name: "key-2", Tier: "pro"},
+	}, keys)
+}
+
+func TestParseClientKeys_JSONArray(t *testing.T) {
+	keys, err := ParseClientKeys(`["plain-key", {"key":"team-key","name":"team","tier":"free","tokens":["tok-a","tok-b"]}]`)
+	require.NoError(t, err)
+	assert.Equal(t, []ClientKey{
+		{Key: "plain-key", Name: "key-1"},
+		{Key: "team-key", Name: "team", Tier: "free", Tokens: []string{"tok-a", "tok-b"}},
+	}, keys)
+}
+
+func TestParseClientKeys_Invalid(t *testing.T) {
+	for _, raw := range []string{
+		`[not-json`,
+		`[{"name":"missing-key"}]`,
+		`dup-key,dup-key:pro`,
+		`[{"key":"a","name":"same"},{"key":"b","name":"same"}]`,
+		`[42]`,
+	} {
+		_, err := ParseClientKeys(raw)
+		assert.Error(t, err, raw)
+	}
+}
+
+func TestClientKeys_LegacyTokenIsFirstKey(t *testing.T) {
+	t.Setenv("KIRO_CLIENT_TOKEN", "legacy-key")
+	t.Setenv("KIRO_CLIENT_TOKENS", "alpha-key:pro")
+	keys, err := ClientKeys()
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	assert.Equal(t, ClientKey{Key: "legacy-key", Name: DefaultClientKeyName}, keys[0])
+	assert.Equal(t, "alpha-key", keys[1].Key)
+
+	// 与列表中的密钥相同时不重复，使用列表中的配置
+	t.Setenv("KIRO_CLIENT_TOKEN", "alpha-key")
+	keys, err = ClientKeys()
+	require.NoError(t, err)
+	assert.Equal(t, []ClientKey{{Key: "alpha-key", Name: "key-1", Tier: "pro"}}, keys)
+
+	// 列表格式错误时仍保留 KIRO_CLIENT_TOKEN
+	t.Setenv("KIRO_CLIENT_TOKEN", "legacy-key")
+	t.Setenv("KIRO_CLIENT_TOKENS", "[broken")
+	keys, err = ClientKeys()
+	assert.Error(t, err)
+	assert.Equal(t, []ClientKey{{Key: "legacy-key", Name: DefaultClientKeyName}}, keys)
+}
+
+func TestClientRateLimitTiers(t *testing.T) {
+	t.Setenv("CLIENT_RATE_LIMIT_TIERS", "")
+	tiers, err := ClientRateLimitTiers()
+	require.NoError(t, err)
+	assert.Empty(t, tiers)
+
+	t.Setenv("CLIENT_RATE_LIMIT_TIERS", `{"free":10,"pro":600}`)
+	tiers, err = ClientRateLimitTiers()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"free": 10, "pro": 600}, tiers)
+
+	for _, raw := range []string{`{"free":0}`, `not-json`} {
+		t.Setenv("CLIENT_RATE_LIMIT_TIERS", raw)
+		_, err = ClientRateLimitTiers()
+		assert.Error(t, err, raw)
+	}
+}
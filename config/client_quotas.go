@@ -0,0 +1,67 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultQuotaPersistInterval 未设置 QUOTA_PERSIST_INTERVAL 时配额用量的持久化间隔
+const DefaultQuotaPersistInterval = time.Minute
+
+// QuotaBudget 单个时间窗口内的预算，0 表示该项不限制
+type QuotaBudget struct {
+	InputTokens  int64 `json:"input_tokens,omitempty"`
+	OutputTokens int64 `json:"output_tokens,omitempty"`
+	Requests     int64 `json:"requests,omitempty"`
+}
+
+// ClientQuota 客户端密钥的每日与每月预算（按 UTC 自然日、自然月计算）
+type ClientQuota struct {
+	Daily   QuotaBudget `json:"daily,omitempty"`
+	Monthly QuotaBudget `json:"monthly,omitempty"`
+}
+
+// Validate 检查预算是否为非负数
+func (q ClientQuota) Validate() error {
+	for i, budget := range []QuotaBudget{q.Daily, q.Monthly} {
+		if budget.InputTokens < 0 || budget.OutputTokens < 0 || budget.Requests < 0 {
+			return fmt.Errorf("%s 预算不能为负数", []string{"daily", "monthly"}[i])
+		}
+	}
+	return nil
+}
+
+// ParseClientQuotas 解析 JSON 对象（密钥名称 -> 预算），如
+// {"marketing":{"daily":{"output_tokens":5000000}},"ci":{"monthly":{"requests":10000}}}
+func ParseClientQuotas(raw string) (map[string]ClientQuota, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, nil
+	}
+	var quotas map[string]ClientQuota
+	if err := json.Unmarshal([]byte(raw), &quotas); err != nil {
+		return nil, fmt.Errorf("解析 CLIENT_QUOTAS 失败: %v", err)
+	}
+	for name, quota := range quotas {
+		if err := quota.Validate(); err != nil {
+			return nil, fmt.Errorf("客户端密钥 %s 的配额无效: %w", name, err)
+		}
+	}
+	return quotas, nil
+}
+
+// ClientQuotas 读取 CLIENT_QUOTAS，未配置的密钥不限额
+func ClientQuotas() (map[string]ClientQuota, error) {
+	return ParseClientQuotas(os.Getenv("CLIENT_QUOTAS"))
+}
+
+// QuotaPersistInterval 配额用量写入持久化文件的间隔（QUOTA_PERSIST_INTERVAL，Go duration），默认 1m
+func QuotaPersistInterval() time.Duration {
+	if d, err := time.ParseDuration(strings.TrimSpace(os.Getenv("QUOTA_PERSIST_INTERVAL"))); err == nil && d > 0 {
+		return d
+	}
+	return DefaultQuotaPersistInterval
+}
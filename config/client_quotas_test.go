@@ -0,0 +1,36 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseClientQuotas(t *testing.T) {
+	quotas, err := ParseClientQuotas(`{"marketing":{"daily":{"output_tokens":5000000}},"ci":{"monthly":{"requests":10000,"input_tokens":1000}}}`)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]ClientQuota{
+		"marketing": {Daily: QuotaBudget{OutputTokens: 5000000}},
+		"ci":        {Monthly: QuotaBudget{Requests: 10000, InputTokens: 1000}},
+	}, quotas)
+
+	quotas, err = ParseClientQuotas(" ")
+	require.NoError(t, err)
+	assert.Nil(t, quotas)
+
+	for _, raw := range []string{`[1]`, `{"a":{"daily":{"requests":-1}}}`, `{"a":{"monthly":{"output_tokens":"many"}}}`} {
+		_, err := ParseClientQuotas(raw)
+		assert.Error(t, err, raw)
+	}
+}
+
+func TestQuotaPersistInterval(t *testing.T) {
+	t.Setenv("QUOTA_PERSIST_INTERVAL", "")
+	assert.Equal(t, DefaultQuotaPersistInterval, QuotaPersistInterval())
+	t.Setenv("QUOTA_PERSIST_INTERVAL", "30s")
+	assert.Equal(t, 30*time.Second, QuotaPersistInterval())
+	t.Setenv("QUOTA_PERSIST_INTERVAL", "-1s")
+	assert.Equal(t, DefaultQuotaPersistInterval, QuotaPersistInterval())
+}
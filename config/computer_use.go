@@ -0,0 +1,10 @@
+package config
+
+// ComputerUseToolType Anthropic computer_use（beta）工具的类型标识
+const ComputerUseToolType = "computer_20241022"
+
+// IncludeScreenshotsInHistory 历史中 computer_use 工具结果的截图是否原样发送（INCLUDE_SCREENSHOTS_IN_HISTORY=true）
+// 默认替换为文本占位，只保留最新一轮的截图，避免历史中累积大量图片
+func IncludeScreenshotsInHistory() bool {
+	return boolEnv("INCLUDE_SCREENSHOTS_IN_HISTORY")
+}
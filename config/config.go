@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
 )
 
 // ModelMap 模型映射表
@@ -24,16 +25,40 @@ const IdcRefreshTokenURL = "https://oidc.us-east-1.amazonaws.com/token"
 // CodeWhispererURL CodeWhisperer API的URL (Kiro 0.8.0 新端点)
 const CodeWhispererURL = "https://q.us-east-1.amazonaws.com/generateAssistantResponse"
 
-// MaxToolDescriptionLength 工具描述的最大长度（字符数）
-// 可通过环境变量 MAX_TOOL_DESCRIPTION_LENGTH 配置，默认 10000
-var MaxToolDescriptionLength = getEnvIntWithDefault("MAX_TOOL_DESCRIPTION_LENGTH", 10000)
+// 上游请求的内容协商头（与 Kiro IDE 抓包一致）
+const (
+	// UpstreamContentType 请求体类型
+	UpstreamContentType = "application/json"
+	// UpstreamAcceptStream 流式请求的 Accept（AWS EventStream）
+	UpstreamAcceptStream = "application/vnd.amazon.eventstream"
+	// UpstreamAcceptJSON 非流式请求的 Accept
+	UpstreamAcceptJSON = "application/json"
+)
+
+// boolEnv 获取布尔类型环境变量，isTrueValue 为真时返回true，未设置或其他值为false（每次调用实时读取）
+func boolEnv(key string) bool {
+	return isTrueValue(os.Getenv(key))
+}
 
-// getEnvIntWithDefault 获取整数类型环境变量（带默认值）
-func getEnvIntWithDefault(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
-		if intValue, err := strconv.Atoi(value); err == nil {
-			return intValue
-		}
+// isTrueValue 判断取值是否表示开启：1/true/yes/on，不区分大小写，忽略首尾空白
+func isTrueValue(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "1", "true", "yes", "on":
+		return true
+	default:
+		return false
+	}
+}
+
+// positiveIntEnv 获取正整数类型环境变量，未设置或非法时返回默认值（每次调用实时读取）
+func positiveIntEnv(key string, defaultValue int) int {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return defaultValue
 	}
-	return defaultValue
+	return n
 }
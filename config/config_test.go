@@ -0,0 +1,24 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBoolEnv(t *testing.T) {
+	for value, want := range map[string]bool{
+		"":      false,
+		"1":     true,
+		"true":  true,
+		" YES ": true,
+		"On":    true,
+		"0":     false,
+		"false": false,
+		"off":   false,
+		"maybe": false,
+	} {
+		t.Setenv("BOOL_ENV_TEST", value)
+		assert.Equal(t, want, boolEnv("BOOL_ENV_TEST"), "value=%q", value)
+	}
+}
@@ -0,0 +1,26 @@
+// Package configtest 提供测试中替换 config 状态的辅助函数，只应被 _test.go 文件导入，
+// 避免 testing 包被链接进生产二进制
+package configtest
+
+import (
+	"testing"
+
+	"kiro2api/config"
+)
+
+// Override 为当前测试替换配置快照，mutate 修改的是当前快照的副本，测试结束后恢复
+func Override(t testing.TB, mutate func(*config.Settings)) {
+	t.Helper()
+	s := config.Get()
+	mutate(&s)
+	previous := config.SwapSettings(&s)
+	t.Cleanup(func() { config.SwapSettings(previous) })
+}
+
+// WithDeterministic 为当前测试启用确定性模式并重置随机序列和计数器，测试结束后恢复
+func WithDeterministic(t testing.TB) {
+	t.Helper()
+	t.Setenv(config.DeterministicModeEnv, "true")
+	config.ResetDeterministicState()
+	t.Cleanup(config.ResetDeterministicState)
+}
@@ -0,0 +1,24 @@
+package configtest
+
+import (
+	"testing"
+
+	"kiro2api/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithDeterministic_RunsResetHooks(t *testing.T) {
+	resets := 0
+	config.RegisterDeterministicReset(func() { resets++ })
+
+	t.Run("inner", func(t *testing.T) {
+		WithDeterministic(t)
+		assert.True(t, config.IsDeterministicModeEnabled())
+		assert.Equal(t, 1, resets)
+	})
+
+	// 子测试结束后再次重置，且环境变量已恢复
+	assert.Equal(t, 2, resets)
+	assert.False(t, config.IsDeterministicModeEnabled())
+}
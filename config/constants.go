@@ -4,9 +4,6 @@ import "time"
 
 // Token管理常量
 const (
-	// TokenCacheKeyFormat token缓存key格式
-	TokenCacheKeyFormat = "token_%d"
-
 	// TokenRefreshCleanupDelay token刷新完成后的清理延迟
 	TokenRefreshCleanupDelay = 5 * time.Second
 )
@@ -33,6 +30,21 @@ const (
 
 	// LongTextThreshold 长文本阈值（字符数）
 	LongTextThreshold = 1000
+
+	// CodeCharsPerToken 代码/JSON的字符密度（字符/token），符号多、BPE合并少，不再做长文本压缩
+	CodeCharsPerToken = 3.2
+
+	// TextCharsPerToken 普通文本的基准字符密度（字符/token，50-100字符文本的取值），按模型校准时以它为基准缩放
+	TextCharsPerToken = 2.6
+
+	// CodeDetectionMinRunes 参与代码检测的最短文本长度，更短的文本沿用普通文本估算
+	CodeDetectionMinRunes = 50
+
+	// CodeSymbolRatioThreshold 符号字符占非空白字符的比例达到该值时视为代码
+	CodeSymbolRatioThreshold = 0.20
+
+	// CodeStructureDensityThreshold 花括号、方括号、分号占全部字符的比例达到该值时视为代码
+	CodeStructureDensityThreshold = 0.03
 )
 
 // EventStream解析器常量
@@ -42,6 +54,9 @@ const (
 
 	// EventStreamMaxMessageSize AWS EventStream最大消息长度（16MB）
 	EventStreamMaxMessageSize = 16 * 1024 * 1024
+
+	// EventStreamReadChunkSize 非流式响应增量解析时每次读取的字节数
+	EventStreamReadChunkSize = 8192
 )
 
 // Token计算常量
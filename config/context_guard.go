@@ -0,0 +1,46 @@
+package config
+
+import (
+	"os"
+	"strings"
+)
+
+// IsContextGuardEnabled 是否启用上下文保护（CONTEXT_GUARD=true）
+func IsContextGuardEnabled() bool {
+	return boolEnv("CONTEXT_GUARD")
+}
+
+// ContextGuardMaxTokens 上下文保护的token预算
+// 可通过环境变量 CONTEXT_GUARD_MAX_TOKENS 配置，默认180000
+func ContextGuardMaxTokens() int {
+	return positiveIntEnv("CONTEXT_GUARD_MAX_TOKENS", DefaultContextGuardMaxTokens)
+}
+
+// ContextGuardBlockTokens 历史文本块截断阈值
+// 可通过环境变量 CONTEXT_GUARD_BLOCK_TOKENS 配置，默认2000
+func ContextGuardBlockTokens() int {
+	return positiveIntEnv("CONTEXT_GUARD_BLOCK_TOKENS", DefaultContextGuardBlockTokens)
+}
+
+// 历史压缩模式
+const (
+	HistoryCompressionDrop      = "drop"      // 整轮丢弃最旧的历史
+	HistoryCompressionSummarise = "summarise" // 先把最旧的若干轮合并为摘要
+)
+
+// HistoryCompressionMode 上下文保护裁剪历史的方式
+// 可通过环境变量 HISTORY_COMPRESSION_MODE 配置（drop/summarise），默认 drop，无法识别的值按 drop 处理
+func HistoryCompressionMode() string {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("HISTORY_COMPRESSION_MODE"))) {
+	case "summarise", "summarize":
+		return HistoryCompressionSummarise
+	default:
+		return HistoryCompressionDrop
+	}
+}
+
+// HistorySummaryPairs summarise 模式下合并为摘要的最早对话轮数
+// 可通过环境变量 HISTORY_SUMMARY_PAIRS 配置，默认4
+func HistorySummaryPairs() int {
+	return positiveIntEnv("HISTORY_SUMMARY_PAIRS", DefaultHistorySummaryPairs)
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"errors"
+	"regexp"
+)
+
+// 客户端自定义会话ID和agent续接ID的请求头
+const (
+	ConversationIDHeader      = "X-Conversation-ID"
+	AgentContinuationIDHeader = "X-Agent-Continuation-ID"
+	// ClientFingerprintHeader 客户端稳定标识，存在时代替客户端IP与User-Agent生成会话ID，多实例部署时各实例结果一致
+	ClientFingerprintHeader = "X-Client-Fingerprint"
+)
+
+// ClientFingerprintMaxLength X-Client-Fingerprint 的最大长度
+const ClientFingerprintMaxLength = 128
+
+// clientFingerprintPattern X-Client-Fingerprint 的格式：字母、数字和 . _ : -
+var clientFingerprintPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]+$`)
+
+// ConversationOverrideMaxLength 自定义会话ID的最大长度
+const ConversationOverrideMaxLength = 64
+
+// conversationOverridePattern 自定义会话ID的格式：UUID 或 conv-<16位十六进制>（与自动生成的格式一致）
+var conversationOverridePattern = regexp.MustCompile(`^(?:[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}|conv-[0-9a-f]{16})$`)
+
+var (
+	// ErrConversationOverrideTooLong 自定义会话ID超过 ConversationOverrideMaxLength
+	ErrConversationOverrideTooLong = errors.New("长度不能超过64个字符")
+	// ErrInvalidConversationOverride 自定义会话ID格式不合法
+	ErrInvalidConversationOverride = errors.New("格式无效，需为UUID或 conv-<16位十六进制>")
+	// ErrClientFingerprintTooLong X-Client-Fingerprint 超过 ClientFingerprintMaxLength
+	ErrClientFingerprintTooLong = errors.New("长度不能超过128个字符")
+	// ErrInvalidClientFingerprint X-Client-Fingerprint 包含不允许的字符
+	ErrInvalidClientFingerprint = errors.New("格式无效，只能包含字母、数字和 . _ : -")
+)
+
+// ValidateConversationOverride 校验 X-Conversation-ID / X-Agent-Continuation-ID 的取值
+func ValidateConversationOverride(value string) error {
+	if len(value) > ConversationOverrideMaxLength {
+		return ErrConversationOverrideTooLong
+	}
+	if !conversationOverridePattern.MatchString(value) {
+		return ErrInvalidConversationOverride
+	}
+	return nil
+}
+
+// ValidateClientFingerprint 校验 X-Client-Fingerprint 的取值
+func ValidateClientFingerprint(value string) error {
+	if len(value) > ClientFingerprintMaxLength {
+		return ErrClientFingerprintTooLong
+	}
+	if !clientFingerprintPattern.MatchString(value) {
+		return ErrInvalidClientFingerprint
+	}
+	return nil
+}
+
+// IsConversationNamespaceEnabled 是否按客户端密钥隔离自定义会话ID，使不同密钥无法指定同一个上游会话
+// 通过环境变量 CONVERSATION_NAMESPACE 配置，默认关闭
+func IsConversationNamespaceEnabled() bool {
+	return boolEnv("CONVERSATION_NAMESPACE")
+}
@@ -0,0 +1,36 @@
+package config
+
+import "sync"
+
+// DeterministicModeEnv 启用确定性模式的环境变量
+const DeterministicModeEnv = "DETERMINISTIC_MODE"
+
+var (
+	deterministicResetMu    sync.Mutex
+	deterministicResetHooks []func()
+)
+
+// IsDeterministicModeEnabled 确定性模式：用于集成测试和上游请求的 golden 文件比对
+// 启用后关闭序列化随机缩进，请求头ID由请求ID派生，消息ID使用计数器，随机数使用固定种子
+func IsDeterministicModeEnabled() bool {
+	return boolEnv(DeterministicModeEnv)
+}
+
+// RegisterDeterministicReset 注册确定性状态的重置函数（如重新播种随机数、清零计数器）
+// 由持有确定性状态的包在 init 中调用
+func RegisterDeterministicReset(reset func()) {
+	deterministicResetMu.Lock()
+	defer deterministicResetMu.Unlock()
+	deterministicResetHooks = append(deterministicResetHooks, reset)
+}
+
+// ResetDeterministicState 执行全部已注册的重置函数，使随机序列和计数器从头开始
+func ResetDeterministicState() {
+	deterministicResetMu.Lock()
+	hooks := append([]func(){}, deterministicResetHooks...)
+	deterministicResetMu.Unlock()
+
+	for _, reset := range hooks {
+		reset()
+	}
+}
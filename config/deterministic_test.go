@@ -0,0 +1,14 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsDeterministicModeEnabled(t *testing.T) {
+	for value, want := range map[string]bool{"": false, "false": false, "true": true, "1": true, "ON": true} {
+		t.Setenv("DETERMINISTIC_MODE", value)
+		assert.Equal(t, want, IsDeterministicModeEnabled(), value)
+	}
+}
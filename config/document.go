@@ -0,0 +1,34 @@
+package config
+
+import (
+	"os"
+	"strings"
+	"time"
+)
+
+// DocumentURLAllowlist 允许拉取系统提示 document 块的域名白名单
+// 通过环境变量 DOCUMENT_URL_ALLOWLIST 配置（逗号分隔，如 "docs.example.com,example.org"），
+// 子域名自动匹配；为空时拒绝所有 URL 文档
+func DocumentURLAllowlist() []string {
+	var domains []string
+	for _, part := range strings.Split(os.Getenv("DOCUMENT_URL_ALLOWLIST"), ",") {
+		domain := strings.ToLower(strings.TrimSpace(part))
+		if domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	return domains
+}
+
+// DocumentFetchTimeout 拉取单个 URL 文档的超时
+// 可通过环境变量 DOCUMENT_FETCH_TIMEOUT（秒）配置，默认10秒
+func DocumentFetchTimeout() time.Duration {
+	seconds := positiveIntEnv("DOCUMENT_FETCH_TIMEOUT", int(DefaultDocumentFetchTimeout/time.Second))
+	return time.Duration(seconds) * time.Second
+}
+
+// DocumentFetchMaxBytes 单个 URL 文档的最大读取字节数
+// 可通过环境变量 DOCUMENT_FETCH_MAX_BYTES 配置，默认512KB
+func DocumentFetchMaxBytes() int64 {
+	return int64(positiveIntEnv("DOCUMENT_FETCH_MAX_BYTES", DefaultDocumentFetchMaxBytes))
+}
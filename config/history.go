@@ -0,0 +1,19 @@
+package config
+
+// ParallelHistoryThreshold 历史消息并行预处理阈值
+// 可通过环境变量 PARALLEL_HISTORY_THRESHOLD 配置，默认50
+func ParallelHistoryThreshold() int {
+	return positiveIntEnv("PARALLEL_HISTORY_THRESHOLD", DefaultParallelHistoryThreshold)
+}
+
+// ConversionCacheSize 请求转换缓存（按请求内容哈希复用转换结果）的最大条目数
+// 可通过环境变量 CONVERSION_CACHE_SIZE 配置，默认0（不缓存）
+func ConversionCacheSize() int {
+	return positiveIntEnv("CONVERSION_CACHE_SIZE", DefaultConversionCacheSize)
+}
+
+// IsStrictHistoryEnabled 是否拒绝需要调整才能发送的历史（STRICT_HISTORY=true）
+// 默认丢弃孤立的 assistant、合并连续的 user、为末尾孤立的 user 补齐 "OK"，并通过响应头告知客户端
+func IsStrictHistoryEnabled() bool {
+	return boolEnv("STRICT_HISTORY")
+}
@@ -0,0 +1,30 @@
+package config
+
+import (
+	"os"
+	"strings"
+)
+
+// 历史消息中 document 块的处理方式
+const (
+	// HistoryDocumentsInclude 内联文档内容（base64/text 来源），url 来源替换为占位
+	HistoryDocumentsInclude = "true"
+	// HistoryDocumentsPlaceholder 所有文档都替换为 [document: N chars] 占位
+	HistoryDocumentsPlaceholder = "placeholder"
+	// HistoryDocumentsOff 丢弃文档（默认）
+	HistoryDocumentsOff = "false"
+)
+
+// HistoryDocumentsMode 历史中用户消息的 document 块的处理方式
+// 通过环境变量 HISTORY_INCLUDE_DOCUMENTS 配置（true/placeholder/false），未设置或无法识别时为 false
+func HistoryDocumentsMode() string {
+	value := strings.ToLower(strings.TrimSpace(os.Getenv("HISTORY_INCLUDE_DOCUMENTS")))
+	switch {
+	case isTrueValue(value):
+		return HistoryDocumentsInclude
+	case value == HistoryDocumentsPlaceholder:
+		return HistoryDocumentsPlaceholder
+	default:
+		return HistoryDocumentsOff
+	}
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LatencyBuckets 延迟直方图桶上界
+// 可通过环境变量 LATENCY_BUCKETS（逗号分隔的毫秒值，如 "100,500,1000"）配置；
+// 格式非法时使用默认值
+func LatencyBuckets() []time.Duration {
+	value := strings.TrimSpace(os.Getenv("LATENCY_BUCKETS"))
+	if value == "" {
+		return append([]time.Duration(nil), DefaultLatencyBuckets...)
+	}
+
+	var buckets []time.Duration
+	seen := make(map[int]bool)
+	for _, part := range strings.Split(value, ",") {
+		ms, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || ms <= 0 {
+			return append([]time.Duration(nil), DefaultLatencyBuckets...)
+		}
+		if seen[ms] {
+			continue
+		}
+		seen[ms] = true
+		buckets = append(buckets, time.Duration(ms)*time.Millisecond)
+	}
+
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i] < buckets[j] })
+	return buckets
+}
+
+// LatencyResetInterval 延迟直方图重置周期
+// 可通过环境变量 LATENCY_RESET_INTERVAL（Go duration 格式，如 "30m"）配置，默认1小时
+func LatencyResetInterval() time.Duration {
+	value := strings.TrimSpace(os.Getenv("LATENCY_RESET_INTERVAL"))
+	if value == "" {
+		return DefaultLatencyResetInterval
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return DefaultLatencyResetInterval
+	}
+	return d
+}
@@ -0,0 +1,63 @@
+package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 模拟上游的工具调用方式
+const (
+	// MockToolUseOff 只返回文本响应（默认）
+	MockToolUseOff = "off"
+	// MockToolUseFirst 请求声明了工具时调用第一个工具
+	MockToolUseFirst = "first"
+)
+
+// IsMockUpstreamEnabled 是否以进程内模拟上游代替 CodeWhisperer，用于无真实凭证的本地开发和CI
+// 通过环境变量 MOCK_UPSTREAM 配置，默认关闭
+func IsMockUpstreamEnabled() bool {
+	return boolEnv("MOCK_UPSTREAM")
+}
+
+// MockErrorRate 模拟上游返回错误的概率（0~1）
+// 通过环境变量 MOCK_ERROR_RATE 配置，默认0；非法值视为0，超出范围时截断
+func MockErrorRate() float64 {
+	rate, err := strconv.ParseFloat(strings.TrimSpace(os.Getenv("MOCK_ERROR_RATE")), 64)
+	if err != nil || rate <= 0 {
+		return 0
+	}
+	return min(rate, 1)
+}
+
+// MockFirstTokenDelay 模拟上游输出第一个事件前的延迟
+// 通过环境变量 MOCK_FIRST_TOKEN_DELAY_MS（毫秒）配置，默认不延迟
+func MockFirstTokenDelay() time.Duration {
+	return time.Duration(positiveIntEnv("MOCK_FIRST_TOKEN_DELAY_MS", 0)) * time.Millisecond
+}
+
+// MockToolUse 模拟上游的工具调用方式
+// 通过环境变量 MOCK_TOOL_USE 配置：off（默认）、first，或请求中声明的某个工具名
+func MockToolUse() string {
+	value := strings.TrimSpace(os.Getenv("MOCK_TOOL_USE"))
+	if value == "" {
+		return MockToolUseOff
+	}
+	return value
+}
+
+// MockToolInput 模拟工具调用的参数（JSON对象）
+// 通过环境变量 MOCK_TOOL_INPUT 配置，未设置时根据工具的 input_schema 生成占位参数
+func MockToolInput() string {
+	return strings.TrimSpace(os.Getenv("MOCK_TOOL_INPUT"))
+}
+
+// MockResponseTemplate 模拟上游文本响应的 text/template 模板
+// 通过环境变量 MOCK_RESPONSE_TEMPLATE 配置，可用字段 .Model、.Prompt、.ToolCount
+func MockResponseTemplate() string {
+	if tmpl := os.Getenv("MOCK_RESPONSE_TEMPLATE"); strings.TrimSpace(tmpl) != "" {
+		return tmpl
+	}
+	return DefaultMockResponseTemplate
+}
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// TokenCalibrationFile 按模型校准表的持久化文件路径，启动时加载，校准接口更新后写回
+// 通过环境变量 TOKEN_CALIBRATION_FILE 配置，默认 data/token_calibration.json
+func TokenCalibrationFile() string {
+	if file := strings.TrimSpace(os.Getenv("TOKEN_CALIBRATION_FILE")); file != "" {
+		return file
+	}
+	return DefaultTokenCalibrationFile
+}
+
+// CalibrationCoefficients 单个模型实测的字符/token比率
+// 文本估算按 TextCharsPerToken 相对默认值的比例缩放（长短文本分档随之缩放），代码/JSON同理
+type CalibrationCoefficients struct {
+	TextCharsPerToken float64 `json:"text_chars_per_token"`
+	CodeCharsPerToken float64 `json:"code_chars_per_token"`
+	Samples           int     `json:"samples"` // 参与校准的实测次数
+}
+
+// DefaultCalibrationCoefficients 未校准模型使用的默认比率
+func DefaultCalibrationCoefficients() CalibrationCoefficients {
+	return CalibrationCoefficients{TextCharsPerToken: TextCharsPerToken, CodeCharsPerToken: CodeCharsPerToken}
+}
+
+// Calibrate 按一次实测结果修正比率：估算偏高时提高字符/token比率，偏低时降低
+// estimated 应为使用当前比率得到的估算值；样本越多单次修正的权重越小，最小为 CalibrationMinWeight
+func (c CalibrationCoefficients) Calibrate(actualTokens, estimatedTokens int) CalibrationCoefficients {
+	factor := float64(estimatedTokens) / float64(actualTokens)
+	factor = math.Min(math.Max(factor, 1/CalibrationMaxFactor), CalibrationMaxFactor)
+	weight := math.Max(1/float64(c.Samples+1), CalibrationMinWeight)
+	adjust := 1 + weight*(factor-1)
+
+	c.TextCharsPerToken *= adjust
+	c.CodeCharsPerToken *= adjust
+	c.Samples++
+	return c
+}
+
+// ModelCalibrationTable 模型名到实测比率的映射，未出现的模型使用默认比率
+type ModelCalibrationTable map[string]CalibrationCoefficients
+
+// Validate 检查比率均为正数
+func (t ModelCalibrationTable) Validate() error {
+	for model, coefficients := range t {
+		if strings.TrimSpace(model) == "" {
+			return fmt.Errorf("校准表中的模型名不能为空")
+		}
+		if coefficients.TextCharsPerToken <= 0 || coefficients.CodeCharsPerToken <= 0 {
+			return fmt.Errorf("模型 %s 的字符/token比率必须大于0", model)
+		}
+		if coefficients.Samples < 0 {
+			return fmt.Errorf("模型 %s 的样本数不能为负数", model)
+		}
+	}
+	return nil
+}
+
+// LoadModelCalibrationTable 从 TokenCalibrationFile 加载按模型校准表，文件不存在时返回空表
+func LoadModelCalibrationTable() (ModelCalibrationTable, error) {
+	data, err := os.ReadFile(TokenCalibrationFile())
+	if errors.Is(err, os.ErrNotExist) {
+		return ModelCalibrationTable{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取按模型校准表失败: %v", err)
+	}
+
+	var table ModelCalibrationTable
+	if err := json.Unmarshal(data, &table); err != nil {
+		return nil, fmt.Errorf("解析按模型校准表失败: %v", err)
+	}
+	if table == nil {
+		table = ModelCalibrationTable{}
+	}
+	if err := table.Validate(); err != nil {
+		return nil, err
+	}
+	return table, nil
+}
+
+// SaveModelCalibrationTable 写入持久化文件（先写临时文件再重命名，避免留下半份配置）
+func SaveModelCalibrationTable(table ModelCalibrationTable) error {
+	data, err := json.MarshalIndent(table, "", "  ")
+	if err != nil {
+		return err
+	}
+	file := TokenCalibrationFile()
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
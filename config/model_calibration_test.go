@@ -0,0 +1,53 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCalibrationCoefficients_Calibrate(t *testing.T) {
+	defaults := DefaultCalibrationCoefficients()
+
+	// 首个样本权重为1：估算偏高25%，比率提高25%
+	first := defaults.Calibrate(100, 125)
+	assert.InDelta(t, TextCharsPerToken*1.25, first.TextCharsPerToken, 1e-9)
+	assert.InDelta(t, CodeCharsPerToken*1.25, first.CodeCharsPerToken, 1e-9)
+	assert.Equal(t, 1, first.Samples)
+
+	// 第二个样本权重为1/2
+	second := first.Calibrate(100, 80)
+	assert.InDelta(t, first.TextCharsPerToken*0.9, second.TextCharsPerToken, 1e-9)
+	assert.Equal(t, 2, second.Samples)
+
+	// 异常样本被截断到 CalibrationMaxFactor
+	outlier := defaults.Calibrate(1, 1000)
+	assert.InDelta(t, TextCharsPerToken*CalibrationMaxFactor, outlier.TextCharsPerToken, 1e-9)
+
+	// 样本很多后仍保留最小权重
+	many := CalibrationCoefficients{TextCharsPerToken: 2, CodeCharsPerToken: 3, Samples: 100}.Calibrate(100, 200)
+	assert.InDelta(t, 2*(1+CalibrationMinWeight), many.TextCharsPerToken, 1e-9)
+}
+
+func TestModelCalibrationTable_LoadSave(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "data", "token_calibration.json")
+	t.Setenv("TOKEN_CALIBRATION_FILE", file)
+
+	table, err := LoadModelCalibrationTable()
+	require.NoError(t, err)
+	assert.Empty(t, table, "文件不存在时返回空表")
+
+	table = ModelCalibrationTable{"claude-sonnet-4": {TextCharsPerToken: 3.1, CodeCharsPerToken: 3.5, Samples: 2}}
+	require.NoError(t, SaveModelCalibrationTable(table))
+
+	loaded, err := LoadModelCalibrationTable()
+	require.NoError(t, err)
+	assert.Equal(t, table, loaded)
+
+	require.NoError(t, os.WriteFile(file, []byte(`{"claude-sonnet-4":{"text_chars_per_token":0,"code_chars_per_token":3}}`), 0644))
+	_, err = LoadModelCalibrationTable()
+	assert.Error(t, err)
+}
@@ -0,0 +1,46 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrModelUnavailable 模型能力探测连续失败，暂停路由该模型
+var ErrModelUnavailable = errors.New("模型能力探测连续失败，暂不可用")
+
+var (
+	unavailableModelsMu sync.RWMutex
+	unavailableModels   = map[string]bool{}
+)
+
+// CapabilityProbeInterval 模型能力探测周期（启动时探测一次，之后按周期重复）
+// 通过环境变量 CAPABILITY_PROBE_INTERVAL（小时）配置，默认0表示不探测
+func CapabilityProbeInterval() time.Duration {
+	hours, err := strconv.Atoi(strings.TrimSpace(os.Getenv("CAPABILITY_PROBE_INTERVAL")))
+	if err != nil || hours <= 0 {
+		return 0
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// SetModelAvailable 设置 ModelMap 中模型的路由可用性，由模型能力探测更新
+func SetModelAvailable(model string, available bool) {
+	unavailableModelsMu.Lock()
+	defer unavailableModelsMu.Unlock()
+	if available {
+		delete(unavailableModels, model)
+	} else {
+		unavailableModels[model] = true
+	}
+}
+
+// IsModelAvailable 模型是否可以路由；未被探测标记为不可用的模型均视为可用
+func IsModelAvailable(model string) bool {
+	unavailableModelsMu.RLock()
+	defer unavailableModelsMu.RUnlock()
+	return !unavailableModels[model]
+}
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ModelFallbacks 模型名到按顺序尝试的回退模型列表
+type ModelFallbacks map[string][]string
+
+// LoadModelFallbacks 解析环境变量 MODEL_FALLBACKS（JSON对象，如 {"claude-opus-4.5":["claude-sonnet-4.5"]}），未配置时返回nil
+// 配置无效时返回错误，启动失败
+func LoadModelFallbacks() (ModelFallbacks, error) {
+	return ParseModelFallbacks(os.Getenv("MODEL_FALLBACKS"))
+}
+
+// ParseModelFallbacks 解析并校验模型回退配置：模型名不能为空，回退列表不能包含自身或重复的模型
+func ParseModelFallbacks(raw string) (ModelFallbacks, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+
+	var fallbacks ModelFallbacks
+	if err := json.Unmarshal([]byte(raw), &fallbacks); err != nil {
+		return nil, fmt.Errorf("MODEL_FALLBACKS 不是有效的JSON对象: %v", err)
+	}
+
+	for model, chain := range fallbacks {
+		if strings.TrimSpace(model) == "" {
+			return nil, fmt.Errorf("MODEL_FALLBACKS: 模型名不能为空")
+		}
+		if len(chain) == 0 {
+			return nil, fmt.Errorf("MODEL_FALLBACKS[%s]: 回退列表不能为空", model)
+		}
+		seen := map[string]bool{model: true}
+		for _, fallback := range chain {
+			if strings.TrimSpace(fallback) == "" {
+				return nil, fmt.Errorf("MODEL_FALLBACKS[%s]: 回退模型名不能为空", model)
+			}
+			if seen[fallback] {
+				return nil, fmt.Errorf("MODEL_FALLBACKS[%s]: 回退模型 %s 重复或与主模型相同", model, fallback)
+			}
+			seen[fallback] = true
+		}
+	}
+	return fallbacks, nil
+}
+
+// ModelFallbackErrorRate 主模型在 ModelErrorRateWindow 内的上游错误率超过该值时改用回退模型
+// 通过环境变量 MODEL_FALLBACK_ERROR_RATE（0-1）配置，默认 DefaultModelFallbackErrorRate
+func ModelFallbackErrorRate() float64 {
+	rate, err := strconv.ParseFloat(strings.TrimSpace(os.Getenv("MODEL_FALLBACK_ERROR_RATE")), 64)
+	if err != nil || rate <= 0 || rate > 1 {
+		return DefaultModelFallbackErrorRate
+	}
+	return rate
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseModelFallbacks(t *testing.T) {
+	fallbacks, err := ParseModelFallbacks("")
+	require.NoError(t, err)
+	assert.Nil(t, fallbacks)
+
+	fallbacks, err = ParseModelFallbacks(`{"claude-opus-4.5":["claude-sonnet-4.5","claude-sonnet-4"]}`)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"claude-sonnet-4.5", "claude-sonnet-4"}, fallbacks["claude-opus-4.5"])
+
+	invalid := map[string]string{
+		"不是JSON对象": `["claude-sonnet-4"]`,
+		"回退列表为空":   `{"claude-opus-4.5":[]}`,
+		"回退到自身":    `{"claude-opus-4.5":["claude-opus-4.5"]}`,
+		"回退模型重复":   `{"claude-opus-4.5":["claude-sonnet-4","claude-sonnet-4"]}`,
+		"回退模型名为空白": `{"claude-opus-4.5":[" "]}`,
+	}
+	for name, raw := range invalid {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseModelFallbacks(raw)
+			assert.ErrorContains(t, err, "MODEL_FALLBACKS")
+		})
+	}
+}
+
+func TestModelFallbackErrorRate(t *testing.T) {
+	t.Setenv("MODEL_FALLBACK_ERROR_RATE", "")
+	assert.Equal(t, DefaultModelFallbackErrorRate, ModelFallbackErrorRate())
+
+	t.Setenv("MODEL_FALLBACK_ERROR_RATE", "0.2")
+	assert.Equal(t, 0.2, ModelFallbackErrorRate())
+
+	t.Setenv("MODEL_FALLBACK_ERROR_RATE", "1.5")
+	assert.Equal(t, DefaultModelFallbackErrorRate, ModelFallbackErrorRate())
+}
@@ -0,0 +1,58 @@
+package config
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// ModelPassthroughPrefix 直通模型名前缀，如 "cw:CLAUDE_SONNET_4_5_20250929_V1_0"
+// 前缀之后的部分原样作为上游 modelId，不经过 ModelMap 映射
+const ModelPassthroughPrefix = "cw:"
+
+// passthroughModelIDPattern 直通 modelId 的格式约束（宽松匹配 CodeWhisperer 的模型ID风格）
+var passthroughModelIDPattern = regexp.MustCompile(`^[A-Z0-9_]{5,80}$`)
+
+var (
+	// ErrModelNotMapped 模型不在 ModelMap 中
+	ErrModelNotMapped = errors.New("模型映射不存在")
+	// ErrModelPassthroughDisabled 使用了直通前缀但未启用 ALLOW_MODEL_PASSTHROUGH
+	ErrModelPassthroughDisabled = errors.New("未启用模型直通（ALLOW_MODEL_PASSTHROUGH），不支持 " + ModelPassthroughPrefix + " 前缀")
+	// ErrInvalidPassthroughModelID 直通 modelId 格式不合法
+	ErrInvalidPassthroughModelID = errors.New("直通模型ID格式无效，需匹配 ^[A-Z0-9_]{5,80}$")
+)
+
+// IsModelPassthroughEnabled 是否允许通过 cw: 前缀直接指定上游 modelId
+// 通过环境变量 ALLOW_MODEL_PASSTHROUGH 配置，默认关闭
+func IsModelPassthroughEnabled() bool {
+	return boolEnv("ALLOW_MODEL_PASSTHROUGH")
+}
+
+// IsPassthroughModel 模型名是否使用了直通前缀
+func IsPassthroughModel(model string) bool {
+	return strings.HasPrefix(model, ModelPassthroughPrefix)
+}
+
+// ResolveModelID 将请求中的模型名解析为上游 modelId
+// 带 cw: 前缀时跳过 ModelMap，校验后原样透传；否则查 ModelMap，能力探测标记为不可用的模型不再路由
+func ResolveModelID(model string) (string, error) {
+	if !IsPassthroughModel(model) {
+		modelID := ModelMap[model]
+		if modelID == "" {
+			return "", ErrModelNotMapped
+		}
+		if !IsModelAvailable(model) {
+			return "", ErrModelUnavailable
+		}
+		return modelID, nil
+	}
+
+	if !IsModelPassthroughEnabled() {
+		return "", ErrModelPassthroughDisabled
+	}
+	modelID := strings.TrimPrefix(model, ModelPassthroughPrefix)
+	if !passthroughModelIDPattern.MatchString(modelID) {
+		return "", ErrInvalidPassthroughModelID
+	}
+	return modelID, nil
+}
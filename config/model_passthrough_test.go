@@ -0,0 +1,71 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveModelID(t *testing.T) {
+	tests := []struct {
+		name        string
+		passthrough string
+		model       string
+		want        string
+		wantErr     error
+	}{
+		{name: "ModelMap映射", model: "claude-sonnet-4", want: "claude-sonnet-4"},
+		{name: "未知模型", model: "unknown-model", wantErr: ErrModelNotMapped},
+		{name: "直通启用", passthrough: "true", model: "cw:CLAUDE_SONNET_4_5_20250929_V1_0", want: "CLAUDE_SONNET_4_5_20250929_V1_0"},
+		{name: "直通未启用", passthrough: "", model: "cw:CLAUDE_SONNET_4_5_20250929_V1_0", wantErr: ErrModelPassthroughDisabled},
+		{name: "直通ID含小写", passthrough: "true", model: "cw:claude_sonnet", wantErr: ErrInvalidPassthroughModelID},
+		{name: "直通ID过短", passthrough: "true", model: "cw:ABCD", wantErr: ErrInvalidPassthroughModelID},
+		{name: "直通ID含非法字符", passthrough: "true", model: "cw:CLAUDE-SONNET/../X", wantErr: ErrInvalidPassthroughModelID},
+		{name: "直通ID为空", passthrough: "true", model: "cw:", wantErr: ErrInvalidPassthroughModelID},
+		{name: "启用直通不影响ModelMap", passthrough: "true", model: "claude-haiku-4.5", want: "claude-haiku-4.5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("ALLOW_MODEL_PASSTHROUGH", tt.passthrough)
+
+			got, err := ResolveModelID(tt.model)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				assert.Empty(t, got)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestResolveModelID_PassthroughIDLengthBounds(t *testing.T) {
+	t.Setenv("ALLOW_MODEL_PASSTHROUGH", "1")
+
+	_, err := ResolveModelID("cw:ABCDE")
+	assert.NoError(t, err)
+
+	long := make([]byte, 81)
+	for i := range long {
+		long[i] = 'A'
+	}
+	_, err = ResolveModelID("cw:" + string(long[:80]))
+	assert.NoError(t, err)
+	_, err = ResolveModelID("cw:" + string(long))
+	assert.ErrorIs(t, err, ErrInvalidPassthroughModelID)
+}
+
+func TestResolveModelID_UnavailableModel(t *testing.T) {
+	SetModelAvailable("claude-opus-4.5", false)
+	t.Cleanup(func() { SetModelAvailable("claude-opus-4.5", true) })
+
+	_, err := ResolveModelID("claude-opus-4.5")
+	assert.ErrorIs(t, err, ErrModelUnavailable)
+
+	SetModelAvailable("claude-opus-4.5", true)
+	got, err := ResolveModelID("claude-opus-4.5")
+	assert.NoError(t, err)
+	assert.Equal(t, "claude-opus-4.5", got)
+}
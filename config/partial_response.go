@@ -0,0 +1,7 @@
+package config
+
+// IsPartialResponseOnErrorEnabled 非流式请求读取上游响应中途出错时，是否返回已收到的部分内容而不是5xx
+// 通过环境变量 PARTIAL_RESPONSE_ON_ERROR 配置，默认关闭
+func IsPartialResponseOnErrorEnabled() bool {
+	return boolEnv("PARTIAL_RESPONSE_ON_ERROR")
+}
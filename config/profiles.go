@@ -0,0 +1,67 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// ProfileHeader 管理员指定本次请求使用的 CodeWhisperer profile 的请求头，取值为账号 profiles 中的名称
+const ProfileHeader = "X-Kiro-Profile"
+
+// Profile 按模型把请求路由到各账号中同名的 CodeWhisperer AI profile（不同的模型档位或定制）
+// ARN 属于具体账号，在账号配置的 profiles 中按名称配置
+type Profile struct {
+	Name string `json:"name"`
+	// Models 使用该 profile 的模型名，支持 path.Match 通配符（如 claude-opus-*）
+	Models []string `json:"models"`
+}
+
+// LoadProfiles 解析环境变量 KIRO_PROFILES（JSON数组，如 [{"name":"opus","models":["claude-opus-*"]}]），未配置时返回nil
+// 配置无效时返回错误，启动失败
+func LoadProfiles() ([]Profile, error) {
+	return ParseProfiles(os.Getenv("KIRO_PROFILES"))
+}
+
+// ParseProfiles 解析并校验 profile 配置：名称不能为空且不能重复，models 不能为空且通配符必须合法
+// 不认识的字段（如旧格式的 arn）视为配置错误，避免按账号配置 ARN 后旧配置被静默忽略
+func ParseProfiles(raw string) ([]Profile, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+
+	var profiles []Profile
+	decoder := json.NewDecoder(strings.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&profiles); err != nil {
+		return nil, fmt.Errorf("KIRO_PROFILES 不是有效的JSON数组: %v", err)
+	}
+
+	seen := make(map[string]bool, len(profiles))
+	for i, profile := range profiles {
+		if strings.TrimSpace(profile.Name) == "" {
+			return nil, fmt.Errorf("KIRO_PROFILES[%d]: 名称不能为空", i)
+		}
+		if seen[profile.Name] {
+			return nil, fmt.Errorf("KIRO_PROFILES[%d]: 名称 %s 重复", i, profile.Name)
+		}
+		seen[profile.Name] = true
+		if len(profile.Models) == 0 {
+			return nil, fmt.Errorf("KIRO_PROFILES[%s]: models 不能为空", profile.Name)
+		}
+		for _, pattern := range profile.Models {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("KIRO_PROFILES[%s]: 模型通配符 %q 无效: %v", profile.Name, pattern, err)
+			}
+		}
+	}
+	return profiles, nil
+}
+
+// IsRefreshProfileArnEnabled 账号未配置 profileArn 时是否发送刷新 token 响应中的 profileArn
+// 可通过环境变量 SEND_REFRESH_PROFILE_ARN 开启，默认关闭（请求不携带 profileArn）
+func IsRefreshProfileArnEnabled() bool {
+	return boolEnv("SEND_REFRESH_PROFILE_ARN")
+}
@@ -0,0 +1,35 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseProfiles(t *testing.T) {
+	profiles, err := ParseProfiles("")
+	require.NoError(t, err)
+	assert.Nil(t, profiles)
+
+	profiles, err = ParseProfiles(`[{"name":"opus","models":["claude-opus-*"]},{"name":"sonnet","models":["claude-sonnet-*","claude-3-7-*"]}]`)
+	require.NoError(t, err)
+	require.Len(t, profiles, 2)
+	assert.Equal(t, []string{"claude-opus-*"}, profiles[0].Models)
+	assert.Equal(t, "sonnet", profiles[1].Name)
+
+	invalid := map[string]string{
+		"不是JSON数组":  `{"name":"opus"}`,
+		"名称为空":      `[{"name":" ","models":["claude-*"]}]`,
+		"名称重复":      `[{"name":"a","models":["claude-*"]},{"name":"a","models":["gpt-*"]}]`,
+		"models为空":  `[{"name":"a"}]`,
+		"旧格式的arn字段": `[{"name":"a","arn":"arn:aws:codewhisperer:us-east-1:123:profile/A","models":["claude-*"]}]`,
+		"通配符无效":     `[{"name":"a","models":["claude-["]}]`,
+	}
+	for name, raw := range invalid {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseProfiles(raw)
+			assert.ErrorContains(t, err, "KIRO_PROFILES")
+		})
+	}
+}
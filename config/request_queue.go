@@ -0,0 +1,30 @@
+package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxConcurrentRequests 同时转发给上游的最大请求数，超出的请求按优先级通道排队
+// 通过环境变量 MAX_CONCURRENT_REQUESTS 配置，默认0表示不限制
+func MaxConcurrentRequests() int {
+	return positiveIntEnv("MAX_CONCURRENT_REQUESTS", 0)
+}
+
+// MaxLowPriorityRPS 低优先级通道（长请求）每秒最多放行的请求数
+// 通过环境变量 MAX_LOW_PRIORITY_RPS 配置，支持小数（如 0.5 表示每2秒一个），默认0表示不限制
+func MaxLowPriorityRPS() float64 {
+	rps, err := strconv.ParseFloat(strings.TrimSpace(os.Getenv("MAX_LOW_PRIORITY_RPS")), 64)
+	if err != nil || rps <= 0 {
+		return 0
+	}
+	return rps
+}
+
+// RequestQueueTimeout 请求在优先级队列中等待的最长时间，超时返回503
+// 通过环境变量 REQUEST_QUEUE_TIMEOUT（Go duration 格式，如 "30s"）配置，默认 DefaultRequestQueueTimeout，0表示一直等待到客户端断开
+func RequestQueueTimeout() time.Duration {
+	return nonNegativeDurationEnv("REQUEST_QUEUE_TIMEOUT", DefaultRequestQueueTimeout)
+}
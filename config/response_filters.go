@@ -0,0 +1,77 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// 响应过滤规则的作用范围，对应 RESPONSE_FILTERS 中的 applies_to
+const (
+	ResponseFilterText     = "text"
+	ResponseFilterToolArgs = "tool_args"
+	ResponseFilterBoth     = "both"
+)
+
+// ResponseFilterRule RESPONSE_FILTERS 中的一条规则，replacement 支持 $1 等分组引用
+type ResponseFilterRule struct {
+	Pattern     string `json:"pattern"`
+	Replacement string `json:"replacement"`
+	AppliesTo   string `json:"applies_to"`
+}
+
+// ResponseFilter 编译后的过滤规则
+type ResponseFilter struct {
+	Regexp      *regexp.Regexp
+	Replacement string
+	Text        bool // 作用于文本内容
+	ToolArgs    bool // 作用于工具参数JSON
+	// Window 跨增量匹配时需要暂存的字符数，取正则源码长度
+	Window int
+}
+
+// LoadResponseFilters 解析并编译环境变量 RESPONSE_FILTERS（JSON数组），未配置时返回nil
+// 任一正则无效时返回错误，启动失败
+func LoadResponseFilters() ([]ResponseFilter, error) {
+	return ParseResponseFilters(os.Getenv("RESPONSE_FILTERS"))
+}
+
+// ParseResponseFilters 解析并编译响应过滤规则，applies_to 为空时视为 both
+func ParseResponseFilters(raw string) ([]ResponseFilter, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+
+	var rules []ResponseFilterRule
+	if err := json.Unmarshal([]byte(raw), &rules); err != nil {
+		return nil, fmt.Errorf("RESPONSE_FILTERS 不是有效的JSON数组: %v", err)
+	}
+
+	filters := make([]ResponseFilter, 0, len(rules))
+	for i, rule := range rules {
+		if rule.Pattern == "" {
+			return nil, fmt.Errorf("RESPONSE_FILTERS[%d]: pattern 不能为空", i)
+		}
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("RESPONSE_FILTERS[%d]: 无效的正则 %q: %v", i, rule.Pattern, err)
+		}
+
+		filter := ResponseFilter{Regexp: re, Replacement: rule.Replacement, Window: utf8.RuneCountInString(rule.Pattern)}
+		switch strings.ToLower(strings.TrimSpace(rule.AppliesTo)) {
+		case ResponseFilterText:
+			filter.Text = true
+		case ResponseFilterToolArgs:
+			filter.ToolArgs = true
+		case ResponseFilterBoth, "":
+			filter.Text, filter.ToolArgs = true, true
+		default:
+			return nil, fmt.Errorf("RESPONSE_FILTERS[%d]: applies_to 只能是 text、tool_args 或 both，实际为 %q", i, rule.AppliesTo)
+		}
+		filters = append(filters, filter)
+	}
+	return filters, nil
+}
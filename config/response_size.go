@@ -0,0 +1,10 @@
+package config
+
+// ResponseSizeTrailer 客户端声明 TE: trailers 时，流式响应结束后通过该 trailer 返回发送的总字节数
+const ResponseSizeTrailer = "X-Response-Size"
+
+// LargeResponseWarningBytes 流式响应发送给客户端的字节数超过该值时输出一条警告日志
+// 可通过环境变量 LARGE_RESPONSE_WARNING_BYTES 配置，默认1MB
+func LargeResponseWarningBytes() int {
+	return positiveIntEnv("LARGE_RESPONSE_WARNING_BYTES", DefaultLargeResponseWarningBytes)
+}
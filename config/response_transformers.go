@@ -0,0 +1,19 @@
+package config
+
+import (
+	"os"
+	"strings"
+)
+
+// ResponseTransformers 下发 Anthropic 响应前依次应用的转换器名称
+// 通过环境变量 RESPONSE_TRANSFORMERS 配置（逗号分隔，按顺序应用，如 "strip_thinking,normalise_stop_reason"），默认为空
+func ResponseTransformers() []string {
+	var names []string
+	for _, part := range strings.Split(os.Getenv("RESPONSE_TRANSFORMERS"), ",") {
+		name := strings.ToLower(strings.TrimSpace(part))
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
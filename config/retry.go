@@ -0,0 +1,24 @@
+package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var maxRetryDelayEnv = "MAX_RETRY_DELAY"
+
+// MaxRetryDelay 单次重试等待时间上限
+// 可通过环境变量 MAX_RETRY_DELAY（秒）配置，默认30秒；每次调用实时读取，支持热更新
+func MaxRetryDelay() time.Duration {
+	value := strings.TrimSpace(os.Getenv(maxRetryDelayEnv))
+	if value == "" {
+		return DefaultMaxRetryDelay
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds <= 0 {
+		return DefaultMaxRetryDelay
+	}
+	return time.Duration(seconds) * time.Second
+}
@@ -0,0 +1,33 @@
+package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// IsCheckOnlyEnabled 是否只执行启动自检而不提供服务（等同于命令行参数 --check）
+// 通过环境变量 CHECK_ONLY 配置，默认关闭
+func IsCheckOnlyEnabled() bool {
+	return boolEnv("CHECK_ONLY")
+}
+
+// CheckRefreshTimeout 自检时单个token刷新的超时
+// 通过环境变量 CHECK_REFRESH_TIMEOUT（秒）配置，默认10秒
+func CheckRefreshTimeout() time.Duration {
+	seconds, err := strconv.Atoi(strings.TrimSpace(os.Getenv("CHECK_REFRESH_TIMEOUT")))
+	if err != nil || seconds <= 0 {
+		return DefaultCheckRefreshTimeout
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// CheckProbeModel 自检 --probe 发送探测请求使用的模型
+// 通过环境变量 CHECK_PROBE_MODEL 配置，默认 claude-haiku-4.5
+func CheckProbeModel() string {
+	if model := strings.TrimSpace(os.Getenv("CHECK_PROBE_MODEL")); model != "" {
+		return model
+	}
+	return DefaultCheckProbeModel
+}
@@ -0,0 +1,174 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// 配置项默认值
+const (
+	// DefaultLogLevel 未设置 LOG_LEVEL 时的日志级别
+	DefaultLogLevel = "info"
+	// DefaultMaxToolDescriptionLength 未设置 MAX_TOOL_DESCRIPTION_LENGTH 时工具描述的最大长度（字符数）
+	DefaultMaxToolDescriptionLength = 10000
+)
+
+// Settings 启动时从环境变量（含 data/system_config.json 应用到环境变量的值）加载的配置快照
+// 只包含标量字段，Get 返回的副本可以随意修改，不影响其他读取者
+type Settings struct {
+	// Port 监听端口（PORT），为空时使用命令行参数或 8080
+	Port string
+	// LogLevel 日志级别（LOG_LEVEL）：debug/info/warn/error/fatal，默认 info
+	LogLevel string
+	// StealthMode 隐身模式（STEALTH_MODE）：1/true/yes/on 开启，默认关闭
+	StealthMode bool
+	// HeaderStrategy 请求头策略（HEADER_STRATEGY）：real_simulation/random，
+	// 未设置时隐身模式下为 random，否则为 real_simulation
+	HeaderStrategy string
+	// HTTP2Mode 上游HTTP/2模式（STEALTH_HTTP2_MODE）：auto/force/disable，默认 auto
+	HTTP2Mode string
+	// TokenCacheTTL token缓存与使用限制检查的有效期（TOKEN_CACHE_TTL，Go duration），默认 5m
+	TokenCacheTTL time.Duration
+	// MaxToolDescriptionLength 工具描述的最大长度（MAX_TOOL_DESCRIPTION_LENGTH，字符数），默认 10000
+	MaxToolDescriptionLength int
+}
+
+// 配置项对应的环境变量
+const (
+	portEnv                     = "PORT"
+	logLevelEnv                 = "LOG_LEVEL"
+	stealthModeEnv              = "STEALTH_MODE"
+	headerStrategyEnv           = "HEADER_STRATEGY"
+	http2ModeEnv                = "STEALTH_HTTP2_MODE"
+	tokenCacheTTLEnv            = "TOKEN_CACHE_TTL"
+	maxToolDescriptionLengthEnv = "MAX_TOOL_DESCRIPTION_LENGTH"
+)
+
+// logLevels LOG_LEVEL 允许的取值（与 logger.ParseLevel 一致）
+var logLevels = map[string]bool{"debug": true, "info": true, "warn": true, "warning": true, "error": true, "fatal": true}
+
+// activeSettings 当前生效的配置快照
+var activeSettings atomic.Pointer[Settings]
+
+// Get 返回当前配置快照
+// 尚未调用 LoadSettings 时按环境变量初始化，取值无效的配置项使用默认值
+func Get() Settings {
+	if s := activeSettings.Load(); s != nil {
+		return *s
+	}
+	s, _ := ParseSettings(os.LookupEnv)
+	activeSettings.CompareAndSwap(nil, &s)
+	return *activeSettings.Load()
+}
+
+// LoadSettings 从环境变量重新加载配置，所有配置项都有效时替换当前快照
+// 任一配置项无效时返回汇总了全部错误的 error，当前快照保持不变
+func LoadSettings() error {
+	s, err := ParseSettings(os.LookupEnv)
+	if err != nil {
+		return err
+	}
+	activeSettings.Store(&s)
+	return nil
+}
+
+// SetLogLevel 运行时调整日志级别后同步配置快照，使 /admin/config 与实际生效的级别一致
+func SetLogLevel(level string) {
+	Get() // 确保快照已初始化
+	for {
+		current := activeSettings.Load()
+		next := *current
+		next.LogLevel = level
+		if activeSettings.CompareAndSwap(current, &next) {
+			return
+		}
+	}
+}
+
+// SwapSettings 替换当前配置快照并返回原快照（尚未初始化时为nil），供 configtest 在测试中替换和恢复配置
+func SwapSettings(s *Settings) *Settings {
+	return activeSettings.Swap(s)
+}
+
+// ParseSettings 按 lookup 读取并校验配置，无效的配置项使用默认值，所有错误汇总后返回
+func ParseSettings(lookup func(key string) (string, bool)) (Settings, error) {
+	s := Settings{
+		LogLevel:                 DefaultLogLevel,
+		HTTP2Mode:                http2ModeAuto,
+		TokenCacheTTL:            DefaultTokenCacheTTL,
+		MaxToolDescriptionLength: DefaultMaxToolDescriptionLength,
+	}
+	var errs []error
+	value := func(key string) string {
+		v, _ := lookup(key)
+		return strings.TrimSpace(v)
+	}
+
+	if port := value(portEnv); port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+			errs = append(errs, fmt.Errorf("%s 必须是 1-65535 之间的端口号: %q", portEnv, port))
+		} else {
+			s.Port = port
+		}
+	}
+
+	if level := strings.ToLower(value(logLevelEnv)); level != "" {
+		if !logLevels[level] {
+			errs = append(errs, fmt.Errorf("%s 必须是 debug/info/warn/error/fatal 之一: %q", logLevelEnv, level))
+		} else {
+			s.LogLevel = level
+		}
+	}
+
+	switch mode := strings.ToLower(value(stealthModeEnv)); {
+	case isTrueValue(mode):
+		s.StealthMode = true
+	case mode == "", mode == "0", mode == "false", mode == "no", mode == "off":
+	default:
+		errs = append(errs, fmt.Errorf("%s 必须是布尔值: %q", stealthModeEnv, mode))
+	}
+
+	switch strategy := strings.ToLower(value(headerStrategyEnv)); strategy {
+	case HeaderStrategyRandom, HeaderStrategyRealSimulation:
+		s.HeaderStrategy = strategy
+	default:
+		if strategy != "" {
+			errs = append(errs, fmt.Errorf("%s 必须是 %s 或 %s: %q", headerStrategyEnv, HeaderStrategyRealSimulation, HeaderStrategyRandom, strategy))
+		}
+		s.HeaderStrategy = HeaderStrategyRealSimulation
+		if s.StealthMode {
+			s.HeaderStrategy = HeaderStrategyRandom
+		}
+	}
+
+	switch mode := strings.ToLower(value(http2ModeEnv)); mode {
+	case http2ModeAuto, http2ModeForce, http2ModeDisable:
+		s.HTTP2Mode = mode
+	case "":
+	default:
+		errs = append(errs, fmt.Errorf("%s 必须是 auto/force/disable 之一: %q", http2ModeEnv, mode))
+	}
+
+	if ttl := value(tokenCacheTTLEnv); ttl != "" {
+		if d, err := time.ParseDuration(ttl); err != nil || d <= 0 {
+			errs = append(errs, fmt.Errorf("%s 必须是正的时长（如 5m）: %q", tokenCacheTTLEnv, ttl))
+		} else {
+			s.TokenCacheTTL = d
+		}
+	}
+
+	if length := value(maxToolDescriptionLengthEnv); length != "" {
+		if n, err := strconv.Atoi(length); err != nil || n <= 0 {
+			errs = append(errs, fmt.Errorf("%s 必须是正整数: %q", maxToolDescriptionLengthEnv, length))
+		} else {
+			s.MaxToolDescriptionLength = n
+		}
+	}
+
+	return s, errors.Join(errs...)
+}
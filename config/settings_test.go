@@ -0,0 +1,144 @@
+package config
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// lookupFrom 以 map 作为环境变量来源
+func lookupFrom(env map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+}
+
+func TestParseSettings_Defaults(t *testing.T) {
+	s, err := ParseSettings(lookupFrom(nil))
+	require.NoError(t, err)
+	assert.Equal(t, Settings{
+		LogLevel:                 DefaultLogLevel,
+		HeaderStrategy:           HeaderStrategyRealSimulation,
+		HTTP2Mode:                HTTP2ModeAuto,
+		TokenCacheTTL:            DefaultTokenCacheTTL,
+		MaxToolDescriptionLength: DefaultMaxToolDescriptionLength,
+	}, s)
+
+	// 隐身模式下未设置请求头策略时使用随机策略
+	s, err = ParseSettings(lookupFrom(map[string]string{"STEALTH_MODE": "on"}))
+	require.NoError(t, err)
+	assert.True(t, s.StealthMode)
+	assert.Equal(t, HeaderStrategyRandom, s.HeaderStrategy)
+}
+
+func TestParseSettings_Values(t *testing.T) {
+	s, err := ParseSettings(lookupFrom(map[string]string{
+		"PORT":                        "9090",
+		"LOG_LEVEL":                   "DEBUG",
+		"STEALTH_MODE":                "true",
+		"HEADER_STRATEGY":             "real_simulation",
+		"STEALTH_HTTP2_MODE":          "force",
+		"TOKEN_CACHE_TTL":             "90s",
+		"MAX_TOOL_DESCRIPTION_LENGTH": "2048",
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, Settings{
+		Port:                     "9090",
+		LogLevel:                 "debug",
+		StealthMode:              true,
+		HeaderStrategy:           HeaderStrategyRealSimulation,
+		HTTP2Mode:                HTTP2ModeForce,
+		TokenCacheTTL:            90 * time.Second,
+		MaxToolDescriptionLength: 2048,
+	}, s)
+}
+
+func TestParseSettings_AggregatesErrors(t *testing.T) {
+	env := map[string]string{
+		"PORT":                        "70000",
+		"LOG_LEVEL":                   "verbose",
+		"STEALTH_MODE":                "maybe",
+		"HEADER_STRATEGY":             "chrome",
+		"STEALTH_HTTP2_MODE":          "h3",
+		"TOKEN_CACHE_TTL":             "5",
+		"MAX_TOOL_DESCRIPTION_LENGTH": "-1",
+	}
+	s, err := ParseSettings(lookupFrom(env))
+	require.Error(t, err)
+	for key := range env {
+		assert.ErrorContains(t, err, key)
+	}
+
+	// 无效的配置项使用默认值
+	assert.Equal(t, Settings{
+		LogLevel:                 DefaultLogLevel,
+		HeaderStrategy:           HeaderStrategyRealSimulation,
+		HTTP2Mode:                HTTP2ModeAuto,
+		TokenCacheTTL:            DefaultTokenCacheTTL,
+		MaxToolDescriptionLength: DefaultMaxToolDescriptionLength,
+	}, s)
+}
+
+func TestLoadSettings_KeepsSnapshotOnError(t *testing.T) {
+	t.Setenv("TOKEN_CACHE_TTL", "2m")
+	require.NoError(t, LoadSettings())
+	t.Cleanup(func() { activeSettings.Store(nil) })
+
+	t.Setenv("TOKEN_CACHE_TTL", "soon")
+	assert.ErrorContains(t, LoadSettings(), "TOKEN_CACHE_TTL")
+	assert.Equal(t, 2*time.Minute, Get().TokenCacheTTL)
+}
+
+func TestGet_SnapshotImmutable(t *testing.T) {
+	// configtest 依赖本包，这里直接替换快照
+	snapshot := Get()
+	snapshot.MaxToolDescriptionLength = 100
+	previous := SwapSettings(&snapshot)
+	t.Cleanup(func() { SwapSettings(previous) })
+
+	// 修改 Get 返回的副本不影响快照
+	s := Get()
+	s.MaxToolDescriptionLength = 1
+	assert.Equal(t, 100, Get().MaxToolDescriptionLength)
+
+	// 并发读取时重新加载，读取者只会看到完整的旧快照或新快照
+	t.Setenv("MAX_TOOL_DESCRIPTION_LENGTH", "200")
+	t.Setenv("TOKEN_CACHE_TTL", "2m")
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				s := Get()
+				if s.MaxToolDescriptionLength == 100 {
+					assert.Equal(t, DefaultTokenCacheTTL, s.TokenCacheTTL)
+				} else {
+					assert.Equal(t, 200, s.MaxToolDescriptionLength)
+					assert.Equal(t, 2*time.Minute, s.TokenCacheTTL)
+				}
+				s.MaxToolDescriptionLength = j
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		require.NoError(t, LoadSettings())
+	}
+	wg.Wait()
+	assert.Equal(t, 200, Get().MaxToolDescriptionLength)
+}
+
+func TestSetLogLevel_UpdatesSnapshot(t *testing.T) {
+	t.Setenv("LOG_LEVEL", "info")
+	t.Setenv("MAX_TOOL_DESCRIPTION_LENGTH", "300")
+	require.NoError(t, LoadSettings())
+	t.Cleanup(func() { activeSettings.Store(nil) })
+
+	SetLogLevel("debug")
+	assert.Equal(t, "debug", Get().LogLevel)
+	assert.Equal(t, 300, Get().MaxToolDescriptionLength, "其他配置项不变")
+}
@@ -1,10 +1,5 @@
 package config
 
-import (
-	"os"
-	"strings"
-)
-
 const (
 	HeaderStrategyRealSimulation = "real_simulation"
 	HeaderStrategyRandom         = "random"
@@ -18,43 +13,28 @@ const (
 	HTTP2ModeDisable = http2ModeDisable
 )
 
-var (
-	stealthModeEnv    = "STEALTH_MODE"
-	headerStrategyEnv = "HEADER_STRATEGY"
-	http2ModeEnv      = "STEALTH_HTTP2_MODE"
+// 管理员调试用的单次请求头覆盖，只在携带有效管理员Token时生效
+const (
+	HeaderStrategyOverrideHeader = "X-Kiro-Header-Strategy"
+	AgentModeOverrideHeader      = "X-Kiro-Agent-Mode"
+
+	// 覆盖取值，也是完成日志中 header_strategy 字段的取值
+	HeaderOverrideKiro   = "kiro"   // Kiro IDE 画像（real_simulation）
+	HeaderOverrideRandom = "random" // 随机画像
+	HeaderOverrideLegacy = "legacy" // 未开启伪装时的固定请求头
 )
 
+// IsStealthModeEnabled 是否启用隐身模式（STEALTH_MODE）
 func IsStealthModeEnabled() bool {
-	value := strings.ToLower(strings.TrimSpace(os.Getenv(stealthModeEnv)))
-	switch value {
-	case "1", "true", "yes", "on":
-		return true
-	default:
-		return false
-	}
+	return Get().StealthMode
 }
 
+// ActiveHeaderStrategy 当前的请求头策略（HEADER_STRATEGY）
 func ActiveHeaderStrategy() string {
-	strategy := strings.ToLower(strings.TrimSpace(os.Getenv(headerStrategyEnv)))
-	switch strategy {
-	case HeaderStrategyRandom:
-		return HeaderStrategyRandom
-	case HeaderStrategyRealSimulation:
-		return HeaderStrategyRealSimulation
-	default:
-		if IsStealthModeEnabled() {
-			return HeaderStrategyRandom
-		}
-		return HeaderStrategyRealSimulation
-	}
+	return Get().HeaderStrategy
 }
 
+// HTTP2Mode 上游HTTP/2模式（STEALTH_HTTP2_MODE）
 func HTTP2Mode() string {
-	mode := strings.ToLower(strings.TrimSpace(os.Getenv(http2ModeEnv)))
-	switch mode {
-	case http2ModeForce, http2ModeDisable:
-		return mode
-	default:
-		return http2ModeAuto
-	}
+	return Get().HTTP2Mode
 }
@@ -0,0 +1,52 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// StopReasons Claude 规范中的 stop_reason 取值
+var StopReasons = []string{"end_turn", "max_tokens", "stop_sequence", "tool_use", "pause_turn", "refusal"}
+
+// IsValidStopReason 是否为 Claude 规范中的 stop_reason
+func IsValidStopReason(reason string) bool {
+	for _, r := range StopReasons {
+		if r == reason {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadStopReasonOverrides 解析环境变量 STOP_REASON_OVERRIDES（JSON对象，原 stop_reason -> 替换后的值，
+// 如 {"tool_use":"end_turn"}），未配置时返回nil；配置无效时返回错误，启动失败
+func LoadStopReasonOverrides() (map[string]string, error) {
+	return ParseStopReasonOverrides(os.Getenv("STOP_REASON_OVERRIDES"))
+}
+
+// ParseStopReasonOverrides 解析并校验 stop_reason 替换规则：原值和替换值都必须是规范中的 stop_reason，且不能相同
+func ParseStopReasonOverrides(raw string) (map[string]string, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+
+	var overrides map[string]string
+	if err := json.Unmarshal([]byte(raw), &overrides); err != nil {
+		return nil, fmt.Errorf("STOP_REASON_OVERRIDES 不是有效的JSON对象: %v", err)
+	}
+
+	for from, to := range overrides {
+		if !IsValidStopReason(from) {
+			return nil, fmt.Errorf("STOP_REASON_OVERRIDES: 未知的 stop_reason %q", from)
+		}
+		if !IsValidStopReason(to) {
+			return nil, fmt.Errorf("STOP_REASON_OVERRIDES[%s]: 未知的 stop_reason %q", from, to)
+		}
+		if from == to {
+			return nil, fmt.Errorf("STOP_REASON_OVERRIDES[%s]: 不能替换为自身", from)
+		}
+	}
+	return overrides, nil
+}
@@ -0,0 +1,31 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseStopReasonOverrides(t *testing.T) {
+	overrides, err := ParseStopReasonOverrides("")
+	require.NoError(t, err)
+	assert.Nil(t, overrides)
+
+	overrides, err = ParseStopReasonOverrides(`{"tool_use":"end_turn","refusal":"end_turn"}`)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"tool_use": "end_turn", "refusal": "end_turn"}, overrides)
+
+	invalid := map[string]string{
+		"不是JSON对象": `["tool_use"]`,
+		"未知原值":     `{"tool_call":"end_turn"}`,
+		"未知替换值":    `{"tool_use":"stop"}`,
+		"替换为自身":    `{"end_turn":"end_turn"}`,
+	}
+	for name, raw := range invalid {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseStopReasonOverrides(raw)
+			assert.ErrorContains(t, err, "STOP_REASON_OVERRIDES")
+		})
+	}
+}
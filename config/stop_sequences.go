@@ -0,0 +1,23 @@
+package config
+
+import (
+	"os"
+	"strings"
+)
+
+// stop_sequences 的传递方式
+const (
+	// StopSequencesModeInjected 作为指令追加到系统提示开头（默认）
+	StopSequencesModeInjected = "injected"
+	// StopSequencesModeNative 写入 CodeWhisperer 请求的 inferenceConfig.stopSequences
+	StopSequencesModeNative = "native"
+)
+
+// StopSequencesMode stop_sequences 的传递方式
+// 通过环境变量 STOP_SEQUENCES_MODE 配置（native/injected），未设置或无法识别时为 injected
+func StopSequencesMode() string {
+	if strings.ToLower(strings.TrimSpace(os.Getenv("STOP_SEQUENCES_MODE"))) == StopSequencesModeNative {
+		return StopSequencesModeNative
+	}
+	return StopSequencesModeInjected
+}
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"os"
+	"strings"
+	"time"
+)
+
+// StrictSSEHeader 请求头，值为 1 或 true 时该请求以严格模式校验SSE事件序列，出现违规即终止流
+const StrictSSEHeader = "X-Kiro-Strict-SSE"
+
+// IsStrictSSERequested 判断 X-Kiro-Strict-SSE 请求头的值是否开启严格模式
+func IsStrictSSERequested(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "1", "true":
+		return true
+	default:
+		return false
+	}
+}
+
+// FlushBatchSize 流式响应每批刷新的SSE事件数
+// 可通过环境变量 FLUSH_BATCH_SIZE 配置，默认1（每个事件刷新一次），最大8
+func FlushBatchSize() int {
+	return min(positiveIntEnv("FLUSH_BATCH_SIZE", DefaultFlushBatchSize), MaxFlushBatchSize)
+}
+
+// FlushTimeout 批量未满时兜底刷新的等待时间
+// 可通过环境变量 FLUSH_TIMEOUT_MS（毫秒）配置，默认10ms
+func FlushTimeout() time.Duration {
+	ms := positiveIntEnv("FLUSH_TIMEOUT_MS", int(DefaultFlushTimeout/time.Millisecond))
+	return time.Duration(ms) * time.Millisecond
+}
+
+// StreamIdleTimeout 上游流没有产生事件的最长等待时间，超时后以 max_tokens 结束流
+// 可通过环境变量 STREAM_IDLE_TIMEOUT_SECONDS（秒）配置，默认120秒
+func StreamIdleTimeout() time.Duration {
+	seconds := positiveIntEnv("STREAM_IDLE_TIMEOUT_SECONDS", int(DefaultStreamIdleTimeout/time.Second))
+	return time.Duration(seconds) * time.Second
+}
+
+// IsSSEProxyPaddingEnabled 是否在SSE响应开头输出填充注释行，防止中间代理缓冲小响应
+// 通过环境变量 SSE_PROXY_PADDING 配置，默认关闭
+func IsSSEProxyPaddingEnabled() bool {
+	return boolEnv("SSE_PROXY_PADDING")
+}
+
+// IsSSEGzipEnabled 客户端声明 Accept-Encoding: gzip 时是否压缩SSE响应
+// 通过环境变量 SSE_GZIP 配置，默认开启；设为 0/false/no/off 关闭
+func IsSSEGzipEnabled() bool {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("SSE_GZIP"))) {
+	case "0", "false", "no", "off":
+		return false
+	default:
+		return true
+	}
+}
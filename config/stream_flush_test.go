@@ -0,0 +1,38 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlushBatchSize(t *testing.T) {
+	t.Setenv("FLUSH_BATCH_SIZE", "")
+	assert.Equal(t, 1, FlushBatchSize())
+
+	t.Setenv("FLUSH_BATCH_SIZE", "4")
+	assert.Equal(t, 4, FlushBatchSize())
+
+	t.Setenv("FLUSH_BATCH_SIZE", "32")
+	assert.Equal(t, MaxFlushBatchSize, FlushBatchSize())
+}
+
+func TestFlushTimeout(t *testing.T) {
+	t.Setenv("FLUSH_TIMEOUT_MS", "")
+	assert.Equal(t, DefaultFlushTimeout, FlushTimeout())
+
+	t.Setenv("FLUSH_TIMEOUT_MS", "25")
+	assert.Equal(t, 25*time.Millisecond, FlushTimeout())
+}
+
+func TestIsSSEProxyPaddingEnabled(t *testing.T) {
+	t.Setenv("SSE_PROXY_PADDING", "")
+	assert.False(t, IsSSEProxyPaddingEnabled())
+
+	t.Setenv("SSE_PROXY_PADDING", "true")
+	assert.True(t, IsSSEProxyPaddingEnabled())
+
+	t.Setenv("SSE_PROXY_PADDING", "off")
+	assert.False(t, IsSSEProxyPaddingEnabled())
+}
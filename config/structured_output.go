@@ -0,0 +1,15 @@
+package config
+
+import (
+	"os"
+	"strings"
+)
+
+// StructuredOutputInstruction 请求 json_object / json_schema 时附加给模型的输出约束说明（schema 由代理追加在其后）
+// 通过环境变量 STRUCTURED_OUTPUT_INSTRUCTION 配置，默认要求只输出一个不带代码块围栏的JSON值
+func StructuredOutputInstruction() string {
+	if instruction := strings.TrimSpace(os.Getenv("STRUCTURED_OUTPUT_INSTRUCTION")); instruction != "" {
+		return instruction
+	}
+	return DefaultStructuredOutputInstruction
+}
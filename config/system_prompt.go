@@ -0,0 +1,34 @@
+package config
+
+import "os"
+
+// NoInjectHeader 管理员调试用请求头，取值为 true 时本次请求不注入服务端系统提示
+const NoInjectHeader = "X-Kiro-No-Inject"
+
+// SystemPromptPrefix 注入到客户端系统提示之前的内容
+// 可通过环境变量 SYSTEM_PROMPT_PREFIX 配置，取值为已存在的文件路径时读取文件内容，默认为空
+func SystemPromptPrefix() string {
+	return textOrFileEnv("SYSTEM_PROMPT_PREFIX")
+}
+
+// SystemPromptSuffix 注入到客户端系统提示之后的内容
+// 可通过环境变量 SYSTEM_PROMPT_SUFFIX 配置，取值为已存在的文件路径时读取文件内容，默认为空
+func SystemPromptSuffix() string {
+	return textOrFileEnv("SYSTEM_PROMPT_SUFFIX")
+}
+
+// textOrFileEnv 读取环境变量；取值是普通文件的路径时返回文件内容，读取失败时返回空
+func textOrFileEnv(key string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return ""
+	}
+	if info, err := os.Stat(value); err == nil && info.Mode().IsRegular() {
+		data, err := os.ReadFile(value)
+		if err != nil {
+			return ""
+		}
+		return string(data)
+	}
+	return value
+}
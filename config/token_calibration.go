@@ -0,0 +1,86 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// 工具数量分档，与 TokenEstimator 的工具开销策略一致
+const (
+	ToolCountTierSingle = "1"   // 单工具
+	ToolCountTierFew    = "2-5" // 少量工具
+	ToolCountTierMany   = "6+"  // 大量工具
+)
+
+// TokenCalibration token估算校准参数
+// 所有倍率未设置（0）时视为1，即保持默认估算结果
+type TokenCalibration struct {
+	// GlobalMultiplier 作用于最终估算总数
+	GlobalMultiplier float64 `json:"global_multiplier,omitempty"`
+	// TextMultiplier 作用于每段文本的估算结果（系统提示、消息、工具描述）
+	TextMultiplier float64 `json:"text_multiplier,omitempty"`
+	// ToolCountMultipliers 按工具数量分档作用于工具定义部分（键为 "1"/"2-5"/"6+"）
+	ToolCountMultipliers map[string]float64 `json:"tool_count_multipliers,omitempty"`
+	// SchemaCharsPerToken 按工具数量分档覆盖 schema 编码密度（字符/token）
+	SchemaCharsPerToken map[string]float64 `json:"schema_chars_per_token,omitempty"`
+}
+
+// ToolCountTier 返回工具数量所属分档
+func ToolCountTier(toolCount int) string {
+	if toolCount <= 1 {
+		return ToolCountTierSingle
+	}
+	if toolCount <= 5 {
+		return ToolCountTierFew
+	}
+	return ToolCountTierMany
+}
+
+// ParseTokenCalibration 解析 JSON 格式的校准参数，空字符串返回默认值
+func ParseTokenCalibration(raw string) (TokenCalibration, error) {
+	var calibration TokenCalibration
+	if strings.TrimSpace(raw) == "" {
+		return calibration, nil
+	}
+	if err := json.Unmarshal([]byte(raw), &calibration); err != nil {
+		return TokenCalibration{}, fmt.Errorf("解析token估算校准参数失败: %v", err)
+	}
+
+	if calibration.GlobalMultiplier < 0 || calibration.TextMultiplier < 0 {
+		return TokenCalibration{}, fmt.Errorf("token估算倍率不能为负数")
+	}
+	for tier, value := range calibration.ToolCountMultipliers {
+		if !isToolCountTier(tier) {
+			return TokenCalibration{}, fmt.Errorf("未知的工具数量分档: %s", tier)
+		}
+		if value < 0 {
+			return TokenCalibration{}, fmt.Errorf("工具数量分档 %s 的倍率不能为负数", tier)
+		}
+	}
+	for tier, value := range calibration.SchemaCharsPerToken {
+		if !isToolCountTier(tier) {
+			return TokenCalibration{}, fmt.Errorf("未知的工具数量分档: %s", tier)
+		}
+		if value <= 0 {
+			return TokenCalibration{}, fmt.Errorf("工具数量分档 %s 的schema编码密度必须大于0", tier)
+		}
+	}
+
+	return calibration, nil
+}
+
+// LoadTokenCalibration 从环境变量 TOKEN_ESTIMATOR_CALIBRATION 读取校准参数
+// 示例: {"global_multiplier":1.05,"text_multiplier":0.9,"tool_count_multipliers":{"6+":1.2},"schema_chars_per_token":{"1":2.0}}
+func LoadTokenCalibration() (TokenCalibration, error) {
+	return ParseTokenCalibration(os.Getenv("TOKEN_ESTIMATOR_CALIBRATION"))
+}
+
+func isToolCountTier(tier string) bool {
+	switch tier {
+	case ToolCountTierSingle, ToolCountTierFew, ToolCountTierMany:
+		return true
+	}
+	return false
+}
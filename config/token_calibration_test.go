@@ -0,0 +1,49 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTokenCalibration_Empty(t *testing.T) {
+	calibration, err := ParseTokenCalibration("")
+	require.NoError(t, err)
+	assert.Equal(t, TokenCalibration{}, calibration)
+}
+
+func TestParseTokenCalibration_Valid(t *testing.T) {
+	calibration, err := ParseTokenCalibration(`{"global_multiplier":1.05,"text_multiplier":0.9,"tool_count_multipliers":{"6+":1.2},"schema_chars_per_token":{"1":2.0}}`)
+	require.NoError(t, err)
+	assert.Equal(t, 1.05, calibration.GlobalMultiplier)
+	assert.Equal(t, 0.9, calibration.TextMultiplier)
+	assert.Equal(t, 1.2, calibration.ToolCountMultipliers[ToolCountTierMany])
+	assert.Equal(t, 2.0, calibration.SchemaCharsPerToken[ToolCountTierSingle])
+}
+
+func TestParseTokenCalibration_Invalid(t *testing.T) {
+	for _, raw := range []string{
+		`not-json`,
+		`{"global_multiplier":-1}`,
+		`{"tool_count_multipliers":{"3":1.2}}`,
+		`{"schema_chars_per_token":{"2-5":0}}`,
+	} {
+		_, err := ParseTokenCalibration(raw)
+		assert.Error(t, err, raw)
+	}
+}
+
+func TestLoadTokenCalibration_FromEnv(t *testing.T) {
+	t.Setenv("TOKEN_ESTIMATOR_CALIBRATION", `{"text_multiplier":1.1}`)
+	calibration, err := LoadTokenCalibration()
+	require.NoError(t, err)
+	assert.Equal(t, 1.1, calibration.TextMultiplier)
+}
+
+func TestToolCountTier(t *testing.T) {
+	assert.Equal(t, ToolCountTierSingle, ToolCountTier(1))
+	assert.Equal(t, ToolCountTierFew, ToolCountTier(2))
+	assert.Equal(t, ToolCountTierFew, ToolCountTier(5))
+	assert.Equal(t, ToolCountTierMany, ToolCountTier(6))
+}
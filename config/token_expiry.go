@@ -0,0 +1,44 @@
+package config
+
+import (
+	"os"
+	"strings"
+	"time"
+)
+
+// TokenExpiryMargin 判断token是否可用时提前视为过期的余量
+// 可通过环境变量 TOKEN_EXPIRY_MARGIN（Go duration 格式，如 "2m"，"0s" 表示不预留）配置，默认2分钟
+func TokenExpiryMargin() time.Duration {
+	return nonNegativeDurationEnv("TOKEN_EXPIRY_MARGIN", DefaultTokenExpiryMargin)
+}
+
+// ClockSkewWarningThreshold 刷新响应 Date 头与本机时间的偏差超过该值时输出警告
+// 可通过环境变量 CLOCK_SKEW_WARNING_THRESHOLD（Go duration 格式）配置，默认30秒
+func ClockSkewWarningThreshold() time.Duration {
+	return nonNegativeDurationEnv("CLOCK_SKEW_WARNING_THRESHOLD", DefaultClockSkewWarningThreshold)
+}
+
+// nonNegativeDurationEnv 读取 Go duration 格式的环境变量，未设置、格式无效或为负时返回默认值
+func nonNegativeDurationEnv(key string, defaultValue time.Duration) time.Duration {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return defaultValue
+	}
+	return d
+}
+
+// TokenWaitTimeout 没有可用token时请求排队等待的最长时间
+// 可通过环境变量 TOKEN_WAIT_TIMEOUT（Go duration 格式，如 "5s"）配置，默认0表示不等待、立即失败
+func TokenWaitTimeout() time.Duration {
+	return nonNegativeDurationEnv("TOKEN_WAIT_TIMEOUT", 0)
+}
+
+// TokenWaitQueueSize 同时排队等待token的最大请求数
+// 可通过环境变量 TOKEN_WAIT_QUEUE_SIZE 配置，默认64
+func TokenWaitQueueSize() int {
+	return positiveIntEnv("TOKEN_WAIT_QUEUE_SIZE", DefaultTokenWaitQueueSize)
+}
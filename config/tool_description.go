@@ -0,0 +1,6 @@
+package config
+
+// IsToolDescriptionEnhancementEnabled 是否为描述过短的工具按 input_schema 生成描述（ENHANCE_TOOL_DESCRIPTIONS=true）
+func IsToolDescriptionEnhancementEnabled() bool {
+	return boolEnv("ENHANCE_TOOL_DESCRIPTIONS")
+}
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ToolFilterFile 工具过滤配置的持久化文件路径，通过管理接口修改后写入，启动时优先于环境变量加载
+// 通过环境变量 TOOL_FILTER_FILE 配置，默认 data/tool_filter.json
+func ToolFilterFile() string {
+	if file := strings.TrimSpace(os.Getenv("TOOL_FILTER_FILE")); file != "" {
+		return file
+	}
+	return DefaultToolFilterFile
+}
+
+// ToolFilter 工具黑白名单，条目支持 path.Match 通配符（如 *_search）
+// 白名单非空时只保留匹配白名单的工具；黑名单优先于白名单
+type ToolFilter struct {
+	Blacklist []string `json:"blacklist"`
+	Whitelist []string `json:"whitelist"`
+}
+
+// Validate 检查通配符语法
+func (f ToolFilter) Validate() error {
+	for _, pattern := range append(append([]string(nil), f.Blacklist...), f.Whitelist...) {
+		if strings.TrimSpace(pattern) == "" {
+			return fmt.Errorf("工具过滤规则不能为空")
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("无效的工具过滤规则 %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Allows 判断工具是否保留
+func (f ToolFilter) Allows(name string) bool {
+	if matchToolPattern(f.Blacklist, name) {
+		return false
+	}
+	return len(f.Whitelist) == 0 || matchToolPattern(f.Whitelist, name)
+}
+
+// IsEmpty 没有任何规则时不过滤
+func (f ToolFilter) IsEmpty() bool {
+	return len(f.Blacklist) == 0 && len(f.Whitelist) == 0
+}
+
+func matchToolPattern(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadToolFilter 加载工具过滤配置：持久化文件存在时使用文件内容，
+// 否则读取环境变量 TOOL_BLACKLIST / TOOL_WHITELIST（逗号分隔）
+func LoadToolFilter() (ToolFilter, error) {
+	data, err := os.ReadFile(ToolFilterFile())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return ToolFilter{}, fmt.Errorf("读取工具过滤配置失败: %v", err)
+	}
+
+	var filter ToolFilter
+	if err == nil {
+		if err := json.Unmarshal(data, &filter); err != nil {
+			return ToolFilter{}, fmt.Errorf("解析工具过滤配置失败: %v", err)
+		}
+	} else {
+		filter = ToolFilter{
+			Blacklist: splitToolPatterns(os.Getenv("TOOL_BLACKLIST")),
+			Whitelist: splitToolPatterns(os.Getenv("TOOL_WHITELIST")),
+		}
+	}
+
+	if err := filter.Validate(); err != nil {
+		return ToolFilter{}, err
+	}
+	return filter, nil
+}
+
+// SaveToolFilter 写入持久化文件（先写临时文件再重命名，避免留下半份配置）
+func SaveToolFilter(filter ToolFilter) error {
+	data, err := json.MarshalIndent(filter, "", "  ")
+	if err != nil {
+		return err
+	}
+	file := ToolFilterFile()
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+func splitToolPatterns(raw string) []string {
+	var patterns []string
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			patterns = append(patterns, item)
+		}
+	}
+	return patterns
+}
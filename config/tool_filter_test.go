@@ -0,0 +1,55 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestToolFilter_Allows(t *testing.T) {
+	filter := ToolFilter{Blacklist: []string{"web_search", "*_search"}}
+	assert.False(t, filter.Allows("web_search"))
+	assert.False(t, filter.Allows("code_search"))
+	assert.True(t, filter.Allows("read_file"))
+
+	filter.Whitelist = []string{"read_*", "code_search"}
+	assert.True(t, filter.Allows("read_file"))
+	assert.False(t, filter.Allows("write_file"), "白名单非空时只保留匹配的工具")
+	assert.False(t, filter.Allows("code_search"), "黑名单优先于白名单")
+
+	assert.True(t, ToolFilter{}.Allows("anything"))
+	assert.True(t, ToolFilter{}.IsEmpty())
+}
+
+func TestToolFilter_Validate(t *testing.T) {
+	assert.NoError(t, ToolFilter{Blacklist: []string{"*_search"}, Whitelist: []string{"read_?"}}.Validate())
+	assert.Error(t, ToolFilter{Blacklist: []string{"[abc"}}.Validate())
+	assert.Error(t, ToolFilter{Whitelist: []string{" "}}.Validate())
+}
+
+func TestLoadToolFilter(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "data", "tool_filter.json")
+	t.Setenv("TOOL_FILTER_FILE", file)
+	t.Setenv("TOOL_BLACKLIST", " web_search, *_search ,")
+	t.Setenv("TOOL_WHITELIST", "")
+
+	// 文件不存在时读取环境变量
+	filter, err := LoadToolFilter()
+	require.NoError(t, err)
+	assert.Equal(t, ToolFilter{Blacklist: []string{"web_search", "*_search"}}, filter)
+
+	// 持久化文件优先于环境变量
+	require.NoError(t, SaveToolFilter(ToolFilter{Blacklist: []string{}, Whitelist: []string{"read_file"}}))
+	filter, err = LoadToolFilter()
+	require.NoError(t, err)
+	assert.Equal(t, ToolFilter{Blacklist: []string{}, Whitelist: []string{"read_file"}}, filter)
+	_, err = os.Stat(file + ".tmp")
+	assert.True(t, os.IsNotExist(err), "不应留下临时文件")
+
+	require.NoError(t, os.WriteFile(file, []byte(`{"blacklist":["[bad"]}`), 0644))
+	_, err = LoadToolFilter()
+	assert.Error(t, err)
+}
@@ -0,0 +1,6 @@
+package config
+
+// IsToolInputEagerParseEnabled 是否在收到 stop 信号前检测工具参数JSON是否已完整（TOOL_INPUT_EAGER_PARSE=true）
+func IsToolInputEagerParseEnabled() bool {
+	return boolEnv("TOOL_INPUT_EAGER_PARSE")
+}
@@ -0,0 +1,16 @@
+package config
+
+import (
+	"os"
+	"strings"
+)
+
+// ToolIntroText 每条消息第一个工具调用前插入的介绍文本
+// 通过环境变量 TOOL_INTRO_TEXT 配置，默认为空（不插入任何文本块）
+func ToolIntroText() string {
+	text := os.Getenv("TOOL_INTRO_TEXT")
+	if strings.TrimSpace(text) == "" {
+		return ""
+	}
+	return text
+}
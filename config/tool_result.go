@@ -0,0 +1,7 @@
+package config
+
+// BinaryThresholdBytes tool_result 内容按 base64 二进制识别的解码后最小字节数
+// 可通过环境变量 BINARY_THRESHOLD_BYTES 配置，默认1KB；解码后不超过该长度的内容仍按文本发送
+func BinaryThresholdBytes() int {
+	return positiveIntEnv("BINARY_THRESHOLD_BYTES", DefaultBinaryThresholdBytes)
+}
@@ -0,0 +1,12 @@
+package config
+
+import (
+	"os"
+	"strings"
+)
+
+// ToolStateFile 工具状态持久化文件路径
+// 通过环境变量 TOOL_STATE_FILE 配置；为空时不保存/恢复工具状态
+func ToolStateFile() string {
+	return strings.TrimSpace(os.Getenv("TOOL_STATE_FILE"))
+}
@@ -0,0 +1,27 @@
+package config
+
+import (
+	"os"
+	"strings"
+)
+
+// 工具调用参数校验模式
+const (
+	ToolArgsValidationOff    = "off"
+	ToolArgsValidationWarn   = "warn"   // 照常转发，附带校验结果
+	ToolArgsValidationReject = "reject" // 不合规的工具调用不转发给客户端
+)
+
+// ToolArgsValidationMode 转发 tool_use 前是否按请求中工具的 input_schema 校验模型生成的参数
+// 通过环境变量 VALIDATE_TOOL_ARGS 配置：true 为告警模式，reject 为严格模式，默认关闭
+func ToolArgsValidationMode() string {
+	value := strings.ToLower(strings.TrimSpace(os.Getenv("VALIDATE_TOOL_ARGS")))
+	switch {
+	case isTrueValue(value), value == "warn":
+		return ToolArgsValidationWarn
+	case value == "reject":
+		return ToolArgsValidationReject
+	default:
+		return ToolArgsValidationOff
+	}
+}
@@ -0,0 +1,13 @@
+package config
+
+// W3C Trace Context 请求头
+const (
+	TraceParentHeader = "traceparent"
+	TraceStateHeader  = "tracestate"
+
+	// TraceIDHeader 响应头，返回本次请求的追踪ID，便于客户端关联日志
+	TraceIDHeader = "X-Trace-ID"
+)
+
+// TraceStateMaxLength tracestate 超过该长度时不再转发（W3C 建议至少支持 512 字符）
+const TraceStateMaxLength = 512
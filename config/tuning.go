@@ -13,9 +13,9 @@ const (
 
 	// ========== Token缓存配置 ==========
 
-	// TokenCacheTTL Token缓存的生存时间
+	// DefaultTokenCacheTTL Token缓存的默认生存时间，可通过 TOKEN_CACHE_TTL 配置（见 Settings）
 	// 过期后需要重新刷新
-	TokenCacheTTL = 5 * time.Minute
+	DefaultTokenCacheTTL = 5 * time.Minute
 
 	// HTTPClientKeepAlive HTTP客户端Keep-Alive间隔
 	HTTPClientKeepAlive = 30 * time.Second
@@ -23,3 +23,454 @@ const (
 	// HTTPClientTLSHandshakeTimeout HTTP客户端TLS握手超时
 	HTTPClientTLSHandshakeTimeout = 15 * time.Second
 )
+
+// ========== 上游重试配置 ==========
+
+const (
+	// UpstreamMaxRetries 上游返回429时的最大重试次数
+	UpstreamMaxRetries = 3
+
+	// DefaultMaxRetryDelay 单次重试等待时间的默认上限
+	DefaultMaxRetryDelay = 30 * time.Second
+
+	// UpstreamDrainLimit 丢弃上游响应前最多读取的剩余字节数，读到EOF的连接才能放回连接池复用
+	UpstreamDrainLimit = 64 << 10
+)
+
+// ========== 上游延迟统计配置 ==========
+
+const (
+	// DefaultLatencyResetInterval 延迟直方图的默认重置周期
+	DefaultLatencyResetInterval = time.Hour
+)
+
+// DefaultLatencyBuckets 延迟直方图的默认桶上界
+var DefaultLatencyBuckets = []time.Duration{
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	1 * time.Second,
+	5 * time.Second,
+	30 * time.Second,
+}
+
+// ========== 上下文保护配置 ==========
+
+const (
+	// DefaultContextGuardMaxTokens 上下文保护的默认token预算
+	DefaultContextGuardMaxTokens = 180000
+
+	// DefaultContextGuardBlockTokens 超过该token数的历史文本块会被截断中间部分
+	DefaultContextGuardBlockTokens = 2000
+
+	// DefaultHistorySummaryPairs summarise 模式下每次合并为摘要的最早对话轮数
+	DefaultHistorySummaryPairs = 4
+
+	// DefaultHistorySummarySentenceRunes 摘要中每条消息首句的最大字符数
+	DefaultHistorySummarySentenceRunes = 200
+)
+
+// ========== 历史消息处理配置 ==========
+
+const (
+	// DefaultParallelHistoryThreshold 历史消息数超过该值时并行预处理
+	DefaultParallelHistoryThreshold = 50
+
+	// DefaultConversionCacheSize 请求转换缓存的默认容量，0 表示不缓存
+	DefaultConversionCacheSize = 0
+)
+
+// ========== 响应体积监控配置 ==========
+
+const (
+	// DefaultLargeResponseWarningBytes 流式响应超过该字节数时输出警告日志
+	DefaultLargeResponseWarningBytes = 1 << 20
+)
+
+// ResponseSizeBuckets 响应体积直方图的桶上界（字节）
+var ResponseSizeBuckets = []int64{
+	1 << 10,
+	10 << 10,
+	100 << 10,
+	1 << 20,
+	10 << 20,
+}
+
+// ========== 工具结果二进制内容配置 ==========
+
+const (
+	// DefaultBinaryThresholdBytes tool_result 中 base64 内容解码后超过该长度才按二进制块发送
+	DefaultBinaryThresholdBytes = 1024
+)
+
+// ========== 系统提示文档拉取配置 ==========
+
+const (
+	// DefaultDocumentFetchTimeout 拉取单个 URL 文档的默认超时
+	DefaultDocumentFetchTimeout = 10 * time.Second
+
+	// DefaultDocumentFetchMaxBytes 单个 URL 文档的默认最大读取字节数，超出部分截断
+	DefaultDocumentFetchMaxBytes = 512 * 1024
+
+	// DocumentFetchMaxRedirects 拉取文档时允许的最大重定向次数
+	DocumentFetchMaxRedirects = 3
+)
+
+// ========== 流式输出刷新配置 ==========
+
+const (
+	// DefaultFlushBatchSize 默认每个事件刷新一次
+	DefaultFlushBatchSize = 1
+
+	// MaxFlushBatchSize 批量刷新允许的最大事件数
+	MaxFlushBatchSize = 8
+
+	// DefaultFlushTimeout 批量未满时兜底刷新的默认等待时间
+	DefaultFlushTimeout = 10 * time.Millisecond
+
+	// DefaultStreamIdleTimeout 上游流超过该时长没有产生事件时以 max_tokens 结束
+	DefaultStreamIdleTimeout = 120 * time.Second
+
+	// SSEProxyPaddingBytes 启用 SSE_PROXY_PADDING 时首个注释行的填充字节数（超过常见代理的缓冲阈值）
+	SSEProxyPaddingBytes = 2048
+
+	// SSEGzipLevel SSE响应gzip压缩级别（1为最快，尽量降低逐事件刷新带来的延迟）
+	SSEGzipLevel = 1
+)
+
+// ========== 确定性模式配置 ==========
+
+const (
+	// DeterministicSeed 确定性模式下所有随机数生成器使用的固定种子
+	DeterministicSeed int64 = 20240101
+)
+
+// ========== 流式重复内容检测配置 ==========
+
+const (
+	// DuplicateFinalMinBytes 低于该长度的 text_delta 不做重复检测，避免误判正常的短重复
+	DuplicateFinalMinBytes = 32
+
+	// DuplicateFinalPrefixRatio text_delta 是已输出文本的前缀且长度不少于该比例时视为重复的最终内容
+	DuplicateFinalPrefixRatio = 0.9
+
+	// DuplicateFinalMaxTrackedBytes 每个内容块保留用于前缀比较的最大文本字节数，超出后只做整段哈希比较
+	DuplicateFinalMaxTrackedBytes = 256 * 1024
+)
+
+// ========== 会话审计配置 ==========
+
+const (
+	// DefaultAuditRetention 会话审计记录的默认保留时长
+	DefaultAuditRetention = time.Hour
+
+	// DefaultAuditMaxBytes 所有会话审计记录合计占用的默认上限（按JSON序列化大小估算）
+	DefaultAuditMaxBytes = 16 * 1024 * 1024
+
+	// DefaultAuditMaxTurns 单个会话最多保留的请求记录数，超出后丢弃最早的记录
+	DefaultAuditMaxTurns = 100
+)
+
+// ========== 会话实时监控配置 ==========
+
+const (
+	// TranscriptPreviewChars 实时监控推送的内容预览最大字符数（按rune计）
+	TranscriptPreviewChars = 200
+
+	// TranscriptSubscriberBuffer 每个监控订阅者的事件缓冲长度，缓冲满时丢弃新事件而不阻塞请求
+	TranscriptSubscriberBuffer = 64
+
+	// TranscriptPingInterval 监控WebSocket连接的心跳间隔
+	TranscriptPingInterval = 30 * time.Second
+)
+
+// ========== 工具结果流式输出配置 ==========
+
+const (
+	// ToolResultStreamChunkBytes 流式工具结果每个 input_json_delta 片段的最大字节数
+	ToolResultStreamChunkBytes = 4096
+)
+
+// ========== 工具描述补全配置 ==========
+
+const (
+	// ToolDescriptionMinLength 工具描述少于该字符数时视为过短，由 ENHANCE_TOOL_DESCRIPTIONS 按 schema 补全
+	ToolDescriptionMinLength = 10
+)
+
+// ========== 工具过滤配置 ==========
+
+const (
+	// DefaultToolFilterFile 工具黑白名单的默认持久化文件（TOOL_FILTER_FILE 未设置时）
+	DefaultToolFilterFile = "data/tool_filter.json"
+)
+
+// ========== 按模型token估算校准 ==========
+
+const (
+	// DefaultTokenCalibrationFile 按模型校准表的默认持久化文件（TOKEN_CALIBRATION_FILE 未设置时）
+	DefaultTokenCalibrationFile = "data/token_calibration.json"
+
+	// CalibrationMinWeight 校准样本的最小权重，样本很多后新的实测结果仍能修正比率
+	CalibrationMinWeight = 0.1
+
+	// CalibrationMaxFactor 单次实测允许的最大偏差倍数（估算/实际），超出时截断，避免异常样本把比率拉偏
+	CalibrationMaxFactor = 4.0
+)
+
+// ========== 多区域上游配置 ==========
+
+const (
+	// DefaultUpstreamFailoverErrorPercent 端点错误率超过该百分比时切换到下一个端点
+	DefaultUpstreamFailoverErrorPercent = 50
+
+	// DefaultUpstreamHealthWindow 端点健康统计的默认滑动窗口
+	DefaultUpstreamHealthWindow = 60 * time.Second
+
+	// DefaultUpstreamProbeInterval 不健康端点的默认探测间隔
+	DefaultUpstreamProbeInterval = 30 * time.Second
+
+	// UpstreamHealthMinSamples 窗口内样本数少于该值时不判定端点不健康，避免单次失败触发切换
+	UpstreamHealthMinSamples = 5
+
+	// UpstreamHealthMaxSamples 每个端点保留的最大样本数
+	UpstreamHealthMaxSamples = 1024
+
+	// UpstreamProbeTimeout 单次探测请求的超时
+	UpstreamProbeTimeout = 5 * time.Second
+)
+
+// ========== 上游熔断配置 ==========
+
+const (
+	// DefaultCircuitBreakerThreshold 连续失败达到该次数时打开熔断
+	DefaultCircuitBreakerThreshold = 5
+
+	// DefaultCircuitBreakerWindow 计算失败率的默认滑动窗口
+	DefaultCircuitBreakerWindow = 60 * time.Second
+
+	// DefaultCircuitBreakerCooldown 熔断打开后进入半开状态前的默认冷却时间
+	DefaultCircuitBreakerCooldown = 30 * time.Second
+
+	// DefaultCircuitBreakerHalfOpenProbes 半开状态默认允许同时进行的探测请求数
+	DefaultCircuitBreakerHalfOpenProbes = 1
+
+	// CircuitBreakerMaxSamples 每个熔断器保留的最大样本数
+	CircuitBreakerMaxSamples = 1024
+)
+
+// ========== 本地搜索配置 ==========
+
+const (
+	// DefaultWebSearchTimeout 单次搜索请求的默认超时
+	DefaultWebSearchTimeout = 10 * time.Second
+
+	// DefaultWebSearchMaxResults 每次搜索默认回传的结果条数
+	DefaultWebSearchMaxResults = 5
+
+	// WebSearchMaxRounds 单个请求最多执行的搜索往返次数，超过后把上游响应原样返回给客户端
+	WebSearchMaxRounds = 3
+
+	// WebSearchResultMaxBytes 单个 tool_result 的最大字节数，超出部分截断
+	WebSearchResultMaxBytes = 8 * 1024
+
+	// WebSearchResponseMaxBytes 读取搜索服务响应的最大字节数
+	WebSearchResponseMaxBytes = 1024 * 1024
+)
+
+// ========== 模拟上游配置 ==========
+
+const (
+	// DefaultMockResponseTemplate 模拟上游默认的文本响应模板
+	DefaultMockResponseTemplate = "这是来自模拟上游（{{.Model}}）的响应。你说：{{.Prompt}}"
+
+	// MockPromptPreviewRunes 模板中 .Prompt 保留的最大字符数
+	MockPromptPreviewRunes = 200
+
+	// MockTextChunkRunes 模拟文本响应每个 assistantResponseEvent 包含的字符数
+	MockTextChunkRunes = 8
+
+	// MockToolInputChunkBytes 模拟工具参数每个 toolUseEvent 分片的字节数
+	MockToolInputChunkBytes = 16
+
+	// MockAccessToken 模拟模式下使用的占位 access token
+	MockAccessToken = "mock-access-token"
+)
+
+// ========== 消息批处理配置 ==========
+
+const (
+	// DefaultBatchParallelism 批处理默认同时执行的请求数
+	DefaultBatchParallelism = 4
+
+	// MaxBatchParallelism BATCH_PARALLELISM 的上限
+	MaxBatchParallelism = 64
+
+	// BatchMaxRequests 单个批处理允许的最大请求数
+	BatchMaxRequests = 10000
+
+	// BatchRetention 批处理结束后结果的保留时长
+	BatchRetention = 24 * time.Hour
+)
+
+// ========== 模型与租户统计配置 ==========
+
+const (
+	// TenantIDHeader 多租户部署中标识租户的请求头
+	TenantIDHeader = "X-Tenant-ID"
+
+	// DefaultTenantID 未携带租户请求头时使用的租户标签
+	DefaultTenantID = "default"
+
+	// TenantIDMaxLength 租户标签的最大长度，超出部分截断
+	TenantIDMaxLength = 64
+
+	// StatsMaxModels 单独统计的模型数上限，超出后计入 StatsOverflowLabel
+	StatsMaxModels = 256
+
+	// StatsMaxTenants 单独统计的租户数上限，超出后计入 StatsOverflowLabel
+	StatsMaxTenants = 1024
+
+	// StatsOverflowLabel 超出统计上限的模型或租户合并使用的标签
+	StatsOverflowLabel = "_other"
+)
+
+// ========== token软删除与管理操作日志配置 ==========
+
+const (
+	// DefaultTokenPurgeDays 清除接口未指定天数时，永久移除删除超过该天数的token配置
+	DefaultTokenPurgeDays = 30
+
+	// AdminAuditMaxEntries 内存中保留的管理操作日志条数，超出后丢弃最早的记录
+	AdminAuditMaxEntries = 1000
+)
+
+// ========== 上游请求头日志配置 ==========
+
+const (
+	// UpstreamHeaderLogMaxRequests 内存中保留上游请求头记录的最近请求数，超出后丢弃最早的请求
+	UpstreamHeaderLogMaxRequests = 500
+
+	// RedactedHeaderPrefixChars 敏感请求头脱敏后保留的前缀字符数
+	RedactedHeaderPrefixChars = 8
+)
+
+// DefaultRedactedHeaders 默认脱敏的请求头（不区分大小写）
+var DefaultRedactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Amz-Security-Token", "X-Api-Key"}
+
+// ========== 启动自检配置 ==========
+
+const (
+	// MinClientTokenLength KIRO_CLIENT_TOKEN 的最小长度，自检时短于该长度视为弱密码
+	MinClientTokenLength = 32
+
+	// DefaultCheckRefreshTimeout 自检时单个token刷新的默认超时
+	DefaultCheckRefreshTimeout = 10 * time.Second
+
+	// DefaultCheckProbeModel 自检 --probe 默认请求的模型
+	DefaultCheckProbeModel = "claude-haiku-4.5"
+)
+
+// ========== 模型能力探测配置 ==========
+
+const (
+	// CapabilityProbeFailureThreshold 模型连续探测失败达到该次数时标记为不可用
+	CapabilityProbeFailureThreshold = 3
+
+	// CapabilityProbeTimeout 单个模型探测请求的超时
+	CapabilityProbeTimeout = 30 * time.Second
+
+	// CapabilityProbePrompt 探测请求发送的消息内容
+	CapabilityProbePrompt = "ping"
+)
+
+// ========== 结构化输出配置 ==========
+
+const (
+	// DefaultStructuredOutputInstruction STRUCTURED_OUTPUT_INSTRUCTION 未设置时的输出约束说明
+	DefaultStructuredOutputInstruction = "Respond with a single valid JSON value only. Do not wrap it in markdown code fences and do not add any text before or after it."
+)
+
+// ========== token过期与时钟偏差配置 ==========
+
+const (
+	// DefaultTokenExpiryMargin 判断token可用时从过期时间中预留的安全余量
+	DefaultTokenExpiryMargin = 2 * time.Minute
+
+	// DefaultClockSkewWarningThreshold 与上游时钟偏差超过该值时输出警告
+	DefaultClockSkewWarningThreshold = 30 * time.Second
+)
+
+// ========== token等待队列配置 ==========
+
+const (
+	// DefaultTokenWaitQueueSize 没有可用token时允许排队等待的最大请求数，超出时立即返回429
+	DefaultTokenWaitQueueSize = 64
+
+	// TokenWaitSampleSize 计算等待时间分位数时保留的最近样本数
+	TokenWaitSampleSize = 1000
+
+	// TokenWaitMinRefreshInterval 排队期间队首请求自行刷新token缓存的最短间隔，避免缓存TTL过短时频繁刷新
+	TokenWaitMinRefreshInterval = time.Second
+)
+
+// ========== 运行时日志级别配置 ==========
+
+const (
+	// LogLevelChangeInterval 通过管理接口调整日志级别的最小间隔，避免频繁切换到debug刷屏
+	LogLevelChangeInterval = 10 * time.Second
+)
+
+// ========== 模型回退配置 ==========
+
+const (
+	// DefaultModelFallbackErrorRate MODEL_FALLBACK_ERROR_RATE 未设置时，主模型滚动错误率超过该值即改用回退模型
+	DefaultModelFallbackErrorRate = 0.5
+
+	// ModelErrorRateWindow 计算滚动错误率的时间窗口，窗口外的结果不再参与计算，主模型恢复后重新被选用
+	ModelErrorRateWindow = 5 * time.Minute
+
+	// ModelErrorRateMinSamples 窗口内上游结果少于该数时不按错误率回退
+	ModelErrorRateMinSamples = 5
+
+	// ModelErrorRateMaxSamples 每个模型在窗口内最多保留的上游结果数
+	ModelErrorRateMaxSamples = 200
+)
+
+// ========== 请求优先级队列配置 ==========
+
+const (
+	// PriorityHighMaxTokens 估算输入token少于该值的请求进入高优先级通道
+	PriorityHighMaxTokens = 1000
+
+	// PriorityMediumMaxTokens 估算输入token不超过该值的请求进入中优先级通道，超过的进入低优先级通道
+	PriorityMediumMaxTokens = 10000
+
+	// DefaultRequestQueueTimeout 请求在优先级队列中等待的默认最长时间
+	DefaultRequestQueueTimeout = 30 * time.Second
+
+	// RequestQueueThroughputWindow 统计各通道吞吐量的时间窗口
+	RequestQueueThroughputWindow = time.Minute
+)
+
+// ========== 转换警告配置 ==========
+
+const (
+	// MaxWarningsHeaderBytes X-Kiro-Warnings 响应头JSON的最大字节数，超过时改为base64编码
+	MaxWarningsHeaderBytes = 2048
+)
+
+// ========== 流式响应检查点配置 ==========
+
+const (
+	// DefaultCheckpointTTL 检查点文件在流结束后保留的默认时长
+	DefaultCheckpointTTL = 5 * time.Minute
+
+	// DefaultCheckpointBufferBytes 每条流在内存中保留的最近SSE字节数，用于按字节偏移续传
+	DefaultCheckpointBufferBytes = 256 * 1024
+
+	// DefaultCheckpointUpstreamTimeout 启用检查点时客户端断开后继续读取上游的默认最长时间
+	DefaultCheckpointUpstreamTimeout = 10 * time.Minute
+
+	// CheckpointDirName 未配置 CHECKPOINT_DIR 时在系统临时目录下使用的子目录名
+	CheckpointDirName = "kiro2api-checkpoints"
+)
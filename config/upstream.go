@@ -0,0 +1,95 @@
+package config
+
+import (
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultRegion 未显式配置区域时使用的AWS区域
+const DefaultRegion = "us-east-1"
+
+// DefaultUpstreamEndpoint 未配置 UPSTREAM_ENDPOINTS 时使用的上游基础地址
+const DefaultUpstreamEndpoint = "https://q.us-east-1.amazonaws.com"
+
+// CodeWhispererPath 上游对话接口路径，拼接在基础地址之后
+const CodeWhispererPath = "/generateAssistantResponse"
+
+// UpstreamEndpoints 按优先级排列的上游基础地址列表（第一个为主端点）
+// 通过环境变量 UPSTREAM_ENDPOINTS 配置（逗号分隔，如 "https://q.us-east-1.amazonaws.com,https://q.eu-central-1.amazonaws.com"），
+// 非 http(s) 地址会被忽略并去重；为空时仅使用默认端点
+func UpstreamEndpoints() []string {
+	var endpoints []string
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(os.Getenv("UPSTREAM_ENDPOINTS"), ",") {
+		endpoint := strings.TrimRight(strings.TrimSpace(part), "/")
+		if endpoint == "" || seen[endpoint] {
+			continue
+		}
+		u, err := url.Parse(endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			continue
+		}
+		seen[endpoint] = true
+		endpoints = append(endpoints, endpoint)
+	}
+	if len(endpoints) == 0 {
+		return []string{DefaultUpstreamEndpoint}
+	}
+	return endpoints
+}
+
+// UpstreamFailoverErrorPercent 触发故障转移的错误率阈值（百分比）
+// 可通过环境变量 UPSTREAM_FAILOVER_ERROR_PERCENT 配置（1-100），默认50
+func UpstreamFailoverErrorPercent() int {
+	percent := positiveIntEnv("UPSTREAM_FAILOVER_ERROR_PERCENT", DefaultUpstreamFailoverErrorPercent)
+	if percent > 100 {
+		return 100
+	}
+	return percent
+}
+
+// UpstreamHealthWindow 计算端点错误率和p95延迟的滑动窗口
+// 可通过环境变量 UPSTREAM_HEALTH_WINDOW（秒）配置，默认60秒
+func UpstreamHealthWindow() time.Duration {
+	seconds := positiveIntEnv("UPSTREAM_HEALTH_WINDOW", int(DefaultUpstreamHealthWindow/time.Second))
+	return time.Duration(seconds) * time.Second
+}
+
+// UpstreamProbeInterval 不健康端点的探测间隔
+// 可通过环境变量 UPSTREAM_PROBE_INTERVAL（秒）配置，默认30秒
+func UpstreamProbeInterval() time.Duration {
+	seconds := positiveIntEnv("UPSTREAM_PROBE_INTERVAL", int(DefaultUpstreamProbeInterval/time.Second))
+	return time.Duration(seconds) * time.Second
+}
+
+// RegionFromEndpoint 从上游地址的主机名中解析AWS区域（如 q.eu-central-1.amazonaws.com → eu-central-1），
+// 无法识别时返回空字符串
+func RegionFromEndpoint(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return ""
+	}
+	for _, label := range strings.Split(u.Hostname(), ".") {
+		if IsValidRegion(label) {
+			return label
+		}
+	}
+	return ""
+}
+
+// IsValidRegion 判断字符串是否为AWS区域格式（如 us-east-1、ap-southeast-2）
+func IsValidRegion(region string) bool {
+	parts := strings.Split(region, "-")
+	if len(parts) < 3 {
+		return false
+	}
+	for _, p := range parts[:len(parts)-1] {
+		if p == "" || strings.Trim(p, "abcdefghijklmnopqrstuvwxyz") != "" {
+			return false
+		}
+	}
+	last := parts[len(parts)-1]
+	return last != "" && strings.Trim(last, "0123456789") == ""
+}
@@ -0,0 +1,23 @@
+package config
+
+import (
+	"os"
+	"strings"
+)
+
+// IsUpstreamHeaderLoggingEnabled 是否记录发往上游的请求头与上游返回的响应头（调试用）
+// 通过环境变量 LOG_UPSTREAM_HEADERS 配置，默认关闭
+func IsUpstreamHeaderLoggingEnabled() bool {
+	return boolEnv("LOG_UPSTREAM_HEADERS")
+}
+
+// RedactedHeaders 记录时需要脱敏的请求头：默认列表加上环境变量 LOG_UPSTREAM_HEADERS_REDACT 中追加的名称（逗号分隔）
+func RedactedHeaders() []string {
+	headers := append([]string(nil), DefaultRedactedHeaders...)
+	for _, name := range strings.Split(os.Getenv("LOG_UPSTREAM_HEADERS_REDACT"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			headers = append(headers, name)
+		}
+	}
+	return headers
+}
@@ -0,0 +1,40 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpstreamEndpoints(t *testing.T) {
+	t.Setenv("UPSTREAM_ENDPOINTS", "")
+	assert.Equal(t, []string{DefaultUpstreamEndpoint}, UpstreamEndpoints())
+
+	t.Setenv("UPSTREAM_ENDPOINTS", " https://q.eu-central-1.amazonaws.com/ ,ftp://bad,https://q.us-east-1.amazonaws.com,https://q.eu-central-1.amazonaws.com")
+	assert.Equal(t, []string{
+		"https://q.eu-central-1.amazonaws.com",
+		"https://q.us-east-1.amazonaws.com",
+	}, UpstreamEndpoints())
+}
+
+func TestUpstreamFailoverErrorPercent(t *testing.T) {
+	t.Setenv("UPSTREAM_FAILOVER_ERROR_PERCENT", "")
+	assert.Equal(t, DefaultUpstreamFailoverErrorPercent, UpstreamFailoverErrorPercent())
+
+	t.Setenv("UPSTREAM_FAILOVER_ERROR_PERCENT", "150")
+	assert.Equal(t, 100, UpstreamFailoverErrorPercent())
+}
+
+func TestRegionFromEndpoint(t *testing.T) {
+	assert.Equal(t, "eu-central-1", RegionFromEndpoint("https://q.eu-central-1.amazonaws.com"))
+	assert.Equal(t, "us-east-1", RegionFromEndpoint(DefaultUpstreamEndpoint))
+	assert.Equal(t, "", RegionFromEndpoint("http://127.0.0.1:8080"))
+}
+
+func TestIsValidRegion(t *testing.T) {
+	assert.True(t, IsValidRegion("ap-southeast-2"))
+	assert.True(t, IsValidRegion("us-gov-west-1"))
+	assert.False(t, IsValidRegion("us-east"))
+	assert.False(t, IsValidRegion("US-EAST-1"))
+	assert.False(t, IsValidRegion("us-east-1a"))
+}
@@ -0,0 +1,47 @@
+package config
+
+import (
+	"os"
+	"strings"
+	"time"
+)
+
+// web_search 工具的处理方式
+const (
+	// WebSearchModeDrop 静默丢弃 web_search 工具定义与历史调用（默认）
+	WebSearchModeDrop = "drop"
+	// WebSearchModeError 请求声明 web_search 工具时返回400
+	WebSearchModeError = "error"
+	// WebSearchModeLocal 由代理调用本地搜索服务执行 web_search，并把结果作为 tool_result 回传给模型
+	WebSearchModeLocal = "local"
+)
+
+// WebSearchMode web_search 工具的处理方式
+// 通过环境变量 WEB_SEARCH_MODE 配置（drop/error/local），未设置或无法识别时为 drop
+func WebSearchMode() string {
+	switch mode := strings.ToLower(strings.TrimSpace(os.Getenv("WEB_SEARCH_MODE"))); mode {
+	case WebSearchModeError, WebSearchModeLocal:
+		return mode
+	default:
+		return WebSearchModeDrop
+	}
+}
+
+// WebSearchProviderURL local 模式使用的 SearxNG（或兼容的 HTTP JSON）搜索服务地址
+// 通过环境变量 WEB_SEARCH_PROVIDER_URL 配置，如 "http://searxng:8080"
+func WebSearchProviderURL() string {
+	return strings.TrimRight(strings.TrimSpace(os.Getenv("WEB_SEARCH_PROVIDER_URL")), "/")
+}
+
+// WebSearchTimeout 单次搜索请求的超时
+// 可通过环境变量 WEB_SEARCH_TIMEOUT（秒）配置，默认10秒
+func WebSearchTimeout() time.Duration {
+	seconds := positiveIntEnv("WEB_SEARCH_TIMEOUT", int(DefaultWebSearchTimeout/time.Second))
+	return time.Duration(seconds) * time.Second
+}
+
+// WebSearchMaxResults 每次搜索回传给模型的最大结果条数
+// 可通过环境变量 WEB_SEARCH_MAX_RESULTS 配置，默认5
+func WebSearchMaxResults() int {
+	return positiveIntEnv("WEB_SEARCH_MAX_RESULTS", DefaultWebSearchMaxResults)
+}
@@ -0,0 +1,37 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebSearchMode(t *testing.T) {
+	cases := map[string]string{
+		"":        WebSearchModeDrop,
+		"drop":    WebSearchModeDrop,
+		" LOCAL ": WebSearchModeLocal,
+		"error":   WebSearchModeError,
+		"unknown": WebSearchModeDrop,
+	}
+	for value, want := range cases {
+		t.Setenv("WEB_SEARCH_MODE", value)
+		assert.Equal(t, want, WebSearchMode(), "WEB_SEARCH_MODE=%q", value)
+	}
+}
+
+func TestWebSearchProviderSettings(t *testing.T) {
+	t.Setenv("WEB_SEARCH_PROVIDER_URL", "http://searx.local:8080/")
+	t.Setenv("WEB_SEARCH_TIMEOUT", "")
+	t.Setenv("WEB_SEARCH_MAX_RESULTS", "bad")
+
+	assert.Equal(t, "http://searx.local:8080", WebSearchProviderURL())
+	assert.Equal(t, DefaultWebSearchTimeout, WebSearchTimeout())
+	assert.Equal(t, DefaultWebSearchMaxResults, WebSearchMaxResults())
+
+	t.Setenv("WEB_SEARCH_TIMEOUT", "3")
+	t.Setenv("WEB_SEARCH_MAX_RESULTS", "8")
+	assert.Equal(t, 3*time.Second, WebSearchTimeout())
+	assert.Equal(t, 8, WebSearchMaxResults())
+}
@@ -1,6 +1,7 @@
 package converter
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 
@@ -132,7 +133,7 @@ func extractToolResultsFromMessage(content any) []types.ToolResult {
 								}
 							}
 
-							toolResult.Content = contentArray
+							toolResult.Content = encodeBinaryToolResultContent(contentArray)
 						}
 
 						// 提取 status (默认为 success)
@@ -184,7 +185,7 @@ func extractToolResultsFromMessage(content any) []types.ToolResult {
 						}
 					}
 
-					toolResult.Content = contentArray
+					toolResult.Content = encodeBinaryToolResultContent(contentArray)
 				}
 
 				// 设置 status
@@ -203,419 +204,173 @@ func extractToolResultsFromMessage(content any) []types.ToolResult {
 }
 
 // BuildCodeWhispererRequest 构建 CodeWhisperer 请求
-func BuildCodeWhispererRequest(anthropicReq types.AnthropicRequest, ctx *gin.Context) (types.CodeWhispererRequest, error) {
-	// logger.Debug("构建CodeWhisperer请求", logger.String("profile_arn", profileArn))
-
-	cwReq := types.CodeWhispererRequest{}
-
-	// 设置代理相关字段 (基于参考文档的标准配置)
-	// 使用稳定的代理延续ID生成器，保持会话连续性 (KISS + DRY原则)
-	cwReq.ConversationState.AgentContinuationId = utils.GenerateStableAgentContinuationID(ctx)
-	cwReq.ConversationState.AgentTaskType = "vibe" // 固定设置为"vibe"，符合参考文档
-
-	// 智能设置ChatTriggerType (KISS: 简化逻辑但保持准确性)
-	cwReq.ConversationState.ChatTriggerType = determineChatTriggerType(anthropicReq)
-
-	// 使用稳定的会话ID生成器，基于客户端信息生成持久化的conversationId
-	if ctx != nil {
-		cwReq.ConversationState.ConversationId = utils.GenerateStableConversationID(ctx)
-
-		// 调试日志：记录会话ID生成信息
-		// clientInfo := utils.ExtractClientInfo(ctx)
-		// logger.Debug("生成稳定会话ID",
-		// 	logger.String("conversation_id", cwReq.ConversationState.ConversationId),
-		// 	logger.String("agent_continuation_id", cwReq.ConversationState.AgentContinuationId),
-		// 	logger.String("agent_task_type", cwReq.ConversationState.AgentTaskType),
-		// 	logger.String("client_ip", clientInfo["client_ip"]),
-		// 	logger.String("user_agent", clientInfo["user_agent"]),
-		// 	logger.String("custom_conv_id", clientInfo["custom_conv_id"]),
-		// logger.String("custom_agent_cont_id", clientInfo["custom_agent_cont_id"]))
-	} else {
-		// 向后兼容：如果没有提供context，仍使用UUID
-		cwReq.ConversationState.ConversationId = utils.GenerateUUID()
-		logger.Debug("使用随机UUID作为会话ID（向后兼容）",
-			logger.String("conversation_id", cwReq.ConversationState.ConversationId),
-			logger.String("agent_continuation_id", cwReq.ConversationState.AgentContinuationId),
-			logger.String("agent_task_type", cwReq.ConversationState.AgentTaskType))
-	}
+// 保留原有签名，内部委托给默认配置的 RequestBuilder，opts 可覆盖单次请求的配置
+func BuildCodeWhispererRequest(anthropicReq types.AnthropicRequest, ctx *gin.Context, opts ...BuilderOption) (types.CodeWhispererRequest, error) {
+	return NewRequestBuilder(opts...).Build(anthropicReq, ctx)
+}
 
-	// 处理最后一条消息，包括图片
-	if len(anthropicReq.Messages) == 0 {
-		return cwReq, fmt.Errorf("消息列表为空")
+// MarshalCodeWhispererRequest 按照Stealth策略序列化请求
+// 确定性模式下始终使用紧凑格式，保证相同请求得到相同字节；history 为 nil 时按 [] 发送
+func MarshalCodeWhispererRequest(req types.CodeWhispererRequest) ([]byte, error) {
+	if req.ConversationState.History == nil {
+		req.ConversationState.History = []any{}
 	}
 
-	lastMessage := anthropicReq.Messages[len(anthropicReq.Messages)-1]
-
-	// 调试：记录原始消息内容
-	// logger.Debug("处理用户消息",
-	// 	logger.String("role", lastMessage.Role),
-	// 	logger.String("content_type", fmt.Sprintf("%T", lastMessage.Content)))
-
-	textContent, images, err := processMessageContent(lastMessage.Content)
-	if err != nil {
-		return cwReq, fmt.Errorf("处理消息内容失败: %v", err)
+	if config.IsStealthModeEnabled() && !config.IsDeterministicModeEnabled() && utils.RandomBool() {
+		indentWidth := int(utils.RandomIntBetween(1, 4))
+		indent := strings.Repeat(" ", indentWidth)
+		return utils.MarshalIndent(req, "", indent)
 	}
 
-	cwReq.ConversationState.CurrentMessage.UserInputMessage.Content = textContent
-	// 确保Images字段始终是数组，即使为空
-	if len(images) > 0 {
-		cwReq.ConversationState.CurrentMessage.UserInputMessage.Images = images
-	} else {
-		cwReq.ConversationState.CurrentMessage.UserInputMessage.Images = []types.CodeWhispererImage{}
-	}
+	return utils.SafeMarshal(req)
+}
 
-	// 新增：检查并处理 ToolResults
-	if lastMessage.Role == "user" {
-		toolResults := extractToolResultsFromMessage(lastMessage.Content)
-		if len(toolResults) > 0 {
-			cwReq.ConversationState.CurrentMessage.UserInputMessage.UserInputMessageContext.ToolResults = toolResults
+// extractToolUsesFromMessage 从助手消息内容中提取工具调用，按 WEB_SEARCH_MODE 决定是否过滤 web_search
+func extractToolUsesFromMessage(content any) []types.ToolUseEntry {
+	return extractToolUses(content, filterWebSearchByMode())
+}
 
-			logger.Debug("已添加工具结果到请求",
-				logger.Int("tool_results_count", len(toolResults)),
-				logger.String("conversation_id", cwReq.ConversationState.ConversationId))
+// IsWebSearchTool 判断是否为上游不支持的 web_search 工具
+func IsWebSearchTool(name string) bool {
+	return name == "web_search" || name == "websearch"
+}
 
-			// 对于包含 tool_result 的请求，content 应该为空字符串（符合 req2.json 的格式）
-			cwReq.ConversationState.CurrentMessage.UserInputMessage.Content = ""
-			logger.Debug("工具结果请求，设置 content 为空字符串")
-		}
-	}
+// filterWebSearchByMode 除 local 模式外，web_search 工具定义和历史调用都不发送到上游
+// error 模式的请求在进入转换前已被拒绝，这里按 drop 处理
+func filterWebSearchByMode() bool {
+	return config.WebSearchMode() != config.WebSearchModeLocal
+}
 
-	// 检查模型映射是否存在，如果不存在则返回错误
-	modelId := config.ModelMap[anthropicReq.Model]
-	if modelId == "" {
-		logger.Warn("模型映射不存在",
-			logger.String("requested_model", anthropicReq.Model),
-			logger.String("request_id", cwReq.ConversationState.AgentContinuationId))
+// ErrWebSearchUnsupported WEB_SEARCH_MODE=error 时请求声明了 web_search 工具
+var ErrWebSearchUnsupported = errors.New("不支持 web_search 工具：请从 tools 中移除，或由服务端配置 WEB_SEARCH_MODE=local 启用本地搜索")
 
-		// 返回模型未找到错误，使用已生成的AgentContinuationId
-		return cwReq, types.NewModelNotFoundErrorType(anthropicReq.Model, cwReq.ConversationState.AgentContinuationId)
+// CheckWebSearchTools WEB_SEARCH_MODE=error 时，请求声明 web_search 工具则返回 ErrWebSearchUnsupported
+func CheckWebSearchTools(tools []types.AnthropicTool) error {
+	if config.WebSearchMode() != config.WebSearchModeError {
+		return nil
 	}
-	cwReq.ConversationState.CurrentMessage.UserInputMessage.ModelId = modelId
-	cwReq.ConversationState.CurrentMessage.UserInputMessage.Origin = "AI_EDITOR" // v0.4兼容性：固定使用AI_EDITOR
-
-	// 处理 tools 信息 - 根据req.json实际结构优化工具转换
-	if len(anthropicReq.Tools) > 0 {
-		// logger.Debug("开始处理工具配置",
-		// 	logger.Int("tools_count", len(anthropicReq.Tools)),
-		// 	logger.String("conversation_id", cwReq.ConversationState.ConversationId))
-
-		var tools []types.CodeWhispererTool
-		for i, tool := range anthropicReq.Tools {
-			// 验证工具定义的完整性 (SOLID-SRP: 单一责任验证)
-			if tool.Name == "" {
-				logger.Warn("跳过无名称的工具", logger.Int("tool_index", i))
-				continue
-			}
-
-			// 过滤不支持的工具：web_search (静默过滤，不发送到上游)
-			if tool.Name == "web_search" || tool.Name == "websearch" {
-				continue
-			}
-
-			// logger.Debug("转换工具定义",
-			// 	logger.Int("tool_index", i),
-			// 	logger.String("tool_name", tool.Name),
-			// logger.String("tool_description", tool.Description)
-			// )
-
-			// 根据req.json的实际结构，确保JSON Schema完整性
-			cwTool := types.CodeWhispererTool{}
-			cwTool.ToolSpecification.Name = tool.Name
-
-			// 限制 description 长度为 10000 字符
-			if len(tool.Description) > config.MaxToolDescriptionLength {
-				cwTool.ToolSpecification.Description = tool.Description[:config.MaxToolDescriptionLength]
-				logger.Debug("工具描述超长已截断",
-					logger.String("tool_name", tool.Name),
-					logger.Int("original_length", len(tool.Description)),
-					logger.Int("max_length", config.MaxToolDescriptionLength))
-			} else {
-				cwTool.ToolSpecification.Description = tool.Description
-			}
-
-			// 直接使用原始的InputSchema，避免过度处理 (恢复v0.4兼容性)
-			cwTool.ToolSpecification.InputSchema = types.InputSchema{
-				Json: tool.InputSchema,
-			}
-			tools = append(tools, cwTool)
+	for _, tool := range tools {
+		if IsWebSearchTool(tool.Name) {
+			return ErrWebSearchUnsupported
 		}
-
-		// 工具配置放在 UserInputMessageContext.Tools 中 (符合req.json结构)
-		cwReq.ConversationState.CurrentMessage.UserInputMessage.UserInputMessageContext.Tools = tools
 	}
+	return nil
+}
 
-	// 构建历史消息
-	if len(anthropicReq.System) > 0 || len(anthropicReq.Messages) > 1 || len(anthropicReq.Tools) > 0 {
-		var history []any
-
-		// 构建综合系统提示
-		var systemContentBuilder strings.Builder
-
-		// 添加原有的 system 消息
-		if len(anthropicReq.System) > 0 {
-			for _, sysMsg := range anthropicReq.System {
-				content, err := utils.GetMessageContent(sysMsg)
-				if err == nil {
-					systemContentBuilder.WriteString(content)
-					systemContentBuilder.WriteString("\n")
-				}
-			}
-		}
-
-		// 如果有系统内容，添加到历史记录 (恢复v0.4结构化类型)
-		if systemContentBuilder.Len() > 0 {
-			userMsg := types.HistoryUserMessage{}
-			userMsg.UserInputMessage.Content = strings.TrimSpace(systemContentBuilder.String())
-			userMsg.UserInputMessage.ModelId = modelId
-			userMsg.UserInputMessage.Origin = "AI_EDITOR" // v0.4兼容性：固定使用AI_EDITOR
-			history = append(history, userMsg)
-
-			assistantMsg := types.HistoryAssistantMessage{}
-			assistantMsg.AssistantResponseMessage.Content = "OK"
-			assistantMsg.AssistantResponseMessage.ToolUses = nil
-			history = append(history, assistantMsg)
-		}
-
-		// 然后处理常规消息历史 (修复配对逻辑：合并连续user消息，然后与assistant配对)
-		// 关键修复：收集连续的user消息并合并，遇到assistant时配对添加
-		var userMessagesBuffer []types.AnthropicRequestMessage // 累积连续的user消息
-
-		// 决定历史消息的循环边界
-		// 关键修复：如果最后一条消息是assistant，应该将它加入历史（与前面的user配对）
-		// 如果最后一条是user，它作为currentMessage，不加入历史
-		historyEndIndex := len(anthropicReq.Messages) - 1
-		if lastMessage.Role == "assistant" {
-			historyEndIndex = len(anthropicReq.Messages) // 包含最后一条assistant
-		}
+// extractToolUses 提取工具调用，filterWebSearch 控制是否过滤 web_search
+func extractToolUses(content any, filterWebSearch bool) []types.ToolUseEntry {
+	_, toolUses, _ := extractAssistantContent(content, filterWebSearch)
+	return toolUses
+}
 
-		for i := 0; i < historyEndIndex; i++ {
-			msg := anthropicReq.Messages[i]
+// extractAssistantContent 一次遍历助手消息内容，同时取出文本和工具调用，保证二者来自同一条消息
+// 文本块以换行拼接；没有非空文本（如只有工具调用）时文本为 utils.EmptyMessagePlaceholder
+func extractAssistantContent(content any, filterWebSearch bool) (string, []types.ToolUseEntry, error) {
+	var texts []string
+	var toolUses []types.ToolUseEntry
 
-			if msg.Role == "user" {
-				// 收集user消息到缓冲区
-				userMessagesBuffer = append(userMessagesBuffer, msg)
+	switch v := content.(type) {
+	case []any:
+		for _, item := range v {
+			block, ok := item.(map[string]any)
+			if !ok {
 				continue
 			}
-			if msg.Role == "assistant" {
-				// 遇到assistant，只有当有对应的user消息时才处理（忽略孤立assistant）
-				if len(userMessagesBuffer) > 0 {
-					// 合并所有累积的user消息
-					mergedUserMsg := types.HistoryUserMessage{}
-					var contentParts []string
-					var allImages []types.CodeWhispererImage
-					var allToolResults []types.ToolResult
-
-					for _, userMsg := range userMessagesBuffer {
-						// 处理每个user消息的内容和图片
-						messageContent, messageImages, err := processMessageContent(userMsg.Content)
-						if err == nil && messageContent != "" {
-							contentParts = append(contentParts, messageContent)
-							if len(messageImages) > 0 {
-								allImages = append(allImages, messageImages...)
-							}
-						}
-
-						// 收集工具结果
-						toolResults := extractToolResultsFromMessage(userMsg.Content)
-						if len(toolResults) > 0 {
-							allToolResults = append(allToolResults, toolResults...)
-						}
-					}
-
-					// 设置合并后的内容
-					mergedUserMsg.UserInputMessage.Content = strings.Join(contentParts, "\n")
-					if len(allImages) > 0 {
-						mergedUserMsg.UserInputMessage.Images = allImages
-					}
-					if len(allToolResults) > 0 {
-						mergedUserMsg.UserInputMessage.UserInputMessageContext.ToolResults = allToolResults
-						// 如果历史用户消息包含工具结果，也将 content 设置为空字符串
-						mergedUserMsg.UserInputMessage.Content = ""
-						// logger.Debug("历史用户消息包含工具结果",
-						// 	logger.Int("merged_messages", len(userMessagesBuffer)),
-						// 	logger.Int("tool_results_count", len(allToolResults)))
-					}
-
-					mergedUserMsg.UserInputMessage.ModelId = modelId
-					mergedUserMsg.UserInputMessage.Origin = "AI_EDITOR"
-					history = append(history, mergedUserMsg)
-
-					// 清空缓冲区
-					userMessagesBuffer = nil
-
-					// 添加assistant消息（只在有配对的user时添加）
-					assistantMsg := types.HistoryAssistantMessage{}
-					assistantContent, err := utils.GetMessageContent(msg.Content)
-					if err == nil {
-						assistantMsg.AssistantResponseMessage.Content = assistantContent
-					} else {
-						assistantMsg.AssistantResponseMessage.Content = ""
-					}
-
-					// 提取助手消息中的工具调用
-					toolUses := extractToolUsesFromMessage(msg.Content)
-					if len(toolUses) > 0 {
-						assistantMsg.AssistantResponseMessage.ToolUses = toolUses
-					} else {
-						assistantMsg.AssistantResponseMessage.ToolUses = nil
-					}
-
-					history = append(history, assistantMsg)
+			switch block["type"] {
+			case "text":
+				if text, ok := block["text"].(string); ok && text != "" {
+					texts = append(texts, text)
+				}
+			case "tool_use":
+				if toolUse, ok := toolUseFromMap(block, filterWebSearch); ok {
+					toolUses = append(toolUses, toolUse)
 				}
-				// 如果buffer为空，孤立的assistant消息被忽略（不添加到history）
 			}
 		}
-
-		// 处理结尾的孤立user消息
-		// 如果最后一条是user（作为currentMessage），buffer中可能还有倒数第二条及之前的孤立user消息
-		// 这些孤立的user消息应该配对一个"OK"的assistant
-		if len(userMessagesBuffer) > 0 {
-			// 合并所有孤立的user消息
-			mergedOrphanUserMsg := types.HistoryUserMessage{}
-			var contentParts []string
-			var allImages []types.CodeWhispererImage
-			var allToolResults []types.ToolResult
-
-			for _, userMsg := range userMessagesBuffer {
-				messageContent, messageImages, err := processMessageContent(userMsg.Content)
-				if err == nil && messageContent != "" {
-					contentParts = append(contentParts, messageContent)
-					if len(messageImages) > 0 {
-						allImages = append(allImages, messageImages...)
-					}
+	case []types.ContentBlock:
+		for _, block := range v {
+			switch block.Type {
+			case "text":
+				if block.Text != nil && *block.Text != "" {
+					texts = append(texts, *block.Text)
 				}
-
-				toolResults := extractToolResultsFromMessage(userMsg.Content)
-				if len(toolResults) > 0 {
-					allToolResults = append(allToolResults, toolResults...)
+			case "tool_use":
+				if toolUse, ok := toolUseFromContentBlock(block, filterWebSearch); ok {
+					toolUses = append(toolUses, toolUse)
 				}
 			}
-
-			mergedOrphanUserMsg.UserInputMessage.Content = strings.Join(contentParts, "\n")
-			if len(allImages) > 0 {
-				mergedOrphanUserMsg.UserInputMessage.Images = allImages
-			}
-			if len(allToolResults) > 0 {
-				mergedOrphanUserMsg.UserInputMessage.UserInputMessageContext.ToolResults = allToolResults
-				mergedOrphanUserMsg.UserInputMessage.Content = ""
-			}
-
-			mergedOrphanUserMsg.UserInputMessage.ModelId = modelId
-			mergedOrphanUserMsg.UserInputMessage.Origin = "AI_EDITOR"
-			history = append(history, mergedOrphanUserMsg)
-
-			// 自动配对一个"OK"的assistant响应
-			autoAssistantMsg := types.HistoryAssistantMessage{}
-			autoAssistantMsg.AssistantResponseMessage.Content = "OK"
-			autoAssistantMsg.AssistantResponseMessage.ToolUses = nil
-			history = append(history, autoAssistantMsg)
-
-			logger.Debug("历史消息末尾存在孤立的user消息，已自动配对assistant",
-				logger.Int("orphan_messages", len(userMessagesBuffer)))
 		}
-
-		cwReq.ConversationState.History = history
+	default:
+		// 纯文本等其他格式不包含工具调用
+		text, err := utils.GetMessageContent(content)
+		return text, nil, err
 	}
 
-	// 最终验证请求完整性 (KISS: 简化验证逻辑)
-	if err := validateCodeWhispererRequest(&cwReq); err != nil {
-		return cwReq, fmt.Errorf("请求验证失败: %v", err)
+	if len(texts) == 0 {
+		return utils.EmptyMessagePlaceholder, toolUses, nil
 	}
-
-	return cwReq, nil
+	return strings.Join(texts, "\n"), toolUses, nil
 }
 
-// MarshalCodeWhispererRequest 按照Stealth策略序列化请求
-func MarshalCodeWhispererRequest(req types.CodeWhispererRequest) ([]byte, error) {
-	if config.IsStealthModeEnabled() && utils.RandomBool() {
-		indentWidth := int(utils.RandomIntBetween(1, 4))
-		indent := strings.Repeat(" ", indentWidth)
-		return utils.MarshalIndent(req, "", indent)
-	}
-
-	return utils.SafeMarshal(req)
-}
+// toolUseFromMap 把 map 形式的 tool_use 块转换为历史工具调用，被过滤时返回false
+func toolUseFromMap(block map[string]any, filterWebSearch bool) (types.ToolUseEntry, bool) {
+	toolUse := types.ToolUseEntry{}
 
-// extractToolUsesFromMessage 从助手消息内容中提取工具调用
-func extractToolUsesFromMessage(content any) []types.ToolUseEntry {
-	var toolUses []types.ToolUseEntry
-
-	switch v := content.(type) {
-	case []any:
-		for _, item := range v {
-			if block, ok := item.(map[string]any); ok {
-				if blockType, exists := block["type"]; exists {
-					if typeStr, ok := blockType.(string); ok && typeStr == "tool_use" {
-						toolUse := types.ToolUseEntry{}
-
-						// 提取 id 作为 ToolUseId
-						if id, ok := block["id"].(string); ok {
-							toolUse.ToolUseId = id
-						}
-
-						// 提取 name
-						if name, ok := block["name"].(string); ok {
-							toolUse.Name = name
-						}
+	// 提取 id 作为 ToolUseId
+	if id, ok := block["id"].(string); ok {
+		toolUse.ToolUseId = id
+	}
 
-						// 过滤不支持的工具：web_search (静默过滤)
-						if toolUse.Name == "web_search" || toolUse.Name == "websearch" {
-							continue
-						}
+	// 提取 name
+	if name, ok := block["name"].(string); ok {
+		toolUse.Name = name
+	}
 
-						// 提取 input
-						if input, ok := block["input"].(map[string]any); ok {
-							toolUse.Input = input
-						} else {
-							// 如果 input 不是 map 或不存在，设置为空对象
-							toolUse.Input = map[string]any{}
-						}
+	// 过滤不支持的工具：web_search (静默过滤)
+	if filterWebSearch && IsWebSearchTool(toolUse.Name) {
+		return toolUse, false
+	}
 
-						toolUses = append(toolUses, toolUse)
+	// 提取 input
+	if input, ok := block["input"].(map[string]any); ok {
+		toolUse.Input = input
+	} else {
+		// 如果 input 不是 map 或不存在，设置为空对象
+		toolUse.Input = map[string]any{}
+	}
 
-						// logger.Debug("提取到历史工具调用", logger.String("tool_id", toolUse.ToolUseId), logger.String("tool_name", toolUse.Name))
-					}
-				}
-			}
-		}
-	case []types.ContentBlock:
-		for _, block := range v {
-			if block.Type == "tool_use" {
-				toolUse := types.ToolUseEntry{}
+	return toolUse, true
+}
 
-				if block.ID != nil {
-					toolUse.ToolUseId = *block.ID
-				}
+// toolUseFromContentBlock 把 ContentBlock 形式的 tool_use 块转换为历史工具调用，被过滤时返回false
+func toolUseFromContentBlock(block types.ContentBlock, filterWebSearch bool) (types.ToolUseEntry, bool) {
+	toolUse := types.ToolUseEntry{}
 
-				if block.Name != nil {
-					toolUse.Name = *block.Name
-				}
+	if block.ID != nil {
+		toolUse.ToolUseId = *block.ID
+	}
 
-				// 过滤不支持的工具：web_search (静默过滤)
-				if toolUse.Name == "web_search" || toolUse.Name == "websearch" {
-					continue
-				}
+	if block.Name != nil {
+		toolUse.Name = *block.Name
+	}
 
-				if block.Input != nil {
-					switch inp := (*block.Input).(type) {
-					case map[string]any:
-						toolUse.Input = inp
-					default:
-						toolUse.Input = map[string]any{
-							"value": inp,
-						}
-					}
-				} else {
-					toolUse.Input = map[string]any{}
-				}
+	// 过滤不支持的工具：web_search (静默过滤)
+	if filterWebSearch && IsWebSearchTool(toolUse.Name) {
+		return toolUse, false
+	}
 
-				toolUses = append(toolUses, toolUse)
+	if block.Input != nil {
+		switch inp := (*block.Input).(type) {
+		case map[string]any:
+			toolUse.Input = inp
+		default:
+			toolUse.Input = map[string]any{
+				"value": inp,
 			}
 		}
-	case string:
-		// 如果是纯文本，不包含工具调用
-		return nil
+	} else {
+		toolUse.Input = map[string]any{}
 	}
 
-	return toolUses
+	return toolUse, true
 }
@@ -6,7 +6,10 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"kiro2api/config"
+	"kiro2api/config/configtest"
 	"kiro2api/types"
+	"kiro2api/utils"
 )
 
 func init() {
@@ -111,6 +114,43 @@ func TestBuildCodeWhispererRequest_WithTools(t *testing.T) {
 	assert.Equal(t, "get_weather", cwReq.ConversationState.CurrentMessage.UserInputMessage.UserInputMessageContext.Tools[0].ToolSpecification.Name)
 }
 
+func TestBuildCodeWhispererRequest_ToolsWithoutSchema(t *testing.T) {
+	emptySchema := map[string]any{"type": "object", "properties": map[string]any{}}
+
+	t.Run("Anthropic工具省略input_schema", func(t *testing.T) {
+		anthropicReq := types.AnthropicRequest{
+			Model:     "claude-sonnet-4",
+			MaxTokens: 1024,
+			Messages:  []types.AnthropicRequestMessage{{Role: "user", Content: "几点了？"}},
+			Tools:     []types.AnthropicTool{{Name: "get_time", Description: "Get current time"}},
+		}
+
+		cwReq, err := BuildCodeWhispererRequest(anthropicReq, nil)
+
+		require.NoError(t, err)
+		tools := cwReq.ConversationState.CurrentMessage.UserInputMessage.UserInputMessageContext.Tools
+		require.Len(t, tools, 1)
+		assert.Equal(t, emptySchema, tools[0].ToolSpecification.InputSchema.Json)
+	})
+
+	t.Run("OpenAI工具省略parameters", func(t *testing.T) {
+		anthropicReq, err := ConvertOpenAIToAnthropic(types.OpenAIRequest{
+			Model:    "claude-sonnet-4",
+			Messages: []types.OpenAIMessage{{Role: "user", Content: "几点了？"}},
+			Tools:    []types.OpenAITool{{Type: "function", Function: types.OpenAIFunction{Name: "get_time"}}},
+		}, nil)
+		require.NoError(t, err)
+
+		cwReq, err := BuildCodeWhispererRequest(anthropicReq, nil)
+
+		require.NoError(t, err)
+		tools := cwReq.ConversationState.CurrentMessage.UserInputMessage.UserInputMessageContext.Tools
+		require.Len(t, tools, 1)
+		assert.Equal(t, "get_time", tools[0].ToolSpecification.Name)
+		assert.Equal(t, emptySchema, tools[0].ToolSpecification.InputSchema.Json)
+	})
+}
+
 func TestBuildCodeWhispererRequest_FilterWebSearchTool(t *testing.T) {
 	anthropicReq := types.AnthropicRequest{
 		Model:     "claude-sonnet-4-20250514",
@@ -460,9 +500,9 @@ func TestBuildCodeWhispererRequest_OrphanAssistantMessages(t *testing.T) {
 		require.NoError(t, err)
 		assert.NotNil(t, cwReq.ConversationState.History)
 
-		// 历史应该包含2条消息（第二个assistant被忽略）：
+		// 历史应该包含2条消息（连续的assistant被合并）：
 		// 1. user: "Question 1"
-		// 2. assistant: "Answer 1"
+		// 2. assistant: "Answer 1\nAnswer 2"
 		assert.Len(t, cwReq.ConversationState.History, 2)
 
 		// 验证第一对
@@ -472,7 +512,7 @@ func TestBuildCodeWhispererRequest_OrphanAssistantMessages(t *testing.T) {
 
 		firstAssistantMsg, ok := cwReq.ConversationState.History[1].(types.HistoryAssistantMessage)
 		assert.True(t, ok)
-		assert.Equal(t, "Answer 1", firstAssistantMsg.AssistantResponseMessage.Content)
+		assert.Equal(t, "Answer 1\nAnswer 2", firstAssistantMsg.AssistantResponseMessage.Content)
 
 		// 当前消息
 		assert.Equal(t, "Question 2", cwReq.ConversationState.CurrentMessage.UserInputMessage.Content)
@@ -645,3 +685,49 @@ func TestBuildCodeWhispererRequest_OrphanUserMessages(t *testing.T) {
 	})
 }
 
+// TestMarshalCodeWhispererRequest_Deterministic Stealth 模式会随机缩进，确定性模式下输出必须稳定
+func TestMarshalCodeWhispererRequest_Deterministic(t *testing.T) {
+	configtest.WithDeterministic(t)
+	configtest.Override(t, func(s *config.Settings) { s.StealthMode = true })
+
+	var req types.CodeWhispererRequest
+	req.ConversationState.ConversationId = "conv-golden"
+	req.ConversationState.History = []any{}
+	req.ConversationState.CurrentMessage.UserInputMessage.Content = "hello"
+	req.ConversationState.CurrentMessage.UserInputMessage.ModelId = "CLAUDE_SONNET_4_20250514_V1_0"
+
+	golden, err := utils.SafeMarshal(req)
+	require.NoError(t, err)
+
+	for i := 0; i < 20; i++ {
+		body, err := MarshalCodeWhispererRequest(req)
+		require.NoError(t, err)
+		assert.Equal(t, string(golden), string(body))
+	}
+}
+
+func TestCheckWebSearchTools(t *testing.T) {
+	tools := []types.AnthropicTool{{Name: "get_weather"}, {Name: "web_search"}}
+
+	for _, mode := range []string{"", "drop", "local"} {
+		t.Setenv("WEB_SEARCH_MODE", mode)
+		assert.NoError(t, CheckWebSearchTools(tools), "WEB_SEARCH_MODE=%q", mode)
+	}
+
+	t.Setenv("WEB_SEARCH_MODE", "error")
+	assert.ErrorIs(t, CheckWebSearchTools(tools), ErrWebSearchUnsupported)
+	assert.NoError(t, CheckWebSearchTools(tools[:1]))
+}
+
+func TestExtractToolUsesFromMessage_WebSearchMode(t *testing.T) {
+	content := []any{
+		map[string]any{"type": "tool_use", "id": "t1", "name": "web_search", "input": map[string]any{"query": "q"}},
+	}
+
+	assert.Empty(t, extractToolUsesFromMessage(content))
+
+	t.Setenv("WEB_SEARCH_MODE", "local")
+	toolUses := extractToolUsesFromMessage(content)
+	require.Len(t, toolUses, 1)
+	assert.Equal(t, "web_search", toolUses[0].Name)
+}
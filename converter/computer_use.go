@@ -0,0 +1,137 @@
+package converter
+
+import (
+	"fmt"
+	"strings"
+
+	"kiro2api/config"
+	"kiro2api/types"
+)
+
+// OmittedScreenshotPlaceholder 历史中被省略的 computer_use 截图替换成的文本
+const OmittedScreenshotPlaceholder = "[screenshot omitted]"
+
+// computerUseActions Anthropic computer 工具支持的动作
+var computerUseActions = []string{
+	"key", "type", "mouse_move", "left_click", "left_click_drag",
+	"right_click", "middle_click", "double_click", "screenshot", "cursor_position",
+}
+
+// IsComputerUseTool 是否为 computer_use 工具（按 type 识别，名称由客户端决定，通常为 "computer"）
+func IsComputerUseTool(tool types.AnthropicTool) bool {
+	return tool.Type == config.ComputerUseToolType
+}
+
+// computerUseTool 上游没有 computer_use 的原生格式，转换为带屏幕和动作说明的普通工具
+// 参数结构与 Anthropic computer 工具一致（action/coordinate/text），模型返回的 tool_use 客户端可以直接执行
+func computerUseTool(tool types.AnthropicTool) types.AnthropicTool {
+	tool.Description = computerUseDescription(tool)
+	tool.InputSchema = computerUseInputSchema()
+	return tool
+}
+
+// computerUseDescription 生成 computer_use 工具的描述，客户端提供的描述附加在最后
+func computerUseDescription(tool types.AnthropicTool) string {
+	var sb strings.Builder
+	sb.WriteString("Use a mouse and keyboard to interact with a computer, and take screenshots.")
+	if tool.DisplayWidthPx > 0 && tool.DisplayHeightPx > 0 {
+		fmt.Fprintf(&sb, " The screen resolution is %dx%d pixels.", tool.DisplayWidthPx, tool.DisplayHeightPx)
+	}
+	if tool.DisplayNumber != nil {
+		fmt.Fprintf(&sb, " The display number is %d.", *tool.DisplayNumber)
+	}
+	sb.WriteString(" Take a screenshot before acting if you are unsure of the current screen state.")
+	sb.WriteString(" Coordinates are [x, y] pixels from the top-left corner.")
+	sb.WriteString(" Actions: key (press a key or combination such as \"ctrl+s\", given in text), type (type the string in text),")
+	sb.WriteString(" mouse_move, left_click_drag (move or drag to coordinate), left_click, right_click, middle_click, double_click,")
+	sb.WriteString(" screenshot, cursor_position.")
+	if description := strings.TrimSpace(tool.Description); description != "" {
+		sb.WriteString("\n\n")
+		sb.WriteString(description)
+	}
+	return sb.String()
+}
+
+// computerUseInputSchema computer 工具的参数schema
+func computerUseInputSchema() map[string]any {
+	actions := make([]any, len(computerUseActions))
+	for i, action := range computerUseActions {
+		actions[i] = action
+	}
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"action": map[string]any{
+				"type":        "string",
+				"enum":        actions,
+				"description": "The action to perform.",
+			},
+			"coordinate": map[string]any{
+				"type":        "array",
+				"items":       map[string]any{"type": "integer"},
+				"description": "[x, y] pixel position, required by mouse_move and left_click_drag.",
+			},
+			"text": map[string]any{
+				"type":        "string",
+				"description": "Text to type, or the key combination to press. Required by type and key.",
+			},
+		},
+		"required": []any{"action"},
+	}
+}
+
+// computerUseToolNames 请求中 computer_use 工具的名称
+func computerUseToolNames(tools []types.AnthropicTool) map[string]bool {
+	var names map[string]bool
+	for _, tool := range tools {
+		if IsComputerUseTool(tool) {
+			if names == nil {
+				names = make(map[string]bool)
+			}
+			names[tool.Name] = true
+		}
+	}
+	return names
+}
+
+// omitHistoryScreenshots 把历史中 computer_use 工具结果里的图片替换为文本占位，返回替换的图片数
+// 只处理调用了 computer_use 工具的 tool_result，其他工具返回的图片保持不变
+func omitHistoryScreenshots(processed []historyMessageResult, toolNames map[string]bool) int {
+	if len(toolNames) == 0 {
+		return 0
+	}
+
+	computerCalls := make(map[string]bool)
+	for _, msg := range processed {
+		for _, toolUse := range msg.toolUses {
+			if toolNames[toolUse.Name] {
+				computerCalls[toolUse.ToolUseId] = true
+			}
+		}
+	}
+
+	omitted := 0
+	for _, msg := range processed {
+		for _, result := range msg.toolResults {
+			if !computerCalls[result.ToolUseId] {
+				continue
+			}
+			for i, item := range result.Content {
+				if isToolResultImage(item) {
+					result.Content[i] = map[string]any{"text": OmittedScreenshotPlaceholder}
+					omitted++
+				}
+			}
+		}
+	}
+	return omitted
+}
+
+// isToolResultImage tool_result 内容项是否为图片：Anthropic 图片块或已识别为图片的 base64 数据
+func isToolResultImage(item map[string]any) bool {
+	if blockType, _ := item["type"].(string); blockType == "image" {
+		return true
+	}
+	_, isImage := item["image"]
+	return isImage
+}
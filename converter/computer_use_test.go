@@ -0,0 +1,130 @@
+package converter
+
+import (
+	"testing"
+
+	"kiro2api/types"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// computerUseSession 多轮 computer_use 会话：截图 -> 点击 -> 查询天气（返回图片），最后一条消息带最新截图
+const computerUseSession = `{
+	"model": "claude-sonnet-4",
+	"max_tokens": 1024,
+	"tools": [
+		{"type": "computer_20241022", "name": "computer", "display_width_px": 1024, "display_height_px": 768, "display_number": 1},
+		{"name": "get_weather", "description": "查询天气图", "input_schema": {"type": "object", "properties": {"city": {"type": "string"}}}}
+	],
+	"messages": [
+		{"role": "user", "content": "打开浏览器"},
+		{"role": "assistant", "content": [{"type": "tool_use", "id": "toolu_shot", "name": "computer", "input": {"action": "screenshot"}}]},
+		{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "toolu_shot", "content": [
+			{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "c2NyZWVuMQ=="}}
+		]}]},
+		{"role": "assistant", "content": [
+			{"type": "text", "text": "点击浏览器图标"},
+			{"type": "tool_use", "id": "toolu_click", "name": "computer", "input": {"action": "left_click", "coordinate": [100, 200]}}
+		]},
+		{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "toolu_click", "content": [
+			{"type": "text", "text": "clicked"},
+			{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "c2NyZWVuMg=="}}
+		]}]},
+		{"role": "assistant", "content": [{"type": "tool_use", "id": "toolu_weather", "name": "get_weather", "input": {"city": "北京"}}]},
+		{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "toolu_weather", "content": [
+			{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "d2VhdGhlcg=="}}
+		]}]},
+		{"role": "assistant", "content": [{"type": "tool_use", "id": "toolu_latest", "name": "computer", "input": {"action": "screenshot"}}]},
+		{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "toolu_latest", "content": [
+			{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "c2NyZWVuMw=="}}
+		]}]}
+	]
+}`
+
+// historyToolResults 按 tool_use_id 收集历史中的工具结果
+func historyToolResults(t *testing.T, cwReq types.CodeWhispererRequest) map[string]types.ToolResult {
+	t.Helper()
+	results := make(map[string]types.ToolResult)
+	for _, entry := range cwReq.ConversationState.History {
+		if msg, ok := entry.(types.HistoryUserMessage); ok {
+			for _, result := range msg.UserInputMessage.UserInputMessageContext.ToolResults {
+				results[result.ToolUseId] = result
+			}
+		}
+	}
+	return results
+}
+
+func TestBuildCodeWhispererRequest_ComputerUseTool(t *testing.T) {
+	t.Setenv("INCLUDE_SCREENSHOTS_IN_HISTORY", "")
+	req := decodeAnthropicRequest(t, computerUseSession)
+	require.Empty(t, ValidateAnthropicRequest(req))
+
+	cwReq, err := BuildCodeWhispererRequest(req, nil)
+	require.NoError(t, err)
+
+	tools := cwReq.ConversationState.CurrentMessage.UserInputMessage.UserInputMessageContext.Tools
+	require.Len(t, tools, 2)
+	computer := tools[0].ToolSpecification
+	assert.Equal(t, "computer", computer.Name)
+	assert.Contains(t, computer.Description, "1024x768 pixels")
+	assert.Contains(t, computer.Description, "display number is 1")
+	assert.Equal(t, "object", computer.InputSchema.Json["type"])
+	assert.Equal(t, []any{"action"}, computer.InputSchema.Json["required"])
+	assert.Contains(t, computer.InputSchema.Json["properties"], "coordinate")
+	assert.Equal(t, "查询天气图", tools[1].ToolSpecification.Description, "普通工具不受影响")
+}
+
+func TestBuildCodeWhispererRequest_ComputerUseScreenshots(t *testing.T) {
+	placeholder := map[string]any{"text": OmittedScreenshotPlaceholder}
+
+	t.Run("默认省略历史截图", func(t *testing.T) {
+		t.Setenv("INCLUDE_SCREENSHOTS_IN_HISTORY", "")
+		cwReq, err := BuildCodeWhispererRequest(decodeAnthropicRequest(t, computerUseSession), nil)
+		require.NoError(t, err)
+
+		results := historyToolResults(t, cwReq)
+		assert.Equal(t, []map[string]any{placeholder}, results["toolu_shot"].Content)
+		require.Len(t, results["toolu_click"].Content, 2)
+		assert.Equal(t, "clicked", results["toolu_click"].Content[0]["text"])
+		assert.Equal(t, placeholder, results["toolu_click"].Content[1])
+		assert.Equal(t, "image", results["toolu_weather"].Content[0]["type"], "非 computer_use 工具的图片保留")
+
+		// 当前消息中的最新截图始终保留
+		current := cwReq.ConversationState.CurrentMessage.UserInputMessage.UserInputMessageContext.ToolResults
+		require.Len(t, current, 1)
+		assert.Equal(t, "toolu_latest", current[0].ToolUseId)
+		assert.Equal(t, "image", current[0].Content[0]["type"])
+	})
+
+	t.Run("INCLUDE_SCREENSHOTS_IN_HISTORY=true 保留历史截图", func(t *testing.T) {
+		t.Setenv("INCLUDE_SCREENSHOTS_IN_HISTORY", "true")
+		cwReq, err := BuildCodeWhispererRequest(decodeAnthropicRequest(t, computerUseSession), nil)
+		require.NoError(t, err)
+
+		results := historyToolResults(t, cwReq)
+		assert.Equal(t, "image", results["toolu_shot"].Content[0]["type"])
+		assert.Equal(t, "image", results["toolu_click"].Content[1]["type"])
+	})
+
+	t.Run("没有 computer_use 工具时不省略", func(t *testing.T) {
+		t.Setenv("INCLUDE_SCREENSHOTS_IN_HISTORY", "")
+		req := decodeAnthropicRequest(t, computerUseSession)
+		req.Tools[0] = types.AnthropicTool{Name: "computer", Description: "自定义工具", InputSchema: emptyObjectSchema()}
+
+		cwReq, err := BuildCodeWhispererRequest(req, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "image", historyToolResults(t, cwReq)["toolu_shot"].Content[0]["type"])
+	})
+}
+
+func TestValidateAnthropicRequest_ComputerUseDisplay(t *testing.T) {
+	req := decodeAnthropicRequest(t, `{
+		"model": "claude-sonnet-4",
+		"max_tokens": 1024,
+		"tools": [{"type": "computer_20241022", "name": "computer", "display_width_px": 1024}],
+		"messages": [{"role": "user", "content": "截图"}]
+	}`)
+	assert.Equal(t, []string{"tools.0"}, errorFields(ValidateAnthropicRequest(req)))
+}
@@ -11,8 +11,8 @@ import (
 
 // 消息内容处理器
 
-// processMessageContent 处理消息内容，提取文本和图片
-func processMessageContent(content any) (string, []types.CodeWhispererImage, error) {
+// processMessageContent 处理消息内容，提取文本和图片；path 为内容在请求中的位置，丢弃的内容块记录到 warnings
+func processMessageContent(content any, path string, warnings *Warnings) (string, []types.CodeWhispererImage, error) {
 	var textParts []string
 	var images []types.CodeWhispererImage
 
@@ -28,6 +28,7 @@ func processMessageContent(content any) (string, []types.CodeWhispererImage, err
 				contentBlock, err := parseContentBlock(block)
 				if err != nil {
 					logger.Warn("解析内容块失败，跳过", logger.Err(err), logger.Int("index", i))
+					warnings.Add(WarningContentBlockDropped, fmt.Sprintf("%s.%d", path, i), err.Error(), nil)
 					continue // 跳过无法解析的块
 				}
 
@@ -67,6 +68,8 @@ func processMessageContent(content any) (string, []types.CodeWhispererImage, err
 				logger.Warn("内容块不是map[string]any类型",
 					logger.Int("index", i),
 					logger.String("actual_type", fmt.Sprintf("%T", item)))
+				warnings.Add(WarningContentBlockDropped, fmt.Sprintf("%s.%d", path, i),
+					fmt.Sprintf("内容块类型 %T 无法解析", item), nil)
 			}
 		}
 
@@ -124,6 +127,37 @@ func processMessageContent(content any) (string, []types.CodeWhispererImage, err
 	return result, images, nil
 }
 
+// userTextContent 提取消息中 text 块的文本，不含 tool_result 的内容
+// 用于同时包含工具结果和追问的 user 消息：工具结果放在 ToolResults，追问保留在 Content；只有空白文本时返回空字符串
+func userTextContent(content any) string {
+	var textParts []string
+
+	switch v := content.(type) {
+	case string:
+		textParts = append(textParts, v)
+	case []any:
+		for _, item := range v {
+			if block, ok := item.(map[string]any); ok && block["type"] == "text" {
+				if text, ok := block["text"].(string); ok {
+					textParts = append(textParts, text)
+				}
+			}
+		}
+	case []types.ContentBlock:
+		for _, block := range v {
+			if block.Type == "text" && block.Text != nil {
+				textParts = append(textParts, *block.Text)
+			}
+		}
+	}
+
+	text := strings.Join(textParts, "")
+	if strings.TrimSpace(text) == "" {
+		return ""
+	}
+	return text
+}
+
 // parseContentBlock 解析内容块
 func parseContentBlock(block map[string]any) (types.ContentBlock, error) {
 	var contentBlock types.ContentBlock
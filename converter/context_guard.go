@@ -0,0 +1,201 @@
+package converter
+
+import (
+	"fmt"
+
+	"kiro2api/types"
+	"kiro2api/utils"
+)
+
+// ContextTruncationMarker 被截断文本块中间插入的标记
+const ContextTruncationMarker = "\n[...truncated...]\n"
+
+// ContextReduction 上下文保护的裁剪结果
+type ContextReduction struct {
+	OriginalTokens  int
+	FinalTokens     int
+	DroppedPairs    int // 丢弃的历史对话轮数
+	DroppedMessages int // 丢弃的历史消息条数
+	TruncatedBlocks int // 截断的历史文本块数
+
+	SummarisedMessages int // 合并为摘要的历史消息条数
+}
+
+// Reduced 是否发生了裁剪
+func (r ContextReduction) Reduced() bool {
+	return r.DroppedPairs > 0 || r.TruncatedBlocks > 0 || r.SummarisedMessages > 0
+}
+
+// HeaderValue X-Kiro-Context-Reduced 响应头的值，发生摘要时追加 summarised_messages
+func (r ContextReduction) HeaderValue() string {
+	value := fmt.Sprintf("dropped_pairs=%d;dropped_messages=%d;truncated_blocks=%d;tokens=%d->%d",
+		r.DroppedPairs, r.DroppedMessages, r.TruncatedBlocks, r.OriginalTokens, r.FinalTokens)
+	if r.SummarisedMessages > 0 {
+		value += fmt.Sprintf(";summarised_messages=%d", r.SummarisedMessages)
+	}
+	return value
+}
+
+// GuardContext 在请求超出token预算时裁剪历史消息，直到估算值不超过 maxTokens：
+//  1. 从最旧的 user/assistant 轮次开始整轮丢弃（紧随其后的工具结果轮一并丢弃，避免孤立的 tool_result），
+//     最近一轮始终保留，以免当前消息中的 tool_result 失去对应的 tool_use
+//  2. 仍超出时，按从旧到新的顺序将剩余历史中超过 blockTokens 的文本块截断中间部分，插入 ContextTruncationMarker
+//
+// 当前消息（最后一条）、系统提示和工具定义不做修改；原请求不会被修改。
+// 裁剪后仍可能超出预算（例如当前消息本身过大），调用方以 FinalTokens 为准
+func GuardContext(req types.AnthropicRequest, maxTokens, blockTokens int) (types.AnthropicRequest, ContextReduction) {
+	return GuardContextWithCompressor(req, maxTokens, blockTokens, nil)
+}
+
+// GuardContextWithCompressor 同 GuardContext，compressor 不为空时先把最早的若干轮合并为摘要，
+// 仍超出预算再按原顺序丢弃（摘要对作为最旧的一轮最先被丢弃）和截断
+func GuardContextWithCompressor(req types.AnthropicRequest, maxTokens, blockTokens int, compressor *HistoryCompressor) (types.AnthropicRequest, ContextReduction) {
+	estimator := utils.NewTokenEstimatorForModel(req.Model)
+	reduction := ContextReduction{OriginalTokens: estimateRequestTokens(estimator, req)}
+	reduction.FinalTokens = reduction.OriginalTokens
+
+	if reduction.OriginalTokens <= maxTokens || len(req.Messages) <= 1 {
+		return req, reduction
+	}
+
+	current := req.Messages[len(req.Messages)-1]
+	history := append([]types.AnthropicRequestMessage(nil), req.Messages[:len(req.Messages)-1]...)
+
+	estimate := func() int {
+		req.Messages = append(append([]types.AnthropicRequestMessage(nil), history...), current)
+		return estimateRequestTokens(estimator, req)
+	}
+
+	tokens := reduction.OriginalTokens
+	if compressor != nil {
+		if compressed, count := compressor.Compress(history); count > 0 {
+			history = compressed
+			reduction.SummarisedMessages = count
+			tokens = estimate()
+		}
+	}
+
+	// 阶段一：丢弃最旧的对话轮次，保留最近一轮
+	for tokens > maxTokens {
+		// 丢弃轮次后若以工具结果开头，继续丢弃以免留下孤立的 tool_result
+		end := roundEnd(history)
+		if end >= len(history) {
+			break
+		}
+
+		reduction.DroppedPairs++
+		reduction.DroppedMessages += end
+		history = history[end:]
+		tokens = estimate()
+	}
+
+	// 阶段二：截断剩余历史中的超长文本块
+	for i := 0; i < len(history) && tokens > maxTokens; i++ {
+		content, truncated := truncateContentBlocks(estimator, history[i].Content, blockTokens)
+		if truncated == 0 {
+			continue
+		}
+		history[i].Content = content
+		reduction.TruncatedBlocks += truncated
+		tokens = estimate()
+	}
+
+	req.Messages = append(history, current)
+	reduction.FinalTokens = tokens
+	return req, reduction
+}
+
+// estimateRequestTokens 估算完整请求的token数
+func estimateRequestTokens(estimator *utils.TokenEstimator, req types.AnthropicRequest) int {
+	return estimator.EstimateTokens(&types.CountTokensRequest{
+		Model:    req.Model,
+		Messages: req.Messages,
+		System:   req.System,
+		Tools:    req.Tools,
+	})
+}
+
+// oldestRoundEnd 返回最旧一轮对话的结束下标（不含）：开头的user消息加上随后连续的assistant消息
+// 没有assistant时整段视为一轮
+func oldestRoundEnd(history []types.AnthropicRequestMessage) int {
+	i := 0
+	for i < len(history) && history[i].Role != "assistant" {
+		i++
+	}
+	for i < len(history) && history[i].Role == "assistant" {
+		i++
+	}
+	return i
+}
+
+// truncateContentBlocks 截断内容中超过 blockTokens 的文本块，返回新内容和截断数量（不修改原内容）
+func truncateContentBlocks(estimator *utils.TokenEstimator, content any, blockTokens int) (any, int) {
+	switch v := content.(type) {
+	case string:
+		if text, ok := truncateMiddle(estimator, v, blockTokens); ok {
+			return text, 1
+		}
+	case []any:
+		var result []any
+		count := 0
+		for i, item := range v {
+			block, ok := item.(map[string]any)
+			if !ok || block["type"] != "text" {
+				continue
+			}
+			text, _ := block["text"].(string)
+			truncatedText, ok := truncateMiddle(estimator, text, blockTokens)
+			if !ok {
+				continue
+			}
+			if result == nil {
+				result = append([]any(nil), v...)
+			}
+			newBlock := make(map[string]any, len(block))
+			for k, val := range block {
+				newBlock[k] = val
+			}
+			newBlock["text"] = truncatedText
+			result[i] = newBlock
+			count++
+		}
+		if count > 0 {
+			return result, count
+		}
+	case []types.ContentBlock:
+		var result []types.ContentBlock
+		count := 0
+		for i, block := range v {
+			if block.Type != "text" || block.Text == nil {
+				continue
+			}
+			truncatedText, ok := truncateMiddle(estimator, *block.Text, blockTokens)
+			if !ok {
+				continue
+			}
+			if result == nil {
+				result = append([]types.ContentBlock(nil), v...)
+			}
+			result[i].Text = &truncatedText
+			count++
+		}
+		if count > 0 {
+			return result, count
+		}
+	}
+	return content, 0
+}
+
+// truncateMiddle 保留文本首尾、截去中间部分，使其约为 maxTokens
+func truncateMiddle(estimator *utils.TokenEstimator, text string, maxTokens int) (string, bool) {
+	tokens := estimator.EstimateTextTokens(text)
+	if tokens <= maxTokens {
+		return text, false
+	}
+
+	runes := []rune(text)
+	keep := len(runes) * maxTokens / tokens
+	head := keep / 2
+	tail := keep - head
+	return string(runes[:head]) + ContextTruncationMarker + string(runes[len(runes)-tail:]), true
+}
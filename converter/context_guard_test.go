@@ -0,0 +1,149 @@
+package converter
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"kiro2api/types"
+	"kiro2api/utils"
+)
+
+func longText(label string, words int) string {
+	return label + " " + strings.Repeat("lorem ipsum ", words)
+}
+
+// overBudgetRequest 构造4轮历史 + 当前消息的会话
+func overBudgetRequest() types.AnthropicRequest {
+	var messages []types.AnthropicRequestMessage
+	for i := 1; i <= 4; i++ {
+		messages = append(messages,
+			userMsg(longText(fmt.Sprintf("question %d", i), 500)),
+			assistantMsg(longText(fmt.Sprintf("answer %d", i), 500)),
+		)
+	}
+	messages = append(messages, userMsg("current question"))
+
+	return types.AnthropicRequest{
+		Model:    "claude-sonnet-4",
+		Messages: messages,
+		Tools: []types.AnthropicTool{
+			{Name: "read_file", Description: "read a file", InputSchema: map[string]any{"type": "object"}},
+		},
+	}
+}
+
+func estimateFor(req types.AnthropicRequest, messages []types.AnthropicRequestMessage) int {
+	req.Messages = messages
+	return estimateRequestTokens(utils.NewTokenEstimator(), req)
+}
+
+func TestGuardContext_UnderBudget(t *testing.T) {
+	req := overBudgetRequest()
+
+	result, reduction := GuardContext(req, 1_000_000, 100)
+
+	assert.False(t, reduction.Reduced())
+	assert.Equal(t, reduction.OriginalTokens, reduction.FinalTokens)
+	assert.Len(t, result.Messages, len(req.Messages))
+}
+
+func TestGuardContext_DropsOldestPairsFirst(t *testing.T) {
+	req := overBudgetRequest()
+	// 预算恰好容纳丢弃前两轮后的会话
+	budget := estimateFor(req, req.Messages[4:])
+
+	result, reduction := GuardContext(req, budget, 100)
+
+	require.True(t, reduction.Reduced())
+	assert.Equal(t, 2, reduction.DroppedPairs)
+	assert.Equal(t, 4, reduction.DroppedMessages)
+	assert.Equal(t, 0, reduction.TruncatedBlocks)
+	assert.LessOrEqual(t, reduction.FinalTokens, budget)
+	assert.Greater(t, reduction.OriginalTokens, budget)
+
+	require.Len(t, result.Messages, 5)
+	assert.True(t, strings.HasPrefix(result.Messages[0].Content.(string), "question 3"))
+	assert.Equal(t, "current question", result.Messages[4].Content)
+	assert.Equal(t, "dropped_pairs=2;dropped_messages=4;truncated_blocks=0;tokens="+
+		fmt.Sprintf("%d->%d", reduction.OriginalTokens, reduction.FinalTokens), reduction.HeaderValue())
+
+	// 原请求不被修改
+	assert.Len(t, req.Messages, 9)
+}
+
+func TestGuardContext_TruncatesAfterDroppingPairs(t *testing.T) {
+	req := overBudgetRequest()
+	// 最近一轮包含超长文本块
+	req.Messages[6] = userMsg([]any{
+		map[string]any{"type": "text", "text": longText("pasted file", 20000)},
+	})
+	original := req.Messages[6].Content.([]any)[0].(map[string]any)["text"]
+
+	budget := estimateFor(req, []types.AnthropicRequestMessage{req.Messages[7], req.Messages[8]}) + 1500
+
+	result, reduction := GuardContext(req, budget, 1000)
+
+	// 先丢弃除最近一轮之外的所有轮次，再截断
+	assert.Equal(t, 3, reduction.DroppedPairs)
+	assert.Equal(t, 1, reduction.TruncatedBlocks)
+	assert.LessOrEqual(t, reduction.FinalTokens, budget)
+	assert.Equal(t, reduction.FinalTokens, estimateFor(result, result.Messages))
+
+	require.Len(t, result.Messages, 3)
+	text := result.Messages[0].Content.([]any)[0].(map[string]any)["text"].(string)
+	assert.Contains(t, text, ContextTruncationMarker)
+	assert.True(t, strings.HasPrefix(text, "pasted file"))
+
+	// 当前消息和工具定义保持不变，原内容未被修改
+	assert.Equal(t, "current question", result.Messages[2].Content)
+	assert.Equal(t, req.Tools, result.Tools)
+	assert.Equal(t, original, req.Messages[6].Content.([]any)[0].(map[string]any)["text"])
+}
+
+func TestGuardContext_DropsToolResultRoundWithToolUse(t *testing.T) {
+	req := types.AnthropicRequest{
+		Model: "claude-sonnet-4",
+		Messages: []types.AnthropicRequestMessage{
+			userMsg(longText("read the file", 500)),
+			assistantMsg([]any{
+				map[string]any{"type": "tool_use", "id": "t1", "name": "read_file", "input": map[string]any{}},
+			}),
+			userMsg([]any{
+				map[string]any{"type": "tool_result", "tool_use_id": "t1", "content": longText("file body", 500)},
+			}),
+			assistantMsg(longText("summary", 500)),
+			userMsg("next question"),
+			assistantMsg("next answer"),
+			userMsg("current question"),
+		},
+	}
+	budget := estimateFor(req, req.Messages[4:])
+
+	result, reduction := GuardContext(req, budget, 100)
+
+	// tool_use 与对应 tool_result 作为一次裁剪整体丢弃
+	assert.Equal(t, 1, reduction.DroppedPairs)
+	assert.Equal(t, 4, reduction.DroppedMessages)
+	require.Len(t, result.Messages, 3)
+	assert.Equal(t, "next question", result.Messages[0].Content)
+}
+
+func TestGuardContext_KeepsCurrentMessageWhenStillOverBudget(t *testing.T) {
+	req := types.AnthropicRequest{
+		Model: "claude-sonnet-4",
+		Messages: []types.AnthropicRequestMessage{
+			userMsg("q1"),
+			assistantMsg("a1"),
+			userMsg(longText("huge current", 5000)),
+		},
+	}
+
+	result, reduction := GuardContext(req, 100, 50)
+
+	assert.False(t, reduction.Reduced())
+	assert.Greater(t, reduction.FinalTokens, 100)
+	assert.Equal(t, req.Messages[2], result.Messages[2])
+}
@@ -0,0 +1,263 @@
+package converter
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+	"sort"
+	"sync"
+
+	"kiro2api/config"
+	"kiro2api/types"
+	"kiro2api/utils"
+)
+
+// conversionCacheKey 请求内容与影响转换结果的构建选项的 SHA256
+type conversionCacheKey [sha256.Size]byte
+
+// conversionCacheEntry LRU链表中的一项
+type conversionCacheEntry struct {
+	key         conversionCacheKey
+	cwReq       types.CodeWhispererRequest
+	adjustments []HistoryAdjustment
+	warnings    Warnings
+}
+
+// RequestConversionCache 按请求内容哈希缓存已构建的 CodeWhispererRequest 的LRU
+// 缓存的请求在命中之间共享历史、工具等切片，调用方只能读取，不能修改
+type RequestConversionCache struct {
+	mutex   sync.Mutex
+	maxSize int
+	entries map[conversionCacheKey]*list.Element
+	order   *list.List // 最近使用的在前
+}
+
+var (
+	globalConversionCache *RequestConversionCache
+	conversionCacheOnce   sync.Once
+)
+
+// GetRequestConversionCache 获取全局请求转换缓存，CONVERSION_CACHE_SIZE 未配置时返回nil
+func GetRequestConversionCache() *RequestConversionCache {
+	conversionCacheOnce.Do(func() {
+		if size := config.ConversionCacheSize(); size > 0 {
+			globalConversionCache = NewRequestConversionCache(size)
+		}
+	})
+	return globalConversionCache
+}
+
+// NewRequestConversionCache 创建最多保存 maxSize 个转换结果的缓存
+func NewRequestConversionCache(maxSize int) *RequestConversionCache {
+	return &RequestConversionCache{
+		maxSize: maxSize,
+		entries: make(map[conversionCacheKey]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// Len 当前缓存的条目数
+func (c *RequestConversionCache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.order.Len()
+}
+
+func (c *RequestConversionCache) get(key conversionCacheKey) (conversionCacheEntry, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return conversionCacheEntry{}, false
+	}
+	c.order.MoveToFront(elem)
+	return *elem.Value.(*conversionCacheEntry), true
+}
+
+func (c *RequestConversionCache) put(key conversionCacheKey, cwReq types.CodeWhispererRequest, adjustments []HistoryAdjustment, warnings Warnings) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*conversionCacheEntry)
+		entry.cwReq, entry.adjustments, entry.warnings = cwReq, adjustments, warnings
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&conversionCacheEntry{key: key, cwReq: cwReq, adjustments: adjustments, warnings: warnings})
+	for c.order.Len() > c.maxSize {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*conversionCacheEntry).key)
+	}
+}
+
+// cacheKey 计算请求的缓存key；模型无法解析时返回false，走完整构建以返回模型错误
+// 解析后的模型ID计入key，模型直通开关等配置变化后不会命中旧结果
+func (b *RequestBuilder) cacheKey(anthropicReq types.AnthropicRequest) (conversionCacheKey, bool) {
+	modelID, err := config.ResolveModelID(anthropicReq.Model)
+	if err != nil {
+		return conversionCacheKey{}, false
+	}
+
+	enc := keyEncoderPool.Get().(*requestKeyEncoder)
+	defer func() {
+		enc.buf, enc.err = enc.buf[:0], nil
+		keyEncoderPool.Put(enc)
+	}()
+
+	enc.request(anthropicReq)
+	enc.string(modelID)
+	enc.bool(b.filterWebSearch)
+	enc.int(int64(b.historyLimit))
+	enc.bool(b.enhancer != nil)
+	enc.strings(b.toolFilter.Blacklist)
+	enc.strings(b.toolFilter.Whitelist)
+	enc.string(b.stopSequencesMode)
+	enc.bool(b.includeScreenshots)
+	enc.bool(b.strictHistory)
+	enc.string(b.historyDocuments)
+	if enc.err != nil {
+		return conversionCacheKey{}, false
+	}
+	return sha256.Sum256(enc.buf), true
+}
+
+// keyEncoderPool 复用编码缓冲区，长对话的编码结果可达数百KB
+var keyEncoderPool = sync.Pool{
+	New: func() any { return &requestKeyEncoder{} },
+}
+
+// requestKeyEncoder 把请求编码为带类型标记和长度前缀的规范字节序列，用于计算缓存key
+// 相比通过反射序列化整个请求为JSON开销小得多；map按key排序，保证相同内容得到相同编码
+// 无法识别的类型退回JSON序列化（同样带类型标记），不同请求不会得到相同编码
+type requestKeyEncoder struct {
+	buf []byte
+	err error
+}
+
+func (e *requestKeyEncoder) request(req types.AnthropicRequest) {
+	e.string(req.Model)
+	e.int(int64(req.MaxTokens))
+	e.bool(req.Stream)
+	if req.Temperature != nil {
+		e.value(*req.Temperature)
+	} else {
+		e.value(nil)
+	}
+	e.value(req.ToolChoice)
+	e.value(req.Metadata)
+	e.strings(req.StopSequences)
+	if req.ResponseFormat != nil {
+		e.json(req.ResponseFormat)
+	} else {
+		e.value(nil)
+	}
+
+	e.int(int64(len(req.System)))
+	for _, sys := range req.System {
+		e.string(sys.Type)
+		e.string(sys.Text)
+		e.string(sys.Title)
+		if sys.Source != nil {
+			e.json(sys.Source)
+		} else {
+			e.value(nil)
+		}
+	}
+
+	e.int(int64(len(req.Messages)))
+	for _, msg := range req.Messages {
+		e.string(msg.Role)
+		e.value(msg.Content)
+	}
+
+	e.int(int64(len(req.Tools)))
+	for _, tool := range req.Tools {
+		e.string(tool.Type)
+		e.string(tool.Name)
+		e.string(tool.Description)
+		e.value(tool.InputSchema)
+		e.int(int64(tool.DisplayWidthPx))
+		e.int(int64(tool.DisplayHeightPx))
+		if tool.DisplayNumber != nil {
+			e.int(int64(*tool.DisplayNumber))
+		} else {
+			e.value(nil)
+		}
+	}
+}
+
+func (e *requestKeyEncoder) value(v any) {
+	switch x := v.(type) {
+	case nil:
+		e.buf = append(e.buf, 'n')
+	case string:
+		e.buf = append(e.buf, 's')
+		e.string(x)
+	case bool:
+		e.buf = append(e.buf, 'b')
+		e.bool(x)
+	case float64:
+		e.buf = append(e.buf, 'f')
+		e.buf = binary.BigEndian.AppendUint64(e.buf, math.Float64bits(x))
+	case []any:
+		e.buf = append(e.buf, 'a')
+		e.int(int64(len(x)))
+		for _, item := range x {
+			e.value(item)
+		}
+	case map[string]any:
+		e.buf = append(e.buf, 'm')
+		e.int(int64(len(x)))
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			e.string(k)
+			e.value(x[k])
+		}
+	default:
+		e.json(x)
+	}
+}
+
+func (e *requestKeyEncoder) json(v any) {
+	data, err := utils.SafeMarshal(v)
+	if err != nil {
+		e.err = err
+		return
+	}
+	e.buf = append(e.buf, 'j')
+	e.int(int64(len(data)))
+	e.buf = append(e.buf, data...)
+}
+
+func (e *requestKeyEncoder) string(s string) {
+	e.int(int64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *requestKeyEncoder) strings(items []string) {
+	e.int(int64(len(items)))
+	for _, item := range items {
+		e.string(item)
+	}
+}
+
+func (e *requestKeyEncoder) int(n int64) {
+	e.buf = binary.AppendVarint(e.buf, n)
+}
+
+func (e *requestKeyEncoder) bool(v bool) {
+	if v {
+		e.buf = append(e.buf, 1)
+	} else {
+		e.buf = append(e.buf, 0)
+	}
+}
@@ -0,0 +1,233 @@
+package converter
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"kiro2api/config"
+	"kiro2api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newConversionCacheTestRequest(messages int) types.AnthropicRequest {
+	history := buildLongConversation(messages / 2)
+	return types.AnthropicRequest{
+		Model:    "claude-sonnet-4",
+		System:   []types.AnthropicSystemMessage{{Type: "text", Text: "You are a helpful assistant."}},
+		Messages: append(history, userMsg("latest question")),
+		Tools: []types.AnthropicTool{{
+			Name:        "read_file",
+			Description: "Read a file",
+			InputSchema: map[string]any{"type": "object", "properties": map[string]any{"path": map[string]any{"type": "string"}}},
+		}},
+	}
+}
+
+func newAgentContext(agentID string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	c.Request.Header.Set("X-Agent-Continuation-ID", agentID)
+	return c
+}
+
+func TestRequestConversionCache_HitRegeneratesIdentity(t *testing.T) {
+	cache := NewRequestConversionCache(8)
+	req := newConversionCacheTestRequest(20)
+
+	first, err := NewRequestBuilder(WithConversionCache(cache)).Build(req, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, cache.Len())
+
+	second, err := NewRequestBuilder(WithConversionCache(cache)).Build(req, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, cache.Len(), "相同请求应命中缓存")
+
+	// 会话标识每次重新生成，其余内容与完整转换一致
+	assert.NotEqual(t, first.ConversationState.ConversationId, second.ConversationState.ConversationId)
+	assert.NotEqual(t, first.ConversationState.AgentContinuationId, second.ConversationState.AgentContinuationId)
+	assert.Equal(t, first.ConversationState.History, second.ConversationState.History)
+	assert.Equal(t, first.ConversationState.CurrentMessage, second.ConversationState.CurrentMessage)
+
+	full, err := NewRequestBuilder(WithConversionCache(nil)).Build(req, nil)
+	require.NoError(t, err)
+	assert.Equal(t, full.ConversationState.History, second.ConversationState.History)
+	assert.Equal(t, full.ConversationState.CurrentMessage, second.ConversationState.CurrentMessage)
+}
+
+func TestRequestConversionCache_HitUsesCurrentContext(t *testing.T) {
+	cache := NewRequestConversionCache(8)
+	req := newConversionCacheTestRequest(4)
+
+	_, err := NewRequestBuilder(WithConversionCache(cache)).Build(req, newAgentContext("0b9f2c1e-6d4a-4c8e-9f1a-2b3c4d5e6f70"))
+	require.NoError(t, err)
+
+	hit, err := NewRequestBuilder(WithConversionCache(cache)).Build(req, newAgentContext("5e6f7a8b-9c0d-4e1f-8a2b-3c4d5e6f7a8b"))
+	require.NoError(t, err)
+	assert.Equal(t, "5e6f7a8b-9c0d-4e1f-8a2b-3c4d5e6f7a8b", hit.ConversationState.AgentContinuationId)
+
+	fixed, err := NewRequestBuilder(WithConversionCache(cache), WithConversationID("conv-fixed")).Build(req, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "conv-fixed", fixed.ConversationState.ConversationId)
+	assert.Equal(t, 1, cache.Len())
+
+	// 修改返回结果的会话标识不影响缓存中的请求
+	fixed.ConversationState.ConversationId = "mutated"
+	again, err := NewRequestBuilder(WithConversionCache(cache), WithConversationID("conv-again")).Build(req, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "conv-again", again.ConversationState.ConversationId)
+}
+
+func TestRequestConversionCache_KeyIncludesBuilderOptions(t *testing.T) {
+	cache := NewRequestConversionCache(8)
+	req := newConversionCacheTestRequest(20)
+
+	full, err := NewRequestBuilder(WithConversionCache(cache)).Build(req, nil)
+	require.NoError(t, err)
+
+	limited, err := NewRequestBuilder(WithConversionCache(cache), WithHistoryLimit(1)).Build(req, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 2, cache.Len(), "不同的历史轮数限制不能共享缓存")
+	assert.Less(t, len(limited.ConversationState.History), len(full.ConversationState.History))
+
+	_, err = NewRequestBuilder(WithConversionCache(cache), WithoutWebSearchFilter()).Build(req, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 3, cache.Len())
+}
+
+func TestRequestConversionCache_DoesNotCacheErrors(t *testing.T) {
+	cache := NewRequestConversionCache(8)
+
+	_, err := NewRequestBuilder(WithConversionCache(cache)).Build(types.AnthropicRequest{
+		Model:    "claude-sonnet-4",
+		Messages: []types.AnthropicRequestMessage{userMsg("   ")},
+	}, nil)
+	require.Error(t, err)
+
+	_, err = NewRequestBuilder(WithConversionCache(cache)).Build(types.AnthropicRequest{
+		Model:    "unknown-model",
+		Messages: []types.AnthropicRequestMessage{userMsg("hello")},
+	}, nil)
+	require.Error(t, err)
+
+	assert.Equal(t, 0, cache.Len())
+}
+
+func TestRequestConversionCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	cache := NewRequestConversionCache(2)
+	build := func(text string) {
+		t.Helper()
+		_, err := NewRequestBuilder(WithConversionCache(cache)).Build(types.AnthropicRequest{
+			Model:    "claude-sonnet-4",
+			Messages: []types.AnthropicRequestMessage{userMsg(text)},
+		}, nil)
+		require.NoError(t, err)
+	}
+	keyOf := func(text string) conversionCacheKey {
+		key, ok := NewRequestBuilder().cacheKey(types.AnthropicRequest{
+			Model:    "claude-sonnet-4",
+			Messages: []types.AnthropicRequestMessage{userMsg(text)},
+		})
+		require.True(t, ok)
+		return key
+	}
+
+	build("a")
+	build("b")
+	build("a") // 命中后 a 成为最近使用
+	build("c") // 淘汰最久未使用的 b
+
+	assert.Equal(t, 2, cache.Len())
+	_, hasA := cache.get(keyOf("a"))
+	_, hasB := cache.get(keyOf("b"))
+	_, hasC := cache.get(keyOf("c"))
+	assert.True(t, hasA)
+	assert.False(t, hasB)
+	assert.True(t, hasC)
+}
+
+func benchmarkBuildConversion(b *testing.B, cache *RequestConversionCache) {
+	for _, messages := range []int{10, 200} {
+		b.Run(fmt.Sprintf("messages=%d", messages), func(b *testing.B) {
+			req := newConversionCacheTestRequest(messages)
+			builder := NewRequestBuilder(WithConversionCache(cache))
+			if _, err := builder.Build(req, nil); err != nil {
+				b.Fatal(err)
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := builder.Build(req, nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkBuild_FullConversion(b *testing.B) {
+	benchmarkBuildConversion(b, nil)
+}
+
+func BenchmarkBuild_ConversionCacheHit(b *testing.B) {
+	benchmarkBuildConversion(b, NewRequestConversionCache(16))
+}
+
+func TestRequestBuilder_CacheKey(t *testing.T) {
+	b := NewRequestBuilder()
+	keyOf := func(req types.AnthropicRequest) conversionCacheKey {
+		t.Helper()
+		key, ok := b.cacheKey(req)
+		require.True(t, ok)
+		return key
+	}
+	withContent := func(content any) types.AnthropicRequest {
+		return types.AnthropicRequest{Model: "claude-sonnet-4", Messages: []types.AnthropicRequestMessage{userMsg(content)}}
+	}
+
+	// map 的遍历顺序不影响key
+	schema := func() map[string]any {
+		return map[string]any{"type": "object", "required": []any{"a"}, "properties": map[string]any{"a": map[string]any{"type": "string"}, "b": map[string]any{"type": "number"}}}
+	}
+	withTool := func() types.AnthropicRequest {
+		req := withContent("hi")
+		req.Tools = []types.AnthropicTool{{Name: "t", InputSchema: schema()}}
+		return req
+	}
+	for i := 0; i < 20; i++ {
+		assert.Equal(t, keyOf(withTool()), keyOf(withTool()))
+	}
+
+	// 内容结构不同的请求不会得到相同的key
+	distinct := []types.AnthropicRequest{
+		withContent("ab"),
+		withContent([]any{"ab"}),
+		withContent([]any{"a", "b"}),
+		withContent([]any{map[string]any{"type": "text", "text": "ab"}}),
+		withContent([]types.ContentBlock{{Type: "text"}}),
+		withContent(nil),
+		withContent(true),
+		withContent(float64(1)),
+	}
+	seen := map[conversionCacheKey]int{}
+	for i, req := range distinct {
+		key := keyOf(req)
+		if j, dup := seen[key]; dup {
+			t.Fatalf("请求 #%d 与 #%d 的key相同", i, j)
+		}
+		seen[key] = i
+	}
+
+	// 工具黑白名单不同时不复用结果
+	filtered, ok := NewRequestBuilder(WithToolFilter(config.ToolFilter{Blacklist: []string{"t"}})).cacheKey(withTool())
+	require.True(t, ok)
+	assert.NotEqual(t, keyOf(withTool()), filtered)
+
+	_, ok = b.cacheKey(types.AnthropicRequest{Model: "unknown-model"})
+	assert.False(t, ok, "模型无法解析时不使用缓存")
+}
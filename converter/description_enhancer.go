@@ -0,0 +1,105 @@
+package converter
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"kiro2api/config"
+	"kiro2api/types"
+)
+
+// DescriptionEnhancer 为描述过短的工具按 input_schema 的参数生成一句话描述
+// 例如 {location: string, unit: string} 生成 "Get data using location (string) and unit (string)."
+type DescriptionEnhancer struct {
+	minLength int // 描述少于该字符数时补全
+}
+
+// NewDescriptionEnhancer 创建工具描述补全器
+func NewDescriptionEnhancer() *DescriptionEnhancer {
+	return &DescriptionEnhancer{minLength: config.ToolDescriptionMinLength}
+}
+
+// Enhance 返回补全后的描述；描述足够长或 schema 没有参数时原样返回，ok 为false
+// 原描述非空时保留在生成的句子之前
+func (e *DescriptionEnhancer) Enhance(tool types.AnthropicTool) (description string, ok bool) {
+	original := strings.TrimSpace(tool.Description)
+	if utf8.RuneCountInString(original) >= e.minLength {
+		return tool.Description, false
+	}
+
+	properties, _ := tool.InputSchema["properties"].(map[string]any)
+	if len(properties) == 0 {
+		return tool.Description, false
+	}
+
+	names := make([]string, 0, len(properties))
+	for name := range properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	params := make([]string, 0, len(names))
+	for _, name := range names {
+		params = append(params, describeProperty(name, properties[name]))
+	}
+	sentence := "Get data using " + joinWithAnd(params) + "."
+
+	if original == "" {
+		return sentence, true
+	}
+	if !strings.HasSuffix(original, ".") {
+		original += "."
+	}
+	return original + " " + sentence, true
+}
+
+// describeProperty 描述单个参数：名称（类型: 参数描述）
+func describeProperty(name string, schema any) string {
+	prop, _ := schema.(map[string]any)
+
+	var details []string
+	if propType := schemaTypeName(prop); propType != "" {
+		details = append(details, propType)
+	}
+	if desc, _ := prop["description"].(string); strings.TrimSpace(desc) != "" {
+		details = append(details, strings.TrimRight(strings.TrimSpace(desc), "."))
+	}
+
+	if len(details) == 0 {
+		return name
+	}
+	return name + " (" + strings.Join(details, ": ") + ")"
+}
+
+// schemaTypeName 返回参数类型名：联合类型以 "/" 连接，数组带上元素类型（如 array of string）
+func schemaTypeName(prop map[string]any) string {
+	switch t := prop["type"].(type) {
+	case string:
+		if t == "array" {
+			if items, ok := prop["items"].(map[string]any); ok {
+				if itemType := schemaTypeName(items); itemType != "" {
+					return "array of " + itemType
+				}
+			}
+		}
+		return t
+	case []any:
+		var names []string
+		for _, item := range t {
+			if name, ok := item.(string); ok {
+				names = append(names, name)
+			}
+		}
+		return strings.Join(names, "/")
+	}
+	return ""
+}
+
+// joinWithAnd 以英文列举方式连接：a、"a and b"、"a, b and c"
+func joinWithAnd(items []string) string {
+	if len(items) <= 1 {
+		return strings.Join(items, "")
+	}
+	return strings.Join(items[:len(items)-1], ", ") + " and " + items[len(items)-1]
+}
@@ -0,0 +1,85 @@
+package converter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"kiro2api/types"
+)
+
+func TestDescriptionEnhancer_Enhance(t *testing.T) {
+	tests := []struct {
+		name        string
+		tool        types.AnthropicTool
+		description string
+		enhanced    bool
+	}{
+		{
+			name: "无描述的字符串参数",
+			tool: types.AnthropicTool{Name: "get_weather", InputSchema: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"unit":     map[string]any{"type": "string"},
+					"location": map[string]any{"type": "string"},
+				},
+			}},
+			description: "Get data using location (string) and unit (string).",
+			enhanced:    true,
+		},
+		{
+			name: "参数描述与多种类型",
+			tool: types.AnthropicTool{Name: "search", InputSchema: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"query": map[string]any{"type": "string", "description": "Search keywords."},
+					"tags":  map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+					"limit": map[string]any{"type": []any{"integer", "null"}},
+					"raw":   map[string]any{},
+				},
+			}},
+			description: "Get data using limit (integer/null), query (string: Search keywords), raw and tags (array of string).",
+			enhanced:    true,
+		},
+		{
+			name: "保留过短的原描述",
+			tool: types.AnthropicTool{Name: "read", Description: "Read", InputSchema: map[string]any{
+				"properties": map[string]any{"path": map[string]any{"type": "string", "description": "文件路径"}},
+			}},
+			description: "Read. Get data using path (string: 文件路径).",
+			enhanced:    true,
+		},
+		{
+			name: "描述足够长时不修改",
+			tool: types.AnthropicTool{Name: "read", Description: "Read a file from disk", InputSchema: map[string]any{
+				"properties": map[string]any{"path": map[string]any{"type": "string"}},
+			}},
+			description: "Read a file from disk",
+		},
+		{
+			name: "按字符而非字节计算长度",
+			tool: types.AnthropicTool{Name: "read", Description: "读取本地磁盘上的文件内容", InputSchema: map[string]any{
+				"properties": map[string]any{"path": map[string]any{"type": "string"}},
+			}},
+			description: "读取本地磁盘上的文件内容",
+		},
+		{
+			name:        "schema没有参数时不修改",
+			tool:        types.AnthropicTool{Name: "ping", InputSchema: map[string]any{"type": "object", "properties": map[string]any{}}},
+			description: "",
+		},
+		{
+			name:        "schema为空时不修改",
+			tool:        types.AnthropicTool{Name: "ping", Description: "ping"},
+			description: "ping",
+		},
+	}
+
+	enhancer := NewDescriptionEnhancer()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			description, enhanced := enhancer.Enhance(tt.tool)
+			assert.Equal(t, tt.description, description)
+			assert.Equal(t, tt.enhanced, enhanced)
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package converter
+
+import (
+	"fmt"
+
+	"kiro2api/types"
+	"kiro2api/utils"
+)
+
+// 历史调整的类型
+const (
+	// HistoryDroppedAssistant 前面没有 user 消息的 assistant 消息被丢弃
+	HistoryDroppedAssistant = "dropped_assistant"
+	// HistoryAutoPairedUser 没有 assistant 回复的 user 消息自动配对 "OK"
+	HistoryAutoPairedUser = "auto_paired_user"
+	// HistoryMergedUsers 连续的 user 消息合并为一条
+	HistoryMergedUsers = "merged_users"
+)
+
+// HistoryAdjustment 构建历史时对客户端消息所做的一次调整
+// 下标对应客户端请求的 messages；消息未经 MarkClientIndexes 标记时为转换时的下标
+type HistoryAdjustment struct {
+	Kind  string `json:"kind"`
+	Index int    `json:"index"`         // 被调整的消息下标，merged_users 为第一条
+	End   int    `json:"end,omitempty"` // merged_users 的最后一条下标
+}
+
+// String 调整的可读描述，用于 STRICT_HISTORY 的错误信息
+func (a HistoryAdjustment) String() string {
+	switch a.Kind {
+	case HistoryDroppedAssistant:
+		return fmt.Sprintf("messages.%d: assistant 消息前面没有 user 消息", a.Index)
+	case HistoryAutoPairedUser:
+		return fmt.Sprintf("messages.%d: user 消息之后没有 assistant 回复", a.Index)
+	case HistoryMergedUsers:
+		return fmt.Sprintf("messages.%d-%d: 连续的 user 消息", a.Index, a.End)
+	default:
+		return fmt.Sprintf("messages.%d: %s", a.Index, a.Kind)
+	}
+}
+
+// clientHistoryAdjustments 把调整中转换时的下标（工具顺序修复、上下文裁剪之后）换算为客户端请求中的下标
+func clientHistoryAdjustments(messages []types.AnthropicRequestMessage, adjustments []HistoryAdjustment) []HistoryAdjustment {
+	if len(adjustments) == 0 {
+		return nil
+	}
+	clientIndex := func(i int) int {
+		if i < len(messages) {
+			if index, ok := messages[i].ClientIndex(); ok {
+				return index
+			}
+		}
+		return i
+	}
+
+	mapped := make([]HistoryAdjustment, len(adjustments))
+	for i, adjustment := range adjustments {
+		adjustment.Index = clientIndex(adjustment.Index)
+		if adjustment.Kind == HistoryMergedUsers {
+			adjustment.End = clientIndex(adjustment.End)
+		}
+		mapped[i] = adjustment
+	}
+	return mapped
+}
+
+// HistoryAdjustmentsHeaderValue X-Kiro-History-Adjustments 响应头的值（紧凑JSON数组）
+func HistoryAdjustmentsHeaderValue(adjustments []HistoryAdjustment) string {
+	data, err := utils.FastMarshal(adjustments)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// HistoryViolationError STRICT_HISTORY 启用时，历史需要调整才能发送
+type HistoryViolationError struct {
+	Adjustment HistoryAdjustment // 第一处需要调整的位置
+}
+
+func (e *HistoryViolationError) Error() string {
+	return "消息历史不符合 user/assistant 交替顺序（STRICT_HISTORY）: " + e.Adjustment.String()
+}
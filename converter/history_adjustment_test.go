@@ -0,0 +1,113 @@
+package converter
+
+import (
+	"testing"
+
+	"kiro2api/types"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildCodeWhispererRequest_HistoryAdjustments(t *testing.T) {
+	t.Setenv("STRICT_HISTORY", "")
+
+	tests := []struct {
+		name     string
+		messages []types.AnthropicRequestMessage
+		want     []HistoryAdjustment
+		header   string
+	}{
+		{
+			name:     "开头是assistant消息",
+			messages: []types.AnthropicRequestMessage{assistantMsg("Hello, how can I help?"), userMsg("Tell me about Go")},
+			want:     []HistoryAdjustment{{Kind: HistoryDroppedAssistant, Index: 0}},
+			header:   `[{"kind":"dropped_assistant","index":0}]`,
+		},
+		{
+			name: "历史末尾存在孤立user消息",
+			messages: []types.AnthropicRequestMessage{
+				userMsg("第一个问题"), assistantMsg("第一个回答"), userMsg("第二个问题（孤立）"), userMsg("第三个问题（当前）"),
+			},
+			want:   []HistoryAdjustment{{Kind: HistoryAutoPairedUser, Index: 2}},
+			header: `[{"kind":"auto_paired_user","index":2}]`,
+		},
+		{
+			name: "历史末尾存在多个连续孤立user消息",
+			messages: []types.AnthropicRequestMessage{
+				userMsg("第一个问题"), assistantMsg("第一个回答"), userMsg("第二个问题（孤立1）"), userMsg("第三个问题（孤立2）"), userMsg("第四个问题（当前）"),
+			},
+			want: []HistoryAdjustment{
+				{Kind: HistoryMergedUsers, Index: 2, End: 3},
+				{Kind: HistoryAutoPairedUser, Index: 3},
+			},
+			header: `[{"kind":"merged_users","index":2,"end":3},{"kind":"auto_paired_user","index":3}]`,
+		},
+		{
+			name: "历史中间的连续user消息",
+			messages: []types.AnthropicRequestMessage{
+				userMsg("part 1"), userMsg("part 2"), assistantMsg("answer"), userMsg("current"),
+			},
+			want:   []HistoryAdjustment{{Kind: HistoryMergedUsers, Index: 0, End: 1}},
+			header: `[{"kind":"merged_users","index":0,"end":1}]`,
+		},
+		{
+			name: "正常配对的消息不受影响",
+			messages: []types.AnthropicRequestMessage{
+				userMsg("第一个问题"), assistantMsg("第一个回答"), userMsg("第二个问题"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var adjustments []HistoryAdjustment
+			_, err := BuildCodeWhispererRequest(types.AnthropicRequest{Model: "claude-sonnet-4", Messages: tt.messages}, nil,
+				WithHistoryAdjustments(&adjustments))
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, adjustments)
+			if tt.header != "" {
+				assert.JSONEq(t, tt.header, HistoryAdjustmentsHeaderValue(adjustments))
+			}
+		})
+	}
+}
+
+func TestBuildCodeWhispererRequest_HistoryAdjustmentsFromCache(t *testing.T) {
+	t.Setenv("STRICT_HISTORY", "")
+	cache := NewRequestConversionCache(4)
+	req := types.AnthropicRequest{
+		Model:    "claude-sonnet-4",
+		Messages: []types.AnthropicRequestMessage{assistantMsg("leading"), userMsg("current")},
+	}
+
+	for i := 0; i < 2; i++ {
+		var adjustments []HistoryAdjustment
+		_, err := BuildCodeWhispererRequest(req, nil, WithConversionCache(cache), WithHistoryAdjustments(&adjustments))
+		require.NoError(t, err)
+		assert.Equal(t, []HistoryAdjustment{{Kind: HistoryDroppedAssistant, Index: 0}}, adjustments, "第%d次构建", i+1)
+	}
+	assert.Equal(t, 1, cache.Len())
+}
+
+func TestBuildCodeWhispererRequest_StrictHistory(t *testing.T) {
+	t.Setenv("STRICT_HISTORY", "true")
+
+	_, err := BuildCodeWhispererRequest(types.AnthropicRequest{
+		Model: "claude-sonnet-4",
+		Messages: []types.AnthropicRequestMessage{
+			userMsg("第一个问题"), assistantMsg("第一个回答"), userMsg("孤立1"), userMsg("孤立2"), userMsg("当前"),
+		},
+	}, nil)
+	var violation *HistoryViolationError
+	require.ErrorAs(t, err, &violation)
+	assert.Equal(t, HistoryAdjustment{Kind: HistoryMergedUsers, Index: 2, End: 3}, violation.Adjustment, "只报告第一处")
+	assert.Contains(t, err.Error(), "messages.2-3: 连续的 user 消息")
+
+	// 交替顺序正确的对话不受影响
+	_, err = BuildCodeWhispererRequest(types.AnthropicRequest{
+		Model:    "claude-sonnet-4",
+		Messages: []types.AnthropicRequestMessage{userMsg("q1"), assistantMsg("a1"), userMsg("q2")},
+	}, nil)
+	require.NoError(t, err)
+}
@@ -0,0 +1,127 @@
+package converter
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"kiro2api/config"
+	"kiro2api/types"
+)
+
+// historySummaryAck 摘要消息之后的合成 assistant 回复，保持 user/assistant 交替
+const historySummaryAck = "Understood. I will continue with the context above."
+
+// sentenceTerminators 首句的结束标记（含标记本身）
+var sentenceTerminators = []string{". ", "! ", "? ", "。", "！", "？"}
+
+// HistoryCompressor 把最早的若干轮对话替换为一对合成的摘要消息
+// 摘要采用抽取式方法：按顺序取每条消息文本内容的第一句
+type HistoryCompressor struct {
+	Pairs         int // 每次合并的最早对话轮数
+	SentenceRunes int // 每条消息首句的最大字符数
+}
+
+// NewHistoryCompressor 创建历史压缩器，pairs 为合并的最早对话轮数
+func NewHistoryCompressor(pairs int) *HistoryCompressor {
+	return &HistoryCompressor{Pairs: pairs, SentenceRunes: config.DefaultHistorySummarySentenceRunes}
+}
+
+// Compress 把最早的 Pairs 轮（不含最后一轮）替换为摘要对，返回新历史和被摘要的消息条数
+// 工具调用轮与紧随其后的工具结果轮视为一个整体；不修改原切片
+func (hc *HistoryCompressor) Compress(history []types.AnthropicRequestMessage) ([]types.AnthropicRequestMessage, int) {
+	end := 0
+	for pairs := 0; pairs < hc.Pairs; pairs++ {
+		next := end + roundEnd(history[end:])
+		if next >= len(history) {
+			break
+		}
+		end = next
+	}
+	if end == 0 {
+		return history, 0
+	}
+
+	compressed := make([]types.AnthropicRequestMessage, 0, len(history)-end+2)
+	compressed = append(compressed,
+		types.AnthropicRequestMessage{Role: "user", Content: hc.summarise(history[:end])},
+		types.AnthropicRequestMessage{Role: "assistant", Content: historySummaryAck},
+	)
+	compressed = append(compressed, history[end:]...)
+	return compressed, end
+}
+
+// summarise 生成 "[Summary of first N messages: ...]" 摘要文本，没有文本内容的消息（如纯工具调用）跳过
+func (hc *HistoryCompressor) summarise(messages []types.AnthropicRequestMessage) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "[Summary of first %d messages:", len(messages))
+	for _, msg := range messages {
+		sentence := firstSentence(messageText(msg.Content), hc.SentenceRunes)
+		if sentence == "" {
+			continue
+		}
+		role := "User"
+		if msg.Role == "assistant" {
+			role = "Assistant"
+		}
+		fmt.Fprintf(&sb, "\n%s: %s", role, sentence)
+	}
+	sb.WriteString("]")
+	return sb.String()
+}
+
+// roundEnd 返回最旧一轮对话的结束下标（不含），随后以工具结果开头的轮次一并计入
+func roundEnd(history []types.AnthropicRequestMessage) int {
+	end := oldestRoundEnd(history)
+	for end < len(history) && history[end].Role == "user" && len(extractToolResultsFromMessage(history[end].Content)) > 0 {
+		end += oldestRoundEnd(history[end:])
+	}
+	return end
+}
+
+// messageText 提取消息中的文本内容，忽略工具调用、工具结果和图片
+func messageText(content any) string {
+	switch v := content.(type) {
+	case string:
+		return v
+	case []any:
+		var texts []string
+		for _, item := range v {
+			if block, ok := item.(map[string]any); ok && block["type"] == "text" {
+				if text, ok := block["text"].(string); ok {
+					texts = append(texts, text)
+				}
+			}
+		}
+		return strings.Join(texts, "\n")
+	case []types.ContentBlock:
+		var texts []string
+		for _, block := range v {
+			if block.Type == "text" && block.Text != nil {
+				texts = append(texts, *block.Text)
+			}
+		}
+		return strings.Join(texts, "\n")
+	}
+	return ""
+}
+
+// firstSentence 返回文本第一行中的第一句，超过 maxRunes 时截断并追加省略号
+func firstSentence(text string, maxRunes int) string {
+	text = strings.TrimSpace(text)
+	if i := strings.IndexByte(text, '\n'); i >= 0 {
+		text = text[:i]
+	}
+	cut := len(text)
+	for _, terminator := range sentenceTerminators {
+		if i := strings.Index(text, terminator); i >= 0 && i+len(terminator) < cut {
+			cut = i + len(terminator)
+		}
+	}
+	text = strings.TrimSpace(text[:cut])
+
+	if maxRunes > 0 && utf8.RuneCountInString(text) > maxRunes {
+		text = string([]rune(text)[:maxRunes]) + "…"
+	}
+	return text
+}
@@ -0,0 +1,127 @@
+package converter
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"kiro2api/types"
+)
+
+// topicConversation 每轮讨论一个主题，首句点明主题，后面是大段细节
+func topicConversation() []types.AnthropicRequestMessage {
+	topics := []string{"database migrations", "retry backoff", "log rotation", "TLS certificates"}
+	var messages []types.AnthropicRequestMessage
+	for _, topic := range topics {
+		messages = append(messages,
+			userMsg("How should I handle "+topic+"? "+longText("details", 300)),
+			assistantMsg([]any{
+				map[string]any{"type": "text", "text": "For " + topic + " the key point is consistency. " + longText("explanation", 300)},
+			}),
+		)
+	}
+	return append(messages, userMsg("current question"))
+}
+
+func TestHistoryCompressor_SummaryIsRepresentative(t *testing.T) {
+	messages := topicConversation()
+	history := messages[:len(messages)-1]
+
+	compressed, count := NewHistoryCompressor(2).Compress(history)
+
+	assert.Equal(t, 4, count)
+	require.Len(t, compressed, len(history)-4+2)
+	assert.Equal(t, "user", compressed[0].Role)
+	assert.Equal(t, "assistant", compressed[1].Role)
+	assert.Equal(t, history[4:], compressed[2:], "其余历史保持不变")
+
+	summary := compressed[0].Content.(string)
+	assert.Equal(t, "[Summary of first 4 messages:\n"+
+		"User: How should I handle database migrations?\n"+
+		"Assistant: For database migrations the key point is consistency.\n"+
+		"User: How should I handle retry backoff?\n"+
+		"Assistant: For retry backoff the key point is consistency.]", summary)
+	assert.NotContains(t, summary, "log rotation", "未被摘要的轮次不出现在摘要中")
+	assert.Less(t, len(summary), len(longText("details", 300)))
+
+	// 原历史不被修改
+	assert.True(t, strings.HasPrefix(history[0].Content.(string), "How should I handle database migrations?"))
+}
+
+func TestHistoryCompressor_KeepsLastRoundAndToolPairs(t *testing.T) {
+	history := []types.AnthropicRequestMessage{
+		userMsg("Read the config file. Then tell me the port."),
+		assistantMsg([]any{
+			map[string]any{"type": "tool_use", "id": "t1", "name": "read_file", "input": map[string]any{}},
+		}),
+		userMsg([]any{
+			map[string]any{"type": "tool_result", "tool_use_id": "t1", "content": "port: 8080"},
+		}),
+		assistantMsg("The port is 8080."),
+		userMsg("next question"),
+		assistantMsg("next answer"),
+	}
+
+	// 轮数超过可用轮次时最后一轮仍保留
+	compressed, count := NewHistoryCompressor(10).Compress(history)
+
+	assert.Equal(t, 4, count, "工具调用与工具结果一起被摘要")
+	require.Len(t, compressed, 4)
+	assert.Equal(t, "[Summary of first 4 messages:\nUser: Read the config file.\nAssistant: The port is 8080.]", compressed[0].Content)
+	assert.Equal(t, "next question", compressed[2].Content)
+
+	// 只有一轮时不压缩
+	single, count := NewHistoryCompressor(10).Compress(history[4:])
+	assert.Zero(t, count)
+	assert.Equal(t, history[4:], single)
+}
+
+func TestFirstSentence(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		maxRunes int
+		expected string
+	}{
+		{"英文句号", "  First one. Second one.", 0, "First one."},
+		{"中文句号", "先做迁移。再部署。", 0, "先做迁移。"},
+		{"只取第一行", "line one\nline two", 0, "line one"},
+		{"没有结束符", "no terminator", 0, "no terminator"},
+		{"版本号中的点不算句末", "Use v1.2 first. Later", 0, "Use v1.2 first."},
+		{"超长截断", "一二三四五六", 3, "一二三…"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, firstSentence(tt.text, tt.maxRunes))
+		})
+	}
+}
+
+func TestGuardContextWithCompressor_SummarisesBeforeDropping(t *testing.T) {
+	req := overBudgetRequest()
+	compressor := NewHistoryCompressor(2)
+	compressed, _ := compressor.Compress(req.Messages[:len(req.Messages)-1])
+	// 预算恰好容纳摘要后的会话
+	budget := estimateFor(req, append(compressed, req.Messages[len(req.Messages)-1]))
+
+	result, reduction := GuardContextWithCompressor(req, budget, 100, compressor)
+
+	require.True(t, reduction.Reduced())
+	assert.Equal(t, 4, reduction.SummarisedMessages)
+	assert.Zero(t, reduction.DroppedPairs)
+	assert.LessOrEqual(t, reduction.FinalTokens, budget)
+	require.Len(t, result.Messages, 7)
+	assert.True(t, strings.HasPrefix(result.Messages[0].Content.(string), "[Summary of first 4 messages:"))
+	assert.True(t, strings.HasPrefix(result.Messages[2].Content.(string), "question 3"))
+	assert.Equal(t, "current question", result.Messages[6].Content)
+	assert.True(t, strings.HasSuffix(reduction.HeaderValue(), ";summarised_messages=4"))
+
+	// 摘要后仍超出预算时继续丢弃，最先丢弃摘要对
+	tight := estimateFor(req, req.Messages[6:])
+	result, reduction = GuardContextWithCompressor(req, tight, 100, compressor)
+	assert.Equal(t, 4, reduction.SummarisedMessages)
+	assert.Equal(t, 2, reduction.DroppedPairs)
+	require.Len(t, result.Messages, 3)
+	assert.True(t, strings.HasPrefix(result.Messages[0].Content.(string), "question 4"))
+}
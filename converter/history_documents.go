@@ -0,0 +1,119 @@
+package converter
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"kiro2api/config"
+	"kiro2api/types"
+)
+
+// historyDocument 从历史消息中取出的 document 块
+type historyDocument struct {
+	title   string
+	origin  string // url 来源的地址
+	content string // 可内联的文本内容
+	chars   int    // 占位中的字符数
+	inline  bool   // content 是否可以内联
+}
+
+// appendHistoryDocuments 把历史中用户消息的 document 块按 mode 追加到消息文本，返回处理的文档数
+// processed 与 messages 一一对应；内容处理失败的消息已跳过文本，文档同样跳过
+func appendHistoryDocuments(processed []historyMessageResult, messages []types.AnthropicRequestMessage, mode string) int {
+	if mode == config.HistoryDocumentsOff {
+		return 0
+	}
+
+	count := 0
+	for i := range processed {
+		if processed[i].role != "user" || processed[i].err != nil {
+			continue
+		}
+		docs := extractHistoryDocuments(messages[i].Content)
+		if len(docs) == 0 {
+			continue
+		}
+
+		parts := make([]string, 0, len(docs)+1)
+		if processed[i].text != "" {
+			parts = append(parts, processed[i].text)
+		}
+		for _, doc := range docs {
+			content := doc.content
+			if mode == config.HistoryDocumentsPlaceholder || !doc.inline {
+				content = fmt.Sprintf("[document: %d chars]", doc.chars)
+			}
+			parts = append(parts, formatDocumentText(doc.title, doc.origin, content))
+		}
+		processed[i].text = strings.Join(parts, "\n")
+		count += len(docs)
+	}
+	return count
+}
+
+// extractHistoryDocuments 按顺序取出消息中的 document 块
+// base64 来源解码后是 UTF-8 文本时可内联，否则只记录解码后的字节数；url 来源不拉取，字符数为 URL 的长度
+func extractHistoryDocuments(content any) []historyDocument {
+	var docs []historyDocument
+
+	switch v := content.(type) {
+	case []any:
+		for _, item := range v {
+			block, ok := item.(map[string]any)
+			if !ok || block["type"] != "document" {
+				continue
+			}
+			source, ok := block["source"].(map[string]any)
+			if !ok {
+				continue
+			}
+			title, _ := block["title"].(string)
+			sourceType, _ := source["type"].(string)
+			data, _ := source["data"].(string)
+			url, _ := source["url"].(string)
+			if doc, ok := newHistoryDocument(title, sourceType, data, url); ok {
+				docs = append(docs, doc)
+			}
+		}
+	case []types.ContentBlock:
+		for _, block := range v {
+			if block.Type == "document" && block.Source != nil {
+				if doc, ok := newHistoryDocument("", block.Source.Type, block.Source.Data, ""); ok {
+					docs = append(docs, doc)
+				}
+			}
+		}
+	}
+
+	return docs
+}
+
+// newHistoryDocument 按来源类型生成文档，不支持的来源返回 false
+func newHistoryDocument(title, sourceType, data, url string) (historyDocument, bool) {
+	doc := historyDocument{title: title}
+	switch sourceType {
+	case "base64":
+		decoded, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			doc.chars = utf8.RuneCountInString(data)
+			return doc, true
+		}
+		if !utf8.Valid(decoded) {
+			doc.chars = len(decoded)
+			return doc, true
+		}
+		doc.content, doc.inline = string(decoded), true
+		doc.chars = utf8.RuneCount(decoded)
+	case "text":
+		doc.content, doc.inline = data, true
+		doc.chars = utf8.RuneCountInString(data)
+	case "url":
+		doc.origin = url
+		doc.chars = utf8.RuneCountInString(url)
+	default:
+		return doc, false
+	}
+	return doc, true
+}
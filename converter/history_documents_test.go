@@ -0,0 +1,88 @@
+package converter
+
+import (
+	"testing"
+
+	"kiro2api/types"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// documentSession 第一轮用户消息带 base64 文本文档和 URL 文档，文档内容 "季度报告：收入增长" 的 base64 为 5a2j5bqm5oql5ZGK77ya5pS25YWl5aKe6ZW/
+const documentSession = `{
+	"model": "claude-sonnet-4",
+	"max_tokens": 1024,
+	"messages": [
+		{"role": "user", "content": [
+			{"type": "text", "text": "总结这两份文档"},
+			{"type": "document", "title": "报告", "source": {"type": "base64", "media_type": "text/plain", "data": "5a2j5bqm5oql5ZGK77ya5pS25YWl5aKe6ZW/"}},
+			{"type": "document", "source": {"type": "url", "url": "https://docs.example.com/a.pdf"}}
+		]},
+		{"role": "assistant", "content": "收入增长了"},
+		{"role": "user", "content": "继续"}
+	]
+}`
+
+// firstHistoryUserContent 返回历史中第一条用户消息的文本
+func firstHistoryUserContent(t *testing.T, cwReq types.CodeWhispererRequest) string {
+	t.Helper()
+	require.NotEmpty(t, cwReq.ConversationState.History)
+	msg, ok := cwReq.ConversationState.History[0].(types.HistoryUserMessage)
+	require.True(t, ok)
+	return msg.UserInputMessage.Content
+}
+
+func TestBuildCodeWhispererRequest_HistoryDocuments(t *testing.T) {
+	t.Run("默认丢弃文档", func(t *testing.T) {
+		t.Setenv("HISTORY_INCLUDE_DOCUMENTS", "")
+		cwReq, err := BuildCodeWhispererRequest(decodeAnthropicRequest(t, documentSession), nil)
+		require.NoError(t, err)
+
+		assert.Equal(t, "总结这两份文档", firstHistoryUserContent(t, cwReq))
+	})
+
+	t.Run("false 丢弃文档", func(t *testing.T) {
+		t.Setenv("HISTORY_INCLUDE_DOCUMENTS", "false")
+		cwReq, err := BuildCodeWhispererRequest(decodeAnthropicRequest(t, documentSession), nil)
+		require.NoError(t, err)
+
+		assert.NotContains(t, firstHistoryUserContent(t, cwReq), "<document")
+	})
+
+	t.Run("true 内联base64文档，URL文档替换为占位", func(t *testing.T) {
+		t.Setenv("HISTORY_INCLUDE_DOCUMENTS", "true")
+		cwReq, err := BuildCodeWhispererRequest(decodeAnthropicRequest(t, documentSession), nil)
+		require.NoError(t, err)
+
+		assert.Equal(t, "总结这两份文档\n"+
+			"<document title=\"报告\">\n季度报告：收入增长\n</document>\n"+
+			"<document source=\"https://docs.example.com/a.pdf\">\n[document: 30 chars]\n</document>",
+			firstHistoryUserContent(t, cwReq))
+	})
+
+	t.Run("placeholder 所有文档替换为占位", func(t *testing.T) {
+		t.Setenv("HISTORY_INCLUDE_DOCUMENTS", "placeholder")
+		cwReq, err := BuildCodeWhispererRequest(decodeAnthropicRequest(t, documentSession), nil)
+		require.NoError(t, err)
+
+		assert.Equal(t, "总结这两份文档\n"+
+			"<document title=\"报告\">\n[document: 9 chars]\n</document>\n"+
+			"<document source=\"https://docs.example.com/a.pdf\">\n[document: 30 chars]\n</document>",
+			firstHistoryUserContent(t, cwReq))
+	})
+}
+
+func TestExtractHistoryDocuments(t *testing.T) {
+	content := []any{
+		map[string]any{"type": "document", "source": map[string]any{"type": "text", "data": "纯文本"}},
+		map[string]any{"type": "document", "source": map[string]any{"type": "base64", "media_type": "application/pdf", "data": "JVBERi0x/w=="}},
+		map[string]any{"type": "document", "source": map[string]any{"type": "file", "file_id": "f_1"}},
+		map[string]any{"type": "text", "text": "不是文档"},
+	}
+
+	docs := extractHistoryDocuments(content)
+	require.Len(t, docs, 2, "不支持的来源被忽略")
+	assert.Equal(t, historyDocument{content: "纯文本", chars: 3, inline: true}, docs[0])
+	assert.Equal(t, historyDocument{chars: 7}, docs[1], "二进制文档只记录字节数，不内联")
+}
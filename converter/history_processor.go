@@ -0,0 +1,78 @@
+package converter
+
+import (
+	"fmt"
+	"runtime"
+
+	"kiro2api/logger"
+	"kiro2api/types"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// historyMessageResult 单条历史消息的预处理结果
+type historyMessageResult struct {
+	role        string
+	text        string
+	images      []types.CodeWhispererImage
+	toolResults []types.ToolResult
+	toolUses    []types.ToolUseEntry
+	err         error    // 内容处理失败时记录，合并时跳过该消息的文本和图片
+	warnings    Warnings // 处理该消息时产生的转换警告
+}
+
+// processHistoryMessages 预处理历史消息，结果与输入顺序一一对应
+// 消息数超过 threshold 时并行处理；单条消息失败只影响自身，不中断其余消息
+func processHistoryMessages(messages []types.AnthropicRequestMessage, filterWebSearch bool, threshold int) []historyMessageResult {
+	results := make([]historyMessageResult, len(messages))
+
+	if len(messages) <= threshold {
+		for i, msg := range messages {
+			results[i] = processHistoryMessage(msg, i, filterWebSearch)
+		}
+		return results
+	}
+
+	// 按CPU数切分为连续分片，避免每条消息一个goroutine的调度开销
+	workers := runtime.GOMAXPROCS(0)
+	chunkSize := (len(messages) + workers - 1) / workers
+
+	var g errgroup.Group
+	for start := 0; start < len(messages); start += chunkSize {
+		end := min(start+chunkSize, len(messages))
+		g.Go(func() error {
+			// 每个goroutine只写入自己分片的下标，无需加锁
+			for i := start; i < end; i++ {
+				results[i] = processHistoryMessage(messages[i], i, filterWebSearch)
+			}
+			return nil
+		})
+	}
+	_ = g.Wait() // 单条消息的错误记录在结果中，不会返回到这里
+
+	return results
+}
+
+// processHistoryMessage 处理单条历史消息，index 为消息在 messages 中的下标，用于警告的位置
+// 并行处理时每条消息的警告记录在各自的结果中，由调用方按顺序汇总
+func processHistoryMessage(msg types.AnthropicRequestMessage, index int, filterWebSearch bool) historyMessageResult {
+	result := historyMessageResult{role: msg.Role}
+
+	switch msg.Role {
+	case "user":
+		result.text, result.images, result.err = processMessageContent(msg.Content, fmt.Sprintf("messages.%d.content", index), &result.warnings)
+		if result.err != nil {
+			logger.Debug("历史消息内容处理失败，跳过该消息的文本和图片", logger.Err(result.err))
+		}
+		result.toolResults = extractToolResultsFromMessage(msg.Content)
+		// 工具结果放在 ToolResults 中，文本只保留客户端的追问，避免重复发送工具输出
+		if len(result.toolResults) > 0 && result.err == nil {
+			result.text = userTextContent(msg.Content)
+		}
+	case "assistant":
+		// 文本与工具调用在同一次遍历中取出，二者总是对应同一条消息
+		result.text, result.toolUses, result.err = extractAssistantContent(msg.Content, filterWebSearch)
+	}
+
+	return result
+}
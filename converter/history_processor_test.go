@@ -0,0 +1,215 @@
+package converter
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"kiro2api/types"
+	"kiro2api/utils"
+)
+
+// buildLongConversation 构造 rounds 轮 user/assistant 对话（含工具调用与工具结果）
+func buildLongConversation(rounds int) []types.AnthropicRequestMessage {
+	var messages []types.AnthropicRequestMessage
+	for i := 0; i < rounds; i++ {
+		if i%3 == 1 {
+			messages = append(messages, userMsg([]any{
+				map[string]any{"type": "tool_result", "tool_use_id": fmt.Sprintf("tool-%d", i-1), "content": fmt.Sprintf("result %d", i)},
+			}))
+		} else {
+			messages = append(messages, userMsg([]any{
+				map[string]any{"type": "text", "text": fmt.Sprintf("question %d", i)},
+			}))
+		}
+
+		if i%3 == 0 {
+			messages = append(messages, assistantMsg([]any{
+				map[string]any{"type": "text", "text": fmt.Sprintf("answer %d", i)},
+				map[string]any{"type": "tool_use", "id": fmt.Sprintf("tool-%d", i), "name": "read_file", "input": map[string]any{"path": fmt.Sprintf("/f/%d", i)}},
+			}))
+		} else {
+			messages = append(messages, assistantMsg(fmt.Sprintf("answer %d", i)))
+		}
+	}
+	return messages
+}
+
+func invalidImageMessage() types.AnthropicRequestMessage {
+	return userMsg([]any{
+		map[string]any{"type": "text", "text": "broken image"},
+		map[string]any{"type": "image", "source": map[string]any{"type": "base64", "media_type": "image/png", "data": "!!!not-base64"}},
+	})
+}
+
+func TestProcessHistoryMessages_ParallelPreservesOrder(t *testing.T) {
+	messages := buildLongConversation(100)
+
+	sequential := processHistoryMessages(messages, true, len(messages))
+	parallel := processHistoryMessages(messages, true, 0)
+
+	require.Len(t, parallel, len(messages))
+	assert.Equal(t, sequential, parallel)
+
+	for i, result := range parallel {
+		assert.Equal(t, messages[i].Role, result.role, "index=%d", i)
+	}
+	assert.Equal(t, "question 0", parallel[0].text)
+	assert.Equal(t, "answer 99", parallel[len(parallel)-1].text)
+}
+
+func TestProcessHistoryMessages_SingleFailureIsolated(t *testing.T) {
+	messages := buildLongConversation(60)
+	messages[40] = invalidImageMessage()
+
+	results := processHistoryMessages(messages, true, 0)
+
+	require.Len(t, results, len(messages))
+	require.Error(t, results[40].err)
+	assert.Empty(t, results[40].text)
+
+	for i, result := range results {
+		if i == 40 {
+			continue
+		}
+		assert.NoError(t, result.err, "index=%d", i)
+	}
+	assert.Equal(t, "answer 20", results[41].text)
+}
+
+func TestBuildHistory_ParallelMatchesSequential(t *testing.T) {
+	messages := append(buildLongConversation(80), userMsg("current"))
+	messages[10] = invalidImageMessage()
+	req := types.AnthropicRequest{Model: "claude-sonnet-4", Messages: messages}
+
+	sequentialBuilder := NewRequestBuilder()
+	sequentialBuilder.parallelThreshold = len(messages)
+	parallelBuilder := NewRequestBuilder()
+	parallelBuilder.parallelThreshold = 1
+
+	sequential, err := sequentialBuilder.buildHistory(newHistoryState(t, sequentialBuilder, req))
+	require.NoError(t, err)
+	parallel, err := parallelBuilder.buildHistory(newHistoryState(t, parallelBuilder, req))
+	require.NoError(t, err)
+
+	history := parallel.cwReq.ConversationState.History
+	assert.Equal(t, sequential.cwReq.ConversationState.History, history)
+	require.Len(t, history, 160)
+
+	// 处理失败的消息只丢失自身的文本，配对关系不变
+	failed := historyUser(t, history[10])
+	assert.Equal(t, "", failed.UserInputMessage.Content)
+	assert.Equal(t, "answer 5", historyAssistant(t, history[11]).AssistantResponseMessage.Content)
+}
+
+func benchmarkProcessHistoryMessages(b *testing.B, threshold int) {
+	messages := buildLongConversation(200)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		processHistoryMessages(messages, true, threshold)
+	}
+}
+
+func BenchmarkProcessHistoryMessages_Sequential(b *testing.B) {
+	benchmarkProcessHistoryMessages(b, 1<<30)
+}
+
+func BenchmarkProcessHistoryMessages_Parallel(b *testing.B) {
+	benchmarkProcessHistoryMessages(b, 0)
+}
+
+func TestExtractAssistantContent(t *testing.T) {
+	t.Run("文本与工具调用来自同一条消息", func(t *testing.T) {
+		text, toolUses, err := extractAssistantContent([]any{
+			map[string]any{"type": "text", "text": "Let me check both files."},
+			map[string]any{"type": "tool_use", "id": "t1", "name": "read_file", "input": map[string]any{"path": "a.go"}},
+			map[string]any{"type": "text", "text": "And the second one."},
+			map[string]any{"type": "tool_use", "id": "t2", "name": "read_file"},
+		}, true)
+		require.NoError(t, err)
+		assert.Equal(t, "Let me check both files.\nAnd the second one.", text)
+		require.Len(t, toolUses, 2)
+		assert.Equal(t, "t1", toolUses[0].ToolUseId)
+		assert.Equal(t, map[string]any{"path": "a.go"}, toolUses[0].Input)
+		assert.Equal(t, map[string]any{}, toolUses[1].Input)
+	})
+
+	t.Run("ContentBlock切片", func(t *testing.T) {
+		text, id, name := "Reading.", "t1", "read_file"
+		var input any = map[string]any{"path": "a.go"}
+		got, toolUses, err := extractAssistantContent([]types.ContentBlock{
+			{Type: "text", Text: &text},
+			{Type: "tool_use", ID: &id, Name: &name, Input: &input},
+		}, true)
+		require.NoError(t, err)
+		assert.Equal(t, "Reading.", got)
+		require.Len(t, toolUses, 1)
+		assert.Equal(t, "read_file", toolUses[0].Name)
+	})
+
+	t.Run("只有工具调用或空文本时使用占位文本", func(t *testing.T) {
+		text, toolUses, err := extractAssistantContent([]any{
+			map[string]any{"type": "text", "text": ""},
+			map[string]any{"type": "tool_use", "id": "t1", "name": "read_file", "input": map[string]any{}},
+		}, true)
+		require.NoError(t, err)
+		assert.Equal(t, utils.EmptyMessagePlaceholder, text)
+		assert.Len(t, toolUses, 1)
+	})
+
+	t.Run("过滤web_search时保留文本", func(t *testing.T) {
+		text, toolUses, err := extractAssistantContent([]any{
+			map[string]any{"type": "text", "text": "Searching."},
+			map[string]any{"type": "tool_use", "id": "t1", "name": "web_search", "input": map[string]any{"query": "q"}},
+		}, true)
+		require.NoError(t, err)
+		assert.Equal(t, "Searching.", text)
+		assert.Empty(t, toolUses)
+	})
+
+	t.Run("纯文本", func(t *testing.T) {
+		text, toolUses, err := extractAssistantContent("plain answer", true)
+		require.NoError(t, err)
+		assert.Equal(t, "plain answer", text)
+		assert.Nil(t, toolUses)
+	})
+}
+
+// TestBuildHistory_AssistantTextAndToolUsesBeforeToolResultTurn 当前消息只有工具结果时，
+// 上一条助手消息的文本和工具调用都应出现在同一条历史记录中
+func TestBuildHistory_AssistantTextAndToolUsesBeforeToolResultTurn(t *testing.T) {
+	b := NewRequestBuilder()
+	state := newHistoryState(t, b, types.AnthropicRequest{
+		Model: "claude-sonnet-4",
+		Messages: []types.AnthropicRequestMessage{
+			userMsg("Compare a.go and b.go"),
+			assistantMsg([]any{
+				map[string]any{"type": "text", "text": "I'll read both files."},
+				map[string]any{"type": "tool_use", "id": "t1", "name": "read_file", "input": map[string]any{"path": "a.go"}},
+				map[string]any{"type": "tool_use", "id": "t2", "name": "read_file", "input": map[string]any{"path": "b.go"}},
+			}),
+			userMsg([]any{
+				map[string]any{"type": "tool_result", "tool_use_id": "t1", "content": "package a"},
+				map[string]any{"type": "tool_result", "tool_use_id": "t2", "content": "package b"},
+			}),
+		},
+	})
+	state, err := b.buildHistory(state)
+	require.NoError(t, err)
+
+	history := state.cwReq.ConversationState.History
+	require.Len(t, history, 2)
+	assert.Equal(t, "Compare a.go and b.go", historyUser(t, history[0]).UserInputMessage.Content)
+
+	assistant := historyAssistant(t, history[1]).AssistantResponseMessage
+	assert.Equal(t, "I'll read both files.", assistant.Content)
+	require.Len(t, assistant.ToolUses, 2)
+	assert.Equal(t, "t1", assistant.ToolUses[0].ToolUseId)
+	assert.Equal(t, "t2", assistant.ToolUses[1].ToolUseId)
+
+	current := state.cwReq.ConversationState.CurrentMessage.UserInputMessage
+	require.Len(t, current.UserInputMessageContext.ToolResults, 2)
+	assert.Equal(t, "t1", current.UserInputMessageContext.ToolResults[0].ToolUseId)
+}
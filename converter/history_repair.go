@@ -0,0 +1,219 @@
+package converter
+
+import (
+	"slices"
+	"strings"
+
+	"kiro2api/types"
+)
+
+// HistoryRepair 工具调用顺序修复的结果
+type HistoryRepair struct {
+	Reordered []string // 通过交换相邻轮次修复的 tool_use id
+	Dropped   []string // 无法修复而丢弃的 tool_result 引用的 id
+}
+
+// Repaired 是否修改了历史
+func (r HistoryRepair) Repaired() bool {
+	return len(r.Reordered) > 0 || len(r.Dropped) > 0
+}
+
+// HeaderValue X-Kiro-History-Repaired 响应头的值，只列出非空的部分
+func (r HistoryRepair) HeaderValue() string {
+	var parts []string
+	if len(r.Reordered) > 0 {
+		parts = append(parts, "reordered="+strings.Join(r.Reordered, ","))
+	}
+	if len(r.Dropped) > 0 {
+		parts = append(parts, "dropped="+strings.Join(r.Dropped, ","))
+	}
+	return strings.Join(parts, ";")
+}
+
+// RepairToolOrder 修复 tool_result 出现在对应 tool_use 之前的历史（部分客户端按时间戳排序时会打乱工具轮次）
+// 对每个之前没有对应 tool_use 的 tool_result：
+//  1. 该 tool_use 之后已有同 id 的 tool_result 时，提前出现的这个视为重复，直接丢弃
+//  2. 结果所在的 user 消息紧挨在调用所在的 assistant 消息之前，且消息中的全部 tool_result 都属于这条 assistant 消息时，交换这两条消息
+//  3. 其他情况（不相邻、与其他调用混在一起、调用位于最后一条消息）丢弃该 tool_result，丢弃后为空的消息整条移除
+//
+// 完全没有对应 tool_use 的 tool_result 不在修复范围内，保持原样。
+// 结果只取决于消息内容，相同输入总是得到相同输出；原消息不会被修改
+func RepairToolOrder(messages []types.AnthropicRequestMessage) ([]types.AnthropicRequestMessage, HistoryRepair) {
+	var repair HistoryRepair
+
+	useAt := make(map[string][]int)    // tool_use id -> 所在消息下标（升序）
+	resultAt := make(map[string][]int) // tool_result id -> 所在消息下标（升序）
+	for i, msg := range messages {
+		switch msg.Role {
+		case "assistant":
+			for _, id := range toolBlockIDs(msg.Content, "tool_use") {
+				useAt[id] = append(useAt[id], i)
+			}
+		case "user":
+			for _, id := range toolBlockIDs(msg.Content, "tool_result") {
+				resultAt[id] = append(resultAt[id], i)
+			}
+		}
+	}
+
+	// early 记录每条 user 消息中提前出现的 tool_result：id -> 其后第一个对应 tool_use 的下标
+	early := make(map[int]map[string]int)
+	for i, msg := range messages {
+		if msg.Role != "user" {
+			continue
+		}
+		for _, id := range toolBlockIDs(msg.Content, "tool_result") {
+			uses := useAt[id]
+			if len(uses) == 0 || uses[0] < i {
+				continue
+			}
+			j := uses[slices.IndexFunc(uses, func(u int) bool { return u > i })]
+			if early[i] == nil {
+				early[i] = make(map[string]int)
+			}
+			early[i][id] = j
+		}
+	}
+	if len(early) == 0 {
+		return messages, repair
+	}
+
+	swap := make(map[int]bool)
+	drop := make(map[int]map[string]bool)
+	for i := range messages {
+		ids := early[i]
+		if ids == nil {
+			continue
+		}
+		if canSwapToolTurns(messages, i, ids, useAt, resultAt) {
+			swap[i] = true
+			for _, id := range toolBlockIDs(messages[i].Content, "tool_result") {
+				repair.Reordered = appendUnique(repair.Reordered, id)
+			}
+			continue
+		}
+		drop[i] = make(map[string]bool, len(ids))
+		for _, id := range toolBlockIDs(messages[i].Content, "tool_result") {
+			if _, ok := ids[id]; ok {
+				drop[i][id] = true
+				repair.Dropped = appendUnique(repair.Dropped, id)
+			}
+		}
+	}
+
+	repaired := make([]types.AnthropicRequestMessage, 0, len(messages))
+	for i := 0; i < len(messages); i++ {
+		if swap[i] {
+			repaired = append(repaired, messages[i+1], messages[i])
+			i++
+			continue
+		}
+		msg := messages[i]
+		if ids := drop[i]; ids != nil {
+			content, remaining := dropToolResults(msg.Content, ids)
+			if remaining == 0 {
+				continue
+			}
+			msg.Content = content
+		}
+		repaired = append(repaired, msg)
+	}
+	return repaired, repair
+}
+
+// canSwapToolTurns 判断第 i 条 user 消息能否与紧随其后的 assistant 消息交换
+// 要求消息中的每个 tool_result 都引用下一条消息中的 tool_use，且这些调用尚未在之后得到结果，交换后不会破坏其他配对
+func canSwapToolTurns(messages []types.AnthropicRequestMessage, i int, early map[string]int, useAt, resultAt map[string][]int) bool {
+	j := i + 1
+	if j >= len(messages)-1 || messages[j].Role != "assistant" {
+		return false
+	}
+	for _, id := range toolBlockIDs(messages[i].Content, "tool_result") {
+		use, ok := early[id]
+		if !ok || use != j {
+			return false
+		}
+		if answeredAfter(id, j, useAt, resultAt) {
+			return false
+		}
+	}
+	return true
+}
+
+// answeredAfter 第 j 条消息中的调用在同 id 的下一次调用之前是否已有结果；有则提前出现的结果是重复的
+func answeredAfter(id string, j int, useAt, resultAt map[string][]int) bool {
+	next := -1
+	if k := slices.IndexFunc(useAt[id], func(u int) bool { return u > j }); k >= 0 {
+		next = useAt[id][k]
+	}
+	return slices.ContainsFunc(resultAt[id], func(r int) bool { return r > j && (next < 0 || r < next) })
+}
+
+// toolBlockIDs 按出现顺序返回内容中指定类型工具块的id（tool_use 取 id，tool_result 取 tool_use_id）
+func toolBlockIDs(content any, blockType string) []string {
+	var ids []string
+	switch v := content.(type) {
+	case []any:
+		for _, item := range v {
+			block, ok := item.(map[string]any)
+			if !ok || block["type"] != blockType {
+				continue
+			}
+			key := "id"
+			if blockType == "tool_result" {
+				key = "tool_use_id"
+			}
+			if id, _ := block[key].(string); id != "" {
+				ids = append(ids, id)
+			}
+		}
+	case []types.ContentBlock:
+		for _, block := range v {
+			if block.Type != blockType {
+				continue
+			}
+			id := block.ID
+			if blockType == "tool_result" {
+				id = block.ToolUseId
+			}
+			if id != nil && *id != "" {
+				ids = append(ids, *id)
+			}
+		}
+	}
+	return ids
+}
+
+// dropToolResults 移除引用 ids 中工具调用的 tool_result 块，返回新内容和剩余块数（不修改原内容）
+func dropToolResults(content any, ids map[string]bool) (any, int) {
+	switch v := content.(type) {
+	case []any:
+		kept := make([]any, 0, len(v))
+		for _, item := range v {
+			if block, ok := item.(map[string]any); ok && block["type"] == "tool_result" {
+				if id, _ := block["tool_use_id"].(string); ids[id] {
+					continue
+				}
+			}
+			kept = append(kept, item)
+		}
+		return kept, len(kept)
+	case []types.ContentBlock:
+		kept := make([]types.ContentBlock, 0, len(v))
+		for _, block := range v {
+			if block.Type == "tool_result" && block.ToolUseId != nil && ids[*block.ToolUseId] {
+				continue
+			}
+			kept = append(kept, block)
+		}
+		return kept, len(kept)
+	}
+	return content, 1
+}
+
+func appendUnique(ids []string, id string) []string {
+	if slices.Contains(ids, id) {
+		return ids
+	}
+	return append(ids, id)
+}
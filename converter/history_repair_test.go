@@ -0,0 +1,194 @@
+package converter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"kiro2api/types"
+	"kiro2api/utils"
+)
+
+func parseMessages(t *testing.T, fixture string) []types.AnthropicRequestMessage {
+	t.Helper()
+	var messages []types.AnthropicRequestMessage
+	require.NoError(t, utils.SafeUnmarshal([]byte(fixture), &messages))
+	return messages
+}
+
+func TestRepairToolOrder(t *testing.T) {
+	tests := []struct {
+		name   string
+		before string
+		after  string
+		repair HistoryRepair
+		header string
+	}{
+		{
+			name: "顺序正确时不修改",
+			before: `[
+				{"role": "user", "content": "读取文件"},
+				{"role": "assistant", "content": [{"type": "tool_use", "id": "t1", "name": "read", "input": {}}]},
+				{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "t1", "content": "ok"}]},
+				{"role": "assistant", "content": "完成"},
+				{"role": "user", "content": "继续"}
+			]`,
+			after: `[
+				{"role": "user", "content": "读取文件"},
+				{"role": "assistant", "content": [{"type": "tool_use", "id": "t1", "name": "read", "input": {}}]},
+				{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "t1", "content": "ok"}]},
+				{"role": "assistant", "content": "完成"},
+				{"role": "user", "content": "继续"}
+			]`,
+		},
+		{
+			name: "相邻轮次颠倒时交换",
+			before: `[
+				{"role": "user", "content": "读取文件"},
+				{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "t1", "content": "ok"}]},
+				{"role": "assistant", "content": [{"type": "text", "text": "读取中"}, {"type": "tool_use", "id": "t1", "name": "read", "input": {}}]},
+				{"role": "assistant", "content": "完成"},
+				{"role": "user", "content": "继续"}
+			]`,
+			after: `[
+				{"role": "user", "content": "读取文件"},
+				{"role": "assistant", "content": [{"type": "text", "text": "读取中"}, {"type": "tool_use", "id": "t1", "name": "read", "input": {}}]},
+				{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "t1", "content": "ok"}]},
+				{"role": "assistant", "content": "完成"},
+				{"role": "user", "content": "继续"}
+			]`,
+			repair: HistoryRepair{Reordered: []string{"t1"}},
+			header: "reordered=t1",
+		},
+		{
+			name: "不相邻时丢弃孤立结果",
+			before: `[
+				{"role": "user", "content": [{"type": "text", "text": "读取文件"}, {"type": "tool_result", "tool_use_id": "t1", "content": "ok"}]},
+				{"role": "assistant", "content": "好的"},
+				{"role": "user", "content": "请读取"},
+				{"role": "assistant", "content": [{"type": "tool_use", "id": "t1", "name": "read", "input": {}}]},
+				{"role": "user", "content": "继续"}
+			]`,
+			after: `[
+				{"role": "user", "content": [{"type": "text", "text": "读取文件"}]},
+				{"role": "assistant", "content": "好的"},
+				{"role": "user", "content": "请读取"},
+				{"role": "assistant", "content": [{"type": "tool_use", "id": "t1", "name": "read", "input": {}}]},
+				{"role": "user", "content": "继续"}
+			]`,
+			repair: HistoryRepair{Dropped: []string{"t1"}},
+			header: "dropped=t1",
+		},
+		{
+			name: "与其他调用的结果混在一起时只丢弃提前的结果",
+			before: `[
+				{"role": "user", "content": "开始"},
+				{"role": "assistant", "content": [{"type": "tool_use", "id": "t1", "name": "read", "input": {}}]},
+				{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "t1", "content": "a"}, {"type": "tool_result", "tool_use_id": "t2", "content": "b"}]},
+				{"role": "assistant", "content": [{"type": "tool_use", "id": "t2", "name": "read", "input": {}}]},
+				{"role": "user", "content": "继续"}
+			]`,
+			after: `[
+				{"role": "user", "content": "开始"},
+				{"role": "assistant", "content": [{"type": "tool_use", "id": "t1", "name": "read", "input": {}}]},
+				{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "t1", "content": "a"}]},
+				{"role": "assistant", "content": [{"type": "tool_use", "id": "t2", "name": "read", "input": {}}]},
+				{"role": "user", "content": "继续"}
+			]`,
+			repair: HistoryRepair{Dropped: []string{"t2"}},
+			header: "dropped=t2",
+		},
+		{
+			name: "同一id的结果出现两次时丢弃提前的重复结果并移除空消息",
+			before: `[
+				{"role": "user", "content": "开始"},
+				{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "t1", "content": "旧"}]},
+				{"role": "assistant", "content": [{"type": "tool_use", "id": "t1", "name": "read", "input": {}}]},
+				{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "t1", "content": "新"}]},
+				{"role": "assistant", "content": "完成"},
+				{"role": "user", "content": "继续"}
+			]`,
+			after: `[
+				{"role": "user", "content": "开始"},
+				{"role": "assistant", "content": [{"type": "tool_use", "id": "t1", "name": "read", "input": {}}]},
+				{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "t1", "content": "新"}]},
+				{"role": "assistant", "content": "完成"},
+				{"role": "user", "content": "继续"}
+			]`,
+			repair: HistoryRepair{Dropped: []string{"t1"}},
+			header: "dropped=t1",
+		},
+		{
+			name: "同一id的调用出现两次时按最近的调用配对",
+			before: `[
+				{"role": "user", "content": "开始"},
+				{"role": "assistant", "content": [{"type": "tool_use", "id": "t1", "name": "read", "input": {}}]},
+				{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "t1", "content": "第一次"}]},
+				{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "t9", "content": "第二次"}]},
+				{"role": "assistant", "content": [{"type": "tool_use", "id": "t9", "name": "read", "input": {}}]},
+				{"role": "assistant", "content": [{"type": "tool_use", "id": "t1", "name": "read", "input": {}}]},
+				{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "t1", "content": "第三次"}]},
+				{"role": "assistant", "content": "完成"},
+				{"role": "user", "content": "继续"}
+			]`,
+			after: `[
+				{"role": "user", "content": "开始"},
+				{"role": "assistant", "content": [{"type": "tool_use", "id": "t1", "name": "read", "input": {}}]},
+				{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "t1", "content": "第一次"}]},
+				{"role": "assistant", "content": [{"type": "tool_use", "id": "t9", "name": "read", "input": {}}]},
+				{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "t9", "content": "第二次"}]},
+				{"role": "assistant", "content": [{"type": "tool_use", "id": "t1", "name": "read", "input": {}}]},
+				{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "t1", "content": "第三次"}]},
+				{"role": "assistant", "content": "完成"},
+				{"role": "user", "content": "继续"}
+			]`,
+			repair: HistoryRepair{Reordered: []string{"t9"}},
+			header: "reordered=t9",
+		},
+		{
+			name: "调用在最后一条消息时不交换",
+			before: `[
+				{"role": "user", "content": "开始"},
+				{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "t1", "content": "ok"}]},
+				{"role": "assistant", "content": [{"type": "tool_use", "id": "t1", "name": "read", "input": {}}]}
+			]`,
+			after: `[
+				{"role": "user", "content": "开始"},
+				{"role": "assistant", "content": [{"type": "tool_use", "id": "t1", "name": "read", "input": {}}]}
+			]`,
+			repair: HistoryRepair{Dropped: []string{"t1"}},
+			header: "dropped=t1",
+		},
+		{
+			name: "没有对应调用的结果保持原样",
+			before: `[
+				{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "missing", "content": "ok"}]},
+				{"role": "assistant", "content": "好的"},
+				{"role": "user", "content": "继续"}
+			]`,
+			after: `[
+				{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "missing", "content": "ok"}]},
+				{"role": "assistant", "content": "好的"},
+				{"role": "user", "content": "继续"}
+			]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := parseMessages(t, tt.before)
+			original := parseMessages(t, tt.before)
+
+			repaired, repair := RepairToolOrder(before)
+
+			assert.Equal(t, parseMessages(t, tt.after), repaired)
+			assert.Equal(t, tt.repair, repair)
+			assert.Equal(t, tt.repair.Repaired(), repair.Repaired())
+			assert.Equal(t, tt.header, repair.HeaderValue())
+			assert.Equal(t, original, before, "原消息不应被修改")
+
+			again, _ := RepairToolOrder(parseMessages(t, tt.before))
+			assert.Equal(t, repaired, again, "修复结果应是确定的")
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package converter
 
 import (
+	"fmt"
 	"strings"
 	"time"
 
@@ -11,7 +12,9 @@ import (
 // OpenAI格式转换器
 
 // ConvertOpenAIToAnthropic 将OpenAI请求转换为Anthropic请求
-func ConvertOpenAIToAnthropic(openaiReq types.OpenAIRequest) types.AnthropicRequest {
+// tools 为空数组时与未提供 tools 等价；此时 tool_choice 指定具体工具返回错误，其余取值被忽略
+// 被跳过的工具和缩短的参数名记录到 warnings，传nil表示不关心
+func ConvertOpenAIToAnthropic(openaiReq types.OpenAIRequest, warnings *Warnings) (types.AnthropicRequest, error) {
 	var anthropicMessages []types.AnthropicRequestMessage
 
 	// 转换消息
@@ -44,10 +47,11 @@ func ConvertOpenAIToAnthropic(openaiReq types.OpenAIRequest) types.AnthropicRequ
 	}
 
 	anthropicReq := types.AnthropicRequest{
-		Model:     openaiReq.Model,
-		MaxTokens: maxTokens,
-		Messages:  anthropicMessages,
-		Stream:    stream,
+		Model:          openaiReq.Model,
+		MaxTokens:      maxTokens,
+		Messages:       anthropicMessages,
+		Stream:         stream,
+		ResponseFormat: openaiReq.ResponseFormat,
 	}
 
 	if openaiReq.Temperature != nil {
@@ -56,12 +60,8 @@ func ConvertOpenAIToAnthropic(openaiReq types.OpenAIRequest) types.AnthropicRequ
 
 	// 转换 tools
 	if len(openaiReq.Tools) > 0 {
-		anthropicTools, err := validateAndProcessTools(openaiReq.Tools)
-		if err != nil {
-			// 记录警告但不中断处理，允许部分工具失败
-			// 可以考虑返回错误，取决于业务需求
-			// 这里参考server.py的做法，记录错误但继续处理有效的工具
-		}
+		// 无效工具不中断处理，已逐个记录到 warnings，参考server.py的做法继续处理有效的工具
+		anthropicTools, _ := validateAndProcessTools(openaiReq.Tools, warnings)
 		anthropicReq.Tools = anthropicTools
 	}
 
@@ -70,7 +70,15 @@ func ConvertOpenAIToAnthropic(openaiReq types.OpenAIRequest) types.AnthropicRequ
 		anthropicReq.ToolChoice = convertOpenAIToolChoiceToAnthropic(openaiReq.ToolChoice)
 	}
 
-	return anthropicReq
+	// 客户端明确传入 tools:[] 却指定了具体工具，无法满足，直接报错；
+	// 工具全部被过滤的情况沿用原有的宽松处理
+	if len(openaiReq.Tools) == 0 {
+		if tc, ok := anthropicReq.ToolChoice.(*types.ToolChoice); ok && tc != nil && tc.Type == "tool" {
+			return types.AnthropicRequest{}, fmt.Errorf("tool_choice 指定了工具 %q，但 tools 为空", tc.Name)
+		}
+	}
+
+	return NormalizeEmptyTools(anthropicReq), nil
 }
 
 // ConvertAnthropicToOpenAI 将Anthropic响应转换为OpenAI响应
@@ -4,8 +4,10 @@ import (
 	"testing"
 
 	"kiro2api/types"
+	"kiro2api/utils"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestConvertOpenAIToAnthropic_BasicMessage(t *testing.T) {
@@ -24,7 +26,8 @@ func TestConvertOpenAIToAnthropic_BasicMessage(t *testing.T) {
 		},
 	}
 
-	anthropicReq := ConvertOpenAIToAnthropic(openaiReq)
+	anthropicReq, err := ConvertOpenAIToAnthropic(openaiReq, nil)
+	require.NoError(t, err)
 
 	assert.NotEmpty(t, anthropicReq.Model, "模型不应为空")
 	assert.Equal(t, 1024, anthropicReq.MaxTokens)
@@ -51,7 +54,8 @@ func TestConvertOpenAIToAnthropic_SystemMessage(t *testing.T) {
 		},
 	}
 
-	anthropicReq := ConvertOpenAIToAnthropic(openaiReq)
+	anthropicReq, err := ConvertOpenAIToAnthropic(openaiReq, nil)
+	require.NoError(t, err)
 
 	// 当前实现保留system消息在messages中（不提取到System字段）
 	assert.Len(t, anthropicReq.Messages, 2)
@@ -81,7 +85,8 @@ func TestConvertOpenAIToAnthropic_MultipleMessages(t *testing.T) {
 		},
 	}
 
-	anthropicReq := ConvertOpenAIToAnthropic(openaiReq)
+	anthropicReq, err := ConvertOpenAIToAnthropic(openaiReq, nil)
+	require.NoError(t, err)
 
 	assert.Len(t, anthropicReq.Messages, 3)
 	assert.Equal(t, "user", anthropicReq.Messages[0].Role)
@@ -100,7 +105,8 @@ func TestConvertOpenAIToAnthropic_DefaultMaxTokens(t *testing.T) {
 		},
 	}
 
-	anthropicReq := ConvertOpenAIToAnthropic(openaiReq)
+	anthropicReq, err := ConvertOpenAIToAnthropic(openaiReq, nil)
+	require.NoError(t, err)
 
 	// 应该使用默认值16384
 	assert.Equal(t, 16384, anthropicReq.MaxTokens)
@@ -117,7 +123,8 @@ func TestConvertOpenAIToAnthropic_StreamDefault(t *testing.T) {
 		},
 	}
 
-	anthropicReq := ConvertOpenAIToAnthropic(openaiReq)
+	anthropicReq, err := ConvertOpenAIToAnthropic(openaiReq, nil)
+	require.NoError(t, err)
 
 	// Stream默认应该为false
 	assert.False(t, anthropicReq.Stream)
@@ -180,6 +187,29 @@ func TestConvertAnthropicToOpenAI_MultipleContentBlocks(t *testing.T) {
 	assert.Contains(t, openaiResp.Choices[0].Message.Content, "Second part")
 }
 
+func TestConvertAnthropicToOpenAI_ToolCallsKeepOrder(t *testing.T) {
+	ids := []string{"tooluse_first", "tooluse_second", "tooluse_third"}
+	content := []map[string]any{}
+	for i, id := range ids {
+		content = append(content, map[string]any{
+			"type":  "tool_use",
+			"id":    id,
+			"name":  []string{"read_file", "list_dir", "grep_search"}[i],
+			"input": map[string]any{"index": i},
+		})
+	}
+
+	openaiResp := ConvertAnthropicToOpenAI(map[string]any{"content": content}, "claude-sonnet-4", "chatcmpl-1")
+
+	toolCalls := openaiResp.Choices[0].Message.ToolCalls
+	assert.Len(t, toolCalls, len(ids))
+	for i, id := range ids {
+		// tool_call id应原样保留，顺序与content一致
+		assert.Equal(t, id, toolCalls[i].ID)
+	}
+	assert.Equal(t, "tool_calls", openaiResp.Choices[0].FinishReason)
+}
+
 func TestConvertAnthropicToOpenAI_StopReasonMapping(t *testing.T) {
 	tests := []struct {
 		name                 string
@@ -219,7 +249,8 @@ func TestConvertOpenAIToAnthropic_EmptyMessages(t *testing.T) {
 		Messages: []types.OpenAIMessage{},
 	}
 
-	anthropicReq := ConvertOpenAIToAnthropic(openaiReq)
+	anthropicReq, err := ConvertOpenAIToAnthropic(openaiReq, nil)
+	require.NoError(t, err)
 
 	// 应该返回空消息数组
 	assert.Empty(t, anthropicReq.Messages)
@@ -239,3 +270,96 @@ func TestConvertAnthropicToOpenAI_EmptyContent(t *testing.T) {
 	assert.Len(t, openaiResp.Choices, 1)
 	assert.Empty(t, openaiResp.Choices[0].Message.Content)
 }
+
+func TestConvertOpenAIToAnthropic_EmptyToolsIgnoresToolChoice(t *testing.T) {
+	tests := []struct {
+		name       string
+		toolChoice string
+	}{
+		{"未指定", ``},
+		{"auto", `, "tool_choice": "auto"`},
+		{"none", `, "tool_choice": "none"`},
+		{"required", `, "tool_choice": "required"`},
+		{"未知字符串", `, "tool_choice": "sometimes"`},
+		{"无法解析的对象", `, "tool_choice": {"type": "auto"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var openaiReq types.OpenAIRequest
+			body := `{"model": "claude-sonnet-4", "messages": [{"role": "user", "content": "你好"}], "tools": []` + tt.toolChoice + `}`
+			require.NoError(t, utils.SafeUnmarshal([]byte(body), &openaiReq))
+
+			anthropicReq, err := ConvertOpenAIToAnthropic(openaiReq, nil)
+			require.NoError(t, err)
+			assert.Nil(t, anthropicReq.Tools)
+			assert.Nil(t, anthropicReq.ToolChoice, "没有工具时 tool_choice 应被忽略")
+			assert.Empty(t, ValidateAnthropicRequest(anthropicReq))
+
+			cwReq, err := NewRequestBuilder(WithConversionCache(nil)).Build(anthropicReq, nil)
+			require.NoError(t, err)
+			assert.Equal(t, "MANUAL", cwReq.ConversationState.ChatTriggerType)
+			assert.Empty(t, cwReq.ConversationState.CurrentMessage.UserInputMessage.UserInputMessageContext.Tools)
+			assert.Empty(t, cwReq.ConversationState.History, "单条消息且无工具时不应构建历史")
+		})
+	}
+}
+
+func TestConvertOpenAIToAnthropic_EmptyToolsWithNamedToolChoice(t *testing.T) {
+	tests := []struct {
+		name       string
+		toolChoice any
+	}{
+		{"对象", map[string]any{"type": "function", "function": map[string]any{"name": "get_weather"}}},
+		{"结构体", types.OpenAIToolChoice{Type: "function", Function: &types.OpenAIToolChoiceFunction{Name: "get_weather"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openaiReq := types.OpenAIRequest{
+				Model:      "claude-sonnet-4",
+				Messages:   []types.OpenAIMessage{{Role: "user", Content: "北京天气"}},
+				Tools:      []types.OpenAITool{},
+				ToolChoice: tt.toolChoice,
+			}
+
+			_, err := ConvertOpenAIToAnthropic(openaiReq, nil)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), `tool_choice 指定了工具 "get_weather"，但 tools 为空`)
+		})
+	}
+}
+
+func TestConvertOpenAIToAnthropic_FilteredToolsDropToolChoice(t *testing.T) {
+	t.Setenv("WEB_SEARCH_MODE", "drop")
+
+	openaiReq := types.OpenAIRequest{
+		Model:    "claude-sonnet-4",
+		Messages: []types.OpenAIMessage{{Role: "user", Content: "搜索一下"}},
+		Tools: []types.OpenAITool{{
+			Type:     "function",
+			Function: types.OpenAIFunction{Name: "web_search", Parameters: map[string]any{"type": "object"}},
+		}},
+		ToolChoice: "required",
+	}
+
+	anthropicReq, err := ConvertOpenAIToAnthropic(openaiReq, nil)
+	require.NoError(t, err)
+	assert.Nil(t, anthropicReq.Tools)
+	assert.Nil(t, anthropicReq.ToolChoice)
+	assert.Equal(t, "MANUAL", determineChatTriggerType(anthropicReq))
+}
+
+func TestNormalizeEmptyTools(t *testing.T) {
+	req := NormalizeEmptyTools(types.AnthropicRequest{
+		Tools:      []types.AnthropicTool{},
+		ToolChoice: map[string]any{"type": "any"},
+	})
+	assert.Nil(t, req.Tools)
+	assert.Nil(t, req.ToolChoice)
+
+	tools := []types.AnthropicTool{{Name: "read_file"}}
+	req = NormalizeEmptyTools(types.AnthropicRequest{Tools: tools, ToolChoice: map[string]any{"type": "any"}})
+	assert.Equal(t, tools, req.Tools)
+	assert.Equal(t, map[string]any{"type": "any"}, req.ToolChoice)
+}
@@ -0,0 +1,598 @@
+package converter
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"kiro2api/config"
+	"kiro2api/logger"
+	"kiro2api/types"
+	"kiro2api/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BuilderOption RequestBuilder 的函数式配置项
+type BuilderOption func(*RequestBuilder)
+
+// WithConversationID 使用指定的会话ID，而不是基于客户端信息生成
+func WithConversationID(id string) BuilderOption {
+	return func(b *RequestBuilder) {
+		b.conversationID = id
+	}
+}
+
+// WithoutWebSearchFilter 关闭 web_search 工具过滤（工具定义与历史工具调用均保留）
+func WithoutWebSearchFilter() BuilderOption {
+	return func(b *RequestBuilder) {
+		b.filterWebSearch = false
+	}
+}
+
+// WithToolFilter 使用指定的工具黑白名单，而不是全局配置
+func WithToolFilter(filter config.ToolFilter) BuilderOption {
+	return func(b *RequestBuilder) {
+		b.toolFilter = filter
+	}
+}
+
+// WithHistoryLimit 限制历史中保留的对话轮数（user/assistant 配对），系统提示配对不计入
+// n <= 0 表示不限制
+func WithHistoryLimit(n int) BuilderOption {
+	return func(b *RequestBuilder) {
+		b.historyLimit = n
+	}
+}
+
+// WithConversionCache 使用指定的转换缓存，nil 表示不缓存
+func WithConversionCache(cache *RequestConversionCache) BuilderOption {
+	return func(b *RequestBuilder) {
+		b.cache = cache
+	}
+}
+
+// WithSystemPromptInjection 使用指定的系统提示注入，而不是全局配置；传入空值表示不注入
+func WithSystemPromptInjection(injection SystemPromptInjection) BuilderOption {
+	return func(b *RequestBuilder) {
+		b.injection = injection
+	}
+}
+
+// WithHistoryAdjustments 构建完成后把历史调整记录写入 dst，命中转换缓存时写入缓存的记录
+func WithHistoryAdjustments(dst *[]HistoryAdjustment) BuilderOption {
+	return func(b *RequestBuilder) {
+		b.adjustments = dst
+	}
+}
+
+// WithWarnings 构建完成后把转换警告写入 dst，命中转换缓存时写入缓存的警告
+func WithWarnings(dst *Warnings) BuilderOption {
+	return func(b *RequestBuilder) {
+		b.warnings = dst
+	}
+}
+
+// WithProfileArn 在请求顶层携带指定的 profileArn，空字符串表示不携带
+func WithProfileArn(arn string) BuilderOption {
+	return func(b *RequestBuilder) {
+		b.profileArn = arn
+	}
+}
+
+// builderState 请求构建过程中在各阶段间传递的状态
+type builderState struct {
+	anthropicReq types.AnthropicRequest
+	ctx          *gin.Context
+	lastMessage  types.AnthropicRequestMessage
+	modelId      string
+	cwReq        types.CodeWhispererRequest
+	adjustments  []HistoryAdjustment // buildHistory 对客户端消息所做的调整
+	warnings     Warnings            // 各阶段累积的转换警告
+}
+
+// buildStage 单个构建阶段，接收并返回构建状态
+type buildStage func(*builderState) (*builderState, error)
+
+// RequestBuilder 将 Anthropic 请求分阶段转换为 CodeWhisperer 请求
+// 阶段顺序：identity -> current-message -> tools -> history -> validate
+type RequestBuilder struct {
+	conversationID    string
+	filterWebSearch   bool
+	historyLimit      int
+	parallelThreshold int // 历史消息数超过该值时并行预处理
+	cache             *RequestConversionCache
+	enhancer          *DescriptionEnhancer // 为nil时不补全工具描述
+	toolFilter        config.ToolFilter    // 创建时的黑白名单快照，热更新不影响已创建的构建器
+	injection         SystemPromptInjection
+	stopSequencesMode string // 创建时的 STOP_SEQUENCES_MODE 快照
+	profileArn        string
+
+	includeScreenshots bool   // 创建时的 INCLUDE_SCREENSHOTS_IN_HISTORY 快照
+	strictHistory      bool   // 创建时的 STRICT_HISTORY 快照
+	historyDocuments   string // 创建时的 HISTORY_INCLUDE_DOCUMENTS 快照
+
+	adjustments *[]HistoryAdjustment // 非nil时接收历史调整记录
+	warnings    *Warnings            // 非nil时接收转换警告
+}
+
+// NewRequestBuilder 创建请求构建器
+func NewRequestBuilder(opts ...BuilderOption) *RequestBuilder {
+	b := &RequestBuilder{
+		filterWebSearch:   filterWebSearchByMode(),
+		parallelThreshold: config.ParallelHistoryThreshold(),
+		cache:             GetRequestConversionCache(),
+		toolFilter:        ActiveToolFilter(),
+		injection:         ActiveSystemPromptInjection(),
+		stopSequencesMode: config.StopSequencesMode(),
+
+		includeScreenshots: config.IncludeScreenshotsInHistory(),
+		strictHistory:      config.IsStrictHistoryEnabled(),
+		historyDocuments:   config.HistoryDocumentsMode(),
+	}
+	if config.IsToolDescriptionEnhancementEnabled() {
+		b.enhancer = NewDescriptionEnhancer()
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// Build 构建请求；服务端系统提示先注入到客户端系统提示前后，再构建历史
+// 启用转换缓存时，相同请求复用已构建的结果，只重新执行 identity 阶段
+// 会话ID和代理延续ID依赖客户端上下文，命中缓存时也按本次请求重新生成
+func (b *RequestBuilder) Build(anthropicReq types.AnthropicRequest, ctx *gin.Context) (types.CodeWhispererRequest, error) {
+	anthropicReq.System = b.injection.Apply(anthropicReq.System)
+	if b.cache == nil {
+		return b.finish(b.build(anthropicReq, ctx))
+	}
+
+	key, ok := b.cacheKey(anthropicReq)
+	if !ok {
+		return b.finish(b.build(anthropicReq, ctx))
+	}
+
+	if cached, hit := b.cache.get(key); hit {
+		state, err := b.buildIdentity(&builderState{anthropicReq: anthropicReq, ctx: ctx, cwReq: cached.cwReq, adjustments: cached.adjustments, warnings: cached.warnings})
+		logger.Debug("请求转换缓存命中",
+			logger.String("conversation_id", state.cwReq.ConversationState.ConversationId))
+		return b.finish(state, err)
+	}
+
+	state, err := b.build(anthropicReq, ctx)
+	if err == nil {
+		b.cache.put(key, state.cwReq, state.adjustments, state.warnings)
+	}
+	return b.finish(state, err)
+}
+
+// finish 输出历史调整记录和转换警告并返回构建结果，STRICT_HISTORY 启用且需要调整历史时返回 HistoryViolationError
+// 调整的下标在缓存之外换算为客户端请求中的下标，内容相同、客户端下标不同的请求命中缓存时仍然正确
+func (b *RequestBuilder) finish(state *builderState, err error) (types.CodeWhispererRequest, error) {
+	adjustments := clientHistoryAdjustments(state.anthropicReq.Messages, state.adjustments)
+	if b.adjustments != nil {
+		*b.adjustments = adjustments
+	}
+	if b.warnings != nil {
+		*b.warnings = state.warnings
+	}
+	if err == nil && b.strictHistory && len(adjustments) > 0 {
+		return state.cwReq, &HistoryViolationError{Adjustment: adjustments[0]}
+	}
+	return state.cwReq, err
+}
+
+// build 依次执行各阶段，任一阶段出错即返回当前已构建的状态和错误
+func (b *RequestBuilder) build(anthropicReq types.AnthropicRequest, ctx *gin.Context) (*builderState, error) {
+	state := &builderState{
+		anthropicReq: anthropicReq,
+		ctx:          ctx,
+	}
+	b.applyStopSequences(state)
+
+	stages := []buildStage{
+		b.buildIdentity,
+		b.buildCurrentMessage,
+		b.buildTools,
+		b.buildHistory,
+		b.validate,
+	}
+
+	for _, stage := range stages {
+		next, err := stage(state)
+		if err != nil {
+			return next, err
+		}
+		state = next
+	}
+
+	return state, nil
+}
+
+// buildIdentity 设置代理延续ID、任务类型、触发类型、会话ID和 profileArn
+func (b *RequestBuilder) buildIdentity(state *builderState) (*builderState, error) {
+	state.cwReq.ProfileArn = b.profileArn
+	cs := &state.cwReq.ConversationState
+
+	// 使用稳定的代理延续ID生成器，保持会话连续性
+	cs.AgentContinuationId = utils.GenerateStableAgentContinuationID(state.ctx)
+	cs.AgentTaskType = "vibe" // 固定设置为"vibe"，符合参考文档
+	cs.ChatTriggerType = determineChatTriggerType(state.anthropicReq)
+
+	switch {
+	case b.conversationID != "":
+		cs.ConversationId = b.conversationID
+	case state.ctx != nil:
+		// 基于客户端信息生成持久化的conversationId
+		cs.ConversationId = utils.GenerateStableConversationID(state.ctx)
+	default:
+		// 向后兼容：如果没有提供context，仍使用UUID
+		cs.ConversationId = utils.GenerateUUID()
+		logger.Debug("使用随机UUID作为会话ID（向后兼容）",
+			logger.String("conversation_id", cs.ConversationId),
+			logger.String("agent_continuation_id", cs.AgentContinuationId),
+			logger.String("agent_task_type", cs.AgentTaskType))
+	}
+
+	return state, nil
+}
+
+// buildCurrentMessage 处理最后一条消息（文本、图片、工具结果）并解析模型映射
+func (b *RequestBuilder) buildCurrentMessage(state *builderState) (*builderState, error) {
+	messages := state.anthropicReq.Messages
+	if len(messages) == 0 {
+		return state, fmt.Errorf("消息列表为空")
+	}
+
+	state.lastMessage = messages[len(messages)-1]
+	userInput := &state.cwReq.ConversationState.CurrentMessage.UserInputMessage
+
+	textContent, images, err := processMessageContent(state.lastMessage.Content,
+		fmt.Sprintf("messages.%d.content", len(messages)-1), &state.warnings)
+	if err != nil {
+		return state, fmt.Errorf("处理消息内容失败: %v", err)
+	}
+
+	userInput.Content = textContent
+	// 确保Images字段始终是数组，即使为空
+	if len(images) > 0 {
+		userInput.Images = images
+	} else {
+		userInput.Images = []types.CodeWhispererImage{}
+	}
+
+	if state.lastMessage.Role == "user" {
+		toolResults := extractToolResultsFromMessage(state.lastMessage.Content)
+		if len(toolResults) > 0 {
+			userInput.UserInputMessageContext.ToolResults = toolResults
+
+			logger.Debug("已添加工具结果到请求",
+				logger.Int("tool_results_count", len(toolResults)),
+				logger.String("conversation_id", state.cwReq.ConversationState.ConversationId))
+
+			// 只有 tool_result 时 content 为空字符串（符合 req2.json 的格式）；
+			// 同时带有追问文本时保留文本，上游接受 content 与 toolResults 同时存在，否则追问不会到达模型
+			userInput.Content = userTextContent(state.lastMessage.Content)
+		}
+	}
+
+	// 结构化输出约束以指令形式附加到当前消息
+	if instruction := StructuredOutputInstruction(state.anthropicReq.ResponseFormat); instruction != "" {
+		if userInput.Content != "" {
+			userInput.Content += "\n\n"
+		}
+		userInput.Content += instruction
+	}
+
+	// 解析上游模型ID（ModelMap映射或cw:前缀直通），失败时返回模型错误
+	modelId, err := config.ResolveModelID(state.anthropicReq.Model)
+	if err != nil {
+		agentContinuationId := state.cwReq.ConversationState.AgentContinuationId
+		logger.Warn("模型解析失败",
+			logger.String("requested_model", state.anthropicReq.Model),
+			logger.String("request_id", agentContinuationId),
+			logger.Err(err))
+
+		// 返回模型错误，使用已生成的AgentContinuationId
+		if errors.Is(err, config.ErrModelNotMapped) {
+			return state, types.NewModelNotFoundErrorType(state.anthropicReq.Model, agentContinuationId)
+		}
+		return state, types.NewInvalidModelErrorType(state.anthropicReq.Model, err.Error(), agentContinuationId)
+	}
+	if config.IsPassthroughModel(state.anthropicReq.Model) {
+		logger.Debug("使用直通模型ID",
+			logger.String("requested_model", state.anthropicReq.Model),
+			logger.String("model_id", modelId))
+	}
+
+	state.modelId = modelId
+	userInput.ModelId = modelId
+	userInput.Origin = "AI_EDITOR" // v0.4兼容性：固定使用AI_EDITOR
+
+	return state, nil
+}
+
+// buildTools 转换工具定义，跳过无名称工具并按配置过滤 web_search 和黑白名单，computer_use 工具转换为普通工具
+func (b *RequestBuilder) buildTools(state *builderState) (*builderState, error) {
+	if len(state.anthropicReq.Tools) == 0 {
+		return state, nil
+	}
+
+	var tools []types.CodeWhispererTool
+	for i, tool := range state.anthropicReq.Tools {
+		if tool.Name == "" {
+			logger.Warn("跳过无名称的工具", logger.Int("tool_index", i))
+			state.warnings.Add(WarningToolSkipped, fmt.Sprintf("tools.%d", i), "工具名称为空", nil)
+			continue
+		}
+
+		// 过滤不支持的工具：web_search（local 模式下保留，由代理执行）
+		if b.filterWebSearch && IsWebSearchTool(tool.Name) {
+			continue
+		}
+
+		if !b.toolFilter.Allows(tool.Name) {
+			logger.Debug("工具被黑白名单过滤", logger.String("tool_name", tool.Name))
+			continue
+		}
+
+		// computer_use 工具转换为带动作说明的普通工具，描述和schema由代理生成
+		computerUse := IsComputerUseTool(tool)
+		if computerUse {
+			tool = computerUseTool(tool)
+		}
+
+		// 无参工具可能省略 input_schema，上游要求提供对象schema
+		if len(tool.InputSchema) == 0 {
+			tool.InputSchema = emptyObjectSchema()
+		}
+
+		if b.enhancer != nil && !computerUse {
+			if description, ok := b.enhancer.Enhance(tool); ok {
+				logger.Debug("按 input_schema 补全工具描述",
+					logger.String("tool_name", tool.Name),
+					logger.String("description", description))
+				tool.Description = description
+			}
+		}
+
+		cwTool := types.CodeWhispererTool{}
+		cwTool.ToolSpecification.Name = tool.Name
+
+		// 限制 description 长度
+		if maxLength := config.Get().MaxToolDescriptionLength; len(tool.Description) > maxLength {
+			cwTool.ToolSpecification.Description = tool.Description[:maxLength]
+			logger.Debug("工具描述超长已截断",
+				logger.String("tool_name", tool.Name),
+				logger.Int("original_length", len(tool.Description)),
+				logger.Int("max_length", maxLength))
+			state.warnings.Add(WarningToolDescriptionTruncated, fmt.Sprintf("tools.%d.description", i),
+				fmt.Sprintf("工具描述超过 %d 字节，已截断", maxLength),
+				map[string]any{"name": tool.Name, "original_length": len(tool.Description)})
+		} else {
+			cwTool.ToolSpecification.Description = tool.Description
+		}
+
+		// 直接使用原始的InputSchema，避免过度处理 (恢复v0.4兼容性)
+		cwTool.ToolSpecification.InputSchema = types.InputSchema{
+			Json: tool.InputSchema,
+		}
+		tools = append(tools, cwTool)
+	}
+
+	// 工具配置放在 UserInputMessageContext.Tools 中 (符合req.json结构)
+	state.cwReq.ConversationState.CurrentMessage.UserInputMessage.UserInputMessageContext.Tools = tools
+	return state, nil
+}
+
+// buildHistory 构建历史消息：系统提示配对 "OK"，连续 user 消息与连续 assistant 消息分别合并后配对，
+// 开头的孤立 assistant 丢弃，末尾孤立 user 自动配对 "OK"；对 user 消息的调整记录在 state.adjustments，
+// 由 finish 换算下标并按 STRICT_HISTORY 决定是否拒绝
+func (b *RequestBuilder) buildHistory(state *builderState) (*builderState, error) {
+	req := state.anthropicReq
+	if len(req.System) == 0 && len(req.Messages) <= 1 && len(req.Tools) == 0 {
+		state.cwReq.ConversationState.History = []any{}
+		return state, nil
+	}
+
+	history := []any{}
+
+	// 构建综合系统提示
+	var systemContentBuilder strings.Builder
+	for _, sysMsg := range req.System {
+		content, err := utils.GetMessageContent(sysMsg)
+		if err == nil {
+			systemContentBuilder.WriteString(content)
+			systemContentBuilder.WriteString("\n")
+		}
+	}
+
+	if systemContentBuilder.Len() > 0 {
+		userMsg := types.HistoryUserMessage{}
+		userMsg.UserInputMessage.Content = strings.TrimSpace(systemContentBuilder.String())
+		userMsg.UserInputMessage.ModelId = state.modelId
+		userMsg.UserInputMessage.Origin = "AI_EDITOR"
+		history = append(history, userMsg, okAssistantMessage())
+	}
+	systemEntries := len(history)
+
+	// 如果最后一条是assistant，应将它加入历史（与前面的user配对）
+	// 如果最后一条是user，它作为currentMessage，不加入历史
+	historyEndIndex := len(req.Messages) - 1
+	if state.lastMessage.Role == "assistant" {
+		historyEndIndex = len(req.Messages)
+	}
+
+	processed := processHistoryMessages(req.Messages[:historyEndIndex], b.filterWebSearch, b.parallelThreshold)
+	if !b.includeScreenshots {
+		if omitted := omitHistoryScreenshots(processed, computerUseToolNames(req.Tools)); omitted > 0 {
+			logger.Debug("已省略历史中的computer_use截图", logger.Int("screenshots", omitted))
+		}
+	}
+	if documents := appendHistoryDocuments(processed, req.Messages[:historyEndIndex], b.historyDocuments); documents > 0 {
+		logger.Debug("已将历史中的document块加入用户消息", logger.Int("documents", documents), logger.String("mode", b.historyDocuments))
+	}
+
+	var userMessagesBuffer []historyMessageResult      // 累积连续的user消息
+	var assistantMessagesBuffer []historyMessageResult // 累积连续的assistant消息（如客户端重试工具调用）
+	var firstUser, lastUser int                        // 缓冲的user消息在 messages 中的首尾下标
+	var adjustments []HistoryAdjustment
+	recordMergedUsers := func() {
+		if len(userMessagesBuffer) > 1 {
+			adjustments = append(adjustments, HistoryAdjustment{Kind: HistoryMergedUsers, Index: firstUser, End: lastUser})
+		}
+	}
+	flushPair := func() {
+		if len(assistantMessagesBuffer) == 0 {
+			return
+		}
+		recordMergedUsers()
+		history = append(history,
+			mergeUserMessages(userMessagesBuffer, state.modelId),
+			mergeAssistantMessages(assistantMessagesBuffer))
+		userMessagesBuffer = nil
+		assistantMessagesBuffer = nil
+	}
+
+	for i, msg := range processed {
+		switch msg.role {
+		case "user":
+			flushPair()
+			if len(userMessagesBuffer) == 0 {
+				firstUser = i
+			}
+			lastUser = i
+			userMessagesBuffer = append(userMessagesBuffer, msg)
+		case "assistant":
+			// 孤立的assistant消息（前面没有user）被忽略
+			if len(userMessagesBuffer) == 0 {
+				adjustments = append(adjustments, HistoryAdjustment{Kind: HistoryDroppedAssistant, Index: i})
+				continue
+			}
+			assistantMessagesBuffer = append(assistantMessagesBuffer, msg)
+		}
+	}
+	flushPair()
+
+	// 处理结尾的孤立user消息：合并后自动配对一个"OK"的assistant
+	if len(userMessagesBuffer) > 0 {
+		recordMergedUsers()
+		adjustments = append(adjustments, HistoryAdjustment{Kind: HistoryAutoPairedUser, Index: lastUser})
+		history = append(history, mergeUserMessages(userMessagesBuffer, state.modelId), okAssistantMessage())
+
+		logger.Debug("历史消息末尾存在孤立的user消息，已自动配对assistant",
+			logger.Int("orphan_messages", len(userMessagesBuffer)))
+	}
+
+	for _, msg := range processed {
+		state.warnings = append(state.warnings, msg.warnings...)
+	}
+	for _, adjustment := range adjustments {
+		state.warnings.Add(WarningHistoryAdjusted, fmt.Sprintf("messages.%d", adjustment.Index), adjustment.String(), adjustment)
+	}
+
+	state.adjustments = adjustments
+
+	state.cwReq.ConversationState.History = b.limitHistory(history, systemEntries)
+	return state, nil
+}
+
+// limitHistory 按 historyLimit 保留最近的对话轮次，系统提示配对始终保留
+func (b *RequestBuilder) limitHistory(history []any, systemEntries int) []any {
+	if b.historyLimit <= 0 {
+		return history
+	}
+
+	maxEntries := b.historyLimit * 2
+	conversation := history[systemEntries:]
+	if len(conversation) <= maxEntries {
+		return history
+	}
+
+	trimmed := make([]any, 0, systemEntries+maxEntries)
+	trimmed = append(trimmed, history[:systemEntries]...)
+	trimmed = append(trimmed, conversation[len(conversation)-maxEntries:]...)
+
+	logger.Debug("历史消息超出轮次限制，已截断",
+		logger.Int("history_limit", b.historyLimit),
+		logger.Int("dropped_rounds", (len(conversation)-maxEntries)/2))
+
+	return trimmed
+}
+
+// mergeAssistantMessages 合并连续的assistant消息：文本以换行拼接，工具调用按顺序累积，
+// 保证重试产生的每个 tool_use id 都保留在历史中；
+// 只含工具调用的消息的占位文本仅在没有其他文本时保留
+func mergeAssistantMessages(messages []historyMessageResult) types.HistoryAssistantMessage {
+	assistantMsg := types.HistoryAssistantMessage{}
+	var contentParts []string
+	var allToolUses []types.ToolUseEntry
+
+	for _, msg := range messages {
+		if msg.text != "" && msg.text != utils.EmptyMessagePlaceholder {
+			contentParts = append(contentParts, msg.text)
+		}
+		allToolUses = append(allToolUses, msg.toolUses...)
+	}
+
+	if len(contentParts) > 0 {
+		assistantMsg.AssistantResponseMessage.Content = strings.Join(contentParts, "\n")
+	} else {
+		assistantMsg.AssistantResponseMessage.Content = messages[0].text
+	}
+	if len(allToolUses) > 0 {
+		assistantMsg.AssistantResponseMessage.ToolUses = allToolUses
+	}
+	return assistantMsg
+}
+
+// mergeUserMessages 合并连续的user消息：文本以换行拼接，图片和工具结果累积
+// 包含工具结果的消息只保留 text 块的文本，全部为工具结果时 content 为空字符串
+func mergeUserMessages(messages []historyMessageResult, modelId string) types.HistoryUserMessage {
+	merged := types.HistoryUserMessage{}
+	var contentParts []string
+	var allImages []types.CodeWhispererImage
+	var allToolResults []types.ToolResult
+
+	for _, userMsg := range messages {
+		allToolResults = append(allToolResults, userMsg.toolResults...)
+		if userMsg.err != nil {
+			continue
+		}
+		if userMsg.text != "" {
+			contentParts = append(contentParts, userMsg.text)
+		}
+		// 只有工具结果的消息文本为空，其中的图片仍然保留
+		if userMsg.text != "" || len(userMsg.toolResults) > 0 {
+			allImages = append(allImages, userMsg.images...)
+		}
+	}
+
+	merged.UserInputMessage.Content = strings.Join(contentParts, "\n")
+	if len(allImages) > 0 {
+		merged.UserInputMessage.Images = allImages
+	}
+	if len(allToolResults) > 0 {
+		merged.UserInputMessage.UserInputMessageContext.ToolResults = allToolResults
+	}
+
+	merged.UserInputMessage.ModelId = modelId
+	merged.UserInputMessage.Origin = "AI_EDITOR"
+	return merged
+}
+
+// okAssistantMessage 用于补齐配对的占位assistant消息
+func okAssistantMessage() types.HistoryAssistantMessage {
+	assistantMsg := types.HistoryAssistantMessage{}
+	assistantMsg.AssistantResponseMessage.Content = "OK"
+	return assistantMsg
+}
+
+// validate 最终验证请求完整性
+func (b *RequestBuilder) validate(state *builderState) (*builderState, error) {
+	if err := validateCodeWhispererRequest(&state.cwReq); err != nil {
+		return state, fmt.Errorf("请求验证失败: %v", err)
+	}
+	return state, nil
+}
@@ -0,0 +1,551 @@
+package converter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"kiro2api/config"
+	"kiro2api/types"
+	"kiro2api/utils"
+)
+
+// newHistoryState 构造已完成 current-message 阶段的构建状态
+func newHistoryState(t *testing.T, b *RequestBuilder, req types.AnthropicRequest) *builderState {
+	t.Helper()
+	state, err := b.buildIdentity(&builderState{anthropicReq: req})
+	require.NoError(t, err)
+	state, err = b.buildCurrentMessage(state)
+	require.NoError(t, err)
+	return state
+}
+
+func userMsg(content any) types.AnthropicRequestMessage {
+	return types.AnthropicRequestMessage{Role: "user", Content: content}
+}
+
+func assistantMsg(content any) types.AnthropicRequestMessage {
+	return types.AnthropicRequestMessage{Role: "assistant", Content: content}
+}
+
+func historyUser(t *testing.T, entry any) types.HistoryUserMessage {
+	t.Helper()
+	msg, ok := entry.(types.HistoryUserMessage)
+	require.True(t, ok, "期望 HistoryUserMessage，实际 %T", entry)
+	return msg
+}
+
+func historyAssistant(t *testing.T, entry any) types.HistoryAssistantMessage {
+	t.Helper()
+	msg, ok := entry.(types.HistoryAssistantMessage)
+	require.True(t, ok, "期望 HistoryAssistantMessage，实际 %T", entry)
+	return msg
+}
+
+func TestRequestBuilder_Identity(t *testing.T) {
+	t.Run("无context时生成随机会话ID", func(t *testing.T) {
+		state, err := NewRequestBuilder().buildIdentity(&builderState{})
+		require.NoError(t, err)
+
+		cs := state.cwReq.ConversationState
+		assert.NotEmpty(t, cs.ConversationId)
+		assert.NotEmpty(t, cs.AgentContinuationId)
+		assert.Equal(t, "vibe", cs.AgentTaskType)
+		assert.Equal(t, "MANUAL", cs.ChatTriggerType)
+	})
+
+	t.Run("WithConversationID覆盖会话ID", func(t *testing.T) {
+		state, err := NewRequestBuilder(WithConversationID("conv-fixed")).buildIdentity(&builderState{})
+		require.NoError(t, err)
+		assert.Equal(t, "conv-fixed", state.cwReq.ConversationState.ConversationId)
+	})
+}
+
+func TestRequestBuilder_CurrentMessage(t *testing.T) {
+	b := NewRequestBuilder()
+
+	t.Run("空消息列表", func(t *testing.T) {
+		_, err := b.buildCurrentMessage(&builderState{})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "消息列表为空")
+	})
+
+	t.Run("模型不存在", func(t *testing.T) {
+		state := &builderState{anthropicReq: types.AnthropicRequest{
+			Model:    "unknown-model",
+			Messages: []types.AnthropicRequestMessage{userMsg("hi")},
+		}}
+		state.cwReq.ConversationState.AgentContinuationId = "agent-1"
+
+		_, err := b.buildCurrentMessage(state)
+		require.Error(t, err)
+		modelErr, ok := err.(*types.ModelNotFoundErrorType)
+		require.True(t, ok)
+		assert.Contains(t, modelErr.ErrorData.Error.Message, "agent-1")
+	})
+
+	t.Run("直通模型ID原样透传", func(t *testing.T) {
+		t.Setenv("ALLOW_MODEL_PASSTHROUGH", "true")
+		state := &builderState{anthropicReq: types.AnthropicRequest{
+			Model:    "cw:CLAUDE_SONNET_4_5_20250929_V1_0",
+			Messages: []types.AnthropicRequestMessage{userMsg("hi")},
+		}}
+
+		state, err := b.buildCurrentMessage(state)
+		require.NoError(t, err)
+		assert.Equal(t, "CLAUDE_SONNET_4_5_20250929_V1_0", state.modelId)
+		assert.Equal(t, "CLAUDE_SONNET_4_5_20250929_V1_0", state.cwReq.ConversationState.CurrentMessage.UserInputMessage.ModelId)
+	})
+
+	t.Run("直通未启用或ID非法时返回模型错误", func(t *testing.T) {
+		for _, tc := range []struct {
+			passthrough string
+			model       string
+		}{
+			{passthrough: "", model: "cw:CLAUDE_SONNET_4_5_20250929_V1_0"},
+			{passthrough: "true", model: "cw:not-valid"},
+		} {
+			t.Setenv("ALLOW_MODEL_PASSTHROUGH", tc.passthrough)
+			state := &builderState{anthropicReq: types.AnthropicRequest{
+				Model:    tc.model,
+				Messages: []types.AnthropicRequestMessage{userMsg("hi")},
+			}}
+			state.cwReq.ConversationState.AgentContinuationId = "agent-2"
+
+			_, err := b.buildCurrentMessage(state)
+			modelErr, ok := err.(*types.ModelNotFoundErrorType)
+			require.True(t, ok, "期望 ModelNotFoundErrorType，实际 %T", err)
+			assert.Equal(t, "invalid_model", modelErr.ErrorData.Error.Code)
+			assert.Contains(t, modelErr.ErrorData.Error.Message, tc.model)
+			assert.Contains(t, modelErr.ErrorData.Error.Message, "agent-2")
+		}
+	})
+
+	t.Run("工具结果清空content", func(t *testing.T) {
+		state := &builderState{anthropicReq: types.AnthropicRequest{
+			Model: "claude-sonnet-4",
+			Messages: []types.AnthropicRequestMessage{userMsg([]any{
+				map[string]any{"type": "tool_result", "tool_use_id": "t1", "content": "ok"},
+			})},
+		}}
+
+		state, err := b.buildCurrentMessage(state)
+		require.NoError(t, err)
+
+		userInput := state.cwReq.ConversationState.CurrentMessage.UserInputMessage
+		assert.Equal(t, "", userInput.Content)
+		assert.Len(t, userInput.UserInputMessageContext.ToolResults, 1)
+		assert.Equal(t, "claude-sonnet-4", userInput.ModelId)
+		assert.NotNil(t, userInput.Images)
+	})
+
+	t.Run("工具结果与追问同时存在时保留追问", func(t *testing.T) {
+		state := &builderState{anthropicReq: types.AnthropicRequest{
+			Model: "claude-sonnet-4",
+			Messages: []types.AnthropicRequestMessage{userMsg([]any{
+				map[string]any{"type": "tool_result", "tool_use_id": "t1", "content": "line 1\nline 2\nline 3"},
+				map[string]any{"type": "text", "text": "解释一下第3行"},
+			})},
+		}}
+
+		state, err := b.buildCurrentMessage(state)
+		require.NoError(t, err)
+
+		userInput := state.cwReq.ConversationState.CurrentMessage.UserInputMessage
+		assert.Equal(t, "解释一下第3行", userInput.Content)
+		require.Len(t, userInput.UserInputMessageContext.ToolResults, 1)
+		assert.Equal(t, "t1", userInput.UserInputMessageContext.ToolResults[0].ToolUseId)
+	})
+}
+
+func TestRequestBuilder_Tools(t *testing.T) {
+	req := types.AnthropicRequest{
+		Tools: []types.AnthropicTool{
+			{Name: "get_weather", Description: "weather"},
+			{Name: ""},
+			{Name: "web_search"},
+			{Name: "websearch"},
+		},
+	}
+
+	t.Run("默认过滤web_search", func(t *testing.T) {
+		state, err := NewRequestBuilder().buildTools(&builderState{anthropicReq: req})
+		require.NoError(t, err)
+
+		tools := state.cwReq.ConversationState.CurrentMessage.UserInputMessage.UserInputMessageContext.Tools
+		require.Len(t, tools, 1)
+		assert.Equal(t, "get_weather", tools[0].ToolSpecification.Name)
+	})
+
+	t.Run("WithoutWebSearchFilter保留web_search", func(t *testing.T) {
+		state, err := NewRequestBuilder(WithoutWebSearchFilter()).buildTools(&builderState{anthropicReq: req})
+		require.NoError(t, err)
+
+		tools := state.cwReq.ConversationState.CurrentMessage.UserInputMessage.UserInputMessageContext.Tools
+		assert.Len(t, tools, 3)
+	})
+
+	t.Run("WEB_SEARCH_MODE=local保留web_search", func(t *testing.T) {
+		t.Setenv("WEB_SEARCH_MODE", "local")
+		state, err := NewRequestBuilder().buildTools(&builderState{anthropicReq: req})
+		require.NoError(t, err)
+
+		tools := state.cwReq.ConversationState.CurrentMessage.UserInputMessage.UserInputMessageContext.Tools
+		assert.Len(t, tools, 3)
+	})
+}
+
+func TestRequestBuilder_ToolDescriptionEnhancement(t *testing.T) {
+	req := types.AnthropicRequest{
+		Tools: []types.AnthropicTool{
+			{Name: "get_weather", InputSchema: map[string]any{
+				"type":       "object",
+				"properties": map[string]any{"location": map[string]any{"type": "string"}},
+			}},
+		},
+	}
+	description := func(b *RequestBuilder) string {
+		state, err := b.buildTools(&builderState{anthropicReq: req})
+		require.NoError(t, err)
+		return state.cwReq.ConversationState.CurrentMessage.UserInputMessage.UserInputMessageContext.Tools[0].ToolSpecification.Description
+	}
+
+	assert.Empty(t, description(NewRequestBuilder()), "默认不补全")
+
+	t.Setenv("ENHANCE_TOOL_DESCRIPTIONS", "true")
+	assert.Equal(t, "Get data using location (string).", description(NewRequestBuilder()))
+	assert.Empty(t, req.Tools[0].Description, "不应修改原请求")
+}
+
+func TestRequestBuilder_ToolFilterSnapshot(t *testing.T) {
+	previous := ActiveToolFilter()
+	t.Cleanup(func() { SetToolFilter(previous) })
+
+	req := types.AnthropicRequest{
+		Tools: []types.AnthropicTool{
+			{Name: "read_file", Description: "Read a file from disk"},
+			{Name: "code_search", Description: "Search the codebase"},
+		},
+	}
+	toolNames := func(b *RequestBuilder) []string {
+		state, err := b.buildTools(&builderState{anthropicReq: req})
+		require.NoError(t, err)
+		var names []string
+		for _, tool := range state.cwReq.ConversationState.CurrentMessage.UserInputMessage.UserInputMessageContext.Tools {
+			names = append(names, tool.ToolSpecification.Name)
+		}
+		return names
+	}
+
+	SetToolFilter(config.ToolFilter{})
+	inFlight := NewRequestBuilder()
+
+	SetToolFilter(config.ToolFilter{Blacklist: []string{"*_search"}})
+	assert.Equal(t, []string{"read_file"}, toolNames(NewRequestBuilder()), "更新后的请求使用新配置")
+	assert.Equal(t, []string{"read_file", "code_search"}, toolNames(inFlight), "更新前创建的构建器保留原配置")
+
+	assert.Equal(t, []string{"code_search"}, toolNames(NewRequestBuilder(WithToolFilter(config.ToolFilter{Whitelist: []string{"code_*"}}))))
+}
+
+func TestRequestBuilder_HistoryPairing(t *testing.T) {
+	b := NewRequestBuilder()
+
+	t.Run("单条消息无历史", func(t *testing.T) {
+		state := newHistoryState(t, b, types.AnthropicRequest{
+			Model:    "claude-sonnet-4",
+			Messages: []types.AnthropicRequestMessage{userMsg("hi")},
+		})
+		state, err := b.buildHistory(state)
+		require.NoError(t, err)
+		assert.Equal(t, []any{}, state.cwReq.ConversationState.History, "没有历史时发送 []，不发送 null")
+	})
+
+	t.Run("系统提示配对OK", func(t *testing.T) {
+		state := newHistoryState(t, b, types.AnthropicRequest{
+			Model: "claude-sonnet-4",
+			System: []types.AnthropicSystemMessage{
+				{Type: "text", Text: "rule 1"},
+				{Type: "text", Text: "rule 2"},
+			},
+			Messages: []types.AnthropicRequestMessage{userMsg("hi")},
+		})
+		state, err := b.buildHistory(state)
+		require.NoError(t, err)
+
+		history := state.cwReq.ConversationState.History
+		require.Len(t, history, 2)
+		assert.Equal(t, "rule 1\nrule 2", historyUser(t, history[0]).UserInputMessage.Content)
+		assert.Equal(t, "OK", historyAssistant(t, history[1]).AssistantResponseMessage.Content)
+	})
+
+	t.Run("连续user消息合并后与assistant配对", func(t *testing.T) {
+		state := newHistoryState(t, b, types.AnthropicRequest{
+			Model: "claude-sonnet-4",
+			Messages: []types.AnthropicRequestMessage{
+				userMsg("part 1"),
+				userMsg("part 2"),
+				assistantMsg("answer"),
+				userMsg("current"),
+			},
+		})
+		state, err := b.buildHistory(state)
+		require.NoError(t, err)
+
+		history := state.cwReq.ConversationState.History
+		require.Len(t, history, 2)
+		merged := historyUser(t, history[0])
+		assert.Equal(t, "part 1\npart 2", merged.UserInputMessage.Content)
+		assert.Equal(t, "claude-sonnet-4", merged.UserInputMessage.ModelId)
+		assert.Equal(t, "answer", historyAssistant(t, history[1]).AssistantResponseMessage.Content)
+	})
+
+	t.Run("开头孤立assistant被丢弃，连续assistant合并", func(t *testing.T) {
+		state := newHistoryState(t, b, types.AnthropicRequest{
+			Model: "claude-sonnet-4",
+			Messages: []types.AnthropicRequestMessage{
+				assistantMsg("leading"),
+				userMsg("q1"),
+				assistantMsg("a1"),
+				assistantMsg("a1 again"),
+				userMsg("current"),
+			},
+		})
+		state, err := b.buildHistory(state)
+		require.NoError(t, err)
+
+		history := state.cwReq.ConversationState.History
+		require.Len(t, history, 2)
+		assert.Equal(t, "q1", historyUser(t, history[0]).UserInputMessage.Content)
+		assert.Equal(t, "a1\na1 again", historyAssistant(t, history[1]).AssistantResponseMessage.Content)
+	})
+
+	t.Run("重试工具调用的连续assistant保留全部tool_use", func(t *testing.T) {
+		state := newHistoryState(t, b, types.AnthropicRequest{
+			Model: "claude-sonnet-4",
+			Messages: []types.AnthropicRequestMessage{
+				userMsg("read the config"),
+				assistantMsg([]any{
+					map[string]any{"type": "tool_use", "id": "toolu_first", "name": "read_file", "input": map[string]any{"path": "conf.yml"}},
+				}),
+				assistantMsg([]any{
+					map[string]any{"type": "text", "text": "Retrying with the correct path."},
+					map[string]any{"type": "tool_use", "id": "toolu_retry", "name": "read_file", "input": map[string]any{"path": "config.yml"}},
+				}),
+				userMsg([]any{
+					map[string]any{"type": "tool_result", "tool_use_id": "toolu_retry", "content": "port: 8080"},
+				}),
+				assistantMsg("The port is 8080."),
+				userMsg("current"),
+			},
+		})
+		state, err := b.buildHistory(state)
+		require.NoError(t, err)
+
+		history := state.cwReq.ConversationState.History
+		require.Len(t, history, 4)
+
+		merged := historyAssistant(t, history[1]).AssistantResponseMessage
+		assert.Equal(t, "Retrying with the correct path.", merged.Content)
+		require.Len(t, merged.ToolUses, 2)
+		assert.Equal(t, "toolu_first", merged.ToolUses[0].ToolUseId)
+		assert.Equal(t, "toolu_retry", merged.ToolUses[1].ToolUseId)
+
+		toolResults := historyUser(t, history[2]).UserInputMessage.UserInputMessageContext.ToolResults
+		require.Len(t, toolResults, 1)
+		knownIDs := map[string]bool{}
+		for _, toolUse := range merged.ToolUses {
+			knownIDs[toolUse.ToolUseId] = true
+		}
+		assert.True(t, knownIDs[toolResults[0].ToolUseId], "tool_result 引用的 id 必须存在于历史中")
+		assert.Equal(t, "The port is 8080.", historyAssistant(t, history[3]).AssistantResponseMessage.Content)
+	})
+
+	t.Run("末尾孤立user自动配对OK", func(t *testing.T) {
+		state := newHistoryState(t, b, types.AnthropicRequest{
+			Model: "claude-sonnet-4",
+			Messages: []types.AnthropicRequestMessage{
+				userMsg("orphan 1"),
+				userMsg("orphan 2"),
+				userMsg("current"),
+			},
+		})
+		state, err := b.buildHistory(state)
+		require.NoError(t, err)
+
+		history := state.cwReq.ConversationState.History
+		require.Len(t, history, 2)
+		assert.Equal(t, "orphan 1\norphan 2", historyUser(t, history[0]).UserInputMessage.Content)
+		assert.Equal(t, "OK", historyAssistant(t, history[1]).AssistantResponseMessage.Content)
+	})
+
+	t.Run("最后一条assistant纳入历史", func(t *testing.T) {
+		state := newHistoryState(t, b, types.AnthropicRequest{
+			Model: "claude-sonnet-4",
+			Messages: []types.AnthropicRequestMessage{
+				userMsg("q1"),
+				assistantMsg("a1"),
+			},
+		})
+		state, err := b.buildHistory(state)
+		require.NoError(t, err)
+
+		history := state.cwReq.ConversationState.History
+		require.Len(t, history, 2)
+		assert.Equal(t, "q1", historyUser(t, history[0]).UserInputMessage.Content)
+		assert.Equal(t, "a1", historyAssistant(t, history[1]).AssistantResponseMessage.Content)
+	})
+
+	t.Run("历史工具结果清空content", func(t *testing.T) {
+		state := newHistoryState(t, b, types.AnthropicRequest{
+			Model: "claude-sonnet-4",
+			Messages: []types.AnthropicRequestMessage{
+				userMsg("q1"),
+				assistantMsg([]any{
+					map[string]any{"type": "tool_use", "id": "t1", "name": "get_weather", "input": map[string]any{}},
+					map[string]any{"type": "tool_use", "id": "t2", "name": "web_search", "input": map[string]any{}},
+				}),
+				userMsg([]any{
+					map[string]any{"type": "tool_result", "tool_use_id": "t1", "content": "sunny"},
+				}),
+				assistantMsg("done"),
+				userMsg("current"),
+			},
+		})
+		state, err := b.buildHistory(state)
+		require.NoError(t, err)
+
+		history := state.cwReq.ConversationState.History
+		require.Len(t, history, 4)
+
+		toolUses := historyAssistant(t, history[1]).AssistantResponseMessage.ToolUses
+		require.Len(t, toolUses, 1)
+		assert.Equal(t, "get_weather", toolUses[0].Name)
+
+		toolResultMsg := historyUser(t, history[2])
+		assert.Equal(t, "", toolResultMsg.UserInputMessage.Content)
+		assert.Len(t, toolResultMsg.UserInputMessage.UserInputMessageContext.ToolResults, 1)
+	})
+
+	t.Run("历史工具结果与追问同时存在时保留追问", func(t *testing.T) {
+		state := newHistoryState(t, b, types.AnthropicRequest{
+			Model: "claude-sonnet-4",
+			Messages: []types.AnthropicRequestMessage{
+				userMsg("读一下 main.go"),
+				assistantMsg([]any{
+					map[string]any{"type": "tool_use", "id": "t1", "name": "read_file", "input": map[string]any{"path": "main.go"}},
+				}),
+				userMsg([]any{
+					map[string]any{"type": "tool_result", "tool_use_id": "t1", "content": "package main"},
+					map[string]any{"type": "text", "text": "这个包名是什么意思？"},
+				}),
+				assistantMsg("main 包是可执行程序的入口"),
+				userMsg("current"),
+			},
+		})
+		state, err := b.buildHistory(state)
+		require.NoError(t, err)
+
+		history := state.cwReq.ConversationState.History
+		require.Len(t, history, 4)
+
+		toolResultMsg := historyUser(t, history[2])
+		assert.Equal(t, "这个包名是什么意思？", toolResultMsg.UserInputMessage.Content)
+		require.Len(t, toolResultMsg.UserInputMessage.UserInputMessageContext.ToolResults, 1)
+		assert.Equal(t, "t1", toolResultMsg.UserInputMessage.UserInputMessageContext.ToolResults[0].ToolUseId)
+	})
+}
+
+func TestRequestBuilder_HistoryLimit(t *testing.T) {
+	req := types.AnthropicRequest{
+		Model:  "claude-sonnet-4",
+		System: []types.AnthropicSystemMessage{{Type: "text", Text: "system"}},
+		Messages: []types.AnthropicRequestMessage{
+			userMsg("q1"), assistantMsg("a1"),
+			userMsg("q2"), assistantMsg("a2"),
+			userMsg("q3"), assistantMsg("a3"),
+			userMsg("current"),
+		},
+	}
+
+	b := NewRequestBuilder(WithHistoryLimit(2))
+	state, err := b.buildHistory(newHistoryState(t, b, req))
+	require.NoError(t, err)
+
+	history := state.cwReq.ConversationState.History
+	require.Len(t, history, 6)
+	assert.Equal(t, "system", historyUser(t, history[0]).UserInputMessage.Content)
+	assert.Equal(t, "q2", historyUser(t, history[2]).UserInputMessage.Content)
+	assert.Equal(t, "a3", historyAssistant(t, history[5]).AssistantResponseMessage.Content)
+
+	// 未超出限制时保持不变
+	b = NewRequestBuilder(WithHistoryLimit(10))
+	state, err = b.buildHistory(newHistoryState(t, b, req))
+	require.NoError(t, err)
+	assert.Len(t, state.cwReq.ConversationState.History, 8)
+}
+
+func TestRequestBuilder_WithoutWebSearchFilterKeepsHistoryToolUses(t *testing.T) {
+	b := NewRequestBuilder(WithoutWebSearchFilter())
+	state := newHistoryState(t, b, types.AnthropicRequest{
+		Model: "claude-sonnet-4",
+		Messages: []types.AnthropicRequestMessage{
+			userMsg("q1"),
+			assistantMsg([]any{
+				map[string]any{"type": "tool_use", "id": "t1", "name": "web_search", "input": map[string]any{}},
+			}),
+			userMsg("current"),
+		},
+	})
+	state, err := b.buildHistory(state)
+	require.NoError(t, err)
+
+	history := state.cwReq.ConversationState.History
+	require.Len(t, history, 2)
+	assert.Len(t, historyAssistant(t, history[1]).AssistantResponseMessage.ToolUses, 1)
+}
+
+func TestRequestBuilder_Build(t *testing.T) {
+	req := types.AnthropicRequest{
+		Model:    "claude-sonnet-4",
+		Messages: []types.AnthropicRequestMessage{userMsg("hello")},
+	}
+
+	cwReq, err := NewRequestBuilder(WithConversationID("conv-1")).Build(req, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "conv-1", cwReq.ConversationState.ConversationId)
+	assert.Equal(t, "hello", cwReq.ConversationState.CurrentMessage.UserInputMessage.Content)
+
+	// validate 阶段错误需要包装
+	_, err = NewRequestBuilder().Build(types.AnthropicRequest{
+		Model:    "claude-sonnet-4",
+		Messages: []types.AnthropicRequestMessage{userMsg("   ")},
+	}, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "请求验证失败")
+}
+
+func TestRequestBuilder_ProfileArn(t *testing.T) {
+	req := types.AnthropicRequest{
+		Model:    "claude-sonnet-4",
+		Messages: []types.AnthropicRequestMessage{userMsg("hello")},
+	}
+	arn := "arn:aws:codewhisperer:us-east-1:123456789012:profile/OPUS"
+
+	cwReq, err := NewRequestBuilder(WithProfileArn(arn), WithConversionCache(nil)).Build(req, nil)
+	require.NoError(t, err)
+	body, err := MarshalCodeWhispererRequest(cwReq)
+	require.NoError(t, err)
+	var payload map[string]any
+	require.NoError(t, utils.SafeUnmarshal(body, &payload))
+	assert.Equal(t, arn, payload["profileArn"], "profileArn 位于请求顶层")
+
+	// 命中转换缓存时按本次构建器的配置设置 profileArn
+	cache := NewRequestConversionCache(4)
+	_, err = NewRequestBuilder(WithProfileArn(arn), WithConversionCache(cache)).Build(req, nil)
+	require.NoError(t, err)
+	cwReq, err = NewRequestBuilder(WithConversionCache(cache)).Build(req, nil)
+	require.NoError(t, err)
+	body, err = MarshalCodeWhispererRequest(cwReq)
+	require.NoError(t, err)
+	assert.NotContains(t, string(body), "profileArn", "未指定时不发送 profileArn")
+}
@@ -0,0 +1,247 @@
+package converter
+
+import (
+	"fmt"
+	"strings"
+
+	"kiro2api/types"
+)
+
+// ValidationError 请求中单个字段的校验错误
+// Field 使用与 Anthropic API 错误一致的点分路径，如 messages.0.content.1.type
+type ValidationError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e ValidationError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// FormatValidationErrors 把校验错误拼接为一条错误消息
+func FormatValidationErrors(errs []ValidationError) string {
+	parts := make([]string, len(errs))
+	for i, err := range errs {
+		parts[i] = err.Error()
+	}
+	return strings.Join(parts, "; ")
+}
+
+// knownContentBlockTypes 消息内容中允许出现的块类型
+// thinking、document、服务端工具等块转换时会被忽略，但属于合法输入，不能拒绝
+var knownContentBlockTypes = map[string]bool{
+	"text":                   true,
+	"image":                  true,
+	"image_url":              true,
+	"document":               true,
+	"tool_use":               true,
+	"tool_result":            true,
+	"thinking":               true,
+	"redacted_thinking":      true,
+	"server_tool_use":        true,
+	"web_search_tool_result": true,
+}
+
+// ValidateAnthropicRequest 在转换前检查请求的字段约束，返回全部校验错误；请求合法时返回nil
+// 只检查结构和取值范围，图片内容、模型映射等由转换过程自行校验
+func ValidateAnthropicRequest(req types.AnthropicRequest) []ValidationError {
+	v := &requestValidator{}
+
+	if strings.TrimSpace(req.Model) == "" {
+		v.add("model", "不能为空")
+	}
+	if req.MaxTokens < 0 {
+		v.add("max_tokens", "不能为负数: %d", req.MaxTokens)
+	}
+	if req.Temperature != nil && (*req.Temperature < 0 || *req.Temperature > 1) {
+		v.add("temperature", "必须在 0 到 1 之间: %v", *req.Temperature)
+	}
+
+	if len(req.Messages) == 0 {
+		v.add("messages", "messages 数组不能为空")
+	}
+	for i, msg := range req.Messages {
+		v.message(fmt.Sprintf("messages.%d", i), msg)
+	}
+
+	for i, sys := range req.System {
+		v.systemBlock(fmt.Sprintf("system.%d", i), sys)
+	}
+
+	toolNames := make(map[string]bool, len(req.Tools))
+	for i, tool := range req.Tools {
+		field := fmt.Sprintf("tools.%d.name", i)
+		switch {
+		case strings.TrimSpace(tool.Name) == "":
+			v.add(field, "不能为空")
+		case toolNames[tool.Name]:
+			v.add(field, "工具名重复: %s", tool.Name)
+		}
+		toolNames[tool.Name] = true
+		if IsComputerUseTool(tool) && (tool.DisplayWidthPx <= 0 || tool.DisplayHeightPx <= 0) {
+			v.add(fmt.Sprintf("tools.%d", i), "computer_use 工具需要正数的 display_width_px 和 display_height_px")
+		}
+	}
+
+	if req.ToolChoice != nil {
+		v.toolChoice(req.ToolChoice, toolNames)
+	}
+
+	v.responseFormat(req.ResponseFormat)
+
+	return v.errs
+}
+
+// requestValidator 收集校验错误
+type requestValidator struct {
+	errs []ValidationError
+}
+
+func (v *requestValidator) add(field, format string, args ...any) {
+	v.errs = append(v.errs, ValidationError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *requestValidator) message(field string, msg types.AnthropicRequestMessage) {
+	if msg.Role != "user" && msg.Role != "assistant" {
+		v.add(field+".role", "必须是 user 或 assistant: %q", msg.Role)
+	}
+
+	switch content := msg.Content.(type) {
+	case string:
+	case []any:
+		for i, block := range content {
+			v.contentBlock(fmt.Sprintf("%s.content.%d", field, i), msg.Role, block)
+		}
+	case nil:
+		v.add(field+".content", "不能为空")
+	default:
+		v.add(field+".content", "必须是字符串或内容块数组，实际为 %T", msg.Content)
+	}
+}
+
+func (v *requestValidator) contentBlock(field, role string, item any) {
+	block, ok := item.(map[string]any)
+	if !ok {
+		v.add(field, "内容块必须是对象，实际为 %T", item)
+		return
+	}
+
+	blockType, ok := block["type"].(string)
+	if !ok {
+		v.add(field+".type", "缺少内容块类型或类型不是字符串")
+		return
+	}
+	if !knownContentBlockTypes[blockType] {
+		v.add(field+".type", "不支持的内容块类型: %s", blockType)
+		return
+	}
+
+	switch blockType {
+	case "text":
+		if _, ok := block["text"].(string); !ok {
+			v.add(field+".text", "text 块的 text 必须是字符串")
+		}
+	case "image":
+		if _, ok := block["source"].(map[string]any); !ok {
+			v.add(field+".source", "image 块缺少 source 对象")
+		}
+	case "image_url":
+		if _, ok := block["image_url"].(map[string]any); !ok {
+			v.add(field+".image_url", "image_url 块缺少 image_url 对象")
+		}
+	case "tool_use":
+		if role != "assistant" {
+			v.add(field+".type", "tool_use 只能出现在 assistant 消息中")
+		}
+		if id, _ := block["id"].(string); id == "" {
+			v.add(field+".id", "tool_use 块缺少 id")
+		}
+		if name, _ := block["name"].(string); name == "" {
+			v.add(field+".name", "tool_use 块缺少 name")
+		}
+		if input, exists := block["input"]; exists && input != nil {
+			if _, ok := input.(map[string]any); !ok {
+				v.add(field+".input", "tool_use 的 input 必须是对象，实际为 %T", input)
+			}
+		}
+	case "tool_result":
+		if role != "user" {
+			v.add(field+".type", "tool_result 只能出现在 user 消息中")
+		}
+		if id, _ := block["tool_use_id"].(string); id == "" {
+			v.add(field+".tool_use_id", "tool_result 块缺少 tool_use_id")
+		}
+		switch content := block["content"].(type) {
+		case nil, string, []any, map[string]any:
+		default:
+			v.add(field+".content", "tool_result 的 content 必须是字符串或数组，实际为 %T", content)
+		}
+		if isError, exists := block["is_error"]; exists && isError != nil {
+			if _, ok := isError.(bool); !ok {
+				v.add(field+".is_error", "必须是布尔值")
+			}
+		}
+	}
+}
+
+func (v *requestValidator) systemBlock(field string, sys types.AnthropicSystemMessage) {
+	switch sys.Type {
+	case "", "text":
+	case "document":
+		if sys.Source == nil {
+			v.add(field+".source", "document 块缺少 source")
+		}
+	default:
+		v.add(field+".type", "不支持的系统提示块类型: %s", sys.Type)
+	}
+}
+
+func (v *requestValidator) toolChoice(choice any, toolNames map[string]bool) {
+	var choiceType, name string
+	switch tc := choice.(type) {
+	case *types.ToolChoice:
+		if tc == nil {
+			return
+		}
+		choiceType, name = tc.Type, tc.Name
+	case map[string]any:
+		var ok bool
+		if choiceType, ok = tc["type"].(string); !ok {
+			v.add("tool_choice.type", "缺少类型或类型不是字符串")
+			return
+		}
+		name, _ = tc["name"].(string)
+	default:
+		v.add("tool_choice", "必须是对象，实际为 %T", choice)
+		return
+	}
+
+	switch choiceType {
+	case "auto", "any", "none":
+	case "tool":
+		if name == "" {
+			v.add("tool_choice.name", "type 为 tool 时必须指定工具名")
+		} else if len(toolNames) == 0 {
+			v.add("tool_choice.name", "指定了工具 %s，但 tools 为空", name)
+		} else if !toolNames[name] {
+			v.add("tool_choice.name", "工具不存在: %s", name)
+		}
+	default:
+		v.add("tool_choice.type", "必须是 auto、any、tool 或 none: %q", choiceType)
+	}
+}
+
+func (v *requestValidator) responseFormat(rf *types.ResponseFormat) {
+	if rf == nil {
+		return
+	}
+	switch rf.Type {
+	case ResponseFormatText, ResponseFormatJSONObject:
+	case ResponseFormatJSONSchema:
+		if rf.JSONSchema == nil || rf.JSONSchema.Schema == nil {
+			v.add("response_format.json_schema.schema", "type 为 json_schema 时必须提供 schema 对象")
+		}
+	default:
+		v.add("response_format.type", "必须是 text、json_object 或 json_schema: %q", rf.Type)
+	}
+}
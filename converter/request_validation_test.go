@@ -0,0 +1,239 @@
+package converter
+
+import (
+	"testing"
+
+	"kiro2api/types"
+	"kiro2api/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decodeAnthropicRequest(t *testing.T, body string) types.AnthropicRequest {
+	t.Helper()
+	var req types.AnthropicRequest
+	require.NoError(t, utils.SafeUnmarshal([]byte(body), &req))
+	return req
+}
+
+func errorFields(errs []ValidationError) []string {
+	fields := make([]string, len(errs))
+	for i, err := range errs {
+		fields[i] = err.Field
+	}
+	return fields
+}
+
+func TestValidateAnthropicRequest_Valid(t *testing.T) {
+	req := decodeAnthropicRequest(t, `{
+		"model": "claude-sonnet-4",
+		"max_tokens": 1024,
+		"temperature": 0.5,
+		"system": [{"type": "text", "text": "你是助手"}],
+		"tools": [{"name": "get_weather", "description": "查询天气", "input_schema": {"type": "object"}}],
+		"tool_choice": {"type": "tool", "name": "get_weather"},
+		"messages": [
+			{"role": "user", "content": "北京天气如何"},
+			{"role": "assistant", "content": [
+				{"type": "thinking", "thinking": "需要查询", "signature": "sig"},
+				{"type": "text", "text": "我来查一下"},
+				{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {"city": "北京"}}
+			]},
+			{"role": "user", "content": [
+				{"type": "tool_result", "tool_use_id": "toolu_1", "content": [{"type": "text", "text": "晴"}], "is_error": false},
+				{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "AAAA"}},
+				{"type": "text", "text": "谢谢"}
+			]}
+		]
+	}`)
+
+	assert.Empty(t, ValidateAnthropicRequest(req))
+}
+
+func TestValidateAnthropicRequest_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		fields []string
+	}{
+		{
+			name:   "缺少model",
+			body:   `{"messages": [{"role": "user", "content": "hi"}]}`,
+			fields: []string{"model"},
+		},
+		{
+			name:   "max_tokens为负数",
+			body:   `{"model": "claude-sonnet-4", "max_tokens": -1, "messages": [{"role": "user", "content": "hi"}]}`,
+			fields: []string{"max_tokens"},
+		},
+		{
+			name:   "temperature超出范围",
+			body:   `{"model": "claude-sonnet-4", "temperature": 1.5, "messages": [{"role": "user", "content": "hi"}]}`,
+			fields: []string{"temperature"},
+		},
+		{
+			name:   "messages为空",
+			body:   `{"model": "claude-sonnet-4", "messages": []}`,
+			fields: []string{"messages"},
+		},
+		{
+			name:   "role为system",
+			body:   `{"model": "claude-sonnet-4", "messages": [{"role": "system", "content": "hi"}]}`,
+			fields: []string{"messages.0.role"},
+		},
+		{
+			name:   "缺少role",
+			body:   `{"model": "claude-sonnet-4", "messages": [{"content": "hi"}]}`,
+			fields: []string{"messages.0.role"},
+		},
+		{
+			name:   "缺少content",
+			body:   `{"model": "claude-sonnet-4", "messages": [{"role": "user"}]}`,
+			fields: []string{"messages.0.content"},
+		},
+		{
+			name:   "content为数字",
+			body:   `{"model": "claude-sonnet-4", "messages": [{"role": "user", "content": 42}]}`,
+			fields: []string{"messages.0.content"},
+		},
+		{
+			name:   "content为对象",
+			body:   `{"model": "claude-sonnet-4", "messages": [{"role": "user", "content": {"type": "text", "text": "hi"}}]}`,
+			fields: []string{"messages.0.content"},
+		},
+		{
+			name:   "内容块不是对象",
+			body:   `{"model": "claude-sonnet-4", "messages": [{"role": "user", "content": ["hi"]}]}`,
+			fields: []string{"messages.0.content.0"},
+		},
+		{
+			name:   "内容块缺少type",
+			body:   `{"model": "claude-sonnet-4", "messages": [{"role": "user", "content": [{"text": "hi"}]}]}`,
+			fields: []string{"messages.0.content.0.type"},
+		},
+		{
+			name:   "未知内容块类型",
+			body:   `{"model": "claude-sonnet-4", "messages": [{"role": "user", "content": [{"type": "video", "url": "x"}]}]}`,
+			fields: []string{"messages.0.content.0.type"},
+		},
+		{
+			name:   "text块的text不是字符串",
+			body:   `{"model": "claude-sonnet-4", "messages": [{"role": "user", "content": [{"type": "text", "text": 1}]}]}`,
+			fields: []string{"messages.0.content.0.text"},
+		},
+		{
+			name:   "image块缺少source",
+			body:   `{"model": "claude-sonnet-4", "messages": [{"role": "user", "content": [{"type": "image"}]}]}`,
+			fields: []string{"messages.0.content.0.source"},
+		},
+		{
+			name:   "image_url块缺少image_url",
+			body:   `{"model": "claude-sonnet-4", "messages": [{"role": "user", "content": [{"type": "image_url", "image_url": "data:"}]}]}`,
+			fields: []string{"messages.0.content.0.image_url"},
+		},
+		{
+			name: "tool_use缺少id和name",
+			body: `{"model": "claude-sonnet-4", "messages": [{"role": "user", "content": "hi"},
+				{"role": "assistant", "content": [{"type": "tool_use", "input": {}}]}]}`,
+			fields: []string{"messages.1.content.0.id", "messages.1.content.0.name"},
+		},
+		{
+			name: "tool_use的input不是对象",
+			body: `{"model": "claude-sonnet-4", "messages": [{"role": "user", "content": "hi"},
+				{"role": "assistant", "content": [{"type": "tool_use", "id": "toolu_1", "name": "x", "input": "{}"}]}]}`,
+			fields: []string{"messages.1.content.0.input"},
+		},
+		{
+			name:   "tool_use出现在user消息中",
+			body:   `{"model": "claude-sonnet-4", "messages": [{"role": "user", "content": [{"type": "tool_use", "id": "toolu_1", "name": "x", "input": {}}]}]}`,
+			fields: []string{"messages.0.content.0.type"},
+		},
+		{
+			name:   "tool_result缺少tool_use_id",
+			body:   `{"model": "claude-sonnet-4", "messages": [{"role": "user", "content": [{"type": "tool_result", "content": "ok"}]}]}`,
+			fields: []string{"messages.0.content.0.tool_use_id"},
+		},
+		{
+			name:   "tool_result的content为数字",
+			body:   `{"model": "claude-sonnet-4", "messages": [{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "toolu_1", "content": 3}]}]}`,
+			fields: []string{"messages.0.content.0.content"},
+		},
+		{
+			name:   "tool_result的is_error不是布尔值",
+			body:   `{"model": "claude-sonnet-4", "messages": [{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "toolu_1", "is_error": "yes"}]}]}`,
+			fields: []string{"messages.0.content.0.is_error"},
+		},
+		{
+			name: "tool_result出现在assistant消息中",
+			body: `{"model": "claude-sonnet-4", "messages": [{"role": "user", "content": "hi"},
+				{"role": "assistant", "content": [{"type": "tool_result", "tool_use_id": "toolu_1", "content": "ok"}]}]}`,
+			fields: []string{"messages.1.content.0.type"},
+		},
+		{
+			name:   "系统提示块类型未知",
+			body:   `{"model": "claude-sonnet-4", "system": [{"type": "image", "text": ""}], "messages": [{"role": "user", "content": "hi"}]}`,
+			fields: []string{"system.0.type"},
+		},
+		{
+			name:   "系统提示document缺少source",
+			body:   `{"model": "claude-sonnet-4", "system": [{"type": "document"}], "messages": [{"role": "user", "content": "hi"}]}`,
+			fields: []string{"system.0.source"},
+		},
+		{
+			name:   "工具名为空",
+			body:   `{"model": "claude-sonnet-4", "tools": [{"name": "", "input_schema": {}}], "messages": [{"role": "user", "content": "hi"}]}`,
+			fields: []string{"tools.0.name"},
+		},
+		{
+			name:   "工具名重复",
+			body:   `{"model": "claude-sonnet-4", "tools": [{"name": "a", "input_schema": {}}, {"name": "a", "input_schema": {}}], "messages": [{"role": "user", "content": "hi"}]}`,
+			fields: []string{"tools.1.name"},
+		},
+		{
+			name:   "tool_choice为字符串",
+			body:   `{"model": "claude-sonnet-4", "tool_choice": "auto", "messages": [{"role": "user", "content": "hi"}]}`,
+			fields: []string{"tool_choice"},
+		},
+		{
+			name:   "tool_choice类型未知",
+			body:   `{"model": "claude-sonnet-4", "tool_choice": {"type": "required"}, "messages": [{"role": "user", "content": "hi"}]}`,
+			fields: []string{"tool_choice.type"},
+		},
+		{
+			name:   "tool_choice指定的工具不存在",
+			body:   `{"model": "claude-sonnet-4", "tools": [{"name": "a", "input_schema": {}}], "tool_choice": {"type": "tool", "name": "b"}, "messages": [{"role": "user", "content": "hi"}]}`,
+			fields: []string{"tool_choice.name"},
+		},
+		{
+			name:   "tools为空时tool_choice指定工具",
+			body:   `{"model": "claude-sonnet-4", "tools": [], "tool_choice": {"type": "tool", "name": "b"}, "messages": [{"role": "user", "content": "hi"}]}`,
+			fields: []string{"tool_choice.name"},
+		},
+		{
+			name:   "多处错误全部返回",
+			body:   `{"max_tokens": -5, "messages": [{"role": "bot", "content": [{"type": "text"}]}]}`,
+			fields: []string{"model", "max_tokens", "messages.0.role", "messages.0.content.0.text"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := ValidateAnthropicRequest(decodeAnthropicRequest(t, tt.body))
+
+			assert.Equal(t, tt.fields, errorFields(errs))
+			for _, err := range errs {
+				assert.NotEmpty(t, err.Message)
+			}
+		})
+	}
+}
+
+func TestFormatValidationErrors(t *testing.T) {
+	errs := []ValidationError{
+		{Field: "model", Message: "不能为空"},
+		{Field: "messages.0.role", Message: `必须是 user 或 assistant: "bot"`},
+	}
+
+	assert.Equal(t, `model: 不能为空; messages.0.role: 必须是 user 或 assistant: "bot"`, FormatValidationErrors(errs))
+}
@@ -0,0 +1,48 @@
+package converter
+
+import (
+	"strings"
+
+	"kiro2api/config"
+	"kiro2api/types"
+)
+
+// stopSequencesInstructionPrefix injected 模式下追加到系统提示开头的指令
+const stopSequencesInstructionPrefix = "Stop generating when you encounter: "
+
+// StopSequencesInstruction 返回 injected 模式下的停止序列指令，没有有效的停止序列时为空
+func StopSequencesInstruction(stopSequences []string) string {
+	sequences := nonEmptyStopSequences(stopSequences)
+	if len(sequences) == 0 {
+		return ""
+	}
+	return stopSequencesInstructionPrefix + strings.Join(sequences, ", ")
+}
+
+// nonEmptyStopSequences 去掉空字符串，空白字符（如 "\n\n"）是合法的停止序列，原样保留
+func nonEmptyStopSequences(stopSequences []string) []string {
+	var sequences []string
+	for _, seq := range stopSequences {
+		if seq != "" {
+			sequences = append(sequences, seq)
+		}
+	}
+	return sequences
+}
+
+// applyStopSequences 按构建器的 STOP_SEQUENCES_MODE 快照传递 stop_sequences：
+// injected 模式把指令作为第一个系统提示块，native 模式写入 inferenceConfig.stopSequences
+func (b *RequestBuilder) applyStopSequences(state *builderState) {
+	switch b.stopSequencesMode {
+	case config.StopSequencesModeNative:
+		if sequences := nonEmptyStopSequences(state.anthropicReq.StopSequences); len(sequences) > 0 {
+			state.cwReq.InferenceConfig = &types.InferenceConfig{StopSequences: sequences}
+		}
+	default:
+		if instruction := StopSequencesInstruction(state.anthropicReq.StopSequences); instruction != "" {
+			system := make([]types.AnthropicSystemMessage, 0, len(state.anthropicReq.System)+1)
+			system = append(system, types.AnthropicSystemMessage{Type: "text", Text: instruction})
+			state.anthropicReq.System = append(system, state.anthropicReq.System...)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package converter
+
+import (
+	"encoding/json"
+	"testing"
+
+	"kiro2api/types"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildCodeWhispererRequest_StopSequences(t *testing.T) {
+	t.Setenv("SYSTEM_PROMPT_PREFIX", "")
+	t.Setenv("SYSTEM_PROMPT_SUFFIX", "")
+
+	newRequest := func() types.AnthropicRequest {
+		return types.AnthropicRequest{
+			Model:         "claude-sonnet-4",
+			System:        []types.AnthropicSystemMessage{{Type: "text", Text: "You are a helpful assistant."}},
+			Messages:      []types.AnthropicRequestMessage{userMsg("List three colors")},
+			StopSequences: []string{"###", "", "<END>"},
+		}
+	}
+
+	t.Run("injected", func(t *testing.T) {
+		t.Setenv("STOP_SEQUENCES_MODE", "")
+		req := newRequest()
+
+		cwReq, err := BuildCodeWhispererRequest(req, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "Stop generating when you encounter: ###, <END>\nYou are a helpful assistant.", systemHistoryContent(t, cwReq))
+		assert.Nil(t, cwReq.InferenceConfig)
+		assert.Len(t, req.System, 1, "原请求不被修改")
+
+		body, err := MarshalCodeWhispererRequest(cwReq)
+		require.NoError(t, err)
+		assert.NotContains(t, string(body), "inferenceConfig")
+	})
+
+	t.Run("native", func(t *testing.T) {
+		t.Setenv("STOP_SEQUENCES_MODE", "Native")
+		req := newRequest()
+
+		cwReq, err := BuildCodeWhispererRequest(req, nil)
+		require.NoError(t, err)
+		require.NotNil(t, cwReq.InferenceConfig)
+		assert.Equal(t, []string{"###", "<END>"}, cwReq.InferenceConfig.StopSequences)
+		assert.Equal(t, "You are a helpful assistant.", systemHistoryContent(t, cwReq))
+
+		body, err := MarshalCodeWhispererRequest(cwReq)
+		require.NoError(t, err)
+		var sent struct {
+			InferenceConfig struct {
+				StopSequences []string `json:"stopSequences"`
+			} `json:"inferenceConfig"`
+		}
+		require.NoError(t, json.Unmarshal(body, &sent))
+		assert.Equal(t, []string{"###", "<END>"}, sent.InferenceConfig.StopSequences)
+	})
+
+	t.Run("没有停止序列", func(t *testing.T) {
+		for _, mode := range []string{"native", "injected"} {
+			t.Setenv("STOP_SEQUENCES_MODE", mode)
+			req := newRequest()
+			req.StopSequences = []string{""}
+
+			cwReq, err := BuildCodeWhispererRequest(req, nil)
+			require.NoError(t, err)
+			assert.Nil(t, cwReq.InferenceConfig, mode)
+			assert.Equal(t, "You are a helpful assistant.", systemHistoryContent(t, cwReq), mode)
+		}
+	})
+}
@@ -0,0 +1,419 @@
+package converter
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"kiro2api/config"
+	"kiro2api/types"
+	"kiro2api/utils"
+)
+
+// response_format 的类型
+const (
+	ResponseFormatText       = "text"
+	ResponseFormatJSONObject = "json_object"
+	ResponseFormatJSONSchema = "json_schema"
+)
+
+// IsStructuredOutput 请求是否要求以JSON输出
+func IsStructuredOutput(rf *types.ResponseFormat) bool {
+	return rf != nil && (rf.Type == ResponseFormatJSONObject || rf.Type == ResponseFormatJSONSchema)
+}
+
+// ValidateResponseFormat 检查 response_format 的结构，返回全部校验错误
+func ValidateResponseFormat(rf *types.ResponseFormat) []ValidationError {
+	v := &requestValidator{}
+	v.responseFormat(rf)
+	return v.errs
+}
+
+// StructuredOutputInstruction 返回附加到当前用户消息的输出约束说明，不要求JSON输出时返回空字符串
+// CodeWhisperer 请求没有对应的 responseFormat 字段，约束只能以指令的形式传给模型，由代理在返回前校验
+func StructuredOutputInstruction(rf *types.ResponseFormat) string {
+	if !IsStructuredOutput(rf) {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteString("<system-instruction>\n")
+	sb.WriteString(config.StructuredOutputInstruction())
+	if rf.Type == ResponseFormatJSONSchema && rf.JSONSchema != nil {
+		schema, err := utils.SafeMarshal(rf.JSONSchema.Schema)
+		if err == nil {
+			sb.WriteString(" The JSON must conform to the following JSON Schema")
+			if rf.JSONSchema.Name != "" {
+				sb.WriteString(" (" + rf.JSONSchema.Name + ")")
+			}
+			sb.WriteString(":\n")
+			sb.Write(schema)
+		}
+	} else {
+		sb.WriteString(" The top-level value must be a JSON object.")
+	}
+	sb.WriteString("\n</system-instruction>")
+	return sb.String()
+}
+
+// StructuredOutputCorrection 返回 json_schema 校验失败后重试时发给模型的纠错消息，说明上一次输出不符合约束的位置
+// 输出约束本身由 StructuredOutputInstruction 照常附加在这条消息之后
+func StructuredOutputCorrection(violation error) string {
+	return "Your previous response was rejected because it did not satisfy the required JSON output format: " +
+		violation.Error() + ". Respond again with corrected JSON."
+}
+
+// SchemaViolation 值不符合 JSON Schema（response_format 或工具 input_schema）约束
+// Path 为不符合约束的位置（如 $.items.0.name），整体无法解析时为 $
+type SchemaViolation struct {
+	Path    string
+	Message string
+}
+
+func (e *SchemaViolation) Error() string {
+	return e.Path + ": " + e.Message
+}
+
+// ValidateStructuredOutput 校验模型输出是否为满足 response_format 的JSON
+// 返回去掉首尾空白和 markdown 代码块围栏后的JSON文本；整体无法解析时取文本中第一个完整的JSON对象
+// （json_schema 也接受数组）；不符合约束时返回 *SchemaViolation
+func ValidateStructuredOutput(rf *types.ResponseFormat, text string) (string, error) {
+	cleaned := stripJSONFence(text)
+
+	var value any
+	if err := utils.SafeUnmarshal([]byte(cleaned), &value); err != nil {
+		extracted, extractedValue, ok := extractJSONValue(cleaned, rf.Type == ResponseFormatJSONSchema)
+		if !ok {
+			return "", &SchemaViolation{Path: "$", Message: fmt.Sprintf("响应不是有效的JSON: %v", err)}
+		}
+		cleaned, value = extracted, extractedValue
+	}
+
+	switch {
+	case rf.Type == ResponseFormatJSONSchema && rf.JSONSchema != nil:
+		if err := validateJSONSchema(rf.JSONSchema.Schema, rf.JSONSchema.Schema, value, "$"); err != nil {
+			return "", err
+		}
+	default:
+		if _, ok := value.(map[string]any); !ok {
+			return "", &SchemaViolation{Path: "$", Message: "顶层必须是JSON对象"}
+		}
+	}
+	return cleaned, nil
+}
+
+// ValidateJSONSchema 按 validateJSONSchema 支持的关键字子集校验值，schema 为根（$ref 相对它解析）
+func ValidateJSONSchema(schema map[string]any, value any) *SchemaViolation {
+	return validateJSONSchema(schema, schema, value, "$")
+}
+
+// stripJSONFence 去掉模型常见的 ```json ... ``` 包裹
+func stripJSONFence(text string) string {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "```") || !strings.HasSuffix(text, "```") || len(text) < 6 {
+		return text
+	}
+	body := strings.TrimSuffix(text[3:], "```")
+	if newline := strings.IndexByte(body, '\n'); newline >= 0 {
+		body = body[newline+1:]
+	} else {
+		body = strings.TrimPrefix(body, "json")
+	}
+	return strings.TrimSpace(body)
+}
+
+// extractJSONValue 在夹杂说明文字的输出中查找第一个括号配对完整且能解析的JSON对象，allowArray 为true时也接受数组
+// 扫描时跳过字符串字面量内的括号；某个候选无法解析时从下一个起始括号继续查找
+func extractJSONValue(text string, allowArray bool) (string, any, bool) {
+	for start := 0; start < len(text); start++ {
+		if text[start] != '{' && !(allowArray && text[start] == '[') {
+			continue
+		}
+		end, ok := matchJSONBrackets(text, start)
+		if !ok {
+			continue
+		}
+		candidate := text[start : end+1]
+		var value any
+		if utils.SafeUnmarshal([]byte(candidate), &value) == nil {
+			return candidate, value, true
+		}
+	}
+	return "", nil, false
+}
+
+// matchJSONBrackets 返回与 text[start] 处的左括号配对的右括号位置
+func matchJSONBrackets(text string, start int) (int, bool) {
+	depth := 0
+	inString, escaped := false, false
+	for i := start; i < len(text); i++ {
+		ch := text[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case ch == '\\':
+				escaped = true
+			case ch == '"':
+				inString = false
+			}
+			continue
+		}
+		switch ch {
+		case '"':
+			inString = true
+		case '{', '[':
+			depth++
+		case '}', ']':
+			depth--
+			if depth == 0 {
+				return i, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// validateJSONSchema 按 JSON Schema 的常用子集校验值：
+// type、enum、const、properties、required、additionalProperties、items、minItems/maxItems、
+// minLength/maxLength、pattern、minimum/maximum、exclusiveMinimum/exclusiveMaximum、anyOf/oneOf/allOf、
+// 以及指向 #/$defs 或 #/definitions 的本地 $ref；其余关键字忽略
+func validateJSONSchema(root, schema map[string]any, value any, path string) *SchemaViolation {
+	if ref, ok := schema["$ref"].(string); ok {
+		resolved, found := resolveSchemaRef(root, ref)
+		if !found {
+			return &SchemaViolation{Path: path, Message: "无法解析 $ref: " + ref}
+		}
+		return validateJSONSchema(root, resolved, value, path)
+	}
+
+	fail := func(format string, args ...any) *SchemaViolation {
+		return &SchemaViolation{Path: path, Message: fmt.Sprintf(format, args...)}
+	}
+
+	if expected, ok := schema["type"]; ok && !matchesSchemaType(expected, value) {
+		return fail("类型应为 %s，实际为 %s", schemaTypeLabel(expected), jsonTypeName(value))
+	}
+	if enum, ok := schema["enum"].([]any); ok && !containsJSONValue(enum, value) {
+		return fail("取值不在 enum 中")
+	}
+	if constant, ok := schema["const"]; ok && !reflect.DeepEqual(constant, value) {
+		return fail("取值不等于 const")
+	}
+
+	for _, sub := range schemaList(schema["allOf"]) {
+		if err := validateJSONSchema(root, sub, value, path); err != nil {
+			return err
+		}
+	}
+	if anyOf := schemaList(schema["anyOf"]); len(anyOf) > 0 && countMatchingSchemas(root, anyOf, value, path) == 0 {
+		return fail("不满足 anyOf 中的任何一个 schema")
+	}
+	if oneOf := schemaList(schema["oneOf"]); len(oneOf) > 0 && countMatchingSchemas(root, oneOf, value, path) != 1 {
+		return fail("必须恰好满足 oneOf 中的一个 schema")
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		return validateSchemaObject(root, schema, v, path)
+	case []any:
+		if minItems, ok := schemaNumber(schema, "minItems"); ok && float64(len(v)) < minItems {
+			return fail("元素个数 %d 少于 minItems %v", len(v), minItems)
+		}
+		if maxItems, ok := schemaNumber(schema, "maxItems"); ok && float64(len(v)) > maxItems {
+			return fail("元素个数 %d 超过 maxItems %v", len(v), maxItems)
+		}
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range v {
+				if err := validateJSONSchema(root, items, item, fmt.Sprintf("%s.%d", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		length := float64(len([]rune(v)))
+		if minLength, ok := schemaNumber(schema, "minLength"); ok && length < minLength {
+			return fail("长度 %v 小于 minLength %v", length, minLength)
+		}
+		if maxLength, ok := schemaNumber(schema, "maxLength"); ok && length > maxLength {
+			return fail("长度 %v 超过 maxLength %v", length, maxLength)
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			re, err := regexp.Compile(pattern)
+			if err == nil && !re.MatchString(v) {
+				return fail("不匹配 pattern %s", pattern)
+			}
+		}
+	case float64:
+		if minimum, ok := schemaNumber(schema, "minimum"); ok && v < minimum {
+			return fail("%v 小于 minimum %v", v, minimum)
+		}
+		if maximum, ok := schemaNumber(schema, "maximum"); ok && v > maximum {
+			return fail("%v 大于 maximum %v", v, maximum)
+		}
+		if exclusive, ok := schemaNumber(schema, "exclusiveMinimum"); ok && v <= exclusive {
+			return fail("%v 不大于 exclusiveMinimum %v", v, exclusive)
+		}
+		if exclusive, ok := schemaNumber(schema, "exclusiveMaximum"); ok && v >= exclusive {
+			return fail("%v 不小于 exclusiveMaximum %v", v, exclusive)
+		}
+	}
+	return nil
+}
+
+// validateSchemaObject 校验对象的 required、properties 与 additionalProperties（按属性名排序，错误位置稳定）
+func validateSchemaObject(root, schema, object map[string]any, path string) *SchemaViolation {
+	for _, item := range toStringList(schema["required"]) {
+		if _, ok := object[item]; !ok {
+			return &SchemaViolation{Path: path, Message: "缺少必需字段 " + item}
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]any)
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fieldPath := path + "." + name
+		if propSchema, ok := properties[name].(map[string]any); ok {
+			if err := validateJSONSchema(root, propSchema, object[name], fieldPath); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, declared := properties[name]; declared {
+			continue
+		}
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				return &SchemaViolation{Path: fieldPath, Message: "不允许的字段"}
+			}
+		case map[string]any:
+			if err := validateJSONSchema(root, additional, object[name], fieldPath); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolveSchemaRef 解析 #/$defs/name 与 #/definitions/name 形式的本地引用
+func resolveSchemaRef(root map[string]any, ref string) (map[string]any, bool) {
+	for _, prefix := range []string{"#/$defs/", "#/definitions/"} {
+		if name, ok := strings.CutPrefix(ref, prefix); ok {
+			defs, _ := root[strings.TrimSuffix(strings.TrimPrefix(prefix, "#/"), "/")].(map[string]any)
+			schema, found := defs[name].(map[string]any)
+			return schema, found
+		}
+	}
+	if ref == "#" {
+		return root, true
+	}
+	return nil, false
+}
+
+func countMatchingSchemas(root map[string]any, schemas []map[string]any, value any, path string) int {
+	matched := 0
+	for _, sub := range schemas {
+		if validateJSONSchema(root, sub, value, path) == nil {
+			matched++
+		}
+	}
+	return matched
+}
+
+func matchesSchemaType(expected, value any) bool {
+	switch t := expected.(type) {
+	case string:
+		return matchesJSONType(t, value)
+	case []any:
+		for _, item := range t {
+			if name, ok := item.(string); ok && matchesJSONType(name, value) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func matchesJSONType(name string, value any) bool {
+	switch name {
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	default:
+		return jsonTypeName(value) == name
+	}
+}
+
+func jsonTypeName(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func schemaTypeLabel(expected any) string {
+	if list, ok := expected.([]any); ok {
+		return strings.Join(toStringList(list), "/")
+	}
+	return fmt.Sprint(expected)
+}
+
+func containsJSONValue(values []any, value any) bool {
+	for _, candidate := range values {
+		if reflect.DeepEqual(candidate, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func schemaList(raw any) []map[string]any {
+	list, _ := raw.([]any)
+	schemas := make([]map[string]any, 0, len(list))
+	for _, item := range list {
+		if schema, ok := item.(map[string]any); ok {
+			schemas = append(schemas, schema)
+		}
+	}
+	return schemas
+}
+
+func schemaNumber(schema map[string]any, key string) (float64, bool) {
+	n, ok := schema[key].(float64)
+	return n, ok
+}
+
+func toStringList(raw any) []string {
+	list, _ := raw.([]any)
+	items := make([]string, 0, len(list))
+	for _, item := range list {
+		if s, ok := item.(string); ok {
+			items = append(items, s)
+		}
+	}
+	return items
+}
@@ -0,0 +1,133 @@
+package converter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"kiro2api/types"
+	"kiro2api/utils"
+)
+
+func parseResponseFormat(t *testing.T, fixture string) *types.ResponseFormat {
+	t.Helper()
+	var rf types.ResponseFormat
+	require.NoError(t, utils.SafeUnmarshal([]byte(fixture), &rf))
+	return &rf
+}
+
+const weatherSchema = `{"type": "json_schema", "json_schema": {"name": "weather", "schema": {
+	"type": "object",
+	"required": ["city", "temperature", "conditions"],
+	"additionalProperties": false,
+	"properties": {
+		"city": {"type": "string", "minLength": 1},
+		"temperature": {"type": "number", "minimum": -100, "maximum": 100},
+		"unit": {"enum": ["celsius", "fahrenheit"]},
+		"conditions": {"type": "array", "minItems": 1, "items": {"$ref": "#/$defs/condition"}},
+		"humidity": {"type": ["integer", "null"]},
+		"source": {"anyOf": [{"type": "string", "pattern": "^https://"}, {"type": "null"}]}
+	},
+	"$defs": {"condition": {"type": "object", "required": ["kind"], "properties": {"kind": {"type": "string"}}}}
+}}}`
+
+func TestValidateStructuredOutput(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		text    string
+		cleaned string
+		errPath string
+	}{
+		{
+			name:    "符合schema",
+			format:  weatherSchema,
+			text:    `{"city": "Paris", "temperature": 21.5, "unit": "celsius", "conditions": [{"kind": "sunny"}], "humidity": 40, "source": "https://example.com"}`,
+			cleaned: `{"city": "Paris", "temperature": 21.5, "unit": "celsius", "conditions": [{"kind": "sunny"}], "humidity": 40, "source": "https://example.com"}`,
+		},
+		{
+			name:    "去掉代码块围栏",
+			format:  weatherSchema,
+			text:    "```json\n{\"city\": \"Paris\", \"temperature\": 21, \"conditions\": [{\"kind\": \"rain\"}], \"humidity\": null}\n```",
+			cleaned: `{"city": "Paris", "temperature": 21, "conditions": [{"kind": "rain"}], "humidity": null}`,
+		},
+		{name: "不是JSON", format: weatherSchema, text: `The weather in Paris is sunny.`, errPath: "$"},
+		{name: "JSON后有多余文本", format: `{"type": "json_object"}`, text: `{"a": 1} done`, cleaned: `{"a": 1}`},
+		{name: "从说明文字中提取对象", format: `{"type": "json_object"}`, text: `Sure! Here is the data: {"note": "use } and { freely", "n": [1, {"x": 2}]} Hope this helps.`, cleaned: `{"note": "use } and { freely", "n": [1, {"x": 2}]}`},
+		{name: "json_object跳过数组和不完整的对象", format: `{"type": "json_object"}`, text: `Values [1, 2] then {broken then {"ok": true}`, cleaned: `{"ok": true}`},
+		{name: "json_schema可提取数组", format: `{"type": "json_schema", "json_schema": {"schema": {"type": "array"}}}`, text: `Result: [1, 2, 3].`, cleaned: `[1, 2, 3]`},
+		{name: "提取的对象仍按schema校验", format: weatherSchema, text: `The answer is {"city": "Paris"} as requested.`, errPath: "$"},
+		{name: "缺少必需字段", format: weatherSchema, text: `{"city": "Paris", "conditions": [{"kind": "sunny"}]}`, errPath: "$"},
+		{name: "类型错误", format: weatherSchema, text: `{"city": "Paris", "temperature": "warm", "conditions": [{"kind": "sunny"}]}`, errPath: "$.temperature"},
+		{name: "超出maximum", format: weatherSchema, text: `{"city": "Paris", "temperature": 120, "conditions": [{"kind": "sunny"}]}`, errPath: "$.temperature"},
+		{name: "不在enum中", format: weatherSchema, text: `{"city": "Paris", "temperature": 20, "unit": "kelvin", "conditions": [{"kind": "sunny"}]}`, errPath: "$.unit"},
+		{name: "不允许的字段", format: weatherSchema, text: `{"city": "Paris", "temperature": 20, "conditions": [{"kind": "sunny"}], "wind": 3}`, errPath: "$.wind"},
+		{name: "数组元素通过$ref校验", format: weatherSchema, text: `{"city": "Paris", "temperature": 20, "conditions": [{"kind": "sunny"}, {}]}`, errPath: "$.conditions.1"},
+		{name: "minItems", format: weatherSchema, text: `{"city": "Paris", "temperature": 20, "conditions": []}`, errPath: "$.conditions"},
+		{name: "integer不接受小数", format: weatherSchema, text: `{"city": "Paris", "temperature": 20, "conditions": [{"kind": "sunny"}], "humidity": 40.5}`, errPath: "$.humidity"},
+		{name: "anyOf都不满足", format: weatherSchema, text: `{"city": "Paris", "temperature": 20, "conditions": [{"kind": "sunny"}], "source": "ftp://x"}`, errPath: "$.source"},
+		{name: "json_object接受任意对象", format: `{"type": "json_object"}`, text: ` {"anything": [1, 2]} `, cleaned: `{"anything": [1, 2]}`},
+		{name: "json_object要求顶层为对象", format: `{"type": "json_object"}`, text: `[1, 2]`, errPath: "$"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cleaned, err := ValidateStructuredOutput(parseResponseFormat(t, tt.format), tt.text)
+			if tt.errPath == "" {
+				require.NoError(t, err)
+				assert.Equal(t, tt.cleaned, cleaned)
+				return
+			}
+			var outputErr *SchemaViolation
+			require.ErrorAs(t, err, &outputErr)
+			assert.Equal(t, tt.errPath, outputErr.Path, outputErr.Message)
+		})
+	}
+}
+
+func TestStructuredOutputInstruction(t *testing.T) {
+	assert.Empty(t, StructuredOutputInstruction(nil))
+	assert.Empty(t, StructuredOutputInstruction(&types.ResponseFormat{Type: ResponseFormatText}))
+
+	instruction := StructuredOutputInstruction(parseResponseFormat(t, `{"type": "json_schema", "json_schema": {"name": "person", "schema": {"type": "object", "required": ["name"]}}}`))
+	assert.Contains(t, instruction, "(person)")
+	assert.Contains(t, instruction, `{"required":["name"],"type":"object"}`)
+
+	assert.Contains(t, StructuredOutputInstruction(&types.ResponseFormat{Type: ResponseFormatJSONObject}), "JSON object")
+
+	t.Setenv("STRUCTURED_OUTPUT_INSTRUCTION", "Output JSON only.")
+	assert.Equal(t, "<system-instruction>\nOutput JSON only. The top-level value must be a JSON object.\n</system-instruction>",
+		StructuredOutputInstruction(&types.ResponseFormat{Type: ResponseFormatJSONObject}))
+}
+
+func TestStructuredOutputCorrection(t *testing.T) {
+	correction := StructuredOutputCorrection(&SchemaViolation{Path: "$.name", Message: "类型应为 string，实际为 number"})
+	assert.Equal(t, "Your previous response was rejected because it did not satisfy the required JSON output format: $.name: 类型应为 string，实际为 number. Respond again with corrected JSON.", correction)
+}
+
+func TestValidateResponseFormat(t *testing.T) {
+	assert.Empty(t, ValidateResponseFormat(nil))
+	assert.Empty(t, ValidateResponseFormat(&types.ResponseFormat{Type: ResponseFormatJSONObject}))
+
+	errs := ValidateResponseFormat(&types.ResponseFormat{Type: ResponseFormatJSONSchema})
+	require.Len(t, errs, 1)
+	assert.Equal(t, "response_format.json_schema.schema", errs[0].Field)
+
+	errs = ValidateResponseFormat(&types.ResponseFormat{Type: "xml"})
+	require.Len(t, errs, 1)
+	assert.Equal(t, "response_format.type", errs[0].Field)
+}
+
+func TestRequestBuilder_StructuredOutputInstruction(t *testing.T) {
+	req := types.AnthropicRequest{
+		Model:          "claude-sonnet-4",
+		Messages:       []types.AnthropicRequestMessage{userMsg("Describe Paris")},
+		ResponseFormat: &types.ResponseFormat{Type: ResponseFormatJSONObject},
+	}
+	state, err := NewRequestBuilder().buildCurrentMessage(&builderState{anthropicReq: req})
+	require.NoError(t, err)
+
+	content := state.cwReq.ConversationState.CurrentMessage.UserInputMessage.Content
+	assert.Contains(t, content, "Describe Paris\n\n<system-instruction>")
+	assert.Contains(t, content, "The top-level value must be a JSON object.")
+}
@@ -0,0 +1,74 @@
+package converter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"kiro2api/types"
+	"kiro2api/utils"
+)
+
+// HasSystemDocuments 系统提示中是否包含 document 块
+func HasSystemDocuments(system []types.AnthropicSystemMessage) bool {
+	for _, sysMsg := range system {
+		if sysMsg.Type == "document" {
+			return true
+		}
+	}
+	return false
+}
+
+// InlineSystemDocuments 将系统提示中的 document 块转换为文本块
+// url 来源通过 fetcher 拉取（受白名单、超时和大小限制约束），text 来源直接内联；
+// 返回新切片，不修改原系统提示
+func InlineSystemDocuments(ctx context.Context, system []types.AnthropicSystemMessage, fetcher *utils.DocumentFetcher) ([]types.AnthropicSystemMessage, error) {
+	result := make([]types.AnthropicSystemMessage, 0, len(system))
+
+	for i, sysMsg := range system {
+		if sysMsg.Type != "document" {
+			result = append(result, sysMsg)
+			continue
+		}
+		if sysMsg.Source == nil {
+			return nil, fmt.Errorf("system[%d]: document 块缺少 source", i)
+		}
+
+		var content, origin string
+		switch sysMsg.Source.Type {
+		case "url":
+			text, err := fetcher.Fetch(ctx, sysMsg.Source.URL)
+			if err != nil {
+				return nil, fmt.Errorf("system[%d]: %w", i, err)
+			}
+			content, origin = text, sysMsg.Source.URL
+		case "text":
+			content = sysMsg.Source.Data
+		default:
+			return nil, fmt.Errorf("system[%d]: 不支持的文档来源类型: %s", i, sysMsg.Source.Type)
+		}
+
+		result = append(result, types.AnthropicSystemMessage{
+			Type: "text",
+			Text: formatDocumentText(sysMsg.Title, origin, content),
+		})
+	}
+
+	return result, nil
+}
+
+// formatDocumentText 以 <document> 标签包裹文档内容，便于模型区分文档与指令
+func formatDocumentText(title, origin, content string) string {
+	var sb strings.Builder
+	sb.WriteString("<document")
+	if title != "" {
+		fmt.Fprintf(&sb, " title=%q", title)
+	}
+	if origin != "" {
+		fmt.Fprintf(&sb, " source=%q", origin)
+	}
+	sb.WriteString(">\n")
+	sb.WriteString(strings.TrimSpace(content))
+	sb.WriteString("\n</document>")
+	return sb.String()
+}
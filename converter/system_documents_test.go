@@ -0,0 +1,73 @@
+package converter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"kiro2api/types"
+	"kiro2api/utils"
+)
+
+func TestInlineSystemDocuments(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("Always answer in English.\n"))
+	}))
+	defer server.Close()
+
+	system := []types.AnthropicSystemMessage{
+		{Type: "text", Text: "You are a helpful assistant."},
+		{Type: "document", Title: "Style Guide", Source: &types.DocumentSource{Type: "url", URL: server.URL + "/style.txt"}},
+		{Type: "document", Source: &types.DocumentSource{Type: "text", MediaType: "text/plain", Data: "inline notes"}},
+	}
+	fetcher := utils.NewDocumentFetcher([]string{"127.0.0.1"}, time.Second, 1024)
+
+	require.True(t, HasSystemDocuments(system))
+	result, err := InlineSystemDocuments(context.Background(), system, fetcher)
+	require.NoError(t, err)
+
+	require.Len(t, result, 3)
+	assert.Equal(t, system[0], result[0])
+	assert.Equal(t, "text", result[1].Type)
+	assert.Equal(t, "<document title=\"Style Guide\" source=\""+server.URL+"/style.txt\">\nAlways answer in English.\n</document>", result[1].Text)
+	assert.Equal(t, "<document>\ninline notes\n</document>", result[2].Text)
+	assert.False(t, HasSystemDocuments(result))
+
+	// 原系统提示不被修改
+	assert.Equal(t, "document", system[1].Type)
+}
+
+func TestInlineSystemDocuments_AllowlistEnforced(t *testing.T) {
+	requested := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = true
+	}))
+	defer server.Close()
+
+	system := []types.AnthropicSystemMessage{
+		{Type: "document", Source: &types.DocumentSource{Type: "url", URL: server.URL}},
+	}
+	fetcher := utils.NewDocumentFetcher([]string{"docs.example.com"}, time.Second, 1024)
+
+	_, err := InlineSystemDocuments(context.Background(), system, fetcher)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, utils.ErrDocumentURLNotAllowed)
+	assert.False(t, requested)
+}
+
+func TestInlineSystemDocuments_InvalidSource(t *testing.T) {
+	fetcher := utils.NewDocumentFetcher(nil, time.Second, 1024)
+
+	_, err := InlineSystemDocuments(context.Background(), []types.AnthropicSystemMessage{{Type: "document"}}, fetcher)
+	assert.ErrorContains(t, err, "缺少 source")
+
+	_, err = InlineSystemDocuments(context.Background(), []types.AnthropicSystemMessage{
+		{Type: "document", Source: &types.DocumentSource{Type: "base64", Data: "JVBERi0="}},
+	}, fetcher)
+	assert.ErrorContains(t, err, "不支持的文档来源类型")
+}
@@ -0,0 +1,54 @@
+package converter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"kiro2api/config"
+	"kiro2api/types"
+)
+
+// SystemPromptInjection 服务端配置的系统提示注入，在客户端系统提示前后追加
+type SystemPromptInjection struct {
+	Prefix string
+	Suffix string
+}
+
+// ActiveSystemPromptInjection 返回当前配置（SYSTEM_PROMPT_PREFIX/SYSTEM_PROMPT_SUFFIX）的系统提示注入
+func ActiveSystemPromptInjection() SystemPromptInjection {
+	return SystemPromptInjection{
+		Prefix: strings.TrimSpace(config.SystemPromptPrefix()),
+		Suffix: strings.TrimSpace(config.SystemPromptSuffix()),
+	}
+}
+
+// Empty 是否没有需要注入的内容
+func (i SystemPromptInjection) Empty() bool {
+	return i.Prefix == "" && i.Suffix == ""
+}
+
+// Hash 返回注入内容的 SHA-256（十六进制），用于确认部署的版本而不暴露原文；未配置时为空
+func (i SystemPromptInjection) Hash() string {
+	if i.Empty() {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(i.Prefix + "\x00" + i.Suffix))
+	return hex.EncodeToString(sum[:])
+}
+
+// Apply 返回注入后的系统提示，前缀和后缀各作为一个文本块；不修改原切片
+func (i SystemPromptInjection) Apply(system []types.AnthropicSystemMessage) []types.AnthropicSystemMessage {
+	if i.Empty() {
+		return system
+	}
+	result := make([]types.AnthropicSystemMessage, 0, len(system)+2)
+	if i.Prefix != "" {
+		result = append(result, types.AnthropicSystemMessage{Type: "text", Text: i.Prefix})
+	}
+	result = append(result, system...)
+	if i.Suffix != "" {
+		result = append(result, types.AnthropicSystemMessage{Type: "text", Text: i.Suffix})
+	}
+	return result
+}
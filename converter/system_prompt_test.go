@@ -0,0 +1,86 @@
+package converter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"kiro2api/types"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// systemHistoryContent 返回历史中第一条（系统提示）消息的内容
+func systemHistoryContent(t *testing.T, cwReq types.CodeWhispererRequest) string {
+	t.Helper()
+	require.NotEmpty(t, cwReq.ConversationState.History)
+	first, ok := cwReq.ConversationState.History[0].(types.HistoryUserMessage)
+	require.True(t, ok)
+	return first.UserInputMessage.Content
+}
+
+func TestBuildCodeWhispererRequest_InjectsSystemPrompt(t *testing.T) {
+	t.Setenv("SYSTEM_PROMPT_PREFIX", "遵守组织安全规范。")
+	t.Setenv("SYSTEM_PROMPT_SUFFIX", "回答使用中文。")
+
+	tests := []struct {
+		name     string
+		system   []types.AnthropicSystemMessage
+		expected string
+	}{
+		{"客户端有系统提示", []types.AnthropicSystemMessage{{Type: "text", Text: "You are a helpful assistant."}},
+			"遵守组织安全规范。\nYou are a helpful assistant.\n回答使用中文。"},
+		{"客户端没有系统提示", nil, "遵守组织安全规范。\n回答使用中文。"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := types.AnthropicRequest{
+				Model:    "claude-sonnet-4",
+				System:   tt.system,
+				Messages: []types.AnthropicRequestMessage{userMsg("hello")},
+			}
+
+			cwReq, err := BuildCodeWhispererRequest(req, nil)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, systemHistoryContent(t, cwReq))
+			assert.Len(t, req.System, len(tt.system), "原请求不被修改")
+		})
+	}
+
+	t.Run("空注入时跳过", func(t *testing.T) {
+		req := types.AnthropicRequest{
+			Model:    "claude-sonnet-4",
+			System:   []types.AnthropicSystemMessage{{Type: "text", Text: "client"}},
+			Messages: []types.AnthropicRequestMessage{userMsg("hello")},
+		}
+		cwReq, err := BuildCodeWhispererRequest(req, nil, WithSystemPromptInjection(SystemPromptInjection{}))
+		require.NoError(t, err)
+		assert.Equal(t, "client", systemHistoryContent(t, cwReq))
+
+		req.System = nil
+		cwReq, err = BuildCodeWhispererRequest(req, nil, WithSystemPromptInjection(SystemPromptInjection{}))
+		require.NoError(t, err)
+		assert.Empty(t, cwReq.ConversationState.History)
+	})
+}
+
+func TestActiveSystemPromptInjection(t *testing.T) {
+	t.Setenv("SYSTEM_PROMPT_PREFIX", "")
+	t.Setenv("SYSTEM_PROMPT_SUFFIX", "")
+	assert.True(t, ActiveSystemPromptInjection().Empty())
+	assert.Empty(t, ActiveSystemPromptInjection().Hash())
+
+	// 取值为文件路径时读取文件内容
+	path := filepath.Join(t.TempDir(), "prefix.txt")
+	require.NoError(t, os.WriteFile(path, []byte("  文件中的规则\n"), 0o600))
+	t.Setenv("SYSTEM_PROMPT_PREFIX", path)
+
+	injection := ActiveSystemPromptInjection()
+	assert.Equal(t, SystemPromptInjection{Prefix: "文件中的规则"}, injection)
+	assert.Len(t, injection.Hash(), 64)
+	assert.Equal(t, injection.Hash(), ActiveSystemPromptInjection().Hash(), "相同内容的哈希稳定")
+
+	// 前缀和后缀互换时哈希不同
+	assert.NotEqual(t, SystemPromptInjection{Prefix: "a"}.Hash(), SystemPromptInjection{Suffix: "a"}.Hash())
+}
@@ -0,0 +1,24 @@
+package converter
+
+import (
+	"sync/atomic"
+
+	"kiro2api/config"
+)
+
+// activeToolFilter 当前生效的工具黑白名单，启动时加载，可通过管理接口热更新
+// 每个 RequestBuilder 创建时取一份快照，更新只影响之后的请求
+var activeToolFilter atomic.Pointer[config.ToolFilter]
+
+// SetToolFilter 原子替换全局工具黑白名单
+func SetToolFilter(filter config.ToolFilter) {
+	activeToolFilter.Store(&filter)
+}
+
+// ActiveToolFilter 返回当前生效的工具黑白名单
+func ActiveToolFilter() config.ToolFilter {
+	if filter := activeToolFilter.Load(); filter != nil {
+		return *filter
+	}
+	return config.ToolFilter{}
+}
@@ -0,0 +1,74 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"kiro2api/config"
+	"kiro2api/types"
+	"kiro2api/utils"
+)
+
+// encodeBinaryToolResultContent 将 tool_result 中的 base64 二进制文本替换为图片或文档块
+// 文本整体是合法 base64、解码后超过 BINARY_THRESHOLD_BYTES 且不是UTF-8文本时视为二进制：
+// 可识别的图片格式转换为 {"image": CodeWhispererImage}；上游是否接受其他二进制块尚未确认，
+// 其余格式替换为说明格式和大小的文本占位，避免把大段 base64 当作文本发送
+func encodeBinaryToolResultContent(content []map[string]any) []map[string]any {
+	threshold := config.BinaryThresholdBytes()
+	for i, item := range content {
+		text, ok := item["text"].(string)
+		if !ok {
+			continue
+		}
+		if blockType, exists := item["type"]; exists && blockType != "text" {
+			continue
+		}
+		if block := binaryContentBlock(text, threshold); block != nil {
+			content[i] = block
+		}
+	}
+	return content
+}
+
+// binaryContentBlock 识别 base64 编码的二进制数据，不满足条件时返回nil
+func binaryContentBlock(text string, threshold int) map[string]any {
+	encoded := strings.TrimSpace(text)
+	// 解码后长度约为编码长度的3/4，先按长度排除短文本，避免对普通输出做解码
+	if len(encoded)/4*3 <= threshold || len(encoded)%4 != 0 {
+		return nil
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(data) <= threshold || isPlainText(data) {
+		return nil
+	}
+
+	if mediaType, err := utils.DetectImageFormat(data); err == nil {
+		image := types.CodeWhispererImage{Format: utils.GetImageFormatFromMediaType(mediaType)}
+		image.Source.Bytes = encoded
+		return map[string]any{"image": image}
+	}
+
+	return map[string]any{
+		"text": fmt.Sprintf("[二进制内容已省略: 格式 %s, %d 字节]", binaryDocumentFormat(data), len(data)),
+	}
+}
+
+// isPlainText 解码结果是否为文本（base64编码的文本内容保持原样发送）
+func isPlainText(data []byte) bool {
+	return utf8.Valid(data) && bytes.IndexByte(data, 0) < 0
+}
+
+// binaryDocumentFormat 按文件头识别二进制格式，无法识别时返回 "bin"
+func binaryDocumentFormat(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("%PDF-")):
+		return "pdf"
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")):
+		return "zip"
+	default:
+		return "bin"
+	}
+}
@@ -0,0 +1,72 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"kiro2api/types"
+)
+
+// syntheticBinary 以给定文件头开头、填充不可打印字节的二进制数据
+func syntheticBinary(header string, size int) []byte {
+	data := append([]byte(header), bytes.Repeat([]byte{0x00, 0xFF}, size/2)...)
+	return data[:size]
+}
+
+func binaryToolResult(id string, content any) []any {
+	return []any{map[string]any{"type": "tool_result", "tool_use_id": id, "content": content}}
+}
+
+func TestExtractToolResultsFromMessage_BinaryContent(t *testing.T) {
+	t.Setenv("BINARY_THRESHOLD_BYTES", "64")
+
+	t.Run("PNG 转换为图片块", func(t *testing.T) {
+		encoded := base64.StdEncoding.EncodeToString(syntheticBinary("\x89PNG\r\n\x1a\n", 128))
+		results := extractToolResultsFromMessage(binaryToolResult("tool_png", encoded))
+
+		require.Len(t, results, 1)
+		require.Len(t, results[0].Content, 1)
+		image, ok := results[0].Content[0]["image"].(types.CodeWhispererImage)
+		require.True(t, ok, "应转换为图片块: %v", results[0].Content[0])
+		assert.Equal(t, "png", image.Format)
+		assert.Equal(t, encoded, image.Source.Bytes)
+	})
+
+	t.Run("PDF 替换为文本占位", func(t *testing.T) {
+		encoded := base64.StdEncoding.EncodeToString(syntheticBinary("%PDF-1.7\n", 128))
+		results := extractToolResultsFromMessage(binaryToolResult("tool_pdf", []any{
+			map[string]any{"type": "text", "text": "读取成功"},
+			map[string]any{"type": "text", "text": encoded},
+		}))
+
+		require.Len(t, results[0].Content, 2)
+		assert.Equal(t, "读取成功", results[0].Content[0]["text"])
+		assert.Equal(t, map[string]any{"text": "[二进制内容已省略: 格式 pdf, 128 字节]"}, results[0].Content[1])
+	})
+
+	t.Run("无法识别的二进制", func(t *testing.T) {
+		encoded := base64.StdEncoding.EncodeToString(syntheticBinary("\x01\x02", 100))
+		isError := false
+		id := "tool_bin"
+		results := extractToolResultsFromMessage([]types.ContentBlock{
+			{Type: "tool_result", ToolUseId: &id, Content: encoded, IsError: &isError},
+		})
+
+		assert.Equal(t, map[string]any{"text": "[二进制内容已省略: 格式 bin, 100 字节]"}, results[0].Content[0])
+	})
+
+	t.Run("保持文本原样", func(t *testing.T) {
+		cases := map[string]string{
+			"未超过阈值":       base64.StdEncoding.EncodeToString(syntheticBinary("\x89PNG\r\n\x1a\n", 64)),
+			"base64编码的文本": base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("plain text "), 20)),
+			"非base64":     "这不是 base64 内容，" + string(bytes.Repeat([]byte("x"), 200)),
+		}
+		for name, text := range cases {
+			results := extractToolResultsFromMessage(binaryToolResult("tool_text", text))
+			assert.Equal(t, []map[string]any{{"text": text}}, results[0].Content, name)
+		}
+	})
+}
@@ -2,17 +2,21 @@ package converter
 
 import (
 	"fmt"
+	"maps"
+	"slices"
 	"strings"
 
+	"kiro2api/config"
+	"kiro2api/logger"
 	"kiro2api/types"
 	"kiro2api/utils"
 )
 
 // 工具处理器
 
-// validateAndProcessTools 验证和处理工具定义
+// validateAndProcessTools 验证和处理工具定义，被跳过的无效工具同时记录到 warnings
 // 参考server.py中的clean_gemini_schema函数以及Anthropic官方文档
-func validateAndProcessTools(tools []types.OpenAITool) ([]types.AnthropicTool, error) {
+func validateAndProcessTools(tools []types.OpenAITool, warnings *Warnings) ([]types.AnthropicTool, error) {
 	if len(tools) == 0 {
 		return nil, nil
 	}
@@ -21,32 +25,37 @@ func validateAndProcessTools(tools []types.OpenAITool) ([]types.AnthropicTool, e
 	var validationErrors []string
 
 	for i, tool := range tools {
+		path := fmt.Sprintf("tools.%d", i)
 		if tool.Type != "function" {
-			validationErrors = append(validationErrors, fmt.Sprintf("tool[%d]: 不支持的工具类型 '%s'，仅支持 'function'", i, tool.Type))
+			message := fmt.Sprintf("不支持的工具类型 '%s'，仅支持 'function'", tool.Type)
+			validationErrors = append(validationErrors, fmt.Sprintf("tool[%d]: %s", i, message))
+			warnings.Add(WarningToolSkipped, path, message, nil)
 			continue
 		}
 
 		// 验证函数名称
 		if tool.Function.Name == "" {
 			validationErrors = append(validationErrors, fmt.Sprintf("tool[%d]: 函数名称不能为空", i))
+			warnings.Add(WarningToolSkipped, path, "函数名称不能为空", nil)
 			continue
 		}
 
-		// 过滤不支持的工具：web_search (静默过滤，不发送到上游)
-		if tool.Function.Name == "web_search" || tool.Function.Name == "websearch" {
+		// 过滤不支持的工具：web_search（仅 drop 模式静默过滤；error 模式保留以便返回400，local 模式由代理执行）
+		if IsWebSearchTool(tool.Function.Name) && config.WebSearchMode() == config.WebSearchModeDrop {
 			continue
 		}
 
-		// 验证参数schema
-		if tool.Function.Parameters == nil {
-			validationErrors = append(validationErrors, fmt.Sprintf("tool[%d]: 参数schema不能为空", i))
-			continue
+		// OpenAI 规范允许无参函数省略 parameters，按空对象schema处理
+		params := tool.Function.Parameters
+		if len(params) == 0 {
+			params = emptyObjectSchema()
 		}
 
 		// 清理和验证参数
-		cleanedParams, err := cleanAndValidateToolParameters(tool.Function.Parameters)
+		cleanedParams, err := cleanAndValidateToolParameters(params, path+".function.parameters", warnings)
 		if err != nil {
 			validationErrors = append(validationErrors, fmt.Sprintf("tool[%d] (%s): %v", i, tool.Function.Name, err))
+			warnings.Add(WarningToolSkipped, path, err.Error(), map[string]any{"name": tool.Function.Name})
 			continue
 		}
 
@@ -65,8 +74,23 @@ func validateAndProcessTools(tools []types.OpenAITool) ([]types.AnthropicTool, e
 	return anthropicTools, nil
 }
 
-// cleanAndValidateToolParameters 清理和验证工具参数
-func cleanAndValidateToolParameters(params map[string]any) (map[string]any, error) {
+// emptyObjectSchema 无参工具使用的默认schema
+func emptyObjectSchema() map[string]any {
+	return map[string]any{"type": "object", "properties": map[string]any{}}
+}
+
+// NormalizeEmptyTools 没有工具时统一为未提供 tools：清空空数组并忽略 tool_choice
+// 下游以 len(Tools)>0 判断触发类型和历史构建，tool_choice 在没有工具时没有意义
+func NormalizeEmptyTools(req types.AnthropicRequest) types.AnthropicRequest {
+	if len(req.Tools) == 0 {
+		req.Tools = nil
+		req.ToolChoice = nil
+	}
+	return req
+}
+
+// cleanAndValidateToolParameters 清理和验证工具参数，path 为参数schema在请求中的位置，缩短的参数名记录到 warnings
+func cleanAndValidateToolParameters(params map[string]any, path string, warnings *Warnings) (map[string]any, error) {
 	if params == nil {
 		return nil, fmt.Errorf("参数不能为nil")
 	}
@@ -90,7 +114,9 @@ func cleanAndValidateToolParameters(params map[string]any) (map[string]any, erro
 	// 处理超长参数名 - CodeWhisperer限制参数名长度；保留原名映射
 	if properties, ok := tempParams["properties"].(map[string]any); ok {
 		cleanedProperties := make(map[string]any)
-		for paramName, paramDef := range properties {
+		// 按参数名顺序处理，警告顺序保持稳定
+		for _, paramName := range slices.Sorted(maps.Keys(properties)) {
+			paramDef := properties[paramName]
 			cleanedName := paramName
 			// 如果参数名超过64字符，进行简化
 			if len(paramName) > 64 {
@@ -100,6 +126,12 @@ func cleanAndValidateToolParameters(params map[string]any) (map[string]any, erro
 				} else {
 					cleanedName = paramName[:30] + "_param"
 				}
+				logger.Debug("工具参数名超长已缩短",
+					logger.String("original_name", paramName),
+					logger.String("cleaned_name", cleanedName))
+				warnings.Add(WarningToolParameterRenamed, path+".properties."+paramName,
+					fmt.Sprintf("参数名超过64字符，已缩短为 %s", cleanedName),
+					map[string]any{"original": paramName, "renamed": cleanedName})
 			}
 			cleanedProperties[cleanedName] = paramDef
 		}
@@ -309,9 +341,9 @@ func convertContentBlock(block map[string]any) (map[string]any, error) {
 		return block, nil
 
 	case "tool_use":
-		// 过滤不支持的web_search工具调用（静默过滤，返回nil表示跳过）
+		// 过滤不支持的web_search工具调用（local 模式除外，返回nil表示跳过）
 		if name, ok := block["name"].(string); ok {
-			if name == "web_search" || name == "websearch" {
+			if IsWebSearchTool(name) && filterWebSearchByMode() {
 				return nil, nil
 			}
 		}
@@ -6,12 +6,13 @@ import (
 	"kiro2api/types"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestValidateAndProcessTools_EmptyTools(t *testing.T) {
 	tools := []types.OpenAITool{}
 
-	result, err := validateAndProcessTools(tools)
+	result, err := validateAndProcessTools(tools, nil)
 
 	assert.NoError(t, err)
 	assert.Nil(t, result)
@@ -38,7 +39,7 @@ func TestValidateAndProcessTools_ValidTool(t *testing.T) {
 		},
 	}
 
-	result, err := validateAndProcessTools(tools)
+	result, err := validateAndProcessTools(tools, nil)
 
 	assert.NoError(t, err)
 	assert.Len(t, result, 1)
@@ -57,7 +58,7 @@ func TestValidateAndProcessTools_InvalidType(t *testing.T) {
 		},
 	}
 
-	result, err := validateAndProcessTools(tools)
+	result, err := validateAndProcessTools(tools, nil)
 
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "不支持的工具类型")
@@ -77,29 +78,36 @@ func TestValidateAndProcessTools_EmptyFunctionName(t *testing.T) {
 		},
 	}
 
-	result, err := validateAndProcessTools(tools)
+	result, err := validateAndProcessTools(tools, nil)
 
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "函数名称不能为空")
 	assert.Empty(t, result)
 }
 
-func TestValidateAndProcessTools_NilParameters(t *testing.T) {
-	tools := []types.OpenAITool{
-		{
-			Type: "function",
-			Function: types.OpenAIFunction{
-				Name:       "test_func",
-				Parameters: nil,
-			},
-		},
-	}
+func TestValidateAndProcessTools_OmittedParameters(t *testing.T) {
+	for name, params := range map[string]map[string]any{
+		"nil参数":  nil,
+		"空map参数": {},
+	} {
+		t.Run(name, func(t *testing.T) {
+			tools := []types.OpenAITool{
+				{
+					Type: "function",
+					Function: types.OpenAIFunction{
+						Name:       "get_time",
+						Parameters: params,
+					},
+				},
+			}
 
-	result, err := validateAndProcessTools(tools)
+			result, err := validateAndProcessTools(tools, nil)
 
-	assert.Error(t, err)
-	assert.Contains(t, err.Error(), "参数schema不能为空")
-	assert.Empty(t, result)
+			require.NoError(t, err)
+			require.Len(t, result, 1)
+			assert.Equal(t, map[string]any{"type": "object", "properties": map[string]any{}}, result[0].InputSchema)
+		})
+	}
 }
 
 func TestValidateAndProcessTools_MultipleTools(t *testing.T) {
@@ -132,7 +140,7 @@ func TestValidateAndProcessTools_MultipleTools(t *testing.T) {
 		},
 	}
 
-	result, err := validateAndProcessTools(tools)
+	result, err := validateAndProcessTools(tools, nil)
 
 	assert.NoError(t, err)
 	assert.Len(t, result, 2)
@@ -159,7 +167,7 @@ func TestValidateAndProcessTools_MixedValidInvalid(t *testing.T) {
 		},
 	}
 
-	result, err := validateAndProcessTools(tools)
+	result, err := validateAndProcessTools(tools, nil)
 
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "不支持的工具类型")
@@ -212,7 +220,7 @@ func TestValidateAndProcessTools_FilterWebSearch(t *testing.T) {
 		},
 	}
 
-	result, err := validateAndProcessTools(tools)
+	result, err := validateAndProcessTools(tools, nil)
 
 	// web_search should be filtered out silently, no error
 	assert.NoError(t, err)
@@ -257,7 +265,7 @@ func TestValidateAndProcessTools_FilterWebSearchVariant(t *testing.T) {
 		},
 	}
 
-	result, err := validateAndProcessTools(tools)
+	result, err := validateAndProcessTools(tools, nil)
 
 	// websearch variant should also be filtered
 	assert.NoError(t, err)
@@ -491,3 +499,22 @@ func TestConvertOpenAIContentToAnthropic_FilterWebSearchVariantInHistory(t *test
 	assert.True(t, ok)
 	assert.Equal(t, "text", block["type"])
 }
+
+func TestValidateAndProcessTools_WebSearchMode(t *testing.T) {
+	tools := []types.OpenAITool{{
+		Type: "function",
+		Function: types.OpenAIFunction{
+			Name:       "web_search",
+			Parameters: map[string]any{"type": "object", "properties": map[string]any{"query": map[string]any{"type": "string"}}},
+		},
+	}}
+
+	// error 模式保留 web_search，交给处理器返回400；local 模式由代理执行
+	for _, mode := range []string{"error", "local"} {
+		t.Setenv("WEB_SEARCH_MODE", mode)
+		result, err := validateAndProcessTools(tools, nil)
+		require.NoError(t, err)
+		require.Len(t, result, 1, "WEB_SEARCH_MODE=%s", mode)
+		assert.Equal(t, "web_search", result[0].Name)
+	}
+}
@@ -0,0 +1,91 @@
+package converter
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+
+	"kiro2api/config"
+	"kiro2api/utils"
+)
+
+// 转换警告的代码
+const (
+	// WarningToolParameterRenamed 工具参数名超过64字符被缩短
+	WarningToolParameterRenamed = "tool_parameter_renamed"
+	// WarningToolDescriptionTruncated 工具描述超过 MAX_TOOL_DESCRIPTION_LENGTH 被截断
+	WarningToolDescriptionTruncated = "tool_description_truncated"
+	// WarningToolSkipped 工具定义无效（无名称、类型不支持、schema不合法）被跳过
+	WarningToolSkipped = "tool_skipped"
+	// WarningContentBlockDropped 无法解析的内容块被丢弃
+	WarningContentBlockDropped = "content_block_dropped"
+	// WarningHistoryAdjusted 构建历史时调整了客户端消息，Detail 为 HistoryAdjustment
+	WarningHistoryAdjusted = "history_adjusted"
+)
+
+// Warning 转换过程中不影响请求发送的问题
+type Warning struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Path    string `json:"path,omitempty"` // 问题所在位置，如 tools.0.input_schema.properties.xxx
+	Detail  any    `json:"detail,omitempty"`
+}
+
+// Warnings 在转换调用链中传递的警告累积器
+type Warnings []Warning
+
+// Add 追加一条警告；接收者为nil时忽略，调用方不关心警告时可以传nil
+func (w *Warnings) Add(code, path, message string, detail any) {
+	if w == nil {
+		return
+	}
+	*w = append(*w, Warning{Code: code, Message: message, Path: path, Detail: detail})
+}
+
+// Codes 按顺序返回各条警告的代码
+func (w Warnings) Codes() []string {
+	codes := make([]string, 0, len(w))
+	for _, warning := range w {
+		codes = append(codes, warning.Code)
+	}
+	return codes
+}
+
+// JSON 紧凑JSON数组，用于日志
+func (w Warnings) JSON() string {
+	data, err := utils.FastMarshal(w)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// WarningsHeaderValue X-Kiro-Warnings 响应头的值：非ASCII字符转义为 \uXXXX 的紧凑JSON数组，
+// 超过 config.MaxWarningsHeaderBytes 时为该JSON的标准base64编码（不以 "[" 开头）
+func WarningsHeaderValue(warnings Warnings) string {
+	value := escapeNonASCII(warnings.JSON())
+	if len(value) > config.MaxWarningsHeaderBytes {
+		return base64.StdEncoding.EncodeToString([]byte(value))
+	}
+	return value
+}
+
+// escapeNonASCII 把JSON中的非ASCII字符转义为 \uXXXX，响应头只能可靠地传输ASCII
+// 非ASCII字符只会出现在字符串值内，转义后仍是等价的JSON
+func escapeNonASCII(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for _, r := range s {
+		if r < 0x80 {
+			b.WriteRune(r)
+			continue
+		}
+		if r1, r2 := utf16.EncodeRune(r); r1 != '\uFFFD' {
+			fmt.Fprintf(&b, "\\u%04x\\u%04x", r1, r2)
+			continue
+		}
+		fmt.Fprintf(&b, "\\u%04x", r)
+	}
+	return b.String()
+}
@@ -0,0 +1,145 @@
+package converter
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"kiro2api/config"
+	"kiro2api/config/configtest"
+	"kiro2api/types"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateAndProcessTools_ParameterRenamedWarning(t *testing.T) {
+	longName := strings.Repeat("a", 70)
+	tools := []types.OpenAITool{{
+		Type: "function",
+		Function: types.OpenAIFunction{
+			Name: "search",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"query":  map[string]any{"type": "string"},
+					longName: map[string]any{"type": "string"},
+				},
+				"required": []any{longName},
+			},
+		},
+	}}
+
+	var warnings Warnings
+	result, err := validateAndProcessTools(tools, &warnings)
+
+	require.NoError(t, err)
+	require.Len(t, result, 1)
+	assert.Equal(t, []string{WarningToolParameterRenamed}, warnings.Codes())
+	assert.Equal(t, "tools.0.function.parameters.properties."+longName, warnings[0].Path)
+	assert.Equal(t, map[string]any{"original": longName, "renamed": strings.Repeat("a", 30) + "_param"}, warnings[0].Detail)
+}
+
+func TestValidateAndProcessTools_SkippedToolWarning(t *testing.T) {
+	tools := []types.OpenAITool{
+		{Type: "retrieval", Function: types.OpenAIFunction{Name: "search"}},
+		{Type: "function", Function: types.OpenAIFunction{Name: "get_time"}},
+	}
+
+	var warnings Warnings
+	result, err := validateAndProcessTools(tools, &warnings)
+
+	require.Error(t, err)
+	require.Len(t, result, 1)
+	assert.Equal(t, []string{WarningToolSkipped}, warnings.Codes())
+	assert.Equal(t, "tools.0", warnings[0].Path)
+}
+
+func TestBuildCodeWhispererRequest_DescriptionTruncatedWarning(t *testing.T) {
+	configtest.Override(t, func(s *config.Settings) { s.MaxToolDescriptionLength = 16 })
+
+	req := types.AnthropicRequest{
+		Model:     "claude-sonnet-4",
+		MaxTokens: 100,
+		Messages:  []types.AnthropicRequestMessage{userMsg("几点了？")},
+		Tools: []types.AnthropicTool{
+			{Name: "get_time", Description: "Get the current time in the given timezone", InputSchema: emptyObjectSchema()},
+			{Name: "get_date", Description: "Get the date", InputSchema: emptyObjectSchema()},
+		},
+	}
+
+	var warnings Warnings
+	cwReq, err := BuildCodeWhispererRequest(req, nil, WithConversionCache(nil), WithWarnings(&warnings))
+
+	require.NoError(t, err)
+	tools := cwReq.ConversationState.CurrentMessage.UserInputMessage.UserInputMessageContext.Tools
+	require.Len(t, tools, 2)
+	assert.Equal(t, "Get the current ", tools[0].ToolSpecification.Description)
+	assert.Equal(t, []string{WarningToolDescriptionTruncated}, warnings.Codes())
+	assert.Equal(t, "tools.0.description", warnings[0].Path)
+}
+
+func TestBuildCodeWhispererRequest_NoWarnings(t *testing.T) {
+	req := types.AnthropicRequest{
+		Model:     "claude-sonnet-4",
+		MaxTokens: 100,
+		Messages:  []types.AnthropicRequestMessage{userMsg("你好")},
+	}
+
+	warnings := Warnings{{Code: "stale"}}
+	_, err := BuildCodeWhispererRequest(req, nil, WithConversionCache(nil), WithWarnings(&warnings))
+
+	require.NoError(t, err)
+	assert.Empty(t, warnings)
+}
+
+func TestBuildCodeWhispererRequest_ContentBlockDroppedWarning(t *testing.T) {
+	req := types.AnthropicRequest{
+		Model:     "claude-sonnet-4",
+		MaxTokens: 100,
+		Messages: []types.AnthropicRequestMessage{{
+			Role:    "user",
+			Content: []any{map[string]any{"text": "缺少type"}, map[string]any{"type": "text", "text": "你好"}},
+		}},
+	}
+
+	var warnings Warnings
+	cwReq, err := BuildCodeWhispererRequest(req, nil, WithConversionCache(nil), WithWarnings(&warnings))
+
+	require.NoError(t, err)
+	assert.Equal(t, "你好", cwReq.ConversationState.CurrentMessage.UserInputMessage.Content)
+	assert.Equal(t, []string{WarningContentBlockDropped}, warnings.Codes())
+	assert.Equal(t, "messages.0.content.0", warnings[0].Path)
+}
+
+func TestWarningsHeaderValue(t *testing.T) {
+	t.Run("非ASCII字符转义", func(t *testing.T) {
+		warnings := Warnings{{Code: WarningToolSkipped, Message: "函数名称不能为空", Path: "tools.0"}}
+
+		value := WarningsHeaderValue(warnings)
+
+		assert.Equal(t, `[{"code":"tool_skipped","message":"\u51fd\u6570\u540d\u79f0\u4e0d\u80fd\u4e3a\u7a7a","path":"tools.0"}]`, value)
+		assert.JSONEq(t, warnings.JSON(), value)
+	})
+
+	t.Run("超过上限时base64编码", func(t *testing.T) {
+		var warnings Warnings
+		for range 100 {
+			warnings.Add(WarningContentBlockDropped, "messages.0.content.0", "缺少内容块类型", nil)
+		}
+
+		value := WarningsHeaderValue(warnings)
+
+		require.False(t, strings.HasPrefix(value, "["))
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		require.NoError(t, err)
+		assert.JSONEq(t, warnings.JSON(), string(decoded))
+	})
+}
+
+func TestWarnings_AddNilReceiver(t *testing.T) {
+	var warnings *Warnings
+	assert.NotPanics(t, func() {
+		warnings.Add(WarningToolSkipped, "tools.0", "函数名称不能为空", nil)
+	})
+}
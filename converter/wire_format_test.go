@@ -0,0 +1,106 @@
+package converter
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"kiro2api/config"
+	"kiro2api/config/configtest"
+	"kiro2api/types"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// updateGolden 结构体变更有意改变上游请求格式时，执行 go test ./converter -run TestCodeWhispererRequest_Golden -update 重新生成
+var updateGolden = flag.Bool("update", false, "重新生成 testdata 中的 golden 文件")
+
+// wireFormatRequest 覆盖上游请求主要字段：系统提示、工具定义、历史中的图片/工具调用/工具结果，以及当前消息的图片与工具结果
+const wireFormatRequest = `{
+	"model": "claude-sonnet-4",
+	"max_tokens": 1024,
+	"system": [{"type": "text", "text": "You are a helpful assistant."}],
+	"tools": [
+		{"name": "get_weather", "description": "Get weather information", "input_schema": {"type": "object", "properties": {"city": {"type": "string"}}, "required": ["city"]}}
+	],
+	"messages": [
+		{"role": "user", "content": [
+			{"type": "text", "text": "这张图是哪里？"},
+			{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "aGlzdG9yeQ=="}}
+		]},
+		{"role": "assistant", "content": "看起来是北京。"},
+		{"role": "user", "content": "北京天气怎么样？"},
+		{"role": "assistant", "content": [
+			{"type": "text", "text": "我来查询一下。"},
+			{"type": "tool_use", "id": "toolu_weather", "name": "get_weather", "input": {"city": "北京"}}
+		]},
+		{"role": "user", "content": [
+			{"type": "tool_result", "tool_use_id": "toolu_weather", "content": "晴，25°C"},
+			{"type": "text", "text": "对照这张天气图再确认一下"},
+			{"type": "image", "source": {"type": "base64", "media_type": "image/jpeg", "data": "Y3VycmVudA=="}}
+		]}
+	]
+}`
+
+// buildWireFormatRequest 构建并序列化请求，会话ID固定以保证输出稳定
+func buildWireFormatRequest(t *testing.T, raw string) []byte {
+	t.Helper()
+	var anthropicReq types.AnthropicRequest
+	require.NoError(t, json.Unmarshal([]byte(raw), &anthropicReq))
+
+	cwReq, err := BuildCodeWhispererRequest(anthropicReq, nil, WithConversationID("conv-golden"))
+	require.NoError(t, err)
+	cwReq.ConversationState.AgentContinuationId = "agent-golden"
+
+	body, err := MarshalCodeWhispererRequest(cwReq)
+	require.NoError(t, err)
+	return body
+}
+
+// TestCodeWhispererRequest_Golden 上游对请求格式敏感（字段顺序、空数组、null），
+// golden 文件锁定的是现有实现的序列化结果，不是抓包得到的上游参考请求；
+// 任何改变序列化结果的结构体修改都会使本测试失败，需要在评审中确认后用 -update 更新 golden 文件
+func TestCodeWhispererRequest_Golden(t *testing.T) {
+	configtest.WithDeterministic(t)
+	configtest.Override(t, func(s *config.Settings) { s.StealthMode = true })
+
+	body := buildWireFormatRequest(t, wireFormatRequest)
+	golden := filepath.Join("testdata", "codewhisperer_request.golden.json")
+	if *updateGolden {
+		require.NoError(t, os.WriteFile(golden, append(body, '\n'), 0644))
+	}
+
+	want, err := os.ReadFile(golden)
+	require.NoError(t, err)
+	assert.Equal(t, strings.TrimSuffix(string(want), "\n"), string(body))
+}
+
+// TestCodeWhispererRequest_EmptyFields 没有图片时当前消息发送 "images":[]、历史消息省略 images，history 始终是数组，不发送 null
+func TestCodeWhispererRequest_EmptyFields(t *testing.T) {
+	configtest.WithDeterministic(t)
+
+	cases := map[string]string{
+		"单条消息": `{"model": "claude-sonnet-4", "messages": [{"role": "user", "content": "hello"}]}`,
+		"多轮对话": `{"model": "claude-sonnet-4", "messages": [
+			{"role": "user", "content": "hi"},
+			{"role": "assistant", "content": "hello"},
+			{"role": "user", "content": "bye"}
+		]}`,
+	}
+	for name, raw := range cases {
+		t.Run(name, func(t *testing.T) {
+			body := string(buildWireFormatRequest(t, raw))
+			assert.Equal(t, 1, strings.Count(body, `"images"`), "只有当前消息带 images 字段")
+			assert.Contains(t, body, `"images":[]`)
+			assert.NotContains(t, body, `"history":null`)
+			assert.Contains(t, body, `"history":[`)
+		})
+	}
+
+	single := string(buildWireFormatRequest(t, cases["单条消息"]))
+	assert.Contains(t, single, `"history":[]`)
+}
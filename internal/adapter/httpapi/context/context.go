@@ -1,12 +1,63 @@
 package context
 
-import "github.com/gin-gonic/gin"
+import (
+	stdcontext "context"
+
+	"kiro2api/converter"
+	"kiro2api/internal/audit"
+
+	"github.com/gin-gonic/gin"
+)
 
 const (
 	requestIDKey = "request_id"
 	messageIDKey = "message_id"
+
+	conversationIDKey     = "conversation_id"
+	inputTokensKey        = "input_tokens"
+	tokenUsageKey         = "token_usage"
+	historyAdjustmentsKey = "history_adjustments"
+	requestWarningsKey    = "request_warnings"
+	warningsKey           = "warnings"
+	servedModelKey        = "served_model"
+
+	headerOverridesKey = "header_overrides"
+	appliedHeadersKey  = "applied_headers"
+
+	sseViolationsKey = "sse_violations"
+	responseBytesKey = "response_bytes"
+	streamEndedKey   = "stream_ended"
+
+	upstreamHeadersKey = "upstream_headers"
+	upstreamContextKey = "upstream_context"
+
+	clientKeyKey = "client_key"
 )
 
+// HeaderOverrides 管理员通过请求头指定的单次请求覆盖，空字段表示不覆盖
+type HeaderOverrides struct {
+	Strategy  string
+	AgentMode string
+	NoInject  bool   // 跳过服务端系统提示注入
+	Profile   string // 使用所选账号中该名称的 CodeWhisperer profile
+}
+
+// ClientKey 通过认证的客户端密钥（不含密钥本身），用于按密钥限流、选择token子集和日志
+type ClientKey struct {
+	Name   string
+	Tier   string
+	Tokens []string // 允许使用的 TokenID 子集，为空表示整个token池
+	// Fingerprint 密钥的 SHA-256（十六进制），CONVERSATION_NAMESPACE 启用时用于隔离不同密钥的自定义会话ID
+	Fingerprint string
+}
+
+// AppliedHeaders 实际发往上游的请求头策略和 agent mode
+type AppliedHeaders struct {
+	Strategy  string
+	AgentMode string
+	ABTest    bool // 策略由请求头A/B测试按客户端IP分配
+}
+
 func SetRequestID(c *gin.Context, id string) {
 	c.Set(requestIDKey, id)
 	c.Writer.Header().Set("X-Request-ID", id)
@@ -33,3 +84,218 @@ func GetMessageID(c *gin.Context) string {
 	}
 	return ""
 }
+
+// SetClientKey 记录本次请求通过认证的客户端密钥
+func SetClientKey(c *gin.Context, key ClientKey) {
+	c.Set(clientKeyKey, key)
+}
+
+func GetClientKey(c *gin.Context) (ClientKey, bool) {
+	if v, ok := c.Get(clientKeyKey); ok {
+		if key, ok := v.(ClientKey); ok {
+			return key, true
+		}
+	}
+	return ClientKey{}, false
+}
+
+// SetConversationID 记录本次请求发往上游的会话ID，供审计等后续处理使用
+func SetConversationID(c *gin.Context, id string) {
+	c.Set(conversationIDKey, id)
+}
+
+func GetConversationID(c *gin.Context) string {
+	if v, ok := c.Get(conversationIDKey); ok {
+		if id, ok := v.(string); ok {
+			return id
+		}
+	}
+	return ""
+}
+
+// SetHistoryAdjustments 记录转换时对历史消息的调整（X-Kiro-History-Adjustments 的紧凑JSON），写入后续日志
+func SetHistoryAdjustments(c *gin.Context, value string) {
+	c.Set(historyAdjustmentsKey, value)
+}
+
+func GetHistoryAdjustments(c *gin.Context) string {
+	if v, ok := c.Get(historyAdjustmentsKey); ok {
+		if value, ok := v.(string); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+// SetRequestWarnings 记录进入上游转换前（如 OpenAI 格式转换）产生的警告，构建上游请求时与构建器的警告合并
+func SetRequestWarnings(c *gin.Context, warnings converter.Warnings) {
+	c.Set(requestWarningsKey, warnings)
+}
+
+func GetRequestWarnings(c *gin.Context) converter.Warnings {
+	if v, ok := c.Get(requestWarningsKey); ok {
+		if warnings, ok := v.(converter.Warnings); ok {
+			return warnings
+		}
+	}
+	return nil
+}
+
+// SetWarnings 记录本次请求的全部转换警告（紧凑JSON），写入请求完成日志
+func SetWarnings(c *gin.Context, value string) {
+	c.Set(warningsKey, value)
+}
+
+func GetWarnings(c *gin.Context) string {
+	if v, ok := c.Get(warningsKey); ok {
+		if value, ok := v.(string); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+// SetServedModel 记录发生模型回退时实际处理请求的模型
+func SetServedModel(c *gin.Context, model string) {
+	c.Set(servedModelKey, model)
+}
+
+func GetServedModel(c *gin.Context) string {
+	if v, ok := c.Get(servedModelKey); ok {
+		if model, ok := v.(string); ok {
+			return model
+		}
+	}
+	return ""
+}
+
+// SetInputTokens 记录本次请求的输入token估算值，message_start 与最终 usage 共用该值
+func SetInputTokens(c *gin.Context, tokens int) {
+	c.Set(inputTokensKey, tokens)
+}
+
+func GetInputTokens(c *gin.Context) (int, bool) {
+	if v, ok := c.Get(inputTokensKey); ok {
+		if tokens, ok := v.(int); ok {
+			return tokens, true
+		}
+	}
+	return 0, false
+}
+
+// TokenUsage 请求完成后计入统计的实际token用量
+type TokenUsage struct {
+	InputTokens  int
+	OutputTokens int
+}
+
+// SetTokenUsage 记录请求完成后的实际token用量，供客户端配额结算
+func SetTokenUsage(c *gin.Context, usage TokenUsage) {
+	c.Set(tokenUsageKey, usage)
+}
+
+// GetTokenUsage 返回请求的实际token用量，请求失败（未记录）时返回false
+func GetTokenUsage(c *gin.Context) (TokenUsage, bool) {
+	if v, ok := c.Get(tokenUsageKey); ok {
+		if usage, ok := v.(TokenUsage); ok {
+			return usage, true
+		}
+	}
+	return TokenUsage{}, false
+}
+
+// SetHeaderOverrides 记录已通过管理员校验的请求头覆盖
+func SetHeaderOverrides(c *gin.Context, overrides HeaderOverrides) {
+	c.Set(headerOverridesKey, overrides)
+}
+
+func GetHeaderOverrides(c *gin.Context) HeaderOverrides {
+	if v, ok := c.Get(headerOverridesKey); ok {
+		if overrides, ok := v.(HeaderOverrides); ok {
+			return overrides
+		}
+	}
+	return HeaderOverrides{}
+}
+
+// SetAppliedHeaders 记录本次请求最后一次发往上游时使用的请求头策略，写入请求完成日志
+func SetAppliedHeaders(c *gin.Context, applied AppliedHeaders) {
+	c.Set(appliedHeadersKey, applied)
+}
+
+func GetAppliedHeaders(c *gin.Context) (AppliedHeaders, bool) {
+	if v, ok := c.Get(appliedHeadersKey); ok {
+		if applied, ok := v.(AppliedHeaders); ok {
+			return applied, true
+		}
+	}
+	return AppliedHeaders{}, false
+}
+
+// SetSSEViolations 记录流式响应中的SSE事件序列违规次数，写入请求完成日志
+func SetSSEViolations(c *gin.Context, count int) {
+	c.Set(sseViolationsKey, count)
+}
+
+func GetSSEViolations(c *gin.Context) (int, bool) {
+	if v, ok := c.Get(sseViolationsKey); ok {
+		if count, ok := v.(int); ok {
+			return count, true
+		}
+	}
+	return 0, false
+}
+
+// MarkStreamEnded 记录流式响应已发送结束事件（Anthropic 的 message_stop 或 OpenAI 的 [DONE]）
+// panic恢复中间件据此判断是否还需要补发结束事件
+func MarkStreamEnded(c *gin.Context) {
+	c.Set(streamEndedKey, true)
+}
+
+func IsStreamEnded(c *gin.Context) bool {
+	return c.GetBool(streamEndedKey)
+}
+
+// SetResponseBytes 记录流式响应发送给客户端的总字节数，写入请求完成日志
+func SetResponseBytes(c *gin.Context, bytes int) {
+	c.Set(responseBytesKey, bytes)
+}
+
+func GetResponseBytes(c *gin.Context) (int, bool) {
+	if v, ok := c.Get(responseBytesKey); ok {
+		if bytes, ok := v.(int); ok {
+			return bytes, true
+		}
+	}
+	return 0, false
+}
+
+// AddUpstreamHeaders 追加一次上游请求的请求头记录（LOG_UPSTREAM_HEADERS 启用时），请求结束后由中间件统一输出
+func AddUpstreamHeaders(c *gin.Context, exchange audit.HeaderExchange) {
+	exchanges := GetUpstreamHeaders(c)
+	c.Set(upstreamHeadersKey, append(exchanges, exchange))
+}
+
+func GetUpstreamHeaders(c *gin.Context) []audit.HeaderExchange {
+	if v, ok := c.Get(upstreamHeadersKey); ok {
+		if exchanges, ok := v.([]audit.HeaderExchange); ok {
+			return exchanges
+		}
+	}
+	return nil
+}
+
+// SetUpstreamContext 指定上游请求使用的context，替代默认绑定的客户端请求context
+func SetUpstreamContext(c *gin.Context, ctx stdcontext.Context) {
+	c.Set(upstreamContextKey, ctx)
+}
+
+// GetUpstreamContext 上游请求使用的context，未指定时为客户端请求的context（客户端断开即取消）
+func GetUpstreamContext(c *gin.Context) stdcontext.Context {
+	if v, ok := c.Get(upstreamContextKey); ok {
+		if ctx, ok := v.(stdcontext.Context); ok {
+			return ctx
+		}
+	}
+	return c.Request.Context()
+}
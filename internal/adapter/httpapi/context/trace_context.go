@@ -0,0 +1,112 @@
+package context
+
+import (
+	"strings"
+
+	"kiro2api/config"
+	"kiro2api/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+const traceContextKey = "trace_context"
+
+const (
+	traceVersion      = "00"
+	traceParentLength = 55 // 00-<32位trace-id>-<16位parent-id>-<2位flags>
+	zeroTraceID       = "00000000000000000000000000000000"
+	zeroSpanID        = "0000000000000000"
+)
+
+// TraceContext 本次请求的 W3C Trace Context
+type TraceContext struct {
+	TraceID      string // 128位追踪ID（32位小写十六进制）
+	SpanID       string // 本服务处理该请求的span
+	ParentSpanID string // 客户端 traceparent 中的 parent-id，客户端未携带时为空
+	Flags        string // trace-flags，沿用客户端的值，新建时为 01（已采样）
+	TraceState   string // 原样转发的 tracestate
+}
+
+// ParseTraceParent 解析 traceparent 请求头，格式不合法时返回 false
+// 未知的更高版本按 00 版本的字段解析，忽略多出的部分
+func ParseTraceParent(value string) (TraceContext, bool) {
+	value = strings.TrimSpace(value)
+	if len(value) < traceParentLength || (len(value) > traceParentLength && value[traceParentLength] != '-') {
+		return TraceContext{}, false
+	}
+	parts := strings.Split(value[:traceParentLength], "-")
+	if len(parts) != 4 {
+		return TraceContext{}, false
+	}
+	version, traceID, parentID, flags := parts[0], parts[1], parts[2], parts[3]
+	if !isLowerHex(version, 2) || version == "ff" || (version == traceVersion && len(value) != traceParentLength) {
+		return TraceContext{}, false
+	}
+	if !isLowerHex(traceID, 32) || traceID == zeroTraceID || !isLowerHex(parentID, 16) || parentID == zeroSpanID || !isLowerHex(flags, 2) {
+		return TraceContext{}, false
+	}
+	return TraceContext{TraceID: traceID, ParentSpanID: parentID, Flags: flags}, true
+}
+
+// NewTraceContext 客户端未携带合法 traceparent 时开始新的追踪
+func NewTraceContext() TraceContext {
+	return TraceContext{TraceID: newTraceID(), SpanID: NewSpanID(), Flags: "01"}
+}
+
+// FromClient 追踪上下文是否来自客户端携带的 traceparent（而不是本服务新建的追踪）
+func (tc TraceContext) FromClient() bool {
+	return tc.ParentSpanID != ""
+}
+
+// ChildTraceParent 为一次上游调用生成子span，返回发往上游的 traceparent
+func (tc TraceContext) ChildTraceParent() string {
+	return tc.traceParent(NewSpanID())
+}
+
+func (tc TraceContext) traceParent(spanID string) string {
+	return traceVersion + "-" + tc.TraceID + "-" + spanID + "-" + tc.Flags
+}
+
+// NewSpanID 生成64位的span ID（16位小写十六进制，不全为0）
+func NewSpanID() string {
+	for {
+		if id := utils.RandomHex(16); id != zeroSpanID {
+			return id
+		}
+	}
+}
+
+func newTraceID() string {
+	for {
+		if id := utils.RandomHex(32); id != zeroTraceID {
+			return id
+		}
+	}
+}
+
+func isLowerHex(s string, length int) bool {
+	if len(s) != length {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if (s[i] < '0' || s[i] > '9') && (s[i] < 'a' || s[i] > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// SetTraceContext 记录本次请求的追踪上下文，并通过 X-Trace-ID 响应头返回追踪ID
+func SetTraceContext(c *gin.Context, tc TraceContext) {
+	c.Set(traceContextKey, tc)
+	c.Writer.Header().Set(config.TraceIDHeader, tc.TraceID)
+}
+
+func GetTraceContext(c *gin.Context) (TraceContext, bool) {
+	if v, ok := c.Get(traceContextKey); ok {
+		if tc, ok := v.(TraceContext); ok {
+			return tc, true
+		}
+	}
+	return TraceContext{}, false
+}
@@ -0,0 +1,53 @@
+package context
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		valid bool
+	}{
+		{"合法", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"首尾空白", " 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00 ", true},
+		{"更高版本带扩展字段", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true},
+		{"00版本不允许扩展字段", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false},
+		{"版本ff无效", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"trace-id全为0", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"parent-id全为0", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"大写十六进制", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false},
+		{"长度不足", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-1", false},
+		{"分隔符错误", "00_4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"空", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc, ok := ParseTraceParent(tt.value)
+			assert.Equal(t, tt.valid, ok)
+			if ok {
+				assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", tc.TraceID)
+				assert.Equal(t, "00f067aa0ba902b7", tc.ParentSpanID)
+			}
+		})
+	}
+}
+
+func TestTraceContext_ChildTraceParent(t *testing.T) {
+	tc := NewTraceContext()
+	assert.Len(t, tc.TraceID, 32)
+	assert.Len(t, tc.SpanID, 16)
+	assert.Empty(t, tc.ParentSpanID)
+
+	first, ok := ParseTraceParent(tc.ChildTraceParent())
+	assert.True(t, ok)
+	second, _ := ParseTraceParent(tc.ChildTraceParent())
+	assert.Equal(t, tc.TraceID, first.TraceID)
+	assert.Equal(t, "01", first.Flags)
+	assert.NotEqual(t, first.ParentSpanID, second.ParentSpanID)
+	assert.NotEqual(t, tc.SpanID, first.ParentSpanID)
+}
@@ -34,7 +34,7 @@ func (h *Handler) handleAdminLogin(c *gin.Context) {
 		return
 	}
 
-	if req.Token != expectedToken {
+	if !middleware.MatchAdminToken(req.Token) {
 		logger.Warn("管理员登录失败：Token错误",
 			logger.String("ip", c.ClientIP()))
 		c.JSON(http.StatusUnauthorized, gin.H{
@@ -82,7 +82,7 @@ func (h *Handler) handleAdminStatus(c *gin.Context) {
 		if adminToken == "" {
 			adminToken, _ = c.Cookie("admin_token")
 		}
-		loggedIn = adminToken == expectedToken
+		loggedIn = middleware.MatchAdminToken(adminToken)
 	}
 	
 	c.JSON(http.StatusOK, gin.H{
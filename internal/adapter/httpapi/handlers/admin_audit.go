@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"kiro2api/internal/adapter/httpapi/middleware"
+	"kiro2api/internal/audit"
+	"kiro2api/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// adminAuditResponse GET /admin/audit 的响应
+type adminAuditResponse struct {
+	Entries []audit.AdminAction `json:"entries"`
+}
+
+// recordAdminAction 记录一次管理操作，执行者取自请求携带的管理员Token
+func (h *Handler) recordAdminAction(c *gin.Context, action, tokenID string) {
+	entry := audit.AdminAction{
+		Actor:   middleware.AdminActor(c),
+		Action:  action,
+		TokenID: tokenID,
+	}
+	h.adminLog.Record(entry)
+
+	logger.Info("管理操作",
+		logger.String("actor", entry.Actor),
+		logger.String("action", action),
+		logger.String("token_id", tokenID))
+}
+
+// handleGetAdminAudit 返回最近的管理操作记录（按时间倒序），limit 参数限制条数
+func (h *Handler) handleGetAdminAudit(c *gin.Context) {
+	limit := 0
+	if raw := c.Query("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "无效的limit: " + raw})
+			return
+		}
+		limit = parsed
+	}
+
+	c.JSON(http.StatusOK, adminAuditResponse{Entries: h.adminLog.Entries(limit)})
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"net/http"
+
+	"kiro2api/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// adminConfigResponse GET /admin/config 的响应，只包含不涉及密钥的配置项
+type adminConfigResponse struct {
+	Port                     string `json:"port"`
+	LogLevel                 string `json:"log_level"`
+	StealthMode              bool   `json:"stealth_mode"`
+	HeaderStrategy           string `json:"header_strategy"`
+	HTTP2Mode                string `json:"http2_mode"`
+	TokenCacheTTL            string `json:"token_cache_ttl"`
+	MaxToolDescriptionLength int    `json:"max_tool_description_length"`
+}
+
+// handleGetAdminConfig 返回当前生效的配置快照
+func (h *Handler) handleGetAdminConfig(c *gin.Context) {
+	settings := config.Get()
+	c.JSON(http.StatusOK, adminConfigResponse{
+		Port:                     settings.Port,
+		LogLevel:                 settings.LogLevel,
+		StealthMode:              settings.StealthMode,
+		HeaderStrategy:           settings.HeaderStrategy,
+		HTTP2Mode:                settings.HTTP2Mode,
+		TokenCacheTTL:            settings.TokenCacheTTL.String(),
+		MaxToolDescriptionLength: settings.MaxToolDescriptionLength,
+	})
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"kiro2api/config"
+	"kiro2api/config/configtest"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleGetAdminConfig(t *testing.T) {
+	configtest.Override(t, func(s *config.Settings) {
+		s.Port = "9090"
+		s.LogLevel = "debug"
+		s.StealthMode = true
+		s.HeaderStrategy = config.HeaderStrategyRandom
+		s.HTTP2Mode = config.HTTP2ModeDisable
+		s.TokenCacheTTL = 90 * time.Second
+		s.MaxToolDescriptionLength = 2048
+	})
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/admin/config", (&Handler{}).handleGetAdminConfig)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/config", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{
+		"port": "9090",
+		"log_level": "debug",
+		"stealth_mode": true,
+		"header_strategy": "random",
+		"http2_mode": "disable",
+		"token_cache_ttl": "1m30s",
+		"max_tool_description_length": 2048
+	}`, w.Body.String())
+}
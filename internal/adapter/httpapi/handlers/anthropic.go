@@ -5,8 +5,12 @@ import (
 	"net/http"
 	"strings"
 
+	"kiro2api/converter"
+	srvcontext "kiro2api/internal/adapter/httpapi/context"
+	logutil "kiro2api/internal/adapter/httpapi/logging"
 	"kiro2api/internal/adapter/httpapi/request"
 	"kiro2api/internal/adapter/httpapi/support"
+	"kiro2api/internal/adapter/upstream/shared"
 	"kiro2api/logger"
 	"kiro2api/types"
 	"kiro2api/utils"
@@ -17,11 +21,11 @@ import (
 func (h *Handler) handleAnthropicMessages(c *gin.Context) {
 	reqCtx := &request.Context{
 		GinContext:  c,
-		AuthService: h.authService,
+		AuthService: h.tokens,
 		RequestType: "Anthropic",
 	}
 
-	tokenWithUsage, body, err := reqCtx.GetTokenWithUsageAndBody()
+	body, err := reqCtx.ReadBody()
 	if err != nil {
 		return
 	}
@@ -72,11 +76,16 @@ func (h *Handler) handleAnthropicMessages(c *gin.Context) {
 		return
 	}
 
-	if len(anthropicReq.Messages) == 0 {
-		logger.Error("请求中没有消息")
-		support.RespondError(c, http.StatusBadRequest, "%s", "messages 数组不能为空")
+	if errs := converter.ValidateAnthropicRequest(anthropicReq); len(errs) > 0 {
+		logger.Warn("请求参数校验失败", logutil.AddFields(c,
+			logger.Int("error_count", len(errs)),
+			logger.String("errors", converter.FormatValidationErrors(errs)))...)
+		respondValidationErrors(c, errs)
 		return
 	}
+	anthropicReq = converter.NormalizeEmptyTools(anthropicReq)
+	anthropicReq.MarkClientIndexes()
+	anthropicReq = applyHistoryRepair(c, anthropicReq)
 
 	lastMsg := anthropicReq.Messages[len(anthropicReq.Messages)-1]
 	content, err := utils.GetMessageContent(lastMsg.Content)
@@ -89,7 +98,7 @@ func (h *Handler) handleAnthropicMessages(c *gin.Context) {
 	}
 
 	trimmedContent := strings.TrimSpace(content)
-	if trimmedContent == "" || trimmedContent == "answer for user question" {
+	if trimmedContent == "" || trimmedContent == utils.EmptyMessagePlaceholder {
 		logger.Error("消息内容为空或无效",
 			logger.String("content", content),
 			logger.String("trimmed_content", trimmedContent))
@@ -97,6 +106,47 @@ func (h *Handler) handleAnthropicMessages(c *gin.Context) {
 		return
 	}
 
+	if err := converter.CheckWebSearchTools(anthropicReq.Tools); err != nil {
+		support.RespondErrorWithCode(c, http.StatusBadRequest, "unsupported_tool", "%v", err)
+		return
+	}
+
+	stream, ok := support.NegotiateStream(c, anthropicReq.Stream)
+	if !ok {
+		return
+	}
+	anthropicReq.Stream = stream
+	if converter.HasSystemDocuments(anthropicReq.System) {
+		system, err := converter.InlineSystemDocuments(c.Request.Context(), anthropicReq.System, utils.NewDocumentFetcherFromConfig())
+		if err != nil {
+			logger.Warn("处理系统提示文档失败", logutil.AddFields(c, logger.Err(err))...)
+			support.RespondError(c, http.StatusBadRequest, "处理系统提示文档失败: %v", err)
+			return
+		}
+		anthropicReq.System = system
+	}
+
+	anthropicReq = applyContextGuard(c, anthropicReq)
+	srvcontext.SetInputTokens(c, shared.EstimateRequestInputTokens(c, anthropicReq))
+
+	settle, ok := h.reserveQuota(c)
+	if !ok {
+		return
+	}
+	defer settle()
+
+	release, ok := h.acquireQueueSlot(c)
+	if !ok {
+		return
+	}
+	defer release()
+
+	// 配额与排队通过后再选择token，被拒绝或仍在排队的请求不占用token
+	tokenWithUsage, ok := reqCtx.GetTokenWithUsage()
+	if !ok {
+		return
+	}
+
 	if anthropicReq.Stream {
 		h.gateway.HandleAnthropicStream(c, anthropicReq, tokenWithUsage)
 		return
@@ -104,3 +154,15 @@ func (h *Handler) handleAnthropicMessages(c *gin.Context) {
 
 	h.gateway.HandleAnthropicNonStream(c, anthropicReq, tokenWithUsage.TokenInfo)
 }
+
+// respondValidationErrors 以 Anthropic 错误格式返回400，details 列出每个字段的错误
+func respondValidationErrors(c *gin.Context, errs []converter.ValidationError) {
+	c.JSON(http.StatusBadRequest, gin.H{
+		"type": "error",
+		"error": gin.H{
+			"type":    "invalid_request_error",
+			"message": converter.FormatValidationErrors(errs),
+			"details": errs,
+		},
+	})
+}
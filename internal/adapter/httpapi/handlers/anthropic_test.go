@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"kiro2api/converter"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRespondValidationErrors_AnthropicFormat(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	respondValidationErrors(c, []converter.ValidationError{
+		{Field: "messages.0.role", Message: "必须是 user 或 assistant: \"bot\""},
+		{Field: "messages.0.content.0.type", Message: "不支持的内容块类型: video"},
+	})
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var body struct {
+		Type  string `json:"type"`
+		Error struct {
+			Type    string                      `json:"type"`
+			Message string                      `json:"message"`
+			Details []converter.ValidationError `json:"details"`
+		} `json:"error"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, "error", body.Type)
+	assert.Equal(t, "invalid_request_error", body.Error.Type)
+	assert.Contains(t, body.Error.Message, "messages.0.content.0.type: 不支持的内容块类型: video")
+	require.Len(t, body.Error.Details, 2)
+	assert.Equal(t, "messages.0.role", body.Error.Details[0].Field)
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+
+	"kiro2api/config"
+	srvcontext "kiro2api/internal/adapter/httpapi/context"
+	logutil "kiro2api/internal/adapter/httpapi/logging"
+	"kiro2api/internal/adapter/httpapi/middleware"
+	"kiro2api/internal/adapter/httpapi/support"
+	"kiro2api/internal/batch"
+	"kiro2api/logger"
+	"kiro2api/utils"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/errgroup"
+)
+
+// handleCreateMessageBatch 创建消息批处理，立即返回批处理对象，请求在后台按 BATCH_PARALLELISM 并发执行
+func (h *Handler) handleCreateMessageBatch(c *gin.Context) {
+	body, err := c.GetRawData()
+	if err != nil {
+		support.RespondError(c, http.StatusBadRequest, "读取请求体失败: %v", err)
+		return
+	}
+
+	var req batch.CreateRequest
+	if err := utils.SafeUnmarshal(body, &req); err != nil {
+		support.RespondError(c, http.StatusBadRequest, "解析请求体失败: %v", err)
+		return
+	}
+	if errs := batch.ValidateCreateRequest(req); len(errs) > 0 {
+		respondValidationErrors(c, errs)
+		return
+	}
+
+	customIDs := make([]string, len(req.Requests))
+	for i, item := range req.Requests {
+		customIDs[i] = item.CustomID
+	}
+	created := h.batches.Create(batchOwner(c), customIDs)
+	logger.Info("创建消息批处理", logutil.AddFields(c,
+		logger.String("batch_id", created.ID),
+		logger.Int("request_count", len(req.Requests)),
+		logger.Int("parallelism", config.BatchParallelism()),
+	)...)
+
+	// 批处理中的请求沿用创建者通过认证的客户端密钥，照常计入其配额、限定其token子集
+	key, authenticated := srvcontext.GetClientKey(c)
+	var clientKey *srvcontext.ClientKey
+	if authenticated {
+		clientKey = &key
+	}
+	go h.runMessageBatch(created.ID, req.Requests, c.GetHeader(config.TenantIDHeader), clientKey)
+
+	c.JSON(http.StatusOK, created)
+}
+
+// handleGetMessageBatch 查询批处理状态
+func (h *Handler) handleGetMessageBatch(c *gin.Context) {
+	b, ok := h.batches.Get(c.Param("id"), batchOwner(c))
+	if !ok {
+		respondBatchNotFound(c)
+		return
+	}
+	c.JSON(http.StatusOK, b)
+}
+
+// handleGetMessageBatchResults 以 JSONL 返回已结束批处理的结果，每行一个请求，按提交顺序排列
+func (h *Handler) handleGetMessageBatchResults(c *gin.Context) {
+	results, b, ok := h.batches.Results(c.Param("id"), batchOwner(c))
+	if !ok {
+		if b.ID == "" {
+			respondBatchNotFound(c)
+			return
+		}
+		c.JSON(http.StatusConflict, gin.H{
+			"type": "error",
+			"error": gin.H{
+				"type":    "invalid_request_error",
+				"message": "批处理仍在进行中，结束后才能获取结果",
+			},
+		})
+		return
+	}
+
+	var buf bytes.Buffer
+	for _, result := range results {
+		line, err := utils.SafeMarshal(result)
+		if err != nil {
+			logger.Warn("序列化批处理结果失败", logger.String("custom_id", result.CustomID), logger.Err(err))
+			continue
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	c.Data(http.StatusOK, "application/x-jsonl", buf.Bytes())
+}
+
+// batchOwner 批处理的创建者：请求使用的客户端密钥名称，未启用客户端密钥时为空
+// 查询其他客户端密钥创建的批处理按不存在处理
+func batchOwner(c *gin.Context) string {
+	key, _ := srvcontext.GetClientKey(c)
+	return key.Name
+}
+
+func respondBatchNotFound(c *gin.Context) {
+	c.JSON(http.StatusNotFound, gin.H{
+		"type": "error",
+		"error": gin.H{
+			"type":    "not_found_error",
+			"message": "批处理不存在或结果已过期",
+		},
+	})
+}
+
+// runMessageBatch 并发执行批处理中的请求并记录结果
+// 每个请求完整经过 /v1/messages 的非流式处理流程（校验、转换、上游调用、统计、客户端限流与配额），单个请求失败不影响其他请求
+// clientKey 为创建批处理的客户端密钥，未认证时为nil；每个请求与直接调用共用该密钥的限流令牌桶，超出档位的请求记为 rate_limit_error 错误
+func (h *Handler) runMessageBatch(batchID string, items []batch.RequestItem, tenantID string, clientKey *srvcontext.ClientKey) {
+	engine := gin.New()
+	engine.Use(middleware.RequestIDMiddleware())
+	owner := ""
+	if clientKey != nil {
+		owner = clientKey.Name
+		engine.Use(func(c *gin.Context) {
+			srvcontext.SetClientKey(c, *clientKey)
+			c.Next()
+		})
+	}
+	engine.Use(middleware.ClientRateLimitMiddleware())
+	engine.POST("/v1/messages", h.handleAnthropicMessages)
+
+	var g errgroup.Group
+	g.SetLimit(config.BatchParallelism())
+	for i, item := range items {
+		g.Go(func() error {
+			h.batches.RecordResult(batchID, i, executeBatchItem(engine, batchID, item, tenantID))
+			return nil
+		})
+	}
+	_ = g.Wait()
+
+	if b, ok := h.batches.Get(batchID, owner); ok {
+		logger.Info("消息批处理结束",
+			logger.String("batch_id", batchID),
+			logger.Int("succeeded", b.RequestCounts.Succeeded),
+			logger.Int("errored", b.RequestCounts.Errored))
+	}
+}
+
+// executeBatchItem 以非流式 /v1/messages 请求执行单个批处理请求
+func executeBatchItem(engine *gin.Engine, batchID string, item batch.RequestItem, tenantID string) batch.Result {
+	body, err := utils.SafeMarshal(item.Params)
+	if err != nil {
+		return batch.ResultFromResponse(item.CustomID, http.StatusBadRequest, []byte(err.Error()))
+	}
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, "/v1/messages", bytes.NewReader(body))
+	if err != nil {
+		return batch.ResultFromResponse(item.CustomID, http.StatusInternalServerError, []byte(err.Error()))
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-Request-ID", batchID+"_"+item.CustomID)
+	if tenantID != "" {
+		req.Header.Set(config.TenantIDHeader, tenantID)
+	}
+
+	w := newBufferedResponseWriter()
+	engine.ServeHTTP(w, req)
+	return batch.ResultFromResponse(item.CustomID, w.status, w.body.Bytes())
+}
+
+// bufferedResponseWriter 在内存中保存批处理单个请求的响应
+type bufferedResponseWriter struct {
+	header http.Header
+	body   bytes.Buffer
+	status int
+}
+
+func newBufferedResponseWriter() *bufferedResponseWriter {
+	return &bufferedResponseWriter{header: make(http.Header), status: http.StatusOK}
+}
+
+func (w *bufferedResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferedResponseWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+func (w *bufferedResponseWriter) WriteHeader(status int) {
+	w.status = status
+}
+
+func (w *bufferedResponseWriter) Flush() {}
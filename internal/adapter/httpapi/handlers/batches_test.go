@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"kiro2api/config"
+	srvcontext "kiro2api/internal/adapter/httpapi/context"
+	"kiro2api/internal/batch"
+	"kiro2api/internal/quota"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newBatchRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	t.Setenv("MOCK_UPSTREAM", "true")
+	t.Setenv("MOCK_RESPONSE_TEMPLATE", "批处理响应：{{.Prompt}}")
+	t.Setenv("BATCH_PARALLELISM", "2")
+	gin.SetMode(gin.TestMode)
+
+	h := New(Options{})
+	h.batches = batch.NewStore(time.Hour, nil)
+	router := gin.New()
+	router.POST("/v1/messages/batches", h.handleCreateMessageBatch)
+	router.GET("/v1/messages/batches/:id", h.handleGetMessageBatch)
+	router.GET("/v1/messages/batches/:id/results", h.handleGetMessageBatchResults)
+	return router
+}
+
+func getPath(router *gin.Engine, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w
+}
+
+// TestMessageBatch_PartialFailure 部分请求失败时其余请求照常完成，失败请求以 Anthropic 错误格式记录
+func TestMessageBatch_PartialFailure(t *testing.T) {
+	router := newBatchRouter(t)
+
+	w := postJSON(router, "/v1/messages/batches", `{"requests": [
+		{"custom_id": "ok-1", "params": {"model": "claude-sonnet-4", "max_tokens": 100, "messages": [{"role": "user", "content": "第一个"}]}},
+		{"custom_id": "bad-role", "params": {"model": "claude-sonnet-4", "max_tokens": 100, "messages": [{"role": "system", "content": "hi"}]}},
+		{"custom_id": "ok-2", "params": {"model": "claude-sonnet-4", "max_tokens": 100, "messages": [{"role": "user", "content": "第二个"}]}},
+		{"custom_id": "empty", "params": {"model": "claude-sonnet-4", "max_tokens": 100, "messages": [{"role": "user", "content": "   "}]}}
+	]}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var created batch.MessageBatch
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Equal(t, "message_batch", created.Type)
+	assert.Equal(t, 4, created.RequestCounts.Processing+created.RequestCounts.Succeeded+created.RequestCounts.Errored)
+
+	var status batch.MessageBatch
+	require.Eventually(t, func() bool {
+		w := getPath(router, "/v1/messages/batches/"+created.ID)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+		return status.ProcessingStatus == batch.StatusEnded
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, batch.RequestCounts{Succeeded: 2, Errored: 2}, status.RequestCounts)
+
+	w = getPath(router, "/v1/messages/batches/"+created.ID+"/results")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	results := make(map[string]batch.Result)
+	var order []string
+	scanner := bufio.NewScanner(strings.NewReader(w.Body.String()))
+	for scanner.Scan() {
+		var r batch.Result
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &r))
+		results[r.CustomID] = r
+		order = append(order, r.CustomID)
+	}
+	assert.Equal(t, []string{"ok-1", "bad-role", "ok-2", "empty"}, order)
+
+	ok := results["ok-2"].Result
+	assert.Equal(t, batch.ResultSucceeded, ok.Type)
+	content := ok.Message["content"].([]any)[0].(map[string]any)
+	assert.Equal(t, "批处理响应：第二个", content["text"])
+
+	for _, id := range []string{"bad-role", "empty"} {
+		failed := results[id].Result
+		assert.Equal(t, batch.ResultErrored, failed.Type, id)
+		assert.Equal(t, "error", failed.Error["type"], id)
+		assert.Equal(t, "invalid_request_error", failed.Error["error"].(map[string]any)["type"], id)
+	}
+}
+
+// TestMessageBatch_ChargesClientQuota 批处理中的请求计入创建者客户端密钥的配额
+func TestMessageBatch_ChargesClientQuota(t *testing.T) {
+	t.Setenv("MOCK_UPSTREAM", "true")
+	t.Setenv("MOCK_RESPONSE_TEMPLATE", "批处理响应：{{.Prompt}}")
+	t.Setenv("BATCH_PARALLELISM", "1")
+	gin.SetMode(gin.TestMode)
+
+	h := New(Options{})
+	h.batches = batch.NewStore(time.Hour, nil)
+	h.quotas = quota.NewEngine(map[string]config.ClientQuota{
+		"marketing": {Daily: config.QuotaBudget{Requests: 1}},
+	}, nil)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		srvcontext.SetClientKey(c, srvcontext.ClientKey{Name: "marketing"})
+	})
+	router.POST("/v1/messages/batches", h.handleCreateMessageBatch)
+	router.GET("/v1/messages/batches/:id", h.handleGetMessageBatch)
+
+	w := postJSON(router, "/v1/messages/batches", `{"requests": [
+		{"custom_id": "first", "params": {"model": "claude-sonnet-4", "max_tokens": 100, "messages": [{"role": "user", "content": "第一个"}]}},
+		{"custom_id": "second", "params": {"model": "claude-sonnet-4", "max_tokens": 100, "messages": [{"role": "user", "content": "第二个"}]}}
+	]}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var created batch.MessageBatch
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+
+	var status batch.MessageBatch
+	require.Eventually(t, func() bool {
+		w := getPath(router, "/v1/messages/batches/"+created.ID)
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+		return status.ProcessingStatus == batch.StatusEnded
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, batch.RequestCounts{Succeeded: 1, Errored: 1}, status.RequestCounts, "超出配额的请求失败")
+
+	used := h.quotas.Status("marketing").Daily.Used
+	assert.Equal(t, int64(1), used.Requests)
+	assert.Positive(t, used.OutputTokens)
+}
+
+func TestMessageBatch_InvalidBatchRejected(t *testing.T) {
+	router := newBatchRouter(t)
+
+	w := postJSON(router, "/v1/messages/batches", `{"requests": [
+		{"custom_id": "dup", "params": {"model": "claude-sonnet-4"}},
+		{"custom_id": "dup", "params": {"model": "claude-sonnet-4", "stream": true}}
+	]}`)
+
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "requests.1.custom_id")
+	assert.Contains(t, w.Body.String(), "requests.1.params.stream")
+}
+
+func TestMessageBatch_NotFound(t *testing.T) {
+	router := newBatchRouter(t)
+
+	w := getPath(router, "/v1/messages/batches/msgbatch_missing")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), "not_found_error")
+
+	w = getPath(router, "/v1/messages/batches/msgbatch_missing/results")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+// newKeyedBatchRouter 跳过认证，按请求头 X-Test-Key 写入客户端密钥
+func newKeyedBatchRouter(t *testing.T, keys map[string]srvcontext.ClientKey) (*gin.Engine, *Handler) {
+	t.Helper()
+	t.Setenv("MOCK_UPSTREAM", "true")
+	t.Setenv("MOCK_RESPONSE_TEMPLATE", "批处理响应：{{.Prompt}}")
+	t.Setenv("BATCH_PARALLELISM", "1")
+	gin.SetMode(gin.TestMode)
+
+	h := New(Options{})
+	h.batches = batch.NewStore(time.Hour, nil)
+	h.quotas = quota.NewEngine(nil, nil)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		if key, ok := keys[c.GetHeader("X-Test-Key")]; ok {
+			srvcontext.SetClientKey(c, key)
+		}
+	})
+	router.POST("/v1/messages/batches", h.handleCreateMessageBatch)
+	router.GET("/v1/messages/batches/:id", h.handleGetMessageBatch)
+	router.GET("/v1/messages/batches/:id/results", h.handleGetMessageBatchResults)
+	return router, h
+}
+
+func serveBatchAs(router *gin.Engine, method, path, keyName, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Test-Key", keyName)
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// waitBatchEnded 以 keyName 轮询批处理直到结束
+func waitBatchEnded(t *testing.T, router *gin.Engine, id, keyName string) batch.MessageBatch {
+	t.Helper()
+	var status batch.MessageBatch
+	require.Eventually(t, func() bool {
+		w := serveBatchAs(router, http.MethodGet, "/v1/messages/batches/"+id, keyName, "")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+		return status.ProcessingStatus == batch.StatusEnded
+	}, 5*time.Second, 10*time.Millisecond)
+	return status
+}
+
+// TestMessageBatch_ScopedToCreator 其他客户端密钥即使知道批处理ID也无法查询状态和结果
+func TestMessageBatch_ScopedToCreator(t *testing.T) {
+	router, _ := newKeyedBatchRouter(t, map[string]srvcontext.ClientKey{
+		"owner":    {Name: "batch-owner"},
+		"intruder": {Name: "batch-intruder"},
+	})
+
+	w := serveBatchAs(router, http.MethodPost, "/v1/messages/batches", "owner", `{"requests": [
+		{"custom_id": "only", "params": {"model": "claude-sonnet-4", "max_tokens": 100, "messages": [{"role": "user", "content": "机密"}]}}
+	]}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var created batch.MessageBatch
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	waitBatchEnded(t, router, created.ID, "owner")
+
+	assert.Equal(t, http.StatusOK, serveBatchAs(router, http.MethodGet, "/v1/messages/batches/"+created.ID+"/results", "owner", "").Code)
+	for _, keyName := range []string{"intruder", "unauthenticated"} {
+		for _, path := range []string{"/v1/messages/batches/" + created.ID, "/v1/messages/batches/" + created.ID + "/results"} {
+			w := serveBatchAs(router, http.MethodGet, path, keyName, "")
+			assert.Equal(t, http.StatusNotFound, w.Code, keyName+" "+path)
+			assert.NotContains(t, w.Body.String(), "机密")
+		}
+	}
+}
+
+// TestMessageBatch_ChargesClientRateLimit 批处理中的每个请求计入创建者的限流档位，超出的请求记为 rate_limit_error
+func TestMessageBatch_ChargesClientRateLimit(t *testing.T) {
+	t.Setenv("CLIENT_RATE_LIMIT_TIERS", `{"batch-free":2}`)
+	router, _ := newKeyedBatchRouter(t, map[string]srvcontext.ClientKey{
+		"free": {Name: "batch-rate-limited", Tier: "batch-free"},
+	})
+
+	w := serveBatchAs(router, http.MethodPost, "/v1/messages/batches", "free", `{"requests": [
+		{"custom_id": "1", "params": {"model": "claude-sonnet-4", "max_tokens": 100, "messages": [{"role": "user", "content": "一"}]}},
+		{"custom_id": "2", "params": {"model": "claude-sonnet-4", "max_tokens": 100, "messages": [{"role": "user", "content": "二"}]}},
+		{"custom_id": "3", "params": {"model": "claude-sonnet-4", "max_tokens": 100, "messages": [{"role": "user", "content": "三"}]}}
+	]}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var created batch.MessageBatch
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+
+	status := waitBatchEnded(t, router, created.ID, "free")
+	assert.Equal(t, batch.RequestCounts{Succeeded: 2, Errored: 1}, status.RequestCounts, "每分钟2次的档位只放行2个请求")
+
+	w = serveBatchAs(router, http.MethodGet, "/v1/messages/batches/"+created.ID+"/results", "free", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "rate_limit_error")
+}
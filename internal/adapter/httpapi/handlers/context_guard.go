@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"kiro2api/config"
+	"kiro2api/converter"
+	logutil "kiro2api/internal/adapter/httpapi/logging"
+	"kiro2api/logger"
+	"kiro2api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// contextReducedHeader 上下文被裁剪时返回的响应头
+const contextReducedHeader = "X-Kiro-Context-Reduced"
+
+// applyContextGuard 启用上下文保护（CONTEXT_GUARD）时，裁剪超出token预算的历史消息
+func applyContextGuard(c *gin.Context, anthropicReq types.AnthropicRequest) types.AnthropicRequest {
+	if !config.IsContextGuardEnabled() {
+		return anthropicReq
+	}
+
+	maxTokens := config.ContextGuardMaxTokens()
+	var compressor *converter.HistoryCompressor
+	if config.HistoryCompressionMode() == config.HistoryCompressionSummarise {
+		compressor = converter.NewHistoryCompressor(config.HistorySummaryPairs())
+	}
+	reduced, reduction := converter.GuardContextWithCompressor(anthropicReq, maxTokens, config.ContextGuardBlockTokens(), compressor)
+	if !reduction.Reduced() {
+		return anthropicReq
+	}
+
+	c.Header(contextReducedHeader, reduction.HeaderValue())
+	logger.Warn("请求超出上下文预算，已裁剪历史消息",
+		logutil.AddFields(c,
+			logger.Int("max_tokens", maxTokens),
+			logger.Int("original_tokens", reduction.OriginalTokens),
+			logger.Int("final_tokens", reduction.FinalTokens),
+			logger.Int("dropped_pairs", reduction.DroppedPairs),
+			logger.Int("dropped_messages", reduction.DroppedMessages),
+			logger.Int("truncated_blocks", reduction.TruncatedBlocks),
+			logger.Int("summarised_messages", reduction.SummarisedMessages),
+		)...)
+
+	return reduced
+}
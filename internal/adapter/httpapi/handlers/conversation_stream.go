@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"kiro2api/config"
+	"kiro2api/internal/adapter/httpapi/middleware"
+	"kiro2api/logger"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
+)
+
+// pingCodec 发送空的ping控制帧，客户端协议栈会自动回复pong
+var pingCodec = websocket.Codec{
+	Marshal: func(any) ([]byte, byte, error) {
+		return nil, websocket.PingFrame, nil
+	},
+}
+
+// handleConversationStream 通过WebSocket实时推送新的会话消息（已脱敏、截断为预览）
+// 每个连接是广播的一个订阅者；客户端发送的数据帧被忽略，仅用于感知连接关闭
+// 升级前自行校验管理员Token，不依赖全局中间件的路径匹配
+func (h *Handler) handleConversationStream(c *gin.Context) {
+	if !middleware.IsAdminRequest(c) {
+		logger.Warn("会话监控连接认证失败", logger.String("ip", c.ClientIP()))
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error":   "unauthorized",
+			"message": "需要管理员认证",
+		})
+		return
+	}
+
+	server := websocket.Server{
+		Handshake: checkSameOrigin,
+		Handler: func(ws *websocket.Conn) {
+			h.streamTranscripts(ws, config.TranscriptPingInterval)
+		},
+	}
+	server.ServeHTTP(c.Writer, c.Request)
+}
+
+// checkSameOrigin 要求Origin与Host一致，防止跨站页面借用管理端连接；
+// 缺少Origin的连接无法判断来源，一律拒绝（非浏览器客户端需显式携带Origin）
+func checkSameOrigin(cfg *websocket.Config, req *http.Request) error {
+	if req.Header.Get("Origin") == "" {
+		return errors.New("缺少Origin头")
+	}
+	origin, err := websocket.Origin(cfg, req)
+	if err != nil {
+		return err
+	}
+	if origin == nil || origin.Host != req.Host {
+		return fmt.Errorf("origin %s 与 host %s 不一致", req.Header.Get("Origin"), req.Host)
+	}
+	cfg.Origin = origin
+	return nil
+}
+
+func (h *Handler) streamTranscripts(ws *websocket.Conn, pingInterval time.Duration) {
+	events, cancel := h.transcripts.Subscribe()
+	defer cancel()
+
+	logger.Info("会话监控客户端已连接", logger.String("remote_addr", ws.Request().RemoteAddr))
+	defer logger.Info("会话监控客户端已断开", logger.String("remote_addr", ws.Request().RemoteAddr))
+
+	// 读循环：控制帧由协议栈处理，收到关闭帧或连接出错时结束推送
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		var discard []byte
+		for {
+			if err := websocket.Message.Receive(ws, &discard); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := websocket.JSON.Send(ws, event); err != nil {
+				logger.Debug("会话监控推送失败", logger.Err(err))
+				return
+			}
+		case <-ticker.C:
+			if err := pingCodec.Send(ws, nil); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"kiro2api/internal/audit"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+)
+
+const conversationStreamAdminToken = "admin-secret-abcd"
+
+func newConversationStreamServer(t *testing.T, hub *audit.TranscriptHub) (wsURL, origin string) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	t.Setenv("ADMIN_TOKEN", conversationStreamAdminToken)
+
+	router := gin.New()
+	h := &Handler{transcripts: hub}
+	router.GET("/admin/ws/conversations", h.handleConversationStream)
+
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http") + "/admin/ws/conversations", server.URL
+}
+
+// dialConversationStream 以指定Origin与管理员Token建立连接，origin为空时不发送Origin头
+func dialConversationStream(wsURL, origin, adminToken string) (*websocket.Conn, error) {
+	cfg, err := websocket.NewConfig(wsURL, "http://placeholder")
+	if err != nil {
+		return nil, err
+	}
+	if origin == "" {
+		cfg.Origin = nil
+	} else if cfg.Origin, err = url.Parse(origin); err != nil {
+		return nil, err
+	}
+	if adminToken != "" {
+		cfg.Header.Set("X-Admin-Token", adminToken)
+	}
+	return websocket.DialConfig(cfg)
+}
+
+func TestHandleConversationStream_MultipleSubscribers(t *testing.T) {
+	hub := audit.NewTranscriptHub(8)
+	wsURL, origin := newConversationStreamServer(t, hub)
+
+	const clients = 3
+	conns := make([]*websocket.Conn, clients)
+	for i := range conns {
+		conn, err := dialConversationStream(wsURL, origin, conversationStreamAdminToken)
+		require.NoError(t, err)
+		defer conn.Close()
+		conns[i] = conn
+	}
+	require.Eventually(t, func() bool { return hub.SubscriberCount() == clients }, time.Second, 10*time.Millisecond)
+
+	events := []audit.TranscriptEvent{
+		{ConversationID: "conv-1", Role: "user", ContentPreview: "你好", TokenCount: 2},
+		{ConversationID: "conv-1", Role: "assistant", ContentPreview: "你好，有什么可以帮你？", TokenCount: 9},
+	}
+	for _, event := range events {
+		hub.Publish(event)
+	}
+
+	for i, conn := range conns {
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+		for _, expected := range events {
+			var got audit.TranscriptEvent
+			require.NoError(t, websocket.JSON.Receive(conn, &got), "客户端 %d", i)
+			assert.Equal(t, expected, got, "客户端 %d", i)
+		}
+	}
+
+	// 断开一个客户端后订阅被释放，其余客户端继续接收
+	require.NoError(t, conns[0].Close())
+	require.Eventually(t, func() bool { return hub.SubscriberCount() == clients-1 }, time.Second, 10*time.Millisecond)
+
+	next := audit.TranscriptEvent{ConversationID: "conv-2", Role: "user", ContentPreview: "再见", TokenCount: 1}
+	hub.Publish(next)
+	for _, conn := range conns[1:] {
+		var got audit.TranscriptEvent
+		require.NoError(t, websocket.JSON.Receive(conn, &got))
+		assert.Equal(t, next, got)
+	}
+}
+
+func TestHandleConversationStream_RejectsCrossOrigin(t *testing.T) {
+	hub := audit.NewTranscriptHub(8)
+	wsURL, _ := newConversationStreamServer(t, hub)
+
+	_, err := dialConversationStream(wsURL, "http://evil.example.com", conversationStreamAdminToken)
+	require.Error(t, err)
+	assert.Equal(t, 0, hub.SubscriberCount())
+}
+
+func TestHandleConversationStream_RejectsMissingOrigin(t *testing.T) {
+	hub := audit.NewTranscriptHub(8)
+	wsURL, _ := newConversationStreamServer(t, hub)
+
+	_, err := dialConversationStream(wsURL, "", conversationStreamAdminToken)
+	require.Error(t, err)
+	assert.Equal(t, 0, hub.SubscriberCount())
+}
+
+func TestHandleConversationStream_RequiresAdminToken(t *testing.T) {
+	hub := audit.NewTranscriptHub(8)
+	wsURL, origin := newConversationStreamServer(t, hub)
+
+	for _, token := range []string{"", "wrong-token"} {
+		_, err := dialConversationStream(wsURL, origin, token)
+		require.Error(t, err, "token=%q", token)
+	}
+	assert.Equal(t, 0, hub.SubscriberCount())
+}
+
+func TestStreamTranscripts_SendsPing(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	hub := audit.NewTranscriptHub(8)
+	h := &Handler{transcripts: hub}
+
+	router := gin.New()
+	router.GET("/ws", func(c *gin.Context) {
+		websocket.Server{Handler: func(ws *websocket.Conn) {
+			h.streamTranscripts(ws, 20*time.Millisecond)
+		}}.ServeHTTP(c.Writer, c.Request)
+	})
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	conn, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", "", server.URL)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// ping由协议栈自动回复pong，不会作为数据帧交给调用方；连接在多个心跳周期后仍可正常收到事件
+	time.Sleep(100 * time.Millisecond)
+	require.Eventually(t, func() bool { return hub.SubscriberCount() == 1 }, time.Second, 10*time.Millisecond)
+	hub.Publish(audit.TranscriptEvent{ConversationID: "conv-1", Role: "assistant"})
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	var got audit.TranscriptEvent
+	require.NoError(t, websocket.JSON.Receive(conn, &got))
+	assert.Equal(t, "conv-1", got.ConversationID)
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"net/http"
+
+	"kiro2api/config"
+	"kiro2api/internal/audit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// conversationExport 会话导出响应
+type conversationExport struct {
+	ConversationID   string       `json:"conversation_id"`
+	AuditLevel       string       `json:"audit_level"`
+	RetentionSeconds int64        `json:"retention_seconds"`
+	Turns            []audit.Turn `json:"turns"`
+}
+
+// handleGetConversation 导出保留期内指定会话的请求记录，用于复现用户问题
+func (h *Handler) handleGetConversation(c *gin.Context) {
+	level := config.AuditLevel()
+	if level == config.AuditLevelOff || h.conversations == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   "会话审计未启用（AUDIT_LEVEL=off）",
+		})
+		return
+	}
+
+	conversationID := c.Param("conversation_id")
+	turns := h.conversations.Get(conversationID)
+	if len(turns) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   "会话不存在或记录已过期",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, conversationExport{
+		ConversationID:   conversationID,
+		AuditLevel:       level,
+		RetentionSeconds: int64(h.conversations.Retention().Seconds()),
+		Turns:            turns,
+	})
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"kiro2api/internal/audit"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serveConversation(t *testing.T, h *Handler, id string) *httptest.ResponseRecorder {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.GET("/admin/conversations/:conversation_id", h.handleGetConversation)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/conversations/"+id, nil))
+	return w
+}
+
+func TestHandleGetConversation(t *testing.T) {
+	t.Setenv("AUDIT_LEVEL", "")
+	store := audit.NewConversationStore(time.Hour, 1<<20, 10)
+	store.Record("conv-1", audit.Turn{Model: "claude-sonnet-4", MessageCount: 2, InputTokens: 10, OutputTokens: 5, StopReason: "end_turn"})
+	h := &Handler{conversations: store}
+
+	w := serveConversation(t, h, "conv-1")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var resp conversationExport
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "conv-1", resp.ConversationID)
+	assert.Equal(t, "metadata", resp.AuditLevel)
+	assert.Equal(t, int64(3600), resp.RetentionSeconds)
+	require.Len(t, resp.Turns, 1)
+	assert.Equal(t, 10, resp.Turns[0].InputTokens)
+	assert.Equal(t, "end_turn", resp.Turns[0].StopReason)
+
+	assert.Equal(t, http.StatusNotFound, serveConversation(t, h, "missing").Code)
+
+	t.Setenv("AUDIT_LEVEL", "off")
+	assert.Equal(t, http.StatusNotFound, serveConversation(t, h, "conv-1").Code)
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	logutil "kiro2api/internal/adapter/httpapi/logging"
+	"kiro2api/internal/adapter/upstream/shared"
 	"kiro2api/logger"
 	"kiro2api/types"
 	"kiro2api/utils"
@@ -43,10 +44,38 @@ func (h *Handler) handleCountTokens(c *gin.Context) {
 		return
 	}
 
-	estimator := utils.NewTokenEstimator()
+	// 计入服务端注入的系统提示，与实际请求的 usage 保持一致
+	req.System = shared.SystemPromptInjection(c).Apply(req.System)
+	estimator := utils.NewTokenEstimatorForModel(req.Model)
 	tokenCount := estimator.EstimateTokens(&req)
 
 	c.JSON(http.StatusOK, types.CountTokensResponse{
 		InputTokens: tokenCount,
 	})
 }
+
+// handleEstimateBreakdown 返回token估算的分项明细及当前生效的校准参数，用于校准 TOKEN_ESTIMATOR_CALIBRATION 和按模型的字符/token比率
+func (h *Handler) handleEstimateBreakdown(c *gin.Context) {
+	var req types.CountTokensRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Warn("token估算请求解析失败",
+			logutil.AddFields(c,
+				logger.Err(err),
+			)...)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"type":    "invalid_request_error",
+				"message": fmt.Sprintf("Invalid request body: %v", err),
+			},
+		})
+		return
+	}
+
+	estimator := utils.NewTokenEstimatorForModel(req.Model)
+	c.JSON(http.StatusOK, gin.H{
+		"breakdown":         estimator.EstimateBreakdown(&req),
+		"calibration":       utils.ActiveTokenCalibration(),
+		"model_calibration": utils.ModelCalibrationCoefficients(req.Model),
+	})
+}
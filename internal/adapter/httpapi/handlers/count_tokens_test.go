@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"kiro2api/types"
+	"kiro2api/utils"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
@@ -67,3 +68,72 @@ func TestHandleCountTokens_InvalidModel(t *testing.T) {
 
 	assert.Equal(t, http.StatusBadRequest, w.Code)
 }
+
+func TestHandleEstimateBreakdown(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	request := types.CountTokensRequest{
+		Model:  "claude-sonnet-4",
+		System: []types.AnthropicSystemMessage{{Type: "text", Text: "You are a helpful assistant."}},
+		Messages: []types.AnthropicRequestMessage{
+			{Role: "user", Content: "Hello, how are you?"},
+		},
+		Tools: []types.AnthropicTool{
+			{Name: "get_weather", Description: "Get weather", InputSchema: map[string]any{"type": "object"}},
+		},
+	}
+
+	jsonBytes, err := json.Marshal(request)
+	assert.NoError(t, err)
+
+	c.Request = httptest.NewRequest(http.MethodPost, "/admin/estimate", bytes.NewReader(jsonBytes))
+	c.Request.Header.Set("Content-Type", "application/json")
+
+	handler := &Handler{}
+	handler.handleEstimateBreakdown(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response struct {
+		Breakdown utils.TokenEstimateBreakdown `json:"breakdown"`
+	}
+	err = json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.Greater(t, response.Breakdown.System, 0)
+	assert.Greater(t, response.Breakdown.Messages, 0)
+	assert.Len(t, response.Breakdown.Tools, 1)
+	assert.Equal(t, "get_weather", response.Breakdown.Tools[0].Name)
+	assert.Equal(t, utils.NewTokenEstimator().EstimateTokens(&request), response.Breakdown.Total)
+}
+
+func TestHandleCountTokens_PassthroughModel(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	request := types.CountTokensRequest{
+		Model:    "cw:CLAUDE_SONNET_4_5_20250929_V1_0",
+		Messages: []types.AnthropicRequestMessage{{Role: "user", Content: "Hello"}},
+	}
+	jsonBytes, err := json.Marshal(request)
+	assert.NoError(t, err)
+
+	for _, tc := range []struct {
+		passthrough string
+		status      int
+	}{
+		{passthrough: "true", status: http.StatusOK},
+		{passthrough: "", status: http.StatusBadRequest},
+	} {
+		t.Setenv("ALLOW_MODEL_PASSTHROUGH", tc.passthrough)
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages/count_tokens", bytes.NewReader(jsonBytes))
+		c.Request.Header.Set("Content-Type", "application/json")
+
+		(&Handler{}).handleCountTokens(c)
+		assert.Equal(t, tc.status, w.Code, "ALLOW_MODEL_PASSTHROUGH=%q", tc.passthrough)
+	}
+}
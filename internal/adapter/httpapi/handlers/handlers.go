@@ -7,7 +7,14 @@ import (
 	"kiro2api/auth"
 	"kiro2api/config"
 	logutil "kiro2api/internal/adapter/httpapi/logging"
+	"kiro2api/internal/adapter/httpapi/openapi"
+	"kiro2api/internal/adapter/httpapi/request"
 	"kiro2api/internal/adapter/upstream"
+	"kiro2api/internal/adapter/upstream/shared"
+	"kiro2api/internal/audit"
+	"kiro2api/internal/batch"
+	"kiro2api/internal/queue"
+	"kiro2api/internal/quota"
 	"kiro2api/logger"
 	"kiro2api/types"
 
@@ -20,16 +27,47 @@ type Options struct {
 }
 
 type Handler struct {
-	authService  *auth.AuthService
-	tokenManager *auth.TokenManager
-	gateway      *upstream.Gateway
+	authService   *auth.AuthService
+	tokens        request.TokenProvider // 代理请求使用的token来源，模拟上游模式下为占位token
+	tokenManager  *auth.TokenManager
+	gateway       *upstream.Gateway
+	conversations *audit.ConversationStore
+	transcripts   *audit.TranscriptHub
+	adminLog      *audit.AdminLog
+	headerLog     *audit.HeaderLog
+	batches       *batch.Store
+	queue         *queue.PriorityQueue
+	quotas        *quota.Engine
+
+	openAPISpec *openapi.Document // Register 完成时根据路由表生成
+	openAPIErr  error
 }
 
 func New(opts Options) *Handler {
+	// 避免将nil指针包装为非nil接口
+	var tokens interface {
+		request.TokenProvider
+		shared.RetryTokenSource
+	}
+	if opts.AuthService != nil {
+		tokens = opts.AuthService
+	}
+	if config.IsMockUpstreamEnabled() {
+		tokens = shared.MockTokenSource{}
+	}
+
 	return &Handler{
-		authService:  opts.AuthService,
-		tokenManager: opts.TokenManager,
-		gateway:      upstream.NewGateway(),
+		authService:   opts.AuthService,
+		tokens:        tokens,
+		tokenManager:  opts.TokenManager,
+		gateway:       upstream.NewGateway(tokens),
+		conversations: audit.GetConversationStore(),
+		transcripts:   audit.GetTranscriptHub(),
+		adminLog:      audit.GetAdminLog(),
+		headerLog:     audit.GetHeaderLog(),
+		batches:       batch.GetStore(),
+		queue:         queue.GetPriorityQueue(),
+		quotas:        quota.GetEngine(),
 	}
 }
 
@@ -45,6 +83,9 @@ func (h *Handler) Register(r *gin.Engine) {
 		})
 	})
 	
+	// OpenAPI文档（不需要认证）
+	r.GET("/openapi.json", h.handleOpenAPISpec)
+	
 	// 登录页面（不需要认证）
 	r.GET("/login", func(c *gin.Context) {
 		c.File(filepath.Join(staticDir, "login.html"))
@@ -61,10 +102,42 @@ func (h *Handler) Register(r *gin.Engine) {
 	r.POST("/api/tokens/delete", h.handleTokenDelete)
 	r.POST("/api/tokens/refresh-all", h.handleRefreshAllTokens)
 	r.POST("/api/tokens/cleanup", h.handleCleanupTokens)
+	r.POST("/admin/tokens/:index/test", h.handleTokenTest)
+	r.POST("/admin/tokens/restore", h.handleTokenRestore)
+	r.POST("/admin/tokens/rollback", h.handleTokenRollback)
+	r.POST("/admin/tokens/import/format/:format", h.handleTokenImportFormat)
+	r.POST("/admin/tokens/purge", h.handleTokenPurge)
+	r.GET("/admin/audit", h.handleGetAdminAudit)
 	r.GET("/api/stats", h.handleGetStats)
+	r.GET("/admin/stats", h.handleGetAdminStats)
+	r.GET("/admin/stats/latency", h.handleGetLatencyStats)
+	r.GET("/admin/stats/upstreams", h.handleGetUpstreamStats)
+	r.GET("/admin/stats/circuits", h.handleGetCircuitStats)
+	r.GET("/admin/stats/models", h.handleGetModelStats)
+	r.GET("/admin/stats/tenants", h.handleGetTenantStats)
+	r.GET("/admin/stats/sse", h.handleGetSSEStats)
+	r.GET("/admin/stats/large-responses", h.handleGetLargeResponseStats)
+	r.GET("/admin/queue/stats", h.handleGetQueueStats)
+	r.GET("/admin/quotas", h.handleGetQuotas)
+	r.PUT("/admin/quotas", h.handleUpdateQuota)
+	r.GET("/admin/models/capabilities", h.handleGetModelCapabilities)
+	r.GET("/admin/stop-reasons", h.handleGetStopReasons)
+	r.POST("/admin/estimate", h.handleEstimateBreakdown)
+	r.POST("/admin/token-estimator/calibrate", h.handleCalibrateTokenEstimator)
+	r.GET("/admin/conversations/:conversation_id", h.handleGetConversation)
+	r.GET("/admin/ws/conversations", h.handleConversationStream)
+	r.GET("/admin/requests/:id/headers", h.handleGetRequestHeaders)
 
 	r.GET("/api/settings", h.handleGetSettings)
 	r.POST("/api/settings", h.handleSaveSettings)
+	r.GET("/admin/tools/filter", h.handleGetToolFilter)
+	r.PUT("/admin/tools/filter", h.handleUpdateToolFilter)
+	r.GET("/admin/config", h.handleGetAdminConfig)
+	r.GET("/admin/log/level", h.handleGetLogLevel)
+	r.PUT("/admin/log/level", h.handleUpdateLogLevel)
+	r.PUT("/admin/stealth/ab-test", h.handleStartHeaderABTest)
+	r.DELETE("/admin/stealth/ab-test", h.handleStopHeaderABTest)
+	r.GET("/admin/stealth/ab-test/results", h.handleGetHeaderABResults)
 
 	// 管理员认证API
 	r.POST("/api/admin/login", h.handleAdminLogin)
@@ -79,6 +152,9 @@ func (h *Handler) Register(r *gin.Engine) {
 
 	r.POST("/v1/messages", h.handleAnthropicMessages)
 	r.POST("/v1/messages/count_tokens", h.handleCountTokens)
+	r.POST("/v1/messages/batches", h.handleCreateMessageBatch)
+	r.GET("/v1/messages/batches/:id", h.handleGetMessageBatch)
+	r.GET("/v1/messages/batches/:id/results", h.handleGetMessageBatchResults)
 	r.POST("/v1/chat/completions", h.handleOpenAICompletions)
 
 	r.NoRoute(func(c *gin.Context) {
@@ -89,11 +165,17 @@ func (h *Handler) Register(r *gin.Engine) {
 			)...)
 		c.JSON(http.StatusNotFound, gin.H{"error": "404 未找到"})
 	})
+
+	h.buildOpenAPISpec(r)
 }
 
 func (h *Handler) handleModels(c *gin.Context) {
 	models := []types.Model{}
 	for anthropicModel := range config.ModelMap {
+		// 能力探测标记为不可用的模型不再列出
+		if !config.IsModelAvailable(anthropicModel) {
+			continue
+		}
 		model := types.Model{
 			ID:          anthropicModel,
 			Object:      "model",
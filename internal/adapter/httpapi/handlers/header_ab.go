@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"kiro2api/internal/adapter/upstream/shared"
+	"kiro2api/internal/audit"
+	"kiro2api/internal/stats"
+	"kiro2api/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// headerABRequest PUT /admin/stealth/ab-test 的请求体
+type headerABRequest struct {
+	ControlPercent *int   `json:"control_percent"`
+	StrategyA      string `json:"strategy_a"`
+	StrategyB      string `json:"strategy_b"`
+}
+
+// headerABArmResult A/B测试单个分组的配置与上游请求统计
+type headerABArmResult struct {
+	Arm      string `json:"arm"`
+	Strategy string `json:"strategy"`
+	Percent  int    `json:"percent"` // 分到该组的客户端IP比例
+	stats.HeaderStrategyMetrics
+}
+
+// headerABResultsResponse 当前或最近一次A/B测试的结果，从未开始过时 test 为空、arms 为空数组
+type headerABResultsResponse struct {
+	Active bool                 `json:"active"`
+	Test   *shared.HeaderABTest `json:"test,omitempty"`
+	Arms   []headerABArmResult  `json:"arms"`
+}
+
+func headerABResults() headerABResultsResponse {
+	test, ok := shared.CurrentHeaderABTest()
+	if !ok {
+		return headerABResultsResponse{Arms: []headerABArmResult{}}
+	}
+	abStats := stats.GetHeaderABStats()
+	return headerABResultsResponse{
+		Active: test.StoppedAt == nil,
+		Test:   &test,
+		Arms: []headerABArmResult{
+			{Arm: shared.HeaderABArmA, Strategy: test.StrategyA, Percent: test.ControlPercent, HeaderStrategyMetrics: abStats.Snapshot(test.StrategyA)},
+			{Arm: shared.HeaderABArmB, Strategy: test.StrategyB, Percent: 100 - test.ControlPercent, HeaderStrategyMetrics: abStats.Snapshot(test.StrategyB)},
+		},
+	}
+}
+
+// handleStartHeaderABTest 开始请求头策略A/B测试（替换进行中的测试并清空统计），只影响之后的上游请求，不持久化
+func (h *Handler) handleStartHeaderABTest(c *gin.Context) {
+	var req headerABRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误: " + err.Error()})
+		return
+	}
+	if req.ControlPercent == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少 control_percent"})
+		return
+	}
+	test := shared.HeaderABTest{
+		ControlPercent: *req.ControlPercent,
+		StrategyA:      strings.ToLower(strings.TrimSpace(req.StrategyA)),
+		StrategyB:      strings.ToLower(strings.TrimSpace(req.StrategyB)),
+		StartedAt:      time.Now(),
+	}
+	if err := test.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	stats.GetHeaderABStats().Reset()
+	shared.StartHeaderABTest(test)
+
+	logger.Info("请求头策略A/B测试已开始",
+		logger.Int("control_percent", test.ControlPercent),
+		logger.String("strategy_a", test.StrategyA),
+		logger.String("strategy_b", test.StrategyB))
+	h.recordAdminAction(c, audit.AdminActionABTest, "")
+
+	c.JSON(http.StatusOK, headerABResults())
+}
+
+// handleStopHeaderABTest 停止A/B测试，恢复按配置选择请求头策略；统计保留到下一次开始测试
+func (h *Handler) handleStopHeaderABTest(c *gin.Context) {
+	if !shared.StopHeaderABTest(time.Now()) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "没有进行中的A/B测试"})
+		return
+	}
+
+	logger.Info("请求头策略A/B测试已停止")
+	h.recordAdminAction(c, audit.AdminActionABTest, "")
+
+	c.JSON(http.StatusOK, headerABResults())
+}
+
+// handleGetHeaderABResults 返回各策略的上游请求数和错误率
+func (h *Handler) handleGetHeaderABResults(c *gin.Context) {
+	c.JSON(http.StatusOK, headerABResults())
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"kiro2api/internal/audit"
+	"kiro2api/internal/stats"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serveHeaderAB(t *testing.T, h *Handler, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.PUT("/admin/stealth/ab-test", h.handleStartHeaderABTest)
+	router.DELETE("/admin/stealth/ab-test", h.handleStopHeaderABTest)
+	router.GET("/admin/stealth/ab-test/results", h.handleGetHeaderABResults)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return w
+}
+
+func TestHeaderABTestEndpoints(t *testing.T) {
+	h := &Handler{adminLog: audit.NewAdminLog(10)}
+	t.Cleanup(func() {
+		serveHeaderAB(t, h, http.MethodDelete, "/admin/stealth/ab-test", "")
+		stats.GetHeaderABStats().Reset()
+	})
+
+	for _, body := range []string{
+		`{"strategy_a":"kiro","strategy_b":"random"}`,
+		`{"control_percent":120,"strategy_a":"kiro","strategy_b":"random"}`,
+		`{"control_percent":80,"strategy_a":"kiro","strategy_b":"kiro"}`,
+		`{"control_percent":80,"strategy_a":"kiro","strategy_b":"chrome"}`,
+	} {
+		w := serveHeaderAB(t, h, http.MethodPut, "/admin/stealth/ab-test", body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+	assert.Empty(t, h.adminLog.Entries(0))
+
+	// 旧测试的统计在开始新测试时清空
+	stats.GetHeaderABStats().Record("random", http.StatusTooManyRequests)
+
+	w := serveHeaderAB(t, h, http.MethodPut, "/admin/stealth/ab-test", `{"control_percent":80,"strategy_a":"Kiro","strategy_b":"random"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	stats.GetHeaderABStats().Record("kiro", http.StatusOK)
+	stats.GetHeaderABStats().Record("random", http.StatusOK)
+	stats.GetHeaderABStats().Record("random", http.StatusForbidden)
+
+	w = serveHeaderAB(t, h, http.MethodGet, "/admin/stealth/ab-test/results", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var results headerABResultsResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &results))
+	assert.True(t, results.Active)
+	require.NotNil(t, results.Test)
+	assert.Equal(t, 80, results.Test.ControlPercent)
+	require.Len(t, results.Arms, 2)
+	assert.Equal(t, headerABArmResult{Arm: "a", Strategy: "kiro", Percent: 80, HeaderStrategyMetrics: stats.HeaderStrategyMetrics{
+		RequestsTotal: 1, ByStatus: map[int]int64{http.StatusOK: 1},
+	}}, results.Arms[0])
+	assert.Equal(t, headerABArmResult{Arm: "b", Strategy: "random", Percent: 20, HeaderStrategyMetrics: stats.HeaderStrategyMetrics{
+		RequestsTotal: 2, ErrorsTotal: 1, ErrorRate: 0.5, ByStatus: map[int]int64{http.StatusOK: 1, http.StatusForbidden: 1},
+	}}, results.Arms[1])
+
+	// 停止后结果仍可查看
+	w = serveHeaderAB(t, h, http.MethodDelete, "/admin/stealth/ab-test", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	w = serveHeaderAB(t, h, http.MethodGet, "/admin/stealth/ab-test/results", "")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &results))
+	assert.False(t, results.Active)
+	require.NotNil(t, results.Test)
+	assert.NotNil(t, results.Test.StoppedAt)
+	assert.Equal(t, int64(2), results.Arms[1].RequestsTotal)
+
+	w = serveHeaderAB(t, h, http.MethodDelete, "/admin/stealth/ab-test", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	entries := h.adminLog.Entries(0)
+	require.Len(t, entries, 2)
+	assert.Equal(t, audit.AdminActionABTest, entries[0].Action)
+}
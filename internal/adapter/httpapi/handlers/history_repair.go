@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"kiro2api/converter"
+	logutil "kiro2api/internal/adapter/httpapi/logging"
+	"kiro2api/logger"
+	"kiro2api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// historyRepairedHeader 修复了工具调用顺序时返回的响应头
+const historyRepairedHeader = "X-Kiro-History-Repaired"
+
+// applyHistoryRepair 修复 tool_result 早于对应 tool_use 的历史，避免上游报告未知的 toolUseId
+func applyHistoryRepair(c *gin.Context, anthropicReq types.AnthropicRequest) types.AnthropicRequest {
+	messages, repair := converter.RepairToolOrder(anthropicReq.Messages)
+	if !repair.Repaired() {
+		return anthropicReq
+	}
+
+	c.Header(historyRepairedHeader, repair.HeaderValue())
+	logger.Warn("历史消息中 tool_result 早于对应的 tool_use，已修复",
+		logutil.AddFields(c,
+			logger.Any("reordered", repair.Reordered),
+			logger.Any("dropped", repair.Dropped),
+		)...)
+
+	anthropicReq.Messages = messages
+	return anthropicReq
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"kiro2api/config"
+	"kiro2api/internal/audit"
+	"kiro2api/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// adjustableLogLevels 管理接口允许设置的日志级别
+var adjustableLogLevels = []string{"debug", "info", "warn", "error"}
+
+// logLevelState 运行时调整日志级别的状态，限制调整频率
+type logLevelState struct {
+	mutex     sync.Mutex
+	changedAt time.Time
+	now       func() time.Time
+}
+
+var runtimeLogLevel = &logLevelState{now: time.Now}
+
+// logLevelRequest PUT /admin/log/level 的请求体
+type logLevelRequest struct {
+	Level string `json:"level"`
+}
+
+// logLevelResponse 当前日志级别与最近一次通过管理接口调整的时间
+type logLevelResponse struct {
+	Level     string     `json:"level"`
+	ChangedAt *time.Time `json:"changed_at,omitempty"`
+}
+
+func (s *logLevelState) response() logLevelResponse {
+	resp := logLevelResponse{Level: strings.ToLower(logger.GetLevel().String())}
+	if !s.changedAt.IsZero() {
+		changedAt := s.changedAt
+		resp.ChangedAt = &changedAt
+	}
+	return resp
+}
+
+// handleGetLogLevel 返回当前日志级别
+func (h *Handler) handleGetLogLevel(c *gin.Context) {
+	runtimeLogLevel.mutex.Lock()
+	defer runtimeLogLevel.mutex.Unlock()
+	c.JSON(http.StatusOK, runtimeLogLevel.response())
+}
+
+// handleUpdateLogLevel 立即调整日志级别，无需重启；两次调整至少间隔 config.LogLevelChangeInterval
+func (h *Handler) handleUpdateLogLevel(c *gin.Context) {
+	var req logLevelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误: " + err.Error()})
+		return
+	}
+	name := strings.ToLower(strings.TrimSpace(req.Level))
+	if !slices.Contains(adjustableLogLevels, name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的日志级别: " + req.Level + "（可选 " + strings.Join(adjustableLogLevels, "/") + "）"})
+		return
+	}
+	level, _ := logger.ParseLevel(name)
+
+	runtimeLogLevel.mutex.Lock()
+	defer runtimeLogLevel.mutex.Unlock()
+
+	now := runtimeLogLevel.now()
+	if !runtimeLogLevel.changedAt.IsZero() {
+		if wait := config.LogLevelChangeInterval - now.Sub(runtimeLogLevel.changedAt); wait > 0 {
+			seconds := int((wait + time.Second - 1) / time.Second)
+			c.Header("Retry-After", strconv.Itoa(seconds))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "日志级别调整过于频繁，请在 " + strconv.Itoa(seconds) + " 秒后重试"})
+			return
+		}
+	}
+
+	previous := logger.GetLevel()
+	logger.SetLevel(level)
+	config.SetLogLevel(name)
+	runtimeLogLevel.changedAt = now
+
+	// 以WARN记录，除调整为error外都能在日志中看到这次调整
+	logger.Warn("日志级别已调整",
+		logger.String("from", strings.ToLower(previous.String())),
+		logger.String("to", name))
+	h.recordAdminAction(c, audit.AdminActionLogLevel, "")
+
+	c.JSON(http.StatusOK, runtimeLogLevel.response())
+}
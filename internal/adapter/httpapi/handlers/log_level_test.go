@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"kiro2api/config"
+	"kiro2api/config/configtest"
+	"kiro2api/internal/audit"
+	"kiro2api/logger"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serveLogLevel(t *testing.T, h *Handler, method, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.GET("/admin/log/level", h.handleGetLogLevel)
+	router.PUT("/admin/log/level", h.handleUpdateLogLevel)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(method, "/admin/log/level", strings.NewReader(body)))
+	return w
+}
+
+// withLogLevelClock 使用可控时钟并在测试结束后恢复日志级别、配置快照与调整状态
+func withLogLevelClock(t *testing.T) *time.Time {
+	t.Helper()
+	configtest.Override(t, func(*config.Settings) {})
+	previous := logger.GetLevel()
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	runtimeLogLevel = &logLevelState{now: func() time.Time { return now }}
+	t.Cleanup(func() {
+		logger.SetLevel(previous)
+		runtimeLogLevel = &logLevelState{now: time.Now}
+	})
+	return &now
+}
+
+func decodeLogLevel(t *testing.T, w *httptest.ResponseRecorder) logLevelResponse {
+	t.Helper()
+	var resp logLevelResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	return resp
+}
+
+func TestHandleUpdateLogLevel(t *testing.T) {
+	now := withLogLevelClock(t)
+	logger.SetLevel(logger.INFO)
+	h := &Handler{adminLog: audit.NewAdminLog(10)}
+
+	w := serveLogLevel(t, h, http.MethodGet, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, logLevelResponse{Level: "info"}, decodeLogLevel(t, w))
+
+	w = serveLogLevel(t, h, http.MethodPut, `{"level":"DEBUG"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	resp := decodeLogLevel(t, w)
+	assert.Equal(t, "debug", resp.Level)
+	require.NotNil(t, resp.ChangedAt)
+	assert.True(t, now.Equal(*resp.ChangedAt))
+	assert.Equal(t, logger.DEBUG, logger.GetLevel(), "调整立即生效")
+	assert.Equal(t, audit.AdminActionLogLevel, h.adminLog.Entries(1)[0].Action)
+
+	// 10秒内再次调整被拒绝
+	*now = now.Add(4 * time.Second)
+	w = serveLogLevel(t, h, http.MethodPut, `{"level":"error"}`)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "6", w.Header().Get("Retry-After"))
+	assert.Equal(t, logger.DEBUG, logger.GetLevel())
+
+	*now = now.Add(6 * time.Second)
+	w = serveLogLevel(t, h, http.MethodPut, `{"level":"error"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, logger.ERROR, logger.GetLevel())
+
+	w = serveLogLevel(t, h, http.MethodGet, "")
+	resp = decodeLogLevel(t, w)
+	assert.Equal(t, "error", resp.Level)
+	assert.True(t, now.Equal(*resp.ChangedAt))
+}
+
+func TestHandleUpdateLogLevel_InvalidLevel(t *testing.T) {
+	withLogLevelClock(t)
+	logger.SetLevel(logger.INFO)
+	h := &Handler{adminLog: audit.NewAdminLog(10)}
+
+	for _, body := range []string{`{"level":"fatal"}`, `{"level":"verbose"}`, `{}`, `not-json`} {
+		w := serveLogLevel(t, h, http.MethodPut, body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+	assert.Equal(t, logger.INFO, logger.GetLevel())
+
+	// 无效请求不占用调整间隔
+	w := serveLogLevel(t, h, http.MethodPut, `{"level":"warn"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+// TestHandleUpdateLogLevel_SyncsAdminConfig 调整后 /admin/config 显示实际生效的级别
+func TestHandleUpdateLogLevel_SyncsAdminConfig(t *testing.T) {
+	withLogLevelClock(t)
+	logger.SetLevel(logger.INFO)
+	h := &Handler{adminLog: audit.NewAdminLog(10)}
+
+	w := serveLogLevel(t, h, http.MethodPut, `{"level":"debug"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	router := gin.New()
+	router.GET("/admin/config", h.handleGetAdminConfig)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/config", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"log_level":"debug"`)
+}
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newMockUpstreamRouter 启用 MOCK_UPSTREAM 后创建处理器，请求经完整的转换、上游调用、解析与SSE输出流程
+func newMockUpstreamRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	t.Setenv("MOCK_UPSTREAM", "true")
+	gin.SetMode(gin.TestMode)
+
+	h := New(Options{})
+	router := gin.New()
+	router.POST("/v1/messages", h.handleAnthropicMessages)
+	router.POST("/v1/chat/completions", h.handleOpenAICompletions)
+	return router
+}
+
+func postJSON(router *gin.Engine, path, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	return w
+}
+
+type sseEvent struct {
+	Event string
+	Data  map[string]any
+}
+
+func parseSSE(t *testing.T, body string) []sseEvent {
+	t.Helper()
+	var events []sseEvent
+	var current sseEvent
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			current.Event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			payload := strings.TrimPrefix(line, "data: ")
+			if payload == "[DONE]" {
+				continue
+			}
+			require.NoError(t, json.Unmarshal([]byte(payload), &current.Data))
+			events = append(events, current)
+			current = sseEvent{}
+		}
+	}
+	return events
+}
+
+func TestMockUpstream_AnthropicStreamText(t *testing.T) {
+	t.Setenv("MOCK_RESPONSE_TEMPLATE", "你好！我是模拟上游，收到了：{{.Prompt}}")
+	router := newMockUpstreamRouter(t)
+
+	w := postJSON(router, "/v1/messages", `{"model": "claude-sonnet-4", "max_tokens": 100, "stream": true,
+		"messages": [{"role": "user", "content": "测试一下"}]}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	events := parseSSE(t, w.Body.String())
+	var types []string
+	var text strings.Builder
+	for _, e := range events {
+		types = append(types, e.Event)
+		if delta, ok := e.Data["delta"].(map[string]any); ok && delta["type"] == "text_delta" {
+			text.WriteString(delta["text"].(string))
+		}
+	}
+
+	assert.Equal(t, "message_start", types[0])
+	assert.Equal(t, []string{"content_block_stop", "message_delta", "message_stop"}, types[len(types)-3:])
+	assert.Equal(t, "你好！我是模拟上游，收到了：测试一下", text.String())
+
+	delta := events[len(events)-2].Data["delta"].(map[string]any)
+	assert.Equal(t, "end_turn", delta["stop_reason"])
+}
+
+func TestMockUpstream_AnthropicStreamToolUse(t *testing.T) {
+	t.Setenv("MOCK_TOOL_USE", "get_weather")
+	t.Setenv("MOCK_TOOL_INPUT", `{"city":"北京"}`)
+	router := newMockUpstreamRouter(t)
+
+	w := postJSON(router, "/v1/messages", `{"model": "claude-sonnet-4", "max_tokens": 100, "stream": true,
+		"tools": [{"name": "get_weather", "description": "查询天气", "input_schema": {"type": "object", "properties": {"city": {"type": "string"}}, "required": ["city"]}}],
+		"messages": [{"role": "user", "content": "北京天气如何"}]}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var toolName, partialJSON, stopReason string
+	for _, e := range parseSSE(t, w.Body.String()) {
+		if block, ok := e.Data["content_block"].(map[string]any); ok && block["type"] == "tool_use" {
+			toolName = block["name"].(string)
+		}
+		if delta, ok := e.Data["delta"].(map[string]any); ok {
+			if delta["type"] == "input_json_delta" {
+				partialJSON += delta["partial_json"].(string)
+			}
+			if reason, ok := delta["stop_reason"].(string); ok {
+				stopReason = reason
+			}
+		}
+	}
+
+	assert.Equal(t, "get_weather", toolName)
+	assert.JSONEq(t, `{"city":"北京"}`, partialJSON)
+	assert.Equal(t, "tool_use", stopReason)
+}
+
+func TestMockUpstream_AnthropicNonStream(t *testing.T) {
+	t.Setenv("MOCK_RESPONSE_TEMPLATE", "非流式响应")
+	router := newMockUpstreamRouter(t)
+
+	w := postJSON(router, "/v1/messages", `{"model": "claude-sonnet-4", "max_tokens": 100,
+		"messages": [{"role": "user", "content": "hi"}]}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var resp struct {
+		Content []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+		StopReason string `json:"stop_reason"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.NotEmpty(t, resp.Content)
+	assert.Equal(t, "非流式响应", resp.Content[0].Text)
+	assert.Equal(t, "end_turn", resp.StopReason)
+}
+
+func TestMockUpstream_OpenAIStream(t *testing.T) {
+	t.Setenv("MOCK_RESPONSE_TEMPLATE", "OpenAI 兼容流")
+	router := newMockUpstreamRouter(t)
+
+	w := postJSON(router, "/v1/chat/completions", `{"model": "claude-sonnet-4", "stream": true,
+		"messages": [{"role": "user", "content": "hi"}]}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var text strings.Builder
+	for _, e := range parseSSE(t, w.Body.String()) {
+		choices, _ := e.Data["choices"].([]any)
+		for _, choice := range choices {
+			if delta, ok := choice.(map[string]any)["delta"].(map[string]any); ok {
+				if content, ok := delta["content"].(string); ok {
+					text.WriteString(content)
+				}
+			}
+		}
+	}
+	assert.Equal(t, "OpenAI 兼容流", text.String())
+	assert.Contains(t, w.Body.String(), "[DONE]")
+}
+
+func TestMockUpstream_ErrorInjection(t *testing.T) {
+	t.Setenv("MOCK_ERROR_RATE", "1")
+	router := newMockUpstreamRouter(t)
+
+	w := postJSON(router, "/v1/messages", `{"model": "claude-sonnet-4", "max_tokens": 100, "stream": true,
+		"messages": [{"role": "user", "content": "hi"}]}`)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), "模拟上游注入的错误")
+}
+
+func TestMockUpstream_RepairsOutOfOrderToolHistory(t *testing.T) {
+	t.Setenv("MOCK_RESPONSE_TEMPLATE", "已修复")
+	router := newMockUpstreamRouter(t)
+
+	w := postJSON(router, "/v1/messages", `{"model": "claude-sonnet-4", "max_tokens": 100, "stream": false,
+		"tools": [{"name": "read", "description": "read a file", "input_schema": {"type": "object"}}],
+		"messages": [
+			{"role": "user", "content": "读取文件"},
+			{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "toolu_1", "content": "ok"}]},
+			{"role": "assistant", "content": [{"type": "tool_use", "id": "toolu_1", "name": "read", "input": {}}]},
+			{"role": "assistant", "content": "读取完成"},
+			{"role": "user", "content": "继续"}
+		]}`)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "reordered=toolu_1", w.Header().Get("X-Kiro-History-Repaired"))
+}
@@ -4,9 +4,11 @@ import (
 	"net/http"
 
 	"kiro2api/converter"
+	srvcontext "kiro2api/internal/adapter/httpapi/context"
 	logutil "kiro2api/internal/adapter/httpapi/logging"
 	"kiro2api/internal/adapter/httpapi/request"
 	"kiro2api/internal/adapter/httpapi/support"
+	"kiro2api/internal/adapter/upstream/shared"
 	"kiro2api/logger"
 	"kiro2api/types"
 	"kiro2api/utils"
@@ -17,11 +19,11 @@ import (
 func (h *Handler) handleOpenAICompletions(c *gin.Context) {
 	reqCtx := &request.Context{
 		GinContext:  c,
-		AuthService: h.authService,
+		AuthService: h.tokens,
 		RequestType: "OpenAI",
 	}
 
-	tokenInfo, body, err := reqCtx.GetTokenAndBody()
+	body, err := reqCtx.ReadBody()
 	if err != nil {
 		return
 	}
@@ -45,7 +47,52 @@ func (h *Handler) handleOpenAICompletions(c *gin.Context) {
 			}()),
 		)...)
 
-	anthropicReq := converter.ConvertOpenAIToAnthropic(openaiReq)
+	var warnings converter.Warnings
+	anthropicReq, err := converter.ConvertOpenAIToAnthropic(openaiReq, &warnings)
+	if err != nil {
+		logger.Warn("OpenAI请求转换失败", logutil.AddFields(c, logger.Err(err))...)
+		support.RespondErrorWithCode(c, http.StatusBadRequest, "invalid_tool_choice", "%v", err)
+		return
+	}
+	srvcontext.SetRequestWarnings(c, warnings)
+	// 转换后的消息与 OpenAI messages 一一对应
+	anthropicReq.MarkClientIndexes()
+
+	if errs := converter.ValidateResponseFormat(anthropicReq.ResponseFormat); len(errs) > 0 {
+		support.RespondErrorWithCode(c, http.StatusBadRequest, "invalid_response_format", "%s", converter.FormatValidationErrors(errs))
+		return
+	}
+
+	if err := converter.CheckWebSearchTools(anthropicReq.Tools); err != nil {
+		support.RespondErrorWithCode(c, http.StatusBadRequest, "unsupported_tool", "%v", err)
+		return
+	}
+
+	stream, ok := support.NegotiateStream(c, anthropicReq.Stream)
+	if !ok {
+		return
+	}
+	anthropicReq.Stream = stream
+	anthropicReq = applyContextGuard(c, anthropicReq)
+	srvcontext.SetInputTokens(c, shared.EstimateRequestInputTokens(c, anthropicReq))
+
+	settle, ok := h.reserveQuota(c)
+	if !ok {
+		return
+	}
+	defer settle()
+
+	release, ok := h.acquireQueueSlot(c)
+	if !ok {
+		return
+	}
+	defer release()
+
+	// 配额与排队通过后再选择token，被拒绝或仍在排队的请求不占用token
+	tokenInfo, ok := reqCtx.GetToken()
+	if !ok {
+		return
+	}
 
 	if anthropicReq.Stream {
 		h.gateway.HandleOpenAIStream(c, anthropicReq, tokenInfo)
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"kiro2api/internal/adapter/httpapi/middleware"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// newOpenAIErrorRouter 带请求ID和客户端认证中间件的 OpenAI 路由
+func newOpenAIErrorRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	t.Setenv("MOCK_UPSTREAM", "true")
+	t.Setenv("KIRO_CLIENT_TOKEN", "")
+	gin.SetMode(gin.TestMode)
+
+	h := New(Options{})
+	router := gin.New()
+	router.Use(middleware.RequestIDMiddleware())
+	router.Use(middleware.PathBasedAuthMiddleware("client-token", []string{"/v1"}))
+	router.POST("/v1/messages", h.handleAnthropicMessages)
+	router.POST("/v1/chat/completions", h.handleOpenAICompletions)
+	return router
+}
+
+func postWithRequestID(router *gin.Engine, path string, headers map[string]string, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Request-ID", "req_test")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestOpenAIRoute_ErrorBodyShape(t *testing.T) {
+	router := newOpenAIErrorRouter(t)
+	auth := map[string]string{"Authorization": "Bearer client-token"}
+
+	t.Run("400 请求体解析失败", func(t *testing.T) {
+		w := postWithRequestID(router, "/v1/chat/completions", auth, `{"model":`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Regexp(t, `^\{"error":\{"message":"解析请求体失败: .+ \(request id: req_test\)","type":"invalid_request_error","param":null,"code":"bad_request"\}\}$`, w.Body.String())
+	})
+
+	t.Run("406 参数相关错误带param", func(t *testing.T) {
+		w := postWithRequestID(router, "/v1/chat/completions", map[string]string{
+			"Authorization": "Bearer client-token",
+			"Accept":        "application/json",
+		}, `{"model":"claude-sonnet-4","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+		assert.Equal(t, http.StatusNotAcceptable, w.Code)
+		assert.Contains(t, w.Body.String(), `"type":"invalid_request_error","param":"stream","code":"not_acceptable"`)
+	})
+
+	t.Run("401 缺少密钥", func(t *testing.T) {
+		w := postWithRequestID(router, "/v1/chat/completions", nil, `{}`)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.JSONEq(t, `{"error":{
+			"message":"请求缺少 API 密钥，请通过 Authorization: Bearer 或 x-api-key 提供 (request id: req_test)",
+			"type":"authentication_error","param":null,"code":"invalid_api_key"}}`, w.Body.String())
+	})
+
+	t.Run("Anthropic路由保持原格式", func(t *testing.T) {
+		w := postWithRequestID(router, "/v1/messages", nil, `{}`)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.JSONEq(t, `{"error":"401"}`, w.Body.String())
+
+		w = postWithRequestID(router, "/v1/messages", auth, `{"model":`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.NotContains(t, w.Body.String(), "invalid_request_error")
+	})
+}
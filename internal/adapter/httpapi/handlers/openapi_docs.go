@@ -0,0 +1,688 @@
+package handlers
+
+//go:generate go run ../../../../cmd/openapi-gen -o ../../../../openapi.yaml
+
+import (
+	"net/http"
+	"strings"
+
+	"kiro2api/auth"
+	"kiro2api/config"
+	"kiro2api/converter"
+	"kiro2api/internal/adapter/httpapi/middleware"
+	"kiro2api/internal/adapter/httpapi/openapi"
+	"kiro2api/internal/adapter/upstream/shared"
+	"kiro2api/internal/audit"
+	"kiro2api/internal/batch"
+	"kiro2api/internal/queue"
+	"kiro2api/internal/quota"
+	"kiro2api/internal/stats"
+	"kiro2api/internal/version"
+	"kiro2api/logger"
+	"kiro2api/types"
+	"kiro2api/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OpenAPIInfo 生成文档使用的元信息
+var OpenAPIInfo = openapi.Info{
+	Title:       version.ProjectName,
+	Description: "Anthropic / OpenAI 兼容的 CodeWhisperer 代理服务",
+	Version:     version.Version,
+}
+
+// apiError support.RespondError 返回的错误结构
+type apiError struct {
+	Error struct {
+		Message string `json:"message"`
+		Code    string `json:"code"`
+	} `json:"error"`
+}
+
+// invalidRequestError count_tokens 等Anthropic兼容端点返回的错误结构
+type invalidRequestError struct {
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// validationErrorResponse /v1/messages 参数校验失败时返回的 Anthropic 格式错误
+type validationErrorResponse struct {
+	Type  string `json:"type"`
+	Error struct {
+		Type    string                      `json:"type"`
+		Message string                      `json:"message"`
+		Details []converter.ValidationError `json:"details"`
+	} `json:"error"`
+}
+
+// anthropicError Anthropic 格式的错误响应
+type anthropicError struct {
+	Type  string `json:"type"`
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// adminResult 管理接口通用的 success/message/error 响应结构
+type adminResult struct {
+	Success bool   `json:"success"`
+	Message string `json:"message,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// errorMessage 以error字符串表示错误的响应结构（认证中间件、加载配置失败等）
+type errorMessage struct {
+	Error   string `json:"error"`
+	Message string `json:"message,omitempty"`
+}
+
+// estimateResponse /admin/estimate 的响应结构
+type estimateResponse struct {
+	Breakdown        utils.TokenEstimateBreakdown   `json:"breakdown"`
+	Calibration      config.TokenCalibration        `json:"calibration"`
+	ModelCalibration config.CalibrationCoefficients `json:"model_calibration"` // 请求模型的字符/token比率，未校准时为默认值
+}
+
+// indexRequest 按索引操作token的请求体
+type indexRequest struct {
+	Index int `json:"index"`
+}
+
+// tokenIDRequest 按TokenID操作token的请求体
+type tokenIDRequest struct {
+	TokenID string `json:"token_id"`
+}
+
+// adminLoginRequest 管理员登录请求体
+type adminLoginRequest struct {
+	Token string `json:"token"`
+}
+
+// 通用响应描述
+var (
+	respUnauthorized = openapi.ResponseDoc{Description: "认证失败", Body: errorMessage{}}
+	respAdminOK      = openapi.ResponseDoc{Description: "成功", Body: adminResult{}}
+	respAdminError   = openapi.ResponseDoc{Description: "请求参数错误", Body: adminResult{}}
+	respAdminFailure = openapi.ResponseDoc{Description: "服务端错误", Body: adminResult{}}
+	respObject       = openapi.ResponseDoc{Description: "成功", Body: map[string]any{}}
+)
+
+// 认证方式名称，对应 components.securitySchemes
+const (
+	securityClientBearer = "clientBearer"
+	securityClientAPIKey = "clientApiKey"
+	securityAdminToken   = "adminToken"
+	securityAdminCookie  = "adminCookie"
+)
+
+// OpenAPISecuritySchemes 与认证中间件一致的认证方式
+var OpenAPISecuritySchemes = map[string]*openapi.SecurityScheme{
+	securityClientBearer: {Type: "http", Scheme: "bearer", Description: "客户端密钥（KIRO_CLIENT_TOKEN / KIRO_CLIENT_TOKENS）"},
+	securityClientAPIKey: {Type: "apiKey", In: "header", Name: "x-api-key", Description: "客户端密钥（KIRO_CLIENT_TOKEN / KIRO_CLIENT_TOKENS）"},
+	securityAdminToken:   {Type: "apiKey", In: "header", Name: "X-Admin-Token", Description: "管理员Token（ADMIN_TOKEN）"},
+	securityAdminCookie:  {Type: "apiKey", In: "cookie", Name: "admin_token", Description: "Dashboard登录后的管理员Token"},
+}
+
+// routeSecurity 按路径返回认证要求，由 server.go 注册的认证中间件的路径判定得出
+func routeSecurity(path string) []string {
+	switch {
+	case middleware.RequiresClientAuth(path):
+		return []string{securityClientBearer, securityClientAPIKey}
+	case middleware.IsAdminOnlyPath(path), middleware.IsDashboardAdminPath(path):
+		return []string{securityAdminToken, securityAdminCookie}
+	default:
+		return nil
+	}
+}
+
+// 兼容API支持的扩展请求头
+var apiRequestHeaders = []openapi.HeaderDoc{
+	{Name: config.ConversationIDHeader, Description: "自定义会话ID（UUID或 conv-<16位十六进制>，最长64字符），优先于按客户端特征生成的会话ID，格式无效时返回400"},
+	{Name: config.AgentContinuationIDHeader, Description: "自定义agent续接ID，格式要求同 X-Conversation-ID"},
+	{Name: config.ClientFingerprintHeader, Description: "客户端稳定标识（字母、数字和 . _ : -，最长128字符），存在时代替客户端IP与User-Agent生成会话ID，格式无效时返回400"},
+	{Name: "X-Request-ID", Description: "请求ID，未携带时自动生成"},
+	{Name: config.TraceParentHeader, Description: "W3C Trace Context，沿用其中的追踪ID并传递给上游"},
+	{Name: config.TraceStateHeader, Description: "W3C Trace Context 的厂商状态，随 traceparent 原样转发给上游"},
+	{Name: config.TenantIDHeader, Description: "租户标签，用于按租户统计"},
+	{Name: shared.LastEventIDHeader, Description: "流式请求断线重连时携带最后收到的事件ID（<检查点ID>:<序号>）或已收到的字节数（<检查点ID>@<字节数>），检查点存在时重放其后已记录的内容，否则按新请求处理；检查点属于其他客户端密钥时返回403"},
+	{Name: config.ProfileHeader, Description: "管理员调试：使用所选账号中该名称的 CodeWhisperer profile，优先于按模型选择的 profile，需携带管理员Token；账号未配置该名称时返回400"},
+	{Name: config.StrictSSEHeader, Description: "取值为 1 或 true 时严格校验SSE事件序列，出现违规即终止流"},
+	{Name: config.HeaderStrategyOverrideHeader, Description: "管理员调试：本次请求使用的请求头画像（kiro/random/legacy），需携带管理员Token"},
+	{Name: config.AgentModeOverrideHeader, Description: "管理员调试：本次请求的agent模式，需携带管理员Token"},
+	{Name: config.NoInjectHeader, Description: "管理员调试：取值为 true 时不注入服务端系统提示，需携带管理员Token"},
+}
+
+// 兼容API成功响应可能携带的扩展响应头
+var apiResponseHeaders = []openapi.HeaderDoc{
+	{Name: contextReducedHeader, Description: "上下文超出预算被裁剪时返回裁剪明细"},
+	{Name: historyRepairedHeader, Description: "修复了工具调用顺序时返回修复明细"},
+	{Name: shared.HistoryAdjustmentsHeader, Description: "转换时丢弃孤立 assistant、合并连续 user 或为孤立 user 补齐回复时，以JSON数组列出每处调整"},
+	{Name: shared.WarningsHeader, Description: "转换时缩短参数名、截断工具描述、跳过工具或丢弃内容块等时，以JSON数组列出每条警告；超过2048字节时为base64编码"},
+	{Name: shared.TruncatedUpstreamHeader, Description: "非流式响应因上游连接中断只包含部分内容时为 true"},
+	{Name: shared.ModelFallbackHeader, Description: "请求改由 MODEL_FALLBACKS 中的回退模型处理时返回，格式为 \"<请求的模型> -> <实际模型>; reason=<error_rate|model_error>\""},
+	{Name: config.TraceIDHeader, Description: "本次请求的追踪ID，用于关联客户端与服务端日志"},
+}
+
+// retryAfterHeader 限流与熔断响应携带的重试等待秒数
+var retryAfterHeader = []openapi.HeaderDoc{{Name: "Retry-After", Description: "建议的重试等待秒数"}}
+
+// rateLimitHeaders 代理接口429响应的响应头：限流时只有 Retry-After，超出配额时另有重置时间
+var rateLimitHeaders = []openapi.HeaderDoc{
+	retryAfterHeader[0],
+	{Name: QuotaResetHeader, Description: "超出客户端配额（code 为 quota_exceeded）时为配额窗口的重置时间（RFC 3339）"},
+}
+
+// RouteDocs 返回所有已注册路由的文档描述，键为 openapi.RouteKey(method, ginPath)
+// 新增路由时必须同步补充描述，否则 OpenAPI 文档生成和测试会失败
+func RouteDocs() map[string]openapi.RouteDoc {
+	docs := map[string]openapi.RouteDoc{
+		// 静态资源与页面
+		openapi.RouteKey(http.MethodGet, "/static/*filepath"):  {Hidden: true},
+		openapi.RouteKey(http.MethodHead, "/static/*filepath"): {Hidden: true},
+		openapi.RouteKey(http.MethodGet, "/health"): {
+			Summary: "健康检查", Tag: "system", OperationID: "health",
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK: {Description: "服务正常", Body: struct {
+					Status string `json:"status"`
+				}{}},
+			},
+		},
+		openapi.RouteKey(http.MethodGet, "/openapi.json"): {
+			Summary: "本服务的OpenAPI文档（启动时根据已注册路由生成）", Tag: "system",
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:                  {Description: "OpenAPI 3.0 文档", Body: map[string]any{}},
+				http.StatusInternalServerError: {Description: "文档生成失败", Body: errorMessage{}},
+			},
+		},
+		openapi.RouteKey(http.MethodGet, "/login"): {
+			Summary: "登录页面", Tag: "dashboard", OperationID: "loginPage",
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK: {Description: "HTML页面", ContentTypes: []string{"text/html"}},
+			},
+		},
+		openapi.RouteKey(http.MethodGet, "/"): {
+			Summary: "管理面板首页", Tag: "dashboard", OperationID: "indexPage",
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK: {Description: "HTML页面", ContentTypes: []string{"text/html"}},
+			},
+		},
+
+		// token池管理
+		openapi.RouteKey(http.MethodGet, "/api/tokens"): {
+			Summary: "查看token池状态", Tag: "tokens",
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:                  respObject,
+				http.StatusUnauthorized:        respUnauthorized,
+				http.StatusInternalServerError: {Description: "加载配置失败", Body: errorMessage{}},
+			},
+		},
+		openapi.RouteKey(http.MethodGet, "/api/tokens/export"): {
+			Summary: "导出token配置（默认遮蔽 refreshToken/clientSecret，include_secrets=true 时导出明文）", Tag: "tokens",
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:           {Description: "认证配置列表", Body: []auth.AuthConfig{}},
+				http.StatusUnauthorized: respUnauthorized,
+			},
+		},
+		openapi.RouteKey(http.MethodPost, "/api/tokens/reload"): {
+			Summary: "导入token配置（JSON请求体或multipart文件上传），按refreshToken去重；dry_run=true 只返回报告，replace=true 整体替换", Tag: "tokens",
+			Request: []auth.AuthConfig{},
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:                  {Description: "导入报告", Body: tokenImportResponse{}},
+				http.StatusBadRequest:          {Description: "请求无法解析、配置为空或存在无效配置", Body: tokenImportResponse{}},
+				http.StatusUnauthorized:        respUnauthorized,
+				http.StatusInternalServerError: respAdminFailure,
+			},
+		},
+		openapi.RouteKey(http.MethodPost, "/api/tokens/toggle"): {
+			Summary: "切换token启用/停用状态", Tag: "tokens",
+			Request: indexRequest{},
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:           respAdminOK,
+				http.StatusBadRequest:   respAdminError,
+				http.StatusUnauthorized: respUnauthorized,
+			},
+		},
+		openapi.RouteKey(http.MethodPost, "/api/tokens/delete"): {
+			Summary: "软删除token（可通过 /admin/tokens/restore 恢复）", Tag: "tokens",
+			Request: indexRequest{},
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:           respAdminOK,
+				http.StatusBadRequest:   respAdminError,
+				http.StatusUnauthorized: respUnauthorized,
+			},
+		},
+		openapi.RouteKey(http.MethodPost, "/api/tokens/refresh-all"): {
+			Summary: "刷新全部token", Tag: "tokens",
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:                  respObject,
+				http.StatusUnauthorized:        respUnauthorized,
+				http.StatusInternalServerError: respAdminFailure,
+			},
+		},
+		openapi.RouteKey(http.MethodPost, "/api/tokens/cleanup"): {
+			Summary: "软删除失效token（过期或已耗尽）", Tag: "tokens",
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:                  respObject,
+				http.StatusUnauthorized:        respUnauthorized,
+				http.StatusInternalServerError: respAdminFailure,
+			},
+		},
+		openapi.RouteKey(http.MethodPost, "/admin/tokens/:index/test"): {
+			Summary: "测试单个token（不影响token池）", Tag: "tokens",
+			Params: map[string]any{"index": 0},
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:                 {Description: "测试报告", Body: auth.TokenTestReport{}},
+				http.StatusBadRequest:         respAdminError,
+				http.StatusUnauthorized:       respUnauthorized,
+				http.StatusNotFound:           {Description: "索引超出范围", Body: adminResult{}},
+				http.StatusServiceUnavailable: {Description: "token管理器未初始化", Body: adminResult{}},
+			},
+		},
+		openapi.RouteKey(http.MethodPost, "/admin/tokens/restore"): {
+			Summary: "恢复已软删除的token", Tag: "tokens",
+			Request: tokenIDRequest{},
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:         respAdminOK,
+				http.StatusBadRequest: {Description: "token不存在或未被删除", Body: adminResult{}},
+			},
+		},
+		openapi.RouteKey(http.MethodPost, "/admin/tokens/rollback"): {
+			Summary: "撤销最近一次 replace=true 的导入（只能回滚一次）", Tag: "tokens",
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:         respObject,
+				http.StatusBadRequest: {Description: "没有可回滚的配置", Body: adminResult{}},
+			},
+		},
+		openapi.RouteKey(http.MethodPost, "/admin/tokens/import/format/:format"): {
+			Summary: "从凭证文件导入token配置（format 为 awscli/saml/oidc，请求体为原始文件内容）", Tag: "tokens",
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:                  respObject,
+				http.StatusBadRequest:          {Description: "格式不支持、凭证无法解析或配置无效", Body: adminResult{}},
+				http.StatusServiceUnavailable:  {Description: "token管理器未初始化", Body: adminResult{}},
+				http.StatusInternalServerError: respAdminFailure,
+			},
+		},
+		openapi.RouteKey(http.MethodPost, "/admin/tokens/purge"): {
+			Summary: "永久移除删除超过 days 天（默认30）的token配置", Tag: "tokens",
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:                  respObject,
+				http.StatusBadRequest:          {Description: "days 参数无效", Body: adminResult{}},
+				http.StatusInternalServerError: respAdminFailure,
+			},
+		},
+		openapi.RouteKey(http.MethodGet, "/admin/audit"): {
+			Summary: "管理操作日志（按时间倒序，limit 参数限制条数）", Tag: "tokens",
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:         {Description: "最近的管理操作记录", Body: adminAuditResponse{}},
+				http.StatusBadRequest: {Description: "limit 参数无效", Body: errorMessage{}},
+			},
+		},
+
+		// 统计
+		openapi.RouteKey(http.MethodGet, "/api/stats"): {
+			Summary: "请求统计", Tag: "stats",
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:           respObject,
+				http.StatusUnauthorized: respUnauthorized,
+			},
+		},
+		openapi.RouteKey(http.MethodGet, "/admin/stats"): {
+			Summary: "管理概览（今日用量、系统提示注入的哈希）", Tag: "stats",
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK: {Description: "系统提示只返回哈希和长度，不返回原文；token_wait 为没有可用token时的排队统计；upstream_models 为按模型的上游错误、延迟、stop_reason 分布与回退次数；panics 为按路由恢复的panic次数", Body: adminStatsResponse{}},
+			},
+		},
+		openapi.RouteKey(http.MethodGet, "/admin/stats/latency"): {
+			Summary: "延迟分位统计", Tag: "stats",
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK: respObject,
+			},
+		},
+		openapi.RouteKey(http.MethodGet, "/admin/stats/upstreams"): {
+			Summary: "上游端点健康状况（错误率、p95延迟、故障转移状态）", Tag: "stats",
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK: {Description: "按优先级排列的端点列表", Body: upstreamStatsResponse{}},
+			},
+		},
+		openapi.RouteKey(http.MethodGet, "/admin/models/capabilities"): {
+			Summary: "模型能力探测结果（需 CAPABILITY_PROBE_INTERVAL，连续被拒绝的模型停止路由）", Tag: "stats",
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK: {Description: "按模型名排序的探测结果", Body: modelCapabilitiesResponse{}},
+			},
+		},
+		openapi.RouteKey(http.MethodGet, "/admin/stop-reasons"): {
+			Summary: "当前生效的 stop_reason 替换规则（STOP_REASON_OVERRIDES）及触发次数", Tag: "stats",
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK: {Description: "按原 stop_reason 排序，未配置时为空列表；fired 为自进程启动以来的替换次数", Body: stopReasonsResponse{}},
+			},
+		},
+		openapi.RouteKey(http.MethodGet, "/admin/stats/circuits"): {
+			Summary: "上游熔断器状态（closed/open/half_open、连续失败次数、打开次数）", Tag: "stats",
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK: {Description: "按熔断键排序的熔断器列表", Body: circuitStatsResponse{}},
+			},
+		},
+		openapi.RouteKey(http.MethodGet, "/admin/stats/models"): {
+			Summary: "按模型统计请求数、token用量与错误率", Tag: "stats",
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK: {Description: "自进程启动以来的累计统计", Body: modelStatsResponse{}},
+			},
+		},
+		openapi.RouteKey(http.MethodGet, "/admin/stats/tenants"): {
+			Summary: "按租户（X-Tenant-ID）统计请求数、token用量与错误率", Tag: "stats",
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK: {Description: "自进程启动以来的累计统计，含每个租户按模型的明细", Body: tenantStatsResponse{}},
+			},
+		},
+		openapi.RouteKey(http.MethodGet, "/admin/stats/sse"): {
+			Summary: "SSE事件序列违规统计（按规则计数、严格模式终止次数）", Tag: "stats",
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK: {Description: "自进程启动以来的累计统计", Body: stats.SSEViolationMetrics{}},
+			},
+		},
+		openapi.RouteKey(http.MethodGet, "/admin/stats/large-responses"): {
+			Summary: "流式响应体积直方图（字节分桶、超过 LARGE_RESPONSE_WARNING_BYTES 的响应数）", Tag: "stats",
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK: {Description: "自进程启动以来的累计统计", Body: largeResponseStatsResponse{}},
+			},
+		},
+		openapi.RouteKey(http.MethodGet, "/admin/queue/stats"): {
+			Summary: "请求优先级队列各通道（high/medium/low）的排队深度与吞吐量", Tag: "stats",
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK: {Description: "throughput_per_min 为最近一分钟放行的请求数，max_concurrent 为0表示不限并发", Body: queue.QueueStats{}},
+			},
+		},
+		openapi.RouteKey(http.MethodGet, "/admin/quotas"): {
+			Summary: "各客户端密钥在当前每日/每月窗口（UTC）的用量与剩余预算", Tag: "stats",
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK: {Description: "按密钥名称排序；remaining 中为 null 的项不限制", Body: quotasResponse{}},
+			},
+		},
+		openapi.RouteKey(http.MethodPut, "/admin/quotas"): {
+			Summary: "运行时更新客户端密钥的每日/每月预算（持久化到配置目录的 quotas.json，已用量不变）", Tag: "settings",
+			Request: quotaUpdateRequest{},
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:                  {Description: "更新后的配额状态", Body: quota.KeyStatus{}},
+				http.StatusBadRequest:          {Description: "请求体无效或预算为负数", Body: errorMessage{}},
+				http.StatusNotFound:            {Description: "未知的客户端密钥名称", Body: errorMessage{}},
+				http.StatusInternalServerError: {Description: "持久化失败", Body: errorMessage{}},
+			},
+		},
+		openapi.RouteKey(http.MethodPost, "/admin/estimate"): {
+			Summary: "token估算分项明细", Tag: "stats",
+			Request: types.CountTokensRequest{},
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:         {Description: "估算明细与校准参数", Body: estimateResponse{}},
+				http.StatusBadRequest: {Description: "请求体无效", Body: invalidRequestError{}},
+			},
+		},
+		openapi.RouteKey(http.MethodPost, "/admin/token-estimator/calibrate"): {
+			Summary: "按一次实测的实际/估算token数修正模型的字符/token比率（持久化到 data/token_calibration.json，只影响之后的估算）", Tag: "stats",
+			Request: calibrateRequest{},
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:                  {Description: "校准前后的字符/token比率", Body: calibrateResponse{}},
+				http.StatusBadRequest:          {Description: "缺少模型或token数不是正数", Body: errorMessage{}},
+				http.StatusInternalServerError: {Description: "持久化失败", Body: errorMessage{}},
+			},
+		},
+
+		openapi.RouteKey(http.MethodGet, "/admin/conversations/:conversation_id"): {
+			Summary: "导出会话请求记录（AUDIT_LEVEL=full 时包含规范化请求体）", Tag: "stats",
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:       {Description: "保留期内的请求记录", Body: conversationExport{}},
+				http.StatusNotFound: {Description: "会话不存在、已过期或审计未启用", Body: adminResult{}},
+			},
+		},
+		openapi.RouteKey(http.MethodGet, "/admin/requests/:id/headers"): {
+			Summary: "查看最近请求发往上游的请求头与上游响应头（需 LOG_UPSTREAM_HEADERS=true，敏感请求头已脱敏）", Tag: "stats",
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:       {Description: "按请求ID记录的上游请求（含429重试）", Body: audit.RequestHeaders{}},
+				http.StatusNotFound: {Description: "请求不存在、记录已被淘汰或日志未启用", Body: errorMessage{}},
+			},
+		},
+		openapi.RouteKey(http.MethodGet, "/admin/ws/conversations"): {
+			Summary: "WebSocket实时推送新的会话消息（脱敏后的内容预览）", Tag: "stats",
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusSwitchingProtocols: {Description: "升级为WebSocket，每条消息为含 conversation_id、role、content_preview、token_count 的JSON文本帧"},
+				http.StatusBadRequest:         {Description: "不是有效的WebSocket握手"},
+				http.StatusForbidden:          {Description: "浏览器Origin与Host不一致"},
+			},
+		},
+
+		// 系统配置
+		openapi.RouteKey(http.MethodGet, "/api/settings"): {
+			Summary: "读取系统配置", Tag: "settings",
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:           {Description: "配置键值", Body: map[string]string{}},
+				http.StatusUnauthorized: respUnauthorized,
+			},
+		},
+		openapi.RouteKey(http.MethodPost, "/api/settings"): {
+			Summary: "保存系统配置", Tag: "settings",
+			Request: map[string]string{},
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:                  respAdminOK,
+				http.StatusBadRequest:          respAdminError,
+				http.StatusUnauthorized:        respUnauthorized,
+				http.StatusInternalServerError: respAdminFailure,
+			},
+		},
+		openapi.RouteKey(http.MethodGet, "/admin/tools/filter"): {
+			Summary: "读取工具黑白名单", Tag: "settings",
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK: {Description: "当前生效的黑白名单", Body: config.ToolFilter{}},
+			},
+		},
+		openapi.RouteKey(http.MethodPut, "/admin/tools/filter"): {
+			Summary: "热更新工具黑白名单（支持 * 通配符，持久化到 data/tool_filter.json，只影响之后的请求）", Tag: "settings",
+			Request: config.ToolFilter{},
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:                  {Description: "更新后的黑白名单", Body: config.ToolFilter{}},
+				http.StatusBadRequest:          {Description: "请求体或通配符无效", Body: errorMessage{}},
+				http.StatusInternalServerError: {Description: "持久化失败", Body: errorMessage{}},
+			},
+		},
+		openapi.RouteKey(http.MethodGet, "/admin/config"): {
+			Summary: "读取当前生效的配置快照（端口、日志级别、隐身模式、请求头策略、HTTP/2模式、token缓存有效期、工具描述长度上限，不含密钥）", Tag: "settings",
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK: {Description: "配置快照", Body: adminConfigResponse{}},
+			},
+		},
+		openapi.RouteKey(http.MethodGet, "/admin/log/level"): {
+			Summary: "读取当前日志级别与最近一次调整时间", Tag: "settings",
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK: {Description: "当前日志级别", Body: logLevelResponse{}},
+			},
+		},
+		openapi.RouteKey(http.MethodPut, "/admin/log/level"): {
+			Summary: "立即调整日志级别（debug/info/warn/error，不持久化，两次调整至少间隔10秒）", Tag: "settings",
+			Request: logLevelRequest{},
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:              {Description: "调整后的日志级别", Body: logLevelResponse{}},
+				http.StatusBadRequest:      {Description: "日志级别无效", Body: errorMessage{}},
+				http.StatusTooManyRequests: {Description: "调整过于频繁", Body: errorMessage{}, Headers: retryAfterHeader},
+			},
+		},
+		openapi.RouteKey(http.MethodPut, "/admin/stealth/ab-test"): {
+			Summary: "开始请求头策略A/B测试：按客户端IP的稳定哈希把 control_percent% 的请求分到 strategy_a，其余分到 strategy_b（替换进行中的测试并清空统计，不持久化）", Tag: "settings",
+			Request: headerABRequest{},
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:         {Description: "测试配置与各策略统计", Body: headerABResultsResponse{}},
+				http.StatusBadRequest: {Description: "比例或策略无效", Body: errorMessage{}},
+			},
+		},
+		openapi.RouteKey(http.MethodDelete, "/admin/stealth/ab-test"): {
+			Summary: "停止请求头策略A/B测试，统计保留到下一次开始测试", Tag: "settings",
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:       {Description: "已停止的测试与各策略统计", Body: headerABResultsResponse{}},
+				http.StatusNotFound: {Description: "没有进行中的A/B测试", Body: errorMessage{}},
+			},
+		},
+		openapi.RouteKey(http.MethodGet, "/admin/stealth/ab-test/results"): {
+			Summary: "读取请求头策略A/B测试各策略的上游请求数、错误率和状态码分布", Tag: "settings",
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK: {Description: "当前或最近一次测试的结果", Body: headerABResultsResponse{}},
+			},
+		},
+
+		// 管理员认证
+		openapi.RouteKey(http.MethodPost, "/api/admin/login"): {
+			Summary: "管理员登录", Tag: "admin",
+			Request: adminLoginRequest{},
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:           respAdminOK,
+				http.StatusBadRequest:   respAdminError,
+				http.StatusUnauthorized: {Description: "令牌错误", Body: adminResult{}},
+			},
+		},
+		openapi.RouteKey(http.MethodPost, "/api/admin/logout"): {
+			Summary: "管理员登出", Tag: "admin",
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:           respAdminOK,
+				http.StatusUnauthorized: respUnauthorized,
+			},
+		},
+		openapi.RouteKey(http.MethodGet, "/api/admin/status"): {
+			Summary: "管理员登录状态", Tag: "admin",
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK: respObject,
+			},
+		},
+
+		// 系统管理
+		openapi.RouteKey(http.MethodPost, "/api/system/restart"): {
+			Summary: "重启服务", Tag: "system", OperationID: "restartService",
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:           respAdminOK,
+				http.StatusUnauthorized: respUnauthorized,
+			},
+		},
+		openapi.RouteKey(http.MethodGet, "/api/system/info"): {
+			Summary: "系统信息", Tag: "system",
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:           respObject,
+				http.StatusUnauthorized: respUnauthorized,
+			},
+		},
+
+		// 兼容API
+		openapi.RouteKey(http.MethodGet, "/v1/models"): {
+			Summary: "模型列表", Tag: "api",
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:           {Description: "可用模型", Body: types.ModelsResponse{}},
+				http.StatusUnauthorized: respUnauthorized,
+			},
+		},
+		openapi.RouteKey(http.MethodPost, "/v1/messages"): {
+			Summary: "Anthropic Messages API（stream=true 时返回SSE）", Tag: "api",
+			Request: types.AnthropicRequest{},
+			Headers: apiRequestHeaders,
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:                  {Description: "消息响应", Body: map[string]any{}, ContentTypes: []string{"text/event-stream"}, Headers: apiResponseHeaders},
+				http.StatusBadRequest:          {Description: "请求参数校验失败（details 列出每个字段的错误）", Body: validationErrorResponse{}},
+				http.StatusUnauthorized:        respUnauthorized,
+				http.StatusUnprocessableEntity: {Description: "非流式响应不符合 response_format 约束", Body: anthropicError{}},
+				http.StatusTooManyRequests:     {Description: "上游限流、客户端密钥超出限流档位或超出配额（code 为 quota_exceeded）", Body: apiError{}, Headers: rateLimitHeaders},
+				http.StatusInternalServerError: {Description: "上游请求失败", Body: apiError{}},
+				http.StatusServiceUnavailable:  {Description: "上游熔断中（Retry-After 为冷却剩余秒数），或在优先级队列中排队超过 REQUEST_QUEUE_TIMEOUT（code 为 queue_timeout）", Body: anthropicError{}, Headers: retryAfterHeader},
+			},
+		},
+		openapi.RouteKey(http.MethodPost, "/v1/messages/count_tokens"): {
+			Summary: "计算输入token数", Tag: "api",
+			Request: types.CountTokensRequest{},
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:           {Description: "token数", Body: types.CountTokensResponse{}},
+				http.StatusBadRequest:   {Description: "请求体无效", Body: invalidRequestError{}},
+				http.StatusUnauthorized: respUnauthorized,
+			},
+		},
+		openapi.RouteKey(http.MethodPost, "/v1/messages/batches"): {
+			Summary: "创建消息批处理（后台并发执行，最多10000个非流式请求）", Tag: "api",
+			Request: batch.CreateRequest{},
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:           {Description: "已创建的批处理", Body: batch.MessageBatch{}},
+				http.StatusBadRequest:   {Description: "批处理结构无效", Body: validationErrorResponse{}},
+				http.StatusUnauthorized: respUnauthorized,
+			},
+		},
+		openapi.RouteKey(http.MethodGet, "/v1/messages/batches/:id"): {
+			Summary: "查询批处理状态", Tag: "api",
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:           {Description: "批处理状态与各状态请求数", Body: batch.MessageBatch{}},
+				http.StatusUnauthorized: respUnauthorized,
+				http.StatusNotFound:     {Description: "批处理不存在、已过期或由其他客户端密钥创建", Body: anthropicError{}},
+			},
+		},
+		openapi.RouteKey(http.MethodGet, "/v1/messages/batches/:id/results"): {
+			Summary: "获取批处理结果（JSONL，每行一个请求的 custom_id 与 result）", Tag: "api",
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:           {Description: "每行一个 batch.Result", ContentTypes: []string{"application/x-jsonl"}},
+				http.StatusUnauthorized: respUnauthorized,
+				http.StatusNotFound:     {Description: "批处理不存在、已过期或由其他客户端密钥创建", Body: anthropicError{}},
+				http.StatusConflict:     {Description: "批处理仍在进行中", Body: anthropicError{}},
+			},
+		},
+		openapi.RouteKey(http.MethodPost, "/v1/chat/completions"): {
+			Summary: "OpenAI Chat Completions API（stream=true 时返回SSE）", Tag: "api",
+			Request: types.OpenAIRequest{},
+			Headers: apiRequestHeaders,
+			Responses: map[int]openapi.ResponseDoc{
+				http.StatusOK:                  {Description: "补全响应", Body: types.OpenAIResponse{}, ContentTypes: []string{"text/event-stream"}, Headers: apiResponseHeaders},
+				http.StatusBadRequest:          {Description: "请求无效", Body: apiError{}},
+				http.StatusUnauthorized:        respUnauthorized,
+				http.StatusUnprocessableEntity: {Description: "非流式响应不符合 response_format 约束", Body: apiError{}},
+				http.StatusTooManyRequests:     {Description: "上游限流、客户端密钥超出限流档位或超出配额（code 为 quota_exceeded）", Body: apiError{}, Headers: rateLimitHeaders},
+				http.StatusInternalServerError: {Description: "上游请求失败", Body: apiError{}},
+				http.StatusServiceUnavailable:  {Description: "上游熔断中（Retry-After 为冷却剩余秒数），或在优先级队列中排队超过 REQUEST_QUEUE_TIMEOUT（code 为 queue_timeout）", Body: anthropicError{}, Headers: retryAfterHeader},
+			},
+		},
+	}
+
+	for key, doc := range docs {
+		if doc.Hidden {
+			continue
+		}
+		_, path, _ := strings.Cut(key, " ")
+		doc.Security = routeSecurity(path)
+		docs[key] = doc
+	}
+	return docs
+}
+
+// BuildOpenAPISpec 注册全部路由到临时引擎，返回注册时生成的OpenAPI文档
+func BuildOpenAPISpec() (*openapi.Document, error) {
+	h := New(Options{})
+	h.Register(gin.New())
+	return h.openAPISpec, h.openAPIErr
+}
+
+// generateOpenAPISpec 结合 RouteDocs 与路由表生成OpenAPI文档
+func generateOpenAPISpec(routes gin.RoutesInfo) (*openapi.Document, error) {
+	doc, err := openapi.Generate(OpenAPIInfo, routes, RouteDocs())
+	if err != nil {
+		return nil, err
+	}
+	doc.Components.SecuritySchemes = OpenAPISecuritySchemes
+	return doc, nil
+}
+
+// handleOpenAPISpec 返回启动时生成的OpenAPI文档
+func (h *Handler) handleOpenAPISpec(c *gin.Context) {
+	if h.openAPIErr != nil {
+		c.JSON(http.StatusInternalServerError, errorMessage{Error: "生成OpenAPI文档失败", Message: h.openAPIErr.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, h.openAPISpec)
+}
+
+// buildOpenAPISpec 在路由注册完成后生成文档，失败时只记录日志，不影响服务启动
+func (h *Handler) buildOpenAPISpec(r *gin.Engine) {
+	h.openAPISpec, h.openAPIErr = generateOpenAPISpec(r.Routes())
+	if h.openAPIErr != nil {
+		logger.Error("生成OpenAPI文档失败", logger.Err(h.openAPIErr))
+	}
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"kiro2api/internal/adapter/httpapi/openapi"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const committedSpecPath = "../../../../openapi.yaml"
+
+func TestOpenAPISpec_CoversAllRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	doc, err := BuildOpenAPISpec()
+	require.NoError(t, err)
+	require.NoError(t, openapi.Validate(doc))
+
+	engine := gin.New()
+	New(Options{}).Register(engine)
+	docs := RouteDocs()
+	for _, route := range engine.Routes() {
+		key := openapi.RouteKey(route.Method, route.Path)
+		public := strings.HasPrefix(route.Path, "/v1/") || strings.HasPrefix(route.Path, "/admin/")
+		if docs[key].Hidden {
+			assert.False(t, public, "%s 不能在文档中隐藏", key)
+			continue
+		}
+		item, ok := doc.Paths[openapi.OpenAPIPath(route.Path)]
+		require.True(t, ok, "缺少路径 %s", route.Path)
+		op := item.Operation(route.Method)
+		require.NotNil(t, op, "缺少操作 %s", key)
+		if public {
+			assert.NotEmpty(t, op.Security, "%s 缺少认证要求", key)
+		}
+	}
+}
+
+func TestOpenAPISpec_ServedAsJSON(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	New(Options{}).Register(engine)
+
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var doc openapi.Document
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &doc))
+	require.NoError(t, openapi.Validate(&doc))
+
+	messages := doc.Paths["/v1/messages"].Operation(http.MethodPost)
+	require.NotNil(t, messages)
+	var headers []string
+	for _, param := range messages.Parameters {
+		if param.In == "header" {
+			headers = append(headers, param.Name)
+		}
+	}
+	assert.Contains(t, headers, "X-Conversation-ID")
+	assert.Contains(t, headers, "X-Kiro-Strict-SSE")
+	assert.Contains(t, messages.Responses["200"].Headers, "X-Kiro-Context-Reduced")
+	assert.Equal(t, []openapi.SecurityRequirement{{"clientBearer": {}}, {"clientApiKey": {}}}, messages.Security)
+	assert.Equal(t, "#/components/schemas/AnthropicRequest", messages.RequestBody.Content["application/json"].Schema.Ref)
+
+	assert.Equal(t, "X-Admin-Token", doc.Components.SecuritySchemes["adminToken"].Name)
+	assert.Empty(t, doc.Paths["/health"].Get.Security)
+
+	// 认证要求与认证中间件的路径判定一致
+	admin := []openapi.SecurityRequirement{{"adminToken": {}}, {"adminCookie": {}}}
+	assert.Equal(t, admin, doc.Paths["/api/tokens/export"].Get.Security)
+	assert.Equal(t, admin, doc.Paths["/admin/audit"].Get.Security)
+	assert.Empty(t, doc.Paths["/api/admin/login"].Post.Security)
+	assert.Empty(t, doc.Paths["/api/admin/status"].Get.Security)
+}
+
+// TestOpenAPISpec_CommittedFileUpToDate 加载仓库中提交的 openapi.yaml 并校验，同时确认与当前路由生成的结果一致
+func TestOpenAPISpec_CommittedFileUpToDate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	committed, err := os.ReadFile(filepath.FromSlash(committedSpecPath))
+	require.NoError(t, err)
+
+	parsed, err := openapi.Parse(committed)
+	require.NoError(t, err)
+	require.NoError(t, openapi.Validate(parsed))
+
+	doc, err := BuildOpenAPISpec()
+	require.NoError(t, err)
+	generated, err := openapi.Marshal(doc)
+	require.NoError(t, err)
+
+	assert.Equal(t, string(generated), string(committed), "openapi.yaml 已过期，请运行 make openapi 重新生成")
+}
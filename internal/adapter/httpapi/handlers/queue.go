@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	srvcontext "kiro2api/internal/adapter/httpapi/context"
+	logutil "kiro2api/internal/adapter/httpapi/logging"
+	"kiro2api/internal/adapter/httpapi/support"
+	"kiro2api/internal/queue"
+	"kiro2api/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// acquireQueueSlot 按估算输入token选择优先级通道并排队等待执行名额
+// 返回值ok为false表示排队超时（已写入503）或客户端已断开，调用方应直接返回；ok为true时请求结束后需调用 release
+func (h *Handler) acquireQueueSlot(c *gin.Context) (release func(), ok bool) {
+	tokens, _ := srvcontext.GetInputTokens(c)
+	lane := queue.ClassifyLane(tokens)
+
+	release, err := h.queue.Acquire(c.Request.Context(), lane)
+	if err == nil {
+		return release, true
+	}
+
+	if !errors.Is(err, queue.ErrQueueTimeout) {
+		logger.Debug("排队期间客户端断开", logutil.AddFields(c, logger.String("lane", lane.String()))...)
+		return nil, false
+	}
+	logger.Warn("请求排队超时",
+		logutil.AddFields(c,
+			logger.String("lane", lane.String()),
+			logger.Int("estimated_tokens", tokens),
+		)...)
+	support.RespondErrorWithCode(c, http.StatusServiceUnavailable, "queue_timeout", "请求排队超时（%s 优先级通道），请稍后重试", lane)
+	return nil, false
+}
+
+// handleGetQueueStats 获取优先级队列各通道的排队深度与吞吐量
+func (h *Handler) handleGetQueueStats(c *gin.Context) {
+	c.JSON(http.StatusOK, h.queue.Stats())
+}
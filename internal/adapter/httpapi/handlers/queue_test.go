@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	srvcontext "kiro2api/internal/adapter/httpapi/context"
+	"kiro2api/internal/adapter/httpapi/request"
+	"kiro2api/internal/queue"
+	"kiro2api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcquireQueueSlot_TimeoutReturns503(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := &Handler{queue: queue.NewPriorityQueue(1, 0, 10*time.Millisecond)}
+
+	// 占满唯一的并发名额
+	release, err := h.queue.Acquire(context.Background(), queue.LaneHigh)
+	require.NoError(t, err)
+	defer release()
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	srvcontext.SetInputTokens(c, 20000)
+
+	_, ok := h.acquireQueueSlot(c)
+	require.False(t, ok)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "queue_timeout")
+
+	router := gin.New()
+	router.GET("/admin/queue/stats", h.handleGetQueueStats)
+	statsRecorder := httptest.NewRecorder()
+	router.ServeHTTP(statsRecorder, httptest.NewRequest(http.MethodGet, "/admin/queue/stats", nil))
+
+	var stats queue.QueueStats
+	require.NoError(t, json.Unmarshal(statsRecorder.Body.Bytes(), &stats))
+	require.Len(t, stats.Lanes, 3)
+	assert.Equal(t, "low", stats.Lanes[queue.LaneLow].Lane)
+	assert.Equal(t, int64(1), stats.Lanes[queue.LaneLow].TimedOut)
+	assert.Equal(t, 1, stats.Active)
+}
+
+// countingTokenProvider 记录选择token的次数
+type countingTokenProvider struct {
+	request.TokenProvider
+	calls int
+}
+
+func (p *countingTokenProvider) GetToken() (types.TokenInfo, error) {
+	p.calls++
+	return p.TokenProvider.GetToken()
+}
+
+func (p *countingTokenProvider) GetTokenWithUsage() (*types.TokenWithUsage, error) {
+	p.calls++
+	return p.TokenProvider.GetTokenWithUsage()
+}
+
+// TestProxyHandlers_QueueTimeoutDoesNotSelectToken 排队超时的请求不占用token
+func TestProxyHandlers_QueueTimeoutDoesNotSelectToken(t *testing.T) {
+	t.Setenv("MOCK_UPSTREAM", "true")
+	gin.SetMode(gin.TestMode)
+
+	h := New(Options{})
+	tokens := &countingTokenProvider{TokenProvider: h.tokens}
+	h.tokens = tokens
+	h.queue = queue.NewPriorityQueue(1, 0, 10*time.Millisecond)
+	router := gin.New()
+	router.POST("/v1/messages", h.handleAnthropicMessages)
+	router.POST("/v1/chat/completions", h.handleOpenAICompletions)
+
+	release, err := h.queue.Acquire(context.Background(), queue.LaneHigh)
+	require.NoError(t, err)
+
+	w := postJSON(router, "/v1/messages", `{"model":"claude-sonnet-4","max_tokens":100,"messages":[{"role":"user","content":"你好"}]}`)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	w = postJSON(router, "/v1/chat/completions", `{"model":"claude-sonnet-4","messages":[{"role":"user","content":"你好"}]}`)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Zero(t, tokens.calls, "排队未通过前不选择token")
+
+	release()
+	w = postJSON(router, "/v1/messages", `{"model":"claude-sonnet-4","max_tokens":100,"messages":[{"role":"user","content":"你好"}]}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 1, tokens.calls)
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"kiro2api/config"
+	srvcontext "kiro2api/internal/adapter/httpapi/context"
+	logutil "kiro2api/internal/adapter/httpapi/logging"
+	"kiro2api/internal/adapter/httpapi/support"
+	"kiro2api/internal/audit"
+	"kiro2api/internal/quota"
+	"kiro2api/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// QuotaResetHeader 配额超出时返回窗口重置时间（RFC 3339）的响应头
+const QuotaResetHeader = "X-Quota-Reset"
+
+// quotasResponse GET /admin/quotas 的响应
+type quotasResponse struct {
+	Keys []quota.KeyStatus `json:"keys"`
+}
+
+// quotaUpdateRequest PUT /admin/quotas 的请求体，未提供的窗口表示不限制
+type quotaUpdateRequest struct {
+	Key string `json:"key" binding:"required"` // 客户端密钥名称
+	config.ClientQuota
+}
+
+// reserveQuota 按估算输入token预占客户端密钥的配额
+// 返回值ok为false表示超出配额（已写入429 quota_exceeded），调用方应直接返回；ok为true时请求结束后需调用 settle 按实际用量结算
+func (h *Handler) reserveQuota(c *gin.Context) (settle func(), ok bool) {
+	key, authenticated := srvcontext.GetClientKey(c)
+	if !authenticated {
+		return func() {}, true
+	}
+	tokens, _ := srvcontext.GetInputTokens(c)
+
+	reservation, err := h.quotas.Reserve(key.Name, tokens)
+	if err == nil {
+		return func() {
+			// 请求失败时没有记录用量，按0结算退还预占的输入token
+			usage, _ := srvcontext.GetTokenUsage(c)
+			h.quotas.Settle(reservation, usage.InputTokens, usage.OutputTokens)
+		}, true
+	}
+
+	var exceeded *quota.ExceededError
+	if !errors.As(err, &exceeded) {
+		logger.Error("客户端配额检查失败，放行请求", logutil.AddFields(c, logger.Err(err))...)
+		return func() {}, true
+	}
+	seconds := int(math.Ceil(time.Until(exceeded.ResetAt).Seconds()))
+	logger.Warn("客户端密钥超出配额",
+		logutil.AddFields(c,
+			logger.String("client_key", key.Name),
+			logger.String("window", string(exceeded.Window)),
+			logger.String("item", exceeded.Item),
+			logger.Int64("limit", exceeded.Limit),
+			logger.Int64("used", exceeded.Used),
+			logger.Int("estimated_tokens", tokens),
+		)...)
+	resetAt := exceeded.ResetAt.Format(time.RFC3339)
+	c.Header(QuotaResetHeader, resetAt)
+	c.Header("Retry-After", strconv.Itoa(max(seconds, 1)))
+	support.RespondErrorWithCode(c, http.StatusTooManyRequests, "quota_exceeded", "%v，配额将于 %s 重置", err, resetAt)
+	return nil, false
+}
+
+// handleGetQuotas 返回各客户端密钥在当前每日/每月窗口的用量与剩余预算
+func (h *Handler) handleGetQuotas(c *gin.Context) {
+	c.JSON(http.StatusOK, quotasResponse{Keys: h.quotas.Snapshot()})
+}
+
+// handleUpdateQuota 运行时更新客户端密钥的预算并持久化，已用量不变
+func (h *Handler) handleUpdateQuota(c *gin.Context) {
+	var req quotaUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误: " + err.Error()})
+		return
+	}
+	if err := req.ClientQuota.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !isClientKeyName(req.Key) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "未知的客户端密钥: " + req.Key})
+		return
+	}
+
+	if err := h.quotas.SetBudget(req.Key, req.ClientQuota); err != nil {
+		logger.Error("保存客户端配额失败", logger.String("client_key", req.Key), logger.Err(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存客户端配额失败: " + err.Error()})
+		return
+	}
+	logger.Info("客户端配额已更新",
+		logger.String("client_key", req.Key),
+		logger.Any("daily", req.Daily),
+		logger.Any("monthly", req.Monthly))
+	h.recordAdminAction(c, audit.AdminActionQuota, "")
+
+	c.JSON(http.StatusOK, h.quotas.Status(req.Key))
+}
+
+// isClientKeyName 名称是否对应已配置的客户端密钥
+func isClientKeyName(name string) bool {
+	keys, _ := config.ClientKeys()
+	for _, key := range keys {
+		if key.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"kiro2api/config"
+	srvcontext "kiro2api/internal/adapter/httpapi/context"
+	"kiro2api/internal/audit"
+	"kiro2api/internal/quota"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newQuotaTestRouter 代理路由模拟处理器的流程：记录客户端密钥与估算输入token后预占配额，成功时记录实际用量
+func newQuotaTestRouter(h *Handler) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/v1/messages", func(c *gin.Context) {
+		srvcontext.SetClientKey(c, srvcontext.ClientKey{Name: c.GetHeader("X-Key-Name")})
+		srvcontext.SetInputTokens(c, 400)
+		settle, ok := h.reserveQuota(c)
+		if !ok {
+			return
+		}
+		defer settle()
+		srvcontext.SetTokenUsage(c, srvcontext.TokenUsage{InputTokens: 250, OutputTokens: 90})
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+	router.GET("/admin/quotas", h.handleGetQuotas)
+	router.PUT("/admin/quotas", h.handleUpdateQuota)
+	return router
+}
+
+func serveQuotaRequest(router *gin.Engine, method, path, keyName, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("X-Key-Name", keyName)
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestReserveQuota(t *testing.T) {
+	h := &Handler{
+		quotas: quota.NewEngine(map[string]config.ClientQuota{
+			"marketing": {Daily: config.QuotaBudget{InputTokens: 600}},
+		}, nil),
+		adminLog: audit.NewAdminLog(10),
+	}
+	router := newQuotaTestRouter(h)
+
+	w := serveQuotaRequest(router, http.MethodPost, "/v1/messages", "marketing", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	status := h.quotas.Status("marketing")
+	assert.Equal(t, quota.Usage{InputTokens: 250, OutputTokens: 90, Requests: 1}, status.Daily.Used, "按实际用量结算")
+
+	// 已用250 + 估算400 超出600
+	w = serveQuotaRequest(router, http.MethodPost, "/v1/messages", "marketing", "")
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"quota_exceeded"`)
+	resetAt, err := time.Parse(time.RFC3339, w.Header().Get(QuotaResetHeader))
+	require.NoError(t, err)
+	assert.True(t, resetAt.Equal(status.Daily.ResetAt))
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+	assert.Equal(t, int64(1), h.quotas.Status("marketing").Daily.Used.Requests, "被拒绝的请求不计入")
+
+	// 未配置预算的密钥不受限制
+	w = serveQuotaRequest(router, http.MethodPost, "/v1/messages", "other", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestHandleQuotas(t *testing.T) {
+	t.Setenv("KIRO_CLIENT_TOKEN", "")
+	t.Setenv("KIRO_CLIENT_TOKENS", `[{"key":"k1","name":"marketing"}]`)
+	h := &Handler{quotas: quota.NewEngine(nil, nil), adminLog: audit.NewAdminLog(10)}
+	router := newQuotaTestRouter(h)
+
+	w := serveQuotaRequest(router, http.MethodPut, "/admin/quotas", "", `{"key":"marketing","daily":{"output_tokens":5000000},"monthly":{"requests":100}}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var updated quota.KeyStatus
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &updated))
+	assert.Equal(t, config.QuotaBudget{OutputTokens: 5000000}, updated.Daily.Budget)
+	require.NotNil(t, updated.Monthly.Remaining.Requests)
+	assert.Equal(t, int64(100), *updated.Monthly.Remaining.Requests)
+	assert.Nil(t, updated.Daily.Remaining.Requests)
+
+	require.Equal(t, http.StatusOK, serveQuotaRequest(router, http.MethodPost, "/v1/messages", "marketing", "").Code)
+
+	w = serveQuotaRequest(router, http.MethodGet, "/admin/quotas", "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp quotasResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Keys, 1)
+	assert.Equal(t, "marketing", resp.Keys[0].Key)
+	assert.Equal(t, int64(4999910), *resp.Keys[0].Daily.Remaining.OutputTokens)
+	assert.Equal(t, int64(99), *resp.Keys[0].Monthly.Remaining.Requests)
+
+	for body, code := range map[string]int{
+		`{"key":"unknown","daily":{"requests":1}}`:    http.StatusNotFound,
+		`{"key":"marketing","daily":{"requests":-1}}`: http.StatusBadRequest,
+		`{"daily":{"requests":1}}`:                    http.StatusBadRequest,
+	} {
+		assert.Equal(t, code, serveQuotaRequest(router, http.MethodPut, "/admin/quotas", "", body).Code, body)
+	}
+}
@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"net/http"
+
+	"kiro2api/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleGetRequestHeaders 返回最近请求发往上游的请求头和上游响应头（需启用 LOG_UPSTREAM_HEADERS）
+func (h *Handler) handleGetRequestHeaders(c *gin.Context) {
+	requestID := c.Param("id")
+	entry, ok := h.headerLog.Get(requestID)
+	if !ok {
+		message := "请求不存在或记录已被淘汰: " + requestID
+		if !config.IsUpstreamHeaderLoggingEnabled() {
+			message = "上游请求头日志未启用（LOG_UPSTREAM_HEADERS）"
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": message})
+		return
+	}
+	c.JSON(http.StatusOK, entry)
+}
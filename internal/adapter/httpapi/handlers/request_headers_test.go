@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"kiro2api/internal/audit"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleGetRequestHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("LOG_UPSTREAM_HEADERS", "true")
+
+	h := &Handler{headerLog: audit.NewHeaderLog(10)}
+	h.headerLog.Record(audit.RequestHeaders{
+		RequestID: "req_abc",
+		Path:      "/v1/messages",
+		Exchanges: []audit.HeaderExchange{{
+			Endpoint:        "https://upstream",
+			StatusCode:      http.StatusOK,
+			RequestHeaders:  map[string]string{"Authorization": "Bearer e..."},
+			ResponseHeaders: map[string]string{"X-Amzn-Requestid": "rid"},
+		}},
+	})
+
+	r := gin.New()
+	r.GET("/admin/requests/:id/headers", h.handleGetRequestHeaders)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/requests/req_abc/headers", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var entry audit.RequestHeaders
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &entry))
+	assert.Equal(t, "req_abc", entry.RequestID)
+	require.Len(t, entry.Exchanges, 1)
+	assert.Equal(t, "Bearer e...", entry.Exchanges[0].RequestHeaders["Authorization"])
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/requests/req_missing/headers", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"os"
 	"strings"
@@ -86,6 +87,13 @@ func (h *Handler) handleSaveSettings(c *gin.Context) {
 
 	// 保存到持久化文件
 	if err := config.SaveSystemConfig(newConfig); err != nil {
+		if errors.Is(err, config.ErrInvalidConfig) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"error":   err.Error(),
+			})
+			return
+		}
 		logger.Error("保存系统配置失败", logger.Err(err))
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
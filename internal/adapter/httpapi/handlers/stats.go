@@ -3,7 +3,12 @@ package handlers
 import (
 	"net/http"
 	"strconv"
+	"time"
 
+	"kiro2api/auth"
+	"kiro2api/config"
+	"kiro2api/converter"
+	"kiro2api/internal/adapter/upstream/shared"
 	"kiro2api/internal/stats"
 
 	"github.com/gin-gonic/gin"
@@ -35,3 +40,159 @@ func (h *Handler) handleGetStats(c *gin.Context) {
 		},
 	})
 }
+
+// adminStatsResponse 管理概览：今日用量和当前生效的服务端配置摘要
+type adminStatsResponse struct {
+	TodayTotal   todayTotalStats   `json:"today_total"`
+	SystemPrompt systemPromptStats `json:"system_prompt"`
+	// TokenWait 没有可用token时的排队统计（模拟上游模式下不返回）
+	TokenWait *auth.TokenWaitStats `json:"token_wait,omitempty"`
+	// UpstreamModels 按模型的上游调用次数、错误、延迟、stop_reason 分布和回退次数
+	UpstreamModels []stats.ModelUpstreamMetrics `json:"upstream_models"`
+	// Panics 请求处理中恢复的panic次数（含流式事件处理中恢复的）
+	Panics stats.PanicMetrics `json:"panics"`
+}
+
+type todayTotalStats struct {
+	InputTokens  int64 `json:"input_tokens"`
+	OutputTokens int64 `json:"output_tokens"`
+	RequestCount int   `json:"request_count"`
+}
+
+// systemPromptStats 服务端注入的系统提示，只返回哈希和长度，不返回原文
+type systemPromptStats struct {
+	Enabled     bool   `json:"enabled"`
+	Hash        string `json:"hash"`
+	PrefixBytes int    `json:"prefix_bytes"`
+	SuffixBytes int    `json:"suffix_bytes"`
+}
+
+// handleGetAdminStats 获取管理概览，可用于确认部署的系统提示注入版本
+func (h *Handler) handleGetAdminStats(c *gin.Context) {
+	input, output, requests := stats.GetCollector().GetTodayTotal()
+	injection := converter.ActiveSystemPromptInjection()
+
+	resp := adminStatsResponse{
+		TodayTotal: todayTotalStats{
+			InputTokens:  input,
+			OutputTokens: output,
+			RequestCount: requests,
+		},
+		SystemPrompt: systemPromptStats{
+			Enabled:     !injection.Empty(),
+			Hash:        injection.Hash(),
+			PrefixBytes: len(injection.Prefix),
+			SuffixBytes: len(injection.Suffix),
+		},
+		UpstreamModels: stats.GetModelUpstreamStats().Snapshot(),
+		Panics:         stats.GetPanicCounter().Snapshot(),
+	}
+	if h.tokenManager != nil {
+		wait := h.tokenManager.WaitStats()
+		resp.TokenWait = &wait
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// handleGetLatencyStats 获取各端点上游响应延迟分位数
+func (h *Handler) handleGetLatencyStats(c *gin.Context) {
+	tracker := stats.GetLatencyTracker()
+	endpoints, windowStart := tracker.Snapshot()
+
+	bucketsMs := make([]int64, 0, len(tracker.Buckets()))
+	for _, bucket := range tracker.Buckets() {
+		bucketsMs = append(bucketsMs, bucket.Milliseconds())
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"endpoints":    endpoints,
+		"buckets_ms":   bucketsMs,
+		"window_start": windowStart,
+	})
+}
+
+// upstreamStatsResponse 上游端点健康状况（按优先级排列，active 为当前使用的端点）
+type upstreamStatsResponse struct {
+	Endpoints []shared.EndpointHealth `json:"endpoints"`
+}
+
+// handleGetUpstreamStats 获取各上游端点的错误率、p95延迟与健康状态
+func (h *Handler) handleGetUpstreamStats(c *gin.Context) {
+	c.JSON(http.StatusOK, upstreamStatsResponse{
+		Endpoints: shared.GetEndpointPool().Snapshot(),
+	})
+}
+
+// modelCapabilitiesResponse 模型能力探测结果（按模型名排序）
+type modelCapabilitiesResponse struct {
+	Enabled       bool                     `json:"enabled"`
+	IntervalHours int                      `json:"interval_hours"`
+	Models        []shared.ModelCapability `json:"models"`
+}
+
+// handleGetModelCapabilities 获取各模型最近的探测结果与路由可用性
+func (h *Handler) handleGetModelCapabilities(c *gin.Context) {
+	interval := config.CapabilityProbeInterval()
+	c.JSON(http.StatusOK, modelCapabilitiesResponse{
+		Enabled:       interval > 0,
+		IntervalHours: int(interval / time.Hour),
+		Models:        shared.GetModelCapabilityProbe().Snapshot(),
+	})
+}
+
+// circuitStatsResponse 上游熔断器状态（按熔断键排序）
+type circuitStatsResponse struct {
+	Enabled  bool                   `json:"enabled"`
+	Circuits []shared.CircuitStatus `json:"circuits"`
+}
+
+// handleGetCircuitStats 获取各上游熔断器的状态、连续失败次数与打开次数
+func (h *Handler) handleGetCircuitStats(c *gin.Context) {
+	c.JSON(http.StatusOK, circuitStatsResponse{
+		Enabled:  config.IsCircuitBreakerEnabled(),
+		Circuits: shared.GetCircuitBreaker().Snapshot(),
+	})
+}
+
+// modelStatsResponse 按模型汇总的累计请求数、token用量与错误率
+type modelStatsResponse struct {
+	Models map[string]stats.ModelMetrics `json:"models"`
+}
+
+// handleGetModelStats 获取各模型的请求与token统计，用于成本分摊
+func (h *Handler) handleGetModelStats(c *gin.Context) {
+	c.JSON(http.StatusOK, modelStatsResponse{
+		Models: stats.GetCollector().ModelStats(),
+	})
+}
+
+// tenantStatsResponse 按租户（X-Tenant-ID 请求头）汇总的统计，每个租户附带按模型的明细
+type tenantStatsResponse struct {
+	Tenants map[string]stats.TenantMetrics `json:"tenants"`
+}
+
+// handleGetTenantStats 获取各租户的请求与token统计
+func (h *Handler) handleGetTenantStats(c *gin.Context) {
+	c.JSON(http.StatusOK, tenantStatsResponse{
+		Tenants: stats.GetCollector().TenantStats(),
+	})
+}
+
+// handleGetSSEStats 获取流式响应的SSE事件序列违规统计（非严格模式下违规只计数，严格模式下终止流）
+func (h *Handler) handleGetSSEStats(c *gin.Context) {
+	c.JSON(http.StatusOK, stats.GetSSEViolationCounter().Snapshot())
+}
+
+// largeResponseStatsResponse 流式响应体积直方图及当前的告警阈值
+type largeResponseStatsResponse struct {
+	WarningThresholdBytes int `json:"warning_threshold_bytes"`
+	stats.ResponseSizeMetrics
+}
+
+// handleGetLargeResponseStats 获取流式响应体积直方图与超过告警阈值的响应数
+func (h *Handler) handleGetLargeResponseStats(c *gin.Context) {
+	c.JSON(http.StatusOK, largeResponseStatsResponse{
+		WarningThresholdBytes: config.LargeResponseWarningBytes(),
+		ResponseSizeMetrics:   stats.GetResponseSizeHistogram().Snapshot(),
+	})
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"kiro2api/converter"
+	"kiro2api/internal/stats"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serveStats(t *testing.T, path string, handler gin.HandlerFunc) *httptest.ResponseRecorder {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.GET(path, handler)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w
+}
+
+func TestHandleGetModelAndTenantStats(t *testing.T) {
+	collector := stats.GetCollector()
+	collector.Record(120, 30, "model-stats-handler-test", "tenant-stats-handler-test")
+	collector.RecordError("model-stats-handler-test", "tenant-stats-handler-test")
+
+	h := &Handler{}
+
+	w := serveStats(t, "/admin/stats/models", h.handleGetModelStats)
+	require.Equal(t, http.StatusOK, w.Code)
+	var models modelStatsResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &models))
+	model := models.Models["model-stats-handler-test"]
+	assert.Equal(t, int64(2), model.RequestsTotal)
+	assert.Equal(t, int64(120), model.InputTokensTotal)
+	assert.Equal(t, int64(30), model.OutputTokensTotal)
+	assert.Equal(t, 0.5, model.ErrorRate)
+
+	w = serveStats(t, "/admin/stats/tenants", h.handleGetTenantStats)
+	require.Equal(t, http.StatusOK, w.Code)
+	var tenants tenantStatsResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &tenants))
+	tenant := tenants.Tenants["tenant-stats-handler-test"]
+	assert.Equal(t, int64(2), tenant.RequestsTotal)
+	assert.Equal(t, 0.5, tenant.ErrorRate)
+	assert.Equal(t, int64(120), tenant.Models["model-stats-handler-test"].InputTokensTotal)
+}
+
+func TestHandleGetSSEStats(t *testing.T) {
+	before := stats.GetSSEViolationCounter().Snapshot()
+	stats.GetSSEViolationCounter().RecordStream([]string{"duplicate_message_stop"}, true)
+
+	w := serveStats(t, "/admin/stats/sse", (&Handler{}).handleGetSSEStats)
+	require.Equal(t, http.StatusOK, w.Code)
+	var got stats.SSEViolationMetrics
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+	assert.Equal(t, before.StreamsTotal+1, got.StreamsTotal)
+	assert.Equal(t, before.StrictTerminations+1, got.StrictTerminations)
+	assert.Equal(t, before.ByRule["duplicate_message_stop"]+1, got.ByRule["duplicate_message_stop"])
+}
+
+func TestHandleGetAdminStats_SystemPromptHashOnly(t *testing.T) {
+	t.Setenv("SYSTEM_PROMPT_PREFIX", "secret guardrail")
+	t.Setenv("SYSTEM_PROMPT_SUFFIX", "")
+
+	w := serveStats(t, "/admin/stats", (&Handler{}).handleGetAdminStats)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "secret guardrail", "不返回注入原文")
+
+	var resp adminStatsResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.True(t, resp.SystemPrompt.Enabled)
+	assert.Equal(t, converter.ActiveSystemPromptInjection().Hash(), resp.SystemPrompt.Hash)
+	assert.Equal(t, len("secret guardrail"), resp.SystemPrompt.PrefixBytes)
+	assert.Zero(t, resp.SystemPrompt.SuffixBytes)
+
+	t.Setenv("SYSTEM_PROMPT_PREFIX", "")
+	w = serveStats(t, "/admin/stats", (&Handler{}).handleGetAdminStats)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, systemPromptStats{}, resp.SystemPrompt)
+}
@@ -0,0 +1,19 @@
+package handlers
+
+import (
+	"net/http"
+
+	"kiro2api/internal/adapter/upstream/shared"
+
+	"github.com/gin-gonic/gin"
+)
+
+// stopReasonsResponse GET /admin/stop-reasons 的响应
+type stopReasonsResponse struct {
+	Overrides []shared.StopReasonOverrideStatus `json:"overrides"`
+}
+
+// handleGetStopReasons 返回当前生效的 STOP_REASON_OVERRIDES 及每条规则自启动以来的触发次数
+func (h *Handler) handleGetStopReasons(c *gin.Context) {
+	c.JSON(http.StatusOK, stopReasonsResponse{Overrides: shared.GetStopReasonOverrides().Snapshot()})
+}
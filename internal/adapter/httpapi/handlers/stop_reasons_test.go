@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"kiro2api/internal/adapter/upstream/shared"
+	"kiro2api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleGetStopReasons(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Cleanup(func() { shared.SetStopReasonOverrides(nil) })
+	router := gin.New()
+	router.GET("/admin/stop-reasons", (&Handler{}).handleGetStopReasons)
+
+	get := func() stopReasonsResponse {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/stop-reasons", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		var resp stopReasonsResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+
+	shared.SetStopReasonOverrides(nil)
+	assert.Empty(t, get().Overrides)
+
+	shared.SetStopReasonOverrides(map[string]string{"tool_use": "end_turn"})
+	srm := shared.NewStopReasonManager(types.AnthropicRequest{})
+	srm.UpdateToolCallStatus(true, true)
+	require.Equal(t, "end_turn", srm.DetermineStopReason())
+
+	assert.Equal(t, []shared.StopReasonOverrideStatus{{From: "tool_use", To: "end_turn", Fired: 1}}, get().Overrides)
+}
@@ -6,6 +6,7 @@ import (
 	"os/exec"
 	"time"
 
+	"kiro2api/config"
 	"kiro2api/logger"
 
 	"github.com/gin-gonic/gin"
@@ -59,7 +60,7 @@ func (h *Handler) handleRestartService(c *gin.Context) {
 // handleGetSystemInfo 获取系统信息
 func (h *Handler) handleGetSystemInfo(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
-		"port":     os.Getenv("PORT"),
+		"port":     config.Get().Port,
 		"gin_mode": os.Getenv("GIN_MODE"),
 		"version":  "1.0.0",
 	})
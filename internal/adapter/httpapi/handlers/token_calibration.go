@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"maps"
+	"net/http"
+	"strings"
+	"sync"
+
+	"kiro2api/config"
+	"kiro2api/internal/audit"
+	"kiro2api/logger"
+	"kiro2api/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tokenCalibrationMutex 串行化校准表更新，保证持久化文件与内存中的校准表一致
+var tokenCalibrationMutex sync.Mutex
+
+// calibrateRequest POST /admin/token-estimator/calibrate 的请求体：一次实测的实际token数与估算值
+type calibrateRequest struct {
+	Model           string `json:"model"`
+	ActualTokens    int    `json:"actual_tokens"`
+	EstimatedTokens int    `json:"estimated_tokens"`
+}
+
+// calibrateResponse 校准前后该模型的字符/token比率
+type calibrateResponse struct {
+	Model        string                         `json:"model"`
+	Previous     config.CalibrationCoefficients `json:"previous"`
+	Coefficients config.CalibrationCoefficients `json:"coefficients"`
+}
+
+// handleCalibrateTokenEstimator 按一次实测结果更新模型的字符/token比率并持久化，只影响之后的估算
+func (h *Handler) handleCalibrateTokenEstimator(c *gin.Context) {
+	var req calibrateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误: " + err.Error()})
+		return
+	}
+	req.Model = strings.TrimSpace(req.Model)
+	if req.Model == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少 model"})
+		return
+	}
+	if req.ActualTokens <= 0 || req.EstimatedTokens <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "actual_tokens 和 estimated_tokens 必须大于0"})
+		return
+	}
+
+	tokenCalibrationMutex.Lock()
+	defer tokenCalibrationMutex.Unlock()
+
+	previous := utils.ModelCalibrationCoefficients(req.Model)
+	table := maps.Clone(utils.ActiveModelCalibrationTable())
+	if table == nil {
+		table = config.ModelCalibrationTable{}
+	}
+	table[req.Model] = previous.Calibrate(req.ActualTokens, req.EstimatedTokens)
+
+	if err := config.SaveModelCalibrationTable(table); err != nil {
+		logger.Error("保存按模型校准表失败", logger.Err(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存按模型校准表失败: " + err.Error()})
+		return
+	}
+	utils.SetModelCalibrationTable(table)
+
+	logger.Info("token估算比率已按实测校准",
+		logger.String("model", req.Model),
+		logger.Int("actual_tokens", req.ActualTokens),
+		logger.Int("estimated_tokens", req.EstimatedTokens),
+		logger.Float64("text_chars_per_token", table[req.Model].TextCharsPerToken),
+		logger.Int("samples", table[req.Model].Samples))
+	h.recordAdminAction(c, audit.AdminActionTokenCalibration, "")
+
+	c.JSON(http.StatusOK, calibrateResponse{Model: req.Model, Previous: previous, Coefficients: table[req.Model]})
+}
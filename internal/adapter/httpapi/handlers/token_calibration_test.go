@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"kiro2api/config"
+	"kiro2api/internal/audit"
+	"kiro2api/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serveCalibrate(t *testing.T, h *Handler, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.POST("/admin/token-estimator/calibrate", h.handleCalibrateTokenEstimator)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/token-estimator/calibrate", strings.NewReader(body)))
+	return w
+}
+
+func TestHandleCalibrateTokenEstimator(t *testing.T) {
+	t.Setenv("TOKEN_CALIBRATION_FILE", filepath.Join(t.TempDir(), "token_calibration.json"))
+	t.Cleanup(func() { utils.SetModelCalibrationTable(config.ModelCalibrationTable{}) })
+	h := &Handler{adminLog: audit.NewAdminLog(10)}
+
+	for _, body := range []string{
+		`{"actual_tokens":100,"estimated_tokens":120}`,
+		`{"model":"claude-sonnet-4","actual_tokens":0,"estimated_tokens":120}`,
+		`{"model":"claude-sonnet-4","actual_tokens":100,"estimated_tokens":-1}`,
+		`not-json`,
+	} {
+		w := serveCalibrate(t, h, body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+	assert.Empty(t, h.adminLog.Entries(0))
+
+	w := serveCalibrate(t, h, `{"model":"claude-sonnet-4","actual_tokens":100,"estimated_tokens":120}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var resp calibrateResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, config.DefaultCalibrationCoefficients(), resp.Previous)
+	assert.InDelta(t, config.TextCharsPerToken*1.2, resp.Coefficients.TextCharsPerToken, 1e-9)
+	assert.Equal(t, 1, resp.Coefficients.Samples)
+
+	// 内存和持久化文件同时更新，其他模型不受影响
+	assert.Equal(t, resp.Coefficients, utils.ModelCalibrationCoefficients("claude-sonnet-4"))
+	assert.Equal(t, config.DefaultCalibrationCoefficients(), utils.ModelCalibrationCoefficients("claude-opus-4"))
+	saved, err := config.LoadModelCalibrationTable()
+	require.NoError(t, err)
+	assert.Equal(t, resp.Coefficients, saved["claude-sonnet-4"])
+
+	entries := h.adminLog.Entries(0)
+	require.Len(t, entries, 1)
+	assert.Equal(t, audit.AdminActionTokenCalibration, entries[0].Action)
+}
@@ -5,10 +5,13 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"kiro2api/auth"
+	"kiro2api/config"
+	"kiro2api/internal/audit"
 	"kiro2api/logger"
 	"kiro2api/types"
 
@@ -50,6 +53,11 @@ func (h *Handler) handleTokenPool(c *gin.Context) {
 	}
 
 	for i, authConfig := range configs {
+		// 软删除的token不展示，保留索引与配置数组一致
+		if authConfig.IsDeleted() {
+			continue
+		}
+
 		if authConfig.Disabled {
 			tokenData := map[string]any{
 				"index":           i,
@@ -86,7 +94,7 @@ func (h *Handler) handleTokenPool(c *gin.Context) {
 		var available float64
 		userEmail := fmt.Sprintf("用户-%d", i)
 
-		checker := auth.NewUsageLimitsChecker()
+		checker := auth.NewUsageLimitsChecker(authConfig.Region)
 		if usage, checkErr := checker.CheckUsageLimits(tokenInfo); checkErr == nil {
 			usageInfo = usage
 			available = auth.CalculateAvailableCount(usage)
@@ -163,7 +171,7 @@ func (h *Handler) handleTokenPool(c *gin.Context) {
 		"active_tokens": activeCount,
 		"tokens":        tokenList,
 		"pool_stats": map[string]any{
-			"total_tokens":  len(configs),
+			"total_tokens":  len(tokenList),
 			"active_tokens": activeCount,
 		},
 	})
@@ -172,7 +180,7 @@ func (h *Handler) handleTokenPool(c *gin.Context) {
 func refreshSingleTokenByConfig(config auth.AuthConfig) (types.TokenInfo, error) {
 	switch config.AuthType {
 	case auth.AuthMethodSocial:
-		return auth.RefreshSocialToken(config.RefreshToken)
+		return auth.RefreshSocialToken(config.RefreshToken, config.Region)
 	case auth.AuthMethodIdC:
 		return auth.RefreshIdCToken(config)
 	default:
@@ -298,55 +306,197 @@ func (h *Handler) handleTokenReload(c *gin.Context) {
 		return
 	}
 
-	// 验证每个配置的必要字段
-	for i, cfg := range newConfigs {
-		if cfg.RefreshToken == "" {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"success": false,
-				"error":   fmt.Sprintf("配置 #%d: refreshToken 不能为空", i),
-			})
-			return
-		}
-		if cfg.AuthType != auth.AuthMethodSocial && cfg.AuthType != auth.AuthMethodIdC {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"success": false,
-				"error":   fmt.Sprintf("配置 #%d: auth 必须是 'Social' 或 'IdC'", i),
-			})
-			return
-		}
-		if cfg.AuthType == auth.AuthMethodIdC && (cfg.ClientID == "" || cfg.ClientSecret == "") {
+	opts := auth.TokenImportOptions{
+		DryRun:  c.Query("dry_run") == "true",
+		Replace: c.Query("replace") == "true",
+	}
+
+	logger.Info("收到token配置更新请求",
+		logger.Int("new_config_count", len(newConfigs)),
+		logger.String("content_type", contentType),
+		logger.Bool("dry_run", opts.DryRun),
+		logger.Bool("replace", opts.Replace))
+
+	// 执行热更新：按refreshToken去重，任一配置无效则整体拒绝
+	report, err := h.tokenManager.ImportConfigs(newConfigs, opts)
+	resp := tokenImportResponse{ConfigCount: len(newConfigs), TokenImportReport: report}
+	if err != nil {
+		logger.Warn("token配置更新失败", logger.Err(err))
+		resp.Error = "更新失败: " + err.Error()
+		c.JSON(http.StatusBadRequest, resp)
+		return
+	}
+
+	resp.Success = true
+	switch {
+	case opts.DryRun:
+		resp.Message = "校验完成，未修改配置"
+		c.JSON(http.StatusOK, resp)
+		return
+	case opts.Replace:
+		resp.Message = "配置已替换，可通过 /admin/tokens/rollback 回滚"
+		h.recordAdminAction(c, audit.AdminActionReplace, "")
+	default:
+		resp.Message = "配置更新成功"
+	}
+
+	logger.Info("token配置更新成功",
+		logger.Int("added", len(report.AddedIDs)),
+		logger.Int("duplicates", len(report.Duplicates)))
+	for _, tokenID := range report.AddedIDs {
+		h.recordAdminAction(c, audit.AdminActionReload, tokenID)
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// tokenImportResponse reload 接口的响应，附带导入报告
+type tokenImportResponse struct {
+	Success     bool   `json:"success"`
+	Message     string `json:"message,omitempty"`
+	Error       string `json:"error,omitempty"`
+	ConfigCount int    `json:"config_count"` // 请求中的配置数
+	auth.TokenImportReport
+}
+
+// handleTokenRollback 撤销最近一次 replace=true 的导入，恢复之前的token配置（只能回滚一次）
+func (h *Handler) handleTokenRollback(c *gin.Context) {
+	if h.tokenManager == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"success": false,
+			"error":   "token管理器未初始化",
+		})
+		return
+	}
+
+	count, err := h.tokenManager.RollbackConfigs()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	h.recordAdminAction(c, audit.AdminActionRollback, "")
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":      true,
+		"message":      "回滚成功",
+		"config_count": count,
+	})
+}
+
+// handleTokenImportFormat 从 awscli/saml/oidc 凭证文件导入token配置，请求体为原始文件内容
+func (h *Handler) handleTokenImportFormat(c *gin.Context) {
+	format := c.Param("format")
+	parser, ok := auth.GetCredentialParser(format)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   fmt.Sprintf("不支持的凭证格式: %s（支持 %s）", format, strings.Join(auth.SupportedCredentialFormats(), ", ")),
+		})
+		return
+	}
+
+	if h.tokenManager == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"success": false,
+			"error":   "token管理器未初始化",
+		})
+		return
+	}
+
+	data, err := c.GetRawData()
+	if err != nil || len(data) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "请求体为空",
+		})
+		return
+	}
+
+	configs, err := parser.Parse(data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   fmt.Sprintf("解析%s凭证失败: %v", parser.Format(), err),
+		})
+		return
+	}
+
+	for i, cfg := range configs {
+		if err := auth.ValidateAuthConfig(cfg); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{
 				"success": false,
-				"error":   fmt.Sprintf("配置 #%d: IdC 认证需要 clientId 和 clientSecret", i),
+				"error":   fmt.Sprintf("配置 #%d: %v", i, err),
 			})
 			return
 		}
 	}
 
-	logger.Info("收到token配置更新请求",
-		logger.Int("new_config_count", len(newConfigs)),
-		logger.String("content_type", contentType))
-
-	// 执行热更新
-	if err := h.tokenManager.ReloadConfigs(newConfigs); err != nil {
-		logger.Error("token配置更新失败", logger.Err(err))
+	addedIDs, err := h.tokenManager.ReloadConfigs(configs)
+	if err != nil {
+		logger.Error("导入token配置失败", logger.Err(err))
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"success": false,
-			"error":   "更新失败: " + err.Error(),
+			"error":   "导入失败: " + err.Error(),
 		})
 		return
 	}
 
-	logger.Info("token配置更新成功", logger.Int("config_count", len(newConfigs)))
+	logger.Info("从凭证文件导入token配置",
+		logger.String("format", parser.Format()),
+		logger.Int("config_count", len(configs)))
+	for _, tokenID := range addedIDs {
+		h.recordAdminAction(c, audit.AdminActionImport, tokenID)
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"success":      true,
-		"message":      "配置更新成功",
-		"config_count": len(newConfigs),
-		"timestamp":    time.Now().Format(time.RFC3339),
+		"message":      "导入成功",
+		"config_count": len(configs),
+		"token_ids":    addedIDs,
 	})
 }
 
+// handleTokenTest 立即检测指定索引的token（刷新+使用限制），不影响token池
+func (h *Handler) handleTokenTest(c *gin.Context) {
+	if h.tokenManager == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"success": false,
+			"error":   "token管理器未初始化",
+		})
+		return
+	}
+
+	index, err := strconv.Atoi(c.Param("index"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "无效的token索引: " + c.Param("index"),
+		})
+		return
+	}
+
+	report, err := h.tokenManager.TestToken(index)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	logger.Info("token检测完成",
+		logger.Int("index", index),
+		logger.Bool("valid", report.Valid),
+		logger.Float64("available_credits", report.AvailableCredits),
+		logger.String("error", report.Error))
+
+	c.JSON(http.StatusOK, report)
+}
+
 // handleTokenToggle 切换token启用/停用状态
 func (h *Handler) handleTokenToggle(c *gin.Context) {
 	var req struct {
@@ -364,7 +514,8 @@ func (h *Handler) handleTokenToggle(c *gin.Context) {
 
 	logger.Info("收到toggle请求", logger.Int("index", req.Index))
 
-	if err := h.tokenManager.ToggleTokenStatus(req.Index); err != nil {
+	tokenID, err := h.tokenManager.ToggleTokenStatus(req.Index)
+	if err != nil {
 		logger.Error("切换token状态失败", logger.Err(err))
 		c.JSON(http.StatusBadRequest, gin.H{
 			"success": false,
@@ -374,6 +525,7 @@ func (h *Handler) handleTokenToggle(c *gin.Context) {
 	}
 
 	logger.Info("token状态已切换", logger.Int("index", req.Index))
+	h.recordAdminAction(c, audit.AdminActionToggle, tokenID)
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -381,7 +533,7 @@ func (h *Handler) handleTokenToggle(c *gin.Context) {
 	})
 }
 
-// handleTokenDelete 删除token
+// handleTokenDelete 软删除token，可通过 /admin/tokens/restore 恢复
 func (h *Handler) handleTokenDelete(c *gin.Context) {
 	var req struct {
 		Index int `json:"index"`
@@ -398,7 +550,8 @@ func (h *Handler) handleTokenDelete(c *gin.Context) {
 
 	logger.Info("收到delete请求", logger.Int("index", req.Index))
 
-	if err := h.tokenManager.RemoveToken(req.Index); err != nil {
+	tokenID, err := h.tokenManager.RemoveToken(req.Index)
+	if err != nil {
 		logger.Error("删除token失败", logger.Err(err))
 		c.JSON(http.StatusBadRequest, gin.H{
 			"success": false,
@@ -407,11 +560,82 @@ func (h *Handler) handleTokenDelete(c *gin.Context) {
 		return
 	}
 
-	logger.Info("token已删除", logger.Int("index", req.Index))
+	logger.Info("token已删除", logger.Int("index", req.Index), logger.String("token_id", tokenID))
+	h.recordAdminAction(c, audit.AdminActionDelete, tokenID)
 
 	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"message": "删除成功",
+		"success":  true,
+		"message":  "删除成功",
+		"token_id": tokenID,
+	})
+}
+
+// handleTokenRestore 恢复已软删除的token
+func (h *Handler) handleTokenRestore(c *gin.Context) {
+	var req struct {
+		TokenID string `json:"token_id"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil || req.TokenID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "请求参数错误: 缺少 token_id",
+		})
+		return
+	}
+
+	if err := h.tokenManager.RestoreToken(req.TokenID); err != nil {
+		logger.Error("恢复token失败", logger.Err(err))
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	h.recordAdminAction(c, audit.AdminActionRestore, req.TokenID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":  true,
+		"message":  "恢复成功",
+		"token_id": req.TokenID,
+	})
+}
+
+// handleTokenPurge 永久移除删除超过指定天数的token配置
+func (h *Handler) handleTokenPurge(c *gin.Context) {
+	days := config.DefaultTokenPurgeDays
+	if raw := c.Query("days"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"error":   "无效的天数: " + raw,
+			})
+			return
+		}
+		days = parsed
+	}
+
+	purgedIDs, err := h.tokenManager.PurgeDeletedTokens(time.Duration(days) * 24 * time.Hour)
+	if err != nil {
+		logger.Error("清除已删除token失败", logger.Err(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	for _, tokenID := range purgedIDs {
+		h.recordAdminAction(c, audit.AdminActionPurge, tokenID)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":      true,
+		"message":      "清除完成",
+		"purged_count": len(purgedIDs),
+		"token_ids":    purgedIDs,
 	})
 }
 
@@ -425,9 +649,22 @@ func (h *Handler) handleExportTokens(c *gin.Context) {
 		return
 	}
 	
-	logger.Info("导出token配置", logger.Int("count", len(configs)))
-	
-	// 直接返回配置数组（JSON格式）
+	// 默认遮蔽 refreshToken 和 clientSecret，显式传 include_secrets=true 才导出明文
+	includeSecrets := c.Query("include_secrets") == "true"
+	if !includeSecrets {
+		masked := make([]auth.AuthConfig, len(configs))
+		for i, cfg := range configs {
+			cfg.RefreshToken = maskToken(cfg.RefreshToken)
+			cfg.ClientSecret = maskToken(cfg.ClientSecret)
+			masked[i] = cfg
+		}
+		configs = masked
+	}
+
+	logger.Info("导出token配置",
+		logger.Int("count", len(configs)),
+		logger.Bool("include_secrets", includeSecrets))
+
 	c.JSON(http.StatusOK, configs)
 }
 
@@ -454,11 +691,11 @@ func (h *Handler) handleRefreshAllTokens(c *gin.Context) {
 	})
 }
 
-// handleCleanupTokens 清理失效token（过期或已耗尽）
+// handleCleanupTokens 软删除失效token（过期或已耗尽）
 func (h *Handler) handleCleanupTokens(c *gin.Context) {
 	logger.Info("收到清理失效token请求")
 
-	removedCount, err := h.tokenManager.CleanupInvalidTokens()
+	removedIDs, err := h.tokenManager.CleanupInvalidTokens()
 	if err != nil {
 		logger.Error("清理token失败", logger.Err(err))
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -468,11 +705,15 @@ func (h *Handler) handleCleanupTokens(c *gin.Context) {
 		return
 	}
 
-	logger.Info("token清理完成", logger.Int("removed_count", removedCount))
+	logger.Info("token清理完成", logger.Int("removed_count", len(removedIDs)))
+	for _, tokenID := range removedIDs {
+		h.recordAdminAction(c, audit.AdminActionCleanup, tokenID)
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"success":       true,
 		"message":       "清理完成",
-		"removed_count": removedCount,
+		"removed_count": len(removedIDs),
+		"token_ids":     removedIDs,
 	})
 }
@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"kiro2api/auth"
+	"kiro2api/internal/audit"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleTokenTest_RequestErrors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	manager := auth.NewTokenManager([]auth.AuthConfig{{AuthType: auth.AuthMethodSocial, RefreshToken: "token"}})
+
+	tests := []struct {
+		name    string
+		handler *Handler
+		index   string
+		status  int
+	}{
+		{"token管理器未初始化", &Handler{}, "0", http.StatusServiceUnavailable},
+		{"索引不是数字", &Handler{tokenManager: manager}, "abc", http.StatusBadRequest},
+		{"索引越界", &Handler{tokenManager: manager}, "5", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPost, "/admin/tokens/"+tt.index+"/test", nil)
+			c.Params = gin.Params{{Key: "index", Value: tt.index}}
+
+			tt.handler.handleTokenTest(c)
+
+			assert.Equal(t, tt.status, w.Code)
+			assert.Contains(t, w.Body.String(), `"success":false`)
+		})
+	}
+}
+
+func TestHandleTokenRollback_WithoutTokenManager(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := serveAdminJSON(t, (&Handler{}).handleTokenRollback, http.MethodPost, "/admin/tokens/rollback", "")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), `"success":false`)
+}
+
+func serveAdminJSON(t *testing.T, handler gin.HandlerFunc, method, target, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(method, target, strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Request.Header.Set("X-Admin-Token", "admin-secret-abcd")
+	handler(c)
+	return w
+}
+
+func TestHandleTokenDeleteRestore_RecordsAdminActions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("CONFIG_DIR", t.TempDir())
+	t.Setenv("ADMIN_TOKEN", "admin-secret-abcd")
+
+	// 停用状态的token恢复时不会发起刷新请求
+	manager := auth.NewTokenManager([]auth.AuthConfig{{AuthType: auth.AuthMethodSocial, RefreshToken: "token", Disabled: true}})
+	h := &Handler{tokenManager: manager, adminLog: audit.NewAdminLog(10)}
+	tokenID := manager.GetCurrentConfigs()[0].TokenID
+
+	w := serveAdminJSON(t, h.handleTokenDelete, http.MethodPost, "/api/tokens/delete", `{"index":0}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), tokenID)
+	assert.True(t, manager.GetCurrentConfigs()[0].IsDeleted())
+
+	w = serveAdminJSON(t, h.handleTokenRestore, http.MethodPost, "/admin/tokens/restore", `{"token_id":"`+tokenID+`"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.False(t, manager.GetCurrentConfigs()[0].IsDeleted())
+
+	w = serveAdminJSON(t, h.handleTokenRestore, http.MethodPost, "/admin/tokens/restore", `{"token_id":"`+tokenID+`"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "未删除的token不能恢复")
+
+	w = serveAdminJSON(t, h.handleGetAdminAudit, http.MethodGet, "/admin/audit", "")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var resp adminAuditResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Entries, 2, "失败的操作不记录")
+	assert.Equal(t, audit.AdminActionRestore, resp.Entries[0].Action)
+	assert.Equal(t, audit.AdminActionDelete, resp.Entries[1].Action)
+	for _, entry := range resp.Entries {
+		assert.Equal(t, tokenID, entry.TokenID)
+		assert.Equal(t, "admin:***abcd", entry.Actor)
+		assert.False(t, entry.Timestamp.IsZero())
+	}
+}
+
+func TestHandleTokenPurge_RejectsInvalidDays(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("CONFIG_DIR", t.TempDir())
+
+	manager := auth.NewTokenManager([]auth.AuthConfig{{AuthType: auth.AuthMethodSocial, RefreshToken: "token"}})
+	h := &Handler{tokenManager: manager, adminLog: audit.NewAdminLog(10)}
+
+	w := serveAdminJSON(t, h.handleTokenPurge, http.MethodPost, "/admin/tokens/purge?days=-1", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = serveAdminJSON(t, h.handleTokenPurge, http.MethodPost, "/admin/tokens/purge?days=0", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"purged_count":0`)
+	assert.Len(t, manager.GetCurrentConfigs(), 1, "未删除的token不会被清除")
+}
+
+func TestHandleExportTokens_MasksSecretsByDefault(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("CONFIG_DIR", t.TempDir())
+
+	manager := auth.NewTokenManager([]auth.AuthConfig{{
+		AuthType:     auth.AuthMethodIdC,
+		RefreshToken: "refresh-token-secret-123456",
+		ClientID:     "client-id",
+		ClientSecret: "client-secret-abcdef",
+	}})
+	h := &Handler{tokenManager: manager}
+
+	var configs []auth.AuthConfig
+	w := serveAdminJSON(t, h.handleExportTokens, http.MethodGet, "/api/tokens/export", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &configs))
+	require.Len(t, configs, 1)
+	assert.Equal(t, "*********************123456", configs[0].RefreshToken)
+	assert.Equal(t, "**************abcdef", configs[0].ClientSecret)
+	assert.Equal(t, "client-id", configs[0].ClientID)
+	assert.NotContains(t, w.Body.String(), "refresh-token-secret")
+	assert.Equal(t, "refresh-token-secret-123456", manager.GetCurrentConfigs()[0].RefreshToken, "遮蔽不影响内存中的配置")
+
+	w = serveAdminJSON(t, h.handleExportTokens, http.MethodGet, "/api/tokens/export?include_secrets=true", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &configs))
+	assert.Equal(t, "refresh-token-secret-123456", configs[0].RefreshToken)
+	assert.Equal(t, "client-secret-abcdef", configs[0].ClientSecret)
+}
+
+func TestHandleTokenImportFormat_RejectsBadInput(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := &Handler{tokenManager: auth.NewTokenManager(nil)}
+
+	tests := []struct {
+		name    string
+		format  string
+		body    string
+		wantErr string
+	}{
+		{"不支持的格式", "pem", "-----BEGIN-----", "不支持的凭证格式: pem（支持 awscli, oidc, saml）"},
+		{"请求体为空", "oidc", "", "请求体为空"},
+		{"凭证无法解析", "saml", "<Assertion>", "解析saml凭证失败"},
+		{"配置无效", "awscli", "[p]\nrefresh_token = token\nauth = Unknown\n", "配置 #0: auth 必须是"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPost, "/admin/tokens/import/format/"+tt.format, strings.NewReader(tt.body))
+			c.Params = gin.Params{{Key: "format", Value: tt.format}}
+
+			h.handleTokenImportFormat(c)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Contains(t, w.Body.String(), tt.wantErr)
+		})
+	}
+	assert.Empty(t, h.tokenManager.GetCurrentConfigs(), "校验失败时不应写入任何配置")
+}
+
+func TestHandleTokenReload_DryRunReplaceRollback(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("CONFIG_DIR", t.TempDir())
+	t.Setenv("ADMIN_TOKEN", "admin-secret-abcd")
+
+	// 停用状态的配置导入后不会发起刷新请求
+	manager := auth.NewTokenManager([]auth.AuthConfig{{AuthType: auth.AuthMethodSocial, RefreshToken: "existing", Disabled: true}})
+	h := &Handler{tokenManager: manager, adminLog: audit.NewAdminLog(10)}
+	original := manager.GetCurrentConfigs()
+
+	body := `[{"auth":"Social","refreshToken":"existing"},{"auth":"Social","refreshToken":"new-token","disabled":true},{"auth":"Unknown","refreshToken":"bad"}]`
+	var resp tokenImportResponse
+
+	w := serveAdminJSON(t, h.handleTokenReload, http.MethodPost, "/api/tokens/reload?dry_run=true", body)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.True(t, resp.DryRun)
+	assert.Equal(t, 1, resp.WouldAdd)
+	require.Len(t, resp.Duplicates, 1)
+	assert.Equal(t, original[0].TokenID, resp.Duplicates[0].TokenID)
+	require.Len(t, resp.Invalid, 1)
+	assert.Equal(t, 2, resp.Invalid[0].Index)
+	assert.Equal(t, original, manager.GetCurrentConfigs(), "dry_run 不修改配置")
+
+	w = serveAdminJSON(t, h.handleTokenReload, http.MethodPost, "/api/tokens/reload", body)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "存在无效配置时整体拒绝")
+	assert.Contains(t, w.Body.String(), "配置 #2 无效")
+
+	w = serveAdminJSON(t, h.handleTokenReload, http.MethodPost, "/api/tokens/reload?replace=true", `[{"auth":"Social","refreshToken":"new-token","disabled":true}]`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 1, resp.Removed)
+	require.Len(t, resp.AddedIDs, 1)
+	configs := manager.GetCurrentConfigs()
+	require.Len(t, configs, 1)
+	assert.Equal(t, "new-token", configs[0].RefreshToken)
+
+	w = serveAdminJSON(t, h.handleTokenRollback, http.MethodPost, "/admin/tokens/rollback", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, original, manager.GetCurrentConfigs())
+
+	w = serveAdminJSON(t, h.handleTokenRollback, http.MethodPost, "/admin/tokens/rollback", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code, "只能回滚一次")
+
+	entries := h.adminLog.Entries(10)
+	require.Len(t, entries, 3)
+	assert.Equal(t, audit.AdminActionRollback, entries[0].Action)
+	assert.Equal(t, audit.AdminActionReload, entries[1].Action)
+	assert.Equal(t, resp.AddedIDs[0], entries[1].TokenID)
+	assert.Equal(t, audit.AdminActionReplace, entries[2].Action)
+}
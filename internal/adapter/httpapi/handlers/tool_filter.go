@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"net/http"
+	"sync"
+
+	"kiro2api/config"
+	"kiro2api/converter"
+	"kiro2api/internal/audit"
+	"kiro2api/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// toolFilterMutex 串行化黑白名单更新，保证持久化文件与内存中的配置一致
+var toolFilterMutex sync.Mutex
+
+// toolFilterResponse 工具黑白名单（列表始终非null）
+func toolFilterResponse(filter config.ToolFilter) config.ToolFilter {
+	if filter.Blacklist == nil {
+		filter.Blacklist = []string{}
+	}
+	if filter.Whitelist == nil {
+		filter.Whitelist = []string{}
+	}
+	return filter
+}
+
+// handleGetToolFilter 返回当前生效的工具黑白名单
+func (h *Handler) handleGetToolFilter(c *gin.Context) {
+	c.JSON(http.StatusOK, toolFilterResponse(converter.ActiveToolFilter()))
+}
+
+// handleUpdateToolFilter 热更新工具黑白名单并持久化，只影响之后到达的请求
+func (h *Handler) handleUpdateToolFilter(c *gin.Context) {
+	var filter config.ToolFilter
+	if err := c.ShouldBindJSON(&filter); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误: " + err.Error()})
+		return
+	}
+	filter = toolFilterResponse(filter)
+	if err := filter.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	toolFilterMutex.Lock()
+	defer toolFilterMutex.Unlock()
+
+	if err := config.SaveToolFilter(filter); err != nil {
+		logger.Error("保存工具黑白名单失败", logger.Err(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "保存工具黑白名单失败: " + err.Error()})
+		return
+	}
+	converter.SetToolFilter(filter)
+
+	logger.Info("工具黑白名单已更新",
+		logger.Any("blacklist", filter.Blacklist),
+		logger.Any("whitelist", filter.Whitelist))
+	h.recordAdminAction(c, audit.AdminActionToolFilter, "")
+
+	c.JSON(http.StatusOK, filter)
+}
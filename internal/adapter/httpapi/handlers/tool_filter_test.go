@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"kiro2api/config"
+	"kiro2api/converter"
+	"kiro2api/internal/audit"
+	"kiro2api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serveToolFilter(t *testing.T, h *Handler, method, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.GET("/admin/tools/filter", h.handleGetToolFilter)
+	router.PUT("/admin/tools/filter", h.handleUpdateToolFilter)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(method, "/admin/tools/filter", strings.NewReader(body)))
+	return w
+}
+
+// builtToolNames 构建请求并返回发往上游的工具名
+func builtToolNames(t *testing.T, b *converter.RequestBuilder) []string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+
+	cwReq, err := b.Build(types.AnthropicRequest{
+		Model:     "claude-sonnet-4",
+		MaxTokens: 100,
+		Messages:  []types.AnthropicRequestMessage{{Role: "user", Content: "hi"}},
+		Tools: []types.AnthropicTool{
+			{Name: "read_file", Description: "Read a file from disk", InputSchema: map[string]any{"type": "object"}},
+			{Name: "code_search", Description: "Search the codebase", InputSchema: map[string]any{"type": "object"}},
+		},
+	}, c)
+	require.NoError(t, err)
+
+	var names []string
+	for _, tool := range cwReq.ConversationState.CurrentMessage.UserInputMessage.UserInputMessageContext.Tools {
+		names = append(names, tool.ToolSpecification.Name)
+	}
+	return names
+}
+
+func TestHandleUpdateToolFilter(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "data", "tool_filter.json")
+	t.Setenv("TOOL_FILTER_FILE", file)
+	previous := converter.ActiveToolFilter()
+	t.Cleanup(func() { converter.SetToolFilter(previous) })
+	converter.SetToolFilter(config.ToolFilter{})
+
+	h := &Handler{adminLog: audit.NewAdminLog(10)}
+
+	// 更新前开始的请求持有旧配置的快照
+	inFlight := converter.NewRequestBuilder(converter.WithConversionCache(nil))
+
+	w := serveToolFilter(t, h, http.MethodPut, `{"blacklist":["web_search","*_search"],"whitelist":[]}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	assert.Equal(t, []string{"read_file"}, builtToolNames(t, converter.NewRequestBuilder(converter.WithConversionCache(nil))), "新请求立即使用新配置")
+	assert.Equal(t, []string{"read_file", "code_search"}, builtToolNames(t, inFlight), "进行中的请求使用旧配置完成")
+
+	// 已持久化，GET 返回当前配置
+	saved, err := os.ReadFile(file)
+	require.NoError(t, err)
+	var persisted config.ToolFilter
+	require.NoError(t, json.Unmarshal(saved, &persisted))
+	assert.Equal(t, config.ToolFilter{Blacklist: []string{"web_search", "*_search"}, Whitelist: []string{}}, persisted)
+
+	w = serveToolFilter(t, h, http.MethodGet, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"blacklist":["web_search","*_search"],"whitelist":[]}`, w.Body.String())
+
+	entries := h.adminLog.Entries(0)
+	require.Len(t, entries, 1)
+	assert.Equal(t, audit.AdminActionToolFilter, entries[0].Action)
+}
+
+func TestHandleUpdateToolFilter_InvalidPatternKeepsCurrentFilter(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "tool_filter.json")
+	t.Setenv("TOOL_FILTER_FILE", file)
+	previous := converter.ActiveToolFilter()
+	t.Cleanup(func() { converter.SetToolFilter(previous) })
+	converter.SetToolFilter(config.ToolFilter{Blacklist: []string{"web_search"}})
+
+	h := &Handler{adminLog: audit.NewAdminLog(10)}
+	for _, body := range []string{`{"blacklist":["[bad"]}`, `{"blacklist":`} {
+		w := serveToolFilter(t, h, http.MethodPut, body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+
+	assert.Equal(t, config.ToolFilter{Blacklist: []string{"web_search"}}, converter.ActiveToolFilter())
+	_, err := os.Stat(file)
+	assert.True(t, os.IsNotExist(err), "无效配置不应持久化")
+	assert.Empty(t, h.adminLog.Entries(0))
+}
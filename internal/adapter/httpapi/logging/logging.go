@@ -10,13 +10,22 @@ import (
 func AddFields(c *gin.Context, fields ...logger.Field) []logger.Field {
 	rid := srvcontext.GetRequestID(c)
 	mid := srvcontext.GetMessageID(c)
-	out := make([]logger.Field, 0, len(fields)+2)
+	out := make([]logger.Field, 0, len(fields)+4)
 	if rid != "" {
 		out = append(out, logger.String("request_id", rid))
 	}
 	if mid != "" {
 		out = append(out, logger.String("message_id", mid))
 	}
+	if tc, ok := srvcontext.GetTraceContext(c); ok {
+		out = append(out, logger.String("trace_id", tc.TraceID))
+	}
+	if key, ok := srvcontext.GetClientKey(c); ok {
+		out = append(out, logger.String("client_key", key.Name))
+	}
+	if adjustments := srvcontext.GetHistoryAdjustments(c); adjustments != "" {
+		out = append(out, logger.String("history_adjustments", adjustments))
+	}
 	out = append(out, fields...)
 	return out
 }
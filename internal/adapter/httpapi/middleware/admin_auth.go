@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/hex"
 	"net/http"
 	"os"
@@ -56,19 +57,8 @@ func AdminAuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		path := c.Request.URL.Path
-		
-		// API端点不需要管理员认证（使用各自的认证机制）
-		if strings.HasPrefix(path, "/v1/") {
-			c.Next()
-			return
-		}
-
-		// 登录相关路径和静态资源不需要认证
-		if path == "/api/admin/login" || 
-		   path == "/api/admin/status" || 
-		   path == "/login" ||
-		   strings.HasPrefix(path, "/static/") {  // 静态资源不需要认证
+		// API端点、登录相关路径和静态资源不需要管理员认证
+		if !IsDashboardAdminPath(c.Request.URL.Path) {
 			c.Next()
 			return
 		}
@@ -86,24 +76,71 @@ func AdminAuthMiddleware() gin.HandlerFunc {
 			expectedToken = currentAdminToken
 		}
 
-		if adminToken != expectedToken {
-			// Dashboard相关路径需要认证
-			if path == "/" || strings.HasPrefix(path, "/static/") || strings.HasPrefix(path, "/api/") {
-				// HTML页面请求：重定向到登录页
-				if c.GetHeader("Accept") != "" && strings.Contains(c.GetHeader("Accept"), "text/html") {
-					c.Redirect(http.StatusFound, "/login")
-					c.Abort()
-					return
-				}
-				
-				// API请求：返回401
-				c.JSON(http.StatusUnauthorized, gin.H{
-					"error":   "unauthorized",
-					"message": "需要管理员认证",
-				})
+		if !tokensEqual(adminToken, expectedToken) {
+			// HTML页面请求：重定向到登录页
+			if c.GetHeader("Accept") != "" && strings.Contains(c.GetHeader("Accept"), "text/html") {
+				c.Redirect(http.StatusFound, "/login")
 				c.Abort()
 				return
 			}
+
+			// API请求：返回401
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error":   "unauthorized",
+				"message": "需要管理员认证",
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// IsDashboardAdminPath 启用管理员Token时 AdminAuthMiddleware 要求认证的 Dashboard 路径：首页和 /api/*（登录与状态接口除外）
+func IsDashboardAdminPath(path string) bool {
+	if path == "/api/admin/login" || path == "/api/admin/status" {
+		return false
+	}
+	return path == "/" || strings.HasPrefix(path, "/api/")
+}
+
+// adminOnlyPrefixes 只接受管理员Token的路径前缀：管理接口，以及可读取或修改token、设置和进程状态的 Dashboard 接口
+var adminOnlyPrefixes = []string{"/admin/", "/api/tokens/", "/api/settings", "/api/system/"}
+
+// IsAdminOnlyPath 路径是否由 AdminRouteAuthMiddleware 强制要求管理员Token
+func IsAdminOnlyPath(path string) bool {
+	for _, prefix := range adminOnlyPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// AdminRouteAuthMiddleware 管理接口认证中间件（路径见 IsAdminOnlyPath），与客户端 KIRO_CLIENT_TOKEN 认证相互独立
+// 未配置 ADMIN_TOKEN 时这些接口整体关闭并返回404；否则必须携带管理员Token（X-Admin-Token 头或 admin_token cookie）
+func AdminRouteAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !IsAdminOnlyPath(c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+
+		if GetAdminToken() == "" {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		if !IsAdminRequest(c) {
+			logger.Warn("管理接口认证失败",
+				logger.String("path", c.Request.URL.Path),
+				logger.String("ip", c.ClientIP()))
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error":   "unauthorized",
+				"message": "需要管理员认证",
+			})
+			return
 		}
 
 		c.Next()
@@ -126,6 +163,46 @@ func GetAdminToken() string {
 	return token
 }
 
+// AdminActor 返回发起管理操作的key名，用于管理操作日志
+// 携带有效管理员Token时为 "admin:" 加Token末4位，否则为 anonymous
+func AdminActor(c *gin.Context) string {
+	adminToken, ok := validAdminToken(c)
+	if !ok {
+		return "anonymous"
+	}
+	return "admin:" + maskTokenSuffix(adminToken)
+}
+
+// IsAdminRequest 请求是否携带有效的管理员Token；未启用管理员Token时始终为false
+func IsAdminRequest(c *gin.Context) bool {
+	_, ok := validAdminToken(c)
+	return ok
+}
+
+// validAdminToken 取出请求携带的管理员Token（X-Admin-Token 头或 admin_token cookie）并校验
+func validAdminToken(c *gin.Context) (string, bool) {
+	adminToken := c.GetHeader("X-Admin-Token")
+	if adminToken == "" {
+		adminToken, _ = c.Cookie("admin_token")
+	}
+
+	if !MatchAdminToken(adminToken) {
+		return "", false
+	}
+	return adminToken, true
+}
+
+// MatchAdminToken 以常量时间比较 token 与当前管理员Token；未启用管理员Token时始终为false
+func MatchAdminToken(token string) bool {
+	expectedToken := GetAdminToken()
+	return expectedToken != "" && tokensEqual(token, expectedToken)
+}
+
+// tokensEqual 常量时间比较，避免通过响应耗时逐字节猜测Token
+func tokensEqual(provided, expected string) bool {
+	return subtle.ConstantTimeCompare([]byte(provided), []byte(expected)) == 1
+}
+
 // generateRandomToken 生成随机token
 func generateRandomToken(length int) string {
 	bytes := make([]byte, length)
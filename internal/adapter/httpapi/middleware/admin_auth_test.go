@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// serveAdminRoute 按 server.go 的顺序挂载客户端认证和管理接口认证后请求 /admin/audit
+func serveAdminRoute(t *testing.T, headers map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	return serveAdminPath(t, "/admin/audit", headers)
+}
+
+// serveAdminPath 同 serveAdminRoute，请求指定路径
+func serveAdminPath(t *testing.T, path string, headers map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	t.Setenv("KIRO_CLIENT_TOKEN", "client-token")
+
+	router := gin.New()
+	router.Use(AdminRouteAuthMiddleware())
+	router.Use(PathBasedAuthMiddleware("client-token", []string{"/v1"}))
+	router.GET(path, func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestAdminRouteAuthMiddleware_RejectsClientToken(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "admin-secret")
+
+	for name, headers := range map[string]map[string]string{
+		"Authorization": {"Authorization": "Bearer client-token"},
+		"x-api-key":     {"x-api-key": "client-token"},
+		"X-Admin-Token": {"X-Admin-Token": "client-token"},
+		"未携带":           {},
+	} {
+		w := serveAdminRoute(t, headers)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, name)
+	}
+}
+
+func TestAdminRouteAuthMiddleware_AllowsAdminToken(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "admin-secret")
+
+	w := serveAdminRoute(t, map[string]string{"X-Admin-Token": "admin-secret"})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = serveAdminRoute(t, map[string]string{"Cookie": "admin_token=admin-secret"})
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestAdminRouteAuthMiddleware_DisabledWithoutAdminToken(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "")
+
+	w := serveAdminRoute(t, map[string]string{"Authorization": "Bearer client-token"})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestAdminRouteAuthMiddleware_ProtectsDashboardAdminAPIs(t *testing.T) {
+	for _, path := range []string{"/api/tokens/export", "/api/tokens/reload", "/api/settings", "/api/system/restart"} {
+		t.Setenv("ADMIN_TOKEN", "")
+		assert.Equal(t, http.StatusNotFound, serveAdminPath(t, path, nil).Code, path)
+
+		t.Setenv("ADMIN_TOKEN", "admin-secret")
+		assert.Equal(t, http.StatusUnauthorized, serveAdminPath(t, path, map[string]string{"Authorization": "Bearer client-token"}).Code, path)
+		assert.Equal(t, http.StatusOK, serveAdminPath(t, path, map[string]string{"X-Admin-Token": "admin-secret"}).Code, path)
+	}
+
+	// Token池状态和登录接口不受影响
+	t.Setenv("ADMIN_TOKEN", "")
+	assert.Equal(t, http.StatusOK, serveAdminPath(t, "/api/tokens", nil).Code)
+	assert.Equal(t, http.StatusOK, serveAdminPath(t, "/api/admin/status", nil).Code)
+}
+
+func TestAdminRouteAuthMiddleware_SkipsClientRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("ADMIN_TOKEN", "admin-secret")
+
+	router := gin.New()
+	router.Use(AdminRouteAuthMiddleware())
+	router.GET("/v1/models", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/models", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestMatchAdminToken(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "admin-secret")
+	assert.True(t, MatchAdminToken("admin-secret"))
+	assert.False(t, MatchAdminToken("admin-secre"))
+	assert.False(t, MatchAdminToken(""))
+
+	t.Setenv("ADMIN_TOKEN", "")
+	assert.False(t, MatchAdminToken(""), "未启用管理员Token时不匹配任何值")
+}
@@ -1,15 +1,20 @@
 package middleware
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"net/http"
-	"os"
 	"strings"
 
+	"kiro2api/config"
+	"kiro2api/internal/adapter/httpapi/context"
+	"kiro2api/internal/adapter/httpapi/support"
 	"kiro2api/logger"
 
 	"github.com/gin-gonic/gin"
 )
 
+// PathBasedAuthMiddleware 校验受保护路径的客户端密钥，通过后把密钥信息写入请求上下文
 func PathBasedAuthMiddleware(authToken string, protectedPrefixes []string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		path := c.Request.URL.Path
@@ -19,22 +24,36 @@ func PathBasedAuthMiddleware(authToken string, protectedPrefixes []string) gin.H
 			return
 		}
 
-		// 🔥 热更新支持：动态读取环境变量
-		// 优先使用环境变量的最新值，fallback到启动时的token
-		currentToken := os.Getenv("KIRO_CLIENT_TOKEN")
-		if currentToken == "" {
-			currentToken = authToken // 使用启动时的值作为备用
+		// 🔥 热更新支持：动态读取环境变量（KIRO_CLIENT_TOKEN / KIRO_CLIENT_TOKENS）
+		// 均未配置时fallback到启动时的token
+		keys, err := config.ClientKeys()
+		if err != nil {
+			logger.Error("客户端密钥配置格式错误，仅接受 KIRO_CLIENT_TOKEN", logger.Err(err))
+		}
+		if len(keys) == 0 && authToken != "" {
+			keys = []config.ClientKey{{Key: authToken, Name: config.DefaultClientKeyName}}
 		}
 
-		if !validateAPIKey(c, currentToken) {
+		key, ok := validateAPIKey(c, keys)
+		if !ok {
 			c.Abort()
 			return
 		}
+		fingerprint := sha256.Sum256([]byte(key.Key))
+		context.SetClientKey(c, context.ClientKey{Name: key.Name, Tier: key.Tier, Tokens: key.Tokens, Fingerprint: hex.EncodeToString(fingerprint[:])})
 
 		c.Next()
 	}
 }
 
+// ClientAuthPrefixes 需要客户端密钥认证的路径前缀，server.go 注册 PathBasedAuthMiddleware 时使用
+var ClientAuthPrefixes = []string{"/v1"}
+
+// RequiresClientAuth 路径是否在 ClientAuthPrefixes 下，需要客户端密钥认证
+func RequiresClientAuth(path string) bool {
+	return requiresAuth(path, ClientAuthPrefixes)
+}
+
 func requiresAuth(path string, protectedPrefixes []string) bool {
 	for _, prefix := range protectedPrefixes {
 		if strings.HasPrefix(path, prefix) {
@@ -44,23 +63,35 @@ func requiresAuth(path string, protectedPrefixes []string) bool {
 	return false
 }
 
-func validateAPIKey(c *gin.Context, authToken string) bool {
+// validateAPIKey 在所有客户端密钥中查找与请求匹配的密钥（常量时间比较）
+func validateAPIKey(c *gin.Context, keys []config.ClientKey) (config.ClientKey, bool) {
 	providedAPIKey := extractAPIKey(c)
 	if providedAPIKey == "" {
 		logger.Warn("请求缺少Authorization或x-api-key头")
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "401"})
-		return false
+		respondUnauthorized(c, "请求缺少 API 密钥，请通过 Authorization: Bearer 或 x-api-key 提供")
+		return config.ClientKey{}, false
 	}
 
-	if providedAPIKey != authToken {
-		logger.Error("authToken验证失败",
-			logger.String("expected_suffix", maskTokenSuffix(authToken)),
-			logger.String("provided_suffix", maskTokenSuffix(providedAPIKey)))
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "401"})
-		return false
+	for _, key := range keys {
+		if tokensEqual(providedAPIKey, key.Key) {
+			return key, true
+		}
 	}
 
-	return true
+	logger.Error("authToken验证失败",
+		logger.Int("key_count", len(keys)),
+		logger.String("provided_suffix", maskTokenSuffix(providedAPIKey)))
+	respondUnauthorized(c, "API 密钥无效")
+	return config.ClientKey{}, false
+}
+
+// respondUnauthorized OpenAI 兼容路由返回 OpenAI 错误格式，其余路由保持原有响应
+func respondUnauthorized(c *gin.Context, message string) {
+	if support.IsOpenAIRoute(c) {
+		support.RespondErrorWithCode(c, http.StatusUnauthorized, "invalid_api_key", "%s", message)
+		return
+	}
+	c.JSON(http.StatusUnauthorized, gin.H{"error": "401"})
 }
 
 // maskTokenSuffix 只显示token的最后4位，用于调试
@@ -71,12 +102,25 @@ func maskTokenSuffix(token string) string {
 	return "***" + token[len(token)-4:]
 }
 
+// extractAPIKey 提取客户端提供的token，优先级：Authorization: Bearer > x-api-key > 不带scheme的Authorization（兼容旧客户端）
 func extractAPIKey(c *gin.Context) string {
-	apiKey := c.GetHeader("Authorization")
-	if apiKey == "" {
-		apiKey = c.GetHeader("x-api-key")
-	} else {
-		apiKey = strings.TrimPrefix(apiKey, "Bearer ")
+	authorization := strings.TrimSpace(c.GetHeader("Authorization"))
+	if token, ok := parseBearerToken(authorization); ok {
+		return token
+	}
+
+	if apiKey := strings.TrimSpace(c.GetHeader("x-api-key")); apiKey != "" {
+		return apiKey
+	}
+
+	return authorization
+}
+
+// parseBearerToken 解析 "Bearer <token>"，scheme 不区分大小写
+func parseBearerToken(authorization string) (string, bool) {
+	scheme, token, found := strings.Cut(authorization, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
 	}
-	return apiKey
+	return strings.TrimSpace(token), true
 }
@@ -1,10 +1,14 @@
 package middleware
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
+	"kiro2api/internal/adapter/httpapi/context"
+
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 )
@@ -64,3 +68,106 @@ func TestPathBasedAuthMiddleware_SkipsUnprotectedPath(t *testing.T) {
 
 	assert.Equal(t, http.StatusOK, w.Code)
 }
+
+// serveWithAuthHeaders 使用给定请求头访问受保护端点，返回状态码
+func serveWithAuthHeaders(t *testing.T, headers map[string]string) int {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	router := gin.New()
+	router.Use(PathBasedAuthMiddleware("test-token", []string{"/v1"}))
+	router.POST("/v1/messages", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	router.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestPathBasedAuthMiddleware_HeaderFormats(t *testing.T) {
+	t.Setenv("KIRO_CLIENT_TOKEN", "")
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    int
+	}{
+		{name: "Bearer", headers: map[string]string{"Authorization": "Bearer test-token"}, want: http.StatusOK},
+		{name: "小写bearer", headers: map[string]string{"Authorization": "bearer test-token"}, want: http.StatusOK},
+		{name: "x-api-key", headers: map[string]string{"x-api-key": "test-token"}, want: http.StatusOK},
+		{name: "x-api-key错误", headers: map[string]string{"x-api-key": "other-token"}, want: http.StatusUnauthorized},
+		{name: "都未提供", headers: nil, want: http.StatusUnauthorized},
+		{
+			name:    "Bearer优先于x-api-key",
+			headers: map[string]string{"Authorization": "Bearer test-token", "x-api-key": "other-token"},
+			want:    http.StatusOK,
+		},
+		{
+			name:    "Bearer错误时不回退到x-api-key",
+			headers: map[string]string{"Authorization": "Bearer other-token", "x-api-key": "test-token"},
+			want:    http.StatusUnauthorized,
+		},
+		{
+			name:    "非Bearer的Authorization时使用x-api-key",
+			headers: map[string]string{"Authorization": "Basic abc", "x-api-key": "test-token"},
+			want:    http.StatusOK,
+		},
+		{name: "不带scheme的Authorization", headers: map[string]string{"Authorization": "test-token"}, want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, serveWithAuthHeaders(t, tt.headers))
+		})
+	}
+}
+
+func TestPathBasedAuthMiddleware_XAPIKeyUsesCurrentClientToken(t *testing.T) {
+	t.Setenv("KIRO_CLIENT_TOKEN", "rotated-token")
+
+	assert.Equal(t, http.StatusOK, serveWithAuthHeaders(t, map[string]string{"x-api-key": "rotated-token"}))
+	assert.Equal(t, http.StatusUnauthorized, serveWithAuthHeaders(t, map[string]string{"x-api-key": "test-token"}))
+}
+
+func TestPathBasedAuthMiddleware_MultipleClientKeys(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("KIRO_CLIENT_TOKEN", "legacy-key")
+	t.Setenv("KIRO_CLIENT_TOKENS", `[{"key":"team-key","name":"team","tier":"pro","tokens":["tok-a"]},"other-key"]`)
+
+	serve := func(apiKey string) (int, context.ClientKey) {
+		var seen context.ClientKey
+		router := gin.New()
+		router.Use(PathBasedAuthMiddleware("startup-key", []string{"/v1"}))
+		router.POST("/v1/messages", func(c *gin.Context) {
+			seen, _ = context.GetClientKey(c)
+			c.Status(http.StatusOK)
+		})
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+		req.Header.Set("x-api-key", apiKey)
+		router.ServeHTTP(w, req)
+		return w.Code, seen
+	}
+
+	code, key := serve("team-key")
+	assert.Equal(t, http.StatusOK, code)
+	fingerprint := sha256.Sum256([]byte("team-key"))
+	assert.Equal(t, context.ClientKey{Name: "team", Tier: "pro", Tokens: []string{"tok-a"}, Fingerprint: hex.EncodeToString(fingerprint[:])}, key)
+
+	code, key = serve("other-key")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "key-2", key.Name)
+
+	code, key = serve("legacy-key")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "default", key.Name)
+
+	// 已配置密钥时启动时的token不再有效
+	code, _ = serve("startup-key")
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
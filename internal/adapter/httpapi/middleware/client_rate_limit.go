@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"kiro2api/config"
+	"kiro2api/internal/adapter/httpapi/context"
+	"kiro2api/internal/adapter/httpapi/support"
+	"kiro2api/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// clientRateBucket 单个客户端密钥的令牌桶，容量为每分钟请求数，按 rpm/60 每秒匀速补充
+type clientRateBucket struct {
+	rpm    int
+	tokens float64
+	last   time.Time
+}
+
+// clientRateLimiter 按客户端密钥名称限流
+type clientRateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*clientRateBucket
+	now     func() time.Time
+}
+
+func newClientRateLimiter(now func() time.Time) *clientRateLimiter {
+	return &clientRateLimiter{buckets: make(map[string]*clientRateBucket), now: now}
+}
+
+// allow 消耗一个令牌；令牌不足时返回需要等待的时间
+// 档位的每分钟请求数变化（热更新）时重置该密钥的令牌桶
+func (l *clientRateLimiter) allow(name string, rpm int) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	bucket, exists := l.buckets[name]
+	if !exists || bucket.rpm != rpm {
+		bucket = &clientRateBucket{rpm: rpm, tokens: float64(rpm), last: now}
+		l.buckets[name] = bucket
+	}
+
+	perSecond := float64(rpm) / 60
+	bucket.tokens = math.Min(float64(rpm), bucket.tokens+now.Sub(bucket.last).Seconds()*perSecond)
+	bucket.last = now
+
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / perSecond * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// sharedClientRateLimiter 进程内共用的令牌桶，服务路由与批处理的内部路由按同一个额度限流
+var sharedClientRateLimiter = newClientRateLimiter(time.Now)
+
+// ClientRateLimitMiddleware 按客户端密钥的限流档位（CLIENT_RATE_LIMIT_TIERS）限制请求速率
+// 只对已通过 PathBasedAuthMiddleware 认证的请求生效；未配置档位或档位不存在时不限流
+// 所有实例共用同一组令牌桶，批处理中的每个请求与直接请求一样计入密钥的额度
+func ClientRateLimitMiddleware() gin.HandlerFunc {
+	return clientRateLimitMiddleware(sharedClientRateLimiter)
+}
+
+func clientRateLimitMiddleware(limiter *clientRateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key, ok := context.GetClientKey(c)
+		if !ok || key.Tier == "" {
+			c.Next()
+			return
+		}
+
+		tiers, err := config.ClientRateLimitTiers()
+		if err != nil {
+			logger.Error("客户端限流档位配置错误，跳过限流", logger.Err(err))
+			c.Next()
+			return
+		}
+		rpm, exists := tiers[key.Tier]
+		if !exists {
+			c.Next()
+			return
+		}
+
+		allowed, wait := limiter.allow(key.Name, rpm)
+		if allowed {
+			c.Next()
+			return
+		}
+
+		seconds := int(math.Ceil(wait.Seconds()))
+		logger.Warn("客户端密钥超出限流档位",
+			logger.String("request_id", context.GetRequestID(c)),
+			logger.String("client_key", key.Name),
+			logger.String("tier", key.Tier),
+			logger.Int("rpm", rpm),
+			logger.Int("retry_after", seconds))
+		c.Header("Retry-After", strconv.Itoa(seconds))
+		support.RespondErrorWithCode(c, http.StatusTooManyRequests, "rate_limited", "客户端密钥 %s 超出限流档位 %s（每分钟 %d 次），请在 %d 秒后重试", key.Name, key.Tier, rpm, seconds)
+		c.Abort()
+	}
+}
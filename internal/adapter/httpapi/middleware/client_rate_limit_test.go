@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"kiro2api/internal/adapter/httpapi/context"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock 可手动推进的时钟
+type fakeClock struct{ now time.Time }
+
+func (f *fakeClock) Now() time.Time { return f.now }
+
+// newClientRateLimitRouter 跳过认证，直接按请求头 X-Test-Key 写入客户端密钥
+func newClientRateLimitRouter(clock *fakeClock, keys map[string]context.ClientKey) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		if key, ok := keys[c.GetHeader("X-Test-Key")]; ok {
+			context.SetClientKey(c, key)
+		}
+		c.Next()
+	})
+	router.Use(clientRateLimitMiddleware(newClientRateLimiter(clock.Now)))
+	router.POST("/v1/messages", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	return router
+}
+
+func serveAsClient(router *gin.Engine, name string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	req.Header.Set("X-Test-Key", name)
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestClientRateLimitMiddleware_PerKeyTiers(t *testing.T) {
+	t.Setenv("CLIENT_RATE_LIMIT_TIERS", `{"free":2,"pro":60}`)
+	clock := &fakeClock{now: time.Unix(1_700_000_000, 0)}
+	router := newClientRateLimitRouter(clock, map[string]context.ClientKey{
+		"free-a":    {Name: "free-a", Tier: "free"},
+		"free-b":    {Name: "free-b", Tier: "free"},
+		"pro":       {Name: "pro", Tier: "pro"},
+		"unlimited": {Name: "unlimited"},
+	})
+
+	// free 档每分钟2次：第3次被拒绝并返回 Retry-After
+	assert.Equal(t, http.StatusOK, serveAsClient(router, "free-a").Code)
+	assert.Equal(t, http.StatusOK, serveAsClient(router, "free-a").Code)
+	limited := serveAsClient(router, "free-a")
+	assert.Equal(t, http.StatusTooManyRequests, limited.Code)
+	assert.Equal(t, "30", limited.Header().Get("Retry-After"))
+	assert.Contains(t, limited.Body.String(), "rate_limited")
+
+	// 同档位的其他密钥有独立的令牌桶
+	assert.Equal(t, http.StatusOK, serveAsClient(router, "free-b").Code)
+
+	// 其他档位和未设置档位的密钥不受影响
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, http.StatusOK, serveAsClient(router, "pro").Code)
+		assert.Equal(t, http.StatusOK, serveAsClient(router, "unlimited").Code)
+	}
+
+	// 令牌按每分钟请求数匀速补充
+	clock.now = clock.now.Add(30 * time.Second)
+	assert.Equal(t, http.StatusOK, serveAsClient(router, "free-a").Code)
+	assert.Equal(t, http.StatusTooManyRequests, serveAsClient(router, "free-a").Code)
+}
+
+func TestClientRateLimitMiddleware_TierChangeResetsBucket(t *testing.T) {
+	t.Setenv("CLIENT_RATE_LIMIT_TIERS", `{"free":1}`)
+	clock := &fakeClock{now: time.Unix(1_700_000_000, 0)}
+	router := newClientRateLimitRouter(clock, map[string]context.ClientKey{
+		"free": {Name: "free", Tier: "free"},
+	})
+
+	assert.Equal(t, http.StatusOK, serveAsClient(router, "free").Code)
+	assert.Equal(t, http.StatusTooManyRequests, serveAsClient(router, "free").Code)
+
+	// 热更新提高限额后立即生效
+	t.Setenv("CLIENT_RATE_LIMIT_TIERS", `{"free":5}`)
+	assert.Equal(t, http.StatusOK, serveAsClient(router, "free").Code)
+
+	// 档位配置错误时不限流
+	t.Setenv("CLIENT_RATE_LIMIT_TIERS", `not-json`)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, http.StatusOK, serveAsClient(router, "free").Code)
+	}
+}
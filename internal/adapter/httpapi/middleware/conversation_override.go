@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"net/http"
+
+	"kiro2api/config"
+	"kiro2api/internal/adapter/httpapi/context"
+	"kiro2api/internal/adapter/httpapi/support"
+	"kiro2api/logger"
+	"kiro2api/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// conversationOverrideHeaders 客户端可以自定义的会话标识请求头及其校验函数
+var conversationOverrideHeaders = []struct {
+	name     string
+	validate func(string) error
+}{
+	{config.ConversationIDHeader, config.ValidateConversationOverride},
+	{config.AgentContinuationIDHeader, config.ValidateConversationOverride},
+	{config.ClientFingerprintHeader, config.ValidateClientFingerprint},
+}
+
+// ConversationOverrideMiddleware 校验客户端自定义的会话标识（X-Conversation-ID、X-Agent-Continuation-ID、X-Client-Fingerprint），
+// 不合法时返回400并指明请求头；CONVERSATION_NAMESPACE 启用时按客户端密钥改写为隔离后的值，
+// 不同密钥无法指定同一个上游会话。只处理 prefixes 下的路径，需在客户端认证之后执行
+func ConversationOverrideMiddleware(prefixes []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !requiresAuth(c.Request.URL.Path, prefixes) {
+			c.Next()
+			return
+		}
+		for _, header := range conversationOverrideHeaders {
+			value := c.GetHeader(header.name)
+			if value == "" {
+				continue
+			}
+			if err := header.validate(value); err != nil {
+				logger.Warn("拒绝无效的自定义会话ID",
+					logger.String("request_id", context.GetRequestID(c)),
+					logger.String("header", header.name),
+					logger.Int("length", len(value)))
+				support.RespondErrorWithCode(c, http.StatusBadRequest, "invalid_header", "请求头 %s 无效: %v", header.name, err)
+				c.Abort()
+				return
+			}
+			if !config.IsConversationNamespaceEnabled() {
+				continue
+			}
+			if key, ok := context.GetClientKey(c); ok {
+				c.Request.Header.Set(header.name, utils.NamespacedConversationID(key.Fingerprint, value))
+			}
+		}
+		c.Next()
+	}
+}
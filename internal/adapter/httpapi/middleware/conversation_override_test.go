@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"kiro2api/internal/adapter/httpapi/context"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testConversationID = "conv-0123456789abcdef"
+	testAgentID        = "3f2a9c1e-7b4d-4e8a-9c2f-1a2b3c4d5e6f"
+)
+
+// serveConversationOverride 以指定的客户端密钥发送请求，返回状态码、响应体和处理器看到的请求头
+func serveConversationOverride(t *testing.T, fingerprint, path string, headers map[string]string) (int, string, http.Header) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	var seen http.Header
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		context.SetClientKey(c, context.ClientKey{Name: "client", Fingerprint: fingerprint})
+	})
+	router.Use(ConversationOverrideMiddleware([]string{"/v1"}))
+	router.POST(path, func(c *gin.Context) {
+		seen = c.Request.Header.Clone()
+		c.Status(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, path, nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w.Code, w.Body.String(), seen
+}
+
+func TestConversationOverrideMiddleware_AcceptsValidIDs(t *testing.T) {
+	t.Setenv("CONVERSATION_NAMESPACE", "")
+
+	code, _, seen := serveConversationOverride(t, "fp-a", "/v1/messages", map[string]string{
+		"X-Conversation-ID":       testConversationID,
+		"X-Agent-Continuation-ID": testAgentID,
+		"X-Client-Fingerprint":    "team-a:laptop_01.dev",
+	})
+
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, testConversationID, seen.Get("X-Conversation-ID"), "未启用命名空间时原样使用")
+	assert.Equal(t, testAgentID, seen.Get("X-Agent-Continuation-ID"))
+	assert.Equal(t, "team-a:laptop_01.dev", seen.Get("X-Client-Fingerprint"))
+}
+
+func TestConversationOverrideMiddleware_RejectsInvalidIDs(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		value   string
+		message string
+	}{
+		{"格式无效", "X-Conversation-ID", "custom-conv-123", "格式无效"},
+		{"大写十六进制", "X-Conversation-ID", "conv-0123456789ABCDEF", "格式无效"},
+		{"过长", "X-Agent-Continuation-ID", strings.Repeat("a", 65), "长度不能超过64个字符"},
+		{"UUID后追加内容", "X-Agent-Continuation-ID", testAgentID + "-x", "格式无效"},
+		{"指纹包含空格", "X-Client-Fingerprint", "my laptop", "格式无效"},
+		{"指纹过长", "X-Client-Fingerprint", strings.Repeat("f", 129), "长度不能超过128个字符"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, body, seen := serveConversationOverride(t, "fp-a", "/v1/messages", map[string]string{tt.header: tt.value})
+			assert.Equal(t, http.StatusBadRequest, code)
+			assert.Contains(t, body, tt.header)
+			assert.Contains(t, body, tt.message)
+			assert.Nil(t, seen, "请求不应到达处理器")
+		})
+	}
+
+	// OpenAI 兼容路由返回 OpenAI 错误格式
+	code, body, _ := serveConversationOverride(t, "fp-a", "/v1/chat/completions", map[string]string{"X-Conversation-ID": "bad"})
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Contains(t, body, `"invalid_request_error"`)
+
+	// 非 /v1 路径不校验
+	code, _, _ = serveConversationOverride(t, "fp-a", "/api/tokens", map[string]string{"X-Conversation-ID": "bad"})
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestConversationOverrideMiddleware_NamespacesPerClientKey(t *testing.T) {
+	t.Setenv("CONVERSATION_NAMESPACE", "true")
+	headers := map[string]string{
+		"X-Conversation-ID":       testConversationID,
+		"X-Agent-Continuation-ID": testAgentID,
+		"X-Client-Fingerprint":    "laptop-01",
+	}
+
+	_, _, first := serveConversationOverride(t, "fp-a", "/v1/messages", headers)
+	_, _, again := serveConversationOverride(t, "fp-a", "/v1/messages", headers)
+	_, _, other := serveConversationOverride(t, "fp-b", "/v1/messages", headers)
+
+	convA := first.Get("X-Conversation-ID")
+	assert.NotEqual(t, testConversationID, convA)
+	assert.Equal(t, convA, again.Get("X-Conversation-ID"), "同一密钥的会话ID保持稳定")
+	assert.NotEqual(t, convA, other.Get("X-Conversation-ID"), "不同密钥指定相同ID不会指向同一会话")
+	assert.NotEqual(t, first.Get("X-Agent-Continuation-ID"), other.Get("X-Agent-Continuation-ID"))
+	assert.NotEqual(t, first.Get("X-Client-Fingerprint"), other.Get("X-Client-Fingerprint"), "不同密钥使用相同指纹也不会共享会话")
+	assert.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`, convA, "隔离后的ID仍是合法的上游会话ID")
+}
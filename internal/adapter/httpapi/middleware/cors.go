@@ -6,7 +6,8 @@ func CORSMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, x-api-key")
+		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, x-api-key, X-Admin-Token, X-Kiro-Header-Strategy, X-Kiro-Agent-Mode, X-Kiro-Strict-SSE, X-Kiro-No-Inject, X-Kiro-Profile, traceparent, tracestate")
+		c.Header("Access-Control-Expose-Headers", "X-Kiro-Context-Reduced, X-Kiro-History-Repaired, X-Kiro-History-Adjustments, X-Kiro-Warnings, X-Kiro-Truncated-Upstream, X-Kiro-Model-Fallback, X-Trace-ID")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(200)
@@ -0,0 +1,131 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"kiro2api/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// gzipWriterPool 复用gzip压缩器，避免每个流式响应重新分配压缩缓冲区
+var gzipWriterPool = sync.Pool{
+	New: func() any {
+		gz, _ := gzip.NewWriterLevel(io.Discard, config.SSEGzipLevel)
+		return gz
+	},
+}
+
+// SSEGzipMiddleware 对SSE响应做gzip压缩
+// 仅当客户端声明 Accept-Encoding: gzip 且响应 Content-Type 为 text/event-stream 时生效，
+// 其他响应原样输出；每次 Flush 都会先刷新gzip缓冲，事件不会滞留在压缩器中
+func SSEGzipMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !config.IsSSEGzipEnabled() || !acceptsGzip(c.GetHeader("Accept-Encoding")) {
+			c.Next()
+			return
+		}
+
+		original := c.Writer
+		w := &sseGzipWriter{ResponseWriter: original}
+		c.Writer = w
+		defer func() {
+			w.close()
+			c.Writer = original
+		}()
+
+		c.Next()
+	}
+}
+
+// acceptsGzip 判断 Accept-Encoding 是否接受gzip（q=0 表示拒绝）
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "x-gzip" {
+			continue
+		}
+		params = strings.TrimSpace(params)
+		if q, ok := strings.CutPrefix(params, "q="); ok {
+			if v, err := strconv.ParseFloat(strings.TrimSpace(q), 64); err == nil && v == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// sseGzipWriter 在首次写出时根据响应类型决定是否启用压缩
+type sseGzipWriter struct {
+	gin.ResponseWriter
+	gz      *gzip.Writer
+	decided bool
+}
+
+// decide 检查即将提交的响应头，SSE响应改为gzip编码
+func (w *sseGzipWriter) decide() {
+	if w.decided {
+		return
+	}
+	w.decided = true
+
+	header := w.ResponseWriter.Header()
+	if !strings.HasPrefix(header.Get("Content-Type"), "text/event-stream") || header.Get("Content-Encoding") != "" {
+		return
+	}
+
+	header.Set("Content-Encoding", "gzip")
+	header.Add("Vary", "Accept-Encoding")
+	header.Del("Content-Length")
+
+	w.gz = gzipWriterPool.Get().(*gzip.Writer)
+	w.gz.Reset(w.ResponseWriter)
+}
+
+func (w *sseGzipWriter) WriteHeaderNow() {
+	w.decide()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *sseGzipWriter) Write(data []byte) (int, error) {
+	w.decide()
+	if w.gz == nil {
+		return w.ResponseWriter.Write(data)
+	}
+	w.ResponseWriter.WriteHeaderNow()
+	return w.gz.Write(data)
+}
+
+func (w *sseGzipWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush 先把压缩器中的数据刷到底层连接，再刷新HTTP响应
+func (w *sseGzipWriter) Flush() {
+	w.decide()
+	if w.gz != nil {
+		_ = w.gz.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// close 写入gzip尾部并归还压缩器
+func (w *sseGzipWriter) close() {
+	if w.gz == nil {
+		return
+	}
+	_ = w.gz.Close()
+	w.ResponseWriter.Flush()
+	w.gz.Reset(io.Discard)
+	gzipWriterPool.Put(w.gz)
+	w.gz = nil
+}
+
+var _ http.Flusher = (*sseGzipWriter)(nil)
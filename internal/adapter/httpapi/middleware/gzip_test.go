@@ -0,0 +1,222 @@
+package middleware
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"kiro2api/internal/adapter/upstream/shared"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const sseTestProse = "The proxy converts upstream EventStream frames into Server-Sent Events so that " +
+	"standard Anthropic and OpenAI clients can consume them without modification. Each text delta " +
+	"carries only a handful of words, which means the event envelope repeats for every token and " +
+	"dominates the payload size. Compressing the stream removes most of that repetition, while " +
+	"flushing after every event keeps the perceived latency unchanged for interactive users. "
+
+// sendTypicalStream 按 Anthropic 流式格式发送一段文本较多的响应，每个 text_delta 约含一个短语
+func sendTypicalStream(c *gin.Context) {
+	c.Header("Content-Type", "text/event-stream; charset=utf-8")
+	c.Header("Cache-Control", "no-cache")
+
+	sender := &shared.AnthropicStreamSender{}
+	_ = sender.SendEvent(c, map[string]any{
+		"type": "message_start",
+		"message": map[string]any{
+			"id": "msg_test", "type": "message", "role": "assistant", "model": "claude-sonnet-4",
+			"content": []any{}, "usage": map[string]any{"input_tokens": 42, "output_tokens": 1},
+		},
+	})
+	_ = sender.SendEvent(c, map[string]any{
+		"type": "content_block_start", "index": 0,
+		"content_block": map[string]any{"type": "text", "text": ""},
+	})
+
+	words := strings.Fields(strings.Repeat(sseTestProse, 4))
+	for i := 0; i < len(words); i += 3 {
+		chunk := strings.Join(words[i:min(i+3, len(words))], " ") + " "
+		_ = sender.SendEvent(c, map[string]any{
+			"type": "content_block_delta", "index": 0,
+			"delta": map[string]any{"type": "text_delta", "text": chunk},
+		})
+	}
+
+	_ = sender.SendEvent(c, map[string]any{"type": "content_block_stop", "index": 0})
+	_ = sender.SendEvent(c, map[string]any{
+		"type":  "message_delta",
+		"delta": map[string]any{"stop_reason": "end_turn"},
+		"usage": map[string]any{"output_tokens": 120},
+	})
+	_ = sender.SendEvent(c, map[string]any{"type": "message_stop"})
+}
+
+func newGzipTestRouter(handler gin.HandlerFunc) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(SSEGzipMiddleware())
+	router.GET("/stream", handler)
+	return router
+}
+
+// recordStream 返回写到连接上的原始字节与响应头
+func recordStream(t testing.TB, router *gin.Engine, acceptEncoding string) (*httptest.ResponseRecorder, []byte) {
+	req := httptest.NewRequest(http.MethodGet, "/stream", nil)
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w, w.Body.Bytes()
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	assert.True(t, acceptsGzip("gzip"))
+	assert.True(t, acceptsGzip("br, gzip, deflate"))
+	assert.True(t, acceptsGzip("GZIP;q=0.5"))
+	assert.True(t, acceptsGzip("x-gzip"))
+	assert.False(t, acceptsGzip(""))
+	assert.False(t, acceptsGzip("br, deflate"))
+	assert.False(t, acceptsGzip("gzip;q=0"))
+}
+
+func TestSSEGzip_StandardClientDecompresses(t *testing.T) {
+	server := httptest.NewServer(newGzipTestRouter(sendTypicalStream))
+	defer server.Close()
+
+	_, plain := recordStream(t, newGzipTestRouter(sendTypicalStream), "")
+
+	// 默认Transport会自动声明 Accept-Encoding: gzip 并透明解压
+	resp, err := http.Get(server.URL + "/stream")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.True(t, resp.Uncompressed, "标准客户端应收到gzip编码并自动解压")
+	assert.Equal(t, "text/event-stream; charset=utf-8", resp.Header.Get("Content-Type"))
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, string(plain), string(body))
+
+	var events []string
+	scanner := bufio.NewScanner(strings.NewReader(string(body)))
+	for scanner.Scan() {
+		if name, ok := strings.CutPrefix(scanner.Text(), "event: "); ok {
+			events = append(events, name)
+		}
+	}
+	require.NotEmpty(t, events)
+	assert.Equal(t, "message_start", events[0])
+	assert.Equal(t, "message_stop", events[len(events)-1])
+}
+
+func TestSSEGzip_FlushesEachEvent(t *testing.T) {
+	release := make(chan struct{})
+	router := newGzipTestRouter(func(c *gin.Context) {
+		c.Header("Content-Type", "text/event-stream")
+		fmt.Fprint(c.Writer, "event: ping\ndata: {\"type\":\"ping\"}\n\n")
+		c.Writer.Flush()
+		<-release
+		fmt.Fprint(c.Writer, "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n")
+		c.Writer.Flush()
+	})
+	server := httptest.NewServer(router)
+	defer server.Close()
+	defer func() {
+		select {
+		case <-release:
+		default:
+			close(release)
+		}
+	}()
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/stream", nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+
+	// 处理器仍阻塞时，第一个事件就必须能被完整解压读出
+	gz, err := gzip.NewReader(resp.Body)
+	require.NoError(t, err)
+	reader := bufio.NewReader(gz)
+	for _, want := range []string{"event: ping\n", "data: {\"type\":\"ping\"}\n", "\n"} {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, want, line)
+	}
+
+	close(release)
+	rest, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n", string(rest))
+}
+
+func TestSSEGzip_SkipsOtherResponses(t *testing.T) {
+	t.Run("客户端不接受gzip", func(t *testing.T) {
+		w, body := recordStream(t, newGzipTestRouter(sendTypicalStream), "")
+		assert.Empty(t, w.Header().Get("Content-Encoding"))
+		assert.Contains(t, string(body), "event: message_start")
+	})
+
+	t.Run("q=0", func(t *testing.T) {
+		w, _ := recordStream(t, newGzipTestRouter(sendTypicalStream), "gzip;q=0")
+		assert.Empty(t, w.Header().Get("Content-Encoding"))
+	})
+
+	t.Run("非SSE响应", func(t *testing.T) {
+		router := newGzipTestRouter(func(c *gin.Context) {
+			c.JSON(http.StatusOK, gin.H{"type": "message"})
+		})
+		w, body := recordStream(t, router, "gzip")
+		assert.Empty(t, w.Header().Get("Content-Encoding"))
+		assert.JSONEq(t, `{"type":"message"}`, string(body))
+	})
+
+	t.Run("SSE_GZIP关闭", func(t *testing.T) {
+		t.Setenv("SSE_GZIP", "false")
+		w, _ := recordStream(t, newGzipTestRouter(sendTypicalStream), "gzip")
+		assert.Empty(t, w.Header().Get("Content-Encoding"))
+	})
+}
+
+func TestSSEGzip_ReducesWireBytes(t *testing.T) {
+	router := newGzipTestRouter(sendTypicalStream)
+	_, plain := recordStream(t, router, "")
+	w, compressed := recordStream(t, router, "gzip")
+
+	require.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+
+	reduction := 1 - float64(len(compressed))/float64(len(plain))
+	t.Logf("SSE原始 %d 字节，gzip后 %d 字节，减少 %.1f%%", len(plain), len(compressed), reduction*100)
+	assert.GreaterOrEqual(t, reduction, 0.60, "逐事件刷新时gzip仍应减少至少60%%的传输字节")
+}
+
+func BenchmarkSSEGzip(b *testing.B) {
+	router := newGzipTestRouter(sendTypicalStream)
+	_, plain := recordStream(b, router, "")
+
+	var compressedBytes int
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, compressed := recordStream(b, router, "gzip")
+		compressedBytes = len(compressed)
+	}
+	b.StopTimer()
+
+	b.ReportMetric(float64(len(plain)), "plain_bytes")
+	b.ReportMetric(float64(compressedBytes), "wire_bytes")
+	b.ReportMetric(100*(1-float64(compressedBytes)/float64(len(plain))), "reduction_%")
+}
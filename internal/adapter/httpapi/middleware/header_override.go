@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"kiro2api/config"
+	"kiro2api/internal/adapter/httpapi/context"
+	"kiro2api/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// agentModePattern 允许的 agent mode 取值，避免把任意内容写进上游请求头
+var agentModePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,31}$`)
+
+// HeaderOverrideMiddleware 解析管理员调试用的单次请求覆盖（X-Kiro-Header-Strategy、X-Kiro-Agent-Mode、X-Kiro-No-Inject、X-Kiro-Profile）
+// 只有携带有效管理员Token时才生效；未授权或取值无效时忽略覆盖，请求照常处理
+func HeaderOverrideMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		strategy := strings.ToLower(strings.TrimSpace(c.GetHeader(config.HeaderStrategyOverrideHeader)))
+		agentMode := strings.TrimSpace(c.GetHeader(config.AgentModeOverrideHeader))
+		noInject, _ := strconv.ParseBool(strings.TrimSpace(c.GetHeader(config.NoInjectHeader)))
+		profile := strings.TrimSpace(c.GetHeader(config.ProfileHeader))
+		if strategy == "" && agentMode == "" && !noInject && profile == "" {
+			c.Next()
+			return
+		}
+
+		if !IsAdminRequest(c) {
+			logger.Warn("忽略未授权的请求头覆盖",
+				logger.String("request_id", context.GetRequestID(c)),
+				logger.String("header_strategy", strategy),
+				logger.String("agent_mode", agentMode),
+				logger.Bool("no_inject", noInject),
+				logger.String("profile", profile))
+			c.Next()
+			return
+		}
+
+		overrides := context.HeaderOverrides{NoInject: noInject, Profile: profile}
+		switch strategy {
+		case "":
+		case config.HeaderOverrideKiro, config.HeaderOverrideRandom, config.HeaderOverrideLegacy:
+			overrides.Strategy = strategy
+		default:
+			logger.Warn("忽略无效的请求头策略覆盖",
+				logger.String("request_id", context.GetRequestID(c)),
+				logger.String("header_strategy", strategy))
+		}
+		if agentMode != "" {
+			if agentModePattern.MatchString(agentMode) {
+				overrides.AgentMode = agentMode
+			} else {
+				logger.Warn("忽略无效的agent mode覆盖",
+					logger.String("request_id", context.GetRequestID(c)),
+					logger.String("agent_mode", agentMode))
+			}
+		}
+
+		context.SetHeaderOverrides(c, overrides)
+		c.Next()
+	}
+}
@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"kiro2api/config"
+	"kiro2api/internal/adapter/httpapi/context"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func serveHeaderOverride(t *testing.T, headers map[string]string) context.HeaderOverrides {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	var got context.HeaderOverrides
+	router := gin.New()
+	router.Use(HeaderOverrideMiddleware())
+	router.POST("/v1/messages", func(c *gin.Context) {
+		got = context.GetHeaderOverrides(c)
+		c.Status(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code, "覆盖无效时请求照常处理")
+	return got
+}
+
+func TestHeaderOverrideMiddleware_AppliesWithAdminToken(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "admin-secret")
+
+	got := serveHeaderOverride(t, map[string]string{
+		"X-Admin-Token":                     "admin-secret",
+		config.HeaderStrategyOverrideHeader: "Random",
+		config.AgentModeOverrideHeader:      "spec",
+	})
+
+	assert.Equal(t, context.HeaderOverrides{Strategy: config.HeaderOverrideRandom, AgentMode: "spec"}, got)
+}
+
+func TestHeaderOverrideMiddleware_IgnoredWithoutAdminToken(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "admin-secret")
+
+	for name, adminToken := range map[string]string{"missing": "", "wrong": "other"} {
+		t.Run(name, func(t *testing.T) {
+			headers := map[string]string{
+				config.HeaderStrategyOverrideHeader: "legacy",
+				config.AgentModeOverrideHeader:      "spec",
+			}
+			if adminToken != "" {
+				headers["X-Admin-Token"] = adminToken
+			}
+			assert.Equal(t, context.HeaderOverrides{}, serveHeaderOverride(t, headers))
+		})
+	}
+}
+
+func TestHeaderOverrideMiddleware_IgnoresInvalidValues(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "admin-secret")
+
+	got := serveHeaderOverride(t, map[string]string{
+		"X-Admin-Token":                     "admin-secret",
+		config.HeaderStrategyOverrideHeader: "real_simulation",
+		config.AgentModeOverrideHeader:      "spec\r\nX-Injected: 1",
+	})
+
+	assert.Equal(t, context.HeaderOverrides{}, got)
+}
+
+func TestHeaderOverrideMiddleware_NoInjectRequiresAdminToken(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "admin-secret")
+
+	got := serveHeaderOverride(t, map[string]string{
+		"X-Admin-Token":       "admin-secret",
+		config.NoInjectHeader: "true",
+	})
+	assert.Equal(t, context.HeaderOverrides{NoInject: true}, got)
+
+	got = serveHeaderOverride(t, map[string]string{config.NoInjectHeader: "true"})
+	assert.False(t, got.NoInject, "未携带管理员Token时不能跳过注入")
+}
+
+func TestHeaderOverrideMiddleware_ProfileRequiresAdminToken(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "admin-secret")
+
+	got := serveHeaderOverride(t, map[string]string{
+		"X-Admin-Token":      "admin-secret",
+		config.ProfileHeader: " opus ",
+	})
+	assert.Equal(t, context.HeaderOverrides{Profile: "opus"}, got)
+
+	got = serveHeaderOverride(t, map[string]string{config.ProfileHeader: "opus"})
+	assert.Empty(t, got.Profile, "未携带管理员Token时不能指定profile")
+}
@@ -0,0 +1,131 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strings"
+
+	"kiro2api/internal/adapter/httpapi/context"
+	logutil "kiro2api/internal/adapter/httpapi/logging"
+	"kiro2api/internal/adapter/httpapi/support"
+	"kiro2api/internal/stats"
+	"kiro2api/logger"
+	"kiro2api/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// panicErrorMessage 返回给客户端的错误信息，不暴露panic内容
+const panicErrorMessage = "服务内部错误"
+
+// RecoveryMiddleware 恢复handler中的panic，按路由返回对应协议的错误
+// 尚未写出响应时：/v1/chat/completions 返回 OpenAI 错误格式，其余 /v1/* 返回 Anthropic 错误格式（api_error），
+// 其他路由返回通用错误格式；SSE响应已开始时补发 error 事件和结束事件（message_stop 或 [DONE]），
+// 流已发送过结束事件时不再重复发送
+// 需注册在 SSEGzipMiddleware 之后，补发的事件与之前的响应经过同一个压缩器
+func RecoveryMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+			// 由 net/http 处理的中止信号，不作为错误
+			if err, ok := r.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+				panic(r)
+			}
+
+			route := c.FullPath()
+			if route == "" {
+				route = c.Request.URL.Path
+			}
+			stats.GetPanicCounter().Record(route)
+			logger.Error("请求处理panic",
+				logutil.AddFields(c,
+					logger.String("route", route),
+					logger.Any("panic", r),
+					logger.String("stack", string(debug.Stack())),
+				)...)
+
+			respondPanic(c)
+			c.Abort()
+		}()
+
+		c.Next()
+	}
+}
+
+// respondPanic 按响应是否已开始和路由写出错误
+func respondPanic(c *gin.Context) {
+	if !c.Writer.Written() {
+		respondPanicError(c)
+		return
+	}
+	if !strings.HasPrefix(c.Writer.Header().Get("Content-Type"), "text/event-stream") || context.IsStreamEnded(c) {
+		return
+	}
+
+	// 写出失败说明客户端已断开，无需处理
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error("panic后结束SSE流失败", logutil.AddFields(c, logger.Any("panic", r))...)
+		}
+	}()
+	if support.IsOpenAIRoute(c) {
+		writeOpenAIPanicEvents(c)
+	} else {
+		writeAnthropicPanicEvents(c)
+	}
+	c.Writer.Flush()
+	context.MarkStreamEnded(c)
+}
+
+// respondPanicError 响应尚未写出时返回500错误体，消息附带请求ID
+func respondPanicError(c *gin.Context) {
+	requestID := context.GetRequestID(c)
+	switch {
+	case support.IsOpenAIRoute(c):
+		support.RespondOpenAIError(c, http.StatusInternalServerError, "internal_error", panicErrorMessage)
+	case strings.HasPrefix(c.Request.URL.Path, "/v1/"):
+		c.JSON(http.StatusInternalServerError, anthropicPanicError(requestID))
+	default:
+		support.RespondError(c, http.StatusInternalServerError, "%s (request id: %s)", panicErrorMessage, requestID)
+	}
+}
+
+// anthropicPanicError Anthropic 格式的 api_error，与官方API一样在顶层附带 request_id
+func anthropicPanicError(requestID string) map[string]any {
+	return map[string]any{
+		"type": "error",
+		"error": map[string]any{
+			"type":    "api_error",
+			"message": fmt.Sprintf("%s (request id: %s)", panicErrorMessage, requestID),
+		},
+		"request_id": requestID,
+	}
+}
+
+// writeAnthropicPanicEvents 发送 error 事件和 message_stop
+func writeAnthropicPanicEvents(c *gin.Context) {
+	for _, event := range []map[string]any{
+		anthropicPanicError(context.GetRequestID(c)),
+		{"type": "message_stop"},
+	} {
+		data, err := utils.SafeMarshal(event)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", event["type"], data)
+	}
+}
+
+// writeOpenAIPanicEvents 发送 OpenAI 格式的错误块和 [DONE]
+func writeOpenAIPanicEvents(c *gin.Context) {
+	resp := support.NewOpenAIErrorResponse(http.StatusInternalServerError, "internal_error", panicErrorMessage, context.GetRequestID(c))
+	if data, err := utils.SafeMarshal(resp); err == nil {
+		fmt.Fprintf(c.Writer, "data: %s\n\n", data)
+	}
+	fmt.Fprint(c.Writer, "data: [DONE]\n\n")
+}
@@ -0,0 +1,129 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"kiro2api/internal/adapter/httpapi/support"
+	"kiro2api/internal/adapter/upstream/shared"
+	"kiro2api/internal/stats"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newRecoveryTestEngine 注册会panic的handler：stream 为 true 时先输出部分SSE事件再panic
+func newRecoveryTestEngine(stream bool) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(RequestIDMiddleware(), RecoveryMiddleware())
+
+	handler := func(c *gin.Context) {
+		if stream {
+			c.Header("Content-Type", "text/event-stream; charset=utf-8")
+			if support.IsOpenAIRoute(c) {
+				_ = (&shared.OpenAIStreamSender{}).SendEvent(c, map[string]any{"object": "chat.completion.chunk"})
+			} else {
+				_ = (&shared.AnthropicStreamSender{}).SendEvent(c, map[string]any{"type": "message_start"})
+			}
+		}
+		panic("boom")
+	}
+	r.POST("/v1/messages", handler)
+	r.POST(support.OpenAIChatCompletionsPath, handler)
+	r.GET("/admin/stats", handler)
+	return r
+}
+
+func serveRecoveryTest(r *gin.Engine, method, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("X-Request-ID", "req_panic")
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestRecoveryMiddleware_NonStream(t *testing.T) {
+	r := newRecoveryTestEngine(false)
+
+	t.Run("Anthropic路由返回api_error", func(t *testing.T) {
+		w := serveRecoveryTest(r, http.MethodPost, "/v1/messages")
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.JSONEq(t, `{"type":"error","error":{"type":"api_error","message":"服务内部错误 (request id: req_panic)"},"request_id":"req_panic"}`, w.Body.String())
+	})
+
+	t.Run("OpenAI路由返回OpenAI错误格式", func(t *testing.T) {
+		w := serveRecoveryTest(r, http.MethodPost, support.OpenAIChatCompletionsPath)
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.JSONEq(t, `{"error":{"message":"服务内部错误 (request id: req_panic)","type":"server_error","param":null,"code":"internal_error"}}`, w.Body.String())
+	})
+
+	t.Run("其他路由返回通用错误格式", func(t *testing.T) {
+		w := serveRecoveryTest(r, http.MethodGet, "/admin/stats")
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.JSONEq(t, `{"error":{"message":"服务内部错误 (request id: req_panic)","code":"internal_error"}}`, w.Body.String())
+	})
+}
+
+func TestRecoveryMiddleware_Stream(t *testing.T) {
+	r := newRecoveryTestEngine(true)
+
+	t.Run("Anthropic流补发error和message_stop", func(t *testing.T) {
+		w := serveRecoveryTest(r, http.MethodPost, "/v1/messages")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "event: message_start\ndata: {\"type\":\"message_start\"}\n\n"+
+			"event: error\ndata: {\"error\":{\"message\":\"服务内部错误 (request id: req_panic)\",\"type\":\"api_error\"},\"request_id\":\"req_panic\",\"type\":\"error\"}\n\n"+
+			"event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n", w.Body.String())
+	})
+
+	t.Run("OpenAI流补发错误块和DONE", func(t *testing.T) {
+		w := serveRecoveryTest(r, http.MethodPost, support.OpenAIChatCompletionsPath)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "data: {\"object\":\"chat.completion.chunk\"}\n\n"+
+			"data: {\"error\":{\"message\":\"服务内部错误 (request id: req_panic)\",\"type\":\"server_error\",\"param\":null,\"code\":\"internal_error\"}}\n\n"+
+			"data: [DONE]\n\n", w.Body.String())
+	})
+}
+
+func TestRecoveryMiddleware_StreamAlreadyEnded(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(RecoveryMiddleware())
+	r.POST("/v1/messages", func(c *gin.Context) {
+		c.Header("Content-Type", "text/event-stream; charset=utf-8")
+		sender := &shared.AnthropicStreamSender{}
+		_ = sender.SendEvent(c, map[string]any{"type": "message_start"})
+		_ = sender.SendEvent(c, map[string]any{"type": "message_stop"})
+		panic("after stop")
+	})
+
+	w := serveRecoveryTest(r, http.MethodPost, "/v1/messages")
+	assert.Equal(t, "event: message_start\ndata: {\"type\":\"message_start\"}\n\n"+
+		"event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n", w.Body.String())
+}
+
+func TestRecoveryMiddleware_CountsPanics(t *testing.T) {
+	r := newRecoveryTestEngine(false)
+	before := stats.GetPanicCounter().Snapshot()
+
+	serveRecoveryTest(r, http.MethodPost, "/v1/messages")
+	serveRecoveryTest(r, http.MethodPost, support.OpenAIChatCompletionsPath)
+
+	after := stats.GetPanicCounter().Snapshot()
+	assert.Equal(t, before.Total+2, after.Total)
+	assert.Equal(t, before.ByRoute["/v1/messages"]+1, after.ByRoute["/v1/messages"])
+}
+
+func TestRecoveryMiddleware_RepanicsAbortHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(RecoveryMiddleware())
+	r.GET("/abort", func(c *gin.Context) { panic(http.ErrAbortHandler) })
+
+	defer func() {
+		require.Equal(t, http.ErrAbortHandler, recover(), "ErrAbortHandler 应交给 net/http 处理")
+	}()
+	serveRecoveryTest(r, http.MethodGet, "/abort")
+}
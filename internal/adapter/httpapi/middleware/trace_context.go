@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"strings"
+
+	"kiro2api/config"
+	"kiro2api/internal/adapter/httpapi/context"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TraceContextMiddleware 读取 W3C traceparent/tracestate 请求头，沿用客户端的追踪ID并为本服务生成新的span；
+// 未携带或格式不合法时开始新的追踪（此时丢弃 tracestate）。追踪ID通过 X-Trace-ID 响应头返回
+func TraceContextMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tc, ok := context.ParseTraceParent(c.GetHeader(config.TraceParentHeader))
+		if ok {
+			tc.SpanID = context.NewSpanID()
+			// 多个 tracestate 请求头按规范合并为一个列表
+			if state := strings.Join(c.Request.Header.Values(config.TraceStateHeader), ","); len(state) <= config.TraceStateMaxLength {
+				tc.TraceState = state
+			}
+		} else {
+			tc = context.NewTraceContext()
+		}
+		context.SetTraceContext(c, tc)
+		c.Next()
+	}
+}
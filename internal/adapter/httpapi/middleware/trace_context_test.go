@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"kiro2api/internal/adapter/httpapi/context"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serveTraceContext(t *testing.T, header http.Header) (context.TraceContext, *httptest.ResponseRecorder) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	var got context.TraceContext
+	router := gin.New()
+	router.Use(TraceContextMiddleware())
+	router.POST("/v1/messages", func(c *gin.Context) {
+		var ok bool
+		got, ok = context.GetTraceContext(c)
+		require.True(t, ok)
+		c.Status(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	req.Header = header
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return got, w
+}
+
+func TestTraceContextMiddleware_ContinuesClientTrace(t *testing.T) {
+	header := http.Header{}
+	header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	header.Add("tracestate", "congo=t61rcWkgMzE")
+	header.Add("tracestate", "rojo=00f067aa0ba902b7")
+
+	tc, w := serveTraceContext(t, header)
+
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", tc.TraceID)
+	assert.Equal(t, "00f067aa0ba902b7", tc.ParentSpanID)
+	assert.Len(t, tc.SpanID, 16)
+	assert.NotEqual(t, tc.ParentSpanID, tc.SpanID)
+	assert.Equal(t, "00", tc.Flags, "沿用客户端的采样标志")
+	assert.Equal(t, "congo=t61rcWkgMzE,rojo=00f067aa0ba902b7", tc.TraceState)
+	assert.Equal(t, tc.TraceID, w.Header().Get("X-Trace-ID"))
+}
+
+func TestTraceContextMiddleware_StartsNewTrace(t *testing.T) {
+	for name, traceParent := range map[string]string{"missing": "", "invalid": "00-xyz-00f067aa0ba902b7-01"} {
+		t.Run(name, func(t *testing.T) {
+			header := http.Header{}
+			if traceParent != "" {
+				header.Set("traceparent", traceParent)
+			}
+			header.Set("tracestate", "congo=t61rcWkgMzE")
+
+			tc, w := serveTraceContext(t, header)
+
+			assert.Len(t, tc.TraceID, 32)
+			assert.Empty(t, tc.ParentSpanID)
+			assert.Equal(t, "01", tc.Flags)
+			assert.Empty(t, tc.TraceState, "traceparent无效时丢弃tracestate")
+			assert.Equal(t, tc.TraceID, w.Header().Get("X-Trace-ID"))
+		})
+	}
+
+	// 过长的 tracestate 不转发
+	header := http.Header{}
+	header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	header.Set("tracestate", "k="+strings.Repeat("v", 600))
+	tc, _ := serveTraceContext(t, header)
+	assert.Empty(t, tc.TraceState)
+}
@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"kiro2api/config"
+	"kiro2api/internal/adapter/httpapi/context"
+	logutil "kiro2api/internal/adapter/httpapi/logging"
+	"kiro2api/internal/audit"
+	"kiro2api/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UpstreamHeaderLogMiddleware LOG_UPSTREAM_HEADERS 启用时，请求结束后输出本次请求发往上游的请求头和上游响应头，
+// 并保存到最近请求记录中供 GET /admin/requests/:id/headers 查询；敏感请求头在记录时已脱敏
+func UpstreamHeaderLogMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if !config.IsUpstreamHeaderLoggingEnabled() {
+			return
+		}
+		exchanges := context.GetUpstreamHeaders(c)
+		if len(exchanges) == 0 {
+			return
+		}
+
+		logger.Info("上游请求头",
+			logutil.AddFields(c,
+				logger.String("path", c.Request.URL.Path),
+				logger.Any("upstream_headers", exchanges),
+			)...)
+
+		audit.GetHeaderLog().Record(audit.RequestHeaders{
+			RequestID: context.GetRequestID(c),
+			Path:      c.Request.URL.Path,
+			Exchanges: exchanges,
+		})
+	}
+}
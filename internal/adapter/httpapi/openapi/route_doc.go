@@ -0,0 +1,40 @@
+package openapi
+
+// RouteDoc 单个路由的文档描述
+type RouteDoc struct {
+	// Summary 接口简述
+	Summary string
+	// Tag 分组标签
+	Tag string
+	// OperationID 为空时从处理函数名推断，匿名处理函数必须显式指定
+	OperationID string
+	// Params 路径参数的示例值，用于反射参数类型；未声明的路径参数视为string
+	Params map[string]any
+	// Headers 可选的请求头参数（如 X-Conversation-ID、X-Kiro-* 扩展头）
+	Headers []HeaderDoc
+	// Security 可用的认证方式（components.securitySchemes 中的名称），满足任一即可；为空表示无需认证
+	Security []string
+	// Request 请求体示例值（通常为结构体零值），nil表示无请求体
+	Request any
+	// Responses 按HTTP状态码声明的响应
+	Responses map[int]ResponseDoc
+	// Hidden 为true时不写入文档（如静态文件路由），但仍视为已描述
+	Hidden bool
+}
+
+// ResponseDoc 单个响应码的描述
+type ResponseDoc struct {
+	Description string
+	// Body JSON响应体示例值，nil表示不声明JSON内容
+	Body any
+	// ContentTypes 额外的非JSON内容类型（如 text/event-stream、text/html）
+	ContentTypes []string
+	// Headers 可能返回的响应头
+	Headers []HeaderDoc
+}
+
+// HeaderDoc 请求头或响应头的描述，值均为字符串
+type HeaderDoc struct {
+	Name        string
+	Description string
+}
@@ -0,0 +1,173 @@
+package openapi
+
+import (
+	"encoding/json"
+	"path"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Schema OpenAPI 3.0 schema对象（仅包含反射生成用到的字段）
+type Schema struct {
+	Ref                  string             `yaml:"$ref,omitempty" json:"$ref,omitempty"`
+	Type                 string             `yaml:"type,omitempty" json:"type,omitempty" binding:"omitempty,oneof=object array string integer number boolean"`
+	Format               string             `yaml:"format,omitempty" json:"format,omitempty"`
+	Nullable             bool               `yaml:"nullable,omitempty" json:"nullable,omitempty"`
+	Items                *Schema            `yaml:"items,omitempty" json:"items,omitempty" binding:"required_if=Type array"`
+	Properties           map[string]*Schema `yaml:"properties,omitempty" json:"properties,omitempty" binding:"dive,required"`
+	Required             []string           `yaml:"required,omitempty" json:"required,omitempty"`
+	AdditionalProperties *Schema            `yaml:"additionalProperties,omitempty" json:"additionalProperties,omitempty"`
+}
+
+const componentRefPrefix = "#/components/schemas/"
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// schemaRegistry 收集具名结构体的schema，重复出现时以$ref引用
+type schemaRegistry struct {
+	schemas map[string]*Schema
+	names   map[reflect.Type]string
+}
+
+func newSchemaRegistry() *schemaRegistry {
+	return &schemaRegistry{
+		schemas: map[string]*Schema{},
+		names:   map[reflect.Type]string{},
+	}
+}
+
+// SchemaOf 反射示例值的类型生成schema，具名结构体写入 components 并返回引用
+func SchemaOf(sample any) (*Schema, map[string]*Schema) {
+	reg := newSchemaRegistry()
+	return reg.schemaOf(sample), reg.schemas
+}
+
+func (r *schemaRegistry) schemaOf(sample any) *Schema {
+	if sample == nil {
+		return &Schema{}
+	}
+	return r.schemaForType(reflect.TypeOf(sample))
+}
+
+func (r *schemaRegistry) schemaForType(t reflect.Type) *Schema {
+	if t == rawMessageType {
+		return &Schema{}
+	}
+	if t == timeType {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := r.schemaForType(t.Elem())
+		if s.Ref == "" {
+			s.Nullable = true
+		}
+		return s
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Uint:
+		return &Schema{Type: "integer"}
+	case reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: r.schemaForType(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: r.schemaForType(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return r.structSchema(t)
+		}
+		return r.ref(t)
+	default:
+		// interface{} 等无法确定的类型允许任意值
+		return &Schema{}
+	}
+}
+
+// ref 注册具名结构体并返回引用；先占位再展开字段，避免递归类型无限展开
+func (r *schemaRegistry) ref(t reflect.Type) *Schema {
+	name, ok := r.names[t]
+	if !ok {
+		name = r.componentName(t)
+		r.names[t] = name
+		r.schemas[name] = &Schema{}
+		*r.schemas[name] = *r.structSchema(t)
+	}
+	return &Schema{Ref: componentRefPrefix + name}
+}
+
+// componentName 默认使用首字母大写的类型名，不同包的同名类型以包名作前缀区分
+func (r *schemaRegistry) componentName(t reflect.Type) string {
+	name := upperFirst(t.Name())
+	if _, taken := r.schemas[name]; !taken {
+		return name
+	}
+	return upperFirst(path.Base(t.PkgPath())) + name
+}
+
+func upperFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+func (r *schemaRegistry) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	r.collectFields(t, s)
+	if len(s.Properties) == 0 {
+		s.Properties = nil
+	}
+	return s
+}
+
+// collectFields 按encoding/json的规则展开字段：忽略未导出字段和 json:"-"，匿名嵌入结构体字段提升到外层
+func (r *schemaRegistry) collectFields(t reflect.Type, s *Schema) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				r.collectFields(ft, s)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		s.Properties[name] = r.schemaForType(field.Type)
+		if !strings.Contains(opts, "omitempty") && field.Type.Kind() != reflect.Ptr {
+			s.Required = append(s.Required, name)
+		}
+	}
+}
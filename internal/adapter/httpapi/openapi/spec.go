@@ -0,0 +1,370 @@
+// Package openapi 根据已注册的Gin路由生成OpenAPI 3.0文档
+//
+// 路由表来自 gin.Engine.Routes()，请求/响应结构通过反射Go类型的json标签生成schema。
+// 所有处理函数签名都是 func(*gin.Context)，无法从签名推断参数类型，
+// 因此路径参数类型、请求体与响应码由 RouteDoc 描述表显式声明。
+package openapi
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// Version 生成文档使用的OpenAPI版本
+const Version = "3.0.3"
+
+// Document OpenAPI文档根对象（仅包含本项目用到的字段）
+// 同时带 yaml/json 标签：仓库中提交 openapi.yaml，运行时通过 /openapi.json 提供
+type Document struct {
+	OpenAPI    string               `yaml:"openapi" json:"openapi" binding:"startswith=3.0."`
+	Info       Info                 `yaml:"info" json:"info"`
+	Paths      map[string]*PathItem `yaml:"paths" json:"paths" binding:"min=1,dive,keys,startswith=/,endkeys,required"`
+	Components Components           `yaml:"components,omitempty" json:"components"`
+}
+
+// Info 文档元信息
+type Info struct {
+	Title       string `yaml:"title" json:"title" binding:"required"`
+	Description string `yaml:"description,omitempty" json:"description,omitempty"`
+	Version     string `yaml:"version" json:"version" binding:"required"`
+}
+
+// Components 可复用的schema与认证方式定义
+type Components struct {
+	Schemas         map[string]*Schema         `yaml:"schemas,omitempty" json:"schemas,omitempty" binding:"dive,required"`
+	SecuritySchemes map[string]*SecurityScheme `yaml:"securitySchemes,omitempty" json:"securitySchemes,omitempty" binding:"dive,required"`
+}
+
+// SecurityScheme 认证方式（apiKey 或 http bearer）
+type SecurityScheme struct {
+	Type        string `yaml:"type" json:"type" binding:"oneof=apiKey http"`
+	Description string `yaml:"description,omitempty" json:"description,omitempty"`
+	Name        string `yaml:"name,omitempty" json:"name,omitempty" binding:"required_if=Type apiKey"`
+	In          string `yaml:"in,omitempty" json:"in,omitempty" binding:"required_if=Type apiKey,omitempty,oneof=header query cookie"`
+	Scheme      string `yaml:"scheme,omitempty" json:"scheme,omitempty" binding:"required_if=Type http"`
+}
+
+// SecurityRequirement 认证要求，键为 components.securitySchemes 中的名称
+type SecurityRequirement map[string][]string
+
+// PathItem 单个路径下各HTTP方法的操作
+type PathItem struct {
+	Get    *Operation `yaml:"get,omitempty" json:"get,omitempty"`
+	Post   *Operation `yaml:"post,omitempty" json:"post,omitempty"`
+	Put    *Operation `yaml:"put,omitempty" json:"put,omitempty"`
+	Patch  *Operation `yaml:"patch,omitempty" json:"patch,omitempty"`
+	Delete *Operation `yaml:"delete,omitempty" json:"delete,omitempty"`
+}
+
+// Operation 单个接口操作
+type Operation struct {
+	OperationID string                `yaml:"operationId" json:"operationId" binding:"required"`
+	Summary     string                `yaml:"summary,omitempty" json:"summary,omitempty"`
+	Tags        []string              `yaml:"tags,omitempty" json:"tags,omitempty"`
+	Parameters  []Parameter           `yaml:"parameters,omitempty" json:"parameters,omitempty" binding:"dive"`
+	RequestBody *RequestBody          `yaml:"requestBody,omitempty" json:"requestBody,omitempty"`
+	Responses   map[string]*Response  `yaml:"responses" json:"responses" binding:"min=1,dive,required"`
+	Security    []SecurityRequirement `yaml:"security,omitempty" json:"security,omitempty"`
+}
+
+// Parameter 路径/请求头参数
+type Parameter struct {
+	Name        string  `yaml:"name" json:"name" binding:"required"`
+	In          string  `yaml:"in" json:"in" binding:"oneof=path query header cookie"`
+	Description string  `yaml:"description,omitempty" json:"description,omitempty"`
+	Required    bool    `yaml:"required" json:"required"`
+	Schema      *Schema `yaml:"schema" json:"schema" binding:"required"`
+}
+
+// RequestBody 请求体
+type RequestBody struct {
+	Required bool                  `yaml:"required" json:"required"`
+	Content  map[string]*MediaType `yaml:"content" json:"content" binding:"min=1,dive,required"`
+}
+
+// Response 单个响应码的描述
+type Response struct {
+	Description string                `yaml:"description" json:"description" binding:"required"`
+	Headers     map[string]*Header    `yaml:"headers,omitempty" json:"headers,omitempty" binding:"dive,required"`
+	Content     map[string]*MediaType `yaml:"content,omitempty" json:"content,omitempty" binding:"dive,required"`
+}
+
+// Header 响应头
+type Header struct {
+	Description string  `yaml:"description,omitempty" json:"description,omitempty"`
+	Schema      *Schema `yaml:"schema" json:"schema" binding:"required"`
+}
+
+// MediaType 某种内容类型的schema
+type MediaType struct {
+	Schema *Schema `yaml:"schema" json:"schema" binding:"required"`
+}
+
+// operations 按固定顺序返回路径下的所有操作
+func (p *PathItem) operations() map[string]*Operation {
+	ops := map[string]*Operation{}
+	for method, op := range map[string]*Operation{
+		"GET": p.Get, "POST": p.Post, "PUT": p.Put, "PATCH": p.Patch, "DELETE": p.Delete,
+	} {
+		if op != nil {
+			ops[method] = op
+		}
+	}
+	return ops
+}
+
+// Operation 返回指定HTTP方法的操作，不存在时为nil
+func (p *PathItem) Operation(method string) *Operation {
+	return p.operations()[method]
+}
+
+func (p *PathItem) set(method string, op *Operation) error {
+	switch method {
+	case "GET":
+		p.Get = op
+	case "POST":
+		p.Post = op
+	case "PUT":
+		p.Put = op
+	case "PATCH":
+		p.Patch = op
+	case "DELETE":
+		p.Delete = op
+	default:
+		return fmt.Errorf("不支持的HTTP方法: %s", method)
+	}
+	return nil
+}
+
+// RouteKey 返回描述表使用的路由键，格式为 "METHOD /path"（路径保持Gin写法）
+func RouteKey(method, path string) string {
+	return method + " " + path
+}
+
+// Generate 将Gin路由表与描述表合并为OpenAPI文档
+// 未在描述表中声明的路由、以及描述表中已不存在的路由都会返回错误，保证文档与路由同步
+func Generate(info Info, routes gin.RoutesInfo, docs map[string]RouteDoc) (*Document, error) {
+	doc := &Document{
+		OpenAPI: Version,
+		Info:    info,
+		Paths:   map[string]*PathItem{},
+	}
+	reg := newSchemaRegistry()
+	seen := map[string]bool{}
+	operationIDs := map[string]string{}
+
+	// 按路由键排序，保证生成结果稳定
+	sorted := append(gin.RoutesInfo(nil), routes...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return RouteKey(sorted[i].Method, sorted[i].Path) < RouteKey(sorted[j].Method, sorted[j].Path)
+	})
+
+	for _, route := range sorted {
+		key := RouteKey(route.Method, route.Path)
+		rd, ok := docs[key]
+		if !ok {
+			return nil, fmt.Errorf("路由 %s 缺少文档描述", key)
+		}
+		seen[key] = true
+		if rd.Hidden {
+			continue
+		}
+
+		op, err := buildOperation(route, rd, reg)
+		if err != nil {
+			return nil, fmt.Errorf("路由 %s: %w", key, err)
+		}
+		if prev, dup := operationIDs[op.OperationID]; dup {
+			return nil, fmt.Errorf("路由 %s 与 %s 的operationId重复: %s", key, prev, op.OperationID)
+		}
+		operationIDs[op.OperationID] = key
+
+		path := OpenAPIPath(route.Path)
+		item := doc.Paths[path]
+		if item == nil {
+			item = &PathItem{}
+			doc.Paths[path] = item
+		}
+		if err := item.set(route.Method, op); err != nil {
+			return nil, fmt.Errorf("路由 %s: %w", key, err)
+		}
+	}
+
+	var stale []string
+	for key := range docs {
+		if !seen[key] {
+			stale = append(stale, key)
+		}
+	}
+	if len(stale) > 0 {
+		sort.Strings(stale)
+		return nil, fmt.Errorf("文档描述了未注册的路由: %s", strings.Join(stale, ", "))
+	}
+
+	doc.Components.Schemas = reg.schemas
+	return doc, nil
+}
+
+func buildOperation(route gin.RouteInfo, rd RouteDoc, reg *schemaRegistry) (*Operation, error) {
+	opID := rd.OperationID
+	if opID == "" {
+		opID = OperationIDFromHandler(route.Handler)
+	}
+	if opID == "" {
+		return nil, fmt.Errorf("无法从处理函数 %s 推断operationId，请在描述表中指定", route.Handler)
+	}
+
+	op := &Operation{
+		OperationID: opID,
+		Summary:     rd.Summary,
+		Responses:   map[string]*Response{},
+	}
+	if rd.Tag != "" {
+		op.Tags = []string{rd.Tag}
+	}
+
+	for _, name := range PathParams(route.Path) {
+		schema := &Schema{Type: "string"}
+		if sample, ok := rd.Params[name]; ok {
+			schema = reg.schemaOf(sample)
+		}
+		op.Parameters = append(op.Parameters, Parameter{Name: name, In: "path", Required: true, Schema: schema})
+	}
+	for _, h := range rd.Headers {
+		op.Parameters = append(op.Parameters, Parameter{Name: h.Name, In: "header", Description: h.Description, Schema: &Schema{Type: "string"}})
+	}
+	for name := range rd.Params {
+		if !containsString(PathParams(route.Path), name) {
+			return nil, fmt.Errorf("声明了不存在的路径参数: %s", name)
+		}
+	}
+
+	for _, scheme := range rd.Security {
+		op.Security = append(op.Security, SecurityRequirement{scheme: []string{}})
+	}
+
+	if rd.Request != nil {
+		op.RequestBody = &RequestBody{
+			Required: true,
+			Content:  map[string]*MediaType{"application/json": {Schema: reg.schemaOf(rd.Request)}},
+		}
+	}
+
+	if len(rd.Responses) == 0 {
+		return nil, fmt.Errorf("至少需要声明一个响应码")
+	}
+	for code, resp := range rd.Responses {
+		if code < 100 || code > 599 {
+			return nil, fmt.Errorf("无效的响应码: %d", code)
+		}
+		r := &Response{Description: resp.Description}
+		if r.Description == "" {
+			r.Description = defaultDescription(code)
+		}
+		for _, h := range resp.Headers {
+			if r.Headers == nil {
+				r.Headers = map[string]*Header{}
+			}
+			r.Headers[h.Name] = &Header{Description: h.Description, Schema: &Schema{Type: "string"}}
+		}
+		content := map[string]*MediaType{}
+		if resp.Body != nil {
+			content["application/json"] = &MediaType{Schema: reg.schemaOf(resp.Body)}
+		}
+		for _, ct := range resp.ContentTypes {
+			content[ct] = &MediaType{Schema: &Schema{Type: "string"}}
+		}
+		if len(content) > 0 {
+			r.Content = content
+		}
+		op.Responses[fmt.Sprintf("%d", code)] = r
+	}
+	return op, nil
+}
+
+// OpenAPIPath 将Gin的 :param 路径参数转换为OpenAPI的 {param} 写法
+func OpenAPIPath(ginPath string) string {
+	segments := strings.Split(ginPath, "/")
+	for i, seg := range segments {
+		if strings.HasPrefix(seg, ":") || strings.HasPrefix(seg, "*") {
+			segments[i] = "{" + seg[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// PathParams 返回Gin路径中的参数名（按出现顺序）
+func PathParams(ginPath string) []string {
+	var params []string
+	for _, seg := range strings.Split(ginPath, "/") {
+		if strings.HasPrefix(seg, ":") || strings.HasPrefix(seg, "*") {
+			params = append(params, seg[1:])
+		}
+	}
+	return params
+}
+
+// OperationIDFromHandler 从Gin记录的处理函数名推断operationId
+// 例如 "kiro2api/.../handlers.(*Handler).handleTokenPool-fm" -> "tokenPool"；匿名函数返回空字符串
+func OperationIDFromHandler(handler string) string {
+	name := strings.TrimSuffix(handler, "-fm")
+	if idx := strings.LastIndex(name, "."); idx >= 0 {
+		name = name[idx+1:]
+	}
+	if name == "" || strings.HasPrefix(name, "func") {
+		return ""
+	}
+	name = strings.TrimPrefix(name, "handle")
+	if name == "" {
+		return ""
+	}
+	return strings.ToLower(name[:1]) + name[1:]
+}
+
+// Marshal 将文档序列化为YAML（两空格缩进）
+func Marshal(doc *Document) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Parse 解析YAML格式的OpenAPI文档
+func Parse(data []byte) (*Document, error) {
+	var doc Document
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+func defaultDescription(code int) string {
+	switch {
+	case code < 300:
+		return "成功"
+	case code < 500:
+		return "请求错误"
+	default:
+		return "服务端错误"
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,178 @@
+package openapi
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type sampleItem struct {
+	Name string `json:"name"`
+}
+
+type sampleBase struct {
+	ID int64 `json:"id"`
+}
+
+type sampleRequest struct {
+	sampleBase
+	Title    string         `json:"title"`
+	Count    *int           `json:"count,omitempty"`
+	Tags     []string       `json:"tags,omitempty"`
+	Items    []sampleItem   `json:"items"`
+	Extra    map[string]any `json:"extra,omitempty"`
+	Raw      any            `json:"raw"`
+	Ignored  string         `json:"-"`
+	internal string
+	Next     *sampleRequest `json:"next,omitempty"`
+}
+
+func TestOpenAPIPath(t *testing.T) {
+	assert.Equal(t, "/admin/tokens/{index}/test", OpenAPIPath("/admin/tokens/:index/test"))
+	assert.Equal(t, "/static/{filepath}", OpenAPIPath("/static/*filepath"))
+	assert.Equal(t, "/v1/messages", OpenAPIPath("/v1/messages"))
+	assert.Equal(t, []string{"a", "b"}, PathParams("/x/:a/y/:b"))
+}
+
+func TestOperationIDFromHandler(t *testing.T) {
+	assert.Equal(t, "tokenPool", OperationIDFromHandler("kiro2api/internal/adapter/httpapi/handlers.(*Handler).handleTokenPool-fm"))
+	assert.Equal(t, "models", OperationIDFromHandler("pkg.(*Handler).handleModels-fm"))
+	assert.Equal(t, "", OperationIDFromHandler("kiro2api/internal/adapter/httpapi/handlers.(*Handler).Register.func1"))
+}
+
+func TestSchemaOf_ReflectsJSONTags(t *testing.T) {
+	schema, components := SchemaOf(sampleRequest{})
+	assert.Equal(t, "#/components/schemas/SampleRequest", schema.Ref)
+
+	req := components["SampleRequest"]
+	require.NotNil(t, req)
+	assert.Equal(t, "object", req.Type)
+	assert.ElementsMatch(t, []string{"id", "title", "items", "raw"}, req.Required)
+
+	assert.Equal(t, "integer", req.Properties["id"].Type, "嵌入结构体字段应提升到外层")
+	assert.Equal(t, "int64", req.Properties["id"].Format)
+	assert.True(t, req.Properties["count"].Nullable)
+	assert.Equal(t, "array", req.Properties["tags"].Type)
+	assert.Equal(t, "#/components/schemas/SampleItem", req.Properties["items"].Items.Ref)
+	assert.Equal(t, "object", req.Properties["extra"].Type)
+	assert.Equal(t, &Schema{}, req.Properties["raw"], "any类型允许任意值")
+	assert.Equal(t, "#/components/schemas/SampleRequest", req.Properties["next"].Ref, "递归类型应使用引用")
+	assert.NotContains(t, req.Properties, "Ignored")
+	assert.NotContains(t, req.Properties, "-")
+	assert.NotContains(t, req.Properties, "internal")
+}
+
+func testRoutes() gin.RoutesInfo {
+	return gin.RoutesInfo{
+		{Method: "GET", Path: "/items/:id", Handler: "pkg.(*Handler).handleGetItem-fm"},
+		{Method: "POST", Path: "/items", Handler: "pkg.(*Handler).handleCreateItem-fm"},
+		{Method: "GET", Path: "/health", Handler: "pkg.(*Handler).Register.func1"},
+	}
+}
+
+func testDocs() map[string]RouteDoc {
+	return map[string]RouteDoc{
+		"GET /items/:id": {
+			Summary: "获取条目",
+			Params:  map[string]any{"id": 0},
+			Responses: map[int]ResponseDoc{
+				200: {Body: sampleItem{}},
+				404: {Description: "不存在"},
+			},
+		},
+		"POST /items": {
+			Request:   sampleRequest{},
+			Responses: map[int]ResponseDoc{201: {Body: sampleItem{}}},
+		},
+		"GET /health": {
+			OperationID: "health",
+			Responses:   map[int]ResponseDoc{200: {ContentTypes: []string{"text/plain"}}},
+		},
+	}
+}
+
+func TestGenerate_BuildsValidDocument(t *testing.T) {
+	doc, err := Generate(Info{Title: "test", Version: "1"}, testRoutes(), testDocs())
+	require.NoError(t, err)
+	require.NoError(t, Validate(doc))
+
+	get := doc.Paths["/items/{id}"].Get
+	require.NotNil(t, get)
+	assert.Equal(t, "getItem", get.OperationID)
+	require.Len(t, get.Parameters, 1)
+	assert.Equal(t, "integer", get.Parameters[0].Schema.Type)
+	assert.Equal(t, "成功", get.Responses["200"].Description)
+	assert.Equal(t, "不存在", get.Responses["404"].Description)
+
+	post := doc.Paths["/items"].Post
+	require.NotNil(t, post)
+	assert.Equal(t, "createItem", post.OperationID)
+	assert.Equal(t, "#/components/schemas/SampleRequest", post.RequestBody.Content["application/json"].Schema.Ref)
+	assert.Contains(t, doc.Components.Schemas, "SampleItem")
+
+	assert.Equal(t, "health", doc.Paths["/health"].Get.OperationID)
+}
+
+func TestGenerate_HeadersAndSecurity(t *testing.T) {
+	docs := testDocs()
+	create := docs["POST /items"]
+	create.Headers = []HeaderDoc{{Name: "X-Trace-ID", Description: "追踪ID"}}
+	create.Security = []string{"apiKey", "bearer"}
+	create.Responses = map[int]ResponseDoc{201: {Body: sampleItem{}, Headers: []HeaderDoc{{Name: "X-Item-Reduced"}}}}
+	docs["POST /items"] = create
+
+	doc, err := Generate(Info{Title: "test", Version: "1"}, testRoutes(), docs)
+	require.NoError(t, err)
+
+	post := doc.Paths["/items"].Operation("POST")
+	require.NotNil(t, post)
+	assert.Equal(t, []Parameter{{Name: "X-Trace-ID", In: "header", Description: "追踪ID", Schema: &Schema{Type: "string"}}}, post.Parameters)
+	assert.Equal(t, []SecurityRequirement{{"apiKey": {}}, {"bearer": {}}}, post.Security, "多个认证方式满足任一即可")
+	assert.Contains(t, post.Responses["201"].Headers, "X-Item-Reduced")
+	assert.Empty(t, doc.Paths["/health"].Get.Security)
+
+	// 认证方式需由调用方在 components 中定义
+	assert.ErrorContains(t, Validate(doc), "未定义的认证方式 apiKey")
+	doc.Components.SecuritySchemes = map[string]*SecurityScheme{
+		"apiKey": {Type: "apiKey", Name: "x-api-key", In: "header"},
+		"bearer": {Type: "http", Scheme: "bearer"},
+	}
+	assert.NoError(t, Validate(doc))
+}
+
+func TestGenerate_RequiresDocsInSyncWithRoutes(t *testing.T) {
+	docs := testDocs()
+	delete(docs, "POST /items")
+	_, err := Generate(Info{Title: "test", Version: "1"}, testRoutes(), docs)
+	assert.ErrorContains(t, err, "POST /items")
+
+	docs = testDocs()
+	docs["DELETE /items/:id"] = RouteDoc{Responses: map[int]ResponseDoc{204: {}}}
+	_, err = Generate(Info{Title: "test", Version: "1"}, testRoutes(), docs)
+	assert.ErrorContains(t, err, "DELETE /items/:id")
+
+	docs = testDocs()
+	health := docs["GET /health"]
+	health.OperationID = ""
+	docs["GET /health"] = health
+	_, err = Generate(Info{Title: "test", Version: "1"}, testRoutes(), docs)
+	assert.ErrorContains(t, err, "operationId")
+}
+
+func TestMarshalParse_RoundTrip(t *testing.T) {
+	doc, err := Generate(Info{Title: "test", Version: "1"}, testRoutes(), testDocs())
+	require.NoError(t, err)
+
+	data, err := Marshal(doc)
+	require.NoError(t, err)
+
+	parsed, err := Parse(data)
+	require.NoError(t, err)
+	require.NoError(t, Validate(parsed))
+
+	again, err := Marshal(parsed)
+	require.NoError(t, err)
+	assert.Equal(t, string(data), string(again))
+}
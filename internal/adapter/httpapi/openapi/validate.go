@@ -0,0 +1,199 @@
+package openapi
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+var (
+	pathTemplateParam = regexp.MustCompile(`\{([^}/]+)\}`)
+	responseCode      = regexp.MustCompile(`^([1-5][0-9]{2}|[1-5]XX|default)$`)
+)
+
+// Validate 按OpenAPI 3.0规范检查文档的结构约束，返回发现的全部问题
+//
+// 字段级约束（版本号、info必填字段、路径以/开头、参数位置、响应description、认证方式字段等）
+// 以binding标签声明在文档结构体上，交给Gin的 binding.Validator 校验；
+// 标签无法表达的跨字段规则在这里检查：路径模板参数必须声明为必填的path参数、operationId全局唯一、
+// 响应码合法、所有 $ref 都能在 components.schemas 中解析、参数不重复、
+// security 引用的认证方式已在 components.securitySchemes 中定义。
+func Validate(doc *Document) error {
+	var errs []error
+	add := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if err := binding.Validator.ValidateStruct(doc); err != nil {
+		errs = append(errs, bindingErrors(err)...)
+	}
+
+	paths := make([]string, 0, len(doc.Paths))
+	for p := range doc.Paths {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	operationIDs := map[string]string{}
+	for _, p := range paths {
+		item := doc.Paths[p]
+		if item == nil {
+			continue
+		}
+		ops := item.operations()
+		if len(ops) == 0 {
+			add("路径 %s 没有任何操作", p)
+		}
+
+		var templateParams []string
+		for _, m := range pathTemplateParam.FindAllStringSubmatch(p, -1) {
+			templateParams = append(templateParams, m[1])
+		}
+
+		methods := make([]string, 0, len(ops))
+		for m := range ops {
+			methods = append(methods, m)
+		}
+		sort.Strings(methods)
+
+		for _, method := range methods {
+			op := ops[method]
+			where := method + " " + p
+
+			if op.OperationID != "" {
+				if prev, dup := operationIDs[op.OperationID]; dup {
+					add("%s 与 %s 的operationId重复: %s", where, prev, op.OperationID)
+				} else {
+					operationIDs[op.OperationID] = where
+				}
+			}
+
+			declared := map[string]bool{}
+			seenParams := map[string]bool{}
+			for _, param := range op.Parameters {
+				if seenParams[param.In+" "+param.Name] {
+					add("%s 重复声明了参数 %s（%s）", where, param.Name, param.In)
+				}
+				seenParams[param.In+" "+param.Name] = true
+				validateSchema(doc, param.Schema, where+" 参数 "+param.Name, add)
+				if param.In != "path" {
+					continue
+				}
+				declared[param.Name] = true
+				if !param.Required {
+					add("%s 的路径参数 %s 必须为required", where, param.Name)
+				}
+				if !containsString(templateParams, param.Name) {
+					add("%s 声明了路径中不存在的参数 %s", where, param.Name)
+				}
+			}
+			for _, name := range templateParams {
+				if !declared[name] {
+					add("%s 未声明路径参数 %s", where, name)
+				}
+			}
+
+			if op.RequestBody != nil {
+				for ct, mt := range op.RequestBody.Content {
+					if mt != nil {
+						validateSchema(doc, mt.Schema, where+" 请求体 "+ct, add)
+					}
+				}
+			}
+
+			for code, resp := range op.Responses {
+				if !responseCode.MatchString(code) {
+					add("%s 的响应码 %s 不合法", where, code)
+				}
+				if resp == nil {
+					continue
+				}
+				for ct, mt := range resp.Content {
+					if mt != nil {
+						validateSchema(doc, mt.Schema, where+" 响应 "+code+" "+ct, add)
+					}
+				}
+				for name, h := range resp.Headers {
+					if h != nil {
+						validateSchema(doc, h.Schema, where+" 响应 "+code+" 响应头 "+name, add)
+					}
+				}
+			}
+
+			for _, req := range op.Security {
+				for scheme := range req {
+					if _, ok := doc.Components.SecuritySchemes[scheme]; !ok {
+						add("%s 引用了未定义的认证方式 %s", where, scheme)
+					}
+				}
+			}
+		}
+	}
+
+	names := make([]string, 0, len(doc.Components.Schemas))
+	for name := range doc.Components.Schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		validateSchema(doc, doc.Components.Schemas[name], "components.schemas."+name, add)
+	}
+
+	return errors.Join(errs...)
+}
+
+// bindingErrors 将binding标签的校验失败转换为中文错误，字段路径沿用Go结构体的命名空间
+func bindingErrors(err error) []error {
+	var fieldErrs validator.ValidationErrors
+	if !errors.As(err, &fieldErrs) {
+		return []error{err}
+	}
+	errs := make([]error, 0, len(fieldErrs))
+	for _, fe := range fieldErrs {
+		where := strings.TrimPrefix(fe.Namespace(), "Document.")
+		switch fe.Tag() {
+		case "required", "required_if", "min":
+			errs = append(errs, fmt.Errorf("%s 不能为空", where))
+		case "startswith":
+			errs = append(errs, fmt.Errorf("%s 必须以 %s 开头，实际为 %q", where, fe.Param(), fe.Value()))
+		case "oneof":
+			errs = append(errs, fmt.Errorf("%s 的值 %q 不合法，可选值: %s", where, fe.Value(), fe.Param()))
+		default:
+			errs = append(errs, fmt.Errorf("%s 不满足约束 %s", where, fe.Tag()))
+		}
+	}
+	return errs
+}
+
+// validateSchema 递归检查schema的 $ref 能否解析、required字段是否存在；nil与type约束由binding标签负责
+func validateSchema(doc *Document, s *Schema, where string, add func(string, ...any)) {
+	if s == nil {
+		return
+	}
+	if s.Ref != "" {
+		name, ok := strings.CutPrefix(s.Ref, componentRefPrefix)
+		if !ok {
+			add("%s 的 $ref %s 只支持引用 components.schemas", where, s.Ref)
+		} else if _, exists := doc.Components.Schemas[name]; !exists {
+			add("%s 的 $ref %s 无法解析", where, s.Ref)
+		}
+		return
+	}
+	validateSchema(doc, s.Items, where+"[]", add)
+	for _, req := range s.Required {
+		if _, ok := s.Properties[req]; !ok {
+			add("%s 的required字段 %s 不在properties中", where, req)
+		}
+	}
+	for name, prop := range s.Properties {
+		validateSchema(doc, prop, where+"."+name, add)
+	}
+	if s.AdditionalProperties != nil {
+		validateSchema(doc, s.AdditionalProperties, where+".*", add)
+	}
+}
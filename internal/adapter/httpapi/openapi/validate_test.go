@@ -0,0 +1,160 @@
+package openapi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func validDocument() *Document {
+	return &Document{
+		OpenAPI: Version,
+		Info:    Info{Title: "test", Version: "1"},
+		Paths: map[string]*PathItem{
+			"/items/{id}": {
+				Get: &Operation{
+					OperationID: "getItem",
+					Parameters: []Parameter{
+						{Name: "id", In: "path", Required: true, Schema: &Schema{Type: "string"}},
+					},
+					Responses: map[string]*Response{
+						"200": {Description: "ok", Content: map[string]*MediaType{
+							"application/json": {Schema: &Schema{Ref: componentRefPrefix + "Item"}},
+						}},
+					},
+				},
+			},
+		},
+		Components: Components{Schemas: map[string]*Schema{
+			"Item": {Type: "object", Properties: map[string]*Schema{"name": {Type: "string"}}, Required: []string{"name"}},
+		}},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		mutate  func(doc *Document)
+		wantErr string
+	}{
+		{name: "合法文档", mutate: func(doc *Document) {}},
+		{
+			name:    "版本号错误",
+			mutate:  func(doc *Document) { doc.OpenAPI = "2.0" },
+			wantErr: "OpenAPI 必须以 3.0. 开头",
+		},
+		{
+			name:    "缺少标题",
+			mutate:  func(doc *Document) { doc.Info.Title = "" },
+			wantErr: "Info.Title 不能为空",
+		},
+		{
+			name:    "未声明路径参数",
+			mutate:  func(doc *Document) { doc.Paths["/items/{id}"].Get.Parameters = nil },
+			wantErr: "未声明路径参数 id",
+		},
+		{
+			name:    "路径参数非必填",
+			mutate:  func(doc *Document) { doc.Paths["/items/{id}"].Get.Parameters[0].Required = false },
+			wantErr: "必须为required",
+		},
+		{
+			name: "引用无法解析",
+			mutate: func(doc *Document) {
+				delete(doc.Components.Schemas, "Item")
+			},
+			wantErr: "无法解析",
+		},
+		{
+			name: "响应码非法",
+			mutate: func(doc *Document) {
+				doc.Paths["/items/{id}"].Get.Responses["20"] = &Response{Description: "x"}
+			},
+			wantErr: "响应码 20 不合法",
+		},
+		{
+			name: "required字段不存在",
+			mutate: func(doc *Document) {
+				doc.Components.Schemas["Item"].Required = []string{"missing"}
+			},
+			wantErr: "required字段 missing",
+		},
+		{
+			name: "operationId重复",
+			mutate: func(doc *Document) {
+				doc.Paths["/other"] = &PathItem{Post: &Operation{
+					OperationID: "getItem",
+					Responses:   map[string]*Response{"200": {Description: "ok"}},
+				}}
+			},
+			wantErr: "operationId重复",
+		},
+		{
+			name: "重复的请求头参数",
+			mutate: func(doc *Document) {
+				header := Parameter{Name: "X-Trace", In: "header", Schema: &Schema{Type: "string"}}
+				doc.Paths["/items/{id}"].Get.Parameters = append(doc.Paths["/items/{id}"].Get.Parameters, header, header)
+			},
+			wantErr: "重复声明了参数 X-Trace",
+		},
+		{
+			name: "认证方式未定义",
+			mutate: func(doc *Document) {
+				doc.Paths["/items/{id}"].Get.Security = []SecurityRequirement{{"apiKey": {}}}
+			},
+			wantErr: "未定义的认证方式 apiKey",
+		},
+		{
+			name: "apiKey认证缺少位置",
+			mutate: func(doc *Document) {
+				doc.Components.SecuritySchemes = map[string]*SecurityScheme{"apiKey": {Type: "apiKey", Name: "x-api-key"}}
+			},
+			wantErr: "Components.SecuritySchemes[apiKey].In 不能为空",
+		},
+		{
+			name: "路径不以/开头",
+			mutate: func(doc *Document) {
+				doc.Paths["items"] = &PathItem{Get: &Operation{
+					OperationID: "listItems",
+					Responses:   map[string]*Response{"200": {Description: "ok"}},
+				}}
+			},
+			wantErr: "Paths[items] 必须以 / 开头",
+		},
+		{
+			name: "参数位置非法",
+			mutate: func(doc *Document) {
+				doc.Paths["/items/{id}"].Get.Parameters = append(doc.Paths["/items/{id}"].Get.Parameters,
+					Parameter{Name: "q", In: "body", Schema: &Schema{Type: "string"}})
+			},
+			wantErr: `Parameters[1].In 的值 "body" 不合法`,
+		},
+		{
+			name: "响应缺少description",
+			mutate: func(doc *Document) {
+				doc.Paths["/items/{id}"].Get.Responses["404"] = &Response{}
+			},
+			wantErr: "Responses[404].Description 不能为空",
+		},
+		{
+			name: "array缺少items",
+			mutate: func(doc *Document) {
+				doc.Components.Schemas["List"] = &Schema{Type: "array"}
+			},
+			wantErr: "Components.Schemas[List].Items 不能为空",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := validDocument()
+			tt.mutate(doc)
+			err := Validate(doc)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
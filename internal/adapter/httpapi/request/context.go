@@ -1,9 +1,13 @@
 package request
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 
+	"kiro2api/auth"
+	srvcontext "kiro2api/internal/adapter/httpapi/context"
 	logutil "kiro2api/internal/adapter/httpapi/logging"
 	"kiro2api/internal/adapter/httpapi/support"
 	"kiro2api/logger"
@@ -17,6 +21,18 @@ type TokenProvider interface {
 	GetTokenWithUsage() (*types.TokenWithUsage, error)
 }
 
+// SubsetTokenProvider 支持只在指定 TokenID 子集中选择token的数据源（客户端密钥绑定的token池）
+type SubsetTokenProvider interface {
+	GetTokenFrom(tokenIDs []string) (types.TokenInfo, error)
+	GetTokenWithUsageFrom(tokenIDs []string) (*types.TokenWithUsage, error)
+}
+
+// ContextTokenProvider 支持随请求取消排队等待的数据源，tokenIDs 为空时在全部token中选择
+type ContextTokenProvider interface {
+	GetTokenContext(ctx context.Context, tokenIDs []string) (types.TokenInfo, error)
+	GetTokenWithUsageContext(ctx context.Context, tokenIDs []string) (*types.TokenWithUsage, error)
+}
+
 type Context struct {
 	GinContext  *gin.Context
 	AuthService TokenProvider
@@ -24,18 +40,39 @@ type Context struct {
 }
 
 func (rc *Context) GetTokenAndBody() (types.TokenInfo, []byte, error) {
-	tokenInfo, err := rc.AuthService.GetToken()
+	tokenInfo, ok := rc.GetToken()
+	if !ok {
+		return types.TokenInfo{}, nil, errTokenUnavailable
+	}
+	body, err := rc.ReadBody()
 	if err != nil {
-		logger.Error("获取token失败", logger.Err(err))
-		support.RespondError(rc.GinContext, http.StatusInternalServerError, "获取token失败: %v", err)
 		return types.TokenInfo{}, nil, err
 	}
+	return tokenInfo, body, nil
+}
 
+func (rc *Context) GetTokenWithUsageAndBody() (*types.TokenWithUsage, []byte, error) {
+	tokenWithUsage, ok := rc.GetTokenWithUsage()
+	if !ok {
+		return nil, nil, errTokenUnavailable
+	}
+	body, err := rc.ReadBody()
+	if err != nil {
+		return nil, nil, err
+	}
+	return tokenWithUsage, body, nil
+}
+
+// errTokenUnavailable 获取token失败，错误响应已写入
+var errTokenUnavailable = errors.New("获取token失败")
+
+// ReadBody 读取请求体，失败时写入400响应
+func (rc *Context) ReadBody() ([]byte, error) {
 	body, err := rc.GinContext.GetRawData()
 	if err != nil {
 		logger.Error("读取请求体失败", logger.Err(err))
 		support.RespondError(rc.GinContext, http.StatusBadRequest, "读取请求体失败: %v", err)
-		return types.TokenInfo{}, nil, err
+		return nil, err
 	}
 
 	logger.Debug(fmt.Sprintf("收到%s请求", rc.RequestType),
@@ -47,33 +84,81 @@ func (rc *Context) GetTokenAndBody() (types.TokenInfo, []byte, error) {
 			logger.String("user_agent", rc.GinContext.GetHeader("User-Agent")),
 		)...)
 
-	return tokenInfo, body, nil
+	return body, nil
 }
 
-func (rc *Context) GetTokenWithUsageAndBody() (*types.TokenWithUsage, []byte, error) {
-	tokenWithUsage, err := rc.AuthService.GetTokenWithUsage()
+// GetToken 选择本次请求使用的token，失败时写入错误响应
+// 应在通过配额与排队检查之后调用，避免被拒绝或仍在排队的请求占用token
+func (rc *Context) GetToken() (types.TokenInfo, bool) {
+	tokenInfo, err := rc.getToken()
 	if err != nil {
-		logger.Error("获取token失败", logger.Err(err))
-		support.RespondError(rc.GinContext, http.StatusInternalServerError, "获取token失败: %v", err)
-		return nil, nil, err
+		rc.respondTokenError(err)
+		return types.TokenInfo{}, false
 	}
+	return tokenInfo, true
+}
 
-	body, err := rc.GinContext.GetRawData()
+// GetTokenWithUsage 选择本次请求使用的token并附带剩余用量，失败时写入错误响应
+func (rc *Context) GetTokenWithUsage() (*types.TokenWithUsage, bool) {
+	tokenWithUsage, err := rc.getTokenWithUsage()
 	if err != nil {
-		logger.Error("读取请求体失败", logger.Err(err))
-		support.RespondError(rc.GinContext, http.StatusBadRequest, "读取请求体失败: %v", err)
-		return nil, nil, err
+		rc.respondTokenError(err)
+		return nil, false
 	}
-
-	logger.Debug(fmt.Sprintf("收到%s请求", rc.RequestType),
+	logger.Debug("已选择token",
 		logutil.AddFields(rc.GinContext,
-			logger.String("direction", "client_request"),
-			logger.String("body", string(body)),
-			logger.Int("body_size", len(body)),
-			logger.String("remote_addr", rc.GinContext.ClientIP()),
-			logger.String("user_agent", rc.GinContext.GetHeader("User-Agent")),
 			logger.Float64("available_count", tokenWithUsage.AvailableCount),
 		)...)
+	return tokenWithUsage, true
+}
 
-	return tokenWithUsage, body, nil
+// clientTokenIDs 返回当前客户端密钥绑定的 TokenID 子集，数据源不支持子集选择时返回nil
+func (rc *Context) clientTokenIDs() ([]string, SubsetTokenProvider) {
+	subset, ok := rc.AuthService.(SubsetTokenProvider)
+	if !ok {
+		return nil, nil
+	}
+	key, ok := srvcontext.GetClientKey(rc.GinContext)
+	if !ok || len(key.Tokens) == 0 {
+		return nil, nil
+	}
+	return key.Tokens, subset
+}
+
+// boundTokenIDs 返回当前客户端密钥绑定的 TokenID 子集，未绑定时为nil
+func (rc *Context) boundTokenIDs() []string {
+	if key, ok := srvcontext.GetClientKey(rc.GinContext); ok {
+		return key.Tokens
+	}
+	return nil
+}
+
+func (rc *Context) getToken() (types.TokenInfo, error) {
+	if provider, ok := rc.AuthService.(ContextTokenProvider); ok {
+		return provider.GetTokenContext(rc.GinContext.Request.Context(), rc.boundTokenIDs())
+	}
+	if tokenIDs, subset := rc.clientTokenIDs(); subset != nil {
+		return subset.GetTokenFrom(tokenIDs)
+	}
+	return rc.AuthService.GetToken()
+}
+
+func (rc *Context) getTokenWithUsage() (*types.TokenWithUsage, error) {
+	if provider, ok := rc.AuthService.(ContextTokenProvider); ok {
+		return provider.GetTokenWithUsageContext(rc.GinContext.Request.Context(), rc.boundTokenIDs())
+	}
+	if tokenIDs, subset := rc.clientTokenIDs(); subset != nil {
+		return subset.GetTokenWithUsageFrom(tokenIDs)
+	}
+	return rc.AuthService.GetTokenWithUsage()
+}
+
+// respondTokenError 获取token失败时的响应：等待队列已满返回429，其余返回500
+func (rc *Context) respondTokenError(err error) {
+	logger.Error("获取token失败", logutil.AddFields(rc.GinContext, logger.Err(err))...)
+	if errors.Is(err, auth.ErrTokenWaitQueueFull) {
+		support.RespondError(rc.GinContext, http.StatusTooManyRequests, "获取token失败: %v", err)
+		return
+	}
+	support.RespondError(rc.GinContext, http.StatusInternalServerError, "获取token失败: %v", err)
 }
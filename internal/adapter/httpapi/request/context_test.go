@@ -2,9 +2,13 @@ package request
 
 import (
 	"bytes"
+	"fmt"
+	"net/http"
 	"net/http/httptest"
 	"testing"
 
+	"kiro2api/auth"
+	srvcontext "kiro2api/internal/adapter/httpapi/context"
 	"kiro2api/types"
 
 	"github.com/gin-gonic/gin"
@@ -144,3 +148,74 @@ func TestContext_GetTokenWithUsageAndBody(t *testing.T) {
 		})
 	}
 }
+
+// subsetAuthService 记录子集选择时收到的 TokenID
+type subsetAuthService struct {
+	mockAuthService
+	requested []string
+}
+
+func (m *subsetAuthService) GetTokenFrom(tokenIDs []string) (types.TokenInfo, error) {
+	m.requested = tokenIDs
+	return types.TokenInfo{AccessToken: "subset-token"}, nil
+}
+
+func (m *subsetAuthService) GetTokenWithUsageFrom(tokenIDs []string) (*types.TokenWithUsage, error) {
+	m.requested = tokenIDs
+	return &types.TokenWithUsage{TokenInfo: types.TokenInfo{AccessToken: "subset-token"}}, nil
+}
+
+func TestContext_UsesClientKeyTokenSubset(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	newContext := func(key *srvcontext.ClientKey, auth TokenProvider) *Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("POST", "/test", bytes.NewBufferString(`{}`))
+		if key != nil {
+			srvcontext.SetClientKey(c, *key)
+		}
+		return &Context{GinContext: c, AuthService: auth, RequestType: "test"}
+	}
+
+	auth := &subsetAuthService{mockAuthService: mockAuthService{token: types.TokenInfo{AccessToken: "pool-token"}}}
+	token, _, err := newContext(&srvcontext.ClientKey{Name: "team", Tokens: []string{"tok-a"}}, auth).GetTokenAndBody()
+	assert.NoError(t, err)
+	assert.Equal(t, "subset-token", token.AccessToken)
+	assert.Equal(t, []string{"tok-a"}, auth.requested)
+
+	usage, _, err := newContext(&srvcontext.ClientKey{Name: "team", Tokens: []string{"tok-b"}}, auth).GetTokenWithUsageAndBody()
+	assert.NoError(t, err)
+	assert.Equal(t, "subset-token", usage.AccessToken)
+	assert.Equal(t, []string{"tok-b"}, auth.requested)
+
+	// 未绑定子集的密钥使用整个token池
+	token, _, err = newContext(&srvcontext.ClientKey{Name: "other"}, auth).GetTokenAndBody()
+	assert.NoError(t, err)
+	assert.Equal(t, "pool-token", token.AccessToken)
+
+	// 数据源不支持子集选择时回退到整个token池
+	token, _, err = newContext(&srvcontext.ClientKey{Name: "team", Tokens: []string{"tok-a"}}, &auth.mockAuthService).GetTokenAndBody()
+	assert.NoError(t, err)
+	assert.Equal(t, "pool-token", token.AccessToken)
+}
+
+func TestContext_TokenWaitQueueFullReturns429(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	for _, tt := range []struct {
+		err  error
+		want int
+	}{
+		{err: fmt.Errorf("wrapped: %w", auth.ErrTokenWaitQueueFull), want: http.StatusTooManyRequests},
+		{err: assert.AnError, want: http.StatusInternalServerError},
+	} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("POST", "/test", bytes.NewBufferString(`{}`))
+		reqCtx := &Context{GinContext: c, AuthService: &mockAuthService{err: tt.err}, RequestType: "test"}
+
+		_, _, err := reqCtx.GetTokenWithUsageAndBody()
+		assert.Error(t, err)
+		assert.Equal(t, tt.want, w.Code, tt.err.Error())
+	}
+}
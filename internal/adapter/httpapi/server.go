@@ -53,15 +53,33 @@ func New(opts Options) (*Server, error) {
 
 	engine := gin.New()
 	engine.Use(gin.Logger())
-	engine.Use(gin.Recovery())
 	engine.Use(middleware.RequestIDMiddleware())
+	engine.Use(middleware.TraceContextMiddleware())
 	engine.Use(middleware.CORSMiddleware())
+	engine.Use(middleware.SSEGzipMiddleware())
+	// handler panic时按路由返回 Anthropic/OpenAI 错误，或为已开始的SSE流补发错误与结束事件
+	engine.Use(middleware.RecoveryMiddleware())
 	
 	// Dashboard管理员认证（如果启用）
 	engine.Use(middleware.AdminAuthMiddleware())
 	
+	// 管理接口认证：/admin/*、/api/tokens/*、/api/settings、/api/system/* 只接受管理员Token，未配置 ADMIN_TOKEN 时返回404
+	engine.Use(middleware.AdminRouteAuthMiddleware())
+
 	// API认证：保护 /v1/* 路径
-	engine.Use(middleware.PathBasedAuthMiddleware(opts.ClientToken, []string{"/v1"}))
+	engine.Use(middleware.PathBasedAuthMiddleware(opts.ClientToken, middleware.ClientAuthPrefixes))
+
+	// 按客户端密钥的限流档位限制请求速率（CLIENT_RATE_LIMIT_TIERS）
+	engine.Use(middleware.ClientRateLimitMiddleware())
+
+	// 校验客户端自定义的会话ID，按需按客户端密钥隔离（CONVERSATION_NAMESPACE）
+	engine.Use(middleware.ConversationOverrideMiddleware(middleware.ClientAuthPrefixes))
+
+	// 管理员调试用的单次请求头覆盖（需携带管理员Token）
+	engine.Use(middleware.HeaderOverrideMiddleware())
+
+	// 调试用的上游请求头日志（LOG_UPSTREAM_HEADERS=true 时生效）
+	engine.Use(middleware.UpstreamHeaderLogMiddleware())
 
 	handler := handlers.New(handlers.Options{
 		AuthService:  opts.AuthService,
@@ -0,0 +1,103 @@
+package support
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	logutil "kiro2api/internal/adapter/httpapi/logging"
+	"kiro2api/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+const eventStreamMediaType = "text/event-stream"
+
+// ErrStreamNotAcceptable 请求体要求流式响应，但Accept头明确排除了text/event-stream
+var ErrStreamNotAcceptable = errors.New("stream=true 但 Accept 头不接受 text/event-stream")
+
+// ResolveStreamMode 根据请求体stream字段与Accept头协商最终的响应模式
+// 规则：
+// - Accept为空或包含通配符时，以请求体stream字段为准
+// - stream=true 但Accept明确排除text/event-stream时，返回ErrStreamNotAcceptable
+// - stream缺失/false 但Accept只接受text/event-stream时，以Accept头为准开启流式
+func ResolveStreamMode(accept string, requested bool) (bool, error) {
+	mediaTypes := parseAcceptMediaTypes(accept)
+	if len(mediaTypes) == 0 {
+		return requested, nil
+	}
+
+	acceptsStream := false
+	onlyStream := true
+	for _, mediaType := range mediaTypes {
+		switch mediaType {
+		case eventStreamMediaType:
+			acceptsStream = true
+		case "*/*", "text/*":
+			acceptsStream = true
+			onlyStream = false
+		default:
+			onlyStream = false
+		}
+	}
+
+	if requested && !acceptsStream {
+		return false, ErrStreamNotAcceptable
+	}
+	if !requested && onlyStream {
+		return true, nil
+	}
+	return requested, nil
+}
+
+// NegotiateStream 协商响应模式，冲突时直接返回406
+// 返回值ok为false表示已写入错误响应，调用方应直接返回
+func NegotiateStream(c *gin.Context, requested bool) (stream bool, ok bool) {
+	accept := c.GetHeader("Accept")
+	stream, err := ResolveStreamMode(accept, requested)
+	if err != nil {
+		logger.Warn("流式响应协商失败",
+			logutil.AddFields(c,
+				logger.String("accept", accept),
+				logger.Bool("stream", requested),
+			)...)
+		RespondError(c, http.StatusNotAcceptable, "%s", "请求体指定 stream=true，但 Accept 头未接受 text/event-stream；请移除 stream 参数或在 Accept 中加入 text/event-stream")
+		return false, false
+	}
+
+	if stream != requested {
+		logger.Debug("根据Accept头切换为流式响应",
+			logutil.AddFields(c,
+				logger.String("accept", accept),
+			)...)
+	}
+	return stream, true
+}
+
+// parseAcceptMediaTypes 解析Accept头，返回q>0的媒体类型（小写、去除参数）
+func parseAcceptMediaTypes(accept string) []string {
+	var mediaTypes []string
+	for _, part := range strings.Split(accept, ",") {
+		segments := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(segments[0]))
+		if mediaType == "" {
+			continue
+		}
+
+		excluded := false
+		for _, param := range segments[1:] {
+			key, value, found := strings.Cut(strings.TrimSpace(param), "=")
+			if !found || strings.ToLower(strings.TrimSpace(key)) != "q" {
+				continue
+			}
+			if q, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil && q <= 0 {
+				excluded = true
+			}
+		}
+		if !excluded {
+			mediaTypes = append(mediaTypes, mediaType)
+		}
+	}
+	return mediaTypes
+}
@@ -0,0 +1,107 @@
+package support
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveStreamMode(t *testing.T) {
+	tests := []struct {
+		name           string
+		accept         string
+		requested      bool
+		expectedStream bool
+		expectedErr    error
+	}{
+		{
+			name:           "stream=true且Accept为SSE",
+			accept:         "text/event-stream",
+			requested:      true,
+			expectedStream: true,
+		},
+		{
+			name:        "stream=true但Accept仅为JSON",
+			accept:      "application/json",
+			requested:   true,
+			expectedErr: ErrStreamNotAcceptable,
+		},
+		{
+			name:           "stream=false且Accept为JSON",
+			accept:         "application/json",
+			requested:      false,
+			expectedStream: false,
+		},
+		{
+			name:           "stream=false但Accept仅为SSE",
+			accept:         "text/event-stream",
+			requested:      false,
+			expectedStream: true,
+		},
+		{
+			name:           "通配符Accept以请求体为准-流式",
+			accept:         "*/*",
+			requested:      true,
+			expectedStream: true,
+		},
+		{
+			name:           "通配符Accept以请求体为准-非流式",
+			accept:         "*/*",
+			requested:      false,
+			expectedStream: false,
+		},
+		{
+			name:           "缺失Accept以请求体为准",
+			accept:         "",
+			requested:      true,
+			expectedStream: true,
+		},
+		{
+			name:           "混合Accept包含SSE",
+			accept:         "application/json, text/event-stream;q=0.9",
+			requested:      true,
+			expectedStream: true,
+		},
+		{
+			name:        "q=0视为明确排除",
+			accept:      "application/json, text/event-stream;q=0",
+			requested:   true,
+			expectedErr: ErrStreamNotAcceptable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream, err := ResolveStreamMode(tt.accept, tt.requested)
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedStream, stream)
+		})
+	}
+}
+
+func TestNegotiateStream_NotAcceptable(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	c.Request.Header.Set("Accept", "application/json")
+
+	stream, ok := NegotiateStream(c, true)
+
+	assert.False(t, ok)
+	assert.False(t, stream)
+	assert.Equal(t, http.StatusNotAcceptable, w.Code)
+
+	var response map[string]any
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	errorObj, ok := response["error"].(map[string]any)
+	assert.True(t, ok)
+	assert.Equal(t, "not_acceptable", errorObj["code"])
+}
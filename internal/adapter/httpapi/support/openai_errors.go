@@ -0,0 +1,90 @@
+package support
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	srvcontext "kiro2api/internal/adapter/httpapi/context"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OpenAIChatCompletionsPath OpenAI 兼容路由，该路由上的错误统一使用 OpenAI 错误格式
+const OpenAIChatCompletionsPath = "/v1/chat/completions"
+
+// OpenAIError OpenAI 错误体中的 error 对象，param 和 code 没有值时输出 null
+type OpenAIError struct {
+	Message string  `json:"message"`
+	Type    string  `json:"type"`
+	Param   *string `json:"param"`
+	Code    *string `json:"code"`
+}
+
+// OpenAIErrorResponse OpenAI 客户端期望的错误响应体
+type OpenAIErrorResponse struct {
+	Error OpenAIError `json:"error"`
+}
+
+// openAIErrorCodes 内部错误码到 OpenAI 错误码的映射，未列出的原样返回
+var openAIErrorCodes = map[string]string{
+	"rate_limited": "rate_limit_exceeded",
+}
+
+// openAIErrorParams 与请求参数相关的错误码对应的 param 字段
+var openAIErrorParams = map[string]string{
+	"invalid_tool_choice":     "tool_choice",
+	"invalid_response_format": "response_format",
+	"unsupported_tool":        "tools",
+	"not_acceptable":          "stream",
+	"strict_history":          "messages",
+}
+
+// IsOpenAIRoute 判断当前请求是否走 OpenAI 兼容路由
+func IsOpenAIRoute(c *gin.Context) bool {
+	return c.Request != nil && c.Request.URL.Path == OpenAIChatCompletionsPath
+}
+
+// OpenAIErrorType 按状态码返回 OpenAI 错误类型
+func OpenAIErrorType(statusCode int) string {
+	switch {
+	case statusCode == http.StatusUnauthorized:
+		return "authentication_error"
+	case statusCode == http.StatusForbidden:
+		return "permission_error"
+	case statusCode == http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case statusCode >= http.StatusInternalServerError:
+		return "server_error"
+	default:
+		return "invalid_request_error"
+	}
+}
+
+// NewOpenAIErrorResponse 构造 OpenAI 格式的错误体，消息末尾附带请求ID便于排查
+func NewOpenAIErrorResponse(statusCode int, code, message, requestID string) OpenAIErrorResponse {
+	// 部分错误（如模型未找到）的消息本身已包含请求ID
+	if requestID != "" && !strings.Contains(message, "(request id:") {
+		message = fmt.Sprintf("%s (request id: %s)", message, requestID)
+	}
+
+	resp := OpenAIErrorResponse{Error: OpenAIError{
+		Message: message,
+		Type:    OpenAIErrorType(statusCode),
+	}}
+	if mapped, ok := openAIErrorCodes[code]; ok {
+		code = mapped
+	}
+	if code != "" {
+		resp.Error.Code = &code
+	}
+	if param, ok := openAIErrorParams[code]; ok {
+		resp.Error.Param = &param
+	}
+	return resp
+}
+
+// RespondOpenAIError 以 OpenAI 错误格式写入响应
+func RespondOpenAIError(c *gin.Context, statusCode int, code, message string) {
+	c.JSON(statusCode, NewOpenAIErrorResponse(statusCode, code, message, srvcontext.GetRequestID(c)))
+}
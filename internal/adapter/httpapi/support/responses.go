@@ -10,10 +10,16 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// RespondErrorWithCode 写入错误响应，OpenAI 兼容路由上改用 OpenAI 错误格式
 func RespondErrorWithCode(c *gin.Context, statusCode int, code string, format string, args ...any) {
+	message := fmt.Sprintf(format, args...)
+	if IsOpenAIRoute(c) {
+		RespondOpenAIError(c, statusCode, code, message)
+		return
+	}
 	c.JSON(statusCode, gin.H{
 		"error": gin.H{
-			"message": fmt.Sprintf(format, args...),
+			"message": message,
 			"code":    code,
 		},
 	})
@@ -30,6 +36,8 @@ func RespondError(c *gin.Context, statusCode int, format string, args ...any) {
 		code = "forbidden"
 	case http.StatusNotFound:
 		code = "not_found"
+	case http.StatusNotAcceptable:
+		code = "not_acceptable"
 	case http.StatusTooManyRequests:
 		code = "rate_limited"
 	default:
@@ -1,17 +1,13 @@
 package anthropic
 
 import (
-	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"strings"
-	"time"
 
 	"kiro2api/config"
 	srvcontext "kiro2api/internal/adapter/httpapi/context"
 	logutil "kiro2api/internal/adapter/httpapi/logging"
-	"kiro2api/internal/adapter/httpapi/support"
 	"kiro2api/internal/adapter/upstream/shared"
 	"kiro2api/internal/stats"
 	"kiro2api/logger"
@@ -35,7 +31,18 @@ func NewProxy(reverseProxy *shared.ReverseProxy) *Proxy {
 }
 
 func (p *Proxy) HandleStream(c *gin.Context, anthropicReq types.AnthropicRequest, tokenWithUsage *types.TokenWithUsage) {
-	sender := &shared.AnthropicStreamSender{}
+	// 携带 Last-Event-ID 的重连请求从检查点续传，不再请求上游
+	if shared.ResumeFromCheckpoint(c) {
+		return
+	}
+
+	checkpoint := shared.NewCheckpointWriter(&shared.AnthropicStreamSender{}, shared.CheckpointOwner(c))
+	defer checkpoint.Close()
+	// 客户端断开后继续读取上游，把剩余事件写入检查点
+	defer checkpoint.DetachUpstream(c)()
+
+	// 检查点记录转换后实际发送给客户端的字节
+	sender := shared.NewTransformingSender(checkpoint, shared.NewConfiguredResponseTransformer())
 	p.handleGenericStream(c, anthropicReq, tokenWithUsage, sender, createAnthropicStreamEvents)
 }
 
@@ -46,33 +53,23 @@ func (p *Proxy) handleGenericStream(
 	sender shared.StreamEventSender,
 	eventCreator func(string, int, string) []map[string]any,
 ) {
-	estimator := utils.NewTokenEstimator()
-	countReq := &types.CountTokensRequest{
-		Model:    anthropicReq.Model,
-		System:   anthropicReq.System,
-		Messages: anthropicReq.Messages,
-		Tools:    shared.FilterSupportedTools(anthropicReq.Tools),
-	}
-	inputTokens := estimator.EstimateTokens(countReq)
+	inputTokens := shared.RequestInputTokens(c, anthropicReq)
 
-	if err := shared.InitializeSSEResponse(c); err != nil {
-		_ = sender.SendError(c, "连接不支持SSE刷新", err)
-		return
-	}
-
-	messageID := fmt.Sprintf(config.MessageIDFormat, time.Now().Format(config.MessageIDTimeFormat))
+	messageID := fmt.Sprintf(config.MessageIDFormat, utils.MessageIDSuffix())
 	srvcontext.SetMessageID(c, messageID)
 
+	// 先等待上游接受请求再提交SSE响应头，Execute失败时已写入JSON错误响应
 	resp, err := p.reverseProxy.Execute(c, anthropicReq, token.TokenInfo, true)
 	if err != nil {
-		var modelNotFoundErrorType *types.ModelNotFoundErrorType
-		if errors.As(err, &modelNotFoundErrorType) {
-			return
-		}
-		_ = sender.SendError(c, "构建请求失败", err)
 		return
 	}
 	defer resp.Body.Close()
+	anthropicReq.Model = shared.ServedModel(c, anthropicReq.Model)
+
+	if err := shared.InitializeSSEResponse(c); err != nil {
+		_ = sender.SendError(c, "连接不支持SSE刷新", err)
+		return
+	}
 
 	ctx := shared.NewStreamProcessorContext(c, anthropicReq, token, sender, messageID, inputTokens)
 	defer ctx.Cleanup()
@@ -80,16 +77,19 @@ func (p *Proxy) handleGenericStream(
 	if err := ctx.SendInitialEvents(eventCreator); err != nil {
 		return
 	}
+	// 无论事件流如何结束（上游直接断开、处理出错或panic），都以 message_stop 收尾
+	defer ctx.FinishStream()
 
 	processor := shared.NewEventStreamProcessor(ctx)
-	if err := processor.ProcessEventStream(resp.Body); err != nil {
-		logger.Error("事件流处理失败", logger.Err(err))
+	// 上游偶尔以完整JSON响应流式请求，此时在本地合成事件序列
+	if shared.IsJSONResponse(resp) {
+		if err := processor.ProcessJSONResponse(resp.Body); err != nil {
+			logger.Error("伪流式响应处理失败", logger.Err(err))
+		}
 		return
 	}
-
-	if err := ctx.SendFinalEvents(); err != nil {
-		logger.Error("发送结束事件失败", logger.Err(err))
-		return
+	if err := processor.ProcessEventStream(resp.Body); err != nil {
+		logger.Error("事件流处理失败", logger.Err(err))
 	}
 }
 
@@ -119,14 +119,8 @@ func createAnthropicStreamEvents(messageID string, inputTokens int, model string
 }
 
 func (p *Proxy) HandleNonStream(c *gin.Context, anthropicReq types.AnthropicRequest, token types.TokenInfo) {
-	estimator := utils.NewTokenEstimator()
-	countReq := &types.CountTokensRequest{
-		Model:    anthropicReq.Model,
-		System:   anthropicReq.System,
-		Messages: anthropicReq.Messages,
-		Tools:    shared.FilterSupportedTools(anthropicReq.Tools),
-	}
-	inputTokens := estimator.EstimateTokens(countReq)
+	estimator := utils.NewTokenEstimatorForModel(anthropicReq.Model)
+	inputTokens := shared.RequestInputTokens(c, anthropicReq)
 
 	resp, err := p.reverseProxy.Execute(c, anthropicReq, token, false)
 	if err != nil {
@@ -135,80 +129,72 @@ func (p *Proxy) HandleNonStream(c *gin.Context, anthropicReq types.AnthropicRequ
 	defer func(Body io.ReadCloser) {
 		_ = Body.Close()
 	}(resp.Body)
+	anthropicReq.Model = shared.ServedModel(c, anthropicReq.Model)
 
-	body, err := utils.ReadHTTPResponse(resp.Body)
-	if err != nil {
-		support.HandleResponseReadError(c, err)
+	compliantParser := parser.NewCompliantEventStreamParser()
+	compliantParser.SetMaxErrors(config.ParserMaxErrors)
+
+	result, truncated, ok := shared.ParseNonStreamResponse(c, compliantParser, resp.Body)
+	if !ok {
 		return
 	}
 
-	compliantParser := parser.NewCompliantEventStreamParser()
-	compliantParser.SetMaxErrors(config.ParserMaxErrors)
+	contexts := []map[string]any{}
+	textAgg := result.GetCompletionText()
 
-	result, err := func() (*parser.ParseResult, error) {
-		done := make(chan struct{})
-		var result *parser.ParseResult
-		var err error
-
-		go func() {
-			defer func() {
-				if r := recover(); r != nil {
-					err = fmt.Errorf("解析器panic: %v", r)
-				}
-				close(done)
-			}()
-			result, err = compliantParser.ParseResponse(body)
-		}()
-
-		select {
-		case <-done:
-			return result, err
-		case <-time.After(10 * time.Second):
-			logger.Error("非流式解析超时")
-			return nil, fmt.Errorf("解析超时")
-		}
-	}()
+	// 按BlockIndex排序，保证并行工具调用的顺序稳定且与上游一致
+	// 响应被截断时只保留参数已完整接收的工具调用
+	allTools := compliantParser.GetToolManager().GetAllToolsOrdered()
+	if truncated {
+		allTools = compliantParser.GetToolManager().GetCompletedToolsOrdered()
+	}
 
-	if err != nil {
-		logger.Error("非流式解析失败",
-			logger.Err(err),
-			logger.String("model", anthropicReq.Model),
-			logger.Int("response_size", len(body)))
+	sawToolUse := len(allTools) > 0
 
-		errorResp := gin.H{
+	checkedText, err := shared.CheckStructuredOutput(c, anthropicReq, textAgg, sawToolUse)
+	if err != nil {
+		if retryReq, ok := shared.StructuredOutputRetryRequest(c, anthropicReq, textAgg, err); ok {
+			p.HandleNonStream(c, retryReq, token)
+			return
+		}
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"type": "error",
 			"error": gin.H{
-				"error":   "响应解析失败",
-				"type":    "parsing_error",
-				"message": "无法解析AWS CodeWhisperer响应格式",
+				"type":    "invalid_response_error",
+				"message": "模型输出不符合 response_format 约束: " + err.Error(),
 			},
-		}
-
-		statusCode := http.StatusInternalServerError
-		if strings.Contains(err.Error(), "解析超时") {
-			statusCode = http.StatusRequestTimeout
-			errorResp["error"].(gin.H)["message"] = "请求处理超时，请稍后重试"
-		} else if strings.Contains(err.Error(), "格式错误") {
-			statusCode = http.StatusBadRequest
-			errorResp["error"].(gin.H)["message"] = "请求格式不正确"
-		}
-
-		c.JSON(statusCode, errorResp)
+		})
 		return
 	}
+	textAgg = checkedText
 
-	contexts := []map[string]any{}
-	textAgg := result.GetCompletionText()
-
-	toolManager := compliantParser.GetToolManager()
-	allTools := make([]*parser.ToolExecution, 0)
-	for _, tool := range toolManager.GetActiveTools() {
-		allTools = append(allTools, tool)
+	// RESPONSE_FILTERS 作用于最终文本和工具参数，参数校验基于替换后的内容
+	responseFilter := shared.NewConfiguredResponseFilter()
+	textAgg = responseFilter.FilterText(textAgg)
+	for _, tool := range allTools {
+		tool.Arguments = responseFilter.FilterToolInput(c, tool.Arguments)
 	}
-	for _, tool := range toolManager.GetCompletedTools() {
-		allTools = append(allTools, tool)
+	responseFilter.LogSummary(c)
+
+	// 上游不保证遵守 stop_sequences，命中时截断文本，停止序列之后的工具调用不再返回
+	stopSequences := shared.NewStopSequenceMatcher(anthropicReq.StopSequences)
+	if stoppedText, ok := stopSequences.Truncate(textAgg); ok {
+		textAgg = stoppedText
+		allTools = nil
+		sawToolUse = false
 	}
 
-	sawToolUse := len(allTools) > 0
+	toolArgsViolations, err := shared.CheckToolArgs(c, anthropicReq, allTools)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"type": "error",
+			"error": gin.H{
+				"type":    "invalid_response_error",
+				"message": err.Error(),
+			},
+		})
+		return
+	}
 
 	if textAgg != "" {
 		contexts = append(contexts, map[string]any{
@@ -231,6 +217,7 @@ func (p *Proxy) HandleNonStream(c *gin.Context, anthropicReq types.AnthropicRequ
 	}
 
 	stopReasonManager := shared.NewStopReasonManager(anthropicReq)
+	stopReasonManager.SetStopSequence(stopSequences.Matched())
 
 	outputTokens := 0
 	for _, contentBlock := range contexts {
@@ -254,30 +241,41 @@ func (p *Proxy) HandleNonStream(c *gin.Context, anthropicReq types.AnthropicRequ
 
 	stopReasonManager.UpdateToolCallStatus(sawToolUse, sawToolUse)
 	stopReason := stopReasonManager.DetermineStopReason()
+	var stopSequence any
+	if stopReason == "stop_sequence" {
+		stopSequence = stopReasonManager.StopSequence()
+	}
 
 	anthropicResp := map[string]any{
 		"content":       contexts,
 		"model":         anthropicReq.Model,
 		"role":          "assistant",
 		"stop_reason":   stopReason,
-		"stop_sequence": nil,
+		"stop_sequence": stopSequence,
 		"type":          "message",
 		"usage": map[string]any{
 			"input_tokens":  inputTokens,
 			"output_tokens": outputTokens,
 		},
 	}
+	if len(toolArgsViolations) > 0 {
+		anthropicResp["x_kiro_validation"] = map[string]any{"tool_args": toolArgsViolations}
+	}
 
 	logger.Debug("下发非流式响应",
 		logutil.AddFields(c,
 			logger.String("direction", "downstream_send"),
 			logger.Any("contexts", contexts),
 			logger.Bool("saw_tool_use", sawToolUse),
+			logger.Bool("truncated", truncated),
 			logger.Int("content_count", len(contexts)),
 		)...)
 
 	// 记录 token 使用统计
-	stats.GetCollector().Record(inputTokens, outputTokens, anthropicReq.Model)
+	shared.RecordTokenUsage(c, inputTokens, outputTokens, anthropicReq.Model)
+	stats.GetModelUpstreamStats().RecordStopReason(anthropicReq.Model, stopReason)
+	shared.RecordConversationTurn(c, anthropicReq, inputTokens, outputTokens, stopReason, textAgg)
 
+	shared.NewConfiguredResponseTransformer().TransformResponse(anthropicResp)
 	c.JSON(http.StatusOK, anthropicResp)
 }
@@ -0,0 +1,921 @@
+package anthropic
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"kiro2api/config"
+	srvcontext "kiro2api/internal/adapter/httpapi/context"
+	"kiro2api/internal/adapter/upstream/shared"
+	"kiro2api/internal/stats"
+	"kiro2api/parser"
+	"kiro2api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// upstreamStatusClient 返回固定状态码的上游客户端，不发出真实网络请求
+func upstreamStatusClient(status int, body string) *http.Client {
+	return &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: status,
+			Header:     http.Header{},
+			Body:       io.NopCloser(strings.NewReader(body)),
+			Request:    req,
+		}, nil
+	})}
+}
+
+func TestHandleStream_UpstreamRejectionReturnsJSONError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("SSE_PROXY_PADDING", "true")
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+
+	proxy := NewProxy(shared.NewReverseProxy(upstreamStatusClient(http.StatusForbidden, `{"message":"forbidden"}`)))
+	proxy.HandleStream(c, types.AnthropicRequest{
+		Model:     "claude-sonnet-4",
+		MaxTokens: 16,
+		Stream:    true,
+		Messages:  []types.AnthropicRequestMessage{{Role: "user", Content: "hi"}},
+	}, &types.TokenWithUsage{TokenInfo: types.TokenInfo{AccessToken: "token"}})
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.True(t, strings.HasPrefix(w.Header().Get("Content-Type"), "application/json"), "上游拒绝时不应提交SSE响应头")
+	assert.NotContains(t, w.Body.String(), "event:")
+
+	var body map[string]map[string]any
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body), "响应体应为完整的JSON: %q", w.Body.String())
+	assert.Equal(t, "unauthorized", body["error"]["code"])
+}
+
+// eventStreamFrame 构造一帧上游 EventStream 事件（不校验CRC）
+func eventStreamFrame(t *testing.T, eventType string, payload any) []byte {
+	t.Helper()
+	body, err := json.Marshal(payload)
+	require.NoError(t, err)
+
+	var headers []byte
+	for _, h := range [][2]string{
+		{":message-type", parser.MessageTypes.EVENT},
+		{":event-type", eventType},
+		{":content-type", "application/json"},
+	} {
+		headers = append(headers, byte(len(h[0])))
+		headers = append(headers, h[0]...)
+		headers = append(headers, 7) // string 类型
+		headers = binary.BigEndian.AppendUint16(headers, uint16(len(h[1])))
+		headers = append(headers, h[1]...)
+	}
+
+	totalLength := 12 + len(headers) + len(body) + 4
+	frame := make([]byte, 12, totalLength)
+	binary.BigEndian.PutUint32(frame[0:4], uint32(totalLength))
+	binary.BigEndian.PutUint32(frame[4:8], uint32(len(headers)))
+	frame = append(frame, headers...)
+	frame = append(frame, body...)
+	return append(frame, 0, 0, 0, 0)
+}
+
+// sseUsage 解析SSE响应，返回 message_start 与 message_delta 中的 usage.input_tokens
+func sseUsage(t *testing.T, body string) (start, delta int) {
+	t.Helper()
+	start, delta = -1, -1
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var event struct {
+			Type    string `json:"type"`
+			Message struct {
+				Usage struct {
+					InputTokens int `json:"input_tokens"`
+				} `json:"usage"`
+			} `json:"message"`
+			Usage struct {
+				InputTokens int `json:"input_tokens"`
+			} `json:"usage"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(data), &event))
+		switch event.Type {
+		case "message_start":
+			start = event.Message.Usage.InputTokens
+		case "message_delta":
+			delta = event.Usage.InputTokens
+		}
+	}
+	require.GreaterOrEqual(t, start, 0, "缺少 message_start")
+	require.GreaterOrEqual(t, delta, 0, "缺少 message_delta")
+	return start, delta
+}
+
+// TestHandleStream_InputTokensConsistent message_start 与最终 message_delta 的 input_tokens 必须一致
+func TestHandleStream_InputTokensConsistent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var upstream bytes.Buffer
+	upstream.Write(eventStreamFrame(t, parser.EventTypes.ASSISTANT_RESPONSE_EVENT, map[string]any{"content": "Checking the weather."}))
+	upstream.Write(eventStreamFrame(t, parser.EventTypes.TOOL_USE_EVENT, map[string]any{
+		"toolUseId": "tooluse_weather", "name": "get_weather", "input": map[string]any{"city": "Paris"}, "stop": true,
+	}))
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(bytes.NewReader(upstream.Bytes())), Request: req}, nil
+	})}
+
+	req := types.AnthropicRequest{
+		Model:     "claude-sonnet-4",
+		MaxTokens: 1024,
+		Stream:    true,
+		System:    []types.AnthropicSystemMessage{{Type: "text", Text: strings.Repeat("You are a careful assistant. ", 40)}},
+		Messages:  []types.AnthropicRequestMessage{{Role: "user", Content: "What is the weather in Paris?"}},
+		Tools: []types.AnthropicTool{
+			{
+				Name:        "get_weather",
+				Description: "Get the current weather for a city",
+				InputSchema: map[string]any{"type": "object", "properties": map[string]any{"city": map[string]any{"type": "string"}}},
+			},
+			{Name: "web_search", Description: "Search the web", InputSchema: map[string]any{"type": "object"}},
+		},
+	}
+
+	run := func(t *testing.T, setup func(c *gin.Context)) (int, int) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+		setup(c)
+
+		NewProxy(shared.NewReverseProxy(client)).HandleStream(c, req, &types.TokenWithUsage{TokenInfo: types.TokenInfo{AccessToken: "token"}})
+		require.Equal(t, http.StatusOK, w.Code)
+		return sseUsage(t, w.Body.String())
+	}
+
+	t.Run("处理器记录的值", func(t *testing.T) {
+		start, delta := run(t, func(c *gin.Context) { srvcontext.SetInputTokens(c, 4321) })
+		assert.Equal(t, 4321, start)
+		assert.Equal(t, start, delta)
+	})
+
+	t.Run("未记录时现场估算", func(t *testing.T) {
+		start, delta := run(t, func(*gin.Context) {})
+		assert.Equal(t, shared.EstimateInputTokens(req), start)
+		assert.Equal(t, start, delta)
+	})
+}
+
+// failingReader 输出 data 后返回 err，模拟上游连接在响应体中途被重置
+type failingReader struct {
+	data []byte
+	err  error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, r.err
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestHandleNonStream_UpstreamDropsMidBody(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var fixture bytes.Buffer
+	fixture.Write(eventStreamFrame(t, parser.EventTypes.ASSISTANT_RESPONSE_EVENT, map[string]any{"content": "The weather in Paris "}))
+	fixture.Write(eventStreamFrame(t, parser.EventTypes.ASSISTANT_RESPONSE_EVENT, map[string]any{"content": "is sunny."}))
+	fixture.Write(eventStreamFrame(t, parser.EventTypes.TOOL_USE_EVENT, map[string]any{
+		"toolUseId": "tooluse_forecast", "name": "get_forecast", "input": `{"city":`,
+	}))
+	fixture.Write(eventStreamFrame(t, parser.EventTypes.TOOL_USE_EVENT, map[string]any{
+		"toolUseId": "tooluse_forecast", "name": "get_forecast", "input": `"Paris"}`, "stop": true,
+	}))
+
+	run := func(t *testing.T, body io.Reader) *httptest.ResponseRecorder {
+		client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(body), Request: req}, nil
+		})}
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+		NewProxy(shared.NewReverseProxy(client)).HandleNonStream(c, types.AnthropicRequest{
+			Model:     "claude-sonnet-4",
+			MaxTokens: 1024,
+			Messages:  []types.AnthropicRequestMessage{{Role: "user", Content: "What is the weather in Paris?"}},
+		}, types.TokenInfo{AccessToken: "token"})
+		return w
+	}
+	half := fixture.Bytes()[:fixture.Len()/2]
+	dropAfter := func(received []byte) io.Reader {
+		return &failingReader{data: append([]byte(nil), received...), err: io.ErrUnexpectedEOF}
+	}
+
+	t.Run("默认返回5xx", func(t *testing.T) {
+		w := run(t, dropAfter(half))
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.Empty(t, w.Header().Get(shared.TruncatedUpstreamHeader))
+	})
+
+	t.Run("启用后返回已收到的部分内容", func(t *testing.T) {
+		t.Setenv("PARTIAL_RESPONSE_ON_ERROR", "true")
+		w := run(t, dropAfter(half))
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Equal(t, "true", w.Header().Get(shared.TruncatedUpstreamHeader))
+
+		var resp struct {
+			Content    []map[string]any `json:"content"`
+			StopReason string           `json:"stop_reason"`
+			Usage      struct {
+				OutputTokens int `json:"output_tokens"`
+			} `json:"usage"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		require.Len(t, resp.Content, 1, "参数不完整的工具调用不应返回")
+		assert.Equal(t, "text", resp.Content[0]["type"])
+		assert.Equal(t, "The weather in Paris is sunny.", resp.Content[0]["text"])
+		assert.Equal(t, "end_turn", resp.StopReason)
+		assert.Positive(t, resp.Usage.OutputTokens)
+	})
+
+	t.Run("没有可用内容时仍返回5xx", func(t *testing.T) {
+		t.Setenv("PARTIAL_RESPONSE_ON_ERROR", "true")
+		w := run(t, dropAfter(half[:10]))
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.Empty(t, w.Header().Get(shared.TruncatedUpstreamHeader))
+	})
+
+	t.Run("完整响应不受影响", func(t *testing.T) {
+		t.Setenv("PARTIAL_RESPONSE_ON_ERROR", "true")
+		w := run(t, bytes.NewReader(fixture.Bytes()))
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get(shared.TruncatedUpstreamHeader))
+		assert.Contains(t, w.Body.String(), `"tool_use"`)
+	})
+}
+
+func TestHandleNonStream_StructuredOutput(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// run 依次以 replies 作为每次上游请求的回复，返回客户端响应和发往上游的请求体
+	run := func(t *testing.T, replies ...string) (*httptest.ResponseRecorder, []string) {
+		var upstreamBodies []string
+		client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			body, _ := io.ReadAll(req.Body)
+			upstreamBodies = append(upstreamBodies, string(body))
+			reply := replies[min(len(upstreamBodies), len(replies))-1]
+			upstream := eventStreamFrame(t, parser.EventTypes.ASSISTANT_RESPONSE_EVENT, map[string]any{"content": reply})
+			return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(bytes.NewReader(upstream)), Request: req}, nil
+		})}
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+		NewProxy(shared.NewReverseProxy(client)).HandleNonStream(c, types.AnthropicRequest{
+			Model:     "claude-sonnet-4",
+			MaxTokens: 1024,
+			Messages:  []types.AnthropicRequestMessage{{Role: "user", Content: "Describe Paris"}},
+			ResponseFormat: &types.ResponseFormat{Type: "json_schema", JSONSchema: &types.JSONSchemaFormat{
+				Name: "city",
+				Schema: map[string]any{
+					"type":       "object",
+					"required":   []any{"name"},
+					"properties": map[string]any{"name": map[string]any{"type": "string"}},
+				},
+			}},
+		}, types.TokenInfo{AccessToken: "token"})
+		return w, upstreamBodies
+	}
+
+	responseText := func(t *testing.T, w *httptest.ResponseRecorder) string {
+		t.Helper()
+		var resp struct {
+			Content []map[string]any `json:"content"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		require.Len(t, resp.Content, 1)
+		text, _ := resp.Content[0]["text"].(string)
+		return text
+	}
+
+	t.Run("符合schema时返回去掉围栏的JSON", func(t *testing.T) {
+		w, bodies := run(t, "```json\n{\"name\": \"Paris\"}\n```")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Len(t, bodies, 1)
+		assert.Equal(t, `{"name": "Paris"}`, responseText(t, w))
+	})
+
+	t.Run("从说明文字中提取JSON", func(t *testing.T) {
+		w, bodies := run(t, `Here you go: {"name": "Paris"} Let me know if you need more.`)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Len(t, bodies, 1)
+		assert.Equal(t, `{"name": "Paris"}`, responseText(t, w))
+	})
+
+	t.Run("不符合schema时附加纠错指令重试一次", func(t *testing.T) {
+		w, bodies := run(t, `{"city": "Paris"}`, `{"name": "Paris"}`)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Len(t, bodies, 2)
+		assert.Contains(t, bodies[1], "缺少必需字段 name")
+		assert.Contains(t, bodies[1], `{\"city\": \"Paris\"}`)
+		assert.Equal(t, `{"name": "Paris"}`, responseText(t, w))
+	})
+
+	t.Run("重试后仍不符合schema时返回422", func(t *testing.T) {
+		w, bodies := run(t, `{"city": "Paris"}`, `{"town": "Paris"}`)
+		require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Len(t, bodies, 2)
+
+		var body struct {
+			Type  string `json:"type"`
+			Error struct {
+				Type    string `json:"type"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		assert.Equal(t, "error", body.Type)
+		assert.Equal(t, "invalid_response_error", body.Error.Type)
+		assert.Contains(t, body.Error.Message, "缺少必需字段 name")
+	})
+}
+
+// TestHandleNonStream_StopSequence 上游未遵守 stop_sequences 时在本地截断并返回 stop_sequence
+func TestHandleNonStream_StopSequence(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		upstream := eventStreamFrame(t, parser.EventTypes.ASSISTANT_RESPONSE_EVENT, map[string]any{"content": "1, 2, 3\n\nHuman: 继续"})
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(bytes.NewReader(upstream)), Request: req}, nil
+	})}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	NewProxy(shared.NewReverseProxy(client)).HandleNonStream(c, types.AnthropicRequest{
+		Model:         "claude-sonnet-4",
+		MaxTokens:     1024,
+		Messages:      []types.AnthropicRequestMessage{{Role: "user", Content: "数到三"}},
+		StopSequences: []string{"\n\nHuman:"},
+	}, types.TokenInfo{AccessToken: "token"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var resp struct {
+		Content      []map[string]any `json:"content"`
+		StopReason   string           `json:"stop_reason"`
+		StopSequence *string          `json:"stop_sequence"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Content, 1)
+	assert.Equal(t, "1, 2, 3", resp.Content[0]["text"])
+	assert.Equal(t, "stop_sequence", resp.StopReason)
+	require.NotNil(t, resp.StopSequence)
+	assert.Equal(t, "\n\nHuman:", *resp.StopSequence)
+}
+
+// TestToolArgsValidation_MissingRequiredField 模型生成的工具参数缺少必需字段时，告警模式照常转发并附带校验结果，严格模式拦截
+func TestToolArgsValidation_MissingRequiredField(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	upstream := eventStreamFrame(t, parser.EventTypes.TOOL_USE_EVENT, map[string]any{
+		"toolUseId": "tooluse_weather", "name": "get_weather", "input": map[string]any{"town": "Paris"}, "stop": true,
+	})
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(bytes.NewReader(upstream)), Request: req}, nil
+	})}
+	req := types.AnthropicRequest{
+		Model:     "claude-sonnet-4",
+		MaxTokens: 1024,
+		Messages:  []types.AnthropicRequestMessage{{Role: "user", Content: "What is the weather in Paris?"}},
+		Tools: []types.AnthropicTool{{
+			Name:        "get_weather",
+			Description: "Get the current weather for a city",
+			InputSchema: map[string]any{
+				"type":       "object",
+				"required":   []any{"city"},
+				"properties": map[string]any{"city": map[string]any{"type": "string"}},
+			},
+		}},
+	}
+
+	run := func(t *testing.T, mode string, stream bool) *httptest.ResponseRecorder {
+		t.Setenv("VALIDATE_TOOL_ARGS", mode)
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+		proxy := NewProxy(shared.NewReverseProxy(client))
+		if stream {
+			proxy.HandleStream(c, req, &types.TokenWithUsage{TokenInfo: types.TokenInfo{AccessToken: "token"}})
+		} else {
+			proxy.HandleNonStream(c, req, types.TokenInfo{AccessToken: "token"})
+		}
+		return w
+	}
+
+	t.Run("告警模式_流式", func(t *testing.T) {
+		body := run(t, "true", true).Body.String()
+		assert.Contains(t, body, `"tool_use"`)
+		assert.Contains(t, body, "[工具调用参数校验]")
+		assert.Contains(t, body, "缺少必需字段 city")
+		assert.Contains(t, body, `"stop_reason":"tool_use"`)
+		assert.Less(t, strings.Index(body, `"tool_use"`), strings.Index(body, "[工具调用参数校验]"), "校验结果应追加在工具块之后")
+	})
+
+	t.Run("告警模式_非流式", func(t *testing.T) {
+		w := run(t, "true", false)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var resp struct {
+			Content    []map[string]any `json:"content"`
+			Validation struct {
+				ToolArgs []shared.ToolArgsViolation `json:"tool_args"`
+			} `json:"x_kiro_validation"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		require.Len(t, resp.Content, 1)
+		assert.Equal(t, "tool_use", resp.Content[0]["type"])
+		require.Len(t, resp.Validation.ToolArgs, 1)
+		assert.Equal(t, shared.ToolArgsViolation{ToolUseID: "tooluse_weather", Name: "get_weather", Path: "$", Message: "缺少必需字段 city"}, resp.Validation.ToolArgs[0])
+	})
+
+	t.Run("严格模式_流式", func(t *testing.T) {
+		body := run(t, "reject", true).Body.String()
+		assert.NotContains(t, body, `"tool_use"`, "不合规的工具块不应转发")
+		assert.Contains(t, body, `"invalid_response_error"`)
+		assert.Contains(t, body, "缺少必需字段 city")
+		assert.NotContains(t, body, "message_stop")
+	})
+
+	t.Run("严格模式_非流式", func(t *testing.T) {
+		w := run(t, "reject", false)
+		require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+		var body struct {
+			Error struct {
+				Type    string `json:"type"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		assert.Equal(t, "invalid_response_error", body.Error.Type)
+		assert.Contains(t, body.Error.Message, "缺少必需字段 city")
+	})
+
+	t.Run("严格模式下合规的调用照常转发", func(t *testing.T) {
+		t.Setenv("VALIDATE_TOOL_ARGS", "reject")
+		valid := req
+		valid.Tools = []types.AnthropicTool{{Name: "get_weather", InputSchema: map[string]any{"type": "object", "required": []any{"town"}}}}
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+		NewProxy(shared.NewReverseProxy(client)).HandleStream(c, valid, &types.TokenWithUsage{TokenInfo: types.TokenInfo{AccessToken: "token"}})
+
+		body := w.Body.String()
+		assert.Contains(t, body, `"tool_use"`)
+		assert.Contains(t, body, `"stop_reason":"tool_use"`)
+		assert.NotContains(t, body, "invalid_response_error")
+	})
+}
+
+// toolUseBlock 比较流式与非流式响应用的 tool_use 内容块
+type toolUseBlock struct {
+	ID    string
+	Name  string
+	Input map[string]any
+}
+
+// streamedToolUseBlocks 按内容块索引拼接 input_json_delta，还原完整的 tool_use 块
+func streamedToolUseBlocks(t *testing.T, body string) ([]toolUseBlock, string) {
+	t.Helper()
+	var order []int
+	blocks := map[int]*toolUseBlock{}
+	partials := map[int]string{}
+	stopReason := ""
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var event struct {
+			Type         string `json:"type"`
+			Index        int    `json:"index"`
+			ContentBlock struct {
+				Type string `json:"type"`
+				ID   string `json:"id"`
+				Name string `json:"name"`
+			} `json:"content_block"`
+			Delta struct {
+				Type        string `json:"type"`
+				PartialJSON string `json:"partial_json"`
+				StopReason  string `json:"stop_reason"`
+			} `json:"delta"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(data), &event), data)
+		switch {
+		case event.Type == "content_block_start" && event.ContentBlock.Type == "tool_use":
+			order = append(order, event.Index)
+			blocks[event.Index] = &toolUseBlock{ID: event.ContentBlock.ID, Name: event.ContentBlock.Name}
+		case event.Type == "content_block_delta" && event.Delta.Type == "input_json_delta":
+			partials[event.Index] += event.Delta.PartialJSON
+		case event.Type == "message_delta":
+			stopReason = event.Delta.StopReason
+		}
+	}
+
+	result := make([]toolUseBlock, 0, len(order))
+	for _, index := range order {
+		block := *blocks[index]
+		block.Input = map[string]any{}
+		if partial := partials[index]; partial != "" {
+			require.NoError(t, json.Unmarshal([]byte(partial), &block.Input), "块 %d 的参数: %s", index, partial)
+		}
+		result = append(result, block)
+	}
+	return result, stopReason
+}
+
+// TestToolUse_NonStreamMatchesStream 同一上游响应的流式与非流式 tool_use 块必须一致
+func TestToolUse_NonStreamMatchesStream(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// 首个片段不完整、stop事件携带最后一个片段、一次性完整参数、无参数
+	var upstream bytes.Buffer
+	upstream.Write(eventStreamFrame(t, parser.EventTypes.ASSISTANT_RESPONSE_EVENT, map[string]any{"content": "Let me check."}))
+	upstream.Write(eventStreamFrame(t, parser.EventTypes.TOOL_USE_EVENT, map[string]any{
+		"toolUseId": "tooluse_forecast", "name": "get_forecast", "input": `{"city":`,
+	}))
+	upstream.Write(eventStreamFrame(t, parser.EventTypes.TOOL_USE_EVENT, map[string]any{
+		"toolUseId": "tooluse_forecast", "name": "get_forecast", "input": `"Paris","days":3}`, "stop": true,
+	}))
+	upstream.Write(eventStreamFrame(t, parser.EventTypes.TOOL_USE_EVENT, map[string]any{
+		"toolUseId": "tooluse_search", "name": "search_docs",
+		"input": map[string]any{"query": "umbrella", "filters": map[string]any{"lang": "fr"}}, "stop": true,
+	}))
+	upstream.Write(eventStreamFrame(t, parser.EventTypes.TOOL_USE_EVENT, map[string]any{
+		"toolUseId": "tooluse_time", "name": "current_time", "stop": true,
+	}))
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(bytes.NewReader(upstream.Bytes())), Request: req}, nil
+	})}
+	req := types.AnthropicRequest{
+		Model:     "claude-sonnet-4",
+		MaxTokens: 1024,
+		Messages:  []types.AnthropicRequestMessage{{Role: "user", Content: "Will it rain in Paris?"}},
+	}
+	run := func(handle func(p *Proxy, c *gin.Context)) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+		handle(NewProxy(shared.NewReverseProxy(client)), c)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		return w
+	}
+
+	w := run(func(p *Proxy, c *gin.Context) { p.HandleNonStream(c, req, types.TokenInfo{AccessToken: "token"}) })
+	var resp struct {
+		Content []struct {
+			Type  string         `json:"type"`
+			ID    string         `json:"id"`
+			Name  string         `json:"name"`
+			Input map[string]any `json:"input"`
+		} `json:"content"`
+		StopReason string `json:"stop_reason"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "tool_use", resp.StopReason)
+	var nonStream []toolUseBlock
+	for _, block := range resp.Content {
+		if block.Type == "tool_use" {
+			nonStream = append(nonStream, toolUseBlock{ID: block.ID, Name: block.Name, Input: block.Input})
+		}
+	}
+
+	streamReq := req
+	streamReq.Stream = true
+	w = run(func(p *Proxy, c *gin.Context) {
+		p.HandleStream(c, streamReq, &types.TokenWithUsage{TokenInfo: types.TokenInfo{AccessToken: "token"}})
+	})
+	stream, stopReason := streamedToolUseBlocks(t, w.Body.String())
+	assert.Equal(t, "tool_use", stopReason)
+
+	expected := []toolUseBlock{
+		{ID: "tooluse_forecast", Name: "get_forecast", Input: map[string]any{"city": "Paris", "days": float64(3)}},
+		{ID: "tooluse_search", Name: "search_docs", Input: map[string]any{"query": "umbrella", "filters": map[string]any{"lang": "fr"}}},
+		{ID: "tooluse_time", Name: "current_time", Input: map[string]any{}},
+	}
+	assert.Equal(t, expected, nonStream, "非流式")
+	assert.Equal(t, expected, stream, "流式")
+}
+
+// sseEventTypes 按顺序返回SSE响应中各事件的 type
+func sseEventTypes(t *testing.T, body string) []string {
+	t.Helper()
+	var types []string
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var event struct {
+			Type string `json:"type"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(data), &event), data)
+		types = append(types, event.Type)
+	}
+	return types
+}
+
+// panickingSender 在第一个 content_block_delta 时panic，模拟事件处理中的程序错误
+type panickingSender struct {
+	shared.AnthropicStreamSender
+	panicked bool
+}
+
+func (s *panickingSender) SendEvent(c *gin.Context, data any) error {
+	if event, ok := data.(map[string]any); ok && event["type"] == "content_block_delta" && !s.panicked {
+		s.panicked = true
+		panic("faulty sender")
+	}
+	return s.AnthropicStreamSender.SendEvent(c, data)
+}
+
+// TestHandleStream_AlwaysEndsWithMessageStop 上游直接断开或处理panic时，流仍以 message_delta 和 message_stop 结束且只结束一次
+func TestHandleStream_AlwaysEndsWithMessageStop(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var upstream bytes.Buffer
+	upstream.Write(eventStreamFrame(t, parser.EventTypes.ASSISTANT_RESPONSE_EVENT, map[string]any{"content": "Checking the forecast."}))
+	toolFrame := eventStreamFrame(t, parser.EventTypes.TOOL_USE_EVENT, map[string]any{
+		"toolUseId": "tooluse_forecast", "name": "get_forecast", "input": map[string]any{"city": "Paris"}, "stop": true,
+	})
+	// 最后一帧只收到一半，之后上游直接断开，没有任何结束事件
+	upstream.Write(toolFrame[:len(toolFrame)/2])
+
+	req := types.AnthropicRequest{
+		Model:     "claude-sonnet-4",
+		MaxTokens: 1024,
+		Stream:    true,
+		Messages:  []types.AnthropicRequestMessage{{Role: "user", Content: "Will it rain in Paris?"}},
+	}
+	run := func(t *testing.T, sender shared.StreamEventSender) []string {
+		client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(bytes.NewReader(upstream.Bytes())), Request: r}, nil
+		})}
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+		token := &types.TokenWithUsage{TokenInfo: types.TokenInfo{AccessToken: "token"}}
+		require.NotPanics(t, func() {
+			NewProxy(shared.NewReverseProxy(client)).handleGenericStream(c, req, token, sender, createAnthropicStreamEvents)
+		})
+		return sseEventTypes(t, w.Body.String())
+	}
+
+	t.Run("帧中途EOF", func(t *testing.T) {
+		events := run(t, &shared.AnthropicStreamSender{})
+		assert.Equal(t, []string{"message_start", "ping", "content_block_start", "content_block_delta", "content_block_stop", "message_delta", "message_stop"}, events)
+	})
+
+	t.Run("发送时panic", func(t *testing.T) {
+		sender := &panickingSender{}
+		events := run(t, sender)
+		require.True(t, sender.panicked)
+		assert.Equal(t, []string{"message_start", "ping", "content_block_start", "error", "content_block_stop", "message_delta", "message_stop"}, events)
+	})
+}
+
+func TestModelFallback_ResponseReportsServedModel(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	shared.SetModelFallbacks(config.ModelFallbacks{"claude-opus-4.5": {"claude-sonnet-4.5"}})
+	t.Cleanup(func() { shared.SetModelFallbacks(nil) })
+
+	// 上游对 claude-opus-4.5 报告容量不足，对回退模型正常回复
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		body, _ := io.ReadAll(req.Body)
+		if strings.Contains(string(body), `"modelId":"`+config.ModelMap["claude-opus-4.5"]+`"`) {
+			return &http.Response{StatusCode: http.StatusBadRequest, Header: http.Header{}, Request: req,
+				Body: io.NopCloser(strings.NewReader(`{"message":"model is at capacity","reason":"INSUFFICIENT_MODEL_CAPACITY"}`))}, nil
+		}
+		upstream := eventStreamFrame(t, parser.EventTypes.ASSISTANT_RESPONSE_EVENT, map[string]any{"content": "Paris"})
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(bytes.NewReader(upstream)), Request: req}, nil
+	})}
+
+	req := types.AnthropicRequest{
+		Model:     "claude-opus-4.5",
+		MaxTokens: 64,
+		Messages:  []types.AnthropicRequestMessage{{Role: "user", Content: "Capital of France?"}},
+	}
+	newContext := func() (*gin.Context, *httptest.ResponseRecorder) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+		return c, w
+	}
+
+	t.Run("非流式", func(t *testing.T) {
+		c, w := newContext()
+		NewProxy(shared.NewReverseProxy(client)).HandleNonStream(c, req, types.TokenInfo{AccessToken: "token"})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Equal(t, "claude-opus-4.5 -> claude-sonnet-4.5; reason=model_error", w.Header().Get(shared.ModelFallbackHeader))
+
+		var resp map[string]any
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, "claude-sonnet-4.5", resp["model"])
+	})
+
+	t.Run("流式", func(t *testing.T) {
+		c, w := newContext()
+		streamReq := req
+		streamReq.Stream = true
+		NewProxy(shared.NewReverseProxy(client)).HandleStream(c, streamReq, &types.TokenWithUsage{TokenInfo: types.TokenInfo{AccessToken: "token"}})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.NotEmpty(t, w.Header().Get(shared.ModelFallbackHeader))
+		assert.Contains(t, w.Body.String(), `"model":"claude-sonnet-4.5"`)
+		assert.NotContains(t, w.Body.String(), `"model":"claude-opus-4.5"`)
+	})
+}
+
+// TestHandleStream_JSONUpstreamPseudoStream 上游以JSON响应流式请求时，本地合成的事件序列须符合规范
+func TestHandleStream_JSONUpstreamPseudoStream(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	upstream := `[
+		{"assistantResponseEvent": {"content": "Checking "}},
+		{"assistantResponseEvent": {"content": "the forecast."}},
+		{"toolUseEvent": {"toolUseId": "tooluse_forecast", "name": "get_forecast", "input": "{\"city\":"}},
+		{"toolUseEvent": {"toolUseId": "tooluse_forecast", "name": "get_forecast", "input": "\"Paris\"}", "stop": true}}
+	]`
+	client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(upstream)),
+			Request:    r,
+		}, nil
+	})}
+	req := types.AnthropicRequest{
+		Model:     "claude-sonnet-4",
+		MaxTokens: 1024,
+		Stream:    true,
+		Messages:  []types.AnthropicRequestMessage{{Role: "user", Content: "Will it rain in Paris?"}},
+	}
+
+	pseudoStreams := func() int64 {
+		for _, metrics := range stats.GetModelUpstreamStats().Snapshot() {
+			if metrics.Model == req.Model {
+				return metrics.PseudoStreamsTotal
+			}
+		}
+		return 0
+	}
+	before := pseudoStreams()
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	NewProxy(shared.NewReverseProxy(client)).HandleStream(c, req, &types.TokenWithUsage{TokenInfo: types.TokenInfo{AccessToken: "token"}})
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, before+1, pseudoStreams())
+	assert.Equal(t, []string{
+		"message_start", "ping",
+		"content_block_start", "content_block_delta", "content_block_stop",
+		"content_block_start", "content_block_delta", "content_block_stop",
+		"message_delta", "message_stop",
+	}, sseEventTypes(t, w.Body.String()))
+
+	// 按严格模式重放，任何违规都会返回错误
+	replay, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ssm := shared.NewSSEStateManager(true)
+	var events []map[string]any
+	scanner := bufio.NewScanner(strings.NewReader(w.Body.String()))
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var event map[string]any
+		require.NoError(t, json.Unmarshal([]byte(data), &event))
+		require.NoError(t, ssm.SendEvent(replay, &shared.AnthropicStreamSender{}, event))
+		events = append(events, event)
+	}
+	assert.Empty(t, ssm.Violations())
+
+	assert.Equal(t, map[string]any{"type": "text_delta", "text": "Checking the forecast."}, events[3]["delta"])
+	assert.Equal(t, "tooluse_forecast", events[5]["content_block"].(map[string]any)["id"])
+	assert.JSONEq(t, `{"city":"Paris"}`, events[6]["delta"].(map[string]any)["partial_json"].(string))
+	assert.Equal(t, "tool_use", events[8]["delta"].(map[string]any)["stop_reason"])
+	assert.Greater(t, events[8]["usage"].(map[string]any)["output_tokens"], float64(0))
+}
+
+// TestHandleStream_CheckpointSurvivesClientDisconnect 启用检查点时客户端中途断开，上游读取不随之取消，
+// 断开后到达的事件写入检查点，携带 Last-Event-ID 重连时拿到剩余的完整响应
+func TestHandleStream_CheckpointSurvivesClientDisconnect(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("CHECKPOINT_DIR", t.TempDir())
+	t.Setenv("CHECKPOINT_INTERVAL_BYTES", "64")
+
+	// 与真实传输一样，上游请求的context取消时响应体随之中断
+	upstreamBody, upstreamWriter := io.Pipe()
+	client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		go func() {
+			<-r.Context().Done()
+			upstreamWriter.CloseWithError(r.Context().Err())
+		}()
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: upstreamBody, Request: r}, nil
+	})}
+	proxy := NewProxy(shared.NewReverseProxy(client))
+	req := types.AnthropicRequest{
+		Model:     "claude-sonnet-4",
+		MaxTokens: 1024,
+		Stream:    true,
+		Messages:  []types.AnthropicRequestMessage{{Role: "user", Content: "Tell me a story."}},
+	}
+
+	requests := make(chan context.Context, 2)
+	handled := make(chan struct{}, 2)
+	router := gin.New()
+	router.POST("/v1/messages", func(c *gin.Context) {
+		requests <- c.Request.Context()
+		proxy.HandleStream(c, req, &types.TokenWithUsage{TokenInfo: types.TokenInfo{AccessToken: "token"}})
+		handled <- struct{}{}
+	})
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	clientCtx, disconnect := context.WithCancel(context.Background())
+	defer disconnect()
+	httpReq, err := http.NewRequestWithContext(clientCtx, http.MethodPost, server.URL+"/v1/messages", strings.NewReader("{}"))
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(httpReq)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// 客户端收到第一段文本后断开
+	_, err = upstreamWriter.Write(eventStreamFrame(t, parser.EventTypes.ASSISTANT_RESPONSE_EVENT, map[string]any{"content": "Once upon a time"}))
+	require.NoError(t, err)
+	var lastEventID, received string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() && !strings.Contains(received, "Once upon a time") {
+		line := scanner.Text()
+		received += line + "\n"
+		if id, ok := strings.CutPrefix(line, "id: "); ok {
+			lastEventID = id
+		}
+	}
+	require.NotEmpty(t, lastEventID, received)
+	disconnect()
+	resp.Body.Close()
+	select {
+	case <-(<-requests).Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("服务端未感知客户端断开")
+	}
+
+	// 断开后上游继续输出，事件写入检查点
+	_, err = upstreamWriter.Write(eventStreamFrame(t, parser.EventTypes.ASSISTANT_RESPONSE_EVENT, map[string]any{"content": ", the end."}))
+	require.NoError(t, err, "客户端断开后上游读取不应被取消")
+	require.NoError(t, upstreamWriter.Close())
+	select {
+	case <-handled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("流未在上游结束后完成")
+	}
+
+	resumeReq, err := http.NewRequest(http.MethodPost, server.URL+"/v1/messages", strings.NewReader("{}"))
+	require.NoError(t, err)
+	resumeReq.Header.Set(shared.LastEventIDHeader, lastEventID)
+	resumeResp, err := http.DefaultClient.Do(resumeReq)
+	require.NoError(t, err)
+	defer resumeResp.Body.Close()
+	replay, err := io.ReadAll(resumeResp.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, resumeResp.StatusCode)
+	assert.NotContains(t, string(replay), "Once upon a time")
+	assert.Contains(t, string(replay), ", the end.")
+	assert.Equal(t, []string{"content_block_delta", "content_block_stop", "message_delta", "message_stop"}, sseEventTypes(t, string(replay)))
+}
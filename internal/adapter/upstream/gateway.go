@@ -17,8 +17,9 @@ type Gateway struct {
 	openai       *openai.Proxy
 }
 
-func NewGateway() *Gateway {
+func NewGateway(tokens shared.RetryTokenSource) *Gateway {
 	reverseProxy := shared.NewReverseProxy(nil)
+	reverseProxy.SetTokenSource(tokens)
 	return &Gateway{
 		reverseProxy: reverseProxy,
 		anthropic:    anthropic.NewProxy(reverseProxy),
@@ -26,19 +27,38 @@ func NewGateway() *Gateway {
 	}
 }
 
+// 各入口先由 ResolveWebSearch 在 WEB_SEARCH_MODE=local 时处理本地搜索（非流式在此完成往返，流式在响应体内边转发边完成），
+// 再交给对应协议的处理流程
+
 func (g *Gateway) HandleAnthropicStream(c *gin.Context, req types.AnthropicRequest, token *types.TokenWithUsage) {
+	req, ok := g.reverseProxy.ResolveWebSearch(c, req, token.TokenInfo, true)
+	if !ok {
+		return
+	}
 	g.anthropic.HandleStream(c, req, token)
 }
 
 func (g *Gateway) HandleAnthropicNonStream(c *gin.Context, req types.AnthropicRequest, token types.TokenInfo) {
+	req, ok := g.reverseProxy.ResolveWebSearch(c, req, token, false)
+	if !ok {
+		return
+	}
 	g.anthropic.HandleNonStream(c, req, token)
 }
 
 func (g *Gateway) HandleOpenAINonStream(c *gin.Context, req types.AnthropicRequest, token types.TokenInfo) {
+	req, ok := g.reverseProxy.ResolveWebSearch(c, req, token, false)
+	if !ok {
+		return
+	}
 	g.openai.HandleNonStream(c, req, token)
 }
 
 func (g *Gateway) HandleOpenAIStream(c *gin.Context, req types.AnthropicRequest, token types.TokenInfo) {
+	req, ok := g.reverseProxy.ResolveWebSearch(c, req, token, true)
+	if !ok {
+		return
+	}
 	g.openai.HandleStream(c, req, token)
 }
 
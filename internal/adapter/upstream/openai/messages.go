@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"sort"
 	"time"
 
 	"kiro2api/config"
@@ -38,23 +39,42 @@ func (p *Proxy) HandleNonStream(c *gin.Context, anthropicReq types.AnthropicRequ
 		return
 	}
 	defer resp.Body.Close()
+	anthropicReq.Model = shared.ServedModel(c, anthropicReq.Model)
 
-	body, err := utils.ReadHTTPResponse(resp.Body)
-	if err != nil {
-		support.HandleResponseReadError(c, err)
+	compliantParser := parser.NewCompliantEventStreamParser()
+	result, truncated, ok := shared.ParseNonStreamResponse(c, compliantParser, resp.Body)
+	if !ok {
 		return
 	}
 
-	compliantParser := parser.NewCompliantEventStreamParser()
-	result, err := compliantParser.ParseResponse(body)
+	contexts := []map[string]any{}
+	allContent := result.GetCompletionText()
+	// 按BlockIndex排序的工具调用，转换后的tool_calls顺序与上游发出顺序一致
+	// 响应被截断时只保留参数已完整接收的工具调用
+	toolCalls := result.GetToolCalls()
+	if truncated {
+		toolCalls = parser.SortToolsByBlockIndex(result.ToolExecutions)
+	}
+	sawToolUse := len(toolCalls) > 0
+
+	checkedContent, err := shared.CheckStructuredOutput(c, anthropicReq, allContent, sawToolUse)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "响应解析失败"})
+		if retryReq, ok := shared.StructuredOutputRetryRequest(c, anthropicReq, allContent, err); ok {
+			p.HandleNonStream(c, retryReq, token)
+			return
+		}
+		support.RespondErrorWithCode(c, http.StatusUnprocessableEntity, "invalid_json_response", "模型输出不符合 response_format 约束: %v", err)
 		return
 	}
+	allContent = checkedContent
 
-	contexts := []map[string]any{}
-	allContent := result.GetCompletionText()
-	sawToolUse := len(result.GetToolCalls()) > 0
+	// RESPONSE_FILTERS 作用于最终文本和工具参数
+	responseFilter := shared.NewConfiguredResponseFilter()
+	allContent = responseFilter.FilterText(allContent)
+	for _, tool := range toolCalls {
+		tool.Arguments = responseFilter.FilterToolInput(c, tool.Arguments)
+	}
+	responseFilter.LogSummary(c)
 
 	if allContent != "" {
 		contexts = append(contexts, map[string]any{
@@ -63,16 +83,20 @@ func (p *Proxy) HandleNonStream(c *gin.Context, anthropicReq types.AnthropicRequ
 		})
 	}
 
-	for _, tool := range result.GetToolCalls() {
-		contexts = append(contexts, map[string]any{
+	for _, tool := range toolCalls {
+		toolUseBlock := map[string]any{
 			"type":  "tool_use",
 			"id":    tool.ID,
 			"name":  tool.Name,
 			"input": tool.Arguments,
-		})
+		}
+		if tool.Arguments == nil {
+			toolUseBlock["input"] = map[string]any{}
+		}
+		contexts = append(contexts, toolUseBlock)
 	}
 
-	inputContent, _ := utils.GetMessageContent(anthropicReq.Messages[0].Content)
+	inputTokens := shared.RequestInputTokens(c, anthropicReq)
 	stopReason := "end_turn"
 	if sawToolUse {
 		stopReason = "tool_use"
@@ -86,41 +110,44 @@ func (p *Proxy) HandleNonStream(c *gin.Context, anthropicReq types.AnthropicRequ
 		"stop_sequence": nil,
 		"type":          "message",
 		"usage": map[string]any{
-			"input_tokens":  len(inputContent),
+			"input_tokens":  inputTokens,
 			"output_tokens": len(allContent),
 		},
 	}
 
-	openaiMessageID := fmt.Sprintf("chatcmpl-%s", time.Now().Format(config.MessageIDTimeFormat))
+	openaiMessageID := fmt.Sprintf("chatcmpl-%s", utils.MessageIDSuffix())
 	openaiResp := converter.ConvertAnthropicToOpenAI(anthropicResp, anthropicReq.Model, openaiMessageID)
 
 	// 记录 token 使用统计
-	stats.GetCollector().Record(len(inputContent), len(allContent), anthropicReq.Model)
+	shared.RecordTokenUsage(c, inputTokens, len(allContent), anthropicReq.Model)
+	stats.GetModelUpstreamStats().RecordStopReason(anthropicReq.Model, stopReason)
+	shared.RecordConversationTurn(c, anthropicReq, inputTokens, len(allContent), stopReason, allContent)
 
 	logger.Debug("下发OpenAI非流式响应",
 		logutil.AddFields(c,
 			logger.String("direction", "downstream_send"),
 			logger.Bool("saw_tool_use", sawToolUse),
+			logger.Bool("truncated", truncated),
 		)...)
 	c.JSON(http.StatusOK, openaiResp)
 }
 
 func (p *Proxy) HandleStream(c *gin.Context, anthropicReq types.AnthropicRequest, token types.TokenInfo) {
-	if err := shared.InitializeSSEResponse(c); err != nil {
-		support.RespondError(c, http.StatusInternalServerError, "%s", "流式响应初始化失败")
-		return
-	}
-
-	messageID := fmt.Sprintf("chatcmpl-%s", time.Now().Format(config.MessageIDTimeFormat))
+	messageID := fmt.Sprintf("chatcmpl-%s", utils.MessageIDSuffix())
 	srvcontext.SetMessageID(c, messageID)
 
+	// 先等待上游接受请求再提交SSE响应头，Execute失败时已写入JSON错误响应
 	resp, err := p.reverseProxy.Execute(c, anthropicReq, token, true)
 	if err != nil {
 		return
 	}
 	defer resp.Body.Close()
+	anthropicReq.Model = shared.ServedModel(c, anthropicReq.Model)
 
-	c.Writer.Flush()
+	if err := shared.InitializeSSEResponse(c); err != nil {
+		support.RespondError(c, http.StatusInternalServerError, "%s", "流式响应初始化失败")
+		return
+	}
 
 	sender := &shared.OpenAIStreamSender{}
 	initialEvent := map[string]any{
@@ -139,21 +166,91 @@ func (p *Proxy) HandleStream(c *gin.Context, anthropicReq types.AnthropicRequest
 		},
 	}
 	sender.SendEvent(c, initialEvent)
+	c.Writer.Flush()
 
 	compliantParser := parser.NewCompliantEventStreamParser()
+	defer shared.TrackToolState(compliantParser)()
 
 	toolIndexByToolUseID := make(map[string]int)
 	toolUseIDByBlockIndex := make(map[int]string)
+	toolArgsSent := make(map[string]bool)
 	nextToolIndex := 0
 	sawToolUse := false
 	sentFinal := false
 
+	// 按发送给客户端的文本和工具参数估算输出token，与Anthropic流式的计算方式一致，用于统计和客户端配额
+	estimator := utils.NewTokenEstimatorForModel(anthropicReq.Model)
+	outputTokens := 0
+	sendDelta := func(dataMap map[string]any) {
+		if delta, ok := dataMap["delta"].(map[string]any); ok {
+			if text, ok := delta["text"].(string); ok {
+				outputTokens += estimator.EstimateTextTokens(text)
+			} else if partialJSON, ok := delta["partial_json"].(string); ok {
+				outputTokens += (len(partialJSON) + 3) / 4
+			}
+		}
+		p.handleContentBlockDelta(c, sender, anthropicReq, messageID, dataMap, toolIndexByToolUseID, toolUseIDByBlockIndex, toolArgsSent)
+	}
+
+	// RESPONSE_FILTERS 未配置时为nil；文本末尾窗口内的原文和工具参数暂存到块结束时下发
+	responseFilter := shared.NewConfiguredResponseFilter()
+	defer responseFilter.LogSummary(c)
+	flushFiltered := func(index int) {
+		if event := responseFilter.Flush(index); event != nil {
+			sendDelta(event)
+		}
+	}
+	flushAllFiltered := func() {
+		for _, index := range responseFilter.PendingBlocks() {
+			flushFiltered(index)
+		}
+	}
+
+	handleEvent := func(dataMap map[string]any) {
+		switch dataMap["type"] {
+		case "content_block_delta":
+			if !filterDelta(responseFilter, dataMap) {
+				return
+			}
+			sendDelta(dataMap)
+		case "content_block_start":
+			if p.handleContentBlockStart(c, sender, anthropicReq, messageID, dataMap, toolIndexByToolUseID, toolUseIDByBlockIndex, &nextToolIndex) {
+				sawToolUse = true
+			}
+		case "message_delta":
+			flushAllFiltered()
+			p.sendPendingToolArguments(c, sender, anthropicReq, messageID, toolIndexByToolUseID, toolArgsSent)
+			if p.handleMessageDelta(c, sender, anthropicReq, messageID, dataMap) {
+				sentFinal = true
+			}
+		case "content_block_stop":
+			// 结束事件在 message_delta 中处理；没有参数的工具补发"{}"，与非流式保持一致
+			flushFiltered(eventBlockIndex(dataMap))
+			p.handleContentBlockStop(c, sender, anthropicReq, messageID, dataMap, toolIndexByToolUseID, toolUseIDByBlockIndex, toolArgsSent)
+		}
+		c.Writer.Flush()
+	}
+
 	totalBytesRead := 0
 	messageCount := 0
 	hasMoreData := true
 	consecutiveErrors := 0
 	const maxConsecutiveErrors = 3
 
+	// 上游偶尔以完整JSON响应流式请求，此时在本地合成事件序列，不再读取事件流
+	if shared.IsJSONResponse(resp) {
+		hasMoreData = false
+		events, err := shared.ReadPseudoStreamEvents(c, anthropicReq.Model, resp.Body)
+		if err != nil {
+			errResp := support.NewOpenAIErrorResponse(http.StatusBadGateway, "upstream_error", "上游返回了无法解析的非流式响应: "+err.Error(), srvcontext.GetRequestID(c))
+			sender.SendEvent(c, errResp)
+		}
+		messageCount = len(events)
+		for _, event := range events {
+			handleEvent(event)
+		}
+	}
+
 	buf := make([]byte, 8192)
 	for hasMoreData {
 		n, err := resp.Body.Read(buf)
@@ -174,22 +271,7 @@ func (p *Proxy) HandleStream(c *gin.Context, anthropicReq types.AnthropicRequest
 				if !ok {
 					continue
 				}
-
-				switch dataMap["type"] {
-				case "content_block_delta":
-					p.handleContentBlockDelta(c, sender, anthropicReq, messageID, dataMap, toolIndexByToolUseID, toolUseIDByBlockIndex)
-				case "content_block_start":
-					if p.handleContentBlockStart(c, sender, anthropicReq, messageID, dataMap, toolIndexByToolUseID, toolUseIDByBlockIndex, &nextToolIndex) {
-						sawToolUse = true
-					}
-				case "message_delta":
-					if p.handleMessageDelta(c, sender, anthropicReq, messageID, dataMap) {
-						sentFinal = true
-					}
-				case "content_block_stop":
-					// ignore; final events handled in message_delta
-				}
-				c.Writer.Flush()
+				handleEvent(dataMap)
 			}
 		}
 
@@ -217,6 +299,8 @@ func (p *Proxy) HandleStream(c *gin.Context, anthropicReq types.AnthropicRequest
 	}
 
 	if !sentFinal && messageCount > 0 {
+		flushAllFiltered()
+		p.sendPendingToolArguments(c, sender, anthropicReq, messageID, toolIndexByToolUseID, toolArgsSent)
 		finishReason := "stop"
 		if sawToolUse {
 			finishReason = "tool_calls"
@@ -239,14 +323,28 @@ func (p *Proxy) HandleStream(c *gin.Context, anthropicReq types.AnthropicRequest
 		c.Writer.Flush()
 	}
 
-	fmt.Fprintf(c.Writer, "data: [DONE]\\n\\n")
+	fmt.Fprint(c.Writer, "data: [DONE]\n\n")
 	c.Writer.Flush()
+	srvcontext.MarkStreamEnded(c)
+
+	// 审计只记录元数据和结束原因；客户端中途断开时上游读取失败结束循环，按已发送的内容记录用量
+	streamStopReason := "end_turn"
+	if sawToolUse {
+		streamStopReason = "tool_use"
+	}
+	if outputTokens < 1 && messageCount > 0 {
+		outputTokens = 1 // 与Anthropic流式一致的最小保护
+	}
+	shared.RecordTokenUsage(c, shared.RequestInputTokens(c, anthropicReq), outputTokens, anthropicReq.Model)
+	stats.GetModelUpstreamStats().RecordStopReason(anthropicReq.Model, streamStopReason)
+	shared.RecordConversationTurn(c, anthropicReq, 0, 0, streamStopReason, "")
 
 	logger.Debug("OpenAI流式转发完成",
 		logutil.AddFields(c,
 			logger.Int("bytes_read", totalBytesRead),
 			logger.Int("message_count", messageCount),
 			logger.Bool("saw_tool_use", sawToolUse),
+			logger.Int("output_tokens", outputTokens),
 		)...)
 }
 
@@ -258,6 +356,7 @@ func (p *Proxy) handleContentBlockDelta(
 	dataMap map[string]any,
 	toolIndexByToolUseID map[string]int,
 	toolUseIDByBlockIndex map[int]string,
+	toolArgsSent map[string]bool,
 ) {
 	delta, ok := dataMap["delta"].(map[string]any)
 	if !ok {
@@ -285,19 +384,7 @@ func (p *Proxy) handleContentBlockDelta(
 			sender.SendEvent(c, contentEvent)
 		}
 	case "input_json_delta":
-		toolBlockIndex := 0
-		if idxAny, ok := dataMap["index"]; ok {
-			switch v := idxAny.(type) {
-			case int:
-				toolBlockIndex = v
-			case int32:
-				toolBlockIndex = int(v)
-			case int64:
-				toolBlockIndex = int(v)
-			case float64:
-				toolBlockIndex = int(v)
-			}
-		}
+		toolBlockIndex := eventBlockIndex(dataMap)
 
 		toolUseID, ok := toolUseIDByBlockIndex[toolBlockIndex]
 		if !ok {
@@ -324,31 +411,125 @@ func (p *Proxy) handleContentBlockDelta(
 			return
 		}
 
-		toolDelta := map[string]any{
-			"id":      messageID,
-			"object":  "chat.completion.chunk",
-			"created": time.Now().Unix(),
-			"model":   anthropicReq.Model,
-			"choices": []map[string]any{
-				{
-					"index": 0,
-					"delta": map[string]any{
-						"tool_calls": []map[string]any{
-							{
-								"index": toolIdx,
-								"type":  "function",
-								"function": map[string]any{
-									"arguments": partial,
-								},
+		toolArgsSent[toolUseID] = true
+		p.sendToolArguments(c, sender, anthropicReq, messageID, toolIdx, partial)
+	}
+}
+
+// filterDelta 对增量应用 RESPONSE_FILTERS，返回false表示内容暂存在过滤器中，本次不下发
+func filterDelta(f *shared.ResponseFilter, dataMap map[string]any) bool {
+	delta, ok := dataMap["delta"].(map[string]any)
+	if !ok {
+		return true
+	}
+	index := eventBlockIndex(dataMap)
+
+	switch delta["type"] {
+	case "text_delta":
+		if !f.FiltersText() {
+			return true
+		}
+		text, _ := delta["text"].(string)
+		filtered := f.FilterTextDelta(index, text)
+		delta["text"] = filtered
+		return filtered != ""
+
+	case "input_json_delta":
+		partialJSON, _ := delta["partial_json"].(string)
+		return !f.BufferToolArgs(index, partialJSON)
+	}
+	return true
+}
+
+// handleContentBlockStop 工具块结束时若从未发送过参数，补发"{}"
+func (p *Proxy) handleContentBlockStop(
+	c *gin.Context,
+	sender *shared.OpenAIStreamSender,
+	anthropicReq types.AnthropicRequest,
+	messageID string,
+	dataMap map[string]any,
+	toolIndexByToolUseID map[string]int,
+	toolUseIDByBlockIndex map[int]string,
+	toolArgsSent map[string]bool,
+) {
+	toolUseID, ok := toolUseIDByBlockIndex[eventBlockIndex(dataMap)]
+	if !ok || toolArgsSent[toolUseID] {
+		return
+	}
+	toolIdx, ok := toolIndexByToolUseID[toolUseID]
+	if !ok {
+		return
+	}
+	toolArgsSent[toolUseID] = true
+	p.sendToolArguments(c, sender, anthropicReq, messageID, toolIdx, "{}")
+}
+
+// sendPendingToolArguments 结束前为从未发送过参数的工具补发"{}"
+// 一次性完整的无参数工具调用上游不会发送 content_block_stop
+func (p *Proxy) sendPendingToolArguments(
+	c *gin.Context,
+	sender *shared.OpenAIStreamSender,
+	anthropicReq types.AnthropicRequest,
+	messageID string,
+	toolIndexByToolUseID map[string]int,
+	toolArgsSent map[string]bool,
+) {
+	pending := make([]string, 0, len(toolIndexByToolUseID))
+	for toolUseID := range toolIndexByToolUseID {
+		if !toolArgsSent[toolUseID] {
+			pending = append(pending, toolUseID)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return toolIndexByToolUseID[pending[i]] < toolIndexByToolUseID[pending[j]]
+	})
+	for _, toolUseID := range pending {
+		toolArgsSent[toolUseID] = true
+		p.sendToolArguments(c, sender, anthropicReq, messageID, toolIndexByToolUseID[toolUseID], "{}")
+	}
+}
+
+// sendToolArguments 发送工具调用参数增量
+func (p *Proxy) sendToolArguments(c *gin.Context, sender *shared.OpenAIStreamSender, anthropicReq types.AnthropicRequest, messageID string, toolIdx int, arguments string) {
+	toolDelta := map[string]any{
+		"id":      messageID,
+		"object":  "chat.completion.chunk",
+		"created": time.Now().Unix(),
+		"model":   anthropicReq.Model,
+		"choices": []map[string]any{
+			{
+				"index": 0,
+				"delta": map[string]any{
+					"tool_calls": []map[string]any{
+						{
+							"index": toolIdx,
+							"type":  "function",
+							"function": map[string]any{
+								"arguments": arguments,
 							},
 						},
 					},
-					"finish_reason": nil,
 				},
+				"finish_reason": nil,
 			},
-		}
-		sender.SendEvent(c, toolDelta)
+		},
+	}
+	sender.SendEvent(c, toolDelta)
+}
+
+// eventBlockIndex 读取事件的内容块索引
+func eventBlockIndex(dataMap map[string]any) int {
+	switch v := dataMap["index"].(type) {
+	case int:
+		return v
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
 	}
+	return 0
 }
 
 func (p *Proxy) handleContentBlockStart(
@@ -376,19 +557,7 @@ func (p *Proxy) handleContentBlockStart(
 		return false
 	}
 
-	toolBlockIndex := 0
-	if idxAny, ok := dataMap["index"]; ok {
-		switch v := idxAny.(type) {
-		case int:
-			toolBlockIndex = v
-		case int32:
-			toolBlockIndex = int(v)
-		case int64:
-			toolBlockIndex = int(v)
-		case float64:
-			toolBlockIndex = int(v)
-		}
-	}
+	toolBlockIndex := eventBlockIndex(dataMap)
 
 	if _, exists := toolIndexByToolUseID[toolUseID]; !exists {
 		toolIndexByToolUseID[toolUseID] = *nextToolIndex
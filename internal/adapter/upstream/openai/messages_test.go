@@ -0,0 +1,337 @@
+package openai
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"kiro2api/config"
+	srvcontext "kiro2api/internal/adapter/httpapi/context"
+	"kiro2api/internal/adapter/upstream/shared"
+	"kiro2api/parser"
+	"kiro2api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestHandleStream_UpstreamRejectionReturnsJSONError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusForbidden,
+			Header:     http.Header{},
+			Body:       io.NopCloser(strings.NewReader(`{"message":"forbidden"}`)),
+			Request:    req,
+		}, nil
+	})}
+
+	NewProxy(shared.NewReverseProxy(client)).HandleStream(c, types.AnthropicRequest{
+		Model:     "claude-sonnet-4",
+		MaxTokens: 16,
+		Stream:    true,
+		Messages:  []types.AnthropicRequestMessage{{Role: "user", Content: "hi"}},
+	}, types.TokenInfo{AccessToken: "token"})
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.True(t, strings.HasPrefix(w.Header().Get("Content-Type"), "application/json"), "上游拒绝时不应提交SSE响应头")
+
+	var body map[string]map[string]any
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body), "响应体应为完整的JSON: %q", w.Body.String())
+	assert.Equal(t, "unauthorized", body["error"]["code"])
+}
+
+// eventStreamFrame 构造一帧上游 EventStream 事件（不校验CRC）
+func eventStreamFrame(t *testing.T, eventType string, payload any) []byte {
+	t.Helper()
+	body, err := json.Marshal(payload)
+	require.NoError(t, err)
+
+	var headers []byte
+	for _, h := range [][2]string{
+		{":message-type", parser.MessageTypes.EVENT},
+		{":event-type", eventType},
+		{":content-type", "application/json"},
+	} {
+		headers = append(headers, byte(len(h[0])))
+		headers = append(headers, h[0]...)
+		headers = append(headers, 7) // string 类型
+		headers = binary.BigEndian.AppendUint16(headers, uint16(len(h[1])))
+		headers = append(headers, h[1]...)
+	}
+
+	totalLength := 12 + len(headers) + len(body) + 4
+	frame := make([]byte, 12, totalLength)
+	binary.BigEndian.PutUint32(frame[0:4], uint32(totalLength))
+	binary.BigEndian.PutUint32(frame[4:8], uint32(len(headers)))
+	frame = append(frame, headers...)
+	frame = append(frame, body...)
+	return append(frame, 0, 0, 0, 0)
+}
+
+// toolUseFixture 文本后跟三个工具调用：分片参数、嵌套对象参数、无参数
+func toolUseFixture(t *testing.T) []byte {
+	var fixture bytes.Buffer
+	fixture.Write(eventStreamFrame(t, parser.EventTypes.ASSISTANT_RESPONSE_EVENT, map[string]any{"content": "Let me check."}))
+	fixture.Write(eventStreamFrame(t, parser.EventTypes.TOOL_USE_EVENT, map[string]any{
+		"toolUseId": "tooluse_forecast", "name": "get_forecast", "input": `{"city":`,
+	}))
+	fixture.Write(eventStreamFrame(t, parser.EventTypes.TOOL_USE_EVENT, map[string]any{
+		"toolUseId": "tooluse_forecast", "name": "get_forecast", "input": `"Paris","days":3}`, "stop": true,
+	}))
+	fixture.Write(eventStreamFrame(t, parser.EventTypes.TOOL_USE_EVENT, map[string]any{
+		"toolUseId": "tooluse_search", "name": "search_docs",
+		"input": map[string]any{"query": "umbrella", "filters": map[string]any{"lang": "fr", "tags": []string{"rain"}}}, "stop": true,
+	}))
+	fixture.Write(eventStreamFrame(t, parser.EventTypes.TOOL_USE_EVENT, map[string]any{
+		"toolUseId": "tooluse_time", "name": "current_time", "stop": true,
+	}))
+	return fixture.Bytes()
+}
+
+func runWithUpstream(t *testing.T, upstream []byte, handle func(p *Proxy, c *gin.Context)) *httptest.ResponseRecorder {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(bytes.NewReader(upstream)), Request: req}, nil
+	})}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	handle(NewProxy(shared.NewReverseProxy(client)), c)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	return w
+}
+
+// streamedToolCalls 按 tool_calls[].index 拼接流式分片，还原完整的工具调用
+func streamedToolCalls(t *testing.T, body string) ([]types.OpenAIToolCall, string) {
+	t.Helper()
+	var calls []types.OpenAIToolCall
+	finishReason := ""
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok || data == "[DONE]" {
+			continue
+		}
+		var chunk struct {
+			Choices []struct {
+				Delta struct {
+					ToolCalls []struct {
+						Index    int    `json:"index"`
+						ID       string `json:"id"`
+						Type     string `json:"type"`
+						Function struct {
+							Name      string `json:"name"`
+							Arguments string `json:"arguments"`
+						} `json:"function"`
+					} `json:"tool_calls"`
+				} `json:"delta"`
+				FinishReason *string `json:"finish_reason"`
+			} `json:"choices"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(data), &chunk), data)
+		for _, choice := range chunk.Choices {
+			if choice.FinishReason != nil {
+				finishReason = *choice.FinishReason
+			}
+			for _, delta := range choice.Delta.ToolCalls {
+				if delta.Index == len(calls) {
+					calls = append(calls, types.OpenAIToolCall{})
+				}
+				require.Less(t, delta.Index, len(calls), "tool_calls 的 index 必须连续")
+				call := &calls[delta.Index]
+				if delta.ID != "" {
+					call.ID = delta.ID
+				}
+				if delta.Type != "" {
+					call.Type = delta.Type
+				}
+				if delta.Function.Name != "" {
+					call.Function.Name = delta.Function.Name
+				}
+				call.Function.Arguments += delta.Function.Arguments
+			}
+		}
+	}
+	return calls, finishReason
+}
+
+// TestToolCalls_NonStreamMatchesStream 同一上游响应的流式与非流式 tool_calls 必须一致
+func TestToolCalls_NonStreamMatchesStream(t *testing.T) {
+	upstream := toolUseFixture(t)
+	req := types.AnthropicRequest{
+		Model:     "claude-sonnet-4",
+		MaxTokens: 1024,
+		Messages:  []types.AnthropicRequestMessage{{Role: "user", Content: "Will it rain in Paris?"}},
+	}
+
+	w := runWithUpstream(t, upstream, func(p *Proxy, c *gin.Context) {
+		p.HandleNonStream(c, req, types.TokenInfo{AccessToken: "token"})
+	})
+	var resp types.OpenAIResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Choices, 1)
+	nonStream := resp.Choices[0].Message.ToolCalls
+	assert.Equal(t, "tool_calls", resp.Choices[0].FinishReason)
+
+	streamReq := req
+	streamReq.Stream = true
+	w = runWithUpstream(t, upstream, func(p *Proxy, c *gin.Context) {
+		p.HandleStream(c, streamReq, types.TokenInfo{AccessToken: "token"})
+	})
+	stream, finishReason := streamedToolCalls(t, w.Body.String())
+	assert.Equal(t, "tool_calls", finishReason)
+
+	expected := []struct{ id, name, args string }{
+		{"tooluse_forecast", "get_forecast", `{"city":"Paris","days":3}`},
+		{"tooluse_search", "search_docs", `{"query":"umbrella","filters":{"lang":"fr","tags":["rain"]}}`},
+		{"tooluse_time", "current_time", `{}`},
+	}
+	for name, calls := range map[string][]types.OpenAIToolCall{"非流式": nonStream, "流式": stream} {
+		require.Len(t, calls, len(expected), name)
+		for i, want := range expected {
+			assert.Equal(t, want.id, calls[i].ID, "%s #%d", name, i)
+			assert.Equal(t, "function", calls[i].Type, "%s #%d", name, i)
+			assert.Equal(t, want.name, calls[i].Function.Name, "%s #%d", name, i)
+			assert.JSONEq(t, want.args, calls[i].Function.Arguments, "%s #%d", name, i)
+		}
+	}
+}
+
+// TestHandleStream_JSONUpstreamPseudoStream 上游以JSON响应流式请求时，合成的 tool_calls 与事件流一致
+func TestHandleStream_JSONUpstreamPseudoStream(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	upstream := `{"assistantResponseMessage": {"content": "Let me check.", "toolUses": [
+		{"toolUseId": "tooluse_forecast", "name": "get_forecast", "input": {"city": "Paris", "days": 3}},
+		{"toolUseId": "tooluse_time", "name": "current_time"}
+	]}}`
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"application/json; charset=utf-8"}},
+			Body:       io.NopCloser(strings.NewReader(upstream)),
+			Request:    req,
+		}, nil
+	})}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	NewProxy(shared.NewReverseProxy(client)).HandleStream(c, types.AnthropicRequest{
+		Model:     "claude-sonnet-4",
+		MaxTokens: 1024,
+		Stream:    true,
+		Messages:  []types.AnthropicRequestMessage{{Role: "user", Content: "Will it rain in Paris?"}},
+	}, types.TokenInfo{AccessToken: "token"})
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"content":"Let me check."`)
+	assert.True(t, strings.HasSuffix(w.Body.String(), "data: [DONE]\n\n"))
+
+	calls, finishReason := streamedToolCalls(t, w.Body.String())
+	assert.Equal(t, "tool_calls", finishReason)
+	require.Len(t, calls, 2)
+	assert.Equal(t, "tooluse_forecast", calls[0].ID)
+	assert.JSONEq(t, `{"city":"Paris","days":3}`, calls[0].Function.Arguments)
+	assert.Equal(t, "current_time", calls[1].Function.Name)
+	assert.JSONEq(t, `{}`, calls[1].Function.Arguments)
+
+	// 流式响应同样按发送的内容记录输出token，供统计和客户端配额结算
+	usage, ok := srvcontext.GetTokenUsage(c)
+	require.True(t, ok)
+	assert.Positive(t, usage.OutputTokens)
+}
+
+// streamedContent 拼接流式分片中的文本内容
+func streamedContent(t *testing.T, body string) string {
+	t.Helper()
+	var sb strings.Builder
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok || data == "[DONE]" {
+			continue
+		}
+		var chunk struct {
+			Choices []struct {
+				Delta struct {
+					Content string `json:"content"`
+				} `json:"delta"`
+			} `json:"choices"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(data), &chunk), data)
+		for _, choice := range chunk.Choices {
+			sb.WriteString(choice.Delta.Content)
+		}
+	}
+	return sb.String()
+}
+
+// TestResponseFilters_AppliedToOpenAIResponses RESPONSE_FILTERS 同样作用于 /v1/chat/completions 的文本和工具参数
+func TestResponseFilters_AppliedToOpenAIResponses(t *testing.T) {
+	filters, err := config.ParseResponseFilters(`[
+		{"pattern": "sk-[a-z0-9]{8}", "replacement": "[key]", "applies_to": "text"},
+		{"pattern": "/home/alice", "replacement": "~", "applies_to": "tool_args"}
+	]`)
+	require.NoError(t, err)
+	shared.SetResponseFilters(filters)
+	t.Cleanup(func() { shared.SetResponseFilters(nil) })
+
+	var upstream bytes.Buffer
+	upstream.Write(eventStreamFrame(t, parser.EventTypes.ASSISTANT_RESPONSE_EVENT, map[string]any{"content": "密钥是 sk-abc"}))
+	upstream.Write(eventStreamFrame(t, parser.EventTypes.ASSISTANT_RESPONSE_EVENT, map[string]any{"content": "d1234，读取文件。"}))
+	upstream.Write(eventStreamFrame(t, parser.EventTypes.TOOL_USE_EVENT, map[string]any{
+		"toolUseId": "tooluse_read", "name": "read_file", "input": `{"path":"/home/al`,
+	}))
+	upstream.Write(eventStreamFrame(t, parser.EventTypes.TOOL_USE_EVENT, map[string]any{
+		"toolUseId": "tooluse_read", "name": "read_file", "input": `ice/a.go"}`, "stop": true,
+	}))
+	req := types.AnthropicRequest{
+		Model:     "claude-sonnet-4",
+		MaxTokens: 1024,
+		Messages:  []types.AnthropicRequestMessage{{Role: "user", Content: "读取我的配置"}},
+	}
+
+	w := runWithUpstream(t, upstream.Bytes(), func(p *Proxy, c *gin.Context) {
+		p.HandleNonStream(c, req, types.TokenInfo{AccessToken: "token"})
+	})
+	var resp types.OpenAIResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Choices, 1)
+	assert.Equal(t, "密钥是 [key]，读取文件。", resp.Choices[0].Message.Content)
+	require.Len(t, resp.Choices[0].Message.ToolCalls, 1)
+	assert.JSONEq(t, `{"path":"~/a.go"}`, resp.Choices[0].Message.ToolCalls[0].Function.Arguments)
+
+	streamReq := req
+	streamReq.Stream = true
+	w = runWithUpstream(t, upstream.Bytes(), func(p *Proxy, c *gin.Context) {
+		p.HandleStream(c, streamReq, types.TokenInfo{AccessToken: "token"})
+	})
+	assert.Equal(t, "密钥是 [key]，读取文件。", streamedContent(t, w.Body.String()))
+	assert.NotContains(t, w.Body.String(), "sk-abc", "原文不能分段泄漏")
+	assert.NotContains(t, w.Body.String(), "alice")
+	calls, finishReason := streamedToolCalls(t, w.Body.String())
+	assert.Equal(t, "tool_calls", finishReason)
+	require.Len(t, calls, 1)
+	assert.JSONEq(t, `{"path":"~/a.go"}`, calls[0].Function.Arguments)
+}
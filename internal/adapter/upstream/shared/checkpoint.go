@@ -0,0 +1,445 @@
+package shared
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"kiro2api/config"
+	srvcontext "kiro2api/internal/adapter/httpapi/context"
+	logutil "kiro2api/internal/adapter/httpapi/logging"
+	"kiro2api/internal/adapter/httpapi/support"
+	"kiro2api/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LastEventIDHeader 客户端重连时携带的最后收到的SSE事件ID，格式为 "<检查点ID>:<序号>"，
+// 或按字节续传时的 "<检查点ID>@<已收到的字节数>"
+const LastEventIDHeader = "Last-Event-ID"
+
+// checkpointOwnerPrefix 检查点文件首行，记录创建者客户端密钥名称的摘要
+const checkpointOwnerPrefix = ": owner "
+
+var (
+	// ErrCheckpointNotFound 检查点文件不存在（未启用、已过期或已正常结束）
+	ErrCheckpointNotFound = errors.New("检查点不存在")
+	// ErrCheckpointBehind 客户端已收到的事件晚于检查点中的最后一个事件
+	ErrCheckpointBehind = errors.New("检查点落后于客户端已收到的事件")
+	// ErrCheckpointOwner 检查点属于其他客户端密钥
+	ErrCheckpointOwner = errors.New("检查点属于其他客户端密钥")
+)
+
+// checkpointIDPattern 允许作为检查点文件名的ID，防止 Last-Event-ID 指向目录外的文件
+var checkpointIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,128}$`)
+
+// checkpointSweepOnce 首次创建检查点时清理上次运行遗留的过期文件
+var checkpointSweepOnce sync.Once
+
+// CheckpointWriter 包装SSE发送器，为每个事件加上 "<检查点ID>:<序号>" 形式的 id，
+// 并按 CHECKPOINT_INTERVAL_BYTES 把已发送的原始字节追加到以检查点ID命名的文件，
+// 客户端断线后携带 Last-Event-ID 重连时从检查点续传
+// 检查点ID随机生成，文件首行记录创建者的客户端密钥，只有同一密钥可以续传
+// 客户端收到 message_stop 后删除检查点；客户端中途断开时上游读取继续（见 DetachUpstream），
+// 剩余事件照常写入检查点，流结束后 CHECKPOINT_TTL 删除
+// 每个事件的原始字节同时进入内存环形缓冲区，按字节偏移续传时包含尚未写入文件的部分
+type CheckpointWriter struct {
+	StreamEventSender
+	id       string
+	owner    string
+	path     string
+	interval int
+	ttl      time.Duration
+	buffer   *SSEEventBuffer
+
+	seq      int64
+	flushed  int64  // 已写入检查点文件的字节数（不含首行）
+	pending  []byte // 尚未写入检查点文件的字节
+	written  bool   // 检查点文件已创建
+	finished bool   // 客户端已收到 message_stop，检查点已删除
+	err      error  // 写检查点失败后不再尝试，不影响向客户端发送
+}
+
+// NewCheckpointWriter 创建检查点发送器，owner 为 CheckpointOwner 返回的创建者；未启用检查点时只转发事件
+func NewCheckpointWriter(sender StreamEventSender, owner string) *CheckpointWriter {
+	w := &CheckpointWriter{StreamEventSender: sender}
+	if !config.IsCheckpointEnabled() {
+		return w
+	}
+	id, err := newCheckpointID()
+	if err != nil {
+		logger.Warn("生成检查点ID失败，本次响应不写检查点", logger.Err(err))
+		return w
+	}
+	w.id = id
+	w.owner = owner
+	w.interval = config.CheckpointIntervalBytes()
+	w.ttl = config.CheckpointTTL()
+	w.path = checkpointPath(id)
+	w.buffer = registerSSEBuffer(id, owner, config.CheckpointBufferBytes())
+	checkpointSweepOnce.Do(func() { SweepExpiredCheckpoints(config.CheckpointDir(), w.ttl, time.Now()) })
+	return w
+}
+
+// DetachUpstream 启用检查点时让上游请求不随客户端断开取消，最长 CHECKPOINT_UPSTREAM_TIMEOUT，
+// 客户端断开后流继续处理到结束，续传时能拿到完整响应；返回的函数在流结束时调用以释放上游请求
+func (w *CheckpointWriter) DetachUpstream(c *gin.Context) context.CancelFunc {
+	if w.path == "" {
+		return func() {}
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(c.Request.Context()), config.CheckpointUpstreamTimeout())
+	srvcontext.SetUpstreamContext(c, ctx)
+	return cancel
+}
+
+// SendEvent 发送带 id 的事件并记录原始字节，累积到间隔字节数时写入检查点
+func (w *CheckpointWriter) SendEvent(c *gin.Context, data any) error {
+	if w.path == "" {
+		return w.StreamEventSender.SendEvent(c, data)
+	}
+
+	w.seq++
+	start := len(w.pending)
+	tee := &teeResponseWriter{ResponseWriter: c.Writer, buf: &w.pending}
+	c.Writer = tee
+	_, _ = tee.WriteString(fmt.Sprintf("id: %s:%d\n", w.id, w.seq))
+	err := w.StreamEventSender.SendEvent(c, data)
+	c.Writer = tee.ResponseWriter
+	w.buffer.Append(w.pending[start:])
+	if err != nil {
+		return err
+	}
+
+	if isMessageStop(data) && c.Request.Context().Err() == nil {
+		w.finish()
+		return nil
+	}
+	// 缓冲区淘汰了尚未写入文件的字节时立即写检查点，每个字节至少保存在文件或缓冲区之一
+	if len(w.pending) >= w.interval || w.buffer.EarliestOffset() > w.flushed {
+		w.flush()
+	}
+	return nil
+}
+
+// Close 流结束时调用：客户端未收到 message_stop 时写入剩余字节，CHECKPOINT_TTL 后删除检查点
+func (w *CheckpointWriter) Close() {
+	if w.path == "" || w.finished {
+		return
+	}
+	if w.seq == 0 {
+		releaseSSEBuffer(w.id)
+		return
+	}
+	w.flush()
+	id, path, written := w.id, w.path, w.written
+	time.AfterFunc(w.ttl, func() {
+		releaseSSEBuffer(id)
+		if written {
+			removeCheckpoint(path)
+		}
+	})
+}
+
+// flush 把累积的字节追加到检查点文件
+func (w *CheckpointWriter) flush() {
+	if len(w.pending) == 0 || w.err != nil {
+		return
+	}
+	data := w.pending
+	if !w.written {
+		data = append([]byte(checkpointOwnerPrefix+checkpointOwnerDigest(w.owner)+"\n\n"), data...)
+	}
+	if err := appendCheckpoint(w.path, data); err != nil {
+		w.err = err
+		logger.Warn("写入流式响应检查点失败", logger.String("checkpoint_id", w.id), logger.Err(err))
+		return
+	}
+	w.written = true
+	w.flushed += int64(len(w.pending))
+	w.pending = w.pending[:0]
+}
+
+// finish 客户端已收到完整响应，不再需要续传
+func (w *CheckpointWriter) finish() {
+	w.finished = true
+	w.pending = nil
+	releaseSSEBuffer(w.id)
+	if w.written {
+		removeCheckpoint(w.path)
+	}
+}
+
+// CheckpointOwner 检查点的创建者：请求使用的客户端密钥名称，未启用客户端密钥时为空
+func CheckpointOwner(c *gin.Context) string {
+	key, _ := srvcontext.GetClientKey(c)
+	return key.Name
+}
+
+// ResumeFromCheckpoint 请求携带 Last-Event-ID 且检查点存在时，以SSE重放该事件之后的内容并返回true
+// 检查点属于其他客户端密钥时返回403并返回true；未携带、格式无效或检查点不存在时返回false，调用方按新请求处理
+// 原请求仍在读取上游时检查点只包含已写入的部分，重放到该处为止
+func ResumeFromCheckpoint(c *gin.Context) bool {
+	lastEventID := strings.TrimSpace(c.GetHeader(LastEventIDHeader))
+	if lastEventID == "" || !config.IsCheckpointEnabled() {
+		return false
+	}
+
+	replay, err := readCheckpointReplay(lastEventID, CheckpointOwner(c))
+	if errors.Is(err, ErrCheckpointOwner) {
+		logger.Warn("拒绝续传其他客户端密钥的检查点",
+			logutil.AddFields(c, logger.String("last_event_id", lastEventID))...)
+		support.RespondErrorWithCode(c, http.StatusForbidden, "checkpoint_forbidden", "%v", err)
+		return true
+	}
+	if err != nil {
+		logger.Debug("无法从检查点续传，按新请求处理",
+			logutil.AddFields(c, logger.String("last_event_id", lastEventID), logger.Err(err))...)
+		return false
+	}
+
+	if err := InitializeSSEResponse(c); err != nil {
+		return false
+	}
+	if _, err := c.Writer.Write(replay); err != nil {
+		logger.Warn("重放检查点失败", logutil.AddFields(c, logger.Err(err))...)
+	}
+	c.Writer.Flush()
+
+	logger.Info("从检查点续传流式响应",
+		logutil.AddFields(c,
+			logger.String("last_event_id", lastEventID),
+			logger.Int("replay_bytes", len(replay)),
+		)...)
+	return true
+}
+
+// readCheckpointReplay 按 Last-Event-ID 的格式选择按事件序号或按字节偏移续传
+func readCheckpointReplay(lastEventID, owner string) ([]byte, error) {
+	if id, offset, ok := parseCheckpointOffset(lastEventID); ok {
+		return ReadCheckpointFromOffset(id, offset, owner)
+	}
+	return ReadCheckpointAfter(lastEventID, owner)
+}
+
+// ReadCheckpointAfter 返回 owner 创建的检查点中 lastEventID 之后的全部原始SSE字节
+func ReadCheckpointAfter(lastEventID, owner string) ([]byte, error) {
+	id, seq, ok := parseCheckpointEventID(lastEventID)
+	if !ok {
+		return nil, fmt.Errorf("%w: 无效的 Last-Event-ID %q", ErrCheckpointNotFound, lastEventID)
+	}
+
+	data, err := readCheckpointFile(id, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	// 事件以空行分隔，data 为单行JSON，不会包含空行
+	var replay []byte
+	var last int64
+	for _, event := range bytes.SplitAfter(data, []byte("\n\n")) {
+		if len(event) == 0 {
+			continue
+		}
+		_, eventSeq, ok := parseCheckpointEventID(checkpointEventID(event))
+		if !ok {
+			continue
+		}
+		last = eventSeq
+		if eventSeq > seq {
+			replay = append(replay, event...)
+		}
+	}
+	if seq > last {
+		return nil, fmt.Errorf("%w: last_event=%d, checkpoint=%d", ErrCheckpointBehind, seq, last)
+	}
+	return replay, nil
+}
+
+// ReadCheckpointFromOffset 返回 owner 创建的流从字节偏移 offset 开始的原始SSE字节，offset 可以落在事件中间
+// 偏移从第一个事件的 id 行开始计算，不含SSE填充注释；优先读取内存环形缓冲区（包含尚未写入检查点文件的字节），
+// 偏移已被淘汰或服务重启后缓冲区不存在时读取检查点文件
+func ReadCheckpointFromOffset(id string, offset int64, owner string) ([]byte, error) {
+	entry, buffered := lookupSSEBuffer(id)
+	if buffered {
+		if subtle.ConstantTimeCompare([]byte(entry.owner), []byte(checkpointOwnerDigest(owner))) != 1 {
+			return nil, ErrCheckpointOwner
+		}
+		replay, err := entry.buffer.ResumeFromOffset(offset)
+		if !errors.Is(err, ErrSSEOffsetEvicted) {
+			return replay, err
+		}
+	}
+
+	data, err := readCheckpointFile(id, owner)
+	if err != nil {
+		return nil, err
+	}
+	if offset < 0 || offset > int64(len(data)) {
+		return nil, fmt.Errorf("%w: offset=%d, checkpoint=%d", ErrSSEOffsetOutOfRange, offset, len(data))
+	}
+	replay := data[offset:]
+	// 文件之后的字节仍在缓冲区中时一并返回
+	if buffered {
+		if tail, err := entry.buffer.ResumeFromOffset(int64(len(data))); err == nil {
+			replay = append(replay, tail...)
+		}
+	}
+	return replay, nil
+}
+
+// readCheckpointFile 读取 owner 创建的检查点文件，返回首行之后的原始SSE字节
+func readCheckpointFile(id, owner string) ([]byte, error) {
+	data, err := os.ReadFile(checkpointPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrCheckpointNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	header, data, ok := bytes.Cut(data, []byte("\n\n"))
+	if !ok {
+		return nil, ErrCheckpointNotFound
+	}
+	digest, ok := bytes.CutPrefix(header, []byte(checkpointOwnerPrefix))
+	if !ok || subtle.ConstantTimeCompare(digest, []byte(checkpointOwnerDigest(owner))) != 1 {
+		return nil, ErrCheckpointOwner
+	}
+	return data, nil
+}
+
+// SweepExpiredCheckpoints 删除修改时间早于 ttl 的检查点文件，返回删除的数量
+func SweepExpiredCheckpoints(dir string, ttl time.Duration, now time.Time) int {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0
+	}
+	removed := 0
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".sse" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || now.Sub(info.ModTime()) < ttl {
+			continue
+		}
+		if os.Remove(filepath.Join(dir, entry.Name())) == nil {
+			removed++
+		}
+	}
+	return removed
+}
+
+// newCheckpointID 生成不可猜测的检查点ID
+func newCheckpointID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return "ckpt_" + hex.EncodeToString(b[:]), nil
+}
+
+// checkpointOwnerDigest 客户端密钥名称的摘要，文件中不保存名称原文
+func checkpointOwnerDigest(owner string) string {
+	sum := sha256.Sum256([]byte(owner))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkpointPath 检查点ID对应的文件路径
+func checkpointPath(id string) string {
+	return filepath.Join(config.CheckpointDir(), id+".sse")
+}
+
+// parseCheckpointEventID 解析 "<检查点ID>:<序号>" 形式的事件ID
+func parseCheckpointEventID(eventID string) (string, int64, bool) {
+	id, seqText, ok := strings.Cut(eventID, ":")
+	if !ok || !checkpointIDPattern.MatchString(id) {
+		return "", 0, false
+	}
+	seq, err := strconv.ParseInt(seqText, 10, 64)
+	if err != nil || seq < 0 {
+		return "", 0, false
+	}
+	return id, seq, true
+}
+
+// parseCheckpointOffset 解析 "<检查点ID>@<字节偏移>" 形式的续传位置
+func parseCheckpointOffset(lastEventID string) (string, int64, bool) {
+	id, offsetText, ok := strings.Cut(lastEventID, "@")
+	if !ok || !checkpointIDPattern.MatchString(id) {
+		return "", 0, false
+	}
+	offset, err := strconv.ParseInt(offsetText, 10, 64)
+	if err != nil || offset < 0 {
+		return "", 0, false
+	}
+	return id, offset, true
+}
+
+// checkpointEventID 取出原始SSE事件的 id 字段
+func checkpointEventID(event []byte) string {
+	for _, line := range strings.Split(string(event), "\n") {
+		if id, ok := strings.CutPrefix(line, "id: "); ok {
+			return id
+		}
+	}
+	return ""
+}
+
+// appendCheckpoint 把数据追加到检查点文件，目录不存在时创建
+func appendCheckpoint(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// removeCheckpoint 删除检查点文件，文件已不存在时忽略
+func removeCheckpoint(path string) {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.Warn("删除流式响应检查点失败", logger.String("path", path), logger.Err(err))
+	}
+}
+
+// isMessageStop 判断事件是否为 message_stop
+func isMessageStop(data any) bool {
+	event, ok := data.(map[string]any)
+	return ok && event["type"] == "message_stop"
+}
+
+// teeResponseWriter 把写入响应的字节同时记录到 buf
+type teeResponseWriter struct {
+	gin.ResponseWriter
+	buf *[]byte
+}
+
+func (w *teeResponseWriter) Write(p []byte) (int, error) {
+	*w.buf = append(*w.buf, p...)
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *teeResponseWriter) WriteString(s string) (int, error) {
+	*w.buf = append(*w.buf, s...)
+	return w.ResponseWriter.WriteString(s)
+}
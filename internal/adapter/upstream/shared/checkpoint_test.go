@@ -0,0 +1,298 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	srvcontext "kiro2api/internal/adapter/httpapi/context"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// checkpointTestEvents 一条完整的流式响应，共 n 个文本增量
+func checkpointTestEvents(n int) []map[string]any {
+	events := []map[string]any{
+		{"type": "message_start", "message": map[string]any{"id": "msg_ckpt"}},
+		{"type": "content_block_start", "index": 0, "content_block": map[string]any{"type": "text", "text": ""}},
+	}
+	for i := 0; i < n; i++ {
+		events = append(events, map[string]any{"type": "content_block_delta", "index": 0, "delta": map[string]any{"type": "text_delta", "text": fmt.Sprintf("第%d段", i)}})
+	}
+	return append(events,
+		map[string]any{"type": "content_block_stop", "index": 0},
+		map[string]any{"type": "message_delta", "delta": map[string]any{"stop_reason": "end_turn"}},
+		map[string]any{"type": "message_stop"},
+	)
+}
+
+func newCheckpointTestContext(t *testing.T) (*gin.Context, *httptest.ResponseRecorder, context.CancelFunc) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	ctx, cancel := context.WithCancel(context.Background())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil).WithContext(ctx)
+	return c, w, cancel
+}
+
+// splitSSEEvents 按空行切分原始SSE字节，保留分隔符
+func splitSSEEvents(body string) []string {
+	events := strings.SplitAfter(body, "\n\n")
+	return events[:len(events)-1]
+}
+
+func TestCheckpointWriter_PeriodicCheckpointBoundaries(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("CHECKPOINT_DIR", dir)
+
+	c, w, _ := newCheckpointTestContext(t)
+	events := checkpointTestEvents(20)
+	probe := NewCheckpointWriter(&AnthropicStreamSender{}, "")
+	require.NoError(t, probe.SendEvent(c, events[2]))
+	eventSize := len(w.Body.String())
+
+	// 每3个增量事件写一次检查点；进程异常退出时（没有 Close）只有已写入的部分可以续传
+	t.Setenv("CHECKPOINT_INTERVAL_BYTES", fmt.Sprint(eventSize*3-1))
+	c, w, _ = newCheckpointTestContext(t)
+	writer := NewCheckpointWriter(&AnthropicStreamSender{}, "")
+	for _, event := range events[:14] {
+		require.NoError(t, writer.SendEvent(c, event))
+	}
+
+	file, err := os.ReadFile(filepath.Join(dir, writer.id+".sse"))
+	require.NoError(t, err)
+	header, data, _ := strings.Cut(string(file), "\n\n")
+	assert.True(t, strings.HasPrefix(header, checkpointOwnerPrefix), "首行记录创建者")
+	sent := splitSSEEvents(w.Body.String())
+	checkpointed := len(splitSSEEvents(data))
+	require.Greater(t, checkpointed, 0)
+	require.Less(t, checkpointed, 14)
+	assert.Equal(t, strings.Join(sent[:checkpointed], ""), data)
+
+	for _, lastEvent := range []int{0, 1, checkpointed - 1, checkpointed} {
+		replay, err := ReadCheckpointAfter(fmt.Sprintf("%s:%d", writer.id, lastEvent), "")
+		require.NoError(t, err, "lastEvent=%d", lastEvent)
+		assert.Equal(t, strings.Join(sent[lastEvent:checkpointed], ""), string(replay), "lastEvent=%d", lastEvent)
+	}
+
+	_, err = ReadCheckpointAfter(fmt.Sprintf("%s:%d", writer.id, checkpointed+1), "")
+	assert.ErrorIs(t, err, ErrCheckpointBehind)
+}
+
+func TestCheckpointWriter_RemovedAfterMessageStop(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("CHECKPOINT_DIR", dir)
+	t.Setenv("CHECKPOINT_INTERVAL_BYTES", "1")
+
+	c, _, _ := newCheckpointTestContext(t)
+	writer := NewCheckpointWriter(&AnthropicStreamSender{}, "")
+	for _, event := range checkpointTestEvents(3) {
+		require.NoError(t, writer.SendEvent(c, event))
+	}
+	writer.Close()
+
+	_, err := os.Stat(filepath.Join(dir, writer.id+".sse"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = ReadCheckpointAfter(writer.id+":3", "")
+	assert.ErrorIs(t, err, ErrCheckpointNotFound)
+}
+
+func TestCheckpointWriter_DisabledSendsWithoutID(t *testing.T) {
+	t.Setenv("CHECKPOINT_INTERVAL_BYTES", "")
+
+	c, w, _ := newCheckpointTestContext(t)
+	writer := NewCheckpointWriter(&AnthropicStreamSender{}, "")
+	require.NoError(t, writer.SendEvent(c, map[string]any{"type": "ping"}))
+	writer.Close()
+
+	assert.Equal(t, "event: ping\ndata: {\"type\":\"ping\"}\n\n", w.Body.String())
+}
+
+func TestResumeFromCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("CHECKPOINT_DIR", dir)
+	t.Setenv("CHECKPOINT_INTERVAL_BYTES", "1")
+
+	c, w, cancel := newCheckpointTestContext(t)
+	srvcontext.SetClientKey(c, srvcontext.ClientKey{Name: "team"})
+	writer := NewCheckpointWriter(&AnthropicStreamSender{}, CheckpointOwner(c))
+	events := checkpointTestEvents(2)
+	for i, event := range events {
+		if i == 3 {
+			cancel()
+		}
+		require.NoError(t, writer.SendEvent(c, event))
+	}
+	writer.Close()
+	sent := splitSSEEvents(w.Body.String())
+
+	t.Run("从Last-Event-ID之后重放", func(t *testing.T) {
+		c, w, _ := newCheckpointTestContext(t)
+		srvcontext.SetClientKey(c, srvcontext.ClientKey{Name: "team"})
+		c.Request.Header.Set(LastEventIDHeader, writer.id+":3")
+
+		require.True(t, ResumeFromCheckpoint(c))
+		assert.Equal(t, "text/event-stream; charset=utf-8", w.Header().Get("Content-Type"))
+		assert.Equal(t, strings.Join(sent[3:], ""), w.Body.String())
+	})
+
+	t.Run("无效或不存在的检查点按新请求处理", func(t *testing.T) {
+		for _, id := range []string{"", writer.id, "../" + writer.id + ":1", "ckpt_missing:1", writer.id + ":99"} {
+			c, w, _ := newCheckpointTestContext(t)
+			srvcontext.SetClientKey(c, srvcontext.ClientKey{Name: "team"})
+			c.Request.Header.Set(LastEventIDHeader, id)
+
+			assert.False(t, ResumeFromCheckpoint(c), "id=%q", id)
+			assert.Empty(t, w.Body.String(), "id=%q", id)
+		}
+	})
+
+	t.Run("其他客户端密钥的续传被拒绝", func(t *testing.T) {
+		for _, key := range []*srvcontext.ClientKey{{Name: "other"}, nil} {
+			c, w, _ := newCheckpointTestContext(t)
+			if key != nil {
+				srvcontext.SetClientKey(c, *key)
+			}
+			c.Request.Header.Set(LastEventIDHeader, writer.id+":1")
+
+			require.True(t, ResumeFromCheckpoint(c))
+			assert.Equal(t, http.StatusForbidden, w.Code)
+			assert.Contains(t, w.Body.String(), "checkpoint_forbidden")
+			assert.NotContains(t, w.Body.String(), "第0段")
+		}
+	})
+}
+
+// TestResumeFromCheckpoint_ByteOffset 按 "<检查点ID>@<字节数>" 续传时从该字节位置精确重放：
+// 流进行中从内存缓冲区读取尚未写入文件的字节，缓冲区淘汰或释放后读取检查点文件
+func TestResumeFromCheckpoint_ByteOffset(t *testing.T) {
+	t.Setenv("CHECKPOINT_DIR", t.TempDir())
+	t.Setenv("CHECKPOINT_INTERVAL_BYTES", "100000")
+
+	c, w, _ := newCheckpointTestContext(t)
+	srvcontext.SetClientKey(c, srvcontext.ClientKey{Name: "team"})
+	writer := NewCheckpointWriter(&AnthropicStreamSender{}, CheckpointOwner(c))
+	events := checkpointTestEvents(10)
+	// 客户端在收到 message_stop 之前断开，流仍在进行
+	for _, event := range events[:len(events)-1] {
+		require.NoError(t, writer.SendEvent(c, event))
+	}
+	sent := w.Body.String()
+	boundary := len(strings.Join(splitSSEEvents(sent)[:4], ""))
+	midRune := strings.Index(sent, "第5段") + 1 // 落在多字节字符内部
+	offsets := []int{0, boundary, boundary + 7, midRune, len(sent)}
+
+	resume := func(t *testing.T, key string, offset int) (bool, *httptest.ResponseRecorder) {
+		c, w, _ := newCheckpointTestContext(t)
+		srvcontext.SetClientKey(c, srvcontext.ClientKey{Name: key})
+		c.Request.Header.Set(LastEventIDHeader, fmt.Sprintf("%s@%d", writer.id, offset))
+		return ResumeFromCheckpoint(c), w
+	}
+
+	t.Run("流进行中从内存缓冲区重放", func(t *testing.T) {
+		_, err := os.Stat(writer.path)
+		require.True(t, os.IsNotExist(err), "未达到间隔字节数时检查点文件尚未写入")
+		for _, offset := range offsets {
+			ok, w := resume(t, "team", offset)
+			require.True(t, ok, "offset=%d", offset)
+			assert.Equal(t, sent[offset:], w.Body.String(), "offset=%d", offset)
+		}
+	})
+
+	t.Run("超出已发送范围按新请求处理", func(t *testing.T) {
+		ok, w := resume(t, "team", len(sent)+1)
+		assert.False(t, ok)
+		assert.Empty(t, w.Body.String())
+	})
+
+	t.Run("其他客户端密钥的续传被拒绝", func(t *testing.T) {
+		ok, w := resume(t, "other", boundary)
+		require.True(t, ok)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.NotContains(t, w.Body.String(), "第0段")
+	})
+
+	t.Run("缓冲区释放后从检查点文件重放", func(t *testing.T) {
+		writer.Close()
+		releaseSSEBuffer(writer.id)
+		for _, offset := range offsets {
+			ok, w := resume(t, "team", offset)
+			require.True(t, ok, "offset=%d", offset)
+			assert.Equal(t, sent[offset:], w.Body.String(), "offset=%d", offset)
+		}
+	})
+}
+
+// TestReadCheckpointFromOffset_EvictedJoinsFileAndBuffer 缓冲区小于检查点间隔时，淘汰尚未写入文件的字节前先写检查点，
+// 偏移已被缓冲区淘汰时从文件读取，与缓冲区中的剩余部分拼接后仍与发送的字节一致
+func TestReadCheckpointFromOffset_EvictedJoinsFileAndBuffer(t *testing.T) {
+	t.Setenv("CHECKPOINT_DIR", t.TempDir())
+	t.Setenv("CHECKPOINT_INTERVAL_BYTES", "600")
+	t.Setenv("CHECKPOINT_BUFFER_BYTES", "400")
+
+	c, w, _ := newCheckpointTestContext(t)
+	writer := NewCheckpointWriter(&AnthropicStreamSender{}, "")
+	defer releaseSSEBuffer(writer.id)
+	for _, event := range checkpointTestEvents(20)[:15] {
+		require.NoError(t, writer.SendEvent(c, event))
+	}
+	sent := w.Body.String()
+	assert.LessOrEqual(t, writer.buffer.EarliestOffset(), writer.flushed, "每个字节至少保存在文件或缓冲区之一")
+
+	_, err := writer.buffer.ResumeFromOffset(10)
+	require.ErrorIs(t, err, ErrSSEOffsetEvicted)
+	replay, err := ReadCheckpointFromOffset(writer.id, 10, "")
+	require.NoError(t, err)
+	assert.Equal(t, sent[10:], string(replay))
+}
+
+// TestNewCheckpointWriter_UniqueIDs 同时开始的流使用不同的检查点，一条流结束不会删除另一条的检查点
+func TestNewCheckpointWriter_UniqueIDs(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("CHECKPOINT_DIR", dir)
+	t.Setenv("CHECKPOINT_INTERVAL_BYTES", "1")
+
+	c1, _, cancel := newCheckpointTestContext(t)
+	c2, _, _ := newCheckpointTestContext(t)
+	first := NewCheckpointWriter(&AnthropicStreamSender{}, "")
+	second := NewCheckpointWriter(&AnthropicStreamSender{}, "")
+	require.NotEqual(t, first.id, second.id)
+	assert.Regexp(t, `^ckpt_[0-9a-f]{32}$`, first.id)
+
+	events := checkpointTestEvents(1)
+	cancel()
+	for _, event := range events {
+		require.NoError(t, first.SendEvent(c1, event))
+		require.NoError(t, second.SendEvent(c2, event))
+	}
+
+	assert.FileExists(t, filepath.Join(dir, first.id+".sse"), "未收到 message_stop 的检查点保留")
+	assert.NoFileExists(t, filepath.Join(dir, second.id+".sse"))
+}
+
+func TestSweepExpiredCheckpoints(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	expired := filepath.Join(dir, "msg_old.sse")
+	fresh := filepath.Join(dir, "msg_new.sse")
+	other := filepath.Join(dir, "notes.txt")
+	for _, path := range []string{expired, fresh, other} {
+		require.NoError(t, os.WriteFile(path, []byte("id: x:1\n\n"), 0o600))
+	}
+	require.NoError(t, os.Chtimes(expired, now.Add(-time.Hour), now.Add(-time.Hour)))
+	require.NoError(t, os.Chtimes(other, now.Add(-time.Hour), now.Add(-time.Hour)))
+
+	assert.Equal(t, 1, SweepExpiredCheckpoints(dir, 5*time.Minute, now))
+	assert.NoFileExists(t, expired)
+	assert.FileExists(t, fresh)
+	assert.FileExists(t, other)
+}
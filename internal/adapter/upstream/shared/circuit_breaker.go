@@ -0,0 +1,299 @@
+package shared
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"sync"
+	"time"
+
+	"kiro2api/config"
+	"kiro2api/logger"
+	"kiro2api/types"
+)
+
+// CircuitState 熔断器状态
+type CircuitState string
+
+const (
+	CircuitClosed   CircuitState = "closed"    // 正常放行
+	CircuitOpen     CircuitState = "open"      // 拒绝请求，等待冷却
+	CircuitHalfOpen CircuitState = "half_open" // 冷却结束，放行有限的探测请求
+)
+
+// CircuitSettings 熔断判定参数
+type CircuitSettings struct {
+	Threshold      int           // 连续失败次数阈值，也是按失败率判定的最少样本数
+	ErrorPercent   int           // 窗口内失败率阈值（百分比），0 表示不按失败率判定
+	Window         time.Duration // 失败率的滑动窗口
+	Cooldown       time.Duration // 打开后进入半开状态前的冷却时间
+	HalfOpenProbes int           // 半开状态允许同时进行的探测请求数
+}
+
+// CircuitSettingsFromConfig 从 CIRCUIT_BREAKER_* 环境变量读取熔断参数
+func CircuitSettingsFromConfig() CircuitSettings {
+	return CircuitSettings{
+		Threshold:      config.CircuitBreakerThreshold(),
+		ErrorPercent:   config.CircuitBreakerErrorPercent(),
+		Window:         config.CircuitBreakerWindow(),
+		Cooldown:       config.CircuitBreakerCooldown(),
+		HalfOpenProbes: config.CircuitBreakerHalfOpenProbes(),
+	}
+}
+
+// CircuitStatus 单个熔断器的状态快照
+type CircuitStatus struct {
+	Key                 string       `json:"key"`
+	State               CircuitState `json:"state"`
+	ConsecutiveFailures int          `json:"consecutive_failures"`
+	Requests            int          `json:"requests"`
+	Errors              int          `json:"errors"`
+	Opens               int          `json:"opens"`
+	OpenedAt            *time.Time   `json:"opened_at,omitempty"`
+	RetryAfterSeconds   int          `json:"retry_after_seconds,omitempty"`
+}
+
+// circuitSample 一次上游请求的结果
+type circuitSample struct {
+	at     time.Time
+	failed bool
+}
+
+// circuit 单个端点（或端点+token）的熔断状态
+type circuit struct {
+	state       CircuitState
+	consecutive int
+	samples     []circuitSample
+	openedAt    time.Time
+	probes      int // 半开状态下进行中的探测请求数
+	opens       int
+}
+
+// CircuitBreaker 按上游端点（可选按token）熔断：
+// 连续失败达到阈值或窗口内失败率超过阈值时打开，冷却期内直接拒绝请求；
+// 冷却结束后进入半开状态放行有限的探测请求，探测成功则关闭，失败则重新打开
+type CircuitBreaker struct {
+	mutex    sync.Mutex
+	settings CircuitSettings
+	circuits map[string]*circuit
+	now      func() time.Time
+}
+
+var (
+	globalCircuitBreaker *CircuitBreaker
+	circuitBreakerOnce   sync.Once
+)
+
+// GetCircuitBreaker 获取全局上游熔断器（基于 CIRCUIT_BREAKER_* 环境变量创建）
+func GetCircuitBreaker() *CircuitBreaker {
+	circuitBreakerOnce.Do(func() {
+		globalCircuitBreaker = NewCircuitBreaker(CircuitSettingsFromConfig())
+	})
+	return globalCircuitBreaker
+}
+
+// NewCircuitBreaker 创建熔断器
+func NewCircuitBreaker(settings CircuitSettings) *CircuitBreaker {
+	return &CircuitBreaker{
+		settings: settings,
+		circuits: make(map[string]*circuit),
+		now:      time.Now,
+	}
+}
+
+// CircuitKey 返回请求对应的熔断键：默认为端点地址，perToken 时附加token指纹（不包含token本身）
+func CircuitKey(endpoint string, tokenInfo types.TokenInfo, perToken bool) string {
+	if !perToken {
+		return endpoint
+	}
+	identifier := tokenInfo.RefreshToken
+	if identifier == "" {
+		identifier = tokenInfo.AccessToken
+	}
+	sum := sha256.Sum256([]byte(identifier))
+	return endpoint + "#" + hex.EncodeToString(sum[:4])
+}
+
+// Allow 判断是否放行请求；拒绝时返回建议的重试等待时间
+// 放行的请求必须随后调用 Record 报告结果（或在没有结果时调用 Release），否则半开状态的探测名额不会释放
+func (b *CircuitBreaker) Allow(key string) (time.Duration, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	cb := b.circuits[key]
+	if cb == nil {
+		return 0, true
+	}
+
+	now := b.now()
+	if cb.state == CircuitOpen {
+		if remaining := b.settings.Cooldown - now.Sub(cb.openedAt); remaining > 0 {
+			return remaining, false
+		}
+		b.transition(key, cb, CircuitHalfOpen)
+	}
+
+	if cb.state == CircuitHalfOpen {
+		if cb.probes >= b.settings.HalfOpenProbes {
+			return time.Second, false
+		}
+		cb.probes++
+	}
+	return 0, true
+}
+
+// Record 报告一次放行请求的结果；failed 表示网络错误或5xx响应
+func (b *CircuitBreaker) Record(key string, failed bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	cb := b.circuits[key]
+	if cb == nil {
+		cb = &circuit{state: CircuitClosed}
+		b.circuits[key] = cb
+	}
+
+	now := b.now()
+	switch cb.state {
+	case CircuitHalfOpen:
+		if cb.probes > 0 {
+			cb.probes--
+		}
+		if failed {
+			cb.openedAt = now
+			b.transition(key, cb, CircuitOpen)
+			return
+		}
+		cb.consecutive = 0
+		cb.samples = nil
+		b.transition(key, cb, CircuitClosed)
+
+	case CircuitClosed:
+		cb.samples = append(cb.samples, circuitSample{at: now, failed: failed})
+		if len(cb.samples) > config.CircuitBreakerMaxSamples {
+			cb.samples = cb.samples[len(cb.samples)-config.CircuitBreakerMaxSamples:]
+		}
+		b.prune(cb, now)
+
+		if !failed {
+			cb.consecutive = 0
+			return
+		}
+		cb.consecutive++
+		if cb.consecutive >= b.settings.Threshold || b.errorRateExceeded(cb) {
+			cb.openedAt = now
+			b.transition(key, cb, CircuitOpen)
+		}
+
+	case CircuitOpen:
+		// 打开之前已放行的请求陆续返回，结果不影响冷却
+	}
+}
+
+// Release 放行的请求没有可报告的结果（如客户端取消）时释放半开状态的探测名额，不计入成功或失败
+func (b *CircuitBreaker) Release(key string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if cb := b.circuits[key]; cb != nil && cb.state == CircuitHalfOpen && cb.probes > 0 {
+		cb.probes--
+	}
+}
+
+// Snapshot 返回所有熔断器的状态（按键排序）
+func (b *CircuitBreaker) Snapshot() []CircuitStatus {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := b.now()
+	result := make([]CircuitStatus, 0, len(b.circuits))
+	for key, cb := range b.circuits {
+		b.prune(cb, now)
+		requests, errors := countCircuitSamples(cb.samples)
+
+		status := CircuitStatus{
+			Key:                 key,
+			State:               cb.state,
+			ConsecutiveFailures: cb.consecutive,
+			Requests:            requests,
+			Errors:              errors,
+			Opens:               cb.opens,
+		}
+		if cb.state != CircuitClosed {
+			openedAt := cb.openedAt
+			status.OpenedAt = &openedAt
+		}
+		if cb.state == CircuitOpen {
+			if remaining := b.settings.Cooldown - now.Sub(cb.openedAt); remaining > 0 {
+				status.RetryAfterSeconds = retryAfterSeconds(remaining)
+			}
+		}
+		result = append(result, status)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+	return result
+}
+
+// transition 切换状态并记录日志（调用方需持有锁）
+func (b *CircuitBreaker) transition(key string, cb *circuit, state CircuitState) {
+	from := cb.state
+	cb.state = state
+
+	switch state {
+	case CircuitOpen:
+		cb.opens++
+		cb.probes = 0
+		requests, errors := countCircuitSamples(cb.samples)
+		logger.Warn("上游熔断已打开，冷却期内直接拒绝请求",
+			logger.String("circuit", key),
+			logger.String("from", string(from)),
+			logger.Int("consecutive_failures", cb.consecutive),
+			logger.Int("requests", requests),
+			logger.Int("errors", errors),
+			logger.Duration("cooldown", b.settings.Cooldown))
+	case CircuitHalfOpen:
+		cb.probes = 0
+		logger.Info("上游熔断冷却结束，进入半开状态放行探测请求",
+			logger.String("circuit", key),
+			logger.Int("probes", b.settings.HalfOpenProbes))
+	case CircuitClosed:
+		logger.Info("上游熔断探测成功，已关闭",
+			logger.String("circuit", key),
+			logger.String("from", string(from)))
+	}
+}
+
+// errorRateExceeded 窗口内样本足够且失败率超过阈值（调用方需持有锁）
+func (b *CircuitBreaker) errorRateExceeded(cb *circuit) bool {
+	if b.settings.ErrorPercent <= 0 {
+		return false
+	}
+	requests, errors := countCircuitSamples(cb.samples)
+	return requests >= b.settings.Threshold && errors*100 > b.settings.ErrorPercent*requests
+}
+
+// prune 丢弃滑动窗口之外的样本
+func (b *CircuitBreaker) prune(cb *circuit, now time.Time) {
+	cutoff := now.Add(-b.settings.Window)
+	i := 0
+	for i < len(cb.samples) && cb.samples[i].at.Before(cutoff) {
+		i++
+	}
+	if i > 0 {
+		cb.samples = append([]circuitSample(nil), cb.samples[i:]...)
+	}
+}
+
+func countCircuitSamples(samples []circuitSample) (requests, errors int) {
+	for _, s := range samples {
+		if s.failed {
+			errors++
+		}
+	}
+	return len(samples), errors
+}
+
+// retryAfterSeconds 把等待时间向上取整为 Retry-After 秒数（至少1秒）
+func retryAfterSeconds(d time.Duration) int {
+	return max(int((d+time.Second-1)/time.Second), 1)
+}
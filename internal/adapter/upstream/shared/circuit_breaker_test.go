@@ -0,0 +1,247 @@
+package shared
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"kiro2api/config"
+	"kiro2api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestCircuitBreaker 创建使用可控时钟的熔断器：连续3次失败打开，冷却10秒，半开允许1个探测
+func newTestCircuitBreaker() (*CircuitBreaker, *time.Time) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := NewCircuitBreaker(CircuitSettings{
+		Threshold:      3,
+		Window:         time.Minute,
+		Cooldown:       10 * time.Second,
+		HalfOpenProbes: 1,
+	})
+	b.now = func() time.Time { return now }
+	return b, &now
+}
+
+func circuitState(t *testing.T, b *CircuitBreaker, key string) CircuitStatus {
+	t.Helper()
+	for _, status := range b.Snapshot() {
+		if status.Key == key {
+			return status
+		}
+	}
+	require.Failf(t, "熔断器不存在", "key=%s", key)
+	return CircuitStatus{}
+}
+
+func TestCircuitBreaker_ClosedOpenHalfOpenClosed(t *testing.T) {
+	b, now := newTestCircuitBreaker()
+	const key = "https://primary"
+
+	// closed：未达到阈值前一直放行，成功会清零连续失败次数
+	for _, failed := range []bool{true, true, false, true, true} {
+		_, ok := b.Allow(key)
+		require.True(t, ok)
+		b.Record(key, failed)
+	}
+	assert.Equal(t, CircuitClosed, circuitState(t, b, key).State)
+	assert.Equal(t, 2, circuitState(t, b, key).ConsecutiveFailures)
+
+	// closed → open
+	_, ok := b.Allow(key)
+	require.True(t, ok)
+	b.Record(key, true)
+	status := circuitState(t, b, key)
+	assert.Equal(t, CircuitOpen, status.State)
+	assert.Equal(t, 1, status.Opens)
+	assert.Equal(t, 10, status.RetryAfterSeconds)
+
+	// open：冷却期内直接拒绝，返回剩余冷却时间
+	*now = now.Add(4 * time.Second)
+	retryAfter, ok := b.Allow(key)
+	assert.False(t, ok)
+	assert.Equal(t, 6*time.Second, retryAfter)
+
+	// open → half_open：冷却结束后只放行一个探测请求
+	*now = now.Add(6 * time.Second)
+	_, ok = b.Allow(key)
+	require.True(t, ok)
+	assert.Equal(t, CircuitHalfOpen, circuitState(t, b, key).State)
+	_, ok = b.Allow(key)
+	assert.False(t, ok, "探测进行中时不应放行更多请求")
+
+	// half_open → closed
+	b.Record(key, false)
+	status = circuitState(t, b, key)
+	assert.Equal(t, CircuitClosed, status.State)
+	assert.Equal(t, 0, status.ConsecutiveFailures)
+	assert.Nil(t, status.OpenedAt)
+	_, ok = b.Allow(key)
+	assert.True(t, ok)
+}
+
+func TestCircuitBreaker_HalfOpenProbeFailureReopens(t *testing.T) {
+	b, now := newTestCircuitBreaker()
+	const key = "https://primary"
+
+	for range 3 {
+		b.Record(key, true)
+	}
+	require.Equal(t, CircuitOpen, circuitState(t, b, key).State)
+
+	*now = now.Add(10 * time.Second)
+	_, ok := b.Allow(key)
+	require.True(t, ok)
+	b.Record(key, true)
+
+	status := circuitState(t, b, key)
+	assert.Equal(t, CircuitOpen, status.State)
+	assert.Equal(t, 2, status.Opens)
+
+	// 重新打开后冷却时间从探测失败时算起
+	retryAfter, ok := b.Allow(key)
+	assert.False(t, ok)
+	assert.Equal(t, 10*time.Second, retryAfter)
+}
+
+func TestCircuitBreaker_ReleaseFreesHalfOpenProbe(t *testing.T) {
+	b, now := newTestCircuitBreaker()
+	const key = "https://primary"
+
+	for range 3 {
+		b.Record(key, true)
+	}
+	*now = now.Add(10 * time.Second)
+	_, ok := b.Allow(key)
+	require.True(t, ok)
+
+	// 探测请求被取消：释放名额，不改变状态
+	b.Release(key)
+	status := circuitState(t, b, key)
+	assert.Equal(t, CircuitHalfOpen, status.State)
+	assert.Equal(t, 1, status.Opens)
+
+	_, ok = b.Allow(key)
+	assert.True(t, ok, "名额释放后应放行新的探测请求")
+}
+
+func TestExecute_CanceledProbeReleasesCircuit(t *testing.T) {
+	breaker, now := newTestCircuitBreaker()
+	rp := NewReverseProxy(&http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return nil, req.Context().Err()
+	})})
+	rp.stealthEnabled = false
+	rp.SetEndpointPool(NewEndpointPool(nil, config.DefaultUpstreamHealthWindow, 50, config.DefaultUpstreamProbeInterval))
+	rp.SetCircuitBreaker(breaker)
+
+	key := CircuitKey(rp.endpoints.Select(), types.TokenInfo{AccessToken: "token"}, rp.perToken)
+	for range 3 {
+		breaker.Record(key, true)
+	}
+	*now = now.Add(10 * time.Second)
+
+	c := newRetryTestContext()
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	cancel()
+	c.Request = c.Request.WithContext(ctx)
+	_, err := rp.Execute(c, newRetryTestRequest(), types.TokenInfo{AccessToken: "token"}, false)
+	require.Error(t, err)
+
+	assert.Equal(t, CircuitHalfOpen, circuitState(t, breaker, key).State)
+	_, ok := breaker.Allow(key)
+	assert.True(t, ok, "取消的探测不应占用半开名额")
+}
+
+func TestCircuitBreaker_ErrorRateOverWindow(t *testing.T) {
+	b, now := newTestCircuitBreaker()
+	b.settings.Threshold = 4
+	b.settings.ErrorPercent = 50
+	const key = "https://primary"
+
+	// 交替失败不会触发连续失败阈值，窗口内失败率达到 3/5 后打开
+	for i, failed := range []bool{true, false, true, false} {
+		b.Record(key, failed)
+		assert.Equal(t, CircuitClosed, circuitState(t, b, key).State, "第%d次", i+1)
+	}
+
+	// 窗口外的样本不计入失败率
+	*now = now.Add(2 * time.Minute)
+	b.Record(key, true)
+	assert.Equal(t, CircuitClosed, circuitState(t, b, key).State)
+	assert.Equal(t, 1, circuitState(t, b, key).Requests)
+
+	for _, failed := range []bool{false, true, true} {
+		b.Record(key, failed)
+	}
+	assert.Equal(t, CircuitOpen, circuitState(t, b, key).State)
+}
+
+func TestCircuitBreaker_KeysAreIndependent(t *testing.T) {
+	b, _ := newTestCircuitBreaker()
+	token := types.TokenInfo{RefreshToken: "refresh-a"}
+
+	perToken := CircuitKey("https://primary", token, true)
+	assert.Equal(t, "https://primary", CircuitKey("https://primary", token, false))
+	assert.True(t, strings.HasPrefix(perToken, "https://primary#"))
+	assert.NotContains(t, perToken, "refresh-a")
+	assert.NotEqual(t, perToken, CircuitKey("https://primary", types.TokenInfo{RefreshToken: "refresh-b"}, true))
+
+	for range 3 {
+		b.Record(perToken, true)
+	}
+	_, ok := b.Allow(perToken)
+	assert.False(t, ok)
+	_, ok = b.Allow("https://primary")
+	assert.True(t, ok)
+}
+
+func TestExecute_CircuitOpenFailsFast(t *testing.T) {
+	var hits atomic.Int32
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		hits.Add(1)
+		return &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(`{"message":"down"}`))}, nil
+	})}
+	breaker, _ := newTestCircuitBreaker()
+	rp := NewReverseProxy(client)
+	rp.stealthEnabled = false
+	rp.SetEndpointPool(NewEndpointPool(nil, config.DefaultUpstreamHealthWindow, 50, config.DefaultUpstreamProbeInterval))
+	rp.SetCircuitBreaker(breaker)
+
+	for range 3 {
+		_, err := rp.Execute(newRetryTestContext(), newRetryTestRequest(), types.TokenInfo{AccessToken: "token"}, false)
+		require.Error(t, err)
+	}
+	require.Equal(t, int32(3), hits.Load())
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	_, err := rp.Execute(c, newRetryTestRequest(), types.TokenInfo{AccessToken: "token"}, false)
+	require.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, int32(3), hits.Load(), "熔断打开后不应请求上游")
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "10", w.Header().Get("Retry-After"))
+	var body map[string]any
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, "overloaded_error", body["error"].(map[string]any)["type"])
+}
+
+func TestRetryAfterSeconds(t *testing.T) {
+	assert.Equal(t, 1, retryAfterSeconds(0))
+	assert.Equal(t, 1, retryAfterSeconds(200*time.Millisecond))
+	assert.Equal(t, 3, retryAfterSeconds(2100*time.Millisecond))
+
+	body, err := json.Marshal(CircuitStatus{Key: "k", State: CircuitHalfOpen})
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `"state":"half_open"`)
+}
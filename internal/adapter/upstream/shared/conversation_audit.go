@@ -0,0 +1,104 @@
+package shared
+
+import (
+	"kiro2api/config"
+	srvcontext "kiro2api/internal/adapter/httpapi/context"
+	logutil "kiro2api/internal/adapter/httpapi/logging"
+	"kiro2api/internal/audit"
+	"kiro2api/logger"
+	"kiro2api/types"
+	"kiro2api/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RecordConversationTurn 将一次完成的请求写入会话审计存储，并向实时监控订阅者推送本轮消息
+// 会话ID来自构建上游请求时写入gin上下文的值；AUDIT_LEVEL=full 时额外保存规范化后的请求体。
+// assistantText 为本轮返回的文本内容，仅用于生成监控预览，不写入审计存储
+// 无论是否有会话ID都会输出一条请求完成日志，包含实际使用的请求头策略和 agent mode
+func RecordConversationTurn(c *gin.Context, req types.AnthropicRequest, inputTokens, outputTokens int, stopReason, assistantText string) {
+	logCompletion(c, req, inputTokens, outputTokens, stopReason)
+
+	conversationID := srvcontext.GetConversationID(c)
+	if conversationID == "" {
+		return
+	}
+
+	publishTranscript(conversationID, req, outputTokens, assistantText)
+
+	level := config.AuditLevel()
+	if level == config.AuditLevelOff {
+		return
+	}
+
+	turn := audit.Turn{
+		RequestID:    srvcontext.GetRequestID(c),
+		MessageID:    srvcontext.GetMessageID(c),
+		Endpoint:     latencyEndpoint(c),
+		Model:        req.Model,
+		Stream:       req.Stream,
+		MessageCount: len(req.Messages),
+		InputTokens:  inputTokens,
+		OutputTokens: outputTokens,
+		StopReason:   stopReason,
+	}
+	if level == config.AuditLevelFull {
+		normalized := audit.NormalizeRequest(req)
+		turn.Request = &normalized
+	}
+
+	audit.GetConversationStore().Record(conversationID, turn)
+}
+
+// logCompletion 输出请求完成的结构化日志
+func logCompletion(c *gin.Context, req types.AnthropicRequest, inputTokens, outputTokens int, stopReason string) {
+	applied, _ := srvcontext.GetAppliedHeaders(c)
+	fields := []logger.Field{
+		logger.String("model", req.Model),
+		logger.Bool("stream", req.Stream),
+		logger.Int("input_tokens", inputTokens),
+		logger.Int("output_tokens", outputTokens),
+		logger.String("stop_reason", stopReason),
+		logger.String("header_strategy", applied.Strategy),
+		logger.String("agent_mode", applied.AgentMode),
+	}
+	if violations, ok := srvcontext.GetSSEViolations(c); ok {
+		fields = append(fields, logger.Int("sse_violations", violations))
+	}
+	if responseBytes, ok := srvcontext.GetResponseBytes(c); ok {
+		fields = append(fields, logger.Int("response_bytes", responseBytes))
+	}
+	if warnings := srvcontext.GetWarnings(c); warnings != "" {
+		fields = append(fields, logger.String("warnings", warnings))
+	}
+	logger.Info("请求完成", logutil.AddFields(c, fields...)...)
+}
+
+// publishTranscript 推送本轮最后一条用户消息和助手回复的脱敏预览
+// 实时监控独立于 AUDIT_LEVEL，没有订阅者时直接跳过，避免额外的文本处理开销
+func publishTranscript(conversationID string, req types.AnthropicRequest, outputTokens int, assistantText string) {
+	hub := audit.GetTranscriptHub()
+	if hub.SubscriberCount() == 0 {
+		return
+	}
+
+	if len(req.Messages) > 0 {
+		last := req.Messages[len(req.Messages)-1]
+		if last.Role == "user" {
+			text, _ := utils.GetMessageContent(last.Content)
+			hub.Publish(audit.TranscriptEvent{
+				ConversationID: conversationID,
+				Role:           "user",
+				ContentPreview: audit.RedactPreview(text, config.TranscriptPreviewChars),
+				TokenCount:     utils.NewTokenEstimatorForModel(req.Model).EstimateTextTokens(text),
+			})
+		}
+	}
+
+	hub.Publish(audit.TranscriptEvent{
+		ConversationID: conversationID,
+		Role:           "assistant",
+		ContentPreview: audit.RedactPreview(assistantText, config.TranscriptPreviewChars),
+		TokenCount:     outputTokens,
+	})
+}
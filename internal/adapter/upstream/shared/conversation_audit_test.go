@@ -0,0 +1,98 @@
+package shared
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	srvcontext "kiro2api/internal/adapter/httpapi/context"
+	"kiro2api/internal/audit"
+	"kiro2api/logger"
+	"kiro2api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordConversationTurn_PublishesTranscript(t *testing.T) {
+	t.Setenv("AUDIT_LEVEL", "off")
+	gin.SetMode(gin.TestMode)
+
+	events, cancel := audit.GetTranscriptHub().Subscribe()
+	defer cancel()
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/v1/messages", nil)
+	srvcontext.SetConversationID(c, "conv-1")
+
+	req := types.AnthropicRequest{
+		Model: "claude-sonnet-4",
+		Messages: []types.AnthropicRequestMessage{
+			{Role: "user", Content: "我的邮箱是 bob@example.com，令牌 Bearer secret-token"},
+		},
+	}
+	RecordConversationTurn(c, req, 20, 7, "end_turn", "已收到")
+
+	require.Len(t, events, 2)
+	user := <-events
+	assert.Equal(t, "conv-1", user.ConversationID)
+	assert.Equal(t, "user", user.Role)
+	assert.Equal(t, "我的邮箱是 [EMAIL]，令牌 Bearer [REDACTED]", user.ContentPreview)
+	assert.Positive(t, user.TokenCount)
+
+	assistant := <-events
+	assert.Equal(t, audit.TranscriptEvent{ConversationID: "conv-1", Role: "assistant", ContentPreview: "已收到", TokenCount: 7}, assistant)
+}
+
+func TestRecordConversationTurn_NoConversationID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	events, cancel := audit.GetTranscriptHub().Subscribe()
+	defer cancel()
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/v1/messages", nil)
+	RecordConversationTurn(c, types.AnthropicRequest{Model: "claude-sonnet-4"}, 1, 1, "end_turn", "ok")
+
+	assert.Len(t, events, 0)
+}
+
+func TestRecordConversationTurn_LogsAppliedHeaders(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "completion.log")
+	t.Setenv("LOG_FILE", logFile)
+	t.Setenv("LOG_CONSOLE", "false")
+	t.Setenv("LOG_LEVEL", "info")
+	logger.Reinitialize()
+	t.Cleanup(func() {
+		os.Unsetenv("LOG_FILE")
+		logger.Reinitialize()
+	})
+
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/v1/messages", nil)
+	srvcontext.SetRequestID(c, "req_override")
+	srvcontext.SetAppliedHeaders(c, srvcontext.AppliedHeaders{Strategy: "legacy", AgentMode: "spec"})
+
+	RecordConversationTurn(c, types.AnthropicRequest{Model: "claude-sonnet-4"}, 10, 3, "end_turn", "")
+
+	data, err := os.ReadFile(logFile)
+	require.NoError(t, err)
+
+	var entry map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var parsed map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &parsed))
+		if parsed["message"] == "请求完成" {
+			entry = parsed
+		}
+	}
+	require.NotNil(t, entry, "应输出请求完成日志")
+	assert.Equal(t, "req_override", entry["request_id"])
+	assert.Equal(t, "legacy", entry["header_strategy"])
+	assert.Equal(t, "spec", entry["agent_mode"])
+	assert.Equal(t, "claude-sonnet-4", entry["model"])
+}
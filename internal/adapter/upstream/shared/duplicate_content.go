@@ -0,0 +1,77 @@
+package shared
+
+import (
+	"hash"
+	"hash/fnv"
+	"strings"
+
+	"kiro2api/config"
+)
+
+// blockTextTracker 记录单个内容块已输出的文本
+// 文本只保留前 DuplicateFinalMaxTrackedBytes 字节用于前缀比较，
+// 同时维护全部文本的长度和增量哈希，超出上限后仍可判断整段重复
+type blockTextTracker struct {
+	length    int
+	prefix    strings.Builder
+	truncated bool
+	digest    hash.Hash64
+}
+
+// duplicateContentDetector 检测上游在增量输出后重发的完整文本
+// 部分上游流在发送完所有增量后，会在最后一个 assistantResponseEvent 中再次发送整段内容，
+// 直传会导致客户端把整段回答渲染两次
+type duplicateContentDetector struct {
+	blocks map[int]*blockTextTracker
+}
+
+func newDuplicateContentDetector() *duplicateContentDetector {
+	return &duplicateContentDetector{blocks: make(map[int]*blockTextTracker)}
+}
+
+// isDuplicate 判断 text 是否为该内容块已输出文本的重发：
+// 与已输出文本完全相同，或为其前缀且长度不少于 DuplicateFinalPrefixRatio
+func (d *duplicateContentDetector) isDuplicate(index int, text string) bool {
+	tracker, ok := d.blocks[index]
+	if !ok || len(text) < config.DuplicateFinalMinBytes || len(text) > tracker.length {
+		return false
+	}
+
+	if float64(len(text)) < float64(tracker.length)*config.DuplicateFinalPrefixRatio {
+		return false
+	}
+
+	if len(text) <= tracker.prefix.Len() {
+		return strings.HasPrefix(tracker.prefix.String(), text)
+	}
+
+	// 超出保留的前缀：只能通过长度和哈希判断整段重复
+	if len(text) != tracker.length {
+		return false
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(text))
+	return h.Sum64() == tracker.digest.Sum64()
+}
+
+// record 记录已输出到客户端的文本
+func (d *duplicateContentDetector) record(index int, text string) {
+	tracker, ok := d.blocks[index]
+	if !ok {
+		tracker = &blockTextTracker{digest: fnv.New64a()}
+		d.blocks[index] = tracker
+	}
+
+	tracker.length += len(text)
+	_, _ = tracker.digest.Write([]byte(text))
+
+	if tracker.truncated {
+		return
+	}
+	if remaining := config.DuplicateFinalMaxTrackedBytes - tracker.prefix.Len(); len(text) > remaining {
+		tracker.prefix.WriteString(text[:remaining])
+		tracker.truncated = true
+		return
+	}
+	tracker.prefix.WriteString(text)
+}
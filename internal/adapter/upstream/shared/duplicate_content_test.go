@@ -0,0 +1,161 @@
+package shared
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"kiro2api/config"
+	"kiro2api/parser"
+	"kiro2api/types"
+	"kiro2api/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingSender 记录发送给客户端的事件
+type recordingSender struct {
+	events []map[string]any
+}
+
+func (s *recordingSender) SendEvent(c *gin.Context, data any) error {
+	if m, ok := data.(map[string]any); ok {
+		s.events = append(s.events, m)
+	}
+	return nil
+}
+
+func (s *recordingSender) SendError(c *gin.Context, message string, err error) error {
+	return nil
+}
+
+// text 拼接所有 text_delta 的文本
+func (s *recordingSender) text() string {
+	var sb strings.Builder
+	for _, event := range s.events {
+		if delta, ok := event["delta"].(map[string]any); ok && delta["type"] == "text_delta" {
+			sb.WriteString(delta["text"].(string))
+		}
+	}
+	return sb.String()
+}
+
+// eventStreamFrame 构造AWS EventStream二进制帧（测试用，CRC置零，解析器不校验）
+func eventStreamFrame(t *testing.T, eventType string, payload any) []byte {
+	t.Helper()
+	body, err := utils.FastMarshal(payload)
+	require.NoError(t, err)
+
+	var headers []byte
+	for _, h := range [][2]string{
+		{":message-type", parser.MessageTypes.EVENT},
+		{":event-type", eventType},
+		{":content-type", "application/json"},
+	} {
+		headers = append(headers, byte(len(h[0])))
+		headers = append(headers, h[0]...)
+		headers = append(headers, 7) // string 类型
+		headers = binary.BigEndian.AppendUint16(headers, uint16(len(h[1])))
+		headers = append(headers, h[1]...)
+	}
+
+	totalLength := 12 + len(headers) + len(body) + 4
+	frame := make([]byte, 12, totalLength)
+	binary.BigEndian.PutUint32(frame[0:4], uint32(totalLength))
+	binary.BigEndian.PutUint32(frame[4:8], uint32(len(headers)))
+	frame = append(frame, headers...)
+	frame = append(frame, body...)
+	return append(frame, 0, 0, 0, 0)
+}
+
+func newTestStreamProcessor(t *testing.T) (*EventStreamProcessor, *recordingSender) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+
+	sender := &recordingSender{}
+	ctx := NewStreamProcessorContext(c, types.AnthropicRequest{Model: "claude-sonnet-4"}, nil, sender, "msg_test", 10)
+	t.Cleanup(ctx.Cleanup)
+	return NewEventStreamProcessor(ctx), sender
+}
+
+// TestProcessEventStream_SuppressesDuplicateFinalContent 上游在增量之后重发完整内容时，客户端只收到一次文本
+func TestProcessEventStream_SuppressesDuplicateFinalContent(t *testing.T) {
+	deltas := []string{
+		"Go 的 goroutine 是由运行时调度的轻量级线程，",
+		"创建成本很低，初始栈只有几 KB，",
+		"可以轻松启动成千上万个。",
+	}
+	full := strings.Join(deltas, "")
+
+	var stream bytes.Buffer
+	for _, d := range deltas {
+		stream.Write(eventStreamFrame(t, parser.EventTypes.ASSISTANT_RESPONSE_EVENT, map[string]any{"content": d}))
+	}
+	// 捕获到的上游行为：最后一个 assistantResponseEvent 重发整段内容
+	stream.Write(eventStreamFrame(t, parser.EventTypes.ASSISTANT_RESPONSE_EVENT, map[string]any{"content": full}))
+
+	processor, sender := newTestStreamProcessor(t)
+	require.NoError(t, processor.ProcessEventStream(&stream))
+
+	assert.Equal(t, full, sender.text())
+	assert.Equal(t, 1, strings.Count(sender.text(), full))
+
+	// 被丢弃的重复文本不计入输出 token
+	estimator := utils.NewTokenEstimator()
+	expectedTokens := 0
+	for _, d := range deltas {
+		expectedTokens += estimator.EstimateTextTokens(d)
+	}
+	assert.Equal(t, expectedTokens, processor.ctx.totalOutputTokens)
+}
+
+func TestDuplicateContentDetector(t *testing.T) {
+	full := strings.Repeat("abcdefghij", 10)
+
+	t.Run("完整重发", func(t *testing.T) {
+		d := newDuplicateContentDetector()
+		d.record(0, full[:40])
+		d.record(0, full[40:])
+		assert.True(t, d.isDuplicate(0, full))
+	})
+
+	t.Run("不少于90%的前缀", func(t *testing.T) {
+		d := newDuplicateContentDetector()
+		d.record(0, full)
+		assert.True(t, d.isDuplicate(0, full[:90]))
+		assert.False(t, d.isDuplicate(0, full[:80]))
+	})
+
+	t.Run("短文本和其他内容块不判定为重复", func(t *testing.T) {
+		d := newDuplicateContentDetector()
+		d.record(0, "hello")
+		assert.False(t, d.isDuplicate(0, "hello"))
+
+		d.record(1, full)
+		assert.False(t, d.isDuplicate(0, full))
+		assert.False(t, d.isDuplicate(1, strings.Repeat("z", len(full))))
+	})
+
+	t.Run("超出保留上限后仍能识别整段重发", func(t *testing.T) {
+		d := newDuplicateContentDetector()
+		chunk := strings.Repeat("x", 1024)
+		var sb strings.Builder
+		for sb.Len() <= config.DuplicateFinalMaxTrackedBytes {
+			d.record(0, chunk)
+			sb.WriteString(chunk)
+		}
+
+		tracker := d.blocks[0]
+		assert.True(t, tracker.truncated)
+		assert.Equal(t, config.DuplicateFinalMaxTrackedBytes, tracker.prefix.Len())
+		assert.True(t, d.isDuplicate(0, sb.String()))
+		assert.False(t, d.isDuplicate(0, sb.String()[:sb.Len()-1]+"y"))
+	})
+}
@@ -0,0 +1,263 @@
+package shared
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"kiro2api/config"
+	"kiro2api/logger"
+	"kiro2api/utils"
+)
+
+// EndpointHealth 单个上游端点在滑动窗口内的健康状况
+type EndpointHealth struct {
+	Endpoint    string     `json:"endpoint"`
+	Region      string     `json:"region,omitempty"`
+	Primary     bool       `json:"primary"`
+	Active      bool       `json:"active"`
+	Healthy     bool       `json:"healthy"`
+	Requests    int        `json:"requests"`
+	Errors      int        `json:"errors"`
+	ErrorRate   float64    `json:"error_rate"`
+	P95Ms       float64    `json:"p95_ms"`
+	LastProbeAt *time.Time `json:"last_probe_at,omitempty"`
+}
+
+// endpointSample 一次上游请求的结果
+type endpointSample struct {
+	at      time.Time
+	latency time.Duration
+	failed  bool
+}
+
+// endpointState 单个端点的样本与健康标记
+type endpointState struct {
+	url       string
+	samples   []endpointSample
+	unhealthy bool
+	lastProbe time.Time
+	probing   bool
+}
+
+// EndpointPool 按优先级管理多个上游端点
+// 优先使用排在前面的端点；窗口内错误率超过阈值时标记为不健康并切换到下一个端点，
+// 不健康端点每隔 probeInterval 探测一次，探测成功后恢复
+type EndpointPool struct {
+	mutex         sync.Mutex
+	endpoints     []*endpointState
+	window        time.Duration
+	errorPercent  int
+	probeInterval time.Duration
+	now           func() time.Time
+	probe         func(endpoint string) bool
+}
+
+var (
+	globalEndpointPool *EndpointPool
+	endpointPoolOnce   sync.Once
+)
+
+// GetEndpointPool 获取全局上游端点池（基于 UPSTREAM_ENDPOINTS 等环境变量创建）
+func GetEndpointPool() *EndpointPool {
+	endpointPoolOnce.Do(func() {
+		globalEndpointPool = NewEndpointPool(config.UpstreamEndpoints(), config.UpstreamHealthWindow(),
+			config.UpstreamFailoverErrorPercent(), config.UpstreamProbeInterval())
+	})
+	return globalEndpointPool
+}
+
+// NewEndpointPool 创建上游端点池，endpoints 按优先级排列，第一个为主端点
+func NewEndpointPool(endpoints []string, window time.Duration, errorPercent int, probeInterval time.Duration) *EndpointPool {
+	if len(endpoints) == 0 {
+		endpoints = []string{config.DefaultUpstreamEndpoint}
+	}
+
+	p := &EndpointPool{
+		window:        window,
+		errorPercent:  errorPercent,
+		probeInterval: probeInterval,
+		now:           time.Now,
+		probe:         probeEndpoint,
+	}
+	for _, endpoint := range endpoints {
+		p.endpoints = append(p.endpoints, &endpointState{url: endpoint})
+	}
+	return p
+}
+
+// Select 返回当前应使用的端点基础地址
+// 同时为已到探测时间的不健康端点启动后台探测；所有端点都不健康时回退到主端点
+func (p *EndpointPool) Select() string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := p.now()
+	for _, ep := range p.endpoints {
+		if ep.unhealthy && !ep.probing && now.Sub(ep.lastProbe) >= p.probeInterval {
+			ep.probing = true
+			go p.runProbe(ep)
+		}
+	}
+	return p.activeUnlocked()
+}
+
+// Record 记录一次上游请求结果；failed 表示网络错误或5xx响应
+func (p *EndpointPool) Record(endpoint string, latency time.Duration, failed bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	ep := p.find(endpoint)
+	if ep == nil {
+		return
+	}
+
+	now := p.now()
+	ep.samples = append(ep.samples, endpointSample{at: now, latency: latency, failed: failed})
+	if len(ep.samples) > config.UpstreamHealthMaxSamples {
+		ep.samples = ep.samples[len(ep.samples)-config.UpstreamHealthMaxSamples:]
+	}
+	p.prune(ep, now)
+
+	if ep.unhealthy {
+		return
+	}
+
+	requests, errors := countSamples(ep.samples)
+	if requests >= config.UpstreamHealthMinSamples && errors*100 > p.errorPercent*requests {
+		ep.unhealthy = true
+		ep.lastProbe = now
+		logger.Warn("上游端点错误率超过阈值，切换到备用端点",
+			logger.String("endpoint", ep.url),
+			logger.Int("requests", requests),
+			logger.Int("errors", errors),
+			logger.Int("threshold_percent", p.errorPercent))
+	}
+}
+
+// Snapshot 返回所有端点的健康状况（按优先级排列）
+func (p *EndpointPool) Snapshot() []EndpointHealth {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := p.now()
+	active := p.activeUnlocked()
+
+	result := make([]EndpointHealth, 0, len(p.endpoints))
+	for i, ep := range p.endpoints {
+		p.prune(ep, now)
+		requests, errors := countSamples(ep.samples)
+
+		health := EndpointHealth{
+			Endpoint: ep.url,
+			Region:   config.RegionFromEndpoint(ep.url),
+			Primary:  i == 0,
+			Active:   ep.url == active,
+			Healthy:  !ep.unhealthy,
+			Requests: requests,
+			Errors:   errors,
+			P95Ms:    p95Millis(ep.samples),
+		}
+		if requests > 0 {
+			health.ErrorRate = float64(errors) / float64(requests)
+		}
+		if ep.unhealthy && !ep.lastProbe.IsZero() {
+			lastProbe := ep.lastProbe
+			health.LastProbeAt = &lastProbe
+		}
+		result = append(result, health)
+	}
+	return result
+}
+
+// runProbe 探测不健康端点，成功则清空历史样本并恢复
+func (p *EndpointPool) runProbe(ep *endpointState) {
+	ok := p.probe(ep.url)
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	ep.probing = false
+	ep.lastProbe = p.now()
+	if !ok {
+		logger.Debug("上游端点探测失败", logger.String("endpoint", ep.url))
+		return
+	}
+
+	ep.unhealthy = false
+	ep.samples = nil
+	logger.Info("上游端点探测成功，恢复使用", logger.String("endpoint", ep.url))
+}
+
+// activeUnlocked 返回第一个健康端点，全部不健康时返回主端点（调用方需持有锁）
+func (p *EndpointPool) activeUnlocked() string {
+	for _, ep := range p.endpoints {
+		if !ep.unhealthy {
+			return ep.url
+		}
+	}
+	return p.endpoints[0].url
+}
+
+func (p *EndpointPool) find(endpoint string) *endpointState {
+	for _, ep := range p.endpoints {
+		if ep.url == endpoint {
+			return ep
+		}
+	}
+	return nil
+}
+
+// prune 丢弃滑动窗口之外的样本
+func (p *EndpointPool) prune(ep *endpointState, now time.Time) {
+	cutoff := now.Add(-p.window)
+	i := 0
+	for i < len(ep.samples) && ep.samples[i].at.Before(cutoff) {
+		i++
+	}
+	if i > 0 {
+		ep.samples = append([]endpointSample(nil), ep.samples[i:]...)
+	}
+}
+
+func countSamples(samples []endpointSample) (requests, errors int) {
+	for _, s := range samples {
+		if s.failed {
+			errors++
+		}
+	}
+	return len(samples), errors
+}
+
+func p95Millis(samples []endpointSample) float64 {
+	if len(samples) == 0 {
+		return 0
+	}
+	latencies := make([]time.Duration, len(samples))
+	for i, s := range samples {
+		latencies[i] = s.latency
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	idx := (len(latencies)*95+99)/100 - 1
+	return float64(latencies[idx]) / float64(time.Millisecond)
+}
+
+// probeEndpoint 向端点基础地址发送无认证的GET请求，非5xx响应即视为可用
+func probeEndpoint(endpoint string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), config.UpstreamProbeTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := utils.SharedHTTPClient.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode < http.StatusInternalServerError
+}
@@ -0,0 +1,198 @@
+package shared
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"kiro2api/types"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock 可手动推进的时钟
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+}
+
+func newTestEndpointPool(endpoints ...string) (*EndpointPool, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	pool := NewEndpointPool(endpoints, time.Minute, 50, 30*time.Second)
+	pool.now = clock.Now
+	pool.probe = func(string) bool { return false }
+	return pool, clock
+}
+
+func TestEndpointPool_PrefersPrimary(t *testing.T) {
+	pool, _ := newTestEndpointPool("https://a", "https://b")
+
+	for i := 0; i < 10; i++ {
+		pool.Record("https://a", 100*time.Millisecond, false)
+	}
+
+	assert.Equal(t, "https://a", pool.Select())
+}
+
+func TestEndpointPool_FailsOverAboveThreshold(t *testing.T) {
+	pool, _ := newTestEndpointPool("https://a", "https://b")
+
+	// 样本数不足时不切换
+	for i := 0; i < 4; i++ {
+		pool.Record("https://a", time.Millisecond, true)
+	}
+	assert.Equal(t, "https://a", pool.Select())
+
+	pool.Record("https://a", time.Millisecond, true)
+	assert.Equal(t, "https://b", pool.Select())
+
+	health := pool.Snapshot()
+	require.Len(t, health, 2)
+	assert.False(t, health[0].Healthy)
+	assert.False(t, health[0].Active)
+	assert.True(t, health[0].Primary)
+	assert.Equal(t, 1.0, health[0].ErrorRate)
+	assert.NotNil(t, health[0].LastProbeAt)
+	assert.True(t, health[1].Active)
+}
+
+func TestEndpointPool_ErrorRateAtThresholdStaysHealthy(t *testing.T) {
+	pool, _ := newTestEndpointPool("https://a", "https://b")
+
+	for i := 0; i < 10; i++ {
+		pool.Record("https://a", time.Millisecond, i >= 5)
+	}
+
+	assert.Equal(t, "https://a", pool.Select(), "错误率等于阈值时不应切换")
+}
+
+func TestEndpointPool_OldSamplesLeaveWindow(t *testing.T) {
+	pool, clock := newTestEndpointPool("https://a", "https://b")
+
+	for i := 0; i < 3; i++ {
+		pool.Record("https://a", time.Millisecond, true)
+	}
+	clock.Advance(2 * time.Minute)
+	for i := 0; i < 3; i++ {
+		pool.Record("https://a", time.Millisecond, true)
+	}
+
+	assert.Equal(t, "https://a", pool.Select(), "窗口外的失败不应计入")
+	assert.Equal(t, 3, pool.Snapshot()[0].Requests)
+}
+
+func TestEndpointPool_P95(t *testing.T) {
+	pool, _ := newTestEndpointPool("https://a")
+
+	for i := 1; i <= 100; i++ {
+		pool.Record("https://a", time.Duration(i)*time.Millisecond, false)
+	}
+
+	assert.Equal(t, 95.0, pool.Snapshot()[0].P95Ms)
+}
+
+func TestEndpointPool_ProbeRecoversPrimary(t *testing.T) {
+	pool, clock := newTestEndpointPool("https://a", "https://b")
+	var probes atomic.Int32
+	var recovered atomic.Bool
+	pool.probe = func(endpoint string) bool {
+		probes.Add(1)
+		return recovered.Load()
+	}
+
+	for i := 0; i < 5; i++ {
+		pool.Record("https://a", time.Millisecond, true)
+	}
+	assert.Equal(t, "https://b", pool.Select())
+	assert.Equal(t, int32(0), probes.Load(), "未到探测间隔时不应探测")
+
+	// 探测失败时继续使用备用端点
+	clock.Advance(30 * time.Second)
+	pool.Select()
+	assert.Eventually(t, func() bool { return probes.Load() == 1 }, time.Second, 5*time.Millisecond)
+	assert.Eventually(t, func() bool { return pool.Select() == "https://b" && !pool.Snapshot()[0].Healthy }, time.Second, 5*time.Millisecond)
+
+	recovered.Store(true)
+	clock.Advance(30 * time.Second)
+	pool.Select()
+	assert.Eventually(t, func() bool { return pool.Select() == "https://a" }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, 0, pool.Snapshot()[0].Requests, "恢复后应清空历史样本")
+}
+
+func TestExecute_ShiftsTrafficWhenPrimaryFails(t *testing.T) {
+	var primaryFailing atomic.Bool
+	var primaryHits, secondaryHits atomic.Int32
+
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		primaryHits.Add(1)
+		if primaryFailing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		assert.Equal(t, "/generateAssistantResponse", r.URL.Path)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer primary.Close()
+
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secondaryHits.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer secondary.Close()
+
+	pool, _ := newTestEndpointPool(primary.URL, secondary.URL)
+	rp := NewReverseProxy(primary.Client())
+	rp.stealthEnabled = false
+	rp.SetEndpointPool(pool)
+	// 只验证端点池的切换，关闭熔断以免提前拒绝请求
+	rp.SetCircuitBreaker(nil)
+
+	send := func() error {
+		resp, err := rp.Execute(newRetryTestContext(), newRetryTestRequest(), types.TokenInfo{AccessToken: "token"}, false)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, send())
+	}
+	assert.Equal(t, int32(5), primaryHits.Load())
+	assert.Equal(t, int32(0), secondaryHits.Load())
+
+	// 主端点开始返回5xx：窗口内11个样本中6个失败，错误率超过50%后切换
+	primaryFailing.Store(true)
+	for i := 0; i < 6; i++ {
+		assert.Error(t, send())
+	}
+	assert.Equal(t, secondary.URL, pool.Select())
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, send())
+	}
+	assert.Equal(t, int32(11), primaryHits.Load(), "切换后不应再向主端点发送请求")
+	assert.Equal(t, int32(5), secondaryHits.Load())
+
+	health := pool.Snapshot()
+	assert.False(t, health[0].Healthy)
+	assert.Equal(t, 6, health[0].Errors)
+	assert.True(t, health[1].Active)
+	assert.Equal(t, 5, health[1].Requests)
+}
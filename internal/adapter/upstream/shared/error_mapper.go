@@ -106,11 +106,13 @@ func (em *ErrorMapper) SendClaudeError(c *gin.Context, claudeError *ClaudeErrorR
 	}
 }
 
+// sendMaxTokensResponse 上游拒绝超长请求时以 max_tokens 的 message_delta 响应，stop_reason 按 STOP_REASON_OVERRIDES 替换
 func (em *ErrorMapper) sendMaxTokensResponse(c *gin.Context, claudeError *ClaudeErrorResponse) {
+	stopReason := GetStopReasonOverrides().Apply("max_tokens")
 	response := map[string]any{
 		"type": "message_delta",
 		"delta": map[string]any{
-			"stop_reason":   "max_tokens",
+			"stop_reason":   stopReason,
 			"stop_sequence": nil,
 		},
 		"usage": map[string]any{
@@ -119,16 +121,24 @@ func (em *ErrorMapper) sendMaxTokensResponse(c *gin.Context, claudeError *Claude
 		},
 	}
 
+	// 流式请求在上游接受前尚未提交SSE响应头
+	if !c.Writer.Written() {
+		if err := InitializeSSEResponse(c); err != nil {
+			logger.Error("初始化SSE响应失败", logger.Err(err))
+		}
+	}
+
 	sender := &AnthropicStreamSender{}
 	if err := sender.SendEvent(c, response); err != nil {
 		logger.Error("发送max_tokens响应失败",
 			logger.Err(err),
 			logger.String("original_message", claudeError.Message))
 	}
+	c.Writer.Flush()
 
 	logger.Info("已发送max_tokens stop_reason响应",
 		logutil.AddFields(c,
-			logger.String("stop_reason", "max_tokens"),
+			logger.String("stop_reason", stopReason),
 			logger.String("original_message", claudeError.Message))...)
 }
 
@@ -145,4 +155,5 @@ func (em *ErrorMapper) sendStandardError(c *gin.Context, claudeError *ClaudeErro
 	if err := sender.SendEvent(c, errorResp); err != nil {
 		logger.Error("发送标准错误响应失败", logger.Err(err))
 	}
+	c.Writer.Flush()
 }
@@ -44,6 +44,7 @@ func TestErrorMapper_SendClaudeError(t *testing.T) {
 	})
 
 	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/event-stream; charset=utf-8", w.Header().Get("Content-Type"))
 	assert.Contains(t, w.Body.String(), "message_delta")
 }
 
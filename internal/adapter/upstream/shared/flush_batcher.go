@@ -0,0 +1,105 @@
+package shared
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// FlushBatcher 批量刷新SSE事件，减少高吞吐流式输出时的写系统调用
+// batchSize<=1 时每个事件立即刷新（与原有行为一致）；
+// 否则累积到 batchSize 个事件再刷新，未满时由定时器在 timeout 后兜底刷新。
+// 写入与定时器刷新共用同一把锁，调用方必须在 Write 的回调中完成事件写入。
+type FlushBatcher struct {
+	mu        sync.Mutex
+	flusher   http.Flusher
+	batchSize int
+	timeout   time.Duration
+	pending   int
+	timer     *time.Timer
+	closed    bool
+	flushes   int
+}
+
+// NewFlushBatcher 创建批量刷新器
+func NewFlushBatcher(flusher http.Flusher, batchSize int, timeout time.Duration) *FlushBatcher {
+	return &FlushBatcher{
+		flusher:   flusher,
+		batchSize: batchSize,
+		timeout:   timeout,
+	}
+}
+
+// Write 在锁内执行一次事件写入，并按批量策略决定是否刷新
+func (b *FlushBatcher) Write(write func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	write()
+	if b.closed {
+		return
+	}
+
+	b.pending++
+	if b.pending >= b.batchSize {
+		b.flushLocked()
+		return
+	}
+	if b.timer == nil {
+		b.timer = time.AfterFunc(b.timeout, b.flushOnTimeout)
+	}
+}
+
+// Flush 立即刷新已缓冲的事件
+func (b *FlushBatcher) Flush() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.closed {
+		b.flushLocked()
+	}
+}
+
+// Close 刷新剩余事件并停止定时器，之后不再触发刷新
+// 必须在 handler 返回前调用，避免定时器写入已结束的响应
+func (b *FlushBatcher) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	if b.pending > 0 {
+		b.flushLocked()
+	}
+	b.stopTimerLocked()
+	b.closed = true
+}
+
+// Flushes 返回实际刷新次数
+func (b *FlushBatcher) Flushes() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.flushes
+}
+
+func (b *FlushBatcher) flushOnTimeout() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.timer = nil
+	if !b.closed && b.pending > 0 {
+		b.flushLocked()
+	}
+}
+
+func (b *FlushBatcher) flushLocked() {
+	b.stopTimerLocked()
+	b.flusher.Flush()
+	b.flushes++
+	b.pending = 0
+}
+
+func (b *FlushBatcher) stopTimerLocked() {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+}
@@ -0,0 +1,165 @@
+package shared
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"kiro2api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingFlusher 记录刷新次数，每次刷新对应一次写系统调用
+type countingFlusher struct {
+	flushes atomic.Int64
+}
+
+func (f *countingFlusher) Flush() {
+	f.flushes.Add(1)
+}
+
+func TestFlushBatcher_DefaultFlushesEveryEvent(t *testing.T) {
+	f := &countingFlusher{}
+	b := NewFlushBatcher(f, 1, time.Hour)
+
+	for i := 0; i < 5; i++ {
+		b.Write(func() {})
+	}
+	assert.Equal(t, int64(5), f.flushes.Load())
+
+	b.Close()
+	assert.Equal(t, int64(5), f.flushes.Load(), "无剩余事件时Close不应额外刷新")
+}
+
+func TestFlushBatcher_FlushesWhenBatchFull(t *testing.T) {
+	f := &countingFlusher{}
+	b := NewFlushBatcher(f, 4, time.Hour)
+	defer b.Close()
+
+	for i := 0; i < 3; i++ {
+		b.Write(func() {})
+	}
+	assert.Equal(t, int64(0), f.flushes.Load())
+
+	b.Write(func() {})
+	assert.Equal(t, int64(1), f.flushes.Load())
+
+	for i := 0; i < 8; i++ {
+		b.Write(func() {})
+	}
+	assert.Equal(t, int64(3), f.flushes.Load())
+}
+
+func TestFlushBatcher_TimeoutFlushesPartialBatch(t *testing.T) {
+	f := &countingFlusher{}
+	b := NewFlushBatcher(f, 8, 10*time.Millisecond)
+	defer b.Close()
+
+	b.Write(func() {})
+	b.Write(func() {})
+
+	assert.Eventually(t, func() bool { return f.flushes.Load() == 1 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, 1, b.Flushes())
+}
+
+func TestFlushBatcher_CloseFlushesRemainderAndStopsTimer(t *testing.T) {
+	f := &countingFlusher{}
+	b := NewFlushBatcher(f, 8, 20*time.Millisecond)
+
+	b.Write(func() {})
+	b.Close()
+	assert.Equal(t, int64(1), f.flushes.Load())
+
+	// Close 之后的写入和定时器都不再触发刷新
+	b.Write(func() {})
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int64(1), f.flushes.Load())
+}
+
+func TestFlushBatcher_WriteRunsUnderLock(t *testing.T) {
+	f := &countingFlusher{}
+	b := NewFlushBatcher(f, 2, time.Millisecond)
+	defer b.Close()
+
+	var inWrite atomic.Bool
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			b.Write(func() {
+				assert.False(t, inWrite.Swap(true), "写入不应并发执行")
+				time.Sleep(10 * time.Microsecond)
+				inWrite.Store(false)
+			})
+		}
+	}()
+	<-done
+}
+
+// flushCountingRecorder 记录真实 ResponseWriter 上的刷新次数
+type flushCountingRecorder struct {
+	*httptest.ResponseRecorder
+	flushes atomic.Int64
+}
+
+func (r *flushCountingRecorder) Flush() {
+	r.flushes.Add(1)
+	r.ResponseRecorder.Flush()
+}
+
+// TestProcessEventStream_SenderDefersFlushToBatcher 真实发送器不逐事件刷新，
+// 整条流的刷新只来自初始事件、FlushBatcher 和结束事件
+func TestProcessEventStream_SenderDefersFlushToBatcher(t *testing.T) {
+	t.Setenv("FLUSH_BATCH_SIZE", "4")
+	t.Setenv("FLUSH_TIMEOUT_MS", "60000")
+	gin.SetMode(gin.TestMode)
+	recorder := &flushCountingRecorder{ResponseRecorder: httptest.NewRecorder()}
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+
+	deltas := make([]string, 16)
+	for i := range deltas {
+		deltas[i] = fmt.Sprintf("第%d段", i)
+	}
+	ctx := NewStreamProcessorContext(c, types.AnthropicRequest{Model: "claude-sonnet-4"}, nil, &AnthropicStreamSender{}, "msg_flush", 10)
+	defer ctx.Cleanup()
+	require.NoError(t, ctx.SendInitialEvents(func(string, int, string) []map[string]any {
+		return []map[string]any{{"type": "message_start", "message": map[string]any{"id": "msg_flush"}}, {"type": "ping"}}
+	}))
+	processor := NewEventStreamProcessor(ctx)
+	require.NoError(t, processor.ProcessEventStream(textDeltaStream(t, deltas...)))
+	ctx.FinishStream()
+
+	events := strings.Count(recorder.Body.String(), "\n\n")
+	require.Greater(t, events, len(deltas))
+	assert.Equal(t, 5, processor.flusher.Flushes(), "16个上游事件按每批4个刷新，结束时刷新剩余的一批")
+	assert.Equal(t, int64(1+processor.flusher.Flushes()+1), recorder.flushes.Load(), "除初始事件和结束事件外，刷新只来自 FlushBatcher")
+}
+
+// BenchmarkFlushBatcher 比较不同批量大小下的刷新（写系统调用）次数
+func BenchmarkFlushBatcher(b *testing.B) {
+	for _, batchSize := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("batch=%d", batchSize), func(b *testing.B) {
+			f := &countingFlusher{}
+			batcher := NewFlushBatcher(f, batchSize, 10*time.Millisecond)
+
+			start := time.Now()
+			for i := 0; i < b.N; i++ {
+				batcher.Write(func() {})
+			}
+			batcher.Close()
+			elapsed := time.Since(start)
+
+			flushes := float64(f.flushes.Load())
+			b.ReportMetric(flushes/float64(b.N), "flushes/event")
+			b.ReportMetric(flushes/elapsed.Seconds(), "flushes/s")
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package shared
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"kiro2api/config"
+)
+
+// HeaderABTest 请求头策略A/B测试：按客户端IP的稳定哈希把 ControlPercent% 的请求分到 StrategyA，其余分到 StrategyB
+type HeaderABTest struct {
+	ControlPercent int        `json:"control_percent"`
+	StrategyA      string     `json:"strategy_a"`
+	StrategyB      string     `json:"strategy_b"`
+	StartedAt      time.Time  `json:"started_at"`
+	StoppedAt      *time.Time `json:"stopped_at,omitempty"`
+}
+
+// A/B测试的分组名
+const (
+	HeaderABArmA = "a"
+	HeaderABArmB = "b"
+)
+
+// Validate 校验比例和策略，两个策略必须是不同的 kiro/random/legacy
+func (t HeaderABTest) Validate() error {
+	if t.ControlPercent < 0 || t.ControlPercent > 100 {
+		return fmt.Errorf("control_percent 必须在 0-100 之间: %d", t.ControlPercent)
+	}
+	for _, field := range []struct{ name, strategy string }{{"strategy_a", t.StrategyA}, {"strategy_b", t.StrategyB}} {
+		switch field.strategy {
+		case config.HeaderOverrideKiro, config.HeaderOverrideRandom, config.HeaderOverrideLegacy:
+		default:
+			return fmt.Errorf("%s 无效: %q（可选 kiro/random/legacy）", field.name, field.strategy)
+		}
+	}
+	if t.StrategyA == t.StrategyB {
+		return fmt.Errorf("strategy_a 与 strategy_b 不能相同")
+	}
+	return nil
+}
+
+// Assign 返回客户端IP所在的分组和策略，同一IP在测试期间始终落在同一分组
+func (t HeaderABTest) Assign(clientIP string) (arm, strategy string) {
+	if headerABBucket(clientIP) < t.ControlPercent {
+		return HeaderABArmA, t.StrategyA
+	}
+	return HeaderABArmB, t.StrategyB
+}
+
+// headerABBucket 把客户端IP稳定地映射到 [0, 100)
+func headerABBucket(clientIP string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(clientIP))
+	return int(h.Sum32() % 100)
+}
+
+// headerABState 当前（或最近一次已停止）的A/B测试
+var headerABState struct {
+	mutex sync.RWMutex
+	test  *HeaderABTest
+}
+
+// StartHeaderABTest 开始新的A/B测试，替换正在进行的测试
+func StartHeaderABTest(test HeaderABTest) {
+	headerABState.mutex.Lock()
+	defer headerABState.mutex.Unlock()
+	test.StoppedAt = nil
+	headerABState.test = &test
+}
+
+// StopHeaderABTest 停止正在进行的A/B测试，保留配置供查看结果；没有进行中的测试时返回 false
+func StopHeaderABTest(now time.Time) bool {
+	headerABState.mutex.Lock()
+	defer headerABState.mutex.Unlock()
+	if headerABState.test == nil || headerABState.test.StoppedAt != nil {
+		return false
+	}
+	stopped := *headerABState.test
+	stopped.StoppedAt = &now
+	headerABState.test = &stopped
+	return true
+}
+
+// CurrentHeaderABTest 返回当前或最近一次已停止的A/B测试
+func CurrentHeaderABTest() (HeaderABTest, bool) {
+	headerABState.mutex.RLock()
+	defer headerABState.mutex.RUnlock()
+	if headerABState.test == nil {
+		return HeaderABTest{}, false
+	}
+	return *headerABState.test, true
+}
+
+// activeHeaderABTest 返回正在进行的A/B测试
+func activeHeaderABTest() (HeaderABTest, bool) {
+	test, ok := CurrentHeaderABTest()
+	if !ok || test.StoppedAt != nil {
+		return HeaderABTest{}, false
+	}
+	return test, true
+}
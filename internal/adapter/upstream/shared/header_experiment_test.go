@@ -0,0 +1,133 @@
+package shared
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"kiro2api/config"
+	srvcontext "kiro2api/internal/adapter/httpapi/context"
+	"kiro2api/internal/stats"
+	"kiro2api/types"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withHeaderABTest 开始A/B测试并在测试结束后清除
+func withHeaderABTest(t *testing.T, test HeaderABTest) {
+	t.Helper()
+	StartHeaderABTest(test)
+	stats.GetHeaderABStats().Reset()
+	t.Cleanup(func() {
+		headerABState.mutex.Lock()
+		headerABState.test = nil
+		headerABState.mutex.Unlock()
+		stats.GetHeaderABStats().Reset()
+	})
+}
+
+func TestHeaderABTest_AssignSplitsByStableHash(t *testing.T) {
+	test := HeaderABTest{ControlPercent: 80, StrategyA: config.HeaderOverrideKiro, StrategyB: config.HeaderOverrideRandom}
+
+	const clients = 10000
+	arms := map[string]int{}
+	for i := 0; i < clients; i++ {
+		ip := fmt.Sprintf("10.%d.%d.%d", i/65536, i/256%256, i%256)
+		arm, strategy := test.Assign(ip)
+		arms[arm]++
+
+		// 同一IP总是落在同一分组
+		againArm, againStrategy := test.Assign(ip)
+		require.Equal(t, arm, againArm)
+		require.Equal(t, strategy, againStrategy)
+		if arm == HeaderABArmA {
+			require.Equal(t, config.HeaderOverrideKiro, strategy)
+		} else {
+			require.Equal(t, config.HeaderOverrideRandom, strategy)
+		}
+	}
+	assert.InDelta(t, 0.8, float64(arms[HeaderABArmA])/clients, 0.02)
+
+	// 0% 与 100% 时所有客户端落在同一组
+	for _, tt := range []struct {
+		percent int
+		arm     string
+	}{{0, HeaderABArmB}, {100, HeaderABArmA}} {
+		test.ControlPercent = tt.percent
+		for i := 0; i < 500; i++ {
+			arm, _ := test.Assign(fmt.Sprintf("192.168.%d.%d", i/256, i%256))
+			require.Equal(t, tt.arm, arm, "control_percent=%d", tt.percent)
+		}
+	}
+}
+
+func TestHeaderABTest_Validate(t *testing.T) {
+	valid := HeaderABTest{ControlPercent: 50, StrategyA: "kiro", StrategyB: "legacy"}
+	assert.NoError(t, valid.Validate())
+
+	for name, test := range map[string]HeaderABTest{
+		"比例为负":    {ControlPercent: -1, StrategyA: "kiro", StrategyB: "random"},
+		"比例超过100": {ControlPercent: 101, StrategyA: "kiro", StrategyB: "random"},
+		"策略无效":    {ControlPercent: 50, StrategyA: "kiro", StrategyB: "chrome"},
+		"策略相同":    {ControlPercent: 50, StrategyA: "random", StrategyB: "random"},
+	} {
+		assert.Error(t, test.Validate(), name)
+	}
+}
+
+func TestExecute_HeaderABTestAppliesAndRecordsStrategy(t *testing.T) {
+	withHeaderABTest(t, HeaderABTest{ControlPercent: 100, StrategyA: config.HeaderOverrideRandom, StrategyB: config.HeaderOverrideKiro, StartedAt: time.Now()})
+
+	status := http.StatusForbidden
+	var agentModes []string
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		agentModes = append(agentModes, req.Header.Get("x-amzn-kiro-agent-mode"))
+		return &http.Response{StatusCode: status, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(`{"message":"denied"}`))}, nil
+	})}
+	rp := NewReverseProxy(client)
+	rp.stealthEnabled = false
+
+	c := newRetryTestContext()
+	_, err := rp.Execute(c, newRetryTestRequest(), types.TokenInfo{AccessToken: "token"}, false)
+	require.Error(t, err)
+	applied, ok := srvcontext.GetAppliedHeaders(c)
+	require.True(t, ok)
+	assert.Equal(t, srvcontext.AppliedHeaders{Strategy: config.HeaderOverrideRandom, AgentMode: agentModes[0], ABTest: true}, applied)
+
+	status = http.StatusOK
+	resp, err := rp.Execute(newRetryTestContext(), newRetryTestRequest(), types.TokenInfo{AccessToken: "token"}, false)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	metrics := stats.GetHeaderABStats().Snapshot(config.HeaderOverrideRandom)
+	assert.Equal(t, int64(2), metrics.RequestsTotal)
+	assert.Equal(t, int64(1), metrics.ErrorsTotal)
+	assert.Equal(t, 0.5, metrics.ErrorRate)
+	assert.Equal(t, map[int]int64{http.StatusForbidden: 1, http.StatusOK: 1}, metrics.ByStatus)
+	assert.Zero(t, stats.GetHeaderABStats().Snapshot(config.HeaderOverrideKiro).RequestsTotal)
+
+	// 管理员单次覆盖优先，不计入A/B统计
+	c = newRetryTestContext()
+	srvcontext.SetHeaderOverrides(c, srvcontext.HeaderOverrides{Strategy: config.HeaderOverrideLegacy})
+	resp, err = rp.Execute(c, newRetryTestRequest(), types.TokenInfo{AccessToken: "token"}, false)
+	require.NoError(t, err)
+	resp.Body.Close()
+	applied, _ = srvcontext.GetAppliedHeaders(c)
+	assert.Equal(t, config.HeaderOverrideLegacy, applied.Strategy)
+	assert.False(t, applied.ABTest)
+	assert.Equal(t, int64(2), stats.GetHeaderABStats().Snapshot(config.HeaderOverrideRandom).RequestsTotal)
+
+	// 停止后恢复按配置选择策略
+	require.True(t, StopHeaderABTest(time.Now()))
+	assert.False(t, StopHeaderABTest(time.Now()), "已停止的测试不能再次停止")
+	c = newRetryTestContext()
+	resp, err = rp.Execute(c, newRetryTestRequest(), types.TokenInfo{AccessToken: "token"}, false)
+	require.NoError(t, err)
+	resp.Body.Close()
+	applied, _ = srvcontext.GetAppliedHeaders(c)
+	assert.False(t, applied.ABTest)
+}
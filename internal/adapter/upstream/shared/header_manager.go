@@ -95,32 +95,99 @@ func NewHeaderManager() *HeaderManager {
 	}
 }
 
+// HeaderOptions 单次调用的请求头选项，零值表示按 STEALTH_MODE/HEADER_STRATEGY 配置
+type HeaderOptions struct {
+	Strategy  string // config.HeaderOverride* 之一
+	AgentMode string // 非空时替换画像中的 agent mode
+}
+
+// AppliedHeaders Apply 实际使用的请求头策略和 agent mode
+type AppliedHeaders struct {
+	Strategy  string
+	AgentMode string
+}
+
 // Apply 应用请求头
 // tokenIdentifier 用于生成稳定的用户画像（版本号等），同一个 token 在一段时间内保持一致
-func (m *HeaderManager) Apply(req *http.Request, isStream bool, tokenIdentifier string) {
+// requestID 为客户端请求ID，确定性模式下用于派生调用ID和追踪ID
+// opts 为单次请求的覆盖（管理员调试用），返回值记录实际使用的策略和 agent mode
+func (m *HeaderManager) Apply(req *http.Request, isStream bool, tokenIdentifier, requestID string, opts HeaderOptions) AppliedHeaders {
+	strategy := opts.Strategy
+	if strategy == "" {
+		strategy = m.defaultStrategy()
+	}
+
+	if strategy == config.HeaderOverrideLegacy {
+		applyLegacyHeaders(req, isStream, requestID)
+	} else {
+		profile := m.selectProfile(strategy, tokenIdentifier)
+		req.Header.Set("User-Agent", profile.userAgent)
+		req.Header.Set("x-amz-user-agent", profile.xAmzUserAgent)
+		req.Header.Set("x-amzn-kiro-agent-mode", profile.agentMode)
+		req.Header.Set("Accept-Language", profile.acceptLang)
+		req.Header.Set("Accept-Encoding", chooseString(acceptEncodings))
+
+		applyCommonHeaders(req, isStream, requestID)
+	}
+
+	if opts.AgentMode != "" {
+		req.Header.Set("x-amzn-kiro-agent-mode", opts.AgentMode)
+	}
+	return AppliedHeaders{
+		Strategy:  strategy,
+		AgentMode: req.Header.Get("x-amzn-kiro-agent-mode"),
+	}
+}
+
+// defaultStrategy 未覆盖时按配置选择的请求头策略
+func (m *HeaderManager) defaultStrategy() string {
 	if !m.stealthEnabled {
-		applyLegacyHeaders(req, isStream)
-		return
+		return config.HeaderOverrideLegacy
 	}
+	if m.strategy == config.HeaderStrategyRandom {
+		return config.HeaderOverrideRandom
+	}
+	return config.HeaderOverrideKiro
+}
 
-	profile := m.selectProfile(tokenIdentifier)
-	req.Header.Set("User-Agent", profile.userAgent)
-	req.Header.Set("x-amz-user-agent", profile.xAmzUserAgent)
-	req.Header.Set("x-amzn-kiro-agent-mode", profile.agentMode)
-	req.Header.Set("Accept-Language", profile.acceptLang)
-	req.Header.Set("Accept-Encoding", chooseString(acceptEncodings))
+// applyCommonHeaders 设置与伪装策略无关的公共请求头：内容协商、调用ID和追踪ID
+func applyCommonHeaders(req *http.Request, isStream bool, requestID string) {
+	req.Header.Set("Content-Type", config.UpstreamContentType)
+	if isStream {
+		req.Header.Set("Accept", config.UpstreamAcceptStream)
+	} else {
+		req.Header.Set("Accept", config.UpstreamAcceptJSON)
+	}
 
-	if !isStream {
-		req.Header.Set("Accept", "application/json")
+	if config.IsDeterministicModeEnabled() {
+		invocationID, traceID, amznRequestID := deterministicRequestIDs(requestID)
+		req.Header.Set("amz-sdk-invocation-id", invocationID)
+		req.Header.Set("X-Amzn-Trace-Id", traceID)
+		req.Header.Set("X-Amzn-RequestId", amznRequestID)
+		return
 	}
 
+	req.Header.Set("amz-sdk-invocation-id", utils.GenerateUUID())
 	req.Header.Set("X-Amzn-Trace-Id", buildTraceID())
 	req.Header.Set("X-Amzn-RequestId", strings.ToUpper(utils.RandomHex(32)))
 }
 
-func (m *HeaderManager) selectProfile(tokenIdentifier string) agentProfile {
-	switch m.strategy {
-	case config.HeaderStrategyRandom:
+// deterministicRequestIDs 由请求ID派生固定的调用ID、追踪ID和请求ID，格式与随机生成的一致
+func deterministicRequestIDs(requestID string) (invocationID, traceID, amznRequestID string) {
+	sum := sha256.Sum256([]byte("kiro-deterministic-" + requestID))
+	digest := hex.EncodeToString(sum[:])
+
+	b := sum[:16]
+	invocationID = fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6],
+		[]byte{(b[6] & 0x0f) | 0x40, b[7]}, []byte{(b[8] & 0x3f) | 0x80, b[9]}, b[10:16])
+	traceID = fmt.Sprintf("Root=1-%08x-%s;Parent=%s;Sampled=1", 0, digest[:24], digest[24:40])
+	amznRequestID = strings.ToUpper(digest[32:64])
+	return invocationID, traceID, amznRequestID
+}
+
+func (m *HeaderManager) selectProfile(strategy, tokenIdentifier string) agentProfile {
+	switch strategy {
+	case config.HeaderOverrideRandom:
 		return m.randomProfile(tokenIdentifier)
 	default:
 		// 默认使用 Kiro IDE 格式（更真实）
@@ -356,12 +423,10 @@ func randomKiroOS() string {
 	return "os/win32#10.0.22621"
 }
 
-func applyLegacyHeaders(req *http.Request, isStream bool) {
+func applyLegacyHeaders(req *http.Request, isStream bool, requestID string) {
 	req.Header.Set("x-amzn-kiro-agent-mode", "vibe")
 	req.Header.Set("x-amz-user-agent", "aws-sdk-js/1.0.27 KiroIDE-legacy")
 	req.Header.Set("User-Agent", "aws-sdk-js/1.0.27 ua/legacy")
 	req.Header.Set("Accept-Encoding", "gzip")
-	if !isStream {
-		req.Header.Set("Accept", "application/json")
-	}
+	applyCommonHeaders(req, isStream, requestID)
 }
@@ -0,0 +1,123 @@
+package shared
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+
+	"kiro2api/config"
+	"kiro2api/config/configtest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	uuidPattern      = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	traceIDPattern   = regexp.MustCompile(`^Root=1-[0-9a-f]{8}-[0-9a-f]{24};Parent=[0-9a-f]{16};Sampled=[01]$`)
+	requestIDPattern = regexp.MustCompile(`^[0-9A-F]{32}$`)
+)
+
+func TestHeaderManager_Apply(t *testing.T) {
+	tests := []struct {
+		name    string
+		stealth bool
+		stream  bool
+		accept  string
+	}{
+		{"stealth+stream", true, true, config.UpstreamAcceptStream},
+		{"stealth+non-stream", true, false, config.UpstreamAcceptJSON},
+		{"legacy+stream", false, true, config.UpstreamAcceptStream},
+		{"legacy+non-stream", false, false, config.UpstreamAcceptJSON},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &HeaderManager{
+				stealthEnabled: tt.stealth,
+				strategy:       config.HeaderStrategyRealSimulation,
+			}
+			req, err := http.NewRequest(http.MethodPost, config.CodeWhispererURL, nil)
+			require.NoError(t, err)
+
+			m.Apply(req, tt.stream, "refresh-token", "req_test", HeaderOptions{})
+
+			assert.Equal(t, tt.accept, req.Header.Get("Accept"))
+			assert.Equal(t, config.UpstreamContentType, req.Header.Get("Content-Type"))
+			assert.Regexp(t, uuidPattern, req.Header.Get("amz-sdk-invocation-id"))
+			assert.Regexp(t, traceIDPattern, req.Header.Get("X-Amzn-Trace-Id"))
+			assert.Regexp(t, requestIDPattern, req.Header.Get("X-Amzn-RequestId"))
+
+			assert.NotEmpty(t, req.Header.Get("User-Agent"))
+			assert.NotEmpty(t, req.Header.Get("x-amz-user-agent"))
+			assert.Equal(t, "vibe", req.Header.Get("x-amzn-kiro-agent-mode"))
+			assert.NotEmpty(t, req.Header.Get("Accept-Encoding"))
+		})
+	}
+}
+
+func TestHeaderManager_ApplyUniqueInvocationIDs(t *testing.T) {
+	m := &HeaderManager{stealthEnabled: true, strategy: config.HeaderStrategyRealSimulation}
+
+	first, _ := http.NewRequest(http.MethodPost, config.CodeWhispererURL, nil)
+	second, _ := http.NewRequest(http.MethodPost, config.CodeWhispererURL, nil)
+	m.Apply(first, true, "refresh-token", "req_test", HeaderOptions{})
+	m.Apply(second, true, "refresh-token", "req_test", HeaderOptions{})
+
+	assert.NotEqual(t, first.Header.Get("amz-sdk-invocation-id"), second.Header.Get("amz-sdk-invocation-id"))
+	// 同一 token 的用户画像保持稳定
+	assert.Equal(t, first.Header.Get("User-Agent"), second.Header.Get("User-Agent"))
+}
+
+func TestHeaderManager_ApplyDeterministic(t *testing.T) {
+	configtest.WithDeterministic(t)
+
+	for _, stealth := range []bool{true, false} {
+		m := &HeaderManager{stealthEnabled: stealth, strategy: config.HeaderStrategyRandom}
+
+		apply := func(requestID string) http.Header {
+			config.ResetDeterministicState()
+			req, err := http.NewRequest(http.MethodPost, config.CodeWhispererURL, nil)
+			require.NoError(t, err)
+			m.Apply(req, true, "refresh-token", requestID, HeaderOptions{})
+			return req.Header
+		}
+
+		first := apply("req_fixed")
+		second := apply("req_fixed")
+		other := apply("req_other")
+
+		assert.Equal(t, first, second, "相同请求ID应得到完全相同的请求头")
+		assert.Regexp(t, uuidPattern, first.Get("amz-sdk-invocation-id"))
+		assert.Regexp(t, traceIDPattern, first.Get("X-Amzn-Trace-Id"))
+		assert.Regexp(t, requestIDPattern, first.Get("X-Amzn-RequestId"))
+		assert.NotEqual(t, first.Get("X-Amzn-RequestId"), other.Get("X-Amzn-RequestId"))
+	}
+}
+
+func TestHeaderManager_ApplyOverrides(t *testing.T) {
+	m := &HeaderManager{stealthEnabled: false, strategy: config.HeaderStrategyRealSimulation}
+
+	newReq := func() *http.Request {
+		req, err := http.NewRequest(http.MethodPost, config.CodeWhispererURL, nil)
+		require.NoError(t, err)
+		return req
+	}
+
+	req := newReq()
+	applied := m.Apply(req, true, "refresh-token", "req_test", HeaderOptions{})
+	assert.Equal(t, AppliedHeaders{Strategy: config.HeaderOverrideLegacy, AgentMode: "vibe"}, applied)
+	assert.Equal(t, "aws-sdk-js/1.0.27 ua/legacy", req.Header.Get("User-Agent"))
+
+	req = newReq()
+	applied = m.Apply(req, true, "refresh-token", "req_test", HeaderOptions{Strategy: config.HeaderOverrideKiro, AgentMode: "spec"})
+	assert.Equal(t, AppliedHeaders{Strategy: config.HeaderOverrideKiro, AgentMode: "spec"}, applied)
+	assert.Contains(t, req.Header.Get("User-Agent"), "KiroIDE-0.8.0-")
+	assert.Equal(t, "spec", req.Header.Get("x-amzn-kiro-agent-mode"))
+
+	// 覆盖只作用于当次调用
+	req = newReq()
+	applied = m.Apply(req, true, "refresh-token", "req_test", HeaderOptions{})
+	assert.Equal(t, config.HeaderOverrideLegacy, applied.Strategy)
+	assert.Equal(t, "vibe", req.Header.Get("x-amzn-kiro-agent-mode"))
+}
@@ -0,0 +1,52 @@
+package shared
+
+import (
+	"net/http"
+
+	"kiro2api/converter"
+	srvcontext "kiro2api/internal/adapter/httpapi/context"
+	logutil "kiro2api/internal/adapter/httpapi/logging"
+	"kiro2api/internal/adapter/httpapi/support"
+	"kiro2api/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HistoryAdjustmentsHeader 转换时调整了历史消息（丢弃孤立 assistant、合并连续 user、补齐 "OK"）时返回的响应头
+const HistoryAdjustmentsHeader = "X-Kiro-History-Adjustments"
+
+// reportHistoryAdjustments 设置响应头并记录到请求上下文，之后的日志都带有 history_adjustments 字段
+// 429 重试会重新构建请求，调整记录相同，只在第一次记录日志
+func reportHistoryAdjustments(c *gin.Context, adjustments []converter.HistoryAdjustment) {
+	if len(adjustments) == 0 {
+		return
+	}
+	value := converter.HistoryAdjustmentsHeaderValue(adjustments)
+	c.Header(HistoryAdjustmentsHeader, value)
+	if srvcontext.GetHistoryAdjustments(c) == value {
+		return
+	}
+	srvcontext.SetHistoryAdjustments(c, value)
+	logger.Warn("转换时调整了历史消息", logutil.AddFields(c, logger.Int("adjustment_count", len(adjustments)))...)
+}
+
+// respondHistoryViolation STRICT_HISTORY 启用时以400拒绝需要调整的历史，错误信息指出第一处位置
+// OpenAI 路由返回 OpenAI 错误格式（code 为 strict_history），其余返回 Anthropic 的 invalid_request_error
+func respondHistoryViolation(c *gin.Context, violation *converter.HistoryViolationError) {
+	logger.Warn("消息历史不符合交替顺序，STRICT_HISTORY 拒绝请求",
+		logutil.AddFields(c,
+			logger.String("kind", violation.Adjustment.Kind),
+			logger.Int("index", violation.Adjustment.Index),
+		)...)
+	if support.IsOpenAIRoute(c) {
+		support.RespondOpenAIError(c, http.StatusBadRequest, "strict_history", violation.Error())
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{
+		"type": "error",
+		"error": gin.H{
+			"type":    "invalid_request_error",
+			"message": violation.Error(),
+		},
+	})
+}
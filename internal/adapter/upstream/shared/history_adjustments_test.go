@@ -0,0 +1,139 @@
+package shared
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"kiro2api/converter"
+	srvcontext "kiro2api/internal/adapter/httpapi/context"
+	"kiro2api/internal/adapter/httpapi/support"
+	"kiro2api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// orphanUserRequest 历史末尾有两条连续的孤立 user 消息
+func orphanUserRequest() types.AnthropicRequest {
+	return types.AnthropicRequest{
+		Model:     "claude-sonnet-4",
+		MaxTokens: 100,
+		Messages: []types.AnthropicRequestMessage{
+			{Role: "user", Content: "第一个问题"},
+			{Role: "assistant", Content: "第一个回答"},
+			{Role: "user", Content: "第二个问题（孤立1）"},
+			{Role: "user", Content: "第三个问题（孤立2）"},
+			{Role: "user", Content: "第四个问题（当前）"},
+		},
+	}
+}
+
+func TestExecute_HistoryAdjustmentsHeader(t *testing.T) {
+	t.Setenv("STRICT_HISTORY", "")
+	calls := 0
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		calls++
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(""))}, nil
+	})}
+	rp := NewReverseProxy(client)
+	rp.stealthEnabled = false
+
+	for _, stream := range []bool{false, true} {
+		c := newRetryTestContext()
+		resp, err := rp.Execute(c, orphanUserRequest(), types.TokenInfo{AccessToken: "token"}, stream)
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		want := `[{"kind":"merged_users","index":2,"end":3},{"kind":"auto_paired_user","index":3}]`
+		assert.JSONEq(t, want, c.Writer.Header().Get(HistoryAdjustmentsHeader), "stream=%v", stream)
+		assert.JSONEq(t, want, srvcontext.GetHistoryAdjustments(c), "stream=%v", stream)
+	}
+	assert.Equal(t, 2, calls)
+
+	// 不需要调整的历史不设置响应头
+	c := newRetryTestContext()
+	resp, err := rp.Execute(c, newRetryTestRequest(), types.TokenInfo{AccessToken: "token"}, false)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Empty(t, c.Writer.Header().Get(HistoryAdjustmentsHeader))
+}
+
+// TestExecute_HistoryAdjustmentsUseClientIndexes 历史修复或上下文裁剪去掉了前面的消息时，报告的仍是客户端请求中的下标
+func TestExecute_HistoryAdjustmentsUseClientIndexes(t *testing.T) {
+	t.Setenv("STRICT_HISTORY", "")
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(""))}, nil
+	})}
+	rp := NewReverseProxy(client)
+	rp.stealthEnabled = false
+
+	req := orphanUserRequest()
+	req.Messages = append([]types.AnthropicRequestMessage{
+		{Role: "user", Content: "最早的问题"},
+		{Role: "assistant", Content: "最早的回答"},
+	}, req.Messages...)
+	req.MarkClientIndexes()
+	// 模拟上下文保护丢弃最旧的一轮
+	req.Messages = req.Messages[2:]
+
+	c := newRetryTestContext()
+	resp, err := rp.Execute(c, req, types.TokenInfo{AccessToken: "token"}, false)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.JSONEq(t, `[{"kind":"merged_users","index":4,"end":5},{"kind":"auto_paired_user","index":5}]`,
+		c.Writer.Header().Get(HistoryAdjustmentsHeader))
+}
+
+func TestExecute_StrictHistoryRejects(t *testing.T) {
+	t.Setenv("STRICT_HISTORY", "true")
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		t.Fatal("STRICT_HISTORY 拒绝的请求不应发往上游")
+		return nil, nil
+	})}
+	rp := NewReverseProxy(client)
+	rp.stealthEnabled = false
+
+	reject := func(t *testing.T, path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request = httptest.NewRequest(http.MethodPost, path, nil)
+
+		_, err := rp.Execute(c, orphanUserRequest(), types.TokenInfo{AccessToken: "token"}, true)
+		var violation *converter.HistoryViolationError
+		require.ErrorAs(t, err, &violation)
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+		assert.Empty(t, recorder.Header().Get(HistoryAdjustmentsHeader))
+		return recorder
+	}
+
+	t.Run("Anthropic错误格式", func(t *testing.T) {
+		var body struct {
+			Type  string `json:"type"`
+			Error struct {
+				Type    string `json:"type"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		require.NoError(t, json.Unmarshal(reject(t, "/v1/messages").Body.Bytes(), &body))
+		assert.Equal(t, "error", body.Type)
+		assert.Equal(t, "invalid_request_error", body.Error.Type)
+		assert.Contains(t, body.Error.Message, "messages.2-3: 连续的 user 消息")
+	})
+
+	t.Run("OpenAI错误格式", func(t *testing.T) {
+		var body support.OpenAIErrorResponse
+		require.NoError(t, json.Unmarshal(reject(t, support.OpenAIChatCompletionsPath).Body.Bytes(), &body))
+		assert.Equal(t, "invalid_request_error", body.Error.Type)
+		require.NotNil(t, body.Error.Code)
+		assert.Equal(t, "strict_history", *body.Error.Code)
+		require.NotNil(t, body.Error.Param)
+		assert.Equal(t, "messages", *body.Error.Param)
+		assert.Contains(t, body.Error.Message, "messages.2-3: 连续的 user 消息")
+	})
+}
@@ -0,0 +1,300 @@
+package shared
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"kiro2api/config"
+	"kiro2api/logger"
+	"kiro2api/parser"
+	"kiro2api/types"
+	"kiro2api/utils"
+)
+
+// MockTokenSource 模拟模式下的token来源，始终返回占位token，不访问认证服务
+type MockTokenSource struct{}
+
+func (MockTokenSource) GetToken() (types.TokenInfo, error) {
+	return mockToken(), nil
+}
+
+func (MockTokenSource) GetTokenWithUsage() (*types.TokenWithUsage, error) {
+	return &types.TokenWithUsage{
+		TokenInfo:      mockToken(),
+		AvailableCount: 1000,
+		LastUsageCheck: time.Now(),
+		TokenPreview:   "mock",
+	}, nil
+}
+
+func (MockTokenSource) MarkTokenRetryAfter(types.TokenInfo, time.Duration) {}
+
+func mockToken() types.TokenInfo {
+	return types.TokenInfo{
+		AccessToken: config.MockAccessToken,
+		ExpiresAt:   time.Now().Add(time.Hour),
+	}
+}
+
+// MockUpstreamTransport 进程内模拟的 CodeWhisperer 上游
+// 按请求内容生成确定的 EventStream 响应：文本来自 MOCK_RESPONSE_TEMPLATE，按 MOCK_TOOL_USE 追加工具调用，
+// 按 MOCK_ERROR_RATE 注入上游错误，按 MOCK_FIRST_TOKEN_DELAY_MS 延迟首个事件
+type MockUpstreamTransport struct{}
+
+// NewMockUpstreamTransport 创建模拟上游
+func NewMockUpstreamTransport() *MockUpstreamTransport {
+	return &MockUpstreamTransport{}
+}
+
+// RoundTrip 实现 http.RoundTripper
+func (t *MockUpstreamTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var cwReq types.CodeWhispererRequest
+	if err := utils.SafeUnmarshal(body, &cwReq); err != nil {
+		return mockErrorResponse(req, http.StatusBadRequest, "ValidationException", "无法解析请求体: "+err.Error()), nil
+	}
+
+	if rate := config.MockErrorRate(); rate > 0 && float64(utils.RandomIntBetween(0, 9999)) < rate*10000 {
+		logger.Info("模拟上游注入错误", logger.Float64("error_rate", rate))
+		return mockErrorResponse(req, http.StatusInternalServerError, "InternalServerException", "模拟上游注入的错误"), nil
+	}
+
+	frames := buildMockFrames(cwReq)
+	pr, pw := io.Pipe()
+	go streamMockFrames(req.Context(), pw, frames, config.MockFirstTokenDelay())
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Status:     "200 OK",
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{"Content-Type": []string{"application/vnd.amazon.eventstream"}},
+		Body:       pr,
+		Request:    req,
+	}, nil
+}
+
+func mockErrorResponse(req *http.Request, status int, errType, message string) *http.Response {
+	body, _ := utils.SafeMarshal(map[string]string{"__type": errType, "message": message})
+	return &http.Response{
+		StatusCode: status,
+		Status:     http.StatusText(status),
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader(body)),
+		Request:    req,
+	}
+}
+
+// streamMockFrames 依次写出事件帧，首帧前等待 delay；客户端断开时提前结束
+func streamMockFrames(ctx context.Context, pw *io.PipeWriter, frames [][]byte, delay time.Duration) {
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			pw.CloseWithError(ctx.Err())
+			return
+		}
+	}
+	for _, frame := range frames {
+		if _, err := pw.Write(frame); err != nil {
+			return
+		}
+	}
+	pw.Close()
+}
+
+// mockTemplateData MOCK_RESPONSE_TEMPLATE 可用的字段
+type mockTemplateData struct {
+	Model     string
+	Prompt    string
+	ToolCount int
+}
+
+// buildMockFrames 根据请求生成模拟响应的全部事件帧
+// 当前消息携带工具结果时只返回文本，避免客户端工具循环无法结束
+func buildMockFrames(cwReq types.CodeWhispererRequest) [][]byte {
+	current := cwReq.ConversationState.CurrentMessage.UserInputMessage
+	tools := current.UserInputMessageContext.Tools
+
+	var frames [][]byte
+	for _, chunk := range splitRunes(renderMockText(current.ModelId, current.Content, len(tools)), config.MockTextChunkRunes) {
+		frames = append(frames, mockFrame(parser.EventTypes.ASSISTANT_RESPONSE_EVENT, map[string]any{"content": chunk}))
+	}
+
+	if len(current.UserInputMessageContext.ToolResults) > 0 {
+		return frames
+	}
+	tool, ok := selectMockTool(tools, config.MockToolUse())
+	if !ok {
+		return frames
+	}
+
+	input := mockToolInput(tool)
+	toolUseID := mockToolUseID(cwReq.ConversationState.ConversationId, tool.Name)
+	toolEvent := func(input any, stop bool) []byte {
+		return mockFrame(parser.EventTypes.TOOL_USE_EVENT, map[string]any{
+			"name":      tool.Name,
+			"toolUseId": toolUseID,
+			"input":     input,
+			"stop":      stop,
+		})
+	}
+
+	// 与真实上游一致：先注册工具，再分片输出参数JSON，最后单独发送不带数据的stop
+	frames = append(frames, toolEvent(map[string]any{}, false))
+	for start := 0; start < len(input); start += config.MockToolInputChunkBytes {
+		end := min(start+config.MockToolInputChunkBytes, len(input))
+		frames = append(frames, toolEvent(input[start:end], false))
+	}
+	frames = append(frames, toolEvent("", true))
+	return frames
+}
+
+// renderMockText 渲染文本响应模板，模板无效时使用默认模板
+func renderMockText(model, prompt string, toolCount int) string {
+	if runes := []rune(prompt); len(runes) > config.MockPromptPreviewRunes {
+		prompt = string(runes[:config.MockPromptPreviewRunes]) + "..."
+	}
+	data := mockTemplateData{Model: model, Prompt: prompt, ToolCount: toolCount}
+
+	tmpl, err := template.New("mock").Parse(config.MockResponseTemplate())
+	if err != nil {
+		logger.Warn("MOCK_RESPONSE_TEMPLATE无效，使用默认模板", logger.Err(err))
+		tmpl = template.Must(template.New("mock").Parse(config.DefaultMockResponseTemplate))
+	}
+
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		logger.Warn("渲染模拟响应失败", logger.Err(err))
+	}
+	return sb.String()
+}
+
+// selectMockTool 按 MOCK_TOOL_USE 选择要调用的工具
+func selectMockTool(tools []types.CodeWhispererTool, mode string) (types.ToolSpecification, bool) {
+	if len(tools) == 0 || mode == config.MockToolUseOff {
+		return types.ToolSpecification{}, false
+	}
+	if mode == config.MockToolUseFirst {
+		return tools[0].ToolSpecification, true
+	}
+	for _, tool := range tools {
+		if tool.ToolSpecification.Name == mode {
+			return tool.ToolSpecification, true
+		}
+	}
+	return types.ToolSpecification{}, false
+}
+
+// mockToolInput 返回工具参数JSON：优先使用 MOCK_TOOL_INPUT，否则按 input_schema 的必填字段生成占位值
+func mockToolInput(tool types.ToolSpecification) string {
+	if input := config.MockToolInput(); input != "" {
+		var parsed map[string]any
+		if err := utils.SafeUnmarshal([]byte(input), &parsed); err == nil {
+			return input
+		}
+		logger.Warn("MOCK_TOOL_INPUT不是合法的JSON对象，按input_schema生成参数")
+	}
+
+	schema := tool.InputSchema.Json
+	properties, _ := schema["properties"].(map[string]any)
+	args := make(map[string]any)
+	required, _ := schema["required"].([]any)
+	for _, name := range required {
+		key, ok := name.(string)
+		if !ok {
+			continue
+		}
+		prop, _ := properties[key].(map[string]any)
+		args[key] = mockValue(prop)
+	}
+
+	encoded, err := utils.SafeMarshal(args)
+	if err != nil {
+		return "{}"
+	}
+	return string(encoded)
+}
+
+// mockValue 按JSON Schema类型生成占位值
+func mockValue(prop map[string]any) any {
+	if values, ok := prop["enum"].([]any); ok && len(values) > 0 {
+		return values[0]
+	}
+	switch prop["type"] {
+	case "integer", "number":
+		return 1
+	case "boolean":
+		return true
+	case "array":
+		return []any{}
+	case "object":
+		return map[string]any{}
+	default:
+		return "mock"
+	}
+}
+
+// mockToolUseID 由会话ID和工具名派生，保证同一请求的响应确定
+func mockToolUseID(conversationID, toolName string) string {
+	sum := sha256.Sum256([]byte(conversationID + "\x00" + toolName))
+	return "tooluse_" + hex.EncodeToString(sum[:11])
+}
+
+func splitRunes(s string, size int) []string {
+	runes := []rune(s)
+	var chunks []string
+	for start := 0; start < len(runes); start += size {
+		chunks = append(chunks, string(runes[start:min(start+size, len(runes))]))
+	}
+	return chunks
+}
+
+// mockFrame 编码一个 AWS EventStream 事件帧（含 prelude 与消息 CRC）
+func mockFrame(eventType string, payload any) []byte {
+	body, _ := utils.SafeMarshal(payload)
+
+	var headers []byte
+	for _, h := range [][2]string{
+		{":message-type", parser.MessageTypes.EVENT},
+		{":event-type", eventType},
+		{":content-type", "application/json"},
+	} {
+		headers = append(headers, byte(len(h[0])))
+		headers = append(headers, h[0]...)
+		headers = append(headers, 7) // string 类型
+		headers = binary.BigEndian.AppendUint16(headers, uint16(len(h[1])))
+		headers = append(headers, h[1]...)
+	}
+
+	totalLength := 12 + len(headers) + len(body) + 4
+	frame := make([]byte, 12, totalLength)
+	binary.BigEndian.PutUint32(frame[0:4], uint32(totalLength))
+	binary.BigEndian.PutUint32(frame[4:8], uint32(len(headers)))
+	binary.BigEndian.PutUint32(frame[8:12], crc32.ChecksumIEEE(frame[0:8]))
+	frame = append(frame, headers...)
+	frame = append(frame, body...)
+	return binary.BigEndian.AppendUint32(frame, crc32.ChecksumIEEE(frame))
+}
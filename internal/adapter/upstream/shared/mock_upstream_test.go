@@ -0,0 +1,160 @@
+package shared
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"kiro2api/parser"
+	"kiro2api/types"
+	"kiro2api/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mockCodeWhispererRequest(t *testing.T, content string, tools []types.CodeWhispererTool, withToolResult bool) *http.Request {
+	t.Helper()
+	var cwReq types.CodeWhispererRequest
+	cwReq.ConversationState.ConversationId = "conv-mock-test"
+	cwReq.ConversationState.CurrentMessage.UserInputMessage.Content = content
+	cwReq.ConversationState.CurrentMessage.UserInputMessage.ModelId = "CLAUDE_SONNET_4_20250514_V1_0"
+	cwReq.ConversationState.CurrentMessage.UserInputMessage.UserInputMessageContext.Tools = tools
+	if withToolResult {
+		cwReq.ConversationState.CurrentMessage.UserInputMessage.UserInputMessageContext.ToolResults = []types.ToolResult{{ToolUseId: "tooluse_1"}}
+	}
+
+	body, err := utils.SafeMarshal(cwReq)
+	require.NoError(t, err)
+	req, err := http.NewRequest(http.MethodPost, "https://codewhisperer.example/generateAssistantResponse", bytes.NewReader(body))
+	require.NoError(t, err)
+	return req
+}
+
+func weatherTool() types.CodeWhispererTool {
+	var tool types.CodeWhispererTool
+	tool.ToolSpecification.Name = "get_weather"
+	tool.ToolSpecification.InputSchema.Json = map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"city": map[string]any{"type": "string"},
+			"days": map[string]any{"type": "integer"},
+			"unit": map[string]any{"type": "string", "enum": []any{"celsius", "fahrenheit"}},
+		},
+		"required": []any{"city", "days", "unit"},
+	}
+	return tool
+}
+
+func roundTripMock(t *testing.T, req *http.Request) (*http.Response, *parser.ParseResult) {
+	t.Helper()
+	resp, err := NewMockUpstreamTransport().RoundTrip(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	if resp.StatusCode != http.StatusOK {
+		return resp, nil
+	}
+
+	result, err := parser.NewCompliantEventStreamParser().ParseResponse(body)
+	require.NoError(t, err)
+	return resp, result
+}
+
+func TestMockUpstream_TextFromTemplate(t *testing.T) {
+	t.Setenv("MOCK_RESPONSE_TEMPLATE", "模型 {{.Model}} 收到：{{.Prompt}}（工具 {{.ToolCount}} 个）")
+
+	resp, result := roundTripMock(t, mockCodeWhispererRequest(t, "你好，世界", nil, false))
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "模型 CLAUDE_SONNET_4_20250514_V1_0 收到：你好，世界（工具 0 个）", result.GetCompletionText())
+	assert.Empty(t, result.GetToolCalls())
+}
+
+func TestMockUpstream_InvalidTemplateFallsBackToDefault(t *testing.T) {
+	t.Setenv("MOCK_RESPONSE_TEMPLATE", "{{.Missing")
+
+	_, result := roundTripMock(t, mockCodeWhispererRequest(t, "hi", nil, false))
+
+	assert.Contains(t, result.GetCompletionText(), "你说：hi")
+}
+
+func TestMockUpstream_ToolUse(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     string
+		input    string
+		wantTool bool
+		wantArgs map[string]any
+	}{
+		{name: "默认只返回文本", mode: "", wantTool: false},
+		{name: "first按schema生成参数", mode: "first", wantTool: true,
+			wantArgs: map[string]any{"city": "mock", "days": float64(1), "unit": "celsius"}},
+		{name: "按工具名调用并使用指定参数", mode: "get_weather", input: `{"city":"北京","days":3,"unit":"celsius"}`, wantTool: true,
+			wantArgs: map[string]any{"city": "北京", "days": float64(3), "unit": "celsius"}},
+		{name: "工具名不存在", mode: "search", wantTool: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("MOCK_TOOL_USE", tt.mode)
+			t.Setenv("MOCK_TOOL_INPUT", tt.input)
+
+			_, result := roundTripMock(t, mockCodeWhispererRequest(t, "北京天气", []types.CodeWhispererTool{weatherTool()}, false))
+
+			assert.NotEmpty(t, result.GetCompletionText())
+			tools := result.GetToolCalls()
+			if !tt.wantTool {
+				assert.Empty(t, tools)
+				return
+			}
+			require.Len(t, tools, 1)
+			assert.Equal(t, "get_weather", tools[0].Name)
+			assert.Equal(t, mockToolUseID("conv-mock-test", "get_weather"), tools[0].ID)
+			assert.Equal(t, tt.wantArgs, tools[0].Arguments)
+		})
+	}
+}
+
+func TestMockUpstream_NoToolUseAfterToolResult(t *testing.T) {
+	t.Setenv("MOCK_TOOL_USE", "first")
+
+	_, result := roundTripMock(t, mockCodeWhispererRequest(t, "", []types.CodeWhispererTool{weatherTool()}, true))
+
+	assert.Empty(t, result.GetToolCalls())
+}
+
+func TestMockUpstream_ErrorInjection(t *testing.T) {
+	t.Setenv("MOCK_ERROR_RATE", "1")
+
+	resp, _ := roundTripMock(t, mockCodeWhispererRequest(t, "hi", nil, false))
+
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+}
+
+func TestMockUpstream_FirstTokenDelay(t *testing.T) {
+	t.Setenv("MOCK_FIRST_TOKEN_DELAY_MS", "50")
+
+	start := time.Now()
+	resp, err := NewMockUpstreamTransport().RoundTrip(mockCodeWhispererRequest(t, "hi", nil, false))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	// 响应头立即返回，首个事件延迟输出
+	assert.Less(t, time.Since(start), 50*time.Millisecond)
+
+	buf := make([]byte, 1)
+	_, err = resp.Body.Read(buf)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+}
+
+func TestMockTokenSource(t *testing.T) {
+	token, err := MockTokenSource{}.GetTokenWithUsage()
+	require.NoError(t, err)
+	assert.NotEmpty(t, token.AccessToken)
+	assert.Greater(t, token.AvailableCount, 0.0)
+}
@@ -0,0 +1,265 @@
+package shared
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"kiro2api/config"
+	"kiro2api/logger"
+	"kiro2api/types"
+	"kiro2api/utils"
+)
+
+// ModelCapability 单个模型最近的能力探测结果
+type ModelCapability struct {
+	Model               string     `json:"model"`
+	ModelID             string     `json:"model_id"`
+	Available           bool       `json:"available"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastStatus          int        `json:"last_status,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastProbeAt         *time.Time `json:"last_probe_at,omitempty"`
+	LastSuccessAt       *time.Time `json:"last_success_at,omitempty"`
+}
+
+// CapabilityTokenSource 探测请求使用的token来源
+type CapabilityTokenSource interface {
+	GetToken() (types.TokenInfo, error)
+}
+
+// ModelCapabilityProbe 向上游逐个发送最小请求，探测 ModelMap 中的模型是否可用
+// 连续 threshold 次被上游拒绝的模型标记为不可用并停止路由，探测成功后恢复；
+// 网络错误、token失效、限流和5xx与模型无关，只记录错误不计入连续失败次数
+type ModelCapabilityProbe struct {
+	mutex     sync.Mutex
+	results   map[string]*ModelCapability
+	client    *http.Client
+	headers   *HeaderManager
+	endpoints *EndpointPool
+	tokens    CapabilityTokenSource
+	threshold int
+	now       func() time.Time
+}
+
+var (
+	globalCapabilityProbe *ModelCapabilityProbe
+	capabilityProbeOnce   sync.Once
+)
+
+// GetModelCapabilityProbe 获取全局模型能力探测器
+func GetModelCapabilityProbe() *ModelCapabilityProbe {
+	capabilityProbeOnce.Do(func() {
+		globalCapabilityProbe = NewModelCapabilityProbe(nil, config.CapabilityProbeFailureThreshold)
+	})
+	return globalCapabilityProbe
+}
+
+// NewModelCapabilityProbe 创建模型能力探测器，client 为nil时使用共享客户端；启用 MOCK_UPSTREAM 时改用进程内模拟上游
+func NewModelCapabilityProbe(client *http.Client, threshold int) *ModelCapabilityProbe {
+	if client == nil {
+		client = utils.SharedHTTPClient
+		if config.IsMockUpstreamEnabled() {
+			client = &http.Client{Transport: NewMockUpstreamTransport()}
+		}
+	}
+	return &ModelCapabilityProbe{
+		results:   make(map[string]*ModelCapability),
+		client:    client,
+		headers:   NewHeaderManager(),
+		endpoints: GetEndpointPool(),
+		threshold: threshold,
+		now:       time.Now,
+	}
+}
+
+// SetTokenSource 设置探测请求使用的token来源，未设置时不探测
+func (p *ModelCapabilityProbe) SetTokenSource(tokens CapabilityTokenSource) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.tokens = tokens
+}
+
+// SetEndpointPool 替换上游端点池（测试中用于注入本地模拟端点）
+func (p *ModelCapabilityProbe) SetEndpointPool(endpoints *EndpointPool) {
+	p.endpoints = endpoints
+}
+
+// Run 立即探测一次，之后每隔 interval 重复，直到 ctx 结束
+func (p *ModelCapabilityProbe) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		p.ProbeAll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProbeAll 按模型名顺序探测 ModelMap 中的全部模型，并同步路由可用性
+func (p *ModelCapabilityProbe) ProbeAll(ctx context.Context) {
+	p.mutex.Lock()
+	tokens := p.tokens
+	p.mutex.Unlock()
+	if tokens == nil {
+		return
+	}
+
+	models := make([]string, 0, len(config.ModelMap))
+	for model := range config.ModelMap {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+
+	for _, model := range models {
+		if ctx.Err() != nil {
+			return
+		}
+		modelID := config.ModelMap[model]
+
+		token, err := tokens.GetToken()
+		if err != nil {
+			p.record(model, modelID, 0, fmt.Errorf("获取token失败: %w", err), false)
+			continue
+		}
+		status, err := p.probe(ctx, modelID, token)
+		p.record(model, modelID, status, err, err != nil && isModelRejection(status))
+	}
+}
+
+// Snapshot 返回各模型的探测结果（按模型名排序）
+func (p *ModelCapabilityProbe) Snapshot() []ModelCapability {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	result := make([]ModelCapability, 0, len(p.results))
+	for _, capability := range p.results {
+		result = append(result, *capability)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Model < result[j].Model })
+	return result
+}
+
+// record 更新单个模型的探测结果；rejected 表示上游明确拒绝了该模型
+func (p *ModelCapabilityProbe) record(model, modelID string, status int, err error, rejected bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	capability, ok := p.results[model]
+	if !ok {
+		capability = &ModelCapability{Model: model, Available: true}
+		p.results[model] = capability
+	}
+
+	now := p.now()
+	capability.ModelID = modelID
+	capability.LastStatus = status
+	capability.LastProbeAt = &now
+
+	wasAvailable := capability.Available
+	switch {
+	case err == nil:
+		capability.LastError = ""
+		capability.LastSuccessAt = &now
+		capability.ConsecutiveFailures = 0
+		capability.Available = true
+	case rejected:
+		capability.LastError = err.Error()
+		capability.ConsecutiveFailures++
+		if capability.ConsecutiveFailures >= p.threshold {
+			capability.Available = false
+		}
+	default:
+		capability.LastError = err.Error()
+	}
+
+	if capability.Available != wasAvailable {
+		config.SetModelAvailable(model, capability.Available)
+		if capability.Available {
+			logger.Info("模型能力探测成功，恢复路由", logger.String("model", model))
+		} else {
+			logger.Warn("模型能力探测连续失败，停止路由",
+				logger.String("model", model),
+				logger.Int("consecutive_failures", capability.ConsecutiveFailures),
+				logger.String("last_error", capability.LastError))
+		}
+	}
+}
+
+// Probe 向上游发送一次模型探测请求，不记录结果也不影响路由；model 为 ModelMap 中的模型名
+func (p *ModelCapabilityProbe) Probe(ctx context.Context, model string, token types.TokenInfo) error {
+	modelID, ok := config.ModelMap[model]
+	if !ok {
+		return fmt.Errorf("模型 %s 不在模型映射中", model)
+	}
+	_, err := p.probe(ctx, modelID, token)
+	return err
+}
+
+// probe 发送只包含一条短消息的非流式请求，2xx 视为模型可用
+func (p *ModelCapabilityProbe) probe(ctx context.Context, modelID string, token types.TokenInfo) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, config.CapabilityProbeTimeout)
+	defer cancel()
+
+	var cwReq types.CodeWhispererRequest
+	cs := &cwReq.ConversationState
+	cs.AgentTaskType = "vibe"
+	cs.ChatTriggerType = "MANUAL"
+	cs.ConversationId = utils.GenerateUUID()
+	cs.History = []any{}
+	userInput := &cs.CurrentMessage.UserInputMessage
+	userInput.Content = config.CapabilityProbePrompt
+	userInput.ModelId = modelID
+	userInput.Images = []types.CodeWhispererImage{}
+	userInput.Origin = "AI_EDITOR"
+
+	body, err := utils.FastMarshal(cwReq)
+	if err != nil {
+		return 0, fmt.Errorf("序列化探测请求失败: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoints.Select()+config.CodeWhispererPath, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("创建探测请求失败: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	tokenIdentifier := token.RefreshToken
+	if tokenIdentifier == "" {
+		tokenIdentifier = token.AccessToken
+	}
+	p.headers.Apply(req, false, tokenIdentifier, cs.ConversationId, HeaderOptions{})
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("探测请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return resp.StatusCode, fmt.Errorf("上游返回 %d: %s", resp.StatusCode, string(respBody))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
+
+// isModelRejection 上游响应是否说明模型本身不可用（排除token失效、限流和服务端错误）
+func isModelRejection(status int) bool {
+	switch {
+	case status == 0:
+		return false
+	case status == http.StatusUnauthorized, status == http.StatusForbidden, status == http.StatusTooManyRequests:
+		return false
+	default:
+		return status < http.StatusInternalServerError
+	}
+}
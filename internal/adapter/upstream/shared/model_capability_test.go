@@ -0,0 +1,164 @@
+package shared
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"kiro2api/config"
+	"kiro2api/types"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rejectingUpstream 模拟上游：rejected 中的 modelId 返回 status，其余返回200
+type rejectingUpstream struct {
+	mu       sync.Mutex
+	rejected map[string]int
+}
+
+func (u *rejectingUpstream) set(modelID string, status int) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if status == 0 {
+		delete(u.rejected, modelID)
+		return
+	}
+	u.rejected[modelID] = status
+}
+
+func newRejectingUpstream(t *testing.T) (*rejectingUpstream, string) {
+	t.Helper()
+	upstream := &rejectingUpstream{rejected: map[string]int{}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, config.CodeWhispererPath, r.URL.Path)
+		assert.Equal(t, "Bearer probe-token", r.Header.Get("Authorization"))
+
+		var cwReq types.CodeWhispererRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&cwReq))
+		modelID := cwReq.ConversationState.CurrentMessage.UserInputMessage.ModelId
+
+		upstream.mu.Lock()
+		status := upstream.rejected[modelID]
+		upstream.mu.Unlock()
+		if status != 0 {
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(`{"message":"Invalid model. Please select a different model to continue.","reason":"INVALID_MODEL_ID"}`))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+	return upstream, server.URL
+}
+
+type staticTokenSource struct{}
+
+func (staticTokenSource) GetToken() (types.TokenInfo, error) {
+	return types.TokenInfo{AccessToken: "probe-token"}, nil
+}
+
+func newTestCapabilityProbe(t *testing.T, endpoint string) *ModelCapabilityProbe {
+	t.Helper()
+	probe := NewModelCapabilityProbe(http.DefaultClient, 2)
+	probe.SetEndpointPool(NewEndpointPool([]string{endpoint}, time.Minute, 50, time.Minute))
+	probe.SetTokenSource(staticTokenSource{})
+	t.Cleanup(func() {
+		for model := range config.ModelMap {
+			config.SetModelAvailable(model, true)
+		}
+	})
+	return probe
+}
+
+func findCapability(t *testing.T, probe *ModelCapabilityProbe, model string) ModelCapability {
+	t.Helper()
+	for _, capability := range probe.Snapshot() {
+		if capability.Model == model {
+			return capability
+		}
+	}
+	t.Fatalf("没有 %s 的探测结果", model)
+	return ModelCapability{}
+}
+
+func TestModelCapabilityProbe_MarksConsistentlyRejectedModelUnavailable(t *testing.T) {
+	upstream, endpoint := newRejectingUpstream(t)
+	upstream.set(config.ModelMap["claude-opus-4.5"], http.StatusBadRequest)
+	probe := newTestCapabilityProbe(t, endpoint)
+
+	// 第一次失败未达到阈值，仍可路由
+	probe.ProbeAll(context.Background())
+	opus := findCapability(t, probe, "claude-opus-4.5")
+	assert.True(t, opus.Available)
+	assert.Equal(t, 1, opus.ConsecutiveFailures)
+	assert.Equal(t, http.StatusBadRequest, opus.LastStatus)
+	assert.Contains(t, opus.LastError, "INVALID_MODEL_ID")
+	assert.True(t, config.IsModelAvailable("claude-opus-4.5"))
+
+	probe.ProbeAll(context.Background())
+	opus = findCapability(t, probe, "claude-opus-4.5")
+	assert.False(t, opus.Available)
+	assert.Equal(t, 2, opus.ConsecutiveFailures)
+	_, err := config.ResolveModelID("claude-opus-4.5")
+	assert.ErrorIs(t, err, config.ErrModelUnavailable)
+
+	sonnet := findCapability(t, probe, "claude-sonnet-4.5")
+	assert.True(t, sonnet.Available)
+	assert.NotNil(t, sonnet.LastSuccessAt)
+	assert.Len(t, probe.Snapshot(), len(config.ModelMap))
+
+	// 上游恢复后一次成功即恢复路由
+	upstream.set(config.ModelMap["claude-opus-4.5"], 0)
+	probe.ProbeAll(context.Background())
+	opus = findCapability(t, probe, "claude-opus-4.5")
+	assert.True(t, opus.Available)
+	assert.Zero(t, opus.ConsecutiveFailures)
+	assert.Empty(t, opus.LastError)
+	assert.True(t, config.IsModelAvailable("claude-opus-4.5"))
+}
+
+func TestModelCapabilityProbe_IgnoresNonModelFailures(t *testing.T) {
+	upstream, endpoint := newRejectingUpstream(t)
+	upstream.set(config.ModelMap["claude-haiku-4.5"], http.StatusTooManyRequests)
+	upstream.set(config.ModelMap["claude-sonnet-4"], http.StatusInternalServerError)
+	probe := newTestCapabilityProbe(t, endpoint)
+
+	for i := 0; i < 3; i++ {
+		probe.ProbeAll(context.Background())
+	}
+
+	for _, model := range []string{"claude-haiku-4.5", "claude-sonnet-4"} {
+		capability := findCapability(t, probe, model)
+		assert.True(t, capability.Available, model)
+		assert.Zero(t, capability.ConsecutiveFailures, model)
+		assert.NotEmpty(t, capability.LastError, model)
+	}
+}
+
+func TestModelCapabilityProbe_SkipsWithoutTokenSource(t *testing.T) {
+	probe := NewModelCapabilityProbe(http.DefaultClient, 2)
+	probe.ProbeAll(context.Background())
+	assert.Empty(t, probe.Snapshot())
+}
+
+func TestModelCapabilityProbe_ProbeDoesNotRecord(t *testing.T) {
+	upstream, endpoint := newRejectingUpstream(t)
+	upstream.set(config.ModelMap["claude-opus-4.5"], http.StatusBadRequest)
+	probe := newTestCapabilityProbe(t, endpoint)
+	token := types.TokenInfo{AccessToken: "probe-token"}
+
+	assert.NoError(t, probe.Probe(context.Background(), "claude-sonnet-4.5", token))
+	err := probe.Probe(context.Background(), "claude-opus-4.5", token)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "INVALID_MODEL_ID")
+	assert.Error(t, probe.Probe(context.Background(), "no-such-model", token))
+
+	assert.Empty(t, probe.Snapshot())
+	assert.True(t, config.IsModelAvailable("claude-opus-4.5"))
+}
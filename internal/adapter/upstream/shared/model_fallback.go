@@ -0,0 +1,121 @@
+package shared
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+
+	"kiro2api/config"
+	srvcontext "kiro2api/internal/adapter/httpapi/context"
+	logutil "kiro2api/internal/adapter/httpapi/logging"
+	"kiro2api/internal/stats"
+	"kiro2api/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ModelFallbackHeader 请求改由回退模型处理时返回的响应头，格式为 "<请求的模型> -> <实际模型>; reason=<原因>"
+const ModelFallbackHeader = "X-Kiro-Model-Fallback"
+
+// 模型回退的原因
+const (
+	FallbackReasonErrorRate  = "error_rate"  // 主模型滚动错误率超过 MODEL_FALLBACK_ERROR_RATE
+	FallbackReasonModelError = "model_error" // 模型不存在、被能力探测停用或上游报告模型容量不足
+)
+
+// modelErrorReasons 上游错误响应中说明模型本身不可用的 reason
+var modelErrorReasons = map[string]bool{
+	"INSUFFICIENT_MODEL_CAPACITY": true,
+	"INVALID_MODEL_ID":            true,
+}
+
+// activeModelFallbacks 启动时加载的 MODEL_FALLBACKS
+var activeModelFallbacks atomic.Pointer[config.ModelFallbacks]
+
+// SetModelFallbacks 替换全局模型回退配置
+func SetModelFallbacks(fallbacks config.ModelFallbacks) {
+	activeModelFallbacks.Store(&fallbacks)
+}
+
+// modelFallbackChain 返回请求模型及其回退模型（按尝试顺序），未配置回退时只有请求模型
+func modelFallbackChain(model string) []string {
+	chain := []string{model}
+	if fallbacks := activeModelFallbacks.Load(); fallbacks != nil {
+		chain = append(chain, (*fallbacks)[model]...)
+	}
+	return chain
+}
+
+// errorRateExceeded 模型在滚动窗口内的上游错误率是否超过回退阈值（样本不足时不判断）
+func errorRateExceeded(model string) bool {
+	rate, samples := stats.GetModelUpstreamStats().RecentErrorRate(model)
+	return samples >= config.ModelErrorRateMinSamples && rate > config.ModelFallbackErrorRate()
+}
+
+// firstHealthyModel 返回回退链中第一个错误率未超过阈值的位置；全部超过时仍使用请求模型
+func firstHealthyModel(chain []string) int {
+	for i, model := range chain {
+		if !errorRateExceeded(model) {
+			return i
+		}
+	}
+	return 0
+}
+
+// isModelResolveError 模型无法路由（不在 ModelMap 中或被能力探测停用），可以改用回退模型
+func isModelResolveError(model string) bool {
+	_, err := config.ResolveModelID(model)
+	return errors.Is(err, config.ErrModelNotMapped) || errors.Is(err, config.ErrModelUnavailable)
+}
+
+// modelUnavailableError 上游报告模型本身不可用，存在回退模型时不向客户端写入错误响应
+type modelUnavailableError struct {
+	model  string
+	status int
+	reason string
+}
+
+func (e *modelUnavailableError) Error() string {
+	return fmt.Sprintf("上游报告模型 %s 不可用（%d %s）", e.model, e.status, e.reason)
+}
+
+// upstreamModelError 读取上游错误响应体，返回说明模型本身不可用的 reason
+// 已读取的内容放回响应体，不是模型错误时后续错误处理仍能读到完整响应
+func upstreamModelError(resp *http.Response) string {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, config.UpstreamDrainLimit))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+
+	var errorBody CodeWhispererErrorBody
+	if json.Unmarshal(body, &errorBody) != nil || !modelErrorReasons[errorBody.Reason] {
+		return ""
+	}
+	return errorBody.Reason
+}
+
+// reportModelFallback 设置响应头并记录实际处理请求的模型；之后响应中的 model 字段使用回退模型
+func reportModelFallback(c *gin.Context, requested, served, reason string) {
+	c.Header(ModelFallbackHeader, fmt.Sprintf("%s -> %s; reason=%s", requested, served, reason))
+	srvcontext.SetServedModel(c, served)
+	logger.Warn("请求改由回退模型处理",
+		logutil.AddFields(c,
+			logger.String("requested_model", requested),
+			logger.String("served_model", served),
+			logger.String("reason", reason),
+		)...)
+}
+
+// ServedModel 返回实际处理请求的模型：发生模型回退时为回退模型，否则为请求的模型
+// 在 Execute 成功返回后、向客户端写入任何内容之前调用，流式响应不会中途更换模型
+func ServedModel(c *gin.Context, requested string) string {
+	if served := srvcontext.GetServedModel(c); served != "" {
+		return served
+	}
+	return requested
+}
@@ -0,0 +1,139 @@
+package shared
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"kiro2api/config"
+	"kiro2api/internal/stats"
+	"kiro2api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// modelErrorUpstream 模拟只对 failing 模型返回模型容量不足的上游，记录每次请求的 modelId
+func modelErrorUpstream(failing string, requested *[]string) *http.Client {
+	return &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		body, _ := io.ReadAll(req.Body)
+		modelID := ""
+		for _, id := range config.ModelMap {
+			if strings.Contains(string(body), `"modelId":"`+id+`"`) {
+				modelID = id
+			}
+		}
+		*requested = append(*requested, modelID)
+
+		if modelID == failing {
+			return &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{},
+				Body: io.NopCloser(strings.NewReader(`{"message":"model is at capacity","reason":"INSUFFICIENT_MODEL_CAPACITY"}`))}, nil
+		}
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(""))}, nil
+	})}
+}
+
+func newFallbackTestProxy(client *http.Client) *ReverseProxy {
+	rp := NewReverseProxy(client)
+	rp.stealthEnabled = false
+	rp.SetCircuitBreaker(nil)
+	rp.SetEndpointPool(NewEndpointPool(nil, config.DefaultUpstreamHealthWindow, 50, config.DefaultUpstreamProbeInterval))
+	return rp
+}
+
+func setTestModelFallbacks(t *testing.T, fallbacks config.ModelFallbacks) {
+	SetModelFallbacks(fallbacks)
+	t.Cleanup(func() { SetModelFallbacks(nil) })
+}
+
+func TestExecute_FallsBackOnModelError(t *testing.T) {
+	setTestModelFallbacks(t, config.ModelFallbacks{"claude-opus-4.5": {"claude-sonnet-4.5"}})
+
+	var requested []string
+	rp := newFallbackTestProxy(modelErrorUpstream(config.ModelMap["claude-opus-4.5"], &requested))
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	req := newRetryTestRequest()
+	req.Model = "claude-opus-4.5"
+
+	resp, err := rp.Execute(c, req, types.TokenInfo{AccessToken: "token"}, true)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, []string{config.ModelMap["claude-opus-4.5"], config.ModelMap["claude-sonnet-4.5"]}, requested, "模型容量不足时不在同一模型上重试")
+	assert.Equal(t, "claude-sonnet-4.5", ServedModel(c, req.Model))
+	assert.Equal(t, "claude-opus-4.5 -> claude-sonnet-4.5; reason=model_error", w.Header().Get(ModelFallbackHeader))
+	assert.Zero(t, w.Body.Len(), "回退成功前不向客户端写入错误响应")
+}
+
+func TestExecute_NoFallbackConfiguredReturnsModelError(t *testing.T) {
+	var requested []string
+	rp := newFallbackTestProxy(modelErrorUpstream(config.ModelMap["claude-opus-4.5"], &requested))
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	req := newRetryTestRequest()
+	req.Model = "claude-opus-4.5"
+
+	_, err := rp.Execute(c, req, types.TokenInfo{AccessToken: "token"}, false)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Empty(t, w.Header().Get(ModelFallbackHeader))
+	assert.Equal(t, "claude-opus-4.5", ServedModel(c, req.Model))
+}
+
+func TestExecute_FallsBackWhenErrorRateExceeded(t *testing.T) {
+	const primary = "test-fallback-primary"
+	config.ModelMap[primary] = "TEST_FALLBACK_PRIMARY"
+	t.Cleanup(func() { delete(config.ModelMap, primary) })
+	setTestModelFallbacks(t, config.ModelFallbacks{primary: {"claude-haiku-4.5"}})
+
+	for range config.ModelErrorRateMinSamples {
+		stats.GetModelUpstreamStats().RecordResponse(primary, http.StatusInternalServerError, false, time.Millisecond)
+	}
+
+	var requested []string
+	rp := newFallbackTestProxy(modelErrorUpstream("", &requested))
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	req := newRetryTestRequest()
+	req.Model = primary
+
+	resp, err := rp.Execute(c, req, types.TokenInfo{AccessToken: "token"}, false)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, []string{config.ModelMap["claude-haiku-4.5"]}, requested, "错误率过高的主模型不再发送请求")
+	assert.Equal(t, primary+" -> claude-haiku-4.5; reason=error_rate", w.Header().Get(ModelFallbackHeader))
+}
+
+func TestExecute_FallsBackWhenModelUnavailable(t *testing.T) {
+	setTestModelFallbacks(t, config.ModelFallbacks{"claude-opus-4.5": {"claude-sonnet-4.5"}})
+	config.SetModelAvailable("claude-opus-4.5", false)
+	t.Cleanup(func() { config.SetModelAvailable("claude-opus-4.5", true) })
+
+	var requested []string
+	rp := newFallbackTestProxy(modelErrorUpstream("", &requested))
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	req := newRetryTestRequest()
+	req.Model = "claude-opus-4.5"
+
+	resp, err := rp.Execute(c, req, types.TokenInfo{AccessToken: "token"}, false)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, []string{config.ModelMap["claude-sonnet-4.5"]}, requested)
+	assert.Equal(t, "claude-sonnet-4.5", ServedModel(c, req.Model))
+}
@@ -0,0 +1,78 @@
+package shared
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"kiro2api/config"
+	"kiro2api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func passthroughRequest(model string) types.AnthropicRequest {
+	return types.AnthropicRequest{
+		Model:     model,
+		MaxTokens: 16,
+		Messages:  []types.AnthropicRequestMessage{{Role: "user", Content: "hi"}},
+	}
+}
+
+func newPassthroughContext() (*gin.Context, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	return c, w
+}
+
+func TestBuildRequest_ModelPassthrough(t *testing.T) {
+	t.Setenv("ALLOW_MODEL_PASSTHROUGH", "true")
+	c, w := newPassthroughContext()
+
+	rp := NewReverseProxy(nil)
+	req, err := rp.buildRequest(c, config.DefaultUpstreamEndpoint, passthroughRequest("cw:CLAUDE_SONNET_4_5_20250929_V1_0"), types.TokenInfo{AccessToken: "token"}, false)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, w.Code, "成功构建请求时不应写入响应")
+
+	body, err := io.ReadAll(req.Body)
+	require.NoError(t, err)
+	var cwReq types.CodeWhispererRequest
+	require.NoError(t, json.Unmarshal(body, &cwReq))
+	assert.Equal(t, "CLAUDE_SONNET_4_5_20250929_V1_0", cwReq.ConversationState.CurrentMessage.UserInputMessage.ModelId)
+}
+
+func TestBuildRequest_ModelPassthroughRejected(t *testing.T) {
+	tests := []struct {
+		name        string
+		passthrough string
+		model       string
+	}{
+		{name: "未启用直通", passthrough: "", model: "cw:CLAUDE_SONNET_4_5_20250929_V1_0"},
+		{name: "直通ID格式非法", passthrough: "true", model: "cw:claude sonnet"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("ALLOW_MODEL_PASSTHROUGH", tt.passthrough)
+			c, w := newPassthroughContext()
+
+			rp := NewReverseProxy(nil)
+			_, err := rp.buildRequest(c, config.DefaultUpstreamEndpoint, passthroughRequest(tt.model), types.TokenInfo{AccessToken: "token"}, false)
+
+			var modelErr *types.ModelNotFoundErrorType
+			require.ErrorAs(t, err, &modelErr)
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+
+			var resp types.ModelNotFoundError
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+			assert.Equal(t, "invalid_model", resp.Error.Code)
+			assert.Contains(t, resp.Error.Message, tt.model)
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package shared
+
+import (
+	"io"
+
+	"kiro2api/config"
+	logutil "kiro2api/internal/adapter/httpapi/logging"
+	"kiro2api/internal/adapter/httpapi/support"
+	"kiro2api/logger"
+	"kiro2api/parser"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TruncatedUpstreamHeader 非流式响应因上游连接中断只包含部分内容时设置为 true
+const TruncatedUpstreamHeader = "X-Kiro-Truncated-Upstream"
+
+// ParseNonStreamResponse 增量读取并解析非流式请求的上游响应
+// 读取中途出错时，若启用 PARTIAL_RESPONSE_ON_ERROR 且已解析出内容，设置 X-Kiro-Truncated-Upstream 响应头并返回部分结果（truncated 为true）；
+// 否则写入错误响应，ok 为false
+func ParseNonStreamResponse(c *gin.Context, compliantParser *parser.CompliantEventStreamParser, body io.Reader) (result *parser.ParseResult, truncated bool, ok bool) {
+	defer TrackToolState(compliantParser)()
+
+	result, err := compliantParser.ParseReader(body)
+	if err == nil {
+		return result, false, true
+	}
+
+	if !config.IsPartialResponseOnErrorEnabled() || !result.HasContent() {
+		support.HandleResponseReadError(c, err)
+		return nil, false, false
+	}
+
+	logger.Warn("上游连接中途断开，返回已收到的部分响应",
+		logutil.AddFields(c,
+			logger.Err(err),
+			logger.Int("messages", len(result.Messages)),
+			logger.Int("completed_tools", len(result.ToolExecutions)),
+		)...)
+	c.Header(TruncatedUpstreamHeader, "true")
+	return result, true, true
+}
@@ -0,0 +1,67 @@
+package shared
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"sync/atomic"
+
+	"kiro2api/config"
+	"kiro2api/types"
+)
+
+// ErrUnknownProfile X-Kiro-Profile 指定的名称不在所选账号的 profiles 中
+var ErrUnknownProfile = errors.New("未知的 profile")
+
+// ProfileSelector 为每个请求选择 CodeWhisperer profileArn
+// ARN 只对所属账号有效，名称在所选 token 的 profiles 中解析为 ARN
+// 优先级：管理员请求头 X-Kiro-Profile 指定的名称 > models 匹配请求模型且账号配置了该名称的第一个 profile > 账号的默认 profile
+type ProfileSelector struct {
+	profiles []config.Profile
+}
+
+// NewProfileSelector 按 KIRO_PROFILES 的顺序创建选择器，profiles 为空时只使用 token 的默认 profile
+func NewProfileSelector(profiles []config.Profile) *ProfileSelector {
+	return &ProfileSelector{profiles: profiles}
+}
+
+// Select 返回本次请求在 token 所属账号上使用的 profileArn；requested 为管理员通过请求头指定的名称
+// requested 不为空但账号没有该名称的 profile 时返回 ErrUnknownProfile
+func (s *ProfileSelector) Select(model, requested string, token types.TokenInfo) (string, error) {
+	if requested = strings.TrimSpace(requested); requested != "" {
+		arn, ok := token.Profiles[requested]
+		if !ok {
+			return "", fmt.Errorf("%w: 当前账号未配置 %s", ErrUnknownProfile, requested)
+		}
+		return arn, nil
+	}
+	for _, profile := range s.profiles {
+		arn, ok := token.Profiles[profile.Name]
+		if !ok {
+			continue
+		}
+		for _, pattern := range profile.Models {
+			if matched, _ := path.Match(pattern, model); matched {
+				return arn, nil
+			}
+		}
+	}
+	return token.ProfileArn, nil
+}
+
+// activeProfileSelector 启动时按 KIRO_PROFILES 创建的选择器
+var activeProfileSelector atomic.Pointer[ProfileSelector]
+
+// SetProfileSelector 替换全局 profile 选择器
+func SetProfileSelector(selector *ProfileSelector) {
+	activeProfileSelector.Store(selector)
+}
+
+// ActiveProfileSelector 返回全局 profile 选择器，未设置时返回只使用 token 默认 profile 的选择器
+func ActiveProfileSelector() *ProfileSelector {
+	if selector := activeProfileSelector.Load(); selector != nil {
+		return selector
+	}
+	return NewProfileSelector(nil)
+}
@@ -0,0 +1,95 @@
+package shared
+
+import (
+	"testing"
+
+	"kiro2api/config"
+	"kiro2api/types"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testOpusProfileArn    = "arn:aws:codewhisperer:us-east-1:123456789012:profile/OPUS"
+	testSonnetProfileArn  = "arn:aws:codewhisperer:us-east-1:123456789012:profile/SONNET"
+	testCustomProfileArn  = "arn:aws:codewhisperer:us-east-1:123456789012:profile/CUSTOM"
+	testDefaultProfileArn = "arn:aws:codewhisperer:us-east-1:123456789012:profile/DEFAULT"
+	testOtherOpusArn      = "arn:aws:codewhisperer:us-east-1:210987654321:profile/OPUS"
+)
+
+func TestProfileSelector_Select(t *testing.T) {
+	selector := NewProfileSelector([]config.Profile{
+		{Name: "opus", Models: []string{"claude-opus-*"}},
+		{Name: "sonnet", Models: []string{"claude-sonnet-4.5", "claude-*"}},
+	})
+	token := types.TokenInfo{
+		ProfileArn: testDefaultProfileArn,
+		Profiles: map[string]string{
+			"opus":   testOpusProfileArn,
+			"sonnet": testSonnetProfileArn,
+			"custom": testCustomProfileArn,
+		},
+	}
+
+	tests := []struct {
+		name      string
+		model     string
+		requested string
+		want      string
+	}{
+		{"请求头指定的profile优先于模型匹配", "claude-opus-4.5", "custom", testCustomProfileArn},
+		{"请求头前后空白被忽略", "claude-opus-4.5", " sonnet ", testSonnetProfileArn},
+		{"按通配符匹配模型", "claude-opus-4.5", "", testOpusProfileArn},
+		{"按配置顺序取第一个匹配", "claude-sonnet-4.5", "", testSonnetProfileArn},
+		{"没有匹配时使用token的默认profile", "gpt-4o", "", testDefaultProfileArn},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			arn, err := selector.Select(tt.model, tt.requested, token)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, arn)
+		})
+	}
+
+	_, err := selector.Select("claude-opus-4.5", "missing", token)
+	assert.ErrorIs(t, err, ErrUnknownProfile)
+}
+
+// TestProfileSelector_PerAccount 同名 profile 在不同账号上解析为各自的 ARN，账号未配置该名称时不使用其他账号的 ARN
+func TestProfileSelector_PerAccount(t *testing.T) {
+	selector := NewProfileSelector([]config.Profile{
+		{Name: "opus", Models: []string{"claude-opus-*"}},
+	})
+
+	first := types.TokenInfo{Profiles: map[string]string{"opus": testOpusProfileArn}}
+	second := types.TokenInfo{Profiles: map[string]string{"opus": testOtherOpusArn}}
+	plain := types.TokenInfo{ProfileArn: testDefaultProfileArn}
+
+	arn, err := selector.Select("claude-opus-4.5", "", first)
+	require.NoError(t, err)
+	assert.Equal(t, testOpusProfileArn, arn)
+
+	arn, err = selector.Select("claude-opus-4.5", "", second)
+	require.NoError(t, err)
+	assert.Equal(t, testOtherOpusArn, arn)
+
+	arn, err = selector.Select("claude-opus-4.5", "", plain)
+	require.NoError(t, err)
+	assert.Equal(t, testDefaultProfileArn, arn, "账号没有该profile时使用自己的默认profile")
+
+	_, err = selector.Select("claude-opus-4.5", "opus", plain)
+	assert.ErrorIs(t, err, ErrUnknownProfile)
+}
+
+func TestProfileSelector_Empty(t *testing.T) {
+	selector := NewProfileSelector(nil)
+
+	arn, err := selector.Select("claude-opus-4.5", "", types.TokenInfo{ProfileArn: testDefaultProfileArn})
+	require.NoError(t, err)
+	assert.Equal(t, testDefaultProfileArn, arn)
+
+	arn, err = selector.Select("claude-opus-4.5", "", types.TokenInfo{})
+	require.NoError(t, err)
+	assert.Empty(t, arn, "没有任何profile时不携带profileArn")
+}
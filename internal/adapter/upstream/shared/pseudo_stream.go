@@ -0,0 +1,216 @@
+package shared
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	logutil "kiro2api/internal/adapter/httpapi/logging"
+	"kiro2api/internal/stats"
+	"kiro2api/logger"
+	"kiro2api/parser"
+	"kiro2api/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrEmptyJSONResponse 上游返回的JSON响应中没有文本或工具调用
+var ErrEmptyJSONResponse = errors.New("上游JSON响应中没有可输出的内容")
+
+// IsJSONResponse 判断上游是否以JSON（而不是EventStream）响应了流式请求
+// 容量紧张时上游偶尔会对流式请求返回完整的 application/json 响应体，EventStream 解析器无法从中解析出事件
+func IsJSONResponse(resp *http.Response) bool {
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// jsonResponseTool 从JSON响应中收集的工具调用，input 为字符串形式的参数片段依次拼接
+type jsonResponseTool struct {
+	id, name string
+	input    any
+	fragment strings.Builder
+}
+
+// ParseJSONResponseBlocks 把上游的完整JSON响应转换为 Anthropic 内容块（text 在前，tool_use 按出现顺序在后）
+// 响应体可以是单个对象或对象数组；对象可以是 assistantResponseEvent / toolUseEvent / assistantResponseMessage
+// 包装的事件，也可以是直接带 content 或 toolUseId+name 的事件体；同一 toolUseId 的字符串参数片段按顺序拼接
+func ParseJSONResponseBlocks(body []byte) ([]map[string]any, error) {
+	var root any
+	if err := utils.FastUnmarshal(body, &root); err != nil {
+		return nil, fmt.Errorf("解析上游JSON响应失败: %w", err)
+	}
+
+	var text strings.Builder
+	var tools []*jsonResponseTool
+	toolByID := map[string]*jsonResponseTool{}
+	addTool := func(item map[string]any) {
+		id, _ := item["toolUseId"].(string)
+		name, _ := item["name"].(string)
+		if id == "" || name == "" {
+			return
+		}
+		tool, ok := toolByID[id]
+		if !ok {
+			tool = &jsonResponseTool{id: id, name: name}
+			toolByID[id] = tool
+			tools = append(tools, tool)
+		}
+		switch input := item["input"].(type) {
+		case string:
+			tool.fragment.WriteString(input)
+		case map[string]any:
+			tool.input = input
+		}
+	}
+
+	var visit func(node any)
+	visit = func(node any) {
+		switch v := node.(type) {
+		case []any:
+			for _, item := range v {
+				visit(item)
+			}
+		case map[string]any:
+			for _, key := range []string{"assistantResponseMessage", "assistantResponseEvent", "toolUseEvent"} {
+				if nested, ok := v[key]; ok {
+					visit(nested)
+				}
+			}
+			if content, ok := v["content"].(string); ok {
+				text.WriteString(content)
+			}
+			if toolUses, ok := v["toolUses"].([]any); ok {
+				for _, item := range toolUses {
+					if tool, ok := item.(map[string]any); ok {
+						addTool(tool)
+					}
+				}
+			}
+			addTool(v)
+		}
+	}
+	visit(root)
+
+	var blocks []map[string]any
+	if text.Len() > 0 {
+		blocks = append(blocks, map[string]any{"type": "text", "text": text.String()})
+	}
+	for _, tool := range tools {
+		input := map[string]any{}
+		if raw := strings.TrimSpace(tool.fragment.String()); raw != "" {
+			if err := utils.FastUnmarshal([]byte(raw), &input); err != nil {
+				return nil, fmt.Errorf("工具 %s 的参数不是有效的JSON对象: %w", tool.name, err)
+			}
+		} else if object, ok := tool.input.(map[string]any); ok {
+			input = object
+		}
+		blocks = append(blocks, map[string]any{"type": "tool_use", "id": tool.id, "name": tool.name, "input": input})
+	}
+	if len(blocks) == 0 {
+		return nil, ErrEmptyJSONResponse
+	}
+	return blocks, nil
+}
+
+// PseudoStreamEvents 为每个内容块生成 content_block_start、一个完整的增量和 content_block_stop
+// message_start 与 message_delta/message_stop 由调用方的流程照常发送
+func PseudoStreamEvents(blocks []map[string]any) []map[string]any {
+	events := make([]map[string]any, 0, len(blocks)*3)
+	for index, block := range blocks {
+		var start, delta map[string]any
+		switch block["type"] {
+		case "text":
+			start = map[string]any{"type": "text", "text": ""}
+			delta = map[string]any{"type": "text_delta", "text": block["text"]}
+		case "tool_use":
+			// 与上游流式事件一致：content_block_start 使用空对象，参数在 input_json_delta 中发送
+			arguments, err := utils.SafeMarshal(block["input"])
+			if err != nil {
+				arguments = []byte("{}")
+			}
+			start = map[string]any{"type": "tool_use", "id": block["id"], "name": block["name"], "input": map[string]any{}}
+			delta = map[string]any{"type": "input_json_delta", "partial_json": string(arguments)}
+		default:
+			continue
+		}
+		events = append(events,
+			map[string]any{"type": "content_block_start", "index": index, "content_block": start},
+			map[string]any{"type": "content_block_delta", "index": index, "delta": delta},
+			map[string]any{"type": "content_block_stop", "index": index},
+		)
+	}
+	return events
+}
+
+// ReadPseudoStreamEvents 读取上游JSON响应体并生成伪流式内容块事件，记录降级日志和统计
+func ReadPseudoStreamEvents(c *gin.Context, model string, body io.Reader) ([]map[string]any, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("读取上游JSON响应失败: %w", err)
+	}
+	stats.GetModelUpstreamStats().RecordPseudoStream(model)
+
+	blocks, err := ParseJSONResponseBlocks(data)
+	if err != nil {
+		logger.Error("上游对流式请求返回了无法使用的JSON响应",
+			logutil.AddFields(c,
+				logger.String("model", model),
+				logger.Int("body_bytes", len(data)),
+				logger.Err(err),
+			)...)
+		return nil, err
+	}
+
+	logger.Warn("上游对流式请求返回了JSON响应，降级为伪流式输出",
+		logutil.AddFields(c,
+			logger.String("model", model),
+			logger.Int("body_bytes", len(data)),
+			logger.Int("content_blocks", len(blocks)),
+		)...)
+	return PseudoStreamEvents(blocks), nil
+}
+
+// ProcessJSONResponse 上游以JSON响应流式请求时，把完整响应转换为内容块事件后按正常流程转发
+// 响应无法使用时向客户端发送error事件，流仍由 FinishStream 以 message_delta 和 message_stop 收尾
+func (esp *EventStreamProcessor) ProcessJSONResponse(body io.Reader) error {
+	defer esp.flusher.Close()
+
+	events, err := ReadPseudoStreamEvents(esp.ctx.c, esp.ctx.req.Model, body)
+	if err != nil {
+		errorEvent := map[string]any{
+			"type": "error",
+			"error": map[string]any{
+				"type":    "api_error",
+				"message": "上游返回了无法解析的非流式响应: " + err.Error(),
+			},
+		}
+		if sendErr := esp.ctx.sender.SendEvent(esp.ctx.c, errorEvent); sendErr != nil {
+			logger.Error("发送上游JSON响应错误事件失败", logger.Err(sendErr))
+		}
+		return err
+	}
+
+	esp.ctx.totalProcessedEvents += len(events)
+	for _, event := range events {
+		eventType, _ := event["type"].(string)
+		var processErr error
+		esp.flusher.Write(func() {
+			processErr = esp.processEvent(parser.SSEEvent{Event: eventType, Data: event})
+		})
+		if processErr != nil {
+			return processErr
+		}
+	}
+
+	var checkErr error
+	esp.flusher.Write(func() {
+		checkErr = esp.checkPendingToolArgs()
+	})
+	return checkErr
+}
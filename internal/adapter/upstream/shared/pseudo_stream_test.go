@@ -0,0 +1,76 @@
+package shared
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsJSONResponse(t *testing.T) {
+	for contentType, want := range map[string]bool{
+		"application/json":                   true,
+		"application/json; charset=utf-8":    true,
+		"application/x-amz-json-1.0+json":    true,
+		"application/vnd.amazon.eventstream": false,
+		"text/event-stream":                  false,
+		"":                                   false,
+	} {
+		resp := &http.Response{Header: http.Header{"Content-Type": []string{contentType}}}
+		assert.Equal(t, want, IsJSONResponse(resp), contentType)
+	}
+}
+
+func TestParseJSONResponseBlocks(t *testing.T) {
+	t.Run("事件数组", func(t *testing.T) {
+		blocks, err := ParseJSONResponseBlocks([]byte(`[
+			{"assistantResponseEvent": {"content": "Hello, "}},
+			{"content": "world"},
+			{"toolUseEvent": {"toolUseId": "t1", "name": "search", "input": "{\"q\":"}},
+			{"toolUseId": "t1", "name": "search", "input": "\"rain\"}", "stop": true},
+			{"toolUseEvent": {"toolUseId": "t2", "name": "now", "stop": true}}
+		]`))
+		require.NoError(t, err)
+		assert.Equal(t, []map[string]any{
+			{"type": "text", "text": "Hello, world"},
+			{"type": "tool_use", "id": "t1", "name": "search", "input": map[string]any{"q": "rain"}},
+			{"type": "tool_use", "id": "t2", "name": "now", "input": map[string]any{}},
+		}, blocks)
+	})
+
+	t.Run("完整消息", func(t *testing.T) {
+		blocks, err := ParseJSONResponseBlocks([]byte(`{"assistantResponseMessage": {"content": "ok", "toolUses": [{"toolUseId": "t1", "name": "search", "input": {"q": "sun"}}]}}`))
+		require.NoError(t, err)
+		assert.Equal(t, []map[string]any{
+			{"type": "text", "text": "ok"},
+			{"type": "tool_use", "id": "t1", "name": "search", "input": map[string]any{"q": "sun"}},
+		}, blocks)
+	})
+
+	t.Run("无法使用的响应", func(t *testing.T) {
+		_, err := ParseJSONResponseBlocks([]byte(`{"message": "capacity exceeded"}`))
+		assert.ErrorIs(t, err, ErrEmptyJSONResponse)
+
+		_, err = ParseJSONResponseBlocks([]byte(`not json`))
+		assert.Error(t, err)
+
+		_, err = ParseJSONResponseBlocks([]byte(`{"toolUseId": "t1", "name": "search", "input": "{\"q\":"}`))
+		assert.ErrorContains(t, err, "search")
+	})
+}
+
+func TestPseudoStreamEvents(t *testing.T) {
+	events := PseudoStreamEvents([]map[string]any{
+		{"type": "text", "text": "hi"},
+		{"type": "tool_use", "id": "t1", "name": "search", "input": map[string]any{"q": "rain"}},
+	})
+	assert.Equal(t, []map[string]any{
+		{"type": "content_block_start", "index": 0, "content_block": map[string]any{"type": "text", "text": ""}},
+		{"type": "content_block_delta", "index": 0, "delta": map[string]any{"type": "text_delta", "text": "hi"}},
+		{"type": "content_block_stop", "index": 0},
+		{"type": "content_block_start", "index": 1, "content_block": map[string]any{"type": "tool_use", "id": "t1", "name": "search", "input": map[string]any{}}},
+		{"type": "content_block_delta", "index": 1, "delta": map[string]any{"type": "input_json_delta", "partial_json": `{"q":"rain"}`}},
+		{"type": "content_block_stop", "index": 1},
+	}, events)
+}
@@ -0,0 +1,220 @@
+package shared
+
+import (
+	"sort"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"kiro2api/config"
+	logutil "kiro2api/internal/adapter/httpapi/logging"
+	"kiro2api/logger"
+	"kiro2api/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// activeResponseFilters 启动时编译的 RESPONSE_FILTERS 规则
+var activeResponseFilters atomic.Pointer[[]config.ResponseFilter]
+
+// SetResponseFilters 替换全局响应过滤规则
+func SetResponseFilters(filters []config.ResponseFilter) {
+	activeResponseFilters.Store(&filters)
+}
+
+// NewConfiguredResponseFilter 按启动时加载的规则创建单个响应的过滤器，未配置规则时返回nil
+func NewConfiguredResponseFilter() *ResponseFilter {
+	if filters := activeResponseFilters.Load(); filters != nil {
+		return NewResponseFilter(*filters)
+	}
+	return nil
+}
+
+// ResponseFilter 按正则替换下发给客户端的文本和工具参数，持有单个响应内的状态
+// 文本增量在每个块内保留长度为最长正则源码的尾部窗口，使跨两个增量的匹配也能被替换；
+// 工具参数在块结束前聚合完整后统一替换
+type ResponseFilter struct {
+	text     []config.ResponseFilter
+	toolArgs []config.ResponseFilter
+	window   int
+
+	carry         map[int]string           // 文本块尚未下发的尾部原文
+	toolArgsBuf   map[int]*strings.Builder // 工具块聚合的参数JSON
+	filteredChars int                      // 被替换掉的原文字符数
+}
+
+// NewResponseFilter 按作用范围拆分规则，没有任何规则时返回nil
+func NewResponseFilter(filters []config.ResponseFilter) *ResponseFilter {
+	f := &ResponseFilter{carry: make(map[int]string), toolArgsBuf: make(map[int]*strings.Builder)}
+	for _, filter := range filters {
+		if filter.Text {
+			f.text = append(f.text, filter)
+			f.window = max(f.window, filter.Window)
+		}
+		if filter.ToolArgs {
+			f.toolArgs = append(f.toolArgs, filter)
+		}
+	}
+	if len(f.text) == 0 && len(f.toolArgs) == 0 {
+		return nil
+	}
+	return f
+}
+
+// FiltersText 是否有作用于文本的规则
+func (f *ResponseFilter) FiltersText() bool {
+	return f != nil && len(f.text) > 0
+}
+
+// FiltersToolArgs 是否有作用于工具参数的规则
+func (f *ResponseFilter) FiltersToolArgs() bool {
+	return f != nil && len(f.toolArgs) > 0
+}
+
+// FilteredChars 本次响应中被替换掉的原文字符数
+func (f *ResponseFilter) FilteredChars() int {
+	if f == nil {
+		return 0
+	}
+	return f.filteredChars
+}
+
+// FilterTextDelta 拼接上次保留的尾部后替换，返回可以下发的文本，末尾窗口内的原文留到下一个增量或块结束
+// 跨越保留边界的匹配整体留到下一次处理，保证同一段原文只被替换一次
+func (f *ResponseFilter) FilterTextDelta(index int, text string) string {
+	buf := f.carry[index] + text
+
+	cut := len(buf)
+	for n := 0; n < f.window && cut > 0; n++ {
+		_, size := utf8.DecodeLastRuneInString(buf[:cut])
+		cut -= size
+	}
+	for moved := true; moved; {
+		moved = false
+		for _, rule := range f.text {
+			for _, loc := range rule.Regexp.FindAllStringIndex(buf, -1) {
+				if loc[0] < cut && loc[1] > cut {
+					cut = loc[0]
+					moved = true
+				}
+			}
+		}
+	}
+
+	f.carry[index] = buf[cut:]
+	return f.apply(f.text, buf[:cut])
+}
+
+// BufferToolArgs 聚合工具参数增量，没有作用于工具参数的规则时返回false，增量照常转发
+func (f *ResponseFilter) BufferToolArgs(index int, partialJSON string) bool {
+	if !f.FiltersToolArgs() {
+		return false
+	}
+	if f.toolArgsBuf[index] == nil {
+		f.toolArgsBuf[index] = &strings.Builder{}
+	}
+	f.toolArgsBuf[index].WriteString(partialJSON)
+	return true
+}
+
+// Flush 块结束前取出暂存内容，返回替换后的 text_delta 或 input_json_delta 事件；没有暂存内容时返回nil
+func (f *ResponseFilter) Flush(index int) map[string]any {
+	if f == nil {
+		return nil
+	}
+
+	if pending, ok := f.carry[index]; ok {
+		delete(f.carry, index)
+		if text := f.apply(f.text, pending); text != "" {
+			return map[string]any{
+				"type":  "content_block_delta",
+				"index": index,
+				"delta": map[string]any{"type": "text_delta", "text": text},
+			}
+		}
+		return nil
+	}
+
+	if args, ok := f.toolArgsBuf[index]; ok {
+		delete(f.toolArgsBuf, index)
+		if args.Len() > 0 {
+			return map[string]any{
+				"type":  "content_block_delta",
+				"index": index,
+				"delta": map[string]any{"type": "input_json_delta", "partial_json": f.apply(f.toolArgs, args.String())},
+			}
+		}
+	}
+	return nil
+}
+
+// PendingBlocks 仍有暂存内容的块索引，按索引升序
+func (f *ResponseFilter) PendingBlocks() []int {
+	if f == nil {
+		return nil
+	}
+	indices := make([]int, 0, len(f.carry)+len(f.toolArgsBuf))
+	for index := range f.carry {
+		indices = append(indices, index)
+	}
+	for index := range f.toolArgsBuf {
+		indices = append(indices, index)
+	}
+	sort.Ints(indices)
+	return indices
+}
+
+// FilterText 替换完整文本（非流式响应）
+func (f *ResponseFilter) FilterText(text string) string {
+	if !f.FiltersText() {
+		return text
+	}
+	return f.apply(f.text, text)
+}
+
+// FilterToolInput 替换序列化后的工具参数（非流式响应），替换结果不是有效的JSON对象时保留原参数
+func (f *ResponseFilter) FilterToolInput(c *gin.Context, input map[string]any) map[string]any {
+	if !f.FiltersToolArgs() || input == nil {
+		return input
+	}
+	raw, err := utils.SafeMarshal(input)
+	if err != nil {
+		return input
+	}
+
+	before := f.filteredChars
+	filtered := f.apply(f.toolArgs, string(raw))
+	if f.filteredChars == before {
+		return input
+	}
+	var result map[string]any
+	if err := utils.SafeUnmarshal([]byte(filtered), &result); err != nil {
+		f.filteredChars = before
+		logger.Warn("工具参数替换后不是有效的JSON，保留原参数", logutil.AddFields(c, logger.Err(err))...)
+		return input
+	}
+	return result
+}
+
+// LogSummary 记录本次响应被替换的字符数，没有替换时不输出
+func (f *ResponseFilter) LogSummary(c *gin.Context) {
+	if f.FilteredChars() == 0 {
+		return
+	}
+	logger.Info("响应过滤器替换了内容", logutil.AddFields(c, logger.Int("filtered_chars", f.filteredChars))...)
+}
+
+// apply 依次应用规则并累计被替换的字符数
+func (f *ResponseFilter) apply(rules []config.ResponseFilter, s string) string {
+	for _, rule := range rules {
+		locs := rule.Regexp.FindAllStringIndex(s, -1)
+		if len(locs) == 0 {
+			continue
+		}
+		for _, loc := range locs {
+			f.filteredChars += utf8.RuneCountInString(s[loc[0]:loc[1]])
+		}
+		s = rule.Regexp.ReplaceAllString(s, rule.Replacement)
+	}
+	return s
+}
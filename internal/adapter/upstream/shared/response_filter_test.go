@@ -0,0 +1,143 @@
+package shared
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"kiro2api/config"
+	"kiro2api/parser"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustResponseFilter(t *testing.T, raw string) *ResponseFilter {
+	t.Helper()
+	filters, err := config.ParseResponseFilters(raw)
+	require.NoError(t, err)
+	return NewResponseFilter(filters)
+}
+
+// textDeltaStream 每个增量一个 assistantResponseEvent
+func textDeltaStream(t *testing.T, deltas ...string) *bytes.Buffer {
+	var stream bytes.Buffer
+	for _, d := range deltas {
+		stream.Write(eventStreamFrame(t, parser.EventTypes.ASSISTANT_RESPONSE_EVENT, map[string]any{"content": d}))
+	}
+	return &stream
+}
+
+// toolArgs 拼接所有 input_json_delta 的参数
+func (s *recordingSender) toolArgs() string {
+	var sb strings.Builder
+	for _, event := range s.events {
+		if delta, ok := event["delta"].(map[string]any); ok && delta["type"] == "input_json_delta" {
+			sb.WriteString(delta["partial_json"].(string))
+		}
+	}
+	return sb.String()
+}
+
+func TestParseResponseFilters(t *testing.T) {
+	filters, err := config.ParseResponseFilters(`[
+		{"pattern": "sk-[a-z0-9]+", "replacement": "[key]", "applies_to": "text"},
+		{"pattern": "/home/\\w+", "replacement": "~", "applies_to": "tool_args"},
+		{"pattern": "secret", "replacement": "***"}
+	]`)
+	require.NoError(t, err)
+	require.Len(t, filters, 3)
+	assert.True(t, filters[0].Text)
+	assert.False(t, filters[0].ToolArgs)
+	assert.True(t, filters[1].ToolArgs)
+	assert.False(t, filters[1].Text)
+	assert.True(t, filters[2].Text && filters[2].ToolArgs, "applies_to 为空时视为 both")
+	assert.Equal(t, len("sk-[a-z0-9]+"), filters[0].Window)
+
+	_, err = config.ParseResponseFilters(`[{"pattern": "a(b", "replacement": ""}]`)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "RESPONSE_FILTERS[0]")
+
+	_, err = config.ParseResponseFilters(`[{"pattern": "a", "applies_to": "thinking"}]`)
+	assert.Error(t, err)
+
+	filters, err = config.ParseResponseFilters("")
+	assert.NoError(t, err)
+	assert.Nil(t, filters)
+}
+
+// TestProcessEventStream_ResponseFilterCrossDelta 匹配跨越两个增量时仍被整体替换
+func TestProcessEventStream_ResponseFilterCrossDelta(t *testing.T) {
+	processor, sender := newTestStreamProcessor(t)
+	processor.ctx.responseFilter = mustResponseFilter(t, `[{"pattern": "sk-[a-z0-9]{8}", "replacement": "[key]", "applies_to": "text"}]`)
+
+	require.NoError(t, processor.ProcessEventStream(textDeltaStream(t, "你的密钥是 sk-abc", "d1234，请妥善保管。")))
+	processor.ctx.FinishStream()
+
+	assert.Equal(t, "你的密钥是 [key]，请妥善保管。", sender.text())
+	for _, delta := range sender.textDeltas() {
+		assert.NotContains(t, delta, "sk-", "原文不能分段泄漏")
+	}
+	assert.Equal(t, len("sk-abcd1234"), processor.ctx.responseFilter.FilteredChars())
+}
+
+// TestProcessEventStream_ResponseFilterToolArgsOnly 只作用于工具参数的规则不修改文本
+func TestProcessEventStream_ResponseFilterToolArgsOnly(t *testing.T) {
+	processor, sender := newTestStreamProcessor(t)
+	processor.ctx.responseFilter = mustResponseFilter(t, `[{"pattern": "/home/alice", "replacement": "~", "applies_to": "tool_args"}]`)
+
+	var stream bytes.Buffer
+	stream.Write(eventStreamFrame(t, parser.EventTypes.ASSISTANT_RESPONSE_EVENT, map[string]any{"content": "读取 /home/alice/a.go"}))
+	stream.Write(eventStreamFrame(t, parser.EventTypes.TOOL_USE_EVENT, map[string]any{
+		"toolUseId": "tooluse_read",
+		"name":      "read_file",
+		"input":     map[string]any{"path": "/home/alice/a.go"},
+		"stop":      true,
+	}))
+	require.NoError(t, processor.ProcessEventStream(&stream))
+	processor.ctx.FinishStream()
+
+	assert.Equal(t, "读取 /home/alice/a.go", sender.text())
+	assert.JSONEq(t, `{"path": "~/a.go"}`, sender.toolArgs())
+
+	// 替换后的参数在 content_block_stop 之前发送
+	argsAt, stopAt := -1, -1
+	for i, event := range sender.events {
+		if delta, ok := event["delta"].(map[string]any); ok && delta["type"] == "input_json_delta" {
+			argsAt = i
+		}
+		if event["type"] == "content_block_stop" && argsAt >= 0 && stopAt < 0 {
+			stopAt = i
+		}
+	}
+	require.GreaterOrEqual(t, argsAt, 0)
+	assert.Less(t, argsAt, stopAt)
+}
+
+// TestResponseFilter_CarryOverPreservesOutput 没有匹配时暂存窗口不改变输出，多字节字符不被拆开
+func TestResponseFilter_CarryOverPreservesOutput(t *testing.T) {
+	f := mustResponseFilter(t, `[{"pattern": "password=\\S+", "replacement": "password=***", "applies_to": "text"}]`)
+	deltas := []string{"这是", "一段很长的中文回答，", "包含 emoji 🎉 和 ASCII", "，", "没有任何需要过滤的内容。", "结尾"}
+
+	var out strings.Builder
+	for _, d := range deltas {
+		out.WriteString(f.FilterTextDelta(0, d))
+	}
+	if event := f.Flush(0); event != nil {
+		out.WriteString(event["delta"].(map[string]any)["text"].(string))
+	}
+
+	assert.Equal(t, strings.Join(deltas, ""), out.String())
+	assert.Zero(t, f.FilteredChars())
+	assert.Empty(t, f.PendingBlocks())
+
+	t.Run("流中途结束时暂存内容仍被发送", func(t *testing.T) {
+		processor, sender := newTestStreamProcessor(t)
+		processor.ctx.responseFilter = mustResponseFilter(t, `[{"pattern": "password=\\S+", "replacement": "password=***", "applies_to": "text"}]`)
+
+		require.NoError(t, processor.ProcessEventStream(textDeltaStream(t, "好的", "，password=hunter2")))
+		processor.ctx.FinishStream()
+
+		assert.Equal(t, "好的，password=***", sender.text())
+	})
+}
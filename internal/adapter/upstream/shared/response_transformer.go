@@ -0,0 +1,296 @@
+package shared
+
+import (
+	"maps"
+
+	"kiro2api/config"
+	"kiro2api/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 内置转换器名称，对应 RESPONSE_TRANSFORMERS 中的取值
+const (
+	TransformerStripThinking       = "strip_thinking"
+	TransformerNormaliseStopReason = "normalise_stop_reason"
+	TransformerAddUsageMetadata    = "add_usage_metadata"
+)
+
+// Transformer 对下发给客户端的 Anthropic 响应做格式适配
+// 转换器可以持有单个响应内的状态，每个请求使用新的实例
+type Transformer interface {
+	Name() string
+	// TransformResponse 原地修改非流式响应
+	TransformResponse(resp map[string]any)
+	// TransformEvent 返回要发送的流式事件，返回nil表示丢弃该事件；需要修改时返回副本，不改动传入的事件
+	TransformEvent(event map[string]any) map[string]any
+}
+
+var transformerFactories = map[string]func() Transformer{
+	TransformerStripThinking:       func() Transformer { return &StripThinkingBlocks{} },
+	TransformerNormaliseStopReason: func() Transformer { return NormaliseStopReason{} },
+	TransformerAddUsageMetadata:    func() Transformer { return AddUsageMetadata{} },
+}
+
+// ResponseTransformer 按配置顺序依次应用的转换器列表，为空时原样返回
+type ResponseTransformer struct {
+	transformers []Transformer
+}
+
+// NewResponseTransformer 按名称创建转换器流水线，未知名称记录警告后忽略
+func NewResponseTransformer(names []string) *ResponseTransformer {
+	pipeline := &ResponseTransformer{}
+	for _, name := range names {
+		factory, ok := transformerFactories[name]
+		if !ok {
+			logger.Warn("未知的响应转换器，已忽略", logger.String("transformer", name))
+			continue
+		}
+		pipeline.transformers = append(pipeline.transformers, factory())
+	}
+	return pipeline
+}
+
+// NewConfiguredResponseTransformer 按 RESPONSE_TRANSFORMERS 创建转换器流水线
+func NewConfiguredResponseTransformer() *ResponseTransformer {
+	return NewResponseTransformer(config.ResponseTransformers())
+}
+
+// Names 返回生效的转换器名称
+func (p *ResponseTransformer) Names() []string {
+	names := make([]string, 0, len(p.transformers))
+	for _, t := range p.transformers {
+		names = append(names, t.Name())
+	}
+	return names
+}
+
+// Empty 没有配置任何转换器
+func (p *ResponseTransformer) Empty() bool {
+	return p == nil || len(p.transformers) == 0
+}
+
+// TransformResponse 依次转换非流式响应
+func (p *ResponseTransformer) TransformResponse(resp map[string]any) {
+	if p == nil {
+		return
+	}
+	for _, t := range p.transformers {
+		t.TransformResponse(resp)
+	}
+}
+
+// TransformEvent 依次转换流式事件，任一转换器丢弃时返回nil
+func (p *ResponseTransformer) TransformEvent(event map[string]any) map[string]any {
+	if p == nil {
+		return event
+	}
+	for _, t := range p.transformers {
+		if event = t.TransformEvent(event); event == nil {
+			return nil
+		}
+	}
+	return event
+}
+
+// transformingSender 发送前对事件应用转换器流水线
+// 包装在 SSE 状态机之后，状态校验和块索引跟踪仍基于转换前的原始事件
+type transformingSender struct {
+	StreamEventSender
+	pipeline *ResponseTransformer
+}
+
+// NewTransformingSender 返回应用转换器的发送器，流水线为空时直接返回原发送器
+func NewTransformingSender(sender StreamEventSender, pipeline *ResponseTransformer) StreamEventSender {
+	if pipeline.Empty() {
+		return sender
+	}
+	return &transformingSender{StreamEventSender: sender, pipeline: pipeline}
+}
+
+func (s *transformingSender) SendEvent(c *gin.Context, data any) error {
+	event, ok := data.(map[string]any)
+	if !ok {
+		return s.StreamEventSender.SendEvent(c, data)
+	}
+	if event = s.pipeline.TransformEvent(event); event == nil {
+		return nil
+	}
+	return s.StreamEventSender.SendEvent(c, event)
+}
+
+// isThinkingBlockType 思考类内容块
+func isThinkingBlockType(blockType string) bool {
+	return blockType == "thinking" || blockType == "redacted_thinking"
+}
+
+// StripThinkingBlocks 移除 thinking/redacted_thinking 内容块，后续块的索引前移保持连续
+type StripThinkingBlocks struct {
+	stripped []int // 已移除块的原始索引，按出现顺序递增
+}
+
+func (*StripThinkingBlocks) Name() string { return TransformerStripThinking }
+
+func (*StripThinkingBlocks) TransformResponse(resp map[string]any) {
+	switch content := resp["content"].(type) {
+	case []map[string]any:
+		kept := make([]map[string]any, 0, len(content))
+		for _, block := range content {
+			if !isThinkingBlockType(getStringField(block, "type")) {
+				kept = append(kept, block)
+			}
+		}
+		resp["content"] = kept
+	case []any:
+		kept := make([]any, 0, len(content))
+		for _, item := range content {
+			if block, ok := item.(map[string]any); ok && isThinkingBlockType(getStringField(block, "type")) {
+				continue
+			}
+			kept = append(kept, item)
+		}
+		resp["content"] = kept
+	}
+}
+
+func (s *StripThinkingBlocks) TransformEvent(event map[string]any) map[string]any {
+	index := extractIndex(event)
+	if index < 0 {
+		return event
+	}
+
+	if getStringField(event, "type") == "content_block_start" {
+		if block, ok := event["content_block"].(map[string]any); ok && isThinkingBlockType(getStringField(block, "type")) {
+			s.stripped = append(s.stripped, index)
+			return nil
+		}
+	}
+
+	shift := 0
+	for _, stripped := range s.stripped {
+		if stripped == index {
+			return nil // 已移除块的 delta 和 stop
+		}
+		if stripped < index {
+			shift++
+		}
+	}
+	if shift == 0 {
+		return event
+	}
+
+	shifted := maps.Clone(event)
+	shifted["index"] = index - shift
+	return shifted
+}
+
+// anthropicStopReasons Anthropic 规范中的 stop_reason 取值
+var anthropicStopReasons = map[string]bool{
+	"end_turn":      true,
+	"max_tokens":    true,
+	"stop_sequence": true,
+	"tool_use":      true,
+	"pause_turn":    true,
+	"refusal":       true,
+}
+
+// stopReasonAliases 其他协议中常见的结束原因
+var stopReasonAliases = map[string]string{
+	"stop":           "end_turn",
+	"length":         "max_tokens",
+	"tool_calls":     "tool_use",
+	"function_call":  "tool_use",
+	"content_filter": "refusal",
+}
+
+// NormaliseStopReason 把 stop_reason 统一为 Anthropic 规范取值，无法识别的取值视为 end_turn
+// 尚未结束时的 null 保持不变
+type NormaliseStopReason struct{}
+
+func (NormaliseStopReason) Name() string { return TransformerNormaliseStopReason }
+
+func (NormaliseStopReason) TransformResponse(resp map[string]any) {
+	if reason, ok := resp["stop_reason"].(string); ok {
+		resp["stop_reason"] = normaliseStopReason(reason)
+	}
+}
+
+func (NormaliseStopReason) TransformEvent(event map[string]any) map[string]any {
+	if getStringField(event, "type") != "message_delta" {
+		return event
+	}
+	delta, ok := event["delta"].(map[string]any)
+	if !ok {
+		return event
+	}
+	reason, ok := delta["stop_reason"].(string)
+	if !ok || normaliseStopReason(reason) == reason {
+		return event
+	}
+
+	delta = maps.Clone(delta)
+	delta["stop_reason"] = normaliseStopReason(reason)
+	normalised := maps.Clone(event)
+	normalised["delta"] = delta
+	return normalised
+}
+
+func normaliseStopReason(reason string) string {
+	if anthropicStopReasons[reason] {
+		return reason
+	}
+	if alias, ok := stopReasonAliases[reason]; ok {
+		return alias
+	}
+	return "end_turn"
+}
+
+// AddUsageMetadata 补全 Anthropic usage 中的缓存字段，部分客户端缺少这些字段时无法解析 usage
+type AddUsageMetadata struct{}
+
+func (AddUsageMetadata) Name() string { return TransformerAddUsageMetadata }
+
+func (AddUsageMetadata) TransformResponse(resp map[string]any) {
+	if usage, ok := resp["usage"].(map[string]any); ok {
+		resp["usage"] = withUsageMetadata(usage)
+	}
+}
+
+func (AddUsageMetadata) TransformEvent(event map[string]any) map[string]any {
+	switch getStringField(event, "type") {
+	case "message_start":
+		message, ok := event["message"].(map[string]any)
+		if !ok {
+			return event
+		}
+		usage, ok := message["usage"].(map[string]any)
+		if !ok {
+			return event
+		}
+		message = maps.Clone(message)
+		message["usage"] = withUsageMetadata(usage)
+		result := maps.Clone(event)
+		result["message"] = message
+		return result
+	case "message_delta":
+		usage, ok := event["usage"].(map[string]any)
+		if !ok {
+			return event
+		}
+		result := maps.Clone(event)
+		result["usage"] = withUsageMetadata(usage)
+		return result
+	}
+	return event
+}
+
+// withUsageMetadata 返回补全了缓存 token 字段的 usage 副本，已有的字段保持不变
+func withUsageMetadata(usage map[string]any) map[string]any {
+	result := maps.Clone(usage)
+	for _, key := range []string{"cache_creation_input_tokens", "cache_read_input_tokens"} {
+		if _, ok := result[key]; !ok {
+			result[key] = 0
+		}
+	}
+	return result
+}
@@ -0,0 +1,118 @@
+package shared
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// thinkingStream 思考块在前、文本块在后的流式事件序列
+func thinkingStream() []map[string]any {
+	return []map[string]any{
+		{"type": "message_start", "message": map[string]any{"id": "msg_1", "usage": map[string]any{"input_tokens": 10, "output_tokens": 1}}},
+		{"type": "content_block_start", "index": 0, "content_block": map[string]any{"type": "thinking", "thinking": ""}},
+		{"type": "content_block_delta", "index": 0, "delta": map[string]any{"type": "thinking_delta", "thinking": "先想一想"}},
+		{"type": "content_block_stop", "index": 0},
+		{"type": "content_block_start", "index": 1, "content_block": map[string]any{"type": "text", "text": ""}},
+		{"type": "content_block_delta", "index": 1, "delta": map[string]any{"type": "text_delta", "text": "答案"}},
+		{"type": "content_block_stop", "index": 1},
+		{"type": "message_delta", "delta": map[string]any{"stop_reason": "stop"}, "usage": map[string]any{"output_tokens": 5}},
+		{"type": "message_stop"},
+	}
+}
+
+func transformStream(pipeline *ResponseTransformer, events []map[string]any) []map[string]any {
+	sender := &recordingSender{}
+	transforming := NewTransformingSender(sender, pipeline)
+	for _, event := range events {
+		_ = transforming.SendEvent(nil, event)
+	}
+	return sender.events
+}
+
+func TestStripThinkingBlocks(t *testing.T) {
+	original := thinkingStream()
+	events := transformStream(NewResponseTransformer([]string{TransformerStripThinking}), original)
+
+	assert.Equal(t, []string{"message_start", "content_block_start", "content_block_delta", "content_block_stop", "message_delta", "message_stop"}, eventTypes(events))
+	for _, event := range events[1:4] {
+		assert.Equal(t, 0, event["index"], "文本块索引前移为0")
+	}
+	assert.Equal(t, 1, original[4]["index"], "不应修改传入的事件")
+
+	resp := map[string]any{"content": []map[string]any{
+		{"type": "thinking", "thinking": "..."},
+		{"type": "redacted_thinking", "data": "..."},
+		{"type": "text", "text": "答案"},
+	}}
+	(&StripThinkingBlocks{}).TransformResponse(resp)
+	assert.Equal(t, []map[string]any{{"type": "text", "text": "答案"}}, resp["content"])
+}
+
+func TestNormaliseStopReason(t *testing.T) {
+	for reason, expected := range map[string]string{
+		"end_turn":      "end_turn",
+		"tool_use":      "tool_use",
+		"stop":          "end_turn",
+		"length":        "max_tokens",
+		"tool_calls":    "tool_use",
+		"whatever_else": "end_turn",
+	} {
+		resp := map[string]any{"stop_reason": reason}
+		NormaliseStopReason{}.TransformResponse(resp)
+		assert.Equal(t, expected, resp["stop_reason"], reason)
+	}
+
+	resp := map[string]any{"stop_reason": nil}
+	NormaliseStopReason{}.TransformResponse(resp)
+	assert.Nil(t, resp["stop_reason"])
+
+	events := transformStream(NewResponseTransformer([]string{TransformerNormaliseStopReason}), thinkingStream())
+	assert.Equal(t, "end_turn", events[7]["delta"].(map[string]any)["stop_reason"])
+}
+
+func TestAddUsageMetadata(t *testing.T) {
+	resp := map[string]any{"usage": map[string]any{"input_tokens": 10, "output_tokens": 5, "cache_read_input_tokens": 3}}
+	AddUsageMetadata{}.TransformResponse(resp)
+	assert.Equal(t, map[string]any{
+		"input_tokens":                10,
+		"output_tokens":               5,
+		"cache_creation_input_tokens": 0,
+		"cache_read_input_tokens":     3,
+	}, resp["usage"])
+
+	events := transformStream(NewResponseTransformer([]string{TransformerAddUsageMetadata}), thinkingStream())
+	startUsage := events[0]["message"].(map[string]any)["usage"].(map[string]any)
+	assert.Equal(t, 0, startUsage["cache_creation_input_tokens"])
+	assert.Equal(t, 0, events[7]["usage"].(map[string]any)["cache_read_input_tokens"])
+}
+
+func TestResponseTransformer_Pipeline(t *testing.T) {
+	t.Setenv("RESPONSE_TRANSFORMERS", " strip_thinking, normalise_stop_reason ,unknown,add_usage_metadata")
+	pipeline := NewConfiguredResponseTransformer()
+	assert.Equal(t, []string{TransformerStripThinking, TransformerNormaliseStopReason, TransformerAddUsageMetadata}, pipeline.Names())
+
+	events := transformStream(pipeline, thinkingStream())
+	require.Len(t, events, 6)
+	assert.Equal(t, 0, events[2]["index"])
+	final := events[4]
+	assert.Equal(t, "end_turn", final["delta"].(map[string]any)["stop_reason"])
+	assert.Equal(t, 0, final["usage"].(map[string]any)["cache_creation_input_tokens"])
+
+	resp := map[string]any{
+		"content":     []map[string]any{{"type": "thinking"}, {"type": "tool_use", "id": "t1"}},
+		"stop_reason": "tool_calls",
+		"usage":       map[string]any{"input_tokens": 1, "output_tokens": 2},
+	}
+	pipeline.TransformResponse(resp)
+	assert.Equal(t, []map[string]any{{"type": "tool_use", "id": "t1"}}, resp["content"])
+	assert.Equal(t, "tool_use", resp["stop_reason"])
+	assert.Contains(t, resp["usage"], "cache_read_input_tokens")
+}
+
+func TestNewTransformingSender_EmptyPipelineReturnsSender(t *testing.T) {
+	t.Setenv("RESPONSE_TRANSFORMERS", "")
+	sender := &recordingSender{}
+	assert.Same(t, sender, NewTransformingSender(sender, NewConfiguredResponseTransformer()))
+}
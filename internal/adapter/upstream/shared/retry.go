@@ -0,0 +1,69 @@
+package shared
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"kiro2api/config"
+	"kiro2api/types"
+)
+
+// RetryTokenSource 重试时切换token的数据源
+// 由AuthService实现：被Retry-After标记的token在冷却期内不会再被选中
+type RetryTokenSource interface {
+	GetToken() (types.TokenInfo, error)
+	MarkTokenRetryAfter(token types.TokenInfo, d time.Duration)
+}
+
+// SubsetTokenSource 支持只在指定 TokenID 子集中切换token（客户端密钥绑定的token池）
+type SubsetTokenSource interface {
+	GetTokenFrom(tokenIDs []string) (types.TokenInfo, error)
+}
+
+// ContextTokenSource 支持随请求取消排队等待的token来源，tokenIDs 为空时在全部token中选择
+type ContextTokenSource interface {
+	GetTokenContext(ctx context.Context, tokenIDs []string) (types.TokenInfo, error)
+}
+
+// ParseRetryAfter 解析Retry-After头，支持秒数与HTTP-date两种格式
+func ParseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if at, err := http.ParseTime(value); err == nil {
+		if d := at.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+
+	return 0, false
+}
+
+// RetryDelay 计算第attempt次重试（从0开始）前的等待时间
+// 存在Retry-After时直接使用其值，否则使用指数退避；两者均不超过MAX_RETRY_DELAY
+func RetryDelay(retryAfter string, attempt int, now time.Time) (time.Duration, bool) {
+	if d, ok := ParseRetryAfter(retryAfter, now); ok {
+		return capRetryDelay(d), true
+	}
+	return capRetryDelay(config.RetryDelay << attempt), false
+}
+
+func capRetryDelay(d time.Duration) time.Duration {
+	if maxDelay := config.MaxRetryDelay(); d > maxDelay || d < 0 {
+		return maxDelay
+	}
+	return d
+}
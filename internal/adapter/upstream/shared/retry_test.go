@@ -0,0 +1,192 @@
+package shared
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"kiro2api/config"
+	srvcontext "kiro2api/internal/adapter/httpapi/context"
+	"kiro2api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	d, ok := ParseRetryAfter("5", now)
+	assert.True(t, ok)
+	assert.Equal(t, 5*time.Second, d)
+
+	d, ok = ParseRetryAfter(now.Add(10*time.Second).Format(http.TimeFormat), now)
+	assert.True(t, ok)
+	assert.Equal(t, 10*time.Second, d)
+
+	_, ok = ParseRetryAfter("", now)
+	assert.False(t, ok)
+
+	_, ok = ParseRetryAfter("soon", now)
+	assert.False(t, ok)
+}
+
+func TestRetryDelay_UsesRetryAfter(t *testing.T) {
+	t.Setenv("MAX_RETRY_DELAY", "20")
+	now := time.Now()
+
+	delay, fromHeader := RetryDelay("3", 2, now)
+	assert.True(t, fromHeader)
+	assert.Equal(t, 3*time.Second, delay, "Retry-After应直接替代指数退避")
+
+	delay, fromHeader = RetryDelay("600", 0, now)
+	assert.True(t, fromHeader)
+	assert.Equal(t, 20*time.Second, delay, "Retry-After应受MAX_RETRY_DELAY约束")
+}
+
+func TestRetryDelay_ExponentialBackoff(t *testing.T) {
+	t.Setenv("MAX_RETRY_DELAY", "")
+	now := time.Now()
+
+	for attempt, expected := range []time.Duration{
+		config.RetryDelay,
+		2 * config.RetryDelay,
+		4 * config.RetryDelay,
+	} {
+		delay, fromHeader := RetryDelay("", attempt, now)
+		assert.False(t, fromHeader)
+		assert.Equal(t, expected, delay)
+	}
+
+	delay, _ := RetryDelay("", 20, now)
+	assert.Equal(t, config.DefaultMaxRetryDelay, delay)
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+type fakeRetryTokenSource struct {
+	next   types.TokenInfo
+	marked map[string]time.Duration
+}
+
+func (f *fakeRetryTokenSource) GetToken() (types.TokenInfo, error) {
+	return f.next, nil
+}
+
+func (f *fakeRetryTokenSource) MarkTokenRetryAfter(token types.TokenInfo, d time.Duration) {
+	f.marked[token.AccessToken] = d
+}
+
+func newRetryTestContext() *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	return c
+}
+
+func newRetryTestRequest() types.AnthropicRequest {
+	return types.AnthropicRequest{
+		Model:     "claude-sonnet-4",
+		MaxTokens: 100,
+		Messages:  []types.AnthropicRequestMessage{{Role: "user", Content: "hi"}},
+	}
+}
+
+func TestExecute_RetryAfterSwitchesToken(t *testing.T) {
+	var authHeaders []string
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		authHeaders = append(authHeaders, req.Header.Get("Authorization"))
+		if len(authHeaders) == 1 {
+			header := http.Header{}
+			header.Set("Retry-After", "3600")
+			return &http.Response{StatusCode: http.StatusTooManyRequests, Header: header, Body: io.NopCloser(strings.NewReader(""))}, nil
+		}
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(""))}, nil
+	})}
+
+	tokens := &fakeRetryTokenSource{
+		next:   types.TokenInfo{AccessToken: "second"},
+		marked: make(map[string]time.Duration),
+	}
+	rp := NewReverseProxy(client)
+	rp.stealthEnabled = false
+	rp.SetTokenSource(tokens)
+
+	start := time.Now()
+	resp, err := rp.Execute(newRetryTestContext(), newRetryTestRequest(), types.TokenInfo{AccessToken: "first"}, false)
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Less(t, time.Since(start), time.Second, "切换token时不应等待Retry-After")
+	assert.Equal(t, []string{"Bearer first", "Bearer second"}, authHeaders)
+	assert.Equal(t, time.Hour, tokens.marked["first"])
+}
+
+func TestExecute_BackoffWithoutRetryAfter(t *testing.T) {
+	var authHeaders []string
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		authHeaders = append(authHeaders, req.Header.Get("Authorization"))
+		if len(authHeaders) <= 2 {
+			return &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(""))}, nil
+		}
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(""))}, nil
+	})}
+
+	tokens := &fakeRetryTokenSource{
+		next:   types.TokenInfo{AccessToken: "second"},
+		marked: make(map[string]time.Duration),
+	}
+	rp := NewReverseProxy(client)
+	rp.stealthEnabled = false
+	rp.SetTokenSource(tokens)
+
+	start := time.Now()
+	resp, err := rp.Execute(newRetryTestContext(), newRetryTestRequest(), types.TokenInfo{AccessToken: "first"}, false)
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	assert.GreaterOrEqual(t, time.Since(start), config.RetryDelay+2*config.RetryDelay, "无Retry-After时应按指数退避等待")
+	assert.Equal(t, []string{"Bearer first", "Bearer first", "Bearer first"}, authHeaders)
+	assert.Empty(t, tokens.marked)
+}
+
+func TestExecute_RecordsRedactedUpstreamHeaders(t *testing.T) {
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		header := http.Header{}
+		header.Set("X-Amzn-Requestid", "upstream-rid")
+		header.Set("Set-Cookie", "AWSALB=affinity-cookie-value")
+		return &http.Response{StatusCode: http.StatusOK, Header: header, Body: io.NopCloser(strings.NewReader("")), Request: req}, nil
+	})}
+	rp := NewReverseProxy(client)
+	rp.stealthEnabled = false
+	rp.SetCircuitBreaker(nil)
+
+	t.Run("未启用时不记录", func(t *testing.T) {
+		t.Setenv("LOG_UPSTREAM_HEADERS", "")
+		c := newRetryTestContext()
+		_, err := rp.Execute(c, newRetryTestRequest(), types.TokenInfo{AccessToken: "access-token-secret"}, false)
+		require.NoError(t, err)
+		assert.Empty(t, srvcontext.GetUpstreamHeaders(c))
+	})
+
+	t.Run("启用后记录脱敏的请求头和响应头", func(t *testing.T) {
+		t.Setenv("LOG_UPSTREAM_HEADERS", "true")
+		c := newRetryTestContext()
+		_, err := rp.Execute(c, newRetryTestRequest(), types.TokenInfo{AccessToken: "access-token-secret"}, false)
+		require.NoError(t, err)
+
+		exchanges := srvcontext.GetUpstreamHeaders(c)
+		require.Len(t, exchanges, 1)
+		assert.Equal(t, http.StatusOK, exchanges[0].StatusCode)
+		assert.Equal(t, "Bearer a...", exchanges[0].RequestHeaders["Authorization"])
+		assert.Equal(t, "AWSALB=a...", exchanges[0].ResponseHeaders["Set-Cookie"])
+		assert.Equal(t, "upstream-rid", exchanges[0].ResponseHeaders["X-Amzn-Requestid"])
+	})
+}
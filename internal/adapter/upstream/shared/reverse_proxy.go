@@ -2,16 +2,22 @@ package shared
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"kiro2api/config"
 	"kiro2api/converter"
+	srvcontext "kiro2api/internal/adapter/httpapi/context"
 	logutil "kiro2api/internal/adapter/httpapi/logging"
 	"kiro2api/internal/adapter/httpapi/support"
+	"kiro2api/internal/audit"
+	"kiro2api/internal/stats"
+	"kiro2api/internal/websearch"
 	"kiro2api/logger"
 	"kiro2api/types"
 	"kiro2api/utils"
@@ -23,64 +29,371 @@ type ReverseProxy struct {
 	client         *http.Client
 	headers        *HeaderManager
 	stealthEnabled bool
+	tokens         RetryTokenSource
+	endpoints      *EndpointPool
+	breaker        *CircuitBreaker
+	perToken       bool
+	search         websearch.Provider
 }
 
+// NewReverseProxy 创建反向代理，client 为nil时使用共享客户端；启用 MOCK_UPSTREAM 时改用进程内模拟上游
 func NewReverseProxy(client *http.Client) *ReverseProxy {
 	if client == nil {
 		client = utils.SharedHTTPClient
+		if config.IsMockUpstreamEnabled() {
+			client = &http.Client{Transport: NewMockUpstreamTransport()}
+		}
 	}
 
-	return &ReverseProxy{
+	rp := &ReverseProxy{
 		client:         client,
 		headers:        NewHeaderManager(),
 		stealthEnabled: config.IsStealthModeEnabled(),
+		endpoints:      GetEndpointPool(),
+		perToken:       config.IsCircuitBreakerPerToken(),
+	}
+	if config.IsCircuitBreakerEnabled() {
+		rp.breaker = GetCircuitBreaker()
 	}
+	return rp
 }
 
+// SetTokenSource 设置429重试时用于切换token的数据源（为nil时仅在原token上重试）
+func (rp *ReverseProxy) SetTokenSource(tokens RetryTokenSource) {
+	rp.tokens = tokens
+}
+
+// SetEndpointPool 替换上游端点池（测试中用于注入本地模拟端点）
+func (rp *ReverseProxy) SetEndpointPool(endpoints *EndpointPool) {
+	rp.endpoints = endpoints
+}
+
+// SetCircuitBreaker 替换上游熔断器（为nil时不熔断，测试中用于隔离全局状态）
+func (rp *ReverseProxy) SetCircuitBreaker(breaker *CircuitBreaker) {
+	rp.breaker = breaker
+}
+
+// Execute 发送请求到上游并返回成功的响应；失败时已向客户端写入错误响应，并计入模型与租户的错误统计
+// 返回值约定：resp 与 err 不会同时非nil；返回错误时上游响应体已关闭，成功时由调用方负责关闭。
+// 上游请求绑定客户端请求的context，客户端断开后请求随之取消，不会继续占用连接；
+// 调用方通过 srvcontext.SetUpstreamContext 指定其他context时（见 CheckpointWriter.DetachUpstream）改用该context
+//
+// 配置了 MODEL_FALLBACKS 时，主模型滚动错误率过高、无法路由或上游报告模型不可用时按顺序改用回退模型，
+// 回退在上游接受请求之前完成，实际使用的模型通过 ServedModel 获取
+//
+// 流式请求经 ResolveWebSearch 启用本地搜索时，响应体在流内完成搜索往返（见 webSearchStream）
 func (rp *ReverseProxy) Execute(c *gin.Context, anthropicReq types.AnthropicRequest, tokenInfo types.TokenInfo, isStream bool) (*http.Response, error) {
-	req, err := rp.buildRequest(c, anthropicReq, tokenInfo, isStream)
-	if err != nil {
-		if _, ok := err.(*types.ModelNotFoundErrorType); ok {
+	if isStream && c.GetBool(streamingWebSearchKey) {
+		return rp.executeStreamingWebSearch(c, anthropicReq, tokenInfo)
+	}
+	return rp.executeWithFallback(c, anthropicReq, tokenInfo, isStream)
+}
+
+// executeWithFallback 按模型回退链发送请求，Execute 的主体
+func (rp *ReverseProxy) executeWithFallback(c *gin.Context, anthropicReq types.AnthropicRequest, tokenInfo types.TokenInfo, isStream bool) (*http.Response, error) {
+	resolveRecoveredTools(c, anthropicReq)
+
+	requested := anthropicReq.Model
+	chain := modelFallbackChain(requested)
+	current, reason := 0, ""
+	if len(chain) > 1 {
+		if current = firstHealthyModel(chain); current > 0 {
+			reason = FallbackReasonErrorRate
+		}
+	}
+
+	for {
+		for current < len(chain)-1 && isModelResolveError(chain[current]) {
+			current++
+			reason = FallbackReasonModelError
+		}
+		anthropicReq.Model = chain[current]
+		if current > 0 {
+			reportModelFallback(c, requested, anthropicReq.Model, reason)
+		}
+
+		resp, err := rp.execute(c, anthropicReq, tokenInfo, isStream, current < len(chain)-1)
+		var unavailable *modelUnavailableError
+		if errors.As(err, &unavailable) {
+			logger.Warn("上游报告模型不可用，尝试回退模型", logutil.AddFields(c, logger.Err(err))...)
+			current++
+			reason = FallbackReasonModelError
+			continue
+		}
+		if err != nil {
+			if resp != nil {
+				drainAndClose(resp.Body)
+				resp = nil
+			}
+			stats.GetCollector().RecordError(anthropicReq.Model, TenantID(c))
+		} else if current > 0 {
+			stats.GetModelUpstreamStats().RecordFallback(requested)
+		}
+		return resp, err
+	}
+}
+
+// drainAndClose 读取少量剩余响应体后关闭，使底层连接可以复用；剩余内容过多时直接关闭连接
+func drainAndClose(body io.ReadCloser) {
+	_, _ = io.Copy(io.Discard, io.LimitReader(body, config.UpstreamDrainLimit))
+	_ = body.Close()
+}
+
+// execute 发送一次请求（含429重试）；allowFallback 为true时上游报告模型不可用不写入错误响应，返回 modelUnavailableError
+func (rp *ReverseProxy) execute(c *gin.Context, anthropicReq types.AnthropicRequest, tokenInfo types.TokenInfo, isStream, allowFallback bool) (*http.Response, error) {
+	// 本地搜索往返已取得最终响应时直接复用，不再请求上游
+	if resp, ok := takePrefetchedResponse(c); ok {
+		return resp, nil
+	}
+
+	for attempt := 0; ; attempt++ {
+		endpoint := rp.endpoints.Select()
+		req, err := rp.buildRequest(c, endpoint, anthropicReq, tokenInfo, isStream)
+		if err != nil {
+			var violation *converter.HistoryViolationError
+			if _, ok := err.(*types.ModelNotFoundErrorType); ok || errors.As(err, &violation) || errors.Is(err, ErrUnknownProfile) {
+				return nil, err
+			}
+			support.HandleRequestBuildError(c, err)
 			return nil, err
 		}
-		support.HandleRequestBuildError(c, err)
-		return nil, err
+
+		if rp.stealthEnabled {
+			time.Sleep(rp.randomJitter())
+		}
+
+		circuitKey := CircuitKey(endpoint, tokenInfo, rp.perToken)
+		if rp.breaker != nil {
+			if retryAfter, ok := rp.breaker.Allow(circuitKey); !ok {
+				// 计入端点失败，使端点池仍能切换到备用端点
+				rp.endpoints.Record(endpoint, 0, true)
+				respondCircuitOpen(c, circuitKey, retryAfter)
+				return nil, ErrCircuitOpen
+			}
+		}
+
+		startTime := time.Now()
+		resp, err := rp.client.Do(req)
+		latency := time.Since(startTime)
+		recordUpstreamHeaders(c, attempt, endpoint, req, resp, err)
+		recordHeaderABResult(c, resp, err)
+		if err != nil {
+			// 客户端放弃请求导致的取消不计入端点与熔断失败，只释放熔断器的探测名额
+			if srvcontext.GetUpstreamContext(c).Err() == nil {
+				rp.endpoints.Record(endpoint, latency, true)
+				rp.recordCircuit(circuitKey, true)
+				stats.GetModelUpstreamStats().RecordResponse(anthropicReq.Model, 0, false, latency)
+			} else if rp.breaker != nil {
+				rp.breaker.Release(circuitKey)
+			}
+			support.HandleRequestSendError(c, err)
+			return nil, err
+		}
+		failed := resp.StatusCode >= http.StatusInternalServerError
+		rp.endpoints.Record(endpoint, latency, failed)
+		rp.recordCircuit(circuitKey, failed)
+		stats.GetLatencyTracker().Record(latencyEndpoint(c), latency)
+		modelReason := ""
+		if resp.StatusCode != http.StatusOK {
+			modelReason = upstreamModelError(resp)
+		}
+		stats.GetModelUpstreamStats().RecordResponse(anthropicReq.Model, resp.StatusCode, modelReason != "", latency)
+
+		// 模型容量不足等模型相关错误直接改用回退模型，不在同一模型上重试
+		if allowFallback && modelReason != "" {
+			drainAndClose(resp.Body)
+			return nil, &modelUnavailableError{model: anthropicReq.Model, status: resp.StatusCode, reason: modelReason}
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests && attempt < config.UpstreamMaxRetries {
+			nextToken, delay := rp.prepareRetry(c, resp, tokenInfo, attempt)
+			drainAndClose(resp.Body)
+
+			if delay > 0 {
+				select {
+				case <-time.After(delay):
+				case <-c.Request.Context().Done():
+					err := c.Request.Context().Err()
+					support.HandleRequestSendError(c, err)
+					return nil, err
+				}
+			}
+
+			tokenInfo = nextToken
+			continue
+		}
+
+		if rp.handleCodeWhispererError(c, resp) {
+			drainAndClose(resp.Body)
+			return nil, fmt.Errorf("CodeWhisperer API error")
+		}
+
+		logger.Debug("上游响应成功",
+			logutil.AddFields(c,
+				logger.String("direction", "upstream_response"),
+				logger.Int("status_code", resp.StatusCode),
+				logger.Int("attempt", attempt),
+				logger.String("upstream_endpoint", endpoint),
+			)...)
+
+		return resp, nil
 	}
+}
 
-	if rp.stealthEnabled {
-		time.Sleep(rp.randomJitter())
+// recordUpstreamHeaders LOG_UPSTREAM_HEADERS 启用时把本次上游请求的请求头和响应头（敏感请求头已脱敏）记录到gin上下文
+func recordUpstreamHeaders(c *gin.Context, attempt int, endpoint string, req *http.Request, resp *http.Response, err error) {
+	if !config.IsUpstreamHeaderLoggingEnabled() {
+		return
 	}
 
-	resp, err := rp.client.Do(req)
+	redact := config.RedactedHeaders()
+	exchange := audit.HeaderExchange{
+		Attempt:        attempt,
+		Endpoint:       endpoint,
+		RequestHeaders: audit.RedactHeaders(req.Header, redact),
+	}
 	if err != nil {
-		support.HandleRequestSendError(c, err)
-		return nil, err
+		exchange.Error = err.Error()
+	} else {
+		exchange.StatusCode = resp.StatusCode
+		exchange.ResponseHeaders = audit.RedactHeaders(resp.Header, redact)
 	}
+	srvcontext.AddUpstreamHeaders(c, exchange)
+}
+
+// ErrCircuitOpen 上游熔断打开，请求未发送
+var ErrCircuitOpen = errors.New("上游熔断已打开")
 
-	if rp.handleCodeWhispererError(c, resp) {
-		resp.Body.Close()
-		return nil, fmt.Errorf("CodeWhisperer API error")
+// recordHeaderABResult 策略由A/B测试分配时，按策略记录本次上游请求的结果；客户端主动取消不计入
+func recordHeaderABResult(c *gin.Context, resp *http.Response, err error) {
+	applied, ok := srvcontext.GetAppliedHeaders(c)
+	if !ok || !applied.ABTest {
+		return
 	}
+	if err != nil {
+		if c.Request.Context().Err() == nil {
+			stats.GetHeaderABStats().Record(applied.Strategy, 0)
+		}
+		return
+	}
+	stats.GetHeaderABStats().Record(applied.Strategy, resp.StatusCode)
+}
 
-	logger.Debug("上游响应成功",
+// recordCircuit 向熔断器报告请求结果（未启用熔断时忽略）
+func (rp *ReverseProxy) recordCircuit(key string, failed bool) {
+	if rp.breaker != nil {
+		rp.breaker.Record(key, failed)
+	}
+}
+
+// respondCircuitOpen 熔断打开时立即返回503 overloaded_error，并通过 Retry-After 告知冷却剩余时间
+func respondCircuitOpen(c *gin.Context, key string, retryAfter time.Duration) {
+	seconds := retryAfterSeconds(retryAfter)
+	logger.Warn("上游熔断中，拒绝请求",
 		logutil.AddFields(c,
-			logger.String("direction", "upstream_response"),
-			logger.Int("status_code", resp.StatusCode),
+			logger.String("circuit", key),
+			logger.Int("retry_after", seconds),
 		)...)
 
-	return resp, nil
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	if support.IsOpenAIRoute(c) {
+		support.RespondErrorWithCode(c, http.StatusServiceUnavailable, "circuit_open", "上游暂时不可用（熔断中），请在 %d 秒后重试", seconds)
+		return
+	}
+	c.JSON(http.StatusServiceUnavailable, gin.H{
+		"type": "error",
+		"error": gin.H{
+			"type":    "overloaded_error",
+			"message": fmt.Sprintf("上游暂时不可用（熔断中），请在 %d 秒后重试", seconds),
+		},
+	})
+}
+
+// nextToken 切换token，客户端密钥绑定了token子集时只在子集中选择；客户端断开时不再排队等待
+func (rp *ReverseProxy) nextToken(c *gin.Context) (types.TokenInfo, error) {
+	if source, ok := rp.tokens.(ContextTokenSource); ok {
+		var tokenIDs []string
+		if key, ok := srvcontext.GetClientKey(c); ok {
+			tokenIDs = key.Tokens
+		}
+		return source.GetTokenContext(c.Request.Context(), tokenIDs)
+	}
+	if subset, ok := rp.tokens.(SubsetTokenSource); ok {
+		if key, ok := srvcontext.GetClientKey(c); ok && len(key.Tokens) > 0 {
+			return subset.GetTokenFrom(key.Tokens)
+		}
+	}
+	return rp.tokens.GetToken()
 }
 
-func (rp *ReverseProxy) buildRequest(c *gin.Context, anthropicReq types.AnthropicRequest, tokenInfo types.TokenInfo, isStream bool) (*http.Request, error) {
-	cwReq, err := converter.BuildCodeWhispererRequest(anthropicReq, c)
+// prepareRetry 根据429响应决定下一次尝试使用的token和等待时间
+// - 有Retry-After：标记当前token冷却，若池中有其他可用token则立即切换，否则等待Retry-After（受上限约束）
+// - 无Retry-After：在当前token上按指数退避重试
+func (rp *ReverseProxy) prepareRetry(c *gin.Context, resp *http.Response, current types.TokenInfo, attempt int) (types.TokenInfo, time.Duration) {
+	retryAfterHeader := resp.Header.Get("Retry-After")
+	delay, fromHeader := RetryDelay(retryAfterHeader, attempt, time.Now())
+
+	if fromHeader && rp.tokens != nil {
+		retryAfter, _ := ParseRetryAfter(retryAfterHeader, time.Now())
+		rp.tokens.MarkTokenRetryAfter(current, retryAfter)
+
+		if next, err := rp.nextToken(c); err == nil && next.AccessToken != current.AccessToken {
+			logger.Warn("上游限流，切换到下一个token重试",
+				logutil.AddFields(c,
+					logger.Int("attempt", attempt+1),
+					logger.String("retry_after", retryAfterHeader),
+				)...)
+			return next, 0
+		}
+	}
+
+	logger.Warn("上游限流，等待后重试",
+		logutil.AddFields(c,
+			logger.Int("attempt", attempt+1),
+			logger.String("retry_after", retryAfterHeader),
+			logger.Bool("from_retry_after", fromHeader),
+			logger.Duration("delay", delay),
+		)...)
+
+	return current, delay
+}
+
+func (rp *ReverseProxy) buildRequest(c *gin.Context, endpoint string, anthropicReq types.AnthropicRequest, tokenInfo types.TokenInfo, isStream bool) (*http.Request, error) {
+	profileArn, err := ActiveProfileSelector().Select(anthropicReq.Model, srvcontext.GetHeaderOverrides(c).Profile, tokenInfo)
+	if err != nil {
+		support.RespondErrorWithCode(c, http.StatusBadRequest, "invalid_header", "请求头 %s 无效: %v", config.ProfileHeader, err)
+		return nil, err
+	}
+
+	var adjustments []converter.HistoryAdjustment
+	var warnings converter.Warnings
+	cwReq, err := converter.BuildCodeWhispererRequest(anthropicReq, c,
+		converter.WithSystemPromptInjection(SystemPromptInjection(c)),
+		converter.WithHistoryAdjustments(&adjustments),
+		converter.WithWarnings(&warnings),
+		converter.WithProfileArn(profileArn))
 	if err != nil {
+		var violation *converter.HistoryViolationError
+		if errors.As(err, &violation) {
+			respondHistoryViolation(c, violation)
+			return nil, err
+		}
 		if modelNotFoundErr, ok := err.(*types.ModelNotFoundErrorType); ok {
+			if support.IsOpenAIRoute(c) {
+				detail := modelNotFoundErr.ErrorData.Error
+				support.RespondErrorWithCode(c, http.StatusBadRequest, detail.Code, "%s", detail.Message)
+				return nil, err
+			}
 			c.JSON(http.StatusBadRequest, modelNotFoundErr.ErrorData)
 			return nil, err
 		}
 		return nil, fmt.Errorf("构建CodeWhisperer请求失败: %v", err)
 	}
 
+	srvcontext.SetConversationID(c, cwReq.ConversationState.ConversationId)
+	reportHistoryAdjustments(c, adjustments)
+	reportWarnings(c, warnings)
+
 	cwReqBody, err := converter.MarshalCodeWhispererRequest(cwReq)
 	if err != nil {
 		return nil, fmt.Errorf("序列化请求失败: %v", err)
@@ -104,16 +417,13 @@ func (rp *ReverseProxy) buildRequest(c *gin.Context, anthropicReq types.Anthropi
 		logger.Int("tools_count", len(cwReq.ConversationState.CurrentMessage.UserInputMessage.UserInputMessageContext.Tools)),
 		logger.String("tools_names", toolNamesPreview))
 
-	req, err := http.NewRequest("POST", config.CodeWhispererURL, bytes.NewReader(cwReqBody))
+	// 默认绑定客户端请求的context：客户端断开或请求被放弃时取消上游请求并释放连接
+	req, err := http.NewRequestWithContext(srvcontext.GetUpstreamContext(c), http.MethodPost, endpoint+config.CodeWhispererPath, bytes.NewReader(cwReqBody))
 	if err != nil {
 		return nil, fmt.Errorf("创建请求失败: %v", err)
 	}
 
 	req.Header.Set("Authorization", "Bearer "+tokenInfo.AccessToken)
-	req.Header.Set("Content-Type", "application/json")
-	if isStream {
-		req.Header.Set("Accept", "text/event-stream")
-	}
 
 	// 使用 refreshToken 作为稳定的标识符（同一个 token 在一段时间内保持一致的用户画像）
 	// refreshToken 是唯一且稳定的，适合作为用户标识
@@ -123,11 +433,48 @@ func (rp *ReverseProxy) buildRequest(c *gin.Context, anthropicReq types.Anthropi
 		tokenIdentifier = tokenInfo.AccessToken
 	}
 	
-	rp.headers.Apply(req, isStream, tokenIdentifier)
+	overrides := srvcontext.GetHeaderOverrides(c)
+	opts := HeaderOptions{Strategy: overrides.Strategy, AgentMode: overrides.AgentMode}
+	// 管理员的单次覆盖优先于A/B测试分配的策略
+	abTest := false
+	if opts.Strategy == "" {
+		if test, ok := activeHeaderABTest(); ok {
+			_, opts.Strategy = test.Assign(c.ClientIP())
+			abTest = true
+		}
+	}
+	applied := rp.headers.Apply(req, isStream, tokenIdentifier, srvcontext.GetRequestID(c), opts)
+	srvcontext.SetAppliedHeaders(c, srvcontext.AppliedHeaders{
+		Strategy:  applied.Strategy,
+		AgentMode: applied.AgentMode,
+		ABTest:    abTest,
+	})
+	applyTraceContext(c, req)
 
 	return req, nil
 }
 
+// applyTraceContext 把客户端的追踪上下文传递给上游，每次上游调用（含重试）使用新的子span
+// 客户端未携带 traceparent 时不向上游发送本服务新建的追踪，上游请求头与未启用追踪时一致
+func applyTraceContext(c *gin.Context, req *http.Request) {
+	tc, ok := srvcontext.GetTraceContext(c)
+	if !ok || !tc.FromClient() {
+		return
+	}
+	req.Header.Set(config.TraceParentHeader, tc.ChildTraceParent())
+	if tc.TraceState != "" {
+		req.Header.Set(config.TraceStateHeader, tc.TraceState)
+	}
+}
+
+// latencyEndpoint 返回延迟统计使用的端点名（优先使用路由模板）
+func latencyEndpoint(c *gin.Context) string {
+	if path := c.FullPath(); path != "" {
+		return path
+	}
+	return c.Request.URL.Path
+}
+
 func (rp *ReverseProxy) randomJitter() time.Duration {
 	base := utils.RandomIntBetween(5, 50)
 	return time.Duration(base) * time.Millisecond
@@ -163,6 +510,14 @@ func (rp *ReverseProxy) handleCodeWhispererError(c *gin.Context, resp *http.Resp
 		return true
 	}
 
+	if resp.StatusCode == http.StatusTooManyRequests {
+		if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" {
+			c.Header("Retry-After", retryAfter)
+		}
+		support.RespondError(c, http.StatusTooManyRequests, "%s", "上游限流，重试次数已用尽，请稍后再试")
+		return true
+	}
+
 	errorMapper := NewErrorMapper()
 	claudeError := errorMapper.MapCodeWhispererError(resp.StatusCode, body)
 
@@ -185,9 +540,13 @@ func FilterSupportedTools(tools []types.AnthropicTool) []types.AnthropicTool {
 		return tools
 	}
 
+	if config.WebSearchMode() == config.WebSearchModeLocal {
+		return tools
+	}
+
 	filtered := make([]types.AnthropicTool, 0, len(tools))
 	for _, tool := range tools {
-		if tool.Name == "web_search" || tool.Name == "websearch" {
+		if converter.IsWebSearchTool(tool.Name) {
 			logger.Debug("过滤不支持的工具（token计算）",
 				logger.String("tool_name", tool.Name))
 			continue
@@ -198,6 +557,9 @@ func FilterSupportedTools(tools []types.AnthropicTool) []types.AnthropicTool {
 	return filtered
 }
 
+// StreamEventSender 向客户端写出SSE事件
+// SendEvent 只写入不刷新，刷新时机由调用方决定（FlushBatcher 批量刷新，初始事件和结束事件立即刷新）；
+// SendError 发送终止流的错误事件并立即刷新
 type StreamEventSender interface {
 	SendEvent(c *gin.Context, data any) error
 	SendError(c *gin.Context, message string, err error) error
@@ -229,7 +591,9 @@ func (s *AnthropicStreamSender) SendEvent(c *gin.Context, data any) error {
 
 	fmt.Fprintf(c.Writer, "event: %s\n", eventType)
 	fmt.Fprintf(c.Writer, "data: %s\n\n", string(json))
-	c.Writer.Flush()
+	if eventType == "message_stop" {
+		srvcontext.MarkStreamEnded(c)
+	}
 	return nil
 }
 
@@ -247,7 +611,11 @@ func (s *AnthropicStreamSender) SendError(c *gin.Context, message string, err er
 		},
 	}
 
-	return s.SendEvent(c, errorEvent)
+	if sendErr := s.SendEvent(c, errorEvent); sendErr != nil {
+		return sendErr
+	}
+	c.Writer.Flush()
+	return nil
 }
 
 func (s *OpenAIStreamSender) SendEvent(c *gin.Context, data any) error {
@@ -262,7 +630,6 @@ func (s *OpenAIStreamSender) SendEvent(c *gin.Context, data any) error {
 		)...)
 
 	fmt.Fprintf(c.Writer, "data: %s\n\n", string(json))
-	c.Writer.Flush()
 	return nil
 }
 
@@ -274,5 +641,60 @@ func (s *OpenAIStreamSender) SendError(c *gin.Context, message string, err error
 		},
 	}
 
-	return s.SendEvent(c, errorEvent)
+	if sendErr := s.SendEvent(c, errorEvent); sendErr != nil {
+		return sendErr
+	}
+	c.Writer.Flush()
+	return nil
+}
+
+// EstimateInputTokens 按发往上游的请求（过滤不支持的工具后）估算输入token数
+func EstimateInputTokens(req types.AnthropicRequest) int {
+	return utils.NewTokenEstimatorForModel(req.Model).EstimateTokens(&types.CountTokensRequest{
+		Model:    req.Model,
+		System:   req.System,
+		Messages: req.Messages,
+		Tools:    FilterSupportedTools(req.Tools),
+	})
+}
+
+// EstimateRequestInputTokens 按本次请求实际发往上游的内容（含服务端注入的系统提示）估算输入token数
+func EstimateRequestInputTokens(c *gin.Context, req types.AnthropicRequest) int {
+	req.System = SystemPromptInjection(c).Apply(req.System)
+	return EstimateInputTokens(req)
+}
+
+// SystemPromptInjection 返回本次请求使用的系统提示注入；管理员通过 X-Kiro-No-Inject 跳过时为空
+func SystemPromptInjection(c *gin.Context) converter.SystemPromptInjection {
+	if srvcontext.GetHeaderOverrides(c).NoInject {
+		return converter.SystemPromptInjection{}
+	}
+	return converter.ActiveSystemPromptInjection()
+}
+
+// RequestInputTokens 返回处理器记录的输入token数，保证 message_start 与最终 usage 使用同一个值
+// 未记录时（如直接调用上游处理流程）按请求现场估算
+func RequestInputTokens(c *gin.Context, req types.AnthropicRequest) int {
+	if tokens, ok := srvcontext.GetInputTokens(c); ok {
+		return tokens
+	}
+	return EstimateRequestInputTokens(c, req)
+}
+
+// RecordTokenUsage 记录一次成功请求的token用量：计入模型与租户统计，并保存到请求上下文供客户端配额结算
+func RecordTokenUsage(c *gin.Context, inputTokens, outputTokens int, model string) {
+	stats.GetCollector().Record(inputTokens, outputTokens, model, TenantID(c))
+	srvcontext.SetTokenUsage(c, srvcontext.TokenUsage{InputTokens: inputTokens, OutputTokens: outputTokens})
+}
+
+// TenantID 返回请求的租户标签（X-Tenant-ID 请求头），未携带时为 config.DefaultTenantID
+func TenantID(c *gin.Context) string {
+	tenant := strings.TrimSpace(c.GetHeader(config.TenantIDHeader))
+	if tenant == "" {
+		return config.DefaultTenantID
+	}
+	if len(tenant) > config.TenantIDMaxLength {
+		tenant = strings.ToValidUTF8(tenant[:config.TenantIDMaxLength], "")
+	}
+	return tenant
 }
@@ -0,0 +1,269 @@
+package shared
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"kiro2api/config"
+	srvcontext "kiro2api/internal/adapter/httpapi/context"
+	"kiro2api/internal/stats"
+	"kiro2api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTenantID(t *testing.T) {
+	c := newRetryTestContext()
+	assert.Equal(t, config.DefaultTenantID, TenantID(c))
+
+	c.Request.Header.Set("X-Tenant-ID", "  team-a ")
+	assert.Equal(t, "team-a", TenantID(c))
+
+	c.Request.Header.Set("X-Tenant-ID", strings.Repeat("租", 30))
+	tenant := TenantID(c)
+	assert.LessOrEqual(t, len(tenant), config.TenantIDMaxLength)
+	assert.Equal(t, strings.Repeat("租", config.TenantIDMaxLength/len("租")), tenant, "截断不应切断多字节字符")
+}
+
+func TestExecute_RecordsErrorForModelAndTenant(t *testing.T) {
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusBadRequest, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(`{"message":"bad"}`))}, nil
+	})}
+	rp := NewReverseProxy(client)
+	rp.stealthEnabled = false
+	rp.SetEndpointPool(NewEndpointPool(nil, config.DefaultUpstreamHealthWindow, 50, config.DefaultUpstreamProbeInterval))
+
+	c := newRetryTestContext()
+	c.Request.Header.Set("X-Tenant-ID", "tenant-execute-error")
+
+	_, err := rp.Execute(c, newRetryTestRequest(), types.TokenInfo{AccessToken: "token"}, false)
+	require.Error(t, err)
+
+	tenant := stats.GetCollector().TenantStats()["tenant-execute-error"]
+	assert.Equal(t, int64(1), tenant.RequestsTotal)
+	assert.Equal(t, int64(1), tenant.ErrorsTotal)
+	assert.Equal(t, 1.0, tenant.Models["claude-sonnet-4"].ErrorRate)
+}
+
+func TestHandleCodeWhispererError_OpenAIErrorBody(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		expected string
+	}{
+		{
+			name:     "429 限流",
+			status:   http.StatusTooManyRequests,
+			expected: `{"error":{"message":"上游限流，重试次数已用尽，请稍后再试 (request id: req_test)","type":"rate_limit_error","param":null,"code":"rate_limit_exceeded"}}`,
+		},
+		{
+			name:     "500 上游错误",
+			status:   http.StatusInternalServerError,
+			body:     `{"message":"boom"}`,
+			expected: `{"error":{"message":"CodeWhisperer Error: {\"message\":\"boom\"} (request id: req_test)","type":"server_error","param":null,"code":"cw_error"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+			srvcontext.SetRequestID(c, "req_test")
+
+			resp := &http.Response{StatusCode: tt.status, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(tt.body))}
+			require.True(t, NewReverseProxy(nil).handleCodeWhispererError(c, resp))
+
+			assert.Equal(t, tt.status, w.Code)
+			assert.JSONEq(t, tt.expected, w.Body.String())
+		})
+	}
+}
+
+func TestEstimateRequestInputTokens_IncludesSystemPromptInjection(t *testing.T) {
+	t.Setenv("SYSTEM_PROMPT_PREFIX", strings.Repeat("Follow the organisation safety policy. ", 20))
+	req := newRetryTestRequest()
+
+	c := newRetryTestContext()
+	assert.Greater(t, EstimateRequestInputTokens(c, req), EstimateInputTokens(req), "注入内容计入输入token")
+	assert.Empty(t, req.System, "原请求不被修改")
+
+	// 管理员跳过注入时按客户端请求估算
+	srvcontext.SetHeaderOverrides(c, srvcontext.HeaderOverrides{NoInject: true})
+	assert.True(t, SystemPromptInjection(c).Empty())
+	assert.Equal(t, EstimateInputTokens(req), EstimateRequestInputTokens(c, req))
+}
+
+// newConnCountingUpstream 启动本地上游并统计建立过的连接数与当前未关闭的连接数
+func newConnCountingUpstream(t *testing.T, handler http.HandlerFunc) (*httptest.Server, func() (opened, open int64)) {
+	t.Helper()
+	var opened, open atomic.Int64
+	server := httptest.NewUnstartedServer(handler)
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			opened.Add(1)
+			open.Add(1)
+		case http.StateClosed, http.StateHijacked:
+			open.Add(-1)
+		}
+	}
+	server.Start()
+	t.Cleanup(server.Close)
+	return server, func() (int64, int64) { return opened.Load(), open.Load() }
+}
+
+func newLocalReverseProxy(endpoint string, transport *http.Transport) *ReverseProxy {
+	rp := NewReverseProxy(&http.Client{Transport: transport})
+	rp.stealthEnabled = false
+	rp.SetCircuitBreaker(nil)
+	rp.SetEndpointPool(NewEndpointPool([]string{endpoint}, time.Minute, 50, time.Minute))
+	return rp
+}
+
+func TestExecute_FailingRequestsDoNotLeakConnections(t *testing.T) {
+	body := strings.Repeat("x", 4096)
+	server, conns := newConnCountingUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		if strings.HasSuffix(r.Header.Get("Authorization"), "limited") {
+			// Retry-After: 0 立即重试，覆盖429重试路径上丢弃响应体的逻辑
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		} else {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		_, _ = io.WriteString(w, body)
+	})
+
+	transport := &http.Transport{MaxIdleConnsPerHost: 2}
+	defer transport.CloseIdleConnections()
+	rp := newLocalReverseProxy(server.URL, transport)
+
+	const requests = 1000
+	for i := 0; i < requests; i++ {
+		token := types.TokenInfo{AccessToken: "token"}
+		if i%2 == 1 {
+			token.AccessToken = "token-limited"
+		}
+		resp, err := rp.Execute(newRetryTestContext(), newRetryTestRequest(), token, false)
+		require.Error(t, err)
+		require.Nil(t, resp, "返回错误时不应同时返回响应")
+	}
+
+	// 响应体被读完并关闭，连接回到连接池复用，而不是每次请求新建连接
+	opened, open := conns()
+	assert.LessOrEqual(t, opened, int64(4), "失败请求不应泄漏连接")
+	assert.LessOrEqual(t, open, int64(2))
+}
+
+func TestExecute_ClientCancellationAbortsUpstreamRequest(t *testing.T) {
+	upstreamCanceled := make(chan struct{})
+	server, conns := newConnCountingUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		// 读完请求体后服务端才会检测到连接关闭
+		_, _ = io.Copy(io.Discard, r.Body)
+		<-r.Context().Done()
+		close(upstreamCanceled)
+	})
+
+	transport := &http.Transport{}
+	defer transport.CloseIdleConnections()
+	rp := newLocalReverseProxy(server.URL, transport)
+	breaker := NewCircuitBreaker(CircuitSettings{Threshold: 1, Window: time.Minute, Cooldown: time.Minute, HalfOpenProbes: 1})
+	rp.SetCircuitBreaker(breaker)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := newRetryTestContext()
+	c.Request = c.Request.WithContext(ctx)
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	resp, err := rp.Execute(c, newRetryTestRequest(), types.TokenInfo{AccessToken: "token"}, false)
+	require.ErrorIs(t, err, context.Canceled)
+	assert.Nil(t, resp)
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	select {
+	case <-upstreamCanceled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("客户端取消后上游请求仍未结束")
+	}
+	require.Eventually(t, func() bool {
+		_, open := conns()
+		return open == 0
+	}, 5*time.Second, 10*time.Millisecond, "取消的请求不应继续占用连接")
+
+	// 客户端主动取消不计入熔断失败
+	_, allowed := breaker.Allow(CircuitKey(server.URL, types.TokenInfo{AccessToken: "token"}, false))
+	assert.True(t, allowed)
+}
+
+func TestExecute_PropagatesTraceContextWithChildSpans(t *testing.T) {
+	var traceParents, traceStates []string
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		traceParents = append(traceParents, req.Header.Get("traceparent"))
+		traceStates = append(traceStates, req.Header.Get("tracestate"))
+		if len(traceParents) == 1 {
+			header := http.Header{}
+			header.Set("Retry-After", "3600")
+			return &http.Response{StatusCode: http.StatusTooManyRequests, Header: header, Body: io.NopCloser(strings.NewReader(""))}, nil
+		}
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(""))}, nil
+	})}
+	rp := NewReverseProxy(client)
+	rp.stealthEnabled = false
+	rp.SetTokenSource(&fakeRetryTokenSource{next: types.TokenInfo{AccessToken: "second"}, marked: make(map[string]time.Duration)})
+
+	c := newRetryTestContext()
+	tc, ok := srvcontext.ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.True(t, ok)
+	tc.SpanID = srvcontext.NewSpanID()
+	tc.TraceState = "vendor=opaque"
+	srvcontext.SetTraceContext(c, tc)
+
+	resp, err := rp.Execute(c, newRetryTestRequest(), types.TokenInfo{AccessToken: "first"}, false)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	// 每次上游调用（含429重试）沿用追踪ID，使用不同的子span
+	require.Len(t, traceParents, 2)
+	spans := map[string]bool{tc.SpanID: true, tc.ParentSpanID: true}
+	for _, traceParent := range traceParents {
+		child, ok := srvcontext.ParseTraceParent(traceParent)
+		require.True(t, ok, traceParent)
+		assert.Equal(t, tc.TraceID, child.TraceID)
+		assert.Equal(t, "01", child.Flags)
+		assert.False(t, spans[child.ParentSpanID], "子span不应重复")
+		spans[child.ParentSpanID] = true
+	}
+	assert.Equal(t, []string{"vendor=opaque", "vendor=opaque"}, traceStates)
+}
+
+func TestExecute_DoesNotSendServerCreatedTraceContext(t *testing.T) {
+	var header http.Header
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		header = req.Header.Clone()
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(""))}, nil
+	})}
+	rp := NewReverseProxy(client)
+	rp.stealthEnabled = false
+
+	c := newRetryTestContext()
+	srvcontext.SetTraceContext(c, srvcontext.NewTraceContext())
+
+	resp, err := rp.Execute(c, newRetryTestRequest(), types.TokenInfo{AccessToken: "token"}, false)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Empty(t, header.Get("traceparent"), "客户端未携带 traceparent 时不发送")
+	assert.Empty(t, header.Get("tracestate"))
+}
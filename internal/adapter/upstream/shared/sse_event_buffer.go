@@ -0,0 +1,162 @@
+package shared
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	// ErrSSEOffsetEvicted 请求的字节偏移早于缓冲区保留的最早事件
+	ErrSSEOffsetEvicted = errors.New("SSE偏移对应的事件已被淘汰")
+	// ErrSSEOffsetOutOfRange 请求的字节偏移超过已写入的总字节数
+	ErrSSEOffsetOutOfRange = errors.New("SSE偏移超出已发送范围")
+)
+
+// bufferedSSEEvent 缓冲区中的一个原始SSE事件
+type bufferedSSEEvent struct {
+	id     int64  // 事件序号，从1开始
+	offset int64  // 事件首字节在整个流中的偏移
+	data   []byte // 原始SSE字节（含结尾空行）
+}
+
+// SSEEventBuffer 按发送顺序保存最近的原始SSE事件及其字节偏移，供重连时按字节位置重放
+// 总字节数超过 capacity 时从最早的事件开始整条淘汰，单个事件超过容量时只保留该事件
+type SSEEventBuffer struct {
+	mutex    sync.Mutex
+	capacity int
+	events   []bufferedSSEEvent // 环形存储，head 为最早的事件
+	head     int
+	count    int
+	size     int   // 当前保留的字节数
+	total    int64 // 流开始以来写入的总字节数
+	nextID   int64
+}
+
+// NewSSEEventBuffer 创建SSE事件缓冲区，capacity 为最多保留的字节数
+func NewSSEEventBuffer(capacity int) *SSEEventBuffer {
+	return &SSEEventBuffer{
+		capacity: capacity,
+		events:   make([]bufferedSSEEvent, 8),
+		nextID:   1,
+	}
+}
+
+// Append 追加一个已发送给客户端的原始SSE事件，返回事件序号和事件首字节的偏移
+func (b *SSEEventBuffer) Append(raw []byte) (id int64, offset int64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	event := bufferedSSEEvent{
+		id:     b.nextID,
+		offset: b.total,
+		data:   append([]byte(nil), raw...),
+	}
+	b.nextID++
+	b.total += int64(len(raw))
+
+	if b.count == len(b.events) {
+		b.grow()
+	}
+	b.events[(b.head+b.count)%len(b.events)] = event
+	b.count++
+	b.size += len(raw)
+
+	for b.count > 1 && b.size > b.capacity {
+		oldest := &b.events[b.head]
+		b.size -= len(oldest.data)
+		*oldest = bufferedSSEEvent{}
+		b.head = (b.head + 1) % len(b.events)
+		b.count--
+	}
+	return event.id, event.offset
+}
+
+// ResumeFromOffset 返回从 offset 开始到当前为止的原始字节，offset 可以落在事件中间
+// offset 等于已写入总字节数时返回空切片
+func (b *SSEEventBuffer) ResumeFromOffset(offset int64) ([]byte, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if offset < 0 || offset > b.total {
+		return nil, fmt.Errorf("%w: offset=%d, total=%d", ErrSSEOffsetOutOfRange, offset, b.total)
+	}
+	if b.count > 0 && offset < b.events[b.head].offset {
+		return nil, fmt.Errorf("%w: offset=%d, earliest=%d", ErrSSEOffsetEvicted, offset, b.events[b.head].offset)
+	}
+
+	result := make([]byte, 0, b.total-offset)
+	for i := 0; i < b.count; i++ {
+		event := b.events[(b.head+i)%len(b.events)]
+		end := event.offset + int64(len(event.data))
+		if end <= offset {
+			continue
+		}
+		start := max(offset-event.offset, 0)
+		result = append(result, event.data[start:]...)
+	}
+	return result, nil
+}
+
+// TotalBytes 返回流开始以来写入的总字节数，即下一个事件的起始偏移
+func (b *SSEEventBuffer) TotalBytes() int64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.total
+}
+
+// EarliestOffset 返回缓冲区保留的最早事件的起始偏移，缓冲区为空时为已写入的总字节数
+func (b *SSEEventBuffer) EarliestOffset() int64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.count == 0 {
+		return b.total
+	}
+	return b.events[b.head].offset
+}
+
+// grow 环形存储已满时按两倍扩容，并把事件按顺序搬到开头
+func (b *SSEEventBuffer) grow() {
+	events := make([]bufferedSSEEvent, len(b.events)*2)
+	for i := 0; i < b.count; i++ {
+		events[i] = b.events[(b.head+i)%len(b.events)]
+	}
+	b.events = events
+	b.head = 0
+}
+
+// ownedSSEBuffer 登记中的事件缓冲区及其创建者
+type ownedSSEBuffer struct {
+	owner  string // 创建者客户端密钥名称的摘要
+	buffer *SSEEventBuffer
+}
+
+// sseBuffers 启用检查点的流的事件缓冲区，按检查点ID索引；客户端收到 message_stop 后或流结束 CHECKPOINT_TTL 后移除
+var sseBuffers = struct {
+	sync.Mutex
+	byID map[string]ownedSSEBuffer
+}{byID: make(map[string]ownedSSEBuffer)}
+
+// registerSSEBuffer 为检查点ID登记新的事件缓冲区
+func registerSSEBuffer(id, owner string, capacity int) *SSEEventBuffer {
+	buffer := NewSSEEventBuffer(capacity)
+	sseBuffers.Lock()
+	defer sseBuffers.Unlock()
+	sseBuffers.byID[id] = ownedSSEBuffer{owner: checkpointOwnerDigest(owner), buffer: buffer}
+	return buffer
+}
+
+// lookupSSEBuffer 返回检查点ID对应的事件缓冲区
+func lookupSSEBuffer(id string) (ownedSSEBuffer, bool) {
+	sseBuffers.Lock()
+	defer sseBuffers.Unlock()
+	entry, ok := sseBuffers.byID[id]
+	return entry, ok
+}
+
+// releaseSSEBuffer 移除检查点ID对应的事件缓冲区
+func releaseSSEBuffer(id string) {
+	sseBuffers.Lock()
+	defer sseBuffers.Unlock()
+	delete(sseBuffers.byID, id)
+}
@@ -0,0 +1,73 @@
+package shared
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sseEvent(eventType, data string) []byte {
+	return []byte(fmt.Sprintf("event: %s\ndata: %s\n\n", eventType, data))
+}
+
+func TestSSEEventBuffer_ResumeFromOffset(t *testing.T) {
+	b := NewSSEEventBuffer(1 << 20)
+	var stream strings.Builder
+	var offsets []int64
+	for i := 0; i < 20; i++ {
+		raw := sseEvent("content_block_delta", fmt.Sprintf(`{"index":0,"delta":{"type":"text_delta","text":"第%d段"}}`, i))
+		id, offset := b.Append(raw)
+		assert.Equal(t, int64(i+1), id)
+		assert.Equal(t, int64(stream.Len()), offset)
+		offsets = append(offsets, offset)
+		stream.Write(raw)
+	}
+	full := stream.String()
+	require.Equal(t, int64(len(full)), b.TotalBytes())
+
+	// 事件边界、事件中间（含多字节字符内部）和末尾都按字节精确重放
+	for _, offset := range []int64{0, offsets[7], offsets[7] + 5, offsets[19] - 1, int64(len(full))} {
+		replay, err := b.ResumeFromOffset(offset)
+		require.NoError(t, err, "offset=%d", offset)
+		assert.Equal(t, full[offset:], string(replay), "offset=%d", offset)
+	}
+
+	_, err := b.ResumeFromOffset(int64(len(full)) + 1)
+	assert.ErrorIs(t, err, ErrSSEOffsetOutOfRange)
+	_, err = b.ResumeFromOffset(-1)
+	assert.ErrorIs(t, err, ErrSSEOffsetOutOfRange)
+}
+
+func TestSSEEventBuffer_EvictsOldestEvents(t *testing.T) {
+	event := sseEvent("ping", `{"type":"ping"}`)
+	b := NewSSEEventBuffer(len(event) * 3)
+
+	var full []byte
+	for i := 0; i < 5; i++ {
+		b.Append(event)
+		full = append(full, event...)
+	}
+
+	// 只保留最后3个事件
+	earliest := int64(len(event) * 2)
+	_, err := b.ResumeFromOffset(earliest - 1)
+	assert.ErrorIs(t, err, ErrSSEOffsetEvicted)
+
+	replay, err := b.ResumeFromOffset(earliest + 3)
+	require.NoError(t, err)
+	assert.Equal(t, full[earliest+3:], replay)
+}
+
+func TestSSEEventBuffer_KeepsOversizedEvent(t *testing.T) {
+	b := NewSSEEventBuffer(8)
+	b.Append(sseEvent("ping", "{}"))
+	large := sseEvent("content_block_delta", strings.Repeat("x", 64))
+	_, offset := b.Append(large)
+
+	replay, err := b.ResumeFromOffset(offset)
+	require.NoError(t, err)
+	assert.Equal(t, large, replay)
+}
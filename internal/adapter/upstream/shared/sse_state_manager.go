@@ -3,6 +3,8 @@ package shared
 import (
 	"errors"
 	"fmt"
+	"slices"
+
 	"kiro2api/logger"
 
 	"github.com/gin-gonic/gin"
@@ -11,13 +13,54 @@ import (
 // BlockState 内容块状态
 type BlockState struct {
 	Index     int    `json:"index"`
-	Type      string `json:"type"` // "text" | "tool_use"
+	Type      string `json:"type"` // "text" | "tool_use" | "thinking"
 	Started   bool   `json:"started"`
 	Stopped   bool   `json:"stopped"`
 	ToolUseID string `json:"tool_use_id,omitempty"` // 仅用于工具块
 }
 
+// SSE事件序列违规规则
+const (
+	SSERuleDuplicateMessageStart   = "duplicate_message_start"
+	SSERuleEventBeforeMessageStart = "event_before_message_start"
+	SSERuleEventAfterMessageStop   = "event_after_message_stop"
+	SSERuleDuplicateBlockStart     = "duplicate_block_start"
+	SSERuleMissingBlockIndex       = "missing_block_index"
+	SSERuleDeltaAfterBlockStop     = "delta_after_block_stop"
+	SSERuleBlockStopWithoutStart   = "block_stop_without_start"
+	SSERuleDuplicateBlockStop      = "duplicate_block_stop"
+	SSERuleDuplicateMessageDelta   = "duplicate_message_delta"
+	SSERuleDuplicateMessageStop    = "duplicate_message_stop"
+)
+
+// SSEStateSnapshot 违规发生前的事件序列状态
+type SSEStateSnapshot struct {
+	MessageStarted   bool        `json:"message_started"`
+	MessageDeltaSent bool        `json:"message_delta_sent"`
+	MessageEnded     bool        `json:"message_ended"`
+	Block            *BlockState `json:"block,omitempty"` // 违规事件涉及的内容块此前的状态
+}
+
+// SSEViolation 一次事件序列违规的结构化报告
+type SSEViolation struct {
+	Rule       string           `json:"rule"`
+	EventType  string           `json:"event_type"`
+	BlockIndex *int             `json:"block_index,omitempty"`
+	PriorState SSEStateSnapshot `json:"prior_state"`
+	Message    string           `json:"message"`
+}
+
+// SSEViolationError 严格模式下违规事件的错误，携带违规报告
+type SSEViolationError struct {
+	Violation SSEViolation
+}
+
+func (e *SSEViolationError) Error() string {
+	return e.Violation.Message
+}
+
 // SSEStateManager SSE事件状态管理器，确保事件序列符合Claude规范
+// 严格模式下违规事件返回 *SSEViolationError；非严格模式下跳过或修正违规事件，只记录违规
 type SSEStateManager struct {
 	messageStarted   bool
 	messageDeltaSent bool // 新增：跟踪message_delta是否已发送
@@ -25,6 +68,7 @@ type SSEStateManager struct {
 	messageEnded     bool
 	nextBlockIndex   int
 	strictMode       bool
+	violations       []SSEViolation
 }
 
 // NewSSEStateManager 创建SSE状态管理器
@@ -42,6 +86,39 @@ func (ssm *SSEStateManager) Reset() {
 	ssm.messageEnded = false
 	ssm.activeBlocks = make(map[int]*BlockState)
 	ssm.nextBlockIndex = 0
+	ssm.violations = nil
+}
+
+// violation 记录一次违规并输出日志；严格模式返回 *SSEViolationError，非严格模式返回nil
+// blockIndex 为nil表示违规与具体内容块无关
+func (ssm *SSEStateManager) violation(rule, eventType string, blockIndex *int, format string, args ...any) error {
+	v := SSEViolation{
+		Rule:       rule,
+		EventType:  eventType,
+		BlockIndex: blockIndex,
+		PriorState: SSEStateSnapshot{
+			MessageStarted:   ssm.messageStarted,
+			MessageDeltaSent: ssm.messageDeltaSent,
+			MessageEnded:     ssm.messageEnded,
+		},
+		Message: fmt.Sprintf(format, args...),
+	}
+	if blockIndex != nil {
+		if block, ok := ssm.activeBlocks[*blockIndex]; ok {
+			prior := *block
+			v.PriorState.Block = &prior
+		}
+	}
+	ssm.violations = append(ssm.violations, v)
+
+	logger.Error(v.Message,
+		logger.String("rule", rule),
+		logger.String("event_type", eventType),
+		logger.Bool("strict", ssm.strictMode))
+	if ssm.strictMode {
+		return &SSEViolationError{Violation: v}
+	}
+	return nil
 }
 
 // SendEvent 受控的事件发送，确保符合Claude规范
@@ -74,12 +151,8 @@ func (ssm *SSEStateManager) SendEvent(c *gin.Context, sender StreamEventSender,
 // handleMessageStart 处理消息开始事件
 func (ssm *SSEStateManager) handleMessageStart(c *gin.Context, sender StreamEventSender, eventData map[string]any) error {
 	if ssm.messageStarted {
-		errMsg := "违规：message_start只能出现一次"
-		logger.Error(errMsg)
-		if ssm.strictMode {
-			return errors.New(errMsg)
-		}
-		return nil // 非严格模式下跳过重复的message_start
+		// 非严格模式下跳过重复的message_start
+		return ssm.violation(SSERuleDuplicateMessageStart, "message_start", nil, "违规：message_start只能出现一次")
 	}
 
 	ssm.messageStarted = true
@@ -88,23 +161,6 @@ func (ssm *SSEStateManager) handleMessageStart(c *gin.Context, sender StreamEven
 
 // handleContentBlockStart 处理内容块开始事件
 func (ssm *SSEStateManager) handleContentBlockStart(c *gin.Context, sender StreamEventSender, eventData map[string]any) error {
-	if !ssm.messageStarted {
-		errMsg := "违规：content_block_start必须在message_start之后"
-		logger.Error(errMsg)
-		if ssm.strictMode {
-			return errors.New(errMsg)
-		}
-	}
-
-	if ssm.messageEnded {
-		errMsg := "违规：message已结束，不能发送content_block_start"
-		logger.Error(errMsg)
-		if ssm.strictMode {
-			return errors.New(errMsg)
-		}
-		return nil
-	}
-
 	// 提取块索引
 	index, ok := eventData["index"].(int)
 	if !ok {
@@ -115,14 +171,20 @@ func (ssm *SSEStateManager) handleContentBlockStart(c *gin.Context, sender Strea
 		}
 	}
 
+	if !ssm.messageStarted {
+		if err := ssm.violation(SSERuleEventBeforeMessageStart, "content_block_start", &index, "违规：content_block_start必须在message_start之后"); err != nil {
+			return err
+		}
+	}
+
+	if ssm.messageEnded {
+		return ssm.violation(SSERuleEventAfterMessageStop, "content_block_start", &index, "违规：message已结束，不能发送content_block_start")
+	}
+
 	// 检查是否重复启动同一块
 	if block, exists := ssm.activeBlocks[index]; exists && block.Started && !block.Stopped {
-		errMsg := fmt.Sprintf("违规：索引%d的content_block已经started但未stopped", index)
-		logger.Error(errMsg, logger.Int("block_index", index))
-		if ssm.strictMode {
-			return errors.New(errMsg)
-		}
-		return nil // 跳过重复的start
+		// 跳过重复的start
+		return ssm.violation(SSERuleDuplicateBlockStart, "content_block_start", &index, "违规：索引%d的content_block已经started但未stopped", index)
 	}
 
 	// 确定块类型
@@ -142,31 +204,10 @@ func (ssm *SSEStateManager) handleContentBlockStart(c *gin.Context, sender Strea
 	// - index:1 stop
 	// - index:0 stop (延迟关闭)
 	//
-	// 修复策略：当检测到新工具块启动时，自动关闭所有未关闭的文本块
-	if blockType == "tool_use" {
-		// 遍历所有活跃块，找到未关闭的文本块
-		for blockIndex, block := range ssm.activeBlocks {
-			if block.Type == "text" && block.Started && !block.Stopped {
-				// 自动发送content_block_stop来关闭文本块
-				stopEvent := map[string]any{
-					"type":  "content_block_stop",
-					"index": blockIndex,
-				}
-				logger.Debug("工具块启动前自动关闭文本块",
-					logger.Int("text_block_index", blockIndex),
-					logger.Int("new_tool_block_index", index),
-					logger.String("reason", "prevent_event_interleaving"))
-
-				// 立即发送stop事件（在工具块start之前）
-				if err := sender.SendEvent(c, stopEvent); err != nil {
-					logger.Error("自动关闭文本块失败", logger.Err(err), logger.Int("index", blockIndex))
-				} else {
-					// 标记文本块已关闭
-					block.Stopped = true
-					logger.Debug("文本块已自动关闭", logger.Int("index", blockIndex))
-				}
-			}
-		}
+	// 修复策略：当检测到新工具块或思考块启动时，自动关闭会与之交错的未关闭块
+	// 交错思考模式（interleaved-thinking）下思考块可以出现在工具块之后，此时关闭前面的工具块
+	if closeTypes := interleavingBlockTypes[blockType]; len(closeTypes) > 0 {
+		ssm.autoCloseBlocks(c, sender, index, blockType, closeTypes)
 	}
 
 	// 创建或更新块状态
@@ -199,6 +240,45 @@ func (ssm *SSEStateManager) handleContentBlockStart(c *gin.Context, sender Strea
 	return sender.SendEvent(c, eventData)
 }
 
+// interleavingBlockTypes 各类型的块启动时需要先关闭的未关闭块类型
+var interleavingBlockTypes = map[string][]string{
+	"tool_use": {"text", "thinking"},
+	"thinking": {"text", "tool_use"},
+}
+
+// autoCloseBlocks 在新块启动前按索引顺序关闭指定类型的未关闭块
+func (ssm *SSEStateManager) autoCloseBlocks(c *gin.Context, sender StreamEventSender, newIndex int, newType string, closeTypes []string) {
+	var indexes []int
+	for blockIndex, block := range ssm.activeBlocks {
+		if slices.Contains(closeTypes, block.Type) && block.Started && !block.Stopped {
+			indexes = append(indexes, blockIndex)
+		}
+	}
+	slices.Sort(indexes)
+
+	for _, blockIndex := range indexes {
+		block := ssm.activeBlocks[blockIndex]
+		// 自动发送content_block_stop来关闭前面的块
+		stopEvent := map[string]any{
+			"type":  "content_block_stop",
+			"index": blockIndex,
+		}
+		logger.Debug("新块启动前自动关闭未关闭的块",
+			logger.Int("block_index", blockIndex),
+			logger.String("block_type", block.Type),
+			logger.Int("new_block_index", newIndex),
+			logger.String("new_block_type", newType),
+			logger.String("reason", "prevent_event_interleaving"))
+
+		// 立即发送stop事件（在新块start之前）
+		if err := sender.SendEvent(c, stopEvent); err != nil {
+			logger.Error("自动关闭块失败", logger.Err(err), logger.Int("index", blockIndex))
+			continue
+		}
+		block.Stopped = true
+	}
+}
+
 // handleContentBlockDelta 处理内容块增量事件
 func (ssm *SSEStateManager) handleContentBlockDelta(c *gin.Context, sender StreamEventSender, eventData map[string]any) error {
 	index, ok := eventData["index"].(int)
@@ -206,12 +286,7 @@ func (ssm *SSEStateManager) handleContentBlockDelta(c *gin.Context, sender Strea
 		if indexFloat, ok := eventData["index"].(float64); ok {
 			index = int(indexFloat)
 		} else {
-			errMsg := "content_block_delta缺少有效索引"
-			logger.Error(errMsg)
-			if ssm.strictMode {
-				return errors.New(errMsg)
-			}
-			return nil
+			return ssm.violation(SSERuleMissingBlockIndex, "content_block_delta", nil, "content_block_delta缺少有效索引")
 		}
 	}
 
@@ -225,8 +300,11 @@ func (ssm *SSEStateManager) handleContentBlockDelta(c *gin.Context, sender Strea
 		blockType := "text" // 默认为文本块
 		if delta, ok := eventData["delta"].(map[string]any); ok {
 			if deltaType, ok := delta["type"].(string); ok {
-				if deltaType == "input_json_delta" {
+				switch deltaType {
+				case "input_json_delta":
 					blockType = "tool_use"
+				case "thinking_delta", "signature_delta":
+					blockType = "thinking"
 				}
 			}
 		}
@@ -243,6 +321,8 @@ func (ssm *SSEStateManager) handleContentBlockDelta(c *gin.Context, sender Strea
 		switch blockType {
 		case "text":
 			startEvent["content_block"].(map[string]any)["text"] = ""
+		case "thinking":
+			startEvent["content_block"].(map[string]any)["thinking"] = ""
 		case "tool_use":
 			// 为工具使用块添加必要字段
 			startEvent["content_block"].(map[string]any)["id"] = fmt.Sprintf("tooluse_auto_%d", index)
@@ -260,12 +340,7 @@ func (ssm *SSEStateManager) handleContentBlockDelta(c *gin.Context, sender Strea
 	}
 
 	if block != nil && block.Stopped {
-		errMsg := fmt.Sprintf("违规：索引%d的content_block已停止，不能发送delta", index)
-		logger.Error(errMsg, logger.Int("block_index", index), logger.Any("eventData", eventData))
-		if ssm.strictMode {
-			return errors.New(errMsg)
-		}
-		return nil
+		return ssm.violation(SSERuleDeltaAfterBlockStop, "content_block_delta", &index, "违规：索引%d的content_block已停止，不能发送delta", index)
 	}
 
 	return sender.SendEvent(c, eventData)
@@ -278,33 +353,18 @@ func (ssm *SSEStateManager) handleContentBlockStop(c *gin.Context, sender Stream
 		if indexFloat, ok := eventData["index"].(float64); ok {
 			index = int(indexFloat)
 		} else {
-			errMsg := "content_block_stop缺少有效索引"
-			logger.Error(errMsg)
-			if ssm.strictMode {
-				return errors.New(errMsg)
-			}
-			return nil
+			return ssm.violation(SSERuleMissingBlockIndex, "content_block_stop", nil, "content_block_stop缺少有效索引")
 		}
 	}
 
 	// 验证块状态
 	block, exists := ssm.activeBlocks[index]
 	if !exists || !block.Started {
-		errMsg := fmt.Sprintf("违规：索引%d的content_block未启动就发送stop", index)
-		logger.Error(errMsg, logger.Int("block_index", index))
-		if ssm.strictMode {
-			return errors.New(errMsg)
-		}
-		return nil
+		return ssm.violation(SSERuleBlockStopWithoutStart, "content_block_stop", &index, "违规：索引%d的content_block未启动就发送stop", index)
 	}
 
 	if block.Stopped {
-		errMsg := fmt.Sprintf("违规：索引%d的content_block重复停止", index)
-		logger.Error(errMsg, logger.Int("block_index", index))
-		if ssm.strictMode {
-			return errors.New(errMsg)
-		}
-		return nil
+		return ssm.violation(SSERuleDuplicateBlockStop, "content_block_stop", &index, "违规：索引%d的content_block重复停止", index)
 	}
 
 	// 标记为已停止
@@ -316,26 +376,15 @@ func (ssm *SSEStateManager) handleContentBlockStop(c *gin.Context, sender Stream
 // handleMessageDelta 处理消息增量事件
 func (ssm *SSEStateManager) handleMessageDelta(c *gin.Context, sender StreamEventSender, eventData map[string]any) error {
 	if !ssm.messageStarted {
-		errMsg := "违规：message_delta必须在message_start之后"
-		logger.Error(errMsg)
-		if ssm.strictMode {
-			return errors.New(errMsg)
+		if err := ssm.violation(SSERuleEventBeforeMessageStart, "message_delta", nil, "违规：message_delta必须在message_start之后"); err != nil {
+			return err
 		}
 	}
 
 	// *** 关键修复：防止重复的message_delta事件 ***
-	// 根据Claude规范，message_delta在一次消息中只能出现一次
+	// 根据Claude规范，message_delta在一次消息中只能出现一次，非严格模式下跳过重复的message_delta
 	if ssm.messageDeltaSent {
-		errMsg := "违规：message_delta只能出现一次"
-		logger.Error(errMsg,
-			logger.Bool("message_started", ssm.messageStarted),
-			logger.Bool("message_delta_sent", ssm.messageDeltaSent),
-			logger.Bool("message_ended", ssm.messageEnded))
-		if ssm.strictMode {
-			return errors.New(errMsg)
-		}
-		logger.Debug("跳过重复的message_delta事件")
-		return nil // 非严格模式下跳过重复的message_delta
+		return ssm.violation(SSERuleDuplicateMessageDelta, "message_delta", nil, "违规：message_delta只能出现一次")
 	}
 
 	// *** 关键修复：在发送message_delta之前，确保所有content_block都已关闭 ***
@@ -350,17 +399,15 @@ func (ssm *SSEStateManager) handleMessageDelta(c *gin.Context, sender StreamEven
 	if len(unclosedBlocks) > 0 {
 		logger.Debug("message_delta前自动关闭未关闭的content_block",
 			logger.Any("unclosed_blocks", unclosedBlocks))
-		// 在非严格模式下，自动关闭未关闭的块
-		if !ssm.strictMode {
-			for _, index := range unclosedBlocks {
-				stopEvent := map[string]any{
-					"type":  "content_block_stop",
-					"index": index,
-				}
-				sender.SendEvent(c, stopEvent)
-				ssm.activeBlocks[index].Stopped = true
-				logger.Debug("自动关闭未关闭的content_block（message_delta前）", logger.Int("index", index))
+		// 块由代理按需自动启动，结束前同样由代理负责关闭，严格模式下也不视为违规
+		for _, index := range unclosedBlocks {
+			stopEvent := map[string]any{
+				"type":  "content_block_stop",
+				"index": index,
 			}
+			sender.SendEvent(c, stopEvent)
+			ssm.activeBlocks[index].Stopped = true
+			logger.Debug("自动关闭未关闭的content_block（message_delta前）", logger.Int("index", index))
 		}
 	}
 
@@ -373,20 +420,13 @@ func (ssm *SSEStateManager) handleMessageDelta(c *gin.Context, sender StreamEven
 // handleMessageStop 处理消息停止事件
 func (ssm *SSEStateManager) handleMessageStop(c *gin.Context, sender StreamEventSender, eventData map[string]any) error {
 	if !ssm.messageStarted {
-		errMsg := "违规：message_stop必须在message_start之后"
-		logger.Error(errMsg)
-		if ssm.strictMode {
-			return errors.New(errMsg)
+		if err := ssm.violation(SSERuleEventBeforeMessageStart, "message_stop", nil, "违规：message_stop必须在message_start之后"); err != nil {
+			return err
 		}
 	}
 
 	if ssm.messageEnded {
-		errMsg := "违规：message_stop只能出现一次"
-		logger.Error(errMsg)
-		if ssm.strictMode {
-			return errors.New(errMsg)
-		}
-		return nil
+		return ssm.violation(SSERuleDuplicateMessageStop, "message_stop", nil, "违规：message_stop只能出现一次")
 	}
 
 	// 注意：未关闭的content_block检查已移至handleMessageDelta中
@@ -415,3 +455,13 @@ func (ssm *SSEStateManager) IsMessageEnded() bool {
 func (ssm *SSEStateManager) IsMessageDeltaSent() bool {
 	return ssm.messageDeltaSent
 }
+
+// IsStrictMode 是否为严格模式
+func (ssm *SSEStateManager) IsStrictMode() bool {
+	return ssm.strictMode
+}
+
+// Violations 返回已记录的违规（按发生顺序）
+func (ssm *SSEStateManager) Violations() []SSEViolation {
+	return ssm.violations
+}
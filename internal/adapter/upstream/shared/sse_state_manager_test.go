@@ -0,0 +1,243 @@
+package shared
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"kiro2api/config"
+	srvcontext "kiro2api/internal/adapter/httpapi/context"
+	"kiro2api/parser"
+	"kiro2api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// violatingSSESequence 依次触发 duplicate_message_start、block_stop_without_start、delta_after_block_stop
+func violatingSSESequence() []map[string]any {
+	return []map[string]any{
+		{"type": "message_start", "message": map[string]any{"id": "msg_test"}},
+		{"type": "message_start", "message": map[string]any{"id": "msg_test"}},
+		{"type": "content_block_stop", "index": 3},
+		{"type": "content_block_delta", "index": 0, "delta": map[string]any{"type": "text_delta", "text": "你好"}},
+		{"type": "content_block_stop", "index": 0},
+		{"type": "content_block_delta", "index": 0, "delta": map[string]any{"type": "text_delta", "text": "迟到的增量"}},
+	}
+}
+
+func newSSETestContext(t *testing.T, strictHeader string) *gin.Context {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	if strictHeader != "" {
+		c.Request.Header.Set(config.StrictSSEHeader, strictHeader)
+	}
+	return c
+}
+
+func eventTypes(events []map[string]any) []string {
+	result := make([]string, len(events))
+	for i, event := range events {
+		result[i], _ = event["type"].(string)
+	}
+	return result
+}
+
+func TestSSEStateManager_NonStrictRecordsViolations(t *testing.T) {
+	c := newSSETestContext(t, "")
+	sender := &recordingSender{}
+	ssm := NewSSEStateManager(false)
+
+	for _, event := range violatingSSESequence() {
+		require.NoError(t, ssm.SendEvent(c, sender, event))
+	}
+
+	// 违规事件被跳过，其余事件正常发送（delta 前自动补发 content_block_start）
+	assert.Equal(t, []string{"message_start", "content_block_start", "content_block_delta", "content_block_stop"}, eventTypes(sender.events))
+
+	violations := ssm.Violations()
+	require.Len(t, violations, 3)
+	assert.Equal(t, SSERuleDuplicateMessageStart, violations[0].Rule)
+	assert.Nil(t, violations[0].BlockIndex)
+	assert.Equal(t, SSERuleBlockStopWithoutStart, violations[1].Rule)
+	assert.Equal(t, 3, *violations[1].BlockIndex)
+	assert.Equal(t, SSERuleDeltaAfterBlockStop, violations[2].Rule)
+	assert.Equal(t, "content_block_delta", violations[2].EventType)
+	require.NotNil(t, violations[2].PriorState.Block)
+	assert.True(t, violations[2].PriorState.Block.Stopped)
+}
+
+func TestSSEStateManager_StrictReturnsStructuredViolation(t *testing.T) {
+	c := newSSETestContext(t, "")
+	sender := &recordingSender{}
+	ssm := NewSSEStateManager(true)
+
+	var violationErr *SSEViolationError
+	for _, event := range violatingSSESequence() {
+		if err := ssm.SendEvent(c, sender, event); err != nil {
+			require.ErrorAs(t, err, &violationErr)
+			break
+		}
+	}
+
+	require.NotNil(t, violationErr)
+	v := violationErr.Violation
+	assert.Equal(t, SSERuleDuplicateMessageStart, v.Rule)
+	assert.Equal(t, "message_start", v.EventType)
+	assert.True(t, v.PriorState.MessageStarted)
+	assert.False(t, v.PriorState.MessageEnded)
+	assert.Equal(t, v.Message, violationErr.Error())
+	assert.Equal(t, []string{"message_start"}, eventTypes(sender.events))
+}
+
+func TestSSEStateManager_StrictAutoClosesBlocksBeforeMessageDelta(t *testing.T) {
+	c := newSSETestContext(t, "")
+	sender := &recordingSender{}
+	ssm := NewSSEStateManager(true)
+
+	events := []map[string]any{
+		{"type": "message_start", "message": map[string]any{"id": "msg_test"}},
+		{"type": "content_block_delta", "index": 0, "delta": map[string]any{"type": "text_delta", "text": "你好"}},
+	}
+	events = append(events, CreateAnthropicFinalEvents(1, 10, "end_turn")...)
+	for _, event := range events {
+		require.NoError(t, ssm.SendEvent(c, sender, event))
+	}
+
+	assert.Empty(t, ssm.Violations())
+	assert.Equal(t, []string{"message_start", "content_block_start", "content_block_delta", "content_block_stop", "message_delta", "message_stop"}, eventTypes(sender.events))
+}
+
+// interleavedThinkingSequence 交错思考模式的事件：思考块出现在工具块之后，closeBlocks 控制上游是否显式关闭每个块
+func interleavedThinkingSequence(closeBlocks bool) []map[string]any {
+	blocks := []struct {
+		start map[string]any
+		delta map[string]any
+	}{
+		{map[string]any{"type": "thinking", "thinking": ""}, map[string]any{"type": "thinking_delta", "thinking": "先查天气"}},
+		{map[string]any{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": map[string]any{}}, map[string]any{"type": "input_json_delta", "partial_json": `{"city":"北京"}`}},
+		{map[string]any{"type": "thinking", "thinking": ""}, map[string]any{"type": "thinking_delta", "thinking": "再查时间"}},
+		{map[string]any{"type": "tool_use", "id": "toolu_2", "name": "get_time", "input": map[string]any{}}, map[string]any{"type": "input_json_delta", "partial_json": `{}`}},
+	}
+
+	events := []map[string]any{{"type": "message_start", "message": map[string]any{"id": "msg_test"}}}
+	for i, block := range blocks {
+		events = append(events,
+			map[string]any{"type": "content_block_start", "index": i, "content_block": block.start},
+			map[string]any{"type": "content_block_delta", "index": i, "delta": block.delta})
+		if closeBlocks {
+			events = append(events, map[string]any{"type": "content_block_stop", "index": i})
+		}
+	}
+	return append(events, CreateAnthropicFinalEvents(1, 10, "tool_use")...)
+}
+
+func TestSSEStateManager_InterleavedThinking(t *testing.T) {
+	blockEvents := []string{"content_block_start", "content_block_delta", "content_block_stop"}
+	var want []string
+	want = append(want, "message_start")
+	for range 4 {
+		want = append(want, blockEvents...)
+	}
+	want = append(want, "message_delta", "message_stop")
+
+	for _, closeBlocks := range []bool{true, false} {
+		c := newSSETestContext(t, "")
+		sender := &recordingSender{}
+		ssm := NewSSEStateManager(true)
+
+		for _, event := range interleavedThinkingSequence(closeBlocks) {
+			require.NoError(t, ssm.SendEvent(c, sender, event), "closeBlocks=%v", closeBlocks)
+		}
+
+		// 上游未关闭时，下一个块启动前自动关闭前一个块，序列与显式关闭相同
+		assert.Empty(t, ssm.Violations(), "closeBlocks=%v", closeBlocks)
+		assert.Equal(t, want, eventTypes(sender.events), "closeBlocks=%v", closeBlocks)
+		for i := 1; i < len(sender.events); i += 3 {
+			if sender.events[i]["type"] != "content_block_start" {
+				continue
+			}
+			assert.Equal(t, (i-1)/3, sender.events[i]["index"], "closeBlocks=%v", closeBlocks)
+			assert.Equal(t, (i-1)/3, sender.events[i+2]["index"], "closeBlocks=%v", closeBlocks)
+		}
+		assert.Equal(t, "thinking", ssm.GetActiveBlocks()[2].Type)
+	}
+}
+
+func TestSSEStateManager_ThinkingDeltaAutoStartsThinkingBlock(t *testing.T) {
+	c := newSSETestContext(t, "")
+	sender := &recordingSender{}
+	ssm := NewSSEStateManager(true)
+
+	events := []map[string]any{
+		{"type": "message_start", "message": map[string]any{"id": "msg_test"}},
+		{"type": "content_block_start", "index": 0, "content_block": map[string]any{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": map[string]any{}}},
+		{"type": "content_block_delta", "index": 1, "delta": map[string]any{"type": "thinking_delta", "thinking": "工具返回后继续思考"}},
+		{"type": "content_block_delta", "index": 1, "delta": map[string]any{"type": "signature_delta", "signature": "sig"}},
+	}
+	for _, event := range events {
+		require.NoError(t, ssm.SendEvent(c, sender, event))
+	}
+
+	assert.Empty(t, ssm.Violations())
+	assert.Equal(t, []string{"message_start", "content_block_start", "content_block_stop", "content_block_start", "content_block_delta", "content_block_delta"}, eventTypes(sender.events))
+	assert.Equal(t, 0, sender.events[2]["index"])
+	assert.Equal(t, map[string]any{"type": "thinking", "thinking": ""}, sender.events[3]["content_block"])
+	assert.True(t, ssm.GetActiveBlocks()[0].Stopped)
+}
+
+func newSSETestProcessor(t *testing.T, strictHeader string) (*EventStreamProcessor, *recordingSender) {
+	t.Helper()
+	c := newSSETestContext(t, strictHeader)
+	sender := &recordingSender{}
+	ctx := NewStreamProcessorContext(c, types.AnthropicRequest{Model: "claude-sonnet-4"}, nil, sender, "msg_test", 10)
+	t.Cleanup(ctx.Cleanup)
+	return NewEventStreamProcessor(ctx), sender
+}
+
+func TestEventStreamProcessor_NonStrictSkipsViolations(t *testing.T) {
+	esp, sender := newSSETestProcessor(t, "")
+
+	for _, event := range violatingSSESequence() {
+		require.NoError(t, esp.processEvent(parser.SSEEvent{Data: event}))
+	}
+	require.NoError(t, esp.ctx.SendFinalEvents())
+
+	assert.Equal(t, []string{"message_start", "content_block_start", "content_block_delta", "content_block_stop", "message_delta", "message_stop"}, eventTypes(sender.events))
+	assert.False(t, esp.ctx.strictTerminated)
+	violations, ok := srvcontext.GetSSEViolations(esp.ctx.c)
+	require.True(t, ok)
+	assert.Equal(t, 3, violations)
+}
+
+func TestEventStreamProcessor_StrictTerminatesWithErrorEvent(t *testing.T) {
+	esp, sender := newSSETestProcessor(t, "1")
+	require.True(t, esp.ctx.sseStateManager.IsStrictMode())
+
+	var processErr error
+	for _, event := range violatingSSESequence() {
+		if processErr = esp.processEvent(parser.SSEEvent{Data: event}); processErr != nil {
+			break
+		}
+	}
+
+	require.Error(t, processErr)
+	assert.True(t, esp.ctx.strictTerminated)
+	require.Equal(t, []string{"message_start", "error"}, eventTypes(sender.events))
+
+	errBody := sender.events[1]["error"].(map[string]any)
+	assert.Equal(t, "sse_protocol_violation", errBody["type"])
+	violation := errBody["violation"].(SSEViolation)
+	assert.Equal(t, SSERuleDuplicateMessageStart, violation.Rule)
+	assert.Equal(t, "message_start", violation.EventType)
+	assert.True(t, violation.PriorState.MessageStarted)
+}
+
+func TestIsStrictSSERequested(t *testing.T) {
+	for value, want := range map[string]bool{"1": true, "true": true, " TRUE ": true, "": false, "0": false, "yes": false} {
+		assert.Equal(t, want, config.IsStrictSSERequested(value), value)
+	}
+}
@@ -1,14 +1,90 @@
 package shared
 
 import (
+	"sort"
+	"sync/atomic"
+
+	"kiro2api/config"
 	"kiro2api/logger"
 	"kiro2api/types"
 )
 
+// StopReasonOverrideStatus 单条 stop_reason 替换规则及自进程启动以来的触发次数
+type StopReasonOverrideStatus struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Fired int64  `json:"fired"`
+}
+
+// StopReasonOverrides 按 STOP_REASON_OVERRIDES 替换返回给客户端的 stop_reason，兼容不支持某些取值的客户端
+// nil 表示没有替换规则
+type StopReasonOverrides struct {
+	overrides map[string]string
+	fired     map[string]*atomic.Int64
+}
+
+// NewStopReasonOverrides 创建 stop_reason 替换规则，overrides 为原值到替换值的映射
+func NewStopReasonOverrides(overrides map[string]string) *StopReasonOverrides {
+	o := &StopReasonOverrides{
+		overrides: make(map[string]string, len(overrides)),
+		fired:     make(map[string]*atomic.Int64, len(overrides)),
+	}
+	for from, to := range overrides {
+		o.overrides[from] = to
+		o.fired[from] = &atomic.Int64{}
+	}
+	return o
+}
+
+// activeStopReasonOverrides 启动时加载的 STOP_REASON_OVERRIDES
+var activeStopReasonOverrides atomic.Pointer[StopReasonOverrides]
+
+// SetStopReasonOverrides 替换全局 stop_reason 替换规则，触发次数重新计数
+func SetStopReasonOverrides(overrides map[string]string) {
+	activeStopReasonOverrides.Store(NewStopReasonOverrides(overrides))
+}
+
+// GetStopReasonOverrides 获取全局 stop_reason 替换规则，未加载时返回nil
+func GetStopReasonOverrides() *StopReasonOverrides {
+	return activeStopReasonOverrides.Load()
+}
+
+// Apply 返回替换后的 stop_reason，命中规则时计数并输出Debug日志
+func (o *StopReasonOverrides) Apply(stopReason string) string {
+	if o == nil {
+		return stopReason
+	}
+	to, ok := o.overrides[stopReason]
+	if !ok {
+		return stopReason
+	}
+	o.fired[stopReason].Add(1)
+	logger.Debug("按 STOP_REASON_OVERRIDES 替换stop_reason",
+		logger.String("stop_reason", stopReason),
+		logger.String("override", to))
+	return to
+}
+
+// Snapshot 返回按原值排序的替换规则及触发次数
+func (o *StopReasonOverrides) Snapshot() []StopReasonOverrideStatus {
+	statuses := []StopReasonOverrideStatus{}
+	if o == nil {
+		return statuses
+	}
+	for from, to := range o.overrides {
+		statuses = append(statuses, StopReasonOverrideStatus{From: from, To: to, Fired: o.fired[from].Load()})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].From < statuses[j].From })
+	return statuses
+}
+
 // StopReasonManager 管理符合Claude规范的stop_reason决策
 type StopReasonManager struct {
 	hasActiveToolCalls bool
 	hasCompletedTools  bool
+	stopSequence       string               // 本地命中的停止序列
+	maxTokens          bool                 // 上游因内容长度超限结束了响应
+	overrides          *StopReasonOverrides // 创建时的全局替换规则
 }
 
 // NewStopReasonManager 创建stop_reason管理器
@@ -16,6 +92,7 @@ func NewStopReasonManager(anthropicReq types.AnthropicRequest) *StopReasonManage
 	return &StopReasonManager{
 		hasActiveToolCalls: false,
 		hasCompletedTools:  false,
+		overrides:          GetStopReasonOverrides(),
 	}
 }
 
@@ -29,8 +106,32 @@ func (srm *StopReasonManager) UpdateToolCallStatus(hasActiveCalls, hasCompleted
 		logger.Bool("has_completed_tools", hasCompleted))
 }
 
-// DetermineStopReason 根据Claude官方规范确定stop_reason
+// SetStopSequence 记录本地命中的停止序列，之后没有工具调用时 stop_reason 为 stop_sequence
+func (srm *StopReasonManager) SetStopSequence(seq string) {
+	srm.stopSequence = seq
+}
+
+// SetMaxTokens 记录上游因内容长度超限结束了响应，stop_reason 为 max_tokens
+func (srm *StopReasonManager) SetMaxTokens() {
+	srm.maxTokens = true
+}
+
+// StopSequence 本地命中的停止序列，stop_reason 为 stop_sequence 时写入响应的 stop_sequence 字段
+func (srm *StopReasonManager) StopSequence() string {
+	return srm.stopSequence
+}
+
+// DetermineStopReason 根据Claude官方规范确定stop_reason，返回前应用 STOP_REASON_OVERRIDES
 func (srm *StopReasonManager) DetermineStopReason() string {
+	return srm.overrides.Apply(srm.determineStopReason())
+}
+
+// determineStopReason 根据工具调用状态确定stop_reason（未应用替换规则）
+func (srm *StopReasonManager) determineStopReason() string {
+	// 上游内容长度超限时响应已被截断，优先于工具调用
+	if srm.maxTokens {
+		return "max_tokens"
+	}
 
 	// 检查是否有工具调用（活跃或已完成）
 	// *** 关键修复：根据Claude规范，只要消息包含tool_use块，stop_reason就应该是tool_use ***
@@ -49,6 +150,11 @@ func (srm *StopReasonManager) DetermineStopReason() string {
 		return "tool_use"
 	}
 
+	// 输出在本地按 stop_sequences 截断
+	if srm.stopSequence != "" {
+		return "stop_sequence"
+	}
+
 	// 规则3: 默认情况 - 自然完成响应
 	return "end_turn"
 }
@@ -61,16 +167,7 @@ func (srm *StopReasonManager) DetermineStopReasonFromUpstream(upstreamStopReason
 	}
 
 	// 验证上游stop_reason是否符合Claude规范
-	validStopReasons := map[string]bool{
-		"end_turn":      true,
-		"max_tokens":    true,
-		"stop_sequence": true,
-		"tool_use":      true,
-		"pause_turn":    true,
-		"refusal":       true,
-	}
-
-	if !validStopReasons[upstreamStopReason] {
+	if !config.IsValidStopReason(upstreamStopReason) {
 		logger.Warn("上游提供了无效的stop_reason，使用本地逻辑",
 			logger.String("upstream_stop_reason", upstreamStopReason))
 		return srm.DetermineStopReason()
@@ -78,7 +175,7 @@ func (srm *StopReasonManager) DetermineStopReasonFromUpstream(upstreamStopReason
 
 	logger.Debug("使用上游stop_reason",
 		logger.String("upstream_stop_reason", upstreamStopReason))
-	return upstreamStopReason
+	return srm.overrides.Apply(upstreamStopReason)
 }
 
 // GetStopReasonDescription 获取stop_reason的描述（用于调试）
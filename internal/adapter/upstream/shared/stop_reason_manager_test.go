@@ -0,0 +1,101 @@
+package shared
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"kiro2api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withStopReasonOverrides 在测试期间替换全局 stop_reason 替换规则
+func withStopReasonOverrides(t *testing.T, overrides map[string]string) {
+	t.Helper()
+	previous := activeStopReasonOverrides.Load()
+	SetStopReasonOverrides(overrides)
+	t.Cleanup(func() { activeStopReasonOverrides.Store(previous) })
+}
+
+func TestStopReasonManager_Overrides(t *testing.T) {
+	cases := []struct {
+		name      string
+		overrides map[string]string
+		toolUse   bool
+		upstream  string
+		maxTokens bool
+		want      string
+	}{
+		{name: "未配置", toolUse: true, want: "tool_use"},
+		{name: "tool_use替换为end_turn", overrides: map[string]string{"tool_use": "end_turn"}, toolUse: true, want: "end_turn"},
+		{name: "规则不匹配时不替换", overrides: map[string]string{"tool_use": "end_turn"}, want: "end_turn"},
+		{name: "end_turn替换为stop_sequence", overrides: map[string]string{"end_turn": "stop_sequence"}, want: "stop_sequence"},
+		{name: "上游refusal替换为end_turn", overrides: map[string]string{"refusal": "end_turn"}, upstream: "refusal", want: "end_turn"},
+		{name: "上游max_tokens替换为end_turn", overrides: map[string]string{"max_tokens": "end_turn"}, upstream: "max_tokens", want: "end_turn"},
+		{name: "上游pause_turn替换为end_turn", overrides: map[string]string{"pause_turn": "end_turn"}, upstream: "pause_turn", want: "end_turn"},
+		{name: "上游值无效时按本地结果替换", overrides: map[string]string{"tool_use": "end_turn"}, toolUse: true, upstream: "unknown", want: "end_turn"},
+		{name: "max_tokens优先于工具调用", overrides: map[string]string{"tool_use": "end_turn"}, toolUse: true, maxTokens: true, want: "max_tokens"},
+		{name: "替换结果不再链式替换", overrides: map[string]string{"tool_use": "end_turn", "end_turn": "stop_sequence"}, toolUse: true, want: "end_turn"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			withStopReasonOverrides(t, tc.overrides)
+			srm := NewStopReasonManager(types.AnthropicRequest{})
+			srm.UpdateToolCallStatus(false, tc.toolUse)
+			if tc.maxTokens {
+				srm.SetMaxTokens()
+			}
+
+			if tc.upstream == "" {
+				assert.Equal(t, tc.want, srm.DetermineStopReason())
+			} else {
+				assert.Equal(t, tc.want, srm.DetermineStopReasonFromUpstream(tc.upstream))
+			}
+		})
+	}
+}
+
+func TestStopReasonOverrides_Snapshot(t *testing.T) {
+	var none *StopReasonOverrides
+	assert.Equal(t, "tool_use", none.Apply("tool_use"))
+	assert.Equal(t, []StopReasonOverrideStatus{}, none.Snapshot())
+
+	o := NewStopReasonOverrides(map[string]string{"tool_use": "end_turn", "refusal": "end_turn"})
+	o.Apply("tool_use")
+	o.Apply("tool_use")
+	o.Apply("end_turn")
+	assert.Equal(t, []StopReasonOverrideStatus{
+		{From: "refusal", To: "end_turn", Fired: 0},
+		{From: "tool_use", To: "end_turn", Fired: 2},
+	}, o.Snapshot())
+}
+
+// TestStopReasonOverrides_MaxTokens 上游内容长度超限产生的 max_tokens 同样按替换规则返回，并计入触发次数
+func TestStopReasonOverrides_MaxTokens(t *testing.T) {
+	withStopReasonOverrides(t, map[string]string{"max_tokens": "end_turn"})
+
+	t.Run("流式响应中途超限", func(t *testing.T) {
+		processor, sender := newTestStreamProcessor(t)
+		processor.ctx.stopReasonManager.UpdateToolCallStatus(false, true)
+		require.True(t, processor.sendMaxTokensStop())
+		processor.ctx.FinishStream()
+
+		assert.Equal(t, []any{"end_turn"}, sender.stopReasons(), "只发送一次结束事件")
+		assert.Equal(t, "end_turn", processor.ctx.finalStopReason())
+	})
+
+	t.Run("上游拒绝超长请求", func(t *testing.T) {
+		gin.SetMode(gin.TestMode)
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+
+		NewErrorMapper().SendClaudeError(c, &ClaudeErrorResponse{StopReason: "max_tokens", Message: "too long"})
+		assert.Contains(t, w.Body.String(), `"stop_reason":"end_turn"`)
+	})
+
+	assert.Equal(t, []StopReasonOverrideStatus{{From: "max_tokens", To: "end_turn", Fired: 2}}, GetStopReasonOverrides().Snapshot())
+}
@@ -0,0 +1,126 @@
+package shared
+
+import (
+	"sort"
+	"strings"
+)
+
+// StopSequenceMatcher 在本地按请求的 stop_sequences 截断回复文本，持有单个响应内的状态
+// 上游（尤其是 injected 模式）不保证在停止序列处停下，输出中出现任一停止序列时截断到其之前；
+// 文本增量末尾可能是停止序列开头的部分暂存到同一块的下一个增量，使跨增量的停止序列也能识别
+type StopSequenceMatcher struct {
+	sequences []string
+	carry     map[int]string // 文本块尚未下发的尾部
+	matched   string         // 命中的停止序列，命中后不再接受文本
+}
+
+// NewStopSequenceMatcher 按请求的 stop_sequences 创建匹配器，没有非空的停止序列时返回nil
+func NewStopSequenceMatcher(sequences []string) *StopSequenceMatcher {
+	m := &StopSequenceMatcher{carry: make(map[int]string)}
+	for _, seq := range sequences {
+		if seq != "" {
+			m.sequences = append(m.sequences, seq)
+		}
+	}
+	if len(m.sequences) == 0 {
+		return nil
+	}
+	return m
+}
+
+// Matched 命中的停止序列，未命中时为空
+func (m *StopSequenceMatcher) Matched() string {
+	if m == nil {
+		return ""
+	}
+	return m.matched
+}
+
+// Truncate 截断完整文本（非流式响应），返回截断后的文本和是否命中
+func (m *StopSequenceMatcher) Truncate(text string) (string, bool) {
+	if m == nil || m.matched != "" {
+		return text, false
+	}
+	if pos, seq := m.find(text); seq != "" {
+		m.matched = seq
+		return text[:pos], true
+	}
+	return text, false
+}
+
+// FeedText 处理一个文本增量，返回本次可以下发的文本
+// 命中时返回停止序列之前的部分并丢弃其余内容；命中后的增量全部丢弃
+func (m *StopSequenceMatcher) FeedText(index int, text string) string {
+	if m == nil {
+		return text
+	}
+	if m.matched != "" {
+		return ""
+	}
+
+	text = m.carry[index] + text
+	delete(m.carry, index)
+	if truncated, ok := m.Truncate(text); ok {
+		m.carry = make(map[int]string)
+		return truncated
+	}
+
+	if held := m.partialSuffix(text); held > 0 {
+		m.carry[index] = text[len(text)-held:]
+		return text[:len(text)-held]
+	}
+	return text
+}
+
+// Flush 块结束时取出暂存的尾部，此时它已不可能构成停止序列
+func (m *StopSequenceMatcher) Flush(index int) string {
+	if m == nil {
+		return ""
+	}
+	text := m.carry[index]
+	delete(m.carry, index)
+	return text
+}
+
+// PendingBlocks 仍有暂存文本的块索引，按索引升序
+func (m *StopSequenceMatcher) PendingBlocks() []int {
+	if m == nil {
+		return nil
+	}
+	indices := make([]int, 0, len(m.carry))
+	for index := range m.carry {
+		indices = append(indices, index)
+	}
+	sort.Ints(indices)
+	return indices
+}
+
+// find 返回最早出现的停止序列及其位置，同一位置以较长的序列为准
+func (m *StopSequenceMatcher) find(text string) (int, string) {
+	pos, matched := -1, ""
+	for _, seq := range m.sequences {
+		i := strings.Index(text, seq)
+		if i < 0 {
+			continue
+		}
+		if pos < 0 || i < pos || (i == pos && len(seq) > len(matched)) {
+			pos, matched = i, seq
+		}
+	}
+	return pos, matched
+}
+
+// partialSuffix 文本末尾可能是某个停止序列开头的最长字节数
+// 停止序列以完整字符开头，因此暂存的位置总在字符边界上
+func (m *StopSequenceMatcher) partialSuffix(text string) int {
+	longest := 0
+	for _, seq := range m.sequences {
+		for n := min(len(seq)-1, len(text)); n > longest; n-- {
+			if strings.HasSuffix(text, seq[:n]) {
+				longest = n
+				break
+			}
+		}
+	}
+	return longest
+}
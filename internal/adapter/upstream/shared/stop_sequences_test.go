@@ -0,0 +1,113 @@
+package shared
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"kiro2api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewStopSequenceMatcher_EmptySequences(t *testing.T) {
+	assert.Nil(t, NewStopSequenceMatcher(nil))
+	assert.Nil(t, NewStopSequenceMatcher([]string{""}))
+
+	var m *StopSequenceMatcher
+	assert.Equal(t, "abc", m.FeedText(0, "abc"))
+	assert.Equal(t, "", m.Matched())
+}
+
+func TestStopSequenceMatcher_Truncate(t *testing.T) {
+	m := NewStopSequenceMatcher([]string{"END", "\n\nHuman:"})
+	text, ok := m.Truncate("答案是42。\n\nHuman: 下一个问题END")
+	assert.True(t, ok)
+	assert.Equal(t, "答案是42。", text)
+	assert.Equal(t, "\n\nHuman:", m.Matched(), "以最早出现的停止序列为准")
+
+	m = NewStopSequenceMatcher([]string{"END"})
+	text, ok = m.Truncate("没有停止序列")
+	assert.False(t, ok)
+	assert.Equal(t, "没有停止序列", text)
+}
+
+// TestStopSequenceMatcher_CrossDelta 停止序列跨越多个增量时，其开头部分不提前下发
+func TestStopSequenceMatcher_CrossDelta(t *testing.T) {
+	m := NewStopSequenceMatcher([]string{"</answer>"})
+
+	var out strings.Builder
+	for _, delta := range []string{"结果：是", "</ans", "wer", "> 之后的内容"} {
+		out.WriteString(m.FeedText(0, delta))
+	}
+	assert.Equal(t, "结果：是", out.String())
+	assert.Equal(t, "</answer>", m.Matched())
+	assert.Equal(t, "", m.FeedText(0, "命中后的增量"))
+	assert.Empty(t, m.PendingBlocks())
+}
+
+// TestStopSequenceMatcher_PartialPrefixReleased 看似停止序列开头的尾部在后续不匹配时原样下发
+func TestStopSequenceMatcher_PartialPrefixReleased(t *testing.T) {
+	m := NewStopSequenceMatcher([]string{"###"})
+
+	var out strings.Builder
+	for _, delta := range []string{"标题 #", "# 不是停止序列", "结尾#"} {
+		out.WriteString(m.FeedText(0, delta))
+	}
+	assert.Equal(t, []int{0}, m.PendingBlocks())
+	out.WriteString(m.Flush(0))
+
+	assert.Equal(t, "标题 ## 不是停止序列结尾#", out.String())
+	assert.Equal(t, "", m.Matched())
+}
+
+func newStopSequenceStreamProcessor(t *testing.T, sequences ...string) (*EventStreamProcessor, *recordingSender) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+
+	sender := &recordingSender{}
+	req := types.AnthropicRequest{Model: "claude-sonnet-4", StopSequences: sequences}
+	ctx := NewStreamProcessorContext(c, req, nil, sender, "msg_test", 10)
+	t.Cleanup(ctx.Cleanup)
+	return NewEventStreamProcessor(ctx), sender
+}
+
+// TestProcessEventStream_StopSequenceEndsStream 输出命中停止序列时截断文本、关闭上游并以 stop_sequence 结束
+func TestProcessEventStream_StopSequenceEndsStream(t *testing.T) {
+	processor, sender := newStopSequenceStreamProcessor(t, "STOP")
+
+	reader := newPausingReader(textFrame(t, "第一段 ST"), textFrame(t, "OP 被截断的内容"), textFrame(t, "不再读取"))
+	defer reader.pauseAt(2)() // 命中后不应再等待上游
+
+	require.NoError(t, processor.ProcessEventStream(reader))
+	processor.ctx.FinishStream()
+
+	assert.True(t, reader.isClosed(), "命中停止序列后关闭上游连接")
+	assert.Equal(t, "第一段 ", sender.text())
+	assert.Equal(t, []any{"stop_sequence"}, sender.stopReasons())
+
+	sequence := eventTypes(sender.events)
+	require.GreaterOrEqual(t, len(sequence), 3)
+	assert.Equal(t, []string{"content_block_stop", "message_delta", "message_stop"}, sequence[len(sequence)-3:])
+	for _, event := range sender.events {
+		if event["type"] == "message_delta" {
+			assert.Equal(t, "STOP", event["delta"].(map[string]any)["stop_sequence"])
+		}
+	}
+}
+
+// TestProcessEventStream_StopSequenceNotMatched 未命中时暂存的尾部在块结束前下发，stop_reason 不变
+func TestProcessEventStream_StopSequenceNotMatched(t *testing.T) {
+	processor, sender := newStopSequenceStreamProcessor(t, "STOP")
+
+	require.NoError(t, processor.ProcessEventStream(textDeltaStream(t, "一切正常 S", "T", "art")))
+	processor.ctx.FinishStream()
+
+	assert.Equal(t, "一切正常 STart", sender.text())
+	assert.Equal(t, []any{"end_turn"}, sender.stopReasons())
+}
@@ -0,0 +1,132 @@
+package shared
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"kiro2api/parser"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pausingReader 按段返回上游数据，读取 pauseBefore 段之前阻塞，直到测试调用 resume 或流被关闭
+type pausingReader struct {
+	chunks      [][]byte
+	pauseBefore map[int]chan struct{}
+	served      int
+	closed      chan struct{}
+	closeOnce   sync.Once
+}
+
+func newPausingReader(chunks ...[]byte) *pausingReader {
+	return &pausingReader{
+		chunks:      chunks,
+		pauseBefore: make(map[int]chan struct{}),
+		closed:      make(chan struct{}),
+	}
+}
+
+// pauseAt 在第 i 段之前暂停，返回的函数用于恢复读取
+func (r *pausingReader) pauseAt(i int) func() {
+	resume := make(chan struct{})
+	r.pauseBefore[i] = resume
+	return func() { close(resume) }
+}
+
+func (r *pausingReader) Read(p []byte) (int, error) {
+	if r.served >= len(r.chunks) {
+		return 0, io.EOF
+	}
+	if resume, ok := r.pauseBefore[r.served]; ok {
+		select {
+		case <-resume:
+			delete(r.pauseBefore, r.served)
+		case <-r.closed:
+			return 0, errors.New("read on closed body")
+		}
+	}
+	n := copy(p, r.chunks[r.served])
+	r.chunks[r.served] = r.chunks[r.served][n:]
+	if len(r.chunks[r.served]) == 0 {
+		r.served++
+	}
+	return n, nil
+}
+
+func (r *pausingReader) Close() error {
+	r.closeOnce.Do(func() { close(r.closed) })
+	return nil
+}
+
+func (r *pausingReader) isClosed() bool {
+	select {
+	case <-r.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func textFrame(t *testing.T, text string) []byte {
+	return eventStreamFrame(t, parser.EventTypes.ASSISTANT_RESPONSE_EVENT, map[string]any{"content": text})
+}
+
+// stopReasons 返回 message_delta 事件中的 stop_reason
+func (s *recordingSender) stopReasons() []any {
+	var reasons []any
+	for _, event := range s.events {
+		if event["type"] == "message_delta" {
+			reasons = append(reasons, event["delta"].(map[string]any)["stop_reason"])
+		}
+	}
+	return reasons
+}
+
+func TestProcessEventStream_IdleTimeoutEndsWithMaxTokens(t *testing.T) {
+	processor, sender := newTestStreamProcessor(t)
+	processor.idleTimeout = 50 * time.Millisecond
+
+	reader := newPausingReader(textFrame(t, "第一段"), textFrame(t, "永远不会到达"))
+	defer reader.pauseAt(1)() // 上游在第二段之前停止输出
+
+	start := time.Now()
+	require.NoError(t, processor.ProcessEventStream(reader))
+	assert.Less(t, time.Since(start), 2*time.Second)
+	assert.True(t, reader.isClosed(), "超时后关闭上游连接")
+
+	assert.Equal(t, []string{"第一段"}, sender.textDeltas())
+	sequence := eventTypes(sender.events)
+	require.GreaterOrEqual(t, len(sequence), 3)
+	assert.Equal(t, []string{"content_block_stop", "message_delta", "message_stop"}, sequence[len(sequence)-3:])
+	assert.Equal(t, []any{"max_tokens"}, sender.stopReasons())
+
+	// 调用方随后的 SendFinalEvents 不重复发送结束事件
+	require.NoError(t, processor.ctx.SendFinalEvents())
+	assert.Equal(t, sequence, eventTypes(sender.events))
+}
+
+func TestProcessEventStream_IdleTimerResetsOnEachEvent(t *testing.T) {
+	processor, sender := newTestStreamProcessor(t)
+	processor.idleTimeout = 300 * time.Millisecond
+
+	reader := newPausingReader(textFrame(t, "a"), textFrame(t, "b"), textFrame(t, "c"), textFrame(t, "d"))
+	resumes := []func(){reader.pauseAt(1), reader.pauseAt(2), reader.pauseAt(3)}
+	go func() {
+		// 每次暂停都短于超时，但累计超过超时
+		for _, resume := range resumes {
+			time.Sleep(150 * time.Millisecond)
+			resume()
+		}
+	}()
+
+	require.NoError(t, processor.ProcessEventStream(reader))
+	require.NoError(t, processor.ctx.SendFinalEvents())
+
+	assert.Equal(t, []string{"a", "b", "c", "d"}, sender.textDeltas())
+	assert.Equal(t, []any{"end_turn"}, sender.stopReasons())
+	assert.False(t, reader.isClosed())
+}
@@ -1,10 +1,16 @@
 package shared
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"runtime/debug"
+	"strconv"
 	"strings"
+	"time"
 
+	"kiro2api/config"
+	srvcontext "kiro2api/internal/adapter/httpapi/context"
 	logutil "kiro2api/internal/adapter/httpapi/logging"
 	"kiro2api/internal/stats"
 	"kiro2api/logger"
@@ -24,21 +30,31 @@ type StreamProcessorContext struct {
 	token       *types.TokenWithUsage
 	sender      StreamEventSender
 	messageID   string
-	inputTokens int
+	inputTokens int // 处理器计算的输入token数，message_start 与 message_delta 共用
 
 	// 状态管理器
 	sseStateManager   *SSEStateManager
 	stopReasonManager *StopReasonManager
 	tokenEstimator    *utils.TokenEstimator
+	duplicateDetector *duplicateContentDetector
+	utf8Boundary      *utf8BoundaryBuffer
 
 	// 流解析器
-	compliantParser *parser.CompliantEventStreamParser
+	compliantParser  *parser.CompliantEventStreamParser
+	untrackToolState func() // 注销 TOOL_STATE_FILE 跟踪的工具状态
 
 	// 统计信息
 	totalOutputTokens    int // 累计发送给客户端的输出 token 数
 	totalReadBytes       int
 	totalProcessedEvents int
 	lastParseErr         error
+	strictTerminated     bool   // 严格模式下因SSE事件序列违规终止了流
+	errorTerminated      bool   // 已发送error事件终止流（严格模式违规或工具参数被拒绝），不再发送结束事件
+	finalEventsSent      bool   // SendFinalEvents 已执行，保证结束事件只发送一次
+	usageRecorded        bool   // 已记录token用量
+	stopReason           string // 已确定的 stop_reason，保证 STOP_REASON_OVERRIDES 只应用一次
+	responseBytes        int    // 已发送给客户端的字节数（含SSE填充）
+	largeResponseWarned  bool   // 已输出过大响应警告，每个流只警告一次
 
 	// 工具调用跟踪
 	toolUseIdByBlockIndex map[int]string
@@ -48,6 +64,21 @@ type StreamProcessorContext struct {
 	// 问题：每个 input_json_delta 单独计算 len(partialJSON)/4 会导致小于4字节的分段被舍弃
 	// 解决：累加每个块的JSON字节数，在 content_block_stop 时一次性计算 token
 	jsonBytesByBlockIndex map[int]int // 每个工具块累积的JSON字节数
+
+	// 工具参数校验（VALIDATE_TOOL_ARGS 未启用时 toolArgsValidator 为nil）
+	toolArgsValidator    *ToolArgsValidator
+	toolNameByBlockIndex map[int]string
+	toolArgsByBlockIndex map[int]*strings.Builder
+	heldToolEvents       map[int][]map[string]any // 严格模式下等待校验的工具块事件
+	toolArgsViolations   []ToolArgsViolation      // 告警模式下记录的违规，流结束前追加到文本块
+
+	// RESPONSE_FILTERS 未配置时为nil
+	responseFilter *ResponseFilter
+	// 请求未带 stop_sequences 时为nil
+	stopSequences *StopSequenceMatcher
+
+	// 回复文本的开头部分，仅用于实时监控预览，超出预览所需长度后不再追加
+	previewText strings.Builder
 }
 
 // NewStreamProcessorContext 创建流处理上下文
@@ -59,6 +90,10 @@ func NewStreamProcessorContext(
 	messageID string,
 	inputTokens int,
 ) *StreamProcessorContext {
+	compliantParser := parser.NewCompliantEventStreamParser()
+	// 登记进行中的工具状态，服务关闭时可保存到TOOL_STATE_FILE
+	untrackToolState := TrackToolState(compliantParser)
+
 	return &StreamProcessorContext{
 		c:                     c,
 		req:                   req,
@@ -66,13 +101,22 @@ func NewStreamProcessorContext(
 		sender:                sender,
 		messageID:             messageID,
 		inputTokens:           inputTokens,
-		sseStateManager:       NewSSEStateManager(false),
+		sseStateManager:       NewSSEStateManager(config.IsStrictSSERequested(c.GetHeader(config.StrictSSEHeader))),
 		stopReasonManager:     NewStopReasonManager(req),
-		tokenEstimator:        utils.NewTokenEstimator(),
-		compliantParser:       parser.NewCompliantEventStreamParser(),
+		tokenEstimator:        utils.NewTokenEstimatorForModel(req.Model),
+		duplicateDetector:     newDuplicateContentDetector(),
+		utf8Boundary:          newUTF8BoundaryBuffer(),
+		compliantParser:       compliantParser,
+		untrackToolState:      untrackToolState,
 		toolUseIdByBlockIndex: make(map[int]string),
 		completedToolUseIds:   make(map[string]bool),
 		jsonBytesByBlockIndex: make(map[int]int), // *** 初始化JSON字节累加器 ***
+		toolArgsValidator:     NewToolArgsValidator(req),
+		toolNameByBlockIndex:  make(map[int]string),
+		toolArgsByBlockIndex:  make(map[int]*strings.Builder),
+		heldToolEvents:        make(map[int][]map[string]any),
+		responseFilter:        NewConfiguredResponseFilter(),
+		stopSequences:         NewStopSequenceMatcher(req.StopSequences),
 	}
 }
 
@@ -81,6 +125,9 @@ func NewStreamProcessorContext(
 func (ctx *StreamProcessorContext) Cleanup() {
 	// 重置解析器状态
 	if ctx.compliantParser != nil {
+		if ctx.untrackToolState != nil {
+			ctx.untrackToolState()
+		}
 		ctx.compliantParser.Reset()
 	}
 
@@ -101,13 +148,33 @@ func (ctx *StreamProcessorContext) Cleanup() {
 		ctx.completedToolUseIds = nil
 	}
 
+	// 记录本次流的SSE违规统计
+	if ctx.sseStateManager != nil {
+		violations := ctx.sseStateManager.Violations()
+		rules := make([]string, len(violations))
+		for i, v := range violations {
+			rules[i] = v.Rule
+		}
+		stats.GetSSEViolationCounter().RecordStream(rules, ctx.strictTerminated)
+	}
+
+	// 流未发送结束事件（error事件终止、客户端断开或处理中断）时，按已发送的内容记录用量，客户端配额照常结算
+	if !ctx.usageRecorded {
+		ctx.recordTokenUsage(ctx.totalOutputTokens)
+	}
+	ctx.recordResponseSize()
+	ctx.responseFilter.LogSummary(ctx.c)
+
 	// 清理管理器引用，帮助GC
 	ctx.sseStateManager = nil
 	ctx.stopReasonManager = nil
 	ctx.tokenEstimator = nil
+	ctx.duplicateDetector = nil
+	ctx.utf8Boundary = nil
 }
 
-// InitializeSSEResponse 初始化SSE响应头
+// InitializeSSEResponse 设置SSE响应头并立即提交
+// 提交后状态码固定为200，无法再返回JSON错误，因此必须在上游已接受请求后再调用
 func InitializeSSEResponse(c *gin.Context) error {
 	// 设置SSE响应头，禁用反向代理缓冲
 	c.Header("Content-Type", "text/event-stream; charset=utf-8")
@@ -115,18 +182,99 @@ func InitializeSSEResponse(c *gin.Context) error {
 	c.Header("Connection", "keep-alive")
 	c.Header("X-Accel-Buffering", "no")
 
+	// 客户端支持 trailer 时预先声明，流结束后返回发送给客户端的总字节数
+	if acceptsTrailers(c) {
+		c.Header("Trailer", config.ResponseSizeTrailer)
+	}
+
 	// 确认底层Writer支持Flush
 	if _, ok := c.Writer.(io.Writer); !ok {
 		return fmt.Errorf("writer不支持SSE刷新")
 	}
 
+	// 部分中间代理会缓冲小响应，先输出一段SSE注释填充其缓冲区（客户端会忽略注释行）
+	if config.IsSSEProxyPaddingEnabled() {
+		if _, err := c.Writer.WriteString(":" + strings.Repeat(" ", config.SSEProxyPaddingBytes) + "\n\n"); err != nil {
+			return fmt.Errorf("写入SSE填充失败: %w", err)
+		}
+	}
+
 	c.Writer.Flush()
 	return nil
 }
 
+// sendEvent 经状态管理器发送事件
+// 严格模式下出现违规时向客户端发送携带违规报告的error事件并返回违规错误，调用方应终止流
+func (ctx *StreamProcessorContext) sendEvent(event map[string]any) error {
+	defer ctx.trackResponseBytes()
+
+	err := ctx.sseStateManager.SendEvent(ctx.c, ctx.sender, event)
+	var violationErr *SSEViolationError
+	if !errors.As(err, &violationErr) {
+		return err
+	}
+
+	ctx.strictTerminated = true
+	ctx.errorTerminated = true
+	logger.Error("严格模式SSE事件序列违规，终止流",
+		logutil.AddFields(ctx.c,
+			logger.String("rule", violationErr.Violation.Rule),
+			logger.String("event_type", violationErr.Violation.EventType),
+			logger.Int("sse_violations", len(ctx.sseStateManager.Violations())),
+		)...)
+
+	errorEvent := map[string]any{
+		"type": "error",
+		"error": map[string]any{
+			"type":      "sse_protocol_violation",
+			"message":   violationErr.Violation.Message,
+			"violation": violationErr.Violation,
+		},
+	}
+	if sendErr := ctx.sender.SendEvent(ctx.c, errorEvent); sendErr != nil {
+		logger.Error("发送SSE违规错误事件失败", logger.Err(sendErr))
+	}
+	return err
+}
+
+// trackResponseBytes 按 Writer 已写入的字节数更新发送总量，首次超过 LARGE_RESPONSE_WARNING_BYTES 时输出警告
+func (ctx *StreamProcessorContext) trackResponseBytes() {
+	if size := ctx.c.Writer.Size(); size > ctx.responseBytes {
+		ctx.responseBytes = size
+	}
+
+	threshold := config.LargeResponseWarningBytes()
+	if ctx.largeResponseWarned || ctx.responseBytes <= threshold {
+		return
+	}
+	ctx.largeResponseWarned = true
+	logger.Warn("流式响应体积超过阈值",
+		logutil.AddFields(ctx.c,
+			logger.String("message_id", ctx.messageID),
+			logger.String("model", ctx.req.Model),
+			logger.Int("response_bytes", ctx.responseBytes),
+			logger.Int("threshold_bytes", threshold),
+		)...)
+}
+
+// recordResponseSize 流结束时记录响应体积直方图，客户端支持 trailer 时写入 X-Response-Size
+func (ctx *StreamProcessorContext) recordResponseSize() {
+	ctx.trackResponseBytes()
+	stats.GetResponseSizeHistogram().Record(int64(ctx.responseBytes), ctx.largeResponseWarned)
+
+	if ctx.c.Writer.Header().Get("Trailer") != "" {
+		ctx.c.Writer.Header().Set(config.ResponseSizeTrailer, strconv.Itoa(ctx.responseBytes))
+	}
+}
+
+// acceptsTrailers 请求是否声明了 TE: trailers
+func acceptsTrailers(c *gin.Context) bool {
+	return c.Request != nil && strings.Contains(strings.ToLower(c.GetHeader("TE")), "trailers")
+}
+
 // SendInitialEvents 发送初始事件
 func (ctx *StreamProcessorContext) SendInitialEvents(eventCreator func(string, int, string) []map[string]any) error {
-	// 直接使用上下文中的 inputTokens（已经通过 TokenEstimator 精确计算）
+	// 与 SendFinalEvents 使用同一个 inputTokens，保证 message_start 与 message_delta 的 usage 一致
 	initialEvents := eventCreator(ctx.messageID, ctx.inputTokens, ctx.req.Model)
 
 	// 注意：初始事件现在只包含 message_start 和 ping
@@ -134,15 +282,151 @@ func (ctx *StreamProcessorContext) SendInitialEvents(eventCreator func(string, i
 	// 这避免了发送空内容块（如果上游只返回 tool_use 而没有文本）
 	for _, event := range initialEvents {
 		// 使用状态管理器发送事件
-		if err := ctx.sseStateManager.SendEvent(ctx.c, ctx.sender, event); err != nil {
+		if err := ctx.sendEvent(event); err != nil {
 			logger.Error("初始SSE事件发送失败", logger.Err(err))
 			return err
 		}
 	}
 
+	// 初始事件立即送达，客户端尽早收到首字节
+	ctx.c.Writer.Flush()
 	return nil
 }
 
+// repairTextDelta 修复 text_delta 在UTF-8字符中间断开的问题，返回false表示本次没有可发送的文本
+func (ctx *StreamProcessorContext) repairTextDelta(dataMap map[string]any) bool {
+	delta, ok := dataMap["delta"].(map[string]any)
+	if !ok || delta["type"] != "text_delta" {
+		return true
+	}
+	text, ok := delta["text"].(string)
+	if !ok || text == "" {
+		return true
+	}
+
+	repaired := ctx.utf8Boundary.repair(extractIndex(dataMap), text)
+	delta["text"] = repaired
+	return repaired != ""
+}
+
+// flushUTF8Remainder 块结束前将始终未补全的字节以替换字符发送，避免丢失内容位置
+func (ctx *StreamProcessorContext) flushUTF8Remainder(index int) {
+	text := ctx.utf8Boundary.flush(index)
+	// 命中停止序列后截留的字节在停止序列之后，直接丢弃
+	if text == "" || ctx.stopSequences.Matched() != "" {
+		return
+	}
+
+	logger.Debug("内容块结束时仍有不完整的UTF-8序列，以替换字符发送", logger.Int("block_index", index))
+	event := map[string]any{
+		"type":  "content_block_delta",
+		"index": index,
+		"delta": map[string]any{"type": "text_delta", "text": text},
+	}
+	if err := ctx.sendEvent(event); err != nil {
+		logger.Error("发送UTF-8替换字符失败", logger.Err(err))
+		return
+	}
+	ctx.totalOutputTokens += ctx.tokenEstimator.EstimateTextTokens(text)
+}
+
+// filterDelta 对增量应用 RESPONSE_FILTERS，返回false表示本次没有可发送的内容
+// 文本末尾窗口内的原文和工具参数暂存在过滤器中，块结束时由 Flush 取出
+func (ctx *StreamProcessorContext) filterDelta(dataMap map[string]any) bool {
+	delta, ok := dataMap["delta"].(map[string]any)
+	if !ok {
+		return true
+	}
+	index := extractIndex(dataMap)
+
+	switch delta["type"] {
+	case "text_delta":
+		if !ctx.responseFilter.FiltersText() {
+			return true
+		}
+		text, _ := delta["text"].(string)
+		// 按原文记录，替换后上游重发的完整文本仍能识别
+		ctx.duplicateDetector.record(index, text)
+		filtered := ctx.responseFilter.FilterTextDelta(index, text)
+		delta["text"] = filtered
+		return filtered != ""
+
+	case "input_json_delta":
+		partialJSON, _ := delta["partial_json"].(string)
+		return !ctx.responseFilter.BufferToolArgs(index, partialJSON)
+	}
+	return true
+}
+
+// applyStopSequences 按 stop_sequences 截断文本增量，返回false表示本次没有可发送的文本
+// 末尾可能是停止序列开头的部分暂存在匹配器中，块结束时由 flushStopSequenceCarry 取出
+func (ctx *StreamProcessorContext) applyStopSequences(dataMap map[string]any) bool {
+	if ctx.stopSequences == nil {
+		return true
+	}
+	delta, ok := dataMap["delta"].(map[string]any)
+	if !ok || delta["type"] != "text_delta" {
+		return true
+	}
+
+	text, _ := delta["text"].(string)
+	index := extractIndex(dataMap)
+	// 按截断前的原文记录，上游重发的完整文本仍能识别；过滤器已按原文记录时不重复记录
+	if !ctx.responseFilter.FiltersText() {
+		ctx.duplicateDetector.record(index, text)
+	}
+	text = ctx.stopSequences.FeedText(index, text)
+	if seq := ctx.stopSequences.Matched(); seq != "" {
+		ctx.stopReasonManager.SetStopSequence(seq)
+	}
+	delta["text"] = text
+	return text != ""
+}
+
+// stopSequenceCarryEvent 取出匹配器暂存的文本尾部，没有暂存内容时返回nil
+func (ctx *StreamProcessorContext) stopSequenceCarryEvent(index int) map[string]any {
+	text := ctx.stopSequences.Flush(index)
+	if text == "" {
+		return nil
+	}
+	return map[string]any{
+		"type":  "content_block_delta",
+		"index": index,
+		"delta": map[string]any{"type": "text_delta", "text": text},
+	}
+}
+
+// flushFilteredRemainders 流提前结束时发送过滤器和停止序列匹配器中所有块暂存的内容
+func (ctx *StreamProcessorContext) flushFilteredRemainders() {
+	for _, index := range ctx.responseFilter.PendingBlocks() {
+		event := ctx.responseFilter.Flush(index)
+		if event == nil || !ctx.applyStopSequences(event) {
+			continue
+		}
+		ctx.sendRemainder(event)
+	}
+	for _, index := range ctx.stopSequences.PendingBlocks() {
+		if event := ctx.stopSequenceCarryEvent(index); event != nil {
+			ctx.sendRemainder(event)
+		}
+	}
+}
+
+// sendRemainder 发送暂存内容并累计输出 token
+func (ctx *StreamProcessorContext) sendRemainder(event map[string]any) {
+	if err := ctx.sendEvent(event); err != nil {
+		logger.Error("发送过滤后的暂存内容失败", logger.Err(err), logger.Any("index", event["index"]))
+		return
+	}
+
+	delta, _ := event["delta"].(map[string]any)
+	if text, ok := delta["text"].(string); ok {
+		ctx.totalOutputTokens += ctx.tokenEstimator.EstimateTextTokens(text)
+	} else if partialJSON, ok := delta["partial_json"].(string); ok {
+		ctx.totalOutputTokens += (len(partialJSON) + 3) / 4
+	}
+}
+
 // processToolUseStart 处理工具使用开始事件
 func (ctx *StreamProcessorContext) processToolUseStart(dataMap map[string]any) {
 	cb, ok := dataMap["content_block"].(map[string]any)
@@ -169,6 +453,9 @@ func (ctx *StreamProcessorContext) processToolUseStart(dataMap map[string]any) {
 
 	// 记录索引到tool_use_id的映射
 	ctx.toolUseIdByBlockIndex[idx] = id
+	if ctx.toolArgsValidator != nil {
+		ctx.toolNameByBlockIndex[idx] = getStringField(cb, "name")
+	}
 
 	logger.Debug("转发tool_use开始",
 		logger.String("tool_use_id", id),
@@ -212,9 +499,64 @@ func (ctx *StreamProcessorContext) processToolUseStop(dataMap map[string]any) {
 
 // 直传模式：不再进行文本聚合
 
-// SendFinalEvents 发送结束事件
+// FinishStream 保证流以结束事件收尾，必须以 defer 调用且在 Cleanup 之前执行
+// 事件处理panic时先恢复并向客户端发送error事件，再照常关闭内容块并发送 message_delta 和 message_stop
+func (ctx *StreamProcessorContext) FinishStream() {
+	if r := recover(); r != nil {
+		logger.Error("事件流处理panic，结束流",
+			logutil.AddFields(ctx.c,
+				logger.Any("panic", r),
+				logger.String("stack", string(debug.Stack())),
+			)...)
+		stats.GetPanicCounter().Record(ctx.c.FullPath())
+		ctx.sendPanicError()
+	}
+
+	// 结束事件经同一个sender发送，sender本身出错时不再让panic传出handler
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error("发送结束事件时panic", logutil.AddFields(ctx.c, logger.Any("panic", r))...)
+		}
+	}()
+	if err := ctx.SendFinalEvents(); err != nil {
+		logger.Error("发送结束事件失败", logger.Err(err))
+	}
+	ctx.c.Writer.Flush()
+}
+
+// sendPanicError 发送通用的内部错误事件，直接经sender发送，不计入SSE事件序列
+// panic内容只写入日志，客户端凭消息中的请求ID对应日志
+func (ctx *StreamProcessorContext) sendPanicError() {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error("发送panic错误事件失败", logutil.AddFields(ctx.c, logger.Any("panic", r))...)
+		}
+	}()
+
+	requestID := srvcontext.GetRequestID(ctx.c)
+	errorEvent := map[string]any{
+		"type": "error",
+		"error": map[string]any{
+			"type":    "api_error",
+			"message": fmt.Sprintf("服务内部错误 (request id: %s)", requestID),
+		},
+		"request_id": requestID,
+	}
+	if err := ctx.sender.SendEvent(ctx.c, errorEvent); err != nil {
+		logger.Error("发送panic错误事件失败", logger.Err(err))
+	}
+}
+
+// SendFinalEvents 发送结束事件，重复调用时不再发送
+// 已发送error事件终止的流不再发送结束事件；上游异常已转换为结束事件时只补充统计
 func (ctx *StreamProcessorContext) SendFinalEvents() error {
-	// 关闭所有未关闭的content_block
+	if ctx.finalEventsSent || ctx.errorTerminated {
+		return nil
+	}
+	ctx.finalEventsSent = true
+
+	// 关闭所有未关闭的content_block；过滤器暂存的内容可能尚未开始块，先发送再取活跃块
+	ctx.flushFilteredRemainders()
 	activeBlocks := ctx.sseStateManager.GetActiveBlocks()
 	for index, block := range activeBlocks {
 		if block.Started && !block.Stopped {
@@ -223,11 +565,13 @@ func (ctx *StreamProcessorContext) SendFinalEvents() error {
 				"index": index,
 			}
 			logger.Debug("最终事件前关闭未关闭的content_block", logger.Int("index", index))
-			if err := ctx.sseStateManager.SendEvent(ctx.c, ctx.sender, stopEvent); err != nil {
+			ctx.flushUTF8Remainder(index)
+			if err := ctx.sendEvent(stopEvent); err != nil {
 				logger.Error("关闭content_block失败", logger.Err(err), logger.Int("index", index))
 			}
 		}
 	}
+	ctx.sendToolArgsWarnings()
 
 	// 更新工具调用状态
 	// 使用已完成工具集合来判断，因为toolUseIdByBlockIndex在stop时已被清空
@@ -265,28 +609,53 @@ func (ctx *StreamProcessorContext) SendFinalEvents() error {
 		}
 	}
 
-	// 确定stop_reason
-	stopReason := ctx.stopReasonManager.DetermineStopReason()
+	// 确定stop_reason；上游内容长度超限时已在 sendMaxTokensStop 中确定
+	stopReason := ctx.finalStopReason()
 
 	logger.Debug("创建结束事件",
 		logger.String("stop_reason", stopReason),
 		logger.String("stop_reason_description", GetStopReasonDescription(stopReason)),
 		logger.Int("output_tokens", outputTokens))
 
-	// 创建并发送结束事件
-	finalEvents := CreateAnthropicFinalEvents(outputTokens, ctx.inputTokens, stopReason)
-	for _, event := range finalEvents {
-		if err := ctx.sseStateManager.SendEvent(ctx.c, ctx.sender, event); err != nil {
-			logger.Error("结束事件发送违规", logger.Err(err))
+	// 创建并发送结束事件；上游异常已转换为结束事件时不再重复发送
+	if !ctx.sseStateManager.IsMessageEnded() {
+		finalEvents := CreateAnthropicFinalEvents(outputTokens, ctx.inputTokens, stopReason)
+		if stopReason == "stop_sequence" {
+			finalEvents[0]["delta"].(map[string]any)["stop_sequence"] = ctx.stopReasonManager.StopSequence()
+		}
+		for _, event := range finalEvents {
+			if err := ctx.sendEvent(event); err != nil {
+				logger.Error("结束事件发送违规", logger.Err(err))
+				break
+			}
 		}
 	}
+	srvcontext.SetSSEViolations(ctx.c, len(ctx.sseStateManager.Violations()))
+	ctx.trackResponseBytes()
+	srvcontext.SetResponseBytes(ctx.c, ctx.responseBytes)
 
 	// 记录 token 使用统计
-	stats.GetCollector().Record(ctx.inputTokens, outputTokens, ctx.req.Model)
+	ctx.recordTokenUsage(outputTokens)
+	stats.GetModelUpstreamStats().RecordStopReason(ctx.req.Model, stopReason)
+	RecordConversationTurn(ctx.c, ctx.req, ctx.inputTokens, outputTokens, stopReason, ctx.previewText.String())
 
 	return nil
 }
 
+// finalStopReason 确定本次响应的 stop_reason（已应用 STOP_REASON_OVERRIDES），多次调用返回同一个值
+func (ctx *StreamProcessorContext) finalStopReason() string {
+	if ctx.stopReason == "" {
+		ctx.stopReason = ctx.stopReasonManager.DetermineStopReason()
+	}
+	return ctx.stopReason
+}
+
+// recordTokenUsage 记录本次流的token用量，只记录一次
+func (ctx *StreamProcessorContext) recordTokenUsage(outputTokens int) {
+	ctx.usageRecorded = true
+	RecordTokenUsage(ctx.c, ctx.inputTokens, outputTokens, ctx.req.Model)
+}
+
 // 辅助函数
 
 // extractIndex 从数据映射中提取索引
@@ -311,27 +680,92 @@ func getStringField(m map[string]any, key string) string {
 // EventStreamProcessor 事件流处理器
 // 遵循单一职责原则：专注于处理事件流
 type EventStreamProcessor struct {
-	ctx *StreamProcessorContext
+	ctx     *StreamProcessorContext
+	flusher *FlushBatcher
+
+	idleTimeout time.Duration // 上游没有产生事件的最长等待时间
+	idleTimer   *time.Timer   // 每次 processEvent 重置，仅在 ProcessEventStream 期间存在
 }
 
 // NewEventStreamProcessor 创建事件流处理器
 func NewEventStreamProcessor(ctx *StreamProcessorContext) *EventStreamProcessor {
 	return &EventStreamProcessor{
-		ctx: ctx,
+		ctx:         ctx,
+		flusher:     NewFlushBatcher(ctx.c.Writer, config.FlushBatchSize(), config.FlushTimeout()),
+		idleTimeout: config.StreamIdleTimeout(),
 	}
 }
 
+// streamChunk 读取协程从上游读到的一段数据
+type streamChunk struct {
+	data []byte
+	err  error
+}
+
+// readStreamChunks 在独立协程中读取上游流，使主循环可以同时等待空闲超时
+// done 关闭后协程在当前 Read 返回时退出；读到错误（含EOF）后发送最后一段并退出
+func readStreamChunks(reader io.Reader, done <-chan struct{}) <-chan streamChunk {
+	chunks := make(chan streamChunk)
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, err := reader.Read(buf)
+			chunk := streamChunk{data: append([]byte(nil), buf[:n]...), err: err}
+			select {
+			case chunks <- chunk:
+			case <-done:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return chunks
+}
+
 // ProcessEventStream 处理事件流的主循环
+// 超过 idleTimeout 没有产生事件时关闭上游流，并以合成的 max_tokens 结束消息
 func (esp *EventStreamProcessor) ProcessEventStream(reader io.Reader) error {
-	buf := make([]byte, 1024)
+	// 返回前刷新剩余的批量事件并停止兜底定时器
+	defer esp.flusher.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	chunks := readStreamChunks(reader, done)
+
+	esp.idleTimer = time.NewTimer(esp.idleTimeout)
+	defer func() {
+		esp.idleTimer.Stop()
+		esp.idleTimer = nil
+	}()
+
+	idleTimedOut := false
+	for !idleTimedOut {
+		var chunk streamChunk
+		select {
+		case chunk = <-chunks:
+		case <-esp.idleTimer.C:
+			idleTimedOut = true
+			logger.Warn("上游流空闲超时，以max_tokens结束",
+				logutil.AddFields(esp.ctx.c,
+					logger.Duration("idle_timeout", esp.idleTimeout),
+					logger.Int("total_read_bytes", esp.ctx.totalReadBytes),
+					logger.Int("processed_events", esp.ctx.totalProcessedEvents),
+				)...)
+			// 关闭上游连接，使阻塞中的读取返回
+			if closer, ok := reader.(io.Closer); ok {
+				_ = closer.Close()
+			}
+			continue
+		}
 
-	for {
-		n, err := reader.Read(buf)
+		n, err := len(chunk.data), chunk.err
 		esp.ctx.totalReadBytes += n
 
 		if n > 0 {
 			// 解析事件流
-			events, parseErr := esp.ctx.compliantParser.ParseStream(buf[:n])
+			events, parseErr := esp.ctx.compliantParser.ParseStream(chunk.data)
 			esp.ctx.lastParseErr = parseErr
 
 			if parseErr != nil {
@@ -347,18 +781,41 @@ func (esp *EventStreamProcessor) ProcessEventStream(reader io.Reader) error {
 
 			// 处理每个事件
 			for _, event := range events {
-				if err := esp.processEvent(event); err != nil {
-					return err
+				var processErr error
+				esp.flusher.Write(func() {
+					processErr = esp.processEvent(event)
+				})
+				if processErr != nil {
+					return processErr
 				}
 			}
 		}
 
+		// 命中停止序列后不再读取上游，关闭连接使读取协程退出
+		if esp.ctx.stopSequences.Matched() != "" {
+			logger.Debug("输出命中停止序列，结束上游流",
+				logutil.AddFields(esp.ctx.c,
+					logger.String("stop_sequence", esp.ctx.stopSequences.Matched()),
+				)...)
+			if closer, ok := reader.(io.Closer); ok {
+				_ = closer.Close()
+			}
+			break
+		}
+
 		if err != nil {
 			if err == io.EOF {
 				logger.Debug("响应流结束",
 					logutil.AddFields(esp.ctx.c,
 						logger.Int("total_read_bytes", esp.ctx.totalReadBytes),
+						logger.Int("recovered_frames", esp.ctx.compliantParser.RecoveredFrames()),
 					)...)
+				if pending := esp.ctx.compliantParser.PendingBytes(); pending > 0 {
+					logger.Warn("响应流结束时最后一帧不完整，已丢弃",
+						logutil.AddFields(esp.ctx.c,
+							logger.Int("pending_bytes", pending),
+						)...)
+				}
 			} else {
 				logger.Error("读取响应流时发生错误",
 					logutil.AddFields(esp.ctx.c,
@@ -371,12 +828,27 @@ func (esp *EventStreamProcessor) ProcessEventStream(reader io.Reader) error {
 		}
 	}
 
-	// 直传模式：无需冲刷剩余文本
-	return nil
+	// 上游未发送 content_block_stop 的工具块在流结束时校验参数
+	var checkErr error
+	esp.flusher.Write(func() {
+		checkErr = esp.checkPendingToolArgs()
+		if checkErr == nil && idleTimedOut && !esp.ctx.sseStateManager.IsMessageEnded() {
+			esp.sendMaxTokensStop()
+		}
+	})
+	return checkErr
 }
 
 // processEvent 处理单个事件
 func (esp *EventStreamProcessor) processEvent(event parser.SSEEvent) error {
+	if esp.idleTimer != nil {
+		esp.idleTimer.Reset(esp.idleTimeout)
+	}
+	// 命中停止序列后的内容不再下发，流在本批事件处理完后结束
+	if esp.ctx.stopSequences.Matched() != "" {
+		return nil
+	}
+
 	dataMap, ok := event.Data.(map[string]any)
 	if !ok {
 		logger.Warn("事件数据类型不匹配,跳过", logger.String("event_type", event.Event))
@@ -389,12 +861,46 @@ func (esp *EventStreamProcessor) processEvent(event parser.SSEEvent) error {
 	switch eventType {
 	case "content_block_start":
 		esp.ctx.processToolUseStart(dataMap)
+		if esp.ctx.holdToolEvent(dataMap) {
+			return nil
+		}
 
 	case "content_block_delta":
+		// 截留被拆开的多字节字符，整个增量都被截留时等下一个增量再发送
+		if !esp.ctx.repairTextDelta(dataMap) {
+			return nil
+		}
 		// 直传：不做聚合
 		// 但需要统计输出字符数（在后面统一处理）
+		// 上游重发的完整文本直接丢弃，不转发也不计入 token
+		if esp.isDuplicateFinalText(dataMap) {
+			return nil
+		}
+		if !esp.ctx.filterDelta(dataMap) {
+			return nil
+		}
+		if !esp.ctx.applyStopSequences(dataMap) {
+			return nil
+		}
+		if esp.ctx.holdToolEvent(dataMap) {
+			return nil
+		}
 
 	case "content_block_stop":
+		// 过滤器暂存的原文在截留的UTF-8字节之前
+		if err := esp.flushResponseFilter(extractIndex(dataMap)); err != nil {
+			return err
+		}
+		if event := esp.ctx.stopSequenceCarryEvent(extractIndex(dataMap)); event != nil {
+			if err := esp.forwardEvent(event); err != nil {
+				return err
+			}
+		}
+		esp.ctx.flushUTF8Remainder(extractIndex(dataMap))
+		// 校验工具参数，严格模式下通过后才转发暂存的 start/delta 事件
+		if err := esp.checkToolArgs(dataMap); err != nil {
+			return err
+		}
 		esp.ctx.processToolUseStop(dataMap)
 
 	case "message_delta":
@@ -404,12 +910,25 @@ func (esp *EventStreamProcessor) processEvent(event parser.SSEEvent) error {
 		if esp.handleExceptionEvent(dataMap) {
 			return nil // 已转换并发送，不转发原始exception事件
 		}
+		if esp.ctx.strictTerminated {
+			return errors.New("严格模式SSE事件序列违规，流已终止")
+		}
 	}
 
+	return esp.forwardEvent(dataMap)
+}
+
+// forwardEvent 发送事件并按实际发送的内容累计输出 token
+func (esp *EventStreamProcessor) forwardEvent(dataMap map[string]any) error {
+	eventType, _ := dataMap["type"].(string)
+
 	// 使用状态管理器发送事件（直传）
-	if err := esp.ctx.sseStateManager.SendEvent(esp.ctx.c, esp.ctx.sender, dataMap); err != nil {
+	if err := esp.ctx.sendEvent(dataMap); err != nil {
 		logger.Error("SSE事件发送违规", logger.Err(err))
-		// 非严格模式下，违规事件被跳过但不中断流
+		// 非严格模式下，违规事件被跳过但不中断流；严格模式下已发送error事件，终止流
+		if esp.ctx.strictTerminated {
+			return err
+		}
 	}
 
 	// *** 关键修复：基于实际发送的 SSE 事件内容累计 token ***
@@ -429,6 +948,12 @@ func (esp *EventStreamProcessor) processEvent(event parser.SSEEvent) error {
 				// 文本内容增量
 				if text, ok := delta["text"].(string); ok {
 					esp.ctx.totalOutputTokens += esp.ctx.tokenEstimator.EstimateTextTokens(text)
+					if !esp.ctx.responseFilter.FiltersText() && esp.ctx.stopSequences == nil {
+						esp.ctx.duplicateDetector.record(extractIndex(dataMap), text)
+					}
+					if esp.ctx.previewText.Len() < config.TranscriptPreviewChars*4 {
+						esp.ctx.previewText.WriteString(text)
+					}
 				}
 
 			case "input_json_delta":
@@ -466,10 +991,44 @@ func (esp *EventStreamProcessor) processEvent(event parser.SSEEvent) error {
 		// 不包含实际内容，不累计 token
 	}
 
-	esp.ctx.c.Writer.Flush()
+	// 刷新由 FlushBatcher 按批量策略统一处理
 	return nil
 }
 
+// flushResponseFilter 块结束前转发过滤器暂存的文本尾部或聚合后的工具参数
+// 工具参数经 holdToolEvent 聚合，参数校验基于替换后的内容
+func (esp *EventStreamProcessor) flushResponseFilter(index int) error {
+	event := esp.ctx.responseFilter.Flush(index)
+	if event == nil || !esp.ctx.applyStopSequences(event) || esp.ctx.holdToolEvent(event) {
+		return nil
+	}
+	return esp.forwardEvent(event)
+}
+
+// isDuplicateFinalText 检查 text_delta 是否为上游重发的完整文本
+func (esp *EventStreamProcessor) isDuplicateFinalText(dataMap map[string]any) bool {
+	delta, ok := dataMap["delta"].(map[string]any)
+	if !ok || delta["type"] != "text_delta" {
+		return false
+	}
+	text, ok := delta["text"].(string)
+	if !ok {
+		return false
+	}
+
+	index := extractIndex(dataMap)
+	if !esp.ctx.duplicateDetector.isDuplicate(index, text) {
+		return false
+	}
+
+	logger.Debug("duplicate_final_content",
+		logutil.AddFields(esp.ctx.c,
+			logger.Int("block_index", index),
+			logger.Int("suppressed_bytes", len(text)),
+		)...)
+	return true
+}
+
 // processContentBlockDelta 处理content_block_delta事件
 // 返回true表示已处理（聚合），不需要转发原始事件
 // processContentBlockDelta 已废弃（直传模式不再需要）
@@ -489,53 +1048,63 @@ func (esp *EventStreamProcessor) handleExceptionEvent(dataMap map[string]any) bo
 				logger.String("exception_type", exceptionType),
 				logger.String("claude_stop_reason", "max_tokens"))...)
 
-		// 关闭所有活跃的content_block
-		activeBlocks := esp.ctx.sseStateManager.GetActiveBlocks()
-		for index, block := range activeBlocks {
-			if block.Started && !block.Stopped {
-				stopEvent := map[string]any{
-					"type":  "content_block_stop",
-					"index": index,
-				}
-				_ = esp.ctx.sseStateManager.SendEvent(esp.ctx.c, esp.ctx.sender, stopEvent)
-			}
-		}
+		// 已转换并发送，不转发原始exception
+		return esp.sendMaxTokensStop()
+	}
 
-		// 构造符合Claude规范的max_tokens响应
-		maxTokensEvent := map[string]any{
-			"type": "message_delta",
-			"delta": map[string]any{
-				"stop_reason":   "max_tokens",
-				"stop_sequence": nil,
-			},
-			"usage": map[string]any{
-				"input_tokens":  esp.ctx.inputTokens,
-				"output_tokens": esp.ctx.totalOutputTokens,
-			},
-		}
+	// 其他类型的异常，正常转发
+	return false
+}
 
-		// 发送max_tokens事件
-		if err := esp.ctx.sseStateManager.SendEvent(esp.ctx.c, esp.ctx.sender, maxTokensEvent); err != nil {
-			logger.Error("发送max_tokens响应失败", logger.Err(err))
-			return false
-		}
+// sendMaxTokensStop 关闭所有活跃的内容块，并以 stop_reason=max_tokens（按 STOP_REASON_OVERRIDES 替换）的 message_delta 和 message_stop 结束消息
+// 之后 SendFinalEvents 不再重复发送结束事件，只沿用同一个 stop_reason 记录统计；发送失败时返回false
+func (esp *EventStreamProcessor) sendMaxTokensStop() bool {
+	esp.ctx.stopReasonManager.SetMaxTokens()
+	stopReason := esp.ctx.finalStopReason()
 
-		// 发送message_stop事件
-		stopEvent := map[string]any{
-			"type": "message_stop",
-		}
-		if err := esp.ctx.sseStateManager.SendEvent(esp.ctx.c, esp.ctx.sender, stopEvent); err != nil {
-			logger.Error("发送message_stop失败", logger.Err(err))
-			return false
+	// 关闭所有活跃的content_block
+	esp.ctx.flushFilteredRemainders()
+	activeBlocks := esp.ctx.sseStateManager.GetActiveBlocks()
+	for index, block := range activeBlocks {
+		if block.Started && !block.Stopped {
+			stopEvent := map[string]any{
+				"type":  "content_block_stop",
+				"index": index,
+			}
+			_ = esp.ctx.sendEvent(stopEvent)
 		}
+	}
 
-		esp.ctx.c.Writer.Flush()
+	// 构造符合Claude规范的max_tokens响应
+	maxTokensEvent := map[string]any{
+		"type": "message_delta",
+		"delta": map[string]any{
+			"stop_reason":   stopReason,
+			"stop_sequence": nil,
+		},
+		"usage": map[string]any{
+			"input_tokens":  esp.ctx.inputTokens,
+			"output_tokens": esp.ctx.totalOutputTokens,
+		},
+	}
 
-		return true // 已转换并发送，不转发原始exception
+	// 发送max_tokens事件
+	if err := esp.ctx.sendEvent(maxTokensEvent); err != nil {
+		logger.Error("发送max_tokens响应失败", logger.Err(err))
+		return false
 	}
 
-	// 其他类型的异常，正常转发
-	return false
+	// 发送message_stop事件
+	stopEvent := map[string]any{
+		"type": "message_stop",
+	}
+	if err := esp.ctx.sendEvent(stopEvent); err != nil {
+		logger.Error("发送message_stop失败", logger.Err(err))
+		return false
+	}
+
+	esp.ctx.c.Writer.Flush()
+	return true
 }
 
 // 直传模式：无flush逻辑
@@ -0,0 +1,130 @@
+package shared
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"kiro2api/config"
+	srvcontext "kiro2api/internal/adapter/httpapi/context"
+	"kiro2api/internal/stats"
+	"kiro2api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInitializeSSEResponse_ProxyPadding(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Run("默认不输出填充", func(t *testing.T) {
+		t.Setenv("SSE_PROXY_PADDING", "")
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+
+		require.NoError(t, InitializeSSEResponse(c))
+		assert.True(t, w.Flushed)
+		assert.Equal(t, "text/event-stream; charset=utf-8", w.Header().Get("Content-Type"))
+		assert.Empty(t, w.Body.String())
+	})
+
+	t.Run("启用后输出注释填充行", func(t *testing.T) {
+		t.Setenv("SSE_PROXY_PADDING", "true")
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+
+		require.NoError(t, InitializeSSEResponse(c))
+		body := w.Body.String()
+		assert.True(t, strings.HasPrefix(body, ":"), "填充必须是SSE注释行")
+		assert.True(t, strings.HasSuffix(body, "\n\n"))
+		assert.GreaterOrEqual(t, len(body), config.SSEProxyPaddingBytes)
+		assert.Equal(t, 1, strings.Count(body, "\n\n"), "填充应为单个注释事件")
+	})
+}
+
+func TestStreamProcessorContext_LargeResponseWarning(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("LARGE_RESPONSE_WARNING_BYTES", "512")
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	c.Request.Header.Set("TE", "trailers")
+	require.NoError(t, InitializeSSEResponse(c))
+	assert.Equal(t, config.ResponseSizeTrailer, w.Header().Get("Trailer"))
+
+	ctx := NewStreamProcessorContext(c, types.AnthropicRequest{Model: "claude-sonnet-4"}, nil, &AnthropicStreamSender{}, "msg_large", 10)
+	largeBefore := stats.GetResponseSizeHistogram().Snapshot().LargeResponses
+
+	require.NoError(t, ctx.sendEvent(map[string]any{
+		"type":    "message_start",
+		"message": map[string]any{"id": "msg_large", "type": "message", "role": "assistant", "content": []any{}},
+	}))
+	assert.Equal(t, w.Body.Len(), ctx.responseBytes)
+	assert.False(t, ctx.largeResponseWarned, "未超过阈值时不警告")
+
+	require.NoError(t, ctx.sendEvent(map[string]any{
+		"type":  "content_block_delta",
+		"index": 0,
+		"delta": map[string]any{"type": "text_delta", "text": strings.Repeat("a", 600)},
+	}))
+	assert.Greater(t, ctx.responseBytes, 512)
+	assert.True(t, ctx.largeResponseWarned)
+
+	ctx.Cleanup()
+	assert.Equal(t, strconv.Itoa(w.Body.Len()), w.Header().Get(config.ResponseSizeTrailer))
+	assert.Equal(t, largeBefore+1, stats.GetResponseSizeHistogram().Snapshot().LargeResponses)
+}
+
+func TestInitializeSSEResponse_NoTrailerWithoutTE(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+
+	require.NoError(t, InitializeSSEResponse(c))
+	assert.Empty(t, w.Header().Get("Trailer"))
+}
+
+func TestStreamProcessorContext_FinishStreamHidesPanicDetails(t *testing.T) {
+	processor, sender := newTestStreamProcessor(t)
+	srvcontext.SetRequestID(processor.ctx.c, "req_panic")
+
+	func() {
+		defer processor.ctx.FinishStream()
+		panic("token=secret-value")
+	}()
+
+	var errorEvent map[string]any
+	for _, event := range sender.events {
+		if event["type"] == "error" {
+			errorEvent = event
+		}
+	}
+	require.NotNil(t, errorEvent)
+	message := errorEvent["error"].(map[string]any)["message"].(string)
+	assert.NotContains(t, message, "secret-value", "panic内容不应返回给客户端")
+	assert.Contains(t, message, "req_panic")
+	assert.Equal(t, "req_panic", errorEvent["request_id"])
+}
+
+// TestStreamProcessorContext_CleanupRecordsPartialUsage 流未发送结束事件就中断时，按已发送的内容记录用量供客户端配额结算
+func TestStreamProcessorContext_CleanupRecordsPartialUsage(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	ctx := NewStreamProcessorContext(c, types.AnthropicRequest{Model: "claude-sonnet-4"}, nil, &recordingSender{}, "msg_test", 10)
+
+	require.NoError(t, NewEventStreamProcessor(ctx).ProcessEventStream(textDeltaStream(t, "客户端断开前已经收到的一段回复")))
+	_, recorded := srvcontext.GetTokenUsage(c)
+	require.False(t, recorded)
+
+	ctx.Cleanup()
+	usage, recorded := srvcontext.GetTokenUsage(c)
+	require.True(t, recorded)
+	assert.Equal(t, 10, usage.InputTokens)
+	assert.Positive(t, usage.OutputTokens)
+}
@@ -0,0 +1,59 @@
+package shared
+
+import (
+	"kiro2api/converter"
+	srvcontext "kiro2api/internal/adapter/httpapi/context"
+	logutil "kiro2api/internal/adapter/httpapi/logging"
+	"kiro2api/logger"
+	"kiro2api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CheckStructuredOutput 请求设置了 json_object / json_schema 且模型没有调用工具时，校验非流式响应的文本
+// 通过时返回去掉代码块围栏后的JSON文本；不通过时返回 *converter.SchemaViolation，由调用方以422响应
+func CheckStructuredOutput(c *gin.Context, req types.AnthropicRequest, text string, sawToolUse bool) (string, error) {
+	if !converter.IsStructuredOutput(req.ResponseFormat) || sawToolUse {
+		return text, nil
+	}
+
+	cleaned, err := converter.ValidateStructuredOutput(req.ResponseFormat, text)
+	if err != nil {
+		logger.Warn("模型输出不符合 response_format 约束",
+			logutil.AddFields(c,
+				logger.String("response_format", req.ResponseFormat.Type),
+				logger.Err(err),
+				logger.Int("text_length", len(text)),
+			)...)
+		return "", err
+	}
+	return cleaned, nil
+}
+
+// structuredOutputRetriedKey 已按 json_schema 纠错重试过的上下文标记
+const structuredOutputRetriedKey = "structured_output_retried"
+
+// StructuredOutputRetryRequest json_schema 校验失败时返回用于重试的请求：追加模型上一次的输出和纠错消息
+// 每个客户端请求最多重试一次，仅用于非流式响应；json_object 或已重试过时返回false，由调用方以422响应
+func StructuredOutputRetryRequest(c *gin.Context, req types.AnthropicRequest, text string, violation error) (types.AnthropicRequest, bool) {
+	if req.ResponseFormat == nil || req.ResponseFormat.Type != converter.ResponseFormatJSONSchema || c.GetBool(structuredOutputRetriedKey) {
+		return req, false
+	}
+	c.Set(structuredOutputRetriedKey, true)
+
+	messages := append([]types.AnthropicRequestMessage(nil), req.Messages...)
+	if text != "" {
+		messages = append(messages, types.AnthropicRequestMessage{Role: "assistant", Content: text})
+	}
+	messages = append(messages, types.AnthropicRequestMessage{
+		Role:    "user",
+		Content: converter.StructuredOutputCorrection(violation),
+	})
+	req.Messages = messages
+	// 追加纠错往返后发往上游的请求变了，重新记录输入token
+	srvcontext.SetInputTokens(c, EstimateRequestInputTokens(c, req))
+
+	logger.Info("模型输出不符合 json_schema，附加纠错指令重试一次",
+		logutil.AddFields(c, logger.Err(violation))...)
+	return req, true
+}
@@ -0,0 +1,244 @@
+package shared
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"kiro2api/config"
+	"kiro2api/converter"
+	logutil "kiro2api/internal/adapter/httpapi/logging"
+	"kiro2api/logger"
+	"kiro2api/parser"
+	"kiro2api/types"
+	"kiro2api/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ToolArgsViolation 一次不符合 input_schema 的工具调用
+type ToolArgsViolation struct {
+	ToolUseID string `json:"tool_use_id"`
+	Name      string `json:"name"`
+	Path      string `json:"path"`
+	Message   string `json:"message"`
+}
+
+func (v ToolArgsViolation) String() string {
+	return fmt.Sprintf("%s (%s) %s: %s", v.Name, v.ToolUseID, v.Path, v.Message)
+}
+
+// ToolArgsValidator 按请求中工具的 input_schema 校验模型生成的工具参数（VALIDATE_TOOL_ARGS）
+type ToolArgsValidator struct {
+	reject  bool
+	schemas map[string]map[string]any // 工具名 -> input_schema
+}
+
+// NewToolArgsValidator 创建校验器；未启用或请求中没有带 input_schema 的工具时返回nil
+func NewToolArgsValidator(req types.AnthropicRequest) *ToolArgsValidator {
+	mode := config.ToolArgsValidationMode()
+	if mode == config.ToolArgsValidationOff {
+		return nil
+	}
+
+	schemas := make(map[string]map[string]any, len(req.Tools))
+	for _, tool := range req.Tools {
+		if len(tool.InputSchema) > 0 {
+			schemas[tool.Name] = tool.InputSchema
+		}
+	}
+	if len(schemas) == 0 {
+		return nil
+	}
+	return &ToolArgsValidator{reject: mode == config.ToolArgsValidationReject, schemas: schemas}
+}
+
+// Rejects 严格模式：不合规的工具调用不转发给客户端
+func (v *ToolArgsValidator) Rejects() bool {
+	return v != nil && v.reject
+}
+
+// Validate 校验已解析的参数；请求中没有该工具的 schema 时不校验
+func (v *ToolArgsValidator) Validate(c *gin.Context, toolUseID, name string, args map[string]any) *ToolArgsViolation {
+	schema, ok := v.schemas[name]
+	if !ok {
+		return nil
+	}
+	var value any = map[string]any{}
+	if args != nil {
+		value = args
+	}
+	return v.report(c, toolUseID, name, converter.ValidateJSONSchema(schema, value))
+}
+
+// ValidateJSON 校验流式聚合得到的参数JSON文本，空文本视为 {}
+func (v *ToolArgsValidator) ValidateJSON(c *gin.Context, toolUseID, name, raw string) *ToolArgsViolation {
+	schema, ok := v.schemas[name]
+	if !ok {
+		return nil
+	}
+	var value any = map[string]any{}
+	if strings.TrimSpace(raw) != "" {
+		if err := utils.SafeUnmarshal([]byte(raw), &value); err != nil {
+			return v.report(c, toolUseID, name, &converter.SchemaViolation{Path: "$", Message: fmt.Sprintf("参数不是有效的JSON: %v", err)})
+		}
+	}
+	return v.report(c, toolUseID, name, converter.ValidateJSONSchema(schema, value))
+}
+
+func (v *ToolArgsValidator) report(c *gin.Context, toolUseID, name string, violation *converter.SchemaViolation) *ToolArgsViolation {
+	if violation == nil {
+		return nil
+	}
+	logger.Warn("工具调用参数不符合 input_schema",
+		logutil.AddFields(c,
+			logger.String("tool_use_id", toolUseID),
+			logger.String("tool_name", name),
+			logger.String("path", violation.Path),
+			logger.String("reason", violation.Message),
+			logger.Bool("rejected", v.reject),
+		)...)
+	return &ToolArgsViolation{ToolUseID: toolUseID, Name: name, Path: violation.Path, Message: violation.Message}
+}
+
+// CheckToolArgs 校验非流式响应中的工具调用
+// 告警模式返回全部违规，由调用方附加到响应的 x_kiro_validation 字段；严格模式遇到违规时返回错误，由调用方以422响应
+func CheckToolArgs(c *gin.Context, req types.AnthropicRequest, tools []*parser.ToolExecution) ([]ToolArgsViolation, error) {
+	validator := NewToolArgsValidator(req)
+	if validator == nil {
+		return nil, nil
+	}
+
+	var violations []ToolArgsViolation
+	for _, tool := range tools {
+		violation := validator.Validate(c, tool.ID, tool.Name, tool.Arguments)
+		if violation == nil {
+			continue
+		}
+		if validator.Rejects() {
+			return nil, fmt.Errorf("工具调用参数不符合 input_schema: %s", violation)
+		}
+		violations = append(violations, *violation)
+	}
+	return violations, nil
+}
+
+// holdToolEvent 严格模式下暂存工具块的 start/delta 事件，参数校验通过后再转发
+// 同时聚合 input_json_delta 供 content_block_stop 时校验
+func (ctx *StreamProcessorContext) holdToolEvent(dataMap map[string]any) bool {
+	if ctx.toolArgsValidator == nil {
+		return false
+	}
+	idx := extractIndex(dataMap)
+	if _, tracked := ctx.toolNameByBlockIndex[idx]; !tracked {
+		return false
+	}
+
+	if delta, ok := dataMap["delta"].(map[string]any); ok && delta["type"] == "input_json_delta" {
+		if partialJSON, ok := delta["partial_json"].(string); ok {
+			if ctx.toolArgsByBlockIndex[idx] == nil {
+				ctx.toolArgsByBlockIndex[idx] = &strings.Builder{}
+			}
+			ctx.toolArgsByBlockIndex[idx].WriteString(partialJSON)
+		}
+	}
+
+	if !ctx.toolArgsValidator.Rejects() {
+		return false
+	}
+	ctx.heldToolEvents[idx] = append(ctx.heldToolEvents[idx], dataMap)
+	return true
+}
+
+// checkToolArgs 工具块结束时校验聚合的参数
+// 通过时转发暂存的事件；告警模式记录违规；严格模式向客户端发送error事件并返回错误，调用方应终止流
+func (esp *EventStreamProcessor) checkToolArgs(dataMap map[string]any) error {
+	ctx := esp.ctx
+	idx := extractIndex(dataMap)
+	name, tracked := ctx.toolNameByBlockIndex[idx]
+	if !tracked {
+		return nil
+	}
+
+	raw := ""
+	if args := ctx.toolArgsByBlockIndex[idx]; args != nil {
+		raw = args.String()
+	}
+	held := ctx.heldToolEvents[idx]
+	delete(ctx.toolNameByBlockIndex, idx)
+	delete(ctx.toolArgsByBlockIndex, idx)
+	delete(ctx.heldToolEvents, idx)
+
+	toolUseID := ctx.toolUseIdByBlockIndex[idx]
+	violation := ctx.toolArgsValidator.ValidateJSON(ctx.c, toolUseID, name, raw)
+	if violation == nil {
+		for _, event := range held {
+			if err := esp.forwardEvent(event); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if !ctx.toolArgsValidator.Rejects() {
+		ctx.toolArgsViolations = append(ctx.toolArgsViolations, *violation)
+		return nil
+	}
+
+	delete(ctx.toolUseIdByBlockIndex, idx)
+	ctx.errorTerminated = true
+	errorEvent := map[string]any{
+		"type": "error",
+		"error": map[string]any{
+			"type":    "invalid_response_error",
+			"message": "工具调用参数不符合 input_schema: " + violation.String(),
+		},
+	}
+	if err := ctx.sender.SendEvent(ctx.c, errorEvent); err != nil {
+		logger.Error("发送工具参数校验错误事件失败", logger.Err(err))
+	}
+	return fmt.Errorf("工具调用参数不符合 input_schema: %s", violation)
+}
+
+// checkPendingToolArgs 上游流结束时仍未收到 content_block_stop 的工具块，按已收到的参数校验
+// 通过的块由 SendFinalEvents 统一关闭
+func (esp *EventStreamProcessor) checkPendingToolArgs() error {
+	indexes := make([]int, 0, len(esp.ctx.toolNameByBlockIndex))
+	for idx := range esp.ctx.toolNameByBlockIndex {
+		indexes = append(indexes, idx)
+	}
+	sort.Ints(indexes)
+
+	for _, idx := range indexes {
+		if err := esp.checkToolArgs(map[string]any{"type": "content_block_stop", "index": idx}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendToolArgsWarnings 告警模式下在最后追加一个文本块，列出不符合 input_schema 的工具调用
+func (ctx *StreamProcessorContext) sendToolArgsWarnings() {
+	if len(ctx.toolArgsViolations) == 0 || ctx.sseStateManager.IsMessageEnded() {
+		return
+	}
+
+	lines := make([]string, len(ctx.toolArgsViolations))
+	for i, v := range ctx.toolArgsViolations {
+		lines[i] = "- " + v.String()
+	}
+	text := "\n\n[工具调用参数校验] 以下工具调用的参数不符合 input_schema:\n" + strings.Join(lines, "\n")
+
+	index := ctx.sseStateManager.nextBlockIndex
+	events := []map[string]any{
+		{"type": "content_block_start", "index": index, "content_block": map[string]any{"type": "text", "text": ""}},
+		{"type": "content_block_delta", "index": index, "delta": map[string]any{"type": "text_delta", "text": text}},
+		{"type": "content_block_stop", "index": index},
+	}
+	for _, event := range events {
+		if err := ctx.sendEvent(event); err != nil {
+			logger.Error("发送工具参数校验结果失败", logger.Err(err))
+			return
+		}
+	}
+}
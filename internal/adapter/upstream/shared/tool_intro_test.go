@@ -0,0 +1,67 @@
+package shared
+
+import (
+	"bytes"
+	"testing"
+
+	"kiro2api/parser"
+	"kiro2api/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// twoToolStream 上游直接返回两个完整的工具调用，没有任何文本
+func twoToolStream(t *testing.T) *bytes.Buffer {
+	var stream bytes.Buffer
+	for _, tool := range []struct{ id, name string }{{"tooluse_a", "read_file"}, {"tooluse_b", "list_dir"}} {
+		stream.Write(eventStreamFrame(t, parser.EventTypes.TOOL_USE_EVENT, map[string]any{
+			"toolUseId": tool.id,
+			"name":      tool.name,
+			"input":     map[string]any{"path": "/tmp"},
+			"stop":      true,
+		}))
+	}
+	return &stream
+}
+
+func TestProcessEventStream_NoToolIntroByDefault(t *testing.T) {
+	t.Setenv("TOOL_INTRO_TEXT", "")
+	processor, sender := newTestStreamProcessor(t)
+	require.NoError(t, processor.ProcessEventStream(twoToolStream(t)))
+
+	assert.Empty(t, sender.textDeltas(), "未配置介绍文本时不发送任何 text_delta")
+	for _, event := range sender.events {
+		if block, ok := event["content_block"].(map[string]any); ok {
+			assert.Equal(t, "tool_use", block["type"], "不应启动文本块")
+		}
+	}
+}
+
+func TestProcessEventStream_ToolIntroOncePerMessage(t *testing.T) {
+	intro := "让我先查看一下相关文件。"
+	t.Setenv("TOOL_INTRO_TEXT", intro)
+	processor, sender := newTestStreamProcessor(t)
+	require.NoError(t, processor.ProcessEventStream(twoToolStream(t)))
+
+	assert.Equal(t, []string{intro}, sender.textDeltas())
+
+	// 介绍文本在第一个工具块之前
+	introAt, firstToolAt := -1, -1
+	for i, event := range sender.events {
+		if delta, ok := event["delta"].(map[string]any); ok && delta["type"] == "text_delta" && introAt < 0 {
+			introAt = i
+		}
+		if block, ok := event["content_block"].(map[string]any); ok && block["type"] == "tool_use" && firstToolAt < 0 {
+			firstToolAt = i
+		}
+	}
+	require.GreaterOrEqual(t, introAt, 0)
+	assert.Less(t, introAt, firstToolAt)
+
+	// 介绍文本计入输出 token
+	baseline, _ := newTestStreamProcessor(t)
+	t.Setenv("TOOL_INTRO_TEXT", "")
+	require.NoError(t, baseline.ProcessEventStream(twoToolStream(t)))
+	assert.Equal(t, baseline.ctx.totalOutputTokens+utils.NewTokenEstimator().EstimateTextTokens(intro), processor.ctx.totalOutputTokens)
+}
@@ -0,0 +1,56 @@
+package shared
+
+import (
+	logutil "kiro2api/internal/adapter/httpapi/logging"
+	"kiro2api/logger"
+	"kiro2api/parser"
+	"kiro2api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TrackToolState 登记解析器中进行中的工具状态，服务关闭时可保存到 TOOL_STATE_FILE；响应处理结束后调用返回的函数注销
+func TrackToolState(compliantParser *parser.CompliantEventStreamParser) func() {
+	registry := parser.DefaultToolStateRegistry()
+	registry.Track(compliantParser.GetToolManager())
+	return func() { registry.Untrack(compliantParser.GetToolManager()) }
+}
+
+// resolveRecoveredTools 请求携带了重启前进行中工具的 tool_result 时，这些工具已由客户端执行完毕，从恢复的工具状态中移除
+func resolveRecoveredTools(c *gin.Context, req types.AnthropicRequest) {
+	registry := parser.DefaultToolStateRegistry()
+	if registry.Recovered() == nil {
+		return
+	}
+	for _, tool := range registry.ResolveRecovered(toolResultIDs(req)) {
+		logger.Info("重启前进行中的工具已收到客户端结果",
+			logutil.AddFields(c,
+				logger.String("tool_use_id", tool.ID),
+				logger.String("tool_name", tool.Name),
+				logger.String("status", tool.Status.String()))...)
+	}
+}
+
+// toolResultIDs 收集请求中所有 tool_result 块引用的 tool_use_id
+func toolResultIDs(req types.AnthropicRequest) []string {
+	var ids []string
+	for _, msg := range req.Messages {
+		switch blocks := msg.Content.(type) {
+		case []any:
+			for _, item := range blocks {
+				if block, ok := item.(map[string]any); ok && block["type"] == "tool_result" {
+					if id, _ := block["tool_use_id"].(string); id != "" {
+						ids = append(ids, id)
+					}
+				}
+			}
+		case []types.ContentBlock:
+			for _, block := range blocks {
+				if block.Type == "tool_result" && block.ToolUseId != nil {
+					ids = append(ids, *block.ToolUseId)
+				}
+			}
+		}
+	}
+	return ids
+}
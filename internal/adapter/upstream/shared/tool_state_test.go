@@ -0,0 +1,23 @@
+package shared
+
+import (
+	"testing"
+
+	"kiro2api/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToolResultIDs(t *testing.T) {
+	id := "tooluse_b"
+	req := types.AnthropicRequest{Messages: []types.AnthropicRequestMessage{
+		{Role: "user", Content: "hi"},
+		{Role: "user", Content: []any{
+			map[string]any{"type": "text", "text": "结果如下"},
+			map[string]any{"type": "tool_result", "tool_use_id": "tooluse_a", "content": "ok"},
+		}},
+		{Role: "user", Content: []types.ContentBlock{{Type: "tool_result", ToolUseId: &id}}},
+	}}
+
+	assert.Equal(t, []string{"tooluse_a", "tooluse_b"}, toolResultIDs(req))
+}
@@ -0,0 +1,55 @@
+package shared
+
+import "unicode/utf8"
+
+// utf8BoundaryBuffer 按内容块暂存 text_delta 末尾不完整的UTF-8序列
+// 上游可能把一个多字节字符拆到两个 assistantResponseEvent 中，直接转发会让客户端收到非法UTF-8
+type utf8BoundaryBuffer struct {
+	pending map[int]string
+}
+
+func newUTF8BoundaryBuffer() *utf8BoundaryBuffer {
+	return &utf8BoundaryBuffer{pending: make(map[int]string)}
+}
+
+// repair 拼接同一块上次暂存的字节，并截留本次末尾不完整的序列，返回可以安全发送的文本
+func (b *utf8BoundaryBuffer) repair(index int, text string) string {
+	if prefix, ok := b.pending[index]; ok {
+		text = prefix + text
+		delete(b.pending, index)
+	}
+
+	if cut := incompleteSuffixStart(text); cut < len(text) {
+		b.pending[index] = text[cut:]
+		text = text[:cut]
+	}
+	return text
+}
+
+// flush 块结束时取出仍未补全的暂存字节，返回替换字符；没有暂存时返回空串
+func (b *utf8BoundaryBuffer) flush(index int) string {
+	if _, ok := b.pending[index]; !ok {
+		return ""
+	}
+	delete(b.pending, index)
+	return string(utf8.RuneError)
+}
+
+// incompleteSuffixStart 返回末尾不完整UTF-8序列的起始位置，末尾完整时返回 len(text)
+// 只向前检查 utf8.UTFMax-1 个字节：更早的首字节即使不完整也不可能再由后续字节补全
+func incompleteSuffixStart(text string) int {
+	for i := len(text) - 1; i >= 0 && i >= len(text)-(utf8.UTFMax-1); i-- {
+		c := text[i]
+		if c < utf8.RuneSelf {
+			return len(text) // ASCII，末尾完整
+		}
+		if !utf8.RuneStart(c) {
+			continue // 续字节，继续向前找首字节
+		}
+		if !utf8.FullRuneInString(text[i:]) {
+			return i
+		}
+		return len(text)
+	}
+	return len(text)
+}
@@ -0,0 +1,90 @@
+package shared
+
+import (
+	"testing"
+	"unicode/utf8"
+
+	"kiro2api/parser"
+	"kiro2api/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// textDeltas 返回每个 text_delta 事件的文本
+func (s *recordingSender) textDeltas() []string {
+	var texts []string
+	for _, event := range s.events {
+		if delta, ok := event["delta"].(map[string]any); ok && delta["type"] == "text_delta" {
+			texts = append(texts, delta["text"].(string))
+		}
+	}
+	return texts
+}
+
+// textDeltaEvent 构造解析器输出的 text_delta 事件
+func textDeltaEvent(index int, text string) parser.SSEEvent {
+	return parser.SSEEvent{Data: map[string]any{
+		"type":  "content_block_delta",
+		"index": index,
+		"delta": map[string]any{"type": "text_delta", "text": text},
+	}}
+}
+
+// TestProcessEvent_RepairsSplitMultiByteCharacter 上游把3字节的“中”拆到两个事件中时，客户端收到的每个增量都是合法UTF-8
+// 事件直接取自解析器输出：sonic 默认不校验UTF-8，payload 中被截断的字节会原样出现在 text 中
+func TestProcessEvent_RepairsSplitMultiByteCharacter(t *testing.T) {
+	zhong := "中" // E4 B8 AD
+	processor, sender := newTestStreamProcessor(t)
+	for _, text := range []string{"你好" + zhong[:2], zhong[2:] + "文", "。"} {
+		require.NoError(t, processor.processEvent(textDeltaEvent(0, text)))
+	}
+
+	deltas := sender.textDeltas()
+	assert.Equal(t, []string{"你好", "中文", "。"}, deltas)
+	for _, text := range deltas {
+		assert.True(t, utf8.ValidString(text), "增量 %q 不是合法UTF-8", text)
+	}
+
+	// token 按修复后的文本计算
+	estimator := utils.NewTokenEstimator()
+	expectedTokens := 0
+	for _, text := range deltas {
+		expectedTokens += estimator.EstimateTextTokens(text)
+	}
+	assert.Equal(t, expectedTokens, processor.ctx.totalOutputTokens)
+}
+
+func TestProcessEvent_FlushesIncompleteSequenceAtBlockStop(t *testing.T) {
+	processor, sender := newTestStreamProcessor(t)
+
+	require.NoError(t, processor.processEvent(textDeltaEvent(0, "结束"+"中"[:1])))
+	require.NoError(t, processor.processEvent(parser.SSEEvent{Data: map[string]any{
+		"type":  "content_block_stop",
+		"index": 0,
+	}}))
+
+	assert.Equal(t, []string{"结束", "\uFFFD"}, sender.textDeltas())
+	assert.Equal(t, "content_block_stop", sender.events[len(sender.events)-1]["type"])
+}
+
+func TestUTF8BoundaryBuffer(t *testing.T) {
+	emoji := "😀" // 4字节
+
+	t.Run("按块独立暂存", func(t *testing.T) {
+		b := newUTF8BoundaryBuffer()
+		assert.Equal(t, "a", b.repair(0, "a"+emoji[:1]))
+		assert.Equal(t, "b", b.repair(1, "b"+emoji[:3]))
+		assert.Equal(t, "", b.repair(0, emoji[1:2]), "仍不完整时整体暂存")
+		assert.Equal(t, emoji, b.repair(0, emoji[2:]))
+		assert.Equal(t, emoji+"c", b.repair(1, emoji[3:]+"c"))
+		assert.Empty(t, b.flush(0))
+	})
+
+	t.Run("完整文本和非法字节原样返回", func(t *testing.T) {
+		b := newUTF8BoundaryBuffer()
+		assert.Equal(t, "中文abc", b.repair(0, "中文abc"))
+		assert.Equal(t, "x\xff", b.repair(0, "x\xff"))
+		assert.Empty(t, b.flush(0))
+	})
+}
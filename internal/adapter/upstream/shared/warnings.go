@@ -0,0 +1,35 @@
+package shared
+
+import (
+	"slices"
+
+	"kiro2api/converter"
+	srvcontext "kiro2api/internal/adapter/httpapi/context"
+	logutil "kiro2api/internal/adapter/httpapi/logging"
+	"kiro2api/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// WarningsHeader 转换时出现不影响请求的问题（参数名缩短、描述截断、内容块丢弃等）时返回的响应头
+const WarningsHeader = "X-Kiro-Warnings"
+
+// reportWarnings 合并进入转换前记录的警告和构建器的警告，设置响应头并记录到请求上下文，写入请求完成日志
+// 429 重试会重新构建请求，警告相同，只在第一次记录日志
+func reportWarnings(c *gin.Context, builderWarnings converter.Warnings) {
+	warnings := slices.Concat(srvcontext.GetRequestWarnings(c), builderWarnings)
+	if len(warnings) == 0 {
+		return
+	}
+	c.Header(WarningsHeader, converter.WarningsHeaderValue(warnings))
+	value := warnings.JSON()
+	if srvcontext.GetWarnings(c) == value {
+		return
+	}
+	srvcontext.SetWarnings(c, value)
+	logger.Warn("请求转换产生警告",
+		logutil.AddFields(c,
+			logger.Int("warning_count", len(warnings)),
+			logger.Any("codes", warnings.Codes()),
+		)...)
+}
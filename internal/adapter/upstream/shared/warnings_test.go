@@ -0,0 +1,46 @@
+package shared
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"kiro2api/converter"
+	srvcontext "kiro2api/internal/adapter/httpapi/context"
+	"kiro2api/types"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecute_WarningsHeader(t *testing.T) {
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(""))}, nil
+	})}
+	rp := NewReverseProxy(client)
+	rp.stealthEnabled = false
+
+	// 没有警告时不设置响应头
+	c := newRetryTestContext()
+	resp, err := rp.Execute(c, newRetryTestRequest(), types.TokenInfo{AccessToken: "token"}, false)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Empty(t, c.Writer.Header().Get(WarningsHeader))
+	assert.Empty(t, srvcontext.GetWarnings(c))
+
+	// OpenAI 转换阶段的警告与构建器的警告合并到同一个响应头
+	c = newRetryTestContext()
+	srvcontext.SetRequestWarnings(c, converter.Warnings{{Code: converter.WarningToolSkipped, Message: "skipped", Path: "tools.0"}})
+	req := newRetryTestRequest()
+	req.Tools = []types.AnthropicTool{{Name: ""}}
+	resp, err = rp.Execute(c, req, types.TokenInfo{AccessToken: "token"}, false)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	var warnings converter.Warnings
+	require.NoError(t, json.Unmarshal([]byte(c.Writer.Header().Get(WarningsHeader)), &warnings))
+	assert.Equal(t, []string{converter.WarningToolSkipped, converter.WarningToolSkipped}, warnings.Codes())
+	assert.Equal(t, warnings.JSON(), srvcontext.GetWarnings(c))
+}
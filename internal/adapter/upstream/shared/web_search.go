@@ -0,0 +1,207 @@
+package shared
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"kiro2api/config"
+	"kiro2api/converter"
+	srvcontext "kiro2api/internal/adapter/httpapi/context"
+	logutil "kiro2api/internal/adapter/httpapi/logging"
+	"kiro2api/internal/adapter/httpapi/support"
+	"kiro2api/internal/websearch"
+	"kiro2api/logger"
+	"kiro2api/parser"
+	"kiro2api/types"
+	"kiro2api/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// prefetchedResponseKey 本地搜索往返结束后，保存最后一次上游响应体的上下文key
+const prefetchedResponseKey = "web_search_prefetched_response"
+
+// prefetchedResponse 已从上游读取完毕、等待交给正常响应流程的响应体（只能取用一次）
+type prefetchedResponse struct {
+	body []byte
+}
+
+// SetSearchProvider 设置 local 模式执行 web_search 的搜索服务（为nil时按环境变量创建）
+func (rp *ReverseProxy) SetSearchProvider(provider websearch.Provider) {
+	rp.search = provider
+}
+
+// ResolveWebSearch 在 WEB_SEARCH_MODE=local 且请求声明了 web_search 工具时，由代理执行模型发起的搜索
+//
+// 流式请求只在上下文中做标记，搜索往返在随后 Execute 返回的响应体内完成，文本边读边转发（见 webSearchStream）。
+// 非流式请求在这里完成往返：若模型只调用了 web_search，则执行搜索、把 tool_use/tool_result 追加到消息历史后重新请求，
+// 最多往返 config.WebSearchMaxRounds 次。最后一次上游响应保存在上下文中，随后的 Execute 直接复用，不会重复请求上游。
+// 返回追加了搜索往返的请求；返回false表示已向客户端写入错误响应
+func (rp *ReverseProxy) ResolveWebSearch(c *gin.Context, req types.AnthropicRequest, token types.TokenInfo, isStream bool) (types.AnthropicRequest, bool) {
+	if config.WebSearchMode() != config.WebSearchModeLocal || !hasWebSearchTool(req.Tools) {
+		return req, true
+	}
+	if isStream {
+		c.Set(streamingWebSearchKey, true)
+		return req, true
+	}
+
+	for round := 0; ; round++ {
+		resp, err := rp.Execute(c, req, token, false)
+		if err != nil {
+			return req, false
+		}
+		body, err := utils.ReadHTTPResponse(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			support.HandleResponseReadError(c, err)
+			return req, false
+		}
+
+		text, tools, parseErr := parseUpstreamTurn(body)
+		searches := webSearchCalls(tools)
+		if parseErr != nil || len(searches) == 0 || len(searches) != len(tools) || round >= config.WebSearchMaxRounds {
+			if len(searches) > 0 {
+				logger.Warn("web_search 未由代理执行，原样返回给客户端",
+					logutil.AddFields(c,
+						logger.Int("round", round),
+						logger.Int("web_search_calls", len(searches)),
+						logger.Int("tool_calls", len(tools)),
+					)...)
+			}
+			c.Set(prefetchedResponseKey, &prefetchedResponse{body: body})
+			return req, true
+		}
+
+		req.Messages = append(req.Messages,
+			types.AnthropicRequestMessage{Role: "assistant", Content: assistantSearchContent(text, tools)},
+			types.AnthropicRequestMessage{Role: "user", Content: rp.runSearches(c, searches)},
+		)
+		// 追加搜索往返后发往上游的请求变了，重新记录输入token
+		srvcontext.SetInputTokens(c, EstimateRequestInputTokens(c, req))
+
+		logger.Info("代理已执行 web_search",
+			logutil.AddFields(c,
+				logger.Int("round", round+1),
+				logger.Int("queries", len(searches)),
+			)...)
+	}
+}
+
+// takePrefetchedResponse 取出 ResolveWebSearch 保存的上游响应，构造为成功的HTTP响应
+func takePrefetchedResponse(c *gin.Context) (*http.Response, bool) {
+	v, ok := c.Get(prefetchedResponseKey)
+	if !ok {
+		return nil, false
+	}
+	prefetched, ok := v.(*prefetchedResponse)
+	if !ok || prefetched.body == nil {
+		return nil, false
+	}
+	body := prefetched.body
+	prefetched.body = nil
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{config.UpstreamAcceptStream}},
+		Body:       io.NopCloser(bytes.NewReader(body)),
+	}, true
+}
+
+// parseUpstreamTurn 解析一次完整的上游响应，返回文本和按顺序排列的工具调用
+func parseUpstreamTurn(body []byte) (string, []*parser.ToolExecution, error) {
+	result, err := parser.NewCompliantEventStreamParser().ParseResponse(body)
+	if err != nil {
+		return "", nil, err
+	}
+	return result.GetCompletionText(), result.GetToolCalls(), nil
+}
+
+func hasWebSearchTool(tools []types.AnthropicTool) bool {
+	for _, tool := range tools {
+		if converter.IsWebSearchTool(tool.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+func webSearchCalls(tools []*parser.ToolExecution) []*parser.ToolExecution {
+	var searches []*parser.ToolExecution
+	for _, tool := range tools {
+		if converter.IsWebSearchTool(tool.Name) {
+			searches = append(searches, tool)
+		}
+	}
+	return searches
+}
+
+// assistantSearchContent 把模型的文本与 web_search 调用还原为助手消息内容块
+func assistantSearchContent(text string, tools []*parser.ToolExecution) []any {
+	var content []any
+	if strings.TrimSpace(text) != "" {
+		content = append(content, map[string]any{"type": "text", "text": text})
+	}
+	for _, tool := range tools {
+		input := tool.Arguments
+		if input == nil {
+			input = map[string]any{}
+		}
+		content = append(content, map[string]any{
+			"type":  "tool_use",
+			"id":    tool.ID,
+			"name":  tool.Name,
+			"input": input,
+		})
+	}
+	return content
+}
+
+// runSearches 依次执行搜索并生成 tool_result 内容块；搜索失败时返回 is_error 的结果让模型自行处理
+func (rp *ReverseProxy) runSearches(c *gin.Context, searches []*parser.ToolExecution) []any {
+	provider, providerErr := rp.searchProvider()
+
+	results := make([]any, 0, len(searches))
+	for _, call := range searches {
+		query, _ := call.Arguments["query"].(string)
+		query = strings.TrimSpace(query)
+
+		err := providerErr
+		var text string
+		if err == nil {
+			text, err = searchOnce(c.Request.Context(), provider, query)
+		}
+
+		block := map[string]any{"type": "tool_result", "tool_use_id": call.ID, "content": text}
+		if err != nil {
+			logger.Warn("本地搜索失败", logutil.AddFields(c, logger.String("query", query), logger.Err(err))...)
+			block["content"] = "搜索失败: " + err.Error()
+			block["is_error"] = true
+		}
+		results = append(results, block)
+	}
+	return results
+}
+
+// searchOnce 执行单次搜索并格式化结果（超过 config.WebSearchResultMaxBytes 截断）
+func searchOnce(ctx context.Context, provider websearch.Provider, query string) (string, error) {
+	if query == "" {
+		return "", errors.New("web_search 缺少 query 参数")
+	}
+	found, err := provider.Search(ctx, query)
+	if err != nil {
+		return "", err
+	}
+	return websearch.FormatResults(query, found, config.WebSearchResultMaxBytes), nil
+}
+
+func (rp *ReverseProxy) searchProvider() (websearch.Provider, error) {
+	if rp.search != nil {
+		return rp.search, nil
+	}
+	return websearch.NewProviderFromConfig()
+}
@@ -0,0 +1,271 @@
+package shared
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"kiro2api/config"
+	"kiro2api/converter"
+	srvcontext "kiro2api/internal/adapter/httpapi/context"
+	logutil "kiro2api/internal/adapter/httpapi/logging"
+	"kiro2api/logger"
+	"kiro2api/parser"
+	"kiro2api/types"
+	"kiro2api/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// streamingWebSearchKey 流式请求启用本地搜索的上下文标记，由 ResolveWebSearch 设置、Execute 读取
+const streamingWebSearchKey = "web_search_streaming"
+
+// executeStreamingWebSearch 发起首轮流式请求，并把响应体包装为在流内完成搜索往返的 webSearchStream
+func (rp *ReverseProxy) executeStreamingWebSearch(c *gin.Context, req types.AnthropicRequest, token types.TokenInfo) (*http.Response, error) {
+	resp, err := rp.executeWithFallback(c, req, token, true)
+	if err != nil {
+		return resp, err
+	}
+	resp.Body = &webSearchStream{
+		rp:        rp,
+		c:         c,
+		req:       req,
+		token:     token,
+		body:      resp.Body,
+		searchIDs: map[string]bool{},
+	}
+	return resp, nil
+}
+
+// webSearchStream 把多轮上游响应拼接为一个 EventStream，交给正常的流式处理流程
+//
+// 每一帧读到即转发，客户端不必等待整轮响应；web_search 的 toolUseEvent 帧暂存不转发。
+// 一轮结束时若模型只调用了 web_search，执行搜索、把 tool_use/tool_result 追加到请求后发起下一轮，
+// 下一轮的帧接在已转发的内容之后，客户端看到的是同一条消息；
+// 同时调用了客户端工具、超过 config.WebSearchMaxRounds 或下一轮请求失败时，补发暂存的帧并结束，
+// 由客户端自行处理 web_search 调用。
+type webSearchStream struct {
+	rp    *ReverseProxy
+	c     *gin.Context
+	req   types.AnthropicRequest
+	token types.TokenInfo
+
+	body       io.ReadCloser
+	round      int
+	turn       bytes.Buffer    // 本轮的全部帧，轮次结束时解析文本与工具调用
+	held       []byte          // 暂存的 web_search 帧
+	searchIDs  map[string]bool // 本轮 web_search 调用的 toolUseId
+	otherTools bool            // 本轮调用了 web_search 以外的工具
+
+	out         []byte
+	passthrough bool // 帧格式无法识别时不再检查，剩余内容原样转发
+	done        bool
+}
+
+func (s *webSearchStream) Read(p []byte) (int, error) {
+	for len(s.out) == 0 && !s.done {
+		if err := s.next(); err != nil {
+			return 0, err
+		}
+	}
+	if len(s.out) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, s.out)
+	s.out = s.out[n:]
+	return n, nil
+}
+
+func (s *webSearchStream) Close() error {
+	s.done = true
+	return s.body.Close()
+}
+
+// next 读取下一帧并决定立即转发还是暂存；本轮读完时转入 endTurn
+func (s *webSearchStream) next() error {
+	if s.passthrough {
+		buf := make([]byte, config.EventStreamReadChunkSize)
+		n, err := s.body.Read(buf)
+		s.out = append(s.out, buf[:n]...)
+		if errors.Is(err, io.EOF) {
+			s.done = true
+			return nil
+		}
+		return err
+	}
+
+	frame, err := readEventStreamFrame(s.body)
+	var malformed *malformedFrameError
+	switch {
+	case errors.Is(err, io.EOF):
+		return s.endTurn()
+	case errors.As(err, &malformed):
+		logger.Warn("上游帧格式无法识别，停止本地搜索并原样转发", logutil.AddFields(s.c, logger.Err(err))...)
+		s.out = append(s.out, s.held...)
+		s.out = append(s.out, malformed.prefix...)
+		s.held = nil
+		s.passthrough = true
+		return nil
+	case err != nil:
+		return err
+	}
+
+	s.turn.Write(frame)
+	if s.isSearchFrame(frame) {
+		s.held = append(s.held, frame...)
+	} else {
+		s.out = append(s.out, frame...)
+	}
+	return nil
+}
+
+// isSearchFrame 判断帧是否属于 web_search 调用，同时记录本轮出现的工具
+func (s *webSearchStream) isSearchFrame(frame []byte) bool {
+	messages, err := parser.NewRobustEventStreamParser().ParseStream(frame)
+	if err != nil || len(messages) != 1 || messages[0].GetEventType() != parser.EventTypes.TOOL_USE_EVENT {
+		return false
+	}
+	var evt struct {
+		Name      string `json:"name"`
+		ToolUseID string `json:"toolUseId"`
+	}
+	if err := utils.FastUnmarshal(messages[0].Payload, &evt); err != nil || evt.ToolUseID == "" {
+		return false
+	}
+	if evt.Name != "" {
+		if converter.IsWebSearchTool(evt.Name) {
+			s.searchIDs[evt.ToolUseID] = true
+		} else {
+			s.otherTools = true
+		}
+	}
+	return s.searchIDs[evt.ToolUseID]
+}
+
+// endTurn 一轮上游响应读完：只调用了 web_search 时执行搜索并切换到下一轮，否则补发暂存帧后结束
+func (s *webSearchStream) endTurn() error {
+	_ = s.body.Close()
+
+	if len(s.searchIDs) == 0 {
+		s.done = true
+		return nil
+	}
+
+	text, tools, parseErr := parseUpstreamTurn(s.turn.Bytes())
+	searches := webSearchCalls(tools)
+	if parseErr != nil || s.otherTools || len(searches) == 0 || len(searches) != len(tools) || s.round >= config.WebSearchMaxRounds {
+		logger.Warn("web_search 未由代理执行，原样返回给客户端",
+			logutil.AddFields(s.c,
+				logger.Int("round", s.round),
+				logger.Int("web_search_calls", len(searches)),
+				logger.Int("tool_calls", len(tools)),
+			)...)
+		s.releaseHeld()
+		return nil
+	}
+
+	s.req.Messages = append(s.req.Messages,
+		types.AnthropicRequestMessage{Role: "assistant", Content: assistantSearchContent(text, tools)},
+		types.AnthropicRequestMessage{Role: "user", Content: s.rp.runSearches(s.c, searches)},
+	)
+	// 追加搜索往返后发往上游的请求变了，重新记录输入token
+	srvcontext.SetInputTokens(s.c, EstimateRequestInputTokens(s.c, s.req))
+
+	// 流已开始，下一轮请求失败时的错误响应不能写入客户端
+	resp, err := s.rp.executeWithFallback(quietContext(s.c), s.req, s.token, true)
+	if err != nil {
+		logger.Warn("本地搜索后的上游请求失败，结束流", logutil.AddFields(s.c, logger.Err(err))...)
+		s.releaseHeld()
+		return nil
+	}
+
+	s.round++
+	logger.Info("代理已执行 web_search",
+		logutil.AddFields(s.c,
+			logger.Int("round", s.round),
+			logger.Int("queries", len(searches)),
+		)...)
+
+	s.body = resp.Body
+	s.turn.Reset()
+	s.held = nil
+	s.searchIDs = map[string]bool{}
+	s.otherTools = false
+	return nil
+}
+
+func (s *webSearchStream) releaseHeld() {
+	s.out = append(s.out, s.held...)
+	s.held = nil
+	s.done = true
+}
+
+// malformedFrameError 帧长度不合法，prefix 为已读出的字节
+type malformedFrameError struct {
+	length uint32
+	prefix []byte
+}
+
+func (e *malformedFrameError) Error() string {
+	return fmt.Sprintf("EventStream帧长度不合法: %d", e.length)
+}
+
+// readEventStreamFrame 按前导的总长度读取一个完整的 AWS EventStream 帧；流在帧边界结束时返回 io.EOF
+func readEventStreamFrame(r io.Reader) ([]byte, error) {
+	var prelude [4]byte
+	if n, err := io.ReadFull(r, prelude[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, &malformedFrameError{prefix: prelude[:n]}
+		}
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(prelude[:])
+	if length < config.EventStreamMinMessageSize || length > config.EventStreamMaxMessageSize {
+		return nil, &malformedFrameError{length: length, prefix: prelude[:]}
+	}
+	frame := make([]byte, length)
+	copy(frame, prelude[:])
+	if n, err := io.ReadFull(r, frame[4:]); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, &malformedFrameError{length: length, prefix: frame[:4+n]}
+		}
+		return nil, err
+	}
+	return frame, nil
+}
+
+// quietContext 复制请求上下文并丢弃写入的响应，用于流已开始后发起的上游请求
+func quietContext(c *gin.Context) *gin.Context {
+	cp := c.Copy()
+	cp.Writer = &discardResponseWriter{ResponseWriter: c.Writer, header: http.Header{}, status: http.StatusOK}
+	return cp
+}
+
+// discardResponseWriter 丢弃状态码和响应体；其余方法沿用原始的 ResponseWriter
+type discardResponseWriter struct {
+	gin.ResponseWriter
+	header http.Header
+	status int
+	size   int
+}
+
+func (w *discardResponseWriter) Header() http.Header  { return w.header }
+func (w *discardResponseWriter) WriteHeader(code int) { w.status = code }
+func (w *discardResponseWriter) WriteHeaderNow()      {}
+func (w *discardResponseWriter) Written() bool        { return true }
+func (w *discardResponseWriter) Status() int          { return w.status }
+func (w *discardResponseWriter) Size() int            { return w.size }
+func (w *discardResponseWriter) Flush()               {}
+
+func (w *discardResponseWriter) Write(b []byte) (int, error) {
+	w.size += len(b)
+	return len(b), nil
+}
+
+func (w *discardResponseWriter) WriteString(s string) (int, error) {
+	w.size += len(s)
+	return len(s), nil
+}
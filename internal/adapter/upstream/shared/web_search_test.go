@@ -0,0 +1,308 @@
+package shared
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"kiro2api/config"
+	srvcontext "kiro2api/internal/adapter/httpapi/context"
+	"kiro2api/internal/websearch"
+	"kiro2api/parser"
+	"kiro2api/types"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSearchProvider 记录查询并返回固定结果
+type fakeSearchProvider struct {
+	queries []string
+	results []websearch.Result
+	err     error
+}
+
+func (f *fakeSearchProvider) Search(ctx context.Context, query string) ([]websearch.Result, error) {
+	f.queries = append(f.queries, query)
+	return f.results, f.err
+}
+
+// fakeUpstream 按顺序返回预设的 EventStream 响应体，并记录每次请求体
+type fakeUpstream struct {
+	responses [][]byte
+	requests  []string
+}
+
+func (f *fakeUpstream) client() *http.Client {
+	return &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		body, _ := io.ReadAll(req.Body)
+		f.requests = append(f.requests, string(body))
+		resp := f.responses[min(len(f.requests), len(f.responses))-1]
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(bytes.NewReader(resp))}, nil
+	})}
+}
+
+func webSearchCallStream(t *testing.T, id, query string) []byte {
+	var stream bytes.Buffer
+	stream.Write(eventStreamFrame(t, parser.EventTypes.ASSISTANT_RESPONSE_EVENT, map[string]any{"content": "Let me search."}))
+	stream.Write(eventStreamFrame(t, parser.EventTypes.TOOL_USE_EVENT, map[string]any{
+		"toolUseId": id, "name": "web_search", "input": map[string]any{"query": query}, "stop": true,
+	}))
+	return stream.Bytes()
+}
+
+func textStream(t *testing.T, text string) []byte {
+	return eventStreamFrame(t, parser.EventTypes.ASSISTANT_RESPONSE_EVENT, map[string]any{"content": text})
+}
+
+func newWebSearchTestProxy(upstream *fakeUpstream, provider websearch.Provider) *ReverseProxy {
+	rp := NewReverseProxy(upstream.client())
+	rp.stealthEnabled = false
+	rp.SetEndpointPool(NewEndpointPool(nil, config.DefaultUpstreamHealthWindow, 50, config.DefaultUpstreamProbeInterval))
+	rp.SetSearchProvider(provider)
+	return rp
+}
+
+func newWebSearchTestRequest() types.AnthropicRequest {
+	req := newRetryTestRequest()
+	req.Messages = []types.AnthropicRequestMessage{{Role: "user", Content: "What is new in Go 1.24?"}}
+	req.Tools = []types.AnthropicTool{{
+		Name:        "web_search",
+		Description: "Search the web",
+		InputSchema: map[string]any{"type": "object", "properties": map[string]any{"query": map[string]any{"type": "string"}}},
+	}}
+	return req
+}
+
+func TestResolveWebSearch_LocalExecutesSearchAndReplaysFinalResponse(t *testing.T) {
+	t.Setenv("WEB_SEARCH_MODE", "local")
+	upstream := &fakeUpstream{responses: [][]byte{
+		webSearchCallStream(t, "tooluse_search1", "go 1.24 release notes"),
+		textStream(t, "Go 1.24 adds generic type aliases."),
+	}}
+	provider := &fakeSearchProvider{results: []websearch.Result{
+		{Title: "Go 1.24 Release Notes", URL: "https://go.dev/doc/go1.24", Snippet: "Generic type aliases are now fully supported."},
+	}}
+	rp := newWebSearchTestProxy(upstream, provider)
+
+	c := newRetryTestContext()
+	req, ok := rp.ResolveWebSearch(c, newWebSearchTestRequest(), types.TokenInfo{AccessToken: "token"}, false)
+	require.True(t, ok)
+
+	assert.Equal(t, []string{"go 1.24 release notes"}, provider.queries)
+	require.Len(t, upstream.requests, 2)
+	assert.Contains(t, upstream.requests[0], `"name":"web_search"`, "local 模式应把 web_search 工具发给上游")
+	assert.Contains(t, upstream.requests[1], "Generic type aliases are now fully supported.")
+	assert.Contains(t, upstream.requests[1], "tooluse_search1")
+
+	// 追加的 assistant tool_use 与 user tool_result
+	require.Len(t, req.Messages, 3)
+	assert.Equal(t, "assistant", req.Messages[1].Role)
+	toolResult := req.Messages[2].Content.([]any)[0].(map[string]any)
+	assert.Equal(t, "tooluse_search1", toolResult["tool_use_id"])
+	assert.Nil(t, toolResult["is_error"])
+
+	// 输入token按追加搜索往返后的请求重新记录
+	inputTokens, ok := srvcontext.GetInputTokens(c)
+	require.True(t, ok)
+	assert.Equal(t, EstimateInputTokens(req), inputTokens)
+
+	// 随后的 Execute 复用最后一次上游响应，不再请求上游
+	resp, err := rp.Execute(c, req, types.TokenInfo{AccessToken: "token"}, true)
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, upstream.responses[1], body)
+	assert.Len(t, upstream.requests, 2)
+}
+
+func TestResolveWebSearch_LoopGuard(t *testing.T) {
+	t.Setenv("WEB_SEARCH_MODE", "local")
+	upstream := &fakeUpstream{responses: [][]byte{webSearchCallStream(t, "tooluse_again", "again")}}
+	provider := &fakeSearchProvider{}
+	rp := newWebSearchTestProxy(upstream, provider)
+
+	req, ok := rp.ResolveWebSearch(newRetryTestContext(), newWebSearchTestRequest(), types.TokenInfo{AccessToken: "token"}, false)
+	require.True(t, ok)
+
+	assert.Len(t, provider.queries, config.WebSearchMaxRounds)
+	assert.Len(t, upstream.requests, config.WebSearchMaxRounds+1)
+	assert.Len(t, req.Messages, 1+2*config.WebSearchMaxRounds)
+}
+
+func TestResolveWebSearch_TruncatesResults(t *testing.T) {
+	t.Setenv("WEB_SEARCH_MODE", "local")
+	upstream := &fakeUpstream{responses: [][]byte{
+		webSearchCallStream(t, "tooluse_big", "big"),
+		textStream(t, "done"),
+	}}
+	provider := &fakeSearchProvider{results: []websearch.Result{
+		{Title: "Huge", URL: "https://example.com", Snippet: strings.Repeat("很长的搜索摘要", 2000)},
+	}}
+	rp := newWebSearchTestProxy(upstream, provider)
+
+	req, ok := rp.ResolveWebSearch(newRetryTestContext(), newWebSearchTestRequest(), types.TokenInfo{AccessToken: "token"}, false)
+	require.True(t, ok)
+
+	content := req.Messages[2].Content.([]any)[0].(map[string]any)["content"].(string)
+	assert.LessOrEqual(t, len(content), config.WebSearchResultMaxBytes+len(websearch.TruncationMarker))
+	assert.True(t, strings.HasSuffix(content, websearch.TruncationMarker))
+}
+
+func TestResolveWebSearch_ProviderErrorBecomesErrorResult(t *testing.T) {
+	t.Setenv("WEB_SEARCH_MODE", "local")
+	upstream := &fakeUpstream{responses: [][]byte{
+		webSearchCallStream(t, "tooluse_fail", "anything"),
+		textStream(t, "I could not search."),
+	}}
+	rp := newWebSearchTestProxy(upstream, &fakeSearchProvider{err: assert.AnError})
+
+	req, ok := rp.ResolveWebSearch(newRetryTestContext(), newWebSearchTestRequest(), types.TokenInfo{AccessToken: "token"}, false)
+	require.True(t, ok)
+
+	toolResult := req.Messages[2].Content.([]any)[0].(map[string]any)
+	assert.Equal(t, true, toolResult["is_error"])
+	assert.Contains(t, toolResult["content"], "搜索失败")
+}
+
+func TestResolveWebSearch_MixedToolCallsGoToClient(t *testing.T) {
+	t.Setenv("WEB_SEARCH_MODE", "local")
+	var stream bytes.Buffer
+	stream.Write(webSearchCallStream(t, "tooluse_search", "query"))
+	stream.Write(eventStreamFrame(t, parser.EventTypes.TOOL_USE_EVENT, map[string]any{
+		"toolUseId": "tooluse_read", "name": "read_file", "input": map[string]any{"path": "a.go"}, "stop": true,
+	}))
+	upstream := &fakeUpstream{responses: [][]byte{stream.Bytes()}}
+	provider := &fakeSearchProvider{}
+	rp := newWebSearchTestProxy(upstream, provider)
+
+	req, ok := rp.ResolveWebSearch(newRetryTestContext(), newWebSearchTestRequest(), types.TokenInfo{AccessToken: "token"}, false)
+	require.True(t, ok)
+
+	assert.Empty(t, provider.queries, "同时调用客户端工具时无法只回填 web_search 结果")
+	assert.Len(t, req.Messages, 1)
+	assert.Len(t, upstream.requests, 1)
+}
+
+func TestResolveWebSearch_StreamingSearchesInsideResponseBody(t *testing.T) {
+	t.Setenv("WEB_SEARCH_MODE", "local")
+	searchTurn := webSearchCallStream(t, "tooluse_search1", "go 1.24 release notes")
+	finalTurn := textStream(t, "Go 1.24 adds generic type aliases.")
+	upstream := &fakeUpstream{responses: [][]byte{searchTurn, finalTurn}}
+	provider := &fakeSearchProvider{results: []websearch.Result{
+		{Title: "Go 1.24 Release Notes", URL: "https://go.dev/doc/go1.24", Snippet: "Generic type aliases are now fully supported."},
+	}}
+	rp := newWebSearchTestProxy(upstream, provider)
+
+	c := newRetryTestContext()
+	req, ok := rp.ResolveWebSearch(c, newWebSearchTestRequest(), types.TokenInfo{AccessToken: "token"}, true)
+	require.True(t, ok)
+	assert.Empty(t, upstream.requests, "流式请求在响应体内执行搜索，开始前不请求上游")
+
+	resp, err := rp.Execute(c, req, types.TokenInfo{AccessToken: "token"}, true)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	// 首轮的文本帧与最终轮拼接为同一个流，web_search 调用帧不下发
+	assert.Equal(t, append(textStream(t, "Let me search."), finalTurn...), body)
+	assert.Equal(t, []string{"go 1.24 release notes"}, provider.queries)
+	require.Len(t, upstream.requests, 2)
+	assert.Contains(t, upstream.requests[1], "Generic type aliases are now fully supported.")
+}
+
+func TestResolveWebSearch_StreamingForwardsBeforeTurnEnds(t *testing.T) {
+	t.Setenv("WEB_SEARCH_MODE", "local")
+	first := textStream(t, "Searching is not needed.")
+	reader, writer := io.Pipe()
+	release := make(chan struct{})
+	go func() {
+		_, _ = writer.Write(first)
+		<-release
+		_, _ = writer.Write(textStream(t, " Done."))
+		_ = writer.Close()
+	}()
+	defer close(release)
+
+	rp := NewReverseProxy(&http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: reader}, nil
+	})})
+	rp.stealthEnabled = false
+	rp.SetEndpointPool(NewEndpointPool(nil, config.DefaultUpstreamHealthWindow, 50, config.DefaultUpstreamProbeInterval))
+	rp.SetSearchProvider(&fakeSearchProvider{})
+
+	c := newRetryTestContext()
+	req, ok := rp.ResolveWebSearch(c, newWebSearchTestRequest(), types.TokenInfo{AccessToken: "token"}, true)
+	require.True(t, ok)
+	resp, err := rp.Execute(c, req, types.TokenInfo{AccessToken: "token"}, true)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	got := make(chan []byte, 1)
+	go func() {
+		buf := make([]byte, len(first))
+		_, _ = io.ReadFull(resp.Body, buf)
+		got <- buf
+	}()
+	select {
+	case buf := <-got:
+		assert.Equal(t, first, buf, "上游还在输出时已读到的帧应立即转发")
+	case <-time.After(2 * time.Second):
+		t.Fatal("流式响应在整轮结束前没有转发任何内容")
+	}
+}
+
+func TestResolveWebSearch_StreamingMixedToolCallsGoToClient(t *testing.T) {
+	t.Setenv("WEB_SEARCH_MODE", "local")
+	var stream bytes.Buffer
+	stream.Write(webSearchCallStream(t, "tooluse_search", "query"))
+	stream.Write(eventStreamFrame(t, parser.EventTypes.TOOL_USE_EVENT, map[string]any{
+		"toolUseId": "tooluse_read", "name": "read_file", "input": map[string]any{"path": "a.go"}, "stop": true,
+	}))
+	upstream := &fakeUpstream{responses: [][]byte{stream.Bytes()}}
+	provider := &fakeSearchProvider{}
+	rp := newWebSearchTestProxy(upstream, provider)
+
+	c := newRetryTestContext()
+	req, ok := rp.ResolveWebSearch(c, newWebSearchTestRequest(), types.TokenInfo{AccessToken: "token"}, true)
+	require.True(t, ok)
+	resp, err := rp.Execute(c, req, types.TokenInfo{AccessToken: "token"}, true)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	assert.Empty(t, provider.queries, "同时调用客户端工具时无法只回填 web_search 结果")
+	assert.Len(t, upstream.requests, 1)
+	assert.Len(t, body, stream.Len(), "暂存的 web_search 帧在结束时补发")
+	assert.Contains(t, string(body), "tooluse_search")
+	assert.Contains(t, string(body), "tooluse_read")
+}
+
+func TestResolveWebSearch_NoopOutsideLocalMode(t *testing.T) {
+	for _, mode := range []string{"", "drop", "error"} {
+		t.Run("mode="+mode, func(t *testing.T) {
+			t.Setenv("WEB_SEARCH_MODE", mode)
+			upstream := &fakeUpstream{responses: [][]byte{textStream(t, "hi")}}
+			rp := newWebSearchTestProxy(upstream, &fakeSearchProvider{})
+
+			req, ok := rp.ResolveWebSearch(newRetryTestContext(), newWebSearchTestRequest(), types.TokenInfo{AccessToken: "token"}, false)
+			require.True(t, ok)
+			assert.Len(t, req.Messages, 1)
+			assert.Empty(t, upstream.requests)
+		})
+	}
+}
+
+func TestFilterSupportedTools_ReadsMode(t *testing.T) {
+	tools := newWebSearchTestRequest().Tools
+
+	t.Setenv("WEB_SEARCH_MODE", "drop")
+	assert.Empty(t, FilterSupportedTools(tools))
+
+	t.Setenv("WEB_SEARCH_MODE", "local")
+	assert.Len(t, FilterSupportedTools(tools), 1)
+}
@@ -0,0 +1,93 @@
+package audit
+
+import (
+	"sync"
+	"time"
+
+	"kiro2api/config"
+)
+
+// 管理操作类型
+const (
+	AdminActionToggle           = "toggle"
+	AdminActionDelete           = "delete"
+	AdminActionRestore          = "restore"
+	AdminActionReload           = "reload"
+	AdminActionReplace          = "replace"
+	AdminActionRollback         = "rollback"
+	AdminActionImport           = "import"
+	AdminActionCleanup          = "cleanup"
+	AdminActionPurge            = "purge"
+	AdminActionToolFilter       = "tool_filter"
+	AdminActionLogLevel         = "log_level"
+	AdminActionABTest           = "ab_test"
+	AdminActionTokenCalibration = "token_calibration"
+	AdminActionQuota            = "quota"
+)
+
+// AdminAction 一次管理操作的记录
+type AdminAction struct {
+	Timestamp time.Time `json:"timestamp"`
+	Actor     string    `json:"actor"`
+	Action    string    `json:"action"`
+	TokenID   string    `json:"token_id,omitempty"`
+}
+
+// AdminLog 管理操作日志，按时间先后保存在内存中，超出上限时丢弃最早的记录
+type AdminLog struct {
+	mutex      sync.Mutex
+	entries    []AdminAction
+	maxEntries int
+	now        func() time.Time
+}
+
+var (
+	globalAdminLog *AdminLog
+	adminLogOnce   sync.Once
+)
+
+// GetAdminLog 获取全局管理操作日志
+func GetAdminLog() *AdminLog {
+	adminLogOnce.Do(func() {
+		globalAdminLog = NewAdminLog(config.AdminAuditMaxEntries)
+	})
+	return globalAdminLog
+}
+
+// NewAdminLog 创建管理操作日志
+func NewAdminLog(maxEntries int) *AdminLog {
+	return &AdminLog{
+		maxEntries: maxEntries,
+		now:        time.Now,
+	}
+}
+
+// Record 追加一条管理操作记录，Timestamp 为空时使用当前时间
+func (l *AdminLog) Record(entry AdminAction) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = l.now()
+	}
+	l.entries = append(l.entries, entry)
+	if overflow := len(l.entries) - l.maxEntries; overflow > 0 {
+		l.entries = append([]AdminAction(nil), l.entries[overflow:]...)
+	}
+}
+
+// Entries 返回最近的 limit 条记录副本，按时间倒序；limit<=0 时返回全部
+func (l *AdminLog) Entries(limit int) []AdminAction {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	n := len(l.entries)
+	if limit > 0 && limit < n {
+		n = limit
+	}
+	result := make([]AdminAction, n)
+	for i := range result {
+		result[i] = l.entries[len(l.entries)-1-i]
+	}
+	return result
+}
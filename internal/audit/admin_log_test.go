@@ -0,0 +1,41 @@
+package audit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminLog_RecordAndEntries(t *testing.T) {
+	log := NewAdminLog(3)
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	log.now = func() time.Time { return base }
+
+	log.Record(AdminAction{Actor: "admin:***abcd", Action: AdminActionDelete, TokenID: "t1"})
+	log.Record(AdminAction{Actor: "admin:***abcd", Action: AdminActionRestore, TokenID: "t1", Timestamp: base.Add(time.Minute)})
+
+	entries := log.Entries(0)
+	require.Len(t, entries, 2)
+	assert.Equal(t, AdminActionRestore, entries[0].Action, "按时间倒序返回")
+	assert.Equal(t, base, entries[1].Timestamp, "未指定时间时使用当前时间")
+
+	assert.Len(t, log.Entries(1), 1)
+}
+
+func TestAdminLog_DropsOldestBeyondLimit(t *testing.T) {
+	log := NewAdminLog(2)
+	for _, id := range []string{"t1", "t2", "t3"} {
+		log.Record(AdminAction{Action: AdminActionToggle, TokenID: id})
+	}
+
+	entries := log.Entries(0)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "t3", entries[0].TokenID)
+	assert.Equal(t, "t2", entries[1].TokenID)
+
+	// 返回的是副本，修改不影响日志
+	entries[0].TokenID = "changed"
+	assert.Equal(t, "t3", log.Entries(1)[0].TokenID)
+}
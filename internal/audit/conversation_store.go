@@ -0,0 +1,188 @@
+package audit
+
+import (
+	"sync"
+	"time"
+
+	"kiro2api/config"
+	"kiro2api/types"
+	"kiro2api/utils"
+)
+
+// Turn 会话中一次请求的审计记录
+type Turn struct {
+	Timestamp    time.Time `json:"timestamp"`
+	RequestID    string    `json:"request_id,omitempty"`
+	MessageID    string    `json:"message_id,omitempty"`
+	Endpoint     string    `json:"endpoint,omitempty"`
+	Model        string    `json:"model"`
+	Stream       bool      `json:"stream"`
+	MessageCount int       `json:"message_count"`
+	InputTokens  int       `json:"input_tokens"`
+	OutputTokens int       `json:"output_tokens"`
+	StopReason   string    `json:"stop_reason,omitempty"`
+
+	// Request 规范化后的请求体，仅 AUDIT_LEVEL=full 时保留
+	Request *types.AnthropicRequest `json:"request,omitempty"`
+	// RequestOmitted 请求体超出容量上限而未保留
+	RequestOmitted bool `json:"request_omitted,omitempty"`
+
+	size int64
+}
+
+// conversationLog 单个会话的记录，按时间先后排列，超出上限时丢弃最早的记录
+type conversationLog struct {
+	turns []Turn
+}
+
+// ConversationStore 按会话ID保存最近请求的内存环形缓冲
+// 记录在 retention 到期后淘汰；合计大小超过 maxBytes 时从全局最早的记录开始淘汰
+type ConversationStore struct {
+	mutex         sync.Mutex
+	retention     time.Duration
+	maxBytes      int64
+	maxTurns      int
+	totalBytes    int64
+	conversations map[string]*conversationLog
+	now           func() time.Time
+}
+
+var (
+	globalStore *ConversationStore
+	storeOnce   sync.Once
+)
+
+// GetConversationStore 获取全局会话审计存储
+func GetConversationStore() *ConversationStore {
+	storeOnce.Do(func() {
+		globalStore = NewConversationStore(config.AuditRetention(), config.AuditMaxBytes(), config.AuditMaxTurns())
+	})
+	return globalStore
+}
+
+// NewConversationStore 创建会话审计存储
+func NewConversationStore(retention time.Duration, maxBytes int64, maxTurns int) *ConversationStore {
+	return &ConversationStore{
+		retention:     retention,
+		maxBytes:      maxBytes,
+		maxTurns:      maxTurns,
+		conversations: make(map[string]*conversationLog),
+		now:           time.Now,
+	}
+}
+
+// Retention 记录保留时长
+func (s *ConversationStore) Retention() time.Duration {
+	return s.retention
+}
+
+// Record 追加一条会话记录，Timestamp 为空时使用当前时间
+func (s *ConversationStore) Record(conversationID string, turn Turn) {
+	if conversationID == "" {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.now()
+	if turn.Timestamp.IsZero() {
+		turn.Timestamp = now
+	}
+	s.evictExpiredLocked(now)
+
+	turn.size = turnSize(turn)
+	if turn.size > s.maxBytes && turn.Request != nil {
+		// 单条请求体超过总上限时只保留元数据
+		turn.Request = nil
+		turn.RequestOmitted = true
+		turn.size = turnSize(turn)
+	}
+
+	log, ok := s.conversations[conversationID]
+	if !ok {
+		log = &conversationLog{}
+		s.conversations[conversationID] = log
+	}
+	log.turns = append(log.turns, turn)
+	s.totalBytes += turn.size
+
+	for len(log.turns) > s.maxTurns {
+		s.dropOldestLocked(conversationID, log)
+	}
+	for s.totalBytes > s.maxBytes {
+		if !s.evictGlobalOldestLocked() {
+			break
+		}
+	}
+}
+
+// Get 返回会话在保留期内的记录副本（按时间先后），不存在时返回nil
+func (s *ConversationStore) Get(conversationID string) []Turn {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.evictExpiredLocked(s.now())
+
+	log, ok := s.conversations[conversationID]
+	if !ok {
+		return nil
+	}
+	return append([]Turn(nil), log.turns...)
+}
+
+// TotalBytes 当前所有记录合计占用的估算字节数
+func (s *ConversationStore) TotalBytes() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.totalBytes
+}
+
+// evictExpiredLocked 淘汰超过保留时长的记录（每个会话内记录按时间有序，只需检查头部）
+func (s *ConversationStore) evictExpiredLocked(now time.Time) {
+	if s.retention <= 0 {
+		return
+	}
+	cutoff := now.Add(-s.retention)
+	for id, log := range s.conversations {
+		for len(log.turns) > 0 && log.turns[0].Timestamp.Before(cutoff) {
+			s.dropOldestLocked(id, log)
+		}
+	}
+}
+
+// evictGlobalOldestLocked 淘汰所有会话中最早的一条记录，没有可淘汰的记录时返回false
+func (s *ConversationStore) evictGlobalOldestLocked() bool {
+	var (
+		oldestID  string
+		oldestLog *conversationLog
+	)
+	for id, log := range s.conversations {
+		if oldestLog == nil || log.turns[0].Timestamp.Before(oldestLog.turns[0].Timestamp) {
+			oldestID, oldestLog = id, log
+		}
+	}
+	if oldestLog == nil {
+		return false
+	}
+	s.dropOldestLocked(oldestID, oldestLog)
+	return true
+}
+
+func (s *ConversationStore) dropOldestLocked(id string, log *conversationLog) {
+	s.totalBytes -= log.turns[0].size
+	log.turns[0] = Turn{}
+	log.turns = log.turns[1:]
+	if len(log.turns) == 0 {
+		delete(s.conversations, id)
+	}
+}
+
+// turnSize 按JSON序列化大小估算记录占用
+func turnSize(turn Turn) int64 {
+	data, err := utils.FastMarshal(turn)
+	if err != nil {
+		return 0
+	}
+	return int64(len(data))
+}
@@ -0,0 +1,103 @@
+package audit
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"kiro2api/types"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestStore 创建使用可控时钟的存储
+func newTestStore(retention time.Duration, maxBytes int64, maxTurns int) (*ConversationStore, *time.Time) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewConversationStore(retention, maxBytes, maxTurns)
+	store.now = func() time.Time { return now }
+	return store, &now
+}
+
+func TestConversationStore_RecordAndGet(t *testing.T) {
+	store, _ := newTestStore(time.Hour, 1<<20, 10)
+
+	store.Record("conv-1", Turn{Model: "claude-sonnet-4", MessageCount: 1, StopReason: "end_turn"})
+	store.Record("conv-1", Turn{Model: "claude-sonnet-4", MessageCount: 3, StopReason: "tool_use"})
+	store.Record("conv-2", Turn{Model: "claude-haiku-4.5"})
+	store.Record("", Turn{Model: "ignored"})
+
+	turns := store.Get("conv-1")
+	require.Len(t, turns, 2)
+	assert.Equal(t, 1, turns[0].MessageCount)
+	assert.Equal(t, "tool_use", turns[1].StopReason)
+	assert.False(t, turns[0].Timestamp.IsZero())
+
+	assert.Len(t, store.Get("conv-2"), 1)
+	assert.Nil(t, store.Get("missing"))
+}
+
+func TestConversationStore_RetentionExpiry(t *testing.T) {
+	store, now := newTestStore(10*time.Minute, 1<<20, 10)
+
+	store.Record("conv-1", Turn{Model: "old"})
+	*now = now.Add(6 * time.Minute)
+	store.Record("conv-1", Turn{Model: "new"})
+	store.Record("conv-2", Turn{Model: "other"})
+
+	*now = now.Add(5 * time.Minute)
+	turns := store.Get("conv-1")
+	require.Len(t, turns, 1, "超过保留时长的记录应被淘汰")
+	assert.Equal(t, "new", turns[0].Model)
+
+	*now = now.Add(10 * time.Minute)
+	assert.Nil(t, store.Get("conv-1"))
+	assert.Nil(t, store.Get("conv-2"))
+	assert.Zero(t, store.TotalBytes(), "淘汰后占用应归零")
+}
+
+func TestConversationStore_MaxTurnsPerConversation(t *testing.T) {
+	store, _ := newTestStore(time.Hour, 1<<20, 3)
+
+	for i := 1; i <= 5; i++ {
+		store.Record("conv-1", Turn{MessageCount: i})
+	}
+
+	turns := store.Get("conv-1")
+	require.Len(t, turns, 3)
+	assert.Equal(t, 3, turns[0].MessageCount)
+	assert.Equal(t, 5, turns[2].MessageCount)
+}
+
+func TestConversationStore_MaxBytesEvictsGloballyOldest(t *testing.T) {
+	single := turnSize(Turn{Model: "m", Timestamp: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)})
+	store, now := newTestStore(time.Hour, single*3, 100)
+
+	store.Record("conv-a", Turn{Model: "m"})
+	*now = now.Add(time.Second)
+	store.Record("conv-b", Turn{Model: "m"})
+	*now = now.Add(time.Second)
+	store.Record("conv-a", Turn{Model: "m"})
+	*now = now.Add(time.Second)
+	store.Record("conv-b", Turn{Model: "m"})
+
+	assert.LessOrEqual(t, store.TotalBytes(), single*3)
+	assert.Len(t, store.Get("conv-a"), 1, "全局最早的记录（conv-a第一条）应被淘汰")
+	assert.Len(t, store.Get("conv-b"), 2)
+}
+
+func TestConversationStore_OversizedRequestKeepsMetadata(t *testing.T) {
+	store, _ := newTestStore(time.Hour, 512, 10)
+
+	req := types.AnthropicRequest{
+		Model:    "claude-sonnet-4",
+		Messages: []types.AnthropicRequestMessage{{Role: "user", Content: strings.Repeat("x", 2048)}},
+	}
+	store.Record("conv-1", Turn{Model: req.Model, Request: &req})
+
+	turns := store.Get("conv-1")
+	require.Len(t, turns, 1)
+	assert.Nil(t, turns[0].Request)
+	assert.True(t, turns[0].RequestOmitted)
+	assert.LessOrEqual(t, store.TotalBytes(), int64(512))
+}
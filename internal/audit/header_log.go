@@ -0,0 +1,117 @@
+package audit
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"kiro2api/config"
+)
+
+// HeaderExchange 一次上游请求的请求头与响应头（敏感请求头已脱敏）
+type HeaderExchange struct {
+	Attempt         int               `json:"attempt"`
+	Endpoint        string            `json:"endpoint"`
+	StatusCode      int               `json:"status_code,omitempty"` // 请求未发送成功时为0
+	Error           string            `json:"error,omitempty"`
+	RequestHeaders  map[string]string `json:"request_headers"`
+	ResponseHeaders map[string]string `json:"response_headers,omitempty"`
+}
+
+// RequestHeaders 一个客户端请求触发的全部上游请求（含429重试）
+type RequestHeaders struct {
+	RequestID string           `json:"request_id"`
+	Path      string           `json:"path"`
+	Timestamp time.Time        `json:"timestamp"`
+	Exchanges []HeaderExchange `json:"exchanges"`
+}
+
+// HeaderLog 按请求ID保存最近请求的上游请求头，超出上限时丢弃最早的请求
+type HeaderLog struct {
+	mutex       sync.Mutex
+	entries     map[string]RequestHeaders
+	order       []string
+	maxRequests int
+	now         func() time.Time
+}
+
+var (
+	globalHeaderLog *HeaderLog
+	headerLogOnce   sync.Once
+)
+
+// GetHeaderLog 获取全局上游请求头记录
+func GetHeaderLog() *HeaderLog {
+	headerLogOnce.Do(func() {
+		globalHeaderLog = NewHeaderLog(config.UpstreamHeaderLogMaxRequests)
+	})
+	return globalHeaderLog
+}
+
+// NewHeaderLog 创建上游请求头记录
+func NewHeaderLog(maxRequests int) *HeaderLog {
+	return &HeaderLog{
+		entries:     make(map[string]RequestHeaders),
+		maxRequests: maxRequests,
+		now:         time.Now,
+	}
+}
+
+// Record 保存一个请求的上游请求头，Timestamp 为空时使用当前时间；同一请求ID重复记录时覆盖
+func (l *HeaderLog) Record(entry RequestHeaders) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = l.now()
+	}
+	if _, exists := l.entries[entry.RequestID]; !exists {
+		l.order = append(l.order, entry.RequestID)
+	}
+	l.entries[entry.RequestID] = entry
+
+	if overflow := len(l.order) - l.maxRequests; overflow > 0 {
+		for _, id := range l.order[:overflow] {
+			delete(l.entries, id)
+		}
+		l.order = append([]string(nil), l.order[overflow:]...)
+	}
+}
+
+// Get 返回指定请求的上游请求头
+func (l *HeaderLog) Get(requestID string) (RequestHeaders, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	entry, ok := l.entries[requestID]
+	return entry, ok
+}
+
+// RedactHeaders 把请求头转换为便于记录的 map，多个取值以 ", " 连接
+// redact 中的请求头（不区分大小写）只保留前 config.RedactedHeaderPrefixChars 个字符并追加 "..."
+func RedactHeaders(header http.Header, redact []string) map[string]string {
+	sensitive := make(map[string]bool, len(redact))
+	for _, name := range redact {
+		sensitive[http.CanonicalHeaderKey(name)] = true
+	}
+
+	result := make(map[string]string, len(header))
+	for name, values := range header {
+		value := strings.Join(values, ", ")
+		if sensitive[http.CanonicalHeaderKey(name)] {
+			value = redactHeaderValue(value)
+		}
+		result[name] = value
+	}
+	return result
+}
+
+// redactHeaderValue 保留前缀便于区分不同凭据；值不长于前缀时整体隐藏
+func redactHeaderValue(value string) string {
+	runes := []rune(value)
+	if len(runes) <= config.RedactedHeaderPrefixChars {
+		return "..."
+	}
+	return string(runes[:config.RedactedHeaderPrefixChars]) + "..."
+}
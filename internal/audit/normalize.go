@@ -0,0 +1,86 @@
+package audit
+
+import (
+	"encoding/base64"
+	"strings"
+
+	"kiro2api/types"
+	"kiro2api/utils"
+)
+
+// NormalizeRequest 返回用于审计保存的请求副本：消息内容统一为JSON结构，
+// base64图片（包括tool_result中嵌套的图片）替换为只包含媒体类型和字节数的占位，原请求不受影响
+func NormalizeRequest(req types.AnthropicRequest) types.AnthropicRequest {
+	normalized := req
+	normalized.Messages = make([]types.AnthropicRequestMessage, len(req.Messages))
+	for i, msg := range req.Messages {
+		normalized.Messages[i] = types.AnthropicRequestMessage{
+			Role:    msg.Role,
+			Content: normalizeContent(msg.Content),
+		}
+	}
+	return normalized
+}
+
+// normalizeContent 通过JSON往返得到与类型无关的内容结构，再替换其中的图片
+func normalizeContent(content any) any {
+	if text, ok := content.(string); ok {
+		return text
+	}
+	data, err := utils.FastMarshal(content)
+	if err != nil {
+		return content
+	}
+	var generic any
+	if err := utils.FastUnmarshal(data, &generic); err != nil {
+		return content
+	}
+	return replaceImages(generic)
+}
+
+func replaceImages(value any) any {
+	switch v := value.(type) {
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = replaceImages(item)
+		}
+		return out
+	case map[string]any:
+		if v["type"] == "image" {
+			if placeholder, ok := imagePlaceholder(v); ok {
+				return placeholder
+			}
+		}
+		out := make(map[string]any, len(v))
+		for k, item := range v {
+			out[k] = replaceImages(item)
+		}
+		return out
+	default:
+		return value
+	}
+}
+
+// imagePlaceholder 将带base64数据的图片块替换为大小占位；url等不含数据的图片块保持原样
+func imagePlaceholder(block map[string]any) (map[string]any, bool) {
+	source, _ := block["source"].(map[string]any)
+	data, ok := source["data"].(string)
+	if !ok {
+		return nil, false
+	}
+	mediaType, _ := source["media_type"].(string)
+	return map[string]any{
+		"type": "image",
+		"source": map[string]any{
+			"type":       "placeholder",
+			"media_type": mediaType,
+			"size_bytes": decodedBase64Len(data),
+		},
+	}, true
+}
+
+// decodedBase64Len 计算base64数据解码后的字节数（不实际解码）
+func decodedBase64Len(data string) int {
+	return base64.RawStdEncoding.DecodedLen(len(strings.TrimRight(strings.TrimSpace(data), "=")))
+}
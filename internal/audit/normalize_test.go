@@ -0,0 +1,83 @@
+package audit
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"kiro2api/types"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeRequest_ReplacesImagesWithSizePlaceholders(t *testing.T) {
+	imageData := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("p", 1001)))
+	imageBlock := map[string]any{
+		"type":   "image",
+		"source": map[string]any{"type": "base64", "media_type": "image/png", "data": imageData},
+	}
+	req := types.AnthropicRequest{
+		Model: "claude-sonnet-4",
+		Messages: []types.AnthropicRequestMessage{
+			{Role: "user", Content: "plain text"},
+			{Role: "user", Content: []any{
+				map[string]any{"type": "text", "text": "look"},
+				imageBlock,
+				map[string]any{
+					"type":        "tool_result",
+					"tool_use_id": "toolu_1",
+					"content":     []any{imageBlock},
+				},
+				map[string]any{
+					"type":   "image",
+					"source": map[string]any{"type": "url", "url": "https://example.com/a.png"},
+				},
+			}},
+		},
+	}
+
+	normalized := NormalizeRequest(req)
+
+	assert.Equal(t, "plain text", normalized.Messages[0].Content)
+
+	blocks, ok := normalized.Messages[1].Content.([]any)
+	require.True(t, ok)
+	require.Len(t, blocks, 4)
+
+	placeholder := blocks[1].(map[string]any)["source"].(map[string]any)
+	assert.Equal(t, "placeholder", placeholder["type"])
+	assert.Equal(t, "image/png", placeholder["media_type"])
+	assert.Equal(t, 1001, placeholder["size_bytes"])
+	assert.NotContains(t, placeholder, "data")
+
+	nested := blocks[2].(map[string]any)["content"].([]any)[0].(map[string]any)["source"].(map[string]any)
+	assert.Equal(t, "placeholder", nested["type"], "tool_result中的图片也应替换")
+
+	urlSource := blocks[3].(map[string]any)["source"].(map[string]any)
+	assert.Equal(t, "https://example.com/a.png", urlSource["url"], "不含数据的图片保持原样")
+
+	original := req.Messages[1].Content.([]any)[1].(map[string]any)["source"].(map[string]any)
+	assert.Equal(t, imageData, original["data"], "不应修改原请求")
+}
+
+func TestNormalizeRequest_TypedContentBlocks(t *testing.T) {
+	req := types.AnthropicRequest{
+		Messages: []types.AnthropicRequestMessage{{Role: "user", Content: []types.ContentBlock{{
+			Type:   "image",
+			Source: &types.ImageSource{Type: "base64", MediaType: "image/jpeg", Data: "aGVsbG8="},
+		}}}},
+	}
+
+	blocks := NormalizeRequest(req).Messages[0].Content.([]any)
+	source := blocks[0].(map[string]any)["source"].(map[string]any)
+	assert.Equal(t, "placeholder", source["type"])
+	assert.Equal(t, 5, source["size_bytes"])
+}
+
+func TestDecodedBase64Len(t *testing.T) {
+	for _, raw := range []string{"", "a", "ab", "abc", "abcd", strings.Repeat("z", 77)} {
+		assert.Equal(t, len(raw), decodedBase64Len(base64.StdEncoding.EncodeToString([]byte(raw))), raw)
+		assert.Equal(t, len(raw), decodedBase64Len(base64.RawStdEncoding.EncodeToString([]byte(raw))), raw)
+	}
+}
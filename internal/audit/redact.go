@@ -0,0 +1,46 @@
+package audit
+
+import (
+	"regexp"
+	"strings"
+)
+
+// redactRule 单条脱敏规则
+type redactRule struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// redactRules 按顺序应用：先处理带前缀的凭据，再处理通用密钥和个人信息
+var redactRules = []redactRule{
+	{regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9._~+/=-]+`), "Bearer [REDACTED]"},
+	{regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`), "[SECRET]"},
+	{regexp.MustCompile(`\bsk-[A-Za-z0-9_-]{16,}`), "[SECRET]"},
+	{regexp.MustCompile(`\b(?:AKIA|ASIA)[A-Z0-9]{16}\b`), "[SECRET]"},
+	{regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`), "[EMAIL]"},
+	{regexp.MustCompile(`(?:\+\d{1,3}[\s-]?)?(?:\(\d{2,4}\)[\s-]?)?\d{3,4}[\s-]?\d{3,4}[\s-]?\d{3,4}\b`), "[PHONE]"},
+}
+
+// RedactPreview 对文本脱敏后截取前 limit 个字符
+// 先脱敏再截断，避免截断点切开凭据导致残留片段无法匹配；
+// 超长文本先按 limit 的数倍预截断，控制正则扫描的开销
+func RedactPreview(text string, limit int) string {
+	if limit <= 0 {
+		return ""
+	}
+	runes := []rune(text)
+	if max := limit * 4; len(runes) > max {
+		runes = runes[:max]
+	}
+
+	redacted := strings.TrimSpace(string(runes))
+	for _, rule := range redactRules {
+		redacted = rule.pattern.ReplaceAllString(redacted, rule.replacement)
+	}
+
+	runes = []rune(redacted)
+	if len(runes) > limit {
+		runes = runes[:limit]
+	}
+	return string(runes)
+}
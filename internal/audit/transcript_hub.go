@@ -0,0 +1,99 @@
+package audit
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"kiro2api/config"
+)
+
+// TranscriptEvent 推送给实时监控客户端的单条会话消息（内容已脱敏并截断）
+type TranscriptEvent struct {
+	ConversationID string `json:"conversation_id"`
+	Role           string `json:"role"`
+	ContentPreview string `json:"content_preview"`
+	TokenCount     int    `json:"token_count"`
+}
+
+// TranscriptHub 会话消息的扇出广播：每个订阅者持有独立的缓冲通道
+// Publish 从不阻塞请求处理，订阅者消费过慢、缓冲已满时丢弃该订阅者的新事件
+type TranscriptHub struct {
+	mutex       sync.RWMutex
+	buffer      int
+	nextID      uint64
+	subscribers map[uint64]chan TranscriptEvent
+	dropped     atomic.Int64
+}
+
+var (
+	globalHub *TranscriptHub
+	hubOnce   sync.Once
+)
+
+// GetTranscriptHub 获取全局会话消息广播
+func GetTranscriptHub() *TranscriptHub {
+	hubOnce.Do(func() {
+		globalHub = NewTranscriptHub(config.TranscriptSubscriberBuffer)
+	})
+	return globalHub
+}
+
+// NewTranscriptHub 创建会话消息广播，buffer 为每个订阅者的缓冲长度
+func NewTranscriptHub(buffer int) *TranscriptHub {
+	if buffer < 1 {
+		buffer = 1
+	}
+	return &TranscriptHub{
+		buffer:      buffer,
+		subscribers: make(map[uint64]chan TranscriptEvent),
+	}
+}
+
+// Subscribe 注册订阅者，返回事件通道和取消函数
+// 取消函数可重复调用，调用后通道被关闭
+func (h *TranscriptHub) Subscribe() (<-chan TranscriptEvent, func()) {
+	ch := make(chan TranscriptEvent, h.buffer)
+
+	h.mutex.Lock()
+	h.nextID++
+	id := h.nextID
+	h.subscribers[id] = ch
+	h.mutex.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			h.mutex.Lock()
+			delete(h.subscribers, id)
+			h.mutex.Unlock()
+			close(ch)
+		})
+	}
+	return ch, cancel
+}
+
+// Publish 向所有订阅者广播事件
+func (h *TranscriptHub) Publish(event TranscriptEvent) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	for _, ch := range h.subscribers {
+		select {
+		case ch <- event:
+		default:
+			h.dropped.Add(1)
+		}
+	}
+}
+
+// SubscriberCount 当前订阅者数量，为0时调用方可跳过事件构造
+func (h *TranscriptHub) SubscriberCount() int {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return len(h.subscribers)
+}
+
+// Dropped 因订阅者缓冲已满而丢弃的事件总数
+func (h *TranscriptHub) Dropped() int64 {
+	return h.dropped.Load()
+}
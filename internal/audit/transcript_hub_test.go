@@ -0,0 +1,51 @@
+package audit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTranscriptHub_FanOut(t *testing.T) {
+	hub := NewTranscriptHub(4)
+	first, cancelFirst := hub.Subscribe()
+	second, cancelSecond := hub.Subscribe()
+	defer cancelSecond()
+	assert.Equal(t, 2, hub.SubscriberCount())
+
+	event := TranscriptEvent{ConversationID: "conv-1", Role: "user", ContentPreview: "hi", TokenCount: 1}
+	hub.Publish(event)
+
+	assert.Equal(t, event, <-first)
+	assert.Equal(t, event, <-second)
+
+	cancelFirst()
+	cancelFirst() // 重复取消不应panic
+	assert.Equal(t, 1, hub.SubscriberCount())
+	_, open := <-first
+	assert.False(t, open, "取消后通道应关闭")
+
+	hub.Publish(event)
+	assert.Equal(t, event, <-second)
+}
+
+func TestTranscriptHub_SlowSubscriberDoesNotBlock(t *testing.T) {
+	hub := NewTranscriptHub(2)
+	slow, cancelSlow := hub.Subscribe()
+	defer cancelSlow()
+	fast, cancelFast := hub.Subscribe()
+	defer cancelFast()
+
+	for i := 0; i < 5; i++ {
+		hub.Publish(TranscriptEvent{ConversationID: "conv-1", TokenCount: i})
+		// fast 及时消费，全部收到
+		require.Equal(t, i, (<-fast).TokenCount)
+	}
+
+	// slow 只保留缓冲内最早的事件，其余被丢弃
+	assert.Equal(t, 0, (<-slow).TokenCount)
+	assert.Equal(t, 1, (<-slow).TokenCount)
+	assert.Len(t, slow, 0)
+	assert.Equal(t, int64(3), hub.Dropped())
+}
@@ -0,0 +1,111 @@
+package batch
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"kiro2api/config"
+	"kiro2api/converter"
+	"kiro2api/utils"
+)
+
+// customIDPattern custom_id 的格式约束（与 Anthropic API 一致）
+var customIDPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// RequestItem 批处理中的单个请求，params 为 /v1/messages 的请求体
+type RequestItem struct {
+	CustomID string         `json:"custom_id"`
+	Params   map[string]any `json:"params"`
+}
+
+// CreateRequest POST /v1/messages/batches 的请求体
+type CreateRequest struct {
+	Requests []RequestItem `json:"requests"`
+}
+
+// ValidateCreateRequest 检查批处理的结构约束；params 的字段在执行时由 /v1/messages 的流程校验，失败时计为该请求的错误结果
+func ValidateCreateRequest(req CreateRequest) []converter.ValidationError {
+	var errs []converter.ValidationError
+	add := func(field, format string, args ...any) {
+		errs = append(errs, converter.ValidationError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	switch {
+	case len(req.Requests) == 0:
+		add("requests", "requests 数组不能为空")
+	case len(req.Requests) > config.BatchMaxRequests:
+		add("requests", "单个批处理最多 %d 个请求，实际为 %d", config.BatchMaxRequests, len(req.Requests))
+	}
+
+	seen := make(map[string]bool, len(req.Requests))
+	for i, item := range req.Requests {
+		field := fmt.Sprintf("requests.%d", i)
+		switch {
+		case !customIDPattern.MatchString(item.CustomID):
+			add(field+".custom_id", "必须为1~64个字母、数字、下划线或连字符: %q", item.CustomID)
+		case seen[item.CustomID]:
+			add(field+".custom_id", "custom_id 重复: %s", item.CustomID)
+		}
+		seen[item.CustomID] = true
+
+		if item.Params == nil {
+			add(field+".params", "不能为空")
+			continue
+		}
+		if stream, _ := item.Params["stream"].(bool); stream {
+			add(field+".params.stream", "批处理不支持流式请求")
+		}
+	}
+	return errs
+}
+
+// ResultFromResponse 把 /v1/messages 的响应转换为批处理结果
+// 非200响应统一转换为 Anthropic 错误格式 {"type":"error","error":{"type":...,"message":...}}
+func ResultFromResponse(customID string, status int, body []byte) Result {
+	var decoded map[string]any
+	_ = utils.SafeUnmarshal(body, &decoded)
+
+	if status == http.StatusOK && decoded != nil {
+		return Result{CustomID: customID, Result: ResultBody{Type: ResultSucceeded, Message: decoded}}
+	}
+
+	if decoded != nil && decoded["type"] == "error" {
+		return Result{CustomID: customID, Result: ResultBody{Type: ResultErrored, Error: decoded}}
+	}
+
+	message := string(body)
+	if errObj, ok := decoded["error"].(map[string]any); ok {
+		if msg, ok := errObj["message"].(string); ok {
+			message = msg
+		}
+	}
+	return Result{CustomID: customID, Result: ResultBody{
+		Type: ResultErrored,
+		Error: map[string]any{
+			"type": "error",
+			"error": map[string]any{
+				"type":    errorType(status),
+				"message": message,
+			},
+		},
+	}}
+}
+
+// errorType 按HTTP状态码映射 Anthropic 错误类型
+func errorType(status int) string {
+	switch status {
+	case http.StatusBadRequest, http.StatusNotAcceptable:
+		return "invalid_request_error"
+	case http.StatusUnauthorized:
+		return "authentication_error"
+	case http.StatusForbidden:
+		return "permission_error"
+	case http.StatusNotFound:
+		return "not_found_error"
+	case http.StatusTooManyRequests:
+		return "rate_limit_error"
+	default:
+		return "api_error"
+	}
+}
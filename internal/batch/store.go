@@ -0,0 +1,300 @@
+package batch
+
+import (
+	"crypto/subtle"
+	"sync"
+	"time"
+
+	"kiro2api/config"
+	"kiro2api/logger"
+	"kiro2api/utils"
+)
+
+// StateFileName 批处理状态与结果的持久化文件名，位于token配置所在目录
+const StateFileName = "batches.json"
+
+// 批处理状态
+const (
+	StatusInProgress = "in_progress"
+	StatusEnded      = "ended"
+)
+
+// 单个请求的结果类型
+const (
+	ResultSucceeded = "succeeded"
+	ResultErrored   = "errored"
+	ResultExpired   = "expired"
+)
+
+// RequestCounts 批处理中各状态的请求数
+type RequestCounts struct {
+	Processing int `json:"processing"`
+	Succeeded  int `json:"succeeded"`
+	Errored    int `json:"errored"`
+	Canceled   int `json:"canceled"`
+	Expired    int `json:"expired"`
+}
+
+// MessageBatch Anthropic Message Batches API 的批处理对象
+type MessageBatch struct {
+	ID                string        `json:"id"`
+	Type              string        `json:"type"`
+	ProcessingStatus  string        `json:"processing_status"`
+	RequestCounts     RequestCounts `json:"request_counts"`
+	EndedAt           *time.Time    `json:"ended_at"`
+	CreatedAt         time.Time     `json:"created_at"`
+	ExpiresAt         time.Time     `json:"expires_at"`
+	ArchivedAt        *time.Time    `json:"archived_at"`
+	CancelInitiatedAt *time.Time    `json:"cancel_initiated_at"`
+	ResultsURL        *string       `json:"results_url"`
+}
+
+// ResultBody 单个请求的结果：成功时为完整消息，失败时为 Anthropic 格式的错误
+type ResultBody struct {
+	Type    string         `json:"type"`
+	Message map[string]any `json:"message,omitempty"`
+	Error   map[string]any `json:"error,omitempty"`
+}
+
+// Result 结果文件（JSONL）中的一行
+type Result struct {
+	CustomID string     `json:"custom_id"`
+	Result   ResultBody `json:"result"`
+}
+
+// entry 批处理及其结果，results 按提交顺序排列，未完成的请求为nil
+type entry struct {
+	batch     MessageBatch
+	owner     string // 创建者的客户端密钥名称，未启用客户端密钥时为空
+	customIDs []string
+	results   []*Result
+}
+
+// persistedEntry 持久化文件中的单个批处理
+type persistedEntry struct {
+	Batch     MessageBatch `json:"batch"`
+	Owner     string       `json:"owner,omitempty"`
+	CustomIDs []string     `json:"custom_ids"`
+	Results   []*Result    `json:"results"`
+}
+
+// Storage 批处理状态的持久化存储，由 auth.ConfigStorage 实现
+type Storage interface {
+	LoadJSON(name string, v any) (bool, error)
+	SaveJSON(name string, v any) error
+}
+
+// Store 批处理状态存储
+// 已结束的批处理在 retention 到期后淘汰，进行中的批处理不会被淘汰；
+// 设置了 storage 时每次状态变化都写入持久化文件，重启后通过 Load 恢复
+type Store struct {
+	mutex     sync.Mutex
+	retention time.Duration
+	batches   map[string]*entry
+	storage   Storage
+	now       func() time.Time
+}
+
+var (
+	globalStore *Store
+	storeMu     sync.Mutex
+)
+
+// GetStore 获取全局批处理存储；启动时未通过 SetStore 设置时创建不持久化的存储
+func GetStore() *Store {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	if globalStore == nil {
+		globalStore = NewStore(config.BatchRetention, nil)
+	}
+	return globalStore
+}
+
+// SetStore 替换全局批处理存储（启动时加载持久化状态后设置）
+func SetStore(s *Store) {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	globalStore = s
+}
+
+// NewStore 创建批处理存储，storage 为nil时不持久化
+func NewStore(retention time.Duration, storage Storage) *Store {
+	return &Store{
+		retention: retention,
+		batches:   make(map[string]*entry),
+		storage:   storage,
+		now:       time.Now,
+	}
+}
+
+// Load 从持久化存储恢复批处理，文件不存在时保持空状态
+// 上次关闭时仍在进行中的请求已无法继续执行，记为 expired 结果并结束批处理
+func (s *Store) Load() error {
+	if s.storage == nil {
+		return nil
+	}
+	var state map[string]persistedEntry
+	found, err := s.storage.LoadJSON(StateFileName, &state)
+	if err != nil || !found {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	expired := 0
+	for id, p := range state {
+		if len(p.Results) != len(p.CustomIDs) {
+			logger.Warn("批处理持久化数据不完整，已丢弃", logger.String("batch_id", id))
+			continue
+		}
+		e := &entry{batch: p.Batch, owner: p.Owner, customIDs: p.CustomIDs, results: p.Results}
+		s.batches[id] = e
+		for i, r := range e.results {
+			if r == nil {
+				expired++
+				s.recordUnlocked(id, e, i, Result{CustomID: e.customIDs[i], Result: ResultBody{Type: ResultExpired}})
+			}
+		}
+	}
+	s.evictUnlocked()
+	if expired > 0 {
+		s.saveUnlocked()
+	}
+	return nil
+}
+
+// Create 创建批处理，owner 为创建者的客户端密钥名称，customIDs 为各请求的 custom_id（按提交顺序），返回批处理快照
+func (s *Store) Create(owner string, customIDs []string) MessageBatch {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.evictUnlocked()
+
+	now := s.now().UTC()
+	b := MessageBatch{
+		ID:               "msgbatch_" + utils.RandomHex(24),
+		Type:             "message_batch",
+		ProcessingStatus: StatusInProgress,
+		RequestCounts:    RequestCounts{Processing: len(customIDs)},
+		CreatedAt:        now,
+		ExpiresAt:        now.Add(s.retention),
+	}
+	s.batches[b.ID] = &entry{
+		batch:     b,
+		owner:     owner,
+		customIDs: append([]string(nil), customIDs...),
+		results:   make([]*Result, len(customIDs)),
+	}
+	s.saveUnlocked()
+	return b
+}
+
+// Get 返回 owner 创建的批处理快照，批处理不存在或属于其他客户端密钥时返回false
+func (s *Store) Get(id, owner string) (MessageBatch, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.evictUnlocked()
+
+	e, ok := s.lookupUnlocked(id, owner)
+	if !ok {
+		return MessageBatch{}, false
+	}
+	return e.batch, true
+}
+
+// Results 返回 owner 创建的已结束批处理的全部结果（按提交顺序）
+// 批处理不存在或属于其他客户端密钥时 batch 为零值，未结束时 ok 为false
+func (s *Store) Results(id, owner string) (results []Result, batch MessageBatch, ok bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.evictUnlocked()
+
+	e, exists := s.lookupUnlocked(id, owner)
+	if !exists {
+		return nil, MessageBatch{}, false
+	}
+	if e.batch.ProcessingStatus != StatusEnded {
+		return nil, e.batch, false
+	}
+
+	results = make([]Result, 0, len(e.results))
+	for _, r := range e.results {
+		if r != nil {
+			results = append(results, *r)
+		}
+	}
+	return results, e.batch, true
+}
+
+// lookupUnlocked 查找批处理，属于其他客户端密钥的按不存在处理，不暴露批处理ID是否有效（调用方需持有锁）
+func (s *Store) lookupUnlocked(id, owner string) (*entry, bool) {
+	e, ok := s.batches[id]
+	if !ok || subtle.ConstantTimeCompare([]byte(e.owner), []byte(owner)) != 1 {
+		return nil, false
+	}
+	return e, true
+}
+
+// RecordResult 记录第 index 个请求的结果，所有请求完成后批处理结束
+func (s *Store) RecordResult(id string, index int, result Result) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	e, ok := s.batches[id]
+	if !ok || index < 0 || index >= len(e.results) || e.results[index] != nil {
+		return
+	}
+	s.recordUnlocked(id, e, index, result)
+	s.saveUnlocked()
+}
+
+// recordUnlocked 写入结果并更新计数，所有请求完成后结束批处理（调用方需持有锁）
+func (s *Store) recordUnlocked(id string, e *entry, index int, result Result) {
+	e.results[index] = &result
+
+	counts := &e.batch.RequestCounts
+	counts.Processing--
+	switch result.Result.Type {
+	case ResultSucceeded:
+		counts.Succeeded++
+	case ResultExpired:
+		counts.Expired++
+	default:
+		counts.Errored++
+	}
+
+	if counts.Processing == 0 {
+		endedAt := s.now().UTC()
+		resultsURL := "/v1/messages/batches/" + id + "/results"
+		e.batch.ProcessingStatus = StatusEnded
+		e.batch.EndedAt = &endedAt
+		e.batch.ResultsURL = &resultsURL
+	}
+}
+
+// saveUnlocked 把全部批处理写入持久化存储（调用方需持有锁）
+// 写入失败只记录日志，内存中的状态仍然有效
+func (s *Store) saveUnlocked() {
+	if s.storage == nil {
+		return
+	}
+	state := make(map[string]persistedEntry, len(s.batches))
+	for id, e := range s.batches {
+		state[id] = persistedEntry{Batch: e.batch, Owner: e.owner, CustomIDs: e.customIDs, Results: e.results}
+	}
+	if err := s.storage.SaveJSON(StateFileName, state); err != nil {
+		logger.Warn("保存批处理状态失败", logger.Err(err))
+	}
+}
+
+// evictUnlocked 淘汰结束时间超过保留期的批处理（调用方需持有锁）
+func (s *Store) evictUnlocked() {
+	if s.retention <= 0 {
+		return
+	}
+	cutoff := s.now().Add(-s.retention)
+	for id, e := range s.batches {
+		if e.batch.EndedAt != nil && e.batch.EndedAt.Before(cutoff) {
+			delete(s.batches, id)
+		}
+	}
+}
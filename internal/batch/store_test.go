@@ -0,0 +1,179 @@
+package batch
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"kiro2api/auth"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore_RecordResultsUntilEnded(t *testing.T) {
+	s := NewStore(time.Hour, nil)
+	created := s.Create("", []string{"a", "b", "c"})
+
+	assert.True(t, strings.HasPrefix(created.ID, "msgbatch_"))
+	assert.Equal(t, StatusInProgress, created.ProcessingStatus)
+	assert.Equal(t, RequestCounts{Processing: 3}, created.RequestCounts)
+
+	s.RecordResult(created.ID, 2, Result{CustomID: "c", Result: ResultBody{Type: ResultErrored}})
+	s.RecordResult(created.ID, 0, Result{CustomID: "a", Result: ResultBody{Type: ResultSucceeded}})
+	// 重复记录被忽略
+	s.RecordResult(created.ID, 0, Result{CustomID: "a", Result: ResultBody{Type: ResultErrored}})
+
+	_, inProgress, ok := s.Results(created.ID, "")
+	assert.False(t, ok, "未结束的批处理不能获取结果")
+	assert.Equal(t, RequestCounts{Processing: 1, Succeeded: 1, Errored: 1}, inProgress.RequestCounts)
+
+	s.RecordResult(created.ID, 1, Result{CustomID: "b", Result: ResultBody{Type: ResultSucceeded}})
+
+	results, ended, ok := s.Results(created.ID, "")
+	require.True(t, ok)
+	assert.Equal(t, StatusEnded, ended.ProcessingStatus)
+	assert.Equal(t, RequestCounts{Succeeded: 2, Errored: 1}, ended.RequestCounts)
+	require.NotNil(t, ended.EndedAt)
+	require.NotNil(t, ended.ResultsURL)
+	assert.Equal(t, "/v1/messages/batches/"+created.ID+"/results", *ended.ResultsURL)
+
+	var ids []string
+	for _, r := range results {
+		ids = append(ids, r.CustomID)
+	}
+	assert.Equal(t, []string{"a", "b", "c"}, ids, "结果按提交顺序排列")
+}
+
+func TestStore_EvictsEndedBatchesAfterRetention(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := NewStore(time.Hour, nil)
+	s.now = func() time.Time { return now }
+
+	ended := s.Create("", []string{"a"})
+	s.RecordResult(ended.ID, 0, Result{CustomID: "a", Result: ResultBody{Type: ResultSucceeded}})
+	running := s.Create("", []string{"a"})
+
+	now = now.Add(2 * time.Hour)
+
+	_, ok := s.Get(ended.ID, "")
+	assert.False(t, ok)
+	_, ok = s.Get(running.ID, "")
+	assert.True(t, ok, "进行中的批处理不会被淘汰")
+}
+
+// TestStore_ScopedToOwner 批处理只对创建它的客户端密钥可见，重启后仍然有效
+func TestStore_ScopedToOwner(t *testing.T) {
+	t.Setenv("CONFIG_DIR", t.TempDir())
+	storage := auth.NewConfigStorage()
+
+	s := NewStore(time.Hour, storage)
+	created := s.Create("marketing", []string{"a"})
+	s.RecordResult(created.ID, 0, Result{CustomID: "a", Result: ResultBody{Type: ResultSucceeded}})
+
+	for _, store := range []*Store{s, reloaded(t, storage)} {
+		_, ok := store.Get(created.ID, "marketing")
+		assert.True(t, ok)
+		_, _, ok = store.Results(created.ID, "marketing")
+		assert.True(t, ok)
+
+		for _, other := range []string{"ci", ""} {
+			_, ok = store.Get(created.ID, other)
+			assert.False(t, ok, other)
+			_, b, ok := store.Results(created.ID, other)
+			assert.False(t, ok, other)
+			assert.Empty(t, b.ID, "其他客户端密钥无法区分批处理是否存在")
+		}
+	}
+}
+
+func reloaded(t *testing.T, storage Storage) *Store {
+	t.Helper()
+	s := NewStore(time.Hour, storage)
+	require.NoError(t, s.Load())
+	return s
+}
+
+func TestStore_PersistsAcrossRestart(t *testing.T) {
+	t.Setenv("CONFIG_DIR", t.TempDir())
+	storage := auth.NewConfigStorage()
+
+	s := NewStore(time.Hour, storage)
+	ended := s.Create("", []string{"a"})
+	s.RecordResult(ended.ID, 0, Result{CustomID: "a", Result: ResultBody{Type: ResultSucceeded, Message: map[string]any{"id": "msg_1"}}})
+	running := s.Create("", []string{"b", "c"})
+	s.RecordResult(running.ID, 1, Result{CustomID: "c", Result: ResultBody{Type: ResultErrored}})
+
+	// 模拟重启：新存储从同一文件恢复
+	restarted := NewStore(time.Hour, storage)
+	require.NoError(t, restarted.Load())
+
+	results, b, ok := restarted.Results(ended.ID, "")
+	require.True(t, ok)
+	assert.Equal(t, ended.CreatedAt, b.CreatedAt)
+	require.Len(t, results, 1)
+	assert.Equal(t, "msg_1", results[0].Result.Message["id"])
+
+	// 重启前未完成的请求记为 expired，批处理结束
+	results, b, ok = restarted.Results(running.ID, "")
+	require.True(t, ok)
+	assert.Equal(t, StatusEnded, b.ProcessingStatus)
+	assert.Equal(t, RequestCounts{Errored: 1, Expired: 1}, b.RequestCounts)
+	require.Len(t, results, 2)
+	assert.Equal(t, Result{CustomID: "b", Result: ResultBody{Type: ResultExpired}}, results[0])
+
+	// 恢复时结束的批处理已写回文件
+	again := NewStore(time.Hour, storage)
+	require.NoError(t, again.Load())
+	b, ok = again.Get(running.ID, "")
+	require.True(t, ok)
+	assert.Equal(t, StatusEnded, b.ProcessingStatus)
+}
+
+func TestValidateCreateRequest(t *testing.T) {
+	params := map[string]any{"model": "claude-sonnet-4"}
+	tests := []struct {
+		name   string
+		req    CreateRequest
+		fields []string
+	}{
+		{name: "合法", req: CreateRequest{Requests: []RequestItem{{CustomID: "req-1", Params: params}, {CustomID: "req_2", Params: params}}}},
+		{name: "requests为空", req: CreateRequest{}, fields: []string{"requests"}},
+		{name: "custom_id格式错误", req: CreateRequest{Requests: []RequestItem{{CustomID: "a b", Params: params}}}, fields: []string{"requests.0.custom_id"}},
+		{name: "custom_id重复", req: CreateRequest{Requests: []RequestItem{{CustomID: "a", Params: params}, {CustomID: "a", Params: params}}}, fields: []string{"requests.1.custom_id"}},
+		{name: "缺少params", req: CreateRequest{Requests: []RequestItem{{CustomID: "a"}}}, fields: []string{"requests.0.params"}},
+		{name: "流式请求", req: CreateRequest{Requests: []RequestItem{{CustomID: "a", Params: map[string]any{"stream": true}}}}, fields: []string{"requests.0.params.stream"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fields []string
+			for _, err := range ValidateCreateRequest(tt.req) {
+				fields = append(fields, err.Field)
+			}
+			assert.Equal(t, tt.fields, fields)
+		})
+	}
+}
+
+func TestResultFromResponse(t *testing.T) {
+	succeeded := ResultFromResponse("a", http.StatusOK, []byte(`{"id":"msg_1","type":"message"}`))
+	assert.Equal(t, ResultSucceeded, succeeded.Result.Type)
+	assert.Equal(t, "msg_1", succeeded.Result.Message["id"])
+
+	// 已是 Anthropic 错误格式时原样保留
+	anthropicErr := ResultFromResponse("b", http.StatusBadRequest, []byte(`{"type":"error","error":{"type":"invalid_request_error","message":"bad"}}`))
+	assert.Equal(t, ResultErrored, anthropicErr.Result.Type)
+	assert.Equal(t, "invalid_request_error", anthropicErr.Result.Error["error"].(map[string]any)["type"])
+
+	// 代理自身的错误格式转换为 Anthropic 格式
+	proxyErr := ResultFromResponse("c", http.StatusTooManyRequests, []byte(`{"error":{"message":"上游限流","code":"rate_limited"}}`))
+	assert.Equal(t, map[string]any{
+		"type":  "error",
+		"error": map[string]any{"type": "rate_limit_error", "message": "上游限流"},
+	}, proxyErr.Result.Error)
+
+	nonJSON := ResultFromResponse("d", http.StatusInternalServerError, []byte("boom"))
+	assert.Equal(t, "boom", nonJSON.Result.Error["error"].(map[string]any)["message"])
+}
@@ -2,10 +2,13 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"sync"
 
+	appconfig "kiro2api/config"
 	"kiro2api/logger"
 )
 
@@ -30,8 +33,21 @@ var (
 	configMutex  sync.RWMutex
 )
 
-// LoadSystemConfig 加载系统配置
-func LoadSystemConfig() *SystemConfig {
+// ErrInvalidConfig 配置项取值无效
+var ErrInvalidConfig = errors.New("配置无效")
+
+// LoadSystemConfig 加载系统配置：持久化文件中的值应用到环境变量后，按环境变量加载并校验 appconfig.Settings
+// 任一配置项无效时返回汇总了全部错误的 error，服务不启动
+func LoadSystemConfig() (*SystemConfig, error) {
+	cfg := loadSystemConfig()
+	if err := appconfig.LoadSettings(); err != nil {
+		return cfg, fmt.Errorf("%w:\n%w", ErrInvalidConfig, err)
+	}
+	return cfg, nil
+}
+
+// loadSystemConfig 优先从持久化文件加载系统配置，文件不存在时从环境变量初始化
+func loadSystemConfig() *SystemConfig {
 	configMutex.Lock()
 	defer configMutex.Unlock()
 
@@ -87,6 +103,24 @@ func loadFromEnv() *SystemConfig {
 	}
 }
 
+// lookupEnv 按应用 cfg 之后的环境变量取值，用于保存前校验
+func (cfg *SystemConfig) lookupEnv(key string) (string, bool) {
+	values := map[string]string{
+		"GIN_MODE":                    cfg.GinMode,
+		"LOG_LEVEL":                   cfg.LogLevel,
+		"LOG_FORMAT":                  cfg.LogFormat,
+		"LOG_CONSOLE":                 cfg.LogConsole,
+		"STEALTH_MODE":                cfg.StealthMode,
+		"HEADER_STRATEGY":             cfg.HeaderStrategy,
+		"STEALTH_HTTP2_MODE":          cfg.HTTP2Mode,
+		"MAX_TOOL_DESCRIPTION_LENGTH": cfg.MaxToolLength,
+	}
+	if value := values[key]; value != "" {
+		return value, true
+	}
+	return os.LookupEnv(key)
+}
+
 // applyConfigToEnv 将配置应用到环境变量（端口不持久化，保持环境变量原值）
 func applyConfigToEnv(cfg *SystemConfig) {
 	if cfg.GinMode != "" {
@@ -121,11 +155,16 @@ func applyConfigToEnv(cfg *SystemConfig) {
 	}
 }
 
-// SaveSystemConfig 保存系统配置
+// SaveSystemConfig 保存系统配置，应用到环境变量后重新加载 appconfig.Settings
+// 配置项无效时返回错误，不写入文件
 func SaveSystemConfig(cfg *SystemConfig) error {
 	configMutex.Lock()
 	defer configMutex.Unlock()
 
+	if _, err := appconfig.ParseSettings(cfg.lookupEnv); err != nil {
+		return fmt.Errorf("%w:\n%w", ErrInvalidConfig, err)
+	}
+
 	// 确保目录存在
 	dir := filepath.Dir(SystemConfigFile)
 	if err := os.MkdirAll(dir, 0755); err != nil {
@@ -146,8 +185,13 @@ func SaveSystemConfig(cfg *SystemConfig) error {
 	// 更新内存中的配置
 	systemConfig = cfg
 	
-	// 应用到环境变量
+	// 应用到环境变量；文件已写入，重新加载失败（其他来源的环境变量无效）时保留原快照，不视为保存失败
 	applyConfigToEnv(cfg)
+	if err := appconfig.LoadSettings(); err != nil {
+		logger.Warn("系统配置已保存，但重新加载配置失败，保留当前配置", logger.Err(err))
+	} else if level, err := logger.ParseLevel(appconfig.Get().LogLevel); err == nil {
+		logger.SetLevel(level)
+	}
 
 	logger.Info("系统配置已保存",
 		logger.String("file", SystemConfigFile))
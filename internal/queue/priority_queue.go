@@ -0,0 +1,304 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"kiro2api/config"
+)
+
+// Lane 请求的优先级通道，按估算输入token数划分
+type Lane int
+
+const (
+	LaneHigh   Lane = iota // 短请求：估算输入token < config.PriorityHighMaxTokens
+	LaneMedium             // 估算输入token不超过 config.PriorityMediumMaxTokens
+	LaneLow                // 长请求，受 MAX_LOW_PRIORITY_RPS 限速
+	laneCount
+)
+
+var laneNames = [laneCount]string{"high", "medium", "low"}
+
+func (l Lane) String() string {
+	return laneNames[l]
+}
+
+// ClassifyLane 按 TokenEstimator.EstimateTokens 得到的输入token数选择通道
+func ClassifyLane(estimatedTokens int) Lane {
+	switch {
+	case estimatedTokens < config.PriorityHighMaxTokens:
+		return LaneHigh
+	case estimatedTokens <= config.PriorityMediumMaxTokens:
+		return LaneMedium
+	default:
+		return LaneLow
+	}
+}
+
+// ErrQueueTimeout 请求排队超过 REQUEST_QUEUE_TIMEOUT 仍未获得执行名额
+var ErrQueueTimeout = errors.New("请求排队超时")
+
+// LaneStats 单个通道的排队统计
+type LaneStats struct {
+	Lane             string `json:"lane"`
+	Depth            int    `json:"depth"`     // 当前排队的请求数
+	MaxDepth         int    `json:"max_depth"` // 启动以来的最大排队数
+	Active           int    `json:"active"`    // 正在执行的请求数
+	Admitted         int64  `json:"admitted"`  // 累计放行的请求数
+	TimedOut         int64  `json:"timed_out"`
+	Canceled         int64  `json:"canceled"`           // 排队期间客户端断开的请求数
+	ThroughputPerMin int    `json:"throughput_per_min"` // 最近 config.RequestQueueThroughputWindow 内放行的请求数
+}
+
+// QueueStats 优先级队列的配置与各通道统计
+type QueueStats struct {
+	MaxConcurrent  int         `json:"max_concurrent"`   // 0表示不限制并发
+	LowPriorityRPS float64     `json:"low_priority_rps"` // 0表示低优先级通道不限速
+	TimeoutMs      int64       `json:"timeout_ms"`
+	Active         int         `json:"active"`
+	Lanes          []LaneStats `json:"lanes"`
+}
+
+// waiter 排队中的请求，放行时关闭 ready
+type waiter struct {
+	lane     Lane
+	ready    chan struct{}
+	admitted bool
+}
+
+type laneCounters struct {
+	maxDepth int
+	active   int
+	admitted int64
+	timedOut int64
+	canceled int64
+	recent   []time.Time // 吞吐量窗口内的放行时间
+}
+
+// PriorityQueue 按优先级通道调度请求：并发名额空出时依次放行高、中、低通道的队首请求，
+// 短请求不会被排在前面的长请求阻塞；低优先级通道另按令牌桶限速
+type PriorityQueue struct {
+	mutex         sync.Mutex
+	maxConcurrent int
+	lowRPS        float64
+	timeout       time.Duration
+	active        int
+	waiting       [laneCount][]*waiter
+	lanes         [laneCount]laneCounters
+
+	lowTokens  float64
+	lowLast    time.Time
+	retryTimer *time.Timer // 低优先级通道等待令牌补充后重新调度
+
+	now func() time.Time
+}
+
+var (
+	globalQueue *PriorityQueue
+	queueOnce   sync.Once
+)
+
+// GetPriorityQueue 获取按启动配置创建的全局优先级队列
+func GetPriorityQueue() *PriorityQueue {
+	queueOnce.Do(func() {
+		globalQueue = NewPriorityQueue(config.MaxConcurrentRequests(), config.MaxLowPriorityRPS(), config.RequestQueueTimeout())
+	})
+	return globalQueue
+}
+
+// NewPriorityQueue 创建优先级队列；maxConcurrent <= 0 不限并发，lowRPS <= 0 不限速，timeout <= 0 一直等待到 ctx 结束
+func NewPriorityQueue(maxConcurrent int, lowRPS float64, timeout time.Duration) *PriorityQueue {
+	q := &PriorityQueue{
+		maxConcurrent: maxConcurrent,
+		lowRPS:        lowRPS,
+		timeout:       timeout,
+		now:           time.Now,
+	}
+	q.lowTokens = q.lowBurst()
+	q.lowLast = q.now()
+	return q
+}
+
+// Acquire 在通道中排队直到获得执行名额，返回的 release 必须在请求结束时调用（可重复调用）
+// ctx 结束时返回 ctx.Err()，排队超时返回 ErrQueueTimeout
+func (q *PriorityQueue) Acquire(ctx context.Context, lane Lane) (func(), error) {
+	w := &waiter{lane: lane, ready: make(chan struct{})}
+
+	q.mutex.Lock()
+	q.waiting[lane] = append(q.waiting[lane], w)
+	if depth := len(q.waiting[lane]); depth > q.lanes[lane].maxDepth {
+		q.lanes[lane].maxDepth = depth
+	}
+	q.dispatchUnlocked()
+	admitted := w.admitted
+	q.mutex.Unlock()
+
+	if admitted {
+		return q.releaseFunc(lane), nil
+	}
+
+	var timeout <-chan time.Time
+	if q.timeout > 0 {
+		timer := time.NewTimer(q.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var err error
+	select {
+	case <-w.ready:
+		return q.releaseFunc(lane), nil
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timeout:
+		err = ErrQueueTimeout
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	// 超时与放行同时发生时以放行为准
+	if w.admitted {
+		return q.releaseFunc(lane), nil
+	}
+	q.removeUnlocked(w)
+	if errors.Is(err, ErrQueueTimeout) {
+		q.lanes[lane].timedOut++
+	} else {
+		q.lanes[lane].canceled++
+	}
+	return nil, err
+}
+
+// Stats 返回当前配置与各通道统计
+func (q *PriorityQueue) Stats() QueueStats {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	now := q.now()
+	stats := QueueStats{
+		MaxConcurrent:  q.maxConcurrent,
+		LowPriorityRPS: q.lowRPS,
+		TimeoutMs:      q.timeout.Milliseconds(),
+		Active:         q.active,
+		Lanes:          make([]LaneStats, 0, laneCount),
+	}
+	for lane := LaneHigh; lane < laneCount; lane++ {
+		counters := &q.lanes[lane]
+		counters.recent = pruneRecent(counters.recent, now)
+		stats.Lanes = append(stats.Lanes, LaneStats{
+			Lane:             lane.String(),
+			Depth:            len(q.waiting[lane]),
+			MaxDepth:         counters.maxDepth,
+			Active:           counters.active,
+			Admitted:         counters.admitted,
+			TimedOut:         counters.timedOut,
+			Canceled:         counters.canceled,
+			ThroughputPerMin: len(counters.recent),
+		})
+	}
+	return stats
+}
+
+// dispatchUnlocked 在并发名额内按优先级放行排队的请求（调用方需持有锁）
+func (q *PriorityQueue) dispatchUnlocked() {
+	for q.maxConcurrent <= 0 || q.active < q.maxConcurrent {
+		w := q.nextUnlocked()
+		if w == nil {
+			return
+		}
+
+		now := q.now()
+		counters := &q.lanes[w.lane]
+		w.admitted = true
+		q.active++
+		counters.active++
+		counters.admitted++
+		counters.recent = append(pruneRecent(counters.recent, now), now)
+		close(w.ready)
+	}
+}
+
+// nextUnlocked 取出下一个可以放行的请求；低优先级通道令牌不足时安排补充后重新调度
+func (q *PriorityQueue) nextUnlocked() *waiter {
+	for lane := LaneHigh; lane < laneCount; lane++ {
+		if len(q.waiting[lane]) == 0 {
+			continue
+		}
+		if lane == LaneLow && !q.takeLowTokenUnlocked() {
+			q.scheduleRetryUnlocked()
+			return nil
+		}
+		w := q.waiting[lane][0]
+		q.waiting[lane] = q.waiting[lane][1:]
+		return w
+	}
+	return nil
+}
+
+// takeLowTokenUnlocked 消耗低优先级通道的一个令牌，未限速时总是成功
+func (q *PriorityQueue) takeLowTokenUnlocked() bool {
+	if q.lowRPS <= 0 {
+		return true
+	}
+	now := q.now()
+	q.lowTokens = math.Min(q.lowBurst(), q.lowTokens+now.Sub(q.lowLast).Seconds()*q.lowRPS)
+	q.lowLast = now
+	if q.lowTokens < 1 {
+		return false
+	}
+	q.lowTokens--
+	return true
+}
+
+// scheduleRetryUnlocked 在下一个令牌补充后重新调度
+func (q *PriorityQueue) scheduleRetryUnlocked() {
+	if q.retryTimer != nil {
+		return
+	}
+	wait := time.Duration((1 - q.lowTokens) / q.lowRPS * float64(time.Second))
+	q.retryTimer = time.AfterFunc(wait, func() {
+		q.mutex.Lock()
+		defer q.mutex.Unlock()
+		q.retryTimer = nil
+		q.dispatchUnlocked()
+	})
+}
+
+// lowBurst 低优先级通道令牌桶容量：每秒放行数，至少为1
+func (q *PriorityQueue) lowBurst() float64 {
+	return math.Max(1, q.lowRPS)
+}
+
+func (q *PriorityQueue) releaseFunc(lane Lane) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			q.mutex.Lock()
+			defer q.mutex.Unlock()
+			q.active--
+			q.lanes[lane].active--
+			q.dispatchUnlocked()
+		})
+	}
+}
+
+func (q *PriorityQueue) removeUnlocked(w *waiter) {
+	waiting := q.waiting[w.lane]
+	for i, candidate := range waiting {
+		if candidate == w {
+			q.waiting[w.lane] = append(waiting[:i], waiting[i+1:]...)
+			return
+		}
+	}
+}
+
+// pruneRecent 丢弃吞吐量窗口外的放行时间（按时间顺序追加）
+func pruneRecent(recent []time.Time, now time.Time) []time.Time {
+	cutoff := now.Add(-config.RequestQueueThroughputWindow)
+	i := sort.Search(len(recent), func(i int) bool { return recent[i].After(cutoff) })
+	return recent[i:]
+}
@@ -0,0 +1,130 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyLane(t *testing.T) {
+	assert.Equal(t, LaneHigh, ClassifyLane(0))
+	assert.Equal(t, LaneHigh, ClassifyLane(999))
+	assert.Equal(t, LaneMedium, ClassifyLane(1000))
+	assert.Equal(t, LaneMedium, ClassifyLane(10000))
+	assert.Equal(t, LaneLow, ClassifyLane(10001))
+}
+
+// waitForDepth 等待通道中的排队数达到 depth
+func waitForDepth(t *testing.T, q *PriorityQueue, lane Lane, depth int) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		return q.Stats().Lanes[lane].Depth == depth
+	}, time.Second, time.Millisecond)
+}
+
+func TestPriorityQueue_AdmitsHigherLanesFirst(t *testing.T) {
+	q := NewPriorityQueue(1, 0, 0)
+	release, err := q.Acquire(context.Background(), LaneLow)
+	require.NoError(t, err)
+
+	// 名额被占用时依次排入：两个低优先级、一个中优先级、一个高优先级
+	var (
+		mu    sync.Mutex
+		order []string
+		wg    sync.WaitGroup
+	)
+	enqueue := func(lane Lane, name string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			done, err := q.Acquire(context.Background(), lane)
+			if !assert.NoError(t, err) {
+				return
+			}
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			done()
+		}()
+	}
+	enqueue(LaneLow, "low-1")
+	waitForDepth(t, q, LaneLow, 1)
+	enqueue(LaneLow, "low-2")
+	waitForDepth(t, q, LaneLow, 2)
+	enqueue(LaneMedium, "medium")
+	waitForDepth(t, q, LaneMedium, 1)
+	enqueue(LaneHigh, "high")
+	waitForDepth(t, q, LaneHigh, 1)
+
+	release()
+	wg.Wait()
+
+	assert.Equal(t, []string{"high", "medium", "low-1", "low-2"}, order, "高优先级先放行，同一通道内按到达顺序")
+
+	stats := q.Stats()
+	assert.Zero(t, stats.Active)
+	assert.Equal(t, int64(3), stats.Lanes[LaneLow].Admitted)
+	assert.Equal(t, 2, stats.Lanes[LaneLow].MaxDepth)
+	assert.Equal(t, 3, stats.Lanes[LaneLow].ThroughputPerMin)
+	assert.Equal(t, int64(1), stats.Lanes[LaneHigh].Admitted)
+}
+
+func TestPriorityQueue_LowLaneRateLimited(t *testing.T) {
+	q := NewPriorityQueue(0, 1, 0)
+	now := time.Now()
+	q.now = func() time.Time { return now }
+	q.lowLast = now
+
+	release, err := q.Acquire(context.Background(), LaneLow)
+	require.NoError(t, err)
+	release()
+
+	// 令牌用尽后低优先级请求排队，其他通道不受影响
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = q.Acquire(ctx, LaneLow)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	release, err = q.Acquire(context.Background(), LaneHigh)
+	require.NoError(t, err)
+	release()
+
+	now = now.Add(time.Second)
+	release, err = q.Acquire(context.Background(), LaneLow)
+	require.NoError(t, err, "令牌补充后放行")
+	release()
+
+	stats := q.Stats()
+	assert.Equal(t, int64(2), stats.Lanes[LaneLow].Admitted)
+	assert.Equal(t, int64(1), stats.Lanes[LaneLow].Canceled)
+	assert.Zero(t, stats.Lanes[LaneLow].Depth)
+}
+
+func TestPriorityQueue_Timeout(t *testing.T) {
+	q := NewPriorityQueue(1, 0, 10*time.Millisecond)
+	release, err := q.Acquire(context.Background(), LaneHigh)
+	require.NoError(t, err)
+	defer release()
+
+	_, err = q.Acquire(context.Background(), LaneMedium)
+	assert.ErrorIs(t, err, ErrQueueTimeout)
+
+	stats := q.Stats()
+	assert.Equal(t, int64(1), stats.Lanes[LaneMedium].TimedOut)
+	assert.Zero(t, stats.Lanes[LaneMedium].Depth)
+	assert.Equal(t, 1, stats.Active)
+}
+
+func TestPriorityQueue_ReleaseIsIdempotent(t *testing.T) {
+	q := NewPriorityQueue(1, 0, 0)
+	release, err := q.Acquire(context.Background(), LaneHigh)
+	require.NoError(t, err)
+	release()
+	release()
+	assert.Zero(t, q.Stats().Active)
+	assert.Zero(t, q.Stats().Lanes[LaneHigh].Active)
+}
@@ -0,0 +1,424 @@
+package quota
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"kiro2api/config"
+	"kiro2api/logger"
+)
+
+// StateFileName 配额状态（管理接口更新的预算与各窗口用量）的持久化文件名，位于token配置所在目录
+const StateFileName = "quotas.json"
+
+// Storage 配额状态的持久化存储，*auth.ConfigStorage 实现了该接口
+type Storage interface {
+	LoadJSON(name string, v any) (bool, error)
+	SaveJSON(name string, v any) error
+}
+
+// Window 配额的时间窗口
+type Window string
+
+const (
+	// WindowDaily UTC 自然日
+	WindowDaily Window = "daily"
+	// WindowMonthly UTC 自然月
+	WindowMonthly Window = "monthly"
+)
+
+// start 返回 now 所在窗口的开始时间
+func (w Window) start(now time.Time) time.Time {
+	now = now.UTC()
+	if w == WindowMonthly {
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// end 返回从 start 开始的窗口的结束（重置）时间
+func (w Window) end(start time.Time) time.Time {
+	if w == WindowMonthly {
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// Usage 时间窗口内的用量
+type Usage struct {
+	InputTokens  int64 `json:"input_tokens"`
+	OutputTokens int64 `json:"output_tokens"`
+	Requests     int64 `json:"requests"`
+}
+
+// windowUsage 单个窗口的用量，Start 为窗口开始时间
+type windowUsage struct {
+	Start time.Time `json:"start"`
+	Usage
+}
+
+// roll 窗口已过期时从 now 所在的窗口重新计数
+func (u *windowUsage) roll(w Window, now time.Time) {
+	if start := w.start(now); !u.Start.Equal(start) {
+		*u = windowUsage{Start: start}
+	}
+}
+
+// keyUsage 单个客户端密钥在两个窗口中的用量
+type keyUsage struct {
+	Daily   windowUsage `json:"daily"`
+	Monthly windowUsage `json:"monthly"`
+}
+
+func (u *keyUsage) window(w Window) *windowUsage {
+	if w == WindowMonthly {
+		return &u.Monthly
+	}
+	return &u.Daily
+}
+
+// persistedState 持久化文件的内容
+type persistedState struct {
+	Budgets map[string]config.ClientQuota `json:"budgets,omitempty"`
+	Usage   map[string]*keyUsage          `json:"usage"`
+}
+
+// ExceededError 请求会超出客户端密钥的配额
+type ExceededError struct {
+	Key     string    // 客户端密钥名称
+	Window  Window    // 超出的时间窗口
+	Item    string    // 超出的项：input_tokens/output_tokens/requests
+	Limit   int64     // 预算
+	Used    int64     // 窗口内已用量（input_tokens 含本次请求的估算值）
+	ResetAt time.Time // 窗口重置时间
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("客户端密钥 %s 超出 %s 配额（%s：%d/%d）", e.Key, e.Window, e.Item, e.Used, e.Limit)
+}
+
+// Reservation 请求转发前预占的配额，请求结束后通过 Settle 按实际用量结算
+type Reservation struct {
+	key      string
+	estimate int64
+	daily    time.Time // 预占时所在窗口的开始时间，窗口已切换时结算不再修正旧窗口
+	monthly  time.Time
+}
+
+// Engine 按客户端密钥统计每日/每月用量并执行预算
+// 预算来自 CLIENT_QUOTAS，管理接口更新的预算优先；用量保存在内存中，定期写入持久化存储，重启后恢复
+type Engine struct {
+	mu        sync.Mutex
+	defaults  map[string]config.ClientQuota // CLIENT_QUOTAS
+	overrides map[string]config.ClientQuota // 管理接口更新的预算
+	usage     map[string]*keyUsage
+	storage   Storage // 为nil时不持久化
+	dirty     bool    // 上次持久化后状态有变化
+	now       func() time.Time
+}
+
+// NewEngine 创建配额引擎，storage 为nil时不持久化
+func NewEngine(budgets map[string]config.ClientQuota, storage Storage) *Engine {
+	if budgets == nil {
+		budgets = map[string]config.ClientQuota{}
+	}
+	return &Engine{
+		defaults:  budgets,
+		overrides: map[string]config.ClientQuota{},
+		usage:     map[string]*keyUsage{},
+		storage:   storage,
+		now:       time.Now,
+	}
+}
+
+var (
+	globalEngine *Engine
+	engineMu     sync.Mutex
+)
+
+// GetEngine 获取全局配额引擎；启动时未通过 SetEngine 设置时按 CLIENT_QUOTAS 创建不持久化的引擎
+func GetEngine() *Engine {
+	engineMu.Lock()
+	defer engineMu.Unlock()
+	if globalEngine == nil {
+		budgets, err := config.ClientQuotas()
+		if err != nil {
+			logger.Warn("客户端配额配置无效，不限额", logger.Err(err))
+		}
+		globalEngine = NewEngine(budgets, nil)
+	}
+	return globalEngine
+}
+
+// SetEngine 替换全局配额引擎（启动时加载持久化状态后设置）
+func SetEngine(e *Engine) {
+	engineMu.Lock()
+	defer engineMu.Unlock()
+	globalEngine = e
+}
+
+// Load 从持久化存储恢复预算与用量，文件不存在时保持空状态
+func (e *Engine) Load() error {
+	if e.storage == nil {
+		return nil
+	}
+	var state persistedState
+	found, err := e.storage.LoadJSON(StateFileName, &state)
+	if err != nil || !found {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for key, budget := range state.Budgets {
+		e.overrides[key] = budget
+	}
+	for key, usage := range state.Usage {
+		if usage != nil {
+			e.usage[key] = usage
+		}
+	}
+	return nil
+}
+
+// Flush 状态有变化时写入持久化存储
+func (e *Engine) Flush() error {
+	if e.storage == nil {
+		return nil
+	}
+
+	e.mu.Lock()
+	if !e.dirty {
+		e.mu.Unlock()
+		return nil
+	}
+	state := persistedState{
+		Budgets: make(map[string]config.ClientQuota, len(e.overrides)),
+		Usage:   make(map[string]*keyUsage, len(e.usage)),
+	}
+	for key, budget := range e.overrides {
+		state.Budgets[key] = budget
+	}
+	for key, usage := range e.usage {
+		copied := *usage
+		state.Usage[key] = &copied
+	}
+	e.dirty = false
+	e.mu.Unlock()
+
+	if err := e.storage.SaveJSON(StateFileName, state); err != nil {
+		e.mu.Lock()
+		e.dirty = true
+		e.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Run 每隔 interval 持久化一次用量，ctx 结束时返回；关闭服务时由调用方最后调用一次 Flush
+func (e *Engine) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := e.Flush(); err != nil {
+				logger.Warn("保存客户端配额用量失败", logger.Err(err))
+			}
+		}
+	}
+}
+
+// budgetUnlocked 返回密钥生效的预算，管理接口更新的预算优先
+func (e *Engine) budgetUnlocked(key string) config.ClientQuota {
+	if budget, ok := e.overrides[key]; ok {
+		return budget
+	}
+	return e.defaults[key]
+}
+
+// usageUnlocked 返回密钥的用量，两个窗口都滚动到 now 所在的窗口
+func (e *Engine) usageUnlocked(key string, now time.Time) *keyUsage {
+	usage, ok := e.usage[key]
+	if !ok {
+		usage = &keyUsage{}
+		e.usage[key] = usage
+	}
+	usage.Daily.roll(WindowDaily, now)
+	usage.Monthly.roll(WindowMonthly, now)
+	return usage
+}
+
+// Reserve 检查本次请求是否超出预算，未超出时计入一次请求并预占估算的输入token
+// 超出时返回 *ExceededError；多个窗口都超出时返回重置时间最晚的一个，客户端在该时间之后重试才会成功
+func (e *Engine) Reserve(key string, estimatedInput int) (*Reservation, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := e.now()
+	usage := e.usageUnlocked(key, now)
+	budget := e.budgetUnlocked(key)
+	estimate := int64(estimatedInput)
+
+	var exceeded *ExceededError
+	for _, w := range []Window{WindowDaily, WindowMonthly} {
+		limits := budget.Daily
+		if w == WindowMonthly {
+			limits = budget.Monthly
+		}
+		used := usage.window(w)
+		check := func(item string, limit, value int64, over bool) {
+			if limit > 0 && over && (exceeded == nil || w.end(used.Start).After(exceeded.ResetAt)) {
+				exceeded = &ExceededError{Key: key, Window: w, Item: item, Limit: limit, Used: value, ResetAt: w.end(used.Start)}
+			}
+		}
+		check("requests", limits.Requests, used.Requests, used.Requests >= limits.Requests)
+		check("input_tokens", limits.InputTokens, used.InputTokens+estimate, used.InputTokens+estimate > limits.InputTokens)
+		check("output_tokens", limits.OutputTokens, used.OutputTokens, used.OutputTokens >= limits.OutputTokens)
+	}
+	if exceeded != nil {
+		return nil, exceeded
+	}
+
+	for _, used := range []*windowUsage{&usage.Daily, &usage.Monthly} {
+		used.Requests++
+		used.InputTokens += estimate
+	}
+	e.dirty = true
+	return &Reservation{key: key, estimate: estimate, daily: usage.Daily.Start, monthly: usage.Monthly.Start}, nil
+}
+
+// Settle 请求结束后按实际用量结算：输入token按实际值修正预占的估算值，输出token计入用量
+// 请求失败时传入0，退还预占的输入token，请求数仍计入；预占后窗口已切换时不再修正旧窗口
+func (e *Engine) Settle(r *Reservation, inputTokens, outputTokens int) {
+	if r == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	usage := e.usageUnlocked(r.key, e.now())
+	for used, start := range map[*windowUsage]time.Time{&usage.Daily: r.daily, &usage.Monthly: r.monthly} {
+		if !used.Start.Equal(start) {
+			continue
+		}
+		used.InputTokens = max(0, used.InputTokens+int64(inputTokens)-r.estimate)
+		used.OutputTokens += int64(outputTokens)
+	}
+	e.dirty = true
+}
+
+// Budget 返回密钥生效的预算
+func (e *Engine) Budget(key string) config.ClientQuota {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.budgetUnlocked(key)
+}
+
+// SetBudget 运行时更新密钥的预算并立即持久化，已用量保持不变
+func (e *Engine) SetBudget(key string, budget config.ClientQuota) error {
+	if err := budget.Validate(); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	e.overrides[key] = budget
+	e.dirty = true
+	e.mu.Unlock()
+	return e.Flush()
+}
+
+// Remaining 窗口内各项的剩余预算，null 表示该项不限制
+type Remaining struct {
+	InputTokens  *int64 `json:"input_tokens"`
+	OutputTokens *int64 `json:"output_tokens"`
+	Requests     *int64 `json:"requests"`
+}
+
+// WindowStatus 单个时间窗口的用量与剩余预算
+type WindowStatus struct {
+	Start     time.Time          `json:"start"`
+	ResetAt   time.Time          `json:"reset_at"`
+	Used      Usage              `json:"used"`
+	Budget    config.QuotaBudget `json:"budget"`
+	Remaining Remaining          `json:"remaining"`
+}
+
+// KeyStatus 客户端密钥的配额状态
+type KeyStatus struct {
+	Key     string       `json:"key"`
+	Daily   WindowStatus `json:"daily"`
+	Monthly WindowStatus `json:"monthly"`
+}
+
+// remaining 预算减去已用量，不限制的项为nil，超出（估算偏低导致）时为0
+func remaining(limit, used int64) *int64 {
+	if limit <= 0 {
+		return nil
+	}
+	left := max(0, limit-used)
+	return &left
+}
+
+// Status 返回密钥在当前窗口的用量与剩余预算
+func (e *Engine) Status(key string) KeyStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.statusUnlocked(key, e.now())
+}
+
+func (e *Engine) statusUnlocked(key string, now time.Time) KeyStatus {
+	usage := e.usageUnlocked(key, now)
+	budget := e.budgetUnlocked(key)
+	window := func(w Window, used windowUsage, limits config.QuotaBudget) WindowStatus {
+		return WindowStatus{
+			Start:   used.Start,
+			ResetAt: w.end(used.Start),
+			Used:    used.Usage,
+			Budget:  limits,
+			Remaining: Remaining{
+				InputTokens:  remaining(limits.InputTokens, used.InputTokens),
+				OutputTokens: remaining(limits.OutputTokens, used.OutputTokens),
+				Requests:     remaining(limits.Requests, used.Requests),
+			},
+		}
+	}
+	return KeyStatus{
+		Key:     key,
+		Daily:   window(WindowDaily, usage.Daily, budget.Daily),
+		Monthly: window(WindowMonthly, usage.Monthly, budget.Monthly),
+	}
+}
+
+// Snapshot 返回所有配置了预算或有用量的密钥的状态，按名称排序
+func (e *Engine) Snapshot() []KeyStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	keys := make(map[string]bool, len(e.defaults)+len(e.overrides)+len(e.usage))
+	for key := range e.defaults {
+		keys[key] = true
+	}
+	for key := range e.overrides {
+		keys[key] = true
+	}
+	for key := range e.usage {
+		keys[key] = true
+	}
+	names := make([]string, 0, len(keys))
+	for key := range keys {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+
+	now := e.now()
+	result := make([]KeyStatus, 0, len(names))
+	for _, key := range names {
+		result = append(result, e.statusUnlocked(key, now))
+	}
+	return result
+}
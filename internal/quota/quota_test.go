@@ -0,0 +1,166 @@
+package quota
+
+import (
+	"testing"
+	"time"
+
+	"kiro2api/auth"
+	"kiro2api/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestEngine 创建时钟固定在 now 指向的时间的引擎
+func newTestEngine(budgets map[string]config.ClientQuota, storage Storage, now *time.Time) *Engine {
+	e := NewEngine(budgets, storage)
+	e.now = func() time.Time { return *now }
+	return e
+}
+
+func TestEngine_RejectsWhenBudgetExhausted(t *testing.T) {
+	now := time.Date(2026, 3, 10, 8, 0, 0, 0, time.UTC)
+	e := newTestEngine(map[string]config.ClientQuota{
+		"marketing": {Daily: config.QuotaBudget{Requests: 2, InputTokens: 1000}},
+	}, nil, &now)
+
+	_, err := e.Reserve("marketing", 600)
+	require.NoError(t, err)
+
+	// 输入预算按本次估算值判断
+	_, err = e.Reserve("marketing", 500)
+	var exceeded *ExceededError
+	require.ErrorAs(t, err, &exceeded)
+	assert.Equal(t, ExceededError{Key: "marketing", Window: WindowDaily, Item: "input_tokens", Limit: 1000, Used: 1100,
+		ResetAt: time.Date(2026, 3, 11, 0, 0, 0, 0, time.UTC)}, *exceeded)
+
+	_, err = e.Reserve("marketing", 100)
+	require.NoError(t, err)
+	_, err = e.Reserve("marketing", 0)
+	require.ErrorAs(t, err, &exceeded)
+	assert.Equal(t, "requests", exceeded.Item)
+
+	// 未配置预算的密钥只统计用量
+	for i := 0; i < 5; i++ {
+		_, err := e.Reserve("other", 1000)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int64(5), e.Status("other").Daily.Used.Requests)
+	assert.Nil(t, e.Status("other").Daily.Remaining.Requests)
+}
+
+func TestEngine_WindowRollover(t *testing.T) {
+	now := time.Date(2026, 1, 31, 23, 59, 0, 0, time.UTC)
+	e := newTestEngine(map[string]config.ClientQuota{
+		"team": {Daily: config.QuotaBudget{OutputTokens: 100}, Monthly: config.QuotaBudget{OutputTokens: 150}},
+	}, nil, &now)
+
+	r, err := e.Reserve("team", 10)
+	require.NoError(t, err)
+	e.Settle(r, 10, 100)
+	_, err = e.Reserve("team", 10)
+	var exceeded *ExceededError
+	require.ErrorAs(t, err, &exceeded)
+	assert.Equal(t, WindowDaily, exceeded.Window)
+
+	// 跨过午夜同时也是月初：两个窗口都重新计数
+	now = now.Add(2 * time.Minute)
+	r, err = e.Reserve("team", 10)
+	require.NoError(t, err)
+	status := e.Status("team")
+	assert.Equal(t, time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC), status.Daily.Start)
+	assert.Equal(t, time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), status.Monthly.ResetAt)
+	assert.Equal(t, Usage{InputTokens: 10, Requests: 1}, status.Monthly.Used)
+
+	// 次日每日窗口重置，每月窗口累计；每月预算耗尽时返回每月窗口的重置时间
+	e.Settle(r, 10, 90)
+	now = now.AddDate(0, 0, 1)
+	r, err = e.Reserve("team", 10)
+	require.NoError(t, err)
+	e.Settle(r, 10, 60)
+	now = now.AddDate(0, 0, 1)
+	_, err = e.Reserve("team", 10)
+	require.ErrorAs(t, err, &exceeded)
+	assert.Equal(t, WindowMonthly, exceeded.Window)
+	assert.Equal(t, time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), exceeded.ResetAt)
+}
+
+func TestEngine_EstimateThenReconcile(t *testing.T) {
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	e := newTestEngine(map[string]config.ClientQuota{
+		"team": {Daily: config.QuotaBudget{InputTokens: 1000, OutputTokens: 500}},
+	}, nil, &now)
+
+	r, err := e.Reserve("team", 800)
+	require.NoError(t, err)
+	assert.Equal(t, Usage{InputTokens: 800, Requests: 1}, e.Status("team").Daily.Used, "转发前预占估算值")
+
+	// 实际输入低于估算：退还差额，剩余预算可以继续使用
+	e.Settle(r, 300, 120)
+	status := e.Status("team")
+	assert.Equal(t, Usage{InputTokens: 300, OutputTokens: 120, Requests: 1}, status.Daily.Used)
+	assert.Equal(t, int64(700), *status.Daily.Remaining.InputTokens)
+	assert.Equal(t, int64(380), *status.Daily.Remaining.OutputTokens)
+
+	// 请求失败时按0结算：退还预占的输入token，请求数仍计入
+	r, err = e.Reserve("team", 600)
+	require.NoError(t, err)
+	e.Settle(r, 0, 0)
+	assert.Equal(t, Usage{InputTokens: 300, OutputTokens: 120, Requests: 2}, e.Status("team").Daily.Used)
+
+	// 实际输入高于估算时按实际值计入，剩余预算不为负
+	r, err = e.Reserve("team", 100)
+	require.NoError(t, err)
+	e.Settle(r, 900, 0)
+	status = e.Status("team")
+	assert.Equal(t, int64(1200), status.Daily.Used.InputTokens)
+	assert.Equal(t, int64(0), *status.Daily.Remaining.InputTokens)
+
+	// 预占后窗口已切换：不修正新窗口
+	r, err = e.Reserve("other", 50)
+	require.NoError(t, err)
+	now = now.AddDate(0, 0, 1)
+	e.Settle(r, 10, 10)
+	assert.Equal(t, Usage{}, e.Status("other").Daily.Used)
+	assert.Equal(t, Usage{InputTokens: 10, OutputTokens: 10, Requests: 1}, e.Status("other").Monthly.Used)
+}
+
+func TestEngine_PersistsAcrossRestart(t *testing.T) {
+	t.Setenv("CONFIG_DIR", t.TempDir())
+	storage := auth.NewConfigStorage()
+	now := time.Date(2026, 7, 15, 9, 0, 0, 0, time.UTC)
+	budgets := map[string]config.ClientQuota{"team": {Daily: config.QuotaBudget{Requests: 10}}}
+
+	e := newTestEngine(budgets, storage, &now)
+	for i := 0; i < 3; i++ {
+		r, err := e.Reserve("team", 100)
+		require.NoError(t, err)
+		e.Settle(r, 50, 20)
+	}
+	require.NoError(t, e.SetBudget("team", config.ClientQuota{Daily: config.QuotaBudget{Requests: 4}}))
+	r, err := e.Reserve("team", 100)
+	require.NoError(t, err)
+	e.Settle(r, 50, 20)
+	require.NoError(t, e.Flush())
+
+	// 模拟重启：新引擎从同一存储恢复，管理接口更新的预算优先于 CLIENT_QUOTAS
+	restarted := newTestEngine(budgets, storage, &now)
+	require.NoError(t, restarted.Load())
+	assert.Equal(t, Usage{InputTokens: 200, OutputTokens: 80, Requests: 4}, restarted.Status("team").Daily.Used)
+	assert.Equal(t, int64(4), restarted.Budget("team").Daily.Requests)
+	_, err = restarted.Reserve("team", 0)
+	assert.Error(t, err, "重启后计数不清零")
+
+	// 窗口在停机期间切换：恢复后按新窗口计数
+	now = now.AddDate(0, 0, 1)
+	again := newTestEngine(budgets, storage, &now)
+	require.NoError(t, again.Load())
+	assert.Equal(t, Usage{}, again.Status("team").Daily.Used)
+	assert.Equal(t, int64(4), again.Status("team").Monthly.Used.Requests)
+}
+
+func TestEngine_SetBudgetRejectsNegative(t *testing.T) {
+	e := NewEngine(nil, nil)
+	assert.Error(t, e.SetBudget("team", config.ClientQuota{Monthly: config.QuotaBudget{InputTokens: -1}}))
+	assert.Equal(t, config.ClientQuota{}, e.Budget("team"))
+}
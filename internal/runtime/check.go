@@ -0,0 +1,251 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"kiro2api/auth"
+	"kiro2api/config"
+	"kiro2api/internal/adapter/upstream/shared"
+	"kiro2api/types"
+)
+
+// CheckStatus 单项自检结果
+type CheckStatus string
+
+const (
+	CheckOK   CheckStatus = "ok"
+	CheckWarn CheckStatus = "warn"
+	CheckFail CheckStatus = "fail"
+	CheckSkip CheckStatus = "skip"
+)
+
+// CheckItem 单项自检的名称、结果和说明
+type CheckItem struct {
+	Name   string      `json:"name"`
+	Status CheckStatus `json:"status"`
+	Detail string      `json:"detail,omitempty"`
+}
+
+// CheckReport 启动自检报告，任一项失败时 OK 为 false
+type CheckReport struct {
+	OK    bool        `json:"ok"`
+	Items []CheckItem `json:"items"`
+}
+
+func (r *CheckReport) add(name string, status CheckStatus, detail string) {
+	r.Items = append(r.Items, CheckItem{Name: name, Status: status, Detail: detail})
+	if status == CheckFail {
+		r.OK = false
+	}
+}
+
+// Text 生成便于人工阅读的报告
+func (r *CheckReport) Text() string {
+	var b strings.Builder
+	for _, item := range r.Items {
+		fmt.Fprintf(&b, "[%-4s] %s", strings.ToUpper(string(item.Status)), item.Name)
+		if item.Detail != "" {
+			fmt.Fprintf(&b, ": %s", item.Detail)
+		}
+		b.WriteByte('\n')
+	}
+	if r.OK {
+		b.WriteString("自检通过\n")
+	} else {
+		b.WriteString("自检失败\n")
+	}
+	return b.String()
+}
+
+// CheckOptions 自检的输入和依赖，测试中可替换刷新与探测实现
+type CheckOptions struct {
+	ClientToken string
+	// ClientKeys 和 ClientKeysErr 为读取客户端密钥（KIRO_CLIENT_TOKENS）的结果，为空时只检查 ClientToken
+	ClientKeys    []config.ClientKey
+	ClientKeysErr error
+	// Configs 和 ConfigErr 为读取认证配置的结果
+	Configs   []auth.AuthConfig
+	ConfigErr error
+	ModelMap  map[string]string
+	// Refresh 刷新单个token，超过 RefreshTimeout 未返回时视为失败
+	Refresh        func(auth.AuthConfig) (types.TokenInfo, error)
+	RefreshTimeout time.Duration
+	// Probe 非nil时用第一个刷新成功的token向 ProbeModel 发送一次非流式请求
+	Probe      func(ctx context.Context, model string, token types.TokenInfo) error
+	ProbeModel string
+}
+
+// DefaultCheckOptions 按启动流程读取配置并使用真实的刷新与上游请求，probe 为 false 时不发送探测请求
+func DefaultCheckOptions(clientToken string, probe bool) CheckOptions {
+	configs, err := auth.ReadConfigs()
+	clientKeys, clientKeysErr := config.ClientKeys()
+	opts := CheckOptions{
+		ClientToken:    clientToken,
+		ClientKeys:     clientKeys,
+		ClientKeysErr:  clientKeysErr,
+		Configs:        configs,
+		ConfigErr:      err,
+		ModelMap:       config.ModelMap,
+		Refresh:        auth.RefreshToken,
+		RefreshTimeout: config.CheckRefreshTimeout(),
+		ProbeModel:     config.CheckProbeModel(),
+	}
+	if probe {
+		opts.Probe = ProbeUpstream
+	}
+	return opts
+}
+
+// RunCheck 依次检查客户端token强度、认证配置、token刷新、模型映射以及（可选的）上游探测，不启动HTTP服务
+func RunCheck(ctx context.Context, opts CheckOptions) *CheckReport {
+	report := &CheckReport{OK: true}
+
+	checkClientToken(report, opts)
+	checkModelMap(report, opts.ModelMap)
+
+	if opts.ConfigErr != nil {
+		report.add("auth_configs", CheckFail, opts.ConfigErr.Error())
+		return report
+	}
+	var enabled []int
+	for i, cfg := range opts.Configs {
+		if cfg.Disabled || cfg.IsDeleted() {
+			report.add(fmt.Sprintf("token[%d]", i), CheckSkip, "已禁用或已删除")
+			continue
+		}
+		enabled = append(enabled, i)
+	}
+	if len(enabled) == 0 {
+		report.add("auth_configs", CheckFail, "没有启用的认证配置")
+		return report
+	}
+	report.add("auth_configs", CheckOK, fmt.Sprintf("%d 个启用的认证配置", len(enabled)))
+
+	tokens := refreshAll(opts, enabled)
+	var valid []int
+	for _, i := range enabled {
+		if tokens[i].err != nil {
+			report.add(fmt.Sprintf("token[%d]", i), CheckWarn, tokens[i].err.Error())
+			continue
+		}
+		valid = append(valid, i)
+		report.add(fmt.Sprintf("token[%d]", i), CheckOK, "过期时间 "+tokens[i].token.ExpiresAt.Format(time.RFC3339))
+	}
+	if len(valid) == 0 {
+		report.add("token_pool", CheckFail, "所有启用的token均刷新失败")
+		return report
+	}
+	report.add("token_pool", CheckOK, fmt.Sprintf("%d/%d 个token可用", len(valid), len(enabled)))
+
+	if opts.Probe != nil {
+		if err := opts.Probe(ctx, opts.ProbeModel, tokens[valid[0]].token); err != nil {
+			report.add("upstream_probe", CheckFail, fmt.Sprintf("%s: %v", opts.ProbeModel, err))
+		} else {
+			report.add("upstream_probe", CheckOK, opts.ProbeModel)
+		}
+	}
+
+	return report
+}
+
+func checkClientToken(report *CheckReport, opts CheckOptions) {
+	if opts.ClientKeysErr != nil {
+		report.add("client_token", CheckFail, opts.ClientKeysErr.Error())
+		return
+	}
+
+	keys := opts.ClientKeys
+	if len(keys) == 0 && opts.ClientToken != "" {
+		keys = []config.ClientKey{{Key: opts.ClientToken, Name: config.DefaultClientKeyName}}
+	}
+	if len(keys) == 0 {
+		report.add("client_token", CheckFail, "未设置 KIRO_CLIENT_TOKENS 或 KIRO_CLIENT_TOKEN")
+		return
+	}
+
+	var weak []string
+	for _, key := range keys {
+		if len(key.Key) < config.MinClientTokenLength {
+			weak = append(weak, fmt.Sprintf("%s(%d)", key.Name, len(key.Key)))
+		}
+	}
+	if len(weak) > 0 {
+		report.add("client_token", CheckFail, fmt.Sprintf("客户端密钥长度不足 %d 字符: %s", config.MinClientTokenLength, strings.Join(weak, ", ")))
+		return
+	}
+	report.add("client_token", CheckOK, "")
+}
+
+func checkModelMap(report *CheckReport, modelMap map[string]string) {
+	var empty []string
+	hasClaude := false
+	for name, modelID := range modelMap {
+		if strings.TrimSpace(modelID) == "" {
+			empty = append(empty, name)
+		}
+		if strings.HasPrefix(name, "claude-") {
+			hasClaude = true
+		}
+	}
+	sort.Strings(empty)
+
+	switch {
+	case len(empty) > 0:
+		report.add("model_map", CheckFail, "映射值为空: "+strings.Join(empty, ", "))
+	case !hasClaude:
+		report.add("model_map", CheckFail, "缺少 claude-* 模型映射")
+	default:
+		report.add("model_map", CheckOK, fmt.Sprintf("%d 个模型映射", len(modelMap)))
+	}
+}
+
+type refreshResult struct {
+	token types.TokenInfo
+	err   error
+}
+
+// refreshAll 并发刷新启用的token，单个刷新超时后不再等待（刷新请求本身没有取消机制）
+func refreshAll(opts CheckOptions, indexes []int) map[int]refreshResult {
+	results := make(map[int]refreshResult, len(indexes))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, i := range indexes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := refreshWithTimeout(opts.Refresh, opts.Configs[i], opts.RefreshTimeout)
+			mu.Lock()
+			results[i] = result
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+func refreshWithTimeout(refresh func(auth.AuthConfig) (types.TokenInfo, error), cfg auth.AuthConfig, timeout time.Duration) refreshResult {
+	done := make(chan refreshResult, 1)
+	go func() {
+		token, err := refresh(cfg)
+		done <- refreshResult{token: token, err: err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case result := <-done:
+		return result
+	case <-timer.C:
+		return refreshResult{err: fmt.Errorf("刷新超时（%s）", timeout)}
+	}
+}
+
+// ProbeUpstream 直接向上游发送一次极小的非流式请求，确认token和模型可用
+func ProbeUpstream(ctx context.Context, model string, token types.TokenInfo) error {
+	return shared.NewModelCapabilityProbe(nil, config.CapabilityProbeFailureThreshold).Probe(ctx, model, token)
+}
@@ -0,0 +1,165 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"kiro2api/auth"
+	"kiro2api/config"
+	"kiro2api/types"
+	"kiro2api/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const strongClientToken = "0123456789abcdef0123456789abcdef"
+
+// fakeRefresh 按 refreshToken 返回预设结果："good" 成功、"slow" 超时、其余失败
+func fakeRefresh(cfg auth.AuthConfig) (types.TokenInfo, error) {
+	switch cfg.RefreshToken {
+	case "good":
+		return types.TokenInfo{AccessToken: "access-" + cfg.RefreshToken, ExpiresAt: time.Now().Add(time.Hour)}, nil
+	case "slow":
+		time.Sleep(time.Second)
+		return types.TokenInfo{}, nil
+	default:
+		return types.TokenInfo{}, errors.New("刷新失败: 状态码 401")
+	}
+}
+
+func checkOptions(configs ...auth.AuthConfig) CheckOptions {
+	return CheckOptions{
+		ClientToken:    strongClientToken,
+		Configs:        configs,
+		ModelMap:       map[string]string{"claude-sonnet-4": "claude-sonnet-4"},
+		Refresh:        fakeRefresh,
+		RefreshTimeout: 50 * time.Millisecond,
+		ProbeModel:     "claude-sonnet-4",
+	}
+}
+
+func findItem(t *testing.T, report *CheckReport, name string) CheckItem {
+	t.Helper()
+	for _, item := range report.Items {
+		if item.Name == name {
+			return item
+		}
+	}
+	t.Fatalf("报告中没有 %s", name)
+	return CheckItem{}
+}
+
+func TestRunCheck_MixedTokens(t *testing.T) {
+	deletedAt := time.Now()
+	report := RunCheck(context.Background(), checkOptions(
+		auth.AuthConfig{AuthType: auth.AuthMethodSocial, RefreshToken: "good"},
+		auth.AuthConfig{AuthType: auth.AuthMethodSocial, RefreshToken: "bad"},
+		auth.AuthConfig{AuthType: auth.AuthMethodSocial, RefreshToken: "slow"},
+		auth.AuthConfig{AuthType: auth.AuthMethodSocial, RefreshToken: "good", DeletedAt: &deletedAt},
+	))
+
+	assert.True(t, report.OK, report.Text())
+	assert.Equal(t, CheckOK, findItem(t, report, "token[0]").Status)
+	assert.Equal(t, CheckWarn, findItem(t, report, "token[1]").Status)
+	assert.Contains(t, findItem(t, report, "token[2]").Detail, "超时")
+	assert.Equal(t, CheckSkip, findItem(t, report, "token[3]").Status)
+	assert.Equal(t, "1/3 个token可用", findItem(t, report, "token_pool").Detail)
+}
+
+func TestRunCheck_AllTokensFail(t *testing.T) {
+	report := RunCheck(context.Background(), checkOptions(
+		auth.AuthConfig{AuthType: auth.AuthMethodSocial, RefreshToken: "bad"},
+	))
+
+	assert.False(t, report.OK)
+	assert.Equal(t, CheckFail, findItem(t, report, "token_pool").Status)
+	assert.Contains(t, report.Text(), "[FAIL] token_pool")
+	assert.True(t, strings.HasSuffix(report.Text(), "自检失败\n"))
+}
+
+func TestRunCheck_ConfigErrorAndEmptyConfigs(t *testing.T) {
+	opts := checkOptions()
+	opts.ConfigErr = errors.New("解析KIRO_AUTH_TOKEN失败")
+	report := RunCheck(context.Background(), opts)
+	assert.False(t, report.OK)
+	assert.Equal(t, "解析KIRO_AUTH_TOKEN失败", findItem(t, report, "auth_configs").Detail)
+
+	report = RunCheck(context.Background(), checkOptions())
+	assert.False(t, report.OK)
+	assert.Equal(t, CheckFail, findItem(t, report, "auth_configs").Status)
+}
+
+func TestRunCheck_ClientTokenAndModelMap(t *testing.T) {
+	opts := checkOptions(auth.AuthConfig{AuthType: auth.AuthMethodSocial, RefreshToken: "good"})
+	opts.ClientToken = "short"
+	opts.ModelMap = map[string]string{"auto": "auto", "claude-x": ""}
+	report := RunCheck(context.Background(), opts)
+
+	assert.False(t, report.OK)
+	assert.Equal(t, CheckFail, findItem(t, report, "client_token").Status)
+	assert.Equal(t, "映射值为空: claude-x", findItem(t, report, "model_map").Detail)
+
+	opts.ModelMap = map[string]string{"auto": "auto"}
+	report = RunCheck(context.Background(), opts)
+	assert.Equal(t, "缺少 claude-* 模型映射", findItem(t, report, "model_map").Detail)
+}
+
+func TestRunCheck_ProbeUsesRefreshedToken(t *testing.T) {
+	opts := checkOptions(
+		auth.AuthConfig{AuthType: auth.AuthMethodSocial, RefreshToken: "bad"},
+		auth.AuthConfig{AuthType: auth.AuthMethodSocial, RefreshToken: "good"},
+	)
+	var probedToken string
+	opts.Probe = func(ctx context.Context, model string, token types.TokenInfo) error {
+		probedToken = token.AccessToken
+		return errors.New("上游返回 403")
+	}
+	report := RunCheck(context.Background(), opts)
+
+	assert.False(t, report.OK)
+	assert.Equal(t, "access-good", probedToken)
+	assert.Equal(t, "claude-sonnet-4: 上游返回 403", findItem(t, report, "upstream_probe").Detail)
+}
+
+func TestCheckReport_JSONSummary(t *testing.T) {
+	report := RunCheck(context.Background(), checkOptions(
+		auth.AuthConfig{AuthType: auth.AuthMethodSocial, RefreshToken: "good"},
+	))
+	data, err := utils.SafeMarshal(report)
+	require.NoError(t, err)
+
+	var summary struct {
+		OK    bool `json:"ok"`
+		Items []struct {
+			Name   string `json:"name"`
+			Status string `json:"status"`
+		} `json:"items"`
+	}
+	require.NoError(t, utils.SafeUnmarshal(data, &summary))
+	assert.True(t, summary.OK)
+	assert.Equal(t, "client_token", summary.Items[0].Name)
+	assert.Equal(t, "ok", summary.Items[0].Status)
+}
+
+func TestRunCheck_ClientKeys(t *testing.T) {
+	opts := checkOptions(auth.AuthConfig{AuthType: auth.AuthMethodSocial, RefreshToken: "good"})
+	opts.ClientKeys = []config.ClientKey{
+		{Key: strongClientToken, Name: "team"},
+		{Key: "short", Name: "key-2"},
+	}
+	report := RunCheck(context.Background(), opts)
+	assert.Equal(t, CheckFail, findItem(t, report, "client_token").Status)
+	assert.Contains(t, findItem(t, report, "client_token").Detail, "key-2(5)")
+
+	opts.ClientKeys = opts.ClientKeys[:1]
+	report = RunCheck(context.Background(), opts)
+	assert.Equal(t, CheckOK, findItem(t, report, "client_token").Status)
+
+	opts.ClientKeysErr = assert.AnError
+	report = RunCheck(context.Background(), opts)
+	assert.Equal(t, CheckFail, findItem(t, report, "client_token").Status)
+}
@@ -6,8 +6,14 @@ import (
 
 	"kiro2api/auth"
 	"kiro2api/config"
+	"kiro2api/converter"
 	"kiro2api/internal/adapter/httpapi"
+	"kiro2api/internal/adapter/upstream/shared"
+	"kiro2api/internal/batch"
+	"kiro2api/internal/quota"
 	"kiro2api/logger"
+	"kiro2api/parser"
+	"kiro2api/utils"
 
 	"kiro2api/internal/version"
 )
@@ -22,23 +28,35 @@ type Runtime struct {
 	authService *auth.AuthService
 }
 
+// New 依次执行各初始化步骤并创建HTTP服务器，各步骤也可单独调用（如启动自检）
 func New(opts Options) (*Runtime, error) {
 	if opts.Port == "" {
 		opts.Port = "8080"
 	}
 
-	if config.IsStealthModeEnabled() {
-		logger.Info("Stealth 模式已启用，随机化网络指纹",
-			logger.String("header_strategy", config.ActiveHeaderStrategy()),
-			logger.String("http2_mode", config.HTTP2Mode()))
-	} else {
-		logger.Info("Stealth 模式未启用，使用兼容性网络指纹配置")
+	LogNetworkMode()
+	ApplyTuning()
+	RestoreToolState()
+	if err := LoadResponseFilters(); err != nil {
+		return nil, err
 	}
+	if err := LoadModelFallbacks(); err != nil {
+		return nil, err
+	}
+	if err := LoadStopReasonOverrides(); err != nil {
+		return nil, err
+	}
+	if err := LoadProfiles(); err != nil {
+		return nil, err
+	}
+	if err := LoadQuotas(); err != nil {
+		return nil, err
+	}
+	LoadBatches()
 
-	logger.Info("正在创建AuthService...")
-	authService, err := auth.NewAuthService()
+	authService, err := NewAuthService()
 	if err != nil {
-		return nil, fmt.Errorf("创建AuthService失败: %w", err)
+		return nil, err
 	}
 
 	server, err := httpapi.New(httpapi.Options{
@@ -57,6 +75,160 @@ func New(opts Options) (*Runtime, error) {
 	}, nil
 }
 
+// LogNetworkMode 记录隐身模式与模拟上游的启用状态
+func LogNetworkMode() {
+	if config.IsStealthModeEnabled() {
+		logger.Info("Stealth 模式已启用，随机化网络指纹",
+			logger.String("header_strategy", config.ActiveHeaderStrategy()),
+			logger.String("http2_mode", config.HTTP2Mode()))
+	} else {
+		logger.Info("Stealth 模式未启用，使用兼容性网络指纹配置")
+	}
+
+	if config.IsMockUpstreamEnabled() {
+		logger.Warn("模拟上游已启用（MOCK_UPSTREAM），请求不会发往CodeWhisperer，仅用于开发和测试",
+			logger.Float64("error_rate", config.MockErrorRate()),
+			logger.String("tool_use", config.MockToolUse()))
+	}
+}
+
+// ApplyTuning 加载token估算校准参数、按模型校准表和工具黑白名单，配置无效时记录警告并沿用默认行为
+func ApplyTuning() {
+	if calibration, err := config.LoadTokenCalibration(); err != nil {
+		logger.Warn("token估算校准参数无效，使用默认估算", logger.Err(err))
+	} else {
+		utils.SetTokenCalibration(calibration)
+	}
+
+	if table, err := config.LoadModelCalibrationTable(); err != nil {
+		logger.Warn("按模型token校准表无效，使用默认字符/token比率", logger.Err(err))
+	} else {
+		utils.SetModelCalibrationTable(table)
+	}
+
+	if filter, err := config.LoadToolFilter(); err != nil {
+		logger.Warn("工具黑白名单配置无效，不过滤工具", logger.Err(err))
+	} else {
+		converter.SetToolFilter(filter)
+	}
+}
+
+// LoadResponseFilters 编译 RESPONSE_FILTERS，正则无效时返回错误，服务不启动
+func LoadResponseFilters() error {
+	filters, err := config.LoadResponseFilters()
+	if err != nil {
+		return fmt.Errorf("响应过滤规则无效: %w", err)
+	}
+	shared.SetResponseFilters(filters)
+	if len(filters) > 0 {
+		logger.Info("响应过滤规则已加载", logger.Int("filters", len(filters)))
+	}
+	return nil
+}
+
+// LoadModelFallbacks 加载 MODEL_FALLBACKS，配置无效时返回错误，服务不启动
+func LoadModelFallbacks() error {
+	fallbacks, err := config.LoadModelFallbacks()
+	if err != nil {
+		return fmt.Errorf("模型回退配置无效: %w", err)
+	}
+	shared.SetModelFallbacks(fallbacks)
+	if len(fallbacks) > 0 {
+		logger.Info("模型回退配置已加载",
+			logger.Int("models", len(fallbacks)),
+			logger.Float64("error_rate_threshold", config.ModelFallbackErrorRate()))
+	}
+	return nil
+}
+
+// LoadStopReasonOverrides 加载 STOP_REASON_OVERRIDES，配置无效时返回错误，服务不启动
+func LoadStopReasonOverrides() error {
+	overrides, err := config.LoadStopReasonOverrides()
+	if err != nil {
+		return fmt.Errorf("stop_reason 替换配置无效: %w", err)
+	}
+	shared.SetStopReasonOverrides(overrides)
+	if len(overrides) > 0 {
+		logger.Info("stop_reason 替换规则已加载", logger.Any("overrides", overrides))
+	}
+	return nil
+}
+
+// LoadProfiles 加载 KIRO_PROFILES，配置无效时返回错误，服务不启动
+func LoadProfiles() error {
+	profiles, err := config.LoadProfiles()
+	if err != nil {
+		return fmt.Errorf("profile 配置无效: %w", err)
+	}
+	shared.SetProfileSelector(shared.NewProfileSelector(profiles))
+	if len(profiles) > 0 {
+		logger.Info("CodeWhisperer profile 配置已加载", logger.Int("profiles", len(profiles)))
+	}
+	return nil
+}
+
+// LoadQuotas 加载 CLIENT_QUOTAS 并从持久化文件恢复配额用量，配置无效时返回错误，服务不启动
+func LoadQuotas() error {
+	budgets, err := config.ClientQuotas()
+	if err != nil {
+		return fmt.Errorf("客户端配额配置无效: %w", err)
+	}
+	engine := quota.NewEngine(budgets, auth.NewConfigStorage())
+	if err := engine.Load(); err != nil {
+		logger.Warn("恢复客户端配额用量失败，从零开始计数", logger.Err(err))
+	}
+	quota.SetEngine(engine)
+	if len(budgets) > 0 {
+		logger.Info("客户端配额已加载", logger.Int("keys", len(budgets)))
+	}
+	return nil
+}
+
+// LoadBatches 从持久化文件恢复消息批处理，上次关闭时未完成的请求记为 expired
+func LoadBatches() {
+	store := batch.NewStore(config.BatchRetention, auth.NewConfigStorage())
+	if err := store.Load(); err != nil {
+		logger.Warn("恢复消息批处理失败，从空状态开始", logger.Err(err))
+	}
+	batch.SetStore(store)
+}
+
+// RestoreToolState 从 TOOL_STATE_FILE 恢复上次关闭时进行中的工具状态
+func RestoreToolState() {
+	if stateFile := config.ToolStateFile(); stateFile != "" {
+		if err := parser.DefaultToolStateRegistry().LoadFromFile(stateFile); err != nil {
+			logger.Warn("恢复工具状态失败", logger.String("path", stateFile), logger.Err(err))
+		}
+	}
+}
+
+// NewAuthService 加载认证配置并创建token池
+func NewAuthService() (*auth.AuthService, error) {
+	logger.Info("正在创建AuthService...")
+	authService, err := auth.NewAuthService()
+	if err != nil {
+		return nil, fmt.Errorf("创建AuthService失败: %w", err)
+	}
+	return authService, nil
+}
+
+// StartCapabilityProbe 设置了 CAPABILITY_PROBE_INTERVAL 时在后台探测 ModelMap 中的模型，ctx 结束时停止
+func StartCapabilityProbe(ctx context.Context, authService *auth.AuthService) {
+	interval := config.CapabilityProbeInterval()
+	if interval <= 0 {
+		return
+	}
+
+	probe := shared.GetModelCapabilityProbe()
+	if config.IsMockUpstreamEnabled() {
+		probe.SetTokenSource(shared.MockTokenSource{})
+	} else {
+		probe.SetTokenSource(authService)
+	}
+	logger.Info("模型能力探测已启用", logger.Duration("interval", interval))
+	go probe.Run(ctx, interval)
+}
+
 func (a *Runtime) Run(ctx context.Context) error {
 	logger.Info("启动"+version.GetVersionInfo(),
 		logger.String("port", a.server.Port()),
@@ -73,7 +245,24 @@ func (a *Runtime) Run(ctx context.Context) error {
 	logger.Info("  POST /v1/chat/completions       - OpenAI API代理")
 	logger.Info("按Ctrl+C停止服务器")
 
-	return a.server.Start(ctx)
+	StartCapabilityProbe(ctx, a.authService)
+	go quota.GetEngine().Run(ctx, config.QuotaPersistInterval())
+
+	err := a.server.Start(ctx)
+
+	// 服务关闭后保存配额用量，重启后不清零
+	if flushErr := quota.GetEngine().Flush(); flushErr != nil {
+		logger.Warn("保存客户端配额用量失败", logger.Err(flushErr))
+	}
+
+	// 服务关闭后保存进行中的工具状态，下次启动时恢复
+	if stateFile := config.ToolStateFile(); stateFile != "" {
+		if saveErr := parser.DefaultToolStateRegistry().SaveToFile(stateFile); saveErr != nil {
+			logger.Warn("保存工具状态失败", logger.String("path", stateFile), logger.Err(saveErr))
+		}
+	}
+
+	return err
 }
 
 func (a *Runtime) AuthService() *auth.AuthService {
@@ -0,0 +1,82 @@
+package stats
+
+import "sync"
+
+// HeaderStrategyMetrics 请求头策略A/B测试中单个策略的上游请求统计（含429重试的每次请求）
+type HeaderStrategyMetrics struct {
+	RequestsTotal int64         `json:"requests_total"`
+	ErrorsTotal   int64         `json:"errors_total"` // 连接失败或状态码 >= 400
+	ErrorRate     float64       `json:"error_rate"`
+	ByStatus      map[int]int64 `json:"by_status"` // 按上游状态码计数，连接失败不计入
+}
+
+// HeaderABStats 按请求头策略累计A/B测试的上游请求结果
+type HeaderABStats struct {
+	mutex      sync.Mutex
+	strategies map[string]*HeaderStrategyMetrics
+}
+
+var (
+	globalHeaderABStats *HeaderABStats
+	headerABStatsOnce   sync.Once
+)
+
+// GetHeaderABStats 获取全局请求头策略A/B测试统计
+func GetHeaderABStats() *HeaderABStats {
+	headerABStatsOnce.Do(func() {
+		globalHeaderABStats = NewHeaderABStats()
+	})
+	return globalHeaderABStats
+}
+
+// NewHeaderABStats 创建请求头策略A/B测试统计
+func NewHeaderABStats() *HeaderABStats {
+	return &HeaderABStats{strategies: make(map[string]*HeaderStrategyMetrics)}
+}
+
+// Record 记录一次上游请求，status 为0表示连接失败
+func (s *HeaderABStats) Record(strategy string, status int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	m, ok := s.strategies[strategy]
+	if !ok {
+		m = &HeaderStrategyMetrics{ByStatus: make(map[int]int64)}
+		s.strategies[strategy] = m
+	}
+	m.RequestsTotal++
+	if status == 0 || status >= 400 {
+		m.ErrorsTotal++
+	}
+	if status != 0 {
+		m.ByStatus[status]++
+	}
+}
+
+// Reset 清空统计，开始新的A/B测试时调用
+func (s *HeaderABStats) Reset() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.strategies = make(map[string]*HeaderStrategyMetrics)
+}
+
+// Snapshot 返回指定策略的统计快照，没有请求的策略返回零值
+func (s *HeaderABStats) Snapshot(strategy string) HeaderStrategyMetrics {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	result := HeaderStrategyMetrics{ByStatus: make(map[int]int64)}
+	m, ok := s.strategies[strategy]
+	if !ok {
+		return result
+	}
+	result.RequestsTotal = m.RequestsTotal
+	result.ErrorsTotal = m.ErrorsTotal
+	if m.RequestsTotal > 0 {
+		result.ErrorRate = float64(m.ErrorsTotal) / float64(m.RequestsTotal)
+	}
+	for status, count := range m.ByStatus {
+		result.ByStatus[status] = count
+	}
+	return result
+}
@@ -0,0 +1,191 @@
+package stats
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"kiro2api/config"
+)
+
+// LatencyStats 单个端点的延迟统计
+type LatencyStats struct {
+	Endpoint string  `json:"endpoint"`
+	Count    int64   `json:"count"`
+	AvgMs    float64 `json:"avg_ms"`
+	MaxMs    float64 `json:"max_ms"`
+	P50Ms    float64 `json:"p50_ms"`
+	P95Ms    float64 `json:"p95_ms"`
+	P99Ms    float64 `json:"p99_ms"`
+}
+
+// latencyHistogram 单个端点的延迟直方图
+// counts 比 buckets 多一个溢出桶，记录超过最大上界的样本
+type latencyHistogram struct {
+	counts []int64
+	total  int64
+	sum    time.Duration
+	max    time.Duration
+}
+
+// LatencyTracker 按端点记录上游响应延迟
+// 直方图在 resetInterval 到期后的下一次读写时整体重置
+type LatencyTracker struct {
+	mutex         sync.Mutex
+	buckets       []time.Duration
+	resetInterval time.Duration
+	windowStart   time.Time
+	endpoints     map[string]*latencyHistogram
+	now           func() time.Time
+}
+
+var (
+	globalLatencyTracker *LatencyTracker
+	latencyOnce          sync.Once
+)
+
+// GetLatencyTracker 获取全局延迟统计器
+func GetLatencyTracker() *LatencyTracker {
+	latencyOnce.Do(func() {
+		globalLatencyTracker = NewLatencyTracker(config.LatencyBuckets(), config.LatencyResetInterval())
+	})
+	return globalLatencyTracker
+}
+
+// NewLatencyTracker 创建延迟统计器，buckets 为升序的桶上界，resetInterval <= 0 表示不重置
+func NewLatencyTracker(buckets []time.Duration, resetInterval time.Duration) *LatencyTracker {
+	sorted := append([]time.Duration(nil), buckets...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	t := &LatencyTracker{
+		buckets:       sorted,
+		resetInterval: resetInterval,
+		endpoints:     make(map[string]*latencyHistogram),
+		now:           time.Now,
+	}
+	t.windowStart = t.now()
+	return t
+}
+
+// Buckets 返回桶上界
+func (t *LatencyTracker) Buckets() []time.Duration {
+	return append([]time.Duration(nil), t.buckets...)
+}
+
+// Record 记录一次端点延迟
+func (t *LatencyTracker) Record(endpoint string, latency time.Duration) {
+	if latency < 0 {
+		latency = 0
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.resetIfExpired()
+
+	h, exists := t.endpoints[endpoint]
+	if !exists {
+		h = &latencyHistogram{counts: make([]int64, len(t.buckets)+1)}
+		t.endpoints[endpoint] = h
+	}
+
+	h.counts[t.bucketIndex(latency)]++
+	h.total++
+	h.sum += latency
+	if latency > h.max {
+		h.max = latency
+	}
+}
+
+// Snapshot 返回当前窗口内各端点的延迟统计（按端点名排序）及窗口起始时间
+func (t *LatencyTracker) Snapshot() ([]LatencyStats, time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.resetIfExpired()
+
+	result := make([]LatencyStats, 0, len(t.endpoints))
+	for endpoint, h := range t.endpoints {
+		result = append(result, LatencyStats{
+			Endpoint: endpoint,
+			Count:    h.total,
+			AvgMs:    durationMs(h.sum / time.Duration(h.total)),
+			MaxMs:    durationMs(h.max),
+			P50Ms:    durationMs(t.percentile(h, 0.50)),
+			P95Ms:    durationMs(t.percentile(h, 0.95)),
+			P99Ms:    durationMs(t.percentile(h, 0.99)),
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Endpoint < result[j].Endpoint })
+	return result, t.windowStart
+}
+
+// Reset 清空所有端点的直方图
+func (t *LatencyTracker) Reset() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.resetUnlocked()
+}
+
+// bucketIndex 返回延迟所属的桶（上界包含），超过最大上界时返回溢出桶
+func (t *LatencyTracker) bucketIndex(latency time.Duration) int {
+	return sort.Search(len(t.buckets), func(i int) bool { return latency <= t.buckets[i] })
+}
+
+// percentile 基于直方图估算分位数：在目标桶内按样本位置线性插值
+// 溢出桶的上界取观测到的最大值
+func (t *LatencyTracker) percentile(h *latencyHistogram, q float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+
+	rank := q * float64(h.total)
+	var cumulative int64
+	for i, count := range h.counts {
+		if count == 0 {
+			continue
+		}
+		if float64(cumulative+count) < rank {
+			cumulative += count
+			continue
+		}
+
+		var lower time.Duration
+		if i > 0 {
+			lower = t.buckets[i-1]
+		}
+		upper := h.max
+		if i < len(t.buckets) && t.buckets[i] < upper {
+			upper = t.buckets[i]
+		}
+		if upper < lower {
+			upper = lower
+		}
+
+		fraction := (rank - float64(cumulative)) / float64(count)
+		return lower + time.Duration(fraction*float64(upper-lower))
+	}
+
+	return h.max
+}
+
+// resetIfExpired 重置周期到期时清空直方图（调用方需持有锁）
+func (t *LatencyTracker) resetIfExpired() {
+	if t.resetInterval <= 0 {
+		return
+	}
+	if t.now().Sub(t.windowStart) >= t.resetInterval {
+		t.resetUnlocked()
+	}
+}
+
+func (t *LatencyTracker) resetUnlocked() {
+	t.endpoints = make(map[string]*latencyHistogram)
+	t.windowStart = t.now()
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
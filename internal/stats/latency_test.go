@@ -0,0 +1,153 @@
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testBuckets = []time.Duration{
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	1 * time.Second,
+	5 * time.Second,
+	30 * time.Second,
+}
+
+func TestLatencyTracker_BucketAssignment(t *testing.T) {
+	tracker := NewLatencyTracker(testBuckets, 0)
+
+	tests := []struct {
+		latency time.Duration
+		bucket  int
+	}{
+		{0, 0},
+		{50 * time.Millisecond, 0},
+		{100 * time.Millisecond, 0}, // 上界包含
+		{101 * time.Millisecond, 1},
+		{500 * time.Millisecond, 1},
+		{999 * time.Millisecond, 2},
+		{3 * time.Second, 3},
+		{30 * time.Second, 4},
+		{31 * time.Second, 5}, // 溢出桶
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.bucket, tracker.bucketIndex(tt.latency), "latency=%v", tt.latency)
+	}
+}
+
+func TestLatencyTracker_RecordCounts(t *testing.T) {
+	tracker := NewLatencyTracker(testBuckets, 0)
+
+	tracker.Record("/v1/messages", 50*time.Millisecond)
+	tracker.Record("/v1/messages", 200*time.Millisecond)
+	tracker.Record("/v1/messages", time.Minute)
+	tracker.Record("/v1/chat/completions", 2*time.Second)
+
+	h := tracker.endpoints["/v1/messages"]
+	require.NotNil(t, h)
+	assert.Equal(t, []int64{1, 1, 0, 0, 0, 1}, h.counts)
+	assert.Equal(t, int64(3), h.total)
+	assert.Equal(t, time.Minute, h.max)
+
+	result, _ := tracker.Snapshot()
+	require.Len(t, result, 2)
+	assert.Equal(t, "/v1/chat/completions", result[0].Endpoint)
+	assert.Equal(t, "/v1/messages", result[1].Endpoint)
+	assert.Equal(t, int64(3), result[1].Count)
+}
+
+func TestLatencyTracker_PercentileAccuracy(t *testing.T) {
+	tracker := NewLatencyTracker([]time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		300 * time.Millisecond,
+		400 * time.Millisecond,
+		500 * time.Millisecond,
+		600 * time.Millisecond,
+		700 * time.Millisecond,
+		800 * time.Millisecond,
+		900 * time.Millisecond,
+		1000 * time.Millisecond,
+	}, 0)
+
+	// 1ms..1000ms 均匀分布，真实 p50=500ms p95=950ms p99=990ms
+	for i := 1; i <= 1000; i++ {
+		tracker.Record("/v1/messages", time.Duration(i)*time.Millisecond)
+	}
+
+	result, _ := tracker.Snapshot()
+	require.Len(t, result, 1)
+
+	stats := result[0]
+	assert.InDelta(t, 500, stats.P50Ms, 5)
+	assert.InDelta(t, 950, stats.P95Ms, 5)
+	assert.InDelta(t, 990, stats.P99Ms, 5)
+	assert.InDelta(t, 500.5, stats.AvgMs, 0.01)
+	assert.Equal(t, float64(1000), stats.MaxMs)
+}
+
+func TestLatencyTracker_PercentileOverflowBucket(t *testing.T) {
+	tracker := NewLatencyTracker(testBuckets, 0)
+
+	for i := 0; i < 90; i++ {
+		tracker.Record("/v1/messages", 50*time.Millisecond)
+	}
+	for i := 0; i < 10; i++ {
+		tracker.Record("/v1/messages", 40*time.Second)
+	}
+
+	result, _ := tracker.Snapshot()
+	require.Len(t, result, 1)
+
+	// p50 落在首个桶内，不超过其上界
+	assert.LessOrEqual(t, result[0].P50Ms, float64(100))
+	// p99 落在溢出桶，介于最大上界和观测最大值之间
+	assert.GreaterOrEqual(t, result[0].P99Ms, float64(30000))
+	assert.LessOrEqual(t, result[0].P99Ms, float64(40000))
+}
+
+func TestLatencyTracker_PercentileCappedByMax(t *testing.T) {
+	tracker := NewLatencyTracker(testBuckets, 0)
+	tracker.Record("/v1/messages", 2*time.Second)
+
+	result, _ := tracker.Snapshot()
+	require.Len(t, result, 1)
+
+	// 单个样本时分位数不应超过观测值
+	assert.LessOrEqual(t, result[0].P99Ms, float64(2000))
+	assert.GreaterOrEqual(t, result[0].P99Ms, float64(1000))
+}
+
+func TestLatencyTracker_ResetInterval(t *testing.T) {
+	now := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
+	tracker := NewLatencyTracker(testBuckets, time.Minute)
+	tracker.now = func() time.Time { return now }
+	tracker.windowStart = now
+
+	tracker.Record("/v1/messages", 50*time.Millisecond)
+	result, _ := tracker.Snapshot()
+	require.Len(t, result, 1)
+
+	now = now.Add(59 * time.Second)
+	result, _ = tracker.Snapshot()
+	assert.Len(t, result, 1)
+
+	now = now.Add(time.Second)
+	result, windowStart := tracker.Snapshot()
+	assert.Empty(t, result)
+	assert.Equal(t, now, windowStart)
+}
+
+func TestLatencyTracker_Reset(t *testing.T) {
+	tracker := NewLatencyTracker(testBuckets, 0)
+	tracker.Record("/v1/messages", time.Second)
+
+	tracker.Reset()
+
+	result, _ := tracker.Snapshot()
+	assert.Empty(t, result)
+}
@@ -0,0 +1,115 @@
+package stats
+
+import (
+	"kiro2api/config"
+)
+
+// ModelMetrics 自进程启动以来的累计请求与token统计（按模型或租户汇总）
+type ModelMetrics struct {
+	RequestsTotal     int64   `json:"requests_total"`
+	ErrorsTotal       int64   `json:"errors_total"`
+	InputTokensTotal  int64   `json:"input_tokens_total"`
+	OutputTokensTotal int64   `json:"output_tokens_total"`
+	ErrorRate         float64 `json:"error_rate"`
+}
+
+// TenantMetrics 单个租户的累计统计及按模型的明细
+type TenantMetrics struct {
+	ModelMetrics
+	Models map[string]ModelMetrics `json:"models"`
+}
+
+// RecordError 记录一次失败的请求（计入请求总数和错误率，不计token）
+func (c *TokenStatsCollector) RecordError(model, tenant string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, m := range c.metricsUnlocked(model, tenant) {
+		m.RequestsTotal++
+		m.ErrorsTotal++
+	}
+}
+
+// ModelStats 返回按模型汇总的统计快照
+func (c *TokenStatsCollector) ModelStats() map[string]ModelMetrics {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	result := make(map[string]ModelMetrics, len(c.modelStats))
+	for model, m := range c.modelStats {
+		result[model] = m.snapshot()
+	}
+	return result
+}
+
+// TenantStats 返回按租户汇总的统计快照，每个租户附带按模型的明细
+func (c *TokenStatsCollector) TenantStats() map[string]TenantMetrics {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	result := make(map[string]TenantMetrics, len(c.tenantStats))
+	for tenant, models := range c.tenantStats {
+		tm := TenantMetrics{Models: make(map[string]ModelMetrics, len(models))}
+		for model, m := range models {
+			tm.Models[model] = m.snapshot()
+			tm.RequestsTotal += m.RequestsTotal
+			tm.ErrorsTotal += m.ErrorsTotal
+			tm.InputTokensTotal += m.InputTokensTotal
+			tm.OutputTokensTotal += m.OutputTokensTotal
+		}
+		tm.ModelMetrics = tm.snapshot()
+		result[tenant] = tm
+	}
+	return result
+}
+
+// recordUsageUnlocked 记录一次成功请求的token用量（调用方需持有锁）
+func (c *TokenStatsCollector) recordUsageUnlocked(inputTokens, outputTokens int, model, tenant string) {
+	for _, m := range c.metricsUnlocked(model, tenant) {
+		m.RequestsTotal++
+		m.InputTokensTotal += int64(inputTokens)
+		m.OutputTokensTotal += int64(outputTokens)
+	}
+}
+
+// metricsUnlocked 返回该请求需要累加的模型汇总与租户明细（调用方需持有锁）
+// 模型或租户数超过上限时，新出现的标签合并计入 config.StatsOverflowLabel
+func (c *TokenStatsCollector) metricsUnlocked(model, tenant string) []*ModelMetrics {
+	model = capLabel(c.modelStats, model, config.StatsMaxModels)
+	tenant = capLabel(c.tenantStats, tenant, config.StatsMaxTenants)
+
+	byModel, ok := c.modelStats[model]
+	if !ok {
+		byModel = &ModelMetrics{}
+		c.modelStats[model] = byModel
+	}
+
+	models, ok := c.tenantStats[tenant]
+	if !ok {
+		models = make(map[string]*ModelMetrics)
+		c.tenantStats[tenant] = models
+	}
+	byTenant, ok := models[model]
+	if !ok {
+		byTenant = &ModelMetrics{}
+		models[model] = byTenant
+	}
+
+	return []*ModelMetrics{byModel, byTenant}
+}
+
+// capLabel 标签已存在或未达上限时原样返回，否则返回 config.StatsOverflowLabel
+func capLabel[V any](existing map[string]V, label string, limit int) string {
+	if _, ok := existing[label]; ok || len(existing) < limit {
+		return label
+	}
+	return config.StatsOverflowLabel
+}
+
+func (m *ModelMetrics) snapshot() ModelMetrics {
+	s := *m
+	if s.RequestsTotal > 0 {
+		s.ErrorRate = float64(s.ErrorsTotal) / float64(s.RequestsTotal)
+	}
+	return s
+}
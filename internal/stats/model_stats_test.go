@@ -0,0 +1,81 @@
+package stats
+
+import (
+	"fmt"
+	"testing"
+
+	"kiro2api/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestModelStats_RoutesByModelAndTenant(t *testing.T) {
+	c := NewTokenStatsCollector()
+
+	c.Record(100, 20, "claude-sonnet-4", "team-a")
+	c.Record(50, 10, "claude-sonnet-4", "team-b")
+	c.Record(300, 40, "claude-opus-4", "team-a")
+	c.RecordError("claude-sonnet-4", "team-a")
+
+	models := c.ModelStats()
+	require.Len(t, models, 2)
+	assert.Equal(t, ModelMetrics{
+		RequestsTotal: 3, ErrorsTotal: 1, InputTokensTotal: 150, OutputTokensTotal: 30, ErrorRate: 1.0 / 3,
+	}, models["claude-sonnet-4"])
+	assert.Equal(t, ModelMetrics{
+		RequestsTotal: 1, InputTokensTotal: 300, OutputTokensTotal: 40,
+	}, models["claude-opus-4"])
+
+	tenants := c.TenantStats()
+	require.Len(t, tenants, 2)
+
+	teamA := tenants["team-a"]
+	assert.Equal(t, int64(3), teamA.RequestsTotal)
+	assert.Equal(t, int64(400), teamA.InputTokensTotal)
+	assert.Equal(t, int64(60), teamA.OutputTokensTotal)
+	assert.InDelta(t, 1.0/3, teamA.ErrorRate, 1e-9)
+	require.Len(t, teamA.Models, 2)
+	assert.Equal(t, 0.5, teamA.Models["claude-sonnet-4"].ErrorRate)
+	assert.Equal(t, int64(300), teamA.Models["claude-opus-4"].InputTokensTotal)
+
+	teamB := tenants["team-b"]
+	assert.Equal(t, int64(1), teamB.RequestsTotal)
+	assert.Equal(t, 0.0, teamB.ErrorRate)
+	assert.Equal(t, []string{"claude-sonnet-4"}, keys(teamB.Models))
+}
+
+func TestModelStats_RecordKeepsHourlyTotals(t *testing.T) {
+	c := NewTokenStatsCollector()
+	c.Record(100, 20, "claude-sonnet-4", "team-a")
+	c.RecordError("claude-sonnet-4", "team-a")
+
+	input, output, requests := c.GetTodayTotal()
+	assert.Equal(t, int64(100), input)
+	assert.Equal(t, int64(20), output)
+	assert.Equal(t, 1, requests, "失败请求只计入模型与租户统计")
+}
+
+func TestModelStats_TenantOverflow(t *testing.T) {
+	c := NewTokenStatsCollector()
+	for i := 0; i < config.StatsMaxTenants; i++ {
+		c.Record(1, 1, "claude-sonnet-4", fmt.Sprintf("tenant-%d", i))
+	}
+
+	c.Record(1, 1, "claude-sonnet-4", "tenant-new")
+	c.Record(1, 1, "claude-sonnet-4", "tenant-0")
+
+	tenants := c.TenantStats()
+	assert.Len(t, tenants, config.StatsMaxTenants+1)
+	assert.NotContains(t, tenants, "tenant-new")
+	assert.Equal(t, int64(1), tenants[config.StatsOverflowLabel].RequestsTotal)
+	assert.Equal(t, int64(2), tenants["tenant-0"].RequestsTotal, "已有租户不受上限影响")
+}
+
+func keys[V any](m map[string]V) []string {
+	result := make([]string, 0, len(m))
+	for k := range m {
+		result = append(result, k)
+	}
+	return result
+}
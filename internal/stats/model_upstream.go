@@ -0,0 +1,220 @@
+package stats
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"kiro2api/config"
+)
+
+// ModelUpstreamMetrics 单个模型的上游调用统计
+// 延迟分位数与 /admin/stats/latency 使用相同的桶和重置周期，其余计数自进程启动以来累计
+type ModelUpstreamMetrics struct {
+	Model          string           `json:"model"`
+	RequestsTotal  int64            `json:"requests_total"`
+	ErrorsTotal    int64            `json:"errors_total"`
+	ErrorsByStatus map[string]int64 `json:"errors_by_status"` // 键为上游状态码，网络错误为 "network"
+	P50Ms          float64          `json:"p50_ms"`
+	P95Ms          float64          `json:"p95_ms"`
+	StopReasons    map[string]int64 `json:"stop_reasons"`
+	FallbacksTotal int64            `json:"fallbacks_total"` // 请求该模型但改由回退模型处理的次数
+	// PseudoStreamsTotal 上游对流式请求返回JSON响应、降级为本地合成SSE的次数
+	PseudoStreamsTotal int64 `json:"pseudo_streams_total"`
+	// RecentErrorRate 最近 config.ModelErrorRateWindow 内与模型相关的上游错误率，模型回退依据该值判断
+	RecentErrorRate float64 `json:"recent_error_rate"`
+	RecentSamples   int     `json:"recent_samples"`
+}
+
+// upstreamOutcome 一次上游调用的结果，用于计算滚动错误率
+type upstreamOutcome struct {
+	at     time.Time
+	failed bool
+}
+
+type modelUpstream struct {
+	requests       int64
+	errors         int64
+	errorsByStatus map[string]int64
+	stopReasons    map[string]int64
+	fallbacks      int64
+	pseudoStreams  int64
+	recent         []upstreamOutcome
+}
+
+// ModelUpstreamStats 按模型记录上游调用次数、错误、延迟和 stop_reason 分布
+type ModelUpstreamStats struct {
+	mutex   sync.Mutex
+	models  map[string]*modelUpstream
+	latency *LatencyTracker
+	now     func() time.Time
+}
+
+var (
+	globalModelUpstreamStats *ModelUpstreamStats
+	modelUpstreamOnce        sync.Once
+)
+
+// GetModelUpstreamStats 获取全局按模型的上游调用统计
+func GetModelUpstreamStats() *ModelUpstreamStats {
+	modelUpstreamOnce.Do(func() {
+		globalModelUpstreamStats = NewModelUpstreamStats()
+	})
+	return globalModelUpstreamStats
+}
+
+// NewModelUpstreamStats 创建按模型的上游调用统计
+func NewModelUpstreamStats() *ModelUpstreamStats {
+	return &ModelUpstreamStats{
+		models:  make(map[string]*modelUpstream),
+		latency: NewLatencyTracker(config.LatencyBuckets(), config.LatencyResetInterval()),
+		now:     time.Now,
+	}
+}
+
+// RecordResponse 记录一次上游调用的结果，status 为0表示网络错误，非200均计入错误数和状态码分布
+// 滚动错误率只计网络错误、5xx 和上游明确指出的模型错误（modelError），
+// token 失效、限流和请求本身的错误与模型无关，不应触发模型回退
+func (s *ModelUpstreamStats) RecordResponse(model string, status int, modelError bool, latency time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	model, m := s.modelUnlocked(model)
+	m.requests++
+	if status != http.StatusOK {
+		m.errors++
+		key := strconv.Itoa(status)
+		if status == 0 {
+			key = "network"
+		}
+		m.errorsByStatus[key]++
+	}
+
+	failed := status == 0 || status >= http.StatusInternalServerError || modelError
+	now := s.now()
+	m.recent = append(pruneOutcomes(m.recent, now), upstreamOutcome{at: now, failed: failed})
+	if len(m.recent) > config.ModelErrorRateMaxSamples {
+		m.recent = m.recent[len(m.recent)-config.ModelErrorRateMaxSamples:]
+	}
+
+	if status != 0 {
+		s.latency.Record(model, latency)
+	}
+}
+
+// RecordStopReason 记录一次成功响应的 stop_reason
+func (s *ModelUpstreamStats) RecordStopReason(model, stopReason string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, m := s.modelUnlocked(model)
+	m.stopReasons[stopReason]++
+}
+
+// RecordFallback 记录一次请求 model 但改由回退模型处理
+func (s *ModelUpstreamStats) RecordFallback(model string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, m := s.modelUnlocked(model)
+	m.fallbacks++
+}
+
+// RecordPseudoStream 记录一次流式请求因上游返回JSON响应而降级为伪流式
+func (s *ModelUpstreamStats) RecordPseudoStream(model string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, m := s.modelUnlocked(model)
+	m.pseudoStreams++
+}
+
+// RecentErrorRate 返回模型在 config.ModelErrorRateWindow 内的上游错误率及样本数
+func (s *ModelUpstreamStats) RecentErrorRate(model string) (float64, int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	m, ok := s.models[model]
+	if !ok {
+		return 0, 0
+	}
+	m.recent = pruneOutcomes(m.recent, s.now())
+	return outcomeErrorRate(m.recent), len(m.recent)
+}
+
+// Snapshot 返回各模型的统计（按模型名排序）
+func (s *ModelUpstreamStats) Snapshot() []ModelUpstreamMetrics {
+	latencies, _ := s.latency.Snapshot()
+	byModel := make(map[string]LatencyStats, len(latencies))
+	for _, l := range latencies {
+		byModel[l.Endpoint] = l
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.now()
+	result := make([]ModelUpstreamMetrics, 0, len(s.models))
+	for model, m := range s.models {
+		m.recent = pruneOutcomes(m.recent, now)
+		metrics := ModelUpstreamMetrics{
+			Model:              model,
+			RequestsTotal:      m.requests,
+			ErrorsTotal:        m.errors,
+			ErrorsByStatus:     make(map[string]int64, len(m.errorsByStatus)),
+			P50Ms:              byModel[model].P50Ms,
+			P95Ms:              byModel[model].P95Ms,
+			StopReasons:        make(map[string]int64, len(m.stopReasons)),
+			FallbacksTotal:     m.fallbacks,
+			PseudoStreamsTotal: m.pseudoStreams,
+			RecentErrorRate:    outcomeErrorRate(m.recent),
+			RecentSamples:      len(m.recent),
+		}
+		for status, count := range m.errorsByStatus {
+			metrics.ErrorsByStatus[status] = count
+		}
+		for reason, count := range m.stopReasons {
+			metrics.StopReasons[reason] = count
+		}
+		result = append(result, metrics)
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Model < result[j].Model })
+	return result
+}
+
+// modelUnlocked 返回统计使用的模型标签及其统计，模型数超过上限时新模型计入 config.StatsOverflowLabel（调用方需持有锁）
+func (s *ModelUpstreamStats) modelUnlocked(model string) (string, *modelUpstream) {
+	model = capLabel(s.models, model, config.StatsMaxModels)
+	m, ok := s.models[model]
+	if !ok {
+		m = &modelUpstream{
+			errorsByStatus: make(map[string]int64),
+			stopReasons:    make(map[string]int64),
+		}
+		s.models[model] = m
+	}
+	return model, m
+}
+
+// pruneOutcomes 丢弃窗口外的结果（结果按时间顺序追加）
+func pruneOutcomes(outcomes []upstreamOutcome, now time.Time) []upstreamOutcome {
+	cutoff := now.Add(-config.ModelErrorRateWindow)
+	i := sort.Search(len(outcomes), func(i int) bool { return outcomes[i].at.After(cutoff) })
+	return outcomes[i:]
+}
+
+func outcomeErrorRate(outcomes []upstreamOutcome) float64 {
+	if len(outcomes) == 0 {
+		return 0
+	}
+	failed := 0
+	for _, o := range outcomes {
+		if o.failed {
+			failed++
+		}
+	}
+	return float64(failed) / float64(len(outcomes))
+}
@@ -0,0 +1,85 @@
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"kiro2api/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestModelUpstreamStats_Snapshot(t *testing.T) {
+	s := NewModelUpstreamStats()
+	s.RecordResponse("claude-sonnet-4", 200, false, 100*time.Millisecond)
+	s.RecordResponse("claude-sonnet-4", 200, false, 300*time.Millisecond)
+	s.RecordResponse("claude-sonnet-4", 429, false, 50*time.Millisecond)
+	s.RecordResponse("claude-sonnet-4", 0, false, time.Second)
+	s.RecordStopReason("claude-sonnet-4", "end_turn")
+	s.RecordStopReason("claude-sonnet-4", "tool_use")
+	s.RecordStopReason("claude-sonnet-4", "end_turn")
+	s.RecordFallback("claude-opus-4.5")
+	s.RecordPseudoStream("claude-sonnet-4")
+
+	snapshot := s.Snapshot()
+	require.Len(t, snapshot, 2)
+	assert.Equal(t, "claude-opus-4.5", snapshot[0].Model)
+	assert.Equal(t, int64(1), snapshot[0].FallbacksTotal)
+
+	sonnet := snapshot[1]
+	assert.Equal(t, int64(4), sonnet.RequestsTotal)
+	assert.Equal(t, int64(2), sonnet.ErrorsTotal)
+	assert.Equal(t, map[string]int64{"429": 1, "network": 1}, sonnet.ErrorsByStatus)
+	assert.Equal(t, map[string]int64{"end_turn": 2, "tool_use": 1}, sonnet.StopReasons)
+	assert.Equal(t, int64(1), sonnet.PseudoStreamsTotal)
+	assert.Equal(t, 0.25, sonnet.RecentErrorRate, "429 与模型无关，不计入滚动错误率")
+	assert.Equal(t, 4, sonnet.RecentSamples)
+	assert.Greater(t, sonnet.P95Ms, 0.0)
+	assert.LessOrEqual(t, sonnet.P50Ms, sonnet.P95Ms)
+}
+
+func TestModelUpstreamStats_RecentErrorRateWindow(t *testing.T) {
+	s := NewModelUpstreamStats()
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	for range 4 {
+		s.RecordResponse("claude-sonnet-4", 500, false, time.Millisecond)
+	}
+	rate, samples := s.RecentErrorRate("claude-sonnet-4")
+	assert.Equal(t, 1.0, rate)
+	assert.Equal(t, 4, samples)
+
+	now = now.Add(config.ModelErrorRateWindow)
+	s.RecordResponse("claude-sonnet-4", 200, false, time.Millisecond)
+	rate, samples = s.RecentErrorRate("claude-sonnet-4")
+	assert.Equal(t, 0.0, rate, "窗口外的失败不再计入")
+	assert.Equal(t, 1, samples)
+
+	rate, samples = s.RecentErrorRate("unknown")
+	assert.Zero(t, rate)
+	assert.Zero(t, samples)
+}
+
+// TestModelUpstreamStats_RecentErrorRateCountsModelErrorsOnly token失效、限流和请求错误不计入滚动错误率
+func TestModelUpstreamStats_RecentErrorRateCountsModelErrorsOnly(t *testing.T) {
+	s := NewModelUpstreamStats()
+	for _, status := range []int{400, 403, 429} {
+		s.RecordResponse("claude-sonnet-4", status, false, time.Millisecond)
+	}
+	rate, samples := s.RecentErrorRate("claude-sonnet-4")
+	assert.Zero(t, rate)
+	assert.Equal(t, 3, samples)
+
+	s.RecordResponse("claude-sonnet-4", 400, true, time.Millisecond)
+	s.RecordResponse("claude-sonnet-4", 503, false, time.Millisecond)
+	s.RecordResponse("claude-sonnet-4", 0, false, time.Millisecond)
+	rate, samples = s.RecentErrorRate("claude-sonnet-4")
+	assert.Equal(t, 0.5, rate, "模型错误、5xx 和网络错误计入")
+	assert.Equal(t, 6, samples)
+
+	snapshot := s.Snapshot()
+	require.Len(t, snapshot, 1)
+	assert.Equal(t, int64(6), snapshot[0].ErrorsTotal, "错误总数仍包含所有非200响应")
+}
@@ -0,0 +1,55 @@
+package stats
+
+import "sync"
+
+// PanicMetrics 自进程启动以来请求处理中恢复的panic次数
+type PanicMetrics struct {
+	Total   int64            `json:"total"`
+	ByRoute map[string]int64 `json:"by_route"`
+}
+
+// PanicCounter 按路由累计已恢复的panic
+type PanicCounter struct {
+	mutex   sync.Mutex
+	metrics PanicMetrics
+}
+
+var (
+	globalPanicCounter *PanicCounter
+	panicCounterOnce   sync.Once
+)
+
+// GetPanicCounter 获取全局panic统计器
+func GetPanicCounter() *PanicCounter {
+	panicCounterOnce.Do(func() {
+		globalPanicCounter = NewPanicCounter()
+	})
+	return globalPanicCounter
+}
+
+// NewPanicCounter 创建panic统计器
+func NewPanicCounter() *PanicCounter {
+	return &PanicCounter{metrics: PanicMetrics{ByRoute: make(map[string]int64)}}
+}
+
+// Record 记录一次在 route 上恢复的panic
+func (c *PanicCounter) Record(route string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.metrics.Total++
+	c.metrics.ByRoute[route]++
+}
+
+// Snapshot 返回统计快照
+func (c *PanicCounter) Snapshot() PanicMetrics {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	s := c.metrics
+	s.ByRoute = make(map[string]int64, len(c.metrics.ByRoute))
+	for route, count := range c.metrics.ByRoute {
+		s.ByRoute[route] = count
+	}
+	return s
+}
@@ -0,0 +1,23 @@
+package stats
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPanicCounter_Record(t *testing.T) {
+	c := NewPanicCounter()
+
+	c.Record("/v1/messages")
+	c.Record("/v1/chat/completions")
+	c.Record("/v1/messages")
+
+	s := c.Snapshot()
+	assert.Equal(t, int64(3), s.Total)
+	assert.Equal(t, map[string]int64{"/v1/messages": 2, "/v1/chat/completions": 1}, s.ByRoute)
+
+	// 快照与内部状态隔离
+	s.ByRoute["/v1/messages"] = 100
+	assert.Equal(t, int64(2), c.Snapshot().ByRoute["/v1/messages"])
+}
@@ -0,0 +1,86 @@
+package stats
+
+import (
+	"sort"
+	"sync"
+
+	"kiro2api/config"
+)
+
+// ResponseSizeBucket 响应体积直方图的一个桶，le_bytes 为桶上界（包含）；溢出桶没有上界，不输出 le_bytes
+type ResponseSizeBucket struct {
+	LeBytes int64 `json:"le_bytes,omitempty"`
+	Count   int64 `json:"count"`
+}
+
+// ResponseSizeMetrics 自进程启动以来流式响应发送给客户端的字节数统计
+type ResponseSizeMetrics struct {
+	ResponsesTotal int64                `json:"responses_total"`
+	LargeResponses int64                `json:"large_responses"`
+	TotalBytes     int64                `json:"total_bytes"`
+	MaxBytes       int64                `json:"max_bytes"`
+	Buckets        []ResponseSizeBucket `json:"buckets"`
+}
+
+// ResponseSizeHistogram 按字节数分桶累计响应体积
+type ResponseSizeHistogram struct {
+	mutex   sync.Mutex
+	bounds  []int64
+	counts  []int64 // 比 bounds 多一个溢出桶
+	metrics ResponseSizeMetrics
+}
+
+var (
+	globalResponseSizeHistogram *ResponseSizeHistogram
+	responseSizeOnce            sync.Once
+)
+
+// GetResponseSizeHistogram 获取全局响应体积直方图
+func GetResponseSizeHistogram() *ResponseSizeHistogram {
+	responseSizeOnce.Do(func() {
+		globalResponseSizeHistogram = NewResponseSizeHistogram(config.ResponseSizeBuckets)
+	})
+	return globalResponseSizeHistogram
+}
+
+// NewResponseSizeHistogram 创建响应体积直方图，bounds 为桶上界（字节）
+func NewResponseSizeHistogram(bounds []int64) *ResponseSizeHistogram {
+	sorted := append([]int64(nil), bounds...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return &ResponseSizeHistogram{
+		bounds: sorted,
+		counts: make([]int64, len(sorted)+1),
+	}
+}
+
+// Record 记录一个已结束响应的字节数，large 表示超过了告警阈值
+func (h *ResponseSizeHistogram) Record(bytes int64, large bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.counts[sort.Search(len(h.bounds), func(i int) bool { return bytes <= h.bounds[i] })]++
+	h.metrics.ResponsesTotal++
+	h.metrics.TotalBytes += bytes
+	if bytes > h.metrics.MaxBytes {
+		h.metrics.MaxBytes = bytes
+	}
+	if large {
+		h.metrics.LargeResponses++
+	}
+}
+
+// Snapshot 返回统计快照，桶按上界升序排列，最后一个为溢出桶
+func (h *ResponseSizeHistogram) Snapshot() ResponseSizeMetrics {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	s := h.metrics
+	s.Buckets = make([]ResponseSizeBucket, len(h.counts))
+	for i, count := range h.counts {
+		s.Buckets[i].Count = count
+		if i < len(h.bounds) {
+			s.Buckets[i].LeBytes = h.bounds[i]
+		}
+	}
+	return s
+}
@@ -0,0 +1,27 @@
+package stats
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponseSizeHistogram_Record(t *testing.T) {
+	h := NewResponseSizeHistogram([]int64{1024, 100})
+
+	h.Record(100, false)
+	h.Record(101, false)
+	h.Record(1024, false)
+	h.Record(4096, true)
+
+	s := h.Snapshot()
+	assert.Equal(t, int64(4), s.ResponsesTotal)
+	assert.Equal(t, int64(1), s.LargeResponses)
+	assert.Equal(t, int64(100+101+1024+4096), s.TotalBytes)
+	assert.Equal(t, int64(4096), s.MaxBytes)
+	assert.Equal(t, []ResponseSizeBucket{
+		{LeBytes: 100, Count: 1},
+		{LeBytes: 1024, Count: 2},
+		{Count: 1},
+	}, s.Buckets)
+}
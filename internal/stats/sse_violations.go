@@ -0,0 +1,67 @@
+package stats
+
+import "sync"
+
+// SSEViolationMetrics 自进程启动以来流式响应的SSE事件序列违规统计
+type SSEViolationMetrics struct {
+	StreamsTotal          int64            `json:"streams_total"`
+	StreamsWithViolations int64            `json:"streams_with_violations"`
+	ViolationsTotal       int64            `json:"violations_total"`
+	StrictTerminations    int64            `json:"strict_terminations"`
+	ByRule                map[string]int64 `json:"by_rule"`
+}
+
+// SSEViolationCounter 按规则累计SSE事件序列违规
+type SSEViolationCounter struct {
+	mutex   sync.Mutex
+	metrics SSEViolationMetrics
+}
+
+var (
+	globalSSEViolationCounter *SSEViolationCounter
+	sseViolationOnce          sync.Once
+)
+
+// GetSSEViolationCounter 获取全局SSE违规统计器
+func GetSSEViolationCounter() *SSEViolationCounter {
+	sseViolationOnce.Do(func() {
+		globalSSEViolationCounter = NewSSEViolationCounter()
+	})
+	return globalSSEViolationCounter
+}
+
+// NewSSEViolationCounter 创建SSE违规统计器
+func NewSSEViolationCounter() *SSEViolationCounter {
+	return &SSEViolationCounter{metrics: SSEViolationMetrics{ByRule: make(map[string]int64)}}
+}
+
+// RecordStream 记录一个已结束的流，rules 为流中每次违规的规则名，strictTerminated 表示严格模式下因违规终止
+func (c *SSEViolationCounter) RecordStream(rules []string, strictTerminated bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.metrics.StreamsTotal++
+	if len(rules) > 0 {
+		c.metrics.StreamsWithViolations++
+	}
+	c.metrics.ViolationsTotal += int64(len(rules))
+	for _, rule := range rules {
+		c.metrics.ByRule[rule]++
+	}
+	if strictTerminated {
+		c.metrics.StrictTerminations++
+	}
+}
+
+// Snapshot 返回统计快照
+func (c *SSEViolationCounter) Snapshot() SSEViolationMetrics {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	s := c.metrics
+	s.ByRule = make(map[string]int64, len(c.metrics.ByRule))
+	for rule, count := range c.metrics.ByRule {
+		s.ByRule[rule] = count
+	}
+	return s
+}
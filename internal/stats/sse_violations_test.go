@@ -0,0 +1,30 @@
+package stats
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSSEViolationCounter_RecordStream(t *testing.T) {
+	c := NewSSEViolationCounter()
+
+	c.RecordStream(nil, false)
+	c.RecordStream([]string{"duplicate_message_start", "delta_after_block_stop", "duplicate_message_start"}, false)
+	c.RecordStream([]string{"block_stop_without_start"}, true)
+
+	s := c.Snapshot()
+	assert.Equal(t, int64(3), s.StreamsTotal)
+	assert.Equal(t, int64(2), s.StreamsWithViolations)
+	assert.Equal(t, int64(4), s.ViolationsTotal)
+	assert.Equal(t, int64(1), s.StrictTerminations)
+	assert.Equal(t, map[string]int64{
+		"duplicate_message_start":  2,
+		"delta_after_block_stop":   1,
+		"block_stop_without_start": 1,
+	}, s.ByRule)
+
+	// 快照与内部状态隔离
+	s.ByRule["duplicate_message_start"] = 100
+	assert.Equal(t, int64(2), c.Snapshot().ByRule["duplicate_message_start"])
+}
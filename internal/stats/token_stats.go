@@ -26,6 +26,9 @@ type TokenStatsCollector struct {
 	mutex       sync.RWMutex
 	hourlyStats map[string]*HourlyStats // key: "2024-12-28 10:00"
 	maxHours    int                     // 保留最近多少小时的数据
+
+	modelStats  map[string]*ModelMetrics            // key: 模型名
+	tenantStats map[string]map[string]*ModelMetrics // key: 租户 -> 模型名
 }
 
 var (
@@ -36,16 +39,23 @@ var (
 // GetCollector 获取全局统计收集器
 func GetCollector() *TokenStatsCollector {
 	once.Do(func() {
-		globalCollector = &TokenStatsCollector{
-			hourlyStats: make(map[string]*HourlyStats),
-			maxHours:    24, // 保留最近 24 小时
-		}
+		globalCollector = NewTokenStatsCollector()
 	})
 	return globalCollector
 }
 
-// Record 记录一次 token 使用
-func (c *TokenStatsCollector) Record(inputTokens, outputTokens int, model string) {
+// NewTokenStatsCollector 创建统计收集器，小时统计保留最近 24 小时
+func NewTokenStatsCollector() *TokenStatsCollector {
+	return &TokenStatsCollector{
+		hourlyStats: make(map[string]*HourlyStats),
+		maxHours:    24,
+		modelStats:  make(map[string]*ModelMetrics),
+		tenantStats: make(map[string]map[string]*ModelMetrics),
+	}
+}
+
+// Record 记录一次成功请求的 token 使用，同时计入模型与租户统计
+func (c *TokenStatsCollector) Record(inputTokens, outputTokens int, model, tenant string) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
@@ -61,6 +71,8 @@ func (c *TokenStatsCollector) Record(inputTokens, outputTokens int, model string
 	stats.InputTokens += int64(inputTokens)
 	stats.OutputTokens += int64(outputTokens)
 	stats.RequestCount++
+
+	c.recordUsageUnlocked(inputTokens, outputTokens, model, tenant)
 }
 
 // GetHourlyStats 获取最近 N 小时的统计数据
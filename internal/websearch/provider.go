@@ -0,0 +1,128 @@
+package websearch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"kiro2api/config"
+	"kiro2api/utils"
+)
+
+// TruncationMarker 搜索结果超出大小限制时追加的标记
+const TruncationMarker = "\n[...truncated...]"
+
+// ErrProviderNotConfigured local 模式下未配置搜索服务
+var ErrProviderNotConfigured = errors.New("未配置搜索服务（WEB_SEARCH_PROVIDER_URL）")
+
+// Result 单条搜索结果
+type Result struct {
+	Title   string `json:"title"`
+	URL     string `json:"url"`
+	Snippet string `json:"content"`
+}
+
+// Provider 搜索服务接口，local 模式下由代理调用以执行 web_search 工具
+type Provider interface {
+	Search(ctx context.Context, query string) ([]Result, error)
+}
+
+// SearxNGProvider 通过 SearxNG 的 JSON 接口（GET /search?q=...&format=json）执行搜索
+// 其他返回相同结构（{"results":[{"title","url","content"}]}）的 HTTP 服务也可直接使用
+type SearxNGProvider struct {
+	baseURL    string
+	client     *http.Client
+	maxResults int
+}
+
+// NewSearxNGProvider 创建 SearxNG 搜索服务客户端
+func NewSearxNGProvider(baseURL string, timeout time.Duration, maxResults int) *SearxNGProvider {
+	return &SearxNGProvider{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		client:     &http.Client{Timeout: timeout},
+		maxResults: maxResults,
+	}
+}
+
+// NewProviderFromConfig 按当前环境变量创建搜索服务，未配置地址时返回 ErrProviderNotConfigured
+func NewProviderFromConfig() (Provider, error) {
+	baseURL := config.WebSearchProviderURL()
+	if baseURL == "" {
+		return nil, ErrProviderNotConfigured
+	}
+	return NewSearxNGProvider(baseURL, config.WebSearchTimeout(), config.WebSearchMaxResults()), nil
+}
+
+// Search 执行搜索，最多返回 maxResults 条结果
+func (p *SearxNGProvider) Search(ctx context.Context, query string) ([]Result, error) {
+	params := url.Values{}
+	params.Set("q", query)
+	params.Set("format", "json")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.baseURL+"/search?"+params.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("创建搜索请求失败: %v", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("搜索请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("搜索请求失败: 状态码 %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, config.WebSearchResponseMaxBytes))
+	if err != nil {
+		return nil, fmt.Errorf("读取搜索响应失败: %v", err)
+	}
+
+	var parsed struct {
+		Results []Result `json:"results"`
+	}
+	if err := utils.SafeUnmarshal(body, &parsed); err != nil {
+		return nil, fmt.Errorf("解析搜索响应失败: %v", err)
+	}
+
+	if p.maxResults > 0 && len(parsed.Results) > p.maxResults {
+		parsed.Results = parsed.Results[:p.maxResults]
+	}
+	return parsed.Results, nil
+}
+
+// FormatResults 把搜索结果格式化为 tool_result 文本，超过 maxBytes 时截断并追加 TruncationMarker
+func FormatResults(query string, results []Result, maxBytes int) string {
+	if len(results) == 0 {
+		return fmt.Sprintf("No results found for %q.", query)
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Search results for %q:\n", query)
+	for i, r := range results {
+		fmt.Fprintf(&sb, "\n%d. %s\n   %s\n", i+1, strings.TrimSpace(r.Title), strings.TrimSpace(r.URL))
+		if snippet := strings.TrimSpace(r.Snippet); snippet != "" {
+			fmt.Fprintf(&sb, "   %s\n", snippet)
+		}
+	}
+
+	text := sb.String()
+	if maxBytes <= 0 || len(text) <= maxBytes {
+		return text
+	}
+
+	// 截断到限制长度，避免切断多字节字符
+	truncated := text[:maxBytes]
+	for len(truncated) > 0 && !utf8.ValidString(truncated) {
+		truncated = truncated[:len(truncated)-1]
+	}
+	return truncated + TruncationMarker
+}
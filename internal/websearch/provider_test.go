@@ -0,0 +1,72 @@
+package websearch
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearxNGProvider_Search(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/search", r.URL.Path)
+		assert.Equal(t, "golang generics", r.URL.Query().Get("q"))
+		assert.Equal(t, "json", r.URL.Query().Get("format"))
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"results":[
+			{"title":"A","url":"https://a.example","content":"first"},
+			{"title":"B","url":"https://b.example","content":"second"},
+			{"title":"C","url":"https://c.example","content":"third"}
+		]}`))
+	}))
+	defer server.Close()
+
+	results, err := NewSearxNGProvider(server.URL+"/", time.Second, 2).Search(context.Background(), "golang generics")
+	require.NoError(t, err)
+	assert.Equal(t, []Result{
+		{Title: "A", URL: "https://a.example", Snippet: "first"},
+		{Title: "B", URL: "https://b.example", Snippet: "second"},
+	}, results)
+}
+
+func TestSearxNGProvider_NonOKStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	_, err := NewSearxNGProvider(server.URL, time.Second, 5).Search(context.Background(), "q")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "403")
+}
+
+func TestNewProviderFromConfig_RequiresURL(t *testing.T) {
+	t.Setenv("WEB_SEARCH_PROVIDER_URL", "")
+	_, err := NewProviderFromConfig()
+	assert.ErrorIs(t, err, ErrProviderNotConfigured)
+}
+
+func TestFormatResults(t *testing.T) {
+	assert.Equal(t, `No results found for "nothing".`, FormatResults("nothing", nil, 100))
+
+	text := FormatResults("go", []Result{{Title: " Go ", URL: "https://go.dev", Snippet: "The Go language"}}, 0)
+	assert.Equal(t, "Search results for \"go\":\n\n1. Go\n   https://go.dev\n   The Go language\n", text)
+}
+
+func TestFormatResults_TruncatesOnRuneBoundary(t *testing.T) {
+	results := []Result{{Title: "中文", URL: "https://example.com", Snippet: strings.Repeat("搜索结果", 100)}}
+
+	for maxBytes := 60; maxBytes < 70; maxBytes++ {
+		text := FormatResults("q", results, maxBytes)
+		require.True(t, strings.HasSuffix(text, TruncationMarker))
+		body := strings.TrimSuffix(text, TruncationMarker)
+		assert.LessOrEqual(t, len(body), maxBytes)
+		assert.True(t, utf8.ValidString(body), "maxBytes=%d 截断不应切断多字节字符", maxBytes)
+	}
+}
@@ -12,6 +12,8 @@ import (
 	"sync/atomic"
 	"time"
 
+	"kiro2api/config"
+
 	"github.com/bytedance/sonic"
 )
 
@@ -69,14 +71,13 @@ func createLogger() *Logger {
 		callerSkip:   3,                      // 默认调用栈深度
 	}
 
-	// 从环境变量设置级别
-	if debug := os.Getenv("DEBUG"); debug != "" && (debug == "true" || debug == "1") {
+	// 级别取自配置快照（LOG_LEVEL）；DEBUG 只在 LOG_LEVEL 为默认值时开启调试级别
+	debug := os.Getenv("DEBUG") == "true" || os.Getenv("DEBUG") == "1"
+	logLevel := config.Get().LogLevel
+	if debug && logLevel == config.DefaultLogLevel {
 		atomic.StoreInt64(&logger.level, int64(DEBUG))
-	}
-	if logLevel := os.Getenv("LOG_LEVEL"); logLevel != "" {
-		if level, err := ParseLevel(logLevel); err == nil {
-			atomic.StoreInt64(&logger.level, int64(level))
-		}
+	} else if level, err := ParseLevel(logLevel); err == nil {
+		atomic.StoreInt64(&logger.level, int64(level))
 	}
 
 	// 从环境变量控制优化特性
@@ -84,10 +85,7 @@ func createLogger() *Logger {
 		logger.enableCaller = true
 	} else {
 		// 在调试级别时，默认开启调用者信息，便于定位（KISS）
-		if lvl := os.Getenv("LOG_LEVEL"); strings.ToLower(lvl) == "debug" {
-			logger.enableCaller = true
-		}
-		if debug := os.Getenv("DEBUG"); debug == "true" || debug == "1" {
+		if logLevel == "debug" || debug {
 			logger.enableCaller = true
 		}
 	}
@@ -270,11 +268,21 @@ func (l *Logger) marshalLogEntry(entry *LogEntry) []byte {
 	return []byte(b.String())
 }
 
+// String 返回级别名称（大写）
+func (l Level) String() string {
+	return levelNames[l]
+}
+
 // SetLevel 设置日志级别（优化：原子操作）
 func SetLevel(level Level) {
 	atomic.StoreInt64(&defaultLogger.level, int64(level))
 }
 
+// GetLevel 返回当前日志级别
+func GetLevel() Level {
+	return Level(atomic.LoadInt64(&defaultLogger.level))
+}
+
 // 全局日志函数
 func Debug(msg string, fields ...Field) {
 	defaultLogger.log(DEBUG, msg, fields)
@@ -0,0 +1,52 @@
+package logger
+
+import (
+	"bytes"
+	"log"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// captureOutput 把默认logger的输出重定向到缓冲区，测试结束后恢复
+func captureOutput(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	previous, level := defaultLogger.logger, GetLevel()
+	var buf bytes.Buffer
+	defaultLogger.logger = log.New(&buf, "", 0)
+	t.Cleanup(func() {
+		defaultLogger.logger = previous
+		SetLevel(level)
+	})
+	return &buf
+}
+
+func TestSetLevel_AppliesToSubsequentCalls(t *testing.T) {
+	buf := captureOutput(t)
+
+	SetLevel(INFO)
+	Debug("hidden-debug")
+	Info("visible-info")
+	assert.NotContains(t, buf.String(), "hidden-debug")
+	assert.Contains(t, buf.String(), "visible-info")
+
+	SetLevel(DEBUG)
+	assert.Equal(t, DEBUG, GetLevel())
+	Debug("visible-debug")
+	assert.Contains(t, buf.String(), `"level":"DEBUG"`)
+	assert.Contains(t, buf.String(), "visible-debug")
+
+	SetLevel(ERROR)
+	buf.Reset()
+	Warn("hidden-warn")
+	Error("visible-error")
+	assert.NotContains(t, buf.String(), "hidden-warn")
+	assert.Contains(t, buf.String(), "visible-error")
+}
+
+func TestLevelString(t *testing.T) {
+	assert.Equal(t, "WARN", WARN.String())
+	level, err := ParseLevel("warning")
+	assert.NoError(t, err)
+	assert.Equal(t, WARN, level)
+}
@@ -2,6 +2,9 @@ package parser
 
 import (
 	"fmt"
+	"io"
+
+	"kiro2api/config"
 	"kiro2api/logger"
 )
 
@@ -24,6 +27,22 @@ func (cesp *CompliantEventStreamParser) SetMaxErrors(maxErrors int) {
 	cesp.robustParser.SetMaxErrors(maxErrors)
 }
 
+// SetEagerParseMode 设置是否在stop信号前提前检测工具参数JSON是否完整，默认取 TOOL_INPUT_EAGER_PARSE
+func (cesp *CompliantEventStreamParser) SetEagerParseMode(enabled bool) {
+	cesp.messageProcessor.toolDataAggregator.EagerParseMode = enabled
+}
+
+// PendingBytes 暂存的不完整帧字节数：帧在多字节字符或JSON记号中间被TCP拆开时，
+// 未收齐的部分保留在 RecoveryBuffer 中，与下一次 ParseStream 的数据拼接后再解析
+func (cesp *CompliantEventStreamParser) PendingBytes() int {
+	return cesp.robustParser.PendingBytes()
+}
+
+// RecoveredFrames 跨 ParseStream 调用拼接后完整解析的帧数
+func (cesp *CompliantEventStreamParser) RecoveredFrames() int {
+	return cesp.robustParser.RecoveredFrames()
+}
+
 // Reset 重置解析器状态
 func (cesp *CompliantEventStreamParser) Reset() {
 	cesp.robustParser.Reset()
@@ -39,16 +58,58 @@ func (cesp *CompliantEventStreamParser) ParseResponse(streamData []byte) (*Parse
 	}
 
 	// 2. 处理消息
+	allEvents, errors := cesp.processMessages(messages, 0)
+
+	// 3. 构建结果
+	return cesp.buildResult(messages, allEvents, errors), nil
+}
+
+// ParseReader 从 reader 增量读取并解析响应，每读到一段数据就解析其中的完整消息
+// 读取中途出错（如上游连接被重置）时，返回已解析部分的结果和读取错误
+func (cesp *CompliantEventStreamParser) ParseReader(r io.Reader) (*ParseResult, error) {
+	var messages []*EventStreamMessage
+	var allEvents []SSEEvent
+	var errors []error
+
+	buf := make([]byte, config.EventStreamReadChunkSize)
+	for {
+		n, readErr := r.Read(buf)
+		if n > 0 {
+			parsed, err := cesp.robustParser.ParseStream(buf[:n])
+			if err != nil {
+				logger.Warn("事件流解析部分失败", logger.Err(err))
+			}
+			events, processErrors := cesp.processMessages(parsed, len(messages))
+			messages = append(messages, parsed...)
+			allEvents = append(allEvents, events...)
+			errors = append(errors, processErrors...)
+		}
+
+		if readErr == io.EOF {
+			if pending := cesp.PendingBytes(); pending > 0 {
+				errors = append(errors, fmt.Errorf("响应结束时最后一帧不完整，丢弃 %d 字节", pending))
+				logger.Warn("响应结束时最后一帧不完整", logger.Int("pending_bytes", pending))
+			}
+			return cesp.buildResult(messages, allEvents, errors), nil
+		}
+		if readErr != nil {
+			return cesp.buildResult(messages, allEvents, errors), readErr
+		}
+	}
+}
+
+// processMessages 把消息转换为SSE事件，单条消息处理失败时记录错误并继续；offset 为消息在整个响应中的起始序号
+func (cesp *CompliantEventStreamParser) processMessages(messages []*EventStreamMessage, offset int) ([]SSEEvent, []error) {
 	var allEvents []SSEEvent
 	var errors []error
 
 	for i, message := range messages {
 		events, processErr := cesp.messageProcessor.ProcessMessage(message)
 		if processErr != nil {
-			errMsg := fmt.Errorf("处理消息 %d 失败: %w", i, processErr)
+			errMsg := fmt.Errorf("处理消息 %d 失败: %w", offset+i, processErr)
 			errors = append(errors, errMsg)
 			logger.Warn("消息处理失败",
-				logger.Int("message_index", i),
+				logger.Int("message_index", offset+i),
 				logger.String("message_type", message.GetMessageType()),
 				logger.String("event_type", message.GetEventType()),
 				logger.Err(processErr))
@@ -57,8 +118,11 @@ func (cesp *CompliantEventStreamParser) ParseResponse(streamData []byte) (*Parse
 
 		allEvents = append(allEvents, events...)
 	}
+	return allEvents, errors
+}
 
-	// 3. 构建结果
+// buildResult 汇总解析结果
+func (cesp *CompliantEventStreamParser) buildResult(messages []*EventStreamMessage, allEvents []SSEEvent, errors []error) *ParseResult {
 	result := &ParseResult{
 		Messages:       messages,
 		Events:         allEvents,
@@ -76,7 +140,7 @@ func (cesp *CompliantEventStreamParser) ParseResponse(streamData []byte) (*Parse
 			logger.Int("error_count", len(errors)))
 	}
 
-	return result, nil
+	return result
 }
 
 // ParseStream 解析流式数据（增量解析）
@@ -218,16 +282,12 @@ func (pr *ParseResult) GetCompletionText() string {
 	return text
 }
 
-// GetToolCalls 获取所有工具调用
-func (pr *ParseResult) GetToolCalls() []*ToolExecution {
-	var tools []*ToolExecution
-
-	for _, tool := range pr.ToolExecutions {
-		tools = append(tools, tool)
-	}
-	for _, tool := range pr.ActiveTools {
-		tools = append(tools, tool)
-	}
+// HasContent 是否解析出了文本或已完成的工具调用
+func (pr *ParseResult) HasContent() bool {
+	return len(pr.ToolExecutions) > 0 || pr.GetCompletionText() != ""
+}
 
-	return tools
+// GetToolCalls 获取所有工具调用（按BlockIndex排序，与上游发出顺序一致）
+func (pr *ParseResult) GetToolCalls() []*ToolExecution {
+	return SortToolsByBlockIndex(pr.ToolExecutions, pr.ActiveTools)
 }
@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"kiro2api/config"
 	"kiro2api/logger"
 	"kiro2api/utils"
 	"strings"
@@ -51,6 +52,8 @@ func NewCompliantMessageProcessor() *CompliantMessageProcessor {
 			processor.toolManager.UpdateToolArgumentsFromJSON(toolUseId, fullParams)
 		})
 
+	processor.toolDataAggregator.EagerParseMode = config.IsToolInputEagerParseEnabled()
+
 	processor.registerEventHandlers()
 	return processor
 }
@@ -72,7 +75,9 @@ func (cmp *CompliantMessageProcessor) registerEventHandlers() {
 	cmp.eventHandlers[EventTypes.COMPLETION] = &CompletionEventHandler{cmp}
 	cmp.eventHandlers[EventTypes.COMPLETION_CHUNK] = &CompletionChunkEventHandler{cmp}
 	cmp.eventHandlers[EventTypes.TOOL_CALL_REQUEST] = &ToolCallRequestHandler{cmp.toolManager}
-	// 移除非标准事件处理器：TOOL_CALL_RESULT, TOOL_EXECUTION_START, TOOL_EXECUTION_END
+	// tool_call_result 只由 StreamingToolResultHandler 处理（非流式结果同样只关闭工具块）
+	cmp.eventHandlers[EventTypes.TOOL_CALL_RESULT] = &StreamingToolResultHandler{cmp.toolManager}
+	// 移除非标准事件处理器：TOOL_EXECUTION_START, TOOL_EXECUTION_END
 	cmp.eventHandlers[EventTypes.TOOL_CALL_ERROR] = &ToolCallErrorHandler{cmp.toolManager}
 	cmp.eventHandlers[EventTypes.SESSION_START] = &SessionStartHandler{cmp.sessionManager}
 	cmp.eventHandlers[EventTypes.SESSION_END] = &SessionEndHandler{cmp.sessionManager}
@@ -178,6 +178,8 @@ type ToolCallResult struct {
 	ToolCallID    string `json:"tool_call_id"`
 	Result        any    `json:"result"`
 	ExecutionTime int64  `json:"execution_time,omitempty"` // 毫秒
+	// StreamToolResult 为true时结果按片段以 input_json_delta 增量下发，避免超大结果（如MCP数据库查询）一次性输出
+	StreamToolResult bool `json:"stream_tool_result,omitempty"`
 }
 
 // ToolCallError 工具调用错误
@@ -0,0 +1,72 @@
+package parser
+
+// jsonCompletionScanner 增量跟踪JSON文本的结构，检测顶层对象/数组何时闭合
+// 只跟踪 { } [ ] 的嵌套深度以及字符串和转义状态，不校验语法；闭合后仍需完整解析确认
+// 多字节UTF-8字符的各字节都不小于0x80，不会被误判为结构字符，因此可以逐字节处理被截断的片段
+type jsonCompletionScanner struct {
+	depth    int
+	inString bool
+	escaped  bool
+	started  bool // 已遇到顶层的 { 或 [
+	complete bool // 顶层值已闭合
+	invalid  bool // 结构错误或闭合后仍有非空白内容
+}
+
+// feed 处理一段新数据，返回顶层值是否已闭合
+func (s *jsonCompletionScanner) feed(fragment string) bool {
+	for i := 0; i < len(fragment) && !s.invalid; i++ {
+		s.step(fragment[i])
+	}
+	return s.complete && !s.invalid
+}
+
+func (s *jsonCompletionScanner) step(b byte) {
+	if s.complete {
+		if !isJSONWhitespace(b) {
+			s.invalid = true
+		}
+		return
+	}
+
+	if s.inString {
+		switch {
+		case s.escaped:
+			s.escaped = false
+		case b == '\\':
+			s.escaped = true
+		case b == '"':
+			s.inString = false
+		}
+		return
+	}
+
+	switch b {
+	case '"':
+		if !s.started {
+			s.invalid = true
+			return
+		}
+		s.inString = true
+	case '{', '[':
+		s.started = true
+		s.depth++
+	case '}', ']':
+		if s.depth == 0 {
+			s.invalid = true
+			return
+		}
+		s.depth--
+		if s.depth == 0 {
+			s.complete = true
+		}
+	default:
+		// 顶层值之前只允许空白
+		if !s.started && !isJSONWhitespace(b) {
+			s.invalid = true
+		}
+	}
+}
+
+func isJSONWhitespace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r'
+}
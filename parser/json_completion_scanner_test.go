@@ -0,0 +1,120 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"kiro2api/utils"
+)
+
+func TestJSONCompletionScanner(t *testing.T) {
+	tests := []struct {
+		name      string
+		fragments []string
+		complete  bool
+		invalid   bool
+	}{
+		{"单个片段", []string{`{"a":1}`}, true, false},
+		{"跨片段闭合", []string{`{"path":"/tmp",`, `"n":[1,{"x":2}]`, `}`}, true, false},
+		{"未闭合", []string{`{"a":{"b":1}`}, false, false},
+		{"字符串中的括号不计入", []string{`{"code":"func() { return }`}, false, false},
+		{"转义引号", []string{`{"q":"say \"}\" now`, `"}`}, true, false},
+		{"转义反斜杠后的引号", []string{`{"p":"C:\\`, `"}`}, true, false},
+		{"多字节字符被截断", []string{"{\"text\":\"\xe6\xb5", "\x8b\xe8\xaf\x95\"}"}, true, false},
+		{"闭合后的空白", []string{`{"a":1}`, " \n"}, true, false},
+		{"闭合后的额外内容", []string{`{"a":1}`, `,"b":2}`}, false, true},
+		{"顶层不是对象", []string{`"text"`}, false, true},
+		{"多余的右括号", []string{`}`}, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s jsonCompletionScanner
+			var complete bool
+			for _, fragment := range tt.fragments {
+				complete = s.feed(fragment)
+			}
+			assert.Equal(t, tt.complete, complete)
+			assert.Equal(t, tt.invalid, s.invalid)
+		})
+	}
+}
+
+func TestSonicAggregator_EagerParseCompletesBeforeStop(t *testing.T) {
+	var callbacks []string
+	aggregator := NewSonicStreamingJSONAggregatorWithCallback(func(toolUseId, fullParams string) {
+		callbacks = append(callbacks, fullParams)
+	})
+	aggregator.EagerParseMode = true
+
+	complete, _ := aggregator.ProcessToolData("eager-1", "write_file", `{"path":"/tmp/a.txt","con`, false, -1)
+	assert.False(t, complete)
+	assert.Empty(t, callbacks)
+
+	// 最后一个片段闭合对象，不等stop信号即完成
+	complete, fullInput := aggregator.ProcessToolData("eager-1", "write_file", `tent":"测试}内容"}`, false, -1)
+	require.True(t, complete)
+	assert.JSONEq(t, `{"path":"/tmp/a.txt","content":"测试}内容"}`, fullInput)
+	assert.Equal(t, []string{fullInput}, callbacks)
+
+	// stop信号沿用提前解析的结果，不重复回调
+	complete, stopInput := aggregator.ProcessToolData("eager-1", "write_file", "", true, -1)
+	assert.True(t, complete)
+	assert.Equal(t, fullInput, stopInput)
+	assert.Len(t, callbacks, 1)
+	assert.Empty(t, aggregator.activeStreamers)
+}
+
+func TestSonicAggregator_EagerParseFallsBackToBuffered(t *testing.T) {
+	aggregator := NewSonicStreamingJSONAggregatorWithCallback(nil)
+	aggregator.EagerParseMode = true
+
+	// 结构闭合但不是合法JSON：提前解析失败，回退到缓冲模式
+	complete, _ := aggregator.ProcessToolData("fallback-1", "tool", `{"a":tru}`, false, -1)
+	assert.False(t, complete)
+	complete, fullInput := aggregator.ProcessToolData("fallback-1", "tool", "", true, -1)
+	assert.True(t, complete)
+	assert.Equal(t, "{}", fullInput)
+
+	// 默认关闭时与原行为一致：只在stop时完成
+	buffered := NewSonicStreamingJSONAggregatorWithCallback(nil)
+	complete, _ = buffered.ProcessToolData("buffered-1", "tool", `{"a":1}`, false, -1)
+	assert.False(t, complete)
+	complete, fullInput = buffered.ProcessToolData("buffered-1", "tool", "", true, -1)
+	assert.True(t, complete)
+	assert.JSONEq(t, `{"a":1}`, fullInput)
+}
+
+func TestLegacyToolUseEventHandler_EagerParseKeepsDeltas(t *testing.T) {
+	toolManager := NewToolLifecycleManager()
+	aggregator := NewSonicStreamingJSONAggregatorWithCallback(func(toolUseId string, fullParams string) {
+		toolManager.UpdateToolArgumentsFromJSON(toolUseId, fullParams)
+	})
+	aggregator.EagerParseMode = true
+	handler := &LegacyToolUseEventHandler{toolManager: toolManager, aggregator: aggregator}
+
+	send := func(input any, stop bool) []SSEEvent {
+		payload, err := utils.FastMarshal(toolUseEvent{Name: "search", ToolUseId: "eager-tool", Input: input, Stop: stop})
+		require.NoError(t, err)
+		events, err := handler.handleToolCallEvent(&EventStreamMessage{Payload: payload})
+		require.NoError(t, err)
+		return events
+	}
+
+	send(map[string]any{}, false)
+	send(`{"query":"go `, false)
+	events := send(`channels"}`, false)
+
+	// 提前完成的片段仍作为 input_json_delta 发送，参数已更新，工具块尚未结束
+	require.Len(t, events, 1)
+	assert.Equal(t, "content_block_delta", events[0].Event)
+	tool := toolManager.GetActiveTools()["eager-tool"]
+	require.NotNil(t, tool)
+	assert.Equal(t, "go channels", tool.Arguments["query"])
+
+	// stop信号结束工具块
+	send("", true)
+	_, stillActive := toolManager.GetActiveTools()["eager-tool"]
+	assert.False(t, stillActive)
+}
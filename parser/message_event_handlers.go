@@ -221,6 +221,20 @@ func (h *ToolCallRequestHandler) Handle(message *EventStreamMessage) ([]SSEEvent
 	return h.toolManager.HandleToolCallRequest(request), nil
 }
 
+// StreamingToolResultHandler 处理工具调用结果事件，stream_tool_result 为true时结果以增量片段下发
+type StreamingToolResultHandler struct {
+	toolManager *ToolLifecycleManager
+}
+
+func (h *StreamingToolResultHandler) Handle(message *EventStreamMessage) ([]SSEEvent, error) {
+	var result ToolCallResult
+	if err := utils.FastUnmarshal(message.Payload, &result); err != nil {
+		return nil, err
+	}
+
+	return h.toolManager.HandleToolCallResult(result), nil
+}
+
 // ToolCallErrorHandler 处理工具调用错误
 type ToolCallErrorHandler struct {
 	toolManager *ToolLifecycleManager
@@ -506,13 +520,20 @@ func (h *LegacyToolUseEventHandler) handleToolCallEvent(message *EventStreamMess
 			logger.String("toolUseId", evt.ToolUseId),
 			logger.String("name", evt.Name))
 
+		// 首个事件只带参数的开头片段时，注册时不解析，片段交给聚合器拼接
+		arguments := inputStr
+		fragment := ""
+		if !evt.Stop && isPartialToolInput(inputStr) {
+			arguments, fragment = "{}", inputStr
+		}
+
 		// 创建初始工具调用请求（使用完整参数）
 		toolCall := ToolCall{
 			ID:   evt.ToolUseId,
 			Type: "function",
 			Function: ToolCallFunction{
 				Name:      evt.Name,
-				Arguments: inputStr, // 使用已转换的input参数
+				Arguments: arguments,
 			},
 		}
 
@@ -537,6 +558,9 @@ func (h *LegacyToolUseEventHandler) handleToolCallEvent(message *EventStreamMess
 		// 因此不应该通过聚合器处理，聚合器只处理后续的字符串片段
 
 		// 如果不是stop事件，说明后续还有数据片段，返回注册事件，等待后续片段
+		if fragment != "" {
+			events = append(events, h.toolInputDelta(evt, fragment)...)
+		}
 		return events, nil
 	}
 
@@ -549,6 +573,12 @@ func (h *LegacyToolUseEventHandler) handleToolCallEvent(message *EventStreamMess
 	// 场景2：stop信号无新数据 - 已有完整数据，stop事件不带新数据
 
 	if evt.Stop {
+		// stop事件携带的最后一个片段先按普通片段处理
+		var events []SSEEvent
+		if inputStr != "" && inputStr != "{}" {
+			events = h.toolInputDelta(evt, inputStr)
+		}
+
 		// 收到stop信号，需要完成聚合
 		// 🔥 关键：只传递空字符串，不传递"{}"，避免污染buffer
 		complete, fullInput := h.aggregator.ProcessToolData(evt.ToolUseId, evt.Name, "", evt.Stop, -1)
@@ -572,8 +602,9 @@ func (h *LegacyToolUseEventHandler) handleToolCallEvent(message *EventStreamMess
 				ToolCallID: evt.ToolUseId,
 				Result:     "Tool execution completed via toolUseEvent",
 			}
-			return h.toolManager.HandleToolCallResult(result), nil
+			return append(events, h.toolManager.HandleToolCallResult(result)...), nil
 		}
+		return events, nil
 	}
 
 	// 如果是空数据但不是stop，返回空事件
@@ -581,47 +612,51 @@ func (h *LegacyToolUseEventHandler) handleToolCallEvent(message *EventStreamMess
 		return []SSEEvent{}, nil
 	}
 
-	// 🔥 使用聚合器处理流式JSON片段
-	complete, _ := h.aggregator.ProcessToolData(evt.ToolUseId, evt.Name, inputStr, evt.Stop, -1)
+	return h.toolInputDelta(evt, inputStr), nil
+}
 
-	// 处理未完整数据时发送增量事件
-	if !complete {
-		// 如果有新的输入片段，发送参数增量事件
-		if inputStr != "" && inputStr != "{}" {
-			// 边界情况检查：确保工具ID有效
-			if evt.ToolUseId == "" {
-				logger.Warn("工具调用片段缺少有效的toolUseId，跳过增量事件发送",
-					logger.String("inputFragment", inputStr))
-				return []SSEEvent{}, nil
-			}
+// isPartialToolInput 判断input是否为不完整的参数片段（不能单独解析为JSON对象）
+func isPartialToolInput(input string) bool {
+	if input == "" || input == "{}" {
+		return false
+	}
+	var args map[string]any
+	return utils.FastUnmarshal([]byte(input), &args) != nil
+}
 
-			// 获取工具的块索引
-			toolIndex := h.toolManager.GetBlockIndex(evt.ToolUseId)
-			if toolIndex >= 0 {
-				return []SSEEvent{{
-					Event: "content_block_delta",
-					Data: map[string]any{
-						"type":  "content_block_delta",
-						"index": toolIndex,
-						"delta": map[string]any{
-							"type":         "input_json_delta",
-							"partial_json": inputStr,
-						},
-					},
-				}}, nil
-			} else {
-				// 工具未注册的边界情况（理论上不应该发生，因为上面已经检查过）
-				logger.Warn("尝试发送增量事件但工具未注册，可能存在时序问题",
-					logger.String("toolUseId", evt.ToolUseId),
-					logger.String("name", evt.Name),
-					logger.String("inputFragment", inputStr))
-			}
-		}
+// toolInputDelta 把非stop的参数片段交给聚合器，并生成对应的 input_json_delta 事件
+func (h *LegacyToolUseEventHandler) toolInputDelta(evt toolUseEvent, inputStr string) []SSEEvent {
+	// 🔥 使用聚合器处理流式JSON片段（此处均为非stop片段）
+	// EagerParseMode 下参数可能提前完成并已通过回调更新，该片段仍作为增量发送，工具块在stop信号到达时结束
+	h.aggregator.ProcessToolData(evt.ToolUseId, evt.Name, inputStr, false, -1)
 
-		// 无新数据或无法获取索引，返回空事件
-		return []SSEEvent{}, nil
+	// 边界情况检查：确保工具ID有效
+	if evt.ToolUseId == "" {
+		logger.Warn("工具调用片段缺少有效的toolUseId，跳过增量事件发送",
+			logger.String("inputFragment", inputStr))
+		return []SSEEvent{}
 	}
 
-	// 非stop事件的流式片段处理完成，返回空事件
-	return []SSEEvent{}, nil
+	// 获取工具的块索引，发送参数增量事件
+	toolIndex := h.toolManager.GetBlockIndex(evt.ToolUseId)
+	if toolIndex < 0 {
+		// 工具未注册的边界情况（理论上不应该发生，因为上面已经检查过）
+		logger.Warn("尝试发送增量事件但工具未注册，可能存在时序问题",
+			logger.String("toolUseId", evt.ToolUseId),
+			logger.String("name", evt.Name),
+			logger.String("inputFragment", inputStr))
+		return []SSEEvent{}
+	}
+
+	return []SSEEvent{{
+		Event: "content_block_delta",
+		Data: map[string]any{
+			"type":  "content_block_delta",
+			"index": toolIndex,
+			"delta": map[string]any{
+				"type":         "input_json_delta",
+				"partial_json": inputStr,
+			},
+		},
+	}}
 }
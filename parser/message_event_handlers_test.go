@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"kiro2api/utils"
 )
 
@@ -157,6 +158,40 @@ func TestLegacyToolUseEventHandler_StreamingFragments(t *testing.T) {
 	t.Log("✅ 流式分片数据场景测试通过")
 }
 
+// TestLegacyToolUseEventHandler_FragmentOnFirstAndStopEvents 首个事件和stop事件携带的参数片段都不能丢失
+func TestLegacyToolUseEventHandler_FragmentOnFirstAndStopEvents(t *testing.T) {
+	toolManager := NewToolLifecycleManager()
+	aggregator := NewSonicStreamingJSONAggregatorWithCallback(func(toolUseId string, fullParams string) {
+		toolManager.UpdateToolArgumentsFromJSON(toolUseId, fullParams)
+	})
+	handler := &LegacyToolUseEventHandler{toolManager: toolManager, aggregator: aggregator}
+
+	var partials []string
+	send := func(input any, stop bool) {
+		payload, err := utils.FastMarshal(toolUseEvent{Name: "get_forecast", ToolUseId: "fragment-tool", Input: input, Stop: stop})
+		require.NoError(t, err)
+		events, err := handler.handleToolCallEvent(&EventStreamMessage{Payload: payload})
+		require.NoError(t, err)
+		for _, event := range events {
+			data, _ := event.Data.(map[string]any)
+			if delta, ok := data["delta"].(map[string]any); ok && delta["type"] == "input_json_delta" {
+				partials = append(partials, delta["partial_json"].(string))
+			}
+		}
+	}
+
+	send(`{"city":`, false)
+	tool := toolManager.GetActiveTools()["fragment-tool"]
+	require.NotNil(t, tool)
+	assert.Empty(t, tool.Arguments, "不完整的首个片段不应被解析为参数")
+
+	send(`"Paris","days":3}`, true)
+	assert.Equal(t, []string{`{"city":`, `"Paris","days":3}`}, partials)
+	assert.Equal(t, map[string]any{"city": "Paris", "days": float64(3)}, tool.Arguments)
+	_, stillActive := toolManager.GetActiveTools()["fragment-tool"]
+	assert.False(t, stillActive)
+}
+
 // TestLegacyToolUseEventHandler_EmptyParameters 测试空参数工具调用
 func TestLegacyToolUseEventHandler_EmptyParameters(t *testing.T) {
 	toolManager := NewToolLifecycleManager()
@@ -0,0 +1,66 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"kiro2api/config"
+)
+
+// RecoveryBuffer 暂存跨 ParseStream 调用的不完整帧
+// TCP 可能在帧中间（包括多字节字符或JSON记号中间）断开，未收齐的字节保留到下一次调用，
+// 与新数据拼接后重新尝试解析，帧内的文本和JSON始终以完整帧为单位交给消息处理器
+type RecoveryBuffer struct {
+	buf       bytes.Buffer
+	carried   bool // 上一次调用留下了不完整的帧，下一个取出的帧由跨调用的数据拼接而成
+	recovered int  // 跨调用拼接后完整取出的帧数
+}
+
+// Append 在暂存数据之后追加新读到的数据
+func (b *RecoveryBuffer) Append(data []byte) {
+	b.carried = b.buf.Len() > 0
+	b.buf.Write(data)
+}
+
+// NextFrame 取出下一个完整帧，数据不足一个帧时返回nil并保留数据等待下一次调用
+// 帧长度不合法时丢弃1字节重新同步，invalid 返回true
+func (b *RecoveryBuffer) NextFrame() (frame []byte, invalid bool) {
+	data := b.buf.Bytes()
+	if len(data) < config.EventStreamMinMessageSize {
+		return nil, false
+	}
+
+	totalLength := binary.BigEndian.Uint32(data[:4])
+	if totalLength < config.EventStreamMinMessageSize || totalLength > config.EventStreamMaxMessageSize {
+		b.buf.Next(1)
+		return nil, true
+	}
+	if len(data) < int(totalLength) {
+		return nil, false
+	}
+
+	frame = make([]byte, totalLength)
+	copy(frame, b.buf.Next(int(totalLength)))
+	if b.carried {
+		b.recovered++
+		b.carried = false
+	}
+	return frame, false
+}
+
+// Len 暂存的不完整帧字节数
+func (b *RecoveryBuffer) Len() int {
+	return b.buf.Len()
+}
+
+// Recovered 跨调用拼接后完整取出的帧数
+func (b *RecoveryBuffer) Recovered() int {
+	return b.recovered
+}
+
+// Reset 丢弃暂存数据
+func (b *RecoveryBuffer) Reset() {
+	b.buf.Reset()
+	b.carried = false
+	b.recovered = 0
+}
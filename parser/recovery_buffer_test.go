@@ -0,0 +1,118 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+	"testing/iotest"
+
+	"kiro2api/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// capturedFrame 按上游格式构造带真实 prelude/message CRC 的帧
+func capturedFrame(t *testing.T, eventType string, payload map[string]any) []byte {
+	t.Helper()
+	body, err := utils.FastMarshal(payload)
+	require.NoError(t, err)
+
+	frame := buildEventStreamFrame(eventType, body)
+	binary.BigEndian.PutUint32(frame[8:12], crc32.ChecksumIEEE(frame[:8]))
+	binary.BigEndian.PutUint32(frame[len(frame)-4:], crc32.ChecksumIEEE(frame[:len(frame)-4]))
+	return frame
+}
+
+// capturedStream 还原一次带工具调用的上游响应：多字节文本、转义字符、分段的工具参数
+func capturedStream(t *testing.T) ([]byte, int) {
+	frames := [][]byte{
+		capturedFrame(t, EventTypes.ASSISTANT_RESPONSE_EVENT, map[string]any{"content": "我先看一下"}),
+		capturedFrame(t, EventTypes.ASSISTANT_RESPONSE_EVENT, map[string]any{"content": "配置文件 📄，路径是 \"config/app.yaml\"\n"}),
+		capturedFrame(t, EventTypes.TOOL_USE_EVENT, map[string]any{"toolUseId": "tooluse_AAAAAAAAAAAAAAAAAAAAAA", "name": "read_file", "input": `{"path": "config/`}),
+		capturedFrame(t, EventTypes.TOOL_USE_EVENT, map[string]any{"toolUseId": "tooluse_AAAAAAAAAAAAAAAAAAAAAA", "name": "read_file", "input": `app.yaml", "encoding": "utf-8"}`}),
+		capturedFrame(t, EventTypes.TOOL_USE_EVENT, map[string]any{"toolUseId": "tooluse_AAAAAAAAAAAAAAAAAAAAAA", "name": "read_file", "stop": true}),
+	}
+	return bytes.Join(frames, nil), len(frames)
+}
+
+// eventsJSON 序列化事件便于整体比较
+func eventsJSON(t *testing.T, events []SSEEvent) string {
+	t.Helper()
+	data, err := utils.FastMarshal(events)
+	require.NoError(t, err)
+	return string(data)
+}
+
+// TestParseStream_SplitAtEveryByte 在每个字节位置把流拆成两段，解析结果与整体解析一致
+func TestParseStream_SplitAtEveryByte(t *testing.T) {
+	stream, frameCount := capturedStream(t)
+	whole, err := NewCompliantEventStreamParser().ParseStream(stream)
+	require.NoError(t, err)
+	require.NotEmpty(t, whole)
+	expected := eventsJSON(t, whole)
+
+	for cut := 1; cut < len(stream); cut++ {
+		p := NewCompliantEventStreamParser()
+		first, err := p.ParseStream(stream[:cut])
+		require.NoError(t, err)
+		second, err := p.ParseStream(stream[cut:])
+		require.NoError(t, err)
+
+		require.Equal(t, expected, eventsJSON(t, append(first, second...)), "cut=%d", cut)
+		require.Zero(t, p.PendingBytes(), "cut=%d", cut)
+	}
+
+	t.Run("逐字节输入", func(t *testing.T) {
+		p := NewCompliantEventStreamParser()
+		var events []SSEEvent
+		for i := range stream {
+			parsed, err := p.ParseStream(stream[i : i+1])
+			require.NoError(t, err)
+			events = append(events, parsed...)
+		}
+		assert.Equal(t, expected, eventsJSON(t, events))
+		assert.Equal(t, frameCount, p.RecoveredFrames(), "每一帧都由跨调用的数据拼接而成")
+	})
+
+	t.Run("ParseReader 逐字节读取", func(t *testing.T) {
+		result, err := NewCompliantEventStreamParser().ParseReader(iotest.OneByteReader(bytes.NewReader(stream)))
+		require.NoError(t, err)
+		assert.Equal(t, expected, eventsJSON(t, result.Events))
+		assert.Empty(t, result.Errors)
+	})
+}
+
+func TestParseReader_TruncatedFinalFrame(t *testing.T) {
+	stream, _ := capturedStream(t)
+	truncated := stream[:len(stream)-7]
+
+	p := NewCompliantEventStreamParser()
+	result, err := p.ParseReader(bytes.NewReader(truncated))
+	require.NoError(t, err)
+	assert.NotEmpty(t, result.GetCompletionText())
+	require.Len(t, result.Errors, 1)
+	assert.Contains(t, result.Errors[0].Error(), "最后一帧不完整")
+	assert.Greater(t, p.PendingBytes(), 0)
+}
+
+func TestRecoveryBuffer_ResyncsAfterInvalidPrelude(t *testing.T) {
+	stream, _ := capturedStream(t)
+	var b RecoveryBuffer
+	b.Append([]byte{0xff, 0xff, 0xff, 0xff})
+	b.Append(stream)
+
+	skipped := 0
+	for {
+		frame, invalid := b.NextFrame()
+		if invalid {
+			skipped++
+			continue
+		}
+		require.NotNil(t, frame)
+		assert.Equal(t, stream[:len(frame)], frame)
+		break
+	}
+	assert.Equal(t, 4, skipped, "逐字节丢弃无效数据直到找到合法的帧头")
+}
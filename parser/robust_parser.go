@@ -1,7 +1,6 @@
 package parser
 
 import (
-	"bytes"
 	"encoding/binary"
 	"fmt"
 	"hash/crc32"
@@ -17,7 +16,7 @@ type RobustEventStreamParser struct {
 	errorCount   int
 	maxErrors    int
 	crcTable     *crc32.Table
-	buffer       *bytes.Buffer // 使用标准库bytes.Buffer替代RingBuffer
+	recovery     *RecoveryBuffer // 跨调用暂存的不完整帧
 	// 并发访问控制
 	mu sync.RWMutex // 保护并发访问
 }
@@ -28,7 +27,7 @@ func NewRobustEventStreamParser() *RobustEventStreamParser {
 		headerParser: NewHeaderParser(),
 		maxErrors:    config.ParserMaxErrors,
 		crcTable:     crc32.MakeTable(crc32.IEEE),
-		buffer:       &bytes.Buffer{},
+		recovery:     &RecoveryBuffer{},
 	}
 }
 
@@ -40,9 +39,7 @@ func (rp *RobustEventStreamParser) SetMaxErrors(maxErrors int) {
 // Reset 重置解析器状态
 func (rp *RobustEventStreamParser) Reset() {
 	rp.errorCount = 0
-	if rp.buffer != nil {
-		rp.buffer.Reset()
-	}
+	rp.recovery.Reset()
 }
 
 // ParseStream 解析流数据并返回消息
@@ -342,60 +339,24 @@ func (rp *RobustEventStreamParser) isValidToolUseIdFormat(toolUseId string) bool
 	return true
 }
 
-// parseStreamWithBuffer 使用bytes.Buffer解析流数据
+// parseStreamWithBuffer 把新数据拼接到上次暂存的不完整帧之后，解析其中所有完整的帧
 func (rp *RobustEventStreamParser) parseStreamWithBuffer(data []byte) ([]*EventStreamMessage, error) {
-	// 写入新数据到缓冲区
-	_, err := rp.buffer.Write(data)
-	if err != nil {
-		logger.Warn("写入缓冲区失败", logger.Err(err))
-		return nil, err
-	}
+	rp.recovery.Append(data)
 
 	messages := make([]*EventStreamMessage, 0, 8)
-
 	for {
-		// 查看可用数据
-		available := rp.buffer.Len()
-		if available < config.EventStreamMinMessageSize {
-			break
-		}
-
-		// 查看消息头（不移除数据）
-		bufferBytes := rp.buffer.Bytes()
-		if len(bufferBytes) < config.EventStreamMinMessageSize {
-			break
-		}
-
-		// 解析消息长度
-		totalLength := binary.BigEndian.Uint32(bufferBytes[:4])
-
-		// 验证长度合理性
-		if totalLength < config.EventStreamMinMessageSize || totalLength > config.EventStreamMaxMessageSize {
-			// 跳过无效数据（丢弃1字节）
-			rp.buffer.Next(1)
+		messageData, invalid := rp.recovery.NextFrame()
+		if invalid {
+			// 跳过无效数据（已丢弃1字节）
 			rp.errorCount++
-			logger.Warn("跳过无效消息头",
-				logger.Int("total_length", int(totalLength)))
+			logger.Warn("跳过无效消息头", logger.Int("pending_bytes", rp.recovery.Len()))
 			continue
 		}
-
-		// 检查是否有足够的数据
-		if available < int(totalLength) {
+		if messageData == nil {
 			// 等待更多数据
 			break
 		}
 
-		// 读取完整消息
-		messageData := make([]byte, totalLength)
-		n, err := rp.buffer.Read(messageData)
-		if err != nil || n != int(totalLength) {
-			logger.Error("读取消息失败",
-				logger.Int("expected", int(totalLength)),
-				logger.Int("actual", n),
-				logger.Err(err))
-			break
-		}
-
 		// 解析消息
 		message, _, err := rp.parseSingleMessageWithValidation(messageData)
 		if err != nil {
@@ -416,3 +377,17 @@ func (rp *RobustEventStreamParser) parseStreamWithBuffer(data []byte) ([]*EventS
 
 	return messages, nil
 }
+
+// PendingBytes 暂存的不完整帧字节数，流结束时仍不为0说明最后一帧被截断
+func (rp *RobustEventStreamParser) PendingBytes() int {
+	rp.mu.RLock()
+	defer rp.mu.RUnlock()
+	return rp.recovery.Len()
+}
+
+// RecoveredFrames 跨 ParseStream 调用拼接后完整解析的帧数
+func (rp *RobustEventStreamParser) RecoveredFrames() int {
+	rp.mu.RLock()
+	defer rp.mu.RUnlock()
+	return rp.recovery.Recovered()
+}
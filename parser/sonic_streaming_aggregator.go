@@ -15,12 +15,16 @@ type ToolParamsUpdateCallback func(toolUseId string, fullParams string)
 
 // AWS EventStream流式传输配置
 // 由于EventStream按字节边界分片传输，导致UTF-8字符截断，
-// 因此只在收到停止信号时进行JSON解析，避免解析损坏的片段
+// 因此默认只在收到停止信号时进行JSON解析，避免解析损坏的片段
 
 type SonicStreamingJSONAggregator struct {
 	activeStreamers map[string]*SonicJSONStreamer
 	mu              sync.RWMutex
 	updateCallback  ToolParamsUpdateCallback
+
+	// EagerParseMode 每个片段后增量检测JSON结构，顶层对象闭合且能完整解析时提前完成聚合（不等stop信号）
+	// 提前解析失败或闭合后又收到非空白内容时，该工具调用回退到缓冲模式
+	EagerParseMode bool
 }
 
 // SonicJSONStreamer 单个工具调用的Sonic流式解析器
@@ -35,6 +39,9 @@ type SonicJSONStreamer struct {
 	fragmentCount  int
 	totalBytes     int
 	incompleteUTF8 string // 用于存储跨片段的不完整UTF-8字符
+
+	eagerScanner *jsonCompletionScanner // EagerParseMode 下的结构跟踪，回退到缓冲模式后为nil
+	eagerInput   string                 // 提前完成时的工具输入，stop信号到达时直接使用
 }
 
 // SonicParseState Sonic JSON解析状态
@@ -80,11 +87,27 @@ func (ssja *SonicStreamingJSONAggregator) ProcessToolData(toolUseId, name, input
 	}
 
 	// AWS EventStream按字节边界分片传输，导致UTF-8中文字符截断问题
-	// 只有在收到停止信号时才进行最终解析，避免中途解析损坏的JSON片段
+	// 缓冲模式下只有在收到停止信号时才进行最终解析，避免中途解析损坏的JSON片段
 	if !stop {
+		if ssja.EagerParseMode && streamer.tryEagerComplete() {
+			ssja.onAggregationComplete(toolUseId, streamer.eagerInput)
+			logger.Debug("工具参数JSON提前完成",
+				logger.String("toolUseId", toolUseId),
+				logger.Int("fragmentCount", streamer.fragmentCount),
+				logger.Int("totalBytes", streamer.totalBytes))
+			return true, streamer.eagerInput
+		}
 		return false, ""
 	}
 
+	// 已提前完成：结果和回调都已生效，只需结束聚合
+	if streamer.eagerInput != "" {
+		fullInput = streamer.eagerInput
+		ssja.cleanupStreamer(streamer)
+		delete(ssja.activeStreamers, toolUseId)
+		return true, fullInput
+	}
+
 	// 收到停止信号，使用Sonic尝试解析当前缓冲区
 	parseResult := streamer.tryParseWithSonic()
 
@@ -155,13 +178,17 @@ func (ssja *SonicStreamingJSONAggregator) createSonicJSONStreamer(toolUseId, too
 	// 直接分配Buffer，Go GC会自动管理
 	buffer := bytes.NewBuffer(nil)
 
-	return &SonicJSONStreamer{
+	streamer := &SonicJSONStreamer{
 		toolUseId:  toolUseId,
 		toolName:   toolName,
 		buffer:     buffer,
 		lastUpdate: time.Now(),
 		result:     make(map[string]any),
 	}
+	if ssja.EagerParseMode {
+		streamer.eagerScanner = &jsonCompletionScanner{}
+	}
+	return streamer
 }
 
 // appendFragment 追加JSON片段
@@ -170,6 +197,9 @@ func (sjs *SonicJSONStreamer) appendFragment(fragment string) error {
 	safeFragment := sjs.ensureUTF8Integrity(fragment)
 
 	sjs.buffer.WriteString(safeFragment)
+	if sjs.eagerScanner != nil {
+		sjs.eagerScanner.feed(safeFragment)
+	}
 	sjs.lastUpdate = time.Now()
 	sjs.fragmentCount++
 	sjs.totalBytes += len(fragment) // 使用原始长度统计
@@ -285,6 +315,53 @@ func (sjs *SonicJSONStreamer) tryParseWithSonic() string {
 	return "invalid"
 }
 
+// tryEagerComplete 检测缓冲区中的JSON是否已完整，仅在首次检测到完整时返回true
+// 结构跟踪出错、完整解析失败或提前完成后又收到非空白内容时回退到缓冲模式，等待stop信号再解析
+func (sjs *SonicJSONStreamer) tryEagerComplete() bool {
+	scanner := sjs.eagerScanner
+	if scanner == nil {
+		return false
+	}
+
+	if scanner.invalid {
+		if sjs.eagerInput != "" {
+			logger.Warn("工具参数JSON提前完成后收到额外内容，回退到缓冲模式",
+				logger.String("toolUseId", sjs.toolUseId))
+		} else {
+			logger.Debug("工具参数JSON结构无法增量跟踪，回退到缓冲模式",
+				logger.String("toolUseId", sjs.toolUseId))
+		}
+		sjs.fallbackToBuffered()
+		return false
+	}
+	if !scanner.complete || sjs.eagerInput != "" {
+		return false
+	}
+
+	if sjs.tryParseWithSonic() != "complete" {
+		logger.Debug("工具参数JSON提前解析失败，回退到缓冲模式",
+			logger.String("toolUseId", sjs.toolUseId))
+		sjs.fallbackToBuffered()
+		return false
+	}
+
+	jsonBytes, err := utils.FastMarshal(sjs.result)
+	if err != nil {
+		sjs.fallbackToBuffered()
+		return false
+	}
+	sjs.eagerInput = string(jsonBytes)
+	return true
+}
+
+// fallbackToBuffered 放弃提前解析，stop信号到达时按缓冲模式重新解析
+func (sjs *SonicJSONStreamer) fallbackToBuffered() {
+	sjs.eagerScanner = nil
+	sjs.eagerInput = ""
+	sjs.state.hasValidJSON = false
+	sjs.result = make(map[string]any)
+}
+
 // onAggregationComplete 聚合完成回调
 func (ssja *SonicStreamingJSONAggregator) onAggregationComplete(toolUseId string, fullInput string) {
 	if ssja.updateCallback != nil {
@@ -1,13 +1,19 @@
 package parser
 
 import (
+	"kiro2api/config"
 	"kiro2api/logger"
 	"kiro2api/utils"
+	"sort"
+	"sync"
 	"time"
+	"unicode/utf8"
 )
 
 // ToolLifecycleManager 工具调用生命周期管理器
+// mu 保护内部状态：请求处理是单协程的，但关闭服务时会从其他协程生成快照
 type ToolLifecycleManager struct {
+	mu                 sync.Mutex
 	activeTools        map[string]*ToolExecution
 	completedTools     map[string]*ToolExecution
 	blockIndexMap      map[string]int
@@ -27,6 +33,9 @@ func NewToolLifecycleManager() *ToolLifecycleManager {
 
 // Reset 重置管理器状态
 func (tlm *ToolLifecycleManager) Reset() {
+	tlm.mu.Lock()
+	defer tlm.mu.Unlock()
+
 	tlm.activeTools = make(map[string]*ToolExecution)
 	tlm.completedTools = make(map[string]*ToolExecution)
 	tlm.blockIndexMap = make(map[string]int)
@@ -37,18 +46,15 @@ func (tlm *ToolLifecycleManager) Reset() {
 // HandleToolCallRequest 处理工具调用请求
 // HandleToolCallRequest 处理工具调用请求（增强参数验证）
 func (tlm *ToolLifecycleManager) HandleToolCallRequest(request ToolCallRequest) []SSEEvent {
+	tlm.mu.Lock()
+	defer tlm.mu.Unlock()
+
 	events := make([]SSEEvent, 0, len(request.ToolCalls)*3) // 调整预分配容量，包含文本介绍
 
-	// *** 关键修复：根据Claude规范，在第一个工具调用前自动生成文本介绍（index:0） ***
+	// 配置了 TOOL_INTRO_TEXT 时，在第一个工具调用前插入一次介绍文本（index:0）
 	if !tlm.textIntroGenerated && len(request.ToolCalls) > 0 {
-		// 生成符合Claude规范的文本介绍事件序列
-		textIntroEvents := tlm.generateTextIntroduction(request.ToolCalls[0])
-		events = append(events, textIntroEvents...)
+		events = append(events, tlm.generateTextIntroduction()...)
 		tlm.textIntroGenerated = true
-
-		// logger.Debug("自动生成工具调用文本介绍",
-		// 	logger.Int("intro_events", len(textIntroEvents)),
-		// 	logger.String("first_tool", request.ToolCalls[0].Function.Name))
 	}
 
 	for _, toolCall := range request.ToolCalls {
@@ -142,6 +148,9 @@ func (tlm *ToolLifecycleManager) HandleToolCallRequest(request ToolCallRequest)
 
 // HandleToolCallResult 处理工具调用结果
 func (tlm *ToolLifecycleManager) HandleToolCallResult(result ToolCallResult) []SSEEvent {
+	tlm.mu.Lock()
+	defer tlm.mu.Unlock()
+
 	events := make([]SSEEvent, 0, 1) // 调整预分配容量（只需要content_block_stop）
 
 	execution, exists := tlm.activeTools[result.ToolCallID]
@@ -176,6 +185,11 @@ func (tlm *ToolLifecycleManager) HandleToolCallResult(result ToolCallResult) []S
 		},
 	})
 
+	// 流式结果：关闭工具块后在独立的内容块中按片段下发结果JSON，不混入tool_use块的input
+	if result.StreamToolResult {
+		events = append(events, tlm.streamToolResultBlock(result.ToolCallID, result.Result)...)
+	}
+
 	// 移动到已完成工具列表
 	tlm.completedTools[result.ToolCallID] = execution
 	delete(tlm.activeTools, result.ToolCallID)
@@ -198,8 +212,95 @@ func (tlm *ToolLifecycleManager) HandleToolCallResult(result ToolCallResult) []S
 	return events
 }
 
+// toolResultBlockKey 工具结果块在 blockIndexMap 中的键，与工具调用本身的块索引区分
+func toolResultBlockKey(toolCallID string) string {
+	return toolCallID + "#result"
+}
+
+// streamToolResultBlock 为工具结果分配独立的内容块：start、若干 input_json_delta 片段、stop
+// 字符串结果原样切分，其他类型先序列化为JSON；切分点对齐UTF-8字符边界
+func (tlm *ToolLifecycleManager) streamToolResultBlock(toolCallID string, result any) []SSEEvent {
+	var payload string
+	switch v := result.(type) {
+	case nil:
+		return nil
+	case string:
+		payload = v
+	default:
+		data, err := utils.FastMarshal(v)
+		if err != nil {
+			logger.Warn("工具结果序列化失败，跳过流式输出", logger.Err(err))
+			return nil
+		}
+		payload = string(data)
+	}
+	if payload == "" {
+		return nil
+	}
+
+	blockIndex := tlm.getOrAssignBlockIndex(toolResultBlockKey(toolCallID))
+	chunks := splitUTF8(payload, config.ToolResultStreamChunkBytes)
+	events := make([]SSEEvent, 0, len(chunks)+2)
+	events = append(events, SSEEvent{
+		Event: "content_block_start",
+		Data: map[string]any{
+			"type":  "content_block_start",
+			"index": blockIndex,
+			"content_block": map[string]any{
+				"type":        "tool_result",
+				"tool_use_id": toolCallID,
+				"content":     "",
+			},
+		},
+	})
+	for _, chunk := range chunks {
+		events = append(events, SSEEvent{
+			Event: "content_block_delta",
+			Data: map[string]any{
+				"type":  "content_block_delta",
+				"index": blockIndex,
+				"delta": map[string]any{
+					"type":         "input_json_delta",
+					"partial_json": chunk,
+				},
+			},
+		})
+	}
+	events = append(events, SSEEvent{
+		Event: "content_block_stop",
+		Data: map[string]any{
+			"type":  "content_block_stop",
+			"index": blockIndex,
+		},
+	})
+	return events
+}
+
+// splitUTF8 按最多 size 字节切分字符串，不会切开多字节字符
+func splitUTF8(s string, size int) []string {
+	if size < utf8.UTFMax {
+		size = utf8.UTFMax
+	}
+	var chunks []string
+	for len(s) > size {
+		end := size
+		for end > 0 && !utf8.RuneStart(s[end]) {
+			end--
+		}
+		chunks = append(chunks, s[:end])
+		s = s[end:]
+	}
+	if s != "" {
+		chunks = append(chunks, s)
+	}
+	return chunks
+}
+
 // HandleToolCallError 处理工具调用错误
 func (tlm *ToolLifecycleManager) HandleToolCallError(errorInfo ToolCallError) []SSEEvent {
+	tlm.mu.Lock()
+	defer tlm.mu.Unlock()
+
 	events := make([]SSEEvent, 0, 2) // 调整预分配容量（error + content_block_stop）
 
 	execution, exists := tlm.activeTools[errorInfo.ToolCallID]
@@ -270,6 +371,9 @@ func (tlm *ToolLifecycleManager) HandleToolCallError(errorInfo ToolCallError) []
 
 // GetToolExecution 获取工具执行信息
 func (tlm *ToolLifecycleManager) GetToolExecution(toolID string) *ToolExecution {
+	tlm.mu.Lock()
+	defer tlm.mu.Unlock()
+
 	if tool, exists := tlm.activeTools[toolID]; exists {
 		return tool
 	}
@@ -281,6 +385,9 @@ func (tlm *ToolLifecycleManager) GetToolExecution(toolID string) *ToolExecution
 
 // GetActiveTools 获取所有活跃的工具
 func (tlm *ToolLifecycleManager) GetActiveTools() map[string]*ToolExecution {
+	tlm.mu.Lock()
+	defer tlm.mu.Unlock()
+
 	result := make(map[string]*ToolExecution)
 	for id, tool := range tlm.activeTools {
 		result[id] = tool
@@ -290,6 +397,9 @@ func (tlm *ToolLifecycleManager) GetActiveTools() map[string]*ToolExecution {
 
 // GetCompletedTools 获取所有已完成的工具
 func (tlm *ToolLifecycleManager) GetCompletedTools() map[string]*ToolExecution {
+	tlm.mu.Lock()
+	defer tlm.mu.Unlock()
+
 	result := make(map[string]*ToolExecution)
 	for id, tool := range tlm.completedTools {
 		result[id] = tool
@@ -297,6 +407,46 @@ func (tlm *ToolLifecycleManager) GetCompletedTools() map[string]*ToolExecution {
 	return result
 }
 
+// GetCompletedToolsOrdered 获取所有已完成的工具，按BlockIndex（即上游发出顺序）排序
+func (tlm *ToolLifecycleManager) GetCompletedToolsOrdered() []*ToolExecution {
+	tlm.mu.Lock()
+	defer tlm.mu.Unlock()
+
+	return SortToolsByBlockIndex(tlm.completedTools)
+}
+
+// GetAllToolsOrdered 获取活跃与已完成的全部工具，按BlockIndex排序
+func (tlm *ToolLifecycleManager) GetAllToolsOrdered() []*ToolExecution {
+	tlm.mu.Lock()
+	defer tlm.mu.Unlock()
+
+	return SortToolsByBlockIndex(tlm.activeTools, tlm.completedTools)
+}
+
+// SortToolsByBlockIndex 合并多个工具集合并按BlockIndex排序，保证与上游发出顺序一致
+// 避免map遍历顺序导致并行工具调用的顺序在每次请求间随机变化
+func SortToolsByBlockIndex(toolSets ...map[string]*ToolExecution) []*ToolExecution {
+	total := 0
+	for _, set := range toolSets {
+		total += len(set)
+	}
+
+	tools := make([]*ToolExecution, 0, total)
+	for _, set := range toolSets {
+		for _, tool := range set {
+			tools = append(tools, tool)
+		}
+	}
+
+	sort.SliceStable(tools, func(i, j int) bool {
+		if tools[i].BlockIndex != tools[j].BlockIndex {
+			return tools[i].BlockIndex < tools[j].BlockIndex
+		}
+		return tools[i].ID < tools[j].ID
+	})
+	return tools
+}
+
 // getOrAssignBlockIndex 获取或分配块索引
 func (tlm *ToolLifecycleManager) getOrAssignBlockIndex(toolID string) int {
 	if index, exists := tlm.blockIndexMap[toolID]; exists {
@@ -311,32 +461,23 @@ func (tlm *ToolLifecycleManager) getOrAssignBlockIndex(toolID string) int {
 
 // GetBlockIndex 获取工具的块索引
 func (tlm *ToolLifecycleManager) GetBlockIndex(toolID string) int {
+	tlm.mu.Lock()
+	defer tlm.mu.Unlock()
+
 	if index, exists := tlm.blockIndexMap[toolID]; exists {
 		return index
 	}
 	return -1
 }
 
-// generateTextIntroduction 生成符合Claude规范的文本介绍事件序列
-// 根据Claude官方示例，工具调用前应有文本介绍，如："Okay, let's check the weather for San Francisco, CA:"
-func (tlm *ToolLifecycleManager) generateTextIntroduction(firstTool ToolCall) []SSEEvent {
-	// 根据工具类型生成合适的介绍文本
-	introText := tlm.generateIntroText(firstTool.Function.Name)
+// generateTextIntroduction 生成工具调用前的介绍文本事件，未配置 TOOL_INTRO_TEXT 时不生成任何事件
+// 只发送 index:0 的 content_block_delta：文本块由 SSEStateManager 按需启动，并在第一个工具块启动前自动关闭
+func (tlm *ToolLifecycleManager) generateTextIntroduction() []SSEEvent {
+	introText := config.ToolIntroText()
+	if introText == "" {
+		return nil
+	}
 
-	// 修复：删除重复的content_block_start和content_block_stop
-	// 原因：block[0]已在sendInitialEvents()中启动（handlers.go:148-156），会在sendFinalEvents()中关闭
-	// 这里只需要发送content_block_delta来添加介绍文本即可
-	//
-	// 之前的问题：
-	// 1. sendInitialEvents发送content_block_start(index:0) → SSEStateManager标记block[0].Started=true
-	// 2. generateTextIntroduction再次发送content_block_start(index:0) → 违规！block已started但未stopped
-	// 3. generateTextIntroduction发送content_block_stop(index:0) → 过早关闭，与后续工具调用冲突
-	//
-	// 修复后的事件序列：
-	// 1. sendInitialEvents: content_block_start(index:0) ← 初始化文本块
-	// 2. generateTextIntroduction: content_block_delta(index:0) ← 添加介绍文本
-	// 3. [工具调用处理]: content_block_start(index:1), content_block_stop(index:1), ...
-	// 4. sendFinalEvents: content_block_stop(index:0) ← 关闭文本块
 	return []SSEEvent{
 		{
 			Event: "content_block_delta",
@@ -352,23 +493,11 @@ func (tlm *ToolLifecycleManager) generateTextIntroduction(firstTool ToolCall) []
 	}
 }
 
-// generateIntroText 根据工具类型生成合适的介绍文本
-func (tlm *ToolLifecycleManager) generateIntroText(_ string) string {
-	//switch strings.ToLower(toolName) {
-	//case "search", "web_search":
-	//	return "让我为您搜索相关信息。"
-	//case "calculator", "calc":
-	//	return "让我为您进行计算。"
-	//case "todowrite":
-	//	return "让我为您更新任务列表。"
-	//default:
-	//	return fmt.Sprintf("让我使用%s工具来帮助您。", toolName)
-	//}
-	return ""
-}
-
 // GenerateToolSummary 生成工具执行摘要
 func (tlm *ToolLifecycleManager) GenerateToolSummary() map[string]any {
+	tlm.mu.Lock()
+	defer tlm.mu.Unlock()
+
 	activeCount := len(tlm.activeTools)
 	completedCount := len(tlm.completedTools)
 	errorCount := 0
@@ -394,6 +523,9 @@ func (tlm *ToolLifecycleManager) GenerateToolSummary() map[string]any {
 
 // UpdateToolArguments 更新工具调用的参数
 func (tlm *ToolLifecycleManager) UpdateToolArguments(toolID string, arguments map[string]any) {
+	tlm.mu.Lock()
+	defer tlm.mu.Unlock()
+
 	// logger.Debug("更新工具调用参数",
 	// 	logger.String("tool_id", toolID),
 	// 	logger.Any("arguments", arguments))
@@ -0,0 +1,190 @@
+package parser
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"kiro2api/config"
+	"kiro2api/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// buildEventStreamFrame 构造AWS EventStream二进制帧（测试用，CRC置零，解析器不校验）
+func buildEventStreamFrame(eventType string, payload []byte) []byte {
+	headers := buildSimpleStringHeader(":message-type", MessageTypes.EVENT)
+	headers = append(headers, buildSimpleStringHeader(":event-type", eventType)...)
+	headers = append(headers, buildSimpleStringHeader(":content-type", "application/json")...)
+
+	totalLength := 12 + len(headers) + len(payload) + 4
+	frame := make([]byte, 12, totalLength)
+	binary.BigEndian.PutUint32(frame[0:4], uint32(totalLength))
+	binary.BigEndian.PutUint32(frame[4:8], uint32(len(headers)))
+	frame = append(frame, headers...)
+	frame = append(frame, payload...)
+	frame = append(frame, 0, 0, 0, 0)
+	return frame
+}
+
+// TestGetToolCalls_StableOrderAcrossRuns 测试并行工具调用在非流式聚合结果中保持发出顺序
+func TestGetToolCalls_StableOrderAcrossRuns(t *testing.T) {
+	expectedIDs := []string{
+		"tooluse_AAAAAAAAAAAAAAAAAAAAAA",
+		"tooluse_BBBBBBBBBBBBBBBBBBBBBB",
+		"tooluse_CCCCCCCCCCCCCCCCCCCCCC",
+	}
+	expectedNames := []string{"read_file", "list_dir", "grep_search"}
+
+	var stream []byte
+	for i, id := range expectedIDs {
+		payload, err := utils.FastMarshal(map[string]any{
+			"name":      expectedNames[i],
+			"toolUseId": id,
+			"input":     map[string]any{"arg": fmt.Sprintf("value-%d", i)},
+			"stop":      true,
+		})
+		require.NoError(t, err)
+		stream = append(stream, buildEventStreamFrame(EventTypes.TOOL_USE_EVENT, payload)...)
+	}
+
+	for run := 0; run < 100; run++ {
+		result, err := NewCompliantEventStreamParser().ParseResponse(stream)
+		require.NoError(t, err)
+
+		tools := result.GetToolCalls()
+		require.Len(t, tools, len(expectedIDs), "run %d", run)
+		for i, tool := range tools {
+			assert.Equal(t, expectedIDs[i], tool.ID, "run %d: tool_use id应保持原样且顺序稳定", run)
+			assert.Equal(t, expectedNames[i], tool.Name, "run %d", run)
+			assert.Equal(t, i+1, tool.BlockIndex, "run %d", run)
+		}
+	}
+}
+
+func TestSortToolsByBlockIndex(t *testing.T) {
+	active := map[string]*ToolExecution{
+		"c": {ID: "c", BlockIndex: 3},
+	}
+	completed := map[string]*ToolExecution{
+		"b": {ID: "b", BlockIndex: 2},
+		"a": {ID: "a", BlockIndex: 1},
+	}
+
+	tools := SortToolsByBlockIndex(active, completed)
+	require.Len(t, tools, 3)
+	assert.Equal(t, "a", tools[0].ID)
+	assert.Equal(t, "b", tools[1].ID)
+	assert.Equal(t, "c", tools[2].ID)
+}
+
+// collectResultDeltas 收集指定块的 input_json_delta 片段，并返回最后一个事件的类型
+func collectResultDeltas(t *testing.T, events []SSEEvent, blockIndex int) ([]string, string) {
+	t.Helper()
+	var fragments []string
+	for _, event := range events {
+		if event.Event != "content_block_delta" {
+			continue
+		}
+		data := event.Data.(map[string]any)
+		delta := data["delta"].(map[string]any)
+		if delta["type"] != "input_json_delta" || data["index"] != blockIndex {
+			continue
+		}
+		fragments = append(fragments, delta["partial_json"].(string))
+	}
+	if len(events) == 0 {
+		return fragments, ""
+	}
+	return fragments, events[len(events)-1].Event
+}
+
+func TestHandleToolCallResult_StreamToolResult(t *testing.T) {
+	tlm := NewToolLifecycleManager()
+	tlm.HandleToolCallRequest(ToolCallRequest{
+		ToolCalls: []ToolCall{{ID: "tooluse_query", Type: "function", Function: ToolCallFunction{Name: "db_query", Arguments: `{}`}}},
+	})
+	blockIndex := tlm.GetBlockIndex("tooluse_query")
+
+	rows := make([]map[string]any, 2000)
+	for i := range rows {
+		rows[i] = map[string]any{"id": i, "name": fmt.Sprintf("用户-%d", i)}
+	}
+	expected, err := utils.FastMarshal(map[string]any{"rows": rows})
+	require.NoError(t, err)
+
+	events := tlm.HandleToolCallResult(ToolCallResult{
+		ToolCallID:       "tooluse_query",
+		Result:           map[string]any{"rows": rows},
+		StreamToolResult: true,
+	})
+
+	toolFragments, _ := collectResultDeltas(t, events, blockIndex)
+	assert.Empty(t, toolFragments, "结果片段不能写入tool_use块的input")
+
+	resultIndex := tlm.GetBlockIndex(toolResultBlockKey("tooluse_query"))
+	require.NotEqual(t, blockIndex, resultIndex, "结果应使用独立的内容块")
+	require.GreaterOrEqual(t, len(events), 3)
+	assert.Equal(t, "content_block_stop", events[0].Event, "先关闭工具块")
+	assert.Equal(t, blockIndex, events[0].Data.(map[string]any)["index"])
+	start := events[1].Data.(map[string]any)
+	assert.Equal(t, "content_block_start", events[1].Event)
+	assert.Equal(t, resultIndex, start["index"])
+	assert.Equal(t, "tool_result", start["content_block"].(map[string]any)["type"])
+	assert.Equal(t, "tooluse_query", start["content_block"].(map[string]any)["tool_use_id"])
+
+	fragments, last := collectResultDeltas(t, events, resultIndex)
+	assert.Equal(t, "content_block_stop", last, "结果块以stop结束")
+	assert.Equal(t, resultIndex, events[len(events)-1].Data.(map[string]any)["index"])
+	require.Greater(t, len(fragments), 1, "大结果应拆分为多个增量片段")
+	for i, fragment := range fragments {
+		assert.LessOrEqual(t, len(fragment), config.ToolResultStreamChunkBytes, "片段 %d 超出大小上限", i)
+		assert.True(t, utf8.ValidString(fragment), "片段 %d 切开了多字节字符", i)
+	}
+	assert.Equal(t, string(expected), strings.Join(fragments, ""))
+}
+
+func TestHandleToolCallResult_NonStreamingEmitsOnlyStop(t *testing.T) {
+	tlm := NewToolLifecycleManager()
+	tlm.HandleToolCallRequest(ToolCallRequest{
+		ToolCalls: []ToolCall{{ID: "tooluse_small", Type: "function", Function: ToolCallFunction{Name: "read_file", Arguments: `{}`}}},
+	})
+
+	events := tlm.HandleToolCallResult(ToolCallResult{ToolCallID: "tooluse_small", Result: map[string]any{"ok": true}})
+	require.Len(t, events, 1)
+	assert.Equal(t, "content_block_stop", events[0].Event)
+}
+
+// TestStreamingToolResult_ProgressiveDelivery 通过解析器逐帧输入，验证结果事件在结果帧到达时即按片段产出
+func TestStreamingToolResult_ProgressiveDelivery(t *testing.T) {
+	requestPayload, err := utils.FastMarshal(map[string]any{"toolCallId": "tooluse_mcp", "toolName": "mcp_search", "input": map[string]any{"q": "all"}})
+	require.NoError(t, err)
+	result := strings.Repeat("x", config.ToolResultStreamChunkBytes*2+10)
+	resultPayload, err := utils.FastMarshal(ToolCallResult{ToolCallID: "tooluse_mcp", Result: result, StreamToolResult: true})
+	require.NoError(t, err)
+
+	parser := NewCompliantEventStreamParser()
+	requestEvents, err := parser.ParseStream(buildEventStreamFrame(EventTypes.TOOL_CALL_REQUEST, requestPayload))
+	require.NoError(t, err)
+	fragments, _ := collectResultDeltas(t, requestEvents, 1)
+	require.Len(t, fragments, 1, "请求帧只包含工具参数")
+	assert.Equal(t, `{"q":"all"}`, fragments[0])
+
+	resultEvents, err := parser.ParseStream(buildEventStreamFrame(EventTypes.TOOL_CALL_RESULT, resultPayload))
+	require.NoError(t, err)
+	toolFragments, _ := collectResultDeltas(t, resultEvents, 1)
+	assert.Empty(t, toolFragments, "结果片段不能写入tool_use块")
+	fragments, last := collectResultDeltas(t, resultEvents, 2)
+	assert.Equal(t, []string{result[:config.ToolResultStreamChunkBytes], result[config.ToolResultStreamChunkBytes : 2*config.ToolResultStreamChunkBytes], "xxxxxxxxxx"}, fragments)
+	assert.Equal(t, "content_block_stop", last)
+}
+
+func TestSplitUTF8(t *testing.T) {
+	assert.Nil(t, splitUTF8("", 8))
+	assert.Equal(t, []string{"abcd", "ef"}, splitUTF8("abcdef", 4))
+	// "中" 占3字节，切分点落在字符中间时回退到字符起始位置
+	assert.Equal(t, []string{"a中", "文"}, splitUTF8("a中文", 5))
+}
@@ -0,0 +1,295 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"kiro2api/logger"
+	"kiro2api/utils"
+)
+
+// toolLifecycleSnapshot ToolLifecycleManager的可序列化状态
+type toolLifecycleSnapshot struct {
+	ActiveTools        map[string]*ToolExecution `json:"active_tools"`
+	CompletedTools     map[string]*ToolExecution `json:"completed_tools"`
+	TextIntroGenerated bool                      `json:"text_intro_generated"`
+}
+
+// Snapshot 将活跃与已完成的工具状态序列化为JSON
+// 序列化失败时返回nil（工具参数来自上游JSON，正常情况下不会失败）
+func (tlm *ToolLifecycleManager) Snapshot() []byte {
+	tlm.mu.Lock()
+	defer tlm.mu.Unlock()
+
+	data, err := utils.SafeMarshal(toolLifecycleSnapshot{
+		ActiveTools:        tlm.activeTools,
+		CompletedTools:     tlm.completedTools,
+		TextIntroGenerated: tlm.textIntroGenerated,
+	})
+	if err != nil {
+		logger.Warn("序列化工具状态失败", logger.Err(err))
+		return nil
+	}
+	return data
+}
+
+// Restore 从Snapshot生成的JSON恢复工具状态，覆盖当前状态
+// 缺失字段按安全默认值补齐：ID取map键、StartTime取当前时间、参数为空对象、缺失的块索引重新分配
+func (tlm *ToolLifecycleManager) Restore(data []byte) error {
+	var snapshot toolLifecycleSnapshot
+	if err := utils.SafeUnmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("解析工具状态失败: %w", err)
+	}
+
+	tlm.mu.Lock()
+	defer tlm.mu.Unlock()
+
+	tlm.activeTools = make(map[string]*ToolExecution)
+	tlm.completedTools = make(map[string]*ToolExecution)
+	tlm.blockIndexMap = make(map[string]int)
+	tlm.nextBlockIndex = 1
+	tlm.textIntroGenerated = snapshot.TextIntroGenerated
+
+	active := normalizeRestoredTools(snapshot.ActiveTools)
+	completed := normalizeRestoredTools(snapshot.CompletedTools)
+
+	// 先登记已有的块索引，保证新分配的索引不会与之冲突
+	for _, tool := range SortToolsByBlockIndex(active, completed) {
+		if tool.BlockIndex <= 0 {
+			continue
+		}
+		tlm.blockIndexMap[tool.ID] = tool.BlockIndex
+		if tool.BlockIndex >= tlm.nextBlockIndex {
+			tlm.nextBlockIndex = tool.BlockIndex + 1
+		}
+	}
+	for _, tool := range SortToolsByBlockIndex(active, completed) {
+		if tool.BlockIndex <= 0 {
+			tool.BlockIndex = tlm.getOrAssignBlockIndex(tool.ID)
+		}
+	}
+
+	tlm.activeTools = active
+	tlm.completedTools = completed
+	return nil
+}
+
+// normalizeRestoredTools 补齐恢复后工具记录的缺失字段，丢弃空记录
+func normalizeRestoredTools(tools map[string]*ToolExecution) map[string]*ToolExecution {
+	result := make(map[string]*ToolExecution, len(tools))
+	for id, tool := range tools {
+		if tool == nil || id == "" {
+			continue
+		}
+		if tool.ID == "" {
+			tool.ID = id
+		}
+		if tool.StartTime.IsZero() {
+			tool.StartTime = time.Now()
+		}
+		if tool.Arguments == nil {
+			tool.Arguments = make(map[string]any)
+		}
+		result[id] = tool
+	}
+	return result
+}
+
+// maxTrackedToolManagers 注册表最多跟踪的管理器数量，超出时淘汰最早登记的（请求未正常注销时防止无限增长）
+const maxTrackedToolManagers = 1024
+
+// ToolStateRegistry 跟踪进行中的工具生命周期管理器，用于服务重启前后保存/恢复工具状态
+type ToolStateRegistry struct {
+	mu        sync.Mutex
+	managers  map[*ToolLifecycleManager]uint64 // 管理器 -> 登记序号
+	nextSeq   uint64
+	recovered *ToolLifecycleManager
+}
+
+// NewToolStateRegistry 创建工具状态注册表
+func NewToolStateRegistry() *ToolStateRegistry {
+	return &ToolStateRegistry{managers: make(map[*ToolLifecycleManager]uint64)}
+}
+
+var defaultToolStateRegistry = NewToolStateRegistry()
+
+// DefaultToolStateRegistry 返回全局工具状态注册表
+func DefaultToolStateRegistry() *ToolStateRegistry {
+	return defaultToolStateRegistry
+}
+
+// Track 登记一个进行中的管理器，超过 maxTrackedToolManagers 时淘汰最早登记的
+func (r *ToolStateRegistry) Track(tlm *ToolLifecycleManager) {
+	if tlm == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.managers[tlm]; !exists && len(r.managers) >= maxTrackedToolManagers {
+		var oldest *ToolLifecycleManager
+		var oldestSeq uint64
+		for m, seq := range r.managers {
+			if oldest == nil || seq < oldestSeq {
+				oldest, oldestSeq = m, seq
+			}
+		}
+		delete(r.managers, oldest)
+		logger.Warn("跟踪的工具状态过多，淘汰最早登记的管理器", logger.Int("limit", maxTrackedToolManagers))
+	}
+	r.nextSeq++
+	r.managers[tlm] = r.nextSeq
+}
+
+// Untrack 请求结束后注销管理器
+func (r *ToolStateRegistry) Untrack(tlm *ToolLifecycleManager) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.managers, tlm)
+}
+
+// Recovered 返回启动时恢复、尚未被后续请求处理的工具状态（未恢复或已全部处理时为nil）
+func (r *ToolStateRegistry) Recovered() *ToolLifecycleManager {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.recovered
+}
+
+// ResolveRecovered 客户端在新请求中提交了恢复状态中工具的 tool_result，说明客户端已收到并执行了这些工具：
+// 从恢复状态中移除并返回对应记录；全部处理完后恢复状态清空，不再保存到 TOOL_STATE_FILE
+func (r *ToolStateRegistry) ResolveRecovered(toolUseIDs []string) []*ToolExecution {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.recovered == nil || len(toolUseIDs) == 0 {
+		return nil
+	}
+
+	var resolved []*ToolExecution
+	for _, id := range toolUseIDs {
+		if tool := r.recovered.forget(id); tool != nil {
+			resolved = append(resolved, tool)
+		}
+	}
+	if len(r.recovered.GetActiveTools()) == 0 && len(r.recovered.GetCompletedTools()) == 0 {
+		r.recovered = nil
+	}
+	return resolved
+}
+
+// forget 从管理器中移除工具记录，返回被移除的记录
+func (tlm *ToolLifecycleManager) forget(toolID string) *ToolExecution {
+	tlm.mu.Lock()
+	defer tlm.mu.Unlock()
+
+	tool := tlm.activeTools[toolID]
+	if tool == nil {
+		tool = tlm.completedTools[toolID]
+	}
+	delete(tlm.activeTools, toolID)
+	delete(tlm.completedTools, toolID)
+	delete(tlm.blockIndexMap, toolID)
+	return tool
+}
+
+// SaveToFile 合并所有进行中（及启动时恢复但尚未完成）的工具状态并写入文件
+func (r *ToolStateRegistry) SaveToFile(path string) error {
+	r.mu.Lock()
+	managers := make([]*ToolLifecycleManager, 0, len(r.managers)+1)
+	for tlm := range r.managers {
+		managers = append(managers, tlm)
+	}
+	if r.recovered != nil {
+		managers = append(managers, r.recovered)
+	}
+	r.mu.Unlock()
+
+	merged := toolLifecycleSnapshot{
+		ActiveTools:    make(map[string]*ToolExecution),
+		CompletedTools: make(map[string]*ToolExecution),
+	}
+	for _, tlm := range managers {
+		var snapshot toolLifecycleSnapshot
+		if err := utils.SafeUnmarshal(tlm.Snapshot(), &snapshot); err != nil {
+			continue
+		}
+		for id, tool := range snapshot.ActiveTools {
+			merged.ActiveTools[id] = tool
+		}
+		for id, tool := range snapshot.CompletedTools {
+			merged.CompletedTools[id] = tool
+		}
+	}
+
+	data, err := utils.SafeMarshal(merged)
+	if err != nil {
+		return fmt.Errorf("序列化工具状态失败: %w", err)
+	}
+
+	if err := writeFileAtomic(path, data); err != nil {
+		return err
+	}
+
+	logger.Info("工具状态已保存",
+		logger.String("path", path),
+		logger.Int("active_tools", len(merged.ActiveTools)),
+		logger.Int("completed_tools", len(merged.CompletedTools)))
+	return nil
+}
+
+// LoadFromFile 从文件恢复工具状态；文件不存在时视为无状态，不返回错误
+func (r *ToolStateRegistry) LoadFromFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("读取工具状态文件失败: %w", err)
+	}
+
+	recovered := NewToolLifecycleManager()
+	if err := recovered.Restore(data); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.recovered = recovered
+	r.mu.Unlock()
+
+	logger.Info("已恢复工具状态",
+		logger.String("path", path),
+		logger.Int("active_tools", len(recovered.GetActiveTools())),
+		logger.Int("completed_tools", len(recovered.GetCompletedTools())))
+	return nil
+}
+
+// writeFileAtomic 先写入同目录的临时文件再重命名，关闭过程中被中断也不会留下不完整的状态文件
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("创建工具状态目录失败: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("创建工具状态临时文件失败: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("写入工具状态文件失败: %w", err)
+	}
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("设置工具状态文件权限失败: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("写入工具状态文件失败: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("替换工具状态文件失败: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestToolLifecycleManager_RestoreThenComplete 测试恢复后的工具调用可以正常完成
+func TestToolLifecycleManager_RestoreThenComplete(t *testing.T) {
+	original := NewToolLifecycleManager()
+	original.HandleToolCallRequest(ToolCallRequest{
+		ToolCalls: []ToolCall{
+			{ID: "tooluse_first", Type: "function", Function: ToolCallFunction{Name: "read_file", Arguments: `{"path":"a.go"}`}},
+			{ID: "tooluse_second", Type: "function", Function: ToolCallFunction{Name: "list_dir", Arguments: `{}`}},
+		},
+	})
+	original.HandleToolCallResult(ToolCallResult{ToolCallID: "tooluse_first", Result: "ok"})
+
+	snapshot := original.Snapshot()
+	require.NotEmpty(t, snapshot)
+
+	restored := NewToolLifecycleManager()
+	require.NoError(t, restored.Restore(snapshot))
+
+	assert.Contains(t, restored.GetActiveTools(), "tooluse_second")
+	assert.Contains(t, restored.GetCompletedTools(), "tooluse_first")
+	assert.Equal(t, "a.go", restored.GetCompletedTools()["tooluse_first"].Arguments["path"])
+
+	events := restored.HandleToolCallResult(ToolCallResult{ToolCallID: "tooluse_second", Result: "done"})
+	require.Len(t, events, 1)
+	assert.Equal(t, "content_block_stop", events[0].Event)
+	assert.Equal(t, 2, events[0].Data.(map[string]any)["index"])
+	assert.Empty(t, restored.GetActiveTools())
+	assert.Equal(t, ToolStatusCompleted, restored.GetCompletedTools()["tooluse_second"].Status)
+
+	// 新的工具调用不应与恢复的块索引冲突
+	restored.HandleToolCallRequest(ToolCallRequest{
+		ToolCalls: []ToolCall{{ID: "tooluse_third", Type: "function", Function: ToolCallFunction{Name: "grep", Arguments: `{}`}}},
+	})
+	assert.Equal(t, 3, restored.GetBlockIndex("tooluse_third"))
+}
+
+// TestToolLifecycleManager_RestoreMissingFields 测试缺失字段按安全默认值补齐
+func TestToolLifecycleManager_RestoreMissingFields(t *testing.T) {
+	restored := NewToolLifecycleManager()
+	require.NoError(t, restored.Restore([]byte(`{"active_tools":{"tooluse_x":{"name":"read_file"},"tooluse_nil":null}}`)))
+
+	active := restored.GetActiveTools()
+	require.Len(t, active, 1)
+	tool := active["tooluse_x"]
+	assert.Equal(t, "tooluse_x", tool.ID)
+	assert.False(t, tool.StartTime.IsZero())
+	assert.NotNil(t, tool.Arguments)
+	assert.Equal(t, 1, tool.BlockIndex)
+	assert.Empty(t, restored.GetCompletedTools())
+
+	require.NoError(t, restored.Restore([]byte(`{}`)))
+	assert.Empty(t, restored.GetActiveTools())
+
+	assert.Error(t, restored.Restore([]byte(`not json`)))
+}
+
+func TestToolStateRegistry_SaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tool_state.json")
+
+	registry := NewToolStateRegistry()
+	assert.NoError(t, registry.LoadFromFile(path), "文件不存在时不应报错")
+	assert.Nil(t, registry.Recovered())
+
+	tlm := NewToolLifecycleManager()
+	tlm.HandleToolCallRequest(ToolCallRequest{
+		ToolCalls: []ToolCall{{ID: "tooluse_inflight", Type: "function", Function: ToolCallFunction{Name: "bash", Arguments: `{"cmd":"ls"}`}}},
+	})
+	registry.Track(tlm)
+	require.NoError(t, registry.SaveToFile(path))
+
+	loaded := NewToolStateRegistry()
+	require.NoError(t, loaded.LoadFromFile(path))
+	require.NotNil(t, loaded.Recovered())
+	assert.Contains(t, loaded.Recovered().GetActiveTools(), "tooluse_inflight")
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "临时文件重命名后不应残留")
+
+	// 客户端提交了恢复工具的结果：从恢复状态中移除，全部处理后不再保存
+	assert.Empty(t, loaded.ResolveRecovered([]string{"tooluse_other"}))
+	resolved := loaded.ResolveRecovered([]string{"tooluse_inflight"})
+	require.Len(t, resolved, 1)
+	assert.Equal(t, "bash", resolved[0].Name)
+	assert.Nil(t, loaded.Recovered())
+
+	require.NoError(t, loaded.SaveToFile(path))
+	reloaded := NewToolStateRegistry()
+	require.NoError(t, reloaded.LoadFromFile(path))
+	assert.Empty(t, reloaded.Recovered().GetActiveTools())
+}
+
+func TestToolStateRegistry_TrackIsBounded(t *testing.T) {
+	registry := NewToolStateRegistry()
+	first := NewToolLifecycleManager()
+	registry.Track(first)
+	for i := 0; i < maxTrackedToolManagers; i++ {
+		registry.Track(NewToolLifecycleManager())
+	}
+
+	assert.Len(t, registry.managers, maxTrackedToolManagers)
+	_, tracked := registry.managers[first]
+	assert.False(t, tracked, "超出上限时淘汰最早登记的管理器")
+}
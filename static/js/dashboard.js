@@ -698,7 +698,7 @@ class TokenDashboard {
         this.showConfirmModal({
             icon: '🗑️',
             title: '删除Token',
-            message: '确定要删除此Token吗？此操作不可恢复！',
+            message: '确定要删除此Token吗？删除后可在管理操作日志中找到其token_id并通过恢复接口撤销。',
             btnClass: 'danger',
             btnText: '确定删除',
             onConfirm: async () => {
@@ -1205,7 +1205,7 @@ class TokenDashboard {
     async exportConfig() {
         try {
             // 从API获取当前配置
-            const response = await fetch(`${this.apiBaseUrl}/tokens/export`);
+            const response = await fetch(`${this.apiBaseUrl}/tokens/export?include_secrets=true`);
             
             if (!response.ok) {
                 throw new Error('获取配置失败');
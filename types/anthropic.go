@@ -2,9 +2,15 @@ package types
 
 // AnthropicTool 表示 Anthropic API 的工具结构
 type AnthropicTool struct {
+	Type        string         `json:"type,omitempty"` // 普通工具为空或 "custom"；computer_use 为 "computer_20241022"
 	Name        string         `json:"name"`
 	Description string         `json:"description"`
 	InputSchema map[string]any `json:"input_schema"`
+
+	// computer_use 工具的屏幕参数
+	DisplayWidthPx  int  `json:"display_width_px,omitempty"`
+	DisplayHeightPx int  `json:"display_height_px,omitempty"`
+	DisplayNumber   *int `json:"display_number,omitempty"`
 }
 
 // ToolChoice 表示工具选择策略
@@ -13,6 +19,20 @@ type ToolChoice struct {
 	Name string `json:"name,omitempty"` // 当type为"tool"时指定的工具名称
 }
 
+// ResponseFormat 结构化输出约束：json_object 要求返回任意JSON对象，json_schema 要求返回符合 schema 的JSON
+type ResponseFormat struct {
+	Type       string            `json:"type"` // "text", "json_object", "json_schema"
+	JSONSchema *JSONSchemaFormat `json:"json_schema,omitempty"`
+}
+
+// JSONSchemaFormat response_format 中 json_schema 的定义
+type JSONSchemaFormat struct {
+	Name        string         `json:"name,omitempty"`
+	Description string         `json:"description,omitempty"`
+	Schema      map[string]any `json:"schema"`
+	Strict      *bool          `json:"strict,omitempty"`
+}
+
 // AnthropicRequest 表示 Anthropic API 的请求结构
 type AnthropicRequest struct {
 	Model       string                    `json:"model"`
@@ -24,6 +44,10 @@ type AnthropicRequest struct {
 	Stream      bool                      `json:"stream"`
 	Temperature *float64                  `json:"temperature,omitempty"`
 	Metadata    map[string]any            `json:"metadata,omitempty"`
+	// StopSequences 遇到其中任一字符串时停止生成，按 STOP_SEQUENCES_MODE 传给上游
+	StopSequences []string `json:"stop_sequences,omitempty"`
+	// ResponseFormat 结构化输出约束，非流式响应返回前按约束校验
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
 }
 
 // AnthropicStreamResponse 表示 Anthropic 流式响应的结构
@@ -47,11 +71,35 @@ type AnthropicStreamResponse struct {
 type AnthropicRequestMessage struct {
 	Role    string `json:"role"`
 	Content any    `json:"content"` // 可以是 string 或 []ContentBlock
+
+	clientIndex int // 在客户端请求 messages 中的下标加一，0 表示未标记（如代理生成的历史摘要）
+}
+
+// MarkClientIndexes 记录每条消息在客户端请求中的下标，历史修复和上下文裁剪之后仍能对应回客户端的 messages
+func (r *AnthropicRequest) MarkClientIndexes() {
+	for i := range r.Messages {
+		r.Messages[i].clientIndex = i + 1
+	}
+}
+
+// ClientIndex 消息在客户端请求 messages 中的下标，未标记时返回false
+func (m AnthropicRequestMessage) ClientIndex() (int, bool) {
+	return m.clientIndex - 1, m.clientIndex > 0
 }
 
 type AnthropicSystemMessage struct {
-	Type string `json:"type"`
-	Text string `json:"text"` // 可以是 string 或 []ContentBlock
+	Type   string          `json:"type"`
+	Text   string          `json:"text"`             // 可以是 string 或 []ContentBlock
+	Title  string          `json:"title,omitempty"`  // document 块的标题
+	Source *DocumentSource `json:"source,omitempty"` // document 块的数据源
+}
+
+// DocumentSource 表示 document 块的数据源
+type DocumentSource struct {
+	Type      string `json:"type"`                 // "url" 或 "text"
+	URL       string `json:"url,omitempty"`        // type=url 时的文档地址
+	MediaType string `json:"media_type,omitempty"` // type=text 时通常为 "text/plain"
+	Data      string `json:"data,omitempty"`       // type=text 时的文档内容
 }
 
 // ContentBlock 表示消息内容块的结构
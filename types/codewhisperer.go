@@ -20,13 +20,22 @@ type CodeWhispererRequest struct {
 				} `json:"userInputMessageContext"`
 				Content string               `json:"content"`
 				ModelId string               `json:"modelId"`
-				Images  []CodeWhispererImage `json:"images"`
+				Images  []CodeWhispererImage `json:"images"` // 没有图片时也发送 []
 				Origin  string               `json:"origin"`
 			} `json:"userInputMessage"`
 		} `json:"currentMessage"`
 		ConversationId string `json:"conversationId"`
-		History        []any  `json:"history"`
+		History        []any  `json:"history"` // 始终为数组，没有历史时为 []，不能为 null
 	} `json:"conversationState"`
+	// InferenceConfig 生成参数，STOP_SEQUENCES_MODE=native 且请求带 stop_sequences 时才发送
+	InferenceConfig *InferenceConfig `json:"inferenceConfig,omitempty"`
+	// ProfileArn 多 profile 账号使用的 CodeWhisperer AI profile，为空时不发送
+	ProfileArn string `json:"profileArn,omitempty"`
+}
+
+// InferenceConfig CodeWhisperer 请求的生成参数
+type InferenceConfig struct {
+	StopSequences []string `json:"stopSequences,omitempty"`
 }
 
 // CodeWhispererImage 表示 CodeWhisperer API 的图片结构
@@ -59,6 +59,17 @@ func NewModelNotFoundError(model, requestId string) *ModelNotFoundError {
 	}
 }
 
+// NewInvalidModelError 创建模型不可用错误（如直通modelId被拒绝），reason说明拒绝原因
+func NewInvalidModelError(model, reason, requestId string) *ModelNotFoundError {
+	return &ModelNotFoundError{
+		Error: ModelNotFoundErrorDetail{
+			Code:    "invalid_model",
+			Message: fmt.Sprintf("模型 %s 不可用: %s (request id: %s)", model, reason, requestId),
+			Type:    "invalid_request_error",
+		},
+	}
+}
+
 // ModelNotFoundErrorType 模型未找到错误的类型包装器，用于在错误处理中识别
 type ModelNotFoundErrorType struct {
 	ErrorData *ModelNotFoundError
@@ -75,3 +86,10 @@ func NewModelNotFoundErrorType(model, requestId string) *ModelNotFoundErrorType
 		ErrorData: NewModelNotFoundError(model, requestId),
 	}
 }
+
+// NewInvalidModelErrorType 创建模型不可用错误类型，与模型未找到错误一样以400返回
+func NewInvalidModelErrorType(model, reason, requestId string) *ModelNotFoundErrorType {
+	return &ModelNotFoundErrorType{
+		ErrorData: NewInvalidModelError(model, reason, requestId),
+	}
+}
@@ -48,6 +48,8 @@ type OpenAIRequest struct {
 	Stream      *bool           `json:"stream,omitempty"`
 	Tools       []OpenAITool    `json:"tools,omitempty"`
 	ToolChoice  any             `json:"tool_choice,omitempty"` // 可以是 "auto", "none", "required" 或 OpenAIToolChoice
+	// ResponseFormat 结构化输出约束（json_object / json_schema）
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
 }
 
 type OpenAIChoice struct {
@@ -13,7 +13,10 @@ type Token struct {
 
 	// API响应字段
 	ExpiresIn  int    `json:"expiresIn,omitempty"`  // 多少秒后失效，来自RefreshResponse
-	ProfileArn string `json:"profileArn,omitempty"` // 来自RefreshResponse
+	ProfileArn string `json:"profileArn,omitempty"` // 来自RefreshResponse，auth.RefreshToken 按账号配置改写为默认发送的 profile
+
+	// Profiles 账号配置的 profile 名称到 ARN 的映射
+	Profiles map[string]string `json:"profiles,omitempty"`
 }
 
 // FromRefreshResponse 从RefreshResponse创建Token
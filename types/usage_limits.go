@@ -134,8 +134,8 @@ func (t *TokenWithUsage) NeedsUsageRefresh() bool {
 		return true
 	}
 
-	// 如果上次检查超过TokenCacheTTL
-	if time.Since(t.LastUsageCheck) > config.TokenCacheTTL {
+	// 如果上次检查超过 TOKEN_CACHE_TTL
+	if time.Since(t.LastUsageCheck) > config.Get().TokenCacheTTL {
 		return true
 	}
 
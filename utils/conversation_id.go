@@ -1,8 +1,12 @@
 package utils
 
 import (
+	"crypto/hmac"
 	"crypto/md5"
+	"crypto/sha256"
 	"fmt"
+	"net"
+	"strings"
 	"sync"
 	"time"
 
@@ -36,12 +40,8 @@ func NewConversationIDManager() *ConversationIDManager {
 // GenerateConversationID 基于客户端信息生成稳定的会话ID
 // 遵循KISS原则：使用客户端特征生成稳定的标识符
 func (c *ConversationIDManager) GenerateConversationID(ctx *gin.Context) string {
-	// 从请求头中获取客户端标识信息
-	clientIP := ctx.ClientIP()
-	userAgent := ctx.GetHeader("User-Agent")
-
 	// 检查是否有自定义的会话ID头（优先级最高）
-	if customConvID := ctx.GetHeader("X-Conversation-ID"); customConvID != "" {
+	if customConvID := conversationOverride(ctx, config.ConversationIDHeader); customConvID != "" {
 		return customConvID
 	}
 
@@ -54,7 +54,7 @@ func (c *ConversationIDManager) GenerateConversationID(ctx *gin.Context) string
 	timeWindow := time.Now().Format("2006010215") // 精确到小时
 
 	// 构建客户端特征字符串
-	clientSignature := fmt.Sprintf("%s|%s|%s", clientIP, userAgent, timeWindow)
+	clientSignature := fmt.Sprintf("%s|%s", clientIdentity(ctx), timeWindow)
 
 	// 检查缓存 (使用读锁)
 	c.mu.RLock()
@@ -99,7 +99,7 @@ var globalConversationIDManager = NewConversationIDManager()
 func GenerateStableConversationID(ctx *gin.Context) string {
 	if config.IsStealthModeEnabled() {
 		if ctx != nil {
-			if headerID := ctx.GetHeader("X-Conversation-ID"); headerID != "" {
+			if headerID := conversationOverride(ctx, config.ConversationIDHeader); headerID != "" {
 				return headerID
 			}
 		}
@@ -117,7 +117,7 @@ func GenerateStableAgentContinuationID(ctx *gin.Context) string {
 	}
 
 	// 检查是否有自定义的代理延续ID头（优先级最高）
-	if customAgentID := ctx.GetHeader("X-Agent-Continuation-ID"); customAgentID != "" {
+	if customAgentID := conversationOverride(ctx, config.AgentContinuationIDHeader); customAgentID != "" {
 		return customAgentID
 	}
 
@@ -132,6 +132,27 @@ func GenerateStableAgentContinuationID(ctx *gin.Context) string {
 	return generateDeterministicGUID(clientSignature, "agent")
 }
 
+// conversationOverride 返回客户端通过请求头指定的会话ID，格式不合法时忽略
+// HTTP 请求在中间件中已校验（不合法时返回400），这里防止其他调用路径把任意内容发往上游
+func conversationOverride(ctx *gin.Context, header string) string {
+	value := ctx.GetHeader(header)
+	if value == "" || config.ValidateConversationOverride(value) != nil {
+		return ""
+	}
+	return value
+}
+
+// NamespacedConversationID 按客户端密钥隔离自定义会话ID：HMAC-SHA256(namespace, id) 格式化为UUID
+// 不同密钥指定相同的ID得到不同的结果，同一密钥得到的结果稳定
+func NamespacedConversationID(namespace, id string) string {
+	mac := hmac.New(sha256.New, []byte(namespace))
+	mac.Write([]byte(id))
+	sum := mac.Sum(nil)
+	sum[6] = (sum[6] & 0x0f) | 0x50 // Version 5
+	sum[8] = (sum[8] & 0x3f) | 0x80 // Variant bits
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
 func resolveConversationStrategy() conversationStrategy {
 	if config.IsStealthModeEnabled() {
 		return strategyRandom
@@ -141,14 +162,43 @@ func resolveConversationStrategy() conversationStrategy {
 
 // buildAgentClientSignature 构建代理客户端特征签名 (SOLID-SRP: 单一职责)
 func buildAgentClientSignature(ctx *gin.Context) string {
-	clientIP := ctx.ClientIP()
-	userAgent := ctx.GetHeader("User-Agent")
-
 	// 统一使用1小时时间窗口，与ConversationId保持一致
 	// 确保在同一会话内AgentContinuationId保持稳定
 	timeWindow := time.Now().Format("2006010215") // 精确到小时
 
-	return fmt.Sprintf("agent|%s|%s|%s", clientIP, userAgent, timeWindow)
+	return fmt.Sprintf("agent|%s|%s", clientIdentity(ctx), timeWindow)
+}
+
+// clientIdentity 生成会话ID和代理延续ID使用的客户端特征
+// 优先使用 X-Client-Fingerprint，否则为原始客户端IP与User-Agent，使负载均衡后的多个实例得到相同结果
+func clientIdentity(ctx *gin.Context) string {
+	if fingerprint := clientFingerprint(ctx); fingerprint != "" {
+		return "fingerprint|" + fingerprint
+	}
+	return fmt.Sprintf("%s|%s", originClientIP(ctx), ctx.GetHeader("User-Agent"))
+}
+
+// clientFingerprint 返回客户端指定的 X-Client-Fingerprint，格式不合法时忽略
+func clientFingerprint(ctx *gin.Context) string {
+	value := ctx.GetHeader(config.ClientFingerprintHeader)
+	if value == "" || config.ValidateClientFingerprint(value) != nil {
+		return ""
+	}
+	return value
+}
+
+// originClientIP 原始客户端IP：X-Forwarded-For 的第一个地址，其次 X-Real-IP，都没有或不是合法IP时使用 gin 解析的客户端IP
+func originClientIP(ctx *gin.Context) string {
+	if forwarded := ctx.GetHeader("X-Forwarded-For"); forwarded != "" {
+		first, _, _ := strings.Cut(forwarded, ",")
+		if ip := net.ParseIP(strings.TrimSpace(first)); ip != nil {
+			return ip.String()
+		}
+	}
+	if ip := net.ParseIP(strings.TrimSpace(ctx.GetHeader("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+	return ctx.ClientIP()
 }
 
 // generateDeterministicGUID 基于输入字符串生成确定性GUID (SOLID-SRP: 单一职责)
@@ -170,13 +220,17 @@ func generateDeterministicGUID(input, namespace string) string {
 		hash[0:4], hash[4:6], hash[6:8], hash[8:10], hash[10:16])
 }
 
-// ExtractClientInfo 提取客户端信息用于调试和日志
+// ExtractClientInfo 提取客户端信息用于调试和日志，identity 为生成会话ID实际使用的客户端特征
 func ExtractClientInfo(ctx *gin.Context) map[string]string {
 	return map[string]string{
 		"client_ip":            ctx.ClientIP(),
+		"origin_ip":            originClientIP(ctx),
 		"user_agent":           ctx.GetHeader("User-Agent"),
-		"custom_conv_id":       ctx.GetHeader("X-Conversation-ID"),
-		"custom_agent_cont_id": ctx.GetHeader("X-Agent-Continuation-ID"),
+		"custom_conv_id":       conversationOverride(ctx, config.ConversationIDHeader),
+		"custom_agent_cont_id": conversationOverride(ctx, config.AgentContinuationIDHeader),
 		"forwarded_for":        ctx.GetHeader("X-Forwarded-For"),
+		"real_ip":              ctx.GetHeader("X-Real-IP"),
+		"client_fingerprint":   clientFingerprint(ctx),
+		"identity":             clientIdentity(ctx),
 	}
 }
@@ -3,6 +3,7 @@ package utils
 import (
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -161,22 +162,22 @@ func TestCustomHeadersOverride(t *testing.T) {
 	c1, _ := gin.CreateTestContext(w1)
 	c1.Request, _ = http.NewRequest("POST", "/v1/messages", nil)
 	c1.Request.Header.Set("User-Agent", "test-client")
-	c1.Request.Header.Set("X-Conversation-ID", "custom-conv-123")
+	c1.Request.Header.Set("X-Conversation-ID", "conv-0123456789abcdef")
 	c1.Request.RemoteAddr = "192.168.1.100:12345"
 
 	convID := manager.GenerateConversationID(c1)
-	assert.Equal(t, "custom-conv-123", convID, "应该使用自定义的ConversationId")
+	assert.Equal(t, "conv-0123456789abcdef", convID, "应该使用自定义的ConversationId")
 
 	// 测试自定义AgentContinuationId
 	w2 := httptest.NewRecorder()
 	c2, _ := gin.CreateTestContext(w2)
 	c2.Request, _ = http.NewRequest("POST", "/v1/messages", nil)
 	c2.Request.Header.Set("User-Agent", "test-client")
-	c2.Request.Header.Set("X-Agent-Continuation-ID", "custom-agent-456")
+	c2.Request.Header.Set("X-Agent-Continuation-ID", "3f2a9c1e-7b4d-4e8a-9c2f-1a2b3c4d5e6f")
 	c2.Request.RemoteAddr = "192.168.1.100:12345"
 
 	agentID := GenerateStableAgentContinuationID(c2)
-	assert.Equal(t, "custom-agent-456", agentID, "应该使用自定义的AgentContinuationId")
+	assert.Equal(t, "3f2a9c1e-7b4d-4e8a-9c2f-1a2b3c4d5e6f", agentID, "应该使用自定义的AgentContinuationId")
 }
 
 // TestIDFormatValidity 测试生成的ID格式是否有效
@@ -208,16 +209,96 @@ func TestExtractClientInfo(t *testing.T) {
 	c, _ := gin.CreateTestContext(w)
 	c.Request, _ = http.NewRequest("POST", "/v1/messages", nil)
 	c.Request.Header.Set("User-Agent", "test-client/1.0")
-	c.Request.Header.Set("X-Conversation-ID", "custom-conv-id")
-	c.Request.Header.Set("X-Agent-Continuation-ID", "custom-agent-id")
+	c.Request.Header.Set("X-Conversation-ID", "conv-fedcba9876543210")
+	c.Request.Header.Set("X-Agent-Continuation-ID", "8d7c6b5a-4f3e-4d2c-8b1a-0f9e8d7c6b5a")
 	c.Request.RemoteAddr = "192.168.1.100:12345"
 
 	info := ExtractClientInfo(c)
 
 	assert.Equal(t, "192.168.1.100", info["client_ip"])
 	assert.Equal(t, "test-client/1.0", info["user_agent"])
-	assert.Equal(t, "custom-conv-id", info["custom_conv_id"])
-	assert.Equal(t, "custom-agent-id", info["custom_agent_cont_id"])
+	assert.Equal(t, "conv-fedcba9876543210", info["custom_conv_id"])
+	assert.Equal(t, "8d7c6b5a-4f3e-4d2c-8b1a-0f9e8d7c6b5a", info["custom_agent_cont_id"])
+	assert.Equal(t, "192.168.1.100", info["origin_ip"])
+	assert.Equal(t, "192.168.1.100|test-client/1.0", info["identity"])
+
+	c.Request.Header.Set("X-Real-IP", "203.0.113.9")
+	c.Request.Header.Set("X-Client-Fingerprint", "laptop-01")
+	info = ExtractClientInfo(c)
+	assert.Equal(t, "203.0.113.9", info["real_ip"])
+	assert.Equal(t, "203.0.113.9", info["origin_ip"])
+	assert.Equal(t, "laptop-01", info["client_fingerprint"])
+	assert.Equal(t, "fingerprint|laptop-01", info["identity"])
+}
+
+// TestClientIdentity_LoadBalancer 多实例部署在负载均衡之后：连接地址不同，但原始客户端相同时生成相同的会话ID
+func TestClientIdentity_LoadBalancer(t *testing.T) {
+	createContext := func(remoteAddr string, headers map[string]string) *gin.Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request, _ = http.NewRequest("POST", "/v1/messages", nil)
+		c.Request.Header.Set("User-Agent", "test-client/1.0")
+		c.Request.RemoteAddr = remoteAddr
+		for k, v := range headers {
+			c.Request.Header.Set(k, v)
+		}
+		return c
+	}
+	ids := func(c *gin.Context) [2]string {
+		return [2]string{NewConversationIDManager().GenerateConversationID(c), GenerateStableAgentContinuationID(c)}
+	}
+
+	tests := []struct {
+		name           string
+		first, second  map[string]string
+		wantSameClient bool
+	}{
+		{
+			name:           "X-Forwarded-For 的第一个地址",
+			first:          map[string]string{"X-Forwarded-For": "203.0.113.7, 10.0.0.1"},
+			second:         map[string]string{"X-Forwarded-For": "203.0.113.7, 10.0.0.2"},
+			wantSameClient: true,
+		},
+		{
+			name:           "X-Real-IP",
+			first:          map[string]string{"X-Real-IP": "203.0.113.7"},
+			second:         map[string]string{"X-Real-IP": "203.0.113.7"},
+			wantSameClient: true,
+		},
+		{
+			name:           "指纹优先于IP",
+			first:          map[string]string{"X-Client-Fingerprint": "laptop-01", "X-Forwarded-For": "203.0.113.7"},
+			second:         map[string]string{"X-Client-Fingerprint": "laptop-01", "X-Forwarded-For": "198.51.100.4"},
+			wantSameClient: true,
+		},
+		{
+			name:   "不同指纹",
+			first:  map[string]string{"X-Client-Fingerprint": "laptop-01"},
+			second: map[string]string{"X-Client-Fingerprint": "laptop-02"},
+		},
+		{
+			name:   "没有转发头时按负载均衡地址区分",
+			first:  map[string]string{},
+			second: map[string]string{},
+		},
+		{
+			name:   "无效指纹被忽略",
+			first:  map[string]string{"X-Client-Fingerprint": "my laptop"},
+			second: map[string]string{"X-Client-Fingerprint": "my laptop"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first := ids(createContext("10.0.0.1:40000", tt.first))
+			second := ids(createContext("10.0.0.2:40000", tt.second))
+			if tt.wantSameClient {
+				assert.Equal(t, first, second)
+			} else {
+				assert.NotEqual(t, first[0], second[0])
+				assert.NotEqual(t, first[1], second[1])
+			}
+		})
+	}
 }
 
 // TestTimeWindowBoundary 测试时间窗口边界情况
@@ -278,3 +359,29 @@ func BenchmarkAgentContinuationIDGeneration(b *testing.B) {
 		_ = GenerateStableAgentContinuationID(c)
 	}
 }
+
+// TestCustomHeadersOverride_InvalidIgnored 格式不合法或过长的自定义ID不发往上游，回退到自动生成
+func TestCustomHeadersOverride_InvalidIgnored(t *testing.T) {
+	manager := NewConversationIDManager()
+
+	for _, value := range []string{"custom-conv-123", "conv-0123456789ABCDEF", strings.Repeat("a", 200), "conv-0123456789abcdef\n"} {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request, _ = http.NewRequest("POST", "/v1/messages", nil)
+		c.Request.Header.Set("X-Conversation-ID", value)
+		c.Request.Header.Set("X-Agent-Continuation-ID", value)
+
+		assert.NotEqual(t, value, manager.GenerateConversationID(c))
+		assert.NotEqual(t, value, GenerateStableAgentContinuationID(c))
+		assert.Empty(t, ExtractClientInfo(c)["custom_conv_id"])
+	}
+}
+
+// TestNamespacedConversationID 不同命名空间的相同ID互不冲突，同一命名空间结果稳定
+func TestNamespacedConversationID(t *testing.T) {
+	id := "conv-0123456789abcdef"
+	a := NamespacedConversationID("key-a", id)
+	assert.Equal(t, a, NamespacedConversationID("key-a", id))
+	assert.NotEqual(t, a, NamespacedConversationID("key-b", id))
+	assert.NotEqual(t, a, NamespacedConversationID("key-a", "conv-0123456789abcdee"))
+	assert.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-5[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, a)
+}
@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"kiro2api/config"
+)
+
+// DocumentTruncationMarker 文档超出大小限制时追加的标记
+const DocumentTruncationMarker = "\n[...truncated...]"
+
+// ErrDocumentURLNotAllowed 文档 URL 不在白名单内
+var ErrDocumentURLNotAllowed = errors.New("文档URL不在允许的域名列表中")
+
+// DocumentFetcher 受限的文档拉取客户端
+// 仅允许 http/https 访问白名单域名（含子域名），重定向目标同样校验白名单，
+// 响应只接受文本类型，超过 maxBytes 的部分截断
+type DocumentFetcher struct {
+	client    *http.Client
+	allowlist []string
+	maxBytes  int64
+}
+
+// NewDocumentFetcher 创建文档拉取客户端
+func NewDocumentFetcher(allowlist []string, timeout time.Duration, maxBytes int64) *DocumentFetcher {
+	f := &DocumentFetcher{
+		allowlist: allowlist,
+		maxBytes:  maxBytes,
+	}
+	f.client = &http.Client{
+		Timeout: timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > config.DocumentFetchMaxRedirects {
+				return fmt.Errorf("重定向次数过多")
+			}
+			if !f.IsAllowed(req.URL) {
+				return ErrDocumentURLNotAllowed
+			}
+			return nil
+		},
+	}
+	return f
+}
+
+// NewDocumentFetcherFromConfig 按当前环境变量配置创建文档拉取客户端
+func NewDocumentFetcherFromConfig() *DocumentFetcher {
+	return NewDocumentFetcher(config.DocumentURLAllowlist(), config.DocumentFetchTimeout(), config.DocumentFetchMaxBytes())
+}
+
+// IsAllowed 检查 URL 是否允许拉取
+func (f *DocumentFetcher) IsAllowed(u *url.URL) bool {
+	if u == nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		return false
+	}
+	for _, domain := range f.allowlist {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// Fetch 拉取文档文本内容，超出大小限制时截断并追加 DocumentTruncationMarker
+func (f *DocumentFetcher) Fetch(ctx context.Context, rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("无效的文档URL: %v", err)
+	}
+	if !f.IsAllowed(u) {
+		return "", ErrDocumentURLNotAllowed
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", fmt.Errorf("创建文档请求失败: %v", err)
+	}
+	req.Header.Set("Accept", "text/*, application/json;q=0.9, */*;q=0.1")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		if errors.Is(err, ErrDocumentURLNotAllowed) {
+			return "", ErrDocumentURLNotAllowed
+		}
+		return "", fmt.Errorf("拉取文档失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("拉取文档失败: 状态码 %d", resp.StatusCode)
+	}
+	if !isTextMediaType(resp.Header.Get("Content-Type")) {
+		return "", fmt.Errorf("不支持的文档类型: %s", resp.Header.Get("Content-Type"))
+	}
+
+	// 多读一个字节用于判断是否超出限制
+	body, err := io.ReadAll(io.LimitReader(resp.Body, f.maxBytes+1))
+	if err != nil {
+		return "", fmt.Errorf("读取文档失败: %v", err)
+	}
+
+	if int64(len(body)) <= f.maxBytes {
+		return string(body), nil
+	}
+
+	// 截断到限制长度，避免切断多字节字符
+	truncated := body[:f.maxBytes]
+	for i := 0; i < utf8.UTFMax && len(truncated) > 0; i++ {
+		if r, _ := utf8.DecodeLastRune(truncated); r != utf8.RuneError {
+			break
+		}
+		truncated = truncated[:len(truncated)-1]
+	}
+	return string(truncated) + DocumentTruncationMarker, nil
+}
+
+// isTextMediaType 判断响应是否为可内联的文本类型；未声明类型时视为文本
+func isTextMediaType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if strings.HasPrefix(mediaType, "text/") {
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/xml", "application/xhtml+xml", "application/javascript", "application/x-yaml", "application/yaml":
+		return true
+	}
+	return false
+}
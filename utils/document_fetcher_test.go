@@ -0,0 +1,130 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestFetcher(maxBytes int64) *DocumentFetcher {
+	return NewDocumentFetcher([]string{"127.0.0.1"}, 2*time.Second, maxBytes)
+}
+
+func TestDocumentFetcher_IsAllowed(t *testing.T) {
+	f := NewDocumentFetcher([]string{"example.com"}, time.Second, 1024)
+
+	tests := []struct {
+		rawURL  string
+		allowed bool
+	}{
+		{"https://example.com/doc.md", true},
+		{"http://docs.example.com/doc.md", true},
+		{"https://EXAMPLE.com:8443/doc", true},
+		{"https://evil-example.com/doc", false},
+		{"https://example.com.evil.org/doc", false},
+		{"ftp://example.com/doc", false},
+		{"file:///etc/passwd", false},
+	}
+
+	for _, tt := range tests {
+		u, err := url.Parse(tt.rawURL)
+		require.NoError(t, err)
+		assert.Equal(t, tt.allowed, f.IsAllowed(u), tt.rawURL)
+	}
+}
+
+func TestDocumentFetcher_EmptyAllowlistRejectsAll(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("不应发出请求")
+	}))
+	defer server.Close()
+
+	f := NewDocumentFetcher(nil, time.Second, 1024)
+	_, err := f.Fetch(context.Background(), server.URL)
+	assert.ErrorIs(t, err, ErrDocumentURLNotAllowed)
+}
+
+func TestDocumentFetcher_FetchText(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		w.Write([]byte("# Guide\nUse tabs."))
+	}))
+	defer server.Close()
+
+	text, err := newTestFetcher(1024).Fetch(context.Background(), server.URL+"/guide.md")
+	require.NoError(t, err)
+	assert.Equal(t, "# Guide\nUse tabs.", text)
+}
+
+func TestDocumentFetcher_TruncatesLargeBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		// 多字节字符跨越截断边界
+		w.Write([]byte(strings.Repeat("a", 9) + strings.Repeat("中", 10)))
+	}))
+	defer server.Close()
+
+	text, err := newTestFetcher(10).Fetch(context.Background(), server.URL)
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat("a", 9)+DocumentTruncationMarker, text)
+}
+
+func TestDocumentFetcher_RejectsBinaryContent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Write([]byte("%PDF-1.7"))
+	}))
+	defer server.Close()
+
+	_, err := newTestFetcher(1024).Fetch(context.Background(), server.URL)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "不支持的文档类型")
+}
+
+func TestDocumentFetcher_RejectsNonOKStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	_, err := newTestFetcher(1024).Fetch(context.Background(), server.URL)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "404")
+}
+
+func TestDocumentFetcher_RedirectOutsideAllowlist(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("不应跟随重定向到白名单之外")
+	}))
+	defer target.Close()
+
+	// 127.0.0.1 在白名单内，localhost 不在
+	redirectURL := strings.Replace(target.URL, "127.0.0.1", "localhost", 1)
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, redirectURL, http.StatusFound)
+	}))
+	defer origin.Close()
+
+	_, err := newTestFetcher(1024).Fetch(context.Background(), origin.URL)
+	assert.ErrorIs(t, err, ErrDocumentURLNotAllowed)
+}
+
+func TestDocumentFetcher_Timeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("late"))
+	}))
+	defer server.Close()
+
+	f := NewDocumentFetcher([]string{"127.0.0.1"}, 50*time.Millisecond, 1024)
+	_, err := f.Fetch(context.Background(), server.URL)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "拉取文档失败")
+}
@@ -4,6 +4,8 @@ import (
 	"os"
 	"strconv"
 	"strings"
+
+	"kiro2api/config"
 )
 
 // IsDebugMode 检查是否启用调试模式
@@ -14,8 +16,8 @@ func IsDebugMode() bool {
 		return true
 	}
 
-	// 检查LOG_LEVEL是否为debug
-	if logLevel := os.Getenv("LOG_LEVEL"); strings.ToLower(logLevel) == "debug" {
+	// 检查配置的日志级别（LOG_LEVEL）是否为debug
+	if config.Get().LogLevel == "debug" {
 		return true
 	}
 
@@ -4,6 +4,9 @@ import (
 	"os"
 	"testing"
 
+	"kiro2api/config"
+	"kiro2api/config/configtest"
+
 	"github.com/stretchr/testify/assert"
 )
 
@@ -22,15 +25,18 @@ func TestIsDebugMode_Debug1(t *testing.T) {
 }
 
 func TestIsDebugMode_LogLevel(t *testing.T) {
-	os.Setenv("LOG_LEVEL", "debug")
-	defer os.Unsetenv("LOG_LEVEL")
+	configtest.Override(t, func(s *config.Settings) { s.LogLevel = "debug" })
 
 	assert.True(t, IsDebugMode())
 }
 
 func TestIsDebugMode_LogLevelUpperCase(t *testing.T) {
-	os.Setenv("LOG_LEVEL", "DEBUG")
-	defer os.Unsetenv("LOG_LEVEL")
+	t.Setenv("LOG_LEVEL", "DEBUG")
+	configtest.Override(t, func(s *config.Settings) {
+		parsed, err := config.ParseSettings(os.LookupEnv)
+		assert.NoError(t, err)
+		*s = parsed
+	})
 
 	assert.True(t, IsDebugMode())
 }
@@ -44,8 +50,8 @@ func TestIsDebugMode_GinMode(t *testing.T) {
 
 func TestIsDebugMode_False(t *testing.T) {
 	os.Unsetenv("DEBUG")
-	os.Unsetenv("LOG_LEVEL")
 	os.Unsetenv("GIN_MODE")
+	configtest.Override(t, func(s *config.Settings) { s.LogLevel = config.DefaultLogLevel })
 
 	assert.False(t, IsDebugMode())
 }
@@ -129,13 +135,12 @@ func TestGetEnvBoolWithDefault_Empty(t *testing.T) {
 func TestMultipleEnvChecks(t *testing.T) {
 	// 测试多个环境变量同时存在的情况
 	os.Setenv("DEBUG", "true")
-	os.Setenv("LOG_LEVEL", "info")
 	os.Setenv("GIN_MODE", "release")
 	defer func() {
 		os.Unsetenv("DEBUG")
-		os.Unsetenv("LOG_LEVEL")
 		os.Unsetenv("GIN_MODE")
 	}()
+	configtest.Override(t, func(s *config.Settings) { s.LogLevel = "info" })
 
 	assert.True(t, IsDebugMode()) // DEBUG=true 优先级最高
 }
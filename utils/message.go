@@ -9,6 +9,9 @@ import (
 	"github.com/bytedance/sonic"
 )
 
+// EmptyMessagePlaceholder 消息没有可提取文本（如只含 tool_use）时返回的占位内容
+const EmptyMessagePlaceholder = "answer for user question"
+
 // ParseToolResultContent 解析tool_result的content字段
 // 参考Python实现：parse_tool_result_content函数和Anthropic官方文档
 func ParseToolResultContent(content any) string {
@@ -103,7 +106,7 @@ func GetMessageContent(content any) (string, error) {
 		return v.Text, nil
 	case string:
 		if len(v) == 0 {
-			return "answer for user question", nil
+			return EmptyMessagePlaceholder, nil
 		}
 		return v, nil
 	case []any:
@@ -151,7 +154,7 @@ func GetMessageContent(content any) (string, error) {
 			return "请描述这张图片的内容", nil
 		}
 		if len(texts) == 0 {
-			return "answer for user question", nil
+			return EmptyMessagePlaceholder, nil
 		}
 		return strings.Join(texts, "\n"), nil
 	case []types.ContentBlock:
@@ -192,7 +195,7 @@ func GetMessageContent(content any) (string, error) {
 			return "请描述这张图片的内容", nil
 		}
 		if len(texts) == 0 {
-			return "answer for user question", nil
+			return EmptyMessagePlaceholder, nil
 		}
 		return strings.Join(texts, "\n"), nil
 	default:
@@ -0,0 +1,25 @@
+package utils
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"kiro2api/config"
+)
+
+// messageIDCounter 确定性模式下的消息ID计数器
+var messageIDCounter atomic.Int64
+
+func init() {
+	config.RegisterDeterministicReset(func() { messageIDCounter.Store(0) })
+}
+
+// MessageIDSuffix 生成消息ID后缀
+// 默认为当前时间（config.MessageIDTimeFormat），确定性模式下为递增计数器
+func MessageIDSuffix() string {
+	if config.IsDeterministicModeEnabled() {
+		return fmt.Sprintf("%014d", messageIDCounter.Add(1))
+	}
+	return time.Now().Format(config.MessageIDTimeFormat)
+}
@@ -7,13 +7,30 @@ import (
 	mathrand "math/rand"
 	"sync"
 	"time"
+
+	"kiro2api/config"
 )
 
 var (
 	fallbackRand     = mathrand.New(mathrand.NewSource(time.Now().UnixNano()))
 	fallbackRandLock sync.Mutex
+
+	// deterministicRand 确定性模式下使用的固定种子随机数生成器
+	deterministicRand     = mathrand.New(mathrand.NewSource(config.DeterministicSeed))
+	deterministicRandLock sync.Mutex
 )
 
+func init() {
+	config.RegisterDeterministicReset(resetDeterministicRand)
+}
+
+// resetDeterministicRand 重新播种确定性随机数生成器
+func resetDeterministicRand() {
+	deterministicRandLock.Lock()
+	defer deterministicRandLock.Unlock()
+	deterministicRand.Seed(config.DeterministicSeed)
+}
+
 func cryptoInt(max int64) (int64, error) {
 	if max <= 0 {
 		return 0, nil
@@ -25,11 +42,31 @@ func cryptoInt(max int64) (int64, error) {
 	return n.Int64(), nil
 }
 
+// randomBytes 填充随机字节：确定性模式使用固定种子，否则使用 crypto/rand（失败时回退）
+func randomBytes(b []byte) {
+	if config.IsDeterministicModeEnabled() {
+		deterministicRandLock.Lock()
+		deterministicRand.Read(b)
+		deterministicRandLock.Unlock()
+		return
+	}
+	if _, err := rand.Read(b); err != nil {
+		fallbackRandLock.Lock()
+		fallbackRand.Read(b)
+		fallbackRandLock.Unlock()
+	}
+}
+
 func RandomIntBetween(min, max int64) int64 {
 	if min >= max {
 		return min
 	}
 	span := max - min + 1
+	if config.IsDeterministicModeEnabled() {
+		deterministicRandLock.Lock()
+		defer deterministicRandLock.Unlock()
+		return min + deterministicRand.Int63n(span)
+	}
 	n, err := cryptoInt(span)
 	if err != nil {
 		fallbackRandLock.Lock()
@@ -48,11 +85,7 @@ func RandomHex(length int) string {
 		return ""
 	}
 	bytes := make([]byte, (length+1)/2)
-	if _, err := rand.Read(bytes); err != nil {
-		fallbackRandLock.Lock()
-		fallbackRand.Read(bytes)
-		fallbackRandLock.Unlock()
-	}
+	randomBytes(bytes)
 	hexString := hex.EncodeToString(bytes)
 	return hexString[:length]
 }
@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"testing"
+
+	"kiro2api/config"
+	"kiro2api/config/configtest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRandom_DeterministicModeRepeatsSequence(t *testing.T) {
+	configtest.WithDeterministic(t)
+
+	first := []any{RandomHex(32), RandomIntBetween(1, 1000), GenerateUUID()}
+	config.ResetDeterministicState()
+	second := []any{RandomHex(32), RandomIntBetween(1, 1000), GenerateUUID()}
+
+	assert.Equal(t, first, second)
+	assert.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, first[2])
+}
+
+func TestMessageIDSuffix(t *testing.T) {
+	t.Run("默认使用时间戳", func(t *testing.T) {
+		assert.Regexp(t, `^\d{14}$`, MessageIDSuffix())
+	})
+
+	t.Run("确定性模式使用计数器", func(t *testing.T) {
+		configtest.WithDeterministic(t)
+		assert.Equal(t, "00000000000001", MessageIDSuffix())
+		assert.Equal(t, "00000000000002", MessageIDSuffix())
+
+		config.ResetDeterministicState()
+		assert.Equal(t, "00000000000001", MessageIDSuffix())
+	})
+}
@@ -0,0 +1,161 @@
+package utils
+
+import (
+	"testing"
+
+	"kiro2api/config"
+	"kiro2api/types"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func calibrationTestRequest(toolCount int) *types.CountTokensRequest {
+	req := &types.CountTokensRequest{
+		Model:  "claude-sonnet-4",
+		System: []types.AnthropicSystemMessage{{Type: "text", Text: "You are a helpful assistant."}},
+		Messages: []types.AnthropicRequestMessage{
+			{Role: "user", Content: "Please summarize the latest release notes for me."},
+		},
+	}
+	for i := 0; i < toolCount; i++ {
+		req.Tools = append(req.Tools, types.AnthropicTool{
+			Name:        "get_weather",
+			Description: "Get the current weather for a location",
+			InputSchema: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"location": map[string]any{"type": "string", "description": "City name"},
+				},
+				"required": []any{"location"},
+			},
+		})
+	}
+	return req
+}
+
+func TestEstimateBreakdown_DefaultMatchesEstimateTokens(t *testing.T) {
+	estimator := NewTokenEstimatorWithCalibration(config.TokenCalibration{})
+
+	for _, toolCount := range []int{0, 1, 3, 8} {
+		req := calibrationTestRequest(toolCount)
+		breakdown := estimator.EstimateBreakdown(req)
+
+		toolTotal := breakdown.ToolsBase
+		for _, tool := range breakdown.Tools {
+			assert.Equal(t, tool.NameTokens+tool.DescriptionTokens+tool.SchemaTokens+tool.OverheadTokens, tool.Total)
+			toolTotal += tool.Total
+		}
+		assert.Len(t, breakdown.Tools, toolCount)
+		assert.Equal(t, breakdown.System+breakdown.Messages+toolTotal+breakdown.RequestOverhead, breakdown.Total)
+		assert.Equal(t, breakdown.Total, estimator.EstimateTokens(req))
+	}
+}
+
+func TestEstimateTokens_UnitMultipliersUnchanged(t *testing.T) {
+	defaults := NewTokenEstimatorWithCalibration(config.TokenCalibration{})
+	unit := NewTokenEstimatorWithCalibration(config.TokenCalibration{
+		GlobalMultiplier: 1,
+		TextMultiplier:   1,
+		ToolCountMultipliers: map[string]float64{
+			config.ToolCountTierSingle: 1,
+			config.ToolCountTierFew:    1,
+			config.ToolCountTierMany:   1,
+		},
+	})
+
+	for _, toolCount := range []int{0, 1, 3, 8} {
+		req := calibrationTestRequest(toolCount)
+		assert.Equal(t, defaults.EstimateTokens(req), unit.EstimateTokens(req))
+	}
+}
+
+func TestEstimateBreakdown_TextMultiplier(t *testing.T) {
+	req := calibrationTestRequest(1)
+	base := NewTokenEstimatorWithCalibration(config.TokenCalibration{}).EstimateBreakdown(req)
+	scaled := NewTokenEstimatorWithCalibration(config.TokenCalibration{TextMultiplier: 2}).EstimateBreakdown(req)
+
+	// 文本部分翻倍，固定开销不变
+	assert.Equal(t, (base.System-2)*2+2, scaled.System)
+	assert.Equal(t, (base.Messages-3)*2+3, scaled.Messages)
+	assert.Equal(t, base.Tools[0].DescriptionTokens*2, scaled.Tools[0].DescriptionTokens)
+	assert.Equal(t, base.Tools[0].SchemaTokens, scaled.Tools[0].SchemaTokens)
+	assert.Equal(t, base.Tools[0].NameTokens, scaled.Tools[0].NameTokens)
+	assert.Equal(t, base.RequestOverhead, scaled.RequestOverhead)
+}
+
+func TestEstimateBreakdown_ToolCountMultiplierOnlyMatchingTier(t *testing.T) {
+	calibration := config.TokenCalibration{
+		ToolCountMultipliers: map[string]float64{config.ToolCountTierMany: 1.5},
+	}
+
+	// 不匹配的分档保持默认
+	few := calibrationTestRequest(3)
+	assert.Equal(t,
+		NewTokenEstimatorWithCalibration(config.TokenCalibration{}).EstimateTokens(few),
+		NewTokenEstimatorWithCalibration(calibration).EstimateTokens(few))
+
+	many := calibrationTestRequest(8)
+	base := NewTokenEstimatorWithCalibration(config.TokenCalibration{}).EstimateBreakdown(many)
+	scaled := NewTokenEstimatorWithCalibration(calibration).EstimateBreakdown(many)
+
+	assert.Equal(t, base.System, scaled.System)
+	assert.Equal(t, base.Messages, scaled.Messages)
+	assert.Equal(t, 270, scaled.ToolsBase) // 180 * 1.5
+	for i := range base.Tools {
+		assert.Equal(t, applyMultiplier(base.Tools[i].Total, 1.5), scaled.Tools[i].Total)
+	}
+}
+
+func TestEstimateBreakdown_SchemaCharsPerTokenOverride(t *testing.T) {
+	req := calibrationTestRequest(1)
+	base := NewTokenEstimatorWithCalibration(config.TokenCalibration{}).EstimateBreakdown(req)
+	// 密度调低、schema估算变大，越过最小值
+	scaled := NewTokenEstimatorWithCalibration(config.TokenCalibration{
+		SchemaCharsPerToken: map[string]float64{config.ToolCountTierSingle: 0.5},
+	}).EstimateBreakdown(req)
+
+	assert.Greater(t, scaled.Tools[0].SchemaTokens, base.Tools[0].SchemaTokens)
+	assert.Equal(t, base.Tools[0].DescriptionTokens, scaled.Tools[0].DescriptionTokens)
+	assert.Equal(t, base.System, scaled.System)
+}
+
+func TestEstimateBreakdown_GlobalMultiplierOnlyAffectsTotal(t *testing.T) {
+	req := calibrationTestRequest(3)
+	base := NewTokenEstimatorWithCalibration(config.TokenCalibration{}).EstimateBreakdown(req)
+	scaled := NewTokenEstimatorWithCalibration(config.TokenCalibration{GlobalMultiplier: 1.1}).EstimateBreakdown(req)
+
+	assert.Equal(t, base.Subtotal, scaled.Subtotal)
+	assert.Equal(t, applyMultiplier(base.Subtotal, 1.1), scaled.Total)
+}
+
+func TestNewTokenEstimator_UsesActiveCalibration(t *testing.T) {
+	t.Cleanup(func() { SetTokenCalibration(config.TokenCalibration{}) })
+
+	req := calibrationTestRequest(0)
+	base := NewTokenEstimator().EstimateTokens(req)
+
+	SetTokenCalibration(config.TokenCalibration{GlobalMultiplier: 2})
+	require.Equal(t, 2.0, ActiveTokenCalibration().GlobalMultiplier)
+	assert.Equal(t, base*2, NewTokenEstimator().EstimateTokens(req))
+}
+
+func TestNewTokenEstimatorForModel_UsesModelCoefficients(t *testing.T) {
+	t.Cleanup(func() { SetModelCalibrationTable(config.ModelCalibrationTable{}) })
+
+	text := "Please summarize the latest release notes for me and list the breaking changes."
+	code := "func main() {\n\tfmt.Println(map[string]int{\"a\": 1, \"b\": 2}); os.Exit(0);\n}"
+	base := NewTokenEstimator()
+
+	SetModelCalibrationTable(config.ModelCalibrationTable{
+		"claude-opus-4": {TextCharsPerToken: config.TextCharsPerToken * 2, CodeCharsPerToken: config.CodeCharsPerToken / 2, Samples: 3},
+	})
+
+	opus := NewTokenEstimatorForModel("claude-opus-4")
+	assert.Equal(t, applyMultiplier(base.EstimateTextTokens(text), 0.5), opus.EstimateTextTokens(text), "字符/token比率翻倍，文本token减半")
+	assert.Equal(t, applyMultiplier(base.EstimateTextTokens(code), 2), opus.EstimateTextTokens(code), "代码按代码比率缩放")
+
+	// 未校准的模型使用默认比率
+	assert.Equal(t, base.EstimateTextTokens(text), NewTokenEstimatorForModel("claude-sonnet-4").EstimateTextTokens(text))
+	assert.Equal(t, config.DefaultCalibrationCoefficients(), ModelCalibrationCoefficients("claude-sonnet-4"))
+}
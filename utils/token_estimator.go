@@ -3,6 +3,8 @@ package utils
 import (
 	"math"
 	"strings"
+	"sync/atomic"
+	"unicode"
 
 	"kiro2api/config"
 	"kiro2api/types"
@@ -13,11 +15,88 @@ import (
 // - KISS: 简单高效的估算算法，避免引入复杂的tokenizer库
 // - 向后兼容: 支持所有Claude模型和消息格式
 // - 性能优先: 本地计算，响应时间<5ms
-type TokenEstimator struct{}
+type TokenEstimator struct {
+	calibration  config.TokenCalibration
+	coefficients config.CalibrationCoefficients // 按模型实测的字符/token比率
+}
+
+// activeCalibration 启动时加载的校准参数，NewTokenEstimator 创建的实例均使用它
+var activeCalibration atomic.Pointer[config.TokenCalibration]
+
+// SetTokenCalibration 设置全局token估算校准参数
+func SetTokenCalibration(calibration config.TokenCalibration) {
+	activeCalibration.Store(&calibration)
+}
+
+// ActiveTokenCalibration 返回当前生效的校准参数
+func ActiveTokenCalibration() config.TokenCalibration {
+	if calibration := activeCalibration.Load(); calibration != nil {
+		return *calibration
+	}
+	return config.TokenCalibration{}
+}
+
+// activeModelCalibration 按模型校准表，启动时从 TOKEN_CALIBRATION_FILE 加载，可通过管理接口更新
+var activeModelCalibration atomic.Pointer[config.ModelCalibrationTable]
+
+// SetModelCalibrationTable 原子替换按模型校准表
+func SetModelCalibrationTable(table config.ModelCalibrationTable) {
+	activeModelCalibration.Store(&table)
+}
+
+// ActiveModelCalibrationTable 返回当前生效的按模型校准表，调用方不应修改返回值
+func ActiveModelCalibrationTable() config.ModelCalibrationTable {
+	if table := activeModelCalibration.Load(); table != nil {
+		return *table
+	}
+	return config.ModelCalibrationTable{}
+}
+
+// ModelCalibrationCoefficients 返回模型的实测比率，未校准的模型返回默认比率
+func ModelCalibrationCoefficients(model string) config.CalibrationCoefficients {
+	if coefficients, ok := ActiveModelCalibrationTable()[model]; ok {
+		return coefficients
+	}
+	return config.DefaultCalibrationCoefficients()
+}
 
-// NewTokenEstimator 创建token估算器实例
+// NewTokenEstimator 创建token估算器实例（使用全局校准参数和默认字符/token比率）
 func NewTokenEstimator() *TokenEstimator {
-	return &TokenEstimator{}
+	return NewTokenEstimatorWithCalibration(ActiveTokenCalibration())
+}
+
+// NewTokenEstimatorForModel 创建使用该模型实测字符/token比率的估算器，未校准的模型与 NewTokenEstimator 相同
+func NewTokenEstimatorForModel(model string) *TokenEstimator {
+	estimator := NewTokenEstimator()
+	estimator.coefficients = ModelCalibrationCoefficients(model)
+	return estimator
+}
+
+// NewTokenEstimatorWithCalibration 使用指定校准参数创建token估算器实例
+func NewTokenEstimatorWithCalibration(calibration config.TokenCalibration) *TokenEstimator {
+	return &TokenEstimator{calibration: calibration, coefficients: config.DefaultCalibrationCoefficients()}
+}
+
+// ToolTokenEstimate 单个工具定义的token估算明细
+type ToolTokenEstimate struct {
+	Name              string `json:"name"`
+	NameTokens        int    `json:"name_tokens"`
+	DescriptionTokens int    `json:"description_tokens"`
+	SchemaTokens      int    `json:"schema_tokens"`
+	OverheadTokens    int    `json:"overhead_tokens"`
+	Total             int    `json:"total"` // 已应用工具数量分档倍率
+}
+
+// TokenEstimateBreakdown 请求token估算的分项明细
+// 各分项已应用文本倍率和工具分档倍率，全局倍率只体现在 Total 中
+type TokenEstimateBreakdown struct {
+	System          int                 `json:"system"`
+	Messages        int                 `json:"messages"`
+	ToolsBase       int                 `json:"tools_base"`
+	Tools           []ToolTokenEstimate `json:"tools"`
+	RequestOverhead int                 `json:"request_overhead"`
+	Subtotal        int                 `json:"subtotal"`
+	Total           int                 `json:"total"`
 }
 
 // EstimateTokens 估算消息的token数量
@@ -28,13 +107,18 @@ func NewTokenEstimator() *TokenEstimator {
 //
 // 注意：此为快速估算，与官方tokenizer可能有±10%误差
 func (e *TokenEstimator) EstimateTokens(req *types.CountTokensRequest) int {
-	totalTokens := 0
+	return e.EstimateBreakdown(req).Total
+}
+
+// EstimateBreakdown 估算消息的token数量并返回分项明细
+func (e *TokenEstimator) EstimateBreakdown(req *types.CountTokensRequest) TokenEstimateBreakdown {
+	var breakdown TokenEstimateBreakdown
 
 	// 1. 系统提示词（system prompt）
 	for _, sysMsg := range req.System {
 		if sysMsg.Text != "" {
-			totalTokens += e.EstimateTextTokens(sysMsg.Text)
-			totalTokens += 2 // 系统提示的固定开销（P0优化：从3降至2）
+			breakdown.System += e.EstimateTextTokens(sysMsg.Text)
+			breakdown.System += 2 // 系统提示的固定开销（P0优化：从3降至2）
 		}
 	}
 
@@ -42,34 +126,38 @@ func (e *TokenEstimator) EstimateTokens(req *types.CountTokensRequest) int {
 	for _, msg := range req.Messages {
 		// 角色标记开销（"user"/"assistant" + JSON结构）
 		// 优化：根据官方测试调整
-		totalTokens += 3
+		breakdown.Messages += 3
 
 		// 消息内容
 		switch content := msg.Content.(type) {
 		case string:
 			// 文本消息
-			totalTokens += e.EstimateTextTokens(content)
+			breakdown.Messages += e.EstimateTextTokens(content)
 		case []any:
 			// 复杂内容块（文本、图片、文档等）
 			for _, block := range content {
-				totalTokens += e.estimateContentBlock(block)
+				breakdown.Messages += e.estimateContentBlock(block)
 			}
 		case []types.ContentBlock:
 			// 类型化内容块
 			for _, block := range content {
-				totalTokens += e.estimateTypedContentBlock(block)
+				breakdown.Messages += e.estimateTypedContentBlock(block)
 			}
 		default:
 			// 其他格式：保守估算为JSON长度
 			if jsonBytes, err := SafeMarshal(content); err == nil {
-				totalTokens += len(jsonBytes) / 4
+				breakdown.Messages += len(jsonBytes) / 4
 			}
 		}
 	}
 
 	// 3. 工具定义（tools）
 	toolCount := len(req.Tools)
+	toolTokens := 0
 	if toolCount > 0 {
+		tier := config.ToolCountTier(toolCount)
+		toolMultiplier := e.calibration.ToolCountMultipliers[tier]
+
 		// 工具开销策略：根据工具数量自适应调整
 		// - 少量工具（1-3个）：每个工具高开销（包含大量元数据和结构信息）
 		// - 大量工具（10+个）：共享开销 + 小增量（避免线性叠加过高）
@@ -91,64 +179,91 @@ func (e *TokenEstimator) EstimateTokens(req *types.CountTokensRequest) int {
 			perToolOverhead = 60    // 从80降至60
 		}
 
-		totalTokens += baseToolsOverhead
+		breakdown.ToolsBase = applyMultiplier(baseToolsOverhead, toolMultiplier)
+		toolTokens += breakdown.ToolsBase
 
+		breakdown.Tools = make([]ToolTokenEstimate, 0, toolCount)
 		for _, tool := range req.Tools {
-			// 工具名称（特殊处理：下划线分词导致token数增加）
-			nameTokens := e.estimateToolName(tool.Name)
-			totalTokens += nameTokens
-
-			// 工具描述
-			totalTokens += e.EstimateTextTokens(tool.Description)
+			estimate := ToolTokenEstimate{
+				Name: tool.Name,
+				// 工具名称（特殊处理：下划线分词导致token数增加）
+				NameTokens: e.estimateToolName(tool.Name),
+				// 工具描述
+				DescriptionTokens: e.EstimateTextTokens(tool.Description),
+				OverheadTokens:    perToolOverhead,
+			}
 
 			// 工具schema（JSON Schema）
 			if tool.InputSchema != nil {
 				if jsonBytes, err := SafeMarshal(tool.InputSchema); err == nil {
-					// Schema编码密度：根据工具数量自适应
-					// 优化：平衡编码密度
-					var schemaCharsPerToken float64
-					if toolCount == 1 {
-						schemaCharsPerToken = 1.9 // 单工具平衡值
-					} else if toolCount <= 5 {
-						schemaCharsPerToken = 2.2 // 少量工具
-					} else {
-						schemaCharsPerToken = 2.5 // 大量工具
-					}
-
-					schemaLen := len(jsonBytes)
-					schemaTokens := int(math.Ceil(float64(schemaLen) / schemaCharsPerToken))  // 进一法
-
-					// $schema字段URL开销（优化：降低开销）
-					if strings.Contains(string(jsonBytes), "$schema") {
-						if toolCount == 1 {
-							schemaTokens += 10 // 从15降至10
-						} else {
-							schemaTokens += 5 // 从8降至5
-						}
-					}
-
-					// 最小schema开销（优化：降低最小值）
-					minSchemaTokens := 50 // 从80降至50
-					if toolCount > 5 {
-						minSchemaTokens = 30 // 从40降至30
-					}
-					if schemaTokens < minSchemaTokens {
-						schemaTokens = minSchemaTokens
-					}
-
-					totalTokens += schemaTokens
+					estimate.SchemaTokens = e.estimateSchemaTokens(jsonBytes, toolCount)
 				}
 			}
 
-			totalTokens += perToolOverhead
+			estimate.Total = applyMultiplier(
+				estimate.NameTokens+estimate.DescriptionTokens+estimate.SchemaTokens+estimate.OverheadTokens,
+				toolMultiplier)
+			toolTokens += estimate.Total
+			breakdown.Tools = append(breakdown.Tools, estimate)
 		}
 	}
 
 	// 4. 基础请求开销（API格式固定开销）
 	// 优化：根据官方测试调整
-	totalTokens += 4 // 调整至4以匹配官方
+	breakdown.RequestOverhead = 4 // 调整至4以匹配官方
 
-	return totalTokens
+	breakdown.Subtotal = breakdown.System + breakdown.Messages + toolTokens + breakdown.RequestOverhead
+	breakdown.Total = applyMultiplier(breakdown.Subtotal, e.calibration.GlobalMultiplier)
+
+	return breakdown
+}
+
+// estimateSchemaTokens 估算工具 JSON Schema 的token数量
+func (e *TokenEstimator) estimateSchemaTokens(jsonBytes []byte, toolCount int) int {
+	// Schema编码密度：根据工具数量自适应
+	// 优化：平衡编码密度
+	var schemaCharsPerToken float64
+	if toolCount == 1 {
+		schemaCharsPerToken = 1.9 // 单工具平衡值
+	} else if toolCount <= 5 {
+		schemaCharsPerToken = 2.2 // 少量工具
+	} else {
+		schemaCharsPerToken = 2.5 // 大量工具
+	}
+	if override, ok := e.calibration.SchemaCharsPerToken[config.ToolCountTier(toolCount)]; ok && override > 0 {
+		schemaCharsPerToken = override
+	}
+
+	schemaLen := len(jsonBytes)
+	schemaTokens := int(math.Ceil(float64(schemaLen) / schemaCharsPerToken)) // 进一法
+
+	// $schema字段URL开销（优化：降低开销）
+	if strings.Contains(string(jsonBytes), "$schema") {
+		if toolCount == 1 {
+			schemaTokens += 10 // 从15降至10
+		} else {
+			schemaTokens += 5 // 从8降至5
+		}
+	}
+
+	// 最小schema开销（优化：降低最小值）
+	minSchemaTokens := 50 // 从80降至50
+	if toolCount > 5 {
+		minSchemaTokens = 30 // 从40降至30
+	}
+	if schemaTokens < minSchemaTokens {
+		schemaTokens = minSchemaTokens
+	}
+
+	return schemaTokens
+}
+
+// applyMultiplier 按倍率缩放token数，倍率未设置（0）或为1时原样返回
+func applyMultiplier(tokens int, multiplier float64) int {
+	if multiplier <= 0 || multiplier == 1 {
+		return tokens
+	}
+	return int(math.Round(float64(tokens) * multiplier))
 }
 
 // estimateToolName 估算工具名称的token数量
@@ -189,18 +304,65 @@ func (e *TokenEstimator) estimateToolName(name string) int {
 // - 检测中文字符比例
 // - 中文: 1.5字符/token（汉字信息密度高）
 // - 英文: 4字符/token（标准GPT tokenizer比率）
+// 内容类型处理：
+// - Markdown中的 ``` 代码块与周围文字分段估算
+// - 符号密度高的文本（源码、JSON）按代码估算，不做长文本压缩
 func (e *TokenEstimator) EstimateTextTokens(text string) int {
 	if text == "" {
 		return 0
 	}
 
+	tokens := 0
+	if strings.Contains(text, "```") {
+		for _, seg := range splitFencedCode(text) {
+			if seg.code {
+				tokens += e.scaleCodeTokens(estimateCodeTokens([]rune(seg.text)))
+			} else {
+				tokens += e.estimateSegmentTokens(seg.text)
+			}
+		}
+	} else {
+		tokens = e.estimateSegmentTokens(text)
+	}
+
+	tokens = applyMultiplier(tokens, e.calibration.TextMultiplier)
+
+	if tokens < 1 {
+		tokens = 1 // 最少1个token
+	}
+
+	return tokens
+}
+
+// estimateSegmentTokens 估算单段文本，先判断是否为代码
+func (e *TokenEstimator) estimateSegmentTokens(text string) int {
 	// 转换为rune数组以正确计算Unicode字符数
 	runes := []rune(text)
-	runeCount := len(runes)
-
-	if runeCount == 0 {
+	if len(runes) == 0 {
 		return 0
 	}
+	if isCodeLike(runes) {
+		return e.scaleCodeTokens(estimateCodeTokens(runes))
+	}
+	return applyMultiplier(estimateProseTokens(runes), ratioScale(config.TextCharsPerToken, e.coefficients.TextCharsPerToken))
+}
+
+// scaleCodeTokens 按模型实测的代码字符/token比率缩放
+func (e *TokenEstimator) scaleCodeTokens(tokens int) int {
+	return applyMultiplier(tokens, ratioScale(config.CodeCharsPerToken, e.coefficients.CodeCharsPerToken))
+}
+
+// ratioScale 实测比率相对默认比率的token缩放倍数，未设置时为1
+func ratioScale(defaultRatio, measured float64) float64 {
+	if measured <= 0 {
+		return 1
+	}
+	return defaultRatio / measured
+}
+
+// estimateProseTokens 按自然语言估算，长文本应用压缩系数
+func estimateProseTokens(runes []rune) int {
+	runeCount := len(runes)
 
 	// 统计中文字符数（扫描全部字符）
 	chineseChars := 0
@@ -281,11 +443,92 @@ func (e *TokenEstimator) EstimateTextTokens(text string) int {
 	}
 	// <50字符: 不压缩
 
-	if tokens < 1 {
-		tokens = 1 // 最少1个token
+	return tokens
+}
+
+// estimateCodeTokens 按代码估算：非中文字符使用更密的字符/token比率，不做长文本压缩
+// 真实BPE对源码和JSON的合并率远低于散文，套用散文的压缩系数会低估25%-40%
+func estimateCodeTokens(runes []rune) int {
+	if len(runes) == 0 {
+		return 0
 	}
+	chineseChars := 0
+	for _, r := range runes {
+		if r >= 0x4E00 && r <= 0x9FFF {
+			chineseChars++
+		}
+	}
+	nonChineseChars := len(runes) - chineseChars
+	return chineseChars + int(math.Ceil(float64(nonChineseChars)/config.CodeCharsPerToken))
+}
 
-	return tokens
+// isCodeLike 根据符号比例和结构字符密度判断文本是否为源码或JSON
+// 只统计ASCII符号：中文标点属于正常行文，不应把中文散文误判为代码
+func isCodeLike(runes []rune) bool {
+	if len(runes) < config.CodeDetectionMinRunes {
+		return false
+	}
+
+	symbols, structural, nonSpace := 0, 0, 0
+	for _, r := range runes {
+		if unicode.IsSpace(r) {
+			continue
+		}
+		nonSpace++
+		if r > unicode.MaxASCII || unicode.IsLetter(r) || unicode.IsDigit(r) {
+			continue
+		}
+		symbols++
+		switch r {
+		case '{', '}', '[', ']', ';':
+			structural++
+		}
+	}
+	if nonSpace == 0 {
+		return false
+	}
+
+	symbolRatio := float64(symbols) / float64(nonSpace)
+	structureDensity := float64(structural) / float64(len(runes))
+	return symbolRatio >= config.CodeSymbolRatioThreshold || structureDensity >= config.CodeStructureDensityThreshold
+}
+
+// textSegment Markdown文本中的一段，code 表示位于 ``` 代码块内（含围栏行）
+type textSegment struct {
+	text string
+	code bool
+}
+
+// splitFencedCode 按 ``` 围栏把文本切分为普通文字段和代码块段；未闭合的代码块延续到文本末尾
+func splitFencedCode(text string) []textSegment {
+	var (
+		segments []textSegment
+		current  strings.Builder
+		inCode   bool
+	)
+	flush := func(code bool) {
+		if current.Len() > 0 {
+			segments = append(segments, textSegment{text: current.String(), code: code})
+			current.Reset()
+		}
+	}
+
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			if inCode {
+				current.WriteString(line)
+				flush(true)
+			} else {
+				flush(false)
+				current.WriteString(line)
+			}
+			inCode = !inCode
+			continue
+		}
+		current.WriteString(line)
+	}
+	flush(inCode)
+	return segments
 }
 
 // EstimateToolUseTokens 精确估算工具调用的token数量
@@ -459,6 +702,12 @@ func IsValidClaudeModel(model string) bool {
 		return false
 	}
 
+	// cw: 直通模型按直通规则校验（需启用 ALLOW_MODEL_PASSTHROUGH 且 modelId 格式合法）
+	if config.IsPassthroughModel(model) {
+		_, err := config.ResolveModelID(model)
+		return err == nil
+	}
+
 	model = strings.ToLower(model)
 
 	// 支持的模型前缀
@@ -0,0 +1,197 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"kiro2api/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// goSnippet 约2KB的Go源码样本
+const goSnippet = `package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// entry 缓存条目
+type entry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time
+}
+
+// LRU 带过期时间的并发安全LRU缓存
+type LRU struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	items    map[string]*list.Element
+	order    *list.List
+}
+
+func NewLRU(capacity int, ttl time.Duration) *LRU {
+	return &LRU{
+		capacity: capacity,
+		ttl:      ttl,
+		items:    make(map[string]*list.Element, capacity),
+		order:    list.New(),
+	}
+}
+
+func (c *LRU) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	e := elem.Value.(*entry)
+	if time.Now().After(e.expiresAt) {
+		c.removeElement(elem)
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return e.value, true
+}
+
+func (c *LRU) Set(key string, value []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		e := elem.Value.(*entry)
+		e.value = value
+		e.expiresAt = time.Now().Add(c.ttl)
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	elem := c.order.PushFront(&entry{key: key, value: value, expiresAt: time.Now().Add(c.ttl)})
+	c.items[key] = elem
+	for c.order.Len() > c.capacity {
+		c.removeElement(c.order.Back())
+	}
+}
+
+func (c *LRU) removeElement(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.items, elem.Value.(*entry).key)
+}
+
+func (c *LRU) Purge() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	removed := 0
+	now := time.Now()
+	for elem := c.order.Back(); elem != nil; {
+		prev := elem.Prev()
+		if now.After(elem.Value.(*entry).expiresAt) {
+			c.removeElement(elem)
+			removed++
+		}
+		elem = prev
+	}
+	return removed
+}
+
+func (c *LRU) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *LRU) Keys() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys := make([]string, 0, c.order.Len())
+	for elem := c.order.Front(); elem != nil; elem = elem.Next() {
+		keys = append(keys, elem.Value.(*entry).key)
+	}
+	return keys
+}
+
+func (c *LRU) Delete(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		c.removeElement(elem)
+		return true
+	}
+	return false
+}
+`
+
+// jsonBlob 约2KB的JSON样本（数据库查询结果）
+const jsonBlob = `{"query":"SELECT id, name, email, status, created_at FROM users WHERE status = 'active' LIMIT 16","rows":[{"id":1001,"name":"Alice Zhang","email":"alice@example.com","status":"active","created_at":"2024-03-01T08:15:22Z"},{"id":1002,"name":"Bob Li","email":"bob.li@example.com","status":"active","created_at":"2024-03-02T09:01:10Z"},{"id":1003,"name":"Carol Wang","email":"carol@example.org","status":"active","created_at":"2024-03-05T11:47:03Z"},{"id":1004,"name":"David Chen","email":"dchen@example.net","status":"active","created_at":"2024-03-07T14:22:41Z"},{"id":1005,"name":"Eve Liu","email":"eve.liu@example.com","status":"active","created_at":"2024-03-09T16:05:59Z"},{"id":1006,"name":"Frank Zhao","email":"frank@example.com","status":"active","created_at":"2024-03-11T07:33:18Z"},{"id":1007,"name":"Grace Sun","email":"grace.sun@example.org","status":"active","created_at":"2024-03-14T10:10:10Z"},{"id":1008,"name":"Henry Zhou","email":"henry@example.net","status":"active","created_at":"2024-03-16T12:45:36Z"},{"id":1009,"name":"Ivy Wu","email":"ivy.wu@example.com","status":"active","created_at":"2024-03-18T18:20:05Z"},{"id":1010,"name":"Jack Xu","email":"jack.xu@example.com","status":"active","created_at":"2024-03-21T21:02:44Z"},{"id":1011,"name":"Kate Ma","email":"kate@example.org","status":"active","created_at":"2024-03-23T05:55:12Z"},{"id":1012,"name":"Leo Hu","email":"leo.hu@example.net","status":"active","created_at":"2024-03-25T13:31:27Z"},{"id":1013,"name":"Mia Guo","email":"mia.guo@example.com","status":"active","created_at":"2024-03-27T08:08:08Z"},{"id":1014,"name":"Noah Lin","email":"noah@example.org","status":"active","created_at":"2024-03-29T19:41:50Z"},{"id":1015,"name":"Olivia He","email":"olivia.he@example.net","status":"active","created_at":"2024-04-01T06:12:33Z"},{"id":1016,"name":"Paul Luo","email":"paul.luo@example.com","status":"active","created_at":"2024-04-03T15:27:19Z"}],"row_count":16,"elapsed_ms":38,"truncated":false}`
+
+func TestSplitFencedCode(t *testing.T) {
+	text := "说明如下：\n```go\nfunc main() {}\n```\n以上是代码。\n```\nunclosed"
+	segments := splitFencedCode(text)
+	require.Len(t, segments, 4)
+	assert.Equal(t, textSegment{text: "说明如下：\n", code: false}, segments[0])
+	assert.Equal(t, textSegment{text: "```go\nfunc main() {}\n```\n", code: true}, segments[1])
+	assert.Equal(t, textSegment{text: "以上是代码。\n", code: false}, segments[2])
+	assert.Equal(t, textSegment{text: "```\nunclosed", code: true}, segments[3], "未闭合的代码块延续到末尾")
+}
+
+func TestIsCodeLike(t *testing.T) {
+	prose := "The quick brown fox jumps over the lazy dog. In computing, a proxy server is a server application that acts as an intermediary between a client and the server providing a resource (e.g., caching, filtering)."
+	chinese := "代理服务器是一种重要的服务器安全功能，它的工作主要在开放系统互联模型的会话层，从而起到防火墙的作用。代理服务器大多被用来连接互联网和局域网。"
+
+	assert.True(t, isCodeLike([]rune(goSnippet)))
+	assert.True(t, isCodeLike([]rune(jsonBlob)))
+	assert.False(t, isCodeLike([]rune(prose)))
+	assert.False(t, isCodeLike([]rune(chinese)), "中文标点不计入符号")
+	assert.False(t, isCodeLike([]rune("{}{}{};;;")), "过短的文本不做代码检测")
+}
+
+// TestEstimateTextTokens_CodeAccuracy 源码与JSON的估算误差应低于15%
+// 参考值是固定数字，与估算器使用的字符/token比率无关：本地无法调用真实tokenizer，
+// 按 cl100k 的预分词正则切分样本、再在驼峰边界（小写后接大写）拆分，统计得到的片段数
+// （Go源码 599，JSON 643）。BPE很少跨越这些边界合并，真实token数通常不少于该值；
+// 取得 /v1/messages/count_tokens 的实测值后应替换
+func TestEstimateTextTokens_CodeAccuracy(t *testing.T) {
+	estimator := NewTokenEstimatorWithCalibration(config.TokenCalibration{})
+
+	tests := []struct {
+		name     string
+		text     string
+		expected int
+	}{
+		{"2KB Go源码", goSnippet, 599},
+		{"2KB JSON", jsonBlob, 643},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			estimated := estimator.EstimateTextTokens(tt.text)
+			errPct := calculateError(estimated, tt.expected)
+			t.Logf("%s: 估算 %d, 参考 %d, 误差 %.2f%%", tt.name, estimated, tt.expected, errPct)
+			assert.Less(t, errPct, 15.0)
+		})
+	}
+}
+
+// TestEstimateTextTokens_FencedCodeNotCompressed Markdown中的代码块不应套用散文的长文本压缩
+func TestEstimateTextTokens_FencedCodeNotCompressed(t *testing.T) {
+	estimator := NewTokenEstimatorWithCalibration(config.TokenCalibration{})
+	prose := strings.Repeat("This paragraph explains how the cache works in plain English. ", 10)
+	markdown := prose + "\n```go\n" + goSnippet + "```\n"
+
+	combined := estimator.EstimateTextTokens(markdown)
+	codeOnly := estimator.EstimateTextTokens(goSnippet)
+	proseOnly := estimator.EstimateTextTokens(prose)
+
+	assert.InDelta(t, codeOnly+proseOnly, combined, float64(combined)*0.05, "代码块与文字分段估算后相加")
+	assert.Greater(t, combined, estimateProseTokens([]rune(markdown)), "整体按散文压缩会低估")
+}